@@ -26,6 +26,17 @@ Endpoint which returns an object with version information.
 	version:     : Version of the API provider
 	revision:    : Revision of the API provider
 
+/healthz
+
+Endpoint which returns the liveness status of the server.
+
+/readyz
+
+Endpoint which returns the readiness status of the server. The readiness check
+verifies that the storage can be accessed and, if clustering is enabled, that the
+cluster transfer queue is not saturated and that the cluster has enough operational
+members. Returns 503 Service Unavailable if any component check failed.
+
 /swagger.json
 
 Dynamically generated swagger definition file. See: http://swagger.io
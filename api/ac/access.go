@@ -44,7 +44,8 @@ var PublicAccessControlEndpointMap = map[string]api.RestEndpointInst{
 AccessManagementEndpointMap contains endpoints which can manage access rights
 */
 var AccessManagementEndpointMap = map[string]api.RestEndpointInst{
-	EndpointUser: UserEndpointInst,
+	EndpointUser:  UserEndpointInst,
+	EndpointToken: TokenEndpointInst,
 }
 
 /*
@@ -217,6 +218,37 @@ func (a *AccessControlLists) CheckHTTPRequest(w http.ResponseWriter, r *http.Req
 	return result == GRANTED
 }
 
+/*
+RequestUser returns the authenticated user of a given request. Requests with an
+access token are made on behalf of the user of the token.
+*/
+func RequestUser(r *http.Request) string {
+	var user string
+
+	if token := requestToken(r); token != nil {
+		user = token.User
+	} else if AuthHandler != nil {
+		user, _ = AuthHandler.CheckAuth(r)
+	}
+
+	return user
+}
+
+/*
+RequestGroups returns the groups of the authenticated user of a given request.
+*/
+func RequestGroups(r *http.Request) []string {
+	var groups []string
+
+	if ACL != nil {
+		if user := RequestUser(r); user != "" {
+			groups, _ = ACL.GroupsOfUser(user)
+		}
+	}
+
+	return groups
+}
+
 // Default error handlers
 
 /*
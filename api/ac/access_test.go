@@ -55,12 +55,17 @@ func TestMain(m *testing.M) {
 
 	// Initialise auth handler
 
-	AuthHandler = auth.NewCookieAuthHandleFuncWrapper(http.HandleFunc)
+	AuthHandler = auth.NewCookieAuthHandleFuncWrapper(TokenHandleFunc(http.HandleFunc))
+
+	// Initialise access tokens
+
+	Tokens, err = NewTokenDB("")
+	errorutil.AssertOk(err)
 
 	// Important statement! - all registered endpoints afterwards
 	// are subject to access control
 
-	api.HandleFunc = AuthHandler.HandleFunc
+	api.HandleFunc = HandleFunc
 
 	// Register management endpoints
 
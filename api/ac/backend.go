@@ -0,0 +1,123 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"sort"
+)
+
+/*
+AuthBackend verifies user credentials. A backend can also determine the groups
+of a user (e.g. from a directory service).
+*/
+type AuthBackend interface {
+
+	/*
+		Name returns the name of this backend.
+	*/
+	Name() string
+
+	/*
+		Authenticate checks the password of a given user. Returns if the
+		credentials are valid and the groups of the user. A nil group list
+		leaves the group memberships in the ACL untouched. An error is returned
+		if the credentials could not be checked.
+	*/
+	Authenticate(user, pass string) (bool, []string, error)
+}
+
+/*
+Backend is the global authentication backend which is used to verify user
+credentials.
+*/
+var Backend AuthBackend = &LocalAuthBackend{}
+
+/*
+CheckUserPassword checks the credentials of a user with the global
+authentication backend. The group memberships of the user are updated if the
+backend determines the groups of the user.
+*/
+func CheckUserPassword(user, pass string) bool {
+
+	ok, groups, err := Backend.Authenticate(user, pass)
+
+	if err != nil {
+		LogAccess("Authentication backend ", Backend.Name(), " failed: ", err)
+		return false
+	}
+
+	if ok && groups != nil && ACL != nil {
+		syncUserGroups(user, groups)
+	}
+
+	return ok
+}
+
+/*
+LocalAuthBackend verifies credentials with the local user database.
+*/
+type LocalAuthBackend struct {
+}
+
+/*
+Name returns the name of this backend.
+*/
+func (lb *LocalAuthBackend) Name() string {
+	return "local"
+}
+
+/*
+Authenticate checks the password of a given user in the global user database.
+Groups are managed in the ACL.
+*/
+func (lb *LocalAuthBackend) Authenticate(user, pass string) (bool, []string, error) {
+	return UserDB != nil && UserDB.CheckUserPassword(user, pass), nil, nil
+}
+
+/*
+syncUserGroups makes sure a user is member of exactly the given groups in the
+global ACL. Groups which do not exist in the ACL are ignored.
+*/
+func syncUserGroups(user string, groups []string) {
+
+	current, _ := ACL.GroupsOfUser(user)
+	known, _ := ACL.GroupNames()
+
+	sort.Strings(known)
+
+	isKnown := func(group string) bool {
+		i := sort.SearchStrings(known, group)
+		return i < len(known) && known[i] == group
+	}
+
+	wanted := make(map[string]bool)
+
+	for _, g := range groups {
+		if isKnown(g) {
+			wanted[g] = true
+		}
+	}
+
+	for _, g := range current {
+		if !wanted[g] {
+			if err := ACL.RemoveUserFromGroup(user, g); err != nil {
+				LogAccess("Could not remove user ", user, " from group ", g, ": ", err)
+			}
+		}
+		delete(wanted, g)
+	}
+
+	for g := range wanted {
+		if err := ACL.AddUserToGroup(user, g); err != nil {
+			LogAccess("Could not add user ", user, " to group ", g, ": ", err)
+		}
+	}
+}
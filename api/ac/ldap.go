@@ -0,0 +1,482 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LDAP configuration keys
+const (
+	LDAPHosts            = "LDAPHosts"
+	LDAPSkipTLSVerify    = "LDAPSkipTLSVerify"
+	LDAPUserDN           = "LDAPUserDN"
+	LDAPGroupBaseDN      = "LDAPGroupBaseDN"
+	LDAPGroupMemberAttr  = "LDAPGroupMemberAttr"
+	LDAPGroupMemberValue = "LDAPGroupMemberValue"
+	LDAPGroupNameAttr    = "LDAPGroupNameAttr"
+	LDAPGroupMapping     = "LDAPGroupMapping"
+	LDAPDefaultGroups    = "LDAPDefaultGroups"
+	LDAPPoolSize         = "LDAPPoolSize"
+	LDAPTimeoutSeconds   = "LDAPTimeoutSeconds"
+)
+
+/*
+LDAPDefaultConfig is the default configuration of the LDAP authentication
+backend. Hosts are given as host:port - an ldaps:// prefix enables TLS. The
+user DN is a pattern which gets the user name (e.g. uid=%v,ou=people,dc=example,dc=com
+or %v@example.com for Active Directory). Groups are searched below the group
+base DN by matching the member attribute against the member value pattern
+(defaults to the user DN). Directory groups are mapped to EliasDB groups -
+the default groups are given to all authenticated users.
+*/
+var LDAPDefaultConfig = map[string]interface{}{
+	LDAPHosts:            []interface{}{"ldap://127.0.0.1:389"},
+	LDAPSkipTLSVerify:    false,
+	LDAPUserDN:           "uid=%v,ou=people,dc=example,dc=com",
+	LDAPGroupBaseDN:      "ou=groups,dc=example,dc=com",
+	LDAPGroupMemberAttr:  "member",
+	LDAPGroupMemberValue: "",
+	LDAPGroupNameAttr:    "cn",
+	LDAPGroupMapping:     map[string]interface{}{},
+	LDAPDefaultGroups:    []interface{}{"public"},
+	LDAPPoolSize:         5,
+	LDAPTimeoutSeconds:   5,
+}
+
+/*
+LDAPAuthBackend verifies credentials with a bind against a LDAP directory
+(e.g. OpenLDAP or Active Directory) and maps directory groups to EliasDB
+groups. Connections are pooled and several hosts can be given for failover.
+*/
+type LDAPAuthBackend struct {
+	hosts         []string               // Directory hosts in failover order
+	tlsConfig     *tls.Config            // TLS config for ldaps:// hosts
+	userDN        string                 // Pattern for the DN of a user
+	groupBaseDN   string                 // Base DN for group searches
+	memberAttr    string                 // Group attribute which contains members
+	memberValue   string                 // Pattern for the member value of a user
+	groupNameAttr string                 // Group attribute which contains the name
+	groupMapping  map[string][]string    // Mapping of directory groups to EliasDB groups
+	defaultGroups []string               // Groups of all authenticated users
+	poolSize      int                    // Max number of idle connections per host
+	timeout       time.Duration          // Timeout for directory operations
+	mutex         *sync.Mutex            // Mutex to protect the pool and the current host
+	pool          map[string][]*ldapConn // Idle connections per host
+	current       int                    // Index of the host which answered last
+}
+
+/*
+NewLDAPAuthBackend creates a new LDAP authentication backend from a given
+configuration.
+*/
+func NewLDAPAuthBackend(config map[string]interface{}) (*LDAPAuthBackend, error) {
+
+	str := func(key string) string {
+		if v, ok := config[key]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return fmt.Sprint(LDAPDefaultConfig[key])
+	}
+
+	num := func(key string) (int, error) {
+		var i int
+		_, err := fmt.Sscan(str(key), &i)
+		if err != nil || i < 1 {
+			return 0, fmt.Errorf("Invalid LDAP config value for %v: %v", key, str(key))
+		}
+		return i, nil
+	}
+
+	list := func(v interface{}) []string {
+		var ret []string
+		if l, ok := v.([]interface{}); ok {
+			for _, i := range l {
+				ret = append(ret, fmt.Sprint(i))
+			}
+		} else if l, ok := v.([]string); ok {
+			ret = append(ret, l...)
+		}
+		return ret
+	}
+
+	poolSize, err := num(LDAPPoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := num(LDAPTimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := &LDAPAuthBackend{
+		hosts:         list(config[LDAPHosts]),
+		tlsConfig:     &tls.Config{InsecureSkipVerify: str(LDAPSkipTLSVerify) == "true"},
+		userDN:        str(LDAPUserDN),
+		groupBaseDN:   str(LDAPGroupBaseDN),
+		memberAttr:    str(LDAPGroupMemberAttr),
+		memberValue:   str(LDAPGroupMemberValue),
+		groupNameAttr: str(LDAPGroupNameAttr),
+		groupMapping:  make(map[string][]string),
+		defaultGroups: list(config[LDAPDefaultGroups]),
+		poolSize:      poolSize,
+		timeout:       time.Duration(timeout) * time.Second,
+		mutex:         &sync.Mutex{},
+		pool:          make(map[string][]*ldapConn),
+	}
+
+	if len(lb.hosts) == 0 {
+		return nil, fmt.Errorf("LDAP config needs at least one host")
+	} else if !strings.Contains(lb.userDN, "%v") {
+		return nil, fmt.Errorf("LDAP user DN must contain %%v: %v", lb.userDN)
+	}
+
+	if m, ok := config[LDAPGroupMapping].(map[string]interface{}); ok {
+		for group, groups := range m {
+			lb.groupMapping[strings.ToLower(group)] = list(groups)
+		}
+	}
+
+	return lb, nil
+}
+
+/*
+Name returns the name of this backend.
+*/
+func (lb *LDAPAuthBackend) Name() string {
+	return "ldap"
+}
+
+/*
+Authenticate checks the password of a given user with a bind against the
+directory. Returns the mapped EliasDB groups of the user if any group mapping
+or default groups are configured. Hosts are tried in order until one answers.
+*/
+func (lb *LDAPAuthBackend) Authenticate(user, pass string) (bool, []string, error) {
+	var err error
+
+	// An empty password would result in an unauthenticated bind which
+	// succeeds on most directories
+
+	if user == "" || pass == "" {
+		return false, nil, nil
+	}
+
+	lb.mutex.Lock()
+	start := lb.current
+	lb.mutex.Unlock()
+
+	for i := 0; i < len(lb.hosts); i++ {
+		hostIndex := (start + i) % len(lb.hosts)
+
+		var ok bool
+		var groups []string
+
+		if ok, groups, err = lb.authenticateWithHost(lb.hosts[hostIndex], user, pass); err == nil {
+
+			lb.mutex.Lock()
+			lb.current = hostIndex
+			lb.mutex.Unlock()
+
+			return ok, groups, nil
+		}
+
+		LogAccess("LDAP host ", lb.hosts[hostIndex], " failed: ", err)
+	}
+
+	return false, nil, fmt.Errorf("No LDAP host could verify credentials: %v", err)
+}
+
+/*
+Close closes all pooled connections.
+*/
+func (lb *LDAPAuthBackend) Close() {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for host, conns := range lb.pool {
+		for _, c := range conns {
+			c.close()
+		}
+		delete(lb.pool, host)
+	}
+}
+
+/*
+authenticateWithHost checks the credentials of a user with a given host. An
+error is only returned if the host could not answer.
+*/
+func (lb *LDAPAuthBackend) authenticateWithHost(host, user, pass string) (bool, []string, error) {
+
+	c, pooled, err := lb.getConn(host)
+	if err != nil {
+		return false, nil, err
+	}
+
+	ok, groups, err := lb.authenticateWithConn(host, c, user, pass)
+
+	if err != nil && pooled {
+
+		// Pooled connections might have been closed by the server in the meantime
+
+		return lb.authenticateWithHost(host, user, pass)
+	}
+
+	return ok, groups, err
+}
+
+/*
+authenticateWithConn checks the credentials of a user with a given connection.
+The connection is returned to the pool if it can be used again.
+*/
+func (lb *LDAPAuthBackend) authenticateWithConn(host string, c *ldapConn, user, pass string) (bool, []string, error) {
+	var groups []string
+
+	dn := fmt.Sprintf(lb.userDN, escapeDN(user))
+
+	code, msg, err := c.bind(dn, pass)
+
+	if err == nil {
+		if code == ldapResultInvalidCredentials {
+			lb.putConn(host, c)
+			return false, nil, nil
+
+		} else if code != ldapResultSuccess {
+			err = fmt.Errorf("Bind failed with result code %v: %v", code, msg)
+
+		} else if len(lb.groupMapping) > 0 || len(lb.defaultGroups) > 0 {
+			groups, err = lb.userGroups(c, user, dn)
+		}
+	}
+
+	if err != nil {
+		c.close()
+		return false, nil, err
+	}
+
+	lb.putConn(host, c)
+
+	return true, groups, nil
+}
+
+/*
+userGroups determines the EliasDB groups of a bound user.
+*/
+func (lb *LDAPAuthBackend) userGroups(c *ldapConn, user, dn string) ([]string, error) {
+
+	groups := make(map[string]bool)
+
+	for _, g := range lb.defaultGroups {
+		groups[g] = true
+	}
+
+	if lb.groupBaseDN != "" && len(lb.groupMapping) > 0 {
+
+		member := dn
+		if lb.memberValue != "" {
+			member = strings.Replace(lb.memberValue, "%v", escapeDN(user), -1)
+		}
+
+		names, err := c.search(lb.groupBaseDN, lb.memberAttr, member, lb.groupNameAttr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			for _, g := range lb.groupMapping[strings.ToLower(name)] {
+				groups[g] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(groups))
+
+	for g := range groups {
+		ret = append(ret, g)
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+/*
+getConn gets a pooled connection to a host or opens a new one. Returns if the
+connection was taken from the pool.
+*/
+func (lb *LDAPAuthBackend) getConn(host string) (*ldapConn, bool, error) {
+
+	lb.mutex.Lock()
+
+	if conns := lb.pool[host]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		lb.pool[host] = conns[:len(conns)-1]
+		lb.mutex.Unlock()
+
+		return c, true, nil
+	}
+
+	lb.mutex.Unlock()
+
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: lb.timeout}
+
+	if strings.HasPrefix(host, "ldaps://") {
+		conn, err = tls.DialWithDialer(dialer, "tcp", strings.TrimPrefix(host, "ldaps://"), lb.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", strings.TrimPrefix(host, "ldap://"))
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &ldapConn{conn, bufio.NewReader(conn), 0, lb.timeout}, false, nil
+}
+
+/*
+putConn returns a connection to the pool.
+*/
+func (lb *LDAPAuthBackend) putConn(host string, c *ldapConn) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if len(lb.pool[host]) < lb.poolSize {
+		lb.pool[host] = append(lb.pool[host], c)
+	} else {
+		c.close()
+	}
+}
+
+/*
+ldapConn is a connection to a LDAP server.
+*/
+type ldapConn struct {
+	conn    net.Conn      // Network connection
+	reader  *bufio.Reader // Reader for responses
+	msgID   int           // Last used message id
+	timeout time.Duration // Timeout for each operation
+}
+
+/*
+bind does a simple bind and returns the result code and diagnostic message.
+*/
+func (c *ldapConn) bind(dn, pass string) (int, string, error) {
+
+	op, err := c.request(func(id int) []byte { return ldapBind(id, dn, pass) }, ldapBindResponse)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return ldapResult(op[0])
+}
+
+/*
+search does a subtree search with an equality filter and returns the values
+of a single attribute of all found entries.
+*/
+func (c *ldapConn) search(baseDN, attr, value, resultAttr string) ([]string, error) {
+	var ret []string
+
+	ops, err := c.request(func(id int) []byte {
+		return ldapSearch(id, baseDN, attr, value, int(c.timeout/time.Second), resultAttr)
+	}, ldapSearchResultDone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops[:len(ops)-1] {
+		if op.tag == ldapSearchResultItem {
+			vals, err := ldapEntryValues(op, resultAttr)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, vals...)
+		}
+	}
+
+	if code, msg, err := ldapResult(ops[len(ops)-1]); err != nil {
+		return nil, err
+	} else if code != ldapResultSuccess {
+		return nil, fmt.Errorf("Search failed with result code %v: %v", code, msg)
+	}
+
+	return ret, nil
+}
+
+/*
+request sends a request and reads all responses until a response with the
+given final tag is received.
+*/
+func (c *ldapConn) request(msg func(id int) []byte, finalTag byte) ([]*berElement, error) {
+	var ret []*berElement
+
+	c.msgID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := c.conn.Write(msg(c.msgID)); err != nil {
+		return nil, err
+	}
+
+	for {
+		id, op, err := readLDAPMessage(c.reader)
+		if err != nil {
+			return nil, err
+		} else if id != c.msgID {
+			return nil, fmt.Errorf("Unexpected LDAP message id: %v", id)
+		}
+
+		ret = append(ret, op)
+
+		if op.tag == finalTag {
+			return ret, nil
+		}
+	}
+}
+
+/*
+close unbinds and closes the connection.
+*/
+func (c *ldapConn) close() {
+	c.msgID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(ldapUnbind(c.msgID))
+	c.conn.Close()
+}
+
+/*
+escapeDN escapes special characters of a value in a distinguished name
+(RFC 4514).
+*/
+func escapeDN(s string) string {
+	var buf strings.Builder
+
+	for i, r := range s {
+		if strings.ContainsRune(`,+"\<>;=`, r) ||
+			(i == 0 && (r == '#' || r == ' ')) || (i == len(s)-1 && r == ' ') {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+
+	return buf.String()
+}
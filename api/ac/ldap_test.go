@@ -0,0 +1,301 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+testLDAPServer is a minimal LDAP server which supports simple binds and
+equality searches for group members.
+*/
+type testLDAPServer struct {
+	listener net.Listener
+	users    map[string]string   // Map of user DN to password
+	groups   map[string][]string // Map of group name to member DNs
+	mutex    *sync.Mutex
+	conns    int
+}
+
+func startTestLDAPServer(t *testing.T) *testLDAPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testLDAPServer{l, map[string]string{
+		"uid=alice,ou=people,dc=example,dc=com": "secret",
+		"uid=bob,ou=people,dc=example,dc=com":   "secret2",
+	}, map[string][]string{
+		"Admins":  {"uid=alice,ou=people,dc=example,dc=com"},
+		"Readers": {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+	}, &sync.Mutex{}, 0}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.conns++
+			s.mutex.Unlock()
+
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *testLDAPServer) serve(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+
+	for {
+		id, op, err := readLDAPMessage(r)
+		if err != nil {
+			return
+		}
+
+		children, _ := op.Children()
+
+		result := func(tag byte, code int) []byte {
+			return ldapMessage(id, berConstructed(tag, berInt(berEnumerated, code),
+				berString(berOctetString, ""), berString(berOctetString, "")))
+		}
+
+		switch op.tag {
+
+		case ldapBindRequest:
+			code := ldapResultInvalidCredentials
+			if pass, ok := s.users[string(children[1].content)]; ok && pass == string(children[2].content) {
+				code = ldapResultSuccess
+			}
+			c.Write(result(ldapBindResponse, code))
+
+		case ldapSearchRequest:
+			filter, _ := children[6].Children()
+			member := string(filter[1].content)
+
+			for name, members := range s.groups {
+				for _, m := range members {
+					if m == member {
+						c.Write(ldapMessage(id, berConstructed(ldapSearchResultItem,
+							berString(berOctetString, "cn="+name+","+string(children[0].content)),
+							berConstructed(berSequence, berConstructed(berSequence,
+								berString(berOctetString, "cn"),
+								berConstructed(berSet, berString(berOctetString, name)))))))
+					}
+				}
+			}
+			c.Write(result(ldapSearchResultDone, ldapResultSuccess))
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) Conns() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conns
+}
+
+func TestLDAPAuthBackend(t *testing.T) {
+
+	// Get an address where no server is listening
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	downHost := l.Addr().String()
+	l.Close()
+
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+
+	lb, err := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts: []interface{}{downHost, "ldap://" + s.listener.Addr().String()},
+		LDAPGroupMapping: map[string]interface{}{
+			"admins":  []interface{}{"admin"},
+			"readers": []interface{}{"public"},
+		},
+		LDAPDefaultGroups: []interface{}{},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer lb.Close()
+
+	if lb.Name() != "ldap" {
+		t.Error("Unexpected result:", lb.Name())
+		return
+	}
+
+	// First host is down - second host answers
+
+	if ok, groups, err := lb.Authenticate("alice", "secret"); !ok || fmt.Sprint(groups) != "[admin public]" || err != nil {
+		t.Error("Unexpected result:", ok, groups, err)
+		return
+	}
+
+	if ok, groups, err := lb.Authenticate("bob", "secret2"); !ok || fmt.Sprint(groups) != "[public]" || err != nil {
+		t.Error("Unexpected result:", ok, groups, err)
+		return
+	}
+
+	if ok, groups, err := lb.Authenticate("bob", "wrong"); ok || groups != nil || err != nil {
+		t.Error("Unexpected result:", ok, groups, err)
+		return
+	}
+
+	// Empty passwords and DN injections are rejected
+
+	if ok, _, err := lb.Authenticate("bob", ""); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, _, err := lb.Authenticate("alice,ou=people,dc=example,dc=com", "secret"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	// All requests were done with a single pooled connection
+
+	if res := s.Conns(); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Stale pooled connections are replaced
+
+	lb.mutex.Lock()
+	for _, c := range lb.pool["ldap://"+s.listener.Addr().String()] {
+		c.conn.Close()
+	}
+	lb.mutex.Unlock()
+
+	if ok, _, err := lb.Authenticate("alice", "secret"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if res := s.Conns(); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// No host reachable
+
+	lb2, _ := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts: []interface{}{downHost},
+	})
+
+	if ok, _, err := lb2.Authenticate("alice", "secret"); ok || err == nil ||
+		!strings.HasPrefix(err.Error(), "No LDAP host could verify credentials:") {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	// Invalid configs
+
+	if _, err := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts: []interface{}{},
+	}); err == nil || err.Error() != "LDAP config needs at least one host" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts:  []interface{}{downHost},
+		LDAPUserDN: "cn=admin",
+	}); err == nil || err.Error() != "LDAP user DN must contain %v: cn=admin" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts:    []interface{}{downHost},
+		LDAPPoolSize: 0,
+	}); err == nil || err.Error() != "Invalid LDAP config value for LDAPPoolSize: 0" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestCheckUserPassword(t *testing.T) {
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+
+	lb, _ := NewLDAPAuthBackend(map[string]interface{}{
+		LDAPHosts: []interface{}{s.listener.Addr().String()},
+		LDAPGroupMapping: map[string]interface{}{
+			"admins":  []interface{}{"admin", "unknown"},
+			"readers": []interface{}{"public"},
+		},
+		LDAPDefaultGroups: []interface{}{},
+	})
+	defer lb.Close()
+
+	oldBackend := Backend
+	Backend = lb
+	defer func() {
+		Backend = oldBackend
+	}()
+
+	ACL.AddUserToGroup("bob", "admin")
+
+	if !CheckUserPassword("alice", "secret") || !CheckUserPassword("bob", "secret2") || CheckUserPassword("bob", "foo") {
+		t.Error("Unexpected result")
+		return
+	}
+
+	// Group memberships are taken from the directory
+
+	if res, err := ACL.GroupsOfUser("alice"); fmt.Sprint(res) != "[admin public]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := ACL.GroupsOfUser("bob"); fmt.Sprint(res) != "[public]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Backend errors deny access
+
+	s.listener.Close()
+	lb.Close()
+
+	if CheckUserPassword("alice", "secret") {
+		t.Error("Unexpected result")
+		return
+	}
+
+	// The local backend uses the user database
+
+	Backend = oldBackend
+
+	if Backend.Name() != "local" || !CheckUserPassword("elias", "elias") || CheckUserPassword("elias", "foo") {
+		t.Error("Unexpected result")
+		return
+	}
+}
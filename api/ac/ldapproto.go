@@ -0,0 +1,320 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Minimal LDAPv3 protocol implementation (RFC 4511)
+// =================================================
+
+// BER tags which are used by the LDAP messages
+
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchResultItem = 0x64
+	ldapSearchResultDone = 0x65
+	ldapSimpleAuth       = 0x80
+	ldapEqualityFilter   = 0xa3
+)
+
+// LDAP result codes
+
+const (
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+)
+
+/*
+maxBERLength is the maximum length of a BER element which is accepted.
+*/
+const maxBERLength = 16 * 1024 * 1024
+
+/*
+berElement is a decoded BER element.
+*/
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+/*
+Children decodes the content of a constructed element.
+*/
+func (e *berElement) Children() ([]*berElement, error) {
+	var ret []*berElement
+
+	r := bufio.NewReader(bytes.NewReader(e.content))
+
+	for {
+		child, err := readBER(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, child)
+	}
+
+	return ret, nil
+}
+
+/*
+Int returns the content of the element as integer.
+*/
+func (e *berElement) Int() int {
+	var ret int
+
+	for i, b := range e.content {
+		if i == 0 && b&0x80 != 0 {
+			ret = -1
+		}
+		ret = ret<<8 | int(b)
+	}
+
+	return ret
+}
+
+/*
+readBER reads a single BER element.
+*/
+func readBER(r *bufio.Reader) (*berElement, error) {
+
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	length := int(l)
+
+	if l&0x80 != 0 {
+		n := int(l & 0x7f)
+
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("Unsupported BER length encoding")
+		}
+
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxBERLength {
+		return nil, fmt.Errorf("BER element too large: %v bytes", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return &berElement{tag, content}, nil
+}
+
+/*
+berEncode encodes a BER element.
+*/
+func berEncode(tag byte, content []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(tag)
+
+	if l := len(content); l < 0x80 {
+		buf.WriteByte(byte(l))
+	} else {
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		buf.WriteByte(0x80 | byte(len(lb)))
+		buf.Write(lb)
+	}
+
+	buf.Write(content)
+
+	return buf.Bytes()
+}
+
+/*
+berInt encodes a non-negative integer.
+*/
+func berInt(tag byte, v int) []byte {
+	content := []byte{byte(v)}
+
+	for v >>= 8; v > 0; v >>= 8 {
+		content = append([]byte{byte(v)}, content...)
+	}
+
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+
+	return berEncode(tag, content)
+}
+
+/*
+berString encodes a string.
+*/
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+/*
+berConstructed encodes a constructed element from a list of encoded children.
+*/
+func berConstructed(tag byte, children ...[]byte) []byte {
+	return berEncode(tag, bytes.Join(children, nil))
+}
+
+/*
+ldapMessage encodes a LDAP message envelope.
+*/
+func ldapMessage(id int, op []byte) []byte {
+	return berConstructed(berSequence, berInt(berInteger, id), op)
+}
+
+/*
+ldapBind encodes a simple bind request.
+*/
+func ldapBind(id int, dn string, pass string) []byte {
+	return ldapMessage(id, berConstructed(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapSimpleAuth, pass)))
+}
+
+/*
+ldapSearch encodes a subtree search request with a single equality filter
+which requests a single attribute.
+*/
+func ldapSearch(id int, baseDN string, attr string, value string, timeLimit int, resultAttr string) []byte {
+	return ldapMessage(id, berConstructed(ldapSearchRequest,
+		berString(berOctetString, baseDN),
+		berInt(berEnumerated, 2), // Scope: whole subtree
+		berInt(berEnumerated, 0), // Never dereference aliases
+		berInt(berInteger, 0),    // No size limit
+		berInt(berInteger, timeLimit),
+		berEncode(berBoolean, []byte{0}),
+		berConstructed(ldapEqualityFilter,
+			berString(berOctetString, attr),
+			berString(berOctetString, value)),
+		berConstructed(berSequence,
+			berString(berOctetString, resultAttr))))
+}
+
+/*
+ldapUnbind encodes an unbind request.
+*/
+func ldapUnbind(id int) []byte {
+	return ldapMessage(id, berEncode(ldapUnbindRequest, nil))
+}
+
+/*
+readLDAPMessage reads a LDAP message and returns its message id and its
+protocol operation.
+*/
+func readLDAPMessage(r *bufio.Reader) (int, *berElement, error) {
+
+	msg, err := readBER(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	children, err := msg.Children()
+	if err != nil {
+		return 0, nil, err
+	} else if msg.tag != berSequence || len(children) < 2 {
+		return 0, nil, fmt.Errorf("Malformed LDAP message")
+	}
+
+	return children[0].Int(), children[1], nil
+}
+
+/*
+ldapResult decodes the result code and diagnostic message of a LDAP result.
+*/
+func ldapResult(op *berElement) (int, string, error) {
+
+	children, err := op.Children()
+	if err != nil {
+		return 0, "", err
+	} else if len(children) < 3 {
+		return 0, "", fmt.Errorf("Malformed LDAP result")
+	}
+
+	return children[0].Int(), string(children[2].content), nil
+}
+
+/*
+ldapEntryValues decodes the values of a given attribute of a search result
+entry.
+*/
+func ldapEntryValues(op *berElement, attr string) ([]string, error) {
+	var ret []string
+
+	children, err := op.Children()
+	if err != nil {
+		return nil, err
+	} else if len(children) < 2 {
+		return nil, fmt.Errorf("Malformed LDAP search result")
+	}
+
+	attrs, err := children[1].Children()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attrs {
+
+		parts, err := a.Children()
+		if err != nil {
+			return nil, err
+		} else if len(parts) < 2 || !strings.EqualFold(string(parts[0].content), attr) {
+			continue
+		}
+
+		vals, err := parts[1].Children()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range vals {
+			ret = append(ret, string(v.content))
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,257 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+EndpointToken is the access token endpoint URL (rooted). Handles token/
+*/
+const EndpointToken = api.APIRoot + "/token/"
+
+/*
+TokenEndpointInst creates a new endpoint handler.
+*/
+func TokenEndpointInst() api.RestEndpointHandler {
+	return &tokenEndpoint{}
+}
+
+/*
+Handler object for access token operations.
+*/
+type tokenEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all access tokens.
+*/
+func (te *tokenEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkTokensEnabled(w) {
+		return
+	}
+
+	data := make([]map[string]interface{}, 0)
+
+	for _, t := range Tokens.AllTokens() {
+		data = append(data, t.Data())
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(data)
+}
+
+/*
+HandlePOST mints a new access token for the current user.
+*/
+func (te *tokenEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var params struct {
+		Description string   `json:"description"`
+		Queries     []string `json:"queries"`
+		Kinds       []string `json:"kinds"`
+		ReadOnly    *bool    `json:"read_only"`
+		Expires     int64    `json:"expires"`
+	}
+
+	if !checkTokensEnabled(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if params.Expires < 0 {
+		http.Error(w, "Invalid expiry: expires should be a positive number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	user := RequestUser(r)
+
+	if user == "" {
+		http.Error(w, "Access tokens can only be created by authenticated users", http.StatusForbidden)
+		return
+	}
+
+	// Tokens are read-only unless requested otherwise
+
+	readOnly := params.ReadOnly == nil || *params.ReadOnly
+
+	secret, token, err := Tokens.Create(user, params.Description, params.Queries,
+		params.Kinds, readOnly, params.Expires)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	LogAccess("User ", user, " created access token ", token.ID)
+
+	data := token.Data()
+	data["token"] = secret
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(data)
+}
+
+/*
+HandleDELETE revokes an access token.
+*/
+func (te *tokenEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkTokensEnabled(w) || !checkResources(w, resources, 1, 1, "Need a token ID") {
+		return
+	}
+
+	if err := Tokens.Revoke(resources[0]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	LogAccess("User ", RequestUser(r), " revoked access token ", resources[0])
+}
+
+/*
+checkTokensEnabled checks if access tokens are enabled.
+*/
+func checkTokensEnabled(w http.ResponseWriter) bool {
+	if Tokens == nil {
+		http.Error(w, "Access tokens are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (te *tokenEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/token"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all access tokens.",
+			"description": "All access tokens which have not expired (without their secrets).",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of access tokens.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Create a new access token.",
+			"description": "An access token acts on behalf of the current user and can be restricted to queries, kinds and read-only requests. It is sent in an Authorization: Bearer header.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "scope",
+					"in":          "body",
+					"description": "Scope of the token. Tokens are read-only by default. Expiry is given in seconds (0 for no expiry).",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"description": map[string]interface{}{
+								"type": "string",
+							},
+							"queries": map[string]interface{}{
+								"type": "array",
+								"items": map[string]interface{}{
+									"type": "string",
+								},
+							},
+							"kinds": map[string]interface{}{
+								"type": "array",
+								"items": map[string]interface{}{
+									"type": "string",
+								},
+							},
+							"read_only": map[string]interface{}{
+								"type": "boolean",
+							},
+							"expires": map[string]interface{}{
+								"type": "integer",
+							},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The new access token including its secret. The secret cannot be retrieved again.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/token/{id}"] = map[string]interface{}{
+		"delete": map[string]interface{}{
+			"summary":     "Revoke an access token.",
+			"description": "The token can no longer be used.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "id",
+					"in":          "path",
+					"description": "ID of the access token.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The token was revoked.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krotik/common/httputil/access"
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestTokenEndpoint(t *testing.T) {
+
+	queryURL := "http://localhost" + TESTPORT
+
+	// Register dummy data endpoints which return the request user
+
+	for _, e := range TokenEndpoints[:2] {
+		api.HandleFunc(e, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok " + RequestUser(r) + " " + fmt.Sprint(RequestGroups(r))))
+		})
+	}
+
+	authCookie := doAuth("elias", "elias")
+	withCookie := func(req *http.Request) {
+		req.AddCookie(authCookie)
+	}
+
+	withToken := func(token string) func(req *http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	mint := func(body string) map[string]interface{} {
+		var res map[string]interface{}
+
+		st := sendTestRequest("application/json", queryURL+EndpointToken, "POST", []byte(body), withCookie)
+
+		if err := json.Unmarshal([]byte(st), &res); err != nil {
+			t.Error("Unexpected result:", st)
+		}
+
+		return res
+	}
+
+	query := func(q string) string {
+		return queryURL + TokenEndpoints[0] + "main?q=" + url.QueryEscape(q)
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken, "POST", []byte("{"), withCookie); res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken, "POST",
+		[]byte(`{"queries":["get Song traverse ::: end"],"kinds":["Song"]}`), withCookie); res != "Query get Song traverse ::: end accesses kinds outside of the token scope" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken, "POST",
+		[]byte(`{"queries":["get Song where"]}`), withCookie); res != "Parse error in token query: Unexpected end" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Token for a single query
+
+	qtoken := mint(`{"description":"dashboard","queries":["get Song where name = 'a'"],"expires":3600}`)
+
+	if qtoken["user"] != "elias" || qtoken["read_only"] != true || qtoken["description"] != "dashboard" ||
+		qtoken["expires"].(float64) == 0 || !strings.HasPrefix(qtoken["token"].(string), qtoken["id"].(string)+".") {
+		t.Error("Unexpected result:", qtoken)
+		return
+	}
+
+	secret := qtoken["token"].(string)
+
+	if res := sendTestRequest("application/json", query("get Song where name = 'a'"), "GET", nil, withToken(secret)); res != "ok elias [admin public]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", query("get Author"), "GET", nil, withToken(secret)); res != "Access token does not permit this query" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", query("get Song where name = 'a'"), "POST", nil, withToken(secret)); res != "Access token is read-only" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken, "GET", nil, withToken(secret)); res != "Access token does not permit access to /db/token/" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", query("get Song"), "GET", nil, withToken("foo.bar")); res != "Invalid access token" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Token for a set of kinds
+
+	ktoken := mint(`{"kinds":["Song","Author"],"read_only":false}`)
+
+	secret = ktoken["token"].(string)
+
+	for _, u := range []string{
+		query("get Song traverse :::Author end"),
+		queryURL + TokenEndpoints[1] + "main/n/Song",
+		queryURL + TokenEndpoints[1] + "main/n/Song/1/:::Author",
+	} {
+		if res := sendTestRequest("application/json", u, "GET", nil, withToken(secret)); res != "ok elias [admin public]" {
+			t.Error("Unexpected result:", u, res)
+			return
+		}
+	}
+
+	for u, msg := range map[string]string{
+		query("get Song traverse ::: end"):                             "Access token does not permit access to all kinds",
+		query("get Song traverse :::Author traverse :::Label end end"): "Access token does not permit access to kind Label",
+		queryURL + TokenEndpoints[1] + "main/n/Label":                  "Access token does not permit access to kind Label",
+		queryURL + TokenEndpoints[1] + "main/n":                        "Access token does not permit access to all kinds",
+	} {
+		if res := sendTestRequest("application/json", u, "GET", nil, withToken(secret)); res != msg {
+			t.Error("Unexpected result:", u, res)
+			return
+		}
+	}
+
+	if res := sendTestRequest("application/json", queryURL+TokenEndpoints[1]+"main/n/Song", "PUT", nil, withToken(secret)); res != "ok elias [admin public]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Tokens can not exceed the rights of their user
+
+	johnCookie := doAuth("johndoe", "doe")
+
+	st := sendTestRequest("application/json", queryURL+EndpointToken, "POST", []byte(`{"read_only":false}`),
+		func(req *http.Request) {
+			req.AddCookie(johnCookie)
+		})
+
+	if st != "Requested create access to /db/token/ was denied" {
+		t.Error("Unexpected result:", st)
+		return
+	}
+
+	ACL.AddGroup("tokens")
+	ACL.AddPermission("tokens", "/db/token/", &access.Rights{Create: true, Read: true, Update: true, Delete: true})
+	ACL.AddUserToGroup("johndoe", "tokens")
+
+	defer func() {
+		ACL.RemoveUserFromGroup("johndoe", "tokens")
+		ACL.RemoveGroup("tokens")
+	}()
+
+	st = sendTestRequest("application/json", queryURL+EndpointToken, "POST", []byte(`{"read_only":false}`),
+		func(req *http.Request) {
+			req.AddCookie(johnCookie)
+		})
+
+	var jtoken map[string]interface{}
+	json.Unmarshal([]byte(st), &jtoken)
+
+	if res := sendTestRequest("application/json", queryURL+TokenEndpoints[1]+"main/n/Song", "GET", nil,
+		withToken(jtoken["token"].(string))); res != "ok johndoe [public tokens]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+TokenEndpoints[1]+"main/n/Song", "PUT", nil,
+		withToken(jtoken["token"].(string))); res != "Requested update access to /db/v1/graph/main/n/Song was denied" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// List and revoke tokens
+
+	res := sendTestRequest("application/json", queryURL+EndpointToken, "GET", nil, withCookie)
+
+	var tokens []map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &tokens); err != nil || len(tokens) != 3 || strings.Contains(res, "hash") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for _, token := range tokens {
+		if token["id"] == ktoken["id"] && fmt.Sprint(token["kinds"]) != "[Song Author]" {
+			t.Error("Unexpected result:", token)
+			return
+		}
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken+ktoken["id"].(string), "DELETE", nil, withCookie); res != "" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+EndpointToken+ktoken["id"].(string), "DELETE", nil, withCookie); res != "Unknown access token: "+ktoken["id"].(string) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest("application/json", queryURL+TokenEndpoints[1]+"main/n/Song", "GET", nil, withToken(secret)); res != "Invalid access token" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestTokenDB(t *testing.T) {
+	defer os.Remove("test_tokens.db")
+
+	db, err := NewTokenDB("test_tokens.db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	secret, token, err := db.Create("elias", "test", []string{" get Song "}, nil, true, 0)
+	if err != nil || fmt.Sprint(token.Queries) != "[get Song]" {
+		t.Error("Unexpected result:", token, err)
+		return
+	}
+
+	expSecret, _, _ := db.Create("elias", "test", nil, nil, true, 1)
+
+	db.tokens[strings.Split(expSecret, ".")[0]].Expires = 1
+
+	if _, err := db.Token(expSecret); err != ErrExpiredToken {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := db.Token(strings.Split(secret, ".")[0] + ".123"); err != ErrInvalidToken {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Tokens are loaded again - expired tokens are removed
+
+	db.save()
+
+	db2, err := NewTokenDB("test_tokens.db")
+	if err != nil || len(db2.AllTokens()) != 1 {
+		t.Error("Unexpected result:", db2.AllTokens(), err)
+		return
+	}
+
+	if res, err := db2.Token(secret); err != nil || res.ID != token.ID || res.User != "elias" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	ioutil.WriteFile("test_tokens.db", []byte("{"), 0600)
+
+	if _, err := NewTokenDB("test_tokens.db"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
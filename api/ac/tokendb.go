@@ -0,0 +1,525 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ac
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/eql/parser"
+)
+
+/*
+Tokens is the global database of scoped access tokens.
+*/
+var Tokens *TokenDB
+
+/*
+TokenEndpoints are the endpoints which can be accessed with an access token.
+*/
+var TokenEndpoints = []string{
+	api.APIRoot + "/v1/query/",
+	api.APIRoot + "/v1/graph/",
+	api.APIRoot + "/v1/find/",
+}
+
+/*
+ErrInvalidToken is returned if an access token is not known.
+*/
+var ErrInvalidToken = errors.New("Invalid access token")
+
+/*
+ErrExpiredToken is returned if an access token has expired.
+*/
+var ErrExpiredToken = errors.New("Access token has expired")
+
+/*
+AccessToken is a token which gives third parties narrowly scoped access to
+the REST API on behalf of a user. A token can be restricted to a set of
+queries, a set of kinds and to read-only requests. Only a hash of the token
+secret is kept.
+*/
+type AccessToken struct {
+	ID          string   `json:"id"`          // ID of the token
+	Hash        string   `json:"hash"`        // Hash of the token secret
+	User        string   `json:"user"`        // User on whose behalf the token acts
+	Description string   `json:"description"` // Description of the token
+	Queries     []string `json:"queries"`     // Queries which may be run (all if empty)
+	Kinds       []string `json:"kinds"`       // Kinds which may be accessed (all if empty)
+	ReadOnly    bool     `json:"read_only"`   // Flag if only read requests are allowed
+	Created     int64    `json:"created"`     // Creation time as Unix time
+	Expires     int64    `json:"expires"`     // Expiry as Unix time (0 for no expiry)
+}
+
+/*
+Data returns the public data of this token.
+*/
+func (t *AccessToken) Data() map[string]interface{} {
+
+	nonNil := func(l []string) []string {
+		if l == nil {
+			return []string{}
+		}
+		return l
+	}
+
+	return map[string]interface{}{
+		"id":          t.ID,
+		"user":        t.User,
+		"description": t.Description,
+		"queries":     nonNil(t.Queries),
+		"kinds":       nonNil(t.Kinds),
+		"read_only":   t.ReadOnly,
+		"created":     t.Created,
+		"expires":     t.Expires,
+	}
+}
+
+/*
+Permits checks if the scope of this token permits a given request. Returns an
+error which describes why the request is not permitted.
+*/
+func (t *AccessToken) Permits(r *http.Request) error {
+	var endpoint string
+
+	for _, e := range TokenEndpoints {
+		if strings.HasPrefix(r.URL.Path, e) {
+			endpoint = e
+		}
+	}
+
+	if endpoint == "" {
+		return fmt.Errorf("Access token does not permit access to %v", r.URL.Path)
+	} else if t.ReadOnly && r.Method != "GET" {
+		return fmt.Errorf("Access token is read-only")
+	}
+
+	query := r.URL.Query().Get("q")
+
+	if len(t.Queries) > 0 && (endpoint != TokenEndpoints[0] || !t.hasQuery(query)) {
+		return fmt.Errorf("Access token does not permit this query")
+	}
+
+	if len(t.Kinds) == 0 {
+		return nil
+	}
+
+	var kinds []string
+
+	switch endpoint {
+
+	case TokenEndpoints[0]:
+		ast, err := eql.ParseQuery("token query", query)
+		if err != nil {
+			return err
+		}
+		kinds = queryKinds(ast)
+
+	case TokenEndpoints[1]:
+
+		// Kinds are only known if they are part of the path
+
+		res := strings.Split(strings.TrimPrefix(r.URL.Path, endpoint), "/")
+
+		if len(res) < 3 {
+			kinds = []string{""}
+		} else {
+			kinds = []string{res[2]}
+			if len(res) > 4 {
+				spec := strings.Split(res[4], ":")
+				kinds = append(kinds, spec[len(spec)-1])
+			}
+		}
+
+	default:
+		kinds = []string{""}
+	}
+
+	for _, kind := range kinds {
+		if !t.hasKind(kind) {
+			if kind == "" {
+				return fmt.Errorf("Access token does not permit access to all kinds")
+			}
+			return fmt.Errorf("Access token does not permit access to kind %v", kind)
+		}
+	}
+
+	return nil
+}
+
+/*
+hasQuery checks if this token permits a given query.
+*/
+func (t *AccessToken) hasQuery(query string) bool {
+	for _, q := range t.Queries {
+		if q == strings.TrimSpace(query) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+hasKind checks if this token permits access to a given kind.
+*/
+func (t *AccessToken) hasKind(kind string) bool {
+	for _, k := range t.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+queryKinds returns all kinds which are accessed by a query. An empty kind is
+returned if a traversal can reach all kinds.
+*/
+func queryKinds(ast *parser.ASTNode) []string {
+	var kinds []string
+
+	var collectTraversals func(n *parser.ASTNode)
+	collectTraversals = func(n *parser.ASTNode) {
+		for _, c := range n.Children {
+			if c.Name == parser.NodeTRAVERSE && len(c.Children) > 0 {
+				spec := strings.Split(c.Children[0].Token.Val, ":")
+				kinds = append(kinds, spec[len(spec)-1])
+				collectTraversals(c)
+			} else if c.Name == parser.NodeJOIN && len(c.Children) > 0 {
+				kinds = append(kinds, c.Children[0].Token.Val)
+			}
+		}
+	}
+
+	if len(ast.Children) > 0 {
+		kinds = append(kinds, ast.Children[0].Token.Val)
+		collectTraversals(ast)
+	}
+
+	return kinds
+}
+
+/*
+TokenDB stores access tokens. Tokens are persisted in a file if a file name
+is given.
+*/
+type TokenDB struct {
+	file   string                  // File which stores the tokens
+	tokens map[string]*AccessToken // Map of token ID to token
+	mutex  *sync.RWMutex           // Mutex to protect the token map
+}
+
+/*
+NewTokenDB creates a new token database. Existing tokens are loaded from the
+given file. No file is used if the file name is empty.
+*/
+func NewTokenDB(file string) (*TokenDB, error) {
+	var tokens []*AccessToken
+
+	db := &TokenDB{file, make(map[string]*AccessToken), &sync.RWMutex{}}
+
+	if file != "" {
+		ok, err := fileutil.PathExists(file)
+
+		if ok && err == nil {
+			var content []byte
+
+			if content, err = ioutil.ReadFile(file); err == nil {
+				err = json.Unmarshal(content, &tokens)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range tokens {
+			db.tokens[t.ID] = t
+		}
+	}
+
+	return db, nil
+}
+
+/*
+Create creates a new access token for a given user and returns the token
+secret. The expiry is given in seconds (0 for no expiry).
+*/
+func (db *TokenDB) Create(user string, description string, queries []string, kinds []string,
+	readOnly bool, expires int64) (string, *AccessToken, error) {
+
+	var scopeQueries []string
+
+	for _, q := range queries {
+		ast, err := eql.ParseQuery("token query", q)
+		if err != nil {
+			return "", nil, err
+		}
+
+		scopeQueries = append(scopeQueries, strings.TrimSpace(q))
+
+		if len(kinds) > 0 {
+			t := &AccessToken{Kinds: kinds}
+
+			for _, kind := range queryKinds(ast) {
+				if !t.hasKind(kind) {
+					return "", nil, fmt.Errorf("Query %v accesses kinds outside of the token scope", q)
+				}
+			}
+		}
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	} else if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().Unix()
+
+	if expires > 0 {
+		expires += now
+	}
+
+	token := &AccessToken{
+		ID:          hex.EncodeToString(id),
+		Hash:        tokenHash(hex.EncodeToString(secret)),
+		User:        user,
+		Description: description,
+		Queries:     scopeQueries,
+		Kinds:       kinds,
+		ReadOnly:    readOnly,
+		Created:     now,
+		Expires:     expires,
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.tokens[token.ID] = token
+
+	return token.ID + "." + hex.EncodeToString(secret), token, db.save()
+}
+
+/*
+Token returns the access token of a given token secret.
+*/
+func (db *TokenDB) Token(secret string) (*AccessToken, error) {
+
+	parts := strings.SplitN(secret, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	db.mutex.RLock()
+	token, ok := db.tokens[parts[0]]
+	db.mutex.RUnlock()
+
+	if !ok || !hmac.Equal([]byte(token.Hash), []byte(tokenHash(parts[1]))) {
+		return nil, ErrInvalidToken
+	} else if token.Expires != 0 && token.Expires < time.Now().Unix() {
+		return nil, ErrExpiredToken
+	}
+
+	return token, nil
+}
+
+/*
+AllTokens returns all tokens which have not expired ordered by creation time.
+*/
+func (db *TokenDB) AllTokens() []*AccessToken {
+	var ret []*AccessToken
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	now := time.Now().Unix()
+
+	for _, t := range db.tokens {
+		if t.Expires == 0 || t.Expires >= now {
+			ret = append(ret, t)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Created == ret[j].Created {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].Created < ret[j].Created
+	})
+
+	return ret
+}
+
+/*
+Revoke removes an access token.
+*/
+func (db *TokenDB) Revoke(id string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.tokens[id]; !ok {
+		return fmt.Errorf("Unknown access token: %v", id)
+	}
+
+	delete(db.tokens, id)
+
+	return db.save()
+}
+
+/*
+save writes all tokens which have not expired to the token file.
+*/
+func (db *TokenDB) save() error {
+	tokens := make([]*AccessToken, 0, len(db.tokens))
+
+	now := time.Now().Unix()
+
+	for id, t := range db.tokens {
+		if t.Expires != 0 && t.Expires < now {
+			delete(db.tokens, id)
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	if db.file == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(tokens, "", "  ")
+
+	if err == nil {
+		err = ioutil.WriteFile(db.file, content, 0600)
+	}
+
+	return err
+}
+
+/*
+tokenHash returns the hash of a token secret.
+*/
+func tokenHash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Token authentication
+// ====================
+
+/*
+tokenHandlers stores the original handlers of all registered endpoints.
+*/
+var tokenHandlers = make(map[string]func(http.ResponseWriter, *http.Request))
+
+/*
+HandleFunc registers an endpoint which is subject to access control. Requests
+can either be authenticated with a cookie (see AuthHandler) or with an access
+token in an Authorization: Bearer header.
+*/
+func HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	tokenHandlers[pattern] = handler
+	AuthHandler.HandleFunc(pattern, handler)
+}
+
+/*
+TokenHandleFunc wraps a HandleFunc so requests with an access token are
+passed to the original handler of an endpoint. The result should be used as
+the HandleFunc of the AuthHandler.
+*/
+func TokenHandleFunc(origHandleFunc func(pattern string,
+	handler func(http.ResponseWriter, *http.Request))) func(pattern string,
+	handler func(http.ResponseWriter, *http.Request)) {
+
+	return func(pattern string, cookieHandler func(http.ResponseWriter, *http.Request)) {
+		handler := tokenHandlers[pattern]
+
+		origHandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if secret := requestTokenSecret(r); secret != "" && handler != nil {
+				handleTokenRequest(w, r, secret, handler)
+				return
+			}
+			cookieHandler(w, r)
+		})
+	}
+}
+
+/*
+handleTokenRequest checks the access token of a request and passes the
+request on to a given handler if the token permits it. The request must also
+be permitted for the user of the token.
+*/
+func handleTokenRequest(w http.ResponseWriter, r *http.Request, secret string,
+	handler func(http.ResponseWriter, *http.Request)) {
+
+	var token *AccessToken
+
+	err := ErrInvalidToken
+
+	if Tokens != nil {
+		token, err = Tokens.Token(secret)
+	}
+
+	if err != nil {
+		LogAccess("Token request to ", r.URL.Path, " from ", r.RemoteAddr, " failed: ", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := token.Permits(r); err != nil {
+		LogAccess("Token ", token.ID, " of user ", token.User, " requested ", r.URL.Path, " - ", DENIED, " (", err, ")")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if ACL == nil || ACL.CheckHTTPRequest(w, r, token.User) {
+		handler(w, r)
+	}
+}
+
+/*
+requestTokenSecret returns the access token secret of a request.
+*/
+func requestTokenSecret(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+/*
+requestToken returns the valid access token of a request or nil.
+*/
+func requestToken(r *http.Request) *AccessToken {
+	if secret := requestTokenSecret(r); secret != "" && Tokens != nil {
+		if token, err := Tokens.Token(secret); err == nil {
+			return token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/krotik/eliasdb/codec"
+)
+
+/*
+ResponseCodecs is the list of codecs which can be used to compress REST
+responses in order of preference. The codec is negotiated with the
+Accept-Encoding header of the client. Responses are not compressed if the list
+is empty.
+*/
+var ResponseCodecs []codec.Codec
+
+/*
+negotiateCodec returns the preferred response codec which is accepted by the
+client of a given request. Returns nil if the response should not be compressed.
+*/
+func negotiateCodec(r *http.Request) codec.Codec {
+	accepted := make(map[string]bool)
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+
+		// A coding with a quality value of 0 is not acceptable
+
+		accepted[coding] = true
+
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 &&
+				strings.ToLower(kv[0]) == "q" {
+
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q == 0 {
+					accepted[coding] = false
+				}
+			}
+		}
+	}
+
+	for _, c := range ResponseCodecs {
+		enc := c.Encoding()
+
+		if enc == "" {
+			continue
+		}
+
+		if ok, known := accepted[enc]; ok || (!known && accepted["*"]) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+/*
+compressResponse runs a request handler and compresses its response with a
+codec which is accepted by the client. Requests for a protocol upgrade (e.g.
+websockets) are not compressed.
+*/
+func compressResponse(w http.ResponseWriter, r *http.Request, handler func(w http.ResponseWriter, r *http.Request)) {
+
+	if len(ResponseCodecs) == 0 {
+		handler(w, r)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	c := negotiateCodec(r)
+
+	if c == nil || r.Method == "HEAD" || r.Header.Get("Upgrade") != "" {
+		handler(w, r)
+		return
+	}
+
+	cw := &compressingResponseWriter{ResponseWriter: w, codec: c}
+
+	handler(cw, r)
+
+	cw.Close()
+}
+
+/*
+compressingResponseWriter compresses the body of a response.
+*/
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	codec   codec.Codec    // Codec for the response body
+	writer  io.WriteCloser // Writer for the compressed body (nil if the body is not compressed)
+	started bool           // Flag if the response header was written
+}
+
+/*
+WriteHeader writes the response header. The body is not compressed if the
+response has no body or if the handler has chosen an encoding itself.
+*/
+func (cw *compressingResponseWriter) WriteHeader(status int) {
+
+	if cw.started {
+		return
+	}
+
+	cw.started = true
+
+	h := cw.Header()
+
+	if status >= http.StatusOK && status != http.StatusNoContent &&
+		status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+
+		if w, err := cw.codec.NewWriter(cw.ResponseWriter); err == nil {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", cw.codec.Encoding())
+			cw.writer = w
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+/*
+Write writes data to the response body.
+*/
+func (cw *compressingResponseWriter) Write(b []byte) (int, error) {
+
+	if !cw.started {
+
+		// The content type must be detected from the uncompressed data
+
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+/*
+Close writes all remaining compressed data of the response body.
+*/
+func (cw *compressingResponseWriter) Close() error {
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+/*
+Hijack allows websocket endpoints to take over the connection.
+*/
+func (cw *compressingResponseWriter) Hijack() (c net.Conn, rw *bufio.ReadWriter, err error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		cw.started = true
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Connection cannot be hijacked")
+}
@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/codec"
+)
+
+func TestResponseCompression(t *testing.T) {
+	var buf bytes.Buffer
+
+	hs, wg := startServer()
+	if hs == nil {
+		return
+	}
+	defer func() {
+		stopServer(hs, wg)
+	}()
+
+	RequestLog, _ = NewRequestLogger(&buf, "info")
+	ResponseCodecs, _ = codec.GetList("zlib:9, gzip")
+	defer func() {
+		RequestLog = nil
+		ResponseCodecs = nil
+	}()
+
+	queryURL := "http://localhost" + TESTPORT + "/testcompression/"
+
+	RegisterRestEndpoints(map[string]RestEndpointInst{
+		"/testcompression/": func() RestEndpointHandler {
+			return &testLogEndpoint{}
+		},
+	})
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	sendRequest := func(url string, acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set(HTTPHeaderRequestID, "myid")
+
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return nil, nil
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp, body
+	}
+
+	// The preferred codec which is accepted by the client is used
+
+	resp, body := sendRequest(queryURL, "gzip, deflate")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "deflate" ||
+		resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Error("Unexpected result:", enc, resp.Header)
+		return
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ioutil.ReadAll(r); string(res) != "myid" {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	resp, _ = sendRequest(queryURL, "gzip, deflate;q=0")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Error("Unexpected result:", enc)
+		return
+	}
+
+	resp, _ = sendRequest(queryURL, "*")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "deflate" {
+		t.Error("Unexpected result:", enc)
+		return
+	}
+
+	// Responses are not compressed if the client accepts none of the codecs
+
+	resp, body = sendRequest(queryURL, "br")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" || string(body) != "myid" {
+		t.Error("Unexpected result:", enc, string(body))
+		return
+	}
+
+	resp, body = sendRequest(queryURL, "")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" || string(body) != "myid" {
+		t.Error("Unexpected result:", enc, string(body))
+		return
+	}
+
+	// Reported errors are compressed and logged
+
+	buf.Reset()
+
+	// The default client accepts gzip and decompresses the response transparently
+
+	if res := sendTestRequest(queryURL+"foo", "GET", nil); !strings.HasPrefix(res,
+		"GraphError: Invalid data (foo) (request id: ") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if !strings.Contains(buf.String(), `"msg":"GraphError: Invalid data (foo)"`) {
+		t.Error("Unexpected result:", buf.String())
+		return
+	}
+
+	resp, _ = sendRequest(queryURL+"foo", "gzip")
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" || resp.StatusCode != http.StatusBadRequest {
+		t.Error("Unexpected result:", enc, resp.StatusCode)
+		return
+	}
+}
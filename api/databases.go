@@ -0,0 +1,176 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+OpenDatabase returns the GraphManager of a database which is addressed by a
+request. The function should check if the request may access the database.
+It writes an error and returns nil if the request cannot be served. Requests
+for databases are rejected if this function is not set.
+*/
+var OpenDatabase func(w http.ResponseWriter, r *http.Request, name string) *graph.Manager
+
+/*
+Map of endpoint handlers which are available for each database.
+*/
+var databaseEndpoints = map[string]RestEndpointInst{}
+
+/*
+Names of databases whose root has been registered.
+*/
+var databaseRoots = map[string]bool{}
+
+/*
+databaseLock is the lock for the database endpoint maps.
+*/
+var databaseLock = &sync.RWMutex{}
+
+/*
+databaseKey is the context key of the database of a request.
+*/
+type databaseKey struct{}
+
+/*
+database is the database of a request.
+*/
+type database struct {
+	name string         // Name of the database
+	gm   *graph.Manager // GraphManager of the database
+}
+
+/*
+DatabaseRoot returns the root of the REST API of a database. The endpoints of
+a database are available under this root (e.g. /db/<name>/api/v1/graph/).
+*/
+func DatabaseRoot(name string) string {
+	return APIRoot + "/" + name + "/api"
+}
+
+/*
+RegisterDatabaseEndpoints registers REST endpoint handlers which are available
+for each database.
+*/
+func RegisterDatabaseEndpoints(endpointInsts map[string]RestEndpointInst) {
+	databaseLock.Lock()
+	defer databaseLock.Unlock()
+
+	for url, endpointInst := range endpointInsts {
+		databaseEndpoints[url] = endpointInst
+	}
+}
+
+/*
+RegisterDatabase registers the root of the REST API of a database. Roots of
+removed databases stay registered - OpenDatabase rejects their requests.
+*/
+func RegisterDatabase(name string) {
+	databaseLock.Lock()
+	defer databaseLock.Unlock()
+
+	if databaseRoots[name] {
+		return
+	}
+
+	databaseRoots[name] = true
+
+	root := DatabaseRoot(name)
+
+	HandleFunc(root+"/", func(w http.ResponseWriter, r *http.Request) {
+		serveRequest(root+"/", w, r, func(w http.ResponseWriter, r *http.Request) {
+			handleDatabaseRequest(name, root, w, r)
+		})
+	})
+}
+
+/*
+handleDatabaseRequest dispatches a request for a database to an endpoint
+handler. The endpoint handler sees the path of the main REST API and gets the
+GraphManager of the database through RequestGM.
+*/
+func handleDatabaseRequest(name string, root string, w http.ResponseWriter, r *http.Request) {
+	var handlerURL string
+
+	path := APIRoot + strings.TrimPrefix(r.URL.Path, root)
+
+	databaseLock.RLock()
+
+	for url := range databaseEndpoints {
+		if strings.HasPrefix(path+"/", url) && len(url) > len(handlerURL) {
+			handlerURL = url
+		}
+	}
+
+	if len(path) < len(handlerURL) {
+
+		// Endpoint roots can be addressed without a trailing slash
+
+		path = handlerURL
+	}
+
+	handlerInst := databaseEndpoints[handlerURL]
+
+	databaseLock.RUnlock()
+
+	if handlerInst == nil {
+		http.Error(w, "Unknown endpoint: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+
+	if OpenDatabase == nil {
+		http.Error(w, "Databases are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	gm := OpenDatabase(w, r, name)
+	if gm == nil {
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), databaseKey{}, &database{name, gm}))
+
+	u := *r.URL
+	u.Path = path
+	r.URL = &u
+
+	handleRequest(handlerURL, handlerInst, w, r)
+}
+
+/*
+RequestGM returns the GraphManager which should be used for a request. This
+is the GraphManager of the addressed database or GM for requests of the main
+REST API.
+*/
+func RequestGM(r *http.Request) *graph.Manager {
+	if db, ok := r.Context().Value(databaseKey{}).(*database); ok {
+		return db.gm
+	}
+	return GM
+}
+
+/*
+RequestDatabase returns the name of the database which is addressed by a
+request. Returns an empty string for requests of the main REST API.
+*/
+func RequestDatabase(r *http.Request) string {
+	if db, ok := r.Context().Value(databaseKey{}).(*database); ok {
+		return db.name
+	}
+	return ""
+}
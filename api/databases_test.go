@@ -0,0 +1,105 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+type testDatabaseEndpoint struct {
+	*DefaultEndpointHandler
+}
+
+func (te *testDatabaseEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	fmt.Fprint(w, RequestDatabase(r), " ", RequestGM(r) != GM, " ", strings.Join(resources, "/"))
+}
+
+func (te *testDatabaseEndpoint) SwaggerDefs(s map[string]interface{}) {
+}
+
+func TestDatabaseRequests(t *testing.T) {
+
+	hs, wg := startServer()
+	if hs == nil {
+		return
+	}
+	defer func() {
+		stopServer(hs, wg)
+	}()
+
+	oldGM := GM
+	defer func() {
+		GM = oldGM
+		OpenDatabase = nil
+	}()
+
+	GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("main"))
+	tgm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("tenant"))
+
+	RegisterDatabaseEndpoints(map[string]RestEndpointInst{
+		APIRoot + "/v1/dbtest/": func() RestEndpointHandler {
+			return &testDatabaseEndpoint{}
+		},
+	})
+
+	RegisterDatabase("tenant")
+	RegisterDatabase("tenant")
+
+	if res := DatabaseRoot("tenant"); res != "/db/tenant/api" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	queryURL := "http://localhost" + TESTPORT + DatabaseRoot("tenant")
+
+	if res := sendTestRequest(queryURL+"/v1/dbtest/foo", "GET", nil); res != "Databases are not enabled" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	OpenDatabase = func(w http.ResponseWriter, r *http.Request, name string) *graph.Manager {
+		if r.URL.Query().Get("deny") != "" {
+			http.Error(w, "Denied", http.StatusForbidden)
+			return nil
+		}
+		return tgm
+	}
+
+	if res := sendTestRequest(queryURL+"/v1/dbtest/foo/bar", "GET", nil); res != "tenant true foo/bar" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest(queryURL+"/v1/dbtest/?deny=1", "GET", nil); res != "Denied" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := sendTestRequest(queryURL+"/v1/foo/", "GET", nil); res != "Unknown endpoint: /db/tenant/api/v1/foo/" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Requests of the main REST API use the main GraphManager
+
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	if RequestGM(r) != GM || RequestDatabase(r) != "" {
+		t.Error("Unexpected result:", RequestGM(r), RequestDatabase(r))
+		return
+	}
+}
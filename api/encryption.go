@@ -0,0 +1,38 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+/*
+EncryptionKeyManager manages the encryption keys of the storage.
+*/
+type EncryptionKeyManager interface {
+
+	/*
+		RotateKey creates a new encryption key for a given partition (or for
+		all partitions without their own keys if the partition is empty) and
+		makes it the active key. Returns the ID of the new key. Existing
+		records must be re-encrypted to use the new key.
+	*/
+	RotateKey(partition string) (byte, error)
+
+	/*
+		ShredPartition removes all encryption keys of a partition. All records
+		of the partition which were encrypted with its own keys become
+		permanently unreadable.
+	*/
+	ShredPartition(partition string) error
+}
+
+/*
+EncryptionKeys is the manager of the encryption keys of the storage (nil if
+encryption is not enabled).
+*/
+var EncryptionKeys EncryptionKeyManager
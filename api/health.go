@@ -0,0 +1,275 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/krotik/eliasdb/config"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+/*
+EndpointHealth is the liveness endpoint URL (rooted). Handles healthz/
+*/
+const EndpointHealth = APIRoot + "/healthz/"
+
+/*
+EndpointReady is the readiness endpoint URL (rooted). Handles readyz/
+*/
+const EndpointReady = APIRoot + "/readyz/"
+
+/*
+Status values of health checks
+*/
+const (
+	HealthStatusOk   = "ok"
+	HealthStatusFail = "fail"
+)
+
+/*
+ReadyMaxPendingTransfers is the maximum number of pending cluster transfer
+requests before the instance is reported as not ready.
+*/
+var ReadyMaxPendingTransfers = 1000
+
+/*
+HealthEndpointInst creates a new endpoint handler.
+*/
+func HealthEndpointInst() RestEndpointHandler {
+	return &healthEndpoint{}
+}
+
+/*
+Handler object for liveness checks.
+*/
+type healthEndpoint struct {
+	*DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the liveness status of the server.
+*/
+func (he *healthEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	writeHealthStatus(w, map[string]interface{}{
+		"status":  HealthStatusOk,
+		"version": config.ProductVersion,
+	}, true)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (he *healthEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/healthz"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the liveness status of the server.",
+			"description": "The healthz endpoint returns ok as long as the server can handle requests.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Status object.",
+				},
+			},
+		},
+	}
+}
+
+/*
+ReadyEndpointInst creates a new endpoint handler.
+*/
+func ReadyEndpointInst() RestEndpointHandler {
+	return &readyEndpoint{}
+}
+
+/*
+Handler object for readiness checks.
+*/
+type readyEndpoint struct {
+	*DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the readiness status of the server and the status of
+each of its components.
+*/
+func (re *readyEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	components := map[string]interface{}{
+		"storage": checkStorageHealth(),
+	}
+
+	if DD != nil {
+		components["cluster"] = checkClusterHealth()
+		components["transfer_queue"] = checkTransferQueueHealth()
+	}
+
+	ready := true
+
+	for _, c := range components {
+		if c.(map[string]interface{})["status"] != HealthStatusOk {
+			ready = false
+		}
+	}
+
+	status := HealthStatusOk
+	if !ready {
+		status = HealthStatusFail
+	}
+
+	writeHealthStatus(w, map[string]interface{}{
+		"status":     status,
+		"components": components,
+	}, ready)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (re *readyEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/readyz"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the readiness status of the server.",
+			"description": "The readyz endpoint checks the storage, the cluster transfer queue and the cluster quorum (if clustering is enabled).",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Status object with the status of all components.",
+				},
+				"503": map[string]interface{}{
+					"description": "Status object with the status of all components - at least one component failed.",
+				},
+			},
+		},
+	}
+}
+
+/*
+checkStorageHealth checks that the graph storage can be accessed.
+*/
+func checkStorageHealth() map[string]interface{} {
+
+	if GS == nil {
+		return healthFailure("No graph storage available")
+	}
+
+	if hc, ok := GS.(graphstorage.HealthCheck); ok {
+		if err := hc.CheckHealth(); err != nil {
+			return healthFailure(err.Error())
+		}
+	}
+
+	return map[string]interface{}{
+		"status": HealthStatusOk,
+		"name":   GS.Name(),
+	}
+}
+
+/*
+checkClusterHealth checks that the cluster has enough operational members.
+Only member counts are reported since the readiness endpoint does not
+require authentication.
+*/
+func checkClusterHealth() map[string]interface{} {
+	members := len(DD.MemberManager.Members())
+	failed := len(DD.MemberManager.Client.FailedPeers())
+	repFac := DD.ReplicationFactor()
+
+	ret := map[string]interface{}{
+		"status":             HealthStatusOk,
+		"members":            members,
+		"live":               members - failed,
+		"replication_factor": repFac,
+	}
+
+	if _, err := DD.DistributionTable(); err != nil {
+		ret["status"] = HealthStatusFail
+		ret["error"] = err.Error()
+
+	} else if err := checkClusterQuorum(members, failed, repFac); err != nil {
+		ret["status"] = HealthStatusFail
+		ret["error"] = err.Error()
+	}
+
+	return ret
+}
+
+/*
+checkClusterQuorum checks that enough cluster members are live to reach all
+data. Each item is stored on repFac members so at most repFac - 1 members can
+fail.
+*/
+func checkClusterQuorum(members int, failed int, repFac int) error {
+
+	if quorum := members - repFac + 1; members-failed < quorum {
+		return fmt.Errorf("Not enough live members for replication factor %v (live: %v, required: %v)",
+			repFac, members-failed, quorum)
+	}
+
+	return nil
+}
+
+/*
+checkTransferQueueHealth checks that the cluster transfer queue is not saturated.
+*/
+func checkTransferQueueHealth() map[string]interface{} {
+	pending, err := DD.PendingTransfers()
+
+	if err != nil {
+		return healthFailure(err.Error())
+	}
+
+	ret := map[string]interface{}{
+		"status":  HealthStatusOk,
+		"pending": pending,
+		"max":     ReadyMaxPendingTransfers,
+	}
+
+	if pending >= ReadyMaxPendingTransfers {
+		ret["status"] = HealthStatusFail
+		ret["error"] = fmt.Sprintf("Transfer queue is saturated (%v pending requests)", pending)
+	}
+
+	return ret
+}
+
+/*
+healthFailure returns a failed component status.
+*/
+func healthFailure(msg string) map[string]interface{} {
+	return map[string]interface{}{
+		"status": HealthStatusFail,
+		"error":  msg,
+	}
+}
+
+/*
+writeHealthStatus writes a status object.
+*/
+func writeHealthStatus(w http.ResponseWriter, data map[string]interface{}, ok bool) {
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.Header().Set("cache-control", "no-cache")
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
@@ -0,0 +1,119 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+
+	oldGS := GS
+	defer func() {
+		GS = oldGS
+	}()
+
+	// Liveness check is always ok
+
+	rec := httptest.NewRecorder()
+	HealthEndpointInst().HandleGET(rec, nil, nil)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"ok","version":"1.2.0"}`+"\n" {
+		t.Error("Unexpected result:", rec.Code, rec.Body.String())
+		return
+	}
+
+	// Readiness check fails without storage
+
+	GS = nil
+
+	rec = httptest.NewRecorder()
+	ReadyEndpointInst().HandleGET(rec, nil, nil)
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() !=
+		`{"components":{"storage":{"error":"No graph storage available","status":"fail"}},"status":"fail"}`+"\n" {
+		t.Error("Unexpected result:", rec.Code, rec.Body.String())
+		return
+	}
+
+	// Readiness check with a disk storage
+
+	testdb := "healthtest"
+	defer os.RemoveAll(testdb)
+
+	dgs, err := graphstorage.NewDiskGraphStorage(testdb, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	GS = dgs
+
+	rec = httptest.NewRecorder()
+	ReadyEndpointInst().HandleGET(rec, nil, nil)
+
+	if rec.Code != http.StatusOK || rec.Body.String() !=
+		`{"components":{"storage":{"name":"healthtest","status":"ok"}},"status":"ok"}`+"\n" {
+		t.Error("Unexpected result:", rec.Code, rec.Body.String())
+		return
+	}
+
+	// Simulate a storage which cannot be accessed
+
+	os.Remove(testdb + "/" + graphstorage.FilenameNameDB)
+
+	rec = httptest.NewRecorder()
+	ReadyEndpointInst().HandleGET(rec, nil, nil)
+
+	var res map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+
+	if rec.Code != http.StatusServiceUnavailable || res["status"] != "fail" {
+		t.Error("Unexpected result:", rec.Code, rec.Body.String())
+		return
+	}
+}
+
+func TestClusterQuorum(t *testing.T) {
+
+	if err := checkClusterQuorum(3, 0, 2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkClusterQuorum(3, 1, 2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkClusterQuorum(3, 2, 2); err == nil || err.Error() !=
+		"Not enough live members for replication factor 2 (live: 1, required: 2)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := checkClusterQuorum(5, 2, 3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkClusterQuorum(5, 3, 3); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
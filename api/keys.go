@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+/*
+KeyObfuscator translates internal node keys into opaque external IDs and back.
+Key obfuscation is applied at the REST boundary so sequential or meaningful keys
+are not exposed to untrusted clients.
+*/
+type KeyObfuscator interface {
+
+	/*
+		ExternalKey returns the external ID for a node key of a given kind.
+	*/
+	ExternalKey(kind string, key string) string
+
+	/*
+		InternalKey returns the node key for an external ID of a given kind.
+	*/
+	InternalKey(kind string, id string) (string, error)
+}
+
+/*
+KeyObfuscation is the key obfuscator which is used by the REST API (nil if keys
+should not be obfuscated).
+*/
+var KeyObfuscation KeyObfuscator
+
+/*
+ErrInvalidExternalKey is returned if an external ID cannot be translated.
+*/
+var ErrInvalidExternalKey = errors.New("Invalid external key")
+
+/*
+ExternalKey translates a node key using the current key obfuscator. The key is
+returned unchanged if key obfuscation is disabled.
+*/
+func ExternalKey(kind string, key string) string {
+	if KeyObfuscation == nil || key == "" {
+		return key
+	}
+	return KeyObfuscation.ExternalKey(kind, key)
+}
+
+/*
+InternalKey translates an external ID using the current key obfuscator. The ID
+is returned unchanged if key obfuscation is disabled.
+*/
+func InternalKey(kind string, id string) (string, error) {
+	if KeyObfuscation == nil || id == "" {
+		return id, nil
+	}
+	return KeyObfuscation.InternalKey(kind, id)
+}
+
+/*
+TokenKeyObfuscator translates node keys into encrypted tokens. Tokens are
+deterministic (the same key of the same kind always results in the same token)
+and bound to the node kind. Tokens are encrypted with AES-GCM using a nonce which
+is derived from the kind and key.
+*/
+type TokenKeyObfuscator struct {
+	aead   cipher.AEAD // Cipher to encrypt keys
+	macKey []byte      // Key to derive nonces
+}
+
+/*
+NewTokenKeyObfuscator creates a new TokenKeyObfuscator from a given secret.
+*/
+func NewTokenKeyObfuscator(secret string) (*TokenKeyObfuscator, error) {
+
+	if secret == "" {
+		return nil, fmt.Errorf("Secret for key obfuscation must not be empty")
+	}
+
+	encKey := sha256.Sum256([]byte("enc:" + secret))
+	macKey := sha256.Sum256([]byte("mac:" + secret))
+
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenKeyObfuscator{aead, macKey[:]}, nil
+}
+
+/*
+ExternalKey returns the encrypted token for a node key of a given kind.
+*/
+func (to *TokenKeyObfuscator) ExternalKey(kind string, key string) string {
+
+	mac := hmac.New(sha256.New, to.macKey)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(key))
+
+	nonce := mac.Sum(nil)[:to.aead.NonceSize()]
+
+	return base64.RawURLEncoding.EncodeToString(
+		to.aead.Seal(nonce, nonce, []byte(key), []byte(kind)))
+}
+
+/*
+InternalKey decrypts a token of a given kind. Returns an error if the token was
+not issued for the given kind or has been tampered with.
+*/
+func (to *TokenKeyObfuscator) InternalKey(kind string, id string) (string, error) {
+
+	token, err := base64.RawURLEncoding.DecodeString(id)
+
+	if err != nil || len(token) < to.aead.NonceSize() {
+		return "", ErrInvalidExternalKey
+	}
+
+	nonce := token[:to.aead.NonceSize()]
+
+	key, err := to.aead.Open(nil, nonce, token[to.aead.NonceSize():], []byte(kind))
+	if err != nil {
+		return "", ErrInvalidExternalKey
+	}
+
+	return string(key), nil
+}
@@ -0,0 +1,86 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"testing"
+)
+
+func TestKeyObfuscation(t *testing.T) {
+
+	// Keys are not translated without a key obfuscator
+
+	if key := ExternalKey("Person", "123"); key != "123" {
+		t.Error("Unexpected result:", key)
+		return
+	}
+
+	if key, err := InternalKey("Person", "123"); key != "123" || err != nil {
+		t.Error("Unexpected result:", key, err)
+		return
+	}
+
+	if _, err := NewTokenKeyObfuscator(""); err == nil ||
+		err.Error() != "Secret for key obfuscation must not be empty" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ko, _ := NewTokenKeyObfuscator("secret")
+
+	KeyObfuscation = ko
+	defer func() {
+		KeyObfuscation = nil
+	}()
+
+	id := ExternalKey("Person", "123")
+
+	if id == "123" || id != ko.ExternalKey("Person", "123") {
+		t.Error("Unexpected result:", id)
+		return
+	}
+
+	if id2 := ExternalKey("Person", "124"); id2 == id {
+		t.Error("Unexpected result:", id2)
+		return
+	}
+
+	if key, err := InternalKey("Person", id); key != "123" || err != nil {
+		t.Error("Unexpected result:", key, err)
+		return
+	}
+
+	// IDs are bound to a kind and cannot be tampered with
+
+	if _, err := InternalKey("Group", id); err != ErrInvalidExternalKey {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := InternalKey("Person", "x"+id[1:]); err != ErrInvalidExternalKey {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := InternalKey("Person", "123"); err != ErrInvalidExternalKey {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Different secrets produce different IDs
+
+	ko2, _ := NewTokenKeyObfuscator("secret2")
+
+	if id2 := ko2.ExternalKey("Person", "123"); id2 == id {
+		t.Error("Unexpected result:", id2)
+		return
+	}
+}
@@ -0,0 +1,366 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/krotik/common/cryptutil"
+	"github.com/krotik/eliasdb/tracing"
+)
+
+/*
+HTTPHeaderRequestID is the header which contains the correlation ID of a request.
+A client may provide its own ID - otherwise a new ID is generated.
+*/
+const HTTPHeaderRequestID = "X-Request-Id"
+
+/*
+maxRequestIDLength is the maximum length of a correlation ID which is provided
+by a client.
+*/
+const maxRequestIDLength = 128
+
+/*
+Log levels of the request logger
+*/
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+)
+
+/*
+Log sinks of the request logger
+*/
+const (
+	LogSinkStdout = "stdout"
+	LogSinkFile   = "file"
+	LogSinkSyslog = "syslog"
+)
+
+/*
+maxLoggedErrorSize is the maximum number of bytes of an error response which
+are written into the log.
+*/
+const maxLoggedErrorSize = 1024
+
+/*
+RequestLog is the structured request logger which should be used by the REST API.
+(Only available if request logging is enabled.)
+*/
+var RequestLog *RequestLogger
+
+/*
+RequestLogger writes structured JSON log entries - one entry per line.
+*/
+type RequestLogger struct {
+	out   io.Writer   // Sink of the logger
+	level int32       // Minimum level of logged entries (can be changed at runtime)
+	mutex *sync.Mutex // Mutex to serialize writes to the sink
+}
+
+/*
+logLevels maps log level names to their severity.
+*/
+var logLevels = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelError: 2,
+}
+
+/*
+NewRequestLogger creates a new request logger which writes to a given sink.
+*/
+func NewRequestLogger(out io.Writer, level string) (*RequestLogger, error) {
+	l, ok := logLevels[strings.ToLower(level)]
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid log level: %v", level)
+	}
+
+	return &RequestLogger{out, int32(l), &sync.Mutex{}}, nil
+}
+
+/*
+SetLevel changes the minimum level of logged entries.
+*/
+func (rl *RequestLogger) SetLevel(level string) error {
+	l, ok := logLevels[strings.ToLower(level)]
+
+	if !ok {
+		return fmt.Errorf("Invalid log level: %v", level)
+	}
+
+	atomic.StoreInt32(&rl.level, int32(l))
+
+	return nil
+}
+
+/*
+NewLogSink creates a writer for a named log sink. The file parameter is only
+used by the file sink.
+*/
+func NewLogSink(sink string, file string) (io.Writer, error) {
+
+	switch strings.ToLower(sink) {
+	case LogSinkStdout:
+		return os.Stdout, nil
+
+	case LogSinkFile:
+		return os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0660)
+
+	case LogSinkSyslog:
+		return newSyslogSink()
+	}
+
+	return nil, fmt.Errorf("Unknown log sink: %v", sink)
+}
+
+/*
+Log writes a log entry with a given level. Entries below the configured level
+are discarded.
+*/
+func (rl *RequestLogger) Log(level string, requestID string, msg string, fields map[string]interface{}) {
+
+	if l, ok := logLevels[level]; !ok || int32(l) < atomic.LoadInt32(&rl.level) {
+		return
+	}
+
+	entry := make(map[string]interface{})
+
+	for k, v := range fields {
+		entry[k] = v
+	}
+
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	if requestID != "" {
+		entry["request_id"] = requestID
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"level": LogLevelError,
+			"msg":   fmt.Sprint("Could not encode log entry: ", err),
+		})
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.out.Write(append(line, '\n'))
+}
+
+/*
+LogDebug writes a debug log entry.
+*/
+func (rl *RequestLogger) LogDebug(requestID string, msg string, fields map[string]interface{}) {
+	rl.Log(LogLevelDebug, requestID, msg, fields)
+}
+
+/*
+LogInfo writes an info log entry.
+*/
+func (rl *RequestLogger) LogInfo(requestID string, msg string, fields map[string]interface{}) {
+	rl.Log(LogLevelInfo, requestID, msg, fields)
+}
+
+/*
+LogError writes an error log entry.
+*/
+func (rl *RequestLogger) LogError(requestID string, msg string, fields map[string]interface{}) {
+	rl.Log(LogLevelError, requestID, msg, fields)
+}
+
+/*
+requestIDKey is the context key for the correlation ID of a request.
+*/
+type requestIDKey struct{}
+
+/*
+RequestID returns the correlation ID of a given request. Returns an empty string
+if the request has no ID.
+*/
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+/*
+withRequestID makes sure a request has a correlation ID. The ID is stored in
+the request context and returned in the response header. A correlation ID of
+the client is replaced by a new ID if it is too long or contains characters
+other than letters, digits and - _ . :
+*/
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := strings.TrimSpace(r.Header.Get(HTTPHeaderRequestID))
+
+	if !isValidRequestID(id) {
+		id = fmt.Sprintf("%x", cryptutil.GenerateUUID())
+	}
+
+	w.Header().Set(HTTPHeaderRequestID, id)
+
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+/*
+isValidRequestID checks if a given correlation ID can be used in logs and headers.
+*/
+func isValidRequestID(id string) bool {
+
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ReportError reports an error of the GraphManager or the EQL interpreter to the
+client. If request logging is enabled the error message contains the
+correlation ID of the request and the error is written into the error log.
+*/
+func ReportError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	msg := err.Error()
+
+	if lw, ok := w.(*loggingResponseWriter); ok {
+		lw.err = err
+
+		if id := RequestID(r); id != "" {
+			msg = fmt.Sprintf("%v (request id: %v)", msg, id)
+		}
+	}
+
+	http.Error(w, msg, status)
+}
+
+/*
+loggingResponseWriter records the status code and error output of a response.
+*/
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int          // Status code of the response
+	errOut bytes.Buffer // Output of an error response
+	err    error        // Reported error of the response
+}
+
+/*
+WriteHeader records the status code of the response.
+*/
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	lw.status = status
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+/*
+Write records the output of error responses.
+*/
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status >= http.StatusBadRequest && lw.errOut.Len() < maxLoggedErrorSize {
+		lw.errOut.Write(b)
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+/*
+Hijack allows websocket endpoints to take over the connection.
+*/
+func (lw *loggingResponseWriter) Hijack() (c net.Conn, rw *bufio.ReadWriter, err error) {
+	if hj, ok := lw.ResponseWriter.(http.Hijacker); ok {
+		lw.status = http.StatusSwitchingProtocols
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Connection cannot be hijacked")
+}
+
+/*
+logRequest runs a request handler and writes access and error log entries
+for it. Errors which were reported to the client (e.g. errors returned by the
+GraphManager) are logged with the correlation ID of the request.
+*/
+func logRequest(w http.ResponseWriter, r *http.Request, handler func(w http.ResponseWriter, r *http.Request)) {
+	logger := RequestLog
+
+	if logger == nil {
+		handler(w, r)
+		return
+	}
+
+	lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	id := RequestID(r)
+	start := time.Now()
+
+	logger.LogDebug(id, "Request received", map[string]interface{}{
+		"method":         r.Method,
+		"path":           r.URL.Path,
+		"query":          r.URL.RawQuery,
+		"content_length": r.ContentLength,
+	})
+
+	handler(lw, r)
+
+	fields := map[string]interface{}{
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"status":      lw.status,
+		"remote":      r.RemoteAddr,
+		"duration_ms": float64(time.Since(start).Nanoseconds()) / 1e6,
+	}
+
+	if span := tracing.FromContext(r.Context()); span != nil {
+		fields["trace_id"] = span.TraceID
+		span.SetAttr("http.status_code", lw.status)
+	}
+
+	logger.LogInfo(id, "Request handled", fields)
+
+	if lw.status >= http.StatusBadRequest {
+		msg := strings.TrimSpace(lw.errOut.String())
+		fields := map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"status": lw.status,
+		}
+
+		if lw.err != nil {
+			msg = lw.err.Error()
+			fields["error_type"] = fmt.Sprintf("%T", lw.err)
+		}
+
+		logger.LogError(id, msg, fields)
+	}
+}
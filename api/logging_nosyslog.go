@@ -0,0 +1,26 @@
+//go:build windows || plan9
+// +build windows plan9
+
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+)
+
+/*
+newSyslogSink returns an error since syslog is not supported on this platform.
+*/
+func newSyslogSink() (io.Writer, error) {
+	return nil, fmt.Errorf("Log sink syslog is not supported on this platform")
+}
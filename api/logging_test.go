@@ -0,0 +1,290 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/tracing"
+)
+
+type testLogEndpoint struct {
+	*DefaultEndpointHandler
+}
+
+func (te *testLogEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	if len(resources) > 0 {
+		ReportError(w, r, &util.GraphError{Type: util.ErrInvalidData, Detail: resources[0]},
+			http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(RequestID(r)))
+}
+
+func (te *testLogEndpoint) SwaggerDefs(s map[string]interface{}) {
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	if _, err := NewRequestLogger(&buf, "foo"); err == nil || err.Error() != "Invalid log level: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	rl, err := NewRequestLogger(&buf, "Info")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rl.LogDebug("123", "debug", nil)
+	rl.LogInfo("123", "info", map[string]interface{}{"status": 200})
+	rl.LogError("", "error", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Error("Unexpected result:", lines)
+		return
+	}
+
+	var entry map[string]interface{}
+
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if entry["level"] != "info" || entry["msg"] != "info" ||
+		entry["request_id"] != "123" || entry["status"] != 200.0 {
+		t.Error("Unexpected result:", entry)
+		return
+	}
+
+	if !strings.Contains(lines[1], `"level":"error"`) || strings.Contains(lines[1], "request_id") {
+		t.Error("Unexpected result:", lines[1])
+		return
+	}
+
+	// The level can be changed at runtime
+
+	if err := rl.SetLevel("foo"); err == nil || err.Error() != "Invalid log level: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	buf.Reset()
+
+	if err := rl.SetLevel("debug"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rl.LogDebug("123", "debug", nil)
+
+	if res := buf.String(); !strings.Contains(res, `"msg":"debug"`) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := NewLogSink("foo", ""); err == nil || err.Error() != "Unknown log sink: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if out, err := NewLogSink("stdout", ""); err != nil || out != os.Stdout {
+		t.Error("Unexpected result:", out, err)
+		return
+	}
+
+	logfile := "testrequest.log"
+	defer os.Remove(logfile)
+
+	out, err := NewLogSink("file", logfile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	out.(*os.File).Close()
+}
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+
+	hs, wg := startServer()
+	if hs == nil {
+		return
+	}
+	defer func() {
+		stopServer(hs, wg)
+	}()
+
+	RequestLog, _ = NewRequestLogger(&buf, "debug")
+	defer func() {
+		RequestLog = nil
+	}()
+
+	queryURL := "http://localhost" + TESTPORT + "/testlog/"
+
+	RegisterRestEndpoints(map[string]RestEndpointInst{
+		"/testlog/": func() RestEndpointHandler {
+			return &testLogEndpoint{}
+		},
+	})
+
+	// Check that a new correlation ID is generated
+
+	res, resp := sendTestRequestResponse(queryURL, "GET", nil)
+
+	if id := resp.Header.Get(HTTPHeaderRequestID); id == "" || id != res {
+		t.Error("Unexpected result:", id, res)
+		return
+	}
+
+	// Check that a given correlation ID is used
+
+	req, _ := http.NewRequest("GET", queryURL+"foo", nil)
+	req.Header.Set(HTTPHeaderRequestID, "myid")
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if id := resp.Header.Get(HTTPHeaderRequestID); id != "myid" {
+		t.Error("Unexpected result:", id)
+		return
+	}
+
+	// The reported error contains the correlation ID
+
+	if res := strings.TrimSpace(string(body)); res != "GraphError: Invalid data (foo) (request id: myid)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 5 {
+		t.Error("Unexpected result:", lines)
+		return
+	}
+
+	var entry map[string]interface{}
+
+	json.Unmarshal([]byte(lines[4]), &entry)
+
+	if entry["level"] != "error" || entry["request_id"] != "myid" ||
+		entry["msg"] != "GraphError: Invalid data (foo)" || entry["status"] != 400.0 ||
+		entry["error_type"] != "*util.GraphError" {
+		t.Error("Unexpected result:", entry)
+		return
+	}
+
+	// Check that invalid correlation IDs are replaced
+
+	for _, invalid := range []string{"my id", "my\"id", "\u00e4", strings.Repeat("a", 129)} {
+		req.Header.Set(HTTPHeaderRequestID, invalid)
+
+		resp, err = (&http.Client{}).Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+
+		if id := resp.Header.Get(HTTPHeaderRequestID); id == invalid || len(id) != 32 {
+			t.Error("Unexpected result:", id)
+			return
+		}
+	}
+
+	req.Header.Set(HTTPHeaderRequestID, strings.Repeat("a", 128))
+
+	if resp, err = (&http.Client{}).Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	if id := resp.Header.Get(HTTPHeaderRequestID); id != strings.Repeat("a", 128) {
+		t.Error("Unexpected result:", id)
+		return
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	var buf bytes.Buffer
+
+	hs, wg := startServer()
+	if hs == nil {
+		return
+	}
+	defer func() {
+		stopServer(hs, wg)
+	}()
+
+	me := tracing.NewMemoryExporter()
+	tracing.SetExporter(me)
+	RequestLog, _ = NewRequestLogger(&buf, "info")
+
+	defer func() {
+		tracing.SetExporter(nil)
+		RequestLog = nil
+	}()
+
+	queryURL := "http://localhost" + TESTPORT + "/testtrace/"
+
+	RegisterRestEndpoints(map[string]RestEndpointInst{
+		"/testtrace/": func() RestEndpointHandler {
+			return &testLogEndpoint{}
+		},
+	})
+
+	req, _ := http.NewRequest("GET", queryURL, nil)
+	req.Header.Set(tracing.HTTPHeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	traceID, spanID, ok := tracing.ParseTraceParent(resp.Header.Get(tracing.HTTPHeaderTraceParent))
+
+	if !ok || traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("Unexpected result:", resp.Header)
+		return
+	}
+
+	spans := me.Spans()
+
+	if len(spans) != 1 || spans[0].SpanID != spanID || spans[0].ParentID != "00f067aa0ba902b7" ||
+		spans[0].Name != "GET /testtrace/" || spans[0].Attrs["http.status_code"] != 200 {
+		t.Error("Unexpected result:", spans)
+		return
+	}
+
+	if !strings.Contains(buf.String(), `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Error("Unexpected result:", buf.String())
+		return
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+ProjectionAll is the wildcard for groups, endpoints and kinds in a projection policy.
+*/
+const ProjectionAll = "*"
+
+/*
+ResponseProjection is the global projection policy which restricts the
+attributes which can be returned by the REST API (nil if there are no
+restrictions).
+*/
+var ResponseProjection *ProjectionPolicy
+
+/*
+RequestGroups returns the groups of the user of a given request. Only rules
+for all groups (*) are applied if this function is not set.
+*/
+var RequestGroups func(r *http.Request) []string
+
+/*
+structuralAttrs are attributes which are always returned since nodes and edges
+cannot be identified without them.
+*/
+var structuralAttrs = map[string]bool{
+	data.NodeKey:               true,
+	data.NodeKind:              true,
+	data.EdgeEnd1Key:           true,
+	data.EdgeEnd1Kind:          true,
+	data.EdgeEnd1Role:          true,
+	data.EdgeEnd1Cascading:     true,
+	data.EdgeEnd1CascadingLast: true,
+	data.EdgeEnd2Key:           true,
+	data.EdgeEnd2Kind:          true,
+	data.EdgeEnd2Role:          true,
+	data.EdgeEnd2Cascading:     true,
+	data.EdgeEnd2CascadingLast: true,
+}
+
+/*
+ProjectionPolicy is an allow-list of attributes which may be returned. Rules are
+organized by group, endpoint (path prefix) and kind. For example:
+
+	{
+	  "public" : {
+	    "/db/v1/graph/" : {
+	      "Person" : [ "name" ]
+	    }
+	  },
+	  "*" : {
+	    "*" : {
+	      "Account" : [ "name", "email" ]
+	    }
+	  }
+	}
+
+A kind is restricted for a request if at least one rule matches the groups of
+the request user, the request path and the kind. The allowed attributes are the
+union of all matching rules. Kinds without a matching rule are not restricted.
+*/
+type ProjectionPolicy struct {
+	Rules map[string]map[string]map[string][]string // Rules of the policy
+}
+
+/*
+NewProjectionPolicy creates a new ProjectionPolicy from a set of rules.
+*/
+func NewProjectionPolicy(rules map[string]map[string]map[string][]string) *ProjectionPolicy {
+	return &ProjectionPolicy{rules}
+}
+
+/*
+LoadProjectionPolicy loads a ProjectionPolicy from a JSON file.
+*/
+func LoadProjectionPolicy(file string) (*ProjectionPolicy, error) {
+	var rules map[string]map[string]map[string][]string
+
+	content, err := ioutil.ReadFile(file)
+
+	if err == nil {
+		err = json.Unmarshal(content, &rules)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewProjectionPolicy(rules), nil
+}
+
+/*
+ForRequest returns the projection which should be applied to the response of a
+given request. Returns nil if no rule applies.
+*/
+func (p *ProjectionPolicy) ForRequest(r *http.Request) *Projection {
+
+	if p == nil {
+		return nil
+	}
+
+	groups := []string{ProjectionAll}
+
+	if RequestGroups != nil {
+		groups = append(groups, RequestGroups(r)...)
+	}
+
+	kinds := make(map[string]map[string]bool)
+
+	for _, group := range groups {
+		for endpoint, kindRules := range p.Rules[group] {
+
+			if endpoint != ProjectionAll && !strings.HasPrefix(r.URL.Path, endpoint) {
+				continue
+			}
+
+			for kind, attrs := range kindRules {
+				allowed, ok := kinds[kind]
+
+				if !ok {
+					allowed = make(map[string]bool)
+					kinds[kind] = allowed
+				}
+
+				for _, attr := range attrs {
+					allowed[attr] = true
+				}
+			}
+		}
+	}
+
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	return &Projection{kinds}
+}
+
+/*
+Projection is the set of attributes which may be returned for a single request.
+All methods can be called on a nil Projection which allows all attributes.
+*/
+type Projection struct {
+	kinds map[string]map[string]bool // Allowed attributes for each kind
+}
+
+/*
+Allowed checks if an attribute of a given kind may be returned.
+*/
+func (pr *Projection) Allowed(kind string, attr string) bool {
+
+	if pr == nil || structuralAttrs[attr] {
+		return true
+	}
+
+	allowed, ok := pr.kinds[kind]
+	allowedAll, okAll := pr.kinds[ProjectionAll]
+
+	if !ok && !okAll {
+		return true
+	}
+
+	return allowed[attr] || allowedAll[attr]
+}
+
+/*
+Data returns the data of a node or edge which only contains allowed attributes.
+The given data is not modified.
+*/
+func (pr *Projection) Data(nodeData map[string]interface{}) map[string]interface{} {
+
+	if pr == nil || nodeData == nil {
+		return nodeData
+	}
+
+	kind, _ := nodeData[data.NodeKind].(string)
+
+	ret := make(map[string]interface{}, len(nodeData))
+
+	for attr, val := range nodeData {
+		if pr.Allowed(kind, attr) {
+			ret[attr] = val
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,132 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestProjectionPolicy(t *testing.T) {
+
+	policyFile := "testprojection.json"
+	defer os.Remove(policyFile)
+
+	if _, err := LoadProjectionPolicy(policyFile); err == nil {
+		t.Error("Loading a non-existing policy should fail")
+		return
+	}
+
+	ioutil.WriteFile(policyFile, []byte(`{
+  "admin" : {
+    "*" : {
+      "Person" : [ "name", "email" ]
+    }
+  },
+  "*" : {
+    "/db/v1/graph/" : {
+      "Person" : [ "name" ]
+    },
+    "*" : {
+      "*" : [ "title" ]
+    }
+  }
+}`), 0660)
+
+	pp, err := LoadProjectionPolicy(policyFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer func() {
+		RequestGroups = nil
+	}()
+
+	// A nil policy and a nil projection do not restrict anything
+
+	var nilPolicy *ProjectionPolicy
+
+	r, _ := http.NewRequest("GET", "/db/v1/graph/main", nil)
+
+	if proj := nilPolicy.ForRequest(r); proj != nil || !proj.Allowed("Person", "secret") {
+		t.Error("Unexpected result:", proj)
+		return
+	}
+
+	person := map[string]interface{}{
+		"key":    "123",
+		"kind":   "Person",
+		"name":   "John",
+		"email":  "john@example.com",
+		"title":  "Dr",
+		"secret": "foo",
+	}
+
+	proj := pp.ForRequest(r)
+
+	if res := fmt.Sprint(proj.Data(person)); res != "map[key:123 kind:Person name:John title:Dr]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Other endpoints only get the wildcard kind rule
+
+	r, _ = http.NewRequest("GET", "/db/v1/find", nil)
+
+	if res := fmt.Sprint(pp.ForRequest(r).Data(person)); res != "map[key:123 kind:Person title:Dr]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Rules of different groups are combined
+
+	RequestGroups = func(r *http.Request) []string {
+		return []string{"admin"}
+	}
+
+	if res := fmt.Sprint(pp.ForRequest(r).Data(person)); res != "map[email:john@example.com key:123 kind:Person name:John title:Dr]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Policies without matching rules do not restrict anything
+
+	pp = NewProjectionPolicy(map[string]map[string]map[string][]string{
+		"admin": {
+			"/db/v1/graph/": {
+				"Person": {"name"},
+			},
+		},
+	})
+
+	RequestGroups = nil
+
+	if proj := pp.ForRequest(r); proj != nil {
+		t.Error("Unexpected result:", proj)
+		return
+	}
+
+	r, _ = http.NewRequest("GET", "/db/v1/graph/main", nil)
+
+	RequestGroups = func(r *http.Request) []string {
+		return []string{"admin"}
+	}
+
+	if proj := pp.ForRequest(r); !proj.Allowed("Song", "secret") || proj.Allowed("Person", "secret") ||
+		!proj.Allowed("Person", "end1key") {
+		t.Error("Unexpected result:", proj)
+		return
+	}
+}
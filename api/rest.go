@@ -19,6 +19,7 @@ import (
 	"github.com/krotik/eliasdb/ecal"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/tracing"
 )
 
 /*
@@ -46,6 +47,8 @@ GeneralEndpointMap contains general endpoints which should always be available
 */
 var GeneralEndpointMap = map[string]RestEndpointInst{
 	EndpointAbout:   AboutEndpointInst,
+	EndpointHealth:  HealthEndpointInst,
+	EndpointReady:   ReadyEndpointInst,
 	EndpointSwagger: SwaggerEndpointInst,
 }
 
@@ -112,6 +115,24 @@ DDLog is a ringbuffer containing cluster related logs.
 */
 var DDLog *datautil.RingBuffer
 
+/*
+ReadOnly is a flag if the REST API should reject changes of the graph data
+(e.g. if this instance is a replica).
+*/
+var ReadOnly = false
+
+/*
+SystemPartition is the partition which stores internal data of the REST API
+(e.g. the query history of users).
+*/
+const SystemPartition = "_system"
+
+/*
+RequestUser returns the name of the user of a given request. All requests
+are treated as requests of an anonymous user if this function is not set.
+*/
+var RequestUser func(r *http.Request) string
+
 /*
 Map of all registered endpoint handlers.
 */
@@ -138,43 +159,95 @@ func RegisterRestEndpoints(endpointInsts map[string]RestEndpointInst) {
 			var handlerInst = endpointInst
 
 			return func(w http.ResponseWriter, r *http.Request) {
+				serveRequest(handlerURL, w, r, func(w http.ResponseWriter, r *http.Request) {
+					handleRequest(handlerURL, handlerInst, w, r)
+				})
+			}
+		}())
+	}
+}
 
-				// Create a new handler instance
+/*
+serveRequest serves a request of an endpoint. The request gets a correlation
+ID, is traced and logged and its response is compressed before the given
+handler function is called.
+*/
+func serveRequest(handlerURL string, w http.ResponseWriter, r *http.Request,
+	handler func(w http.ResponseWriter, r *http.Request)) {
 
-				handler := handlerInst()
+	// Make sure the request has a correlation ID
 
-				// Handle request in appropriate method
+	r = withRequestID(w, r)
 
-				res := strings.TrimSpace(r.URL.Path[len(handlerURL):])
+	// Trace the request - the trace context of the caller is continued
 
-				if len(res) > 0 && res[len(res)-1] == '/' {
-					res = res[:len(res)-1]
-				}
+	ctx := tracing.ContextWithTraceParent(r.Context(),
+		r.Header.Get(tracing.HTTPHeaderTraceParent))
 
-				var resources []string
+	ctx, span := tracing.StartSpan(ctx, r.Method+" "+handlerURL)
 
-				if res != "" {
-					resources = strings.Split(res, "/")
-				}
+	if span != nil {
+		defer span.Finish()
 
-				switch r.Method {
-				case "GET":
-					handler.HandleGET(w, r, resources)
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		span.SetAttr("request_id", RequestID(r))
 
-				case "POST":
-					handler.HandlePOST(w, r, resources)
+		w.Header().Set(tracing.HTTPHeaderTraceParent, span.TraceParent())
 
-				case "PUT":
-					handler.HandlePUT(w, r, resources)
+		r = r.WithContext(ctx)
 
-				case "DELETE":
-					handler.HandleDELETE(w, r, resources)
+		// Storage operations of the request are children of the request span
 
-				default:
-					http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-				}
-			}
-		}())
+		defer tracing.Activate(span)()
+	}
+
+	// Log and handle the request - the response is compressed if
+	// the client accepts one of the response codecs
+
+	compressResponse(w, r, func(w http.ResponseWriter, r *http.Request) {
+		logRequest(w, r, handler)
+	})
+}
+
+/*
+handleRequest dispatches a request to a new instance of an endpoint handler.
+*/
+func handleRequest(handlerURL string, handlerInst RestEndpointInst, w http.ResponseWriter, r *http.Request) {
+
+	// Create a new handler instance
+
+	handler := handlerInst()
+
+	// Handle request in appropriate method
+
+	res := strings.TrimSpace(r.URL.Path[len(handlerURL):])
+
+	if len(res) > 0 && res[len(res)-1] == '/' {
+		res = res[:len(res)-1]
+	}
+
+	var resources []string
+
+	if res != "" {
+		resources = strings.Split(res, "/")
+	}
+
+	switch r.Method {
+	case "GET":
+		handler.HandleGET(w, r, resources)
+
+	case "POST":
+		handler.HandlePOST(w, r, resources)
+
+	case "PUT":
+		handler.HandlePUT(w, r, resources)
+
+	case "DELETE":
+		handler.HandleDELETE(w, r, resources)
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -188,6 +188,37 @@ func TestEndpointHandling(t *testing.T) {
         },
         "summary": "Return information about the REST API provider."
       }
+    },
+    "/healthz": {
+      "get": {
+        "description": "The healthz endpoint returns ok as long as the server can handle requests.",
+        "produces": [
+          "application/json"
+        ],
+        "responses": {
+          "200": {
+            "description": "Status object."
+          }
+        },
+        "summary": "Return the liveness status of the server."
+      }
+    },
+    "/readyz": {
+      "get": {
+        "description": "The readyz endpoint checks the storage, the cluster transfer queue and the cluster quorum (if clustering is enabled).",
+        "produces": [
+          "application/json"
+        ],
+        "responses": {
+          "200": {
+            "description": "Status object with the status of all components."
+          },
+          "503": {
+            "description": "Status object with the status of all components - at least one component failed."
+          }
+        },
+        "summary": "Return the readiness status of the server."
+      }
     }
   },
   "produces": [
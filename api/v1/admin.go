@@ -0,0 +1,326 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+EndpointAdmin is the admin endpoint URL (rooted). Handles everything under admin/...
+*/
+const EndpointAdmin = api.APIRoot + APIv1 + "/admin/"
+
+/*
+AdminEndpointInst creates a new endpoint handler.
+*/
+func AdminEndpointInst() api.RestEndpointHandler {
+	return &adminEndpoint{}
+}
+
+/*
+Handler object for admin operations.
+*/
+type adminEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns administrative information.
+*/
+func (ae *adminEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need an admin operation") {
+		return
+	}
+
+	if resources[0] != "cache" {
+		http.Error(w, "Unknown admin operation: "+resources[0], http.StatusBadRequest)
+		return
+	}
+
+	writeCacheStats(w, api.GM.CacheStats())
+}
+
+/*
+HandlePOST runs an admin operation.
+*/
+func (ae *adminEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need an admin operation") {
+		return
+	}
+
+	switch resources[0] {
+	case "sync":
+		flushAndSync(w, r)
+	case "cache":
+
+		// Resize all caches according to the recommended allocation
+
+		writeCacheStats(w, api.GM.RebalanceCaches())
+
+	case "index":
+		ae.handleIndex(w, r)
+	case "roles":
+		ae.handleRoles(w, r)
+
+	default:
+		http.Error(w, "Unknown admin operation: "+resources[0], http.StatusBadRequest)
+	}
+}
+
+/*
+writeCacheStats writes the cache statistics of all partitions.
+*/
+func writeCacheStats(w http.ResponseWriter, stats []*graph.PartitionCacheStats) {
+
+	if stats == nil {
+		stats = []*graph.PartitionCacheStats{}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+/*
+handleIndex starts a background job which rebuilds or verifies an index.
+*/
+func (ae *adminEndpoint) handleIndex(w http.ResponseWriter, r *http.Request) {
+	var params map[string]interface{}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if verify, _ := params["verify"].(bool); !verify && api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	part, _ := params["partition"].(string)
+	kind, _ := params["kind"].(string)
+
+	if part == "" || kind == "" {
+		http.Error(w, "Need a partition and a kind", http.StatusBadRequest)
+		return
+	}
+
+	id, err := StartJob("reindex", params)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id": id,
+	})
+}
+
+/*
+handleRoles starts a background job which renames an edge role.
+*/
+func (ae *adminEndpoint) handleRoles(w http.ResponseWriter, r *http.Request) {
+	var params map[string]interface{}
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind, _ := params["kind"].(string)
+	from, _ := params["from"].(string)
+	to, _ := params["to"].(string)
+
+	if kind == "" || from == "" || to == "" {
+		http.Error(w, "Need a kind, a from and a to role", http.StatusBadRequest)
+		return
+	}
+
+	id, err := StartJob("renamerole", params)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id": id,
+	})
+}
+
+/*
+flushAndSync waits until all prior commits are durable.
+*/
+func flushAndSync(w http.ResponseWriter, r *http.Request) {
+
+	start := time.Now()
+
+	if err := api.GM.FlushAndSync(); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"synced":   true,
+		"duration": time.Since(start).Nanoseconds() / int64(time.Millisecond),
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ae *adminEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/admin/cache"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return the cache statistics of all partitions.",
+			"description": "The statistics contain the cache hits and misses of each partition " +
+				"since the last rebalance, the current cache allocation and a recommended " +
+				"allocation which is based on the access frequency of each partition.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of partition cache statistics.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Resize all caches according to the recommended allocation.",
+			"description": "The total cache size is distributed between all partitions according " +
+				"to their access frequency. The access counters are reset afterwards.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The statistics which the new allocation is based on.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/admin/index"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Rebuild or verify an index.",
+			"description": "Starts a background reindex job which rebuilds the full-text and lookup " +
+				"index of a node or edge kind from the stored data. If verify is set then the index " +
+				"is only checked and the job result is a report of all discrepancies. The progress " +
+				"can be followed through the jobs endpoint.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "params",
+					"in":          "body",
+					"description": "Object with partition, kind, entity (n or e) and verify.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the ID of the new job.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/admin/roles"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Rename an edge role.",
+			"description": "Starts a background job which renames a role of all edges of an edge " +
+				"kind in all partitions. The old role remains usable in traversal specs as an " +
+				"alias of the new role. The progress can be followed through the jobs endpoint.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "params",
+					"in":          "body",
+					"description": "Object with the edge kind, the old role (from) and the new role (to).",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the ID of the new job.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/admin/sync"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Commit barrier which makes all prior commits durable.",
+			"description": "Waits for all running commits to finish and returns once all " +
+				"prior commits have been written and synced to disk. The response " +
+				"contains the duration of the sync in milliseconds.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "All prior commits are durable.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+}
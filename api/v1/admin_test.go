@@ -0,0 +1,229 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+func TestAdminSync(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointAdmin
+
+	st, _, res := sendTestRequest(queryURL+"sync", "POST", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"synced": true`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "POST", nil)
+
+	if st != "400 Bad Request" || res != "Unknown admin operation: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+
+	if st != "400 Bad Request" || res != "Need an admin operation" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestAdminCache(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointAdmin
+
+	// The test datastore is kept in memory and has no caches
+
+	st, _, res := sendTestRequest(queryURL+"cache", "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"cache", "POST", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown admin operation: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestAdminIndex(t *testing.T) {
+	var jres map[string]interface{}
+
+	queryURL := "http://localhost" + TESTPORT + EndpointAdmin
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM, _ = songGraph()
+
+	// Remove all started jobs afterwards
+
+	defer func() {
+		jobsLock.Lock()
+		jobs = make(map[string]*Job)
+		jobsLock.Unlock()
+	}()
+
+	waitForJob := func(id string) *Job {
+		for i := 0; i < 100; i++ {
+			jobsLock.Lock()
+			job := *jobs[id]
+			jobsLock.Unlock()
+
+			if job.Status != JobRunning {
+				return &job
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	}
+
+	st, _, res := sendTestRequest(queryURL+"index", "POST", []byte(`{"partition": "main", "kind": "Song", "verify": true}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	job := waitForJob(fmt.Sprint(jres["id"]))
+
+	if st != "200 OK" || job == nil || job.Status != JobFinished || job.Done != 9 || job.Total != 9 ||
+		!job.Result.(*graph.IndexReport).Consistent() {
+		t.Error("Unexpected response:", st, res, job)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"index", "POST", []byte(`{"partition": "main", "kind": "Wrote", "entity": "e"}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job = waitForJob(fmt.Sprint(jres["id"])); st != "200 OK" || job == nil ||
+		job.Status != JobFinished || job.Type != "reindex" {
+		t.Error("Unexpected response:", st, res, job)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"index", "POST", []byte(`{"partition": "main"}`))
+
+	if st != "400 Bad Request" || res != "Need a partition and a kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"index", "POST", []byte(`[`))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"index", "POST", []byte(`{"partition": "main", "kind": "Song"}`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	var jres map[string]interface{}
+
+	queryURL := "http://localhost" + TESTPORT + EndpointAdmin
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM, _ = songGraph()
+
+	defer func() {
+		jobsLock.Lock()
+		jobs = make(map[string]*Job)
+		jobsLock.Unlock()
+	}()
+
+	st, _, res := sendTestRequest(queryURL+"roles", "POST", []byte(`{"kind": "Wrote", "from": "Author", "to": "Writer"}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	var job Job
+
+	for i := 0; i < 100; i++ {
+		jobsLock.Lock()
+		job = *jobs[fmt.Sprint(jres["id"])]
+		jobsLock.Unlock()
+
+		if job.Status != JobRunning {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if st != "200 OK" || job.Status != JobFinished || job.Type != "renamerole" ||
+		fmt.Sprint(job.Result) != "map[renamed:9]" {
+		t.Error("Unexpected response:", st, res, job)
+		return
+	}
+
+	if res := api.GM.EdgeRoles("Wrote"); fmt.Sprint(res) != "[Song Writer]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"roles", "POST", []byte(`{"kind": "Wrote"}`))
+
+	if st != "400 Bad Request" || res != "Need a kind, a from and a to role" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"roles", "POST", []byte(`[`))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"roles", "POST", []byte(`{"kind": "Wrote", "from": "Writer", "to": "Author"}`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,694 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+EndpointAlerts is the alerts endpoint URL (rooted). Handles everything under alerts/...
+*/
+const EndpointAlerts = api.APIRoot + APIv1 + "/alerts/"
+
+/*
+alertNodeKind is the node kind which stores alerts in the system partition.
+*/
+const alertNodeKind = "alert"
+
+/*
+Values which can be compared by an alert
+*/
+const (
+	AlertCompareValue         = "value"          // Current value of the metric
+	AlertCompareChange        = "change"         // Change since the last evaluation
+	AlertCompareChangePercent = "change_percent" // Change since the last evaluation in percent
+)
+
+/*
+Alert is a rule on a graph metric or on the number of changes in a partition.
+The rule is evaluated on a cron schedule and a message is posted to a webhook
+when the rule starts to match (alerting) and when it stops to match (resolved).
+*/
+type Alert struct {
+	Name      string  `json:"name"`      // Name of the alert
+	Partition string  `json:"partition"` // Partition which is monitored
+	Metric    string  `json:"metric"`    // Monitored metric
+	Window    string  `json:"window"`    // Time window of change metrics (e.g. 1h)
+	Compare   string  `json:"compare"`   // Compared value (value, change or change_percent)
+	Operator  string  `json:"operator"`  // Comparison operator (>, >=, <, <=)
+	Threshold float64 `json:"threshold"` // Threshold of the comparison
+	Cron      string  `json:"cron"`      // Cron schedule of the evaluation in server local time
+	Target    string  `json:"target"`    // Webhook which receives alert messages
+}
+
+/*
+alertGraphMetrics are the graph metrics which can be monitored by an alert.
+Node and edge counts can be restricted to a kind (e.g. nodes:Person).
+*/
+var alertGraphMetrics = map[string]func(m *graph.PartitionMetrics, kind string) float64{
+	"nodes": func(m *graph.PartitionMetrics, kind string) float64 {
+		if kind != "" {
+			return float64(m.NodeCounts[kind])
+		}
+		return float64(m.Nodes)
+	},
+	"edges": func(m *graph.PartitionMetrics, kind string) float64 {
+		if kind != "" {
+			return float64(m.EdgeCounts[kind])
+		}
+		return float64(m.Edges)
+	},
+	"density": func(m *graph.PartitionMetrics, kind string) float64 {
+		return m.Density
+	},
+	"components": func(m *graph.PartitionMetrics, kind string) float64 {
+		return float64(m.Components)
+	},
+	"largest_component": func(m *graph.PartitionMetrics, kind string) float64 {
+		return float64(m.LargestComponent)
+	},
+	"isolated_nodes": func(m *graph.PartitionMetrics, kind string) float64 {
+		return float64(m.IsolatedNodes)
+	},
+	"max_degree": func(m *graph.PartitionMetrics, kind string) float64 {
+		return float64(m.MaxDegree)
+	},
+}
+
+/*
+alertChangeOps are the operations which can be counted by a change metric.
+*/
+var alertChangeOps = map[string]bool{
+	replication.OpStoreNode:  true,
+	replication.OpDeleteNode: true,
+	replication.OpStoreEdge:  true,
+	replication.OpDeleteEdge: true,
+}
+
+/*
+alertOperators are the supported comparison operators.
+*/
+var alertOperators = map[string]func(v float64, threshold float64) bool{
+	">": func(v float64, threshold float64) bool {
+		return v > threshold
+	},
+	">=": func(v float64, threshold float64) bool {
+		return v >= threshold
+	},
+	"<": func(v float64, threshold float64) bool {
+		return v < threshold
+	},
+	"<=": func(v float64, threshold float64) bool {
+		return v <= threshold
+	},
+}
+
+/*
+validate checks an alert and returns its parsed cron schedule.
+*/
+func (a *Alert) validate() (*cronSpec, error) {
+
+	if a.Partition == "" || a.Metric == "" {
+		return nil, fmt.Errorf("Alert must contain a partition and a metric")
+	}
+
+	metric := strings.Split(a.Metric, ":")
+
+	if metric[0] == "changes" {
+
+		if len(metric) > 3 || (len(metric) > 1 && !alertChangeOps[metric[1]]) {
+			return nil, fmt.Errorf("Unknown metric: %v", a.Metric)
+		}
+
+		if window, err := time.ParseDuration(a.Window); err != nil || window <= 0 {
+			return nil, fmt.Errorf("Change metrics require a time window (e.g. 1h)")
+		}
+
+	} else if _, ok := alertGraphMetrics[metric[0]]; !ok || len(metric) > 2 ||
+		(len(metric) == 2 && metric[0] != "nodes" && metric[0] != "edges") {
+
+		return nil, fmt.Errorf("Unknown metric: %v", a.Metric)
+	}
+
+	if a.Compare == "" {
+		a.Compare = AlertCompareValue
+	} else if a.Compare != AlertCompareValue && a.Compare != AlertCompareChange &&
+		a.Compare != AlertCompareChangePercent {
+		return nil, fmt.Errorf("Unknown comparison: %v", a.Compare)
+	}
+
+	if _, ok := alertOperators[a.Operator]; !ok {
+		return nil, fmt.Errorf("Unknown operator: %v", a.Operator)
+	}
+
+	if !strings.HasPrefix(a.Target, "http://") && !strings.HasPrefix(a.Target, "https://") {
+		return nil, fmt.Errorf("Alert target must be a webhook URL: %v", a.Target)
+	}
+
+	return parseCron(a.Cron)
+}
+
+/*
+value returns the current value of the metric of an alert.
+*/
+func (a *Alert) value() (float64, error) {
+
+	metric := strings.Split(a.Metric, ":")
+
+	if metric[0] == "changes" {
+
+		if ChangeLog == nil {
+			return 0, fmt.Errorf("Change metrics require the change log")
+		}
+
+		window, err := time.ParseDuration(a.Window)
+		if err != nil {
+			return 0, err
+		}
+
+		var count float64
+
+		for _, c := range ChangeLog.ChangesSince(time.Now().Add(-window).UnixNano()) {
+			if c.Part == a.Partition && (len(metric) < 2 || c.Op == metric[1]) &&
+				(len(metric) < 3 || c.Kind == metric[2]) {
+				count++
+			}
+		}
+
+		return count, nil
+	}
+
+	metrics, err := partitionMetrics(a.Partition, false)
+	if err != nil {
+		return 0, err
+	}
+
+	kind := ""
+	if len(metric) > 1 {
+		kind = metric[1]
+	}
+
+	return alertGraphMetrics[metric[0]](metrics.PartitionMetrics, kind), nil
+}
+
+/*
+alertState is the state of an alert after its last evaluation.
+*/
+type alertState struct {
+	evaluated int64   // Time of the last evaluation (0 if the alert was never evaluated)
+	value     float64 // Value of the metric at the last evaluation
+	alerting  bool    // Flag if the alert matched at the last evaluation
+}
+
+/*
+fetchAlert fetches a stored alert and its state. Returns nil if the alert does
+not exist.
+*/
+func fetchAlert(name string) (*Alert, *alertState, error) {
+	var a *Alert
+	var state *alertState
+
+	node, err := api.GM.FetchNode(api.SystemPartition, name, alertNodeKind)
+
+	if err == nil && node != nil {
+		a = &Alert{}
+		state = &alertState{}
+
+		if err = json.Unmarshal([]byte(node.Attr("data").(string)), a); err == nil {
+			state.evaluated, _ = node.Attr("evaluated").(int64)
+			state.value, _ = node.Attr("value").(float64)
+			state.alerting, _ = node.Attr("alerting").(bool)
+		}
+	}
+
+	return a, state, err
+}
+
+/*
+fetchAlerts fetches all stored alerts sorted by name.
+*/
+func fetchAlerts() ([]*Alert, error) {
+	var alerts []*Alert
+
+	it, err := api.GM.NodeKeyIterator(api.SystemPartition, alertNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var a *Alert
+
+			if a, _, err = fetchAlert(key); a != nil {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Name < alerts[j].Name
+	})
+
+	return alerts, err
+}
+
+/*
+alertJob evaluates an alert and posts a message to its webhook if the alert
+starts or stops to match. Comparisons of changes need a previous evaluation -
+the first evaluation only records the value of the metric. The name parameter
+is the name of the alert.
+*/
+func alertJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var value float64
+
+	name, _ := params["name"].(string)
+
+	a, state, err := fetchAlert(name)
+
+	if err == nil && a == nil {
+		err = fmt.Errorf("Unknown alert: %v", name)
+	}
+
+	if err == nil {
+		value, err = a.value()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the compared value
+
+	compared, comparable := value, true
+
+	switch a.Compare {
+	case AlertCompareChange:
+		compared = value - state.value
+		comparable = state.evaluated != 0
+	case AlertCompareChangePercent:
+		if state.value != 0 {
+			compared = (value - state.value) / state.value * 100
+		}
+		comparable = state.evaluated != 0 && state.value != 0
+	}
+
+	alerting := state.alerting
+	notified := false
+
+	if comparable {
+		alerting = alertOperators[a.Operator](compared, a.Threshold)
+
+		if alerting != state.alerting {
+			msg := map[string]interface{}{
+				"alert":     a.Name,
+				"state":     "resolved",
+				"time":      time.Now().Unix(),
+				"partition": a.Partition,
+				"metric":    a.Metric,
+				"compare":   a.Compare,
+				"operator":  a.Operator,
+				"threshold": a.Threshold,
+				"value":     value,
+				"compared":  compared,
+			}
+
+			if alerting {
+				msg["state"] = "alerting"
+			}
+
+			if state.evaluated != 0 {
+				msg["previous_value"] = state.value
+			}
+
+			msgJSON, _ := json.Marshal(msg)
+
+			// The state is not changed if the message could not be delivered
+			// so the delivery is tried again on the next evaluation
+
+			if err = postWebhook(a.Target, msgJSON, "application/json; charset=utf-8"); err != nil {
+				return nil, err
+			}
+
+			notified = true
+		}
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, a.Name)
+	node.SetAttr(data.NodeKind, alertNodeKind)
+	node.SetAttr("evaluated", time.Now().Unix())
+	node.SetAttr("value", value)
+	node.SetAttr("alerting", alerting)
+
+	if err = api.GM.UpdateNode(api.SystemPartition, node); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"alert":    a.Name,
+		"value":    value,
+		"compared": compared,
+		"alerting": alerting,
+		"notified": notified,
+	}, nil
+}
+
+// REST endpoint
+// =============
+
+/*
+AlertsEndpointInst creates a new endpoint handler.
+*/
+func AlertsEndpointInst() api.RestEndpointHandler {
+	return &alertsEndpoint{}
+}
+
+/*
+Handler object for alert operations.
+*/
+type alertsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all alerts or a single alert with its state and the time of
+its next evaluation.
+*/
+func (ae *alertsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var names []string
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 {
+		alerts, err := fetchAlerts()
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		for _, a := range alerts {
+			names = append(names, a.Name)
+		}
+
+	} else {
+		names = []string{resources[0]}
+	}
+
+	ret := make([]map[string]interface{}, 0, len(names))
+
+	for _, name := range names {
+		var next int64
+
+		a, state, err := fetchAlert(name)
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		} else if a == nil {
+			http.Error(w, "Unknown alert: "+name, http.StatusNotFound)
+			return
+		}
+
+		if cron, err := parseCron(a.Cron); err == nil {
+			if t := cron.next(time.Now()); !t.IsZero() {
+				next = t.Unix()
+			}
+		}
+
+		ret = append(ret, map[string]interface{}{
+			"name":      a.Name,
+			"partition": a.Partition,
+			"metric":    a.Metric,
+			"window":    a.Window,
+			"compare":   a.Compare,
+			"operator":  a.Operator,
+			"threshold": a.Threshold,
+			"cron":      a.Cron,
+			"target":    a.Target,
+			"alerting":  state.alerting,
+			"value":     state.value,
+			"evaluated": state.evaluated,
+			"next_run":  next,
+		})
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(ret)
+	} else {
+		json.NewEncoder(w).Encode(ret[0])
+	}
+}
+
+/*
+HandlePUT stores an alert.
+*/
+func (ae *alertsEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	ae.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores an alert. The state of an existing alert is reset.
+*/
+func (ae *alertsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	a := &Alert{}
+
+	if !checkResources(w, resources, 1, 1, "Need an alert name") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.Name = resources[0]
+
+	if _, err := a.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	alertJSON, err := json.Marshal(a)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, a.Name)
+		node.SetAttr(data.NodeKind, alertNodeKind)
+		node.SetAttr("owner", requestUser(r))
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(alertJSON))
+
+		err = api.GM.StoreNode(api.SystemPartition, node)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes an alert.
+*/
+func (ae *alertsEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need an alert name") {
+		return
+	}
+
+	node, err := api.GM.RemoveNode(api.SystemPartition, resources[0], alertNodeKind)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if node == nil {
+		http.Error(w, "Unknown alert: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ae *alertsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the alert.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	alertParams := append(nameParams, map[string]interface{}{
+		"name":        "alert",
+		"in":          "body",
+		"description": "Alert which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Alert",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/alerts"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all alerts.",
+			"description": "All alerts are returned with their state and the time of their next evaluation.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of alerts.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Alert",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/alerts/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return an alert.",
+			"description": "An alert is returned with its state and the time of its next evaluation.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Alert.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Alert",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store an alert.",
+			"description": "The alert is evaluated on a cron schedule (server local time). A JSON message " +
+				"is posted to the webhook of the alert when the alert starts to match (alerting) and " +
+				"when it stops to match (resolved). Each evaluation is a job of the type alert.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": alertParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The alert was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove an alert.",
+			"description": "The alert is removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The alert was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Alert"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"partition": map[string]interface{}{
+				"description": "Partition which is monitored.",
+				"type":        "string",
+			},
+			"metric": map[string]interface{}{
+				"description": "Monitored metric - a graph metric (nodes[:<kind>], edges[:<kind>], density, " +
+					"components, largest_component, isolated_nodes or max_degree) or the number of " +
+					"changes in a time window (changes[:<operation>[:<kind>]]).",
+				"type": "string",
+			},
+			"window": map[string]interface{}{
+				"description": "Time window of change metrics (e.g. 1h).",
+				"type":        "string",
+			},
+			"compare": map[string]interface{}{
+				"description": "Compared value - the value of the metric (value), its change since the " +
+					"last evaluation (change) or its change in percent (change_percent).",
+				"type": "string",
+			},
+			"operator": map[string]interface{}{
+				"description": "Comparison operator (>, >=, < or <=).",
+				"type":        "string",
+			},
+			"threshold": map[string]interface{}{
+				"description": "Threshold of the comparison.",
+				"type":        "number",
+			},
+			"cron": map[string]interface{}{
+				"description": "Cron schedule of the evaluation with the fields minute, hour, day of month, month and day of week.",
+				"type":        "string",
+			},
+			"target": map[string]interface{}{
+				"description": "Webhook which receives alert messages.",
+				"type":        "string",
+			},
+			"alerting": map[string]interface{}{
+				"description": "Flag if the alert matched at the last evaluation (only returned).",
+				"type":        "boolean",
+			},
+			"value": map[string]interface{}{
+				"description": "Value of the metric at the last evaluation (only returned).",
+				"type":        "number",
+			},
+			"evaluated": map[string]interface{}{
+				"description": "Time of the last evaluation (only returned).",
+				"type":        "integer",
+			},
+			"next_run": map[string]interface{}{
+				"description": "Time of the next evaluation (only returned).",
+				"type":        "integer",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,298 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/replication"
+)
+
+func TestAlerts(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointAlerts
+	jobsURL := "http://localhost" + TESTPORT + EndpointJobs
+
+	oldGM := api.GM
+	oldChangeLog := ChangeLog
+	oldMaxAge := GraphMetricsMaxAge
+	defer func() {
+		api.GM = oldGM
+		ChangeLog = oldChangeLog
+		GraphMetricsMaxAge = oldMaxAge
+
+		// Remove the jobs of this test
+
+		jobsLock.Lock()
+		for id, job := range jobs {
+			if job.Type == "alert" {
+				delete(jobs, id)
+			}
+		}
+		jobsLock.Unlock()
+	}()
+
+	api.GM, _ = songGraph()
+	ChangeLog = nil
+	GraphMetricsMaxAge = 0
+
+	var webhook []map[string]interface{}
+	var webhookLock sync.Mutex
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]interface{}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &msg)
+
+		webhookLock.Lock()
+		webhook = append(webhook, msg)
+		webhookLock.Unlock()
+	}))
+	defer hook.Close()
+
+	// Messages are delivered by the alert jobs in the background
+
+	delivered := func() []map[string]interface{} {
+		webhookLock.Lock()
+		defer webhookLock.Unlock()
+
+		return append([]map[string]interface{}(nil), webhook...)
+	}
+
+	runAlert := func(name string) map[string]interface{} {
+		var job map[string]interface{}
+
+		_, _, res := sendTestRequest(jobsURL+"alert", "POST", []byte(`{"name": "`+name+`"}`))
+		json.Unmarshal([]byte(res), &job)
+
+		id, _ := job["id"].(string)
+
+		for i := 0; i < 100; i++ {
+			_, _, res := sendTestRequest(jobsURL+id, "GET", nil)
+			json.Unmarshal([]byte(res), &job)
+
+			if job["status"] != JobRunning {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return job
+	}
+
+	// Invalid alerts are rejected
+
+	for body, msg := range map[string]string{
+		`{"partition": "main", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`:                                               "Alert must contain a partition and a metric",
+		`{"partition": "main", "metric": "foo", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`:                              "Unknown metric: foo",
+		`{"partition": "main", "metric": "density:Author", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`:                   "Unknown metric: density:Author",
+		`{"partition": "main", "metric": "changes:node.foo", "window": "1h", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`: "Unknown metric: changes:node.foo",
+		`{"partition": "main", "metric": "changes", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`:                          "Change metrics require a time window (e.g. 1h)",
+		`{"partition": "main", "metric": "nodes", "compare": "foo", "cron": "@hourly", "operator": ">", "target": "` + hook.URL + `"}`:          "Unknown comparison: foo",
+		`{"partition": "main", "metric": "nodes", "cron": "@hourly", "operator": "!=", "target": "` + hook.URL + `"}`:                           "Unknown operator: !=",
+		`{"partition": "main", "metric": "nodes", "cron": "@hourly", "operator": ">", "target": "file:foo"}`:                                    "Alert target must be a webhook URL: file:foo",
+		`{"partition": "main", "metric": "nodes", "cron": "* *", "operator": ">", "target": "` + hook.URL + `"}`:                                "Cron schedule must have 5 fields: * *",
+	} {
+		st, _, res := sendTestRequest(queryURL+"test", "POST", []byte(body))
+
+		if st != "400 Bad Request" || res != msg {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	st, _, res := sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Need an alert name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Store alerts
+
+	for name, body := range map[string]string{
+		"authors":  `{"partition": "main", "metric": "nodes:Author", "compare": "change_percent", "operator": "<", "threshold": -10, "cron": "0 * * * *", "target": "` + hook.URL + `"}`,
+		"deletes":  `{"partition": "main", "metric": "changes:node.delete:Author", "window": "1h", "operator": ">=", "threshold": 1, "cron": "0 * * * *", "target": "` + hook.URL + `"}`,
+		"isolated": `{"partition": "main", "metric": "isolated_nodes", "compare": "change", "operator": ">", "threshold": 0, "cron": "30 * * * *", "target": "` + hook.URL + `"}`,
+	} {
+		if st, _, res := sendTestRequest(queryURL+name, "POST", []byte(body)); st != "200 OK" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	var alerts []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &alerts)
+
+	if st != "200 OK" || len(alerts) != 3 || alerts[0]["name"] != "authors" ||
+		alerts[1]["compare"] != "value" || alerts[0]["alerting"] != false ||
+		alerts[0]["next_run"].(float64) <= float64(time.Now().Unix()) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"unknown", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown alert: unknown" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Change metrics need the change log
+
+	if job := runAlert("deletes"); job["status"] != JobFailed || job["error"] != "Change metrics require the change log" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	ChangeLog = replication.NewChangeLog(100)
+	api.GM.SetGraphRule(ChangeLog)
+
+	// The first evaluation of a change only records the value of the metric
+
+	if job := runAlert("authors"); job["status"] != JobFinished ||
+		job["result"].(map[string]interface{})["value"] != float64(3) ||
+		job["result"].(map[string]interface{})["notified"] != false {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	if _, err := api.GM.RemoveNode("main", "000", "Author"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Evaluate all alerts which are due through the scheduler
+
+	sched := NewScheduler()
+	start := time.Date(2021, time.March, 8, 5, 59, 0, 0, time.Local)
+
+	if ids, err := sched.check(start); err != nil || len(ids) != 0 {
+		t.Error("Unexpected result:", ids, err)
+		return
+	}
+
+	ids, err := sched.check(start.Add(time.Minute))
+
+	if err != nil || len(ids) != 2 {
+		t.Error("Unexpected result:", ids, err)
+		return
+	}
+
+	for i := 0; i < 100 && len(delivered()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if msgs := delivered(); len(msgs) != 2 {
+		t.Error("Unexpected webhook delivery:", msgs)
+		return
+	}
+
+	for _, msg := range delivered() {
+		if msg["alert"] == "authors" && (msg["state"] != "alerting" || msg["value"] != float64(2) ||
+			msg["previous_value"] != float64(3) || int(msg["compared"].(float64)) != -33) {
+			t.Error("Unexpected webhook delivery:", msg)
+			return
+		} else if msg["alert"] == "deletes" && (msg["state"] != "alerting" || msg["value"] != float64(1)) {
+			t.Error("Unexpected webhook delivery:", msg)
+			return
+		}
+	}
+
+	var alert map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL+"authors", "GET", nil)
+	json.Unmarshal([]byte(res), &alert)
+
+	if st != "200 OK" || alert["alerting"] != true || alert["value"] != float64(2) ||
+		alert["evaluated"].(float64) < float64(time.Now().Unix()-60) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// A message is posted when the alert is resolved
+
+	webhookLock.Lock()
+	webhook = nil
+	webhookLock.Unlock()
+
+	if job, msgs := runAlert("authors"), delivered(); job["status"] != JobFinished ||
+		job["result"].(map[string]interface{})["alerting"] != false ||
+		len(msgs) != 1 || msgs[0]["state"] != "resolved" {
+		t.Error("Unexpected result:", job, msgs)
+		return
+	}
+
+	// Alerts which do not change their state send no message
+
+	if job, msgs := runAlert("deletes"), delivered(); job["status"] != JobFinished ||
+		job["result"].(map[string]interface{})["alerting"] != true || len(msgs) != 1 {
+		t.Error("Unexpected result:", job, msgs)
+		return
+	}
+
+	// The state is not changed if a message cannot be delivered
+
+	if job := runAlert("isolated"); job["status"] != JobFinished {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "alerts1")
+	node.SetAttr("kind", "Author")
+	api.GM.StoreNode("main", node)
+
+	hook.Close()
+
+	if job := runAlert("isolated"); job["status"] != JobFailed {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"isolated", "GET", nil)
+	json.Unmarshal([]byte(res), &alert)
+
+	if st != "200 OK" || alert["alerting"] != false {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if job := runAlert("unknown"); job["status"] != JobFailed || job["error"] != "Unknown alert: unknown" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	// Remove alerts
+
+	if st, _, res = sendTestRequest(queryURL+"authors", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"authors", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown alert: authors" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
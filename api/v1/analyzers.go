@@ -0,0 +1,225 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+EndpointAnalyzers is the analyzers endpoint URL (rooted). Handles everything under analyzers/...
+*/
+const EndpointAnalyzers = api.APIRoot + APIv1 + "/analyzers/"
+
+/*
+AnalyzersEndpointInst creates a new endpoint handler.
+*/
+func AnalyzersEndpointInst() api.RestEndpointHandler {
+	return &analyzersEndpoint{}
+}
+
+/*
+Handler object for text analyzer operations.
+*/
+type analyzersEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all text analyzers, the analyzers of a kind or a single analyzer.
+*/
+func (ae *analyzersEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 2, "") {
+		return
+	}
+
+	analyzers := api.GM.Analyzers()
+
+	switch len(resources) {
+	case 0:
+		ret = map[string]interface{}{
+			"analyzers": analyzers,
+			"languages": util.AnalyzerLanguages(),
+		}
+	case 1:
+		kindAnalyzers, ok := analyzers[resources[0]]
+		if !ok {
+			kindAnalyzers = map[string]*util.Analyzer{}
+		}
+		ret = kindAnalyzers
+	default:
+		analyzer, ok := analyzers[resources[0]][resources[1]]
+		if !ok {
+			http.Error(w, "Unknown analyzer: "+resources[0]+"/"+resources[1], http.StatusNotFound)
+			return
+		}
+		ret = analyzer
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePUT sets the text analyzer of an attribute.
+*/
+func (ae *analyzersEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	var analyzer util.Analyzer
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 2, "Need a kind and an attribute") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&analyzer); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.GM.SetAnalyzer(resources[0], resources[1], &analyzer); err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+	}
+}
+
+/*
+HandleDELETE removes the text analyzer of an attribute.
+*/
+func (ae *analyzersEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 2, "Need a kind and an attribute") {
+		return
+	}
+
+	if err := api.GM.SetAnalyzer(resources[0], resources[1], nil); err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ae *analyzersEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	pathParams := []map[string]interface{}{
+		{
+			"name":        "kind",
+			"in":          "path",
+			"description": "Node or edge kind.",
+			"required":    true,
+			"type":        "string",
+		},
+		{
+			"name":        "attr",
+			"in":          "path",
+			"description": "Attribute which is analyzed.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/analyzers"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all text analyzers.",
+			"description": "Returns all text analyzers of the full-text index and all supported languages.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A map of kind to a map of attribute to analyzer.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/analyzers/{kind}/{attr}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a text analyzer.",
+			"description": "Returns the text analyzer of an attribute.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": pathParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The analyzer object.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"put": map[string]interface{}{
+			"summary": "Set a text analyzer.",
+			"description": "Sets the text analyzer of an attribute. Existing data needs " +
+				"to be reindexed (e.g. with a reindex job) for the change to take effect.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": append(pathParams, map[string]interface{}{
+				"name":        "analyzer",
+				"in":          "body",
+				"description": "Analyzer object with tokenizer, lowercase, language, stemming, languagestopwords, stopwords, mingram and maxgram.",
+				"required":    true,
+				"schema": map[string]interface{}{
+					"type": "object",
+				},
+			}),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The analyzer was set.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a text analyzer.",
+			"description": "Removes the text analyzer of an attribute.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": pathParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The analyzer was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestAnalyzers(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointAnalyzers
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != `{
+  "analyzers": {},
+  "languages": [
+    "english",
+    "french",
+    "german",
+    "spanish"
+  ]
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "PUT", []byte("{"))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "PUT", []byte(`{"tokenizer": "foo"}`))
+
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Unknown tokenizer: foo)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author", "PUT", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Need a kind and an attribute" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "PUT",
+		[]byte(`{"lowercase": true, "language": "english", "stemming": true}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author", "GET", nil)
+
+	if st != "200 OK" || res != `{
+  "desc": {
+    "lowercase": true,
+    "language": "english",
+    "stemming": true
+  }
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song/name", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown analyzer: Song/name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Existing data needs to be reindexed
+
+	iq, _ := api.GM.NodeIndexQuery("main", "Author")
+
+	if res, _ := iq.LookupWord("desc", "artist"); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := reindexJob(map[string]interface{}{"partition": "main"}, nil); err == nil ||
+		err.Error() != "Need a partition and a kind" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := reindexJob(map[string]interface{}{"partition": "main", "kind": "Author", "entity": "x"}, nil); err == nil ||
+		err.Error() != "Entity type must be n (nodes) or e (edges)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := reindexJob(map[string]interface{}{"partition": "main", "kind": "Wrote", "entity": "e"}, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := reindexJob(map[string]interface{}{"partition": "main", "kind": "Author"}, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	iq, _ = api.GM.NodeIndexQuery("main", "Author")
+
+	if res, _ := iq.LookupWord("desc", "Artist"); fmt.Sprint(res) != "map[000:[7 17]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Remove the analyzer again
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if res := fmt.Sprint(api.GM.Analyzers()); res != "map[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "PUT", []byte(`{}`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author/desc", "DELETE", nil)
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"net/http"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/arrow"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointArrow is the Arrow endpoint URL (rooted). Handles everything under arrow/...
+*/
+const EndpointArrow = api.APIRoot + APIv1 + "/arrow/"
+
+/*
+ArrowBatchSize is the number of rows in each record batch of an Arrow stream.
+The column types of a node kind stream are inferred from its first batch.
+*/
+var ArrowBatchSize = 1024
+
+/*
+ArrowEndpointInst creates a new endpoint handler.
+*/
+func ArrowEndpointInst() api.RestEndpointHandler {
+	return &arrowEndpoint{}
+}
+
+/*
+Handler object for Arrow streams.
+*/
+type arrowEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles an Arrow stream REST call.
+*/
+func (ae *arrowEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 3, "Need a partition") {
+		return
+	}
+
+	if len(resources) == 1 {
+		ae.streamQuery(w, r, resources[0])
+	} else if len(resources) == 3 && resources[1] == "n" {
+		ae.streamNodes(w, r, resources[0], resources[2])
+	} else {
+		http.Error(w, "Need a partition, entity type (n) and a kind", http.StatusBadRequest)
+	}
+}
+
+/*
+streamNodes streams all nodes of a kind. The columns are the key, the kind and
+all attributes of the kind.
+*/
+func (ae *arrowEndpoint) streamNodes(w http.ResponseWriter, r *http.Request, part string, kind string) {
+
+	it, err := api.GM.NodeKeyIteratorContext(r.Context(), part, kind)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if it == nil {
+		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
+		return
+	}
+
+	proj := api.ResponseProjection.ForRequest(r)
+
+	names := []string{data.NodeKey, data.NodeKind}
+
+	for _, attr := range api.GM.NodeAttrs(kind) {
+		if attr != data.NodeKey && attr != data.NodeKind && proj.Allowed(kind, attr) {
+			names = append(names, attr)
+		}
+	}
+
+	ae.writeStream(w, r, names, nil, func() ([]interface{}, bool, error) {
+
+		for it.HasNext() {
+			key := it.Next()
+
+			if it.LastError != nil {
+				return nil, false, it.LastError
+			}
+
+			node, err := api.GM.FetchNode(part, key, kind)
+			if err != nil {
+				return nil, false, err
+			} else if node == nil {
+				continue
+			}
+
+			nodeData := externalData(proj.Data(node.Data()))
+			row := make([]interface{}, len(names))
+
+			for i, name := range names {
+				row[i] = nodeData[name]
+			}
+
+			return row, true, nil
+		}
+
+		return nil, false, nil
+	})
+}
+
+/*
+streamQuery streams the result of a query. The columns are named after the
+data specs of the result columns (e.g. 1:n:name).
+*/
+func (ae *arrowEndpoint) streamQuery(w http.ResponseWriter, r *http.Request, part string) {
+	var err error
+
+	query := r.URL.Query().Get("q")
+
+	if query == "" {
+		http.Error(w, "Missing query (q parameter)", http.StatusBadRequest)
+		return
+	}
+
+	// Translate the external IDs of a lookup query
+
+	if query, err = internalQuery(query); err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	res, err := eql.RunQueryWithOptions(r.Context(), stringutil.CreateDisplayString(part)+" query",
+		part, query, api.GM, QueryOptions)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	header := res.Header()
+	rows := res.Rows()
+	srcs := res.RowSources()
+
+	// Remove values which may not be returned and translate keys
+
+	if proj := api.ResponseProjection.ForRequest(r); proj != nil {
+		rows = projectRows(proj, header.Data(), rows, srcs)
+	}
+
+	if api.KeyObfuscation != nil {
+		rows, _ = externalRows(header.Data(), rows, srcs)
+	}
+
+	// The whole result is known so the column types can be inferred from all rows
+
+	fields := arrow.InferFields(header.Data(), rows)
+
+	ae.writeStream(w, r, header.Data(), fields, func() ([]interface{}, bool, error) {
+		if len(rows) == 0 {
+			return nil, false, nil
+		}
+
+		row := rows[0]
+		rows = rows[1:]
+
+		return row, true, nil
+	})
+}
+
+/*
+writeStream writes an Arrow stream with rows from a given row source. If no
+fields are given then the column types are inferred from the first batch. Errors
+which occur after the stream was started end the stream without an
+end-of-stream marker.
+*/
+func (ae *arrowEndpoint) writeStream(w http.ResponseWriter, r *http.Request, names []string,
+	fields []arrow.Field, next func() ([]interface{}, bool, error)) {
+
+	var sw *arrow.StreamWriter
+
+	batch := make([][]interface{}, 0, ArrowBatchSize)
+
+	writeBatch := func() error {
+		var err error
+
+		if sw == nil {
+			if fields == nil {
+				fields = arrow.InferFields(names, batch)
+			}
+
+			w.Header().Set("content-type", arrow.ContentType)
+
+			if sw, err = arrow.NewStreamWriter(w, fields); err != nil {
+				return err
+			}
+		}
+
+		err = sw.WriteBatch(batch)
+		batch = batch[:0]
+
+		return err
+	}
+
+	for {
+		row, ok, err := next()
+
+		if err == nil {
+
+			if ok {
+				if batch = append(batch, row); len(batch) < ArrowBatchSize {
+					continue
+				}
+			}
+
+			// Write a full batch or the last batch - a stream without rows
+			// still has a schema
+
+			if ok || len(batch) > 0 || sw == nil {
+				err = writeBatch()
+			}
+		}
+
+		if err != nil {
+			if sw == nil {
+				api.ReportError(w, r, err, http.StatusInternalServerError)
+			}
+			return
+
+		} else if !ok {
+			sw.Close()
+			return
+		}
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ae *arrowEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/arrow/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Run a search query and return the result as Arrow IPC stream.",
+			"description": "The columns of the stream are named after the data specs of the result columns.",
+			"produces": []string{
+				"text/plain",
+				arrow.ContentType,
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "q",
+					"in":          "query",
+					"description": "URL encoded query to execute.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An Arrow IPC stream.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/arrow/{partition}/n/{kind}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all nodes of a kind as Arrow IPC stream.",
+			"description": "The columns of the stream are the key, the kind and all attributes of the node kind.",
+			"produces": []string{
+				"text/plain",
+				arrow.ContentType,
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to select.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node kind.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An Arrow IPC stream.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/arrow"
+)
+
+func TestArrowStream(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointArrow
+
+	oldBatchSize := ArrowBatchSize
+	ArrowBatchSize = 4
+	defer func() {
+		ArrowBatchSize = oldBatchSize
+	}()
+
+	checkStream := func(res string, batches int, names ...string) bool {
+		t.Helper()
+
+		if !strings.HasPrefix(res, "\xff\xff\xff\xff") || !strings.HasSuffix(res, "\xff\xff\xff\xff\x00\x00\x00\x00") ||
+			strings.Count(res, "\xff\xff\xff\xff") != batches+2 {
+			t.Error("Unexpected stream:", res)
+			return false
+		}
+
+		for _, name := range names {
+			if !strings.Contains(res, name+"\x00") {
+				t.Error("Unexpected stream:", name, res)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	st, header, res := sendTestRequest(queryURL+"main/n/Song", "GET", nil)
+
+	if st != "200 OK" || header.Get("Content-Type") != arrow.ContentType ||
+		!checkStream(res, 3, "key", "kind", "name", "ranking") {
+		t.Error("Unexpected response:", st, header)
+		return
+	}
+
+	st, header, res = sendTestRequest(queryURL+"main?q="+url.QueryEscape("get Song where ranking < 5"), "GET", nil)
+
+	if st != "200 OK" || header.Get("Content-Type") != arrow.ContentType ||
+		!checkStream(res, 1, "1:n:key", "1:n:name", "1:n:ranking") {
+		t.Error("Unexpected response:", st, header)
+		return
+	}
+
+	// A result without rows still has a schema
+
+	st, _, res = sendTestRequest(queryURL+"main?q="+url.QueryEscape("get Song where ranking < 0"), "GET", nil)
+
+	if st != "200 OK" || !checkStream(res, 1, "1:n:key") {
+		t.Error("Unexpected response:", st)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Missing query (q parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/e/Song", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a partition, entity type (n) and a kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -11,6 +11,24 @@
 /*
 Package v1 contains EliasDB REST API Version 1.
 
+Arrow stream endpoint
+
+/arrow/<partition>/n/<node kind>
+
+Returns all nodes of a kind as Apache Arrow IPC stream (content type
+application/vnd.apache.arrow.stream) which can be read by analytical clients
+(e.g. with pyarrow.ipc.open_stream). The columns are the key, the kind and all
+attributes of the node kind. The column types (utf8, binary, int64, float64,
+bool or timestamp) are inferred from the first record batch - values of later
+batches which cannot be represented in the type of their column are null.
+
+/arrow/<partition>?q=<query>
+
+Runs a search query and returns the result as Arrow IPC stream. The columns are
+named after the data specs of the result columns (e.g. 1:n:name) and their
+types are inferred from all rows of the result.
+
+
 Binary Blob control endpoint
 
 /blob
@@ -31,6 +49,31 @@ GET requests can be used to retrieve a binary blobs with a specific ID. Binary b
 can be updated by sending a PUT request and removed by sending a DELETE request.
 
 
+Change log endpoint
+
+/changes
+
+The changes endpoint returns the recorded changes of the datastore (only available
+if the change log is enabled). It is used by replicas to follow a primary. The
+optional query parameters since and limit select the sequence number of the last
+known change and the maximum number of returned changes. A GET request returns:
+
+	{
+		id        : <ID of the change log>,
+		last_seq  : <Sequence number of the last change>,
+		last_time : <Time of the last change>,
+		changes   : [ { seq : <Sequence number>, op : <Operation>, part : <Partition>,
+		                key : <Key>, kind : <Kind>, data : <New state> }, ... ]
+	}
+
+Returns 409 Conflict if the requested changes are no longer in the change log.
+
+/changes/snapshot
+
+Returns all partitions of the datastore and the sequence number of the last
+change before the snapshot was taken.
+
+
 Cluster control endpoint
 
 /cluster
@@ -90,6 +133,34 @@ Returns the latest cluster related log messages. A DELETE call will clear
 the current log.
 
 
+Console endpoint
+
+/console/<partition>
+
+The console endpoint is a websocket endpoint which speaks a small JSON command
+protocol for interactive clients. Each command is an object with an optional
+id (which is returned with all responses to the command) and a cmd field:
+
+	{ id : <id>, cmd : "query", query : <EQL query>, partition : <partition>, limit : <page size> }
+	{ id : <id>, cmd : "page", result_id : <result id>, offset : <offset>, limit : <page size> }
+	{ id : <id>, cmd : "describe", kind : <optional node kind> }
+	{ id : <id>, cmd : "close" }
+
+The server responds with messages of the following structure:
+
+	{
+		id      : <id of the command>,
+		type    : <ready, progress, result, error or closed>,
+		payload : <result data, progress info or error message>
+	}
+
+Progress messages are pushed while a long running query is executed. Query
+results are stored in the same result cache as the results of the query endpoint.
+
+The command line console and the web terminal both use this endpoint to run
+queries and to describe the datastore.
+
+
 EQL parser endpoint
 
 /eql
@@ -161,6 +232,17 @@ A list of edges:
 
 	[ { <attr> : <value> }, ... ]
 
+The response contains the number of stored (or deleted) nodes and edges and
+their keys in the order of the request. Nodes and edges which are stored
+without a key get a generated key if key generation is enabled (see the
+KeyGeneration option of the server):
+
+	{
+		nodes_stored : <number of nodes>,
+		edges_stored : <number of edges>,
+		keys         : [ <key>, ... ]
+	}
+
 GET requests can be used to query single or a series of nodes. The endpoints
 support the limit and offset parameters for lists:
 
@@ -170,6 +252,17 @@ support the limit and offset parameters for lists:
 The total number of entries is returned in the X-Total-Count header when
 a list is returned.
 
+Node lists are paged over the live data by default - nodes which are stored
+or removed between requests can shift the pages. The optional snapshot
+parameter pages over a stable snapshot of the node keys instead:
+
+	snapshot=true - Take a new snapshot; its ID is returned in the
+	                X-Snapshot-Id header
+	snapshot=<id> - Return a page of an existing snapshot
+
+Snapshots expire after a while. Nodes which were removed after the snapshot
+was taken are left out of the returned pages.
+
 /graph/<partition>/n/<node kind>/[node key]/[traversal spec]
 
 /graph/<partition>/e/<edge kind>/<edge key>
@@ -197,6 +290,54 @@ header is not set.
 	    [ <traversed nodes> ], [ <traversed edges> ]
 	]
 
+Datetime, decimal and binary attribute values are sent and returned as tagged
+values which keep their type:
+
+	{ "$type" : "datetime", "value" : <date in RFC 3339 format> }
+	{ "$type" : "decimal", "value" : <decimal notation, e.g. "12.34"> }
+	{ "$type" : "binary", "value" : <base64 encoded bytes> }
+
+Large binary values (e.g. images or documents) can be stored as blobs in node
+attributes:
+
+/graph/<partition>/n/<node kind>/<node key>/blob/<attr>
+
+A PUT or POST request stores the request body as blob in the given attribute
+of an existing node. The content type of the request is kept with the blob. The
+node attribute only holds a reference to the blob:
+
+	{
+	    size         : <size of the blob in bytes>,
+	    content_type : <content type of the blob>
+	}
+
+A GET request returns the blob data. Range requests are supported. A DELETE
+request removes the blob and the attribute. Blobs are also removed if the
+attribute is overwritten or the node is deleted.
+
+
+Import preview endpoint
+
+/importpreview?format=<csv or ndjson>&kind=<default kind>
+
+The import preview endpoint inspects a sample of CSV or NDJSON data which is sent
+with a POST request. CSV data must have a header row with the attribute names.
+If no format is given it is detected from the content type. Records without a
+kind attribute get the given default kind. The response has the following structure:
+
+	{
+		format : <Format of the sample data>,
+		rows   : <Number of inspected rows>,
+		kinds  : [ { kind : <Kind>, count : <Number of records>,
+		             attrs : [ { name : <Attribute name>, type : <Inferred type>,
+		                         nullable : <Flag if the attribute is missing in some records>,
+		                         unique : <Flag if all values are unique>,
+		                         samples : <Sample values> }, ... ],
+		             key_candidates : <Attributes which could be used as node key> }, ... ],
+		edges  : [ { from_kind : <Kind>, attr : <Attribute>, to_kind : <Kind>,
+		             to_attr : <Key attribute>, matches : <Number of matching values> }, ... ]
+	}
+
 
 Index query endpoint
 
@@ -218,6 +359,25 @@ The return data is a list of node keys:
 
 	[ <node key1>, <node key2>, ... ]
 
+Phrase query results can be ranked by relevance by adding score=true:
+
+/index/<partition>/n/<node kind>?phrase=<phrase>&attr=<attribute>&score=true
+
+The return data is then a list of matches ordered by their BM25 score (best
+match first):
+
+	[
+		{
+			key   : <node key>,
+			score : <BM25 score of the match>,
+			count : <number of occurrences of the phrase>
+		},
+		...
+	]
+
+Values which were indexed by an older version are scored as if they had an
+average length until their node/edge has been stored again.
+
 A word query finds all nodes/edges where an attribute contains a certain word.
 A request url which runs a new word search should be of the following form:
 
@@ -232,6 +392,33 @@ The return data is a map which maps node key to a list of word positions:
 	    ...
 	}
 
+A prefix query finds all nodes/edges where an attribute contains a word which
+starts with a given prefix. A fuzzy word query finds all nodes/edges where an
+attribute contains a word which is similar to a given word (the fuzzy parameter
+is the maximum edit distance between 0 and 3). A request url which runs a new
+prefix or fuzzy word search should be of the following form:
+
+/index/<partition>/n/<node kind>?prefix=<prefix>&attr=<attribute>
+
+/index/<partition>/n/<node kind>?word=<word>&fuzzy=<distance>&attr=<attribute>
+
+The return data is a list of matches ranked by distance (the edit distance or
+the number of characters which complete the prefix) and number of occurrences:
+
+	[
+		{
+			key      : <node key>,
+			word     : <closest matching word>,
+			distance : <distance of the matching word>,
+			count    : <number of occurrences of matching words>
+		},
+		...
+	]
+
+Indexes which were created by an older version have no word dictionaries. They
+are rebuilt in the background by the upgradeindex job when the server starts -
+prefix and fuzzy queries return incomplete results until the job has finished.
+
 A value search finds all nodes/edges where an attribute has a certain value.
 A request url which runs a new value search should be of the following form:
 
@@ -243,6 +430,48 @@ The return data is a list of node keys:
 
 	[ <node key1>, <node key2>, ... ]
 
+A geo search finds all nodes/edges where an attribute holds a location
+(a JSON object with lat and lon values) within a radius (in km) of a given
+location or inside a bounding box. A request url which runs a new geo search
+should be of the following form:
+
+/index/<partition>/n/<node kind>?near=<lat>,<lon>&radius=<km>&attr=<attribute>
+
+/index/<partition>/n/<node kind>?bbox=<min lat>,<min lon>,<max lat>,<max lon>&attr=<attribute>
+
+The return data is a list of node keys. Radius search results are ordered by
+distance:
+
+	[ <node key1>, <node key2>, ... ]
+
+A composite search combines word, value and phrase lookups over several
+attributes. The lookups are evaluated by intersecting or merging their results
+on the server. A composite search is a POST request of the following form:
+
+/index/<partition>/n/<node kind>
+
+/index/<partition>/e/<edge kind>
+
+The body is an index term. A term either has a list of terms which all (and)
+or at least one (or) must match or it looks up a word, value or phrase in an
+attribute. Terms can be nested:
+
+	{
+		and : [
+			{ attr : <attribute>, word : <word> },
+			{
+				or : [
+					{ attr : <attribute>, value : <value> },
+					{ attr : <attribute>, phrase : <phrase> }
+				]
+			}
+		]
+	}
+
+The return data is a sorted list of node keys:
+
+	[ <node key1>, <node key2>, ... ]
+
 
 Find query endpoint
 
@@ -269,6 +498,17 @@ The return data is a map of partitions to node kinds to a list of nodes:
 	}
 
 
+Flush endpoint
+
+/flush
+
+A POST request writes all pending changes to disk and waits until all previous
+commits are durable - regardless of the durability mode of the datastore. Bulk
+loads which run with a relaxed durability mode (periodic or os) can call this
+endpoint at the end. The response is an object with the keys synced and
+duration (in milliseconds).
+
+
 GraphQL request endpoint
 
 /graphql
@@ -279,6 +519,23 @@ query endpoint supports only read-queries (i.e. no mutations). EliasDB supports
 only executable definitions and introspection (i.e. no type system validation).
 
 
+Query history endpoint
+
+/history
+
+The history endpoint stores the query history of the current user in the
+system partition. A GET request returns the most recent entries first (the
+optional query parameter limit restricts the number of entries). A POST
+request adds an entry and a DELETE request clears the history. An entry
+has the following structure:
+
+	{
+		query     : <Query string>,
+		partition : <Partition of the query>,
+		time      : <Time when the entry was added>
+	}
+
+
 General database information endpoint
 
 /info
@@ -298,6 +555,137 @@ The return data is a key-value map:
 The node kind info endpoint returns general information about a known node or
 edge kind such as known attributes or known edges.
 
+For edge kinds the result also contains the role vocabulary of the kind as
+edge_roles (all roles which are used by edges of the kind) and renamed roles
+as edge_role_aliases (a map of old role to new role). The general info
+contains the role vocabularies of all edge kinds as edge_roles.
+
+
+Background jobs endpoint
+
+/jobs
+
+The jobs endpoint returns the state of all running and recently finished
+background jobs:
+
+	[
+		{
+			id       : <ID of the job>,
+			type     : <Type of the job>,
+			status   : <running, finished or failed>,
+			started  : <Start time>,
+			finished : <Finish time>,
+			done     : <Number of processed items (if reported by the job)>,
+			total    : <Total number of items (if reported by the job)>,
+			error    : <Error of a failed job>
+		},
+		...
+	]
+
+/jobs/<type>
+
+A new job is started by sending a POST request with the job parameters as
+body. The response contains the ID of the new job. Available job types:
+
+	analyze : Collect the statistics of the node kinds of a partition (node
+	          counts, distinct values, most common values and histograms of
+	          numeric values). EQL queries use the statistics to decide if
+	          start nodes are looked up in the index and to evaluate the most
+	          selective conditions first. Statistics are not updated when
+	          nodes change - the job should run again after larger changes.
+	          The result contains the collected statistics. Parameters:
+	          { partition : <Partition>, kinds : <Optional list of node kinds> }
+
+	compact : Reclaim the space of deleted and updated data in the storage
+	          files while the datastore stays online. The progress is shown
+	          in the info endpoint. Parameters:
+	          { batch_size : <Optional number of records per batch>,
+	            pause : <Optional pause between batches in ms> }
+
+	dedup : Find candidate duplicate nodes of a kind and connect them with
+	          PossibleDuplicate edges (role Duplicate) which carry the score
+	          of the match. Values of exact rules must be equal (ignoring
+	          case) - values of fuzzy rules must have a similarity (based on
+	          the edit distance) of at least the threshold of the rule (0.8
+	          by default). The score is the weighted average similarity of
+	          all rules. The result contains the candidate pairs which can
+	          be merged with the merge endpoint. Parameters:
+	          { partition : <Partition>, kind : <Node kind>,
+	            rules : [ { attr : <Attribute>,
+	                        fuzzy : <Optional flag for similarity matching>,
+	                        threshold : <Optional minimum similarity>,
+	                        weight : <Optional weight of the rule> }, ... ] }
+
+	quality : Data quality report of a partition. Reports attribute fill
+	          rates, type inconsistencies (e.g. numbers stored as strings),
+	          duplicate candidate nodes and orphaned edges. Parameters:
+	          { partition : <Partition>, kinds : <Optional list of node kinds> }
+
+	reencrypt : Rewrite all records which are not encrypted with the active
+	          encryption key (e.g. after a key rotation). The result contains
+	          the number of rewritten records. Parameters:
+	          { partition : <Optional partition>,
+	            batch_size : <Optional number of records per batch>,
+	            pause : <Optional pause between batches in ms> }
+
+	reindex : Rebuild the full-text and lookup index of a node or edge kind
+	          in a partition from the stored data (e.g. after a text analyzer
+	          was changed or to repair a corrupted index). If verify is set
+	          then the index is only checked and the result is a report with
+	          the number of missing, mismatched and unexpected index entries.
+	          Rebuilding the index of an edge kind also rebuilds the timelines
+	          of its timestamped edges.
+	          Parameters:
+	          { partition : <Partition>, kind : <Kind>,
+	            entity : <Optional entity type n (nodes - default) or e (edges)>,
+	            verify : <Optional flag to only check the index> }
+
+	renamerole : Rename a role of all edges of an edge kind in all partitions.
+	          The old role remains usable in traversal specs as an alias of
+	          the new role. The result contains the number of renamed edges.
+	          Parameters:
+	          { kind : <Edge kind>, from : <Old role>, to : <New role> }
+
+	rotatekey : Create a new active encryption key for a partition (or for
+	          all partitions without their own keys) and rewrite the records
+	          with it. The new key is written to the encryption key file. The
+	          result contains the key ID and the number of rewritten records.
+	          Parameters are the same as for reencrypt.
+
+	shred : Remove all encryption keys of a partition (crypto-shredding).
+	          All records of the partition which were encrypted with its own
+	          keys become permanently unreadable. Run rotatekey for the
+	          partition first so all its records use its own keys.
+	          Parameters:
+	          { partition : <Partition> }
+
+	upgradeindex : Rebuild the indexes of all node and edge kinds in all
+	          partitions if they were created by an older version (e.g.
+	          indexes without word dictionaries for prefix and fuzzy queries).
+	          The job is started automatically when the server starts.
+
+/jobs/<id>
+
+A GET request returns the state of a job including its result. A DELETE
+request removes a finished job.
+
+
+Merge endpoint
+
+/merge/<partition>/<kind>
+
+A POST request merges source nodes into a target node:
+
+	{
+		target  : <Key of the target node>,
+		sources : [ <Key of a source node>, ... ]
+	}
+
+Attributes which the target node does not have are copied from the source
+nodes. All edges of the source nodes are moved to the target node and the
+source nodes are removed. PossibleDuplicate edges between merged nodes are
+removed. The response contains the merged node.
+
 
 Query endpoint
 
@@ -310,14 +698,20 @@ A query result gets an ID and is stored in a cache. The ID is returned in the
 X-Cache-Id header. Subsequent requests for the same result can use the ID
 instead of a query.
 
-The endpoint supports the optional limit, offset and groups parameter:
+The endpoint supports the optional limit, offset, groups and count parameter:
 
 	limit  - How many list items to return
 	offset - Offset in the dataset
 	groups - If set then group information are included in the result
 	         (depending on the result size this can be an expensive call)
+	count  - If set then the exact number of entries in the result is
+	         returned in the X-Filtered-Count header
 
 The total number of entries in the result is returned in the X-Total-Count header.
+If a limit is given and the result is not ordered, filtered or aggregated then
+only the requested entries are evaluated and the header is only set if there
+are no further entries. The count parameter makes sure that all entries are
+counted (without building the entries outside of the requested window).
 A request url which runs a new query should be of the following form:
 
 /query/<partition>?q=<query>
@@ -404,6 +798,188 @@ selected nodes to/from the given (existing) group.
 /queryresult/<rid>/csv
 
 The csv endpoint returns the search result as CSV string.
+
+
+Schema endpoint
+
+/schema
+
+The schema endpoint publishes JSON Schema documents for node kinds which can be
+used by code generators and validation middleware. A GET request returns the
+names of all available schema documents:
+
+	[ <node kind1>.json, <node kind2>.json, ... ]
+
+/schema/<node kind>.json?sample=<number of nodes>
+
+Returns the JSON Schema document of a node kind. The attribute types are
+inferred from the stored nodes. The optional sample parameter limits the number
+of scanned nodes (default 1000, 0 scans all nodes). Attributes which are present
+on all scanned nodes are required.
+
+Saved sessions endpoint
+
+/sessions
+
+The sessions endpoint stores named sessions of the current user in the system
+partition. A GET request returns the names of all saved sessions:
+
+	[
+		{
+			name    : <Name of the session>,
+			updated : <Time of the last update>
+		},
+		...
+	]
+
+/sessions/<name>
+
+A session is stored with a PUT or POST request and returned with a GET request.
+A DELETE request removes the session. The body should have the following
+datastructure:
+
+	{
+		queries : <List of queries>,
+		layout  : <Layout of the client>
+	}
+
+Widget endpoint
+
+/widget/<partition>
+
+The widget endpoint creates signed tokens for queries whose results can be
+embedded in other web pages (e.g. wikis or internal portals). A POST request
+with the following body returns a token:
+
+	{
+		query   : <EQL query>,
+		expires : <Expiry in seconds (optional, 0 for no expiry)>
+	}
+
+The token can be used with the public widget endpoints which do not require
+authentication and allow cross-origin requests from WidgetAllowedOrigins:
+
+	/db/widget/<token>?limit=<max rows>
+
+Returns the query result as an object with the keys header, rows and sources.
+
+	/db/widget.js
+
+Returns a script which renders all elements with a data-eliasdb-widget
+attribute (holding a token) as a table or as a graph:
+
+	<div data-eliasdb-widget="<token>" data-eliasdb-view="graph"></div>
+	<script src="https://<host>/db/widget.js"></script>
+
+Widgets are only available if a WidgetSecret is configured.
+
+Sandbox endpoint
+
+/sandbox/query/<partition>?q=<query>&limit=<max rows>&offset=<offset>
+/sandbox/graph/<partition>/<entity type>/<kind>/<key>/<traversal spec>
+
+The public sandbox endpoint serves read-only GET requests for the partitions
+in SandboxPartitions without authentication (e.g. for public demo datasets).
+Requests behave like GET requests of the query and graph endpoints with the
+following restrictions:
+
+- Each client address may make SandboxRateLimit requests per minute.
+- At most SandboxMaxRows rows, nodes or traversal results are returned.
+- Queries are canceled after SandboxQueryTimeoutSeconds.
+- Query results are not cached and the rid parameter is not supported.
+- Blobs cannot be read.
+
+Analyzers endpoint
+
+/analyzers
+
+The analyzers endpoint manages the text analyzers of the full-text index. An
+analyzer controls how words are extracted from the value of an attribute of a
+node or edge kind. Index queries on the attribute are analyzed in the same
+way. A GET request returns all analyzers and the supported languages:
+
+	{
+		analyzers : { <kind> : { <attr> : <analyzer> } },
+		languages : [ <language>, ... ]
+	}
+
+/analyzers/<kind>/<attr>
+
+A PUT request sets the analyzer of an attribute. A DELETE request removes it.
+An analyzer object has the following keys (all optional):
+
+	{
+		tokenizer         : <standard (split on whitespace and punctuation)
+		                     or whitespace (split only on whitespace)>,
+		lowercase         : <Flag if words should be lowercased>,
+		language          : <Language for stemming and stop words>,
+		stemming          : <Flag if words should be reduced to their stem>,
+		languagestopwords : <Flag if stop words of the language are removed>,
+		stopwords         : <List of additional stop words>,
+		mingram           : <Minimum n-gram size>,
+		maxgram           : <Maximum n-gram size>
+	}
+
+If n-grams are enabled then all n-grams of a word are indexed as well (e.g.
+a word query for "ell" finds "hello"). Changed analyzers only apply to data
+which is written afterwards. A reindex job applies them to existing data.
+
+Unindexed endpoint
+
+/unindexed
+
+The unindexed endpoint manages attributes which are excluded from the
+full-text and lookup index (e.g. large text blobs or frequently changing
+counters). Excluded attributes cannot be found with index queries but writes
+are cheaper. A GET request returns all excluded attributes:
+
+	{ <kind> : [ <attr>, ... ] }
+
+/unindexed/<kind>/<attr>
+
+A PUT request excludes an attribute from the index. The attribute * excludes
+all attributes of a kind. A DELETE request includes the attribute again.
+Changes only apply to data which is written afterwards. A reindex job applies
+them to existing data.
+
+Admin endpoint
+
+/admin
+
+The admin endpoint can be used for administrative operations. A sync
+operation is a commit barrier which waits for all running commits and returns
+once all prior commits are durable on disk:
+
+	/admin/sync
+
+A POST request returns an object with the keys synced and duration (in
+milliseconds). Applications which trigger external side effects can call
+this endpoint before doing so.
+
+The cache operation shows how the storage caches are used by each partition:
+
+	/admin/cache
+
+A GET request returns a list of objects with the keys partition, hits, misses,
+size, max_objects and recommended. The access counters are collected since
+the last rebalance. The recommended allocation distributes the total cache
+size between all partitions according to their access frequency so a small
+hot partition is not starved by a large cold one. A POST request applies the
+recommended allocation and resets the access counters.
+
+The index operation starts a reindex job (see the jobs endpoint) which
+rebuilds or verifies the index of a node or edge kind:
+
+	/admin/index
+
+A POST request with the job parameters as body returns the ID of the new job.
+The progress of the job can be followed through the jobs endpoint.
+
+The roles operation starts a renamerole job which renames an edge role:
+
+	/admin/roles
+
+A POST request with the job parameters as body returns the ID of the new job.
 */
 package v1
 
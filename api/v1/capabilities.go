@@ -0,0 +1,246 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/cdc"
+	"github.com/krotik/eliasdb/codec"
+	"github.com/krotik/eliasdb/config"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+CDCSinks are the change data capture sinks of this server.
+*/
+var CDCSinks []*cdc.Sink
+
+/*
+EndpointCapabilities is the capabilities endpoint URL (rooted). Handles everything under capabilities/...
+*/
+const EndpointCapabilities = api.APIRoot + APIv1 + "/capabilities/"
+
+/*
+CapabilitiesEndpointInst creates a new endpoint handler.
+*/
+func CapabilitiesEndpointInst() api.RestEndpointHandler {
+	return &capabilitiesEndpoint{}
+}
+
+/*
+Handler object for capabilities queries.
+*/
+type capabilitiesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the optional subsystems of this server, if they are enabled
+and their relevant limits.
+*/
+func (ce *capabilitiesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	cluster := map[string]interface{}{
+		"enabled": api.DD != nil,
+	}
+
+	if api.DD != nil {
+		cluster["member"] = api.DD.MemberManager.Name()
+		cluster["members"] = api.DD.MemberManager.Members()
+	}
+
+	changes := map[string]interface{}{
+		"enabled": ChangeLog != nil,
+	}
+
+	if ChangeLog != nil {
+		changes["capacity"] = ChangeLog.Capacity()
+		changes["poll_default_wait"] = int(ChangesPollDefaultWait.Seconds())
+		changes["poll_max_wait"] = int(ChangesPollMaxWait.Seconds())
+	}
+
+	replica := map[string]interface{}{
+		"enabled": Replica != nil,
+	}
+
+	if Replica != nil {
+		replica["primary"] = Replica.Primary()
+	}
+
+	httpCodecs := []string{}
+
+	for _, c := range api.ResponseCodecs {
+		httpCodecs = append(httpCodecs, c.Name())
+	}
+
+	sandbox := map[string]interface{}{
+		"enabled": len(SandboxPartitions) > 0,
+	}
+
+	if len(SandboxPartitions) > 0 {
+		sandbox["partitions"] = SandboxPartitions
+		sandbox["max_rows"] = SandboxMaxRows
+		sandbox["rate_limit"] = SandboxRateLimit
+		sandbox["query_timeout"] = int(SandboxQueryTimeout.Seconds())
+	}
+
+	cdcSinks := map[string]interface{}{}
+
+	for _, sink := range CDCSinks {
+		cdcSinks[sink.Name()] = sink.Stats()
+	}
+
+	maxOps, maxBytes := api.GM.TransLimits()
+	keyGen := api.GM.KeyGeneration()
+
+	data := map[string]interface{}{
+		"api_versions": []string{"v1"},
+		"product":      "EliasDB",
+		"version":      config.ProductVersion,
+		"read_only":    api.ReadOnly,
+		"subsystems": map[string]interface{}{
+			"auth": map[string]interface{}{
+				"enabled": api.RequestUser != nil,
+			},
+			"cluster": cluster,
+			"changes": changes,
+			"replica": replica,
+			"graphql": map[string]interface{}{
+				"enabled":       true,
+				"subscriptions": true,
+			},
+			"schema": map[string]interface{}{
+				"enabled":  true,
+				"enforced": false,
+				"version":  graph.JSONSchemaVersion,
+			},
+			"encryption": map[string]interface{}{
+				"enabled": api.EncryptionKeys != nil,
+			},
+			"compression": map[string]interface{}{
+				"enabled": len(httpCodecs) > 0,
+				"http":    httpCodecs,
+				"codecs":  codec.Names(),
+			},
+			"key_obfuscation": map[string]interface{}{
+				"enabled": api.KeyObfuscation != nil,
+			},
+			"key_generation": map[string]interface{}{
+				"enabled": keyGen != graph.KeyGenerationNone,
+				"mode":    keyGen,
+			},
+			"ecal": map[string]interface{}{
+				"enabled": api.SI != nil,
+			},
+			"rules": map[string]interface{}{
+				"enabled": Rules != nil,
+			},
+			"scripts": map[string]interface{}{
+				"enabled": Scripts != nil,
+			},
+			"sandbox": sandbox,
+			"webhooks": map[string]interface{}{
+				"enabled": Webhooks != nil,
+			},
+			"cdc": map[string]interface{}{
+				"enabled": len(CDCSinks) > 0,
+				"sinks":   cdcSinks,
+			},
+			"databases": map[string]interface{}{
+				"enabled": Databases != nil,
+			},
+			"quotas": map[string]interface{}{
+				"enabled": Quotas != nil,
+			},
+		},
+		"limits": map[string]interface{}{
+			"transaction_max_operations":  maxOps,
+			"transaction_max_bytes":       maxBytes,
+			"traversal_max_visited_nodes": QueryOptions.TraversalMaxVisitedNodes,
+		},
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ce *capabilitiesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/capabilities"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return the capabilities of the server.",
+			"description": "The optional subsystems of the server (auth, cluster, changes, replica, graphql, " +
+				"schema, encryption, compression, key_obfuscation, key_generation, ecal, rules, scripts, " +
+				"sandbox, webhooks and cdc) are returned with a flag if they are enabled and their relevant settings. Limits " +
+				"contain the transaction and traversal limits (0 for no limit).",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Capabilities of the server.",
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"api_versions": map[string]interface{}{
+								"description": "Available API versions.",
+								"type":        "array",
+								"items": map[string]interface{}{
+									"type": "string",
+								},
+							},
+							"version": map[string]interface{}{
+								"description": "Version of the server.",
+								"type":        "string",
+							},
+							"read_only": map[string]interface{}{
+								"description": "Flag if the server rejects writes.",
+								"type":        "boolean",
+							},
+							"subsystems": map[string]interface{}{
+								"description": "Optional subsystems by name.",
+								"type":        "object",
+							},
+							"limits": map[string]interface{}{
+								"description": "Limits of the server.",
+								"type":        "object",
+							},
+						},
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
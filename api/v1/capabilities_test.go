@@ -0,0 +1,103 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
+)
+
+func TestCapabilities(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointCapabilities
+
+	oldChangeLog := ChangeLog
+	oldSandboxPartitions := SandboxPartitions
+	defer func() {
+		ChangeLog = oldChangeLog
+		SandboxPartitions = oldSandboxPartitions
+		api.GM.SetTransLimits(0, 0)
+	}()
+
+	ChangeLog = nil
+	SandboxPartitions = nil
+
+	st, _, res := sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification:" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var data map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	subsystems := data["subsystems"].(map[string]interface{})
+
+	if res := fmt.Sprint(subsystems["changes"], subsystems["sandbox"], subsystems["graphql"], subsystems["cdc"],
+		subsystems["databases"]); res != "map[enabled:false] map[enabled:false] map[enabled:true subscriptions:true] "+
+		"map[enabled:false sinks:map[]] map[enabled:false]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Enabled subsystems report their settings
+
+	ChangeLog = replication.NewChangeLog(100)
+	SandboxPartitions = []string{"main"}
+	api.GM.SetTransLimits(10, 0)
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	json.Unmarshal([]byte(res), &data)
+
+	subsystems = data["subsystems"].(map[string]interface{})
+
+	if res := fmt.Sprint(subsystems["changes"]); res !=
+		"map[capacity:100 enabled:true poll_default_wait:30 poll_max_wait:300]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(subsystems["sandbox"].(map[string]interface{})["partitions"]); res != "[main]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(data["limits"].(map[string]interface{})["transaction_max_operations"]); res != "10" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(subsystems["key_generation"]); res != "map[enabled:false mode:none]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,465 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+EndpointChanges is the changes endpoint URL (rooted). Handles everything under changes/...
+*/
+const EndpointChanges = api.APIRoot + APIv1 + "/changes/"
+
+/*
+ChangeLog is the change log which is served by the changes endpoint (nil if
+the change log is disabled).
+*/
+var ChangeLog *replication.ChangeLog
+
+/*
+Replica is the replication of this instance (nil if this instance is not a replica).
+*/
+var Replica *replication.Replica
+
+/*
+ChangesPollDefaultWait is the time a long-poll request of the changes endpoint
+waits for new changes if no wait parameter is given.
+*/
+var ChangesPollDefaultWait = 30 * time.Second
+
+/*
+ChangesPollMaxWait is the maximum time a long-poll request of the changes
+endpoint waits for new changes.
+*/
+var ChangesPollMaxWait = 5 * time.Minute
+
+/*
+ChangesEndpointInst creates a new endpoint handler.
+*/
+func ChangesEndpointInst() api.RestEndpointHandler {
+	return &changesEndpoint{}
+}
+
+/*
+Handler object for change log requests.
+*/
+type changesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a change log request.
+*/
+func (ce *changesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var since uint64
+	var wait time.Duration
+	var err error
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if ChangeLog == nil {
+		http.Error(w, "Change log is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	poll := len(resources) == 1 && resources[0] == "poll"
+
+	if len(resources) == 1 && !poll {
+
+		if resources[0] != "snapshot" {
+			http.Error(w, "Unknown resource: "+resources[0], http.StatusBadRequest)
+			return
+		}
+
+		// The snapshot is streamed - an error while writing leaves an
+		// incomplete stream which is detected by the replica
+
+		w.Header().Set("content-type", replication.ContentTypeGob)
+
+		var translate replication.DataTranslation
+
+		if api.KeyObfuscation != nil {
+			translate = func(nodeData map[string]interface{}) (map[string]interface{}, error) {
+				return externalData(nodeData), nil
+			}
+		}
+
+		ChangeLog.WriteSnapshot(w, api.GM, translate)
+
+		return
+	}
+
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		if since, err = strconv.ParseUint(sinceParam, 10, 64); err != nil {
+			http.Error(w, "Invalid parameter value: since should be a positive integer number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, ok := queryParamPosNum(w, r, "limit")
+	if !ok {
+		return
+	}
+
+	filter, ok := changesFilterParam(w, r)
+	if !ok {
+		return
+	}
+
+	if poll {
+		wait = ChangesPollDefaultWait
+
+		if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+
+			// The wait time is a duration (e.g. 30s) or a number of seconds
+
+			if _, err := strconv.Atoi(waitParam); err == nil {
+				waitParam += "s"
+			}
+
+			if wait, err = time.ParseDuration(waitParam); err != nil || wait < 0 {
+				http.Error(w, "Invalid parameter value: wait should be a duration (e.g. 30s)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if wait > ChangesPollMaxWait {
+			wait = ChangesPollMaxWait
+		}
+	}
+
+	// Record the replica so the primary can advertise it
+
+	if url := r.Header.Get(replication.ReplicaURLHeader); url != "" && Topology != nil {
+		Topology.ReplicaSeen(url, since)
+	}
+
+	var res *replication.ChangesResponse
+	var timeout <-chan time.Time
+
+	if poll {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	for res == nil {
+
+		// Get the notification channel first so no change is missed
+
+		notify := ChangeLog.Notify()
+		lastSeq, lastTime := ChangeLog.LastSeq()
+
+		changes, next, err := ChangeLog.FilteredChanges(since, limit, filter)
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusConflict)
+			return
+		}
+
+		if poll && len(changes) == 0 {
+
+			// Wait for new changes - changes which were not selected by the
+			// filter are skipped
+
+			since = next
+
+			select {
+			case <-notify:
+				continue
+			case <-timeout:
+			case <-r.Context().Done():
+			}
+		}
+
+		res = &replication.ChangesResponse{
+			ID:       ChangeLog.ID(),
+			LastSeq:  lastSeq,
+			LastTime: lastTime,
+			Changes:  ExternalChanges(changes),
+			Next:     next,
+		}
+	}
+
+	// Replicas request gob which preserves the types of attribute values
+
+	if r.Header.Get("accept") == replication.ContentTypeGob {
+		w.Header().Set("content-type", replication.ContentTypeGob)
+
+		gob.NewEncoder(w).Encode(res)
+
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(res)
+}
+
+/*
+changesFilterParam returns a filter for the changes of the change log from the
+parameters part, kind and op of a request. Each parameter is a comma separated
+list of accepted values. Returns nil if no filter was given.
+*/
+func changesFilterParam(w http.ResponseWriter, r *http.Request) (replication.ChangeFilter, bool) {
+	values := make(map[string]map[string]bool)
+
+	for _, param := range []string{"part", "kind", "op"} {
+		for _, val := range r.URL.Query()[param] {
+			for _, v := range strings.Split(val, ",") {
+
+				if v = strings.TrimSpace(v); v == "" {
+					continue
+				}
+
+				if param == "op" && v != replication.OpStoreNode && v != replication.OpDeleteNode &&
+					v != replication.OpStoreEdge && v != replication.OpDeleteEdge {
+
+					http.Error(w, "Invalid parameter value: op should be one of "+strings.Join([]string{
+						replication.OpStoreNode, replication.OpDeleteNode,
+						replication.OpStoreEdge, replication.OpDeleteEdge}, ", "), http.StatusBadRequest)
+					return nil, false
+				}
+
+				if values[param] == nil {
+					values[param] = make(map[string]bool)
+				}
+
+				values[param][v] = true
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, true
+	}
+
+	return func(c *replication.Change) bool {
+		return (values["part"] == nil || values["part"][c.Part]) &&
+			(values["kind"] == nil || values["kind"][c.Kind]) &&
+			(values["op"] == nil || values["op"][c.Op])
+	}, true
+}
+
+/*
+ExternalChanges returns copies of changes with all keys translated into
+external IDs.
+*/
+func ExternalChanges(changes []*replication.Change) []*replication.Change {
+
+	if api.KeyObfuscation == nil {
+		return changes
+	}
+
+	ret := make([]*replication.Change, len(changes))
+
+	for i, c := range changes {
+		ec := *c
+		ec.Key = api.ExternalKey(c.Kind, c.Key)
+		ec.Data = externalData(c.Data)
+		ret[i] = &ec
+	}
+
+	return ret
+}
+
+/*
+InternalReplicationData translates the external IDs of node or edge data which
+a replica received from its primary into keys. Primary and replica must use
+the same key obfuscation.
+*/
+func InternalReplicationData(nodeData map[string]interface{}) (map[string]interface{}, error) {
+	return nodeData, internalData(nodeData)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ce *changesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/changes"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return changes of the datastore.",
+			"description": "The changes endpoint returns recorded changes of the datastore. " +
+				"It is used by replicas to follow a primary. Replicas request the changes " +
+				"as gob (Accept: application/x-gob) which preserves the types of attribute values.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+				"application/x-gob",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "since",
+					"in":          "query",
+					"description": "Sequence number of the last known change.",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "limit",
+					"in":          "query",
+					"description": "Maximum number of changes to return.",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "part",
+					"in":          "query",
+					"description": "Comma separated list of partitions whose changes should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "query",
+					"description": "Comma separated list of node or edge kinds whose changes should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "op",
+					"in":          "query",
+					"description": "Comma separated list of operations (node.store, node.delete, edge.store or edge.delete) which should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the change log ID, the last sequence number, a list of changes and the sequence number to continue with (next). Each change contains the commit sequence number of its partition (part_seq).",
+				},
+				"409": map[string]interface{}{
+					"description": "The requested changes are no longer available. A snapshot needs to be loaded.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/changes/poll"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Wait for changes of the datastore.",
+			"description": "The poll endpoint is a long-polling variant of the changes endpoint for " +
+				"clients which cannot use websockets or server-sent events. A request returns as soon " +
+				"as changes which are selected by the filter are available or once the wait time is " +
+				"over (with an empty list of changes). Clients continue with the returned next value as since.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "since",
+					"in":          "query",
+					"description": "Sequence number of the last known change.",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "wait",
+					"in":          "query",
+					"description": "Maximum time to wait for changes (e.g. 30s or a number of seconds). Defaults to 30s.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "limit",
+					"in":          "query",
+					"description": "Maximum number of changes to return.",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "part",
+					"in":          "query",
+					"description": "Comma separated list of partitions whose changes should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "query",
+					"description": "Comma separated list of node or edge kinds whose changes should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "op",
+					"in":          "query",
+					"description": "Comma separated list of operations (node.store, node.delete, edge.store or edge.delete) which should be returned.",
+					"required":    false,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the change log ID, the last sequence number, a list of changes and the sequence number to continue with (next).",
+				},
+				"409": map[string]interface{}{
+					"description": "The requested changes are no longer available. A snapshot needs to be loaded.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/changes/snapshot"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return a snapshot of the datastore.",
+			"description": "The snapshot endpoint streams all partitions as a gob stream. The stream " +
+				"starts with the change log ID and the sequence number of the last change before the snapshot.",
+			"produces": []string{
+				"text/plain",
+				"application/x-gob",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A gob stream with a snapshot header followed by all nodes and edges of all partitions.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,283 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/replication"
+)
+
+func TestChanges(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointChanges
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		ChangeLog = nil
+		Replica = nil
+		api.ReadOnly = false
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Change log is not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	ChangeLog = replication.NewChangeLog(2)
+	api.GM.SetGraphRule(ChangeLog)
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown resource: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?since=x", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: since should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Author")
+		api.GM.StoreNode("main", node)
+	}
+
+	var cres replication.ChangesResponse
+
+	st, _, res = sendTestRequest(queryURL+"?since=1&limit=1", "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || cres.ID != ChangeLog.ID() || cres.LastSeq != 3 ||
+		len(cres.Changes) != 1 || cres.Changes[0].Key != "b" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "409 Conflict" || res != "Requested changes are no longer available" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var header replication.SnapshotHeader
+	var entry replication.SnapshotEntry
+
+	st, _, res = sendTestRequest(queryURL+"snapshot", "GET", nil)
+
+	dec := gob.NewDecoder(strings.NewReader(res))
+	dec.Decode(&header)
+	dec.Decode(&entry)
+
+	if st != "200 OK" || header.Seq != 3 || header.ID != ChangeLog.ID() ||
+		entry.Op != replication.OpStoreNode || entry.Data["key"] == nil {
+		t.Error("Unexpected response:", st, header, entry)
+		return
+	}
+
+	// Check replica status and read-only mode
+
+	api.ReadOnly = true
+	Replica = replication.NewReplica("http://localhost"+TESTPORT, api.GM, nil)
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointInfoQuery, "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"replication": {`) || !strings.Contains(res, `"lag_changes": 0`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointGraph+"main/n", "POST", []byte(`[{"key":"x","kind":"Author"}]`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestChangesKeyObfuscation(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointChanges
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		ChangeLog = nil
+		api.KeyObfuscation = nil
+	}()
+
+	api.GM, _ = songGraph()
+	api.KeyObfuscation, _ = api.NewTokenKeyObfuscator("secret")
+
+	ChangeLog = replication.NewChangeLog(10)
+	api.GM.SetGraphRule(ChangeLog)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "a")
+	node.SetAttr("kind", "Author")
+	api.GM.StoreNode("main", node)
+	api.GM.RemoveNode("main", "a", "Author")
+
+	id := api.ExternalKey("Author", "a")
+
+	var cres replication.ChangesResponse
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || len(cres.Changes) != 2 || cres.Changes[0].Key != id ||
+		cres.Changes[0].Data["key"] != id || cres.Changes[1].Key != id {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Snapshots contain external IDs which a replica translates back
+
+	var header replication.SnapshotHeader
+	var entry replication.SnapshotEntry
+
+	_, _, res = sendTestRequest(queryURL+"snapshot", "GET", nil)
+
+	dec := gob.NewDecoder(strings.NewReader(res))
+	dec.Decode(&header)
+	dec.Decode(&entry)
+
+	id = fmt.Sprint(entry.Data["key"])
+
+	ndata, err := InternalReplicationData(entry.Data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kind := fmt.Sprint(ndata["kind"])
+
+	if n, err := api.GM.FetchNode(entry.Part, fmt.Sprint(ndata["key"]), kind); err != nil || n == nil ||
+		id == n.Key() || api.ExternalKey(kind, n.Key()) != id {
+		t.Error("Unexpected result:", n, id, err)
+		return
+	}
+}
+
+func TestChangesPoll(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointChanges
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		ChangeLog = nil
+	}()
+
+	api.GM, _ = songGraph()
+
+	ChangeLog = replication.NewChangeLog(10)
+	api.GM.SetGraphRule(ChangeLog)
+
+	storeNode := func(key string, kind string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", kind)
+		api.GM.StoreNode("main", node)
+	}
+
+	st, _, res := sendTestRequest(queryURL+"poll?wait=x", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: wait should be a duration (e.g. 30s)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"poll?op=foo", "GET", nil)
+
+	if st != "400 Bad Request" || res !=
+		"Invalid parameter value: op should be one of node.store, node.delete, edge.store, edge.delete" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		storeNode(key, "Author")
+	}
+
+	// Available changes are returned immediately
+
+	var cres replication.ChangesResponse
+
+	st, _, res = sendTestRequest(queryURL+"poll?since=1", "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || len(cres.Changes) != 2 || cres.Changes[0].Key != "b" || cres.Next != 3 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The changes endpoint and the poll endpoint filter changes the same way
+
+	cres = replication.ChangesResponse{}
+
+	st, _, res = sendTestRequest(queryURL+"?op=node.delete", "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || len(cres.Changes) != 0 || cres.Next != 3 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// A poll request returns once the wait time is over
+
+	cres = replication.ChangesResponse{}
+	start := time.Now()
+
+	st, _, res = sendTestRequest(queryURL+"poll?since=0&kind=Song&wait=100ms", "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || len(cres.Changes) != 0 || cres.Next != 3 || time.Since(start) < 100*time.Millisecond {
+		t.Error("Unexpected response:", st, res, time.Since(start))
+		return
+	}
+
+	// A poll request waits for changes which are selected by the filter
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		storeNode("x", "Song")
+		time.Sleep(50 * time.Millisecond)
+		storeNode("d", "Author")
+	}()
+
+	cres = replication.ChangesResponse{}
+	start = time.Now()
+
+	st, _, res = sendTestRequest(queryURL+"poll?since=3&part=main&kind=Author,Writer&op=node.store&wait=5", "GET", nil)
+	json.Unmarshal([]byte(res), &cres)
+
+	if st != "200 OK" || len(cres.Changes) != 1 || cres.Changes[0].Key != "d" || cres.Next != 5 ||
+		time.Since(start) >= 5*time.Second {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -14,8 +14,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
 )
 
 /*
@@ -23,6 +25,12 @@ EndpointClusterQuery is the cluster endpoint URL (rooted). Handles everything un
 */
 const EndpointClusterQuery = api.APIRoot + APIv1 + "/cluster/"
 
+/*
+Raft is the Raft cluster member of this instance (nil if Raft replication is
+not enabled).
+*/
+var Raft *replication.RaftNode
+
 /*
 ClusterEndpointInst creates a new endpoint handler.
 */
@@ -43,6 +51,11 @@ HandleGET handles a cluster query REST call.
 func (ce *clusterEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
 	var data interface{}
 
+	if Raft != nil {
+		ce.handleRaftGET(w, r, resources)
+		return
+	}
+
 	// Check clustering is enabled
 
 	if api.DD == nil || api.DDLog == nil {
@@ -107,6 +120,11 @@ func (ce *clusterEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, res
 		return v, ok
 	}
 
+	if Raft != nil {
+		ce.handleRaftPUT(w, r, resources[0], getArg)
+		return
+	}
+
 	if resources[0] == "join" {
 
 		// Get required args
@@ -166,6 +184,82 @@ func (ce *clusterEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, res
 	}
 }
 
+/*
+handleRaftGET returns the status or the members of the Raft cluster.
+*/
+func (ce *clusterEndpoint) handleRaftGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var data interface{}
+	var err error
+
+	if len(resources) == 1 && resources[0] == "members" {
+
+		// Cluster members are requested
+
+		if data, err = Raft.Members(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	} else {
+
+		// By default the status of this member is returned
+
+		data = Raft.Status()
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+handleRaftPUT adds or removes members of the Raft cluster. Requests to a
+follower are redirected to the leader.
+*/
+func (ce *clusterEndpoint) handleRaftPUT(w http.ResponseWriter, r *http.Request, command string,
+	getArg func(string) (string, bool)) {
+
+	var err error
+
+	name, ok := getArg("name")
+	if !ok {
+		return
+	}
+
+	if command == "join" {
+		var addr string
+
+		if addr, ok = getArg("netaddr"); !ok {
+			return
+		}
+
+		err = Raft.Join(name, addr)
+
+	} else if command == "eject" {
+
+		err = Raft.Remove(name)
+
+	} else {
+		http.Error(w, "Unknown command: "+command, http.StatusBadRequest)
+		return
+	}
+
+	if err == replication.ErrNotLeader {
+		if leader := Raft.Leader(); leader != "" {
+
+			// The IDs of the members are their advertised URLs
+
+			http.Redirect(w, r, strings.TrimSuffix(leader, "/")+r.URL.Path, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not %v %v: %v", command, name, err.Error()), http.StatusForbidden)
+	}
+}
+
 /*
 HandleDELETE handles a cluster delete REST call.
 */
@@ -198,7 +292,7 @@ func (ce *clusterEndpoint) SwaggerDefs(s map[string]interface{}) {
 	s["paths"].(map[string]interface{})["/v1/cluster"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Return cluster specific information.",
-			"description": "The cluster endpoint returns the cluster state info which contains cluster members and their state.",
+			"description": "The cluster endpoint returns the cluster state info which contains cluster members and their state. With Raft replication the status of this member (state, leader, term and log indexes) is returned.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -232,7 +326,7 @@ func (ce *clusterEndpoint) SwaggerDefs(s map[string]interface{}) {
 				{
 					"name":        "command",
 					"in":          "path",
-					"description": "Valid commands are: ping, join and eject.",
+					"description": "Valid commands are: ping, join and eject. With Raft replication only join and eject are valid and are redirected to the leader.",
 					"required":    true,
 					"type":        "string",
 				},
@@ -249,7 +343,7 @@ func (ce *clusterEndpoint) SwaggerDefs(s map[string]interface{}) {
 								"type":        "string",
 							},
 							"netaddr": map[string]interface{}{
-								"description": "Network address of a member e.g. localhost:9030 (ping/join=member address to contact)",
+								"description": "Network address of a member e.g. localhost:9030 (ping/join=member address to contact, Raft join=Raft address of the new member)",
 								"type":        "string",
 							},
 						},
@@ -292,6 +386,28 @@ func (ce *clusterEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	s["paths"].(map[string]interface{})["/v1/cluster/members"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the members of a Raft cluster.",
+			"description": "The members endpoint returns the ID, the Raft address and the voting status of every member of a Raft cluster.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of members.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
 	s["paths"].(map[string]interface{})["/v1/cluster/log"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Return latest cluster related log messages.",
@@ -17,16 +17,21 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/raft"
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/cluster"
 	"github.com/krotik/eliasdb/cluster/manager"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/replication"
 )
 
 func TestClusterStorage(t *testing.T) {
@@ -641,3 +646,123 @@ func checkStateInfo(mm *manager.MemberManager, expectedStateInfo string) error {
 
 	return nil
 }
+
+func TestClusterRaft(t *testing.T) {
+	clusterQueryURL := "http://localhost" + TESTPORT + EndpointClusterQuery
+
+	oldRaftTimeout := replication.RaftTimeout
+	replication.RaftTimeout = 100 * time.Millisecond
+	defer func() {
+		replication.RaftTimeout = oldRaftTimeout
+		Raft = nil
+	}()
+
+	// The leader receives requests which are redirected by followers
+
+	var received string
+
+	leaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = r.Method + " " + r.URL.Path + " " + string(body)
+	}))
+	defer leaderServer.Close()
+
+	addr1, trans1 := raft.NewInmemTransport("")
+	addr2, trans2 := raft.NewInmemTransport("")
+	trans1.Connect(addr2, trans2)
+	trans2.Connect(addr1, trans1)
+
+	leader, err := replication.NewRaftNode(&replication.RaftConfig{ID: leaderServer.URL,
+		Bootstrap: true, Transport: trans1}, graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("leader")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer leader.Shutdown()
+
+	follower, err := replication.NewRaftNode(&replication.RaftConfig{ID: "follower",
+		Transport: trans2}, graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("follower")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer follower.Shutdown()
+
+	for i := 0; i < 100 && !leader.IsLeader(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Raft = leader
+
+	st, _, res := sendTestRequest(clusterQueryURL+"join", "PUT", []byte(`{"name": "follower"}`))
+
+	if st != "400 Bad Request" || res != "Required argument netaddr missing in body arguments" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(clusterQueryURL+"ping", "PUT", []byte(`{"name": "follower"}`))
+
+	if st != "400 Bad Request" || res != "Unknown command: ping" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(clusterQueryURL+"join", "PUT", []byte(`{"name": "follower", "netaddr": "`+string(addr2)+`"}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var members []map[string]interface{}
+
+	st, _, res = sendTestRequest(clusterQueryURL+"members", "GET", nil)
+	json.Unmarshal([]byte(res), &members)
+
+	if st != "200 OK" || len(members) != 2 || members[1]["id"] != "follower" ||
+		members[1]["address"] != string(addr2) || members[1]["voter"] != true {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var status map[string]interface{}
+
+	st, _, res = sendTestRequest(clusterQueryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &status)
+
+	if st != "200 OK" || status["state"] != "leader" || status["leader"] != leaderServer.URL ||
+		status["writable"] != true {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Followers redirect membership changes to the leader
+
+	Raft = follower
+
+	for i := 0; i < 100 && follower.Leader() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	st, _, res = sendTestRequest(clusterQueryURL+"eject", "PUT", []byte(`{"name": "follower"}`))
+
+	if st != "200 OK" || received != `PUT /db/v1/cluster/eject {"name": "follower"}` {
+		t.Error("Unexpected response:", st, res, received)
+		return
+	}
+
+	Raft = leader
+
+	st, _, res = sendTestRequest(clusterQueryURL+"eject", "PUT", []byte(`{"name": "follower"}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if members, _ := leader.Members(); len(members) != 1 {
+		t.Error("Unexpected members:", members)
+		return
+	}
+}
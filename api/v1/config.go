@@ -0,0 +1,166 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/config"
+)
+
+/*
+EndpointConfig is the config endpoint URL (rooted). Handles everything under config/...
+*/
+const EndpointConfig = api.APIRoot + APIv1 + "/config/"
+
+/*
+ConfigEndpointInst creates a new endpoint handler.
+*/
+func ConfigEndpointInst() api.RestEndpointHandler {
+	return &configEndpoint{}
+}
+
+/*
+Handler object for config operations.
+*/
+type configEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the current values of all configuration options which can
+be changed at runtime.
+*/
+func (ce *configEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(config.DynamicValues())
+}
+
+/*
+HandlePUT changes configuration options at runtime. The changes are not
+written to the config file.
+*/
+func (ce *configEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	var values map[string]interface{}
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changed, err := config.UpdateDynamic(values)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if changed == nil {
+		changed = []string{}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changed": changed,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ce *configEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/config"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return the configuration options which can be changed at runtime.",
+			"description": "The current values of all configuration options which can be changed " +
+				"at runtime are returned as an object.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Configuration options and their current values.",
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"put": map[string]interface{}{
+			"summary": "Change configuration options at runtime.",
+			"description": "Changes configuration options without restarting the server. Either all " +
+				"given options are changed or none. The changes are not written to the config file.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "options",
+					"in":          "body",
+					"description": "Object with configuration options and their new values.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Names of the changed options.",
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"changed": map[string]interface{}{
+								"type": "array",
+								"items": map[string]interface{}{
+									"type": "string",
+								},
+							},
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/config"
+)
+
+func TestConfigEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointConfig
+
+	oldConfig := config.Config
+	oldOptions := config.DynamicOptions
+	oldSandboxRateLimit := SandboxRateLimit
+	defer func() {
+		config.Config = oldConfig
+		config.DynamicOptions = oldOptions
+		SandboxRateLimit = oldSandboxRateLimit
+	}()
+
+	config.LoadDefaultConfig()
+
+	config.DynamicOptions = map[string]func() error{
+		config.SandboxRateLimit: func() error {
+			SandboxRateLimit = int(config.Int(config.SandboxRateLimit))
+			return nil
+		},
+	}
+
+	st, _, res := sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification:" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != `{
+  "SandboxRateLimit": 30
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte("{"))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{"HTTPSPort": "123"}`))
+
+	if st != "400 Bad Request" || res != "Configuration option HTTPSPort cannot be changed at runtime" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{"SandboxRateLimit": 5}`))
+
+	if st != "200 OK" || res != `{
+  "changed": [
+    "SandboxRateLimit"
+  ]
+}` || SandboxRateLimit != 5 {
+		t.Error("Unexpected response:", st, res, SandboxRateLimit)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{"SandboxRateLimit": 5}`))
+
+	if st != "200 OK" || res != `{
+  "changed": []
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,375 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krotik/common/datautil"
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+)
+
+/*
+EndpointConsole is the console endpoint URL (rooted). Handles websockets under console/
+*/
+const EndpointConsole = api.APIRoot + APIv1 + "/console/"
+
+/*
+ConsoleProgressInterval is the interval in which progress messages are sent
+while a command is running.
+*/
+var ConsoleProgressInterval = 2 * time.Second
+
+/*
+ConsolePageSize is the default number of rows which are returned for a query.
+*/
+var ConsolePageSize = 50
+
+/*
+consoleUpgrader can upgrade normal requests to websocket communications
+*/
+var consoleUpgrader = websocket.Upgrader{
+	Subprotocols:    []string{"eliasdb-console"},
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+/*
+ConsoleEndpointInst creates a new endpoint handler.
+*/
+func ConsoleEndpointInst() api.RestEndpointHandler {
+
+	// Init the result cache if necessary
+
+	if ResultCache == nil {
+		ResultCache = datautil.NewMapCache(ResultCacheMaxSize, ResultCacheMaxAge)
+	}
+
+	return &consoleEndpoint{}
+}
+
+/*
+Handler object for console connections.
+*/
+type consoleEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+consoleConn is a single console connection.
+*/
+type consoleConn struct {
+	conn   *websocket.Conn // Websocket connection
+	wlock  *sync.Mutex     // Lock for writing to the connection
+	r      *http.Request   // Request which opened the connection
+	proj   *api.Projection // Projection for returned results
+	partID string          // Default partition of the connection
+}
+
+/*
+HandleGET handles console connections.
+*/
+func (ce *consoleEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Update the incomming connection to a websocket
+	// If the upgrade fails then the client gets an HTTP error response.
+
+	conn, err := consoleUpgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+
+		// We give details here on what went wrong
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	defer conn.Close()
+
+	cc := &consoleConn{conn, &sync.Mutex{}, r, api.ResponseProjection.ForRequest(r), "main"}
+
+	if len(resources) > 0 && resources[0] != "" {
+		cc.partID = resources[0]
+	}
+
+	cc.write("", "ready", map[string]interface{}{
+		"partition": cc.partID,
+	})
+
+	for {
+		var cmd map[string]interface{}
+
+		_, msg, err := conn.ReadMessage()
+
+		if err != nil {
+
+			// Client has hung up or sent an invalid frame
+
+			return
+		}
+
+		if err := json.Unmarshal(msg, &cmd); err != nil {
+			cc.write("", "error", map[string]interface{}{
+				"message": "Could not decode command: " + err.Error(),
+			})
+			continue
+		}
+
+		if !cc.handleCommand(cmd) {
+			return
+		}
+	}
+}
+
+/*
+handleCommand handles a single command. Returns false if the connection
+should be closed.
+*/
+func (cc *consoleConn) handleCommand(cmd map[string]interface{}) bool {
+	var res map[string]interface{}
+	var err error
+
+	id := ""
+	if val, ok := cmd["id"]; ok {
+		id = fmt.Sprint(val)
+	}
+
+	switch cmd["cmd"] {
+
+	case "close":
+		cc.write(id, "closed", map[string]interface{}{})
+		return false
+
+	case "query":
+		res, err = cc.query(id, cmd)
+
+	case "page":
+		res, err = cc.page(cmd)
+
+	case "describe":
+		res, err = cc.describe(cmd)
+
+	default:
+		err = fmt.Errorf("Unknown command: %v", cmd["cmd"])
+	}
+
+	if err != nil {
+		cc.write(id, "error", map[string]interface{}{
+			"message": err.Error(),
+		})
+	} else {
+		cc.write(id, "result", res)
+	}
+
+	return true
+}
+
+/*
+query runs an EQL query and returns the first page of the result. Progress
+messages are sent while the query is running.
+*/
+func (cc *consoleConn) query(id string, cmd map[string]interface{}) (map[string]interface{}, error) {
+
+	query, ok := cmd["query"].(string)
+	if !ok || query == "" {
+		return nil, fmt.Errorf("Missing query")
+	}
+
+	part := cc.partition(cmd)
+	start := time.Now()
+	done := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(ConsoleProgressInterval):
+				cc.write(id, "progress", map[string]interface{}{
+					"status":     "running",
+					"elapsed_ms": int64(time.Since(start) / time.Millisecond),
+				})
+			}
+		}
+	}()
+
+	res, err := eql.RunQueryWithOptions(cc.r.Context(), stringutil.CreateDisplayString(part)+" query",
+		part, query, api.GM, QueryOptions)
+
+	close(done)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sres := &APISearchResult{res, nil}
+	resID := genID()
+
+	ResultCache.Put(resID, sres)
+
+	return cc.resultPage(resID, sres, 0, cc.intParam(cmd, "limit", ConsolePageSize))
+}
+
+/*
+page returns a page of a previous query result.
+*/
+func (cc *consoleConn) page(cmd map[string]interface{}) (map[string]interface{}, error) {
+
+	resID := fmt.Sprint(cmd["result_id"])
+
+	res, ok := ResultCache.Get(resID)
+	if !ok {
+		return nil, fmt.Errorf("Unknown result ID: %v", resID)
+	}
+
+	return cc.resultPage(resID, res.(*APISearchResult), cc.intParam(cmd, "offset", 0),
+		cc.intParam(cmd, "limit", ConsolePageSize))
+}
+
+/*
+resultPage returns a page of a query result.
+*/
+func (cc *consoleConn) resultPage(resID string, sres *APISearchResult, offset int, limit int) (map[string]interface{}, error) {
+
+	rows := sres.Rows()
+	srcs := sres.RowSources()
+	header := sres.Header()
+
+	if offset < 0 || (offset > 0 && offset >= len(rows)) {
+		return nil, fmt.Errorf("Offset exceeds available rows")
+	}
+
+	end := len(rows)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	rows = rows[offset:end]
+	srcs = srcs[offset:end]
+
+	if cc.proj != nil {
+		rows = projectRows(cc.proj, header.Data(), rows, srcs)
+	}
+
+	if api.KeyObfuscation != nil {
+		rows, srcs = externalRows(header.Data(), rows, srcs)
+	}
+
+	return map[string]interface{}{
+		"result_id": resID,
+		"total":     sres.RowCount(),
+		"offset":    offset,
+		"header": map[string]interface{}{
+			"labels":       header.Labels(),
+			"format":       header.Format(),
+			"data":         header.Data(),
+			"primary_kind": header.PrimaryKind(),
+		},
+		"rows":    rows,
+		"sources": srcs,
+	}, nil
+}
+
+/*
+describe returns information about the datastore or a given kind.
+*/
+func (cc *consoleConn) describe(cmd map[string]interface{}) (map[string]interface{}, error) {
+
+	if kind, ok := cmd["kind"].(string); ok && kind != "" {
+
+		na := api.GM.NodeAttrs(kind)
+		ea := api.GM.EdgeAttrs(kind)
+
+		if len(na) == 0 && len(ea) == 0 {
+			return nil, fmt.Errorf("Unknown node kind %v", kind)
+		}
+
+		return map[string]interface{}{
+			"kind":       kind,
+			"node_attrs": na,
+			"node_edges": api.GM.NodeEdges(kind),
+			"edge_attrs": ea,
+		}, nil
+	}
+
+	nks := api.GM.NodeKinds()
+	ncs := make(map[string]uint64)
+	for _, nk := range nks {
+		ncs[nk] = api.GM.NodeCount(nk)
+	}
+
+	eks := api.GM.EdgeKinds()
+	ecs := make(map[string]uint64)
+	for _, ek := range eks {
+		ecs[ek] = api.GM.EdgeCount(ek)
+	}
+
+	return map[string]interface{}{
+		"partitions":  api.GM.Partitions(),
+		"node_kinds":  nks,
+		"node_counts": ncs,
+		"edge_kinds":  eks,
+		"edge_counts": ecs,
+	}, nil
+}
+
+/*
+partition returns the partition of a command.
+*/
+func (cc *consoleConn) partition(cmd map[string]interface{}) string {
+	if part, ok := cmd["partition"].(string); ok && part != "" {
+		return part
+	}
+	return cc.partID
+}
+
+/*
+intParam returns an integer parameter of a command.
+*/
+func (cc *consoleConn) intParam(cmd map[string]interface{}, name string, def int) int {
+	if val, ok := cmd[name]; ok {
+		if i, err := strconv.Atoi(fmt.Sprint(val)); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+/*
+write writes a message to the client.
+*/
+func (cc *consoleConn) write(id string, msgType string, payload map[string]interface{}) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"type":    msgType,
+		"payload": payload,
+	})
+
+	cc.wlock.Lock()
+	defer cc.wlock.Unlock()
+
+	cc.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ce *consoleEndpoint) SwaggerDefs(s map[string]interface{}) {
+	// No swagger definitions for this endpoint as it only handles websocket requests
+}
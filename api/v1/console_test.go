@@ -0,0 +1,159 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+consoleCmd sends a console command and returns the response. Progress messages
+are skipped.
+*/
+func consoleCmd(c *websocket.Conn, cmd string) (map[string]interface{}, error) {
+	var res map[string]interface{}
+
+	if err := c.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+		return nil, err
+	}
+
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		res = nil
+		json.Unmarshal(msg, &res)
+
+		if res["type"] != "progress" {
+			return res, nil
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	queryURL := "ws://localhost" + TESTPORT + EndpointConsole
+
+	oldGM := api.GM
+	oldInterval := ConsoleProgressInterval
+	defer func() {
+		api.GM = oldGM
+		ConsoleProgressInterval = oldInterval
+	}()
+
+	api.GM, _ = songGraph()
+	ConsoleProgressInterval = time.Millisecond
+
+	c, _, err := websocket.DefaultDialer.Dial(queryURL+"main", nil)
+	if err != nil {
+		t.Error("Could not open websocket:", err)
+		return
+	}
+	defer c.Close()
+
+	_, msg, err := c.ReadMessage()
+	if err != nil || string(msg) != `{"id":"","payload":{"partition":"main"},"type":"ready"}` {
+		t.Error("Unexpected response:", string(msg), err)
+		return
+	}
+
+	// Check errors
+
+	res, err := consoleCmd(c, `{"id":"1","cmd":"foo"}`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id:1 payload:map[message:Unknown command: foo] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, `{"id":"1"`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id: payload:map[message:Could not decode command: unexpected end of JSON input] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, `{"id":"2","cmd":"query","query":"get"}`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id:2 payload:map[message:Parse error in Main query: Unexpected end] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	// Run a query and page through the result
+
+	res, err = consoleCmd(c, `{"id":"3","cmd":"query","query":"get Song","limit":2}`)
+
+	if err != nil || res["type"] != "result" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	payload := res["payload"].(map[string]interface{})
+	resID := payload["result_id"]
+
+	if payload["total"] != 9.0 || len(payload["rows"].([]interface{})) != 2 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	res, err = consoleCmd(c, fmt.Sprintf(`{"id":"4","cmd":"page","result_id":"%v","offset":8,"limit":2}`, resID))
+	payload = res["payload"].(map[string]interface{})
+
+	if err != nil || payload["offset"] != 8.0 || len(payload["rows"].([]interface{})) != 1 {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, fmt.Sprintf(`{"id":"5","cmd":"page","result_id":"%v","offset":9}`, resID))
+	if res := fmt.Sprint(res); err != nil || res != "map[id:5 payload:map[message:Offset exceeds available rows] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, `{"id":"6","cmd":"page","result_id":"foo"}`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id:6 payload:map[message:Unknown result ID: foo] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	// Describe kinds
+
+	res, err = consoleCmd(c, `{"id":"7","cmd":"describe"}`)
+	if res := fmt.Sprint(res["payload"].(map[string]interface{})["node_kinds"]); err != nil || res != "[Author Song Spam]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, `{"id":"8","cmd":"describe","kind":"Author"}`)
+	if res := fmt.Sprint(res["payload"]); err != nil || res != "map[edge_attrs:<nil> kind:Author node_attrs:[desc key kind name] node_edges:[Author:Wrote:Song:Song]]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	res, err = consoleCmd(c, `{"id":"9","cmd":"describe","kind":"foo"}`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id:9 payload:map[message:Unknown node kind foo] type:error]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+
+	// Close the connection
+
+	res, err = consoleCmd(c, `{"id":"10","cmd":"close"}`)
+	if res := fmt.Sprint(res); err != nil || res != "map[id:10 payload:map[] type:closed]" {
+		t.Error("Unexpected response:", res, err)
+		return
+	}
+}
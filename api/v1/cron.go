@@ -0,0 +1,189 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+cronShortcuts are the supported shortcuts for common cron schedules.
+*/
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+/*
+cronRanges are the allowed values of the fields of a cron schedule
+(minute, hour, day of month, month and day of week).
+*/
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+/*
+cronSpec is a parsed cron schedule. Each field is a bit set of the allowed
+values.
+*/
+type cronSpec struct {
+	fields  [5]uint64 // Allowed minutes, hours, days of month, months and days of week
+	anyDay  bool      // Flag if the day of month field is *
+	anyWDay bool      // Flag if the day of week field is *
+}
+
+/*
+parseCron parses a cron schedule with the fields minute, hour, day of month,
+month and day of week (e.g. 30 6 * * 1-5). Fields can contain lists, ranges
+and steps (e.g. 0,30 or 8-18/2 - a step can also follow a *). If day of month
+and day of week are both restricted then a day matches if either field matches.
+*/
+func parseCron(spec string) (*cronSpec, error) {
+
+	if s, ok := cronShortcuts[strings.TrimSpace(spec)]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Cron schedule must have 5 fields: %v", spec)
+	}
+
+	ret := &cronSpec{anyDay: fields[2] == "*", anyWDay: fields[4] == "*"}
+
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronRanges[i][0], cronRanges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron field %v: %v", field, err)
+		}
+
+		ret.fields[i] = bits
+	}
+
+	// Sunday can be given as 0 or 7
+
+	if ret.fields[4]&(1<<7) != 0 {
+		ret.fields[4] |= 1
+	}
+
+	return ret, nil
+}
+
+/*
+parseCronField parses a single field of a cron schedule.
+*/
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var ret uint64
+
+	for _, part := range strings.Split(field, ",") {
+		var err error
+
+		step := 1
+		from, to := min, max
+
+		if i := strings.Index(part, "/"); i != -1 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step")
+			}
+			part = part[:i]
+		}
+
+		if part != "*" {
+			rng := strings.SplitN(part, "-", 2)
+
+			if from, err = strconv.Atoi(rng[0]); err != nil {
+				return 0, fmt.Errorf("invalid value")
+			}
+
+			if len(rng) == 2 {
+				if to, err = strconv.Atoi(rng[1]); err != nil {
+					return 0, fmt.Errorf("invalid value")
+				}
+			} else if step == 1 {
+				to = from
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("value out of range %v-%v", min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			ret |= 1 << uint(v)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+matches checks if a given time (with minute precision) matches the schedule.
+*/
+func (c *cronSpec) matches(t time.Time) bool {
+
+	if !c.has(0, t.Minute()) || !c.has(1, t.Hour()) || !c.has(3, int(t.Month())) {
+		return false
+	}
+
+	return c.matchesDay(t)
+}
+
+/*
+matchesDay checks if the day of a given time matches the schedule.
+*/
+func (c *cronSpec) matchesDay(t time.Time) bool {
+	day, wday := c.has(2, t.Day()), c.has(4, int(t.Weekday()))
+
+	if c.anyDay || c.anyWDay {
+		return day && wday
+	}
+
+	return day || wday
+}
+
+/*
+has checks if a field of the schedule allows a given value.
+*/
+func (c *cronSpec) has(field int, v int) bool {
+	return c.fields[field]&(1<<uint(v)) != 0
+}
+
+/*
+next returns the first time after a given time which matches the schedule.
+Returns the zero time if there is no such time within the next 5 years.
+*/
+func (c *cronSpec) next(t time.Time) time.Time {
+
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		y, m, d := t.Date()
+
+		if !c.has(3, int(m)) {
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !c.matchesDay(t) {
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		} else if !c.has(1, t.Hour()) {
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+		} else if !c.has(0, t.Minute()) {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,356 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/databases"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/quota"
+)
+
+/*
+EndpointDatabases is the databases endpoint URL (rooted). Handles everything under databases/...
+*/
+const EndpointDatabases = api.APIRoot + APIv1 + "/databases/"
+
+/*
+Databases is the database manager which is managed by the databases endpoint
+(nil if multiple databases are disabled).
+*/
+var Databases *databases.Manager
+
+/*
+DatabasesEndpointInst creates a new endpoint handler.
+*/
+func DatabasesEndpointInst() api.RestEndpointHandler {
+	return &databasesEndpoint{}
+}
+
+/*
+Handler object for database operations.
+*/
+type databasesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all databases or a single database with its number of
+stored nodes and its storage usage.
+*/
+func (de *databasesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret []map[string]interface{}
+
+	if !checkResources(w, resources, 0, 1, "") || !checkDatabases(w) {
+		return
+	}
+
+	list := Databases.Databases()
+
+	if len(resources) == 1 {
+		if db, _ := Databases.Database(resources[0]); db != nil {
+			list = []*databases.Database{db}
+		} else {
+			http.Error(w, "Unknown database: "+resources[0], http.StatusNotFound)
+			return
+		}
+	}
+
+	ret = make([]map[string]interface{}, 0, len(list))
+
+	for _, db := range list {
+		var usage *quota.Usage
+
+		if qm := Databases.Quota(db.Name); qm != nil {
+			var err error
+
+			if usage, err = qm.Usage(quota.AllPartitions); err != nil {
+				api.ReportError(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ret = append(ret, map[string]interface{}{
+			"name":                    db.Name,
+			"users":                   db.Users,
+			"max_nodes":               db.MaxNodes,
+			"max_edges":               db.MaxEdges,
+			"max_bytes":               db.MaxBytes,
+			"max_requests_per_minute": db.MaxRequestsPerMinute,
+			"nodes":                   Databases.NodeCount(db.Name),
+			"usage":                   usage,
+			"root":                    api.DatabaseRoot(db.Name),
+		})
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(ret)
+	} else {
+		json.NewEncoder(w).Encode(ret[0])
+	}
+}
+
+/*
+HandlePUT stores a database.
+*/
+func (de *databasesEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	de.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST creates a new database or changes the users and quotas of an
+existing database.
+*/
+func (de *databasesEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	db := &databases.Database{}
+
+	if !checkResources(w, resources, 1, 1, "Need a database name") || !checkDatabases(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(db); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db.Name = resources[0]
+
+	if err := db.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Databases.StoreDatabase(db); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	api.RegisterDatabase(db.Name)
+}
+
+/*
+HandleDELETE removes a database and all its data.
+*/
+func (de *databasesEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a database name") || !checkDatabases(w) {
+		return
+	}
+
+	ok, err := Databases.RemoveDatabase(resources[0])
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if !ok {
+		http.Error(w, "Unknown database: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+OpenDatabase returns the GraphManager of a database for a request. Checks that
+the user of the request may access the database and that the request quota of
+the database is not exceeded.
+*/
+func OpenDatabase(w http.ResponseWriter, r *http.Request, name string) *graph.Manager {
+
+	if !checkDatabases(w) {
+		return nil
+	}
+
+	db, gm := Databases.Database(name)
+
+	if db == nil {
+		http.Error(w, "Unknown database: "+name, http.StatusNotFound)
+		return nil
+	}
+
+	if api.RequestUser != nil {
+		if user := api.RequestUser(r); !db.Allows(user) {
+			http.Error(w, fmt.Sprintf("User %v may not access database %v", user, name), http.StatusForbidden)
+			return nil
+		}
+	}
+
+	if !Databases.Allow(name) {
+		http.Error(w, fmt.Sprintf("Request quota of database %v exceeded", name), http.StatusTooManyRequests)
+		return nil
+	}
+
+	return gm
+}
+
+/*
+checkDatabases checks if multiple databases are enabled. Writes an error and
+returns false if they are not.
+*/
+func checkDatabases(w http.ResponseWriter) bool {
+	if Databases == nil {
+		http.Error(w, "Multiple databases are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (de *databasesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the database.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	databaseParams := append(nameParams, map[string]interface{}{
+		"name":        "database",
+		"in":          "body",
+		"description": "Users and quotas of the database.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Database",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/databases"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all databases.",
+			"description": "All databases are returned with their number of stored nodes.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of databases.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Database",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/databases/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a database.",
+			"description": "A database is returned with its number of stored nodes.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Database.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Database",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store a database.",
+			"description": "A new database is created with an empty datastore. The users and " +
+				"quotas of an existing database are replaced. The REST API of a database is " +
+				"available under /db/{name}/api/v1/.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": databaseParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The database was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a database.",
+			"description": "The database and all its data are removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The database was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Database"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"users": map[string]interface{}{
+				"description": "Users who may access the database (all users if empty).",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"max_nodes": map[string]interface{}{
+				"description": "Maximum number of stored nodes (no limit if 0).",
+				"type":        "integer",
+			},
+			"max_edges": map[string]interface{}{
+				"description": "Maximum number of stored edges (no limit if 0).",
+				"type":        "integer",
+			},
+			"max_bytes": map[string]interface{}{
+				"description": "Maximum estimated size of the stored data in bytes (no limit if 0).",
+				"type":        "integer",
+			},
+			"max_requests_per_minute": map[string]interface{}{
+				"description": "Maximum number of requests per minute (no limit if 0).",
+				"type":        "integer",
+			},
+			"nodes": map[string]interface{}{
+				"description": "Number of stored nodes (only returned).",
+				"type":        "integer",
+			},
+			"usage": map[string]interface{}{
+				"description": "Number of nodes and edges and estimated size of the stored data (only returned).",
+				"type":        "object",
+			},
+			"root": map[string]interface{}{
+				"description": "Root of the REST API of the database (only returned).",
+				"type":        "string",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
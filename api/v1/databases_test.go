@@ -0,0 +1,249 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/databases"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestDatabases(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointDatabases
+	dbURL := "http://localhost" + TESTPORT + api.APIRoot
+
+	defer func() {
+		Databases = nil
+		api.OpenDatabase = nil
+		api.RequestUser = nil
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Multiple databases are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	open := func(name string) (graphstorage.Storage, error) {
+		return graphstorage.NewMemoryGraphStorage(name), nil
+	}
+
+	remove := func(name string) error {
+		return nil
+	}
+
+	dm, err := databases.NewManager(graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("dbtest")),
+		api.SystemPartition, open, remove)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	Databases = dm
+	api.OpenDatabase = OpenDatabase
+	api.RegisterDatabaseEndpoints(V1DatabaseEndpointMap)
+
+	st, _, res = sendTestRequest(queryURL+"v1", "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Reserved database name: v1" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"tenant1", "POST", []byte("{"))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"tenant1", "POST", []byte(`{"max_nodes": 1}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"tenant2", "PUT", []byte(`{"max_requests_per_minute": 1}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != `[
+  {
+    "max_bytes": 0,
+    "max_edges": 0,
+    "max_nodes": 1,
+    "max_requests_per_minute": 0,
+    "name": "tenant1",
+    "nodes": 0,
+    "root": "/db/tenant1/api",
+    "usage": {
+      "nodes": 0,
+      "edges": 0,
+      "bytes": 0
+    },
+    "users": null
+  },
+  {
+    "max_bytes": 0,
+    "max_edges": 0,
+    "max_nodes": 0,
+    "max_requests_per_minute": 1,
+    "name": "tenant2",
+    "nodes": 0,
+    "root": "/db/tenant2/api",
+    "usage": {
+      "nodes": 0,
+      "edges": 0,
+      "bytes": 0
+    },
+    "users": null
+  }
+]` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown database: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Store a node in a database
+
+	tenant1URL := dbURL + "/tenant1/api" + APIv1
+
+	st, _, res = sendTestRequest(tenant1URL+"/graph/main/n", "POST", []byte(`[{"key":"1","kind":"Tenant"}]`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	_, tgm := Databases.Database("tenant1")
+
+	if n, _ := tgm.FetchNode("main", "1", "Tenant"); n == nil {
+		t.Error("Node should be stored in the database")
+		return
+	}
+
+	if n, _ := api.GM.FetchNode("main", "1", "Tenant"); n != nil {
+		t.Error("Node should not be stored in the main datastore:", n)
+		return
+	}
+
+	st, _, res = sendTestRequest(tenant1URL+"/query/main?q=get+Tenant", "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"/db/tenant1/api/v1/graph/main/n/Tenant/1"`) &&
+		!strings.Contains(res, `"1"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(tenant1URL+"/info", "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"Tenant": 1`) || !strings.Contains(res, `"max_nodes": 1`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The node quota of the database is enforced
+
+	st, _, res = sendTestRequest(tenant1URL+"/graph/main/n", "POST", []byte(`[{"key":"2","kind":"Tenant"}]`))
+
+	if st != "507 Insufficient Storage" || !strings.Contains(res, "Quota exceeded: Limit of 1 nodes reached") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Only some endpoints are available for databases
+
+	st, _, res = sendTestRequest(tenant1URL+"/admin/", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown endpoint: /db/tenant1/api/v1/admin/" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The request quota of the database is enforced
+
+	tenant2URL := dbURL + "/tenant2/api" + APIv1
+
+	st, _, res = sendTestRequest(tenant2URL+"/info", "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(tenant2URL+"/info", "GET", nil)
+
+	if st != "429 Too Many Requests" || res != "Request quota of database tenant2 exceeded" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Databases can be restricted to users
+
+	api.RequestUser = func(r *http.Request) string {
+		return "elias"
+	}
+
+	st, _, res = sendTestRequest(queryURL+"tenant1", "POST", []byte(`{"users": ["johndoe"]}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(tenant1URL+"/info", "GET", nil)
+
+	if st != "403 Forbidden" || res != "User elias may not access database tenant1" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove a database
+
+	st, _, res = sendTestRequest(queryURL+"tenant1", "DELETE", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"tenant1", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown database: tenant1" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(tenant1URL+"/info", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown database: tenant1" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -65,7 +65,7 @@ func (e *eqlEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resourc
 			resast, err := eql.ParseQuery("request", fmt.Sprint(query))
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				api.ReportError(w, r, err, http.StatusBadRequest)
 				return
 			}
 
@@ -86,7 +86,7 @@ func (e *eqlEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resourc
 			astnode, err := parser.ASTFromPlain(astmap)
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				api.ReportError(w, r, err, http.StatusBadRequest)
 				return
 			}
 
@@ -95,7 +95,7 @@ func (e *eqlEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resourc
 			ppres, err := parser.PrettyPrint(astnode)
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				api.ReportError(w, r, err, http.StatusBadRequest)
 				return
 			}
 
@@ -47,6 +47,7 @@ HandleGET handles a search query REST call.
 func (ie *findEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
 	var err error
 
+	gm := api.RequestGM(r)
 	ret := make(map[string]map[string][]interface{})
 
 	// Check what is queried
@@ -60,10 +61,11 @@ func (ie *findEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 	}
 
 	lookup := stringutil.IsTrueValue(r.URL.Query().Get("lookup"))
+	proj := api.ResponseProjection.ForRequest(r)
 	part := r.URL.Query().Get("part")
 
-	parts := api.GM.Partitions()
-	kinds := api.GM.NodeKinds()
+	parts := gm.Partitions()
+	kinds := gm.NodeKinds()
 
 	if part != "" && stringutil.IndexOf(part, parts) == -1 {
 		err = fmt.Errorf("Partition %s does not exist", part)
@@ -97,11 +99,11 @@ func (ie *findEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 				// NodeIndexQuery may return nil nil if the node kind does not exist
 				// in a partition
 
-				if iq, err = api.GM.NodeIndexQuery(p, k); err == nil && iq != nil {
+				if iq, err = gm.NodeIndexQuery(p, k); err == nil && iq != nil {
 
 					// Go through all known attributes of the node kind
 
-					for _, attr := range api.GM.NodeAttrs(k) {
+					for _, attr := range gm.NodeAttrs(k) {
 						var keys []string
 
 						// Run the lookup on all attributes
@@ -120,12 +122,12 @@ func (ie *findEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 							if _, ok := nodeMap[key]; !ok && err == nil {
 
 								if lookup {
-									if node, err = api.GM.FetchNode(p, key, k); node != nil {
-										nodeMap[key] = node.Data()
+									if node, err = gm.FetchNode(p, key, k); node != nil {
+										nodeMap[key] = jsonData(proj.Data(node.Data()))
 									}
 								} else {
 									nodeMap[key] = map[string]interface{}{
-										data.NodeKey:  key,
+										data.NodeKey:  api.ExternalKey(k, key),
 										data.NodeKind: k,
 									}
 								}
@@ -148,7 +150,7 @@ func (ie *findEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 	// Check if there was an error
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.ReportError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
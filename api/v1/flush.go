@@ -0,0 +1,86 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+EndpointFlush is the flush endpoint URL (rooted).
+*/
+const EndpointFlush = api.APIRoot + APIv1 + "/flush/"
+
+/*
+FlushEndpointInst creates a new endpoint handler.
+*/
+func FlushEndpointInst() api.RestEndpointHandler {
+	return &flushEndpoint{}
+}
+
+/*
+Handler object for flush operations.
+*/
+type flushEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandlePOST writes all pending changes to disk and waits until all prior
+commits are durable.
+*/
+func (fe *flushEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	flushAndSync(w, r)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (fe *flushEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/flush"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Make all prior commits durable.",
+			"description": "Writes all pending changes to disk and waits until all prior " +
+				"commits have been synced - regardless of the durability mode of the " +
+				"datastore. The response contains the duration of the flush in milliseconds.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "All prior commits are durable.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,34 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlush(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointFlush
+
+	st, _, res := sendTestRequest(queryURL, "POST", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"synced": true`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "405 Method Not Allowed" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+isBlobRequest checks if the resources of a graph request address a blob
+(/graph/<partition>/n/<kind>/<key>/blob/<attr>).
+*/
+func isBlobRequest(resources []string) bool {
+	return len(resources) == 6 && resources[1] == "n" && resources[4] == "blob"
+}
+
+/*
+handleBlobGET streams a blob to the client. Range requests are supported.
+*/
+func (ge *graphEndpoint) handleBlobGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	key, err := api.InternalKey(resources[2], resources[3])
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	br, err := api.RequestGM(r).OpenBlob(resources[0], key, resources[2], resources[5])
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if br == nil {
+		http.Error(w, "Unknown node or blob attribute", http.StatusNotFound)
+		return
+	}
+
+	if br.Ref.ContentType != "" {
+		w.Header().Set("content-type", br.Ref.ContentType)
+	} else {
+		w.Header().Set("content-type", "application/octet-stream")
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, br)
+}
+
+/*
+handleBlobStore stores the request body as a blob.
+*/
+func (ge *graphEndpoint) handleBlobStore(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	key, err := api.InternalKey(resources[2], resources[3])
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	ref, err := api.RequestGM(r).StoreBlob(resources[0], key, resources[2], resources[5],
+		r.Header.Get("content-type"), r.Body)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ref)
+}
+
+/*
+handleBlobDELETE removes a blob.
+*/
+func (ge *graphEndpoint) handleBlobDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	key, err := api.InternalKey(resources[2], resources[3])
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	ref, err := api.RequestGM(r).RemoveBlob(resources[0], key, resources[2], resources[5])
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if ref == nil {
+		http.Error(w, "Unknown node or blob attribute", http.StatusNotFound)
+		return
+	}
+}
+
+/*
+blobSwaggerDefs describes the blob endpoint in swagger.
+*/
+func (ge *graphEndpoint) blobSwaggerDefs(s map[string]interface{}, params []map[string]interface{},
+	defaultError map[string]interface{}) {
+
+	params = append(params, map[string]interface{}{
+		"name":        "attr",
+		"in":          "path",
+		"description": "Node attribute which holds the blob.",
+		"required":    true,
+		"type":        "string",
+	})
+
+	storeBlob := func(method string) map[string]interface{} {
+		return map[string]interface{}{
+			"summary":     "Store a blob.",
+			"description": "The request body is stored as blob in the given node attribute (" + method + "). The node must exist. An existing blob is replaced.",
+			"consumes": []string{
+				"*/*",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Size and content type of the stored blob.",
+				},
+				"default": defaultError,
+			},
+		}
+	}
+
+	s["paths"].(map[string]interface{})["/v1/graph/{partition}/n/{kind}/{key}/blob/{attr}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Download a blob.",
+			"description": "Return the blob of a node attribute. Range requests are supported.",
+			"produces": []string{
+				"text/plain",
+				"*/*",
+			},
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The blob data.",
+				},
+				"206": map[string]interface{}{
+					"description": "The requested range of the blob data.",
+				},
+				"default": defaultError,
+			},
+		},
+		"put":  storeBlob("PUT"),
+		"post": storeBlob("POST"),
+		"delete": map[string]interface{}{
+			"summary":     "Delete a blob.",
+			"description": "Remove the blob and the node attribute which holds it.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the blob was deleted.",
+				},
+				"default": defaultError,
+			},
+		},
+	}
+}
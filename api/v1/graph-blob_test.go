@@ -0,0 +1,162 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+func TestGraphBlob(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	blobURL := queryURL + "main/n/BlobTest/b1/blob/image"
+
+	// Node must exist
+
+	st, _, res := sendTestRequest(blobURL, "PUT", []byte("foo"))
+
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Node b1 of kind BlobTest does not exist in partition main)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "b1")
+	node.SetAttr(data.NodeKind, "BlobTest")
+	api.GM.StoreNode("main", node)
+
+	defer api.GM.RemoveNode("main", "b1", "BlobTest")
+
+	req, _ := http.NewRequest("PUT", blobURL, bytes.NewBufferString("0123456789"))
+	req.Header.Set("Content-Type", "image/png")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != `{"size":10,"content_type":"image/png"}`+"\n" {
+		t.Error("Unexpected response:", resp.Status, string(body))
+		return
+	}
+
+	// The node contains only a reference
+
+	st, _, res = sendTestRequest(queryURL+"main/n/BlobTest/b1", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "image": {
+    "size": 10,
+    "content_type": "image/png"
+  },
+  "key": "b1",
+  "kind": "BlobTest"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Download the blob
+
+	resp, err = http.Get(blobURL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" ||
+		resp.Header.Get("Content-Type") != "image/png" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Error("Unexpected response:", resp.Status, resp.Header, string(body))
+		return
+	}
+
+	// Range requests
+
+	req, _ = http.NewRequest("GET", blobURL, nil)
+	req.Header.Set("Range", "bytes=2-5")
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "2345" ||
+		resp.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Error("Unexpected response:", resp.Status, resp.Header, string(body))
+		return
+	}
+
+	// Unknown blobs
+
+	st, _, res = sendTestRequest(queryURL+"main/n/BlobTest/b1/blob/foo", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown node or blob attribute" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Delete the blob
+
+	st, _, res = sendTestRequest(blobURL, "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(blobURL, "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown node or blob attribute" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(blobURL, "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown node or blob attribute" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Read-only mode
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	for _, method := range []string{"PUT", "POST", "DELETE"} {
+		st, _, res = sendTestRequest(blobURL, method, []byte("foo"))
+
+		if st != "403 Forbidden" || res != "Datastore is read-only" {
+			t.Error("Unexpected response:", method, st, res)
+			return
+		}
+	}
+}
@@ -16,10 +16,13 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/krotik/common/datautil"
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
 )
 
 /*
@@ -27,24 +30,63 @@ EndpointGraph is the graph endpoint URL (rooted). Handles everything under graph
 */
 const EndpointGraph = api.APIRoot + APIv1 + "/graph/"
 
+/*
+SnapshotCacheMaxSize is the maximum number of node key snapshots which are
+kept for paged node lists
+*/
+var SnapshotCacheMaxSize uint64 = 100
+
+/*
+SnapshotCacheMaxAge is the maximum age a node key snapshot can have in seconds
+*/
+var SnapshotCacheMaxAge int64 = 300
+
+/*
+SnapshotCache is a cache for node key snapshots of paged node lists
+*/
+var SnapshotCache *datautil.MapCache
+
 /*
 GraphEndpointInst creates a new endpoint handler.
 */
 func GraphEndpointInst() api.RestEndpointHandler {
+
+	// Init the snapshot cache if necessary
+
+	if SnapshotCache == nil {
+		SnapshotCache = datautil.NewMapCache(SnapshotCacheMaxSize, SnapshotCacheMaxAge)
+	}
+
 	return &graphEndpoint{}
 }
 
+/*
+nodeKeySnapshot is a cached snapshot of all node keys of a kind.
+*/
+type nodeKeySnapshot struct {
+	part string   // Partition of the snapshot
+	kind string   // Node kind of the snapshot
+	keys []string // Node keys of the snapshot
+}
+
 /*
 Handler object for graph operations.
 */
 type graphEndpoint struct {
 	*api.DefaultEndpointHandler
+	maxRows int // Maximum number of returned nodes or traversal results (0 for no maximum)
 }
 
 /*
 HandleGET handles REST calls to retrieve data from the graph database.
 */
 func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	gm := api.RequestGM(r)
+
+	if isBlobRequest(resources) {
+		ge.handleBlobGET(w, r, resources)
+		return
+	}
 
 	// Check parameters
 
@@ -57,6 +99,8 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	proj := api.ResponseProjection.ForRequest(r)
+
 	if len(resources) == 3 {
 
 		// Iterate over a list of nodes
@@ -70,6 +114,10 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				return
 			}
 
+			if ge.maxRows > 0 && (limit == -1 || limit > ge.maxRows) {
+				limit = ge.maxRows
+			}
+
 			// Get offset parameter; -1 if not set
 
 			offset, ok := queryParamPosNum(w, r, "offset")
@@ -77,72 +125,159 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				return
 			}
 
-			it, err := api.GM.NodeKeyIterator(resources[0], resources[2])
+			var err error
+			var it *graph.NodeKeyIterator
+			var snapshot *nodeKeySnapshot
+			var snapshotID string
+
+			switch sp := r.URL.Query().Get("snapshot"); sp {
+
+			case "":
+
+				// Page over the live data - nodes which are stored or removed
+				// between requests might shift the pages
+
+				it, err = gm.NodeKeyIterator(resources[0], resources[2])
+
+			case "true":
+
+				// Take a new snapshot which can be used by subsequent requests
+
+				if it, err = gm.NodeKeySnapshotIterator(resources[0], resources[2]); err == nil && it != nil {
+					snapshot = &nodeKeySnapshot{resources[0], resources[2], make([]string, 0)}
+
+					for it.HasNext() {
+						snapshot.keys = append(snapshot.keys, it.Next())
+					}
+
+					snapshotID = genID()
+					SnapshotCache.Put(snapshotID, snapshot)
+				}
+
+			default:
+
+				res, ok := SnapshotCache.Get(sp)
+				if ok {
+					snapshot = res.(*nodeKeySnapshot)
+				}
+
+				if !ok || snapshot.part != resources[0] || snapshot.kind != resources[2] {
+					http.Error(w, "Unknown snapshot ID (snapshot parameter)", http.StatusBadRequest)
+					return
+				}
+
+				snapshotID = sp
+			}
+
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.ReportError(w, r, err, http.StatusInternalServerError)
 				return
-			} else if it == nil {
+			} else if it == nil && snapshot == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
 				return
 			}
 
-			i := 0
+			var keys []string
 
-			if offset != -1 {
+			if qkeys, ok := r.URL.Query()["key"]; ok {
 
-				for i = 0; i < offset; i++ {
-					if !it.HasNext() {
-						http.Error(w, "Offset exceeds available nodes", http.StatusInternalServerError)
-						return
-					}
+				// Only the nodes with the given keys are returned
 
-					if it.Next(); it.LastError != nil {
-						http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
+				for _, qkey := range qkeys {
+					key, err := api.InternalKey(resources[2], qkey)
+					if err != nil {
+						api.ReportError(w, r, err, http.StatusBadRequest)
 						return
 					}
+					keys = append(keys, key)
 				}
 
-			} else {
+			} else if snapshot != nil {
 
-				offset = 0
-			}
+				// Pages of a snapshot can be sliced directly
 
-			var data []interface{}
+				if offset == -1 {
+					offset = 0
+				} else if offset > len(snapshot.keys) {
+					http.Error(w, "Offset exceeds available nodes", http.StatusInternalServerError)
+					return
+				}
+
+				keys = snapshot.keys[offset:]
+				if limit != -1 && limit < len(keys) {
+					keys = keys[:limit]
+				}
 
-			if limit == -1 {
-				data = make([]interface{}, 0)
 			} else {
-				data = make([]interface{}, 0, limit)
-			}
 
-			for i = offset; it.HasNext(); i++ {
+				i := 0
+
+				if offset != -1 {
 
-				// Break out if the limit was reached
+					for i = 0; i < offset; i++ {
+						if !it.HasNext() {
+							http.Error(w, "Offset exceeds available nodes", http.StatusInternalServerError)
+							return
+						}
 
-				if limit != -1 && i > offset+limit-1 {
-					break
+						if it.Next(); it.LastError != nil {
+							http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
+							return
+						}
+					}
+
+				} else {
+
+					offset = 0
 				}
 
-				key := it.Next()
+				for i = offset; it.HasNext(); i++ {
 
-				if it.LastError != nil {
-					http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
-					return
+					// Break out if the limit was reached
+
+					if limit != -1 && i > offset+limit-1 {
+						break
+					}
+
+					key := it.Next()
+
+					if it.LastError != nil {
+						http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
+						return
+					}
+
+					keys = append(keys, key)
 				}
+			}
+
+			data := make([]interface{}, 0, len(keys))
 
-				node, err := api.GM.FetchNode(resources[0], key, resources[2])
+			for _, key := range keys {
+
+				node, err := gm.FetchNode(resources[0], key, resources[2])
 
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					api.ReportError(w, r, err, http.StatusInternalServerError)
 					return
+				} else if node == nil {
+
+					// Node was removed after the snapshot was taken
+					// or a requested key does not exist
+
+					continue
 				}
 
-				data = append(data, node.Data())
+				data = append(data, jsonData(proj.Data(node.Data())))
 			}
 
 			// Set total count header
 
-			w.Header().Add(HTTPHeaderTotalCount, strconv.FormatUint(api.GM.NodeCount(resources[2]), 10))
+			if snapshot != nil {
+				w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(len(snapshot.keys)))
+				w.Header().Add(HTTPHeaderSnapshotID, snapshotID)
+			} else {
+				w.Header().Add(HTTPHeaderTotalCount, strconv.FormatUint(gm.NodeCount(resources[2]), 10))
+			}
 
 			// Write data
 
@@ -162,35 +297,58 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 		var data map[string]interface{}
 
+		key, err := api.InternalKey(resources[2], resources[3])
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
 		if resources[1] == "n" {
 
-			node, err := api.GM.FetchNode(resources[0], resources[3], resources[2])
+			node, err := gm.FetchNode(resources[0], key, resources[2])
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.ReportError(w, r, err, http.StatusInternalServerError)
 				return
 			} else if node == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
 				return
 			}
 
-			data = node.Data()
+			data = jsonData(proj.Data(node.Data()))
 
 		} else {
 
-			edge, err := api.GM.FetchEdge(resources[0], resources[3], resources[2])
+			edge, err := gm.FetchEdge(resources[0], key, resources[2])
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.ReportError(w, r, err, http.StatusInternalServerError)
 				return
 			} else if edge == nil {
 				http.Error(w, "Unknown partition or edge kind", http.StatusBadRequest)
 				return
 			}
 
-			data = edge.Data()
+			data = jsonData(proj.Data(edge.Data()))
 		}
 
+		// Add the commit sequence number of the last write
+
+		var seq uint64
+
+		if resources[1] == "n" {
+			seq, err = gm.NodeSeq(resources[0], key, resources[2])
+		} else {
+			seq, err = gm.EdgeSeq(resources[0], key, resources[2])
+		}
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(HTTPHeaderEntitySeq, fmt.Sprint(seq))
+
 		// Write data
 
 		w.Header().Set("content-type", "application/json; charset=utf-8")
@@ -202,21 +360,27 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 		if resources[1] == "n" {
 
-			node, err := api.GM.FetchNodePart(resources[0], resources[3], resources[2], []string{"key", "kind"})
+			key, err := api.InternalKey(resources[2], resources[3])
+			if err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			node, err := gm.FetchNodePart(resources[0], key, resources[2], []string{"key", "kind"})
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.ReportError(w, r, err, http.StatusInternalServerError)
 				return
 			} else if node == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
 				return
 			}
 
-			nodes, edges, err := api.GM.TraverseMulti(resources[0], resources[3],
+			nodes, edges, err := gm.TraverseMulti(resources[0], key,
 				resources[2], resources[4], true)
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.ReportError(w, r, err, http.StatusInternalServerError)
 				return
 			}
 
@@ -229,8 +393,8 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				for i, n := range nodes {
 					e := edges[i]
 
-					dataNodes = append(dataNodes, n.Data())
-					dataEdges = append(dataEdges, e.Data())
+					dataNodes = append(dataNodes, jsonData(proj.Data(n.Data())))
+					dataEdges = append(dataEdges, jsonData(proj.Data(e.Data())))
 				}
 			}
 
@@ -241,6 +405,11 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 			sort.Stable(&traversalResultComparator{data})
 
+			if ge.maxRows > 0 && len(data[0]) > ge.maxRows {
+				data[0] = data[0][:ge.maxRows]
+				data[1] = data[1][:ge.maxRows]
+			}
+
 			// Write data
 
 			w.Header().Set("content-type", "application/json; charset=utf-8")
@@ -261,13 +430,13 @@ existing elements. Nodes are updated if they already exist. Edges are replaced
 if they already exist.
 */
 func (ge *graphEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
-	ge.handleGraphRequest(w, r, resources,
-		func(trans graph.Trans, part string, node data.Node) error {
-			return trans.UpdateNode(part, node)
-		},
-		func(trans graph.Trans, part string, edge data.Edge) error {
-			return trans.StoreEdge(part, edge)
-		})
+
+	if isBlobRequest(resources) {
+		ge.handleBlobStore(w, r, resources)
+		return
+	}
+
+	ge.handleGraphRequest(w, r, resources, transUpdateNode, transStoreEdge)
 }
 
 /*
@@ -275,37 +444,72 @@ HandlePOST handles a REST call to insert new elements into the graph or update
 existing elements. Nodes and edges are replaced if they already exist.
 */
 func (ge *graphEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
-	ge.handleGraphRequest(w, r, resources,
-		func(trans graph.Trans, part string, node data.Node) error {
-			return trans.StoreNode(part, node)
-		},
-		func(trans graph.Trans, part string, edge data.Edge) error {
-			return trans.StoreEdge(part, edge)
-		})
+
+	if isBlobRequest(resources) {
+		ge.handleBlobStore(w, r, resources)
+		return
+	}
+
+	ge.handleGraphRequest(w, r, resources, transStoreNode, transStoreEdge)
 }
 
 /*
 HandleDELETE handles a REST call to delete elements from the graph.
 */
 func (ge *graphEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
-	ge.handleGraphRequest(w, r, resources,
-		func(trans graph.Trans, part string, node data.Node) error {
-			return trans.RemoveNode(part, node.Key(), node.Kind())
-		},
-		func(trans graph.Trans, part string, edge data.Edge) error {
-			return trans.RemoveEdge(part, edge.Key(), edge.Kind())
-		})
+
+	if isBlobRequest(resources) {
+		ge.handleBlobDELETE(w, r, resources)
+		return
+	}
+
+	ge.handleGraphRequest(w, r, resources, transRemoveNode, transRemoveEdge)
 }
 
 /*
-handleGraphRequest handles a graph query REST call.
+Functions which add graph operations to a transaction
+*/
+var (
+	transStoreNode = func(trans graph.Trans, part string, node data.Node) error {
+		return trans.StoreNode(part, node)
+	}
+	transUpdateNode = func(trans graph.Trans, part string, node data.Node) error {
+		return trans.UpdateNode(part, node)
+	}
+	transRemoveNode = func(trans graph.Trans, part string, node data.Node) error {
+		return trans.RemoveNode(part, node.Key(), node.Kind())
+	}
+	transStoreEdge = func(trans graph.Trans, part string, edge data.Edge) error {
+		return trans.StoreEdge(part, edge)
+	}
+	transRemoveEdge = func(trans graph.Trans, part string, edge data.Edge) error {
+		return trans.RemoveEdge(part, edge.Key(), edge.Kind())
+	}
+)
+
+/*
+graphSummary is the response of a graph request which stores or removes nodes
+and edges. For removals the counts refer to the removed nodes and edges.
+*/
+type graphSummary struct {
+	NodesStored int      `json:"nodes_stored"` // Number of written nodes
+	EdgesStored int      `json:"edges_stored"` // Number of written edges
+	Keys        []string `json:"keys"`         // Keys of all written nodes and edges (including generated keys)
+}
+
+/*
+handleGraphRequest handles a graph query REST call. Returns a summary of the
+written nodes and edges.
 */
 func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Request, resources []string,
 	transFuncNode func(trans graph.Trans, part string, node data.Node) error,
 	transFuncEdge func(trans graph.Trans, part string, edge data.Edge) error) {
+	gm := api.RequestGM(r)
 
-	var nDataList []map[string]interface{}
-	var eDataList []map[string]interface{}
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
 
 	// Check parameters
 
@@ -313,6 +517,62 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Create a transaction
+
+	trans := graph.NewGraphTrans(gm)
+
+	summary, ok := addGraphRequest(w, r, resources, trans, transFuncNode, transFuncEdge)
+	if !ok {
+		return
+	}
+
+	// Commit transaction
+
+	if err := trans.Commit(); err != nil {
+		api.ReportError(w, r, err, commitErrorStatus(err))
+		return
+	}
+
+	setCommitSeqHeader(w, gm, []string{resources[0]})
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(summary)
+}
+
+/*
+setCommitSeqHeader sets the commit sequence numbers of changed partitions as
+response header. The sequence number of a partition covers all writes which
+were applied before the response was written.
+*/
+func setCommitSeqHeader(w http.ResponseWriter, gm *graph.Manager, parts []string) {
+	seqs := make([]string, 0, len(parts))
+
+	sort.Strings(parts)
+
+	for _, part := range parts {
+		seqs = append(seqs, fmt.Sprintf("%v=%v", part, gm.PartitionSeq(part)))
+	}
+
+	w.Header().Set(HTTPHeaderCommitSeq, strings.Join(seqs, ", "))
+}
+
+/*
+addGraphRequest decodes the nodes and edges of a graph request and adds them
+to a given transaction. The resources are a partition and an optional entity
+type. Returns a summary of the added nodes and edges. Writes an error response
+and returns false if the request could not be added.
+*/
+func addGraphRequest(w http.ResponseWriter, r *http.Request, resources []string, trans graph.Trans,
+	transFuncNode func(trans graph.Trans, part string, node data.Node) error,
+	transFuncEdge func(trans graph.Trans, part string, edge data.Edge) error) (*graphSummary, bool) {
+
+	var nDataList []map[string]interface{}
+	var eDataList []map[string]interface{}
+
+	summary := &graphSummary{0, 0, []string{}}
+
 	dec := json.NewDecoder(r.Body)
 
 	if len(resources) == 1 {
@@ -323,7 +583,7 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 
 		if err := dec.Decode(&gdata); err != nil {
 			http.Error(w, "Could not decode request body as object with list of nodes and/or edges: "+err.Error(), http.StatusBadRequest)
-			return
+			return nil, false
 		}
 
 		nDataList = gdata["nodes"]
@@ -335,7 +595,7 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 
 		if err := dec.Decode(&nDataList); err != nil {
 			http.Error(w, "Could not decode request body as list of nodes: "+err.Error(), http.StatusBadRequest)
-			return
+			return nil, false
 		}
 	} else if resources[1] == "e" {
 
@@ -343,25 +603,35 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 
 		if err := dec.Decode(&eDataList); err != nil {
 			http.Error(w, "Could not decode request body as list of edges: "+err.Error(), http.StatusBadRequest)
-			return
+			return nil, false
 		}
 	}
 
-	// Create a transaction
-
-	trans := graph.NewGraphTrans(api.GM)
-
 	if nDataList != nil {
 
 		// Store nodes in transaction
 
 		for _, ndata := range nDataList {
+
+			if err := data.UntagValues(ndata); err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return nil, false
+			}
+
+			if err := internalData(ndata); err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return nil, false
+			}
+
 			node := data.NewGraphNodeFromMap(ndata)
 
 			if err := transFuncNode(trans, resources[0], node); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+				api.ReportError(w, r, err, transErrorStatus(err))
+				return nil, false
 			}
+
+			summary.NodesStored++
+			summary.Keys = append(summary.Keys, api.ExternalKey(node.Kind(), node.Key()))
 		}
 	}
 
@@ -370,21 +640,47 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		// Store edges in transaction
 
 		for _, edata := range eDataList {
+
+			if err := data.UntagValues(edata); err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return nil, false
+			}
+
+			if err := internalData(edata); err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return nil, false
+			}
+
 			edge := data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edata))
 
 			if err := transFuncEdge(trans, resources[0], edge); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+				api.ReportError(w, r, err, transErrorStatus(err))
+				return nil, false
 			}
+
+			summary.EdgesStored++
+			summary.Keys = append(summary.Keys, api.ExternalKey(edge.Kind(), edge.Key()))
 		}
 	}
 
-	// Commit transaction
+	return summary, true
+}
 
-	if err := trans.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+/*
+transErrorStatus returns the response status for an error of a transaction
+operation. A deadlock of a locking transaction is reported as a conflict and
+a transaction which exceeds the transaction limits as too large.
+*/
+func transErrorStatus(err error) int {
+	if gerr, ok := err.(*util.GraphError); ok {
+		if gerr.Type == util.ErrDeadlock {
+			return http.StatusConflict
+		} else if gerr.Type == util.ErrTransLimit {
+			return http.StatusRequestEntityTooLarge
+		}
 	}
+
+	return http.StatusBadRequest
 }
 
 /*
@@ -442,6 +738,14 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			"type":        "number",
 			"format":      "integer",
 		},
+		{
+			"name":        "key",
+			"in":          "query",
+			"description": "Key of a node which should be returned (can be repeated). Unknown keys are ignored - " +
+				"offset and limit do not apply.",
+			"required":    false,
+			"type":        "string",
+		},
 	}
 
 	keyParam := []map[string]interface{}{
@@ -517,6 +821,32 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	summaryResponse := map[string]interface{}{
+		"description": "A summary of the written nodes and edges. The summary contains " +
+			"the number of stored (or deleted) nodes and edges and the keys of all of them. " +
+			"Nodes and edges which are stored without a key get a generated key if key generation is enabled.",
+		"schema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"nodes_stored": map[string]interface{}{
+					"description": "Number of written nodes.",
+					"type":        "integer",
+				},
+				"edges_stored": map[string]interface{}{
+					"description": "Number of written edges.",
+					"type":        "integer",
+				},
+				"keys": map[string]interface{}{
+					"description": "Keys of all written nodes and edges.",
+					"type":        "array",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
+			},
+		},
+	}
+
 	// Add endpoint to insert a graph with nodes and edges
 
 	s["paths"].(map[string]interface{})["/v1/graph/{partition}"] = map[string]interface{}{
@@ -533,9 +863,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(partitionParams, graphPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -552,9 +880,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(partitionParams, graphPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -571,9 +897,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(partitionParams, graphPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -595,9 +919,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -614,9 +936,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -633,9 +953,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
 			"responses": map[string]interface{}{
-				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
-				},
+				"200":     summaryResponse,
 				"default": defaultError,
 			},
 		},
@@ -721,6 +1039,14 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			},
 		},
 	}
+
+	// Add endpoint for blobs
+
+	blobParams := []map[string]interface{}{defaultParams[0]}
+	blobParams = append(blobParams, partitionParams...)
+	blobParams = append(blobParams, keyParam...)
+
+	ge.blobSwaggerDefs(s, blobParams, defaultError)
 }
 
 // Comparator object to sort traversal results
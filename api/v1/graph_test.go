@@ -13,6 +13,7 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/krotik/common/datautil"
@@ -105,6 +106,84 @@ func TestNestedStorage(t *testing.T) {
 	}
 }
 
+func TestTaggedValueStorage(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	// Store a node with tagged values
+
+	st, _, res := sendTestRequest(queryURL+"main/n", "POST", []byte(`
+[{
+	"key":"taggedtest",
+	"kind":"Test",
+	"date":{"$type":"datetime","value":"2020-02-29T12:30:00Z"},
+	"price":{"$type":"decimal","value":"12.50"},
+	"data":{"$type":"binary","value":"AQID"},
+	"other":{"$type":"foo","value":"bar"}
+}]
+`[1:]))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	n, err := api.GM.FetchNode("main", "taggedtest", "Test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprintf("%T %T %T %T", n.Attr("date"), n.Attr("price"), n.Attr("data"), n.Attr("other")); res !=
+		"time.Time *data.Decimal []uint8 map[string]interface {}" {
+		t.Error("Unexpected types:", res)
+		return
+	}
+
+	// Rich values are returned as tagged values
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Test/taggedtest", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "data": {
+    "$type": "binary",
+    "value": "AQID"
+  },
+  "date": {
+    "$type": "datetime",
+    "value": "2020-02-29T12:30:00Z"
+  },
+  "key": "taggedtest",
+  "kind": "Test",
+  "other": {
+    "$type": "foo",
+    "value": "bar"
+  },
+  "price": {
+    "$type": "decimal",
+    "value": "12.5"
+  }
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n", "POST", []byte(`
+[{
+	"key":"taggedtest",
+	"kind":"Test",
+	"date":{"$type":"datetime","value":"foo"}
+}]
+`[1:]))
+
+	if st != "400 Bad Request" || res != `Invalid value for attribute date: parsing time "foo" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "foo" as "2006"` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.GM.RemoveNode("main", "taggedtest", "Test")
+}
+
 func TestGraphQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointGraph
 
@@ -273,6 +352,69 @@ func TestGraphQuery(t *testing.T) {
 		return
 	}
 
+	// Test fetching nodes by their keys
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Song?key=Aria4&key=Foo&key=LoveSong3&limit=1", "GET", nil)
+	if st != "200 OK" || res != `
+[
+  {
+    "key": "Aria4",
+    "kind": "Song",
+    "name": "Aria4",
+    "ranking": 18
+  },
+  {
+    "key": "LoveSong3",
+    "kind": "Song",
+    "name": "LoveSong3",
+    "ranking": 1
+  }
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Test paging over a snapshot
+
+	st, h, res = sendTestRequest(queryURL+"/main/n/Song?offset=3&limit=2&snapshot=true", "GET", nil)
+	sid := h.Get(HTTPHeaderSnapshotID)
+
+	if st != "200 OK" || sid == "" || h.Get(HTTPHeaderTotalCount) != "9" || !strings.Contains(res, "LoveSong3") {
+		t.Error("Unexpected response:", st, sid, res)
+		return
+	}
+
+	st, h, res = sendTestRequest(queryURL+"/main/n/Song?offset=7&limit=200&snapshot="+sid, "GET", nil)
+	if st != "200 OK" || h.Get(HTTPHeaderSnapshotID) != sid || !strings.Contains(res, "Aria3") ||
+		!strings.Contains(res, "Aria4") || strings.Contains(res, "LoveSong3") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Song?offset=700&snapshot="+sid, "GET", nil)
+	if st != "500 Internal Server Error" || res != "Offset exceeds available nodes" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Author?snapshot="+sid, "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown snapshot ID (snapshot parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Song?snapshot=123", "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown snapshot ID (snapshot parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/SSong?snapshot=true", "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
 	// Test error cases
 
 	msm := gmMSM.StorageManager("main"+"Song"+graph.StorageSuffixNodes,
@@ -628,7 +770,15 @@ func TestGraphOperation(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"main/n", "POST", []byte(jsonString))
 
-	if st != "200 OK" {
+	if st != "200 OK" || res != `
+{
+  "nodes_stored": 2,
+  "edges_stored": 0,
+  "keys": [
+    "111",
+    "112"
+  ]
+}`[1:] {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -770,7 +920,16 @@ func TestGraphOperation(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"main", "PUT", []byte(jsonString))
 
-	if st != "200 OK" {
+	if st != "200 OK" || res != `
+{
+  "nodes_stored": 2,
+  "edges_stored": 1,
+  "keys": [
+    "111",
+    "112",
+    "123"
+  ]
+}`[1:] {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -807,7 +966,14 @@ func TestGraphOperation(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"main", "DELETE", []byte(jsonString))
 
-	if st != "200 OK" {
+	if st != "200 OK" || res != `
+{
+  "nodes_stored": 0,
+  "edges_stored": 1,
+  "keys": [
+    "123"
+  ]
+}`[1:] {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -856,4 +1022,258 @@ func TestGraphOperation(t *testing.T) {
 		t.Error("Unexpected node query result:", nres)
 		return
 	}
+
+	// Nodes and edges without a key get a generated key
+
+	api.GM.SetKeyGeneration(graph.KeyGenerationSequence)
+	defer api.GM.SetKeyGeneration(graph.KeyGenerationNone)
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`
+{
+	"nodes" : [{ "kind" : "graphtest", "name" : "newnode" }],
+	"edges" : [{ "kind" : "testrel",
+		"end1key" : "112", "end1kind" : "graphtest", "end1role" : "node1", "end1cascading" : false,
+		"end2key" : "112", "end2kind" : "graphtest", "end2role" : "node2", "end2cascading" : false }]
+}`))
+
+	if st != "200 OK" || res != `
+{
+  "nodes_stored": 1,
+  "edges_stored": 1,
+  "keys": [
+    "1",
+    "1"
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if nres, err = api.GM.FetchNode("main", "1", "graphtest"); err != nil ||
+		nres == nil || nres.Attr("name") != "newnode" {
+		t.Error("Unexpected node query result:", nres, err)
+		return
+	}
+
+	if eres, err := api.GM.FetchEdge("main", "1", "testrel"); err != nil ||
+		eres == nil || eres.End1Key() != "112" {
+		t.Error("Unexpected edge query result:", eres, err)
+		return
+	}
+}
+
+func TestGraphKeyObfuscation(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.KeyObfuscation = nil
+	}()
+
+	api.GM, _ = songGraph()
+	api.KeyObfuscation, _ = api.NewTokenKeyObfuscator("secret")
+
+	authorID := api.ExternalKey("Author", "000")
+	songID := api.ExternalKey("Song", "newsong")
+
+	// Store a node and an edge using external IDs
+
+	st, _, res := sendTestRequest(queryURL+"main", "POST", []byte(fmt.Sprintf(`
+{
+	"nodes" : [{ "key" : "%v", "kind" : "Song", "name" : "newsong" }],
+	"edges" : [{ "key" : "%v", "kind" : "Wrote",
+		"end1key" : "%v", "end1kind" : "Author", "end1role" : "Author", "end1cascading" : true,
+		"end2key" : "%v", "end2kind" : "Song", "end2role" : "Song", "end2cascading" : false }]
+}`, songID, api.ExternalKey("Wrote", "newedge"), authorID, songID)))
+
+	// The summary contains external IDs
+
+	if st != "200 OK" || !strings.Contains(res, `"`+songID+`"`) ||
+		!strings.Contains(res, `"`+api.ExternalKey("Wrote", "newedge")+`"`) || strings.Contains(res, `"newsong"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "newsong", "Song"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if e, err := api.GM.FetchEdge("main", "newedge", "Wrote"); err != nil || e == nil || e.End1Key() != "000" {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	// Fetch a node using its external ID
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Author/"+authorID, "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"key": "`+authorID+`"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Internal keys and IDs of other kinds are rejected
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Author/000", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid external key" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Song/"+authorID, "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid external key" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Traversals return external IDs
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Author/"+authorID+"/:::Song", "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"key": "`+songID+`"`) ||
+		!strings.Contains(res, `"end1key": "`+authorID+`"`) || !strings.Contains(res, `"name": "newsong"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Query results contain external IDs
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointQuery+
+		"main?q=get+Author", "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"n:Author:`+authorID+`"`) ||
+		!strings.Contains(res, `"`+authorID+`",`) || strings.Contains(res, `"000"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestGraphProjection(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.ResponseProjection = nil
+	}()
+
+	api.GM, _ = songGraph()
+	api.ResponseProjection = api.NewProjectionPolicy(map[string]map[string]map[string][]string{
+		"*": {
+			"*": {
+				"Author": {"name"},
+			},
+		},
+	})
+
+	st, _, res := sendTestRequest(queryURL+"main/n/Author/000", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "key": "000",
+  "kind": "Author",
+  "name": "John"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Traversals only restrict the returned Author nodes
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Song/Aria1/:::Author", "GET", nil)
+
+	if st != "200 OK" || strings.Contains(res, "desc") || !strings.Contains(res, `"number": 1`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Query results contain no values of restricted attributes
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointQuery+
+		"main?q=get+Author+show+name,+desc", "GET", nil)
+
+	if st != "200 OK" || strings.Contains(res, "popular") || !strings.Contains(res, `"John"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestGraphCommitSeq(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+	txURL := "http://localhost" + TESTPORT + EndpointTx
+
+	// Writes return the commit sequence number of the partition
+
+	st, h, res := sendTestRequest(queryURL+"seqtest/n", "POST", []byte(`[{"key": "s1", "kind": "seqtest"}, {"key": "s2", "kind": "seqtest"}]`))
+
+	if st != "200 OK" || h.Get(HTTPHeaderCommitSeq) != "seqtest=2" {
+		t.Error("Unexpected response:", st, h.Get(HTTPHeaderCommitSeq), res)
+		return
+	}
+
+	st, h, res = sendTestRequest(queryURL+"seqtest/n/seqtest/s1", "GET", nil)
+
+	if st != "200 OK" || h.Get(HTTPHeaderEntitySeq) != "1" {
+		t.Error("Unexpected response:", st, h.Get(HTTPHeaderEntitySeq), res)
+		return
+	}
+
+	// Transactions return the numbers of all partitions which they changed
+
+	var ret map[string]interface{}
+
+	_, _, res = sendTestRequest(txURL, "POST", nil)
+	json.Unmarshal([]byte(res), &ret)
+
+	id := ret["id"].(string)
+
+	sendTestRequest(txURL+id+"/seqtest/n", "PUT", []byte(`[{"key": "s1", "kind": "seqtest", "name": "foo"}]`))
+	sendTestRequest(txURL+id+"/seqtest2/n", "POST", []byte(`[{"key": "s1", "kind": "seqtest"}]`))
+
+	st, h, res = sendTestRequest(txURL+id, "POST", nil)
+
+	if st != "200 OK" || h.Get(HTTPHeaderCommitSeq) != "seqtest=3, seqtest2=1" {
+		t.Error("Unexpected response:", st, h.Get(HTTPHeaderCommitSeq), res)
+		return
+	}
+
+	st, h, res = sendTestRequest(queryURL+"seqtest/n/seqtest/s1", "GET", nil)
+
+	if st != "200 OK" || h.Get(HTTPHeaderEntitySeq) != "3" || !strings.Contains(res, "foo") {
+		t.Error("Unexpected response:", st, h.Get(HTTPHeaderEntitySeq), res)
+		return
+	}
+
+	st, h, res = sendTestRequest(queryURL+"seqtest/n", "DELETE", []byte(`[{"key": "s1", "kind": "seqtest"}, {"key": "s2", "kind": "seqtest"}]`))
+
+	if st != "200 OK" || h.Get(HTTPHeaderCommitSeq) != "seqtest=5" {
+		t.Error("Unexpected response:", st, h.Get(HTTPHeaderCommitSeq), res)
+		return
+	}
+
+	api.GM.RemoveNode("seqtest2", "s1", "seqtest")
+}
+
+func TestGraphTransLimits(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	api.GM.SetTransLimits(1, 0)
+	defer api.GM.SetTransLimits(0, 0)
+
+	st, _, res := sendTestRequest(queryURL+"main/n", "POST", []byte(`[{"key": "l1", "kind": "limittest"}, {"key": "l2", "kind": "limittest"}]`))
+
+	if st != "413 Request Entity Too Large" || !strings.HasPrefix(res, "GraphError: Transaction limit exceeded (Transaction ") ||
+		!strings.HasSuffix(res, "cannot contain more than 1 nodes and edges)") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "l1", "limittest"); n != nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
 }
@@ -0,0 +1,534 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointHistory is the history endpoint URL (rooted). Handles everything under history/...
+*/
+const EndpointHistory = api.APIRoot + APIv1 + "/history/"
+
+/*
+EndpointSessions is the sessions endpoint URL (rooted). Handles everything under sessions/...
+*/
+const EndpointSessions = api.APIRoot + APIv1 + "/sessions/"
+
+/*
+HistoryMaxEntries is the maximum number of history entries which are kept for each user.
+*/
+var HistoryMaxEntries = 100
+
+/*
+Node kinds which store user specific data in the system partition
+*/
+const (
+	historyNodeKind = "history"
+	sessionNodeKind = "session"
+)
+
+/*
+anonymousUser is the user name which is used if requests are not authenticated.
+*/
+const anonymousUser = "anonymous"
+
+/*
+historyLock serializes modifications of the query history.
+*/
+var historyLock = &sync.Mutex{}
+
+/*
+requestUser returns the user of a given request.
+*/
+func requestUser(r *http.Request) string {
+	if api.RequestUser != nil {
+		if user := api.RequestUser(r); user != "" {
+			return user
+		}
+	}
+	return anonymousUser
+}
+
+/*
+HistoryEndpointInst creates a new endpoint handler.
+*/
+func HistoryEndpointInst() api.RestEndpointHandler {
+	return &historyEndpoint{}
+}
+
+/*
+Handler object for query history operations.
+*/
+type historyEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the query history of the current user (most recent entry first).
+*/
+func (he *historyEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	limit, ok := queryParamPosNum(w, r, "limit")
+	if !ok {
+		return
+	}
+
+	entries, err := he.entries(requestUser(r))
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if limit != -1 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(entries)
+}
+
+/*
+HandlePOST adds an entry to the query history of the current user.
+*/
+func (he *historyEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var entry map[string]interface{}
+
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if query, ok := entry["query"].(string); !ok || query == "" {
+		http.Error(w, "History entry must contain a query", http.StatusBadRequest)
+		return
+	}
+
+	entry["time"] = time.Now().Unix()
+
+	user := requestUser(r)
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	entries, err := he.entries(user)
+
+	if err == nil {
+		entries = append([]map[string]interface{}{entry}, entries...)
+
+		if len(entries) > HistoryMaxEntries {
+			entries = entries[:HistoryMaxEntries]
+		}
+
+		err = he.storeEntries(user, entries)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE clears the query history of the current user.
+*/
+func (he *historyEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	if _, err := api.GM.RemoveNode(api.SystemPartition, requestUser(r), historyNodeKind); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+entries returns all history entries of a given user.
+*/
+func (he *historyEndpoint) entries(user string) ([]map[string]interface{}, error) {
+	entries := make([]map[string]interface{}, 0)
+
+	node, err := api.GM.FetchNode(api.SystemPartition, user, historyNodeKind)
+
+	if err == nil && node != nil {
+		err = json.Unmarshal([]byte(node.Attr("entries").(string)), &entries)
+	}
+
+	return entries, err
+}
+
+/*
+storeEntries stores the history entries of a given user.
+*/
+func (he *historyEndpoint) storeEntries(user string, entries []map[string]interface{}) error {
+
+	entriesJSON, err := json.Marshal(entries)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, user)
+		node.SetAttr(data.NodeKind, historyNodeKind)
+		node.SetAttr("entries", string(entriesJSON))
+
+		err = api.GM.StoreNode(api.SystemPartition, node)
+	}
+
+	return err
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (he *historyEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/history"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the query history of the current user.",
+			"description": "The history endpoint returns the query history of the current user - most recent entry first.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "limit",
+					"in":          "query",
+					"description": "Maximum number of entries to return.",
+					"required":    false,
+					"type":        "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of history entries.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Add an entry to the query history of the current user.",
+			"description": "An entry is an object which must contain a query.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "entry",
+					"in":          "body",
+					"description": "History entry to add.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the entry was added.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Clear the query history of the current user.",
+			"description": "All history entries of the current user are removed.",
+			"produces": []string{
+				"text/plain",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the history was cleared.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
+
+/*
+SessionsEndpointInst creates a new endpoint handler.
+*/
+func SessionsEndpointInst() api.RestEndpointHandler {
+	return &sessionsEndpoint{}
+}
+
+/*
+Handler object for saved session operations.
+*/
+type sessionsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the list of saved sessions or a single saved session of the current user.
+*/
+func (se *sessionsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	user := requestUser(r)
+
+	if len(resources) == 0 {
+		sessions := make([]map[string]interface{}, 0)
+
+		it, err := api.GM.NodeKeyIterator(api.SystemPartition, sessionNodeKind)
+
+		for err == nil && it != nil && it.HasNext() {
+			key := it.Next()
+
+			if err = it.LastError; err == nil && strings.HasPrefix(key, user+"/") {
+				var node data.Node
+
+				if node, err = api.GM.FetchNodePart(api.SystemPartition, key, sessionNodeKind,
+					[]string{"name", "updated"}); err == nil && node != nil {
+
+					sessions = append(sessions, map[string]interface{}{
+						"name":    node.Attr("name"),
+						"updated": node.Attr("updated"),
+					})
+				}
+			}
+		}
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i]["name"].(string) < sessions[j]["name"].(string)
+		})
+
+		ret = sessions
+
+	} else {
+		var session map[string]interface{}
+
+		node, err := api.GM.FetchNode(api.SystemPartition, user+"/"+resources[0], sessionNodeKind)
+
+		if err == nil && node == nil {
+			http.Error(w, "Unknown session: "+resources[0], http.StatusNotFound)
+			return
+		} else if err == nil {
+			err = json.Unmarshal([]byte(node.Attr("data").(string)), &session)
+		}
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		ret = session
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePUT stores a session of the current user.
+*/
+func (se *sessionsEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a session of the current user.
+*/
+func (se *sessionsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var session map[string]interface{}
+
+	if !checkResources(w, resources, 1, 1, "Need a session name") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionJSON, err := json.Marshal(session)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, requestUser(r)+"/"+resources[0])
+		node.SetAttr(data.NodeKind, sessionNodeKind)
+		node.SetAttr("name", resources[0])
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(sessionJSON))
+
+		err = api.GM.StoreNode(api.SystemPartition, node)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a session of the current user.
+*/
+func (se *sessionsEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a session name") {
+		return
+	}
+
+	node, err := api.GM.RemoveNode(api.SystemPartition, requestUser(r)+"/"+resources[0], sessionNodeKind)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if node == nil {
+		http.Error(w, "Unknown session: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *sessionsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the session.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/sessions"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the saved sessions of the current user.",
+			"description": "The sessions endpoint returns the names and update times of all saved sessions of the current user.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of sessions.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/sessions/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a saved session of the current user.",
+			"description": "A session is an object which contains queries and the layout of a client.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The stored session object.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Store a session of the current user.",
+			"description": "An existing session with the same name is replaced.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": append(nameParams, map[string]interface{}{
+				"name":        "session",
+				"in":          "body",
+				"description": "Session object to store.",
+				"required":    true,
+				"schema": map[string]interface{}{
+					"type": "object",
+				},
+			}),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the session was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a session of the current user.",
+			"description": "The session with the given name is removed.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the session was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
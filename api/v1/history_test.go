@@ -0,0 +1,231 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestHistory(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointHistory
+
+	oldGM := api.GM
+	oldMaxEntries := HistoryMaxEntries
+	defer func() {
+		api.GM = oldGM
+		api.RequestUser = nil
+		HistoryMaxEntries = oldMaxEntries
+	}()
+
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+	HistoryMaxEntries = 2
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "History entry must contain a query" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	for _, q := range []string{"get Author", "get Song", "get Spam"} {
+		st, _, res = sendTestRequest(queryURL, "POST",
+			[]byte(`{"query": "`+q+`", "partition": "main"}`))
+
+		if st != "200 OK" || res != "" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	var entries []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &entries)
+
+	if st != "200 OK" || len(entries) != 2 || entries[0]["query"] != "get Spam" ||
+		entries[1]["query"] != "get Song" || entries[0]["partition"] != "main" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?limit=1", "GET", nil)
+	json.Unmarshal([]byte(res), &entries)
+
+	if st != "200 OK" || len(entries) != 1 || entries[0]["query"] != "get Spam" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// History is stored per user
+
+	api.RequestUser = func(r *http.Request) string {
+		return "johndoe"
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.RequestUser = nil
+
+	st, _, res = sendTestRequest(queryURL, "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, _ := api.GM.FetchNode(api.SystemPartition, anonymousUser, historyNodeKind); n != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+}
+
+func TestSessions(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSessions
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.RequestUser = nil
+	}()
+
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Need a session name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "PUT", []byte(`[1`))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown session: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "PUT",
+		[]byte(`{"queries": ["get Author"], "layout": {"split": 0.5}}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"bar", "POST", []byte(`{"queries": []}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "layout": {
+    "split": 0.5
+  },
+  "queries": [
+    "get Author"
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var sessions []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &sessions)
+
+	if st != "200 OK" || len(sessions) != 2 || sessions[0]["name"] != "bar" ||
+		sessions[1]["name"] != "foo" || sessions[0]["updated"] == nil {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Sessions are stored per user
+
+	api.RequestUser = func(r *http.Request) string {
+		return "johndoe"
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown session: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.RequestUser = nil
+
+	st, _, res = sendTestRequest(queryURL+"foo", "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &sessions)
+
+	if st != "200 OK" || len(sessions) != 1 || sessions[0]["name"] != "bar" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
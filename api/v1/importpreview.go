@@ -0,0 +1,512 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointImportPreview is the import preview endpoint URL (rooted). Handles everything under importpreview/...
+*/
+const EndpointImportPreview = api.APIRoot + APIv1 + "/importpreview/"
+
+/*
+ImportPreviewMaxRows is the maximum number of sample rows which are inspected.
+*/
+var ImportPreviewMaxRows = 1000
+
+/*
+ImportPreviewEdgeMatchRatio is the minimum ratio of attribute values which must
+match keys of another kind to suggest an edge.
+*/
+var ImportPreviewEdgeMatchRatio = 0.8
+
+/*
+Attribute types which are reported by the import preview
+*/
+const (
+	previewTypeBoolean = "boolean"
+	previewTypeFloat   = "float"
+	previewTypeInteger = "integer"
+	previewTypeList    = "list"
+	previewTypeObject  = "object"
+	previewTypeString  = "string"
+)
+
+/*
+ImportPreviewEndpointInst creates a new endpoint handler.
+*/
+func ImportPreviewEndpointInst() api.RestEndpointHandler {
+	return &importPreviewEndpoint{}
+}
+
+/*
+Handler object for import preview operations.
+*/
+type importPreviewEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandlePOST inspects a sample of CSV or NDJSON data and returns the inferred
+kinds, attribute types, suggested key attributes and potential edges.
+*/
+func (ie *importPreviewEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var records []map[string]interface{}
+	var err error
+
+	if len(resources) > 0 {
+		http.Error(w, "Invalid resource specification: "+strings.Join(resources, "/"), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+
+	if format == "" {
+		format = "ndjson"
+
+		if strings.Contains(r.Header.Get("content-type"), "csv") {
+			format = "csv"
+		}
+	}
+
+	switch format {
+	case "csv":
+		records, err = ie.readCSV(r.Body)
+	case "ndjson":
+		records, err = ie.readNDJSON(r.Body)
+	default:
+		http.Error(w, "Unknown format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, "Could not read sample data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defaultKind := r.URL.Query().Get("kind")
+	if defaultKind == "" {
+		defaultKind = "Node"
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(inferImportSchema(format, records, defaultKind))
+}
+
+/*
+readCSV reads CSV sample data. The first row must contain the attribute names.
+*/
+func (ie *importPreviewEndpoint) readCSV(r io.Reader) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+
+	for err == nil && len(records) < ImportPreviewMaxRows {
+		var row []string
+
+		if row, err = cr.Read(); err == nil {
+			record := make(map[string]interface{})
+
+			for i, name := range header {
+				if i < len(row) && row[i] != "" {
+					record[name] = row[i]
+				}
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return records, err
+}
+
+/*
+readNDJSON reads NDJSON sample data (one JSON object per line).
+*/
+func (ie *importPreviewEndpoint) readNDJSON(r io.Reader) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	var err error
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	for line := 1; err == nil && len(records) < ImportPreviewMaxRows && scanner.Scan(); line++ {
+		var record map[string]interface{}
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+
+		if err = dec.Decode(&record); err != nil {
+			err = fmt.Errorf("Line %v: %v", line, err)
+		} else {
+			records = append(records, record)
+		}
+	}
+
+	if err == nil {
+		err = scanner.Err()
+	}
+
+	return records, err
+}
+
+/*
+previewAttr collects information about a single attribute of a kind.
+*/
+type previewAttr struct {
+	name    string
+	types   map[string]int
+	count   int
+	values  map[string]bool
+	samples []interface{}
+}
+
+/*
+previewKind collects information about a single kind.
+*/
+type previewKind struct {
+	name  string
+	count int
+	attrs map[string]*previewAttr
+	keys  []string
+}
+
+/*
+inferImportSchema infers kinds, attribute types, key attributes and potential
+edges from a list of sample records.
+*/
+func inferImportSchema(format string, records []map[string]interface{}, defaultKind string) map[string]interface{} {
+	kinds := make(map[string]*previewKind)
+
+	for _, record := range records {
+		kind := defaultKind
+
+		if k, ok := record[data.NodeKind]; ok && fmt.Sprint(k) != "" {
+			kind = fmt.Sprint(k)
+		}
+
+		pk, ok := kinds[kind]
+		if !ok {
+			pk = &previewKind{kind, 0, make(map[string]*previewAttr), nil}
+			kinds[kind] = pk
+		}
+
+		pk.count++
+
+		for name, val := range record {
+			if name == data.NodeKind || val == nil {
+				continue
+			}
+
+			pa, ok := pk.attrs[name]
+			if !ok {
+				pa = &previewAttr{name, make(map[string]int), 0, make(map[string]bool), nil}
+				pk.attrs[name] = pa
+			}
+
+			pa.count++
+			pa.types[previewType(val)]++
+			pa.values[fmt.Sprint(val)] = true
+
+			if len(pa.samples) < 3 {
+				pa.samples = append(pa.samples, val)
+			}
+		}
+	}
+
+	kindNames := make([]string, 0, len(kinds))
+	for name, pk := range kinds {
+		kindNames = append(kindNames, name)
+		pk.keys = previewKeyCandidates(pk)
+	}
+	sort.Strings(kindNames)
+
+	kindList := make([]map[string]interface{}, 0, len(kindNames))
+
+	for _, name := range kindNames {
+		pk := kinds[name]
+
+		attrNames := make([]string, 0, len(pk.attrs))
+		for attr := range pk.attrs {
+			attrNames = append(attrNames, attr)
+		}
+		sort.Strings(attrNames)
+
+		attrList := make([]map[string]interface{}, 0, len(attrNames))
+
+		for _, attr := range attrNames {
+			pa := pk.attrs[attr]
+
+			attrList = append(attrList, map[string]interface{}{
+				"name":     pa.name,
+				"type":     previewMergeTypes(pa.types),
+				"nullable": pa.count < pk.count,
+				"unique":   len(pa.values) == pa.count,
+				"samples":  pa.samples,
+			})
+		}
+
+		kindList = append(kindList, map[string]interface{}{
+			"kind":           name,
+			"count":          pk.count,
+			"attrs":          attrList,
+			"key_candidates": pk.keys,
+		})
+	}
+
+	return map[string]interface{}{
+		"format": format,
+		"rows":   len(records),
+		"kinds":  kindList,
+		"edges":  previewEdges(kinds, kindNames),
+	}
+}
+
+/*
+previewType returns the type of a single sample value.
+*/
+func previewType(val interface{}) string {
+
+	switch v := val.(type) {
+
+	case bool:
+		return previewTypeBoolean
+
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return previewTypeInteger
+		}
+		return previewTypeFloat
+
+	case map[string]interface{}:
+		return previewTypeObject
+
+	case []interface{}:
+		return previewTypeList
+
+	case string:
+
+		// Values from CSV data are always strings - try to find a more specific type
+
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return previewTypeInteger
+		} else if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return previewTypeFloat
+		} else if _, err := strconv.ParseBool(v); err == nil && len(v) > 1 {
+			return previewTypeBoolean
+		}
+	}
+
+	return previewTypeString
+}
+
+/*
+previewMergeTypes returns a single type for all types which were seen for an attribute.
+*/
+func previewMergeTypes(types map[string]int) string {
+
+	if len(types) == 1 {
+		for t := range types {
+			return t
+		}
+	} else if len(types) == 2 && types[previewTypeInteger] > 0 && types[previewTypeFloat] > 0 {
+		return previewTypeFloat
+	}
+
+	return previewTypeString
+}
+
+/*
+previewKeyCandidates returns all attributes of a kind which have a unique
+value in every record. Attributes which look like keys are listed first.
+*/
+func previewKeyCandidates(pk *previewKind) []string {
+	var candidates []string
+
+	for name, pa := range pk.attrs {
+		t := previewMergeTypes(pa.types)
+
+		if pa.count == pk.count && len(pa.values) == pa.count &&
+			(t == previewTypeString || t == previewTypeInteger) {
+
+			candidates = append(candidates, name)
+		}
+	}
+
+	rank := func(name string) int {
+		lname := strings.ToLower(name)
+
+		if name == data.NodeKey {
+			return 0
+		} else if lname == "id" {
+			return 1
+		} else if strings.HasSuffix(lname, "id") || strings.HasSuffix(lname, "key") {
+			return 2
+		}
+		return 3
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ri, rj := rank(candidates[i]), rank(candidates[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	if candidates == nil {
+		candidates = []string{}
+	}
+
+	return candidates
+}
+
+/*
+previewEdges returns potential edges. An edge is suggested if most values of an
+attribute match the values of the preferred key attribute of another kind.
+*/
+func previewEdges(kinds map[string]*previewKind, kindNames []string) []map[string]interface{} {
+	edges := make([]map[string]interface{}, 0)
+
+	for _, fromName := range kindNames {
+		from := kinds[fromName]
+
+		attrNames := make([]string, 0, len(from.attrs))
+		for attr := range from.attrs {
+			attrNames = append(attrNames, attr)
+		}
+		sort.Strings(attrNames)
+
+		for _, attr := range attrNames {
+			pa := from.attrs[attr]
+
+			for _, toName := range kindNames {
+				to := kinds[toName]
+
+				if len(to.keys) == 0 || (toName == fromName && to.keys[0] == attr) {
+					continue
+				}
+
+				keyValues := to.attrs[to.keys[0]].values
+				matches := 0
+
+				for val := range pa.values {
+					if keyValues[val] {
+						matches++
+					}
+				}
+
+				if matches > 0 && float64(matches)/float64(len(pa.values)) >= ImportPreviewEdgeMatchRatio {
+					edges = append(edges, map[string]interface{}{
+						"from_kind": fromName,
+						"attr":      attr,
+						"to_kind":   toName,
+						"to_attr":   to.keys[0],
+						"matches":   matches,
+					})
+				}
+			}
+		}
+	}
+
+	return edges
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ie *importPreviewEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/importpreview"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Infer a schema from sample data.",
+			"description": "The import preview endpoint inspects a sample of CSV or NDJSON data and returns " +
+				"inferred kinds, attribute types, suggested key attributes and potential edges.",
+			"consumes": []string{
+				"text/csv",
+				"application/x-ndjson",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "format",
+					"in":          "query",
+					"description": "Format of the sample data (csv or ndjson). Detected from the content type if not given.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "query",
+					"description": "Kind of records which have no kind attribute.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "data",
+					"in":          "body",
+					"description": "Sample data.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "string",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with inferred kinds and potential edges.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
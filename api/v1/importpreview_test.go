@@ -0,0 +1,246 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+)
+
+func TestImportPreview(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointImportPreview
+
+	st, _, res := sendTestRequest(queryURL+"foo", "POST", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?format=xml", "POST", nil)
+
+	if st != "400 Bad Request" || res != "Unknown format: xml" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte(`{"key": "a"}
+{"key": `))
+
+	if st != "400 Bad Request" || res != "Could not read sample data: Line 2: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?format=csv&kind=Person", "POST", []byte(`
+id,name,age,score,member
+1,Alice,31,1.5,true
+2,Bob,27,2,false
+3,Bob,,3.25,true
+`[1:]))
+
+	if st != "200 OK" || res != `
+{
+  "edges": [],
+  "format": "csv",
+  "kinds": [
+    {
+      "attrs": [
+        {
+          "name": "age",
+          "nullable": true,
+          "samples": [
+            "31",
+            "27"
+          ],
+          "type": "integer",
+          "unique": true
+        },
+        {
+          "name": "id",
+          "nullable": false,
+          "samples": [
+            "1",
+            "2",
+            "3"
+          ],
+          "type": "integer",
+          "unique": true
+        },
+        {
+          "name": "member",
+          "nullable": false,
+          "samples": [
+            "true",
+            "false",
+            "true"
+          ],
+          "type": "boolean",
+          "unique": false
+        },
+        {
+          "name": "name",
+          "nullable": false,
+          "samples": [
+            "Alice",
+            "Bob",
+            "Bob"
+          ],
+          "type": "string",
+          "unique": false
+        },
+        {
+          "name": "score",
+          "nullable": false,
+          "samples": [
+            "1.5",
+            "2",
+            "3.25"
+          ],
+          "type": "float",
+          "unique": true
+        }
+      ],
+      "count": 3,
+      "key_candidates": [
+        "id"
+      ],
+      "kind": "Person"
+    }
+  ],
+  "rows": 3
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte(`
+{"kind": "Author", "key": "a1", "name": "John", "tags": ["x"]}
+{"kind": "Author", "key": "a2", "name": "Mike", "address": {"city": "Here"}}
+
+{"kind": "Song", "key": "s1", "author": "a1", "ranking": 5}
+{"kind": "Song", "key": "s2", "author": "a2", "ranking": 4.5}
+{"kind": "Song", "key": "s3", "author": "a1", "ranking": 3}
+`[1:]))
+
+	if st != "200 OK" || res != `
+{
+  "edges": [
+    {
+      "attr": "author",
+      "from_kind": "Song",
+      "matches": 2,
+      "to_attr": "key",
+      "to_kind": "Author"
+    }
+  ],
+  "format": "ndjson",
+  "kinds": [
+    {
+      "attrs": [
+        {
+          "name": "address",
+          "nullable": true,
+          "samples": [
+            {
+              "city": "Here"
+            }
+          ],
+          "type": "object",
+          "unique": true
+        },
+        {
+          "name": "key",
+          "nullable": false,
+          "samples": [
+            "a1",
+            "a2"
+          ],
+          "type": "string",
+          "unique": true
+        },
+        {
+          "name": "name",
+          "nullable": false,
+          "samples": [
+            "John",
+            "Mike"
+          ],
+          "type": "string",
+          "unique": true
+        },
+        {
+          "name": "tags",
+          "nullable": true,
+          "samples": [
+            [
+              "x"
+            ]
+          ],
+          "type": "list",
+          "unique": true
+        }
+      ],
+      "count": 2,
+      "key_candidates": [
+        "key",
+        "name"
+      ],
+      "kind": "Author"
+    },
+    {
+      "attrs": [
+        {
+          "name": "author",
+          "nullable": false,
+          "samples": [
+            "a1",
+            "a2",
+            "a1"
+          ],
+          "type": "string",
+          "unique": false
+        },
+        {
+          "name": "key",
+          "nullable": false,
+          "samples": [
+            "s1",
+            "s2",
+            "s3"
+          ],
+          "type": "string",
+          "unique": true
+        },
+        {
+          "name": "ranking",
+          "nullable": false,
+          "samples": [
+            5,
+            4.5,
+            3
+          ],
+          "type": "float",
+          "unique": true
+        }
+      ],
+      "count": 3,
+      "key_candidates": [
+        "key"
+      ],
+      "kind": "Song"
+    }
+  ],
+  "rows": 5
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
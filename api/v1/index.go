@@ -12,10 +12,14 @@ package v1
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/util"
 )
 
 /*
@@ -43,14 +47,8 @@ HandleGET handles a search query REST call.
 func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
 	var err error
 
-	// Check parameters
-
-	if !checkResources(w, resources, 3, 3, "Need a partition, entity type (n or e) and a kind") {
-		return
-	}
-
-	if resources[1] != "n" && resources[1] != "e" {
-		http.Error(w, "Entity type must be n (nodes) or e (edges)", http.StatusBadRequest)
+	iq, ok := ie.indexQuery(w, r, resources)
+	if !ok {
 		return
 	}
 
@@ -64,36 +62,39 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 	phrase := r.URL.Query().Get("phrase")
 	word := r.URL.Query().Get("word")
+	fuzzy := r.URL.Query().Get("fuzzy")
+	prefix := r.URL.Query().Get("prefix")
 	value := r.URL.Query().Get("value")
-
-	// Get the index query object
-
-	var iq graph.IndexQuery
-
-	if resources[1] == "n" {
-		iq, err = api.GM.NodeIndexQuery(resources[0], resources[2])
-	} else {
-		iq, err = api.GM.EdgeIndexQuery(resources[0], resources[2])
-	}
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	} else if iq == nil {
-		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
-		return
-	}
+	near := r.URL.Query().Get("near")
+	bbox := r.URL.Query().Get("bbox")
 
 	// Do the lookup
 
 	var data interface{}
 
 	switch {
+	case phrase != "" && r.URL.Query().Get("score") == "true":
+		data, err = iq.LookupPhraseScored(attr, phrase)
+		if len(data.([]*util.PhraseMatch)) == 0 {
+			data = []*util.PhraseMatch{}
+		}
 	case phrase != "":
 		data, err = iq.LookupPhrase(attr, phrase)
 		if len(data.([]string)) == 0 {
 			data = []string{}
 		}
+	case word != "" && fuzzy != "":
+		var maxDistance int
+
+		if maxDistance, err = strconv.Atoi(fuzzy); err != nil || maxDistance < 0 || maxDistance > util.MaxFuzzyDistance {
+			http.Error(w, fmt.Sprint("Parameter fuzzy must be a maximum edit distance between 0 and ",
+				util.MaxFuzzyDistance), http.StatusBadRequest)
+			return
+		}
+
+		data, err = iq.LookupFuzzy(attr, word, maxDistance)
+	case prefix != "":
+		data, err = iq.LookupPrefix(attr, prefix)
 	case word != "":
 		data, err = iq.LookupWord(attr, word)
 		if len(data.(map[string][]uint64)) == 0 {
@@ -104,15 +105,46 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		if len(data.([]string)) == 0 {
 			data = []string{}
 		}
+	case near != "":
+		var coords []float64
+		var radius float64
+
+		if coords, err = parseCoordinates(near, 2); err != nil {
+			http.Error(w, "Parameter near must be <lat>,<lon>: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if radius, err = strconv.ParseFloat(r.URL.Query().Get("radius"), 64); err != nil || radius < 0 {
+			http.Error(w, "Parameter radius must be a positive number (km)", http.StatusBadRequest)
+			return
+		}
+
+		data, err = iq.LookupGeoRadius(attr, coords[0], coords[1], radius)
+		if len(data.([]string)) == 0 {
+			data = []string{}
+		}
+	case bbox != "":
+		var coords []float64
+
+		if coords, err = parseCoordinates(bbox, 4); err != nil {
+			http.Error(w, "Parameter bbox must be <min lat>,<min lon>,<max lat>,<max lon>: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if coords[0] > coords[2] || coords[1] > coords[3] {
+			http.Error(w, "Parameter bbox must be <min lat>,<min lon>,<max lat>,<max lon>: Minimum is larger than maximum", http.StatusBadRequest)
+			return
+		}
+
+		data, err = iq.LookupGeoBBox(attr, coords[0], coords[1], coords[2], coords[3])
+		if len(data.([]string)) == 0 {
+			data = []string{}
+		}
 	default:
-		http.Error(w, "Query string for either phrase, word or value is required", http.StatusBadRequest)
+		http.Error(w, "Query string for either phrase, word, prefix, value, near or bbox is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if there was an error
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.ReportError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -121,7 +153,142 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 	w.Header().Set("content-type", "application/json; charset=utf-8")
 
 	ret := json.NewEncoder(w)
-	ret.Encode(data)
+	ret.Encode(externalIndexResult(resources[2], data))
+}
+
+/*
+HandlePOST handles a composite search query REST call. The request body is an
+index term which combines lookups over several attributes.
+*/
+func (ie *indexEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var term util.IndexTerm
+
+	iq, ok := ie.indexQuery(w, r, resources)
+	if !ok {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&term); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := iq.LookupComposite(&term)
+
+	if err != nil {
+		if gerr, ok := err.(*util.GraphError); ok && gerr.Type == util.ErrInvalidData {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+		} else {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if len(keys) == 0 {
+		keys = []string{}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(externalKeys(resources[2], keys))
+}
+
+/*
+externalIndexResult returns a copy of an index lookup result with all keys
+translated into external IDs.
+*/
+func externalIndexResult(kind string, res interface{}) interface{} {
+
+	if api.KeyObfuscation == nil {
+		return res
+	}
+
+	switch r := res.(type) {
+	case []string:
+		return externalKeys(kind, r)
+
+	case map[string][]uint64:
+		ret := make(map[string][]uint64, len(r))
+		for k, v := range r {
+			ret[api.ExternalKey(kind, k)] = v
+		}
+		return ret
+
+	case []*util.PhraseMatch:
+		ret := make([]*util.PhraseMatch, len(r))
+		for i, m := range r {
+			ret[i] = &util.PhraseMatch{Key: api.ExternalKey(kind, m.Key), Score: m.Score, Count: m.Count}
+		}
+		return ret
+
+	case []*util.WordMatch:
+		ret := make([]*util.WordMatch, len(r))
+		for i, m := range r {
+			ret[i] = &util.WordMatch{Key: api.ExternalKey(kind, m.Key), Word: m.Word,
+				Distance: m.Distance, Count: m.Count}
+		}
+		return ret
+	}
+
+	return res
+}
+
+/*
+indexQuery returns the index query object for a request.
+*/
+func (ie *indexEndpoint) indexQuery(w http.ResponseWriter, r *http.Request, resources []string) (graph.IndexQuery, bool) {
+	var iq graph.IndexQuery
+	var err error
+
+	gm := api.RequestGM(r)
+
+	if !checkResources(w, resources, 3, 3, "Need a partition, entity type (n or e) and a kind") {
+		return nil, false
+	}
+
+	if resources[1] != "n" && resources[1] != "e" {
+		http.Error(w, "Entity type must be n (nodes) or e (edges)", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if resources[1] == "n" {
+		iq, err = gm.NodeIndexQuery(resources[0], resources[2])
+	} else {
+		iq, err = gm.EdgeIndexQuery(resources[0], resources[2])
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	} else if iq == nil {
+		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return iq, true
+}
+
+/*
+parseCoordinates parses a comma separated list of coordinates.
+*/
+func parseCoordinates(s string, count int) ([]float64, error) {
+	var ret []float64
+
+	parts := strings.Split(s, ",")
+
+	if len(parts) != count {
+		return nil, fmt.Errorf("Expected %v values", count)
+	}
+
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, f)
+	}
+
+	return ret, nil
 }
 
 /*
@@ -174,6 +341,21 @@ func (ie *indexEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"required":    false,
 					"type":        "string",
 				},
+				{
+					"name":        "fuzzy",
+					"in":          "query",
+					"description": "Maximum edit distance for fuzzy word queries (0-3).",
+					"required":    false,
+					"type":        "number",
+					"format":      "integer",
+				},
+				{
+					"name":        "prefix",
+					"in":          "query",
+					"description": "Word prefix to search for in prefix queries.",
+					"required":    false,
+					"type":        "string",
+				},
 				{
 					"name":        "phrase",
 					"in":          "query",
@@ -181,6 +363,13 @@ func (ie *indexEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"required":    false,
 					"type":        "string",
 				},
+				{
+					"name":        "score",
+					"in":          "query",
+					"description": "Rank phrase query results by their BM25 score (true or false).",
+					"required":    false,
+					"type":        "boolean",
+				},
 				{
 					"name":        "value",
 					"in":          "query",
@@ -188,10 +377,88 @@ func (ie *indexEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"required":    false,
 					"type":        "string",
 				},
+				{
+					"name":        "near",
+					"in":          "query",
+					"description": "Location (<lat>,<lon>) to search around in radius queries.",
+					"required":    false,
+					"type":        "string",
+				},
+				{
+					"name":        "radius",
+					"in":          "query",
+					"description": "Radius in km for radius queries.",
+					"required":    false,
+					"type":        "number",
+				},
+				{
+					"name":        "bbox",
+					"in":          "query",
+					"description": "Bounding box (<min lat>,<min lon>,<max lat>,<max lon>) to search in bounding box queries.",
+					"required":    false,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of keys or when doing a word search a map with node/edge key to word positions. Prefix and fuzzy searches return a ranked list of matches (key, matching word, distance and number of occurrences).",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Run a composite index search on the EliasDB datastore.",
+			"description": "A composite index search combines word, value and phrase lookups over several " +
+				"attributes with and / or. The result is the intersection or union of the keys of all lookups.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name": "entity_type",
+					"in":   "path",
+					"description": "Datastore entity type which should selected. " +
+						"Either n for nodes or e for edges.",
+					"required": true,
+					"type":     "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node or edge kind to be queried.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name": "term",
+					"in":   "body",
+					"description": "Index term which either has a list of and or or terms or an attr " +
+						"with one of word, value or phrase.",
+					"required": true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
 			},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
-					"description": "A list of keys or when doing a word search a map with node/edge key to word positions.",
+					"description": "A sorted list of keys.",
 				},
 				"default": map[string]interface{}{
 					"description": "Error response",
@@ -14,7 +14,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/storage"
 )
 
@@ -46,7 +48,7 @@ func TestIndexQuery(t *testing.T) {
 	}
 
 	st, _, res = sendTestRequest(queryURL+"//main/n/Song?attr=1", "GET", nil)
-	if st != "400 Bad Request" || res != "Query string for either phrase, word or value is required" {
+	if st != "400 Bad Request" || res != "Query string for either phrase, word, prefix, value, near or bbox is required" {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -144,3 +146,260 @@ func TestIndexQuery(t *testing.T) {
 	delete(msm.AccessMap, 1)
 
 }
+
+func TestIndexGeoQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointIndexQuery
+
+	for key, loc := range map[string][]float64{
+		"Berlin":  {52.52, 13.405},
+		"Potsdam": {52.39, 13.06},
+		"Hamburg": {53.55, 9.99},
+		"Munich":  {48.14, 11.58},
+	} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "City")
+		node.SetAttr("location", map[string]interface{}{"lat": loc[0], "lon": loc[1]})
+		api.GM.StoreNode("main", node)
+	}
+
+	defer func() {
+		for _, key := range []string{"Berlin", "Potsdam", "Hamburg", "Munich"} {
+			api.GM.RemoveNode("main", key, "City")
+		}
+	}()
+
+	_, _, res := sendTestRequest(queryURL+"main/n/City?attr=location&near=52.52,13.405&radius=50", "GET", nil)
+	if res != `
+[
+  "Berlin",
+  "Potsdam"
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/City?attr=location&near=53,12&radius=10", "GET", nil)
+	if res != "[]" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/City?attr=location&bbox=52,9,54,14", "GET", nil)
+	if res != `
+[
+  "Berlin",
+  "Hamburg",
+  "Potsdam"
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	st, _, res := sendTestRequest(queryURL+"main/n/City?attr=location&near=52.52", "GET", nil)
+	if st != "400 Bad Request" || res != "Parameter near must be <lat>,<lon>: Expected 2 values" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/City?attr=location&near=52.52,13.405", "GET", nil)
+	if st != "400 Bad Request" || res != "Parameter radius must be a positive number (km)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/City?attr=location&bbox=52,x,54,14", "GET", nil)
+	if st != "400 Bad Request" || res != `Parameter bbox must be <min lat>,<min lon>,<max lat>,<max lon>: strconv.ParseFloat: parsing "x": invalid syntax` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/City?attr=location&bbox=54,9,52,14", "GET", nil)
+	if st != "400 Bad Request" || res != "Parameter bbox must be <min lat>,<min lon>,<max lat>,<max lon>: Minimum is larger than maximum" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestIndexScoredPhraseQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointIndexQuery
+
+	_, _, res := sendTestRequest(queryURL+"main/n/Author?attr=desc&phrase=artists&score=true", "GET", nil)
+	if res != `
+[
+  {
+    "key": "000",
+    "score": 0.3955628496211987,
+    "count": 2
+  }
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/Author?attr=desc&phrase=artists&score=false", "GET", nil)
+	if res != `
+[
+  "000"
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/Author?attr=desc&phrase=foo&score=true", "GET", nil)
+	if res != "[]" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+}
+
+func TestIndexCompositeQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointIndexQuery
+
+	st, _, res := sendTestRequest(queryURL+"main/n/Song", "POST", []byte(`{"or": [
+		{"attr": "name", "value": "Aria1"},
+		{"and": [{"attr": "name", "word": "myonlysong3"}, {"attr": "ranking", "value": "19"}]}
+	]}`))
+	if st != "200 OK" || res != `
+[
+  "Aria1",
+  "MyOnlySong3"
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/e/Wrote", "POST", []byte(`{"and": [
+		{"attr": "number", "value": "3"}, {"attr": "number", "value": "4"}
+	]}`))
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Song", "POST", []byte(`{"attr": "name"}`))
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Index term for attribute name needs exactly one of word, value or phrase)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Song", "POST", []byte(`{`))
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/Foo", "POST", []byte(`{}`))
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/x/Song", "POST", []byte(`{}`))
+	if st != "400 Bad Request" || res != "Entity type must be n (nodes) or e (edges)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestIndexWordSearchQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointIndexQuery
+
+	_, _, res := sendTestRequest(queryURL+"main/n/Song?attr=name&prefix=ARIA", "GET", nil)
+	if res != `
+[
+  {
+    "key": "Aria1",
+    "word": "aria1",
+    "distance": 1,
+    "count": 1
+  },
+  {
+    "key": "Aria2",
+    "word": "aria2",
+    "distance": 1,
+    "count": 1
+  },
+  {
+    "key": "Aria3",
+    "word": "aria3",
+    "distance": 1,
+    "count": 1
+  },
+  {
+    "key": "Aria4",
+    "word": "aria4",
+    "distance": 1,
+    "count": 1
+  }
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/Song?attr=name&word=lovesnog3&fuzzy=2", "GET", nil)
+	if res != `
+[
+  {
+    "key": "LoveSong3",
+    "word": "lovesong3",
+    "distance": 2,
+    "count": 1
+  }
+]`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/Song?attr=name&word=lovesnog3&fuzzy=1", "GET", nil)
+	if res != "[]" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	_, _, res = sendTestRequest(queryURL+"main/n/Song?attr=name&prefix=x", "GET", nil)
+	if res != "[]" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	st, _, res := sendTestRequest(queryURL+"main/n/Song?attr=name&word=aria&fuzzy=4", "GET", nil)
+	if st != "400 Bad Request" || res != "Parameter fuzzy must be a maximum edit distance between 0 and 3" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestIndexKeyObfuscation(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointIndexQuery
+
+	api.KeyObfuscation, _ = api.NewTokenKeyObfuscator("secret")
+	defer func() {
+		api.KeyObfuscation = nil
+	}()
+
+	aria1 := api.ExternalKey("Song", "Aria1")
+
+	for _, q := range []string{
+		"main/n/Song?attr=name&value=Aria1",
+		"main/n/Song?attr=name&word=Aria1",
+		"main/n/Song?attr=name&phrase=Aria1",
+		"main/n/Song?attr=name&phrase=Aria1&score=true",
+		"main/n/Song?attr=name&prefix=Aria1",
+		"main/n/Song?attr=name&word=Aria1&fuzzy=1",
+	} {
+		st, _, res := sendTestRequest(queryURL+q, "GET", nil)
+
+		if st != "200 OK" || !strings.Contains(res, `"`+aria1+`"`) || strings.Contains(res, `"Aria1"`) {
+			t.Error("Unexpected response:", q, st, res)
+			return
+		}
+	}
+
+	st, _, res := sendTestRequest(queryURL+"main/n/Song", "POST", []byte(`{"attr": "name", "value": "Aria1"}`))
+
+	if st != "200 OK" || res != "[\n  \""+aria1+"\"\n]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
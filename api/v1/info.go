@@ -16,6 +16,8 @@ import (
 	"net/http"
 
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 /*
@@ -41,6 +43,7 @@ type infoEndpoint struct {
 HandleGET handles a info query REST call.
 */
 func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	gm := api.RequestGM(r)
 
 	data := make(map[string]interface{})
 
@@ -55,8 +58,8 @@ func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 				return
 			}
 
-			na := api.GM.NodeAttrs(resources[1])
-			ea := api.GM.EdgeAttrs(resources[1])
+			na := gm.NodeAttrs(resources[1])
+			ea := gm.EdgeAttrs(resources[1])
 
 			if len(na) == 0 && len(ea) == 0 {
 				http.Error(w, fmt.Sprint("Unknown node kind ", resources[1]), http.StatusBadRequest)
@@ -64,35 +67,75 @@ func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 			}
 
 			data["node_attrs"] = na
-			data["node_edges"] = api.GM.NodeEdges(resources[1])
+			data["node_edges"] = gm.NodeEdges(resources[1])
 			data["edge_attrs"] = ea
+
+			if len(ea) > 0 {
+
+				// Role vocabulary of an edge kind
+
+				data["edge_roles"] = gm.EdgeRoles(resources[1])
+				data["edge_role_aliases"] = gm.EdgeRoleAliases(resources[1])
+			}
 		}
 
 	} else {
 
 		// Get general information
 
-		data["partitions"] = api.GM.Partitions()
+		data["partitions"] = gm.Partitions()
 
-		nks := api.GM.NodeKinds()
+		nks := gm.NodeKinds()
 		data["node_kinds"] = nks
 
 		ncs := make(map[string]uint64)
 		for _, nk := range nks {
-			ncs[nk] = api.GM.NodeCount(nk)
+			ncs[nk] = gm.NodeCount(nk)
 		}
 
 		data["node_counts"] = ncs
 
-		eks := api.GM.EdgeKinds()
+		eks := gm.EdgeKinds()
 		data["edge_kinds"] = eks
 
 		ecs := make(map[string]uint64)
 		for _, ek := range eks {
-			ecs[ek] = api.GM.EdgeCount(ek)
+			ecs[ek] = gm.EdgeCount(ek)
 		}
 
 		data["edge_counts"] = ecs
+
+		ers := make(map[string][]string)
+		for _, ek := range eks {
+			ers[ek] = gm.EdgeRoles(ek)
+		}
+
+		data["edge_roles"] = ers
+
+		if Replica != nil {
+			data["replication"] = Replica.Status()
+		}
+
+		if status := gm.CompactionStatus(); status != nil {
+			data["compaction"] = status
+		}
+
+		data["page_cache"] = file.DefaultPageCache.Stats()
+		data["plan_cache"] = eql.PlanCacheStats()
+
+		// User-defined EQL functions
+
+		data["functions"] = eql.Functions()
+
+		if qm := requestQuotas(r); qm != nil {
+			info, err := quotaInfo(qm)
+			if err != nil {
+				api.ReportError(w, r, err, http.StatusInternalServerError)
+				return
+			}
+
+			data["quota"] = info
+		}
 	}
 
 	// Write data
@@ -110,8 +153,9 @@ func (ie *infoEndpoint) SwaggerDefs(s map[string]interface{}) {
 
 	s["paths"].(map[string]interface{})["/v1/info"] = map[string]interface{}{
 		"get": map[string]interface{}{
-			"summary":     "Return general datastore information.",
-			"description": "The info endpoint returns general database information such as known node kinds, known attributes, etc.",
+			"summary": "Return general datastore information.",
+			"description": "The info endpoint returns general database information such as known node kinds, known attributes, " +
+				"user-defined EQL functions, etc.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -132,8 +176,9 @@ func (ie *infoEndpoint) SwaggerDefs(s map[string]interface{}) {
 
 	s["paths"].(map[string]interface{})["/v1/info/kind/{kind}"] = map[string]interface{}{
 		"get": map[string]interface{}{
-			"summary":     "Return information on a given node or edge kind.",
-			"description": "The info kind endpoint returns information on a given node kind such as known attributes and edges.",
+			"summary": "Return information on a given node or edge kind.",
+			"description": "The info kind endpoint returns information on a given node kind such as known attributes and edges. " +
+				"For edge kinds the known roles and renamed roles are returned.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -10,7 +10,13 @@
 
 package v1
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/eql/interpreter"
+)
 
 func TestInfoQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointInfoQuery
@@ -19,7 +25,41 @@ func TestInfoQuery(t *testing.T) {
 	// elsewhere
 
 	st, _, res := sendTestRequest(queryURL, "GET", nil)
-	if st != "200 OK" {
+	if st != "200 OK" || !strings.Contains(res, `"page_cache": {`) ||
+		!strings.Contains(res, `"plan_cache": {`) || !strings.Contains(res, `"functions": []`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// User-defined EQL functions are listed
+
+	err := eql.RegisterFunction(&interpreter.FunctionDef{
+		Name:        "infotest",
+		Description: "Test function",
+		Params:      []interpreter.FunctionParam{{Name: "value", Type: interpreter.ParamAny}},
+		Func: func(args []interface{}) (interface{}, error) {
+			return args[0], nil
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || !strings.Contains(res, `
+  "functions": [
+    {
+      "name": "infotest",
+      "description": "Test function",
+      "params": [
+        {
+          "name": "value",
+          "type": "any"
+        }
+      ]
+    }
+  ],`) {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -81,6 +121,11 @@ func TestInfoQuery(t *testing.T) {
     "kind",
     "number"
   ],
+  "edge_role_aliases": {},
+  "edge_roles": [
+    "Author",
+    "Song"
+  ],
   "node_attrs": null,
   "node_edges": null
 }`[1:] {
@@ -0,0 +1,899 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graphql"
+)
+
+/*
+EndpointJobs is the jobs endpoint URL (rooted). Handles everything under jobs/...
+*/
+const EndpointJobs = api.APIRoot + APIv1 + "/jobs/"
+
+/*
+JobHistorySize is the maximum number of finished jobs which are kept.
+*/
+var JobHistorySize = 100
+
+/*
+JobFunc runs a background job with the given parameters and returns its result.
+Jobs can report their progress with the given progress function.
+*/
+type JobFunc func(params map[string]interface{}, progress JobProgress) (interface{}, error)
+
+/*
+JobProgress reports the number of processed items and the total number of
+items of a job.
+*/
+type JobProgress func(done uint64, total uint64)
+
+/*
+JobTypes are all known job types.
+*/
+var JobTypes = map[string]JobFunc{
+	"alert":        alertJob,
+	"analyze":      analyzeJob,
+	"backup":       backupJob,
+	"check":        checkJob,
+	"compact":      compactJob,
+	"dedup":        dedupJob,
+	"mutation":     mutationJob,
+	"quality":      qualityJob,
+	"reencrypt":    reencryptJob,
+	"reindex":      reindexJob,
+	"renamerole":   renameRoleJob,
+	"rotatekey":    rotateKeyJob,
+	"script":       scriptJob,
+	"shred":        shredJob,
+	"upgradeindex": upgradeIndexJob,
+}
+
+/*
+Job states
+*/
+const (
+	JobRunning  = "running"
+	JobFinished = "finished"
+	JobFailed   = "failed"
+)
+
+/*
+Job is a background job.
+*/
+type Job struct {
+	ID       string      `json:"id"`               // ID of the job
+	Type     string      `json:"type"`             // Type of the job
+	Status   string      `json:"status"`           // Status of the job
+	Started  int64       `json:"started"`          // Start time of the job
+	Finished int64       `json:"finished"`         // Finish time of the job
+	Done     uint64      `json:"done,omitempty"`   // Number of processed items
+	Total    uint64      `json:"total,omitempty"`  // Total number of items
+	Error    string      `json:"error,omitempty"`  // Error of a failed job
+	Result   interface{} `json:"result,omitempty"` // Result of a finished job
+}
+
+/*
+jobs holds all known jobs.
+*/
+var jobs = make(map[string]*Job)
+
+/*
+jobCount is used to generate job IDs.
+*/
+var jobCount = 0
+
+/*
+jobsLock protects the jobs map and the state of all jobs.
+*/
+var jobsLock = &sync.Mutex{}
+
+/*
+StartJob starts a new background job of a given type. Returns the ID of the job.
+*/
+func StartJob(jobType string, params map[string]interface{}) (string, error) {
+
+	jobFunc, ok := JobTypes[jobType]
+	if !ok {
+		return "", fmt.Errorf("Unknown job type: %v", jobType)
+	}
+
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	jobCount++
+
+	job := &Job{fmt.Sprint(jobCount), jobType, JobRunning, time.Now().Unix(), 0, 0, 0, "", nil}
+	jobs[job.ID] = job
+
+	pruneJobs()
+
+	go func() {
+		res, err := jobFunc(params, func(done uint64, total uint64) {
+			jobsLock.Lock()
+			job.Done = done
+			job.Total = total
+			jobsLock.Unlock()
+		})
+
+		jobsLock.Lock()
+		defer jobsLock.Unlock()
+
+		job.Finished = time.Now().Unix()
+
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = JobFinished
+			job.Result = res
+		}
+	}()
+
+	return job.ID, nil
+}
+
+/*
+pruneJobs removes the oldest finished jobs if there are too many. Expects the
+jobs lock to be held.
+*/
+func pruneJobs() {
+	var finished []*Job
+
+	for _, job := range jobs {
+		if job.Status != JobRunning {
+			finished = append(finished, job)
+		}
+	}
+
+	if len(finished) > JobHistorySize {
+		sortJobs(finished)
+
+		for _, job := range finished[:len(finished)-JobHistorySize] {
+			delete(jobs, job.ID)
+		}
+	}
+}
+
+/*
+sortJobs sorts a list of jobs by their ID (oldest job first).
+*/
+func sortJobs(jobList []*Job) {
+	sort.Slice(jobList, func(i, j int) bool {
+		idi, _ := strconv.Atoi(jobList[i].ID)
+		idj, _ := strconv.Atoi(jobList[j].ID)
+		return idi < idj
+	})
+}
+
+/*
+qualityJob creates a data quality report. Parameters are the partition and
+an optional list of node kinds.
+*/
+func qualityJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var kinds []string
+
+	part, ok := params["partition"].(string)
+	if !ok || part == "" {
+		return nil, fmt.Errorf("Need a partition")
+	}
+
+	if kindList, ok := params["kinds"].([]interface{}); ok {
+		for _, kind := range kindList {
+			kinds = append(kinds, fmt.Sprint(kind))
+		}
+	}
+
+	report, err := graph.DataQualityReport(part, kinds, api.GM)
+
+	if err == nil && api.KeyObfuscation != nil {
+		externalQualityReport(report)
+	}
+
+	return report, err
+}
+
+/*
+analyzeJob collects the statistics of node kinds. Parameters are the partition
+and an optional list of node kinds.
+*/
+func analyzeJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var kinds []string
+
+	part, ok := params["partition"].(string)
+	if !ok || part == "" {
+		return nil, fmt.Errorf("Need a partition")
+	}
+
+	if kindList, ok := params["kinds"].([]interface{}); ok {
+		for _, kind := range kindList {
+			kinds = append(kinds, fmt.Sprint(kind))
+		}
+	}
+
+	return api.GM.Analyze(part, kinds)
+}
+
+/*
+externalQualityReport translates all keys of a data quality report into
+external IDs. The given report is modified.
+*/
+func externalQualityReport(report *graph.QualityReport) {
+
+	for kind, kr := range report.Kinds {
+
+		for _, group := range kr.Duplicates {
+			for i, key := range group {
+				group[i] = api.ExternalKey(kind, key)
+			}
+		}
+
+		// Orphaned edges are reported as <key> (<kind>)
+
+		for i, ek := range kr.OrphanedEdgeKeys {
+			if j := strings.LastIndex(ek, " ("); j != -1 {
+				ekind := strings.TrimSuffix(ek[j+2:], ")")
+				kr.OrphanedEdgeKeys[i] = fmt.Sprintf("%v (%v)", api.ExternalKey(ekind, ek[:j]), ekind)
+			}
+		}
+	}
+}
+
+/*
+dedupJob finds candidate duplicate nodes of a kind and connects them with
+duplicate edges which carry the score of the match. Parameters are the
+partition, the kind and a list of matching rules. The result is the list of
+candidate pairs which can be merged with the merge endpoint.
+*/
+func dedupJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var rules []*graph.DedupRule
+
+	part, _ := params["partition"].(string)
+	kind, _ := params["kind"].(string)
+
+	if part == "" || kind == "" {
+		return nil, fmt.Errorf("Need a partition and a kind")
+	} else if api.ReadOnly {
+		return nil, fmt.Errorf("Datastore is read-only")
+	}
+
+	ruleList, _ := params["rules"].([]interface{})
+
+	for _, r := range ruleList {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Matching rules must be objects")
+		}
+
+		attr, _ := rule["attr"].(string)
+		fuzzy, _ := rule["fuzzy"].(bool)
+		threshold, _ := rule["threshold"].(float64)
+		weight, _ := rule["weight"].(float64)
+
+		rules = append(rules, &graph.DedupRule{Attr: attr, Fuzzy: fuzzy, Threshold: threshold, Weight: weight})
+	}
+
+	candidates, err := api.GM.FindDuplicates(part, kind, rules, graph.IndexProgress(progress))
+
+	if err == nil {
+		err = api.GM.StoreDuplicateEdges(part, kind, candidates)
+	}
+
+	if err == nil && api.KeyObfuscation != nil {
+		for _, c := range candidates {
+			c.Key1 = api.ExternalKey(kind, c.Key1)
+			c.Key2 = api.ExternalKey(kind, c.Key2)
+		}
+	}
+
+	return map[string]interface{}{
+		"candidates": candidates,
+	}, err
+}
+
+/*
+compactJob reclaims the space of deleted and updated data in the storage files.
+The optional batch_size parameter sets the number of records which are copied
+at a time and the optional pause parameter sets the pause between batches in
+milliseconds. The progress is reported in the info endpoint.
+*/
+func compactJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	batchSize, pause := 1000, 0
+
+	if v, ok := params["batch_size"].(float64); ok {
+		batchSize = int(v)
+	}
+
+	if v, ok := params["pause"].(float64); ok {
+		pause = int(v)
+	}
+
+	err := api.GM.Compact(batchSize, time.Duration(pause)*time.Millisecond)
+
+	return api.GM.CompactionStatus(), err
+}
+
+/*
+checkJob verifies the consistency of the storage files and of the graph
+structure. The optional repair parameter is the name of a new directory next
+to the datastore directory. If it is given then all readable nodes, edges and
+blobs are copied into a new datastore in this directory. The result contains
+the check report and the repair report.
+*/
+func checkJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	repair, _ := params["repair"].(string)
+
+	report, err := api.GM.CheckConsistency(graph.IndexProgress(progress))
+	if err != nil {
+		return nil, err
+	}
+
+	// Problem descriptions contain keys
+
+	if api.KeyObfuscation != nil {
+		report.Examples = nil
+	}
+
+	ret := map[string]interface{}{"check": report}
+
+	if repair == "" {
+		return ret, nil
+	}
+
+	dgs, ok := api.GS.(*graphstorage.DiskGraphStorage)
+	if !ok {
+		return ret, fmt.Errorf("Repair needs a disk storage")
+	} else if repair != filepath.Base(repair) || repair == "." || repair == ".." {
+		return ret, fmt.Errorf("Repair target must be a directory name")
+	}
+
+	target := filepath.Join(filepath.Dir(dgs.Name()), repair)
+
+	if _, err := os.Stat(target); err == nil {
+		return ret, fmt.Errorf("Repair target %v exists already", target)
+	}
+
+	tgs, err := graphstorage.NewDiskGraphStorage(target, false)
+	if err != nil {
+		return ret, err
+	}
+
+	defer tgs.Close()
+
+	salvage, err := api.GM.Salvage(graph.NewGraphManager(tgs), graph.IndexProgress(progress))
+
+	if salvage != nil && api.KeyObfuscation != nil {
+		salvage.Examples = nil
+	}
+
+	ret["repair"] = salvage
+
+	return ret, err
+}
+
+/*
+reencryptJob rewrites all records which are not encrypted with the active
+encryption key (e.g. after a key rotation). The optional partition parameter
+restricts the job to the records of a partition. The optional batch_size
+parameter sets the number of records which are rewritten at a time and the
+optional pause parameter sets the pause between batches in milliseconds.
+*/
+func reencryptJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	count, err := reencrypt(params)
+
+	return map[string]interface{}{"records": count}, err
+}
+
+/*
+rotateKeyJob creates a new active encryption key for a partition (or for all
+partitions without their own keys if no partition is given) and rewrites the
+records with the new key. Parameters are the same as for reencryptJob.
+*/
+func rotateKeyJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	part, _ := params["partition"].(string)
+
+	if api.EncryptionKeys == nil {
+		return nil, fmt.Errorf("Encryption keys cannot be changed")
+	}
+
+	key, err := api.EncryptionKeys.RotateKey(part)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := reencrypt(params)
+
+	return map[string]interface{}{"key": key, "records": count}, err
+}
+
+/*
+shredJob removes all encryption keys of a partition. The records of the
+partition become permanently unreadable.
+*/
+func shredJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	part, _ := params["partition"].(string)
+
+	if part == "" {
+		return nil, fmt.Errorf("Need a partition")
+	} else if api.EncryptionKeys == nil {
+		return nil, fmt.Errorf("Encryption keys cannot be changed")
+	}
+
+	return nil, api.EncryptionKeys.ShredPartition(part)
+}
+
+/*
+reencrypt rewrites the records of the storage or of a partition which are not
+encrypted with the active key.
+*/
+func reencrypt(params map[string]interface{}) (int, error) {
+	part, _ := params["partition"].(string)
+	batchSize, pause := 1000, 0
+
+	if v, ok := params["batch_size"].(float64); ok {
+		batchSize = int(v)
+	}
+
+	if v, ok := params["pause"].(float64); ok {
+		pause = int(v)
+	}
+
+	if part != "" {
+		return api.GM.ReencryptPartition(part, batchSize, time.Duration(pause)*time.Millisecond)
+	}
+
+	return api.GM.Reencrypt(batchSize, time.Duration(pause)*time.Millisecond)
+}
+
+/*
+reindexJob rebuilds the full-text and lookup index of a node or edge kind from
+the stored data. The timelines of timestamped edges are rebuilt as well.
+Parameters are the partition, the kind, an optional entity type (n for nodes
+which is the default or e for edges) and an optional verify flag. If the verify flag is set then the index is only checked and the result
+is a report of all discrepancies.
+*/
+func reindexJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	part, _ := params["partition"].(string)
+	kind, _ := params["kind"].(string)
+	verify, _ := params["verify"].(bool)
+
+	if part == "" || kind == "" {
+		return nil, fmt.Errorf("Need a partition and a kind")
+	}
+
+	var report *graph.IndexReport
+	var err error
+
+	switch params["entity"] {
+	case nil, "n":
+		if !verify {
+			return nil, api.GM.ReindexNodes(part, kind, graph.IndexProgress(progress))
+		}
+		report, err = api.GM.VerifyNodeIndex(part, kind, graph.IndexProgress(progress))
+	case "e":
+		if !verify {
+			if err := api.GM.ReindexEdges(part, kind, graph.IndexProgress(progress)); err != nil {
+				return nil, err
+			}
+			return nil, api.GM.RebuildTimelines(part, kind, graph.IndexProgress(progress))
+		}
+		report, err = api.GM.VerifyEdgeIndex(part, kind, graph.IndexProgress(progress))
+	default:
+		return nil, fmt.Errorf("Entity type must be n (nodes) or e (edges)")
+	}
+
+	// Examples are raw index entries which contain keys
+
+	if report != nil && api.KeyObfuscation != nil {
+		report.Examples = nil
+	}
+
+	return report, err
+}
+
+/*
+upgradeIndexJob rebuilds the indexes of all node and edge kinds in all
+partitions if they were created by an older version (e.g. indexes without
+word dictionaries). Nothing is done if the indexes are up to date.
+*/
+func upgradeIndexJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var done uint64
+
+	return nil, api.GM.UpgradeIndexes(func(uint64, uint64) {
+		done++
+		progress(done, 0)
+	})
+}
+
+/*
+renameRoleJob renames an edge role of all edges of an edge kind. Parameters are
+the edge kind, the old role (from) and the new role (to). The result is the
+number of renamed edges.
+*/
+func renameRoleJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	kind, _ := params["kind"].(string)
+	from, _ := params["from"].(string)
+	to, _ := params["to"].(string)
+
+	if kind == "" || from == "" || to == "" {
+		return nil, fmt.Errorf("Need a kind, a from and a to role")
+	}
+
+	renamed, err := api.GM.RenameEdgeRole(kind, from, to, graph.IndexProgress(progress))
+
+	return map[string]interface{}{
+		"renamed": renamed,
+	}, err
+}
+
+/*
+backupJob exports partitions as JSON files (one file per partition) into a
+directory in the schedule result directory. Parameters are the directory (dir -
+{time} is replaced with the start time of the backup) and an optional list of
+partitions (all partitions by default). The result contains the directory and
+the exported partitions.
+*/
+func backupJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var parts []string
+
+	dir, _ := params["dir"].(string)
+
+	if dir == "" {
+		return nil, fmt.Errorf("Need a backup directory")
+	}
+
+	dir = strings.Replace(dir, "{time}", time.Now().Format("20060102-150405"), -1)
+
+	path, err := scheduleResultFile(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if partList, ok := params["partitions"].([]interface{}); ok {
+		for _, part := range partList {
+			parts = append(parts, fmt.Sprint(part))
+		}
+	} else {
+		parts = api.GM.Partitions()
+	}
+
+	for _, part := range parts {
+		if !stringutil.IsAlphaNumeric(part) {
+			return nil, fmt.Errorf("Invalid partition name: %v", part)
+		}
+	}
+
+	if err = os.MkdirAll(path, 0770); err != nil {
+		return nil, err
+	}
+
+	for i, part := range parts {
+		var f *os.File
+
+		progress(uint64(i), uint64(len(parts)))
+
+		if f, err = os.Create(filepath.Join(path, part+".json")); err == nil {
+			err = graph.ExportPartition(f, part, api.GM)
+
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	progress(uint64(len(parts)), uint64(len(parts)))
+
+	return map[string]interface{}{
+		"dir":        dir,
+		"partitions": parts,
+	}, nil
+}
+
+/*
+mutationJob runs a GraphQL mutation (EQL queries are read-only). Parameters are
+the partition, the query and optional variables and operationName. The result
+is the result of the mutation - errors which are reported by the mutation fail
+the job.
+*/
+func mutationJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	part, _ := params["partition"].(string)
+	query, _ := params["query"].(string)
+
+	if part == "" || query == "" {
+		return nil, fmt.Errorf("Need a partition and a query")
+	} else if api.ReadOnly {
+		return nil, fmt.Errorf("Datastore is read-only")
+	}
+
+	res, err := graphql.RunQuery(stringutil.CreateDisplayString(part)+" mutation", part,
+		map[string]interface{}{
+			"operationName": params["operationName"],
+			"query":         query,
+			"variables":     params["variables"],
+		}, api.GM, nil, false)
+
+	if errs, ok := res["errors"]; err == nil && ok {
+		err = fmt.Errorf("Mutation failed: %v", errs)
+	}
+
+	return res, err
+}
+
+/*
+scriptJob runs a deployed script. Parameters are the name of the script and
+optional variables (vars). The result contains the result of the script.
+*/
+func scriptJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	name, _ := params["name"].(string)
+	vars, _ := params["vars"].(map[string]interface{})
+
+	if Scripts == nil {
+		return nil, fmt.Errorf("Scripts are not enabled")
+	} else if name == "" {
+		return nil, fmt.Errorf("Need a script name")
+	}
+
+	res, err := Scripts.Run(name, vars)
+
+	return map[string]interface{}{
+		"result": res,
+	}, err
+}
+
+/*
+JobsEndpointInst creates a new endpoint handler.
+*/
+func JobsEndpointInst() api.RestEndpointHandler {
+	return &jobsEndpoint{}
+}
+
+/*
+Handler object for job operations.
+*/
+type jobsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns a list of all jobs or the state and result of a single job.
+*/
+func (je *jobsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	jobsLock.Lock()
+
+	if len(resources) == 0 {
+		jobList := make([]*Job, 0, len(jobs))
+
+		for _, job := range jobs {
+
+			// Results are only returned for single jobs
+
+			jobList = append(jobList, &Job{job.ID, job.Type, job.Status,
+				job.Started, job.Finished, job.Done, job.Total, job.Error, nil})
+		}
+
+		sortJobs(jobList)
+
+		ret = jobList
+
+	} else if job, ok := jobs[resources[0]]; ok {
+		jobCopy := *job
+		ret = &jobCopy
+	}
+
+	jobsLock.Unlock()
+
+	if ret == nil {
+		http.Error(w, "Unknown job: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePOST starts a new job.
+*/
+func (je *jobsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	params := make(map[string]interface{})
+
+	if !checkResources(w, resources, 1, 1, "Need a job type") {
+		return
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := StartJob(resources[0], params)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id": id,
+	})
+}
+
+/*
+HandleDELETE removes a finished job.
+*/
+func (je *jobsEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a job ID") {
+		return
+	}
+
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	job, ok := jobs[resources[0]]
+
+	if !ok {
+		http.Error(w, "Unknown job: "+resources[0], http.StatusNotFound)
+	} else if job.Status == JobRunning {
+		http.Error(w, "Job is still running", http.StatusConflict)
+	} else {
+		delete(jobs, job.ID)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (je *jobsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/jobs"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all background jobs.",
+			"description": "The jobs endpoint returns the state of all running and recently finished background jobs.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of jobs.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/jobs/{id}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Start a background job.",
+			"description": "Starts a new background job of the given type (e.g. quality or reindex).",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "id",
+					"in":          "path",
+					"description": "Type of the job which should be started.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "params",
+					"in":          "body",
+					"description": "Parameters of the job.",
+					"required":    false,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the ID of the new job.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"get": map[string]interface{}{
+			"summary":     "Return a background job.",
+			"description": "Returns the state and the result of a background job.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "id",
+					"in":          "path",
+					"description": "ID of the job.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The job object.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a finished background job.",
+			"description": "Removes a finished job and its result.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "id",
+					"in":          "path",
+					"description": "ID of the job.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the job was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestJobs(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointJobs
+
+	oldGM := api.GM
+	oldHistorySize := JobHistorySize
+	defer func() {
+		api.GM = oldGM
+		JobHistorySize = oldHistorySize
+		delete(JobTypes, "test")
+	}()
+
+	api.GM, _ = songGraph()
+
+	waitForJob := func(id string) map[string]interface{} {
+		var job map[string]interface{}
+
+		for i := 0; i < 100; i++ {
+			_, _, res := sendTestRequest(queryURL+id, "GET", nil)
+			json.Unmarshal([]byte(res), &job)
+
+			if job["status"] != JobRunning {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return job
+	}
+
+	st, _, res := sendTestRequest(queryURL, "POST", nil)
+
+	if st != "400 Bad Request" || res != "Need a job type" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "POST", nil)
+
+	if st != "400 Bad Request" || res != "Unknown job type: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"quality", "POST", []byte("{"))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"unknown", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown job: unknown" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Start a job with missing parameters
+
+	var jres map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL+"quality", "POST", nil)
+	json.Unmarshal([]byte(res), &jres)
+
+	if st != "200 OK" || jres["id"] == nil {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	failedID := fmt.Sprint(jres["id"])
+
+	if job := waitForJob(failedID); job["status"] != JobFailed || job["error"] != "Need a partition" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	// Start a quality report
+
+	st, _, res = sendTestRequest(queryURL+"quality", "POST",
+		[]byte(`{"partition": "main", "kinds": ["Author"]}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if st != "200 OK" || jres["id"] == nil {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	qualityID := fmt.Sprint(jres["id"])
+
+	job := waitForJob(qualityID)
+
+	if job["status"] != JobFinished || job["type"] != "quality" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	kinds := job["result"].(map[string]interface{})["kinds"].(map[string]interface{})
+
+	if len(kinds) != 1 || kinds["Author"].(map[string]interface{})["count"] != float64(3) {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	// Running jobs cannot be removed
+
+	done := make(chan bool)
+	started := make(chan bool)
+
+	JobTypes["test"] = func(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+		progress(1, 2)
+		close(started)
+		<-done
+		return "test", nil
+	}
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", nil)
+	json.Unmarshal([]byte(res), &jres)
+
+	<-started
+
+	testID := fmt.Sprint(jres["id"])
+
+	st, _, res = sendTestRequest(queryURL+testID, "DELETE", nil)
+
+	if st != "409 Conflict" || res != "Job is still running" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var jobList []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &jobList)
+
+	if st != "200 OK" || len(jobList) != 3 || jobList[0]["id"] != failedID ||
+		jobList[1]["id"] != qualityID || jobList[1]["result"] != nil ||
+		jobList[2]["status"] != JobRunning || jobList[2]["done"] != float64(1) || jobList[2]["total"] != float64(2) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	close(done)
+
+	if job := waitForJob(testID); job["result"] != "test" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+testID, "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+testID, "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown job: "+testID {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Memory storages cannot be compacted
+
+	st, _, res = sendTestRequest(queryURL+"compact", "POST", []byte(`{"batch_size": 10}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(fmt.Sprint(jres["id"])); job["status"] != JobFailed ||
+		job["error"] != "GraphError: Invalid data (Graph storage does not support compaction)" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	sendTestRequest(queryURL+fmt.Sprint(jres["id"]), "DELETE", nil)
+
+	// Memory storages can be checked but not repaired
+
+	st, _, res = sendTestRequest(queryURL+"check", "POST", nil)
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(fmt.Sprint(jres["id"])); job["status"] != JobFinished ||
+		fmt.Sprint(job["result"].(map[string]interface{})["check"].(map[string]interface{})["problems"]) != "0" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	sendTestRequest(queryURL+fmt.Sprint(jres["id"]), "DELETE", nil)
+
+	st, _, res = sendTestRequest(queryURL+"check", "POST", []byte(`{"repair": "repaired"}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(fmt.Sprint(jres["id"])); job["status"] != JobFailed ||
+		job["error"] != "Repair needs a disk storage" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	sendTestRequest(queryURL+fmt.Sprint(jres["id"]), "DELETE", nil)
+
+	// Statistics of node kinds are collected and stored
+
+	st, _, res = sendTestRequest(queryURL+"analyze", "POST",
+		[]byte(`{"partition": "main", "kinds": ["Author"]}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(fmt.Sprint(jres["id"])); job["status"] != JobFinished ||
+		job["result"].([]interface{})[0].(map[string]interface{})["count"] != float64(3) ||
+		api.GM.Statistics("main", "Author") == nil || api.GM.Statistics("main", "Song") != nil {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	sendTestRequest(queryURL+fmt.Sprint(jres["id"]), "DELETE", nil)
+
+	// Keys cannot be changed if encryption is not enabled
+
+	for jobType, msg := range map[string]string{
+		"rotatekey": "Encryption keys cannot be changed",
+		"shred":     "Need a partition",
+	} {
+		st, _, res = sendTestRequest(queryURL+jobType, "POST", nil)
+		json.Unmarshal([]byte(res), &jres)
+
+		if job := waitForJob(fmt.Sprint(jres["id"])); job["status"] != JobFailed || job["error"] != msg {
+			t.Error("Unexpected result:", job)
+			return
+		}
+
+		sendTestRequest(queryURL+fmt.Sprint(jres["id"]), "DELETE", nil)
+	}
+
+	// Old finished jobs are removed
+
+	JobHistorySize = 1
+
+	lastID, _ := StartJob("quality", map[string]interface{}{"partition": "main"})
+	waitForJob(lastID)
+
+	jobsLock.Lock()
+	_, ok := jobs[failedID]
+	jobsLock.Unlock()
+
+	if ok {
+		t.Error("Old job should have been removed")
+		return
+	}
+}
+
+func TestJobsKeyObfuscation(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointJobs
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.KeyObfuscation = nil
+	}()
+
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+	api.KeyObfuscation, _ = api.NewTokenKeyObfuscator("secret")
+
+	for _, key := range []string{"a", "b"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", "John")
+		api.GM.StoreNode("main", node)
+	}
+
+	id, err := StartJob("quality", map[string]interface{}{"partition": "main"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res string
+
+	for i := 0; i < 100; i++ {
+		if _, _, res = sendTestRequest(queryURL+id, "GET", nil); !strings.Contains(res, JobRunning) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !strings.Contains(res, api.ExternalKey("Person", "a")) ||
+		!strings.Contains(res, api.ExternalKey("Person", "b")) || strings.Contains(res, `"a"`) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
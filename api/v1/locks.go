@@ -0,0 +1,157 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+EndpointLocks is the lock diagnostics endpoint URL (rooted).
+*/
+const EndpointLocks = api.APIRoot + APIv1 + "/locks/"
+
+/*
+LocksEndpointInst creates a new endpoint handler.
+*/
+func LocksEndpointInst() api.RestEndpointHandler {
+	return &locksEndpoint{}
+}
+
+/*
+Handler object for lock diagnostics.
+*/
+type locksEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all record locks of locking transactions with their holders
+and waiting requests.
+*/
+func (le *locksEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	lm := api.GM.LockManager()
+
+	// Map lock owners to the IDs of open REST transactions
+
+	owners := make(map[string]string)
+
+	transactionsLock.Lock()
+	for id, rt := range transactions {
+		if _, ok := rt.trans.(graph.LockingTrans); ok {
+			owners[rt.trans.ID()] = id
+		}
+	}
+	transactionsLock.Unlock()
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locks":        lm.Status(),
+		"deadlocks":    lm.Deadlocks(),
+		"transactions": owners,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (le *locksEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/locks"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return the record locks of locking transactions.",
+			"description": "The locks endpoint returns all locks which are held or waited for " +
+				"with their holders and waiting requests. Each waiting request lists the " +
+				"owners which block it. The response also contains the number of detected " +
+				"deadlocks and maps lock owners to the IDs of open transactions.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the current locks.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Locks",
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Locks"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"locks": map[string]interface{}{
+				"description": "Locks which are held or waited for sorted by name.",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"name": map[string]interface{}{
+							"description": "Name of the lock (partition:n|e:kind:key).",
+							"type":        "string",
+						},
+						"holders": map[string]interface{}{
+							"description": "Owners which hold the lock.",
+							"type":        "array",
+							"items": map[string]interface{}{
+								"type": "string",
+							},
+						},
+						"exclusive": map[string]interface{}{
+							"description": "Flag if the lock is held exclusively.",
+							"type":        "boolean",
+						},
+						"waiters": map[string]interface{}{
+							"description": "Waiting requests in the order of their arrival (owner, exclusive, since, waits_for).",
+							"type":        "array",
+							"items": map[string]interface{}{
+								"type": "object",
+							},
+						},
+					},
+				},
+			},
+			"deadlocks": map[string]interface{}{
+				"description": "Number of detected deadlocks.",
+				"type":        "integer",
+			},
+			"transactions": map[string]interface{}{
+				"description": "Map of lock owners to the IDs of open transactions.",
+				"type":        "object",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
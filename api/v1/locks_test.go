@@ -0,0 +1,171 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestLocks(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointLocks
+	txURL := "http://localhost" + TESTPORT + EndpointTx
+
+	begin := func() (string, string) {
+		var ret map[string]interface{}
+
+		_, _, res := sendTestRequest(txURL, "POST", []byte(`{"locking": true}`))
+		json.Unmarshal([]byte(res), &ret)
+
+		id, _ := ret["id"].(string)
+
+		_, _, res = sendTestRequest(txURL+id, "GET", nil)
+		json.Unmarshal([]byte(res), &ret)
+
+		owner, _ := ret["lock_owner"].(string)
+
+		return id, owner
+	}
+
+	locks := func() map[string]interface{} {
+		var ret map[string]interface{}
+
+		_, _, res := sendTestRequest(queryURL, "GET", nil)
+		json.Unmarshal([]byte(res), &ret)
+
+		return ret
+	}
+
+	deadlocks := api.GM.LockManager().Deadlocks()
+
+	if st, _, res := sendTestRequest(txURL, "POST", []byte(`{"locking": 1}`)); st != "400 Bad Request" ||
+		!strings.HasPrefix(res, "Could not decode request body:") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	id1, owner1 := begin()
+	id2, owner2 := begin()
+
+	if owner1 == "" || owner2 == "" {
+		t.Error("Unexpected transactions:", id1, owner1, id2, owner2)
+		return
+	}
+
+	if st, _, res := sendTestRequest(txURL+id1+"/main/n", "POST", []byte(`[{"key": "lock1", "kind": "locktest"}]`)); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res := sendTestRequest(txURL+id2+"/main/n", "POST", []byte(`[{"key": "lock2", "kind": "locktest"}]`)); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The second transaction waits for the lock of the first transaction
+
+	done := make(chan string, 1)
+
+	go func() {
+		st, _, res := sendTestRequest(txURL+id2+"/main/n", "PUT", []byte(`[{"key": "lock1", "kind": "locktest", "tx": 2}]`))
+		done <- st + res
+	}()
+
+	var ret map[string]interface{}
+
+	for i := 0; i < 100; i++ {
+		if ret = locks(); len(ret["locks"].([]interface{})) == 2 &&
+			len(ret["locks"].([]interface{})[0].(map[string]interface{})["waiters"].([]interface{})) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lock := ret["locks"].([]interface{})[0].(map[string]interface{})
+	waiter := lock["waiters"].([]interface{})[0].(map[string]interface{})
+
+	if lock["name"] != "main:n:locktest:lock1" || lock["exclusive"] != true ||
+		lock["holders"].([]interface{})[0] != owner1 || waiter["owner"] != owner2 ||
+		waiter["waits_for"].([]interface{})[0] != owner1 ||
+		ret["transactions"].(map[string]interface{})[owner1] != id1 ||
+		ret["deadlocks"] != float64(deadlocks) {
+		t.Error("Unexpected response:", ret)
+		return
+	}
+
+	// The first transaction would close a wait cycle and is rolled back
+
+	st, _, res := sendTestRequest(txURL+id1+"/main/n", "PUT", []byte(`[{"key": "lock2", "kind": "locktest"}]`))
+
+	if st != "409 Conflict" || res != "GraphError: Deadlock ("+owner1+
+		" cannot acquire lock main:n:locktest:lock2 - wait cycle: ["+owner1+" "+owner2+" "+owner1+"])" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if res := <-done; res != "200 OK" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	if st, _, res := sendTestRequest(txURL+id1, "POST", nil); st != "404 Not Found" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res := sendTestRequest(txURL+id2, "POST", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "lock1", "locktest"); err != nil || n.Attr("tx") != float64(2) {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if ret = locks(); len(ret["locks"].([]interface{})) != 0 || ret["deadlocks"] != float64(deadlocks+1) {
+		t.Error("Unexpected response:", ret)
+		return
+	}
+
+	// Rolled back transactions release their locks
+
+	id1, _ = begin()
+
+	sendTestRequest(txURL+id1+"/main/n", "DELETE", []byte(`[{"key": "lock1", "kind": "locktest"}]`))
+
+	if ret = locks(); len(ret["locks"].([]interface{})) != 1 {
+		t.Error("Unexpected response:", ret)
+		return
+	}
+
+	if st, _, res := sendTestRequest(txURL+id1, "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if ret = locks(); len(ret["locks"].([]interface{})) != 0 {
+		t.Error("Unexpected response:", ret)
+		return
+	}
+
+	api.GM.RemoveNode("main", "lock1", "locktest")
+	api.GM.RemoveNode("main", "lock2", "locktest")
+
+	if st, _, res := sendTestRequest(queryURL+"foo", "GET", nil); st != "400 Bad Request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+EndpointMerge is the merge endpoint URL (rooted). Handles everything under merge/...
+*/
+const EndpointMerge = api.APIRoot + APIv1 + "/merge/"
+
+/*
+MergeEndpointInst creates a new endpoint handler.
+*/
+func MergeEndpointInst() api.RestEndpointHandler {
+	return &mergeEndpoint{}
+}
+
+/*
+Handler object for merge operations.
+*/
+type mergeEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandlePOST merges nodes into a target node.
+*/
+func (me *mergeEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var req struct {
+		Target  string   `json:"target"`
+		Sources []string `json:"sources"`
+	}
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 2, "Need a partition and a node kind") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Target == "" || len(req.Sources) == 0 {
+		http.Error(w, "Need a target and a list of sources", http.StatusBadRequest)
+		return
+	}
+
+	part, kind := resources[0], resources[1]
+
+	target, err := api.InternalKey(kind, req.Target)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	sources := make([]string, len(req.Sources))
+
+	for i, source := range req.Sources {
+		if sources[i], err = api.InternalKey(kind, source); err != nil {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	node, err := api.GM.MergeNodes(part, kind, target, sources)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(jsonData(api.ResponseProjection.ForRequest(r).Data(node.Data())))
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (me *mergeEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/merge/{partition}/{kind}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Merge nodes into a target node.",
+			"description": "Copies missing attributes from the source nodes to the target node, " +
+				"moves all edges of the source nodes to the target node and removes the source nodes. " +
+				"Duplicate edges between merged nodes are removed.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition of the nodes.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node kind.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "merge",
+					"in":          "body",
+					"description": "Key of the target node and keys of the source nodes.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"target": map[string]interface{}{
+								"type": "string",
+							},
+							"sources": map[string]interface{}{
+								"type": "array",
+								"items": map[string]interface{}{
+									"type": "string",
+								},
+							},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The merged node.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestDedupAndMerge(t *testing.T) {
+	jobsURL := "http://localhost" + TESTPORT + EndpointJobs
+	queryURL := "http://localhost" + TESTPORT + EndpointMerge
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.ReadOnly = false
+	}()
+
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for key, name := range map[string]string{"a": "John Smith", "b": "Jon Smith", "c": "Mike Miller"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", name)
+		node.SetAttr("city", "London")
+		node.SetAttr(key, true)
+		api.GM.StoreNode("main", node)
+	}
+
+	runJob := func(params string) map[string]interface{} {
+		var job map[string]interface{}
+
+		_, _, res := sendTestRequest(jobsURL+"dedup", "POST", []byte(params))
+		json.Unmarshal([]byte(res), &job)
+
+		id := fmt.Sprint(job["id"])
+
+		for i := 0; i < 100; i++ {
+			_, _, res := sendTestRequest(jobsURL+id, "GET", nil)
+			json.Unmarshal([]byte(res), &job)
+
+			if job["status"] != JobRunning {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return job
+	}
+
+	if job := runJob(`{"partition": "main", "kind": "Person", "rules": [1]}`); job["status"] != JobFailed ||
+		job["error"] != "Matching rules must be objects" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	job := runJob(`{"partition": "main", "kind": "Person", "rules": [{"attr": "city"}, ` +
+		`{"attr": "name", "fuzzy": true, "threshold": 0.8}]}`)
+
+	if res, _ := json.Marshal(job["result"]); job["status"] != JobFinished ||
+		string(res) != `{"candidates":[{"key1":"a","key2":"b","score":0.95}]}` {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	if api.GM.EdgeCount(graph.DuplicateEdgeKind) != 1 {
+		t.Error("Unexpected result:", api.GM.EdgeCount(graph.DuplicateEdgeKind))
+		return
+	}
+
+	// Merge the candidates
+
+	st, _, res := sendTestRequest(queryURL+"main", "POST", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Need a partition and a node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Person", "POST", []byte(`{"target": "a"}`))
+
+	if st != "400 Bad Request" || res != "Need a target and a list of sources" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Person", "POST", []byte(`{"target": "a", "sources": ["x"]}`))
+
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Unknown node: x (Person))" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Person", "POST", []byte(`{"target": "a", "sources": ["b"]}`))
+
+	if st != "200 OK" || res != `{
+  "a": true,
+  "b": true,
+  "city": "London",
+  "key": "a",
+  "kind": "Person",
+  "name": "John Smith"
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if node, _ := api.GM.FetchNode("main", "b", "Person"); node != nil ||
+		api.GM.EdgeCount(graph.DuplicateEdgeKind) != 0 {
+		t.Error("Unexpected result:", node, api.GM.EdgeCount(graph.DuplicateEdgeKind))
+		return
+	}
+
+	api.ReadOnly = true
+
+	st, _, res = sendTestRequest(queryURL+"main/Person", "POST", []byte(`{"target": "a", "sources": ["c"]}`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
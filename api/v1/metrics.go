@@ -0,0 +1,381 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+/*
+EndpointMetrics is the metrics endpoint URL (rooted). Handles everything under metrics/...
+*/
+const EndpointMetrics = api.APIRoot + APIv1 + "/metrics/"
+
+/*
+GraphMetricsMaxAge is the time for which calculated graph metrics are reused.
+Calculating graph metrics reads all nodes and edges of a partition.
+*/
+var GraphMetricsMaxAge = time.Minute
+
+/*
+MetricsEndpointInst creates a new endpoint handler.
+*/
+func MetricsEndpointInst() api.RestEndpointHandler {
+	return &metricsEndpoint{}
+}
+
+/*
+Handler object for metrics queries.
+*/
+type metricsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns metrics in the Prometheus text format or graph metrics of
+partitions as JSON.
+*/
+func (me *metricsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if len(resources) > 0 {
+
+		if resources[0] != "graph" {
+			http.Error(w, "Unknown resource: "+resources[0], http.StatusBadRequest)
+			return
+		}
+
+		me.handleGraphMetrics(w, r, resources[1:])
+		return
+	}
+
+	stats := file.DefaultPageCache.Stats()
+
+	w.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "eliasdb_page_cache_max_size_bytes", "Capacity of the page cache in bytes.",
+		"gauge", stats.MaxSize)
+	writeMetric(w, "eliasdb_page_cache_size_bytes", "Bytes of records which are held in the page cache.",
+		"gauge", stats.Size)
+	writeMetric(w, "eliasdb_page_cache_hits_total", "Number of records which were found in memory.",
+		"counter", stats.Hits)
+	writeMetric(w, "eliasdb_page_cache_misses_total", "Number of records which had to be read from disk.",
+		"counter", stats.Misses)
+	writeMetric(w, "eliasdb_page_cache_evictions_total", "Number of records which were removed from the page cache.",
+		"counter", stats.Evictions)
+
+	plans := eql.PlanCacheStats()
+
+	writeMetric(w, "eliasdb_plan_cache_max_size", "Maximum number of query plans in the plan cache.",
+		"gauge", plans.MaxSize)
+	writeMetric(w, "eliasdb_plan_cache_size", "Number of query plans which are held in the plan cache.",
+		"gauge", plans.Size)
+	writeMetric(w, "eliasdb_plan_cache_hits_total", "Number of queries which used a cached query plan.",
+		"counter", plans.Hits)
+	writeMetric(w, "eliasdb_plan_cache_misses_total", "Number of queries which had to be parsed.",
+		"counter", plans.Misses)
+
+	remote := graph.RemoteFetchStatistics()
+
+	writeMetric(w, "eliasdb_remote_fetch_calls_total", "Number of remote calls which fetched nodes of other shards.",
+		"counter", remote.Calls)
+	writeMetric(w, "eliasdb_remote_fetch_nodes_total", "Number of nodes which were fetched from other shards.",
+		"counter", remote.Nodes)
+	writeMetric(w, "eliasdb_remote_fetch_errors_total", "Number of remote calls to other shards which failed.",
+		"counter", remote.Errors)
+	writeMetric(w, "eliasdb_remote_fetch_budget_exceeded_total", "Number of traversals which exceeded their remote call budget.",
+		"counter", remote.BudgetExceeded)
+}
+
+/*
+writeMetric writes a single metric in the Prometheus text format.
+*/
+func writeMetric(w io.Writer, name string, help string, metricType string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", name, help, name, metricType, name, value)
+}
+
+/*
+cachedGraphMetrics are graph metrics of a partition with their calculation time.
+*/
+type cachedGraphMetrics struct {
+	*graph.PartitionMetrics
+	Calculated time.Time `json:"calculated"` // Time when the metrics were calculated
+
+	gm *graph.Manager // Graph manager of the metrics
+}
+
+/*
+graphMetricsCache holds the last calculated graph metrics of all partitions.
+*/
+var graphMetricsCache = make(map[string]*cachedGraphMetrics)
+
+/*
+graphMetricsCacheLock protects the graph metrics cache.
+*/
+var graphMetricsCacheLock = &sync.Mutex{}
+
+/*
+handleGraphMetrics returns the graph metrics of all partitions or of a single
+partition. Metrics are recalculated if they are older than GraphMetricsMaxAge
+or if the refresh parameter is set.
+*/
+func (me *metricsEndpoint) handleGraphMetrics(w http.ResponseWriter, r *http.Request, resources []string) {
+	var res interface{}
+
+	refresh := stringutil.IsTrueValue(r.URL.Query().Get("refresh"))
+
+	if len(resources) > 0 {
+
+		metrics, err := partitionMetrics(resources[0], refresh)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res = metrics
+
+	} else {
+
+		allMetrics := make(map[string]*cachedGraphMetrics)
+
+		for _, part := range api.GM.Partitions() {
+
+			metrics, err := partitionMetrics(part, refresh)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			allMetrics[part] = metrics
+		}
+
+		res = allMetrics
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(res)
+}
+
+/*
+partitionMetrics returns the graph metrics of a partition. Metrics are only
+calculated if there are no cached metrics which are young enough.
+*/
+func partitionMetrics(part string, refresh bool) (*cachedGraphMetrics, error) {
+	graphMetricsCacheLock.Lock()
+	defer graphMetricsCacheLock.Unlock()
+
+	cached, ok := graphMetricsCache[part]
+
+	if !ok || refresh || cached.gm != api.GM || time.Since(cached.Calculated) > GraphMetricsMaxAge {
+
+		metrics, err := api.GM.PartitionMetrics(part)
+		if err != nil {
+			return nil, err
+		}
+
+		cached = &cachedGraphMetrics{metrics, time.Now(), api.GM}
+		graphMetricsCache[part] = cached
+	}
+
+	return cached, nil
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (me *metricsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/metrics"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return metrics of the datastore.",
+			"description": "The metrics endpoint returns metrics (e.g. page cache and query plan cache statistics) in the Prometheus text format.",
+			"produces": []string{
+				"text/plain",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Metrics in the Prometheus text format.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/metrics/graph"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return graph metrics of all partitions.",
+			"description": "The metrics endpoint returns structural metrics of the graph in each partition (node and edge counts per kind, density, connected components and a degree histogram). Metrics are cached and only recalculated if they are older than a configured age.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				graphMetricsRefreshParam,
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Graph metrics of each partition.",
+					"schema": map[string]interface{}{
+						"type": "object",
+						"additionalProperties": map[string]interface{}{
+							"$ref": "#/definitions/GraphMetrics",
+						},
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/metrics/graph/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return graph metrics of a partition.",
+			"description": "The metrics endpoint returns structural metrics of the graph in a single partition.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				graphMetricsRefreshParam,
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Graph metrics of the partition.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/GraphMetrics",
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["GraphMetrics"] = map[string]interface{}{
+		"description": "Structural metrics of the graph in a partition.",
+		"type":        "object",
+		"properties": map[string]interface{}{
+			"partition": map[string]interface{}{
+				"description": "Name of the partition.",
+				"type":        "string",
+			},
+			"nodes": map[string]interface{}{
+				"description": "Number of nodes.",
+				"type":        "integer",
+			},
+			"edges": map[string]interface{}{
+				"description": "Number of edges.",
+				"type":        "integer",
+			},
+			"node_counts": map[string]interface{}{
+				"description": "Number of nodes of each kind.",
+				"type":        "object",
+			},
+			"edge_counts": map[string]interface{}{
+				"description": "Number of edges of each kind.",
+				"type":        "object",
+			},
+			"density": map[string]interface{}{
+				"description": "Ratio of edges to possible edges between different nodes.",
+				"type":        "number",
+			},
+			"components": map[string]interface{}{
+				"description": "Number of connected components.",
+				"type":        "integer",
+			},
+			"largest_component": map[string]interface{}{
+				"description": "Number of nodes in the largest connected component.",
+				"type":        "integer",
+			},
+			"isolated_nodes": map[string]interface{}{
+				"description": "Number of nodes without edges.",
+				"type":        "integer",
+			},
+			"max_degree": map[string]interface{}{
+				"description": "Highest number of edges of a node.",
+				"type":        "integer",
+			},
+			"degree_histogram": map[string]interface{}{
+				"description": "Number of nodes by number of edges. Bucket sizes grow exponentially.",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"min": map[string]interface{}{
+							"description": "Smallest degree in the bucket.",
+							"type":        "integer",
+						},
+						"max": map[string]interface{}{
+							"description": "Largest degree in the bucket.",
+							"type":        "integer",
+						},
+						"count": map[string]interface{}{
+							"description": "Number of nodes with a degree in the bucket.",
+							"type":        "integer",
+						},
+					},
+				},
+			},
+			"calculated": map[string]interface{}{
+				"description": "Time when the metrics were calculated.",
+				"type":        "string",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
+
+/*
+graphMetricsRefreshParam is the swagger definition of the refresh parameter.
+*/
+var graphMetricsRefreshParam = map[string]interface{}{
+	"name":        "refresh",
+	"in":          "query",
+	"description": "Recalculate the metrics even if cached metrics are available.",
+	"required":    false,
+	"type":        "boolean",
+}
@@ -0,0 +1,137 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+func TestMetrics(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointMetrics
+
+	stats := file.DefaultPageCache.Stats()
+
+	st, header, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || header.Get("content-type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	if !strings.HasPrefix(res, fmt.Sprintf(`
+# HELP eliasdb_page_cache_max_size_bytes Capacity of the page cache in bytes.
+# TYPE eliasdb_page_cache_max_size_bytes gauge
+eliasdb_page_cache_max_size_bytes %v
+# HELP eliasdb_page_cache_size_bytes Bytes of records which are held in the page cache.
+# TYPE eliasdb_page_cache_size_bytes gauge
+`[1:], stats.MaxSize)) || !strings.Contains(res, "# TYPE eliasdb_page_cache_evictions_total counter\n") ||
+		!strings.Contains(res, "# TYPE eliasdb_plan_cache_hits_total counter\n") ||
+		!strings.Contains(res, "# TYPE eliasdb_remote_fetch_calls_total counter\n") {
+		t.Error("Unexpected response:", res)
+		return
+	}
+}
+
+func TestGraphMetrics(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointMetrics + "graph"
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	var allMetrics map[string]map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &allMetrics); st != "200 OK" || err != nil {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	if m := allMetrics["main"]; fmt.Sprint(m["node_counts"], m["edge_counts"],
+		m["components"], m["max_degree"]) != "map[Author:3 Song:9 Spam:21] map[Wrote:9] 24 4" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	calculated := allMetrics["main"]["calculated"]
+
+	// Metrics of a single partition are cached
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "metrics1")
+	node.SetAttr("kind", "Author")
+	api.GM.StoreNode("main", node)
+
+	st, _, res = sendTestRequest(queryURL+"/main", "GET", nil)
+
+	var metrics map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &metrics); st != "200 OK" || err != nil ||
+		metrics["nodes"] != float64(33) || metrics["calculated"] != calculated {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main?refresh=true", "GET", nil)
+
+	if err := json.Unmarshal([]byte(res), &metrics); st != "200 OK" || err != nil ||
+		metrics["nodes"] != float64(34) || metrics["isolated_nodes"] != float64(22) {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	oldMaxAge := GraphMetricsMaxAge
+	GraphMetricsMaxAge = 0
+	defer func() {
+		GraphMetricsMaxAge = oldMaxAge
+	}()
+
+	time.Sleep(time.Millisecond)
+
+	node.SetAttr("key", "metrics2")
+	api.GM.StoreNode("main", node)
+
+	st, _, res = sendTestRequest(queryURL+"/main", "GET", nil)
+
+	if err := json.Unmarshal([]byte(res), &metrics); st != "200 OK" || err != nil ||
+		metrics["nodes"] != float64(35) {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	// Test error cases
+
+	st, _, res = sendTestRequest(queryURL+"/my-part", "GET", nil)
+
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Partition name my-part is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointMetrics+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown resource: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
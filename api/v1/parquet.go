@@ -0,0 +1,357 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/arrow"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/parquet"
+)
+
+/*
+EndpointParquet is the Parquet endpoint URL (rooted). Handles everything under parquet/...
+*/
+const EndpointParquet = api.APIRoot + APIv1 + "/parquet/"
+
+/*
+ParquetRowGroupSize is the number of rows in each row group of an exported
+Parquet file. The column types of a file are inferred from its first row group.
+*/
+var ParquetRowGroupSize = 10000
+
+/*
+ParquetEndpointInst creates a new endpoint handler.
+*/
+func ParquetEndpointInst() api.RestEndpointHandler {
+	return &parquetEndpoint{}
+}
+
+/*
+Handler object for Parquet files.
+*/
+type parquetEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET exports all nodes of a kind as Parquet file. The columns are the key,
+the kind and all attributes of the kind.
+*/
+func (pe *parquetEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var pw *parquet.Writer
+
+	part, kind, ok := pe.checkKindResources(w, resources)
+	if !ok {
+		return
+	}
+
+	it, err := api.GM.NodeKeyIteratorContext(r.Context(), part, kind)
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if it == nil {
+		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
+		return
+	}
+
+	proj := api.ResponseProjection.ForRequest(r)
+
+	names := []string{data.NodeKey, data.NodeKind}
+
+	for _, attr := range api.GM.NodeAttrs(kind) {
+		if attr != data.NodeKey && attr != data.NodeKind && proj.Allowed(kind, attr) {
+			names = append(names, attr)
+		}
+	}
+
+	rows := make([][]interface{}, 0, ParquetRowGroupSize)
+
+	writeRowGroup := func() error {
+		var err error
+
+		if pw == nil {
+			w.Header().Set("content-type", parquet.ContentType)
+			w.Header().Set("content-disposition", fmt.Sprintf(`attachment; filename="%v.parquet"`, kind))
+
+			if pw, err = parquet.NewWriter(w, arrow.InferFields(names, rows)); err != nil {
+				return err
+			}
+		}
+
+		err = pw.WriteRowGroup(rows)
+		rows = rows[:0]
+
+		return err
+	}
+
+	for it.HasNext() {
+		key := it.Next()
+
+		if it.LastError != nil {
+			err = it.LastError
+			break
+		}
+
+		node, err2 := api.GM.FetchNode(part, key, kind)
+		if err = err2; err != nil {
+			break
+		} else if node == nil {
+			continue
+		}
+
+		nodeData := externalData(proj.Data(node.Data()))
+		row := make([]interface{}, len(names))
+
+		for i, name := range names {
+			row[i] = nodeData[name]
+		}
+
+		if rows = append(rows, row); len(rows) == ParquetRowGroupSize {
+			if err = writeRowGroup(); err != nil {
+				break
+			}
+		}
+	}
+
+	// Write the last row group - a file without rows still has a schema
+
+	if err == nil && (len(rows) > 0 || pw == nil) {
+		err = writeRowGroup()
+	}
+
+	if err != nil {
+
+		// Errors which occur after the file was started end the file
+		// without a footer
+
+		if pw == nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pw.Close()
+}
+
+/*
+parquetSummary is the response of a Parquet import.
+*/
+type parquetSummary struct {
+	NodesStored int `json:"nodes_stored"` // Number of written nodes
+}
+
+/*
+HandlePOST imports the rows of a Parquet file as nodes of a kind. The file
+needs a key column - all other columns (except a kind column) are stored as
+node attributes. Null values are not stored. All nodes are written in a single
+transaction.
+*/
+func (pe *parquetEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	part, kind, ok := pe.checkKindResources(w, resources)
+	if !ok {
+		return
+	}
+
+	// Parquet files are read from the end so the whole file is needed
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Could not read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pr, err := parquet.NewReader(bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer pr.Close()
+
+	keyCol := -1
+
+	for i, name := range pr.Names() {
+		if name == data.NodeKey {
+			keyCol = i
+		}
+	}
+
+	if keyCol == -1 {
+		http.Error(w, "Parquet file needs a key column", http.StatusBadRequest)
+		return
+	}
+
+	trans := graph.NewGraphTrans(api.GM)
+	summary := &parquetSummary{0}
+
+	for {
+		rows, err := pr.Next()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if rows == nil {
+			break
+		}
+
+		for _, row := range rows {
+
+			if row[keyCol] == nil {
+				http.Error(w, fmt.Sprintf("Row %v has no key", summary.NodesStored+1), http.StatusBadRequest)
+				return
+			}
+
+			ndata := map[string]interface{}{
+				data.NodeKey:  fmt.Sprint(row[keyCol]),
+				data.NodeKind: kind,
+			}
+
+			for i, name := range pr.Names() {
+				if i != keyCol && name != data.NodeKind && row[i] != nil {
+					ndata[name] = row[i]
+				}
+			}
+
+			if err := internalData(ndata); err != nil {
+				api.ReportError(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			if err := trans.StoreNode(part, data.NewGraphNodeFromMap(ndata)); err != nil {
+				api.ReportError(w, r, err, transErrorStatus(err))
+				return
+			}
+
+			summary.NodesStored++
+		}
+	}
+
+	if err := trans.Commit(); err != nil {
+		api.ReportError(w, r, err, commitErrorStatus(err))
+		return
+	}
+
+	setCommitSeqHeader(w, api.GM, []string{part})
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(summary)
+}
+
+/*
+checkKindResources checks that the resources of a request are a partition, the
+entity type n and a kind. Writes an error and returns false if they are not.
+*/
+func (pe *parquetEndpoint) checkKindResources(w http.ResponseWriter, resources []string) (string, string, bool) {
+
+	if len(resources) != 3 || resources[1] != "n" {
+		http.Error(w, "Need a partition, entity type (n) and a kind", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return resources[0], resources[2], true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (pe *parquetEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	params := []map[string]interface{}{
+		{
+			"name":        "partition",
+			"in":          "path",
+			"description": "Partition to select.",
+			"required":    true,
+			"type":        "string",
+		},
+		{
+			"name":        "kind",
+			"in":          "path",
+			"description": "Node kind.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/parquet/{partition}/n/{kind}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return all nodes of a kind as Parquet file.",
+			"description": "The columns of the file are the key, the kind and all attributes of the node kind. " +
+				"The column types are inferred from the first row group.",
+			"produces": []string{
+				"text/plain",
+				parquet.ContentType,
+			},
+			"parameters": params,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A Parquet file.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store the rows of a Parquet file as nodes of a kind.",
+			"description": "The file needs a key column. All other columns are stored as node attributes - " +
+				"null values are not stored.",
+			"consumes": []string{
+				parquet.ContentType,
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": append(params, map[string]interface{}{
+				"name":        "file",
+				"in":          "body",
+				"description": "Parquet file.",
+				"required":    true,
+				"schema": map[string]interface{}{
+					"type":   "string",
+					"format": "binary",
+				},
+			}),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Number of stored nodes.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/arrow"
+	"github.com/krotik/eliasdb/parquet"
+)
+
+func TestParquet(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointParquet
+
+	oldRowGroupSize := ParquetRowGroupSize
+	ParquetRowGroupSize = 4
+	defer func() {
+		ParquetRowGroupSize = oldRowGroupSize
+	}()
+
+	defer func() {
+		it, _ := api.GM.NodeKeyIterator("parquettest", "Song")
+		for it != nil && it.HasNext() {
+			api.GM.RemoveNode("parquettest", it.Next(), "Song")
+		}
+	}()
+
+	// Export all songs
+
+	st, header, res := sendTestRequest(queryURL+"main/n/Song", "GET", nil)
+
+	if st != "200 OK" || header.Get("Content-Type") != parquet.ContentType {
+		t.Error("Unexpected response:", st, header)
+		return
+	}
+
+	pr, err := parquet.NewReader(bytes.NewReader([]byte(res)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows, err := pr.Next()
+	pr.Close()
+
+	if res := fmt.Sprint(pr.Names(), " ", pr.NumRows()); err != nil || res != "[key kind name ranking] 9" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := fmt.Sprintf("%T %T", rows[0][2], rows[0][3]); res != "string int64" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Import the exported songs into another partition
+
+	st, _, res = sendTestRequest(queryURL+"parquettest/n/Song", "POST", []byte(res))
+
+	if st != "200 OK" || res != `{
+  "nodes_stored": 9
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if node, err := api.GM.FetchNode("parquettest", "LoveSong3", "Song"); err != nil ||
+		fmt.Sprintf("%v %v %T", node.Attr("name"), node.Attr("ranking"), node.Attr("ranking")) != "LoveSong3 1 int64" {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Rows without a key and files without a key column cannot be imported
+
+	var buf bytes.Buffer
+
+	pw, _ := parquet.NewWriter(&buf, []arrow.Field{{Name: "key", Type: arrow.TypeUtf8}})
+	pw.WriteRowGroup([][]interface{}{{"a"}, {nil}})
+	pw.Close()
+
+	st, _, res = sendTestRequest(queryURL+"parquettest/n/Song", "POST", buf.Bytes())
+
+	if st != "400 Bad Request" || res != "Row 2 has no key" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	buf.Reset()
+
+	pw, _ = parquet.NewWriter(&buf, []arrow.Field{{Name: "name", Type: arrow.TypeUtf8}})
+	pw.WriteRowGroup([][]interface{}{{"a"}})
+	pw.Close()
+
+	st, _, res = sendTestRequest(queryURL+"parquettest/n/Song", "POST", buf.Bytes())
+
+	if st != "400 Bad Request" || res != "Parquet file needs a key column" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"parquettest/n/Song", "POST", []byte("foo"))
+
+	if st != "400 Bad Request" || !strings.HasPrefix(res, "Could not read Parquet file: ") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/n/foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/e/Song", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a partition, entity type (n) and a kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"parquettest/n/Song", "POST", buf.Bytes())
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -21,6 +21,8 @@ import (
 	"github.com/krotik/common/stringutil"
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/data"
 )
 
@@ -39,6 +41,12 @@ ResultCache is a cache for result sets (by default no expiry and no limit)
 */
 var ResultCache *datautil.MapCache
 
+/*
+QueryOptions are the evaluation options of queries which are run through the
+REST API (e.g. the guards for traversals).
+*/
+var QueryOptions = &eql.QueryOptions{}
+
 /*
 idCount is an ID counter for results
 */
@@ -68,6 +76,8 @@ Handler object for search queries.
 */
 type queryEndpoint struct {
 	*api.DefaultEndpointHandler
+	maxRows int  // Maximum number of returned rows (0 for no maximum)
+	noCache bool // Flag if results should not be stored in the result cache
 }
 
 /*
@@ -76,6 +86,8 @@ HandleGET handles a search query REST call.
 func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
 	var err error
 
+	gm := api.RequestGM(r)
+
 	// Check parameters
 
 	if !checkResources(w, resources, 1, 1, "Need a partition") {
@@ -93,6 +105,10 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	if eq.maxRows > 0 && (limit == -1 || limit > eq.maxRows) {
+		limit = eq.maxRows
+	}
+
 	// Get offset parameter; -1 if not set
 
 	offset, ok := queryParamPosNum(w, r, "offset")
@@ -105,18 +121,23 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 	gs := r.URL.Query().Get("groups")
 	showGroups := gs != ""
 
+	// Get count parameter
+
+	countRows := r.URL.Query().Get("count") != ""
+
 	// See if a result ID was given
 
 	resID := r.URL.Query().Get("rid")
 	if resID != "" {
 
-		res, ok := ResultCache.Get(resID)
+		res, ok := ResultCache.Get(resultCacheKey(r, resID))
 		if !ok {
 			http.Error(w, "Unknown result ID (rid parameter)", http.StatusBadRequest)
 			return
 		}
 
-		err = eq.writeResultData(w, res.(*APISearchResult), part, resID, offset, limit, showGroups)
+		err = eq.writeResultData(w, gm, res.(*APISearchResult), part, resID, offset, limit, showGroups,
+			countRows, api.ResponseProjection.ForRequest(r))
 
 	} else {
 		var res eql.SearchResult
@@ -130,40 +151,85 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			return
 		}
 
-		res, err = eql.RunQuery(stringutil.CreateDisplayString(part)+" query",
-			part, query, api.GM)
+		// Translate the external IDs of a lookup query
+
+		if query, err = internalQuery(query); err != nil {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Rows after a requested limit do not need to be evaluated if the
+		// result is not ordered, filtered or aggregated - one more row than
+		// requested shows if there are further rows. Rows outside of the
+		// window are only counted if the exact count was requested.
+
+		opts := QueryOptions
+
+		if limit != -1 && r.URL.Query().Get("limit") != "" {
+			wopts := *QueryOptions
+			wopts.RowLimit = limit + 1
+			wopts.CountRows = countRows
+			if offset > 0 {
+				wopts.RowOffset = offset
+			}
+			opts = &wopts
+		}
+
+		res, err = eql.RunQueryWithOptions(r.Context(), stringutil.CreateDisplayString(part)+" query",
+			part, query, gm, opts)
 
 		if err == nil {
 			sres := &APISearchResult{res, nil}
 
+			if res.Windowed() && offset > 0 && res.RowCount() == 0 {
+				http.Error(w, "Offset exceeds available rows", http.StatusBadRequest)
+				return
+			}
+
 			// Make sure the result has a primary node column
 
 			_, err = sres.GetPrimaryNodeColumn()
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				api.ReportError(w, r, err, http.StatusBadRequest)
 				return
 			}
 
-			// Store the result in the cache
+			// Store the result in the cache unless the query has a no_cache hint
+			// or the result only holds a window of rows
 
-			resID = genID()
+			if _, ok := res.Hints()[eql.HintNoCache]; !eq.noCache && !ok && !res.Windowed() {
+				resID = genID()
 
-			ResultCache.Put(resID, sres)
+				ResultCache.Put(resultCacheKey(r, resID), sres)
+			}
 
-			err = eq.writeResultData(w, sres, part, resID, offset, limit, showGroups)
+			err = eq.writeResultData(w, gm, sres, part, resID, offset, limit, showGroups,
+				countRows, api.ResponseProjection.ForRequest(r))
 		}
 	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.ReportError(w, r, err, http.StatusInternalServerError)
 	}
 }
 
 /*
-writeResultData writes result data for the client.
+resultCacheKey returns the key of a result in the result cache. Results of
+databases are kept apart from the results of the main REST API.
 */
-func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res *APISearchResult,
-	part string, resID string, offset int, limit int, showGroups bool) error {
+func resultCacheKey(r *http.Request, resID string) string {
+	if db := api.RequestDatabase(r); db != "" {
+		return db + "/" + resID
+	}
+	return resID
+}
+
+/*
+writeResultData writes result data for the client. The exact number of all
+rows is written in an extra header if countRows is set.
+*/
+func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, gm *graph.Manager, res *APISearchResult,
+	part string, resID string, offset int, limit int, showGroups bool, countRows bool, proj *api.Projection) error {
 	var err error
 
 	// Write out the data
@@ -188,6 +254,29 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res *APISearchRe
 
 	rows := res.Rows()
 	srcs := res.RowSources()
+	totalCount := fmt.Sprint(res.RowCount())
+	filteredCount := ""
+
+	if res.Windowed() {
+
+		// The rows of a windowed result start at the offset - the total count
+		// is only known if there is no row after the requested rows or if
+		// all rows were counted
+
+		if trc := res.TotalRowCount(); trc != -1 {
+			totalCount = fmt.Sprint(trc)
+		} else if len(rows) > limit {
+			totalCount = ""
+		} else if offset > 0 {
+			totalCount = fmt.Sprint(offset + len(rows))
+		}
+
+		offset = -1
+	}
+
+	if countRows {
+		filteredCount = totalCount
+	}
 
 	if limit == -1 && offset == -1 {
 		resdata["rows"] = rows
@@ -252,7 +341,7 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res *APISearchRe
 					groups := make([]string, 0, 3)
 					key := strings.Split(s[col], ":")[2]
 
-					nodes, _, err = api.GM.TraverseMulti(part, key, pk,
+					nodes, _, err = gm.TraverseMulti(part, key, pk,
 						":::"+eql.GroupNodeKind, false)
 
 					if err == nil {
@@ -271,10 +360,30 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res *APISearchRe
 
 	if err == nil {
 
+		// Remove values which may not be returned
+
+		if proj != nil {
+			rows = projectRows(proj, header.Data(), rows, srcs)
+			resdata["rows"] = rows
+		}
+
+		// Translate keys if key obfuscation is enabled
+
+		if api.KeyObfuscation != nil {
+			resdata["rows"], resdata["sources"] = externalRows(header.Data(), rows, srcs)
+		}
+
 		// Set response header values
 
-		w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(res.RowCount()))
-		w.Header().Add(HTTPHeaderCacheID, resID)
+		if totalCount != "" {
+			w.Header().Add(HTTPHeaderTotalCount, totalCount)
+		}
+		if filteredCount != "" {
+			w.Header().Add(HTTPHeaderFilteredCount, filteredCount)
+		}
+		if resID != "" {
+			w.Header().Add(HTTPHeaderCacheID, resID)
+		}
 
 		w.Header().Set("content-type", "application/json; charset=utf-8")
 
@@ -284,6 +393,102 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res *APISearchRe
 	return err
 }
 
+/*
+projectRows returns a copy of result rows which only contains values of
+allowed attributes. Values of attributes which are not allowed are set to nil.
+*/
+func projectRows(proj *api.Projection, colData []string, rows [][]interface{}, srcs [][]string) [][]interface{} {
+	projRows := make([][]interface{}, len(rows))
+
+	for i, row := range rows {
+		projRow := make([]interface{}, len(row))
+
+		for j, val := range row {
+			projRow[j] = val
+
+			if j < len(colData) && j < len(srcs[i]) {
+				cd := strings.SplitN(colData[j], ":", 3)
+				src := strings.SplitN(srcs[i][j], ":", 3)
+
+				if len(cd) == 3 && len(src) == 3 && !proj.Allowed(src[1], cd[2]) {
+					projRow[j] = nil
+				}
+			}
+		}
+
+		projRows[i] = projRow
+	}
+
+	return projRows
+}
+
+/*
+externalRows returns copies of result rows and row sources with all keys
+translated into external IDs.
+*/
+func externalRows(colData []string, rows [][]interface{}, srcs [][]string) ([][]interface{}, [][]string) {
+	extRows := make([][]interface{}, len(rows))
+	extSrcs := make([][]string, len(srcs))
+
+	for i, row := range rows {
+		extRow := make([]interface{}, len(row))
+		extSrc := make([]string, len(srcs[i]))
+
+		for j, src := range srcs[i] {
+			extSrc[j] = externalSource(src)
+		}
+
+		for j, val := range row {
+			extRow[j] = val
+
+			if j < len(colData) && j < len(extSrc) && strings.HasSuffix(colData[j], ":"+data.NodeKey) {
+				if s := strings.SplitN(extSrc[j], ":", 3); len(s) == 3 {
+					extRow[j] = s[2]
+				}
+			}
+		}
+
+		extRows[i] = extRow
+		extSrcs[i] = extSrc
+	}
+
+	return extRows, extSrcs
+}
+
+/*
+internalQuery translates the external IDs of a lookup query into keys. Other
+queries are returned unchanged.
+*/
+func internalQuery(query string) (string, error) {
+
+	if api.KeyObfuscation == nil || strings.ToLower(parser.FirstWord(query)) != parser.NodeLOOKUP {
+		return query, nil
+	}
+
+	ast, err := eql.ParseQuery("query", query)
+	if err != nil {
+
+		// Errors are reported when the query is run
+
+		return query, nil
+	}
+
+	kind := ast.Children[0].Token.Val
+
+	for _, c := range ast.Children[1:] {
+
+		if c.Name != parser.NodeVALUE {
+			break
+		}
+
+		if c.Token.Val, err = api.InternalKey(kind, c.Token.Val); err != nil {
+			return "", err
+		}
+	}
+
+	return parser.PrettyPrint(ast)
+}
+
 /*
 SwaggerDefs is used to describe the endpoint in swagger.
 */
@@ -298,7 +503,13 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"queries against partitions. The return value is always a list " +
 				"(even if there is only a single entry). A query result gets an " +
 				"ID and is stored in a cache. The ID is returned in the X-Cache-Id " +
-				"header. Subsequent requests for the same result can use the ID instead of a query.",
+				"header. Subsequent requests for the same result can use the ID instead of a query. " +
+				"If a limit is given and the result is not ordered, filtered or aggregated then " +
+				"only the requested rows are evaluated - such a result is not cached and the " +
+				"X-Total-Count header is only set if there are no further rows. If the count " +
+				"parameter is set then the rows after the requested rows are counted without " +
+				"building them - the X-Total-Count and X-Filtered-Count headers contain the " +
+				"exact number of all rows.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -350,6 +561,14 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"type":        "number",
 					"format":      "integer",
 				},
+				{
+					"name":        "count",
+					"in":          "query",
+					"description": "Return the exact number of all rows in the X-Filtered-Count header if set to any value.",
+					"required":    false,
+					"type":        "number",
+					"format":      "integer",
+				},
 			},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
@@ -11,7 +11,14 @@
 package v1
 
 import (
+	"encoding/json"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
 )
 
 func TestQueryPagination(t *testing.T) {
@@ -331,6 +338,68 @@ func TestQueryPagination(t *testing.T) {
 	}
 }
 
+func TestQueryRowWindow(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	// Only the requested rows of an unordered result are evaluated
+
+	var qres map[string]interface{}
+
+	st, h, res := sendTestRequest(queryURL+"//main?q=get+Song&limit=2", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 2 ||
+		h.Get(HTTPHeaderTotalCount) != "" || h.Get(HTTPHeaderCacheID) != "" {
+		t.Error("Unexpected response:", st, h, res)
+		return
+	}
+
+	st, h, res = sendTestRequest(queryURL+"//main?q=get+Song&offset=7&limit=5", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 2 ||
+		h.Get(HTTPHeaderTotalCount) != "9" || h.Get(HTTPHeaderCacheID) != "" {
+		t.Error("Unexpected response:", st, h, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"//main?q=get+Song&offset=9&limit=5", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Offset exceeds available rows" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Rows outside of the window are counted if requested
+
+	st, h, res = sendTestRequest(queryURL+"//main?q=get+Song+where+ranking+>+4&offset=1&limit=2&count=1", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 2 || h.Get(HTTPHeaderTotalCount) != "5" ||
+		h.Get(HTTPHeaderFilteredCount) != "5" || h.Get(HTTPHeaderCacheID) != "" {
+		t.Error("Unexpected response:", st, h, res)
+		return
+	}
+
+	// Ordered results are evaluated completely and cached
+
+	st, h, res = sendTestRequest(queryURL+"//main?q=get+Song+with+ordering(ascending+key)&limit=2", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 2 ||
+		h.Get(HTTPHeaderTotalCount) != "9" || h.Get(HTTPHeaderFilteredCount) != "" || h.Get(HTTPHeaderCacheID) == "" {
+		t.Error("Unexpected response:", st, h, res)
+		return
+	}
+
+	st, h, _ = sendTestRequest(queryURL+"//main?rid="+h.Get(HTTPHeaderCacheID)+"&limit=2&count=1", "GET", nil)
+
+	if st != "200 OK" || h.Get(HTTPHeaderFilteredCount) != "9" {
+		t.Error("Unexpected response:", st, h)
+		return
+	}
+}
+
 func TestQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointQuery
 
@@ -509,6 +578,15 @@ func TestQuery(t *testing.T) {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
+
+	// Results of queries with a no_cache hint are not cached
+
+	st, h, _ := sendTestRequest(queryURL+"main?q="+url.QueryEscape("/*+ no_cache */ get Song"), "GET", nil)
+
+	if st != "200 OK" || h.Get(HTTPHeaderCacheID) != "" {
+		t.Error("Unexpected response:", st, h)
+		return
+	}
 }
 
 func TestGroupingInfo(t *testing.T) {
@@ -595,3 +673,63 @@ func TestGroupingInfo(t *testing.T) {
 		return
 	}
 }
+
+func TestQueryKeyObfuscation(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		api.KeyObfuscation = nil
+	}()
+
+	api.GM, _ = songGraph()
+	api.KeyObfuscation, _ = api.NewTokenKeyObfuscator("secret")
+
+	authorID := api.ExternalKey("Author", "000")
+
+	// Lookup queries accept external IDs
+
+	st, _, res := sendTestRequest(queryURL+"main?q=lookup+Author+'"+authorID+"'", "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"n:Author:`+authorID+`"`) || strings.Contains(res, `"000"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?q=lookup+Author+'000'", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid external key" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Time range traversals return external IDs
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKey, "visit1")
+	edge.SetAttr(data.NodeKind, "Visited")
+	edge.SetAttr(data.EdgeTimestamp, time.Now())
+	edge.SetAttr(data.EdgeEnd1Key, "000")
+	edge.SetAttr(data.EdgeEnd1Kind, "Author")
+	edge.SetAttr(data.EdgeEnd1Role, "visitor")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "Aria1")
+	edge.SetAttr(data.EdgeEnd2Kind, "Song")
+	edge.SetAttr(data.EdgeEnd2Role, "song")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := api.GM.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?q=lookup+Author+'"+authorID+
+		"'+traverse+visitor:Visited:song:Song+where+@inLast('1d')+end+show+key,+2:n:key", "GET", nil)
+
+	if songID := api.ExternalKey("Song", "Aria1"); st != "200 OK" ||
+		!strings.Contains(res, `"`+songID+`"`) || strings.Contains(res, `"Aria1"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -112,9 +112,25 @@ func (qre *queryResultEndpoint) handleRequest(requestType string, w http.Respons
 
 		return
 
+	} else if op == "render" {
+
+		if requestType != "get" {
+			http.Error(w, "Render can only handle GET requests",
+				http.StatusBadRequest)
+			return
+		} else if len(resources) != 3 {
+			http.Error(w, "Need a template name",
+				http.StatusBadRequest)
+			return
+		}
+
+		renderQueryResult(w, r, resources[2], sres, limit)
+
+		return
+
 	} else if op == "quickfilter" {
 
-		qre.quickFilter(requestType, w, resources, sres, limit)
+		qre.quickFilter(requestType, w, r, resources, sres, limit)
 
 		return
 
@@ -186,7 +202,7 @@ func (qre *queryResultEndpoint) groupSelected(requestType string, w http.Respons
 	selections := sres.Selections()
 
 	if col, err = sres.GetPrimaryNodeColumn(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		api.ReportError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -311,7 +327,7 @@ func (qre *queryResultEndpoint) groupSelected(requestType string, w http.Respons
 	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.ReportError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 }
@@ -353,7 +369,7 @@ func (qre *queryResultEndpoint) groupSelectionState(sres *APISearchResult, part
 						nkinds = make([]string, 0)
 					}
 
-					memberKeys[n.Key()] = append(nkeys, key)
+					memberKeys[n.Key()] = append(nkeys, api.ExternalKey(kind, key))
 					memberKinds[n.Key()] = append(nkinds, kind)
 				}
 			}
@@ -415,7 +431,7 @@ func (qre *queryResultEndpoint) selectRows(requestType string, w http.ResponseWr
 		for i, srcs := range sres.RowSources() {
 			if sels[i] {
 				src := strings.Split(srcs[col], ":")
-				keys = append(keys, src[2])
+				keys = append(keys, api.ExternalKey(src[1], src[2]))
 				kinds = append(kinds, src[1])
 			}
 		}
@@ -472,7 +488,7 @@ func (qre *queryResultEndpoint) selectRows(requestType string, w http.ResponseWr
 /*
 quickfilter implements the quickfilter functionality.
 */
-func (qre *queryResultEndpoint) quickFilter(requestType string, w http.ResponseWriter,
+func (qre *queryResultEndpoint) quickFilter(requestType string, w http.ResponseWriter, r *http.Request,
 	resources []string, sres *APISearchResult, limit int) {
 
 	if requestType != "get" {
@@ -495,7 +511,13 @@ func (qre *queryResultEndpoint) quickFilter(requestType string, w http.ResponseW
 
 	counts := make(map[string]uint64)
 
-	for _, row := range sres.Rows() {
+	rows := sres.Rows()
+
+	if proj := api.ResponseProjection.ForRequest(r); proj != nil {
+		rows = projectRows(proj, sres.Header().Data(), rows, sres.RowSources())
+	}
+
+	for _, row := range rows {
 		val := fmt.Sprint(row[index])
 		counts[val]++
 	}
@@ -668,6 +690,35 @@ func (qre *queryResultEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	s["paths"].(map[string]interface{})["/v1/queryresult/{rid}/render/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Render the search result with a report template.",
+			"description": "The render endpoint renders the search result through a stored Go template (see /v1/templates).",
+			"produces": []string{
+				"text/html",
+				"text/plain",
+			},
+			"parameters": append(required, map[string]interface{}{
+				"name":        "name",
+				"in":          "path",
+				"description": "Name of a stored report template.",
+				"required":    true,
+				"type":        "string",
+			}, limit),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The rendered report.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
 	s["paths"].(map[string]interface{})["/v1/queryresult/{rid}/quickfilter/{column}"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Return quickfilter information on a given result column.",
@@ -0,0 +1,313 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/quota"
+)
+
+/*
+EndpointQuotas is the quotas endpoint URL (rooted). Handles everything under quotas/...
+*/
+const EndpointQuotas = api.APIRoot + APIv1 + "/quotas/"
+
+/*
+Quotas is the quota manager of the main datastore which is managed by the
+quotas endpoint (nil if quotas are disabled).
+*/
+var Quotas *quota.Manager
+
+/*
+QuotasEndpointInst creates a new endpoint handler.
+*/
+func QuotasEndpointInst() api.RestEndpointHandler {
+	return &quotasEndpoint{}
+}
+
+/*
+Handler object for quota operations.
+*/
+type quotasEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the limits and the usage of all partitions or of a single
+partition.
+*/
+func (qe *quotasEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") || !checkQuotas(w) {
+		return
+	}
+
+	if len(resources) == 0 {
+		info, err := quotaInfo(Quotas)
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		ret = info
+
+	} else {
+		usage, err := Quotas.Usage(resources[0])
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		limits, ok := Quotas.Limits()[resources[0]]
+		if !ok {
+			limits = &quota.Limits{}
+		}
+
+		ret = map[string]interface{}{
+			"partition": resources[0],
+			"limits":    limits,
+			"usage":     usage,
+		}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePUT sets the limits of a partition.
+*/
+func (qe *quotasEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	qe.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST sets the limits of a partition.
+*/
+func (qe *quotasEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	limits := &quota.Limits{}
+
+	if !checkResources(w, resources, 1, 1, "Need a partition name or "+quota.AllPartitions) || !checkQuotas(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(limits); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := limits.Validate(resources[0]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Quotas.SetLimits(resources[0], limits); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes the limits of a partition.
+*/
+func (qe *quotasEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a partition name or "+quota.AllPartitions) || !checkQuotas(w) {
+		return
+	}
+
+	limits := &quota.Limits{}
+
+	if err := limits.Validate(resources[0]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Quotas.SetLimits(resources[0], limits); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+requestQuotas returns the quota manager of the datastore of a request (nil if
+quotas are disabled).
+*/
+func requestQuotas(r *http.Request) *quota.Manager {
+
+	if name := api.RequestDatabase(r); name != "" {
+		if Databases == nil {
+			return nil
+		}
+		return Databases.Quota(name)
+	}
+
+	return Quotas
+}
+
+/*
+quotaInfo returns the limits and the usage of all partitions of a quota manager.
+*/
+func quotaInfo(qm *quota.Manager) (map[string]interface{}, error) {
+
+	usage, err := qm.Usages()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"limits": qm.Limits(),
+		"usage":  usage,
+	}, nil
+}
+
+/*
+commitErrorStatus returns the response status for an error of a write to the
+datastore. A write which exceeds a quota is reported as insufficient storage.
+*/
+func commitErrorStatus(err error) int {
+	if quota.IsExceeded(err) {
+		return http.StatusInsufficientStorage
+	}
+
+	return http.StatusInternalServerError
+}
+
+/*
+checkQuotas checks if quotas are enabled. Writes an error and returns false
+if they are not.
+*/
+func checkQuotas(w http.ResponseWriter) bool {
+	if Quotas == nil {
+		http.Error(w, "Quotas are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (qe *quotasEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	partitionParams := []map[string]interface{}{
+		{
+			"name":        "partition",
+			"in":          "path",
+			"description": "Name of the partition or * for all partitions together.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	limitsParams := append(partitionParams, map[string]interface{}{
+		"name":        "limits",
+		"in":          "body",
+		"description": "Limits of the partition.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/QuotaLimits",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/quotas"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the limits and the usage of all partitions.",
+			"description": "The limits and the usage are returned as maps of partition names. The entry * holds the limits and the usage of all partitions together.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Limits and usage.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/quotas/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the limits and the usage of a partition.",
+			"description": "The limits of the partition are returned together with its number of nodes, number of edges and estimated size in bytes.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": partitionParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Limits and usage of the partition.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Set the limits of a partition.",
+			"description": "Writes which would exceed a limit are rejected with 507 Insufficient Storage. " +
+				"Existing data is not checked.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": limitsParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The limits were set.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove the limits of a partition.",
+			"description": "The partition can grow without limits.",
+			"parameters":  partitionParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The limits were removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["QuotaLimits"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"max_nodes": map[string]interface{}{
+				"description": "Maximum number of nodes (no limit if 0).",
+				"type":        "integer",
+			},
+			"max_edges": map[string]interface{}{
+				"description": "Maximum number of edges (no limit if 0).",
+				"type":        "integer",
+			},
+			"max_bytes": map[string]interface{}{
+				"description": "Maximum estimated size of all nodes and edges in bytes (no limit if 0).",
+				"type":        "integer",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/quota"
+)
+
+func TestQuotas(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuotas
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		Quotas = nil
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Quotas are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var err error
+
+	if Quotas, err = quota.NewManager(api.GM, api.SystemPartition); err != nil {
+		t.Error(err)
+		return
+	}
+	defer Quotas.Close()
+
+	st, _, res = sendTestRequest(queryURL+"my-part", "POST", []byte(`{}`))
+
+	if st != "400 Bad Request" || !strings.HasPrefix(res, "Invalid partition name") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`{"max_nodes": -1}`))
+
+	if st != "400 Bad Request" || res != "Limits of partition main must not be negative" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	usage, _ := Quotas.Usage("main")
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(fmt.Sprintf(`{"max_nodes": %v}`, usage.Nodes)))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "GET", nil)
+
+	if st != "200 OK" || res != fmt.Sprintf(`{
+  "limits": {
+    "max_nodes": %v
+  },
+  "partition": "main",
+  "usage": {
+    "nodes": %v,
+    "edges": %v,
+    "bytes": %v
+  }
+}`, usage.Nodes, usage.Nodes, usage.Edges, usage.Bytes) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Writes which exceed a quota are rejected
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointGraph+"main/n", "POST",
+		[]byte(`[{"key":"quota","kind":"Song"}]`))
+
+	if st != "507 Insufficient Storage" ||
+		!strings.Contains(res, "Quota exceeded: Limit of "+fmt.Sprint(usage.Nodes)+" nodes reached in partition main") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The usage is shown by the info endpoint
+
+	st, _, res = sendTestRequest("http://localhost"+TESTPORT+EndpointInfoQuery, "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"quota": {`) ||
+		!strings.Contains(res, fmt.Sprintf(`"max_nodes": %v`, usage.Nodes)) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove the limits
+
+	st, _, res = sendTestRequest(queryURL+"main", "DELETE", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"limits": {}`) || !strings.Contains(res, `"*": {`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"my-part", "DELETE", nil)
+
+	if st != "400 Bad Request" || !strings.HasPrefix(res, "Invalid partition name") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -11,11 +11,13 @@
 package v1
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
 )
 
 /*
@@ -28,27 +30,82 @@ HTTPHeaderTotalCount is a special header value containing the total count of obj
 */
 const HTTPHeaderTotalCount = "X-Total-Count"
 
+/*
+HTTPHeaderFilteredCount is a special header value containing the exact number
+of all rows of a query result if only a window of rows was requested.
+*/
+const HTTPHeaderFilteredCount = "X-Filtered-Count"
+
 /*
 HTTPHeaderCacheID is a special header value containing a cache ID for a quick follow up query.
 */
 const HTTPHeaderCacheID = "X-Cache-Id"
 
+/*
+HTTPHeaderSnapshotID is a special header value containing the ID of a node key
+snapshot for follow up requests of a paged node list.
+*/
+const HTTPHeaderSnapshotID = "X-Snapshot-Id"
+
+/*
+HTTPHeaderCommitSeq is a special header value containing the commit sequence
+numbers of the partitions which were changed by a request (e.g. main=42).
+*/
+const HTTPHeaderCommitSeq = "X-Commit-Seq"
+
+/*
+HTTPHeaderEntitySeq is a special header value containing the commit sequence
+number of the last write of a requested node or edge.
+*/
+const HTTPHeaderEntitySeq = "X-Entity-Seq"
+
 /*
 V1EndpointMap is a map of urls to endpoints for version 1 of the API
 */
 var V1EndpointMap = map[string]api.RestEndpointInst{
+	EndpointAdmin:                AdminEndpointInst,
+	EndpointAlerts:               AlertsEndpointInst,
+	EndpointAnalyzers:            AnalyzersEndpointInst,
+	EndpointArrow:                ArrowEndpointInst,
 	EndpointBlob:                 BlobEndpointInst,
+	EndpointCapabilities:         CapabilitiesEndpointInst,
+	EndpointChanges:              ChangesEndpointInst,
 	EndpointClusterQuery:         ClusterEndpointInst,
+	EndpointConfig:               ConfigEndpointInst,
+	EndpointConsole:              ConsoleEndpointInst,
+	EndpointDatabases:            DatabasesEndpointInst,
 	EndpointEql:                  EqlEndpointInst,
 	EndpointGraph:                GraphEndpointInst,
 	EndpointGraphQL:              GraphQLEndpointInst,
 	EndpointGraphQLQuery:         GraphQLQueryEndpointInst,
 	EndpointGraphQLSubscriptions: GraphQLSubscriptionsEndpointInst,
+	EndpointHistory:              HistoryEndpointInst,
+	EndpointImportPreview:        ImportPreviewEndpointInst,
 	EndpointIndexQuery:           IndexEndpointInst,
 	EndpointFindQuery:            FindEndpointInst,
+	EndpointFlush:                FlushEndpointInst,
 	EndpointInfoQuery:            InfoEndpointInst,
+	EndpointJobs:                 JobsEndpointInst,
+	EndpointLocks:                LocksEndpointInst,
+	EndpointMerge:                MergeEndpointInst,
+	EndpointMetrics:              MetricsEndpointInst,
+	EndpointParquet:              ParquetEndpointInst,
 	EndpointQuery:                QueryEndpointInst,
 	EndpointQueryResult:          QueryResultEndpointInst,
+	EndpointQuotas:               QuotasEndpointInst,
+	EndpointRules:                RulesEndpointInst,
+	EndpointSchema:               SchemaEndpointInst,
+	EndpointScript:               ScriptEndpointInst,
+	EndpointScripts:              ScriptsEndpointInst,
+	EndpointSchedules:            SchedulesEndpointInst,
+	EndpointSessions:             SessionsEndpointInst,
+	EndpointShard:                ShardEndpointInst,
+	EndpointTemplates:            TemplatesEndpointInst,
+	EndpointTopology:             TopologyEndpointInst,
+	EndpointTx:                   TxEndpointInst,
+	EndpointUnindexed:            UnindexedEndpointInst,
+	EndpointWebhooks:             WebhooksEndpointInst,
+	EndpointWidget:               WidgetEndpointInst,
 	EndpointECALInternal:         ECALEndpointInst,
 	EndpointECALSock:             ECALSockEndpointInst,
 }
@@ -57,7 +114,23 @@ var V1EndpointMap = map[string]api.RestEndpointInst{
 V1PublicEndpointMap is a map of urls to public endpoints for version 1 of the API
 */
 var V1PublicEndpointMap = map[string]api.RestEndpointInst{
-	EndpointECALPublic: ECALEndpointInst,
+	EndpointECALPublic:   ECALEndpointInst,
+	EndpointSandbox:      SandboxEndpointInst,
+	EndpointWidgetData:   WidgetDataEndpointInst,
+	EndpointWidgetScript: WidgetScriptEndpointInst,
+}
+
+/*
+V1DatabaseEndpointMap is a map of urls to endpoints for version 1 of the API
+which are available for each database
+*/
+var V1DatabaseEndpointMap = map[string]api.RestEndpointInst{
+	EndpointEql:        EqlEndpointInst,
+	EndpointFindQuery:  FindEndpointInst,
+	EndpointGraph:      GraphEndpointInst,
+	EndpointIndexQuery: IndexEndpointInst,
+	EndpointInfoQuery:  InfoEndpointInst,
+	EndpointQuery:      QueryEndpointInst,
 }
 
 // Helper functions
@@ -98,3 +171,105 @@ func queryParamPosNum(w http.ResponseWriter, r *http.Request, param string) (int
 
 	return num, true
 }
+
+/*
+keyAttrs are the attributes of nodes and edges which contain keys and the
+attributes which contain the corresponding kinds.
+*/
+var keyAttrs = [][2]string{
+	{data.NodeKey, data.NodeKind},
+	{data.EdgeEnd1Key, data.EdgeEnd1Kind},
+	{data.EdgeEnd2Key, data.EdgeEnd2Kind},
+}
+
+/*
+externalData returns the data of a node or edge with all keys translated into
+external IDs. The given data is not modified.
+*/
+func externalData(nodeData map[string]interface{}) map[string]interface{} {
+
+	if api.KeyObfuscation == nil || nodeData == nil {
+		return nodeData
+	}
+
+	ret := make(map[string]interface{}, len(nodeData))
+
+	for k, v := range nodeData {
+		ret[k] = v
+	}
+
+	for _, ka := range keyAttrs {
+		if key, ok := ret[ka[0]]; ok {
+			ret[ka[0]] = api.ExternalKey(fmt.Sprint(ret[ka[1]]), fmt.Sprint(key))
+		}
+	}
+
+	return ret
+}
+
+/*
+jsonData returns the data of a node or edge for a JSON response. All keys are
+translated into external IDs and rich attribute values are tagged.
+*/
+func jsonData(nodeData map[string]interface{}) map[string]interface{} {
+	return data.TagValues(externalData(nodeData))
+}
+
+/*
+externalKeys returns a copy of a list of keys of a given kind with all keys
+translated into external IDs.
+*/
+func externalKeys(kind string, keys []string) []string {
+
+	if api.KeyObfuscation == nil {
+		return keys
+	}
+
+	ret := make([]string, len(keys))
+
+	for i, key := range keys {
+		ret[i] = api.ExternalKey(kind, key)
+	}
+
+	return ret
+}
+
+/*
+internalData translates all external IDs of node or edge data (which was
+received from a client) into keys. The given data is modified.
+*/
+func internalData(nodeData map[string]interface{}) error {
+
+	if api.KeyObfuscation == nil {
+		return nil
+	}
+
+	for _, ka := range keyAttrs {
+		if id, ok := nodeData[ka[0]]; ok {
+			key, err := api.InternalKey(fmt.Sprint(nodeData[ka[1]]), fmt.Sprint(id))
+			if err != nil {
+				return err
+			}
+			nodeData[ka[0]] = key
+		}
+	}
+
+	return nil
+}
+
+/*
+externalSource translates the key of a row source (e.g. n:Person:123) into an
+external ID.
+*/
+func externalSource(src string) string {
+
+	if api.KeyObfuscation == nil {
+		return src
+	}
+
+	if s := strings.SplitN(src, ":", 3); len(s) == 3 {
+		return s[0] + ":" + s[1] + ":" + api.ExternalKey(s[1], s[2])
+	}
+
+	return src
+}
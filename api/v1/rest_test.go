@@ -112,6 +112,10 @@ func TestSwaggerDefs(t *testing.T) {
 	for _, inst := range V1EndpointMap {
 		inst().SwaggerDefs(data)
 	}
+
+	for _, inst := range V1PublicEndpointMap {
+		inst().SwaggerDefs(data)
+	}
 }
 
 /*
@@ -0,0 +1,318 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/rules"
+)
+
+/*
+EndpointRules is the rules endpoint URL (rooted). Handles everything under rules/...
+*/
+const EndpointRules = api.APIRoot + APIv1 + "/rules/"
+
+/*
+Rules is the rules engine which is managed by the rules endpoint (nil if
+rules are not available).
+*/
+var Rules *rules.Engine
+
+/*
+RulesEndpointInst creates a new endpoint handler.
+*/
+func RulesEndpointInst() api.RestEndpointHandler {
+	return &rulesEndpoint{}
+}
+
+/*
+Handler object for rule operations.
+*/
+type rulesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all rules or a single rule with its execution statistics.
+*/
+func (re *rulesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret []map[string]interface{}
+
+	if !checkResources(w, resources, 0, 1, "") || !checkRules(w) {
+		return
+	}
+
+	list := Rules.Rules()
+
+	if len(resources) == 1 {
+		if rule, _ := Rules.Rule(resources[0]); rule != nil {
+			list = []*rules.Rule{rule}
+		} else {
+			http.Error(w, "Unknown rule: "+resources[0], http.StatusNotFound)
+			return
+		}
+	}
+
+	ret = make([]map[string]interface{}, 0, len(list))
+
+	for _, rule := range list {
+		_, stats := Rules.Rule(rule.Name)
+
+		ret = append(ret, map[string]interface{}{
+			"name":       rule.Name,
+			"partition":  rule.Partition,
+			"kind":       rule.Kind,
+			"events":     rule.Events,
+			"conditions": rule.Conditions,
+			"actions":    rule.Actions,
+			"mode":       rule.Mode,
+			"disabled":   rule.Disabled,
+			"stats":      stats,
+		})
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(ret)
+	} else {
+		json.NewEncoder(w).Encode(ret[0])
+	}
+}
+
+/*
+HandlePUT stores a rule.
+*/
+func (re *rulesEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	re.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a rule. The statistics of an existing rule are reset.
+*/
+func (re *rulesEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	rule := &rules.Rule{}
+
+	if !checkResources(w, resources, 1, 1, "Need a rule name") || !checkRules(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule.Name = resources[0]
+
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Rules.StoreRule(rule); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a rule.
+*/
+func (re *rulesEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a rule name") || !checkRules(w) {
+		return
+	}
+
+	ok, err := Rules.RemoveRule(resources[0])
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if !ok {
+		http.Error(w, "Unknown rule: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+checkRules checks if the rules engine is available. Writes an error and returns
+false if it is not.
+*/
+func checkRules(w http.ResponseWriter) bool {
+	if Rules == nil {
+		http.Error(w, "Rules are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (re *rulesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the rule.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	ruleParams := append(nameParams, map[string]interface{}{
+		"name":        "rule",
+		"in":          "body",
+		"description": "Rule which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Rule",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/rules"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all rules.",
+			"description": "All rules are returned with their execution statistics.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of rules.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Rule",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/rules/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a rule.",
+			"description": "A rule is returned with its execution statistics.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Rule.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Rule",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store a rule.",
+			"description": "The rule fires on writes of nodes and edges which match its partition, kind, " +
+				"events and conditions. Synchronous rules run their actions within the transaction " +
+				"of the write (a failing action fails the write) - asynchronous rules run their " +
+				"actions in the background after the write.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": ruleParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The rule was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a rule.",
+			"description": "The rule is removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The rule was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Rule"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"partition": map[string]interface{}{
+				"description": "Partition of the writes (all partitions if empty).",
+				"type":        "string",
+			},
+			"kind": map[string]interface{}{
+				"description": "Kind of the written nodes or edges (all kinds if empty).",
+				"type":        "string",
+			},
+			"events": map[string]interface{}{
+				"description": "Events which fire the rule (node.created, node.updated, node.deleted, " +
+					"edge.created, edge.updated or edge.deleted).",
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"conditions": map[string]interface{}{
+				"description": "Conditions on attributes which must all match. A condition has an attribute " +
+					"(attr), an operator (op: =, !=, >, >=, <, <=, contains, exists, missing or changed) " +
+					"and a compared value (value).",
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+				},
+			},
+			"actions": map[string]interface{}{
+				"description": "Actions which run if the rule fires. Each action has a type: set_attr " +
+					"(attr and value or value_attr), create_edge (edge_kind, target_kind, target_key or " +
+					"target_key_attr, role and target_role), webhook (url) or script (event).",
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+				},
+			},
+			"mode": map[string]interface{}{
+				"description": "Execution mode of the rule (sync or async).",
+				"type":        "string",
+			},
+			"disabled": map[string]interface{}{
+				"description": "Flag if the rule is disabled.",
+				"type":        "boolean",
+			},
+			"stats": map[string]interface{}{
+				"description": "Execution statistics of the rule (only returned).",
+				"type":        "object",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/rules"
+)
+
+func TestRules(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointRules
+	graphURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	oldGM := api.GM
+	oldRules := Rules
+	defer func() {
+		api.GM = oldGM
+		Rules = oldRules
+	}()
+
+	api.GM, _ = songGraph()
+	Rules = nil
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Rules are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var err error
+
+	if Rules, err = rules.NewEngine(api.GM, api.SystemPartition); err != nil {
+		t.Error(err)
+		return
+	}
+	defer Rules.Close()
+
+	api.GM.SetGraphRule(Rules)
+
+	// Invalid rules are rejected
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", []byte(`{"events": ["node.foo"]}`))
+
+	if st != "400 Bad Request" || res != "Unknown event: node.foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", []byte(`[1]`))
+
+	if st != "400 Bad Request" || res != "Could not decode request body as object: json: cannot unmarshal array into Go value of type rules.Rule" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Need a rule name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Store a rule which flags top ranked songs
+
+	st, _, res = sendTestRequest(queryURL+"top", "PUT", []byte(`{"partition": "main", "kind": "Song",
+		"events": ["node.created", "node.updated"], "conditions": [{"attr": "ranking", "op": "<=", "value": 3}],
+		"actions": [{"type": "set_attr", "attr": "top", "value": true}]}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(graphURL+"main/n", "POST", []byte(`[{"key": "NewSong", "kind": "Song", "ranking": 2}]`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, _ := api.GM.FetchNode("main", "NewSong", "Song"); n == nil || n.Attr("top") != true {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	var rule map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL+"top", "GET", nil)
+	json.Unmarshal([]byte(res), &rule)
+
+	if st != "200 OK" || rule["mode"] != rules.ModeSync ||
+		rule["stats"].(map[string]interface{})["fired"].(float64) != 2 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var list []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &list)
+
+	if st != "200 OK" || len(list) != 1 || list[0]["name"] != "top" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove the rule
+
+	if st, _, res = sendTestRequest(queryURL+"top", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"top", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown rule: top" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"top", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown rule: top" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
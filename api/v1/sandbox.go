@@ -0,0 +1,286 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+EndpointSandbox is the public sandbox endpoint URL (rooted). Handles everything under sandbox/...
+*/
+const EndpointSandbox = api.APIRoot + "/sandbox/"
+
+/*
+SandboxPartitions is a list of partitions which can be read anonymously
+through the sandbox (the sandbox is disabled if no partition is given).
+*/
+var SandboxPartitions []string
+
+/*
+SandboxMaxRows is the maximum number of rows, nodes or traversal results
+which are returned by a sandbox request.
+*/
+var SandboxMaxRows = 100
+
+/*
+SandboxRateLimit is the maximum number of sandbox requests per minute from a
+single client (0 for no limit).
+*/
+var SandboxRateLimit = 30
+
+/*
+SandboxQueryTimeout is the maximum time a sandbox query may run.
+*/
+var SandboxQueryTimeout = 5 * time.Second
+
+/*
+sandboxRequests counts the requests of all clients in the current rate limit
+window.
+*/
+var sandboxRequests = make(map[string]int)
+
+/*
+sandboxWindow is the start of the current rate limit window.
+*/
+var sandboxWindow time.Time
+
+/*
+sandboxLock is a lock for the rate limit counters
+*/
+var sandboxLock = &sync.Mutex{}
+
+/*
+sandboxAllowRequest checks if a client may make another request in the current
+rate limit window. Returns the seconds until the next window if the request is
+not allowed.
+*/
+func sandboxAllowRequest(client string) (bool, int) {
+	sandboxLock.Lock()
+	defer sandboxLock.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(sandboxWindow) >= time.Minute {
+		sandboxWindow = now
+		sandboxRequests = make(map[string]int)
+	}
+
+	if SandboxRateLimit > 0 && sandboxRequests[client] >= SandboxRateLimit {
+		return false, int((time.Minute-now.Sub(sandboxWindow))/time.Second) + 1
+	}
+
+	sandboxRequests[client]++
+
+	return true, 0
+}
+
+/*
+SandboxEndpointInst creates a new endpoint handler.
+*/
+func SandboxEndpointInst() api.RestEndpointHandler {
+	return &sandboxEndpoint{}
+}
+
+/*
+Handler object for anonymous read-only sandbox requests.
+*/
+type sandboxEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET runs a query or a graph request against an allow-listed partition.
+*/
+func (se *sandboxEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if len(SandboxPartitions) == 0 {
+		http.Error(w, "Sandbox is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 7, "Need a request type (query or graph) and a partition") {
+		return
+	}
+
+	allowed := false
+	for _, p := range SandboxPartitions {
+		if p == resources[1] {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		http.Error(w, "Partition is not available in the sandbox", http.StatusForbidden)
+		return
+	}
+
+	// Identify clients by their address - the sandbox is meant to be used
+	// without a proxy so forwarding headers are not trusted
+
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	if ok, retry := sandboxAllowRequest(client); !ok {
+		w.Header().Set("Retry-After", fmt.Sprint(retry))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch resources[0] {
+	case "query":
+
+		// Result IDs are not accepted since they could be used to read
+		// cached results of other partitions
+
+		if r.URL.Query().Get("rid") != "" {
+			http.Error(w, "Result IDs are not supported in the sandbox", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), SandboxQueryTimeout)
+		defer cancel()
+
+		qe := &queryEndpoint{maxRows: SandboxMaxRows, noCache: true}
+		qe.HandleGET(w, r.WithContext(ctx), resources[1:])
+
+	case "graph":
+
+		if isBlobRequest(resources[1:]) {
+			http.Error(w, "Blobs are not available in the sandbox", http.StatusForbidden)
+			return
+		}
+
+		ge := &graphEndpoint{maxRows: SandboxMaxRows}
+		ge.HandleGET(w, r, resources[1:])
+
+	default:
+		http.Error(w, "Unknown sandbox request type: "+resources[0], http.StatusBadRequest)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *sandboxEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	partitionParam := map[string]interface{}{
+		"name":        "partition",
+		"in":          "path",
+		"description": "Allow-listed partition to read.",
+		"required":    true,
+		"type":        "string",
+	}
+
+	limitParam := map[string]interface{}{
+		"name":        "limit",
+		"in":          "query",
+		"description": "How many results to return (capped by the sandbox row limit).",
+		"required":    false,
+		"type":        "number",
+		"format":      "integer",
+	}
+
+	offsetParam := map[string]interface{}{
+		"name":        "offset",
+		"in":          "query",
+		"description": "Offset in the result list.",
+		"required":    false,
+		"type":        "number",
+		"format":      "integer",
+	}
+
+	s["paths"].(map[string]interface{})["/sandbox/query/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Run an anonymous EQL query.",
+			"description": "The sandbox runs read-only queries against allow-listed partitions without " +
+				"authentication. Requests are rate limited per client and results are capped.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				partitionParam,
+				{
+					"name":        "q",
+					"in":          "query",
+					"description": "URL encoded query to execute.",
+					"required":    true,
+					"type":        "string",
+				},
+				limitParam,
+				offsetParam,
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A query result (see /v1/query).",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/sandbox/graph/{partition}/{entity_type}/{kind}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Read nodes anonymously.",
+			"description": "The sandbox lists nodes of allow-listed partitions without authentication. " +
+				"Single items and traversals can be read by appending a key and a traversal spec (see /v1/graph).",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				partitionParam,
+				{
+					"name":        "entity_type",
+					"in":          "path",
+					"description": "Datastore entity type which should selected. Either n for nodes or e for edges.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node or edge kind to be queried.",
+					"required":    true,
+					"type":        "string",
+				},
+				limitParam,
+				offsetParam,
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of nodes (see /v1/graph).",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestSandbox(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSandbox
+
+	oldGM := api.GM
+	oldRateLimit := SandboxRateLimit
+	oldMaxRows := SandboxMaxRows
+	defer func() {
+		api.GM = oldGM
+		SandboxPartitions = nil
+		SandboxRateLimit = oldRateLimit
+		SandboxMaxRows = oldMaxRows
+		sandboxRequests = make(map[string]int)
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL+"query/main?q=get%20Song", "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Sandbox is not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	SandboxPartitions = []string{"main"}
+	SandboxMaxRows = 3
+	SandboxRateLimit = 0
+
+	st, _, res = sendTestRequest(queryURL+"query/test?q=get%20Author", "GET", nil)
+
+	if st != "403 Forbidden" || res != "Partition is not available in the sandbox" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a request type (query or graph) and a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo/main", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown sandbox request type: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=get%20Song", "POST", nil)
+
+	if st != "405 Method Not Allowed" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Queries return a limited number of rows and are not cached
+
+	var qres map[string]interface{}
+
+	st, header, res := sendTestRequest(queryURL+"query/main?q=get%20Song", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 3 ||
+		header.Get(HTTPHeaderTotalCount) != "9" || header.Get(HTTPHeaderCacheID) != "" ||
+		header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=get%20Song&limit=100&offset=7", "GET", nil)
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 2 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=get%20Song&rid=1", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Result IDs are not supported in the sandbox" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Graph requests return a limited number of nodes and traversal results
+
+	var gres []interface{}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Song", "GET", nil)
+	json.Unmarshal([]byte(res), &gres)
+
+	if st != "200 OK" || len(gres) != 3 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Author/000/:::Song", "GET", nil)
+	json.Unmarshal([]byte(res), &gres)
+
+	if st != "200 OK" || len(gres) != 2 || len(gres[0].([]interface{})) != 3 || len(gres[1].([]interface{})) != 3 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Author/000", "GET", nil)
+
+	if st != "200 OK" || !json.Valid([]byte(res)) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Author/000/blob/1", "GET", nil)
+
+	if st != "403 Forbidden" || res != "Blobs are not available in the sandbox" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Queries are canceled after a timeout
+
+	SandboxQueryTimeout = 0
+	defer func() {
+		SandboxQueryTimeout = 5 * time.Second
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=get%20Song", "GET", nil)
+
+	if st != "500 Internal Server Error" ||
+		res != "EQL error in Main query: Query was canceled (context deadline exceeded) (Line:1 Pos:1)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Clients are rate limited
+
+	SandboxRateLimit = 2
+	sandboxRequests = make(map[string]int)
+
+	sendTestRequest(queryURL+"graph/main/n/Song", "GET", nil)
+	sendTestRequest(queryURL+"graph/main/n/Song", "GET", nil)
+
+	st, header, res = sendTestRequest(queryURL+"graph/main/n/Song", "GET", nil)
+
+	if st != "429 Too Many Requests" || res != "Too many requests" || header.Get("Retry-After") == "" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	// A new window allows new requests
+
+	sandboxWindow = time.Now().Add(-time.Minute)
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Song", "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
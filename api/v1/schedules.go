@@ -0,0 +1,1021 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointSchedules is the schedules endpoint URL (rooted). Handles everything under schedules/...
+*/
+const EndpointSchedules = api.APIRoot + APIv1 + "/schedules/"
+
+/*
+scheduleNodeKind is the node kind which stores scheduled queries in the system partition.
+*/
+const scheduleNodeKind = "schedule"
+
+/*
+scheduleRunsNodeKind is the node kind which stores the run history of a schedule
+in the system partition.
+*/
+const scheduleRunsNodeKind = "schedule_runs"
+
+/*
+ScheduleHistorySize is the maximum number of runs which are kept in the history
+of a schedule.
+*/
+var ScheduleHistorySize = 20
+
+/*
+scheduleRunsLock protects the run histories of all schedules.
+*/
+var scheduleRunsLock = &sync.Mutex{}
+
+/*
+ScheduleResultDir is the directory which receives the results of scheduled
+queries with a file target. File targets are rejected if it is not set.
+*/
+var ScheduleResultDir = ""
+
+/*
+SMTP settings for scheduled queries with an email target. Email targets are
+rejected if no server (host:port) is set. Plain authentication is used if a
+user name is set.
+*/
+var (
+	ScheduleSMTPServer   = ""
+	ScheduleSMTPFrom     = ""
+	ScheduleSMTPUsername = ""
+	ScheduleSMTPPassword = ""
+)
+
+/*
+ScheduleDeliveryTimeout is the timeout for delivering a result or an alert to a webhook.
+*/
+var ScheduleDeliveryTimeout = 30 * time.Second
+
+/*
+sendMail sends an email (can be replaced for testing).
+*/
+var sendMail = smtp.SendMail
+
+func init() {
+
+	// Schedules can run jobs of all other types
+
+	JobTypes["schedule"] = scheduleJob
+}
+
+/*
+Schedule is a saved query or job which runs on a cron schedule. The result of
+a query is delivered to a target. Targets are webhook URLs (http:// or
+https://), files in the result directory (file:<path>) or email addresses
+(mailto:<address>,...).
+*/
+type Schedule struct {
+	Name      string                 `json:"name"`             // Name of the schedule
+	Partition string                 `json:"partition"`        // Partition which is queried
+	Query     string                 `json:"query"`            // EQL query
+	Cron      string                 `json:"cron"`             // Cron schedule in server local time
+	Format    string                 `json:"format"`           // Result format (csv or json)
+	Target    string                 `json:"target"`           // Delivery target
+	Job       string                 `json:"job,omitempty"`    // Type of the job which runs instead of a query
+	Params    map[string]interface{} `json:"params,omitempty"` // Parameters of the job
+}
+
+/*
+ScheduleRun is a run in the history of a schedule.
+*/
+type ScheduleRun struct {
+	Started  int64       `json:"started"`          // Start time of the run
+	Finished int64       `json:"finished"`         // Finish time of the run
+	Status   string      `json:"status"`           // Status of the run (finished or failed)
+	Error    string      `json:"error,omitempty"`  // Error of a failed run
+	Result   interface{} `json:"result,omitempty"` // Result of a finished run
+}
+
+/*
+validate checks a schedule and returns its parsed cron schedule.
+*/
+func (s *Schedule) validate() (*cronSpec, error) {
+
+	if s.Job != "" {
+
+		if _, ok := JobTypes[s.Job]; !ok || s.Job == "schedule" {
+			return nil, fmt.Errorf("Unknown job type: %v", s.Job)
+		} else if s.Query != "" || s.Target != "" {
+			return nil, fmt.Errorf("Schedule must contain either a query or a job")
+		}
+
+		return parseCron(s.Cron)
+	}
+
+	if s.Partition == "" || s.Query == "" {
+		return nil, fmt.Errorf("Schedule must contain a partition and a query")
+	}
+
+	if _, err := parser.Parse("schedule "+s.Name, s.Query); err != nil {
+		return nil, err
+	}
+
+	if s.Format == "" {
+		s.Format = "csv"
+	} else if s.Format != "csv" && s.Format != "json" {
+		return nil, fmt.Errorf("Unknown result format: %v", s.Format)
+	}
+
+	if strings.HasPrefix(s.Target, "file:") {
+		if _, err := scheduleResultFile(s.Target[5:]); err != nil {
+			return nil, err
+		}
+	} else if strings.HasPrefix(s.Target, "mailto:") {
+		if ScheduleSMTPServer == "" {
+			return nil, fmt.Errorf("Email targets require an SMTP server")
+		}
+	} else if !strings.HasPrefix(s.Target, "http://") && !strings.HasPrefix(s.Target, "https://") {
+		return nil, fmt.Errorf("Unknown target: %v", s.Target)
+	}
+
+	return parseCron(s.Cron)
+}
+
+/*
+scheduleResultFile returns the path of a result file in the result directory.
+*/
+func scheduleResultFile(name string) (string, error) {
+
+	if ScheduleResultDir == "" {
+		return "", fmt.Errorf("File targets require a result directory")
+	}
+
+	name = filepath.Clean(name)
+
+	if name == "." || name == ".." || filepath.IsAbs(name) ||
+		strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("File target must be a relative path in the result directory: %v", name)
+	}
+
+	return filepath.Join(ScheduleResultDir, name), nil
+}
+
+/*
+fetchSchedule fetches a stored schedule. Returns nil if the schedule does not exist.
+*/
+func fetchSchedule(name string) (*Schedule, error) {
+	var s *Schedule
+
+	node, err := api.GM.FetchNode(api.SystemPartition, name, scheduleNodeKind)
+
+	if err == nil && node != nil {
+		s = &Schedule{}
+		err = json.Unmarshal([]byte(node.Attr("data").(string)), s)
+	}
+
+	return s, err
+}
+
+/*
+fetchSchedules fetches all stored schedules sorted by name.
+*/
+func fetchSchedules() ([]*Schedule, error) {
+	var schedules []*Schedule
+
+	it, err := api.GM.NodeKeyIterator(api.SystemPartition, scheduleNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var s *Schedule
+
+			if s, err = fetchSchedule(key); s != nil {
+				schedules = append(schedules, s)
+			}
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+
+	return schedules, err
+}
+
+/*
+fetchScheduleRuns fetches the run history of a schedule (newest run first).
+*/
+func fetchScheduleRuns(name string) ([]*ScheduleRun, error) {
+	runs := []*ScheduleRun{}
+
+	node, err := api.GM.FetchNode(api.SystemPartition, name, scheduleRunsNodeKind)
+
+	if err == nil && node != nil {
+		err = json.Unmarshal([]byte(node.Attr("data").(string)), &runs)
+	}
+
+	return runs, err
+}
+
+/*
+recordScheduleRun adds a run to the history of a schedule. Only the last
+ScheduleHistorySize runs are kept.
+*/
+func recordScheduleRun(name string, run *ScheduleRun) error {
+	scheduleRunsLock.Lock()
+	defer scheduleRunsLock.Unlock()
+
+	runs, err := fetchScheduleRuns(name)
+
+	if err == nil {
+		var runsJSON []byte
+
+		runs = append([]*ScheduleRun{run}, runs...)
+
+		if len(runs) > ScheduleHistorySize {
+			runs = runs[:ScheduleHistorySize]
+		}
+
+		if runsJSON, err = json.Marshal(runs); err == nil {
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, name)
+			node.SetAttr(data.NodeKind, scheduleRunsNodeKind)
+			node.SetAttr("data", string(runsJSON))
+
+			err = api.GM.StoreNode(api.SystemPartition, node)
+		}
+	}
+
+	return err
+}
+
+/*
+scheduleJob runs a scheduled query or job and records the run in the history
+of the schedule. The name parameter is the name of the schedule.
+*/
+func scheduleJob(params map[string]interface{}, progress JobProgress) (interface{}, error) {
+	var res interface{}
+
+	name, _ := params["name"].(string)
+
+	s, err := fetchSchedule(name)
+
+	if err == nil && s == nil {
+		err = fmt.Errorf("Unknown schedule: %v", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	run := &ScheduleRun{time.Now().Unix(), 0, JobFinished, "", nil}
+
+	if s.Job != "" {
+		if jobFunc, ok := JobTypes[s.Job]; ok {
+			res, err = jobFunc(s.Params, progress)
+		} else {
+			err = fmt.Errorf("Unknown job type: %v", s.Job)
+		}
+	} else {
+		res, err = runScheduledQuery(s)
+	}
+
+	run.Finished = time.Now().Unix()
+
+	if err != nil {
+		run.Status = JobFailed
+		run.Error = err.Error()
+	} else {
+		run.Result = res
+	}
+
+	if herr := recordScheduleRun(s.Name, run); err == nil {
+		err = herr
+	}
+
+	return res, err
+}
+
+/*
+runScheduledQuery runs the query of a schedule and delivers its result.
+*/
+func runScheduledQuery(s *Schedule) (interface{}, error) {
+	var res eql.SearchResult
+	var result []byte
+	var contentType string
+
+	query, err := internalQuery(s.Query)
+
+	if err == nil {
+		res, err = eql.RunQueryWithOptions(context.Background(),
+			stringutil.CreateDisplayString(s.Partition)+" scheduled query", s.Partition, query,
+			api.GM, QueryOptions)
+	}
+
+	if err == nil {
+		if result, contentType, err = scheduleResult(s, res); err == nil {
+			err = deliverScheduleResult(s, result, contentType)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"schedule": s.Name,
+		"rows":     res.RowCount(),
+		"target":   s.Target,
+	}, nil
+}
+
+/*
+scheduleResult converts a search result into the result format of a schedule.
+*/
+func scheduleResult(s *Schedule, res eql.SearchResult) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	header := res.Header()
+	rows := res.Rows()
+
+	// Translate keys if key obfuscation is enabled
+
+	if api.KeyObfuscation != nil {
+		rows, _ = externalRows(header.Data(), rows, res.RowSources())
+	}
+
+	if s.Format == "json" {
+		err := json.NewEncoder(&buf).Encode(map[string]interface{}{
+			"schedule": s.Name,
+			"time":     time.Now().Unix(),
+			"header": map[string]interface{}{
+				"labels":       header.Labels(),
+				"format":       header.Format(),
+				"data":         header.Data(),
+				"primary_kind": header.PrimaryKind(),
+			},
+			"rows": rows,
+		})
+
+		return buf.Bytes(), "application/json; charset=utf-8", err
+	}
+
+	w := csv.NewWriter(&buf)
+
+	w.Write(header.Labels())
+
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = fmt.Sprint(v)
+		}
+		w.Write(record)
+	}
+
+	w.Flush()
+
+	return buf.Bytes(), "text/csv; charset=utf-8", w.Error()
+}
+
+/*
+deliverScheduleResult delivers the result of a schedule to its target.
+*/
+func deliverScheduleResult(s *Schedule, result []byte, contentType string) error {
+
+	if strings.HasPrefix(s.Target, "file:") {
+
+		filename, err := scheduleResultFile(s.Target[5:])
+
+		if err == nil {
+			if err = os.MkdirAll(filepath.Dir(filename), 0770); err == nil {
+				err = ioutil.WriteFile(filename, result, 0660)
+			}
+		}
+
+		return err
+
+	} else if strings.HasPrefix(s.Target, "mailto:") {
+
+		return mailScheduleResult(s, strings.Split(s.Target[7:], ","), result, contentType)
+	}
+
+	return postWebhook(s.Target, result, contentType)
+}
+
+/*
+postWebhook posts a message to a webhook.
+*/
+func postWebhook(url string, msg []byte, contentType string) error {
+	client := &http.Client{Timeout: ScheduleDeliveryTimeout}
+
+	resp, err := client.Post(url, contentType, bytes.NewReader(msg))
+
+	if err == nil {
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("Webhook %v returned status: %v", url, resp.Status)
+		}
+	}
+
+	return err
+}
+
+/*
+mailScheduleResult sends the result of a schedule as an email attachment.
+*/
+func mailScheduleResult(s *Schedule, to []string, result []byte, contentType string) error {
+	var msg bytes.Buffer
+	var auth smtp.Auth
+
+	mw := multipart.NewWriter(&msg)
+
+	fmt.Fprintf(&msg, "From: %v\r\nTo: %v\r\nSubject: EliasDB scheduled query %v\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%v\r\n\r\n",
+		ScheduleSMTPFrom, strings.Join(to, ", "), s.Name, mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+
+	if err == nil {
+		fmt.Fprintf(part, "Result of the scheduled query %v:\r\n\r\n%v\r\n", s.Name, s.Query)
+
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", s.Name+"."+s.Format)},
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	enc := base64.StdEncoding.EncodeToString(result)
+
+	for len(enc) > 76 {
+		fmt.Fprintf(part, "%v\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(part, "%v\r\n", enc)
+
+	mw.Close()
+
+	if ScheduleSMTPUsername != "" {
+		host := strings.Split(ScheduleSMTPServer, ":")[0]
+		auth = smtp.PlainAuth("", ScheduleSMTPUsername, ScheduleSMTPPassword, host)
+	}
+
+	return sendMail(ScheduleSMTPServer, auth, ScheduleSMTPFrom, to, msg.Bytes())
+}
+
+// Scheduler
+// =========
+
+/*
+ScheduleCheckInterval is the interval in which the scheduler checks for due schedules and alerts.
+*/
+var ScheduleCheckInterval = 10 * time.Second
+
+/*
+Scheduler starts the jobs of scheduled queries and alerts when they are due.
+*/
+type Scheduler struct {
+	lastCheck time.Time // Last minute which was checked
+	stopChan  chan bool // Channel to stop the scheduler loop
+}
+
+/*
+NewScheduler creates a new scheduler.
+*/
+func NewScheduler() *Scheduler {
+	return &Scheduler{time.Time{}, nil}
+}
+
+/*
+Start starts the scheduler loop.
+*/
+func (s *Scheduler) Start() {
+
+	s.stopChan = make(chan bool)
+
+	go func(stop chan bool) {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(ScheduleCheckInterval):
+				if _, err := s.check(time.Now()); err != nil && api.RequestLog != nil {
+					api.RequestLog.LogError("", "Could not check scheduled queries",
+						map[string]interface{}{"error": err.Error()})
+				}
+			}
+		}
+	}(s.stopChan)
+}
+
+/*
+Stop stops the scheduler loop.
+*/
+func (s *Scheduler) Stop() {
+	if s.stopChan != nil {
+		close(s.stopChan)
+		s.stopChan = nil
+	}
+}
+
+/*
+check starts the jobs of all schedules and alerts which were due since the
+last check. A schedule or alert runs at most once per check - missed runs are
+not caught up if the last check is more than an hour ago. Returns the IDs of
+the started jobs.
+*/
+func (s *Scheduler) check(now time.Time) ([]string, error) {
+	var ids []string
+
+	now = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, now.Location())
+
+	if s.lastCheck.IsZero() || now.Sub(s.lastCheck) > time.Hour {
+		s.lastCheck = now.Add(-time.Minute)
+	}
+
+	// Start a job if a cron schedule was due
+
+	startDue := func(jobType string, name string, spec string) error {
+		cron, err := parseCron(spec)
+
+		if err == nil {
+			if next := cron.next(s.lastCheck); !next.IsZero() && !next.After(now) {
+				var id string
+
+				if id, err = StartJob(jobType, map[string]interface{}{"name": name}); err == nil {
+					ids = append(ids, id)
+				}
+			}
+		}
+
+		return err
+	}
+
+	schedules, err := fetchSchedules()
+
+	for _, schedule := range schedules {
+		if cerr := startDue("schedule", schedule.Name, schedule.Cron); err == nil {
+			err = cerr
+		}
+	}
+
+	alerts, aerr := fetchAlerts()
+
+	for _, alert := range alerts {
+		if cerr := startDue("alert", alert.Name, alert.Cron); aerr == nil {
+			aerr = cerr
+		}
+	}
+
+	if err == nil {
+		err = aerr
+	}
+
+	s.lastCheck = now
+
+	return ids, err
+}
+
+// REST endpoint
+// =============
+
+/*
+SchedulesEndpointInst creates a new endpoint handler.
+*/
+func SchedulesEndpointInst() api.RestEndpointHandler {
+	return &schedulesEndpoint{}
+}
+
+/*
+Handler object for scheduled query operations.
+*/
+type schedulesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all schedules or a single schedule with the time of its next
+and last run or the run history of a schedule.
+*/
+func (se *schedulesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var schedules []*Schedule
+	var err error
+
+	if !checkResources(w, resources, 0, 2, "") {
+		return
+	}
+
+	if len(resources) == 2 {
+		se.handleRuns(w, r, resources)
+		return
+	}
+
+	if len(resources) == 0 {
+		schedules, err = fetchSchedules()
+
+	} else {
+		var s *Schedule
+
+		if s, err = fetchSchedule(resources[0]); err == nil && s == nil {
+			http.Error(w, "Unknown schedule: "+resources[0], http.StatusNotFound)
+			return
+		}
+
+		schedules = []*Schedule{s}
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	ret := make([]map[string]interface{}, 0, len(schedules))
+
+	for _, s := range schedules {
+		var next int64
+		var last *ScheduleRun
+
+		if cron, err := parseCron(s.Cron); err == nil {
+			if t := cron.next(time.Now()); !t.IsZero() {
+				next = t.Unix()
+			}
+		}
+
+		runs, err := fetchScheduleRuns(s.Name)
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		} else if len(runs) > 0 {
+
+			// Results are only returned in the run history
+
+			last = &ScheduleRun{runs[0].Started, runs[0].Finished, runs[0].Status, runs[0].Error, nil}
+		}
+
+		ret = append(ret, map[string]interface{}{
+			"name":      s.Name,
+			"partition": s.Partition,
+			"query":     s.Query,
+			"cron":      s.Cron,
+			"format":    s.Format,
+			"target":    s.Target,
+			"job":       s.Job,
+			"params":    s.Params,
+			"next_run":  next,
+			"last_run":  last,
+		})
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(ret)
+	} else {
+		json.NewEncoder(w).Encode(ret[0])
+	}
+}
+
+/*
+handleRuns returns the run history of a schedule (newest run first).
+*/
+func (se *schedulesEndpoint) handleRuns(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if resources[1] != "runs" {
+		http.Error(w, "Invalid resource specification: "+resources[1], http.StatusBadRequest)
+		return
+	}
+
+	s, err := fetchSchedule(resources[0])
+
+	if err == nil && s == nil {
+		http.Error(w, "Unknown schedule: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	var runs []*ScheduleRun
+
+	if err == nil {
+		runs, err = fetchScheduleRuns(s.Name)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(runs)
+}
+
+/*
+HandlePUT stores a schedule.
+*/
+func (se *schedulesEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a schedule.
+*/
+func (se *schedulesEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	s := &Schedule{}
+
+	if !checkResources(w, resources, 1, 1, "Need a schedule name") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Name = resources[0]
+
+	if _, err := s.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scheduleJSON, err := json.Marshal(s)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, s.Name)
+		node.SetAttr(data.NodeKind, scheduleNodeKind)
+		node.SetAttr("owner", requestUser(r))
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(scheduleJSON))
+
+		err = api.GM.StoreNode(api.SystemPartition, node)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a schedule and its run history.
+*/
+func (se *schedulesEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a schedule name") {
+		return
+	}
+
+	node, err := api.GM.RemoveNode(api.SystemPartition, resources[0], scheduleNodeKind)
+
+	if err == nil && node != nil {
+		scheduleRunsLock.Lock()
+		_, err = api.GM.RemoveNode(api.SystemPartition, resources[0], scheduleRunsNodeKind)
+		scheduleRunsLock.Unlock()
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if node == nil {
+		http.Error(w, "Unknown schedule: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *schedulesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the schedule.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	scheduleParams := append(nameParams, map[string]interface{}{
+		"name":        "schedule",
+		"in":          "body",
+		"description": "Schedule which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Schedule",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schedules"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all scheduled queries and jobs.",
+			"description": "All schedules are returned with the time of their next and last run.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of schedules.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Schedule",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schedules/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a scheduled query or job.",
+			"description": "A schedule is returned with the time of its next and last run.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Schedule.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Schedule",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store a scheduled query or job.",
+			"description": "The query runs on a cron schedule (server local time) and its result is " +
+				"delivered as CSV or JSON to a webhook (http:// or https://), a file in the result " +
+				"directory (file:<path>) or email addresses (mailto:<address>,...). Instead of a query " +
+				"a schedule can run a job of any type (e.g. backup, mutation or script) with the given " +
+				"parameters. Each run is a job of the type schedule.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": scheduleParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The schedule was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a scheduled query or job.",
+			"description": "The schedule and its run history are removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The schedule was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schedules/{name}/runs"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the run history of a schedule.",
+			"description": "The last runs of the schedule are returned with their status and result (newest run first).",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of runs.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/ScheduleRun",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Schedule"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"partition": map[string]interface{}{
+				"description": "Partition which is queried.",
+				"type":        "string",
+			},
+			"query": map[string]interface{}{
+				"description": "EQL query.",
+				"type":        "string",
+			},
+			"cron": map[string]interface{}{
+				"description": "Cron schedule with the fields minute, hour, day of month, month and day of week.",
+				"type":        "string",
+			},
+			"format": map[string]interface{}{
+				"description": "Result format (csv or json).",
+				"type":        "string",
+			},
+			"target": map[string]interface{}{
+				"description": "Delivery target of the result.",
+				"type":        "string",
+			},
+			"job": map[string]interface{}{
+				"description": "Type of the job which runs instead of a query.",
+				"type":        "string",
+			},
+			"params": map[string]interface{}{
+				"description": "Parameters of the job.",
+				"type":        "object",
+			},
+			"next_run": map[string]interface{}{
+				"description": "Time of the next run (only returned).",
+				"type":        "integer",
+			},
+			"last_run": map[string]interface{}{
+				"description": "Last run without its result (only returned).",
+				"$ref":        "#/definitions/ScheduleRun",
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["ScheduleRun"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"started": map[string]interface{}{
+				"description": "Start time of the run.",
+				"type":        "integer",
+			},
+			"finished": map[string]interface{}{
+				"description": "Finish time of the run.",
+				"type":        "integer",
+			},
+			"status": map[string]interface{}{
+				"description": "Status of the run (finished or failed).",
+				"type":        "string",
+			},
+			"error": map[string]interface{}{
+				"description": "Error of a failed run.",
+				"type":        "string",
+			},
+			"result": map[string]interface{}{
+				"description": "Result of a finished run.",
+				"type":        "object",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
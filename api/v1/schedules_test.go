@@ -0,0 +1,463 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestCron(t *testing.T) {
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *",
+		"* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+
+		if _, err := parseCron(spec); err == nil {
+			t.Error("Cron schedule should be invalid:", spec)
+			return
+		}
+	}
+
+	start := time.Date(2021, time.March, 5, 6, 30, 0, 0, time.UTC) // Friday
+
+	for spec, expected := range map[string]time.Time{
+		"30 6 * * 1-5":      time.Date(2021, time.March, 8, 6, 30, 0, 0, time.UTC),
+		"*/15 * * * *":      time.Date(2021, time.March, 5, 6, 45, 0, 0, time.UTC),
+		"0,10 8-18/2 * * *": time.Date(2021, time.March, 5, 8, 0, 0, 0, time.UTC),
+		"@daily":            time.Date(2021, time.March, 6, 0, 0, 0, 0, time.UTC),
+		"@yearly":           time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":         time.Date(2021, time.March, 7, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":        time.Date(2021, time.March, 12, 0, 0, 0, 0, time.UTC),
+		"0 0 31 2 *":        {},
+	} {
+		cron, err := parseCron(spec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if next := cron.next(start); !next.Equal(expected) {
+			t.Error("Unexpected next run for", spec, ":", next, "expected:", expected)
+			return
+		}
+
+		if !expected.IsZero() && (!cron.matches(expected) || cron.matches(start.Add(time.Minute))) {
+			t.Error("Unexpected match result for", spec)
+			return
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSchedules
+	jobsURL := "http://localhost" + TESTPORT + EndpointJobs
+
+	oldGM := api.GM
+	oldResultDir := ScheduleResultDir
+	oldSMTPServer := ScheduleSMTPServer
+	oldSendMail := sendMail
+	defer func() {
+		api.GM = oldGM
+		ScheduleResultDir = oldResultDir
+		ScheduleSMTPServer = oldSMTPServer
+		sendMail = oldSendMail
+	}()
+
+	api.GM, _ = songGraph()
+
+	resultDir, err := ioutil.TempDir("", "schedules")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(resultDir)
+
+	ScheduleResultDir = resultDir
+	ScheduleSMTPServer = "localhost:25"
+
+	var mails []string
+
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, strings.Join(to, ",")+"\n"+string(msg))
+		return nil
+	}
+
+	var webhook []string
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		webhook = append(webhook, r.Header.Get("Content-Type")+"\n"+string(body))
+	}))
+	defer hook.Close()
+
+	waitForJob := func(id string) map[string]interface{} {
+		var job map[string]interface{}
+
+		for i := 0; i < 100; i++ {
+			_, _, res := sendTestRequest(jobsURL+id, "GET", nil)
+			json.Unmarshal([]byte(res), &job)
+
+			if job["status"] != JobRunning {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return job
+	}
+
+	// Invalid schedules are rejected
+
+	for body, msg := range map[string]string{
+		`{"partition": "main", "query": "get Author", "cron": "* * *", "target": "` + hook.URL + `"}`:           "Cron schedule must have 5 fields: * * *",
+		`{"partition": "main", "query": "get Author", "cron": "@daily", "target": "ftp://foo"}`:                 "Unknown target: ftp://foo",
+		`{"partition": "main", "query": "get Author", "cron": "@daily", "target": "file:../foo"}`:               "File target must be a relative path in the result directory: ../foo",
+		`{"partition": "main", "query": "get Author", "cron": "@daily", "format": "xml", "target": "file:foo"}`: "Unknown result format: xml",
+		`{"query": "get Author", "cron": "@daily", "target": "file:foo"}`:                                       "Schedule must contain a partition and a query",
+	} {
+		st, _, res := sendTestRequest(queryURL+"test", "POST", []byte(body))
+
+		if st != "400 Bad Request" || res != msg {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	st, _, res := sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Need a schedule name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Store schedules for all target types
+
+	for name, body := range map[string]string{
+		"hook": `{"partition": "main", "query": "get Author show name with ordering(ascending name)", "cron": "0 6 * * *", "target": "` + hook.URL + `"}`,
+		"file": `{"partition": "main", "query": "get Author show name", "cron": "0 6 * * 1", "format": "json", "target": "file:reports/authors.json"}`,
+		"mail": `{"partition": "main", "query": "get Author show name", "cron": "0 7 * * *", "target": "mailto:a@example.com,b@example.com"}`,
+	} {
+		if st, _, res := sendTestRequest(queryURL+name, "POST", []byte(body)); st != "200 OK" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	var schedules []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &schedules)
+
+	if st != "200 OK" || len(schedules) != 3 || schedules[0]["name"] != "file" ||
+		schedules[1]["name"] != "hook" || schedules[1]["format"] != "csv" ||
+		schedules[1]["next_run"].(float64) <= float64(time.Now().Unix()) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"unknown", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown schedule: unknown" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Run all schedules through the scheduler
+
+	sched := NewScheduler()
+	monday := time.Date(2021, time.March, 8, 5, 59, 0, 0, time.Local)
+
+	if ids, err := sched.check(monday); err != nil || len(ids) != 0 {
+		t.Error("Unexpected result:", ids, err)
+		return
+	}
+
+	ids, err := sched.check(monday.Add(time.Minute))
+
+	if err != nil || len(ids) != 2 {
+		t.Error("Unexpected result:", ids, err)
+		return
+	}
+
+	for _, id := range ids {
+		if job := waitForJob(id); job["status"] != JobFinished || job["type"] != "schedule" {
+			t.Error("Unexpected result:", job)
+			return
+		}
+	}
+
+	if len(webhook) != 1 || webhook[0] != `text/csv; charset=utf-8
+Author Name
+Hans
+John
+Mike
+` {
+		t.Error("Unexpected webhook delivery:", webhook)
+		return
+	}
+
+	var fileResult map[string]interface{}
+
+	content, err := ioutil.ReadFile(filepath.Join(resultDir, "reports", "authors.json"))
+	json.Unmarshal(content, &fileResult)
+
+	if err != nil || fileResult["schedule"] != "file" || len(fileResult["rows"].([]interface{})) != 3 {
+		t.Error("Unexpected file delivery:", string(content), err)
+		return
+	}
+
+	// Run a schedule directly as job
+
+	st, _, res = sendTestRequest(jobsURL+"schedule", "POST", []byte(`{"name": "mail"}`))
+
+	var jres map[string]interface{}
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(jres["id"].(string)); job["status"] != JobFinished ||
+		job["result"].(map[string]interface{})["rows"] != float64(3) {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	if len(mails) != 1 || !strings.HasPrefix(mails[0], "a@example.com,b@example.com\n") ||
+		!strings.Contains(mails[0], `Content-Disposition: attachment; filename="mail.csv"`) {
+		t.Error("Unexpected mail delivery:", mails)
+		return
+	}
+
+	// Errors during the delivery fail the job
+
+	hook.Close()
+
+	st, _, res = sendTestRequest(jobsURL+"schedule", "POST", []byte(`{"name": "hook"}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(jres["id"].(string)); job["status"] != JobFailed {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	st, _, res = sendTestRequest(jobsURL+"schedule", "POST", []byte(`{"name": "unknown"}`))
+	json.Unmarshal([]byte(res), &jres)
+
+	if job := waitForJob(jres["id"].(string)); job["status"] != JobFailed || job["error"] != "Unknown schedule: unknown" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	// Remove schedules
+
+	if st, _, res = sendTestRequest(queryURL+"mail", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"mail", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown schedule: mail" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSchedules
+	jobsURL := "http://localhost" + TESTPORT + EndpointJobs
+
+	oldGM := api.GM
+	oldResultDir := ScheduleResultDir
+	oldHistorySize := ScheduleHistorySize
+	defer func() {
+		api.GM = oldGM
+		ScheduleResultDir = oldResultDir
+		ScheduleHistorySize = oldHistorySize
+	}()
+
+	api.GM, _ = songGraph()
+
+	resultDir, err := ioutil.TempDir("", "schedules")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(resultDir)
+
+	ScheduleResultDir = resultDir
+	ScheduleHistorySize = 2
+
+	runJob := func(name string) map[string]interface{} {
+		var job map[string]interface{}
+
+		_, _, res := sendTestRequest(jobsURL+"schedule", "POST", []byte(`{"name": "`+name+`"}`))
+		json.Unmarshal([]byte(res), &job)
+
+		id := job["id"].(string)
+
+		for i := 0; i < 100; i++ {
+			_, _, res := sendTestRequest(jobsURL+id, "GET", nil)
+			json.Unmarshal([]byte(res), &job)
+
+			if job["status"] != JobRunning {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return job
+	}
+
+	// Invalid job schedules are rejected
+
+	for body, msg := range map[string]string{
+		`{"job": "foo", "cron": "@daily"}`:                                                "Unknown job type: foo",
+		`{"job": "schedule", "cron": "@daily"}`:                                           "Unknown job type: schedule",
+		`{"job": "backup", "partition": "main", "query": "get Author", "cron": "@daily"}`: "Schedule must contain either a query or a job",
+		`{"job": "backup", "cron": "@foo"}`:                                               "Cron schedule must have 5 fields: @foo",
+	} {
+		st, _, res := sendTestRequest(queryURL+"test", "POST", []byte(body))
+
+		if st != "400 Bad Request" || res != msg {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	// Store and run job schedules
+
+	for name, body := range map[string]string{
+		"backup":   `{"job": "backup", "params": {"dir": "backups/{time}", "partitions": ["main"]}, "cron": "0 2 * * *"}`,
+		"mutation": `{"job": "mutation", "params": {"query": "mutation { Author(storeNode: {key: \"x\"}) { key } }"}, "cron": "@hourly"}`,
+		"script":   `{"job": "script", "params": {"name": "cleanup"}, "cron": "@hourly"}`,
+	} {
+		if st, _, res := sendTestRequest(queryURL+name, "POST", []byte(body)); st != "200 OK" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	sched := NewScheduler()
+	night := time.Date(2021, time.March, 8, 1, 59, 0, 0, time.Local)
+
+	sched.check(night)
+
+	ids, err := sched.check(night.Add(time.Minute))
+
+	if err != nil || len(ids) != 3 {
+		t.Error("Unexpected result:", ids, err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		if runs, _ := fetchScheduleRuns("backup"); len(runs) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(resultDir, "backups", "*", "main.json"))
+
+	if err != nil || len(dirs) != 1 {
+		t.Error("Unexpected backup:", dirs, err)
+		return
+	}
+
+	content, err := ioutil.ReadFile(dirs[0])
+
+	if err != nil || !strings.Contains(string(content), `"kind" : "Author"`) {
+		t.Error("Unexpected backup:", string(content), err)
+		return
+	}
+
+	// Failed runs are recorded
+
+	if job := runJob("mutation"); job["status"] != JobFailed || job["error"] != "Need a partition and a query" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	if job := runJob("script"); job["status"] != JobFailed || job["error"] != "Scripts are not enabled" {
+		t.Error("Unexpected result:", job)
+		return
+	}
+
+	var schedules []map[string]interface{}
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &schedules)
+
+	if st != "200 OK" || len(schedules) != 3 || schedules[0]["job"] != "backup" ||
+		schedules[2]["last_run"].(map[string]interface{})["status"] != JobFailed ||
+		schedules[2]["last_run"].(map[string]interface{})["error"] != "Scripts are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The run history keeps the last runs (newest first)
+
+	runJob("script")
+
+	var runs []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL+"backup/runs", "GET", nil)
+	json.Unmarshal([]byte(res), &runs)
+
+	if st != "200 OK" || len(runs) != 1 || runs[0]["status"] != JobFinished ||
+		runs[0]["result"].(map[string]interface{})["partitions"].([]interface{})[0] != "main" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"script/runs", "GET", nil)
+	json.Unmarshal([]byte(res), &runs)
+
+	if st != "200 OK" || len(runs) != 2 || runs[0]["started"].(float64) < runs[1]["started"].(float64) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"script/foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"unknown/runs", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown schedule: unknown" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Removing a schedule removes its history
+
+	if st, _, res = sendTestRequest(queryURL+"script", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if runs, err := fetchScheduleRuns("script"); err != nil || len(runs) != 0 {
+		t.Error("Unexpected result:", runs, err)
+		return
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+EndpointSchema is the schema endpoint URL (rooted). Handles everything under schema/...
+*/
+const EndpointSchema = api.APIRoot + APIv1 + "/schema/"
+
+/*
+SchemaEndpointInst creates a new endpoint handler.
+*/
+func SchemaEndpointInst() api.RestEndpointHandler {
+	return &schemaEndpoint{}
+}
+
+/*
+Handler object for schema queries.
+*/
+type schemaEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a schema query REST call.
+*/
+func (se *schemaEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 || resources[0] == "" {
+
+		// List all available schema documents
+
+		docs := []string{}
+
+		for _, kind := range api.GM.NodeKinds() {
+			docs = append(docs, kind+".json")
+		}
+
+		ret = docs
+
+	} else {
+
+		kind := strings.TrimSuffix(resources[0], ".json")
+
+		sampleSize, ok := queryParamPosNum(w, r, "sample")
+		if !ok {
+			return
+		} else if sampleSize == -1 {
+			sampleSize = graph.SchemaSampleSize
+		}
+
+		schema, err := graph.KindSchema(kind, sampleSize, api.GM)
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusInternalServerError)
+			return
+		} else if schema == nil {
+			http.Error(w, fmt.Sprint("Unknown node kind ", kind), http.StatusNotFound)
+			return
+		}
+
+		schema["$id"] = EndpointSchema + kind + ".json"
+
+		ret = schema
+	}
+
+	w.Header().Set("content-type", "application/schema+json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *schemaEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/schema"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a list of available schema documents.",
+			"description": "The schema endpoint lists the JSON Schema documents of all known node kinds.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of schema document names.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schema/{kind}.json"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the JSON Schema of a node kind.",
+			"description": "The schema is inferred from the stored nodes of the given kind.",
+			"produces": []string{
+				"text/plain",
+				"application/schema+json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node kind.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "sample",
+					"in":          "query",
+					"description": "Number of nodes which are scanned to infer the schema (0 scans all nodes).",
+					"required":    false,
+					"type":        "number",
+					"format":      "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A JSON Schema document.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSchema
+
+	st, header, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || !strings.Contains(res, `"Song.json"`) ||
+		header.Get("Content-Type") != "application/schema+json; charset=utf-8" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song.json", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "$id": "/db/v1/schema/Song.json",
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "properties": {
+    "key": {
+      "type": "string"
+    },
+    "kind": {
+      "const": "Song"
+    },
+    "name": {
+      "type": "string"
+    },
+    "ranking": {
+      "type": "integer"
+    }
+  },
+  "required": [
+    "key",
+    "kind",
+    "name",
+    "ranking"
+  ],
+  "title": "Song",
+  "type": "object"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo.json", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown node kind foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song.json?sample=x", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: sample should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song/foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
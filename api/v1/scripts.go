@@ -0,0 +1,436 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/ecal"
+)
+
+/*
+EndpointScripts is the scripts endpoint URL (rooted). Handles everything under scripts/...
+*/
+const EndpointScripts = api.APIRoot + APIv1 + "/scripts/"
+
+/*
+EndpointScript is the script invocation endpoint URL (rooted). Handles everything under script/...
+*/
+const EndpointScript = api.APIRoot + APIv1 + "/script/"
+
+/*
+Scripts is the script manager which is used by the script endpoints (nil if
+scripts are disabled).
+*/
+var Scripts *ecal.ScriptManager
+
+/*
+checkScripts checks if scripts are enabled. Writes an error and returns false
+if they are not.
+*/
+func checkScripts(w http.ResponseWriter) bool {
+	if Scripts == nil {
+		http.Error(w, "Scripts are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+ScriptsEndpointInst creates a new endpoint handler.
+*/
+func ScriptsEndpointInst() api.RestEndpointHandler {
+	return &scriptsEndpoint{}
+}
+
+/*
+Handler object for script management operations.
+*/
+type scriptsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all scripts or a single script.
+*/
+func (se *scriptsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 1, "") || !checkScripts(w) {
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(Scripts.Scripts())
+		return
+	}
+
+	s := Scripts.Script(resources[0])
+
+	if s == nil {
+		http.Error(w, "Unknown script: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+/*
+HandlePUT stores a script.
+*/
+func (se *scriptsEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a script.
+*/
+func (se *scriptsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	s := &ecal.Script{}
+
+	if !checkResources(w, resources, 1, 1, "Need a script name") || !checkScripts(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Name = resources[0]
+
+	if err := Scripts.Validate(s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Scripts.StoreScript(s); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a script.
+*/
+func (se *scriptsEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a script name") || !checkScripts(w) {
+		return
+	}
+
+	ok, err := Scripts.RemoveScript(resources[0])
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if !ok {
+		http.Error(w, "Unknown script: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *scriptsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the script.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	scriptParams := append(nameParams, map[string]interface{}{
+		"name":        "script",
+		"in":          "body",
+		"description": "Script which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Script",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/scripts"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all scripts.",
+			"description": "All deployed scripts are returned.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of scripts.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Script",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/scripts/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a script.",
+			"description": "A deployed script is returned.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Script.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Script",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Deploy a script.",
+			"description": "The ECAL code of the script is compiled and stored. The script can be invoked " +
+				"through the script endpoint and runs on the given graph events.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": scriptParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The script was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a script.",
+			"description": "The script is removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The script was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Script"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"description": "Name of the script.",
+				"type":        "string",
+			},
+			"code": map[string]interface{}{
+				"description": "ECAL code of the script. The result of the script is the value of the variable result.",
+				"type":        "string",
+			},
+			"events": map[string]interface{}{
+				"description": "Graph events which run the script (node.created, node.updated, node.deleted, " +
+					"edge.created, edge.updated or edge.deleted).",
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"partitions": map[string]interface{}{
+				"description": "Partitions which can be accessed by the script.",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"readonly": map[string]interface{}{
+				"description": "Flag if the script may not write to the datastore.",
+				"type":        "boolean",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
+
+/*
+ScriptEndpointInst creates a new endpoint handler.
+*/
+func ScriptEndpointInst() api.RestEndpointHandler {
+	return &scriptEndpoint{}
+}
+
+/*
+Handler object for script invocations.
+*/
+type scriptEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET invokes a script.
+*/
+func (se *scriptEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.invoke(w, r, resources)
+}
+
+/*
+HandlePOST invokes a script.
+*/
+func (se *scriptEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.invoke(w, r, resources)
+}
+
+/*
+HandlePUT invokes a script.
+*/
+func (se *scriptEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.invoke(w, r, resources)
+}
+
+/*
+HandleDELETE invokes a script.
+*/
+func (se *scriptEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.invoke(w, r, resources)
+}
+
+/*
+invoke runs a script with the request in the variable request and returns the
+result of the script as JSON.
+*/
+func (se *scriptEndpoint) invoke(w http.ResponseWriter, r *http.Request, resources []string) {
+	var bodyJSON interface{}
+
+	if len(resources) == 0 {
+		http.Error(w, "Need a script name", http.StatusBadRequest)
+		return
+	} else if !checkScripts(w) {
+		return
+	}
+
+	if Scripts.Script(resources[0]) == nil {
+		http.Error(w, "Unknown script: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Could not read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.Unmarshal(body, &bodyJSON)
+
+	toValues := func(v []string) []interface{} {
+		ret := make([]interface{}, 0, len(v))
+		for _, val := range v {
+			ret = append(ret, val)
+		}
+		return ret
+	}
+
+	query := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		query[k] = toValues(v)
+	}
+
+	header := make(map[string]interface{})
+	for k, v := range r.Header {
+		header[k] = toValues(v)
+	}
+
+	res, err := Scripts.Run(resources[0], map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":     r.Method,
+			"path":       strings.Join(resources[1:], "/"),
+			"query":      query,
+			"header":     header,
+			"bodyString": string(body),
+			"bodyJSON":   bodyJSON,
+			"user":       requestUser(r),
+		},
+	})
+
+	if err != nil {
+		http.Error(w, "Script failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(res)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *scriptEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/script/{name}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Invoke a script.",
+			"description": "The script runs with the variable request which contains the method, path, " +
+				"query, header, bodyString, bodyJSON and user of the request. All HTTP methods " +
+				"invoke the script. The value of the variable result is returned as JSON.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "name",
+					"in":          "path",
+					"description": "Name of the script.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Result of the script.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/ecal"
+)
+
+func TestScripts(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointScripts
+	invokeURL := "http://localhost" + TESTPORT + EndpointScript
+
+	oldGM := api.GM
+	oldScripts := Scripts
+	defer func() {
+		api.GM = oldGM
+		Scripts = oldScripts
+	}()
+
+	api.GM, _ = songGraph()
+	Scripts = nil
+
+	st, _, res := sendTestRequest(invokeURL+"test", "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Scripts are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var err error
+
+	if Scripts, err = ecal.NewScriptManager(api.GM, api.SystemPartition); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Invalid scripts are rejected
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", []byte(`{"partitions": ["_system"]}`))
+
+	if st != "400 Bad Request" || res != "Scripts cannot access the partition: _system" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Need a script name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Deploy and invoke a script
+
+	st, _, res = sendTestRequest(queryURL+"song", "POST", []byte(`{"partitions": ["main"], "readonly": true,
+		"code": "n := db.fetchNode(\"main\", request.path, \"Song\")\nresult := {\"ranking\": n.ranking, \"method\": request.method}"}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(invokeURL+"song/Aria1", "POST", nil)
+
+	if st != "200 OK" || res != `
+{
+  "method": "POST",
+  "ranking": 8
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(invokeURL+"unknown", "GET", nil)
+
+	if st != "404 Not Found" || res != "Unknown script: unknown" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != `
+[
+  {
+    "name": "song",
+    "code": "n := db.fetchNode(\"main\", request.path, \"Song\")\nresult := {\"ranking\": n.ranking, \"method\": request.method}",
+    "partitions": [
+      "main"
+    ],
+    "readonly": true
+  }
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+"song", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"song", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown script: song" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
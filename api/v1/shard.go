@@ -0,0 +1,344 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/shard"
+)
+
+/*
+EndpointShard is the shard endpoint URL (rooted). Handles everything under shard/...
+*/
+const EndpointShard = api.APIRoot + APIv1 + "/shard/"
+
+/*
+ShardRouter is the router which distributes requests over the shards of a
+graph (nil if sharding is not enabled).
+*/
+var ShardRouter *shard.Router
+
+/*
+ShardEndpointInst creates a new endpoint handler.
+*/
+func ShardEndpointInst() api.RestEndpointHandler {
+	return &shardEndpoint{}
+}
+
+/*
+Handler object for sharded graph operations.
+*/
+type shardEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the shard map, a node from the shard which stores it or the
+merged result of a query on all shards of a partition.
+*/
+func (se *shardEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var res interface{}
+
+	if !checkShardingEnabled(w) {
+		return
+	}
+
+	if len(resources) == 0 {
+
+		res = ShardRouter.ShardMap()
+
+	} else if resources[0] == "graph" {
+
+		if len(resources) != 5 || resources[2] != "n" {
+			http.Error(w, "Need a partition, entity type (n), kind and key", http.StatusBadRequest)
+			return
+		}
+
+		node, err := ShardRouter.FetchNode(resources[1], resources[4], resources[3])
+
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusBadGateway)
+			return
+		} else if node == nil {
+			http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
+			return
+		}
+
+		res = data.TagValues(node.Data())
+
+	} else if resources[0] == "query" {
+
+		if !checkResources(w, resources, 2, 2, "Need a partition") {
+			return
+		}
+
+		query := r.URL.Query().Get("q")
+
+		if query == "" {
+			http.Error(w, "Missing query (q parameter)", http.StatusBadRequest)
+			return
+		}
+
+		qres, err := ShardRouter.Query(resources[1], query)
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusBadGateway)
+			return
+		}
+
+		res = qres
+
+	} else {
+		http.Error(w, "Unknown resource: "+resources[0], http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(res)
+}
+
+/*
+HandlePOST stores nodes and edges on the shards which are responsible for them.
+*/
+func (se *shardEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.handleGraphRequest(w, r, resources, (*shard.Router).StoreGraph)
+}
+
+/*
+HandleDELETE removes nodes and edges from the shards which store them.
+*/
+func (se *shardEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.handleGraphRequest(w, r, resources, (*shard.Router).RemoveGraph)
+}
+
+/*
+handleGraphRequest handles a REST call which changes the graph on all
+responsible shards. Returns a summary of the nodes and edges.
+*/
+func (se *shardEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Request, resources []string,
+	routerFunc func(router *shard.Router, part string, nodes []data.Node, edges []data.Edge) error) {
+
+	var nodes []data.Node
+	var edges []data.Edge
+
+	if !checkShardingEnabled(w) {
+		return
+	}
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if len(resources) != 2 || resources[0] != "graph" {
+		http.Error(w, "Need a partition", http.StatusBadRequest)
+		return
+	}
+
+	gdata := make(map[string][]map[string]interface{})
+
+	if err := json.NewDecoder(r.Body).Decode(&gdata); err != nil {
+		http.Error(w, "Could not decode request body as object with list of nodes and/or edges: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, ndata := range gdata["nodes"] {
+		if err := data.UntagValues(ndata); err != nil {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		nodes = append(nodes, data.NewGraphNodeFromMap(ndata))
+	}
+
+	for _, edata := range gdata["edges"] {
+		if err := data.UntagValues(edata); err != nil {
+			api.ReportError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		edges = append(edges, data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edata)))
+	}
+
+	if err := routerFunc(ShardRouter, resources[1], nodes, edges); err != nil {
+		api.ReportError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	summary := &graphSummary{len(nodes), len(edges), []string{}}
+
+	for _, node := range nodes {
+		summary.Keys = append(summary.Keys, node.Key())
+	}
+	for _, edge := range edges {
+		summary.Keys = append(summary.Keys, edge.Key())
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(summary)
+}
+
+/*
+checkShardingEnabled checks if sharding is enabled.
+*/
+func checkShardingEnabled(w http.ResponseWriter) bool {
+	if ShardRouter == nil {
+		http.Error(w, "Sharding is not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *shardEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	partParam := map[string]interface{}{
+		"name":        "partition",
+		"in":          "path",
+		"description": "Partition to select.",
+		"required":    true,
+		"type":        "string",
+	}
+
+	graphOp := func(summary string) map[string]interface{} {
+		return map[string]interface{}{
+			"summary": summary,
+			"description": "Nodes are sent to the shard of their key and edges to the " +
+				"shards of both of their ends.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				partParam,
+				{
+					"name":        "entities",
+					"in":          "body",
+					"description": "Object with a list of nodes and a list of edges.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Summary of the nodes and edges.",
+				},
+				"default": errorResponse,
+			},
+		}
+	}
+
+	s["paths"].(map[string]interface{})["/v1/shard"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the shard map.",
+			"description": "The shard map assigns the partitions of the graph to shards.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The shard map.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/shard/graph/{partition}"] = map[string]interface{}{
+		"post":   graphOp("Store nodes and edges on their shards."),
+		"delete": graphOp("Remove nodes and edges from their shards."),
+	}
+
+	s["paths"].(map[string]interface{})["/v1/shard/graph/{partition}/n/{kind}/{key}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a node from the shard which stores it.",
+			"description": "The node is fetched from the shard of its key.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				partParam,
+				{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Node kind.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "key",
+					"in":          "path",
+					"description": "Node key.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The node.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/shard/query/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Run an EQL query on all shards of a partition.",
+			"description": "The rows of all shards are concatenated - the header is taken " +
+				"from the first shard.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				partParam,
+				{
+					"name":        "q",
+					"in":          "query",
+					"description": "URL encoded query to execute.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The merged query result.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
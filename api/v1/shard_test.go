@@ -0,0 +1,293 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/shard"
+)
+
+func TestShard(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointShard
+
+	defer func() {
+		ShardRouter = nil
+		api.OpenDatabase = nil
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Sharding is not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Two databases of this instance act as shards
+
+	gms := map[string]*graph.Manager{
+		"shardtest1": graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("shardtest1")),
+		"shardtest2": graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("shardtest2")),
+	}
+
+	api.OpenDatabase = func(w http.ResponseWriter, r *http.Request, name string) *graph.Manager {
+		return gms[name]
+	}
+	api.RegisterDatabaseEndpoints(V1DatabaseEndpointMap)
+
+	for name := range gms {
+		api.RegisterDatabase(name)
+	}
+
+	sm, err := shard.ParseShardMap([]byte(fmt.Sprintf(`{
+  "shards" : [
+    { "name" : "s1", "url" : "http://localhost%v/db/shardtest1/api" },
+    { "name" : "s2", "url" : "http://localhost%v/db/shardtest2/api" }
+  ],
+  "partitions" : { "main" : [ "s1", "s2" ] },
+  "default" : [ "s1" ]
+}`, TESTPORT, TESTPORT)))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ShardRouter = shard.NewRouter(sm, nil)
+
+	for name, gm := range map[string]*graph.Manager{"s1": gms["shardtest1"], "s2": gms["shardtest2"]} {
+		ls, _ := ShardRouter.Local(name)
+		gm.SetRemoteNodes(ls)
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != fmt.Sprintf(`{
+  "shards": [
+    {
+      "name": "s1",
+      "url": "http://localhost%v/db/shardtest1/api"
+    },
+    {
+      "name": "s2",
+      "url": "http://localhost%v/db/shardtest2/api"
+    }
+  ],
+  "partitions": {
+    "main": [
+      "s1",
+      "s2"
+    ]
+  },
+  "default": [
+    "s1"
+  ]
+}`, TESTPORT, TESTPORT) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Store a graph whose nodes are spread over both shards
+
+	var nodes, edges []string
+
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, fmt.Sprintf(`{"key":"%v","kind":"Person","name":"Person %v"}`, i, i))
+
+		if i > 0 {
+			edges = append(edges, fmt.Sprintf(`{"key":"%v","kind":"Knows","end1key":"0","end1kind":"Person",`+
+				`"end1role":"friend","end1cascading":false,"end2key":"%v","end2kind":"Person",`+
+				`"end2role":"friend","end2cascading":false}`, i, i))
+		}
+	}
+
+	body := fmt.Sprintf(`{"nodes":[%v],"edges":[%v]}`, strings.Join(nodes, ","), strings.Join(edges, ","))
+
+	st, _, res = sendTestRequest(queryURL+"graph/main", "POST", []byte(body))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	n1 := gms["shardtest1"].NodeCount("Person")
+	n2 := gms["shardtest2"].NodeCount("Person")
+
+	if n1 == 0 || n2 == 0 || n1+n2 != 10 {
+		t.Error("Unexpected distribution:", n1, n2)
+		return
+	}
+
+	// Each shard can traverse from its nodes to nodes of the other shard
+
+	home := gms[map[string]string{"s1": "shardtest1", "s2": "shardtest2"}[sm.ShardOf("main", "0").Name]]
+
+	if nodes, _, err := home.TraverseMulti("main", "0", "Person", ":::", false); err != nil || len(nodes) != 9 {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Nodes of the other shard are fetched if all data is required
+
+	traversed, _, err := home.TraverseMulti("main", "0", "Person", ":::", true)
+	if err != nil || len(traversed) != 9 {
+		t.Error("Unexpected result:", traversed, err)
+		return
+	}
+
+	for _, n := range traversed {
+		if n.Attr("name") != "Person "+n.Key() {
+			t.Error("Unexpected node:", n)
+			return
+		}
+	}
+
+	// Remote calls of EQL traversals are limited by the budget of the query
+
+	eqlQuery := "get Person where key = '0' traverse ::: end show 2:n:name"
+
+	if res, err := eql.RunQueryWithOptions(context.Background(), "test", "main", eqlQuery, home,
+		&eql.QueryOptions{RemoteCallBudget: 1}); err != nil || res.RowCount() != 9 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := eql.RunQueryWithOptions(context.Background(), "test", "main", eqlQuery, home,
+		&eql.QueryOptions{RemoteCallBudget: 0}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nodes are fetched from their shard
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Person/5", "GET", nil)
+
+	if st != "200 OK" || res != `{
+  "key": "5",
+  "kind": "Person",
+  "name": "Person 5"
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main/n/Person/50", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a partition, entity type (n), kind and key" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Queries are run on all shards (the result cache is created on first use)
+
+	QueryEndpointInst()
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=get+Person", "GET", nil)
+
+	var qres map[string]interface{}
+	json.Unmarshal([]byte(res), &qres)
+
+	if st != "200 OK" || len(qres["rows"].([]interface{})) != 10 || len(qres["sources"].([]interface{})) != 10 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query/main", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Missing query (q parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"query/main?q=foo", "GET", nil)
+
+	if st != "502 Bad Gateway" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown resource: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Removing a node removes its edges on all shards
+
+	st, _, res = sendTestRequest(queryURL+"graph/main", "DELETE", []byte(`{"nodes":[{"key":"0","kind":"Person"}]}`))
+
+	if st != "200 OK" || res != `{
+  "nodes_stored": 1,
+  "edges_stored": 0,
+  "keys": [
+    "0"
+  ]
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	for name, gm := range gms {
+		if c := gm.EdgeCount("Knows"); c != 0 {
+			t.Error("Unexpected edge count:", name, c)
+			return
+		}
+
+		if report, err := gm.CheckConsistency(nil); err != nil || !report.Consistent() {
+			t.Error("Unexpected result:", name, report, err)
+			return
+		}
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph", "POST", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph/main", "POST", []byte(`{"nodes":[{"kind":"Person"}],"edges":[{"key":"x","kind":"Knows"}]}`))
+
+	if st != "502 Bad Gateway" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"graph/main", "POST", []byte(`{}`))
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
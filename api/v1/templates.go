@@ -0,0 +1,476 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointTemplates is the templates endpoint URL (rooted). Handles everything under templates/...
+*/
+const EndpointTemplates = api.APIRoot + APIv1 + "/templates/"
+
+/*
+templateNodeKind is the node kind which stores report templates in the system partition.
+*/
+const templateNodeKind = "template"
+
+/*
+TemplateMaxOutputSize is the maximum size of a rendered report in bytes.
+*/
+var TemplateMaxOutputSize = 10 * 1024 * 1024
+
+/*
+ReportTemplate is a Go template which renders a cached query result to HTML
+or text. HTML templates escape all values automatically.
+*/
+type ReportTemplate struct {
+	Name     string `json:"name"`     // Name of the template
+	Format   string `json:"format"`   // Output format (html or text)
+	Template string `json:"template"` // Go template
+}
+
+/*
+ReportData is the data which is passed to a report template.
+*/
+type ReportData struct {
+	Template    string          // Name of the template
+	Time        time.Time       // Time of the rendering
+	Labels      []string        // Column labels
+	Format      []string        // Column formats
+	Data        []string        // Column data specifications
+	PrimaryKind string          // Primary kind of the query
+	Rows        [][]interface{} // Result rows
+	Sources     [][]string      // Sources of the result rows
+	Selections  []bool          // Selections of the result rows
+	Total       int             // Total number of result rows
+}
+
+/*
+reportExecutor is a parsed report template.
+*/
+type reportExecutor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+/*
+parse parses the template and returns an executor for the template's format.
+*/
+func (t *ReportTemplate) parse() (reportExecutor, error) {
+
+	if t.Format == "" {
+		t.Format = "html"
+	}
+
+	if t.Template == "" {
+		return nil, fmt.Errorf("Template must not be empty")
+	} else if t.Format == "html" {
+		return htmltemplate.New(t.Name).Parse(t.Template)
+	} else if t.Format == "text" {
+		return template.New(t.Name).Parse(t.Template)
+	}
+
+	return nil, fmt.Errorf("Unknown template format: %v", t.Format)
+}
+
+/*
+fetchTemplate fetches a stored template. Returns nil if the template does not exist.
+*/
+func fetchTemplate(name string) (*ReportTemplate, error) {
+	var t *ReportTemplate
+
+	node, err := api.GM.FetchNode(api.SystemPartition, name, templateNodeKind)
+
+	if err == nil && node != nil {
+		t = &ReportTemplate{}
+		err = json.Unmarshal([]byte(node.Attr("data").(string)), t)
+	}
+
+	return t, err
+}
+
+/*
+fetchTemplates fetches all stored templates sorted by name.
+*/
+func fetchTemplates() ([]*ReportTemplate, error) {
+	var templates []*ReportTemplate
+
+	it, err := api.GM.NodeKeyIterator(api.SystemPartition, templateNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var t *ReportTemplate
+
+			if t, err = fetchTemplate(key); t != nil {
+				templates = append(templates, t)
+			}
+		}
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates, err
+}
+
+/*
+renderQueryResult renders a cached query result with a stored template. Only
+the first rows of the result are rendered if a limit is given (-1 means no
+limit).
+*/
+func renderQueryResult(w http.ResponseWriter, r *http.Request, name string, res *APISearchResult, limit int) {
+	var buf bytes.Buffer
+
+	t, err := fetchTemplate(name)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	} else if t == nil {
+		http.Error(w, "Unknown template: "+name, http.StatusNotFound)
+		return
+	}
+
+	exec, err := t.parse()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	header := res.Header()
+
+	rd := &ReportData{
+		Template:    t.Name,
+		Time:        time.Now(),
+		Labels:      header.Labels(),
+		Format:      header.Format(),
+		Data:        header.Data(),
+		PrimaryKind: header.PrimaryKind(),
+		Rows:        res.Rows(),
+		Sources:     res.RowSources(),
+		Selections:  res.Selections(),
+		Total:       res.RowCount(),
+	}
+
+	if limit != -1 && limit < len(rd.Rows) {
+		rd.Rows = rd.Rows[:limit]
+		rd.Sources = rd.Sources[:limit]
+		rd.Selections = rd.Selections[:limit]
+	}
+
+	// Remove values which may not be returned and translate keys
+
+	if proj := api.ResponseProjection.ForRequest(r); proj != nil {
+		rd.Rows = projectRows(proj, rd.Data, rd.Rows, rd.Sources)
+	}
+
+	if api.KeyObfuscation != nil {
+		rd.Rows, rd.Sources = externalRows(rd.Data, rd.Rows, rd.Sources)
+	}
+
+	err = exec.Execute(&limitedWriter{&buf, TemplateMaxOutputSize}, rd)
+	if err != nil {
+		http.Error(w, "Could not render template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if t.Format == "html" {
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+	}
+
+	w.Write(buf.Bytes())
+}
+
+/*
+limitedWriter is a writer which fails if more than a given number of bytes
+are written.
+*/
+type limitedWriter struct {
+	w         io.Writer // Underlying writer
+	remaining int       // Number of bytes which can still be written
+}
+
+/*
+Write writes to the underlying writer if the limit is not exceeded.
+*/
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+
+	if len(p) > lw.remaining {
+		return 0, fmt.Errorf("Output exceeds %v bytes", TemplateMaxOutputSize)
+	}
+
+	lw.remaining -= len(p)
+
+	return lw.w.Write(p)
+}
+
+// REST endpoint
+// =============
+
+/*
+TemplatesEndpointInst creates a new endpoint handler.
+*/
+func TemplatesEndpointInst() api.RestEndpointHandler {
+	return &templatesEndpoint{}
+}
+
+/*
+Handler object for report template operations.
+*/
+type templatesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all templates or a single template.
+*/
+func (te *templatesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var templates []*ReportTemplate
+	var err error
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 {
+		templates, err = fetchTemplates()
+
+	} else {
+		var t *ReportTemplate
+
+		if t, err = fetchTemplate(resources[0]); err == nil && t == nil {
+			http.Error(w, "Unknown template: "+resources[0], http.StatusNotFound)
+			return
+		}
+
+		templates = []*ReportTemplate{t}
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		if templates == nil {
+			templates = []*ReportTemplate{}
+		}
+		json.NewEncoder(w).Encode(templates)
+	} else {
+		json.NewEncoder(w).Encode(templates[0])
+	}
+}
+
+/*
+HandlePUT stores a template.
+*/
+func (te *templatesEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	te.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a template.
+*/
+func (te *templatesEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	t := &ReportTemplate{}
+
+	if !checkResources(w, resources, 1, 1, "Need a template name") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.Name = resources[0]
+
+	if _, err := t.parse(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	templateJSON, err := json.Marshal(t)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, t.Name)
+		node.SetAttr(data.NodeKind, templateNodeKind)
+		node.SetAttr("owner", requestUser(r))
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(templateJSON))
+
+		err = api.GM.StoreNode(api.SystemPartition, node)
+	}
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a template.
+*/
+func (te *templatesEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a template name") {
+		return
+	}
+
+	node, err := api.GM.RemoveNode(api.SystemPartition, resources[0], templateNodeKind)
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if node == nil {
+		http.Error(w, "Unknown template: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (te *templatesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the template.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	templateParams := append(nameParams, map[string]interface{}{
+		"name":        "template",
+		"in":          "body",
+		"description": "Template which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/ReportTemplate",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/templates"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all report templates.",
+			"description": "All stored report templates are returned.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of report templates.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/ReportTemplate",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/templates/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a report template.",
+			"description": "A stored report template is returned.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Report template.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/ReportTemplate",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store a report template.",
+			"description": "The Go template renders a cached query result to HTML or text " +
+				"(see /v1/queryresult/{rid}/render/{name}).",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": templateParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The report template was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a report template.",
+			"description": "The report template is removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The report template was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["ReportTemplate"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"format": map[string]interface{}{
+				"description": "Output format (html or text).",
+				"type":        "string",
+			},
+			"template": map[string]interface{}{
+				"description": "Go template which renders the query result.",
+				"type":        "string",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
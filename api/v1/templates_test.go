@@ -0,0 +1,161 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointTemplates
+	resultURL := "http://localhost" + TESTPORT + EndpointQueryResult
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Invalid templates are rejected
+
+	for body, msg := range map[string]string{
+		`{"template": ""}`:                          "Template must not be empty",
+		`{"template": "{{.Rows", "format": "html"}`: "template: report1:1: unclosed action",
+		`{"template": "x", "format": "pdf"}`:        "Unknown template format: pdf",
+		`[1]`: "Could not decode request body as object: json: cannot unmarshal array " +
+			"into Go value of type v1.ReportTemplate",
+	} {
+		st, _, res = sendTestRequest(queryURL+"report1", "POST", []byte(body))
+
+		if st != "400 Bad Request" || res != msg {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	st, _, res = sendTestRequest(queryURL+"report1", "POST", []byte(`{"template": `+
+		`"<h1>{{.PrimaryKind}} ({{.Total}})</h1><table><tr>{{range .Labels}}<th>{{.}}</th>{{end}}</tr>`+
+		`{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</table>"}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"report2", "PUT", []byte(`{"format": "text", "template": `+
+		`"{{range .Rows}}{{index . 1}}: <{{index . 2}}>\n{{end}}"}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var templates []*ReportTemplate
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &templates)
+
+	if st != "200 OK" || len(templates) != 2 || templates[0].Name != "report1" ||
+		templates[0].Format != "html" || templates[1].Name != "report2" || templates[1].Format != "text" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var template *ReportTemplate
+
+	st, _, res = sendTestRequest(queryURL+"report2", "GET", nil)
+	json.Unmarshal([]byte(res), &template)
+
+	if st != "200 OK" || template.Name != "report2" ||
+		template.Template != "{{range .Rows}}{{index . 1}}: <{{index . 2}}>\n{{end}}" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Render a cached query result
+
+	st, header, res := sendTestRequest("http://localhost"+TESTPORT+EndpointQuery+
+		"main?q=get+Song+where+key+beginswith+Aria+with+ordering(ascending+key)", "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	rid := header.Get(HTTPHeaderCacheID)
+
+	st, header, res = sendTestRequest(resultURL+rid+"/render/report1?limit=2", "GET", nil)
+
+	if st != "200 OK" || header.Get("content-type") != "text/html; charset=utf-8" ||
+		res != "<h1>Song (4)</h1><table><tr><th>Song Key</th><th>Song Name</th><th>Ranking</th></tr>"+
+			"<tr><td>Aria1</td><td>Aria1</td><td>8</td></tr>"+
+			"<tr><td>Aria2</td><td>Aria2</td><td>2</td></tr></table>" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	st, header, res = sendTestRequest(resultURL+rid+"/render/report2", "GET", nil)
+
+	if st != "200 OK" || header.Get("content-type") != "text/plain; charset=utf-8" ||
+		res != "Aria1: <8>\nAria2: <2>\nAria3: <4>\nAria4: <18>" {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	// Test error cases
+
+	for url, expected := range map[string]string{
+		resultURL + rid + "/render":         "400 Bad Request Need a template name",
+		resultURL + rid + "/render/report3": "404 Not Found Unknown template: report3",
+		queryURL + "report3":                "404 Not Found Unknown template: report3",
+		queryURL + "report1/x":              "400 Bad Request Invalid resource specification: x",
+	} {
+		if st, _, res = sendTestRequest(url, "GET", nil); st+" "+res != expected {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	if st, _, res = sendTestRequest(resultURL+rid+"/render/report1", "POST", nil); st != "400 Bad Request" ||
+		res != "Render can only handle GET requests" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	oldMax := TemplateMaxOutputSize
+	TemplateMaxOutputSize = 10
+	defer func() {
+		TemplateMaxOutputSize = oldMax
+	}()
+
+	if st, _, res = sendTestRequest(resultURL+rid+"/render/report2", "GET", nil); st != "400 Bad Request" ||
+		res != "Could not render template: Output exceeds 10 bytes" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove the templates
+
+	for _, name := range []string{"report1", "report2"} {
+		if st, _, res = sendTestRequest(queryURL+name, "DELETE", nil); st != "200 OK" || res != "" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	if st, _, res = sendTestRequest(queryURL+"report1", "DELETE", nil); st != "404 Not Found" ||
+		res != "Unknown template: report1" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
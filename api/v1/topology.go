@@ -0,0 +1,298 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+EndpointTopology is the topology endpoint URL (rooted). Handles everything under topology/...
+*/
+const EndpointTopology = api.APIRoot + APIv1 + "/topology/"
+
+/*
+Topology is the replication topology of this instance (nil if not enabled).
+*/
+var Topology *replication.Topology
+
+/*
+PromotedChangeLogSize is the size of the change log which is created when a
+replica without a change log is promoted.
+*/
+var PromotedChangeLogSize = 100000
+
+/*
+topologyUpgrader can upgrade normal requests to websocket communications
+*/
+var topologyUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+/*
+TopologyEndpointInst creates a new endpoint handler.
+*/
+func TopologyEndpointInst() api.RestEndpointHandler {
+	return &topologyEndpoint{}
+}
+
+/*
+Handler object for topology operations.
+*/
+type topologyEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the current topology or streams topology changes over a
+websocket.
+*/
+func (te *topologyEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkTopologyEnabled(w) || !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 {
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(Topology.Info())
+		return
+	}
+
+	if resources[0] != "events" {
+		http.Error(w, "Unknown resource: "+resources[0], http.StatusBadRequest)
+		return
+	}
+
+	conn, err := topologyUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+
+		// We give details here on what went wrong
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	defer conn.Close()
+
+	changes, unsubscribe := Topology.Subscribe()
+	defer unsubscribe()
+
+	// Read from the connection to notice when the client hangs up
+
+	closed := make(chan bool)
+
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	// Send the current topology and then every change
+
+	info := Topology.Info()
+
+	for {
+		if err := conn.WriteJSON(map[string]interface{}{
+			"type":    "topology",
+			"payload": info,
+		}); err != nil {
+			return
+		}
+
+		select {
+		case info = <-changes:
+		case <-closed:
+			return
+		}
+	}
+}
+
+/*
+HandlePOST promotes this instance to primary or lets a replica follow a new
+primary.
+*/
+func (te *topologyEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkTopologyEnabled(w) || !checkResources(w, resources, 1, 1, "Need a topology operation") {
+		return
+	}
+
+	switch resources[0] {
+
+	case "promote":
+
+		if Replica == nil {
+			http.Error(w, replication.ErrAlreadyPrimary.Error(), http.StatusBadRequest)
+			return
+		}
+
+		promoteReplica()
+
+	case "follow":
+		var params map[string]interface{}
+
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		primary, _ := params["primary"].(string)
+
+		if primary == "" {
+			http.Error(w, "Need a primary", http.StatusBadRequest)
+			return
+		} else if Replica == nil {
+			http.Error(w, "Only replicas can follow a new primary", http.StatusBadRequest)
+			return
+		}
+
+		Replica.SetPrimary(primary)
+		Topology.Follow(primary)
+
+	default:
+		http.Error(w, "Unknown topology operation: "+resources[0], http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(Topology.Info())
+}
+
+/*
+promoteReplica stops the replication and makes this instance the primary.
+A change log is created if necessary so other replicas can follow.
+*/
+func promoteReplica() {
+
+	Replica.Stop()
+	Replica = nil
+
+	if ChangeLog == nil {
+		ChangeLog = replication.NewChangeLog(PromotedChangeLogSize)
+		api.GM.SetGraphRule(ChangeLog)
+	}
+
+	api.ReadOnly = false
+
+	Topology.Promote()
+}
+
+/*
+checkTopologyEnabled checks if the topology is available.
+*/
+func checkTopologyEnabled(w http.ResponseWriter) bool {
+	if Topology == nil {
+		http.Error(w, "Topology is not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (te *topologyEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/topology"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Return the replication topology.",
+			"description": "The topology describes the role of this instance, the current " +
+				"primary and all known members. The epoch is increased on every change.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The current topology.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/topology/events"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Stream topology changes.",
+			"description": "Websocket which sends the current topology and then every " +
+				"change of the topology (e.g. after a failover).",
+			"responses": map[string]interface{}{
+				"101": map[string]interface{}{
+					"description": "Switching to the websocket protocol.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/topology/{operation}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Change the role of this instance.",
+			"description": "The promote operation makes a replica the primary. The follow " +
+				"operation lets a replica follow a new primary.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "operation",
+					"in":          "path",
+					"description": "Topology operation (promote or follow).",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "primary",
+					"in":          "body",
+					"description": "Object with the URL of the new primary (only for follow).",
+					"required":    false,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The new topology.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
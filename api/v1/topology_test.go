@@ -0,0 +1,165 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
+)
+
+func TestTopology(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointTopology
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+		ChangeLog = nil
+		Replica = nil
+		Topology = nil
+		api.ReadOnly = false
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Topology is not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	primary := "http://localhost" + TESTPORT
+
+	api.ReadOnly = true
+	Replica = replication.NewReplica(primary, api.GM, nil)
+	Topology = replication.NewTopology("https://replica:9090", primary, time.Hour)
+
+	var info replication.TopologyInfo
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &info)
+
+	if st != "200 OK" || info.Role != replication.RoleReplica || info.Primary != primary ||
+		len(info.Members) != 2 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown resource: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Replicas are recorded when they request changes
+
+	ChangeLog = replication.NewChangeLog(2)
+
+	req, _ := http.NewRequest("GET", "http://localhost"+TESTPORT+EndpointChanges+"?since=0", nil)
+	req.Header.Set(replication.ReplicaURLHeader, "https://other:9090")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+
+	if info := Topology.Info(); len(info.Members) != 3 || info.Members[2].URL != "https://other:9090" {
+		t.Error("Unexpected result:", info)
+		return
+	}
+
+	ChangeLog = nil
+
+	// Clients are notified of topology changes
+
+	c, _, err := websocket.DefaultDialer.Dial("ws://localhost"+TESTPORT+EndpointTopology+"events", nil)
+	if err != nil {
+		t.Error("Could not open websocket:", err)
+		return
+	}
+
+	var msg struct {
+		Type    string
+		Payload replication.TopologyInfo
+	}
+
+	if err := c.ReadJSON(&msg); err != nil || msg.Type != "topology" || msg.Payload.Epoch != 1 {
+		t.Error("Unexpected result:", msg, err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"follow", "POST", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Need a primary" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"follow", "POST", []byte(`{"primary":"https://new:9090"}`))
+
+	if st != "200 OK" || Replica.Primary() != "https://new:9090" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if err := c.ReadJSON(&msg); err != nil || msg.Payload.Primary != "https://new:9090" {
+		t.Error("Unexpected result:", msg, err)
+		return
+	}
+
+	// Promote the replica
+
+	st, _, res = sendTestRequest(queryURL+"promote", "POST", nil)
+	json.Unmarshal([]byte(res), &info)
+
+	if st != "200 OK" || info.Role != replication.RolePrimary || info.Primary != "https://replica:9090" ||
+		Replica != nil || ChangeLog == nil || api.ReadOnly {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if err := c.ReadJSON(&msg); err != nil || msg.Payload.Role != replication.RolePrimary {
+		t.Error("Unexpected result:", msg, err)
+		return
+	}
+
+	c.Close()
+
+	st, _, res = sendTestRequest(queryURL+"promote", "POST", nil)
+
+	if st != "400 Bad Request" || res != "This instance is already the primary" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"follow", "POST", []byte(`{"primary":"https://new:9090"}`))
+
+	if st != "400 Bad Request" || res != "Only replicas can follow a new primary" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "POST", nil)
+
+	if st != "400 Bad Request" || res != "Unknown topology operation: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,614 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/cryptutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+EndpointTx is the transaction endpoint URL (rooted). Handles everything under tx/...
+*/
+const EndpointTx = api.APIRoot + APIv1 + "/tx/"
+
+/*
+TxIdleTimeout is the time after which an unused transaction is rolled back.
+Changes only affect new transactions.
+*/
+var TxIdleTimeout = 5 * time.Minute
+
+/*
+TxMaxOpen is the maximum number of open transactions.
+*/
+var TxMaxOpen = 100
+
+/*
+restTrans is a graph transaction which spans multiple REST requests.
+*/
+type restTrans struct {
+	id      string          // ID of the transaction
+	trans   graph.Trans     // Graph transaction
+	created time.Time       // Time when the transaction was started
+	access  time.Time       // Time of the last access
+	timeout time.Duration   // Idle timeout of the transaction
+	timer   *time.Timer     // Timer which rolls back the transaction when it is idle
+	done    bool            // Flag if the transaction was committed or rolled back
+	parts   map[string]bool // Partitions which are changed by the transaction
+	mutex   *sync.Mutex     // Mutex to serialise requests of the transaction
+}
+
+/*
+rollback discards the graph transaction and releases the locks of a locking
+transaction. Expects the mutex of the transaction to be held.
+*/
+func (rt *restTrans) rollback() {
+	rt.done = true
+
+	if lt, ok := rt.trans.(graph.LockingTrans); ok {
+		lt.Rollback()
+	}
+}
+
+/*
+info returns a JSON representation of the transaction. Expects the mutex of
+the transaction to be held.
+*/
+func (rt *restTrans) info() map[string]interface{} {
+	sn, se, rn, re := rt.trans.Counts()
+
+	ret := map[string]interface{}{
+		"id":           rt.id,
+		"created":      rt.created.Unix(),
+		"access":       rt.access.Unix(),
+		"expires":      rt.access.Add(rt.timeout).Unix(),
+		"store_nodes":  sn,
+		"store_edges":  se,
+		"remove_nodes": rn,
+		"remove_edges": re,
+		"locking":      false,
+	}
+
+	if _, ok := rt.trans.(graph.LockingTrans); ok {
+
+		// Locks are held under the ID of the graph transaction
+
+		ret["locking"] = true
+		ret["lock_owner"] = rt.trans.ID()
+	}
+
+	return ret
+}
+
+/*
+transactions holds all open transactions.
+*/
+var transactions = make(map[string]*restTrans)
+
+/*
+transactionsLock protects the transactions map and the access times of all
+transactions.
+*/
+var transactionsLock = &sync.Mutex{}
+
+/*
+beginTrans starts a new transaction. A locking transaction locks all nodes and
+edges which it changes until it is committed or rolled back.
+*/
+func beginTrans(locking bool) (*restTrans, error) {
+	transactionsLock.Lock()
+	defer transactionsLock.Unlock()
+
+	if len(transactions) >= TxMaxOpen {
+		return nil, fmt.Errorf("Too many open transactions")
+	}
+
+	now := time.Now()
+	id := fmt.Sprintf("%x", cryptutil.GenerateUUID())
+
+	trans := graph.NewGraphTrans(api.GM)
+
+	if locking {
+		trans = graph.NewLockingGraphTrans(api.GM)
+	}
+
+	rt := &restTrans{id, trans, now, now, TxIdleTimeout, nil, false, make(map[string]bool), &sync.Mutex{}}
+
+	rt.timer = time.AfterFunc(rt.timeout, func() {
+		transactionsLock.Lock()
+
+		// The timer might have fired while the transaction was accessed
+
+		t, ok := transactions[id]
+		expired := ok && time.Since(t.access) >= t.timeout
+
+		if expired {
+			delete(transactions, id)
+		}
+
+		transactionsLock.Unlock()
+
+		if expired {
+			rt.mutex.Lock()
+			rt.rollback()
+			rt.mutex.Unlock()
+		}
+	})
+
+	transactions[id] = rt
+
+	return rt, nil
+}
+
+/*
+getTrans looks up an open transaction and resets its idle timeout.
+*/
+func getTrans(id string) (*restTrans, bool) {
+	transactionsLock.Lock()
+	defer transactionsLock.Unlock()
+
+	rt, ok := transactions[id]
+
+	if ok {
+		rt.access = time.Now()
+		rt.timer.Reset(rt.timeout)
+	}
+
+	return rt, ok
+}
+
+/*
+endTrans removes an open transaction. The transaction can no longer be used
+once this function returns.
+*/
+func endTrans(id string) (*restTrans, bool) {
+	transactionsLock.Lock()
+	rt, ok := transactions[id]
+	if ok {
+		delete(transactions, id)
+		rt.timer.Stop()
+	}
+	transactionsLock.Unlock()
+
+	if ok {
+		rt.mutex.Lock()
+		rt.done = true
+	}
+
+	return rt, ok
+}
+
+/*
+TxEndpointInst creates a new endpoint handler.
+*/
+func TxEndpointInst() api.RestEndpointHandler {
+	return &txEndpoint{}
+}
+
+/*
+Handler object for transaction operations.
+*/
+type txEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns a list of all open transactions or the state of a single
+transaction.
+*/
+func (te *txEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 {
+		var txList []*restTrans
+
+		transactionsLock.Lock()
+		for _, rt := range transactions {
+			txList = append(txList, rt)
+		}
+		transactionsLock.Unlock()
+
+		sort.Slice(txList, func(i, j int) bool {
+			return txList[i].created.Before(txList[j].created)
+		})
+
+		infoList := make([]map[string]interface{}, 0, len(txList))
+
+		for _, rt := range txList {
+			rt.mutex.Lock()
+			if !rt.done {
+				infoList = append(infoList, rt.info())
+			}
+			rt.mutex.Unlock()
+		}
+
+		ret = infoList
+
+	} else if rt, ok := getTrans(resources[0]); ok {
+
+		rt.mutex.Lock()
+		if !rt.done {
+			ret = rt.info()
+		}
+		rt.mutex.Unlock()
+	}
+
+	if ret == nil {
+		http.Error(w, "Unknown transaction: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePOST starts a new transaction, commits a transaction or stores nodes
+and edges in a transaction.
+*/
+func (te *txEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 0, 3, "") {
+		return
+	}
+
+	if len(resources) == 0 {
+
+		// Start a new transaction - the optional request body can select
+		// a locking transaction
+
+		var opts struct {
+			Locking bool `json:"locking"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+			http.Error(w, "Could not decode request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rt, err := beginTrans(opts.Locking)
+		if err != nil {
+			api.ReportError(w, r, err, http.StatusTooManyRequests)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      rt.id,
+			"timeout": int(rt.timeout / time.Second),
+		})
+
+		return
+
+	} else if len(resources) == 1 {
+
+		// Commit a transaction
+
+		rt, ok := endTrans(resources[0])
+		if !ok {
+			http.Error(w, "Unknown transaction: "+resources[0], http.StatusNotFound)
+			return
+		}
+		defer rt.mutex.Unlock()
+
+		if err := rt.trans.Commit(); err != nil {
+			api.ReportError(w, r, err, commitErrorStatus(err))
+			return
+		}
+
+		parts := make([]string, 0, len(rt.parts))
+		for part := range rt.parts {
+			parts = append(parts, part)
+		}
+
+		setCommitSeqHeader(w, api.GM, parts)
+
+		return
+	}
+
+	te.handleTxRequest(w, r, resources, transStoreNode, transStoreEdge)
+}
+
+/*
+HandlePUT updates nodes and stores edges in a transaction.
+*/
+func (te *txEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 3, "Need a transaction ID and a partition; optional entity type (n or e)") {
+		return
+	}
+
+	te.handleTxRequest(w, r, resources, transUpdateNode, transStoreEdge)
+}
+
+/*
+HandleDELETE rolls back a transaction or removes nodes and edges in a
+transaction.
+*/
+func (te *txEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 3, "Need a transaction ID") {
+		return
+	}
+
+	if len(resources) == 1 {
+
+		// Roll back a transaction - the graph transaction is discarded
+
+		rt, ok := endTrans(resources[0])
+		if !ok {
+			http.Error(w, "Unknown transaction: "+resources[0], http.StatusNotFound)
+			return
+		}
+		rt.rollback()
+		rt.mutex.Unlock()
+
+		return
+
+	} else if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	te.handleTxRequest(w, r, resources, transRemoveNode, transRemoveEdge)
+}
+
+/*
+handleTxRequest adds the nodes and edges of a graph request to a transaction.
+The transaction is rolled back if the request could not be added.
+*/
+func (te *txEndpoint) handleTxRequest(w http.ResponseWriter, r *http.Request, resources []string,
+	transFuncNode func(trans graph.Trans, part string, node data.Node) error,
+	transFuncEdge func(trans graph.Trans, part string, edge data.Edge) error) {
+
+	rt, ok := getTrans(resources[0])
+
+	if ok {
+		rt.mutex.Lock()
+		defer rt.mutex.Unlock()
+	}
+
+	if !ok || rt.done {
+		http.Error(w, "Unknown transaction: "+resources[0], http.StatusNotFound)
+		return
+	}
+
+	rt.parts[resources[1]] = true
+
+	if _, ok := addGraphRequest(w, r, resources[1:], rt.trans, transFuncNode, transFuncEdge); !ok {
+
+		// A partially applied request cannot be undone - roll back the
+		// whole transaction
+
+		transactionsLock.Lock()
+		delete(transactions, rt.id)
+		rt.timer.Stop()
+		transactionsLock.Unlock()
+
+		rt.rollback()
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (te *txEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	idParam := map[string]interface{}{
+		"name":        "id",
+		"in":          "path",
+		"description": "ID of the transaction.",
+		"required":    true,
+		"type":        "string",
+	}
+
+	graphParams := []map[string]interface{}{
+		idParam,
+		{
+			"name":        "partition",
+			"in":          "path",
+			"description": "Partition to select.",
+			"required":    true,
+			"type":        "string",
+		},
+		{
+			"name":        "entities",
+			"in":          "body",
+			"description": "Nodes and Edges which should be changed.",
+			"required":    true,
+			"schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"nodes": map[string]interface{}{
+						"description": "List of nodes.",
+						"type":        "array",
+						"items": map[string]interface{}{
+							"type": "object",
+						},
+					},
+					"edges": map[string]interface{}{
+						"description": "List of edges.",
+						"type":        "array",
+						"items": map[string]interface{}{
+							"type": "object",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	graphOp := func(summary string, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"summary":     summary,
+			"description": description,
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": graphParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when data was added to the transaction.",
+				},
+				"default": errorResponse,
+			},
+		}
+	}
+
+	s["paths"].(map[string]interface{})["/v1/tx"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all open transactions.",
+			"description": "The tx endpoint returns the state of all open transactions.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of transactions.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Start a transaction.",
+			"description": "Starts a new transaction which is rolled back if it is not used within the idle timeout.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "options",
+					"in":          "body",
+					"description": "Transaction options.",
+					"required":    false,
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"locking": map[string]interface{}{
+								"description": "Flag if the transaction should lock all nodes and edges which it changes until it is committed or rolled back. Lock requests which would cause a deadlock fail with a conflict and roll back the transaction.",
+								"type":        "boolean",
+							},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "An object with the ID and the idle timeout in seconds of the new transaction.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/tx/{id}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a transaction.",
+			"description": "Returns the state of an open transaction.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{idParam},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The transaction object.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Commit a transaction.",
+			"description": "Commits all changes of a transaction at once.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{idParam},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the transaction was committed.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Roll back a transaction.",
+			"description": "Discards all changes of a transaction.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{idParam},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "No data is returned when the transaction was rolled back.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/tx/{id}/{partition}"] = map[string]interface{}{
+		"post": graphOp("Store nodes and edges in a transaction.",
+			"Adds nodes and edges to a transaction. The whole transaction is rolled back if the data is invalid."),
+		"put": graphOp("Update nodes and store edges in a transaction.",
+			"Adds node updates and edges to a transaction. The whole transaction is rolled back if the data is invalid."),
+		"delete": graphOp("Remove nodes and edges in a transaction.",
+			"Adds the removal of nodes and edges to a transaction. The whole transaction is rolled back if the data is invalid."),
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestTx(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointTx
+
+	begin := func() string {
+		var ret map[string]interface{}
+
+		st, _, res := sendTestRequest(queryURL, "POST", nil)
+		json.Unmarshal([]byte(res), &ret)
+
+		if st != "200 OK" || ret["timeout"] != float64(300) {
+			t.Error("Unexpected response:", st, res)
+			return ""
+		}
+
+		return ret["id"].(string)
+	}
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Changes of a transaction are only visible after the commit
+
+	id := begin()
+
+	st, _, res = sendTestRequest(queryURL+id+"/main", "POST", []byte(`{
+  "nodes": [{"key": "tx1", "kind": "txtest", "name": "node1"},
+            {"key": "tx2", "kind": "txtest", "name": "node2"}]
+}`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+id+"/main/e", "POST", []byte(`[{
+  "key": "txe1", "kind": "txrel",
+  "end1key": "tx1", "end1kind": "txtest", "end1role": "node1", "end1cascading": false,
+  "end2key": "tx2", "end2kind": "txtest", "end2role": "node2", "end2cascading": false
+}]`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+id+"/main/n", "PUT", []byte(`[{"key": "tx2", "kind": "txtest", "age": 5}]`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "tx1", "txtest"); n != nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	var info map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL+id, "GET", nil)
+	json.Unmarshal([]byte(res), &info)
+
+	if st != "200 OK" || info["id"] != id || info["store_nodes"] != float64(2) ||
+		info["store_edges"] != float64(1) || info["remove_nodes"] != float64(0) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var infoList []map[string]interface{}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	json.Unmarshal([]byte(res), &infoList)
+
+	if st != "200 OK" || len(infoList) != 1 || infoList[0]["id"] != id {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+id, "POST", nil); st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "tx2", "txtest"); err != nil ||
+		n.Attr("name") != "node2" || n.Attr("age") != float64(5) {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if e, err := api.GM.FetchEdge("main", "txe1", "txrel"); e == nil || err != nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	// A committed transaction cannot be used anymore
+
+	if st, _, res = sendTestRequest(queryURL+id, "POST", nil); st != "404 Not Found" ||
+		res != "Unknown transaction: "+id {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Rolled back transactions leave no trace
+
+	id = begin()
+
+	st, _, res = sendTestRequest(queryURL+id+"/main/n", "DELETE", []byte(`[{"key": "tx1", "kind": "txtest"}]`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+id, "DELETE", nil); st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+id+"/main/n", "DELETE", []byte(`[]`)); st != "404 Not Found" ||
+		res != "Unknown transaction: "+id {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "tx1", "txtest"); n == nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Invalid data rolls back the whole transaction
+
+	id = begin()
+
+	st, _, res = sendTestRequest(queryURL+id+"/main/n", "DELETE", []byte(`[{"key": "tx1", "kind": "txtest"}]`))
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+id+"/main/n", "POST", []byte(`[{"key": "tx3"}]`))
+
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Node is missing a kind value)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+id, "POST", nil); st != "404 Not Found" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("main", "tx1", "txtest"); n == nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Idle transactions are rolled back
+
+	oldTimeout := TxIdleTimeout
+	TxIdleTimeout = 50 * time.Millisecond
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	json.Unmarshal([]byte(res), &info)
+	id = info["id"].(string)
+
+	TxIdleTimeout = oldTimeout
+
+	time.Sleep(200 * time.Millisecond)
+
+	if st, _, res = sendTestRequest(queryURL+id, "GET", nil); st != "404 Not Found" ||
+		res != "Unknown transaction: "+id {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Test error cases
+
+	oldMax := TxMaxOpen
+	TxMaxOpen = 0
+
+	if st, _, res = sendTestRequest(queryURL, "POST", nil); st != "429 Too Many Requests" ||
+		res != "Too many open transactions" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	TxMaxOpen = oldMax
+
+	if st, _, res = sendTestRequest(queryURL+"x/main/n", "PUT", []byte(`[]`)); st != "404 Not Found" ||
+		res != "Unknown transaction: x" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+"x", "PUT", nil); st != "400 Bad Request" ||
+		res != "Need a transaction ID and a partition; optional entity type (n or e)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+"x", "DELETE", nil); st != "404 Not Found" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+
+	if st, _, res = sendTestRequest(queryURL, "POST", nil); st != "403 Forbidden" ||
+		res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		api.ReadOnly = false
+		return
+	}
+
+	api.ReadOnly = false
+
+	if st, _, res = sendTestRequest(queryURL, "GET", nil); st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+/*
+EndpointUnindexed is the unindexed endpoint URL (rooted). Handles everything under unindexed/...
+*/
+const EndpointUnindexed = api.APIRoot + APIv1 + "/unindexed/"
+
+/*
+UnindexedEndpointInst creates a new endpoint handler.
+*/
+func UnindexedEndpointInst() api.RestEndpointHandler {
+	return &unindexedEndpoint{}
+}
+
+/*
+Handler object for selective indexing operations.
+*/
+type unindexedEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all attributes which are not indexed or the attributes of a kind.
+*/
+func (ue *unindexedEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	unindexed := api.GM.Unindexed()
+
+	if len(resources) == 0 {
+		ret = unindexed
+	} else if attrs, ok := unindexed[resources[0]]; ok {
+		ret = attrs
+	} else {
+		ret = []string{}
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+HandlePUT disables the index for an attribute.
+*/
+func (ue *unindexedEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	ue.setIndexed(w, r, resources, false)
+}
+
+/*
+HandleDELETE enables the index for an attribute again.
+*/
+func (ue *unindexedEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+	ue.setIndexed(w, r, resources, true)
+}
+
+/*
+setIndexed enables or disables the index for an attribute.
+*/
+func (ue *unindexedEndpoint) setIndexed(w http.ResponseWriter, r *http.Request, resources []string, indexed bool) {
+
+	if api.ReadOnly {
+		http.Error(w, "Datastore is read-only", http.StatusForbidden)
+		return
+	}
+
+	if !checkResources(w, resources, 2, 2, "Need a kind and an attribute") {
+		return
+	}
+
+	if err := api.GM.SetIndexed(resources[0], resources[1], indexed); err != nil {
+		api.ReportError(w, r, err, http.StatusBadRequest)
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ue *unindexedEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	pathParams := []map[string]interface{}{
+		{
+			"name":        "kind",
+			"in":          "path",
+			"description": "Node or edge kind.",
+			"required":    true,
+			"type":        "string",
+		},
+		{
+			"name":        "attr",
+			"in":          "path",
+			"description": "Attribute which should not be indexed (* for all attributes).",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/unindexed"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all attributes which are not indexed.",
+			"description": "Returns a map of kind to a list of attributes which are excluded from the full-text and lookup index.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A map of kind to a list of attributes.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/unindexed/{kind}/{attr}"] = map[string]interface{}{
+		"put": map[string]interface{}{
+			"summary": "Disable the index for an attribute.",
+			"description": "Excludes an attribute from the full-text and lookup index. Existing " +
+				"data needs to be reindexed (e.g. with a reindex job) to remove existing index entries.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": pathParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The index was disabled.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary": "Enable the index for an attribute.",
+			"description": "Includes an attribute in the full-text and lookup index again. Existing " +
+				"data needs to be reindexed (e.g. with a reindex job) to be found.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": pathParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The index was enabled.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+}
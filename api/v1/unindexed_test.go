@@ -0,0 +1,118 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+)
+
+func TestUnindexed(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointUnindexed
+
+	oldGM := api.GM
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM, _ = songGraph()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "{}" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song/ranking", "PUT", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	sendTestRequest(queryURL+"Song/*", "PUT", nil)
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != `{
+  "Song": [
+    "*",
+    "ranking"
+  ]
+}` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song/*", "DELETE", nil)
+
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song", "GET", nil)
+
+	if st != "200 OK" || res != `[
+  "ranking"
+]` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Author", "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Existing data is affected after a reindex
+
+	if err := api.GM.ReindexNodes("main", "Song", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	iq, _ := api.GM.NodeIndexQuery("main", "Song")
+
+	if res, _ := iq.LookupValue("ranking", "19"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := iq.LookupValue("name", "MyOnlySong3"); fmt.Sprint(res) != "[MyOnlySong3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Song", "PUT", nil)
+
+	if st != "400 Bad Request" || res != "Need a kind and an attribute" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.ReadOnly = true
+	defer func() {
+		api.ReadOnly = false
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"Song/name", "DELETE", nil)
+
+	if st != "403 Forbidden" || res != "Datastore is read-only" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,314 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/webhooks"
+)
+
+/*
+EndpointWebhooks is the webhooks endpoint URL (rooted). Handles everything under webhooks/...
+*/
+const EndpointWebhooks = api.APIRoot + APIv1 + "/webhooks/"
+
+/*
+Webhooks is the webhook manager which is managed by the webhooks endpoint (nil
+if webhooks are disabled).
+*/
+var Webhooks *webhooks.Manager
+
+/*
+WebhooksEndpointInst creates a new endpoint handler.
+*/
+func WebhooksEndpointInst() api.RestEndpointHandler {
+	return &webhooksEndpoint{}
+}
+
+/*
+Handler object for webhook operations.
+*/
+type webhooksEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns all webhooks or a single webhook with its delivery
+statistics. Secrets of webhooks are never returned.
+*/
+func (we *webhooksEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret []map[string]interface{}
+
+	if !checkResources(w, resources, 0, 1, "") || !checkWebhooks(w) {
+		return
+	}
+
+	list := Webhooks.Webhooks()
+
+	if len(resources) == 1 {
+		if wh, _ := Webhooks.Webhook(resources[0]); wh != nil {
+			list = []*webhooks.Webhook{wh}
+		} else {
+			http.Error(w, "Unknown webhook: "+resources[0], http.StatusNotFound)
+			return
+		}
+	}
+
+	ret = make([]map[string]interface{}, 0, len(list))
+
+	for _, wh := range list {
+		_, stats := Webhooks.Webhook(wh.Name)
+
+		ret = append(ret, map[string]interface{}{
+			"name":       wh.Name,
+			"url":        wh.URL,
+			"signed":     wh.Secret != "",
+			"partitions": wh.Partitions,
+			"kinds":      wh.Kinds,
+			"ops":        wh.Ops,
+			"disabled":   wh.Disabled,
+			"stats":      stats,
+		})
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(resources) == 0 {
+		json.NewEncoder(w).Encode(ret)
+	} else {
+		json.NewEncoder(w).Encode(ret[0])
+	}
+}
+
+/*
+HandlePUT stores a webhook.
+*/
+func (we *webhooksEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	we.HandlePOST(w, r, resources)
+}
+
+/*
+HandlePOST stores a webhook. The statistics of an existing webhook are reset.
+*/
+func (we *webhooksEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	wh := &webhooks.Webhook{}
+
+	if !checkResources(w, resources, 1, 1, "Need a webhook name") || !checkWebhooks(w) {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(wh); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wh.Name = resources[0]
+
+	if err := Webhooks.Validate(wh); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Webhooks.StoreWebhook(wh); err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+HandleDELETE removes a webhook.
+*/
+func (we *webhooksEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a webhook name") || !checkWebhooks(w) {
+		return
+	}
+
+	ok, err := Webhooks.RemoveWebhook(resources[0])
+
+	if err != nil {
+		api.ReportError(w, r, err, http.StatusInternalServerError)
+	} else if !ok {
+		http.Error(w, "Unknown webhook: "+resources[0], http.StatusNotFound)
+	}
+}
+
+/*
+checkWebhooks checks if webhooks are enabled. Writes an error and returns false
+if they are not.
+*/
+func checkWebhooks(w http.ResponseWriter) bool {
+	if Webhooks == nil {
+		http.Error(w, "Webhooks are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (we *webhooksEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	nameParams := []map[string]interface{}{
+		{
+			"name":        "name",
+			"in":          "path",
+			"description": "Name of the webhook.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	webhookParams := append(nameParams, map[string]interface{}{
+		"name":        "webhook",
+		"in":          "body",
+		"description": "Webhook which should be stored.",
+		"required":    true,
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Webhook",
+		},
+	})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/webhooks"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all webhooks.",
+			"description": "All webhooks are returned with their delivery statistics.",
+			"produces": []string{
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "List of webhooks.",
+					"schema": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"$ref": "#/definitions/Webhook",
+						},
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/webhooks/{name}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a webhook.",
+			"description": "A webhook is returned with its delivery statistics.",
+			"produces": []string{
+				"application/json",
+			},
+			"parameters": nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Webhook.",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Webhook",
+					},
+				},
+				"default": errorResponse,
+			},
+		},
+		"post": map[string]interface{}{
+			"summary": "Store a webhook.",
+			"description": "The webhook receives all changes of its partitions, kinds and operations " +
+				"which are recorded from now on as JSON POST requests. Failed deliveries are retried " +
+				"with an exponential backoff.",
+			"consumes": []string{
+				"application/json",
+			},
+			"parameters": webhookParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The webhook was stored.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove a webhook.",
+			"description": "The webhook is removed.",
+			"parameters":  nameParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The webhook was removed.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["Webhook"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"description": "URL which receives the changes.",
+				"type":        "string",
+			},
+			"secret": map[string]interface{}{
+				"description": "Secret for the HMAC (SHA-256) signature of requests in the " +
+					"X-EliasDB-Signature header (only stored).",
+				"type": "string",
+			},
+			"signed": map[string]interface{}{
+				"description": "Flag if requests are signed (only returned).",
+				"type":        "boolean",
+			},
+			"partitions": map[string]interface{}{
+				"description": "Partitions of the changes (all partitions if empty).",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"kinds": map[string]interface{}{
+				"description": "Kinds of the changes (all kinds if empty).",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"ops": map[string]interface{}{
+				"description": "Operations of the changes (node.store, node.delete, edge.store or " +
+					"edge.delete - all operations if empty).",
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+			},
+			"disabled": map[string]interface{}{
+				"description": "Flag if the webhook is disabled.",
+				"type":        "boolean",
+			},
+			"stats": map[string]interface{}{
+				"description": "Delivery statistics of the webhook (only returned).",
+				"type":        "object",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/replication"
+	"github.com/krotik/eliasdb/webhooks"
+)
+
+func TestWebhooks(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointWebhooks
+
+	oldGM := api.GM
+	oldWebhooks := Webhooks
+	defer func() {
+		api.GM = oldGM
+		Webhooks = oldWebhooks
+	}()
+
+	api.GM, _ = songGraph()
+	Webhooks = nil
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Webhooks are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var err error
+
+	if Webhooks, err = webhooks.NewManager(api.GM, api.SystemPartition,
+		replication.NewChangeLog(10), ExternalChanges); err != nil {
+		t.Error(err)
+		return
+	}
+	defer Webhooks.Close()
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", []byte(`{"url": "localhost"}`))
+
+	if st != "400 Bad Request" || res != "Invalid webhook URL: localhost" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"test", "POST", []byte(`{"url": "http://localhost", "partitions": ["_system"]}`))
+
+	if st != "400 Bad Request" || res != "Webhooks cannot access the partition: _system" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("{}"))
+
+	if st != "400 Bad Request" || res != "Need a webhook name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"songs", "POST", []byte(`{"url": "http://localhost:9999/hook",
+		"secret": "secret", "kinds": ["Song"], "ops": ["node.store"], "disabled": true}`))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Secrets are not returned
+
+	st, _, res = sendTestRequest(queryURL+"songs", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "disabled": true,
+  "kinds": [
+    "Song"
+  ],
+  "name": "songs",
+  "ops": [
+    "node.store"
+  ],
+  "partitions": null,
+  "signed": true,
+  "stats": {
+    "delivered": 0,
+    "failed": 0,
+    "last_seq": 0,
+    "last_delivery": 0,
+    "last_error": ""
+  },
+  "url": "http://localhost:9999/hook"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if st, _, res = sendTestRequest(queryURL+"songs", "DELETE", nil); st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"songs", "DELETE", nil)
+
+	if st != "404 Not Found" || res != "Unknown webhook: songs" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,525 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/eql"
+)
+
+/*
+EndpointWidget is the widget token endpoint URL (rooted). Handles everything under widget/...
+*/
+const EndpointWidget = api.APIRoot + APIv1 + "/widget/"
+
+/*
+EndpointWidgetData is the public widget data endpoint URL (rooted). Handles everything under widget/...
+*/
+const EndpointWidgetData = api.APIRoot + "/widget/"
+
+/*
+EndpointWidgetScript is the public widget script URL.
+*/
+const EndpointWidgetScript = api.APIRoot + "/widget.js"
+
+/*
+WidgetSecret is the secret which is used to sign widget query tokens (widgets
+are disabled if no secret is set).
+*/
+var WidgetSecret string
+
+/*
+WidgetAllowedOrigins is a list of origins which are allowed to embed widgets
+(* allows all origins).
+*/
+var WidgetAllowedOrigins = []string{"*"}
+
+/*
+ErrInvalidWidgetToken is returned if a widget token cannot be verified.
+*/
+var ErrInvalidWidgetToken = errors.New("Invalid widget token")
+
+/*
+ErrExpiredWidgetToken is returned if a widget token has expired.
+*/
+var ErrExpiredWidgetToken = errors.New("Widget token has expired")
+
+/*
+widgetToken is the signed content of a widget token.
+*/
+type widgetToken struct {
+	Part    string `json:"p"` // Partition to query
+	Query   string `json:"q"` // EQL query
+	Expires int64  `json:"e"` // Expiry as Unix time (0 for no expiry)
+}
+
+/*
+signWidgetToken creates a signed token for a query.
+*/
+func signWidgetToken(part string, query string, expires int64) string {
+	payload, _ := json.Marshal(&widgetToken{part, query, expires})
+
+	p := base64.RawURLEncoding.EncodeToString(payload)
+
+	return p + "." + base64.RawURLEncoding.EncodeToString(widgetTokenMAC(p))
+}
+
+/*
+verifyWidgetToken verifies a token and returns its content.
+*/
+func verifyWidgetToken(token string) (*widgetToken, error) {
+	var ret widgetToken
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidWidgetToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, widgetTokenMAC(parts[0])) {
+		return nil, ErrInvalidWidgetToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(payload, &ret) != nil {
+		return nil, ErrInvalidWidgetToken
+	}
+
+	if ret.Expires != 0 && ret.Expires < time.Now().Unix() {
+		return nil, ErrExpiredWidgetToken
+	}
+
+	return &ret, nil
+}
+
+/*
+widgetTokenMAC calculates the signature of a token payload.
+*/
+func widgetTokenMAC(payload string) []byte {
+	key := sha256.Sum256([]byte("widget:" + WidgetSecret))
+
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+/*
+checkWidgetsEnabled checks if widgets are enabled.
+*/
+func checkWidgetsEnabled(w http.ResponseWriter) bool {
+	if WidgetSecret == "" {
+		http.Error(w, "Widgets are not enabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+/*
+setWidgetCORSHeaders allows the origin of a request to read the response if
+it is an allowed origin.
+*/
+func setWidgetCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+
+	w.Header().Add("Vary", "Origin")
+
+	for _, o := range WidgetAllowedOrigins {
+		if o == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return
+		} else if origin != "" && strings.EqualFold(o, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			return
+		}
+	}
+}
+
+/*
+WidgetEndpointInst creates a new endpoint handler.
+*/
+func WidgetEndpointInst() api.RestEndpointHandler {
+	return &widgetEndpoint{}
+}
+
+/*
+Handler object for widget token requests.
+*/
+type widgetEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandlePOST creates a signed token for a query.
+*/
+func (we *widgetEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	var params struct {
+		Query   string `json:"query"`
+		Expires int64  `json:"expires"`
+	}
+
+	if !checkWidgetsEnabled(w) || !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Could not decode request body as object: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if params.Query == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	} else if params.Expires < 0 {
+		http.Error(w, "Invalid expiry: expires should be a positive number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := eql.ParseQuery("widget query", params.Query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expires int64
+
+	if params.Expires > 0 {
+		expires = time.Now().Unix() + params.Expires
+	}
+
+	token := signWidgetToken(resources[0], params.Query, expires)
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":   token,
+		"expires": expires,
+		"url":     EndpointWidgetData + token,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (we *widgetEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/widget/{partition}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Create a signed widget token for a query.",
+			"description": "A widget token allows read-only access to the result of a single query without authentication.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "params",
+					"in":          "body",
+					"description": "Query and expiry of the token in seconds (0 for no expiry).",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"query": map[string]interface{}{
+								"type": "string",
+							},
+							"expires": map[string]interface{}{
+								"type": "integer",
+							},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The signed token and the URL of the widget data.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
+
+/*
+WidgetDataEndpointInst creates a new endpoint handler.
+*/
+func WidgetDataEndpointInst() api.RestEndpointHandler {
+	return &widgetDataEndpoint{}
+}
+
+/*
+Handler object for widget data requests.
+*/
+type widgetDataEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the result of a signed query.
+*/
+func (wd *widgetDataEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	setWidgetCORSHeaders(w, r)
+
+	if !checkWidgetsEnabled(w) || !checkResources(w, resources, 1, 1, "Need a widget token") {
+		return
+	}
+
+	token, err := verifyWidgetToken(resources[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	limit, ok := queryParamPosNum(w, r, "limit")
+	if !ok {
+		return
+	}
+
+	res, err := eql.RunQueryWithOptions(r.Context(), stringutil.CreateDisplayString(token.Part)+" widget query",
+		token.Part, token.Query, api.GM, QueryOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header := res.Header()
+	rows := res.Rows()
+	srcs := res.RowSources()
+
+	if limit != -1 && limit < len(rows) {
+		rows = rows[:limit]
+		srcs = srcs[:limit]
+	}
+
+	// Remove values which may not be returned and translate keys
+
+	if proj := api.ResponseProjection.ForRequest(r); proj != nil {
+		rows = projectRows(proj, header.Data(), rows, srcs)
+	}
+
+	if api.KeyObfuscation != nil {
+		rows, srcs = externalRows(header.Data(), rows, srcs)
+	}
+
+	w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(res.RowCount()))
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"header": map[string]interface{}{
+			"labels":       header.Labels(),
+			"format":       header.Format(),
+			"data":         header.Data(),
+			"primary_kind": header.PrimaryKind(),
+		},
+		"rows":    rows,
+		"sources": srcs,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (wd *widgetDataEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/widget/{token}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the result of a signed widget query.",
+			"description": "The widget data endpoint runs the query of a widget token. It can be called from allowed origins without authentication.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "token",
+					"in":          "path",
+					"description": "Widget token.",
+					"required":    true,
+					"type":        "string",
+				},
+				{
+					"name":        "limit",
+					"in":          "query",
+					"description": "How many result rows to return.",
+					"required":    false,
+					"type":        "number",
+					"format":      "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Query result header, rows and row sources.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+}
+
+/*
+WidgetScriptEndpointInst creates a new endpoint handler.
+*/
+func WidgetScriptEndpointInst() api.RestEndpointHandler {
+	return &widgetScriptEndpoint{}
+}
+
+/*
+Handler object for widget script requests.
+*/
+type widgetScriptEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the widget script.
+*/
+func (ws *widgetScriptEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	setWidgetCORSHeaders(w, r)
+
+	if !checkWidgetsEnabled(w) {
+		return
+	}
+
+	w.Header().Set("content-type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	w.Write([]byte(widgetScript))
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ws *widgetScriptEndpoint) SwaggerDefs(s map[string]interface{}) {
+}
+
+/*
+widgetScript renders all elements with a data-eliasdb-widget attribute (which
+holds a widget token) as a table or as a graph (data-eliasdb-view="graph"). A
+graph shows the nodes of each result row connected in column order.
+*/
+const widgetScript = `(function () {
+  "use strict";
+
+  var script = document.currentScript;
+  var base = script ? new URL(script.src).origin : "";
+
+  function el(name, attrs, parent, ns) {
+    var e = ns ? document.createElementNS(ns, name) : document.createElement(name);
+    Object.keys(attrs || {}).forEach(function (k) { e.setAttribute(k, attrs[k]); });
+    if (parent) { parent.appendChild(e); }
+    return e;
+  }
+
+  function renderTable(target, res) {
+    var table = el("table", {"class": "eliasdb-widget-table"}, target);
+    var tr = el("tr", {}, el("thead", {}, table));
+    res.header.labels.forEach(function (l) { el("th", {}, tr).textContent = l; });
+    var tbody = el("tbody", {}, table);
+    res.rows.forEach(function (row) {
+      var tr = el("tr", {}, tbody);
+      row.forEach(function (v) {
+        el("td", {}, tr).textContent = v === null ? "" : typeof v === "object" ? JSON.stringify(v) : v;
+      });
+    });
+  }
+
+  function renderGraph(target, res) {
+    var ns = "http://www.w3.org/2000/svg", size = 400, nodes = {}, ids = [], links = [];
+    res.rows.forEach(function (row, i) {
+      var last = null;
+      res.sources[i].forEach(function (src, j) {
+        if (src.indexOf("n:") !== 0) { return; }
+        if (!(src in nodes)) { nodes[src] = {label: String(row[j])}; ids.push(src); }
+        if (last !== null && last !== src) { links.push([last, src]); }
+        last = src;
+      });
+    });
+    ids.forEach(function (id, i) {
+      var a = 2 * Math.PI * i / ids.length;
+      nodes[id].x = size / 2 + (size / 2 - 40) * Math.cos(a);
+      nodes[id].y = size / 2 + (size / 2 - 40) * Math.sin(a);
+    });
+    var svg = el("svg", {"class": "eliasdb-widget-graph", width: size, height: size,
+      viewBox: "0 0 " + size + " " + size}, target, ns);
+    links.forEach(function (l) {
+      el("line", {x1: nodes[l[0]].x, y1: nodes[l[0]].y, x2: nodes[l[1]].x, y2: nodes[l[1]].y,
+        stroke: "#999"}, svg, ns);
+    });
+    ids.forEach(function (id) {
+      var n = nodes[id];
+      el("circle", {cx: n.x, cy: n.y, r: 6, fill: "#369"}, svg, ns);
+      el("text", {x: n.x + 8, y: n.y + 4, "font-size": 12}, svg, ns).textContent = n.label;
+    });
+  }
+
+  function render(target) {
+    var token = target.getAttribute("data-eliasdb-widget");
+    var limit = target.getAttribute("data-eliasdb-limit");
+    var url = base + "` + EndpointWidgetData + `" + encodeURIComponent(token) + (limit ? "?limit=" + encodeURIComponent(limit) : "");
+    fetch(url).then(function (r) {
+      if (!r.ok) { return r.text().then(function (t) { throw new Error(t); }); }
+      return r.json();
+    }).then(function (res) {
+      target.textContent = "";
+      (target.getAttribute("data-eliasdb-view") === "graph" ? renderGraph : renderTable)(target, res);
+    }).catch(function (e) {
+      target.textContent = "Could not load widget: " + e.message;
+    });
+  }
+
+  function renderAll() {
+    Array.prototype.forEach.call(document.querySelectorAll("[data-eliasdb-widget]"), render);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", renderAll);
+  } else {
+    renderAll();
+  }
+})();
+`
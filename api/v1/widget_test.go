@@ -0,0 +1,203 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWidget(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointWidget
+	dataURL := "http://localhost" + TESTPORT + EndpointWidgetData
+	scriptURL := "http://localhost" + TESTPORT + EndpointWidgetScript
+
+	st, _, res := sendTestRequest(queryURL+"main", "POST", []byte(`{"query":"get Song"}`))
+
+	if st != "503 Service Unavailable" || res != "Widgets are not enabled" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	WidgetSecret = "test123"
+	defer func() {
+		WidgetSecret = ""
+		WidgetAllowedOrigins = []string{"*"}
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST",
+		[]byte(`{"query":"get Song where ranking > 6 and name beginswith 'Aria' show name"}`))
+
+	var ret map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil ||
+		ret["expires"] != float64(0) || ret["url"] != EndpointWidgetData+ret["token"].(string) {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	token := ret["token"].(string)
+
+	st, header, res := sendTestRequest(dataURL+token, "GET", nil)
+
+	if st != "200 OK" || header.Get("Access-Control-Allow-Origin") != "*" ||
+		header.Get(HTTPHeaderTotalCount) != "2" || !strings.Contains(res, `
+  "rows": [
+    [
+      "Aria1"
+    ],
+    [
+      "Aria4"
+    ]
+  ],`) {
+		t.Error("Unexpected response:", st, header, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(dataURL+token+"?limit=1", "GET", nil)
+
+	if st != "200 OK" || strings.Contains(res, "Aria4") || !strings.Contains(res, `"n:Song:Aria1"`) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Tokens cannot be changed
+
+	other := signWidgetToken("main", "get Author", 0)
+	tampered := other[:strings.Index(other, ".")] + token[strings.Index(token, "."):]
+
+	st, _, res = sendTestRequest(dataURL+tampered, "GET", nil)
+
+	if st != "403 Forbidden" || res != "Invalid widget token" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(dataURL+"foo", "GET", nil)
+
+	if st != "403 Forbidden" || res != "Invalid widget token" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(dataURL+signWidgetToken("main", "get Song", time.Now().Unix()-1), "GET", nil)
+
+	if st != "403 Forbidden" || res != "Widget token has expired" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(dataURL+signWidgetToken("main", "get Song where", 0), "GET", nil)
+
+	if st != "500 Internal Server Error" || !strings.Contains(res, "Unexpected end") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(dataURL, "GET", nil)
+
+	if st != "400 Bad Request" || res != "Need a widget token" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Tokens with an expiry
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`{"query":"get Song", "expires":60}`))
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil ||
+		ret["expires"].(float64) < float64(time.Now().Unix()+50) {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	if _, err := verifyWidgetToken(ret["token"].(string)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Errors when creating tokens
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`{"query":"get Song where"}`))
+
+	if st != "400 Bad Request" || !strings.Contains(res, "Unexpected end") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`{}`))
+
+	if st != "400 Bad Request" || res != "Missing query" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`{"query":"get Song", "expires":-1}`))
+
+	if st != "400 Bad Request" || res != "Invalid expiry: expires should be a positive number of seconds" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", []byte(`[]`))
+
+	if st != "400 Bad Request" || !strings.HasPrefix(res, "Could not decode request body as object") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte(`{"query":"get Song"}`))
+
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Widget script
+
+	st, header, res = sendTestRequest(scriptURL, "GET", nil)
+
+	if st != "200 OK" || header.Get("Content-Type") != "application/javascript; charset=utf-8" ||
+		!strings.Contains(res, `"`+EndpointWidgetData+`"`) {
+		t.Error("Unexpected response:", st, header)
+		return
+	}
+
+	// Only allowed origins can read widget data
+
+	WidgetAllowedOrigins = []string{"https://wiki.example.com"}
+
+	origin := func(url string, origin string) string {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Origin", origin)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err.Error()
+		}
+		resp.Body.Close()
+
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+
+	if res := origin(dataURL+token, "https://wiki.example.com"); res != "https://wiki.example.com" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := origin(scriptURL, "https://evil.example.com"); res != "" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
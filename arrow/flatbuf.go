@@ -0,0 +1,244 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package arrow
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+/*
+fbTable is a FlatBuffers table. The fields are indexed by their field ID - nil
+fields are absent. A field is either a scalar ([]byte with a size of 1, 2, 4 or
+8 bytes) or a reference to an object (*fbTable, fbString, fbTableVector or
+fbStructVector).
+*/
+type fbTable []interface{}
+
+/*
+fbString is a FlatBuffers string.
+*/
+type fbString string
+
+/*
+fbTableVector is a FlatBuffers vector of tables.
+*/
+type fbTableVector []*fbTable
+
+/*
+fbStructVector is a FlatBuffers vector of structs. All structs are 8 byte aligned.
+*/
+type fbStructVector struct {
+	count int    // Number of structs
+	data  []byte // Encoded structs
+}
+
+/*
+fbBool creates a bool scalar field.
+*/
+func fbBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+/*
+fbUint8 creates an ubyte scalar field.
+*/
+func fbUint8(v uint8) []byte {
+	return []byte{v}
+}
+
+/*
+fbInt16 creates a short scalar field.
+*/
+func fbInt16(v int16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+/*
+fbInt32 creates an int scalar field.
+*/
+func fbInt32(v int32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+/*
+fbInt64 creates a long scalar field.
+*/
+func fbInt64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+/*
+fbBuilder serializes a tree of FlatBuffers objects. Objects are written front
+to back - referenced objects are always written after the object which
+references them so all offsets are positive. Every value is aligned relative to
+the start of the buffer.
+*/
+type fbBuilder struct {
+	buf []byte
+}
+
+/*
+fbFinish serializes a given root table.
+*/
+func fbFinish(root *fbTable) []byte {
+	b := &fbBuilder{make([]byte, 4)}
+
+	b.putOffset(0, b.table(root))
+	b.pad(8, 0)
+
+	return b.buf
+}
+
+/*
+pad appends zero bytes until the buffer size modulo align equals rem.
+*/
+func (b *fbBuilder) pad(align int, rem int) {
+	for len(b.buf)%align != rem {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+/*
+putOffset writes an offset from a given position to a given target.
+*/
+func (b *fbBuilder) putOffset(pos int, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+/*
+table writes a table (and all objects it references) and returns its position.
+*/
+func (b *fbBuilder) table(t *fbTable) int {
+	var refs []int
+
+	// Order the fields by size so all fields are aligned - references are 4 bytes
+
+	fieldSize := func(i int) int {
+		if s, ok := (*t)[i].([]byte); ok {
+			return len(s)
+		}
+		return 4
+	}
+
+	var ids []int
+
+	for i, f := range *t {
+		if f != nil {
+			ids = append(ids, i)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return fieldSize(ids[i]) > fieldSize(ids[j])
+	})
+
+	offsets := make([]int, len(*t))
+	size := 4
+
+	for _, id := range ids {
+		offsets[id] = size
+		size += fieldSize(id)
+	}
+
+	// Write the vtable
+
+	b.pad(2, 0)
+
+	vtable := len(b.buf)
+
+	b.buf = append(b.buf, make([]byte, 4+2*len(*t))...)
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(4+2*len(*t)))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(size))
+
+	for i, o := range offsets {
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*i:], uint16(o))
+	}
+
+	// Write the table - 8 byte fields start directly after the vtable offset
+
+	if len(ids) > 0 && fieldSize(ids[0]) == 8 {
+		b.pad(8, 4)
+	} else {
+		b.pad(4, 0)
+	}
+
+	pos := len(b.buf)
+
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(pos-vtable))
+
+	for _, id := range ids {
+		if s, ok := (*t)[id].([]byte); ok {
+			copy(b.buf[pos+offsets[id]:], s)
+		} else {
+			refs = append(refs, id)
+		}
+	}
+
+	// Write all referenced objects
+
+	for _, id := range refs {
+		b.putOffset(pos+offsets[id], b.object((*t)[id]))
+	}
+
+	return pos
+}
+
+/*
+object writes a referenced object and returns its position.
+*/
+func (b *fbBuilder) object(o interface{}) int {
+	var pos int
+
+	switch v := o.(type) {
+
+	case *fbTable:
+		pos = b.table(v)
+
+	case fbString:
+		b.pad(4, 0)
+		pos = len(b.buf)
+		b.buf = append(b.buf, fbInt32(int32(len(v)))...)
+		b.buf = append(b.buf, v...)
+		b.buf = append(b.buf, 0)
+
+	case fbTableVector:
+		b.pad(4, 0)
+		pos = len(b.buf)
+		b.buf = append(b.buf, fbInt32(int32(len(v)))...)
+		b.buf = append(b.buf, make([]byte, 4*len(v))...)
+
+		for i, t := range v {
+			b.putOffset(pos+4+4*i, b.table(t))
+		}
+
+	case fbStructVector:
+		b.pad(8, 4)
+		pos = len(b.buf)
+		b.buf = append(b.buf, fbInt32(int32(v.count))...)
+		b.buf = append(b.buf, v.data...)
+
+	default:
+		panic("Unknown FlatBuffers object")
+	}
+
+	return pos
+}
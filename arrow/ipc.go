@@ -0,0 +1,394 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package arrow contains a writer for the Apache Arrow IPC streaming format.
+
+The writer produces a stream with a schema message, record batch messages and
+an end-of-stream marker (format version V5, little endian, no compression and
+no dictionaries). The messages are FlatBuffers encoded as described in the
+Arrow columnar format specification. Streams can be read by any Arrow
+implementation (e.g. pyarrow.ipc.open_stream).
+
+Supported column types are utf8, binary, int64, float64, bool and timestamp
+(microseconds, UTC). All columns are nullable.
+*/
+package arrow
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+/*
+ContentType is the media type of Arrow IPC streams.
+*/
+const ContentType = "application/vnd.apache.arrow.stream"
+
+/*
+Column types of an Arrow stream
+*/
+const (
+	TypeUtf8      = "utf8"
+	TypeBinary    = "binary"
+	TypeInt64     = "int64"
+	TypeFloat64   = "float64"
+	TypeBool      = "bool"
+	TypeTimestamp = "timestamp" // Microseconds since the epoch in UTC
+)
+
+/*
+Arrow format constants
+*/
+const (
+	metadataVersionV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeInt           = 2
+	typeFloatingPoint = 3
+	typeBinary        = 4
+	typeUtf8          = 5
+	typeBool          = 6
+	typeTimestamp     = 10
+
+	precisionDouble  = 2
+	timeUnitMicros   = 2
+	continuationMark = 0xFFFFFFFF
+)
+
+/*
+Field is a column of an Arrow stream.
+*/
+type Field struct {
+	Name string // Name of the column
+	Type string // Type of the column
+}
+
+/*
+InferType returns the column type which can represent a given list of values.
+Integer columns with floating point values are float64 columns. Columns with
+values of different types and columns without any values are utf8 columns.
+*/
+func InferType(values []interface{}) string {
+	var ret string
+
+	for _, v := range values {
+		var t string
+
+		if v == nil {
+			continue
+		}
+
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint8, uint16, uint32:
+			t = TypeInt64
+		case float32, float64:
+			t = TypeFloat64
+		case bool:
+			t = TypeBool
+		case time.Time:
+			t = TypeTimestamp
+		case []byte:
+			t = TypeBinary
+		default:
+			return TypeUtf8
+		}
+
+		if ret == "" || ret == t {
+			ret = t
+		} else if (ret == TypeInt64 || ret == TypeFloat64) && (t == TypeInt64 || t == TypeFloat64) {
+			ret = TypeFloat64
+		} else {
+			return TypeUtf8
+		}
+	}
+
+	if ret == "" {
+		ret = TypeUtf8
+	}
+
+	return ret
+}
+
+/*
+InferFields returns the fields for given column names based on the values of
+given rows.
+*/
+func InferFields(names []string, rows [][]interface{}) []Field {
+	fields := make([]Field, len(names))
+	values := make([]interface{}, len(rows))
+
+	for i, name := range names {
+		for j, row := range rows {
+			values[j] = row[i]
+		}
+
+		fields[i] = Field{name, InferType(values)}
+	}
+
+	return fields
+}
+
+/*
+StreamWriter writes rows as Arrow IPC stream.
+*/
+type StreamWriter struct {
+	out    io.Writer // Output of the stream
+	fields []Field   // Columns of the stream
+}
+
+/*
+NewStreamWriter creates a new StreamWriter and writes the schema of the stream.
+*/
+func NewStreamWriter(out io.Writer, fields []Field) (*StreamWriter, error) {
+	sw := &StreamWriter{out, fields}
+
+	fbFields := make(fbTableVector, len(fields))
+
+	for i, f := range fields {
+		var typeID uint8
+		var typeTable *fbTable
+
+		switch f.Type {
+		case TypeUtf8:
+			typeID, typeTable = typeUtf8, &fbTable{}
+		case TypeBinary:
+			typeID, typeTable = typeBinary, &fbTable{}
+		case TypeInt64:
+			typeID, typeTable = typeInt, &fbTable{fbInt32(64), fbBool(true)}
+		case TypeFloat64:
+			typeID, typeTable = typeFloatingPoint, &fbTable{fbInt16(precisionDouble)}
+		case TypeBool:
+			typeID, typeTable = typeBool, &fbTable{}
+		case TypeTimestamp:
+			typeID, typeTable = typeTimestamp, &fbTable{fbInt16(timeUnitMicros), fbString("UTC")}
+		default:
+			return nil, fmt.Errorf("Unknown column type %v for column %v", f.Type, f.Name)
+		}
+
+		// Field table: name, nullable, type type, type, dictionary, children
+
+		fbFields[i] = &fbTable{fbString(f.Name), fbBool(true), fbUint8(typeID), typeTable,
+			nil, fbTableVector{}}
+	}
+
+	// Schema table: endianness (little), fields
+
+	return sw, sw.writeMessage(headerSchema, &fbTable{fbInt16(0), fbFields}, nil)
+}
+
+/*
+WriteBatch writes a record batch with given rows. Values which cannot be
+represented in the type of their column are written as null.
+*/
+func (sw *StreamWriter) WriteBatch(rows [][]interface{}) error {
+	var body []byte
+	var nodes, buffers []byte
+
+	addBuffer := func(buf []byte) {
+		buffers = append(buffers, fbInt64(int64(len(body)))...)
+		buffers = append(buffers, fbInt64(int64(len(buf)))...)
+
+		body = append(body, buf...)
+
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	for i, f := range sw.fields {
+		var values []byte
+		var offsets []byte
+
+		validity := make([]byte, (len(rows)+7)/8)
+		nullCount := 0
+
+		if f.Type == TypeUtf8 || f.Type == TypeBinary {
+			offsets = fbInt32(0)
+		} else if f.Type == TypeBool {
+			values = make([]byte, (len(rows)+7)/8)
+		}
+
+		for j, row := range rows {
+			var v interface{}
+
+			if i < len(row) {
+				v = ConvertValue(f.Type, row[i])
+			}
+
+			if v == nil {
+				nullCount++
+			} else {
+				validity[j/8] |= 1 << uint(j%8)
+			}
+
+			switch f.Type {
+			case TypeUtf8, TypeBinary:
+				if b, ok := v.([]byte); ok {
+					values = append(values, b...)
+				}
+				offsets = append(offsets, fbInt32(int32(len(values)))...)
+			case TypeInt64, TypeTimestamp:
+				n, _ := v.(int64)
+				values = append(values, fbInt64(n)...)
+			case TypeFloat64:
+				n, _ := v.(float64)
+				values = append(values, fbInt64(int64(math.Float64bits(n)))...)
+			case TypeBool:
+				if b, _ := v.(bool); b {
+					values[j/8] |= 1 << uint(j%8)
+				}
+			}
+		}
+
+		nodes = append(nodes, fbInt64(int64(len(rows)))...)
+		nodes = append(nodes, fbInt64(int64(nullCount))...)
+
+		// The validity buffer can be omitted if there are no nulls
+
+		if nullCount == 0 {
+			validity = nil
+		}
+
+		addBuffer(validity)
+
+		if offsets != nil {
+			addBuffer(offsets)
+		}
+
+		addBuffer(values)
+	}
+
+	// RecordBatch table: length, nodes, buffers
+
+	return sw.writeMessage(headerRecordBatch, &fbTable{fbInt64(int64(len(rows))),
+		fbStructVector{len(sw.fields), nodes}, fbStructVector{len(buffers) / 16, buffers}}, body)
+}
+
+/*
+Close writes the end-of-stream marker.
+*/
+func (sw *StreamWriter) Close() error {
+	marker := make([]byte, 8)
+	binary.LittleEndian.PutUint32(marker, continuationMark)
+
+	_, err := sw.out.Write(marker)
+
+	return err
+}
+
+/*
+writeMessage writes an encapsulated message with a given header and body.
+*/
+func (sw *StreamWriter) writeMessage(headerType uint8, header *fbTable, body []byte) error {
+
+	// Message table: version, header type, header, body length
+
+	meta := fbFinish(&fbTable{fbInt16(metadataVersionV5), fbUint8(headerType), header,
+		fbInt64(int64(len(body)))})
+
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, continuationMark)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+
+	_, err := sw.out.Write(append(append(prefix, meta...), body...))
+
+	return err
+}
+
+/*
+ConvertValue converts a value into the representation of a given column type
+(utf8 and binary values are byte slices, timestamps are microseconds since the
+epoch). Returns nil if the value cannot be represented.
+*/
+func ConvertValue(t string, v interface{}) interface{} {
+
+	if v == nil {
+		return nil
+	}
+
+	switch t {
+
+	case TypeUtf8:
+		switch s := v.(type) {
+		case string:
+			return []byte(s)
+		case []byte:
+			return s
+		case time.Time:
+			return []byte(s.UTC().Format(time.RFC3339Nano))
+		case fmt.Stringer:
+			return []byte(s.String())
+		case map[string]interface{}, []interface{}:
+			if b, err := json.Marshal(s); err == nil {
+				return b
+			}
+		}
+		return []byte(fmt.Sprint(v))
+
+	case TypeBinary:
+		if b, ok := v.([]byte); ok {
+			return b
+		}
+
+	case TypeInt64:
+		switch n := v.(type) {
+		case int:
+			return int64(n)
+		case int8:
+			return int64(n)
+		case int16:
+			return int64(n)
+		case int32:
+			return int64(n)
+		case int64:
+			return n
+		case uint8:
+			return int64(n)
+		case uint16:
+			return int64(n)
+		case uint32:
+			return int64(n)
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n)
+			}
+		}
+
+	case TypeFloat64:
+		switch n := v.(type) {
+		case float32:
+			return float64(n)
+		case float64:
+			return n
+		}
+		if n, ok := ConvertValue(TypeInt64, v).(int64); ok {
+			return float64(n)
+		}
+
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b
+		}
+
+	case TypeTimestamp:
+		if tv, ok := v.(time.Time); ok {
+			return tv.Unix()*1000000 + int64(tv.Nanosecond()/1000)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	apache "github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+)
+
+/*
+testTable reads a FlatBuffers table and checks the alignment of all values.
+*/
+type testTable struct {
+	buf []byte
+	pos int
+}
+
+func (t testTable) field(id int, size int) int {
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+
+	if vt%2 != 0 || t.pos%4 != 0 {
+		panic(fmt.Sprint("Unaligned table: ", t.pos, vt))
+	} else if vtsize := int(binary.LittleEndian.Uint16(t.buf[vt:])); 4+2*id >= vtsize {
+		return -1
+	}
+
+	o := int(binary.LittleEndian.Uint16(t.buf[vt+4+2*id:]))
+	if o == 0 {
+		return -1
+	} else if (t.pos+o)%size != 0 {
+		panic(fmt.Sprint("Unaligned field: ", id, t.pos+o, size))
+	}
+
+	return t.pos + o
+}
+
+func (t testTable) int(id int, size int) int64 {
+	p := t.field(id, size)
+	if p == -1 {
+		return 0
+	}
+
+	switch size {
+	case 1:
+		return int64(t.buf[p])
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(t.buf[p:])))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(t.buf[p:])))
+	}
+
+	return int64(binary.LittleEndian.Uint64(t.buf[p:]))
+}
+
+func (t testTable) ref(id int) int {
+	p := t.field(id, 4)
+	if p == -1 {
+		return -1
+	}
+
+	return p + int(binary.LittleEndian.Uint32(t.buf[p:]))
+}
+
+func (t testTable) table(id int) testTable {
+	return testTable{t.buf, t.ref(id)}
+}
+
+func (t testTable) string(id int) string {
+	p := t.ref(id)
+	l := int(binary.LittleEndian.Uint32(t.buf[p:]))
+
+	if t.buf[p+4+l] != 0 {
+		panic("String is not null terminated")
+	}
+
+	return string(t.buf[p+4 : p+4+l])
+}
+
+func (t testTable) tables(id int) []testTable {
+	var ret []testTable
+
+	p := t.ref(id)
+	for i := 0; i < int(binary.LittleEndian.Uint32(t.buf[p:])); i++ {
+		e := p + 4 + 4*i
+		ret = append(ret, testTable{t.buf, e + int(binary.LittleEndian.Uint32(t.buf[e:]))})
+	}
+
+	return ret
+}
+
+func (t testTable) structs(id int) [][2]int64 {
+	var ret [][2]int64
+
+	p := t.ref(id)
+	if (p+4)%8 != 0 {
+		panic("Unaligned struct vector")
+	}
+
+	for i := 0; i < int(binary.LittleEndian.Uint32(t.buf[p:])); i++ {
+		e := p + 4 + 16*i
+		ret = append(ret, [2]int64{int64(binary.LittleEndian.Uint64(t.buf[e:])),
+			int64(binary.LittleEndian.Uint64(t.buf[e+8:]))})
+	}
+
+	return ret
+}
+
+/*
+readTestStream decodes an Arrow IPC stream which was written by a StreamWriter.
+*/
+func readTestStream(stream []byte) ([]string, [][]interface{}, error) {
+	var names, types []string
+	var rows [][]interface{}
+
+	for pos := 0; ; {
+		if binary.LittleEndian.Uint32(stream[pos:]) != continuationMark {
+			return nil, nil, fmt.Errorf("Missing continuation marker at %v", pos)
+		}
+
+		metaLen := int(binary.LittleEndian.Uint32(stream[pos+4:]))
+		if metaLen == 0 {
+			if pos+8 != len(stream) {
+				return nil, nil, fmt.Errorf("Data after end of stream")
+			}
+			break
+		} else if metaLen%8 != 0 {
+			return nil, nil, fmt.Errorf("Unpadded metadata: %v", metaLen)
+		}
+
+		meta := stream[pos+8 : pos+8+metaLen]
+		msg := testTable{meta, int(binary.LittleEndian.Uint32(meta))}
+		body := stream[pos+8+metaLen : pos+8+metaLen+int(msg.int(3, 8))]
+
+		pos += 8 + metaLen + len(body)
+
+		if msg.int(0, 2) != metadataVersionV5 {
+			return nil, nil, fmt.Errorf("Unexpected version: %v", msg.int(0, 2))
+		}
+
+		header := msg.table(2)
+
+		switch msg.int(1, 1) {
+
+		case headerSchema:
+			for _, f := range header.tables(1) {
+				t := f.table(3)
+				ft := fmt.Sprint(f.int(2, 1))
+
+				switch f.int(2, 1) {
+				case typeInt:
+					ft = fmt.Sprintf("int%v%v", t.int(0, 4), t.int(1, 1))
+				case typeFloatingPoint:
+					ft = fmt.Sprint("float", t.int(0, 2))
+				case typeTimestamp:
+					ft = fmt.Sprint("timestamp", t.int(0, 2), t.string(1))
+				}
+
+				if f.int(1, 1) != 1 || len(f.tables(5)) != 0 {
+					return nil, nil, fmt.Errorf("Unexpected field: %v", f.string(0))
+				}
+
+				names = append(names, f.string(0))
+				types = append(types, ft)
+			}
+
+		case headerRecordBatch:
+			length := int(header.int(0, 8))
+			nodes := header.structs(1)
+			buffers := header.structs(2)
+
+			batch := make([][]interface{}, length)
+			for i := range batch {
+				batch[i] = make([]interface{}, len(types))
+			}
+
+			buffer := func() []byte {
+				b := buffers[0]
+				buffers = buffers[1:]
+				if b[0]%8 != 0 {
+					panic("Unaligned buffer")
+				}
+				return body[b[0] : b[0]+b[1]]
+			}
+
+			for i, t := range types {
+				validity := buffer()
+
+				if nodes[i][0] != int64(length) || (nodes[i][1] == 0) != (len(validity) == 0) {
+					return nil, nil, fmt.Errorf("Unexpected field node: %v", nodes[i])
+				}
+
+				var offsets []byte
+				if t == "5" || t == "4" {
+					offsets = buffer()
+				}
+				values := buffer()
+
+				for j := 0; j < length; j++ {
+					if len(validity) > 0 && validity[j/8]&(1<<uint(j%8)) == 0 {
+						continue
+					}
+
+					var v interface{}
+
+					switch t {
+					case "5":
+						v = string(values[binary.LittleEndian.Uint32(offsets[4*j:]):binary.LittleEndian.Uint32(offsets[4*j+4:])])
+					case "4":
+						v = values[binary.LittleEndian.Uint32(offsets[4*j:]):binary.LittleEndian.Uint32(offsets[4*j+4:])]
+					case "int641":
+						v = int64(binary.LittleEndian.Uint64(values[8*j:]))
+					case "float2":
+						v = math.Float64frombits(binary.LittleEndian.Uint64(values[8*j:]))
+					case "6":
+						v = values[j/8]&(1<<uint(j%8)) != 0
+					case "timestamp2UTC":
+						v = time.Unix(0, int64(binary.LittleEndian.Uint64(values[8*j:]))*1000).UTC()
+					}
+
+					batch[j][i] = v
+				}
+			}
+
+			if len(buffers) != 0 {
+				return nil, nil, fmt.Errorf("Unexpected buffers: %v", buffers)
+			}
+
+			rows = append(rows, batch...)
+		}
+	}
+
+	for i, t := range types {
+		names[i] += " " + t
+	}
+
+	return names, rows, nil
+}
+
+func TestInferType(t *testing.T) {
+
+	for _, test := range []struct {
+		values []interface{}
+		res    string
+	}{
+		{[]interface{}{}, TypeUtf8},
+		{[]interface{}{nil, nil}, TypeUtf8},
+		{[]interface{}{1, nil, int64(2)}, TypeInt64},
+		{[]interface{}{1, 1.5}, TypeFloat64},
+		{[]interface{}{1.5, 1}, TypeFloat64},
+		{[]interface{}{true, false}, TypeBool},
+		{[]interface{}{time.Now(), nil}, TypeTimestamp},
+		{[]interface{}{[]byte("a")}, TypeBinary},
+		{[]interface{}{1, true}, TypeUtf8},
+		{[]interface{}{1, "a"}, TypeUtf8},
+		{[]interface{}{map[string]interface{}{}}, TypeUtf8},
+	} {
+		if res := InferType(test.values); res != test.res {
+			t.Error("Unexpected result:", test.values, res)
+			return
+		}
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 8000, time.UTC)
+
+	rows := [][]interface{}{
+		{"123", 1, 1.5, true, ts, []byte{1, 2}, big.NewRat(1, 2)},
+		{nil, int64(-5), 2, false, nil, nil, map[string]interface{}{"a": 1}},
+		{"ü", int32(3), nil, nil, ts.Add(time.Second), []byte{}, 5},
+	}
+
+	fields := InferFields([]string{"key", "int", "float", "bool", "time", "bin", "str"}, rows)
+
+	if res := fmt.Sprint(fields); res != "[{key utf8} {int int64} {float float64} {bool bool} "+
+		"{time timestamp} {bin binary} {str utf8}]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := NewStreamWriter(&buf, []Field{{"foo", "bar"}}); err == nil ||
+		err.Error() != "Unknown column type bar for column foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sw, err := NewStreamWriter(&buf, fields)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sw.WriteBatch(rows[:2]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Values which do not fit the column type are null
+
+	if err := sw.WriteBatch([][]interface{}{rows[2], {1, 4.0, "x", 1, "y", "z"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sw.WriteBatch(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sw.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	names, res, err := readTestStream(buf.Bytes())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(names); res != "[key 5 int int641 float float2 bool 6 time timestamp2UTC bin 4 str 5]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(res); res != "[[123 1 1.5 true 2021-03-04 05:06:07.000008 +0000 UTC [1 2] 1/2] "+
+		"[<nil> -5 2 false <nil> <nil> {\"a\":1}] "+
+		"[ü 3 <nil> <nil> 2021-03-04 05:06:08.000008 +0000 UTC [] 5] "+
+		"[1 4 <nil> <nil> <nil> <nil> <nil>]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestStreamReferenceReader(t *testing.T) {
+	var buf bytes.Buffer
+
+	// Streams must be readable by the reference implementation of Arrow
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 8000, time.UTC)
+
+	rows := [][]interface{}{
+		{"123", 1, 1.5, true, ts, []byte{1, 2}},
+		{nil, int64(-5), 2, false, nil, nil},
+		{"ü", int32(3), nil, nil, ts.Add(time.Second), []byte{}},
+	}
+
+	sw, err := NewStreamWriter(&buf, InferFields([]string{"key", "int", "float", "bool", "time", "bin"}, rows))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sw.WriteBatch(rows[:2])
+	sw.WriteBatch(nil)
+	sw.WriteBatch(rows[2:])
+
+	if err := sw.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, err := ipc.NewReader(&buf, ipc.WithAllocator(memory.NewGoAllocator()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Release()
+
+	if res := r.Schema().String(); res != `schema:
+  fields: 6
+    - key: type=utf8, nullable
+    - int: type=int64, nullable
+    - float: type=float64, nullable
+    - bool: type=bool, nullable
+    - time: type=timestamp[us, tz=UTC], nullable
+    - bin: type=binary, nullable` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var res []string
+
+	for r.Next() {
+		rec := r.Record()
+
+		for i := 0; i < int(rec.NumRows()); i++ {
+			var row []string
+
+			for _, col := range rec.Columns() {
+				if col.IsNull(i) {
+					row = append(row, "null")
+				} else if ts, ok := col.(interface {
+					Value(int) apache.Timestamp
+				}); ok {
+					row = append(row, ts.Value(i).ToTime(apache.Microsecond).UTC().String())
+				} else {
+					row = append(row, col.ValueStr(i))
+				}
+			}
+
+			res = append(res, fmt.Sprint(row))
+		}
+	}
+
+	if err := r.Err(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(res); res != "[[123 1 1.5 true 2021-03-04 05:06:07.000008 +0000 UTC AQI=] "+
+		"[null -5 2 false null null] "+
+		"[ü 3 null null 2021-03-04 05:06:08.000008 +0000 UTC ]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
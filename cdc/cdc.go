@@ -0,0 +1,186 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package cdc contains a change data capture (CDC) publisher which streams the
+changes of a change log to Kafka or NATS.
+
+A sink publishes the changes of selected partitions to a topic per partition.
+Each change is published as a JSON message (the key of a Kafka message is the
+partition, kind and key of the changed node or edge). Changes are published in
+order and failed publications are retried until they succeed (at-least-once
+delivery). After each publication the sink stores a checkpoint with the ID of
+the change log and the sequence number of the last published change.
+
+The change log is held in memory. If the checkpoint of a sink does not belong
+to the current change log (e.g. after a restart) or the sink fell behind
+further than the change log reaches, the sink first publishes the current state
+of all its partitions as a snapshot (node.store and edge.store changes with the
+sequence number 0). Deletions which happened before a restart and were not yet
+published are not contained in such a snapshot.
+
+The Kafka publisher uses the Sarama client (Kafka 0.11 or later) and waits for
+the acks of all in-sync replicas. It publishes to a single partition of each
+topic. The NATS publisher uses the NATS Go client and confirms each
+publication with a flush. Both publishers use plain TCP connections.
+*/
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+/*
+Sink types
+*/
+const (
+	TypeKafka = "kafka"
+	TypeNATS  = "nats"
+)
+
+/*
+clientID is the name of the publishers which is sent to the message brokers.
+*/
+const clientID = "eliasdb"
+
+/*
+AllPartitions is the partition of a topic which receives the changes of all
+partitions which have no topic of their own.
+*/
+const AllPartitions = "*"
+
+/*
+sinkNameRegexp is the pattern of valid sink names.
+*/
+var sinkNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+/*
+Message is a message which is published to a topic.
+*/
+type Message struct {
+	Key   []byte // Key of the message
+	Value []byte // Value of the message
+}
+
+/*
+Publisher publishes messages to a message broker.
+*/
+type Publisher interface {
+
+	/*
+		Publish publishes messages to a topic. The messages are published in
+		order. Returns once the broker has confirmed all messages.
+	*/
+	Publish(topic string, msgs []*Message) error
+
+	/*
+		Close closes all connections of the publisher.
+	*/
+	Close() error
+}
+
+/*
+SinkConfig is the configuration of a sink.
+*/
+type SinkConfig struct {
+	Name           string            `json:"name"`                      // Name of the sink
+	Type           string            `json:"type"`                      // Type of the sink (kafka or nats)
+	Brokers        []string          `json:"brokers,omitempty"`         // Kafka brokers (host:port)
+	KafkaPartition int32             `json:"kafka_partition,omitempty"` // Kafka partition of the topics
+	URL            string            `json:"url,omitempty"`             // NATS server (nats://[user:password@]host:port)
+	Topics         map[string]string `json:"topics"`                    // Topics by partition (* for all other partitions)
+}
+
+/*
+Validate checks a sink configuration.
+*/
+func (sc *SinkConfig) Validate() error {
+
+	if !sinkNameRegexp.MatchString(sc.Name) {
+		return fmt.Errorf("Invalid sink name (allowed are letters, digits, - and _): %v", sc.Name)
+	}
+
+	if sc.Type == TypeKafka && len(sc.Brokers) == 0 {
+		return fmt.Errorf("Kafka sink %v needs at least one broker", sc.Name)
+	} else if sc.Type == TypeNATS && sc.URL == "" {
+		return fmt.Errorf("NATS sink %v needs a server URL", sc.Name)
+	} else if sc.Type != TypeKafka && sc.Type != TypeNATS {
+		return fmt.Errorf("Unknown type of sink %v: %v", sc.Name, sc.Type)
+	}
+
+	if len(sc.Topics) == 0 {
+		return fmt.Errorf("Sink %v needs at least one topic", sc.Name)
+	}
+
+	for part, topic := range sc.Topics {
+		if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+			return fmt.Errorf("Invalid topic of partition %v in sink %v: %v", part, sc.Name, topic)
+		}
+	}
+
+	return nil
+}
+
+/*
+Topic returns the topic of a partition. Returns an empty string if the changes
+of the partition are not published.
+*/
+func (sc *SinkConfig) Topic(part string) string {
+
+	if topic, ok := sc.Topics[part]; ok {
+		return topic
+	}
+
+	return sc.Topics[AllPartitions]
+}
+
+/*
+NewPublisher creates the publisher of a sink.
+*/
+func (sc *SinkConfig) NewPublisher() (Publisher, error) {
+
+	if sc.Type == TypeKafka {
+		return NewKafkaPublisher(sc.Brokers, sc.KafkaPartition), nil
+	}
+
+	return NewNATSPublisher(sc.URL)
+}
+
+/*
+LoadConfig loads and validates a list of sink configurations from a JSON file.
+*/
+func LoadConfig(file string) ([]*SinkConfig, error) {
+	var sinks []*SinkConfig
+
+	content, err := ioutil.ReadFile(file)
+
+	if err == nil {
+		err = json.Unmarshal(content, &sinks)
+	}
+
+	names := make(map[string]bool)
+
+	for i := 0; err == nil && i < len(sinks); i++ {
+		if err = sinks[i].Validate(); err == nil && names[sinks[i].Name] {
+			err = fmt.Errorf("Duplicate sink name: %v", sinks[i].Name)
+		}
+		names[sinks[i].Name] = true
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sinks, nil
+}
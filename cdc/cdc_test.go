@@ -0,0 +1,101 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSinkConfig(t *testing.T) {
+
+	for _, test := range []struct {
+		config *SinkConfig
+		msg    string
+	}{
+		{&SinkConfig{Name: "a b"},
+			"Invalid sink name (allowed are letters, digits, - and _): a b"},
+		{&SinkConfig{Name: "s", Type: "foo"},
+			"Unknown type of sink s: foo"},
+		{&SinkConfig{Name: "s", Type: TypeKafka},
+			"Kafka sink s needs at least one broker"},
+		{&SinkConfig{Name: "s", Type: TypeNATS},
+			"NATS sink s needs a server URL"},
+		{&SinkConfig{Name: "s", Type: TypeNATS, URL: "nats://localhost"},
+			"Sink s needs at least one topic"},
+		{&SinkConfig{Name: "s", Type: TypeNATS, URL: "nats://localhost", Topics: map[string]string{"main": "a b"}},
+			"Invalid topic of partition main in sink s: a b"},
+	} {
+		if err := test.config.Validate(); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	sc := &SinkConfig{Name: "s", Type: TypeNATS, URL: "nats://localhost",
+		Topics: map[string]string{"main": "changes.main", AllPartitions: "changes"}}
+
+	if res := fmt.Sprint(sc.Topic("main"), " ", sc.Topic("other")); res != "changes.main changes" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	delete(sc.Topics, AllPartitions)
+
+	if res := sc.Topic("other"); res != "" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := (&SinkConfig{Type: TypeNATS, URL: "http://localhost"}).NewPublisher(); err == nil ||
+		err.Error() != "Invalid NATS server URL: http://localhost" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Load a configuration file
+
+	file, err := ioutil.TempFile("", "cdc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`[
+  {"name": "kafka", "type": "kafka", "brokers": ["localhost:9092"], "topics": {"main": "eliasdb.main"}},
+  {"name": "kafka", "type": "nats", "url": "nats://localhost", "topics": {"*": "eliasdb"}}
+]`)
+	file.Close()
+
+	if _, err := LoadConfig(file.Name()); err == nil || err.Error() != "Duplicate sink name: kafka" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(file.Name(), []byte(`[
+  {"name": "kafka", "type": "kafka", "brokers": ["localhost:9092"], "kafka_partition": 2, "topics": {"main": "eliasdb.main"}}
+]`), 0600)
+
+	sinks, err := LoadConfig(file.Name())
+
+	if err != nil || len(sinks) != 1 || sinks[0].KafkaPartition != 2 {
+		t.Error("Unexpected result:", sinks, err)
+		return
+	}
+
+	if pub, err := sinks[0].NewPublisher(); err != nil || pub.(*KafkaPublisher).partition != 2 {
+		t.Error("Unexpected result:", pub, err)
+		return
+	}
+}
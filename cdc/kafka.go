@@ -0,0 +1,133 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+/*
+PublishTimeout is the timeout of a single request to a message broker.
+*/
+var PublishTimeout = 10 * time.Second
+
+/*
+newKafkaProducer creates a new synchronous Kafka producer.
+*/
+var newKafkaProducer = sarama.NewSyncProducer
+
+/*
+KafkaPublisher publishes messages to a partition of Kafka topics.
+*/
+type KafkaPublisher struct {
+	brokers   []string            // Bootstrap brokers (host:port)
+	partition int32               // Partition of the topics
+	producer  sarama.SyncProducer // Producer (nil if not connected)
+	lock      *sync.Mutex         // Lock for the producer
+}
+
+/*
+NewKafkaPublisher creates a new Kafka publisher.
+*/
+func NewKafkaPublisher(brokers []string, partition int32) *KafkaPublisher {
+	return &KafkaPublisher{brokers, partition, nil, &sync.Mutex{}}
+}
+
+/*
+Publish publishes messages to a topic. Returns once all in-sync replicas have
+confirmed the messages.
+*/
+func (kp *KafkaPublisher) Publish(topic string, msgs []*Message) error {
+	var err error
+
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	if kp.producer == nil {
+		kp.producer, err = newKafkaProducer(kp.brokers, kp.config())
+	}
+
+	if err == nil {
+		pmsgs := make([]*sarama.ProducerMessage, len(msgs))
+
+		for i, m := range msgs {
+			pmsgs[i] = &sarama.ProducerMessage{
+				Topic:     topic,
+				Partition: kp.partition,
+				Value:     sarama.ByteEncoder(m.Value),
+			}
+
+			if m.Key != nil {
+				pmsgs[i].Key = sarama.ByteEncoder(m.Key)
+			}
+		}
+
+		if err = kp.producer.SendMessages(pmsgs); err != nil {
+
+			// Report the first error of the broker
+
+			if perrs, ok := err.(sarama.ProducerErrors); ok && len(perrs) > 0 {
+				err = perrs[0].Err
+			}
+
+			// Reconnect with the next request
+
+			kp.producer.Close()
+			kp.producer = nil
+		}
+	}
+
+	return err
+}
+
+/*
+Close closes all connections of the publisher.
+*/
+func (kp *KafkaPublisher) Close() error {
+	var err error
+
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	if kp.producer != nil {
+		err = kp.producer.Close()
+		kp.producer = nil
+	}
+
+	return err
+}
+
+/*
+config returns the configuration of the producer. Messages are sent to a fixed
+partition and only one request is sent at a time so retries keep the order of
+the messages.
+*/
+func (kp *KafkaPublisher) config() *sarama.Config {
+
+	config := sarama.NewConfig()
+	config.ClientID = clientID
+	config.Version = sarama.V0_11_0_0
+
+	config.Net.MaxOpenRequests = 1
+	config.Net.DialTimeout = PublishTimeout
+	config.Net.ReadTimeout = PublishTimeout
+	config.Net.WriteTimeout = PublishTimeout
+
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Timeout = PublishTimeout
+	config.Producer.Partitioner = sarama.NewManualPartitioner
+	config.Producer.Return.Successes = true
+
+	return config
+}
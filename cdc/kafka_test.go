@@ -0,0 +1,148 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+func TestKafkaPublisher(t *testing.T) {
+
+	// The mock broker of Sarama speaks the Kafka protocol
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetLeader("changes", 0, broker.BrokerID())
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"ProduceRequest":  sarama.NewMockProduceResponse(t),
+	})
+
+	kp := NewKafkaPublisher([]string{broker.Addr()}, 0)
+	defer kp.Close()
+
+	if err := kp.Publish("changes", []*Message{{[]byte("k1"), []byte("v1")}, {[]byte("k2"), []byte("v2")}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var produced int
+
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.ProduceRequest); ok && req.RequiredAcks == sarama.WaitForAll {
+			produced++
+		}
+	}
+
+	if produced == 0 {
+		t.Error("Unexpected number of produce requests:", produced)
+		return
+	}
+
+	// Errors of the broker are returned
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError("changes", 0, sarama.ErrTopicAuthorizationFailed),
+	})
+
+	if err := kp.Publish("changes", []*Message{{[]byte("k3"), []byte("v3")}}); err != sarama.ErrTopicAuthorizationFailed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The publisher reconnects after an error
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"ProduceRequest":  sarama.NewMockProduceResponse(t),
+	})
+
+	if err := kp.Publish("changes", []*Message{{nil, []byte("v3")}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Unreachable brokers are reported
+
+	kp = NewKafkaPublisher([]string{"127.0.0.1:1"}, 0)
+
+	if err := kp.Publish("changes", []*Message{{nil, []byte("v")}}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestKafkaPublisherMessages(t *testing.T) {
+	var received []string
+
+	oldNewKafkaProducer := newKafkaProducer
+	defer func() {
+		newKafkaProducer = oldNewKafkaProducer
+	}()
+
+	kp := NewKafkaPublisher([]string{"test"}, 2)
+
+	producer := mocks.NewSyncProducer(t, kp.config())
+
+	newKafkaProducer = func(brokers []string, config *sarama.Config) (sarama.SyncProducer, error) {
+		if config.Producer.RequiredAcks != sarama.WaitForAll || config.Net.MaxOpenRequests != 1 {
+			return nil, fmt.Errorf("Unexpected config: %v", config)
+		}
+		return producer, nil
+	}
+
+	check := func(msg *sarama.ProducerMessage) error {
+		var key []byte
+
+		if msg.Key != nil {
+			key, _ = msg.Key.Encode()
+		}
+
+		value, _ := msg.Value.Encode()
+
+		received = append(received, fmt.Sprintf("%v %v %q %s", msg.Topic, msg.Partition, key, value))
+
+		return nil
+	}
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+
+	if err := kp.Publish("changes", []*Message{{[]byte("k1"), []byte("v1")}, {nil, []byte("v2")}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := strings.Join(received, ", "); res != `changes 2 "k1" v1, changes 2 "" v2` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A failed publication closes the producer
+
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	if err := kp.Publish("changes", []*Message{{nil, []byte("v3")}}); err == nil || kp.producer != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+/*
+NATSPublisher publishes messages to subjects of a NATS server.
+*/
+type NATSPublisher struct {
+	url  string      // URL of the server
+	conn *nats.Conn  // Connection to the server (nil if not connected)
+	lock *sync.Mutex // Lock for the connection
+}
+
+/*
+NewNATSPublisher creates a new NATS publisher for a server URL of the form
+nats://[user:password@]host[:port]. A user without a password is sent as token.
+*/
+func NewNATSPublisher(serverURL string) (*NATSPublisher, error) {
+
+	u, err := url.Parse(serverURL)
+
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("Invalid NATS server URL: %v", serverURL)
+	}
+
+	return &NATSPublisher{serverURL, nil, &sync.Mutex{}}, nil
+}
+
+/*
+Publish publishes messages to a subject. Returns once the server has processed
+all messages.
+*/
+func (np *NATSPublisher) Publish(topic string, msgs []*Message) error {
+	var err error
+
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	if np.conn == nil {
+
+		// The publisher reconnects itself with the next request so messages
+		// are never buffered by the client. Errors of the server are returned
+		// by Publish and are not logged.
+
+		np.conn, err = nats.Connect(np.url, nats.Name(clientID),
+			nats.Timeout(PublishTimeout), nats.NoReconnect(),
+			nats.ErrorHandler(func(*nats.Conn, *nats.Subscription, error) {}))
+	}
+
+	for i := 0; err == nil && i < len(msgs); i++ {
+		err = np.conn.Publish(topic, msgs[i].Value)
+	}
+
+	if err == nil {
+
+		// A flush confirms that the server processed all messages - errors
+		// which were reported by the server before are kept by the connection
+
+		if err = np.conn.FlushTimeout(PublishTimeout); err == nil {
+			err = np.conn.LastError()
+		}
+	}
+
+	if err != nil && np.conn != nil {
+		np.conn.Close()
+		np.conn = nil
+	}
+
+	return err
+}
+
+/*
+Close closes the connection to the server.
+*/
+func (np *NATSPublisher) Close() error {
+	np.lock.Lock()
+	defer np.lock.Unlock()
+
+	if np.conn != nil {
+		np.conn.Close()
+		np.conn = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+/*
+startTestNATS starts an embedded NATS server which denies publications to the
+subject fail.
+*/
+func startTestNATS() (*server.Server, error) {
+
+	s, err := server.NewServer(&server.Options{
+		Host: "127.0.0.1",
+		Port: server.RANDOM_PORT,
+		Users: []*server.User{{
+			Username: "user",
+			Password: "pass",
+			Permissions: &server.Permissions{
+				Publish: &server.SubjectPermission{Deny: []string{"fail"}},
+			},
+		}},
+		NoLog:  true,
+		NoSigs: true,
+	})
+
+	if err == nil {
+		go s.Start()
+
+		if !s.ReadyForConnections(10 * time.Second) {
+			err = fmt.Errorf("NATS server did not start")
+		}
+	}
+
+	return s, err
+}
+
+func TestNATSPublisher(t *testing.T) {
+
+	s, err := startTestNATS()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Shutdown()
+
+	addr := s.Addr().String()
+
+	sub, err := nats.Connect("nats://user:pass@" + addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer sub.Close()
+
+	received := make(chan string, 100)
+
+	sub.Subscribe("changes", func(msg *nats.Msg) {
+		received <- msg.Subject + " " + string(msg.Data)
+	})
+	sub.Flush()
+
+	np, err := NewNATSPublisher("nats://user:pass@" + addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer np.Close()
+
+	if err := np.Publish("changes", []*Message{{[]byte("k1"), []byte("v1")}, {nil, []byte("v 2")}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(<-received, ", ", <-received); res != "changes v1, changes v 2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Errors of the server are returned
+
+	if err := np.Publish("fail", []*Message{{nil, []byte("v")}}); err == nil ||
+		err.Error() != `nats: Permissions Violation for Publish to "fail"` {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The publisher reconnects after an error
+
+	if err := np.Publish("changes", []*Message{{nil, []byte("v3")}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := <-received; res != "changes v3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Wrong credentials are reported
+
+	np2, _ := NewNATSPublisher("nats://user:wrong@" + addr)
+
+	if err := np2.Publish("changes", []*Message{{nil, []byte("v")}}); err == nil ||
+		err.Error() != "nats: Authorization Violation" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewNATSPublisher("nats://"); err == nil || err.Error() != "Invalid NATS server URL: nats://" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
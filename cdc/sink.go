@@ -0,0 +1,340 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+CheckpointNodeKind is the node kind which stores the checkpoints of sinks.
+*/
+const CheckpointNodeKind = "cdc_checkpoint"
+
+/*
+BatchSize is the maximum number of changes which are published at once.
+*/
+var BatchSize = 100
+
+/*
+RetryBackoff is the wait time before the first retry of a failed publication.
+The wait time doubles with every further retry.
+*/
+var RetryBackoff = time.Second
+
+/*
+RetryMaxBackoff is the maximum wait time between two retries.
+*/
+var RetryMaxBackoff = time.Minute
+
+/*
+ChangeTranslation translates changes before they are published (e.g. to
+replace keys with external IDs).
+*/
+type ChangeTranslation func(changes []*replication.Change) []*replication.Change
+
+/*
+Stats are the publication statistics of a sink.
+*/
+type Stats struct {
+	Published   int64  `json:"published"`    // Number of published changes
+	Snapshots   int64  `json:"snapshots"`    // Number of published snapshots
+	Failed      int64  `json:"failed"`       // Number of failed publications
+	LastSeq     uint64 `json:"last_seq"`     // Sequence number of the last published change
+	LastPublish int64  `json:"last_publish"` // Time of the last publication (Unix seconds)
+	LastError   string `json:"last_error"`   // Error of the last failed publication
+}
+
+/*
+Sink publishes the changes of a change log to the topics of a publisher.
+*/
+type Sink struct {
+	gm        *graph.Manager         // Graph manager of the changes
+	part      string                 // Partition which stores the checkpoint
+	cl        *replication.ChangeLog // Change log which is published
+	config    *SinkConfig            // Configuration of the sink
+	pub       Publisher              // Publisher of the messages
+	translate ChangeTranslation      // Translation of published changes (nil for no translation)
+	stats     *Stats                 // Publication statistics
+	lock      *sync.RWMutex          // Lock for statistics
+	stop      chan struct{}          // Channel which is closed to stop the sink
+	done      chan struct{}          // Channel which is closed once the sink has stopped
+}
+
+/*
+NewSink creates a new sink and starts publishing. The checkpoint of the sink
+is stored in a given partition. Changes of this partition are never published.
+*/
+func NewSink(gm *graph.Manager, part string, cl *replication.ChangeLog, config *SinkConfig,
+	pub Publisher, translate ChangeTranslation) (*Sink, error) {
+
+	s := &Sink{gm, part, cl, config, pub, translate, &Stats{}, &sync.RWMutex{},
+		make(chan struct{}), make(chan struct{})}
+
+	node, err := gm.FetchNode(part, config.Name, CheckpointNodeKind)
+	if err != nil {
+		return nil, err
+	}
+
+	var log string
+	var since uint64
+
+	if node != nil {
+		log = fmt.Sprint(node.Attr("log"))
+		since, _ = strconv.ParseUint(fmt.Sprint(node.Attr("seq")), 10, 64)
+	}
+
+	go s.run(log, since)
+
+	return s, nil
+}
+
+/*
+Name returns the name of the sink.
+*/
+func (s *Sink) Name() string {
+	return s.config.Name
+}
+
+/*
+Stats returns the publication statistics of the sink.
+*/
+func (s *Sink) Stats() Stats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return *s.stats
+}
+
+/*
+Close stops the sink and closes its publisher.
+*/
+func (s *Sink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	return s.pub.Close()
+}
+
+/*
+run publishes changes until the sink is stopped. The given checkpoint is the
+ID of a change log and the sequence number of the last published change.
+*/
+func (s *Sink) run(log string, since uint64) {
+	defer close(s.done)
+
+	backoff := RetryBackoff
+
+	selects := func(c *replication.Change) bool {
+		return c.Part != s.part && s.config.Topic(c.Part) != ""
+	}
+
+	for {
+		var changes []*replication.Change
+		var next uint64
+		var err error
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		// Get the notification channel first so no change is missed
+
+		notify := s.cl.Notify()
+
+		if log == s.cl.ID() {
+			changes, next, err = s.cl.FilteredChanges(since, BatchSize, selects)
+
+			if err == replication.ErrChangesTruncated {
+
+				// The sink fell behind - publish a snapshot instead
+
+				log = ""
+				continue
+
+			} else if err == nil && len(changes) > 0 {
+
+				if err = s.publish(changes); err == nil {
+					err = s.checkpoint(log, next)
+				}
+
+				if err == nil {
+					s.record(int64(len(changes)), changes[len(changes)-1].Seq, false, nil)
+				}
+			}
+
+		} else {
+
+			// The checkpoint does not belong to the current change log
+
+			if next, err = s.publishSnapshot(); err == nil {
+				if err = s.checkpoint(s.cl.ID(), next); err == nil {
+					log = s.cl.ID()
+					s.record(0, next, true, nil)
+				}
+			}
+		}
+
+		if err != nil {
+
+			// Retry the publication after a backoff
+
+			s.record(0, 0, false, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+
+			if backoff *= 2; backoff > RetryMaxBackoff {
+				backoff = RetryMaxBackoff
+			}
+
+			continue
+		}
+
+		backoff = RetryBackoff
+		since = next
+
+		if len(changes) == 0 && log == s.cl.ID() {
+			select {
+			case <-notify:
+			case <-s.stop:
+				return
+			}
+		}
+	}
+}
+
+/*
+publishSnapshot publishes the current state of all partitions of the sink.
+Returns the sequence number of the last change before the snapshot.
+*/
+func (s *Sink) publishSnapshot() (uint64, error) {
+	var parts []string
+	var batch []*replication.Change
+
+	for _, part := range s.gm.Partitions() {
+		if part != s.part && s.config.Topic(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	seq, err := s.cl.WalkSnapshot(s.gm, parts, func(part string, op string, nodeData map[string]interface{}) error {
+
+		batch = append(batch, &replication.Change{
+			Time: time.Now().UnixNano(),
+			Op:   op,
+			Part: part,
+			Key:  fmt.Sprint(nodeData[data.NodeKey]),
+			Kind: fmt.Sprint(nodeData[data.NodeKind]),
+			Data: nodeData,
+		})
+
+		if len(batch) < BatchSize {
+			return nil
+		}
+
+		err := s.publish(batch)
+		batch = nil
+
+		return err
+	})
+
+	if err == nil && len(batch) > 0 {
+		err = s.publish(batch)
+	}
+
+	return seq, err
+}
+
+/*
+publish publishes a list of changes. The changes of each topic are published
+in order.
+*/
+func (s *Sink) publish(changes []*replication.Change) error {
+	var topics []string
+
+	if s.translate != nil {
+		changes = s.translate(changes)
+	}
+
+	msgs := make(map[string][]*Message)
+
+	for _, c := range changes {
+		topic := s.config.Topic(c.Part)
+
+		value, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := msgs[topic]; !ok {
+			topics = append(topics, topic)
+		}
+
+		msgs[topic] = append(msgs[topic], &Message{[]byte(c.Part + "/" + c.Kind + "/" + c.Key), value})
+	}
+
+	for _, topic := range topics {
+		if err := s.pub.Publish(topic, msgs[topic]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+checkpoint stores the checkpoint of the sink.
+*/
+func (s *Sink) checkpoint(log string, seq uint64) error {
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, s.config.Name)
+	node.SetAttr(data.NodeKind, CheckpointNodeKind)
+	node.SetAttr("log", log)
+	node.SetAttr("seq", fmt.Sprint(seq))
+
+	return s.gm.StoreNode(s.part, node)
+}
+
+/*
+record records the result of a publication.
+*/
+func (s *Sink) record(published int64, seq uint64, snapshot bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err != nil {
+		s.stats.Failed++
+		s.stats.LastError = err.Error()
+		return
+	}
+
+	s.stats.Published += published
+	s.stats.LastSeq = seq
+	s.stats.LastPublish = time.Now().Unix()
+
+	if snapshot {
+		s.stats.Snapshots++
+	}
+}
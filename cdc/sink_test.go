@@ -0,0 +1,193 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+testPublisher records published messages and fails a given number of times.
+*/
+type testPublisher struct {
+	fail   int
+	msgs   []string
+	closed bool
+	lock   sync.Mutex
+}
+
+func (tp *testPublisher) Publish(topic string, msgs []*Message) error {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	if tp.fail > 0 {
+		tp.fail--
+		return errors.New("Test error")
+	}
+
+	for _, m := range msgs {
+		c := &replication.Change{}
+		json.Unmarshal(m.Value, c)
+		tp.msgs = append(tp.msgs, fmt.Sprintf("%v %v %v %v %v", topic, string(m.Key), c.Seq, c.Op, c.Data["name"]))
+	}
+
+	return nil
+}
+
+func (tp *testPublisher) Close() error {
+	tp.closed = true
+	return nil
+}
+
+func (tp *testPublisher) waitFor(n int) []string {
+	for i := 0; i < 500; i++ {
+		tp.lock.Lock()
+		msgs := tp.msgs
+		tp.lock.Unlock()
+
+		if len(msgs) >= n {
+			return msgs
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return tp.msgs
+}
+
+func TestSink(t *testing.T) {
+	oldRetryBackoff := RetryBackoff
+	defer func() {
+		RetryBackoff = oldRetryBackoff
+	}()
+
+	RetryBackoff = time.Millisecond
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	cl := replication.NewChangeLog(100)
+	gm.SetGraphRule(cl)
+
+	storeSong := func(key string, name string) {
+		gm.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+			"key":  key,
+			"kind": "Song",
+			"name": name,
+		}))
+	}
+
+	storeSong("a", "Aria")
+
+	config := &SinkConfig{Name: "test", Type: TypeNATS, URL: "nats://localhost",
+		Topics: map[string]string{"main": "songs"}}
+
+	// A new sink publishes a snapshot first
+
+	pub := &testPublisher{}
+
+	sink, err := NewSink(gm, "system", cl, config, pub, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(pub.waitFor(1)); res != "[songs main/Song/a 0 node.store Aria]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Changes are published in order - failed publications are retried
+
+	pub.lock.Lock()
+	pub.fail = 2
+	pub.lock.Unlock()
+
+	gm.StoreNode("other", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "x",
+		"kind": "Song",
+	}))
+
+	storeSong("b", "Ballad")
+	gm.RemoveNode("main", "a", "Song")
+
+	seq, _ := cl.LastSeq()
+
+	if res := fmt.Sprint(pub.waitFor(3)); res != fmt.Sprintf("[songs main/Song/a 0 node.store Aria "+
+		"songs main/Song/b %v node.store Ballad songs main/Song/a %v node.delete <nil>]", seq-1, seq) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for i := 0; i < 100 && sink.Stats().LastSeq != seq; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := sink.Stats(); stats.Failed != 2 || stats.Published != 2 || stats.Snapshots != 1 ||
+		stats.LastSeq != seq || stats.LastError != "Test error" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if node, err := gm.FetchNode("system", "test", CheckpointNodeKind); err != nil ||
+		node.Attr("log") != cl.ID() || node.Attr("seq") != fmt.Sprint(seq) {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if sink.Name() != "test" || sink.Close() != nil || !pub.closed {
+		t.Error("Unexpected result")
+		return
+	}
+
+	// A sink continues after its checkpoint
+
+	storeSong("c", "Canon")
+
+	pub = &testPublisher{}
+
+	if sink, err = NewSink(gm, "system", cl, config, pub, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(pub.waitFor(1)); res != fmt.Sprintf("[songs main/Song/c %v node.store Canon]", seq+2) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	sink.Close()
+
+	// A sink publishes a snapshot if its checkpoint belongs to another change log
+
+	pub = &testPublisher{}
+
+	if sink, err = NewSink(gm, "system", replication.NewChangeLog(100), config, pub, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	defer sink.Close()
+
+	if res := fmt.Sprint(pub.waitFor(2)); res != "[songs main/Song/b 0 node.store Ballad songs main/Song/c 0 node.store Canon]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
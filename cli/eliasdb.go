@@ -56,6 +56,8 @@ import (
 	"github.com/krotik/eliasdb/config"
 	"github.com/krotik/eliasdb/console"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/codegen"
+	"github.com/krotik/eliasdb/graph/graphstorage"
 	"github.com/krotik/eliasdb/server"
 )
 
@@ -77,6 +79,7 @@ func main() {
 		fmt.Println()
 		fmt.Println("Available commands:")
 		fmt.Println()
+		fmt.Println("    client    EliasDB command line client")
 		fmt.Println("    console   EliasDB server console")
 		fmt.Println("    server    Start EliasDB server")
 		fmt.Println()
@@ -98,6 +101,9 @@ func main() {
 		} else if arg == "console" {
 			config.LoadConfigFile(config.DefaultConfigFile)
 			RunCliConsole()
+		} else if arg == "client" {
+			config.LoadDefaultConfig()
+			RunCliClient()
 		} else {
 			flag.Usage()
 		}
@@ -112,7 +118,6 @@ func main() {
 RunCliConsole runs the server console on the commandline.
 */
 func RunCliConsole() {
-	var err error
 
 	// Try to get the server host and port from the config file
 
@@ -141,7 +146,20 @@ func RunCliConsole() {
 		return
 	}
 
-	if *cmdfile == "" && *cmdline == "" {
+	runConsole(fmt.Sprintf("https://%s:%s", *host, *port), *cmdfile, *cmdline, nil)
+}
+
+/*
+runConsole runs a console which is connected to the given server URL. The
+console reads commands from a file, a single line or the terminal. The
+optional setup function can modify the console before it is started.
+*/
+func runConsole(url string, cmdfile string, cmdline string,
+	setup func(*console.EliasDBConsole, termutil.ConsoleLineTerminal)) {
+
+	var err error
+
+	if cmdfile == "" && cmdline == "" {
 		fmt.Println(fmt.Sprintf("EliasDB %v - Console",
 			config.ProductVersion))
 	}
@@ -154,22 +172,22 @@ func RunCliConsole() {
 
 	clt, err = termutil.NewConsoleLineTerminal(os.Stdout)
 
-	if *cmdfile != "" {
+	if cmdfile != "" {
 		var file *os.File
 
 		// Read commands from a file
 
-		file, err = os.Open(*cmdfile)
+		file, err = os.Open(cmdfile)
 		if err == nil {
 			defer file.Close()
 
 			clt, err = termutil.AddFileReadingWrapper(clt, file, true)
 		}
 
-	} else if *cmdline != "" {
+	} else if cmdline != "" {
 		var buf bytes.Buffer
 
-		buf.WriteString(fmt.Sprintln(*cmdline))
+		buf.WriteString(fmt.Sprintln(cmdline))
 
 		// Read commands from a single line
 
@@ -190,7 +208,7 @@ func RunCliConsole() {
 
 		// Create the console object
 
-		con := console.NewConsole(fmt.Sprintf("https://%s:%s", *host, *port), os.Stdout,
+		con := console.NewConsole(url, os.Stdout,
 			func() (string, string) {
 
 				//  Login function
@@ -233,6 +251,10 @@ func RunCliConsole() {
 				return ioutil.WriteFile(filename, exportBuf.Bytes(), 0666)
 			})
 
+		if setup != nil {
+			setup(con.(*console.EliasDBConsole), clt)
+		}
+
 		// Start the console
 
 		if err = clt.StartTerm(); err == nil {
@@ -240,7 +262,7 @@ func RunCliConsole() {
 
 			defer clt.StopTerm()
 
-			if *cmdfile == "" && *cmdline == "" {
+			if cmdfile == "" && cmdline == "" {
 				fmt.Println("Type 'q' or 'quit' to exit the shell and '?' to get help")
 			}
 
@@ -266,6 +288,143 @@ func RunCliConsole() {
 	}
 }
 
+/*
+RunCliClient runs the command line client. The client runs either a single
+query, import or export or an interactive console.
+*/
+func RunCliClient() {
+
+	// Try to get the server host and port from the config file
+
+	chost, cport := getHostPortFromConfig()
+
+	serverURL := flag.String("url", fmt.Sprintf("https://%s:%s", chost, cport), "URL of the EliasDB server")
+	part := flag.String("part", "main", "Partition which is used")
+	format := flag.String("format", console.FormatTable, "Output format of query results (table, csv or json)")
+	user := flag.String("user", "", "Log in as this user (the password is read from the ELIASDB_PASSWORD environment variable or asked for)")
+
+	showHelp := flag.Bool("help", false, "Show this help message")
+
+	flag.Usage = func() {
+		fmt.Println()
+		fmt.Println(fmt.Sprintf("Usage of %s client [options] [command]", os.Args[0]))
+		fmt.Println()
+		fmt.Println("Available commands (an interactive console is started if no command is given):")
+		fmt.Println()
+		fmt.Println("    query <query>   Run a query and write the result to stdout")
+		fmt.Println("    import <file>   Import nodes and edges from a JSON file into the partition (a zip file can contain a JSON file for each partition)")
+		fmt.Println("    export <file>   Export all nodes and edges of the partition to a JSON file (all partitions are exported if the file name ends with .zip)")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println()
+		flag.PrintDefaults()
+		fmt.Println()
+	}
+
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if *showHelp {
+		flag.Usage()
+		return
+	}
+
+	if *format != console.FormatTable && *format != console.FormatCSV && *format != console.FormatJSON {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Unknown format: %s", *format))
+		os.Exit(1)
+	}
+
+	if *user != "" {
+		config.Config[config.EnableAccessControl] = true
+	}
+
+	args := flag.Args()
+
+	setup := func(con *console.EliasDBConsole, clt termutil.ConsoleLineTerminal) {
+		var asked bool
+
+		con.SetPartition(*part)
+		con.SetFormat(*format)
+
+		con.GetCredentials = func() (string, string) {
+
+			// Ask only once for the password - authentication is skipped
+			// if the login failed
+
+			if asked {
+				return "", ""
+			}
+
+			asked = true
+			pass := os.Getenv("ELIASDB_PASSWORD")
+
+			if pass == "" && clt != nil {
+				pass, _ = clt.NextLinePrompt("Password: ", '*')
+			}
+
+			return *user, pass
+		}
+	}
+
+	if len(args) == 0 {
+		runConsole(*serverURL, "", "", setup)
+		return
+	}
+
+	err := runClientCommand(*serverURL, args, setup)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+/*
+runClientCommand runs a single command of the command line client. Only
+query results are written to stdout - all other output goes to stderr so
+results can be piped into other tools.
+*/
+func runClientCommand(url string, args []string,
+	setup func(*console.EliasDBConsole, termutil.ConsoleLineTerminal)) error {
+
+	var cmd string
+
+	if args[0] == "import" {
+		cmd = console.CommandImport
+	} else if args[0] == "export" {
+		cmd = console.CommandDump
+	} else if args[0] != "query" {
+		return fmt.Errorf("Unknown command: %s", args[0])
+	}
+
+	if len(args) < 2 && cmd == "" {
+		return fmt.Errorf("Please specify a query")
+	} else if len(args) < 2 {
+		return fmt.Errorf("Please specify a file")
+	}
+
+	out := &struct{ io.Writer }{os.Stderr}
+
+	con := console.NewConsole(url, out, nil, nil, nil).(*console.EliasDBConsole)
+
+	setup(con, nil)
+
+	if config.Bool(config.EnableAccessControl) {
+		con.Authenticate(false)
+	}
+
+	if cmd != "" {
+		return con.CommandMap[cmd].Run(args[1:2], con)
+	}
+
+	// Only the query result is written to stdout
+
+	out.Writer = os.Stdout
+
+	_, err := con.Run(strings.Join(args[1:], " "))
+
+	return err
+}
+
 /*
 getHostPortFromConfig gets the host and port from the config file or the
 default config.
@@ -295,6 +454,11 @@ func handleServerCommandLine(gm *graph.Manager) bool {
 
 	importDb := flag.String("import", "", "Import a database from a zip file")
 	exportDb := flag.String("export", "", "Export the current database to a zip file")
+	genClient := flag.String("gen-client", "", "Generate a Go client package with typed models for all node kinds in a directory")
+	genClientPkg := flag.String("gen-client-package", "", "Package name of the generated client (default is the directory name)")
+	genClientKinds := flag.String("gen-client-kinds", "", "Comma separated list of node kinds which are included in the generated client (default is all node kinds)")
+	checkDb := flag.Bool("check", false, "Check the consistency of the datastore")
+	repairDb := flag.String("repair", "", "Copy all readable data of the datastore into a new datastore in a directory")
 
 	if config.Bool(config.EnableECALScripts) {
 		ecalConsole = flag.Bool("ecal-console", false, "Start an interactive interpreter console for ECAL")
@@ -375,6 +539,15 @@ func handleServerCommandLine(gm *graph.Manager) bool {
 		}
 	}
 
+	if err == nil && (*checkDb || *repairDb != "") {
+		err = checkDatastore(gm, *repairDb)
+	}
+
+	if err == nil && *genClient != "" {
+		fmt.Println("Generating client in:", *genClient)
+		err = generateGoClient(gm, *genClient, *genClientPkg, *genClientKinds)
+	}
+
 	if ecalConsole != nil && *ecalConsole {
 		var term termutil.ConsoleLineTerminal
 
@@ -424,3 +597,119 @@ func handleServerCommandLine(gm *graph.Manager) bool {
 
 	return *noServ
 }
+
+/*
+checkDatastore checks the consistency of the datastore and prints all found
+problems. If a repair directory is given then all readable data is copied into
+a new datastore in this directory.
+*/
+func checkDatastore(gm *graph.Manager, repair string) error {
+
+	fmt.Println("Checking datastore")
+
+	report, err := gm.CheckConsistency(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sr := range report.Storage {
+		if !sr.Consistent() {
+			fmt.Println(fmt.Sprintf("Storage %v has %v problems:", sr.Name, sr.Problems))
+
+			for _, example := range sr.Examples {
+				fmt.Println("  ", example)
+			}
+		}
+	}
+
+	if report.Problems > 0 {
+		fmt.Println(fmt.Sprintf("Graph has %v problems:", report.Problems))
+
+		for _, example := range report.Examples {
+			fmt.Println("  ", example)
+		}
+	}
+
+	fmt.Println(fmt.Sprintf("Checked %v nodes and %v edges - datastore is consistent: %v",
+		report.Nodes, report.Edges, report.Consistent()))
+
+	if repair == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(repair); err == nil {
+		return fmt.Errorf("Repair directory %v exists already", repair)
+	}
+
+	fmt.Println("Copying readable data to:", repair)
+
+	gs, err := graphstorage.NewDiskGraphStorage(repair, false)
+	if err != nil {
+		return err
+	}
+
+	defer gs.Close()
+
+	salvage, err := gm.Salvage(graph.NewGraphManager(gs), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, example := range salvage.Examples {
+		fmt.Println("  Lost", example)
+	}
+
+	fmt.Println(fmt.Sprintf("Copied %v nodes, %v edges and %v blobs - lost %v nodes, %v edges and %v blobs",
+		salvage.Nodes, salvage.Edges, salvage.Blobs, salvage.LostNodes, salvage.LostEdges, salvage.LostBlobs))
+
+	return nil
+}
+
+/*
+generateGoClient generates a Go client package for the node kinds of the
+datastore.
+*/
+func generateGoClient(gm *graph.Manager, dir string, pkg string, kinds string) error {
+	var schemas []map[string]interface{}
+
+	if pkg == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		pkg = strings.ToLower(strings.NewReplacer("-", "", ".", "", " ", "").Replace(filepath.Base(abs)))
+	}
+
+	kindList := gm.NodeKinds()
+
+	if kinds != "" {
+		kindList = strings.Split(kinds, ",")
+	}
+
+	for _, kind := range kindList {
+		schema, err := graph.KindSchema(strings.TrimSpace(kind), graph.SchemaSampleSize, gm)
+		if err != nil {
+			return err
+		} else if schema == nil {
+			return fmt.Errorf("Unknown node kind %v", kind)
+		}
+
+		schemas = append(schemas, schema)
+	}
+
+	files, err := codegen.GenerateGoClient(pkg, schemas)
+
+	if err == nil {
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			for name, src := range files {
+				fmt.Println(fmt.Sprintf("Writing %v (package %v)", filepath.Join(dir, name), pkg))
+
+				if err = ioutil.WriteFile(filepath.Join(dir, name), src, 0644); err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	return err
+}
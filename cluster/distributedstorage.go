@@ -83,7 +83,10 @@ type DistributedStorage struct {
 	localName         string                                // Name of the local graph storage
 	localDRHandler    func(interface{}, *interface{}) error // Local data request handler
 	localFlushHandler func() error                          // Handler to flush the local storage
+	localSyncHandler  func() error                          // Handler to sync the local storage
 	localCloseHandler func() error                          // Handler to close the local storage
+	localHealthCheck  func() error                          // Handler to check the local storage
+	localTransfers    func() (int, error)                   // Handler to count pending transfer requests
 
 	mainDB      map[string]string // Local main copy (only set when requested)
 	mainDBError error             // Last error when main db was requested
@@ -155,7 +158,7 @@ func newDistributedAndMemberStorage(gs graphstorage.Storage, config map[string]i
 		mm.LogInfo("Storage disabled:", err)
 	}
 
-	ds := &DistributedStorage{mm, &sync.Mutex{}, dt, err, gs.Name(), nil, nil, nil, nil, nil}
+	ds := &DistributedStorage{mm, &sync.Mutex{}, dt, err, gs.Name(), nil, nil, nil, nil, nil, nil, nil, nil}
 
 	// Create MemberStorage instance which is not exposed - the object will
 	// only be used by the RPC server and called during start and stop. It is
@@ -171,7 +174,10 @@ func newDistributedAndMemberStorage(gs graphstorage.Storage, config map[string]i
 	mm.SetHandleDataRequest(memberStorage.handleDataRequest)
 	ds.localDRHandler = memberStorage.handleDataRequest
 	ds.localFlushHandler = memberStorage.gs.FlushAll
+	ds.localSyncHandler = memberStorage.sync
 	ds.localCloseHandler = memberStorage.gs.Close
+	ds.localHealthCheck = memberStorage.checkHealth
+	ds.localTransfers = memberStorage.pendingTransfers
 
 	// Set update handler
 
@@ -257,6 +263,21 @@ func (ds *DistributedStorage) IsOperational() bool {
 	return ds.distributionTableError == nil && ds.distributionTable != nil
 }
 
+/*
+CheckHealth checks that the local storage of this cluster member can be accessed.
+*/
+func (ds *DistributedStorage) CheckHealth() error {
+	return ds.localHealthCheck()
+}
+
+/*
+PendingTransfers returns the number of data transfer requests which are waiting
+to be sent to other cluster members.
+*/
+func (ds *DistributedStorage) PendingTransfers() (int, error) {
+	return ds.localTransfers()
+}
+
 /*
 DistributionTable returns the current distribution table or an error if the
 storage is not available.
@@ -464,6 +485,14 @@ func (ds *DistributedStorage) FlushAll() error {
 	return ds.localFlushHandler()
 }
 
+/*
+Sync writes all pending local changes to the storage and waits until they
+are durable. Data of other cluster members is not affected.
+*/
+func (ds *DistributedStorage) Sync() error {
+	return ds.localSyncHandler()
+}
+
 /*
 StorageManager gets a storage manager with a certain name. A non-exisClusterting StorageManager
 is not created automatically if the create flag is set to false.
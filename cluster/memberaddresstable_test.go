@@ -203,4 +203,17 @@ func TestAddressTableTransfer(t *testing.T) {
 		t.Error("Unexpected counter value:", counter)
 		return
 	}
+
+	ds1, _ := createCluster(1, 1)
+	ds1[0].localTransfers = ms1[0].pendingTransfers
+
+	if res, err := ds1[0].PendingTransfers(); res != 3 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := ds1[0].CheckHealth(); err != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
 }
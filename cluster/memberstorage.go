@@ -772,3 +772,24 @@ func (ms *memberStorage) dump(smname string) string {
 
 	return res
 }
+
+/*
+checkHealth checks the wrapped local storage if it supports health checks.
+*/
+func (ms *memberStorage) checkHealth() error {
+	if hc, ok := ms.gs.(graphstorage.HealthCheck); ok {
+		return hc.CheckHealth()
+	}
+	return nil
+}
+
+/*
+sync syncs the wrapped local storage if it supports syncing. Falls back to a
+flush otherwise.
+*/
+func (ms *memberStorage) sync() error {
+	if s, ok := ms.gs.(graphstorage.Sync); ok {
+		return s.Sync()
+	}
+	return ms.gs.FlushAll()
+}
@@ -114,3 +114,20 @@ func (ms *memberStorage) transferWorker() {
 
 	go ms.rebalanceWorker(false)
 }
+
+/*
+pendingTransfers counts the requests in the transfer table.
+*/
+func (ms *memberStorage) pendingTransfers() (int, error) {
+	var count int
+
+	it := hash.NewHTreeIterator(ms.at.transfer)
+
+	for it.HasNext() {
+		if key, _ := it.Next(); key != nil {
+			count++
+		}
+	}
+
+	return count, it.LastError
+}
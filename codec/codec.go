@@ -0,0 +1,388 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package codec contains the registry of compression codecs which is shared by
+the storage, the snapshot files and the HTTP layer of EliasDB.
+
+A codec is selected with a spec of the form <name> or <name>:<level> (e.g.
+gzip:9). Each subsystem selects its codec independently. New codecs are added
+with Register - they are then available to all subsystems.
+
+Codecs have a unique ID which is stored with compressed data so data stays
+readable if the selected codec changes. The IDs 0 and 255 are reserved.
+
+A framed stream starts with a zero byte and the ID of its codec followed by
+the compressed data. Streams whose uncompressed data never starts with a zero
+byte (e.g. gob or JSON streams) can be read with NewStreamReader regardless of
+whether they were compressed or not.
+*/
+package codec
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+None is the name which selects no compression.
+*/
+const None = "none"
+
+/*
+StreamMarker is the first byte of a framed stream.
+*/
+const StreamMarker = 0x00
+
+/*
+Codec compresses and decompresses data streams.
+*/
+type Codec interface {
+
+	/*
+		ID returns the unique ID of the codec.
+	*/
+	ID() byte
+
+	/*
+		Name returns the name of the codec.
+	*/
+	Name() string
+
+	/*
+		Level returns the compression level of the codec.
+	*/
+	Level() int
+
+	/*
+		Encoding returns the HTTP content coding of the codec (e.g. gzip).
+		Returns an empty string if the codec cannot be used for HTTP.
+	*/
+	Encoding() string
+
+	/*
+		NewWriter returns a writer which compresses all data to a given writer.
+		The returned writer must be closed to write all data.
+	*/
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	/*
+		NewReader returns a reader for the uncompressed data of a given reader.
+	*/
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+/*
+Factory creates a codec with a given compression level.
+*/
+type Factory func(level int) (Codec, error)
+
+/*
+registration is a registered codec.
+*/
+type registration struct {
+	id           byte    // Unique ID of the codec
+	name         string  // Name of the codec
+	defaultLevel int     // Level which is used if a spec has no level
+	factory      Factory // Factory for codec instances
+}
+
+/*
+registry holds all registered codecs.
+*/
+var registry = struct {
+	byID   map[byte]*registration
+	byName map[string]*registration
+	mutex  *sync.RWMutex
+}{make(map[byte]*registration), make(map[string]*registration), &sync.RWMutex{}}
+
+/*
+Register registers a codec with its ID, name and default level. Codecs must be
+registered before any data is read which was compressed with them.
+*/
+func Register(id byte, name string, defaultLevel int, factory Factory) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if id == 0 || id == 0xFF {
+		panic(fmt.Sprintf("Codec ID %v is reserved", id))
+	} else if r, ok := registry.byID[id]; ok && r.name != name {
+		panic(fmt.Sprintf("Codec ID %v is already used by %v", id, r.name))
+	} else if name == "" || name == None || strings.ContainsAny(name, ":, ") {
+		panic(fmt.Sprintf("Invalid codec name: %v", name))
+	}
+
+	r := &registration{id, name, defaultLevel, factory}
+
+	registry.byID[id] = r
+	registry.byName[name] = r
+}
+
+/*
+Get returns a codec for a given spec (<name> or <name>:<level>). Returns nil
+(no compression) for the name none or an empty spec.
+*/
+func Get(spec string) (Codec, error) {
+	spec = strings.TrimSpace(spec)
+
+	if spec == "" || spec == None {
+		return nil, nil
+	}
+
+	name := spec
+	level := ""
+
+	if i := strings.Index(spec, ":"); i != -1 {
+		name, level = spec[:i], spec[i+1:]
+	}
+
+	registry.mutex.RLock()
+	r, ok := registry.byName[name]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown compression: %v", name)
+	}
+
+	if level == "" {
+		return r.factory(r.defaultLevel)
+	}
+
+	l, err := strconv.Atoi(level)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compression level for %v: %v", name, level)
+	}
+
+	return r.factory(l)
+}
+
+/*
+GetList returns the codecs of a comma separated list of specs. Specs which
+select no compression are skipped.
+*/
+func GetList(specs string) ([]Codec, error) {
+	var ret []Codec
+
+	for _, spec := range strings.Split(specs, ",") {
+		c, err := Get(spec)
+
+		if err != nil {
+			return nil, err
+		} else if c != nil {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ByID returns a codec with its default level for a given ID.
+*/
+func ByID(id byte) (Codec, error) {
+	registry.mutex.RLock()
+	r, ok := registry.byID[id]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec ID: %v", id)
+	}
+
+	return r.factory(r.defaultLevel)
+}
+
+/*
+Names returns the names of all registered codecs.
+*/
+func Names() []string {
+	var ret []string
+
+	registry.mutex.RLock()
+	for name := range registry.byName {
+		ret = append(ret, name)
+	}
+	registry.mutex.RUnlock()
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+Spec returns the spec of a given codec.
+*/
+func Spec(c Codec) string {
+	if c == nil {
+		return None
+	}
+	return fmt.Sprintf("%v:%v", c.Name(), c.Level())
+}
+
+/*
+NewStreamWriter returns a writer for a framed stream which is compressed with
+a given codec. Data is written unchanged if no codec is given.
+*/
+func NewStreamWriter(w io.Writer, c Codec) (io.WriteCloser, error) {
+
+	if c == nil {
+		return nopWriteCloser{w}, nil
+	}
+
+	if _, err := w.Write([]byte{StreamMarker, c.ID()}); err != nil {
+		return nil, err
+	}
+
+	return c.NewWriter(w)
+}
+
+/*
+NewStreamReader returns a reader for the uncompressed data of a stream. Framed
+streams are decompressed with their codec - all other streams are returned as
+they are.
+*/
+func NewStreamReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(2)
+
+	if err != nil || header[0] != StreamMarker {
+		return ioutil.NopCloser(br), nil
+	}
+
+	c, err := ByID(header[1])
+	if err != nil {
+		return nil, err
+	}
+
+	br.Discard(2)
+
+	return c.NewReader(br)
+}
+
+/*
+nopWriteCloser is a writer with a Close method which does nothing.
+*/
+type nopWriteCloser struct {
+	io.Writer
+}
+
+/*
+Close does nothing.
+*/
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Standard codecs
+// ===============
+
+func init() {
+	Register(1, "flate", flate.BestSpeed, func(level int) (Codec, error) {
+		return newStdCodec(1, "flate", "", level, func(w io.Writer, level int) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		}, func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		})
+	})
+
+	Register(2, "gzip", gzip.DefaultCompression, func(level int) (Codec, error) {
+		return newStdCodec(2, "gzip", "gzip", level, func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		}, func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		})
+	})
+
+	Register(3, "zlib", zlib.DefaultCompression, func(level int) (Codec, error) {
+		return newStdCodec(3, "zlib", "deflate", level, func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		}, func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		})
+	})
+}
+
+/*
+stdCodec is a codec which uses a compression package of the standard library.
+*/
+type stdCodec struct {
+	id        byte                                                 // Unique ID of the codec
+	name      string                                               // Name of the codec
+	encoding  string                                               // HTTP content coding
+	level     int                                                  // Compression level
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error) // Writer constructor
+	newReader func(r io.Reader) (io.ReadCloser, error)             // Reader constructor
+}
+
+/*
+newStdCodec creates a new codec for a compression package of the standard
+library. The level is checked by creating a writer.
+*/
+func newStdCodec(id byte, name string, encoding string, level int,
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error),
+	newReader func(r io.Reader) (io.ReadCloser, error)) (Codec, error) {
+
+	if _, err := newWriter(ioutil.Discard, level); err != nil {
+		return nil, fmt.Errorf("Invalid compression level for %v: %v", name, level)
+	}
+
+	return &stdCodec{id, name, encoding, level, newWriter, newReader}, nil
+}
+
+/*
+ID returns the unique ID of the codec.
+*/
+func (sc *stdCodec) ID() byte {
+	return sc.id
+}
+
+/*
+Name returns the name of the codec.
+*/
+func (sc *stdCodec) Name() string {
+	return sc.name
+}
+
+/*
+Level returns the compression level of the codec.
+*/
+func (sc *stdCodec) Level() int {
+	return sc.level
+}
+
+/*
+Encoding returns the HTTP content coding of the codec.
+*/
+func (sc *stdCodec) Encoding() string {
+	return sc.encoding
+}
+
+/*
+NewWriter returns a writer which compresses all data to a given writer.
+*/
+func (sc *stdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return sc.newWriter(w, sc.level)
+}
+
+/*
+NewReader returns a reader for the uncompressed data of a given reader.
+*/
+func (sc *stdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return sc.newReader(r)
+}
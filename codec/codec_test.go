@@ -0,0 +1,181 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCodecRegistry(t *testing.T) {
+
+	if res := fmt.Sprint(Names()); res != "[flate gzip zlib]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if c, err := Get(None); c != nil || err != nil || Spec(c) != "none" {
+		t.Error("Unexpected result:", c, err)
+		return
+	}
+
+	if c, err := Get(""); c != nil || err != nil {
+		t.Error("Unexpected result:", c, err)
+		return
+	}
+
+	if c, err := Get("flate"); err != nil || Spec(c) != "flate:1" || c.ID() != 1 || c.Encoding() != "" {
+		t.Error("Unexpected result:", c, err)
+		return
+	}
+
+	if c, err := Get(" gzip:9 "); err != nil || Spec(c) != "gzip:9" || c.ID() != 2 || c.Encoding() != "gzip" {
+		t.Error("Unexpected result:", c, err)
+		return
+	}
+
+	if c, err := ByID(3); err != nil || Spec(c) != "zlib:-1" || c.Encoding() != "deflate" {
+		t.Error("Unexpected result:", c, err)
+		return
+	}
+
+	if _, err := Get("foo"); err == nil || err.Error() != "Unknown compression: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := Get("gzip:x"); err == nil || err.Error() != "Invalid compression level for gzip: x" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := Get("gzip:42"); err == nil || err.Error() != "Invalid compression level for gzip: 42" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := ByID(99); err == nil || err.Error() != "Unknown codec ID: 99" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res, err := GetList("gzip, none,zlib:1"); err != nil || len(res) != 2 ||
+		Spec(res[0]) != "gzip:-1" || Spec(res[1]) != "zlib:1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := GetList("gzip,foo"); err == nil || err.Error() != "Unknown compression: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Test invalid registrations
+
+	for _, test := range []struct {
+		id   byte
+		name string
+		msg  string
+	}{
+		{0, "foo", "Codec ID 0 is reserved"},
+		{0xFF, "foo", "Codec ID 255 is reserved"},
+		{1, "foo", "Codec ID 1 is already used by flate"},
+		{42, "none", "Invalid codec name: none"},
+		{42, "foo:1", "Invalid codec name: foo:1"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != test.msg {
+					t.Error("Unexpected result:", r)
+				}
+			}()
+
+			Register(test.id, test.name, 0, nil)
+		}()
+	}
+}
+
+func TestCodecStreams(t *testing.T) {
+	var buf bytes.Buffer
+
+	data := strings.Repeat("EliasDB ", 100)
+
+	for _, spec := range []string{"none", "flate", "gzip:9", "zlib:0"} {
+		c, _ := Get(spec)
+
+		buf.Reset()
+
+		w, err := NewStreamWriter(&buf, c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		w.Write([]byte(data))
+		w.Close()
+
+		if c != nil && (buf.Bytes()[0] != StreamMarker || buf.Bytes()[1] != c.ID()) {
+			t.Error("Unexpected stream header:", buf.Bytes()[:2])
+			return
+		}
+
+		r, err := NewStreamReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if res, err := ioutil.ReadAll(r); err != nil || string(res) != data {
+			t.Error("Unexpected result:", spec, string(res), err)
+			return
+		}
+	}
+
+	// The gzip codec produces standard gzip data
+
+	c, _ := Get("gzip")
+
+	buf.Reset()
+
+	w, _ := c.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ioutil.ReadAll(gr); string(res) != data {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	// Test error cases
+
+	if _, err := NewStreamReader(bytes.NewReader([]byte{StreamMarker, 99})); err == nil ||
+		err.Error() != "Unknown codec ID: 99" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	r, _ := NewStreamReader(bytes.NewReader([]byte{StreamMarker}))
+
+	if res, _ := ioutil.ReadAll(r); len(res) != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
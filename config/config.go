@@ -36,76 +36,240 @@ var DefaultConfigFile = "eliasdb.config.json"
 Known configuration options for EliasDB
 */
 const (
-	MemoryOnlyStorage        = "MemoryOnlyStorage"
-	LocationDatastore        = "LocationDatastore"
-	LocationHTTPS            = "LocationHTTPS"
-	LocationWebFolder        = "LocationWebFolder"
-	LocationUserDB           = "LocationUserDB"
-	LocationAccessDB         = "LocationAccessDB"
-	HTTPSCertificate         = "HTTPSCertificate"
-	HTTPSKey                 = "HTTPSKey"
-	LockFile                 = "LockFile"
-	HTTPSHost                = "HTTPSHost"
-	HTTPSPort                = "HTTPSPort"
-	CookieMaxAgeSeconds      = "CookieMaxAgeSeconds"
-	EnableReadOnly           = "EnableReadOnly"
-	EnableECALScripts        = "EnableECALScripts"
-	EnableECALDebugServer    = "EnableECALDebugServer"
-	EnableWebFolder          = "EnableWebFolder"
-	EnableAccessControl      = "EnableAccessControl"
-	EnableWebTerminal        = "EnableWebTerminal"
-	EnableCluster            = "EnableCluster"
-	EnableClusterTerminal    = "EnableClusterTerminal"
-	ResultCacheMaxSize       = "ResultCacheMaxSize"
-	ResultCacheMaxAgeSeconds = "ResultCacheMaxAgeSeconds"
-	ClusterStateInfoFile     = "ClusterStateInfoFile"
-	ClusterConfigFile        = "ClusterConfigFile"
-	ClusterLogHistory        = "ClusterLogHistory"
-	ECALScriptFolder         = "ECALScriptFolder"
-	ECALWorkerCount          = "ECALWorkerCount"
-	ECALEntryScript          = "ECALEntryScript"
-	ECALLogLevel             = "ECALLogLevel"
-	ECALLogFile              = "ECALLogFile"
-	ECALDebugServerHost      = "ECALDebugServerHost"
-	ECALDebugServerPort      = "ECALDebugServerPort"
+	MemoryOnlyStorage          = "MemoryOnlyStorage"
+	LocationDatastore          = "LocationDatastore"
+	LocationHTTPS              = "LocationHTTPS"
+	LocationWebFolder          = "LocationWebFolder"
+	LocationUserDB             = "LocationUserDB"
+	LocationAccessDB           = "LocationAccessDB"
+	HTTPSCertificate           = "HTTPSCertificate"
+	HTTPSKey                   = "HTTPSKey"
+	LockFile                   = "LockFile"
+	HTTPSHost                  = "HTTPSHost"
+	HTTPSPort                  = "HTTPSPort"
+	CookieMaxAgeSeconds        = "CookieMaxAgeSeconds"
+	EnableReadOnly             = "EnableReadOnly"
+	EnableECALScripts          = "EnableECALScripts"
+	EnableECALDebugServer      = "EnableECALDebugServer"
+	EnableWebFolder            = "EnableWebFolder"
+	EnableAccessControl        = "EnableAccessControl"
+	EnableWebTerminal          = "EnableWebTerminal"
+	EnableWebExplorer          = "EnableWebExplorer"
+	EnableCluster              = "EnableCluster"
+	EnableClusterTerminal      = "EnableClusterTerminal"
+	ResultCacheMaxSize         = "ResultCacheMaxSize"
+	ResultCacheMaxAgeSeconds   = "ResultCacheMaxAgeSeconds"
+	ClusterStateInfoFile       = "ClusterStateInfoFile"
+	ClusterConfigFile          = "ClusterConfigFile"
+	ClusterLogHistory          = "ClusterLogHistory"
+	ECALScriptFolder           = "ECALScriptFolder"
+	ECALWorkerCount            = "ECALWorkerCount"
+	ECALEntryScript            = "ECALEntryScript"
+	ECALLogLevel               = "ECALLogLevel"
+	ECALLogFile                = "ECALLogFile"
+	ECALDebugServerHost        = "ECALDebugServerHost"
+	ECALDebugServerPort        = "ECALDebugServerPort"
+	EnableRequestLog           = "EnableRequestLog"
+	RequestLogLevel            = "RequestLogLevel"
+	RequestLogSink             = "RequestLogSink"
+	RequestLogFile             = "RequestLogFile"
+	ReadyMaxPendingTransfers   = "ReadyMaxPendingTransfers"
+	EnableTracing              = "EnableTracing"
+	EnableStorageTracing       = "EnableStorageTracing"
+	TracingSink                = "TracingSink"
+	TracingFile                = "TracingFile"
+	KeyObfuscationSecret       = "KeyObfuscationSecret"
+	EnableProjectionPolicy     = "EnableProjectionPolicy"
+	LocationProjectionPolicy   = "LocationProjectionPolicy"
+	EnableChangeLog            = "EnableChangeLog"
+	ChangeLogSize              = "ChangeLogSize"
+	ReplicaOf                  = "ReplicaOf"
+	ReplicaPollIntervalSeconds = "ReplicaPollIntervalSeconds"
+	ReplicaSkipTLSVerify       = "ReplicaSkipTLSVerify"
+	UserHistoryMaxEntries      = "UserHistoryMaxEntries"
+	WidgetSecret               = "WidgetSecret"
+	WidgetAllowedOrigins       = "WidgetAllowedOrigins"
+	SandboxPartitions          = "SandboxPartitions"
+	SandboxMaxRows             = "SandboxMaxRows"
+	SandboxRateLimit           = "SandboxRateLimit"
+	SandboxQueryTimeoutSeconds = "SandboxQueryTimeoutSeconds"
+	BootstrapManifest          = "BootstrapManifest"
+	CacheResizeIntervalSeconds = "CacheResizeIntervalSeconds"
+	GroupCommitLatencyMillis   = "GroupCommitLatencyMillis"
+	DurabilityMode             = "DurabilityMode"
+	DurabilitySyncSeconds      = "DurabilitySyncSeconds"
+	AuthBackend                = "AuthBackend"
+	LDAPConfigFile             = "LDAPConfigFile"
+	LocationTokenDB            = "LocationTokenDB"
+	CompactionIntervalSeconds  = "CompactionIntervalSeconds"
+	CompactionBatchSize        = "CompactionBatchSize"
+	CompactionPauseMillis      = "CompactionPauseMillis"
+	AdvertisedURL              = "AdvertisedURL"
+	ReplicaTimeoutSeconds      = "ReplicaTimeoutSeconds"
+	CompressionAlgorithm       = "CompressionAlgorithm"
+	CompressionPartitions      = "CompressionPartitions"
+	EncryptionKeyFile          = "EncryptionKeyFile"
+	StorageBackend             = "StorageBackend"
+	S3ConfigFile               = "S3ConfigFile"
+	StorageEngine              = "StorageEngine"
+	TraversalMaxVisitedNodes   = "TraversalMaxVisitedNodes"
+	TraversalCycleDetection    = "TraversalCycleDetection"
+	EQLWorkerCount             = "EQLWorkerCount"
+	EQLPlanCacheMaxSize        = "EQLPlanCacheMaxSize"
+	SnapshotFile               = "SnapshotFile"
+	SnapshotIntervalSeconds    = "SnapshotIntervalSeconds"
+	SnapshotCompression        = "SnapshotCompression"
+	HTTPCompression            = "HTTPCompression"
+	PageCacheSize              = "PageCacheSize"
+	ScheduleResultDir          = "ScheduleResultDir"
+	ScheduleSMTPServer         = "ScheduleSMTPServer"
+	ScheduleSMTPFrom           = "ScheduleSMTPFrom"
+	ScheduleSMTPUsername       = "ScheduleSMTPUsername"
+	ScheduleSMTPPassword       = "ScheduleSMTPPassword"
+	EnableScripts              = "EnableScripts"
+	EnableWebhooks             = "EnableWebhooks"
+	WebhookMaxRetries          = "WebhookMaxRetries"
+	WebhookTimeoutSeconds      = "WebhookTimeoutSeconds"
+	EnableCDC                  = "EnableCDC"
+	CDCConfigFile              = "CDCConfigFile"
+	TransactionMaxOperations   = "TransactionMaxOperations"
+	TransactionMaxBytes        = "TransactionMaxBytes"
+	KeyGeneration              = "KeyGeneration"
+	EnableDatabases            = "EnableDatabases"
+	LocationDatabases          = "LocationDatabases"
+	EnableQuotas               = "EnableQuotas"
+	QuotaRecountSeconds        = "QuotaRecountSeconds"
+	EnableRaft                 = "EnableRaft"
+	RaftBindAddress            = "RaftBindAddress"
+	RaftAdvertisedAddress      = "RaftAdvertisedAddress"
+	RaftBootstrap              = "RaftBootstrap"
+	RaftJoin                   = "RaftJoin"
+	LocationRaft               = "LocationRaft"
+	EnableSharding             = "EnableSharding"
+	ShardConfigFile            = "ShardConfigFile"
+	ShardName                  = "ShardName"
+	ShardSkipTLSVerify         = "ShardSkipTLSVerify"
+	ShardRemoteCallBudget      = "ShardRemoteCallBudget"
 )
 
 /*
 DefaultConfig is the defaut configuration
 */
 var DefaultConfig = map[string]interface{}{
-	MemoryOnlyStorage:        false,
-	EnableReadOnly:           false,
-	EnableECALScripts:        false,
-	EnableECALDebugServer:    false,
-	EnableWebFolder:          true,
-	EnableAccessControl:      false,
-	EnableWebTerminal:        true,
-	EnableCluster:            false,
-	EnableClusterTerminal:    false,
-	LocationDatastore:        "db",
-	LocationHTTPS:            "ssl",
-	LocationWebFolder:        "web",
-	LocationUserDB:           "users.db",
-	LocationAccessDB:         "access.db",
-	HTTPSHost:                "127.0.0.1",
-	HTTPSPort:                "9090",
-	CookieMaxAgeSeconds:      "86400",
-	HTTPSCertificate:         "cert.pem",
-	HTTPSKey:                 "key.pem",
-	LockFile:                 "eliasdb.lck",
-	ResultCacheMaxSize:       0,
-	ResultCacheMaxAgeSeconds: 0,
-	ClusterStateInfoFile:     "cluster.stateinfo",
-	ClusterConfigFile:        "cluster.config.json",
-	ClusterLogHistory:        100.0,
-	ECALScriptFolder:         "scripts",
-	ECALWorkerCount:          10,
-	ECALEntryScript:          "main.ecal",
-	ECALLogLevel:             "info",
-	ECALLogFile:              "",
-	ECALDebugServerHost:      "127.0.0.1",
-	ECALDebugServerPort:      "33274",
+	MemoryOnlyStorage:          false,
+	EnableReadOnly:             false,
+	EnableECALScripts:          false,
+	EnableECALDebugServer:      false,
+	EnableWebFolder:            true,
+	EnableAccessControl:        false,
+	EnableWebTerminal:          true,
+	EnableWebExplorer:          true,
+	EnableCluster:              false,
+	EnableClusterTerminal:      false,
+	LocationDatastore:          "db",
+	LocationHTTPS:              "ssl",
+	LocationWebFolder:          "web",
+	LocationUserDB:             "users.db",
+	LocationAccessDB:           "access.db",
+	HTTPSHost:                  "127.0.0.1",
+	HTTPSPort:                  "9090",
+	CookieMaxAgeSeconds:        "86400",
+	HTTPSCertificate:           "cert.pem",
+	HTTPSKey:                   "key.pem",
+	LockFile:                   "eliasdb.lck",
+	ResultCacheMaxSize:         0,
+	ResultCacheMaxAgeSeconds:   0,
+	ClusterStateInfoFile:       "cluster.stateinfo",
+	ClusterConfigFile:          "cluster.config.json",
+	ClusterLogHistory:          100.0,
+	ECALScriptFolder:           "scripts",
+	ECALWorkerCount:            10,
+	ECALEntryScript:            "main.ecal",
+	ECALLogLevel:               "info",
+	ECALLogFile:                "",
+	ECALDebugServerHost:        "127.0.0.1",
+	ECALDebugServerPort:        "33274",
+	EnableRequestLog:           false,
+	RequestLogLevel:            "info",
+	RequestLogSink:             "stdout",
+	RequestLogFile:             "requests.log",
+	ReadyMaxPendingTransfers:   1000,
+	EnableTracing:              false,
+	EnableStorageTracing:       false,
+	TracingSink:                "file",
+	TracingFile:                "traces.json",
+	KeyObfuscationSecret:       "",
+	EnableProjectionPolicy:     false,
+	LocationProjectionPolicy:   "projection.json",
+	EnableChangeLog:            false,
+	ChangeLogSize:              100000,
+	ReplicaOf:                  "",
+	ReplicaPollIntervalSeconds: 1,
+	ReplicaSkipTLSVerify:       false,
+	UserHistoryMaxEntries:      100,
+	WidgetSecret:               "",
+	WidgetAllowedOrigins:       "*",
+	SandboxPartitions:          "",
+	SandboxMaxRows:             100,
+	SandboxRateLimit:           30,
+	SandboxQueryTimeoutSeconds: 5,
+	BootstrapManifest:          "",
+	CacheResizeIntervalSeconds: 0,
+	GroupCommitLatencyMillis:   0,
+	DurabilityMode:             "sync",
+	DurabilitySyncSeconds:      1,
+	AuthBackend:                "local",
+	LDAPConfigFile:             "ldap.config.json",
+	LocationTokenDB:            "tokens.db",
+	CompactionIntervalSeconds:  0,
+	CompactionBatchSize:        1000,
+	CompactionPauseMillis:      10,
+	AdvertisedURL:              "",
+	ReplicaTimeoutSeconds:      30,
+	CompressionAlgorithm:       "none",
+	CompressionPartitions:      "",
+	EncryptionKeyFile:          "",
+	StorageBackend:             "local",
+	S3ConfigFile:               "s3.config.json",
+	StorageEngine:              "pages",
+	TraversalMaxVisitedNodes:   0,
+	TraversalCycleDetection:    false,
+	EQLWorkerCount:             1,
+	EQLPlanCacheMaxSize:        1000,
+	SnapshotFile:               "",
+	SnapshotIntervalSeconds:    0,
+	SnapshotCompression:        "none",
+	HTTPCompression:            "none",
+	PageCacheSize:              67108864,
+	ScheduleResultDir:          "",
+	ScheduleSMTPServer:         "",
+	ScheduleSMTPFrom:           "",
+	ScheduleSMTPUsername:       "",
+	ScheduleSMTPPassword:       "",
+	EnableScripts:              false,
+	EnableWebhooks:             false,
+	WebhookMaxRetries:          5,
+	WebhookTimeoutSeconds:      10,
+	EnableCDC:                  false,
+	CDCConfigFile:              "cdc.config.json",
+	TransactionMaxOperations:   0,
+	TransactionMaxBytes:        0,
+	KeyGeneration:              "uuid",
+	EnableDatabases:            false,
+	LocationDatabases:          "databases",
+	EnableQuotas:               false,
+	QuotaRecountSeconds:        300,
+	EnableRaft:                 false,
+	RaftBindAddress:            "127.0.0.1:9091",
+	RaftAdvertisedAddress:      "",
+	RaftBootstrap:              false,
+	RaftJoin:                   "",
+	LocationRaft:               "raft",
+	EnableSharding:             false,
+	ShardConfigFile:            "shards.config.json",
+	ShardName:                  "",
+	ShardSkipTLSVerify:         false,
+	ShardRemoteCallBudget:      0,
 }
 
 /*
@@ -0,0 +1,175 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+DynamicOptions are the configuration options which can be changed at runtime.
+Each option has a function which applies the current value of the option in
+Config. Options are registered by the server on startup.
+*/
+var DynamicOptions = make(map[string]func() error)
+
+/*
+dynamicLock serializes changes of dynamic options.
+*/
+var dynamicLock = &sync.Mutex{}
+
+/*
+DynamicValues returns the current values of all options which can be changed
+at runtime.
+*/
+func DynamicValues() map[string]interface{} {
+	dynamicLock.Lock()
+	defer dynamicLock.Unlock()
+
+	ret := make(map[string]interface{})
+
+	for k := range DynamicOptions {
+		ret[k] = Config[k]
+	}
+
+	return ret
+}
+
+/*
+UpdateDynamic changes options at runtime. The type of each new value must match
+the type of the default value of the option. Either all new values are applied
+or none. Returns the names of the changed options.
+*/
+func UpdateDynamic(values map[string]interface{}) ([]string, error) {
+	var changed []string
+
+	dynamicLock.Lock()
+	defer dynamicLock.Unlock()
+
+	newValues := make(map[string]interface{})
+
+	for k, v := range values {
+		if _, ok := DynamicOptions[k]; !ok {
+			if _, ok := DefaultConfig[k]; ok {
+				return nil, fmt.Errorf("Configuration option %v cannot be changed at runtime", k)
+			}
+			return nil, fmt.Errorf("Unknown configuration option: %v", k)
+		}
+
+		nv, err := normalizeValue(k, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if fmt.Sprint(nv) != fmt.Sprint(Config[k]) {
+			newValues[k] = nv
+			changed = append(changed, k)
+		}
+	}
+
+	sort.Strings(changed)
+
+	oldValues := make(map[string]interface{})
+
+	for i, k := range changed {
+		oldValues[k] = Config[k]
+		Config[k] = newValues[k]
+
+		if err := DynamicOptions[k](); err != nil {
+
+			// Restore all options which were changed so far
+
+			for _, rk := range changed[:i+1] {
+				Config[rk] = oldValues[rk]
+				DynamicOptions[rk]()
+			}
+
+			return nil, fmt.Errorf("Could not apply %v: %v", k, err)
+		}
+	}
+
+	return changed, nil
+}
+
+/*
+ReloadDynamic reads a config file and changes all options which can be changed
+at runtime. Returns the names of the changed options and the names of other
+changed options which require a restart.
+*/
+func ReloadDynamic(configfile string) ([]string, []string, error) {
+	var fileConfig map[string]interface{}
+	var restart []string
+
+	content, err := ioutil.ReadFile(configfile)
+
+	if err == nil {
+		err = json.Unmarshal(content, &fileConfig)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read config file %v: %v", configfile, err)
+	}
+
+	values := make(map[string]interface{})
+
+	for k, v := range fileConfig {
+		if _, ok := DynamicOptions[k]; ok {
+			values[k] = v
+		} else if _, ok := DefaultConfig[k]; ok && fmt.Sprint(v) != fmt.Sprint(Config[k]) {
+			restart = append(restart, k)
+		}
+	}
+
+	sort.Strings(restart)
+
+	changed, err := UpdateDynamic(values)
+
+	return changed, restart, err
+}
+
+/*
+normalizeValue checks a new value against the type of the default value of an
+option. Numbers are converted to the type of the default value.
+*/
+func normalizeValue(key string, value interface{}) (interface{}, error) {
+
+	switch DefaultConfig[key].(type) {
+
+	case bool:
+		if _, ok := value.(bool); ok {
+			return value, nil
+		}
+
+	case string:
+		if _, ok := value.(string); ok {
+			return value, nil
+		}
+
+	case int:
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			return int(f), nil
+		} else if i, ok := value.(int); ok {
+			return i, nil
+		}
+
+	case float64:
+		if f, ok := value.(float64); ok {
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Invalid value for configuration option %v: %v", key, value)
+}
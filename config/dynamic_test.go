@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDynamicConfig(t *testing.T) {
+	var applied []string
+
+	LoadDefaultConfig()
+
+	oldOptions := DynamicOptions
+	defer func() {
+		DynamicOptions = oldOptions
+		LoadDefaultConfig()
+	}()
+
+	DynamicOptions = map[string]func() error{
+		RequestLogLevel: func() error {
+			if Str(RequestLogLevel) == "foo" {
+				return fmt.Errorf("Invalid log level: foo")
+			}
+			applied = append(applied, Str(RequestLogLevel))
+			return nil
+		},
+		SandboxRateLimit: func() error {
+			applied = append(applied, fmt.Sprint(Int(SandboxRateLimit)))
+			return nil
+		},
+		TraversalCycleDetection: func() error {
+			applied = append(applied, fmt.Sprint(Bool(TraversalCycleDetection)))
+			return nil
+		},
+	}
+
+	if res := fmt.Sprint(DynamicValues()); res != "map[RequestLogLevel:info SandboxRateLimit:30 TraversalCycleDetection:false]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := UpdateDynamic(map[string]interface{}{"foo": 1}); err == nil ||
+		err.Error() != "Unknown configuration option: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := UpdateDynamic(map[string]interface{}{HTTPSPort: "1"}); err == nil ||
+		err.Error() != "Configuration option HTTPSPort cannot be changed at runtime" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := UpdateDynamic(map[string]interface{}{SandboxRateLimit: 1.5}); err == nil ||
+		err.Error() != "Invalid value for configuration option SandboxRateLimit: 1.5" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := UpdateDynamic(map[string]interface{}{TraversalCycleDetection: "true"}); err == nil ||
+		err.Error() != "Invalid value for configuration option TraversalCycleDetection: true" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Only changed options are applied
+
+	changed, err := UpdateDynamic(map[string]interface{}{
+		SandboxRateLimit:        10.0,
+		TraversalCycleDetection: false,
+	})
+
+	if err != nil || fmt.Sprint(changed) != "[SandboxRateLimit]" || fmt.Sprint(applied) != "[10]" {
+		t.Error("Unexpected result:", changed, applied, err)
+		return
+	}
+
+	if res := Int(SandboxRateLimit); res != 10 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// All options are restored if an option cannot be applied
+
+	applied = nil
+
+	if _, err := UpdateDynamic(map[string]interface{}{
+		RequestLogLevel:  "foo",
+		SandboxRateLimit: 20.0,
+	}); err == nil || err.Error() != "Could not apply RequestLogLevel: Invalid log level: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := fmt.Sprint(Str(RequestLogLevel), Int(SandboxRateLimit), applied); res != "info10 [info]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Reload options from a config file
+
+	applied = nil
+
+	ioutil.WriteFile(testconf, []byte(`{
+    "HTTPSPort": "9091",
+    "RequestLogLevel": "debug",
+    "SandboxRateLimit": 10,
+    "TraversalCycleDetection": true
+}`), 0644)
+
+	defer os.Remove(testconf)
+
+	changed, restart, err := ReloadDynamic(testconf)
+
+	if err != nil || fmt.Sprint(changed, restart, applied) != "[RequestLogLevel TraversalCycleDetection] [HTTPSPort] [debug true]" {
+		t.Error("Unexpected result:", changed, restart, applied, err)
+		return
+	}
+
+	if _, _, err := ReloadDynamic(invalidFileName); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -176,6 +176,72 @@ func (c *CmdExport) Run(args []string, capi CommandConsoleAPI) error {
 	return c.exportFunc(args, capi.ExportBuffer())
 }
 
+// Command: format
+// ===============
+
+/*
+CommandFormat is a command name.
+*/
+const CommandFormat = "format"
+
+/*
+Output formats for query results
+*/
+const (
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+)
+
+/*
+CmdFormat displays or sets the output format for query results.
+*/
+type CmdFormat struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdFormat) Name() string {
+	return CommandFormat
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdFormat) ShortDescription() string {
+	return "Displays or sets the output format for query results."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdFormat) LongDescription() string {
+	return "Displays or sets the output format for query results. Query results can be " +
+		"written as table, csv or json (a list of objects with the column labels as keys)."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdFormat) Run(args []string, capi CommandConsoleAPI) error {
+
+	if len(args) == 0 {
+		fmt.Fprintln(capi.Out(), capi.Format())
+		return nil
+	}
+
+	if args[0] != FormatTable && args[0] != FormatCSV && args[0] != FormatJSON {
+		return fmt.Errorf("Unknown format: %s (use %s, %s or %s)", args[0],
+			FormatTable, FormatCSV, FormatJSON)
+	}
+
+	capi.SetFormat(args[0])
+	fmt.Fprintln(capi.Out(), fmt.Sprintf("Current format is: %s", args[0]))
+
+	return nil
+}
+
 // Command: login
 // ==============
 
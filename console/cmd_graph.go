@@ -11,9 +11,17 @@
 package console
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/krotik/common/stringutil"
@@ -60,10 +68,11 @@ Run executes the command.
 */
 func (c *CmdInfo) Run(args []string, capi CommandConsoleAPI) error {
 
-	res, err := capi.Req(v1.EndpointInfoQuery, "GET", nil)
+	data, err := capi.ConsoleCommand(map[string]interface{}{
+		"cmd": "describe",
+	})
 
 	if err == nil {
-		var data = res.(map[string]interface{})
 		var keys, tab []string
 
 		tab = append(tab, "Kind")
@@ -236,3 +245,313 @@ func (c *CmdFind) Run(args []string, capi CommandConsoleAPI) error {
 
 	return nil
 }
+
+// Command: import
+// ===============
+
+/*
+CommandImport is a command name.
+*/
+const CommandImport = "import"
+
+/*
+CmdImport imports nodes and edges from a file.
+*/
+type CmdImport struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdImport) Name() string {
+	return CommandImport
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdImport) ShortDescription() string {
+	return "Imports nodes and edges from a file."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdImport) LongDescription() string {
+	return "Imports nodes and edges from a JSON file into the current partition. " +
+		"A zip file can contain a JSON file for each partition (e.g. main.json)."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdImport) Run(args []string, capi CommandConsoleAPI) error {
+
+	if len(args) < 1 {
+		return fmt.Errorf("Please specify a file")
+	}
+
+	if strings.ToLower(filepath.Ext(args[0])) != ".zip" {
+		content, err := ioutil.ReadFile(args[0])
+
+		if err == nil {
+			err = importPartition(content, capi.Partition(), capi)
+		}
+
+		return err
+	}
+
+	zipFile, err := zip.OpenReader(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer zipFile.Close()
+
+	for _, file := range zipFile.File {
+		var in io.ReadCloser
+		var content []byte
+
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		part := strings.TrimSuffix(filepath.Base(file.Name), filepath.Ext(file.Name))
+
+		if in, err = file.Open(); err == nil {
+			content, err = ioutil.ReadAll(in)
+			in.Close()
+		}
+
+		if err == nil {
+			err = importPartition(content, part, capi)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+importPartition stores the nodes and edges of an exported partition.
+*/
+func importPartition(content []byte, part string, capi CommandConsoleAPI) error {
+	var gdata map[string][]interface{}
+
+	if err := json.Unmarshal(content, &gdata); err != nil {
+		return fmt.Errorf("Could not decode data for partition %s: %v", part, err)
+	}
+
+	_, err := capi.Req(v1.EndpointGraph+url.PathEscape(part), "POST", content)
+
+	if err == nil {
+		fmt.Fprintln(capi.Out(), fmt.Sprintf("Imported %v nodes and %v edges into partition %s",
+			len(gdata["nodes"]), len(gdata["edges"]), part))
+	}
+
+	return err
+}
+
+// Command: dump
+// =============
+
+/*
+CommandDump is a command name.
+*/
+const CommandDump = "dump"
+
+/*
+DumpPageSize is the number of nodes which are requested at once by the dump command.
+*/
+var DumpPageSize = 1000
+
+/*
+CmdDump writes all nodes and edges to a file.
+*/
+type CmdDump struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdDump) Name() string {
+	return CommandDump
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdDump) ShortDescription() string {
+	return "Writes all nodes and edges to a file."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdDump) LongDescription() string {
+	return "Writes all nodes and edges of the current partition to a JSON file. " +
+		"All partitions are written if the file name ends with .zip. The files can be read by the import command."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdDump) Run(args []string, capi CommandConsoleAPI) error {
+	var buf bytes.Buffer
+
+	if len(args) < 1 {
+		return fmt.Errorf("Please specify a file")
+	}
+
+	if strings.ToLower(filepath.Ext(args[0])) != ".zip" {
+		err := dumpPartition(&buf, capi.Partition(), capi)
+
+		if err == nil {
+			err = ioutil.WriteFile(args[0], buf.Bytes(), 0666)
+		}
+
+		return err
+	}
+
+	res, err := capi.Req(v1.EndpointInfoQuery, "GET", nil)
+	if err != nil {
+		return err
+	}
+
+	zipWriter := zip.NewWriter(&buf)
+
+	for _, part := range res.(map[string]interface{})["partitions"].([]interface{}) {
+		var w io.Writer
+
+		if w, err = zipWriter.Create(fmt.Sprintf("%v.json", part)); err == nil {
+			err = dumpPartition(w, fmt.Sprint(part), capi)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = zipWriter.Close(); err == nil {
+		err = ioutil.WriteFile(args[0], buf.Bytes(), 0666)
+	}
+
+	return err
+}
+
+/*
+dumpPartition writes all nodes and edges of a partition in the format of
+graph.ExportPartition. Edges are collected by traversing from all nodes.
+*/
+func dumpPartition(out io.Writer, part string, capi CommandConsoleAPI) error {
+	var nodes, edges []interface{}
+
+	edgeKeys := make(map[string]bool)
+
+	res, err := capi.Req(v1.EndpointInfoQuery, "GET", nil)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range res.(map[string]interface{})["node_kinds"].([]interface{}) {
+		var kindNodes []interface{}
+
+		if kindNodes, err = dumpNodes(part, fmt.Sprint(kind), capi); err != nil {
+			return err
+		}
+
+		for _, n := range kindNodes {
+			var tres interface{}
+
+			node := n.(map[string]interface{})
+
+			nodes = append(nodes, node)
+
+			if tres, err = capi.Req(fmt.Sprintf("%s%s/n/%s/%s/:::", v1.EndpointGraph, url.PathEscape(part),
+				url.PathEscape(fmt.Sprint(kind)), url.PathEscape(fmt.Sprint(node["key"]))), "GET", nil); err != nil {
+				return err
+			}
+
+			for _, e := range tres.([]interface{})[1].([]interface{}) {
+				edge := e.(map[string]interface{})
+				edgeKey := fmt.Sprint(edge["kind"], "#", edge["key"])
+
+				if !edgeKeys[edgeKey] {
+					edgeKeys[edgeKey] = true
+					edges = append(edges, edge)
+				}
+			}
+		}
+	}
+
+	if nodes == nil {
+		nodes = []interface{}{}
+	}
+
+	if edges == nil {
+		edges = []interface{}{}
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"nodes": nodes,
+		"edges": edges,
+	}, "", "  ")
+
+	if err == nil {
+		if _, err = out.Write(data); err == nil {
+			fmt.Fprintln(capi.Out(), fmt.Sprintf("Dumped %v nodes and %v edges of partition %s",
+				len(nodes), len(edges), part))
+		}
+	}
+
+	return err
+}
+
+/*
+dumpNodes reads all nodes of a kind in pages. The pages are read from a
+snapshot of the node keys so nodes are neither skipped nor repeated if the
+data changes in the meantime.
+*/
+func dumpNodes(part string, kind string, capi CommandConsoleAPI) ([]interface{}, error) {
+	var nodes []interface{}
+
+	snapshot := "true"
+
+	for offset := 0; ; offset += DumpPageSize {
+		var page []interface{}
+
+		endpoint := fmt.Sprintf("%s%s/n/%s?offset=%v&limit=%v&snapshot=%s", v1.EndpointGraph,
+			url.PathEscape(part), url.PathEscape(kind), offset, DumpPageSize, snapshot)
+
+		body, resp, err := capi.SendRequest(endpoint, "application/json", "GET", nil, nil)
+
+		if err != nil {
+			return nil, err
+
+		} else if resp.StatusCode == http.StatusBadRequest && offset == 0 {
+
+			// The kind does not exist in the partition
+
+			return nil, nil
+
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, &CommError{fmt.Errorf("GET request to %s failed: %s", endpoint, body), resp}
+		}
+
+		if err = json.Unmarshal([]byte(body), &page); err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, page...)
+		snapshot = resp.Header.Get(v1.HTTPHeaderSnapshotID)
+
+		if total, _ := strconv.Atoi(resp.Header.Get(v1.HTTPHeaderTotalCount)); offset+DumpPageSize >= total {
+			break
+		}
+	}
+
+	return nodes, nil
+}
@@ -12,8 +12,12 @@ package console
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/krotik/common/httputil/auth"
 	"github.com/krotik/eliasdb/config"
 )
 
@@ -200,3 +204,181 @@ Kind      Producer
 	out.Reset()
 
 }
+
+func TestDataCommands(t *testing.T) {
+	var out bytes.Buffer
+
+	ResetDB()
+	credGiver.Reset()
+	createSongGraph()
+
+	c := NewConsole("http://localhost"+TESTPORT, &out, credGiver.GetCredentials,
+		func() string { return "***pass***" },
+		func(args []string, e *bytes.Buffer) error {
+			return nil
+		})
+
+	auth.TestCookieAuthDisabled = true
+	defer func() {
+		auth.TestCookieAuthDisabled = false
+	}()
+
+	DumpPageSize = 5
+	defer func() {
+		DumpPageSize = 1000
+	}()
+
+	dir, err := ioutil.TempDir("", "consoletest")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Query results can be written as CSV or JSON
+
+	if ok, err := c.Run("format"); !ok || err != nil || out.String() != "table\n" {
+		t.Error(ok, err, out.String())
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("format xml"); ok || err == nil || err.Error() != "Unknown format: xml (use table, csv or json)" {
+		t.Error(ok, err)
+		return
+	}
+
+	if ok, err := c.Run("format csv"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("get Author where key = '000' show key as k, desc as d"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+k,d
+000,A lonely artisT
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	c.Run("format json")
+	out.Reset()
+
+	if ok, err := c.Run("get Author where key = '000' show key as k, desc as d"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+[
+  {
+    "d": "A lonely artisT",
+    "k": "000"
+  }
+]
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Dump a partition and import it into another partition
+
+	if ok, err := c.Run("dump"); ok || err == nil || err.Error() != "Please specify a file" {
+		t.Error(ok, err)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("dump " + filepath.Join(dir, "main.json")); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != "Dumped 33 nodes and 9 edges of partition main\n" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	c.Run("part copy")
+	out.Reset()
+
+	if ok, err := c.Run("import " + filepath.Join(dir, "main.json")); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != "Imported 33 nodes and 9 edges into partition copy\n" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("get Song where name = 'Aria3' traverse ::: end show 2:n:key as k"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+[
+  {
+    "k": "000"
+  }
+]
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A zip file contains all partitions
+
+	out.Reset()
+
+	if ok, err := c.Run("dump " + filepath.Join(dir, "all.zip")); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+Dumped 33 nodes and 9 edges of partition copy
+Dumped 33 nodes and 9 edges of partition main
+Dumped 1 nodes and 0 edges of partition second
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	ResetDB()
+	out.Reset()
+
+	if ok, err := c.Run("import " + filepath.Join(dir, "all.zip")); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+Imported 33 nodes and 9 edges into partition copy
+Imported 33 nodes and 9 edges into partition main
+Imported 1 nodes and 0 edges into partition second
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "invalid.json"), []byte("foo"), 0666)
+
+	if ok, err := c.Run("import " + filepath.Join(dir, "invalid.json")); ok || err == nil ||
+		err.Error() != "Could not decode data for partition copy: invalid character 'o' in literal false (expecting 'a')" {
+		t.Error(ok, err)
+		return
+	}
+}
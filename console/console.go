@@ -24,8 +24,10 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/gorilla/websocket"
 	"github.com/krotik/common/errorutil"
 	"github.com/krotik/eliasdb/api/ac"
+	"github.com/krotik/eliasdb/api/v1"
 	"github.com/krotik/eliasdb/config"
 )
 
@@ -65,6 +67,9 @@ func NewConsole(url string, out io.Writer, getCredentials func() (string, string
 	cmdMap[CommandInfo] = &CmdInfo{}
 	cmdMap[CommandPart] = &CmdPart{}
 	cmdMap[CommandFind] = &CmdFind{}
+	cmdMap[CommandFormat] = &CmdFormat{}
+	cmdMap[CommandImport] = &CmdImport{}
+	cmdMap[CommandDump] = &CmdDump{}
 
 	// Add export if we got an export function
 
@@ -72,8 +77,8 @@ func NewConsole(url string, out io.Writer, getCredentials func() (string, string
 		cmdMap[CommandExport] = &CmdExport{exportFunc}
 	}
 
-	c := &EliasDBConsole{url, "main", out, bytes.NewBuffer(nil), nil,
-		nil, false, cmdMap, getCredentials, getPassword}
+	c := &EliasDBConsole{url, "main", FormatTable, out, bytes.NewBuffer(nil), nil,
+		nil, false, nil, 0, cmdMap, getCredentials, getPassword}
 
 	c.childConsoles = []CommandConsole{&EQLConsole{c}, &GraphQLConsole{c}}
 
@@ -123,6 +128,16 @@ type CommandConsoleAPI interface {
 	*/
 	SetPartition(string)
 
+	/*
+	   Format returns the current output format for query results.
+	*/
+	Format() string
+
+	/*
+	   SetFormat sets the current output format for query results.
+	*/
+	SetFormat(string)
+
 	/*
 	   AskPassword asks the user for a password.
 	*/
@@ -143,6 +158,12 @@ type CommandConsoleAPI interface {
 	SendRequest(endpoint string, contentType string, method string,
 		content []byte, reqMod func(*http.Request)) (string, *http.Response, error)
 
+	/*
+	   ConsoleCommand sends a command to the console endpoint of the connected
+	   server and returns the payload of the result.
+	*/
+	ConsoleCommand(cmd map[string]interface{}) (map[string]interface{}, error)
+
 	/*
 		Out returns a writer which can be used to write to the console.
 	*/
@@ -204,6 +225,7 @@ type EliasDBConsole struct {
 	url string // Current server url (e.g. http://localhost:9090)
 
 	part          string           // Current partition
+	format        string           // Current output format for query results
 	out           io.Writer        // Output for this console
 	export        *bytes.Buffer    // Export buffer
 	childConsoles []CommandConsole // List of child consoles
@@ -211,6 +233,9 @@ type EliasDBConsole struct {
 	authCookie *http.Cookie // User token
 	credsAsked bool         // Flag if the credentials have been asked
 
+	conn   *websocket.Conn // Connection to the console endpoint (opened on first use)
+	connID int             // Counter for console command IDs
+
 	CommandMap     map[string]Command      // Map of registered commands
 	GetCredentials func() (string, string) // Ask the user for credentials
 	GetPassword    func() string           // Ask the user for a password
@@ -244,6 +269,20 @@ func (c *EliasDBConsole) SetPartition(part string) {
 	c.part = part
 }
 
+/*
+Format returns the current output format for query results.
+*/
+func (c *EliasDBConsole) Format() string {
+	return c.format
+}
+
+/*
+SetFormat sets the current output format for query results.
+*/
+func (c *EliasDBConsole) SetFormat(format string) {
+	c.format = format
+}
+
 /*
 AskPassword asks the user for a password.
 */
@@ -324,11 +363,12 @@ func (c *EliasDBConsole) RunCommand(cmdString string) (bool, error) {
 				// Special command "logout" to remove the current auth token
 
 				c.authCookie = nil
+				c.closeConsole()
 
 				fmt.Fprintln(c.out, "Current user logged out.")
 
 			} else if cmd != "ver" && cmd != "whoami" && cmd != "help" &&
-				cmd != "?" && cmd != "export" {
+				cmd != "?" && cmd != "export" && cmd != "format" {
 
 				// Do not authenticate if running local commands
 
@@ -407,6 +447,11 @@ func (c *EliasDBConsole) Authenticate(force bool) {
 					fmt.Fprintln(c.out, "Login as user", user)
 					c.authCookie = resp.Cookies()[0]
 					c.credsAsked = true
+
+					// The console connection needs to be opened with the new token
+
+					c.closeConsole()
+
 					return
 				}
 			}
@@ -507,6 +552,141 @@ func (c *EliasDBConsole) SendRequest(endpoint string, contentType string, method
 	return bodyStr, resp, err
 }
 
+/*
+ConsoleCommand sends a command to the console endpoint of the connected
+server and returns the payload of the result. The connection is opened on
+first use. Progress messages of long running commands are written to the
+console output.
+*/
+func (c *EliasDBConsole) ConsoleCommand(cmd map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+
+	c.connID++
+	id := fmt.Sprint(c.connID)
+	cmd["id"] = id
+
+	// Try to reopen the connection once if it was closed by the server
+
+	for i := 0; i < 2; i++ {
+
+		if c.conn == nil {
+			if err = c.openConsole(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = c.conn.WriteJSON(cmd); err == nil {
+			break
+		}
+
+		c.closeConsole()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var msg map[string]interface{}
+
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			c.closeConsole()
+			return nil, err
+		}
+
+		if msg["id"] != id {
+			continue
+		}
+
+		payload, _ := msg["payload"].(map[string]interface{})
+
+		switch msg["type"] {
+
+		case "progress":
+			fmt.Fprintln(c.out, fmt.Sprintf("Running (%vms) ...", payload["elapsed_ms"]))
+
+		case "error":
+			return nil, fmt.Errorf("%v", payload["message"])
+
+		case "closed":
+			c.closeConsole()
+			return payload, nil
+
+		default:
+			return payload, nil
+		}
+	}
+}
+
+/*
+openConsole opens a connection to the console endpoint of the server.
+*/
+func (c *EliasDBConsole) openConsole() error {
+
+	url := c.url + v1.EndpointConsole
+
+	if strings.HasPrefix(url, "http") {
+		url = "ws" + url[4:]
+	}
+
+	header := http.Header{}
+
+	if c.authCookie != nil {
+		header.Add("Cookie", (&http.Cookie{Name: c.authCookie.Name, Value: c.authCookie.Value}).String())
+	}
+
+	// Console client does not verify the SSL keys
+
+	dialer := &websocket.Dialer{
+		Subprotocols:    []string{"eliasdb-console"},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	conn, resp, err := dialer.Dial(url, header)
+
+	if err != nil {
+
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			return &CommError{
+				fmt.Errorf("Console connection to %s failed: %s", v1.EndpointConsole,
+					strings.Trim(string(body), " \n")),
+				resp,
+			}
+		}
+
+		return err
+	}
+
+	// Wait for the server to be ready
+
+	var msg map[string]interface{}
+
+	if err = conn.ReadJSON(&msg); err == nil && msg["type"] != "ready" {
+		err = fmt.Errorf("Unexpected console message: %v", msg)
+	}
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.conn = conn
+
+	return nil
+}
+
+/*
+closeConsole closes the connection to the console endpoint of the server.
+*/
+func (c *EliasDBConsole) closeConsole() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
 // Util functions
 // ==============
 
@@ -326,13 +326,16 @@ func TestDescriptions(t *testing.T) {
 	}
 
 	if res := out.String(); res != `
+Writes all nodes and edges of the current partition to a JSON file. All partitions are written if the file name ends with .zip. The files can be read by the import command.
 Exports the data which is currently in the export buffer. The export buffer is filled with the previous command output in a machine readable form.
 Do a full-text search of the database.
+Displays or sets the output format for query results. Query results can be written as table, csv or json (a list of objects with the column labels as keys).
 Grants a new permission to a group. Specify first the permission in CRUD format (Create, Read, Update or Delete), then a resource path and then a group name.
 Adds a group to the system.
 Removes a group from the system.
 Returns a list of all groups and their permissions.
 Display descriptions for all available commands.
+Imports nodes and edges from a JSON file into the current partition. A zip file can contain a JSON file for each partition (e.g. main.json).
 Returns general database information such as known node kinds, known attributes, etc ...
 Joins a user to a group.
 Removes a user from a group.
@@ -468,9 +471,12 @@ Writer, 1
 
 	if res := out.String(); res != `
 Command Description
+dump    Writes all nodes and edges to a file.
 export  Exports the last output.
 find    Do a full-text search of the database.
+format  Displays or sets the output format for query results.
 help    Display descriptions for all available commands.
+import  Imports nodes and edges from a file.
 info    Returns general database information.
 part    Displays or sets the current partition.
 ver     Displays server version information.
@@ -488,9 +494,12 @@ ver     Displays server version information.
 
 	if res := out.String(); res != `
 Command Description
+dump    Writes all nodes and edges to a file.
 export  Exports the last output.
 find    Do a full-text search of the database.
+format  Displays or sets the output format for query results.
 help    Display descriptions for all available commands.
+import  Imports nodes and edges from a file.
 info    Returns general database information.
 part    Displays or sets the current partition.
 ver     Displays server version information.
@@ -566,13 +575,16 @@ Login as user elias
 
 	if res := out.String(); res != `
 Command    Description
+dump       Writes all nodes and edges to a file.
 export     Exports the last output.
 find       Do a full-text search of the database.
+format     Displays or sets the output format for query results.
 grantperm  Grants a new permission to a group.
 groupadd   Adds a group to the system.
 groupdel   Removes a group from the system.
 groups     Returns a list of all groups and their permissions.
 help       Display descriptions for all available commands.
+import     Imports nodes and edges from a file.
 info       Returns general database information.
 joingroup  Joins a user to a group.
 leavegroup Removes a user from a group.
@@ -11,11 +11,11 @@
 package console
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"net/url"
 
 	"github.com/krotik/common/stringutil"
-	"github.com/krotik/eliasdb/api/v1"
 )
 
 // EQL Console
@@ -43,15 +43,15 @@ func (c *EQLConsole) Run(cmd string) (bool, error) {
 		return false, nil
 	}
 
-	// Escape query so it can be used in a request
+	// Run the query via the console endpoint
 
-	q := url.QueryEscape(cmd)
+	res, err := c.parent.ConsoleCommand(map[string]interface{}{
+		"cmd":       "query",
+		"query":     cmd,
+		"partition": c.parent.Partition(),
+	})
 
-	resObj, err := c.parent.Req(
-		fmt.Sprintf("%s%s?q=%s", v1.EndpointQuery, c.parent.Partition(), q), "GET", nil)
-
-	if err == nil && resObj != nil {
-		res := resObj.(map[string]interface{})
+	if err == nil {
 		var out []string
 
 		header := res["header"].(map[string]interface{})
@@ -59,6 +59,28 @@ func (c *EQLConsole) Run(cmd string) (bool, error) {
 		labels := header["labels"].([]interface{})
 		data := header["data"].([]interface{})
 		rows := res["rows"].([]interface{})
+		total := int(res["total"].(float64))
+
+		// Fetch all remaining pages of the result
+
+		for len(rows) < total {
+			var page map[string]interface{}
+
+			if page, err = c.parent.ConsoleCommand(map[string]interface{}{
+				"cmd":       "page",
+				"result_id": res["result_id"],
+				"offset":    len(rows),
+			}); err != nil {
+				return true, err
+			}
+
+			prows := page["rows"].([]interface{})
+			if len(prows) == 0 {
+				break
+			}
+
+			rows = append(rows, prows...)
+		}
 
 		for _, l := range labels {
 			out = append(out, fmt.Sprint(l))
@@ -73,12 +95,66 @@ func (c *EQLConsole) Run(cmd string) (bool, error) {
 		}
 
 		c.parent.ExportBuffer().WriteString(stringutil.PrintCSVTable(out, len(labels)))
-		fmt.Fprint(c.parent.Out(), stringutil.PrintGraphicStringTable(out, len(labels), 2, stringutil.SingleLineTable))
+
+		switch c.parent.Format() {
+
+		case FormatCSV:
+			err = c.writeCSV(out[:len(labels)], out[2*len(labels):])
+
+		case FormatJSON:
+			err = c.writeJSON(labels, rows)
+
+		default:
+			fmt.Fprint(c.parent.Out(), stringutil.PrintGraphicStringTable(out, len(labels), 2, stringutil.SingleLineTable))
+		}
 	}
 
 	return true, err
 }
 
+/*
+writeCSV writes a query result as CSV to the console output.
+*/
+func (c *EQLConsole) writeCSV(labels []string, cells []string) error {
+	w := csv.NewWriter(c.parent.Out())
+
+	w.Write(labels)
+
+	for i := 0; i < len(cells); i += len(labels) {
+		w.Write(cells[i : i+len(labels)])
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+/*
+writeJSON writes a query result as a list of objects to the console output.
+The column labels are used as keys.
+*/
+func (c *EQLConsole) writeJSON(labels []interface{}, rows []interface{}) error {
+	res := make([]map[string]interface{}, 0, len(rows))
+
+	for _, r := range rows {
+		obj := make(map[string]interface{})
+
+		for i, c := range r.([]interface{}) {
+			obj[fmt.Sprint(labels[i])] = c
+		}
+
+		res = append(res, obj)
+	}
+
+	out, err := json.MarshalIndent(res, "", "  ")
+
+	if err == nil {
+		fmt.Fprintln(c.parent.Out(), string(out))
+	}
+
+	return err
+}
+
 /*
 Commands returns an empty list. The command line is interpreted as an EQL query.
 */
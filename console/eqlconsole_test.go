@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/krotik/eliasdb/api/v1"
 	"github.com/krotik/eliasdb/config"
 )
 
@@ -69,6 +70,13 @@ Login as user elias
 
 	out.Reset()
 
+	// Results are fetched in pages from the console endpoint
+
+	v1.ConsolePageSize = 4
+	defer func() {
+		v1.ConsolePageSize = 50
+	}()
+
 	if ok, err := c.Run("get Song"); !ok || err != nil {
 		t.Error(ok, err)
 		return
@@ -0,0 +1,100 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package databases contains a manager for additional isolated databases which
+are hosted by a single server instance.
+
+Each database has its own graph storage and therefore its own partitions. A
+database can be restricted to a list of users and can have quotas for the
+number of stored nodes and edges, the size of the stored data and the number
+of requests per minute.
+
+Database definitions are stored as nodes in a partition of the main graph so
+they survive restarts.
+*/
+package databases
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/krotik/eliasdb/quota"
+)
+
+/*
+reservedNames are names which cannot be used for databases since they would
+clash with the paths of the main REST API.
+*/
+var reservedNames = map[string]bool{
+	"v1": true,
+}
+
+/*
+databaseNameRegexp is the pattern of valid database names.
+*/
+var databaseNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+/*
+Database is the definition of an isolated database.
+*/
+type Database struct {
+	Name                 string   `json:"name"`                              // Name of the database
+	Users                []string `json:"users,omitempty"`                   // Users who may access the database (empty for all users)
+	MaxNodes             int64    `json:"max_nodes,omitempty"`               // Maximum number of stored nodes (0 for no limit)
+	MaxEdges             int64    `json:"max_edges,omitempty"`               // Maximum number of stored edges (0 for no limit)
+	MaxBytes             int64    `json:"max_bytes,omitempty"`               // Maximum estimated size of the stored data in bytes (0 for no limit)
+	MaxRequestsPerMinute int      `json:"max_requests_per_minute,omitempty"` // Maximum number of requests per minute (0 for no limit)
+}
+
+/*
+Validate checks a database definition.
+*/
+func (db *Database) Validate() error {
+
+	if !databaseNameRegexp.MatchString(db.Name) {
+		return fmt.Errorf("Invalid database name (allowed are letters, digits, - and _): %v", db.Name)
+	}
+
+	if reservedNames[db.Name] {
+		return fmt.Errorf("Reserved database name: %v", db.Name)
+	}
+
+	if db.MaxNodes < 0 || db.MaxEdges < 0 || db.MaxBytes < 0 || db.MaxRequestsPerMinute < 0 {
+		return fmt.Errorf("Quotas of database %v must not be negative", db.Name)
+	}
+
+	return nil
+}
+
+/*
+Allows checks if a user may access this database.
+*/
+func (db *Database) Allows(user string) bool {
+
+	if len(db.Users) == 0 {
+		return true
+	}
+
+	for _, u := range db.Users {
+		if u == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Limits returns the storage quotas of this database.
+*/
+func (db *Database) Limits() *quota.Limits {
+	return &quota.Limits{MaxNodes: db.MaxNodes, MaxEdges: db.MaxEdges, MaxBytes: db.MaxBytes}
+}
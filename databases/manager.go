@@ -0,0 +1,343 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package databases
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/quota"
+)
+
+/*
+DatabaseNodeKind is the node kind which stores database definitions.
+*/
+const DatabaseNodeKind = "database"
+
+/*
+OpenFunc opens the graph storage of a database. The storage should be created
+if it does not exist.
+*/
+type OpenFunc func(name string) (graphstorage.Storage, error)
+
+/*
+RemoveFunc removes the closed graph storage of a database.
+*/
+type RemoveFunc func(name string) error
+
+/*
+instance is an open database.
+*/
+type instance struct {
+	db       *Database            // Definition of the database
+	gs       graphstorage.Storage // Graph storage of the database
+	gm       *graph.Manager       // Graph manager of the database
+	quota    *quota.Manager       // Quota manager of the database
+	window   int64                // Current minute of the request quota
+	requests int                  // Number of requests in the current minute
+}
+
+/*
+Manager manages isolated databases.
+*/
+type Manager struct {
+	gm        *graph.Manager       // Graph manager which stores the database definitions
+	part      string               // Partition which stores the database definitions
+	open      OpenFunc             // Function to open the storage of a database
+	remove    RemoveFunc           // Function to remove the storage of a database
+	instances map[string]*instance // Open databases by name
+	lock      *sync.RWMutex        // Lock for the open databases
+}
+
+/*
+NewManager creates a new database manager and opens all databases which are
+defined in a given partition.
+*/
+func NewManager(gm *graph.Manager, part string, open OpenFunc, remove RemoveFunc) (*Manager, error) {
+
+	m := &Manager{gm, part, open, remove, make(map[string]*instance), &sync.RWMutex{}}
+
+	it, err := gm.NodeKeyIterator(part, DatabaseNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var node data.Node
+
+			if node, err = gm.FetchNode(part, key, DatabaseNodeKind); err == nil && node != nil {
+				db := &Database{}
+
+				if err = json.Unmarshal([]byte(fmt.Sprint(node.Attr("data"))), db); err == nil {
+					var inst *instance
+
+					if inst, err = m.start(db); err == nil {
+						m.instances[db.Name] = inst
+					}
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+Close closes all databases.
+*/
+func (m *Manager) Close() error {
+	var errors []string
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for name, inst := range m.instances {
+		inst.quota.Close()
+
+		if err := inst.gs.Close(); err != nil {
+			errors = append(errors, fmt.Sprintf("%v: %v", name, err))
+		}
+	}
+
+	m.instances = make(map[string]*instance)
+
+	if errors != nil {
+		return fmt.Errorf("Could not close databases: %v", errors)
+	}
+
+	return nil
+}
+
+/*
+Databases returns all database definitions sorted by name.
+*/
+func (m *Manager) Databases() []*Database {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	ret := make([]*Database, 0, len(m.instances))
+
+	for _, inst := range m.instances {
+		ret = append(ret, inst.db)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+Database returns the definition and the graph manager of a database. Returns
+nil values if the database does not exist.
+*/
+func (m *Manager) Database(name string) (*Database, *graph.Manager) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if inst, ok := m.instances[name]; ok {
+		return inst.db, inst.gm
+	}
+
+	return nil, nil
+}
+
+/*
+Quota returns the quota manager of a database. Returns nil if the database
+does not exist.
+*/
+func (m *Manager) Quota(name string) *quota.Manager {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if inst, ok := m.instances[name]; ok {
+		return inst.quota
+	}
+
+	return nil
+}
+
+/*
+NodeCount returns the number of nodes which are stored in a database.
+*/
+func (m *Manager) NodeCount(name string) uint64 {
+
+	if _, gm := m.Database(name); gm != nil {
+		return nodeCount(gm)
+	}
+
+	return 0
+}
+
+/*
+Allow records a request for a database. Returns false if the request quota
+of the database is exceeded or if the database does not exist.
+*/
+func (m *Manager) Allow(name string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	inst, ok := m.instances[name]
+	if !ok {
+		return false
+	}
+
+	if window := time.Now().Unix() / 60; window != inst.window {
+		inst.window = window
+		inst.requests = 0
+	}
+
+	if max := inst.db.MaxRequestsPerMinute; max > 0 && inst.requests >= max {
+		return false
+	}
+
+	inst.requests++
+
+	return true
+}
+
+/*
+StoreDatabase validates and stores a database definition. A new database is
+created with an empty graph storage. The users and quotas of an existing
+database are replaced.
+*/
+func (m *Manager) StoreDatabase(db *Database) error {
+
+	if err := db.Validate(); err != nil {
+		return err
+	}
+
+	databaseJSON, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	inst, exists := m.instances[db.Name]
+
+	if !exists {
+		if inst, err = m.start(db); err != nil {
+			return err
+		}
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, db.Name)
+	node.SetAttr(data.NodeKind, DatabaseNodeKind)
+	node.SetAttr("updated", time.Now().Unix())
+	node.SetAttr("data", string(databaseJSON))
+
+	if err = m.gm.StoreNode(m.part, node); err != nil {
+		if !exists {
+			inst.quota.Close()
+			inst.gs.Close()
+		}
+		return err
+	}
+
+	if err = inst.quota.SetLimits(quota.AllPartitions, db.Limits()); err != nil {
+		return err
+	}
+
+	inst.db = db
+	m.instances[db.Name] = inst
+
+	return nil
+}
+
+/*
+RemoveDatabase removes a database and all its data. Returns if the database
+existed.
+*/
+func (m *Manager) RemoveDatabase(name string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	inst, ok := m.instances[name]
+	if !ok {
+		return false, nil
+	}
+
+	if _, err := m.gm.RemoveNode(m.part, name, DatabaseNodeKind); err != nil {
+		return true, err
+	}
+
+	delete(m.instances, name)
+
+	inst.quota.Close()
+
+	err := inst.gs.Close()
+
+	if err == nil {
+		err = m.remove(name)
+	}
+
+	return true, err
+}
+
+/*
+start opens the graph storage of a database and creates its graph manager.
+The graph manager enforces the storage quotas of the database and has the same
+transaction limits and key generation as the main graph manager.
+*/
+func (m *Manager) start(db *Database) (*instance, error) {
+
+	gs, err := m.open(db.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open database %v: %v", db.Name, err)
+	}
+
+	inst := &instance{db: db, gs: gs, gm: graph.NewGraphManager(gs)}
+
+	inst.gm.SetTransLimits(m.gm.TransLimits())
+	inst.gm.SetKeyGeneration(m.gm.KeyGeneration())
+
+	if inst.quota, err = quota.NewManager(inst.gm, ""); err == nil {
+		err = inst.quota.SetLimits(quota.AllPartitions, db.Limits())
+	}
+
+	if err != nil {
+		if inst.quota != nil {
+			inst.quota.Close()
+		}
+		gs.Close()
+		return nil, fmt.Errorf("Could not open database %v: %v", db.Name, err)
+	}
+
+	return inst, nil
+}
+
+/*
+nodeCount returns the number of nodes of a graph.
+*/
+func nodeCount(gm *graph.Manager) uint64 {
+	var count uint64
+
+	for _, kind := range gm.NodeKinds() {
+		count += gm.NodeCount(kind)
+	}
+
+	return count
+}
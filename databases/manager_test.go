@@ -0,0 +1,253 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package databases
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/quota"
+)
+
+func TestDatabaseValidate(t *testing.T) {
+
+	for _, test := range []struct {
+		db  *Database
+		msg string
+	}{
+		{&Database{Name: "a b"},
+			"Invalid database name (allowed are letters, digits, - and _): a b"},
+		{&Database{Name: "v1"},
+			"Reserved database name: v1"},
+		{&Database{Name: "d", MaxBytes: -1},
+			"Quotas of database d must not be negative"},
+	} {
+		if err := test.db.Validate(); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	db := &Database{Name: "d", Users: []string{"elias"}}
+
+	if err := db.Validate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !db.Allows("elias") || db.Allows("johndoe") || !(&Database{Name: "d"}).Allows("johndoe") {
+		t.Error("Unexpected access check")
+		return
+	}
+}
+
+func TestManager(t *testing.T) {
+	storages := make(map[string]graphstorage.Storage)
+	var removed []string
+
+	open := func(name string) (graphstorage.Storage, error) {
+		if name == "broken" {
+			return nil, fmt.Errorf("Testerror")
+		}
+		if _, ok := storages[name]; !ok {
+			storages[name] = graphstorage.NewMemoryGraphStorage(name)
+		}
+		return storages[name], nil
+	}
+
+	remove := func(name string) error {
+		delete(storages, name)
+		removed = append(removed, name)
+		return nil
+	}
+
+	mgs := graphstorage.NewMemoryGraphStorage("main")
+	gm := graph.NewGraphManager(mgs)
+	gm.SetTransLimits(100, 0)
+
+	m, err := NewManager(gm, "_system", open, remove)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.StoreDatabase(&Database{Name: "a b"}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := m.StoreDatabase(&Database{Name: "broken"}); err == nil ||
+		err.Error() != "Could not open database broken: Testerror" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := m.StoreDatabase(&Database{Name: "tenant1", MaxNodes: 2, MaxRequestsPerMinute: 2}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.StoreDatabase(&Database{Name: "tenant2"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	db, tgm := m.Database("tenant1")
+
+	if db == nil || tgm == nil || tgm == gm {
+		t.Error("Unexpected result:", db, tgm)
+		return
+	}
+
+	if maxOps, _ := tgm.TransLimits(); maxOps != 100 {
+		t.Error("Unexpected result:", maxOps)
+		return
+	}
+
+	if db, tgm := m.Database("foo"); db != nil || tgm != nil {
+		t.Error("Unexpected result:", db, tgm)
+		return
+	}
+
+	// Databases are isolated from each other
+
+	storeNode := func(gm *graph.Manager, key string) error {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Song")
+		return gm.StoreNode("main", node)
+	}
+
+	if err := storeNode(tgm, "1"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := storeNode(tgm, "2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, tgm2 := m.Database("tenant2")
+
+	if res := fmt.Sprint(m.NodeCount("tenant1"), m.NodeCount("tenant2"), gm.NodeCount("Song")); res != "2 0 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The node quota rejects new nodes but allows updates
+
+	if err := storeNode(tgm, "3"); err == nil ||
+		!strings.Contains(err.Error(), "Quota exceeded: Limit of 2 nodes reached") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := storeNode(tgm, "2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := storeNode(tgm2, "3"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The quota can be changed
+
+	if err := m.StoreDatabase(&Database{Name: "tenant1", MaxNodes: 3, MaxRequestsPerMinute: 2}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := storeNode(tgm, "3"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, tgmNew := m.Database("tenant1"); tgmNew != tgm {
+		t.Error("Graph manager should not change if a database is updated")
+		return
+	}
+
+	if u, err := m.Quota("tenant1").Usage(quota.AllPartitions); err != nil || u.Nodes != 3 || m.Quota("foo") != nil {
+		t.Error("Unexpected result:", u, err)
+		return
+	}
+
+	// Check the request quota
+
+	if !m.Allow("tenant1") || !m.Allow("tenant1") || m.Allow("tenant1") || m.Allow("foo") {
+		t.Error("Unexpected request quota")
+		return
+	}
+
+	for i := 0; i < 5; i++ {
+		if !m.Allow("tenant2") {
+			t.Error("Unexpected request quota")
+			return
+		}
+	}
+
+	// Database definitions are loaded by a new manager
+
+	m2, err := NewManager(gm, "_system", open, remove)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var names []string
+	for _, db := range m2.Databases() {
+		names = append(names, fmt.Sprint(db.Name, ":", db.MaxNodes))
+	}
+
+	if res := fmt.Sprint(names); res != "[tenant1:3 tenant2:0]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := m2.NodeCount("tenant1"); res != 3 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Remove a database
+
+	if ok, err := m.RemoveDatabase("tenant2"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := m.RemoveDatabase("tenant2"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if res := fmt.Sprint(removed, len(m.Databases())); res != "[tenant2] 1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := m.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(m.Databases()) != 0 {
+		t.Error("Unexpected result:", m.Databases())
+		return
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dbfunc
+
+import (
+	"fmt"
+
+	"github.com/krotik/ecal/parser"
+	"github.com/krotik/ecal/util"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+SandboxFunc restricts an ECAL function which gets a partition as its first
+parameter to a set of allowed partitions.
+*/
+type SandboxFunc struct {
+	Func       util.ECALFunction // Restricted function
+	Partitions map[string]bool   // Allowed partitions
+	Write      bool              // Flag if the function writes to the datastore
+	ReadOnly   bool              // Flag if writes are not allowed
+}
+
+/*
+Run executes the ECAL function.
+*/
+func (f *SandboxFunc) Run(instanceID string, vs parser.Scope, is map[string]interface{}, tid uint64, args []interface{}) (interface{}, error) {
+
+	if f.Write && f.ReadOnly {
+		return nil, fmt.Errorf("Function is not allowed in a read-only sandbox")
+	}
+
+	if len(args) > 0 {
+		if part := fmt.Sprint(args[0]); !f.Partitions[part] {
+			return nil, fmt.Errorf("Partition %v is not allowed in the sandbox", part)
+		}
+	}
+
+	return f.Func.Run(instanceID, vs, is, tid, args)
+}
+
+/*
+DocString returns a descriptive string.
+*/
+func (f *SandboxFunc) DocString() (string, error) {
+	return f.Func.DocString()
+}
+
+/*
+NewSandbox returns an ECAL object with the EliasDB functions of the db
+package which can only access the given partitions. Writes are rejected if the
+sandbox is read-only.
+*/
+func NewSandbox(gm *graph.Manager, partitions []string, readOnly bool) map[interface{}]interface{} {
+
+	allowed := make(map[string]bool)
+	for _, p := range partitions {
+		allowed[p] = true
+	}
+
+	restrict := func(f util.ECALFunction, write bool) util.ECALFunction {
+		return &SandboxFunc{f, allowed, write, readOnly}
+	}
+
+	return map[interface{}]interface{}{
+		"storeNode":  restrict(&StoreNodeFunc{GM: gm}, true),
+		"updateNode": restrict(&UpdateNodeFunc{GM: gm}, true),
+		"removeNode": restrict(&RemoveNodeFunc{GM: gm}, true),
+		"fetchNode":  restrict(&FetchNodeFunc{GM: gm}, false),
+		"storeEdge":  restrict(&StoreEdgeFunc{GM: gm}, true),
+		"removeEdge": restrict(&RemoveEdgeFunc{GM: gm}, true),
+		"fetchEdge":  restrict(&FetchEdgeFunc{GM: gm}, false),
+		"traverse":   restrict(&TraverseFunc{GM: gm}, false),
+		"query":      restrict(&QueryFunc{GM: gm}, false),
+
+		// GraphQL requests might contain mutations
+
+		"graphQL": restrict(&GraphQLFunc{GM: gm}, true),
+
+		// Transactions only contain writes which were allowed by the sandbox
+
+		"newTrans": &SandboxFunc{&NewTransFunc{GM: gm}, allowed, true, readOnly},
+		"commit":   &CommitTransFunc{GM: gm},
+	}
+}
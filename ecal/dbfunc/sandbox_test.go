@@ -0,0 +1,79 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dbfunc
+
+import (
+	"testing"
+
+	"github.com/krotik/ecal/util"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSandbox(t *testing.T) {
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	sb := NewSandbox(gm, []string{"main"}, false)
+
+	node := map[interface{}]interface{}{
+		"key":  "foo",
+		"kind": "bar",
+	}
+
+	if _, err := sb["storeNode"].(util.ECALFunction).Run("", nil, nil, 0, []interface{}{"main", node}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sb["storeNode"].(util.ECALFunction).Run("", nil, nil, 0, []interface{}{"other", node}); err == nil ||
+		err.Error() != "Partition other is not allowed in the sandbox" {
+		t.Error(err)
+		return
+	}
+
+	if res, err := sb["fetchNode"].(util.ECALFunction).Run("", nil, nil, 0, []interface{}{"main", "foo", "bar"}); err != nil || res == nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if doc, err := sb["fetchNode"].(util.ECALFunction).DocString(); err != nil || doc == "" {
+		t.Error("Unexpected result:", doc, err)
+		return
+	}
+
+	// Read-only sandboxes reject all writes
+
+	sb = NewSandbox(gm, []string{"main"}, true)
+
+	if _, err := sb["removeNode"].(util.ECALFunction).Run("", nil, nil, 0, []interface{}{"main", "foo", "bar"}); err == nil ||
+		err.Error() != "Function is not allowed in a read-only sandbox" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sb["newTrans"].(util.ECALFunction).Run("", nil, nil, 0, nil); err == nil ||
+		err.Error() != "Function is not allowed in a read-only sandbox" {
+		t.Error(err)
+		return
+	}
+
+	if res, err := sb["query"].(util.ECALFunction).Run("", nil, nil, 0, []interface{}{"main", "get bar"}); err != nil || res == nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := gm.NodeCount("bar"); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
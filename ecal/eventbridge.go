@@ -203,37 +203,49 @@ func (eb *EventBridge) Handle(gm *graph.Manager, trans graph.Trans, event int, e
 			// If there was no direct error adding the event then check if an error was
 			// raised in a sink
 
-			if errs := m.(*engine.RootMonitor).AllErrors(); len(errs) > 0 {
-				var errList []error
+			err = monitorErrors(m)
+		}
 
-				for _, e := range errs {
+		if err != nil {
+			eb.Logger.LogDebug(fmt.Sprintf("EliasDB event %v was handled by ECAL and returned: %v", name, err))
+		}
+	}
 
-					addError := true
+	return err
+}
 
-					for _, se := range e.ErrorMap {
+/*
+monitorErrors returns the errors which were raised in sinks while processing an
+event. Returns graph.ErrEventHandled if sinks only returned this special error.
+*/
+func monitorErrors(m engine.Monitor) error {
+	var err error
 
-						// Check if the sink returned a special graph.ErrEventHandled error
+	if errs := m.(*engine.RootMonitor).AllErrors(); len(errs) > 0 {
+		var errList []error
 
-						if re, ok := se.(*util.RuntimeErrorWithDetail); ok && re.Detail == graph.ErrEventHandled.Error() {
-							addError = false
-						}
-					}
+		for _, e := range errs {
 
-					if addError {
-						errList = append(errList, e)
-					}
-				}
+			addError := true
+
+			for _, se := range e.ErrorMap {
+
+				// Check if the sink returned a special graph.ErrEventHandled error
 
-				if len(errList) > 0 {
-					err = &errorutil.CompositeError{Errors: errList}
-				} else {
-					err = graph.ErrEventHandled
+				if re, ok := se.(*util.RuntimeErrorWithDetail); ok && re.Detail == graph.ErrEventHandled.Error() {
+					addError = false
 				}
 			}
+
+			if addError {
+				errList = append(errList, e)
+			}
 		}
 
-		if err != nil {
-			eb.Logger.LogDebug(fmt.Sprintf("EliasDB event %v was handled by ECAL and returned: %v", name, err))
+		if len(errList) > 0 {
+			err = &errorutil.CompositeError{Errors: errList}
+		} else {
+			err = graph.ErrEventHandled
 		}
 	}
 
@@ -213,6 +213,35 @@ func (si *ScriptingInterpreter) HandleECALSockEvent(p engine.Processor, m engine
 	return err
 }
 
+/*
+HandleRuleScript runs the script action of a rules engine rule. It injects a
+db.rule.<name> event with the rule event, the partition, the written node or
+edge and the transaction of the write.
+*/
+func (si *ScriptingInterpreter) HandleRuleScript(name string, event string, part string,
+	obj map[string]interface{}, trans graph.Trans) error {
+
+	kind := []string{"db", "rule", name}
+
+	state := map[interface{}]interface{}{
+		"event": event,
+		"part":  part,
+		"data":  scope.ConvertJSONToECALObject(obj),
+		"trans": trans,
+	}
+
+	m, err := si.Interpreter.RuntimeProvider.Processor.AddEventAndWait(
+		engine.NewEvent(fmt.Sprintf("EliasDB: %v", strings.Join(kind, ".")), kind, state), nil)
+
+	if err == nil {
+		if err = monitorErrors(m); err == graph.ErrEventHandled {
+			err = nil
+		}
+	}
+
+	return err
+}
+
 /*
 AddEliasDBStdlibFunctions adds EliasDB related ECAL stdlib functions.
 */
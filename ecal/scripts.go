@@ -0,0 +1,383 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ecal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/ecal/interpreter"
+	"github.com/krotik/ecal/parser"
+	"github.com/krotik/ecal/scope"
+	"github.com/krotik/ecal/util"
+	"github.com/krotik/eliasdb/ecal/dbfunc"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+ScriptNodeKind is the node kind which stores scripts.
+*/
+const ScriptNodeKind = "script"
+
+/*
+ScriptResultVar is the variable which holds the result of a script.
+*/
+const ScriptResultVar = "result"
+
+/*
+scriptNameRegexp is the pattern of valid script names.
+*/
+var scriptNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+/*
+scriptEvents maps graph events to the event names of scripts.
+*/
+var scriptEvents = map[int]string{
+	graph.EventNodeCreated: "node.created",
+	graph.EventNodeUpdated: "node.updated",
+	graph.EventNodeDeleted: "node.deleted",
+	graph.EventEdgeCreated: "edge.created",
+	graph.EventEdgeUpdated: "edge.updated",
+	graph.EventEdgeDeleted: "edge.deleted",
+}
+
+/*
+Script is a small ECAL script which is deployed at runtime. A script is invoked
+through the REST API or runs on graph events. Scripts can only access the
+datastore through a sandboxed db object which is restricted to the partitions
+of the script.
+*/
+type Script struct {
+	Name       string   `json:"name"`               // Name of the script
+	Code       string   `json:"code"`               // ECAL code of the script
+	Events     []string `json:"events,omitempty"`   // Graph events which run the script (e.g. node.created)
+	Partitions []string `json:"partitions"`         // Partitions which can be accessed by the script
+	ReadOnly   bool     `json:"readonly,omitempty"` // Flag if the script may not write
+}
+
+/*
+ScriptManager manages and runs deployed scripts. The manager needs to be
+registered as graph rule if scripts should run on graph events.
+*/
+type ScriptManager struct {
+	gm      *graph.Manager     // Graph manager of the scripts
+	part    string             // Partition which stores the scripts
+	scripts map[string]*Script // Scripts by name
+	lock    *sync.RWMutex      // Lock for scripts
+
+	Logger util.Logger // Logger of the scripts
+	Active func() bool // Function which returns if scripts should run on events (nil to always run them)
+}
+
+/*
+NewScriptManager creates a new script manager and loads all scripts which are
+stored in a given partition. Scripts cannot access this partition.
+*/
+func NewScriptManager(gm *graph.Manager, part string) (*ScriptManager, error) {
+	sm := &ScriptManager{gm, part, make(map[string]*Script), &sync.RWMutex{},
+		util.NewStdOutLogger(), nil}
+
+	it, err := gm.NodeKeyIterator(part, ScriptNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var node data.Node
+
+			if node, err = gm.FetchNode(part, key, ScriptNodeKind); err == nil && node != nil {
+				s := &Script{}
+
+				if err = json.Unmarshal([]byte(fmt.Sprint(node.Attr("data"))), s); err == nil {
+					sm.scripts[s.Name] = s
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sm, nil
+}
+
+/*
+Scripts returns all scripts sorted by name.
+*/
+func (sm *ScriptManager) Scripts() []*Script {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	ret := make([]*Script, 0, len(sm.scripts))
+
+	for _, s := range sm.scripts {
+		ret = append(ret, s)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+Script returns a script. Returns nil if the script does not exist.
+*/
+func (sm *ScriptManager) Script(name string) *Script {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	return sm.scripts[name]
+}
+
+/*
+Validate checks a script and compiles its code.
+*/
+func (sm *ScriptManager) Validate(s *Script) error {
+
+	if !scriptNameRegexp.MatchString(s.Name) {
+		return fmt.Errorf("Invalid script name (allowed are letters, digits, - and _): %v", s.Name)
+	}
+
+	if len(s.Partitions) == 0 {
+		return fmt.Errorf("Script must contain at least one partition")
+	}
+
+	for _, p := range s.Partitions {
+		if p == sm.part {
+			return fmt.Errorf("Scripts cannot access the partition: %v", p)
+		}
+	}
+
+	for _, e := range s.Events {
+		found := false
+
+		for _, se := range scriptEvents {
+			if found = se == e; found {
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Unknown event: %v", e)
+		}
+	}
+
+	_, _, err := sm.compile(s)
+
+	return err
+}
+
+/*
+StoreScript validates and stores a script. An existing script with the same
+name is replaced.
+*/
+func (sm *ScriptManager) StoreScript(s *Script) error {
+
+	if err := sm.Validate(s); err != nil {
+		return err
+	}
+
+	scriptJSON, err := json.Marshal(s)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, s.Name)
+		node.SetAttr(data.NodeKind, ScriptNodeKind)
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(scriptJSON))
+
+		if err = sm.gm.StoreNode(sm.part, node); err == nil {
+			sm.lock.Lock()
+			sm.scripts[s.Name] = s
+			sm.lock.Unlock()
+		}
+	}
+
+	return err
+}
+
+/*
+RemoveScript removes a script. Returns if the script existed.
+*/
+func (sm *ScriptManager) RemoveScript(name string) (bool, error) {
+
+	node, err := sm.gm.RemoveNode(sm.part, name, ScriptNodeKind)
+
+	if err == nil {
+		sm.lock.Lock()
+		delete(sm.scripts, name)
+		sm.lock.Unlock()
+	}
+
+	return node != nil, err
+}
+
+/*
+compile parses and validates the code of a script. Scripts cannot import other
+scripts.
+*/
+func (sm *ScriptManager) compile(s *Script) (*parser.ASTNode, *interpreter.ECALRuntimeProvider, error) {
+
+	rtp := interpreter.NewECALRuntimeProvider(s.Name, nil, sm.Logger)
+
+	ast, err := parser.ParseWithRuntime(s.Name, s.Code, rtp)
+
+	if err == nil {
+		err = ast.Runtime.Validate()
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid script code: %v", err)
+	}
+
+	return ast, rtp, nil
+}
+
+/*
+Run runs a script with a set of variables. Values of variables are converted
+to ECAL objects. Returns the value of the result variable of the script.
+*/
+func (sm *ScriptManager) Run(name string, vars map[string]interface{}) (interface{}, error) {
+
+	s := sm.Script(name)
+
+	if s == nil {
+		return nil, fmt.Errorf("Unknown script: %v", name)
+	}
+
+	return sm.run(sm.gm, s, vars)
+}
+
+/*
+run runs a script with a given graph manager.
+*/
+func (sm *ScriptManager) run(gm *graph.Manager, s *Script, vars map[string]interface{}) (interface{}, error) {
+	var res interface{}
+
+	ast, rtp, err := sm.compile(s)
+
+	if err == nil {
+		vs := scope.NewScope(scope.GlobalScope)
+
+		vs.SetValue("db", dbfunc.NewSandbox(gm, s.Partitions, s.ReadOnly))
+
+		for k, v := range vars {
+			if _, ok := v.(graph.Trans); !ok {
+				v = scope.ConvertJSONToECALObject(v)
+			}
+			vs.SetValue(k, v)
+		}
+
+		if _, err = ast.Runtime.Eval(vs, make(map[string]interface{}), rtp.NewThreadID()); err == nil {
+			if val, ok, _ := vs.GetValue(ScriptResultVar); ok {
+				res = scope.ConvertECALToJSONObject(val)
+			}
+		}
+	}
+
+	return res, err
+}
+
+// Graph rule
+// ==========
+
+/*
+Name returns the name of the rule.
+*/
+func (sm *ScriptManager) Name() string {
+	return "system.scripts"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (sm *ScriptManager) Handles() []int {
+	return []int{graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventNodeDeleted,
+		graph.EventEdgeCreated, graph.EventEdgeUpdated, graph.EventEdgeDeleted}
+}
+
+/*
+Handle handles an event. All scripts which handle the event and can access
+the partition of the event run with the transaction of the event (variable
+trans). A failing script fails the write.
+*/
+func (sm *ScriptManager) Handle(gm *graph.Manager, trans graph.Trans, event int, ed ...interface{}) error {
+	var errs []string
+
+	part := ed[0].(string)
+	name := scriptEvents[event]
+
+	if part == sm.part || (sm.Active != nil && !sm.Active()) {
+		return nil
+	}
+
+	for _, s := range sm.Scripts() {
+
+		if !s.handles(name, part) {
+			continue
+		}
+
+		obj := "node"
+		if strings.HasPrefix(name, "edge.") {
+			obj = "edge"
+		}
+
+		vars := map[string]interface{}{
+			"event": name,
+			"part":  part,
+			"trans": trans,
+			obj:     ed[1].(data.Node).Data(),
+		}
+
+		if len(ed) > 2 && ed[2] != nil {
+			vars["old_"+obj] = ed[2].(data.Node).Data()
+		}
+
+		// The given graph manager must be used since the datastore is
+		// locked while the event is handled
+
+		if _, err := sm.run(gm, s, vars); err != nil {
+			errs = append(errs, fmt.Sprintf("Script %v failed: %v", s.Name, err))
+		}
+	}
+
+	if errs != nil {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+/*
+handles checks if a script runs on an event in a partition.
+*/
+func (s *Script) handles(event string, part string) bool {
+	var hasEvent, hasPart bool
+
+	for _, e := range s.Events {
+		hasEvent = hasEvent || e == event
+	}
+
+	for _, p := range s.Partitions {
+		hasPart = hasPart || p == part
+	}
+
+	return hasEvent && hasPart
+}
@@ -0,0 +1,160 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ecal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestScriptManager(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	sm, err := NewScriptManager(gm, "system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.SetGraphRule(sm)
+
+	// Invalid scripts are rejected
+
+	for _, test := range []struct {
+		script *Script
+		msg    string
+	}{
+		{&Script{Name: "a b", Partitions: []string{"main"}},
+			"Invalid script name (allowed are letters, digits, - and _): a b"},
+		{&Script{Name: "s"},
+			"Script must contain at least one partition"},
+		{&Script{Name: "s", Partitions: []string{"system"}},
+			"Scripts cannot access the partition: system"},
+		{&Script{Name: "s", Partitions: []string{"main"}, Events: []string{"node.foo"}},
+			"Unknown event: node.foo"},
+		{&Script{Name: "s", Partitions: []string{"main"}, Code: "a := "},
+			"Invalid script code: "},
+	} {
+		if err := sm.StoreScript(test.script); err == nil || !strings.HasPrefix(err.Error(), test.msg) {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	// A script which is invoked with a request
+
+	if err := sm.StoreScript(&Script{Name: "lookup", Partitions: []string{"main"}, ReadOnly: true, Code: `
+n := db.fetchNode("main", request.key, "Song")
+result := {"name": n.name, "method": request.method}
+`}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "a",
+		"kind": "Song",
+		"name": "Aria",
+	}))
+
+	if res, err := sm.Run("lookup", map[string]interface{}{
+		"request": map[string]interface{}{"key": "a", "method": "GET"},
+	}); err != nil || fmt.Sprint(res) != "map[method:GET name:Aria]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := sm.Run("foo", nil); err == nil || err.Error() != "Unknown script: foo" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Scripts can only access their partitions and cannot write if they are read-only
+
+	sm.StoreScript(&Script{Name: "other", Partitions: []string{"main"}, Code: `
+result := db.fetchNode("other", "a", "Song")
+`})
+
+	if _, err := sm.Run("other", nil); err == nil ||
+		!strings.Contains(err.Error(), "Partition other is not allowed in the sandbox") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm.StoreScript(&Script{Name: "write", Partitions: []string{"main"}, ReadOnly: true, Code: `
+db.removeNode("main", "a", "Song")
+`})
+
+	if _, err := sm.Run("write", nil); err == nil ||
+		!strings.Contains(err.Error(), "Function is not allowed in a read-only sandbox") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// A script which runs on graph events
+
+	sm.StoreScript(&Script{Name: "stamp", Partitions: []string{"main"}, Events: []string{"node.created"}, Code: `
+if node.kind == "Song" {
+  db.updateNode("main", {"key": node.key, "kind": "Marker", "song": node.name}, trans)
+}
+`})
+
+	trans := graph.NewGraphTrans(gm)
+	trans.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "b",
+		"kind": "Song",
+		"name": "Ballad",
+	}))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "b", "Marker"); err != nil || n == nil || n.Attr("song") != "Ballad" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Scripts are loaded from the storage partition
+
+	sm2, err := NewScriptManager(gm, "system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var names []string
+	for _, s := range sm2.Scripts() {
+		names = append(names, s.Name)
+	}
+
+	if res := fmt.Sprint(names); res != "[lookup other stamp write]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if ok, err := sm.RemoveScript("stamp"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if s := sm.Script("stamp"); s != nil {
+		t.Error("Unexpected result:", s)
+		return
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package eliasdb is the entry point for embedding EliasDB in other Go programs.
+
+Open creates a DB handle which wires up the graph storage, the GraphManager and
+optionally the REST API:
+
+	db, err := eliasdb.Open("db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	db.GraphManager().StoreNode("main", node)
+
+	res, err := db.Query("main", "get mynode")
+
+The REST API uses package level state (see the api package) and can therefore
+only be provided by one open DB at a time.
+*/
+package eliasdb
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	v1 "github.com/krotik/eliasdb/api/v1"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+/*
+ErrRESTAPIInUse is returned if the REST API is requested while it is already
+provided by another open DB.
+*/
+var ErrRESTAPIInUse = errors.New("REST API is already provided by another open database")
+
+/*
+ErrClosed is returned if a closed DB is used.
+*/
+var ErrClosed = errors.New("Database is closed")
+
+/*
+restDB is the DB which currently provides the REST API.
+*/
+var restDB *DB
+
+/*
+restLock protects restDB.
+*/
+var restLock = &sync.Mutex{}
+
+/*
+options are the options of a DB.
+*/
+type options struct {
+	memoryOnly bool           // Flag if the DB should only be kept in memory
+	readOnly   bool           // Flag if the DB should be opened read-only
+	mux        *http.ServeMux // ServeMux which receives the REST API endpoints
+
+	durability         string        // Durability mode of the disk storage
+	durabilityInterval time.Duration // Sync interval for periodic durability
+	engine             string        // Storage engine of a new disk storage
+
+	backend file.StorageBackend // Backend which stores the files of the disk storage
+}
+
+/*
+Option is an option for Open.
+*/
+type Option func(o *options)
+
+/*
+MemoryOnly keeps all data in memory. The path is only used as storage name.
+*/
+func MemoryOnly() Option {
+	return func(o *options) {
+		o.memoryOnly = true
+	}
+}
+
+/*
+ReadOnly opens the disk storage in read-only mode.
+*/
+func ReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+/*
+Durability sets the durability mode of the disk storage (see the Durability
+constants in the graphstorage package). The interval is only used for
+periodic durability. Use Flush to make all previous commits durable.
+*/
+func Durability(mode string, interval time.Duration) Option {
+	return func(o *options) {
+		o.durability = mode
+		o.durabilityInterval = interval
+	}
+}
+
+/*
+Engine sets the storage engine (see the Engine constants in the graphstorage
+package) which is used if the disk storage is created. Existing storages keep
+the engine they were created with.
+*/
+func Engine(engine string) Option {
+	return func(o *options) {
+		o.engine = engine
+	}
+}
+
+/*
+Backend sets the backend which stores the files of the disk storage (e.g. the
+backend of the s3 package). Only the pages storage engine stores its files in
+a backend.
+*/
+func Backend(backend file.StorageBackend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+/*
+WithRESTAPI registers the REST API endpoints with the given ServeMux. Serving
+the ServeMux (e.g. via HTTPS) is left to the caller.
+*/
+func WithRESTAPI(mux *http.ServeMux) Option {
+	return func(o *options) {
+		o.mux = mux
+	}
+}
+
+/*
+DB is an embedded EliasDB instance.
+*/
+type DB struct {
+	gs     graphstorage.Storage // Storage of the DB
+	gm     *graph.Manager       // GraphManager of the DB
+	closed bool                 // Flag if the DB has been closed
+	lock   *sync.Mutex          // Lock for closing the DB
+}
+
+/*
+Open opens a DB at a given path. The path is created if it does not exist.
+*/
+func Open(path string, opts ...Option) (*DB, error) {
+	var gs graphstorage.Storage
+	var err error
+
+	o := &options{engine: graphstorage.EnginePages, backend: &file.OSBackend{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.memoryOnly {
+		gs = graphstorage.NewMemoryGraphStorage(path)
+	} else if gs, err = graphstorage.NewDiskGraphStorageWithBackend(path, o.readOnly,
+		o.engine, o.backend); err != nil {
+		return nil, err
+	} else if o.durability != "" {
+		if err = gs.(*graphstorage.DiskGraphStorage).SetDurability(o.durability, o.durabilityInterval); err != nil {
+			gs.Close()
+			return nil, err
+		}
+	}
+
+	db := &DB{gs, graph.NewGraphManager(gs), false, &sync.Mutex{}}
+
+	if o.mux != nil {
+		if err = db.registerRESTAPI(o.mux, o.readOnly); err != nil {
+			gs.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+/*
+registerRESTAPI registers the REST API endpoints of this DB with a given ServeMux.
+*/
+func (db *DB) registerRESTAPI(mux *http.ServeMux, readOnly bool) error {
+	restLock.Lock()
+	defer restLock.Unlock()
+
+	if restDB != nil {
+		return ErrRESTAPIInUse
+	}
+
+	restDB = db
+
+	api.GS = db.gs
+	api.GM = db.gm
+	api.ReadOnly = readOnly
+
+	oldHandleFunc := api.HandleFunc
+	defer func() {
+		api.HandleFunc = oldHandleFunc
+	}()
+
+	api.HandleFunc = mux.HandleFunc
+
+	api.RegisterRestEndpoints(api.GeneralEndpointMap)
+	api.RegisterRestEndpoints(v1.V1EndpointMap)
+
+	return nil
+}
+
+/*
+GraphManager returns the GraphManager of this DB.
+*/
+func (db *DB) GraphManager() *graph.Manager {
+	return db.gm
+}
+
+/*
+Storage returns the graph storage of this DB.
+*/
+func (db *DB) Storage() graphstorage.Storage {
+	return db.gs
+}
+
+/*
+Query runs an EQL query on a partition of this DB.
+*/
+func (db *DB) Query(part string, query string) (eql.SearchResult, error) {
+	if db.isClosed() {
+		return nil, ErrClosed
+	}
+	return eql.RunQuery("db query", part, query, db.gm)
+}
+
+/*
+Flush writes all pending changes to disk and waits until all previous commits
+are durable regardless of the durability mode.
+*/
+func (db *DB) Flush() error {
+	if db.isClosed() {
+		return ErrClosed
+	}
+	return db.gm.FlushAndSync()
+}
+
+/*
+Close flushes all pending changes and closes this DB.
+*/
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	db.closed = true
+
+	restLock.Lock()
+	if restDB == db {
+		restDB = nil
+		api.GS = nil
+		api.GM = nil
+		api.ReadOnly = false
+	}
+	restLock.Unlock()
+
+	err := db.gs.FlushAll()
+
+	if cerr := db.gs.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+/*
+isClosed returns if this DB has been closed.
+*/
+func (db *DB) isClosed() bool {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.closed
+}
@@ -0,0 +1,254 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eliasdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+const testdb = "testdb"
+
+func TestOpen(t *testing.T) {
+	os.RemoveAll(testdb)
+	defer os.RemoveAll(testdb)
+
+	db, err := Open(testdb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "123")
+	node.SetAttr(data.NodeKind, "mynode")
+	node.SetAttr(data.NodeName, "Node1")
+
+	if err := db.GraphManager().StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.Close(); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := db.Query("main", "get mynode"); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Reopen the database read-only and check that the data was persisted
+
+	db, err = Open(testdb, ReadOnly())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := db.Query("main", "get mynode")
+
+	if err != nil || res.RowCount() != 1 || res.Rows()[0][1] != "Node1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := db.GraphManager().StoreNode("main", node); err == nil {
+		t.Error("Read-only database should not accept writes")
+		return
+	}
+
+	if db.Storage().Name() != testdb {
+		t.Error("Unexpected result:", db.Storage().Name())
+		return
+	}
+
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestOpenMemoryOnly(t *testing.T) {
+	mux := http.NewServeMux()
+
+	db, err := Open("memdb", MemoryOnly(), WithRESTAPI(mux))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "123")
+	node.SetAttr(data.NodeKind, "mynode")
+
+	db.GraphManager().StoreNode("main", node)
+
+	// Only one database can provide the REST API
+
+	if _, err := Open("memdb2", MemoryOnly(), WithRESTAPI(http.NewServeMux())); err != ErrRESTAPIInUse {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/db/v1/info")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"mynode"`) {
+		t.Error("Unexpected response:", resp.StatusCode, string(body))
+		return
+	}
+
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// After the database was closed another database can provide the REST API
+
+	db, err = Open("memdb2", MemoryOnly(), WithRESTAPI(http.NewServeMux()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	db.Close()
+
+	if _, err := Open("foo\x00", ReadOnly()); err == nil {
+		t.Error("Opening an invalid path should fail")
+		return
+	}
+}
+
+func TestOpenDurability(t *testing.T) {
+	os.RemoveAll(testdb)
+	defer os.RemoveAll(testdb)
+
+	if _, err := Open(testdb, Durability("foo", 0)); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown durability mode: foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	db, err := Open(testdb, Durability(graphstorage.DurabilityPeriodic, time.Second))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := db.Storage().(*graphstorage.DiskGraphStorage).Durability(); res != graphstorage.DurabilityPeriodic {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, string(rune('a'+i)))
+		node.SetAttr(data.NodeKind, "mynode")
+
+		if err := db.GraphManager().StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.Flush(); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	db, err = Open(testdb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	if res := db.GraphManager().NodeCount("mynode"); res != 10 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+/*
+countingBackend is a storage backend which counts opened files.
+*/
+type countingBackend struct {
+	file.OSBackend
+	opened int
+}
+
+func (b *countingBackend) OpenFile(name string, flag int, perm os.FileMode) (file.BackendFile, error) {
+	b.opened++
+	return b.OSBackend.OpenFile(name, flag, perm)
+}
+
+func TestOpenBackend(t *testing.T) {
+	os.RemoveAll(testdb)
+	defer os.RemoveAll(testdb)
+
+	backend := &countingBackend{}
+
+	db, err := Open(testdb, Backend(backend))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "a")
+	node.SetAttr(data.NodeKind, "mynode")
+
+	if err := db.GraphManager().StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if backend.opened == 0 {
+		t.Error("Storage files should be opened through the backend")
+		return
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/eql/interpreter"
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+isComposedQuery checks if a given query might be a composed query. Composed
+queries define named results with a leading with clause or combine the results
+of several queries with union.
+
+with <name> as (<query>)[, <name> as (<query>)] <query> [union [all] <query>]
+*/
+func isComposedQuery(query string) bool {
+	return strings.ToLower(parser.FirstWord(query)) == "with" ||
+		strings.Contains(strings.ToLower(query), "union")
+}
+
+/*
+runComposedQuery runs a composed query. The queries of named results are run
+first in the order of their definition - each query can use the results which
+were defined before it.
+*/
+func runComposedQuery(ctx context.Context, name string, part string, query string, gm *graph.Manager,
+	ni interpreter.NodeInfo, opts *QueryOptions) (*interpreter.SearchResult, error) {
+
+	named := make(map[string]*interpreter.SearchResult)
+	tokens := parser.LexToList(name, query)
+	i := 0
+
+	// A row window cannot be applied to the parts of a composed query
+
+	if opts != nil && (opts.RowOffset != 0 || opts.RowLimit != 0) {
+		partOpts := *opts
+		partOpts.RowOffset, partOpts.RowLimit = 0, 0
+		opts = &partOpts
+	}
+
+	if tokens[0].ID == parser.TokenWITH {
+
+		for {
+			var resName string
+			var end int
+
+			i++
+
+			if t := tokens[i]; t.ID != parser.TokenVALUE || !stringutil.IsAlphaNumeric(t.Val) {
+				return nil, composeError(name, "Expected name of named result", t)
+			} else if _, ok := named[t.Val]; ok {
+				return nil, composeError(name, "Named result is defined twice", t)
+			}
+
+			resName = tokens[i].Val
+
+			if i++; tokens[i].ID != parser.TokenAS {
+				return nil, composeError(name, "Expected as after name of named result", tokens[i])
+			} else if i++; tokens[i].ID != parser.TokenLPAREN {
+				return nil, composeError(name, "Expected ( before query of named result", tokens[i])
+			}
+
+			start := i + 1
+
+			// Find the matching closing bracket
+
+			for depth := 1; depth > 0; {
+				i++
+
+				switch tokens[i].ID {
+				case parser.TokenLPAREN:
+					depth++
+				case parser.TokenRPAREN:
+					depth--
+				case parser.TokenEOF, parser.TokenError:
+					return nil, composeError(name, "Expected ) after query of named result", tokens[i])
+				}
+			}
+
+			if end = i; end == start {
+				return nil, composeError(name, "Expected query", tokens[end])
+			}
+
+			res, err := runUnionQuery(ctx, name, part, query, tokens[start:end], tokens[end].Pos,
+				gm, ni, opts, named)
+			if err != nil {
+				return nil, err
+			}
+
+			named[resName] = res
+
+			if i++; tokens[i].ID != parser.TokenCOMMA {
+				break
+			}
+		}
+	}
+
+	return runUnionQuery(ctx, name, part, query, tokens[i:], len(query), gm, ni, opts, named)
+}
+
+/*
+runUnionQuery runs the queries of a given non-empty token list which are
+combined with union. The queries are combined from left to right - union removes duplicate
+rows while union all keeps them.
+*/
+func runUnionQuery(ctx context.Context, name string, part string, query string, tokens []parser.LexToken,
+	end int, gm *graph.Manager, ni interpreter.NodeInfo, opts *QueryOptions,
+	named map[string]*interpreter.SearchResult) (*interpreter.SearchResult, error) {
+
+	var res *interpreter.SearchResult
+	var union parser.LexToken
+
+	depth := 0
+	start := 0
+
+	for i := 0; i <= len(tokens); i++ {
+		partEnd := end
+		stop := i
+
+		if i < len(tokens) {
+			t := tokens[i]
+
+			if t.ID == parser.TokenLPAREN {
+				depth++
+				continue
+			} else if t.ID == parser.TokenRPAREN {
+				depth--
+				continue
+			} else if t.ID == parser.TokenEOF {
+				i = len(tokens)
+			} else if t.ID == parser.TokenError {
+
+				// The query which contains the lexer error reports it
+
+				stop++
+				i = len(tokens)
+
+			} else if depth == 0 && (t.ID == parser.TokenUNION || t.ID == parser.TokenUNIONALL) {
+				partEnd = t.Pos
+			} else {
+				continue
+			}
+		}
+
+		if start >= stop {
+			errToken := tokens[len(tokens)-1]
+			if stop < len(tokens) {
+				errToken = tokens[stop]
+			}
+			return nil, composeError(name, "Expected query", errToken)
+		}
+
+		pres, err := runSingleQuery(ctx, name, part, queryPart(query, tokens[start].Pos, partEnd),
+			gm, ni, opts, named)
+		if err != nil {
+			return nil, err
+		}
+
+		if res == nil {
+			res = pres
+		} else if res, err = interpreter.UnionResults(name, query, []*interpreter.SearchResult{res, pres},
+			union.ID == parser.TokenUNIONALL); err != nil {
+			return nil, err
+		}
+
+		if i < len(tokens) {
+			union = tokens[i]
+			start = i + 1
+		}
+	}
+
+	return res, nil
+}
+
+/*
+queryPart returns a part of a given query. All other characters of the query
+are replaced by spaces so positions in errors refer to the whole query.
+*/
+func queryPart(query string, start int, end int) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(query); i++ {
+		if (i >= start && i < end) || query[i] == '\n' {
+			buf.WriteByte(query[i])
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+
+	return buf.String()
+}
+
+/*
+composeError creates a new error for an invalid composed query.
+*/
+func composeError(name string, detail string, t parser.LexToken) error {
+	if t.ID == parser.TokenEOF {
+		return &parser.Error{Source: name, Type: parser.ErrUnexpectedEnd, Detail: detail,
+			Line: t.Lline, Pos: t.Lpos}
+	} else if t.ID == parser.TokenError {
+		return &parser.Error{Source: name, Type: parser.ErrLexicalError, Detail: t.Val,
+			Line: t.Lline, Pos: t.Lpos}
+	}
+
+	return &parser.Error{Source: name, Type: parser.ErrUnexpectedToken,
+		Detail: fmt.Sprintf("%v - %v", detail, t), Line: t.Lline, Pos: t.Lpos}
+}
@@ -0,0 +1,105 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"testing"
+)
+
+func TestComposedQuery(t *testing.T) {
+	gm, _ := songGraph()
+
+	for query, expected := range map[string]string{
+		"get Song where ranking > 10 show key, ranking with ordering(ascending key) " +
+			"union get Song where ranking < 3 show key, ranking with ordering(ascending key)": `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+Aria4, 18
+MyOnlySong3, 19
+Aria2, 2
+LoveSong3, 1
+`[1:],
+		"get Author where name = 'John' union get Author where key = '000' union get Author where name = 'Hans'": `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+000, John
+456, Hans
+`[1:],
+		"get Author where name = 'John' UNION ALL get Author where key = '000'": `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+000, John
+000, John
+`[1:],
+		"get Author where name = 'union'": `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+`[1:],
+		`
+with top as (get Song where ranking > 5), mike as (get Author where name = 'Mike')
+get top where ranking < 10 show key with ordering(ascending key)
+union all get mike traverse :::Song end show Song:key with ordering(ascending key)`: `
+Labels: Song Key
+Format: auto
+Data: 1:n:key
+Aria1
+DeadSong2
+DeadSong2
+FightSong4
+LoveSong3
+StrangeSong1
+`[1:],
+		"with a as (get Song where ranking > 5), b as (get a where ranking < 10 union get Song where ranking = 1) " +
+			"get b show key, ranking with ordering(ascending ranking)": `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+LoveSong3, 1
+DeadSong2, 6
+Aria1, 8
+`[1:],
+	} {
+		if res, err := RunQuery("test", "main", query, gm); err != nil || res.String() != expected {
+			t.Error("Unexpected result:", query, res, err)
+			return
+		}
+	}
+
+	for query, expected := range map[string]string{
+		"get Author union get Song": "EQL result error in test: Invalid union " +
+			"(Part 2 has 3 columns but the first part has 2 columns)",
+		"with a as get Song": "Parse error in test: Unexpected term " +
+			"(Expected ( before query of named result - <GET>) (Line:1 Pos:11)",
+		"with a as (get Song) get a union": "Parse error in test: Unexpected end (Expected query) (Line:1 Pos:28)",
+		"union get Song":                   "Parse error in test: Unexpected term (Expected query - <UNION>) (Line:1 Pos:1)",
+		"with a as () get a":               "Parse error in test: Unexpected term (Expected query - )) (Line:1 Pos:12)",
+		"with a as (get Song), a as (get Song) get a": "Parse error in test: Unexpected term " +
+			`(Named result is defined twice - "a") (Line:1 Pos:23)`,
+		"with 'a b' as (get Song) get a": "Parse error in test: Unexpected term " +
+			`(Expected name of named result - "a b") (Line:1 Pos:6)`,
+		"with a as (get Song": "Parse error in test: Unexpected end " +
+			"(Expected ) after query of named result) (Line:1 Pos:16)",
+		"with a as (get Song) get Author union\nget a where foo = 'bar": "Parse error in test: Lexical error " +
+			"(Unexpected end while reading value) (Line:2 Pos:19)",
+		"with a as (get Song where = 1) get a": "Parse error in test: Term cannot start an expression (=) (Line:1 Pos:27)",
+		"with a as (get Song) get a from group x": "EQL error in test: Invalid construct " +
+			"(Named result cannot be used with a group scope) (Line:1 Pos:26)",
+	} {
+		if _, err := RunQuery("test", "main", query, gm); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,472 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+// Aggregate functions
+// ===================
+
+/*
+QuantileSketchSize is the maximum number of centroids which are kept by the
+median and percentile functions for each group. Results are exact as long
+as a group has no more distinct values.
+*/
+var QuantileSketchSize = 1000
+
+/*
+aggregateFunc is a show function which aggregates the values of all rows of
+a group. Rows are grouped by the values of all columns which are not
+aggregated.
+*/
+type aggregateFunc interface {
+	FuncShow
+
+	/*
+		newAggregator creates a new aggregator for a group of rows.
+	*/
+	newAggregator() aggregator
+}
+
+/*
+aggregator aggregates a stream of numbers.
+*/
+type aggregator interface {
+
+	/*
+		add adds a number.
+	*/
+	add(v float64)
+
+	/*
+		result returns the result of the aggregation. Returns nil if no
+		number was added.
+	*/
+	result() interface{}
+}
+
+/*
+showAggregateInst returns a function which creates a new showAggregate
+object for a given aggregation.
+*/
+func showAggregateInst(agg string) FuncShowInst {
+	return func(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow, string, string, error) {
+		var percentile float64
+
+		np := len(astNode.Children)
+
+		if agg == "percentile" {
+			if np != 3 {
+				return nil, "", "", fmt.Errorf("Percentile function requires 2 parameters: attribute, percentile")
+			}
+
+			p, err := strconv.ParseFloat(astNode.Children[2].Token.Val, 64)
+			if err != nil || p < 0 || p > 100 {
+				return nil, "", "", fmt.Errorf("Percentile must be a number between 0 and 100: %v",
+					astNode.Children[2].Token.Val)
+			}
+
+			percentile = p
+
+		} else if np != 2 {
+			return nil, "", "", fmt.Errorf("%v function requires 1 parameter: attribute", strings.Title(agg))
+		}
+
+		// The attribute is either an attribute of the root node kind or
+		// given as <step>:<n|e>:<attr>
+
+		colData := astNode.Children[1].Token.Val
+		colDataSplit := strings.Split(colData, ":")
+		isNode := true
+
+		if len(colDataSplit) == 3 && (colDataSplit[1] == "n" || colDataSplit[1] == "e") {
+			isNode = colDataSplit[1] == "n"
+		} else if len(colDataSplit) == 1 {
+			colData = "1:n:" + colData
+		} else {
+			return nil, "", "", fmt.Errorf("Invalid attribute for %v function "+
+				"(must be <attr> or <step>:<n|e>:<attr>): %v", agg, colData)
+		}
+
+		attr := colDataSplit[len(colDataSplit)-1]
+
+		label := strings.Title(agg) + " " + rtp.ni.AttributeDisplayString("", attr)
+		if agg == "percentile" {
+			label = fmt.Sprintf("Percentile %v %v", percentile, rtp.ni.AttributeDisplayString("", attr))
+		}
+
+		return &showAggregate{agg, attr, isNode, percentile / 100}, colData, label, nil
+	}
+}
+
+/*
+showAggregate aggregates an attribute over all rows of a group.
+*/
+type showAggregate struct {
+	agg        string  // Aggregation
+	attr       string  // Attribute which is aggregated
+	isNode     bool    // Flag if the attribute is a node attribute
+	percentile float64 // Percentile of the percentile aggregation (0 - 1)
+}
+
+/*
+name returns the name of the function.
+*/
+func (sa *showAggregate) name() string {
+	return sa.agg
+}
+
+/*
+eval returns the value of the aggregated attribute for a single row.
+*/
+func (sa *showAggregate) eval(node data.Node, edge data.Edge) (interface{}, string, error) {
+	var val interface{}
+
+	if sa.isNode && node != nil {
+		val = node.Attr(sa.attr)
+	} else if !sa.isNode && edge != nil {
+		val = edge.Attr(sa.attr)
+	}
+
+	return val, "", nil
+}
+
+/*
+newAggregator creates a new aggregator for a group of rows.
+*/
+func (sa *showAggregate) newAggregator() aggregator {
+	switch sa.agg {
+	case "sum":
+		return &sumAggregator{}
+	case "avg":
+		return &avgAggregator{}
+	case "min":
+		return &minMaxAggregator{false, nil}
+	case "max":
+		return &minMaxAggregator{true, nil}
+	case "stddev":
+		return &stddevAggregator{}
+	case "median":
+		return &quantileAggregator{0.5, newQuantileSketch(QuantileSketchSize)}
+	}
+	return &quantileAggregator{sa.percentile, newQuantileSketch(QuantileSketchSize)}
+}
+
+/*
+toAggregateNumber converts a value into a number. Returns false if the value
+is not a number.
+*/
+func toAggregateNumber(val interface{}) (float64, bool) {
+	if val == nil {
+		return 0, false
+	}
+
+	num, err := strconv.ParseFloat(fmt.Sprint(val), 64)
+
+	return num, err == nil && !math.IsNaN(num)
+}
+
+/*
+sumAggregator calculates the sum of all numbers.
+*/
+type sumAggregator struct {
+	sum   float64
+	count int
+}
+
+/*
+add adds a number.
+*/
+func (a *sumAggregator) add(v float64) {
+	a.sum += v
+	a.count++
+}
+
+/*
+result returns the sum of all numbers.
+*/
+func (a *sumAggregator) result() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+	return a.sum
+}
+
+/*
+avgAggregator calculates the average of all numbers.
+*/
+type avgAggregator struct {
+	sumAggregator
+}
+
+/*
+result returns the average of all numbers.
+*/
+func (a *avgAggregator) result() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+	return a.sum / float64(a.count)
+}
+
+/*
+minMaxAggregator determines the smallest or the largest number.
+*/
+type minMaxAggregator struct {
+	max bool     // Flag if the largest number should be determined
+	val *float64 // Current result
+}
+
+/*
+add adds a number.
+*/
+func (a *minMaxAggregator) add(v float64) {
+	if a.val == nil || (a.max && v > *a.val) || (!a.max && v < *a.val) {
+		a.val = &v
+	}
+}
+
+/*
+result returns the smallest or the largest number.
+*/
+func (a *minMaxAggregator) result() interface{} {
+	if a.val == nil {
+		return nil
+	}
+	return *a.val
+}
+
+/*
+stddevAggregator calculates the population standard deviation of all numbers
+in a single pass (Welford's algorithm).
+*/
+type stddevAggregator struct {
+	count int
+	mean  float64
+	m2    float64
+}
+
+/*
+add adds a number.
+*/
+func (a *stddevAggregator) add(v float64) {
+	a.count++
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+}
+
+/*
+result returns the standard deviation of all numbers.
+*/
+func (a *stddevAggregator) result() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+	return math.Sqrt(a.m2 / float64(a.count))
+}
+
+/*
+quantileAggregator determines a quantile of all numbers.
+*/
+type quantileAggregator struct {
+	q      float64         // Quantile (0 - 1)
+	sketch *quantileSketch // Sketch of all numbers
+}
+
+/*
+add adds a number.
+*/
+func (a *quantileAggregator) add(v float64) {
+	a.sketch.add(v)
+}
+
+/*
+result returns the quantile of all numbers.
+*/
+func (a *quantileAggregator) result() interface{} {
+	if a.sketch.count == 0 {
+		return nil
+	}
+	return a.sketch.quantile(a.q)
+}
+
+/*
+centroid is a number of values which are represented by their mean.
+*/
+type centroid struct {
+	mean  float64
+	count int
+}
+
+/*
+quantileSketch summarises a stream of numbers with a bounded number of
+centroids. If there are more distinct numbers than centroids then the two
+closest centroids are merged - quantiles become approximations.
+*/
+type quantileSketch struct {
+	centroids []centroid // Centroids ordered by their mean
+	size      int        // Maximum number of centroids
+	count     int        // Number of added values
+}
+
+/*
+newQuantileSketch creates a new quantileSketch object.
+*/
+func newQuantileSketch(size int) *quantileSketch {
+	if size < 2 {
+		size = 2
+	}
+	return &quantileSketch{nil, size, 0}
+}
+
+/*
+add adds a number to the sketch.
+*/
+func (qs *quantileSketch) add(v float64) {
+	qs.count++
+
+	i := sort.Search(len(qs.centroids), func(i int) bool {
+		return qs.centroids[i].mean >= v
+	})
+
+	if i < len(qs.centroids) && qs.centroids[i].mean == v {
+		qs.centroids[i].count++
+		return
+	}
+
+	qs.centroids = append(qs.centroids, centroid{})
+	copy(qs.centroids[i+1:], qs.centroids[i:])
+	qs.centroids[i] = centroid{v, 1}
+
+	if len(qs.centroids) > qs.size {
+
+		// Merge the two closest centroids
+
+		j := 0
+		for k := 1; k < len(qs.centroids)-1; k++ {
+			if qs.centroids[k+1].mean-qs.centroids[k].mean < qs.centroids[j+1].mean-qs.centroids[j].mean {
+				j = k
+			}
+		}
+
+		c1, c2 := qs.centroids[j], qs.centroids[j+1]
+		count := c1.count + c2.count
+
+		qs.centroids[j] = centroid{(c1.mean*float64(c1.count) + c2.mean*float64(c2.count)) / float64(count), count}
+		qs.centroids = append(qs.centroids[:j+1], qs.centroids[j+2:]...)
+	}
+}
+
+/*
+quantile returns a quantile (0 - 1) of all added numbers. Values between
+ranks are linearly interpolated.
+*/
+func (qs *quantileSketch) quantile(q float64) float64 {
+	rank := q * float64(qs.count-1)
+	lower := int(math.Floor(rank))
+
+	// Value at a given rank
+
+	valueAt := func(r int) float64 {
+		cum := 0
+		for _, c := range qs.centroids {
+			cum += c.count
+			if r < cum {
+				return c.mean
+			}
+		}
+		return qs.centroids[len(qs.centroids)-1].mean
+	}
+
+	v1 := valueAt(lower)
+	v2 := valueAt(int(math.Ceil(rank)))
+
+	return v1 + (v2-v1)*(rank-float64(lower))
+}
+
+/*
+resultGroup is a group of rows of a search result with aggregated columns.
+*/
+type resultGroup struct {
+	row  []interface{} // Values of the first row of the group
+	src  []string      // Sources of the first row of the group
+	aggs []aggregator  // Aggregators of the aggregated columns
+}
+
+/*
+aggregateRow adds a row to its group. Rows are grouped by the values of all
+columns which are not aggregated.
+*/
+func (sr *SearchResult) aggregateRow(row []interface{}, src []string) {
+	var key bytes.Buffer
+
+	for i, val := range row {
+		if sr.aggCols[i] == nil {
+			fmt.Fprintf(&key, "%q#", fmt.Sprint(val))
+		}
+	}
+
+	group, ok := sr.groups[key.String()]
+
+	if !ok {
+		group = &resultGroup{row, src, make([]aggregator, len(row))}
+
+		for i, af := range sr.aggCols {
+			if af != nil {
+				group.aggs[i] = af.newAggregator()
+			}
+		}
+
+		sr.groups[key.String()] = group
+		sr.groupOrder = append(sr.groupOrder, group)
+	}
+
+	for i, agg := range group.aggs {
+		if agg != nil {
+			if num, ok := toAggregateNumber(row[i]); ok {
+				agg.add(num)
+			}
+		}
+	}
+}
+
+/*
+finishAggregation replaces the rows of the search result with one row for
+each group.
+*/
+func (sr *SearchResult) finishAggregation() {
+	for _, group := range sr.groupOrder {
+
+		for i, agg := range group.aggs {
+			if agg != nil {
+				group.row[i] = agg.result()
+				group.src[i] = ""
+			}
+		}
+
+		sr.Data = append(sr.Data, group.row)
+		sr.Source = append(sr.Source, group.src)
+	}
+
+	sr.groups = nil
+	sr.groupOrder = nil
+}
@@ -0,0 +1,140 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/krotik/eliasdb/eql/parser"
+)
+
+func TestAggregateFunctions(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Aggregate over the whole result
+
+	if _, err := getResult("get Song show @count(1, :::Author), @sum(ranking), @avg(ranking), "+
+		"@min(ranking), @max(ranking), @median(ranking)", `
+Labels: Count, Sum Ranking, Avg Ranking, Min Ranking, Max Ranking, Median Ranking
+Format: auto, auto, auto, auto, auto, auto
+Data: 1:func:count(), 1:func:sum(), 1:func:avg(), 1:func:min(), 1:func:max(), 1:func:median()
+1, 66, 7.333333333333333, 1, 19, 5
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Rows are grouped by all columns which are not aggregated
+
+	if _, err := getResult("get Author traverse :::Song end show name, @median(2:n:ranking), "+
+		"@percentile(2:n:ranking, 95) as p95 with ordering(ascending name)", `
+Labels: Author Name, Median Ranking, p95
+Format: auto, auto, auto
+Data: 1:n:name, 2:func:median(), 2:func:percentile()
+Hans, 19, 19
+John, 6, 16.499999999999996
+Mike, 4, 5.85
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The last digits of the standard deviation depend on the order of the rows
+
+	ast, err := parser.ParseWithRuntime("test", "get Author traverse :::Song end show name, "+
+		"@stddev(2:n:ranking) with ordering(ascending name)", rt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := ast.Runtime.Eval()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sr := res.(*SearchResult)
+
+	for i, expected := range []float64{0, 6.164414002968976, 1.920286436967152} {
+		if sr.RowCount() != 3 || math.Abs(sr.Row(i)[1].(float64)-expected) > 1e-9 {
+			t.Error("Unexpected result:", res)
+			return
+		}
+	}
+
+	// Values which are not numbers are ignored
+
+	if _, err := getResult("get Author show name, @sum(name), @median(name)", `
+Labels: Author Name, Sum Name, Median Name
+Format: auto, auto, auto
+Data: 1:n:name, 1:func:sum(), 1:func:median()
+Hans, <not set>, <not set>
+John, <not set>, <not set>
+Mike, <not set>, <not set>
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test error cases
+
+	for query, msg := range map[string]string{
+		"get Song show @percentile(ranking)": "EQL error in test: Invalid construct " +
+			"(Percentile function requires 2 parameters: attribute, percentile) (Line:1 Pos:15)",
+		"get Song show @percentile(ranking, 101)": "EQL error in test: Invalid construct " +
+			"(Percentile must be a number between 0 and 100: 101) (Line:1 Pos:15)",
+		"get Song show @median(ranking, 1)": "EQL error in test: Invalid construct " +
+			"(Median function requires 1 parameter: attribute) (Line:1 Pos:15)",
+		"get Song show @sum(Song:ranking)": "EQL error in test: Invalid construct " +
+			"(Invalid attribute for sum function (must be <attr> or <step>:<n|e>:<attr>): Song:ranking) (Line:1 Pos:15)",
+	} {
+		if _, err := getResult(query, "", rt, true); err == nil || err.Error() != msg {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+}
+
+func TestQuantileSketch(t *testing.T) {
+	qs := newQuantileSketch(3)
+
+	for _, v := range []float64{3, 1, 2, 2} {
+		qs.add(v)
+	}
+
+	if res := qs.quantile(0.5); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Large streams are approximated with a bounded number of centroids
+
+	qs = newQuantileSketch(50)
+
+	for i := 1000; i > 0; i-- {
+		qs.add(float64(i))
+	}
+
+	if len(qs.centroids) != 50 || qs.count != 1000 {
+		t.Error("Unexpected result:", len(qs.centroids), qs.count)
+		return
+	}
+
+	for q, expected := range map[float64]float64{0: 1, 0.5: 500.5, 0.95: 950.05, 1: 1000} {
+		if res := qs.quantile(q); math.Abs(res-expected) > 20 {
+			t.Error("Unexpected result:", q, res)
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Query composition
+// =================
+
+/*
+namedResult is the result of a query which was given a name within a composed
+query. Queries can use the name instead of a node kind to start from the
+primary nodes of the result.
+*/
+type namedResult struct {
+	kind string   // Node kind of the primary nodes
+	keys []string // Keys of the primary nodes in result order
+}
+
+/*
+SetNamedResult makes a given result available under a given name. A GET query
+which uses the name instead of a node kind starts from the primary nodes of the
+result. A name shadows a node kind of the same name.
+*/
+func (rtp *GetRuntimeProvider) SetNamedResult(name string, res *SearchResult) {
+
+	if rtp.namedResults == nil {
+		rtp.namedResults = make(map[string]*namedResult)
+	}
+
+	kind := res.PrimaryKind()
+	prefix := "n:" + kind + ":"
+	seen := make(map[string]bool)
+	keys := make([]string, 0, res.RowCount())
+
+	for _, src := range res.Source {
+
+		// The first column which shows a primary node determines the row's node
+
+		for _, s := range src {
+			if strings.HasPrefix(s, prefix) {
+				if key := s[len(prefix):]; !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+				break
+			}
+		}
+	}
+
+	rtp.namedResults[name] = &namedResult{kind, keys}
+}
+
+/*
+UnionResults combines the rows of several results into a new result. All
+results must have the same number of columns - the header is taken from the
+first result. Duplicate rows are removed unless the all flag is set.
+*/
+func UnionResults(name string, query string, results []*SearchResult, all bool) (*SearchResult, error) {
+
+	first := results[0]
+	res := &SearchResult{name, query, &withFlags{}, first.hints, first.SearchHeader, first.colFunc, nil,
+		make(map[string]*resultGroup), nil, make([][]string, 0), make([][]interface{}, 0), false, -1}
+
+	seen := make(map[string]bool)
+
+	for i, r := range results {
+
+		if len(r.ColLabels) != len(first.ColLabels) {
+			return nil, &ResultError{name, ErrInvalidUnion,
+				fmt.Sprintf("Part %v has %v columns but the first part has %v columns",
+					i+1, len(r.ColLabels), len(first.ColLabels))}
+		}
+
+		for j, row := range r.Data {
+
+			if !all {
+				rowKey := fmt.Sprintf("%#v", row)
+				if seen[rowKey] {
+					continue
+				}
+				seen[rowKey] = true
+			}
+
+			res.Data = append(res.Data, row)
+			res.Source = append(res.Source, r.Source[j])
+		}
+	}
+
+	return res, nil
+}
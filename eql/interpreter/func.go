@@ -14,8 +14,12 @@ Package interpreter contains the EQL interpreter.
 package interpreter
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,7 +27,9 @@ import (
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/common/errorutil"
 	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
 )
 
 // Where related functions
@@ -39,8 +45,25 @@ type FuncWhere func(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 Runtime map for where related functions
 */
 var whereFunc = map[string]FuncWhere{
-	"count":     whereCount,
-	"parseDate": whereParseDate,
+	"abs":        functionWhere(mathFunc("abs", math.Abs)),
+	"bucket":     whereBucket,
+	"ceil":       functionWhere(mathFunc("ceil", math.Ceil)),
+	"concat":     whereConcat,
+	"count":      whereCount,
+	"date":       whereDate,
+	"dateDiff":   whereDateDiff,
+	"distance":   whereDistance,
+	"floor":      functionWhere(mathFunc("floor", math.Floor)),
+	"formatDate": whereFormatDate,
+	"inLast":     whereInLast,
+	"length":     functionWhere(funcLength),
+	"lower":      functionWhere(stringFunc("lower", strings.ToLower)),
+	"now":        whereNow,
+	"parseDate":  whereParseDate,
+	"round":      functionWhere(funcRound),
+	"substr":     functionWhere(funcSubstr),
+	"trim":       functionWhere(funcTrim),
+	"upper":      functionWhere(stringFunc("upper", strings.ToUpper)),
 }
 
 /*
@@ -53,6 +76,16 @@ func whereCount(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 
 	np := len(astNode.Children)
 
+	if np > 1 && astNode.Children[1].Name == parser.NodeTRAVERSE {
+
+		if np != 2 {
+			return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+				"Count function requires 1 parameter if a subquery is given: traversal", astNode)
+		}
+
+		return countSubquery(astNode.Children[1], rtp, node, 0)
+	}
+
 	if np != 2 && np != 3 {
 		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
 			"Count function requires 1 or 2 parameters: traversal spec, condition clause", astNode)
@@ -103,6 +136,108 @@ func whereCount(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 	return len(nodes), err
 }
 
+/*
+countSubquery counts the nodes which can be reached from a given node via the
+traversal of a subquery (e.g. traverse :::Friend where age > 30 end). A reached
+node is only counted if it matches the where clause of the traversal and if
+every nested traversal reaches at least one node from it. Nested traversals
+stop after the first matching node. Counting stops after max nodes (0 for no
+limit).
+*/
+func countSubquery(travNode *parser.ASTNode, rtp *eqlRuntimeProvider, node data.Node, max int) (int, error) {
+	var count int
+
+	spec := travNode.Children[0].Token.Val
+
+	if len(strings.Split(spec, ":")) != 4 {
+		return 0, rtp.newRuntimeError(ErrInvalidSpec, spec, travNode)
+	}
+
+	ctx := rtp.ctx
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Nodes which would exceed the visited node limit are not read
+
+	nodes, edges, err := rtp.gm.TraverseMultiLimit(ctx, rtp.part, node.Key(), node.Kind(), spec, true,
+		rtp.visitLimit())
+
+	if gerr, ok := err.(*util.GraphError); ok && gerr.Type == util.ErrTraversalLimit {
+		return 0, rtp.newRuntimeError(ErrTraversalLimit, fmt.Sprintf(
+			"Query visited more than %v nodes", rtp.maxVisitedNodes), travNode)
+	} else if err != nil {
+		return 0, err
+	}
+
+	rtp.addVisited(len(nodes))
+
+	for i, n := range nodes {
+		match := true
+
+		for _, child := range travNode.Children[1:] {
+
+			if child.Name == parser.NodeWHERE {
+				var res interface{}
+
+				if res, err = child.Runtime.(CondRuntime).CondEval(n, edges[i]); err == nil {
+					match = res == true
+				}
+
+			} else if child.Name == parser.NodeTRAVERSE {
+				var c int
+
+				if c, err = countSubquery(child, rtp, n, 1); err == nil {
+					match = c > 0
+				}
+
+			} else {
+				err = rtp.newRuntimeError(ErrInvalidConstruct,
+					"Subqueries can only contain where clauses and traversals", child)
+			}
+
+			if err != nil {
+				return 0, err
+			} else if !match {
+				break
+			}
+		}
+
+		if match {
+			if count++; count == max {
+				break
+			}
+		}
+	}
+
+	return count, nil
+}
+
+/*
+whereConcat joins the string representations of all parameters. Parameters
+without a value are skipped.
+*/
+func whereConcat(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	var buf bytes.Buffer
+
+	for _, child := range astNode.Children[1:] {
+
+		val, err := child.Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		if val != nil {
+			buf.WriteString(fmt.Sprint(val))
+		}
+	}
+
+	return buf.String(), nil
+}
+
 /*
 whereParseDate converts a date string into a unix time value.
 */
@@ -149,6 +284,417 @@ func whereParseDate(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 	return ret, err
 }
 
+/*
+whereDistance calculates the distance in km between a location attribute and
+a given location or between two given locations. Returns null if the attribute
+does not hold a location.
+*/
+func whereDistance(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	np := len(astNode.Children)
+
+	if np != 4 && np != 5 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"distance function requires 3 or 4 parameters: location attribute, latitude, longitude or "+
+				"latitude1, longitude1, latitude2, longitude2", astNode)
+	}
+
+	// Evaluate the parameters
+
+	vals := make([]float64, 0, 4)
+
+	for i, child := range astNode.Children[1:] {
+
+		val, err := child.Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 && np == 4 {
+			p, ok := data.ToGeoPoint(val)
+			if !ok {
+				return nil, nil
+			}
+
+			vals = append(vals, p.Lat, p.Lon)
+			continue
+		}
+
+		num, err := strconv.ParseFloat(fmt.Sprint(val), 64)
+		if err != nil {
+			return nil, rtp.newRuntimeError(ErrNotANumber, fmt.Sprint(val), child)
+		}
+
+		vals = append(vals, num)
+	}
+
+	return util.GeoDistance(vals[0], vals[1], vals[2], vals[3]), nil
+}
+
+/*
+whereInLast checks if the timestamp of a traversed edge lies within a given
+time span before now (e.g. '7d'). Traversals which have this function in their
+where clause only follow edges within the time span (see graph.TraverseTimeRange).
+*/
+func whereInLast(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	if len(astNode.Children) != 2 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"inLast function requires 1 parameter: time span", astNode)
+	} else if edge == nil {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"inLast function can only be used in traversals", astNode)
+	}
+
+	span, err := inLastTimeSpan(astNode, rtp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the timestamp if the edge has only the minimal set of attributes
+
+	if edge.Attr(data.EdgeTimestamp) == nil {
+		e, err := rtp.gm.FetchEdgePart(rtp.part, edge.Key(), edge.Kind(), []string{data.EdgeTimestamp})
+		if err != nil {
+			return nil, err
+		} else if e != nil {
+			edge.SetAttr(data.EdgeTimestamp, e.Attr(data.EdgeTimestamp))
+		}
+	}
+
+	t, ok := graph.EdgeTime(edge)
+	now := time.Now()
+
+	return ok && !t.Before(now.Add(-span)) && !t.After(now), nil
+}
+
+/*
+inLastTimeSpan evaluates the time span parameter of an inLast function call.
+*/
+func inLastTimeSpan(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (time.Duration, error) {
+
+	val, err := astNode.Children[1].Runtime.(CondRuntime).CondEval(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	span, err := parseTimeSpan(fmt.Sprint(val))
+	if err != nil {
+		return 0, rtp.newRuntimeError(ErrInvalidConstruct,
+			fmt.Sprintf("Invalid time span for inLast function: %v", val), astNode)
+	}
+
+	return span, nil
+}
+
+/*
+whereBucket maps a timestamp to the start of the time bucket which contains
+it (e.g. the start of the hour for '1h'). Timestamps can be unix times or
+RFC3339 date strings. Buckets are aligned to the unix epoch in UTC - buckets
+which are a multiple of a week start on Mondays. Returns the start of the
+bucket as unix time or as a date string if a layout is given. Returns null
+if the value is not a timestamp.
+*/
+func whereBucket(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	np := len(astNode.Children)
+
+	if np != 3 && np != 4 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"bucket function requires 2 or 3 parameters: timestamp, time span, layout", astNode)
+	}
+
+	val, err := astNode.Children[2].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
+
+	span, err := parseTimeSpan(fmt.Sprint(val))
+	if err != nil || span < time.Second {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			fmt.Sprintf("Invalid time span for bucket function: %v", val), astNode)
+	}
+
+	if val, err = astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge); err != nil {
+		return nil, err
+	}
+
+	ts, ok := unixTimestamp(val)
+	if !ok {
+		return nil, nil
+	}
+
+	// Weekly buckets are shifted so they start on Mondays (the unix epoch
+	// was a Thursday)
+
+	secs := int64(span / time.Second)
+	offset := int64(0)
+
+	if span%(7*24*time.Hour) == 0 {
+		offset = 3 * 24 * 60 * 60
+	}
+
+	start := ts + offset
+	start -= ((start % secs) + secs) % secs
+	start -= offset
+
+	if np == 4 {
+		layout, err := astNode.Children[3].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		return time.Unix(start, 0).UTC().Format(fmt.Sprint(layout)), nil
+	}
+
+	return start, nil
+}
+
+/*
+unixTimestamp converts a value into a unix time. Values can be unix times or
+RFC3339 date strings.
+*/
+func unixTimestamp(val interface{}) (int64, bool) {
+
+	switch v := val.(type) {
+	case nil:
+		return 0, false
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case time.Time:
+		return v.Unix(), true
+	}
+
+	str := fmt.Sprint(val)
+
+	if f, err := strconv.ParseFloat(str, 64); err == nil {
+		return int64(f), true
+	} else if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t.Unix(), true
+	}
+
+	return 0, false
+}
+
+/*
+timeSpanUnits are the units of time spans.
+*/
+var timeSpanUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+/*
+parseTimeSpan parses a time span such as 7d, 12h or 2 weeks.
+*/
+func parseTimeSpan(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+
+	if i > 0 {
+		num, err := strconv.ParseFloat(s[:i], 64)
+		unit, ok := timeSpanUnits[strings.TrimSpace(s[i:])]
+
+		if err == nil && ok && num > 0 {
+			return time.Duration(num * float64(unit)), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid time span: %v", s)
+}
+
+/*
+whereNow returns the current time as unix time. An optional time span (e.g.
+'-7d' or '1h') is added to the current time. Returns a date string if a layout
+is given.
+*/
+func whereNow(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	np := len(astNode.Children)
+
+	if np > 3 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"now function requires at most 2 parameters: time span, layout", astNode)
+	}
+
+	now := time.Now()
+
+	if np > 1 {
+		val, err := astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		span, err := parseSignedTimeSpan(fmt.Sprint(val))
+		if err != nil {
+			return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+				fmt.Sprintf("Invalid time span for now function: %v", val), astNode)
+		}
+
+		now = now.Add(span)
+	}
+
+	if np == 3 {
+		layout, err := astNode.Children[2].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		return now.UTC().Format(fmt.Sprint(layout)), nil
+	}
+
+	return now.Unix(), nil
+}
+
+/*
+whereDate converts a datetime value into a unix time. Values can be unix times
+or RFC3339 date strings. Date strings in other formats can be converted with a
+layout (see whereParseDate). Returns null if the value is not a datetime value.
+*/
+func whereDate(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	np := len(astNode.Children)
+
+	if np != 2 && np != 3 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"date function requires 1 or 2 parameters: datetime value, layout", astNode)
+	}
+
+	val, err := astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil || val == nil {
+		return nil, err
+	}
+
+	if np == 3 {
+		layout, err := astNode.Children[2].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		if t, err := time.Parse(fmt.Sprint(layout), fmt.Sprint(val)); err == nil {
+			return t.Unix(), nil
+		}
+
+		return nil, nil
+	}
+
+	if ts, ok := unixTimestamp(val); ok {
+		return ts, nil
+	}
+
+	return nil, nil
+}
+
+/*
+whereDateDiff calculates the difference between two datetime values (first
+minus second value) in whole units of a given time span (e.g. 'd' or '1h').
+The difference is calculated in seconds if no unit is given. Returns null if
+one of the values is not a datetime value.
+*/
+func whereDateDiff(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	np := len(astNode.Children)
+
+	if np != 3 && np != 4 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"dateDiff function requires 2 or 3 parameters: datetime value, datetime value, unit", astNode)
+	}
+
+	unit := time.Second
+
+	if np == 4 {
+		val, err := astNode.Children[3].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+
+		if unit, ok = timeSpanUnits[strings.TrimSpace(fmt.Sprint(val))]; !ok {
+			if unit, err = parseTimeSpan(fmt.Sprint(val)); err != nil || unit < time.Second {
+				return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+					fmt.Sprintf("Invalid unit for dateDiff function: %v", val), astNode)
+			}
+		}
+	}
+
+	var ts [2]int64
+
+	for i, child := range astNode.Children[1:3] {
+
+		val, err := child.Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+
+		if ts[i], ok = unixTimestamp(val); !ok {
+			return nil, nil
+		}
+	}
+
+	return (ts[0] - ts[1]) / int64(unit/time.Second), nil
+}
+
+/*
+whereFormatDate formats a datetime value as date string in UTC with a given
+layout (see whereParseDate). Returns null if the value is not a datetime value.
+*/
+func whereFormatDate(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	if len(astNode.Children) != 3 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"formatDate function requires 2 parameters: datetime value, layout", astNode)
+	}
+
+	val, err := astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, ok := unixTimestamp(val)
+	if !ok {
+		return nil, nil
+	}
+
+	layout, err := astNode.Children[2].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
+
+	return time.Unix(ts, 0).UTC().Format(fmt.Sprint(layout)), nil
+}
+
+/*
+parseSignedTimeSpan parses a time span which can be negative (e.g. -7d).
+*/
+func parseSignedTimeSpan(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "-") {
+		span, err := parseTimeSpan(s[1:])
+		return -span, err
+	}
+
+	return parseTimeSpan(strings.TrimPrefix(s, "+"))
+}
+
 // Show related functions
 // ======================
 
@@ -156,8 +702,16 @@ func whereParseDate(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 Runtime map for show related functions
 */
 var showFunc = map[string]FuncShowInst{
-	"count":  showCountInst,
-	"objget": showObjgetInst,
+	"avg":        showAggregateInst("avg"),
+	"count":      showCountInst,
+	"max":        showAggregateInst("max"),
+	"median":     showAggregateInst("median"),
+	"min":        showAggregateInst("min"),
+	"objget":     showObjgetInst,
+	"percentile": showAggregateInst("percentile"),
+	"reach":      showReachInst,
+	"stddev":     showAggregateInst("stddev"),
+	"sum":        showAggregateInst("sum"),
 }
 
 /*
@@ -200,6 +754,13 @@ func showCountInst(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow,
 
 	np := len(astNode.Children)
 
+	if np > 1 && astNode.Children[1].Name == parser.NodeTRAVERSE {
+
+		// Subqueries are evaluated for each row like in a where clause
+
+		return showExprInst(astNode, rtp)
+	}
+
 	if np != 3 && np != 4 {
 		return nil, "", "", errors.New("Count function requires 2 or 3 parameters: traversal step, traversal spec, condition clause")
 	}
@@ -289,6 +850,54 @@ func (sc *showCount) eval(node data.Node, edge data.Edge) (interface{}, string,
 	return len(nodes), srcQuery, nil
 }
 
+// Show Expression
+// ---------------
+
+/*
+showExprInst creates a new showExpr object. Expressions operate on the
+attributes of the root node kind.
+*/
+func showExprInst(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow, string, string, error) {
+
+	// Determine which values are node attributes and make sure they are queried
+
+	if err := (&whereRuntime{rtp, astNode, 0}).Validate(); err != nil {
+		return nil, "", "", err
+	}
+
+	label, err := parser.PrettyPrint(astNode)
+
+	return &showExpr{rtp, astNode}, "1:n:" + data.NodeKey, label, err
+}
+
+/*
+showExpr evaluates an expression (e.g. price * quantity) for each row.
+*/
+type showExpr struct {
+	rtp     *eqlRuntimeProvider
+	astNode *parser.ASTNode
+}
+
+/*
+name returns the name of the function.
+*/
+func (se *showExpr) name() string {
+	return "expr"
+}
+
+/*
+eval evaluates the expression with the attributes of a given node.
+*/
+func (se *showExpr) eval(node data.Node, edge data.Edge) (interface{}, string, error) {
+
+	res, err := se.astNode.Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res, "n:" + node.Kind() + ":" + node.Key(), nil
+}
+
 // Show Objget
 // -----------
 
@@ -341,3 +950,148 @@ func (so *showObjget) eval(node data.Node, edge data.Edge) (interface{}, string,
 
 	return val, "n:" + node.Kind() + ":" + node.Key(), nil
 }
+
+// Show Reach
+// ----------
+
+/*
+Aggregation modes of the reach function
+*/
+const (
+	reachFirst = "first"
+	reachList  = "list"
+	reachCount = "count"
+)
+
+/*
+showReachInst creates a new showReach object.
+*/
+func showReachInst(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow, string, string, error) {
+
+	// Check parameters
+
+	np := len(astNode.Children)
+
+	if np < 5 {
+		return nil, "", "", errors.New("Reach function requires at least 4 parameters: traversal step, " +
+			"traversal spec, ..., attribute name, aggregation mode")
+	}
+
+	pos := astNode.Children[1].Token.Val
+	attr := astNode.Children[np-2].Token.Val
+	mode := astNode.Children[np-1].Token.Val
+
+	if mode != reachFirst && mode != reachList && mode != reachCount {
+		return nil, "", "", fmt.Errorf("Unknown aggregation mode for reach function: %v", mode)
+	}
+
+	var specs []string
+
+	for _, c := range astNode.Children[2 : np-2] {
+		specs = append(specs, c.Token.Val)
+	}
+
+	label := rtp.ni.AttributeDisplayString("", attr)
+	if mode == reachCount {
+		label = "Count"
+	}
+
+	return &showReach{rtp, specs, attr, mode}, pos + ":n:" + data.NodeKey, label, nil
+}
+
+/*
+showReach aggregates an attribute of all distinct nodes which can be reached
+via a sequence of traversal specs.
+*/
+type showReach struct {
+	rtp   *eqlRuntimeProvider
+	specs []string
+	attr  string
+	mode  string
+}
+
+/*
+name returns the name of the function.
+*/
+func (sr *showReach) name() string {
+	return "reach"
+}
+
+/*
+eval follows the traversal specs from a given node and aggregates the
+attribute values of the reached nodes.
+*/
+func (sr *showReach) eval(node data.Node, edge data.Edge) (interface{}, string, error) {
+	nodes := []data.Node{node}
+
+	for i, spec := range sr.specs {
+		var next []data.Node
+
+		// Nodes which can be reached via several paths are only visited once
+
+		seen := make(map[string]bool)
+
+		for _, n := range nodes {
+
+			// Only need to retrieve full node values for the last spec
+
+			tnodes, _, err := sr.rtp.gm.TraverseMulti(sr.rtp.part, n.Key(), n.Kind(),
+				spec, i == len(sr.specs)-1)
+			if err != nil {
+				return nil, "", err
+			}
+
+			for _, tn := range tnodes {
+				id := tn.Kind() + "#" + tn.Key()
+
+				if !seen[id] {
+					seen[id] = true
+					next = append(next, tn)
+				}
+			}
+		}
+
+		nodes = next
+	}
+
+	// Produce a stable order of the reached nodes
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Key() == nodes[j].Key() {
+			return nodes[i].Kind() < nodes[j].Kind()
+		}
+		return nodes[i].Key() < nodes[j].Key()
+	})
+
+	var vals []interface{}
+
+	for _, n := range nodes {
+		if val := n.Attr(sr.attr); val != nil {
+			vals = append(vals, val)
+		}
+	}
+
+	var res interface{}
+
+	switch sr.mode {
+	case reachFirst:
+		if len(vals) > 0 {
+			res = vals[0]
+		}
+	case reachList:
+		if vals == nil {
+			vals = []interface{}{}
+		}
+		res = vals
+	case reachCount:
+		res = len(vals)
+	}
+
+	step := len(sr.specs) + 1
+
+	srcQuery := fmt.Sprintf("q:lookup %s %s traverse %s%s show %v:n:%s, %v:n:%s, %v:n:%s",
+		node.Kind(), strconv.Quote(node.Key()), strings.Join(sr.specs, " traverse "),
+		strings.Repeat(" end", len(sr.specs)), step, data.NodeKey, step, data.NodeKind, step, sr.attr)
+
+	return res, srcQuery, nil
+}
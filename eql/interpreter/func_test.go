@@ -10,7 +10,15 @@
 
 package interpreter
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
 
 func TestDateFunctions(t *testing.T) {
 	gm, _ := dateGraph()
@@ -54,6 +62,329 @@ Data: 1:n:key, 1:n:RFC3339_value, 1:n:naive_value, 1:n:name, 1:n:unix
 	}
 }
 
+func TestDistanceFunction(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for _, c := range []struct {
+		key      string
+		lat, lon float64
+	}{{"berlin", 52.52, 13.405}, {"hamburg", 53.55, 9.99}, {"potsdam", 52.39, 13.06}} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", c.key)
+		node.SetAttr("kind", "city")
+		node.SetAttr("loc", &data.GeoPoint{Lat: c.lat, Lon: c.lon})
+		gm.StoreNode("main", node)
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "nowhere")
+	node.SetAttr("kind", "city")
+	node.SetAttr("loc", "foo")
+	gm.StoreNode("main", node)
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get city where @distance(loc, 52.5, 13.3) < 40 show key", `
+Labels: City Key
+Format: auto
+Data: 1:n:key
+berlin
+potsdam
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get city where @distance(52.52, 13.405, 53.55, 9.99) > 250 and @distance(loc, 53.55, 9.99) < 1 show key", `
+Labels: City Key
+Format: auto
+Data: 1:n:key
+hamburg
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get city where @distance(loc, 52.5)", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (distance function requires 3 or 4 parameters: location attribute, latitude, longitude or latitude1, longitude1, latitude2, longitude2) (Line:1 Pos:16)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get city where @distance(loc, 'a', 13.3) < 40", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Value of operand is not a number (a) (Line:1 Pos:31)" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestInLastFunction(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for _, key := range []string{"u1", "p1", "p2", "p3"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", map[bool]string{true: "user", false: "page"}[key == "u1"])
+		gm.StoreNode("main", node)
+	}
+
+	now := time.Now()
+
+	for i, c := range []struct {
+		page string
+		ts   interface{}
+	}{{"p1", now.Add(-time.Hour)}, {"p2", now.Add(-72 * time.Hour)}, {"p3", now.Add(-240 * time.Hour)}, {"p3", nil}} {
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", fmt.Sprint("v", i))
+		edge.SetAttr("kind", "visited")
+		edge.SetAttr(data.EdgeEnd1Key, "u1")
+		edge.SetAttr(data.EdgeEnd1Kind, "user")
+		edge.SetAttr(data.EdgeEnd1Role, "visitor")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, c.page)
+		edge.SetAttr(data.EdgeEnd2Kind, "page")
+		edge.SetAttr(data.EdgeEnd2Role, "page")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		if c.ts != nil {
+			edge.SetAttr(data.EdgeTimestamp, c.ts)
+		}
+		gm.StoreEdge("main", edge)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get user traverse :visited:: where @inLast('7d') end show 2:n:key", `
+Labels: Key
+Format: auto
+Data: 2:n:key
+p1
+p2
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get user traverse :visited::page where @inLast('2 hours') and key = 'p1' end show 2:n:key", `
+Labels: Key
+Format: auto
+Data: 2:n:key
+p1
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Traversals without an edge kind check the timestamps of all edges
+
+	if _, err := getResult("get user traverse ::: where @inLast('30d') end show 2:n:key", `
+Labels: Key
+Format: auto
+Data: 2:n:key
+p1
+p2
+p3
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get user traverse :visited:: where @inLast() end", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (inLast function requires 1 parameter: time span) (Line:1 Pos:36)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get user where @inLast('7d')", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (inLast function can only be used in traversals) (Line:1 Pos:16)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get user traverse :visited:: where @inLast('7 years') end", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (Invalid time span for inLast function: 7 years) (Line:1 Pos:36)" {
+		t.Error(err)
+		return
+	}
+
+	for _, test := range []struct {
+		span string
+		res  string
+	}{{"7d", "168h0m0s"}, {" 1.5 h ", "1h30m0s"}, {"2 weeks", "336h0m0s"}, {"30m", "30m0s"},
+		{"d", "Invalid time span: d"}, {"0d", "Invalid time span: 0d"}, {"5", "Invalid time span: 5"}} {
+
+		res, err := parseTimeSpan(test.span)
+		if err != nil && err.Error() != test.res || err == nil && res.String() != test.res {
+			t.Error("Unexpected result:", test.span, res, err)
+			return
+		}
+	}
+}
+
+func TestBucketFunction(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for key, ts := range map[string]interface{}{
+		"e1": 1700000000, "e2": 1700001000, "e3": "1700003700",
+		"e4": "2023-11-15T01:05:00Z", "e5": nil, "e6": "tomorrow",
+	} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "event")
+		node.SetAttr("ts", ts)
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get event show key, @bucket(ts, '1h') as hour, "+
+		"@bucket(ts, '1d', '2006-01-02'), @bucket(ts, '1w', '2006-01-02') as week "+
+		"with ordering(ascending key)", `
+Labels: Event Key, hour, @bucket(ts, 1d, "2006-01-02"), week
+Format: auto, auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr(), 1:func:expr()
+e1, 1699999200, 2023-11-14, 2023-11-13
+e2, 1699999200, 2023-11-14, 2023-11-13
+e3, 1700002800, 2023-11-14, 2023-11-13
+e4, 1700010000, 2023-11-15, 2023-11-13
+e5, <not set>, <not set>, <not set>
+e6, <not set>, <not set>, <not set>
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Buckets can be grouped by referring to the label of the column
+
+	if _, err := getResult("get event show @bucket(ts, '1h') as hour "+
+		"with filtering(isnotnull hour, uniquecount hour), ordering(ascending hour)", `
+Labels: hour
+Format: auto
+Data: 1:func:expr()
+1699999200 (2)
+1700002800 (1)
+1700010000 (1)
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get event where @bucket(ts, '1d') = @parseDate('2023-11-15', '2006-01-02')", `
+Labels: Event Key, Ts
+Format: auto, auto
+Data: 1:n:key, 1:n:ts
+e4, 2023-11-15T01:05:00Z
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get event where @bucket(ts) = 1", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (bucket function requires 2 or 3 parameters: timestamp, time span, layout) (Line:1 Pos:17)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := getResult("get event where @bucket(ts, '1 year') = 1", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (Invalid time span for bucket function: 1 year) (Line:1 Pos:17)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestDateTimeFunctions(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	now := time.Now()
+
+	for key, vals := range map[string][]interface{}{
+		"o1": {now.Add(-2 * time.Hour).Unix(), "2023-11-14T10:00:00Z", "14.11.2023"},
+		"o2": {now.Add(-72 * time.Hour).Format(time.RFC3339), "2023-11-15T12:30:00Z", "15.11.2023"},
+		"o3": {1700000000, "2023-11-13T23:00:00+01:00", "13.11.2023"},
+		"o4": {1600000000, nil, "soon"},
+	} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "order")
+		node.SetAttr("created", vals[0])
+		node.SetAttr("shipped", vals[1])
+		node.SetAttr("due", vals[2])
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Time windows are relative to the current time
+
+	if _, err := getResult("get order where @date(created) >= @now('-1d') show key", `
+Labels: Order Key
+Format: auto
+Data: 1:n:key
+o1
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get order where @now() - @date(created) < 7 * 24 * 3600 "+
+		"show key, @formatDate(shipped, '2006-01-02') as day with ordering(ascending key)", `
+Labels: Order Key, day
+Format: auto, auto
+Data: 1:n:key, 1:func:expr()
+o1, 2023-11-14
+o2, 2023-11-15
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Datetime values can be compared and formatted
+
+	if _, err := getResult("get order where @date(shipped) != null and @date(shipped) > @date('2023-11-14T00:00:00Z') "+
+		"show key, @dateDiff(shipped, '2023-11-14T00:00:00Z', '1h') as hours, "+
+		"@dateDiff(shipped, 1700000000), @formatDate(shipped, '02.01.2006 15:04') as shipped, "+
+		"@date(due, '02.01.2006') as due with ordering(ascending key)", `
+Labels: Order Key, hours, @dateDiff(shipped, 1700000000), shipped, due
+Format: auto, auto, auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr()
+o1, 10, -44000, 14.11.2023 10:00, 1699920000
+o2, 36, 51400, 15.11.2023 12:30, 1700006400
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get order where @date(due, '02.01.2006') = null and @now('+1h', '2006') > '2023' "+
+		"show key, @dateDiff(shipped, 1700000000, 'days'), @formatDate(shipped, '2006')", `
+Labels: Order Key, @dateDiff(shipped, 1700000000, days), @formatDate(shipped, 2006)
+Format: auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr()
+o4, <not set>, <not set>
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for query, expected := range map[string]string{
+		"get order where @now(1, 2, 3)": "EQL error in test: Invalid construct " +
+			"(now function requires at most 2 parameters: time span, layout) (Line:1 Pos:17)",
+		"get order where @now('1 year')": "EQL error in test: Invalid construct " +
+			"(Invalid time span for now function: 1 year) (Line:1 Pos:17)",
+		"get order where @date()": "EQL error in test: Invalid construct " +
+			"(date function requires 1 or 2 parameters: datetime value, layout) (Line:1 Pos:17)",
+		"get order where @dateDiff(shipped)": "EQL error in test: Invalid construct " +
+			"(dateDiff function requires 2 or 3 parameters: datetime value, datetime value, unit) (Line:1 Pos:17)",
+		"get order where @dateDiff(shipped, created, 'ms')": "EQL error in test: Invalid construct " +
+			"(Invalid unit for dateDiff function: ms) (Line:1 Pos:17)",
+		"get order where @formatDate(shipped)": "EQL error in test: Invalid construct " +
+			"(formatDate function requires 2 parameters: datetime value, layout) (Line:1 Pos:17)",
+	} {
+		if _, err := getResult(query, "", rt, true); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+}
+
 func TestCountFunctions(t *testing.T) {
 	gm, _ := songGraphGroups()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -160,6 +491,148 @@ Data: 1:n:key, 1:n:name, 1:func:count()
 	}
 }
 
+func TestCountSubqueries(t *testing.T) {
+	gm, _ := songGraphGroups()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get Author where @count(traverse :::Song where name beginswith 'A' or name beginswith 'L' end) >= 1 "+
+		"show key, name", `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+000, John
+123, Mike
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The end keyword can be omitted
+
+	if _, err := getResult("get Author where @count(traverse :::Song where name beginswith 'L') = 1 and name != 'John' "+
+		"show key, name, @count(traverse :::Song where name beginswith 'A') as arias", `
+Labels: Author Key, Author Name, arias
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:func:expr()
+123, Mike, 0
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nested traversals must reach at least one node
+
+	if _, err := getResult("get Author where @count(traverse :::Song traverse :::group end end) > 1 "+
+		"show name, @count(traverse :::Song traverse :::group end end), "+
+		"@count(traverse :::Song where name beginswith 'S' traverse :::group where key = 'Best' end end) as strange", `
+Labels: Author Name, @count(traverse :::Song traverse :::group end end), strange
+Format: auto, auto, auto
+Data: 1:n:name, 1:func:expr(), 1:func:expr()
+Mike, 2, 1
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for query, expected := range map[string]string{
+		"get Author where @count(traverse :::Song end, 1) > 1": "EQL error in test: Invalid construct " +
+			"(Count function requires 1 parameter if a subquery is given: traversal) (Line:1 Pos:18)",
+		"get Author where @count(traverse :Song end) > 1": "EQL error in test: " +
+			"Invalid traversal spec (:Song) (Line:1 Pos:25)",
+		"get Author where @count(traverse :::Song show name end) > 1": "EQL error in test: Invalid construct " +
+			"(Subqueries can only contain where clauses and traversals) (Line:1 Pos:42)",
+	} {
+		if _, err := getResult(query, "", rt, true); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+
+	// Subqueries are subject to the traversal guards
+
+	rt.SetTraversalGuards(5, false)
+	defer rt.SetTraversalGuards(0, false)
+
+	if _, err := getResult("get Author where @count(traverse :::Song) > 10", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Traversal limit exceeded (Query visited more than 5 nodes) (Line:1 Pos:25)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestReachFunction(t *testing.T) {
+	gm, _ := songGraphGroups()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Authors of the songs in a group - Mike wrote two of the songs but is only listed once
+
+	res, err := getResult("get group show key, @reach(1, :::Song, :::Author, name, list), "+
+		"@reach(1, :::Song, :::Author, name, count), @reach(1, :::Song, :::Author, name, first)", `
+Labels: Group Key, Name, Count, Name
+Format: auto, auto, auto, auto
+Data: 1:n:key, 1:func:reach(), 1:func:reach(), 1:func:reach()
+Best, [John Mike Hans], 3, John
+`[1:], rt, true)
+
+	if err != nil || res.RowSource(0)[1] != `q:lookup group "Best" traverse :::Song traverse :::Author end end show 3:n:key, 3:n:kind, 3:n:name` {
+		t.Error(res, err)
+		return
+	}
+
+	// Make sure the source query has the expected result
+
+	rt2 := NewLookupRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult(res.RowSource(0)[1][2:], `
+Labels: Key, Kind, Name
+Format: auto, auto, auto
+Data: 3:n:key, 3:n:kind, 3:n:name
+000, Author, John
+123, Author, Mike
+123, Author, Mike
+456, Author, Hans
+`[1:], rt2, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nodes without the attribute are skipped
+
+	if _, err := getResult("get Author show name, @reach(1, :::Song, :::group, key, list) AS groups, "+
+		"@reach(1, :::Song, :::group, name, count), @reach(1, :::Song, :::group, name, first)", `
+Labels: Author Name, groups, Count, Name
+Format: auto, auto, auto, auto
+Data: 1:n:name, 1:func:reach(), 1:func:reach(), 1:func:reach()
+Hans, [Best], 0, <not set>
+John, [Best], 0, <not set>
+Mike, [Best], 0, <not set>
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test error cases
+
+	if _, err := getResult("get group show @reach(1, :::Song, name)", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (Reach function requires at least 4 parameters: traversal step, "+
+			"traversal spec, ..., attribute name, aggregation mode) (Line:1 Pos:16)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get group show @reach(1, :::Song, name, sum)", "", rt, true); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (Unknown aggregation mode for reach function: sum) (Line:1 Pos:16)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get group show @reach(1, :::Song, ::Author, name, list)", "", rt, true); err == nil || err.Error() !=
+		"GraphError: Invalid data (Invalid spec: ::Author)" {
+		t.Error(err)
+		return
+	}
+}
+
 func TestFunctionErrors(t *testing.T) {
 	gm, _ := songGraphGroups()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -0,0 +1,179 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// User-defined functions
+// ======================
+
+/*
+Parameter types of user-defined functions
+*/
+const (
+	ParamAny    = "any"    // Any value (passed as is)
+	ParamString = "string" // String value (passed as string)
+	ParamNumber = "number" // Number value (passed as float64)
+	ParamBool   = "bool"   // Boolean value (passed as bool)
+	ParamList   = "list"   // List value (passed as []interface{})
+)
+
+/*
+FunctionParam describes a parameter of a user-defined function.
+*/
+type FunctionParam struct {
+	Name     string `json:"name"`               // Name of the parameter
+	Type     string `json:"type"`               // Type of the parameter
+	Optional bool   `json:"optional,omitempty"` // Flag if the parameter can be omitted
+}
+
+/*
+FunctionDef is the definition of a user-defined function. Parameter values are
+checked and converted to the types of the parameters before the function is
+called - null values are passed as nil. Optional parameters must be declared
+after all other parameters.
+*/
+type FunctionDef struct {
+	Name        string          `json:"name"`        // Name of the function
+	Description string          `json:"description"` // Description of the function
+	Params      []FunctionParam `json:"params"`      // Parameters of the function
+	Func        Function        `json:"-"`           // Implementation of the function
+}
+
+/*
+functionNameRegexp is the pattern of valid function names.
+*/
+var functionNameRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_]*$")
+
+/*
+functionDefs holds all user-defined functions.
+*/
+var functionDefs = make(map[string]*FunctionDef)
+
+/*
+RegisterFunction registers a user-defined function which can be called in
+where and show clauses with @<name>(...). Functions should be registered
+before any queries are run.
+*/
+func RegisterFunction(def *FunctionDef) error {
+
+	if !functionNameRegexp.MatchString(def.Name) {
+		return fmt.Errorf("Invalid function name: %v", def.Name)
+	} else if def.Func == nil {
+		return fmt.Errorf("Function %v has no implementation", def.Name)
+	}
+
+	if _, ok := whereFunc[def.Name]; ok {
+		return fmt.Errorf("Function %v is already defined", def.Name)
+	} else if _, ok := showFunc[def.Name]; ok {
+		return fmt.Errorf("Function %v is already defined", def.Name)
+	}
+
+	optional := false
+
+	for _, p := range def.Params {
+
+		switch p.Type {
+		case ParamAny, ParamString, ParamNumber, ParamBool, ParamList:
+		default:
+			return fmt.Errorf("Unknown type of parameter %v of function %v: %v", p.Name, def.Name, p.Type)
+		}
+
+		if optional && !p.Optional {
+			return fmt.Errorf("Parameter %v of function %v must be optional", p.Name, def.Name)
+		}
+
+		optional = p.Optional
+	}
+
+	functionDefs[def.Name] = def
+	whereFunc[def.Name] = functionWhere(def.call)
+
+	return nil
+}
+
+/*
+Functions returns the definitions of all user-defined functions ordered by
+name.
+*/
+func Functions() []*FunctionDef {
+
+	ret := make([]*FunctionDef, 0, len(functionDefs))
+
+	for _, def := range functionDefs {
+		ret = append(ret, def)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+call checks and converts the given parameter values and calls the function.
+*/
+func (def *FunctionDef) call(args []interface{}) (interface{}, error) {
+
+	required := 0
+	names := make([]string, 0, len(def.Params))
+
+	for _, p := range def.Params {
+		if !p.Optional {
+			required++
+		}
+		names = append(names, p.Name)
+	}
+
+	if err := checkArgs(def.Name, args, required, len(def.Params), strings.Join(names, ", ")); err != nil {
+		return nil, err
+	}
+
+	for i, arg := range args {
+		var err error
+
+		if arg == nil {
+			continue
+		}
+
+		switch p := def.Params[i]; p.Type {
+
+		case ParamString:
+			args[i] = fmt.Sprint(arg)
+
+		case ParamNumber:
+			args[i], err = numberArg(def.Name, arg)
+
+		case ParamBool:
+			if args[i], err = strconv.ParseBool(fmt.Sprint(arg)); err != nil {
+				err = fmt.Errorf("%v function requires a boolean: %v", def.Name, arg)
+			}
+
+		case ParamList:
+			if _, ok := arg.([]interface{}); !ok {
+				err = fmt.Errorf("%v function requires a list: %v", def.Name, arg)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return def.Func(args)
+}
@@ -0,0 +1,129 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRegisterFunction(t *testing.T) {
+	gm := libraryGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	repeat := func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+
+		sep := ""
+		if len(args) > 2 && args[2] == true {
+			sep = " "
+		}
+
+		return strings.TrimSpace(strings.Repeat(args[0].(string)+sep, int(args[1].(float64)))), nil
+	}
+
+	for _, test := range []struct {
+		def *FunctionDef
+		msg string
+	}{
+		{&FunctionDef{Name: "upper", Func: repeat}, "Function upper is already defined"},
+		{&FunctionDef{Name: "objget", Func: repeat}, "Function objget is already defined"},
+		{&FunctionDef{Name: "my func", Func: repeat}, "Invalid function name: my func"},
+		{&FunctionDef{Name: "repeat"}, "Function repeat has no implementation"},
+		{&FunctionDef{Name: "repeat", Func: repeat, Params: []FunctionParam{{"s", "text", false}}},
+			"Unknown type of parameter s of function repeat: text"},
+		{&FunctionDef{Name: "repeat", Func: repeat, Params: []FunctionParam{{"s", ParamString, true},
+			{"n", ParamNumber, false}}}, "Parameter n of function repeat must be optional"},
+	} {
+		if err := RegisterFunction(test.def); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	err := RegisterFunction(&FunctionDef{
+		Name:        "repeat",
+		Description: "Repeats a string",
+		Params: []FunctionParam{
+			{"string", ParamString, false},
+			{"count", ParamNumber, false},
+			{"spaced", ParamBool, true},
+		},
+		Func: repeat,
+	})
+	defer func() {
+		delete(whereFunc, "repeat")
+		delete(functionDefs, "repeat")
+	}()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, _ := json.Marshal(Functions())
+
+	if string(res) != `[{"name":"repeat","description":"Repeats a string","params":[`+
+		`{"name":"string","type":"string"},{"name":"count","type":"number"},{"name":"spaced","type":"bool","optional":true}]}]` {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	if _, err := getResult("get product where @repeat(key, 2) = 'p2p2' show key, @repeat(price, '2', 'true'), "+
+		"@repeat(title, 1)", `
+Labels: Product Key, @repeat(price, 2, true), @repeat(title, 1)
+Format: auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr()
+p2, -3.5 -3.5, Hut
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for query, expected := range map[string]string{
+		"get product where @repeat(key)":             "repeat function requires 2 or 3 parameters: string, count, spaced",
+		"get product where @repeat(key, 'x')":        "repeat function requires a number: x",
+		"get product where @repeat(key, 1, 'maybe')": "repeat function requires a boolean: maybe",
+	} {
+		expected = fmt.Sprintf("EQL error in test: Invalid construct (%v) (Line:1 Pos:19)", expected)
+
+		if _, err := getResult(query, "", rt, true); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+
+	def := &FunctionDef{Params: []FunctionParam{{"items", ParamList, false}, {"a", ParamAny, true},
+		{"b", ParamAny, true}}}
+	def.Name = "first"
+	def.Func = func(args []interface{}) (interface{}, error) {
+		return args[0].([]interface{})[0], nil
+	}
+
+	if res, err := def.call([]interface{}{[]interface{}{1, 2}}); err != nil || res != 1 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := def.call([]interface{}{1}); err == nil || err.Error() != "first function requires a list: 1" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := def.call(nil); err == nil || err.Error() != "first function requires 1 to 3 parameters: items, a, b" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+// Function library
+// ================
+
+/*
+Function is a function which can be called in where and show clauses. It is
+called with the values of all given parameters and returns a result value.
+*/
+type Function func(args []interface{}) (interface{}, error)
+
+/*
+functionWhere wraps a function into a where related function. Errors of the
+function are reported as invalid constructs.
+*/
+func functionWhere(f Function) FuncWhere {
+	return func(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+		node data.Node, edge data.Edge) (interface{}, error) {
+
+		args := make([]interface{}, 0, len(astNode.Children)-1)
+
+		for _, child := range astNode.Children[1:] {
+
+			val, err := child.Runtime.(CondRuntime).CondEval(node, edge)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, val)
+		}
+
+		res, err := f(args)
+		if err != nil {
+			return nil, rtp.newRuntimeError(ErrInvalidConstruct, err.Error(), astNode)
+		}
+
+		return res, nil
+	}
+}
+
+/*
+checkArgs checks the number of parameters of a library function.
+*/
+func checkArgs(name string, args []interface{}, min int, max int, params string) error {
+
+	if len(args) >= min && len(args) <= max {
+		return nil
+	}
+
+	count := fmt.Sprintf("%v or %v parameters", min, max)
+
+	if max-min > 1 {
+		count = fmt.Sprintf("%v to %v parameters", min, max)
+	} else if min == max {
+		count = fmt.Sprintf("%v parameter", min)
+		if min != 1 {
+			count += "s"
+		}
+	}
+
+	return fmt.Errorf("%v function requires %v: %v", name, count, params)
+}
+
+/*
+numberArg converts a parameter of a library function into a number.
+*/
+func numberArg(name string, arg interface{}) (float64, error) {
+
+	num, err := strconv.ParseFloat(fmt.Sprint(arg), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v function requires a number: %v", name, arg)
+	}
+
+	return num, nil
+}
+
+// String functions
+// ----------------
+
+/*
+stringFunc creates a library function which transforms a string. Returns null
+if the value is null.
+*/
+func stringFunc(name string, transform func(string) string) Function {
+	return func(args []interface{}) (interface{}, error) {
+
+		if err := checkArgs(name, args, 1, 1, "string"); err != nil || args[0] == nil {
+			return nil, err
+		}
+
+		return transform(fmt.Sprint(args[0])), nil
+	}
+}
+
+/*
+funcTrim removes leading and trailing white space or a given set of characters
+from a string. Returns null if the value is null.
+*/
+func funcTrim(args []interface{}) (interface{}, error) {
+
+	if err := checkArgs("trim", args, 1, 2, "string, characters"); err != nil || args[0] == nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		return strings.Trim(fmt.Sprint(args[0]), fmt.Sprint(args[1])), nil
+	}
+
+	return strings.TrimSpace(fmt.Sprint(args[0])), nil
+}
+
+/*
+funcSubstr returns a part of a string given by a start position and an
+optional length. Positions are counted in characters starting from 0 -
+negative start positions are counted from the end of the string. Returns null
+if the value is null.
+*/
+func funcSubstr(args []interface{}) (interface{}, error) {
+	var start, length float64
+	var err error
+
+	if err = checkArgs("substr", args, 2, 3, "string, start, length"); err != nil || args[0] == nil {
+		return nil, err
+	}
+
+	runes := []rune(fmt.Sprint(args[0]))
+	n := len(runes)
+
+	if start, err = numberArg("substr", args[1]); err != nil {
+		return nil, err
+	}
+
+	from := int(start)
+
+	if from < 0 {
+		from += n
+	}
+
+	if from < 0 {
+		from = 0
+	} else if from > n {
+		from = n
+	}
+
+	to := n
+
+	if len(args) == 3 {
+		if length, err = numberArg("substr", args[2]); err != nil {
+			return nil, err
+		}
+
+		if to = from + int(length); to < from {
+			to = from
+		} else if to > n {
+			to = n
+		}
+	}
+
+	return string(runes[from:to]), nil
+}
+
+/*
+funcLength returns the number of characters of a string or the number of
+items of a list or map. Returns null if the value is null.
+*/
+func funcLength(args []interface{}) (interface{}, error) {
+
+	if err := checkArgs("length", args, 1, 1, "value"); err != nil || args[0] == nil {
+		return nil, err
+	}
+
+	switch v := args[0].(type) {
+	case []interface{}:
+		return len(v), nil
+	case []string:
+		return len(v), nil
+	case map[string]interface{}:
+		return len(v), nil
+	}
+
+	return utf8.RuneCountInString(fmt.Sprint(args[0])), nil
+}
+
+// Math functions
+// --------------
+
+/*
+mathFunc creates a library function which transforms a number. Returns null if
+the value is null.
+*/
+func mathFunc(name string, transform func(float64) float64) Function {
+	return func(args []interface{}) (interface{}, error) {
+
+		if err := checkArgs(name, args, 1, 1, "number"); err != nil || args[0] == nil {
+			return nil, err
+		}
+
+		num, err := numberArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return transform(num), nil
+	}
+}
+
+/*
+funcRound rounds a number to the nearest integer or to a given number of
+decimal places. Returns null if the value is null.
+*/
+func funcRound(args []interface{}) (interface{}, error) {
+	var num, places float64
+	var err error
+
+	if err = checkArgs("round", args, 1, 2, "number, decimal places"); err != nil || args[0] == nil {
+		return nil, err
+	}
+
+	if num, err = numberArg("round", args[0]); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		if places, err = numberArg("round", args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	shift := math.Pow(10, math.Trunc(places))
+
+	return math.Round(num*shift) / shift, nil
+}
@@ -0,0 +1,118 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func libraryGraph() *graph.Manager {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for key, vals := range map[string][]interface{}{
+		"p1": {"  Ärmel", 12.345, []interface{}{"a", "b"}},
+		"p2": {"Hut", -3.5, nil},
+		"p3": {nil, "x", nil},
+	} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "product")
+		node.SetAttr("title", vals[0])
+		node.SetAttr("price", vals[1])
+		node.SetAttr("tags", vals[2])
+		gm.StoreNode("main", node)
+	}
+
+	return gm
+}
+
+func TestStringFunctions(t *testing.T) {
+	gm := libraryGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get product show key, @upper(title) as upper, @lower(title) as lower, "+
+		"@trim(title) as trim, @length(title) as len, @length(tags) as tags with ordering(ascending key)", `
+Labels: Product Key, upper, lower, trim, len, tags
+Format: auto, auto, auto, auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr()
+p1,   ÄRMEL,   ärmel, Ärmel, 7, 2
+p2, HUT, hut, Hut, 3, <not set>
+p3, <not set>, <not set>, <not set>, <not set>, <not set>
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get product where @lower(@trim(title)) = 'ärmel'", "", rt, true); err == nil {
+		t.Error("Nested functions should not be possible")
+		return
+	}
+
+	if _, err := getResult("get product where @substr(title, 0, 1) = 'H' or @trim(title, ' lemÄ') = 'r' "+
+		"show key, @substr(title, 1, '-1') as s1, @substr(title, 10) as s2, @substr(title, 2, 3) as s3, "+
+		"@substr(title, '-3') as s4 with ordering(ascending key)", `
+Labels: Product Key, s1, s2, s3, s4
+Format: auto, auto, auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr()
+p1, , , Ärm, mel
+p2, , , t, Hut
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	gm := libraryGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if _, err := getResult("get product where key != 'p3' show key, @abs(price) as abs, @floor(price) as floor, "+
+		"@ceil(price) as ceil, @round(price) as round, @round(price, 2) as round2 with ordering(ascending key)", `
+Labels: Product Key, abs, floor, ceil, round, round2
+Format: auto, auto, auto, auto, auto, auto
+Data: 1:n:key, 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr(), 1:func:expr()
+p1, 12.345, 12, 13, 12, 12.35
+p2, 3.5, -4, -3, -4, -3.5
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get product where key != 'p3' and @round(price) * 2 = 24", `
+Labels: Product Key, Price, Tags, Title
+Format: auto, auto, auto, auto
+Data: 1:n:key, 1:n:price, 1:n:tags, 1:n:title
+p1, 12.345, [a b],   Ärmel
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for query, expected := range map[string]string{
+		"get product where @abs()": "EQL error in test: Invalid construct " +
+			"(abs function requires 1 parameter: number) (Line:1 Pos:19)",
+		"get product where @round(1, 2, 3)": "EQL error in test: Invalid construct " +
+			"(round function requires 1 or 2 parameters: number, decimal places) (Line:1 Pos:19)",
+		"get product where key = 'p3' and @floor(price) = 1": "EQL error in test: Invalid construct " +
+			"(floor function requires a number: x) (Line:1 Pos:34)",
+		"get product where @substr(title, 'a')": "EQL error in test: Invalid construct " +
+			"(substr function requires a number: a) (Line:1 Pos:19)",
+	} {
+		if _, err := getResult(query, "", rt, true); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+}
@@ -13,6 +13,7 @@ package interpreter
 import (
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
 )
 
 // Runtime provider for GET queries
@@ -43,7 +44,7 @@ can interpret GET queries.
 */
 func NewGetRuntimeProvider(name string, part string, gm *graph.Manager, ni NodeInfo) *GetRuntimeProvider {
 	return &GetRuntimeProvider{&eqlRuntimeProvider{name, part, gm, ni, "", false, nil, "",
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, new(int64), 0, false, nil, 0, nil, 0, 0, false, nil, 0, nil}}
 }
 
 /*
@@ -80,9 +81,86 @@ func (rt *getRuntime) Validate() error {
 
 	startKind := rt.node.Children[0].Token.Val
 
+	// A named result of a composed query is used instead of a node kind
+
+	named, isNamed := rt.rtp.namedResults[startKind]
+	if isNamed {
+		startKind = named.kind
+	}
+
 	initErr := rt.rtp.init(startKind, rt.node.Children[1:])
 
-	if rt.rtp.groupScope == "" {
+	if isNamed && initErr == nil {
+
+		if rt.rtp.groupScope != "" {
+			return rt.rtp.newRuntimeError(ErrInvalidConstruct,
+				"Named result cannot be used with a group scope", rt.node.Children[0])
+		}
+
+		// Start keys are provided by the primary nodes of the named result
+
+		keys := named.keys
+
+		rt.rtp.nextStartKey = func() (string, error) {
+			if len(keys) == 0 {
+				return "", nil
+			}
+
+			nextKey := keys[0]
+			keys = keys[1:]
+
+			return nextKey, nil
+		}
+
+	} else if _, ok := rt.rtp.hints[HintUseIndex]; ok && initErr == nil {
+		hintsNode := rt.node.Children[len(rt.node.Children)-1]
+
+		if rt.rtp.groupScope != "" {
+			return rt.rtp.newRuntimeError(ErrInvalidHint,
+				"Index hint cannot be used with a group scope", hintsNode)
+		}
+
+		// Start keys are provided by an index lookup
+
+		keys, err := rt.rtp.indexStartKeys(startKind, hintsNode)
+		if err != nil {
+			return err
+		}
+
+		rt.rtp.nextStartKey = func() (string, error) {
+			if len(keys) == 0 {
+				return "", nil
+			}
+
+			nextKey := keys[0]
+			keys = keys[1:]
+
+			return nextKey, nil
+		}
+
+	} else if rt.rtp.groupScope == "" {
+
+		// Start keys are provided by an index lookup if the statistics of the
+		// start kind show that the where clause is selective enough
+
+		keys, ok, err := rt.rtp.statsIndexStartKeys(startKind)
+		if err != nil {
+			return err
+		} else if ok {
+
+			rt.rtp.nextStartKey = func() (string, error) {
+				if len(keys) == 0 {
+					return "", nil
+				}
+
+				nextKey := keys[0]
+				keys = keys[1:]
+
+				return nextKey, nil
+			}
+
+			return initErr
+		}
 
 		// Start keys can be provided by a simple node key iterator
 
@@ -158,23 +236,64 @@ func (rt *getRuntime) gaterResult(topNode *parser.ASTNode) (interface{}, error)
 
 	res := newSearchResult(rt.rtp.eqlRuntimeProvider, query)
 
+	rt.rtp.visited = new(int64)
+
 	if err == nil {
-		var more bool
+
+		// Rows outside of a row window are not evaluated if the result
+		// does not need all rows
+
+		offset, limit := 0, 0
+
+		if res.windowable() {
+			offset, limit = rt.rtp.rowOffset, rt.rtp.rowLimit
+			res.windowed = offset > 0 || limit > 0
+		}
+
+		// Rows outside of the window are still evaluated if all rows
+		// should be counted
+
+		count := res.windowed && rt.rtp.countRows
+		rows := 0
 
 		// Go through all rows
 
-		more, err = rt.rtp.next()
-		for more && err == nil {
+		err = rt.rtp.evalRows(topNode, func(rowNode []data.Node, rowEdge []data.Edge) (bool, error) {
+
+			rows++
+
+			if offset > 0 {
+
+				// Skip row without building it
+
+				offset--
+
+				return true, nil
+
+			} else if limit > 0 && len(res.Data) >= limit {
+
+				// Count row after a full window without building it
+
+				return true, nil
+			}
 
 			// Add row to the result
 
-			if err := res.addRow(rt.rtp.rowNode, rt.rtp.rowEdge); err != nil {
-				return nil, err
+			if err := res.addRow(rowNode, rowEdge); err != nil {
+				return false, err
 			}
 
-			// More on to the next row
+			// Stop once the window is full
 
-			more, err = rt.rtp.next()
+			return limit == 0 || len(res.Data) < limit || count, nil
+		})
+
+		if count {
+			res.totalRows = rows
+		}
+
+		if err != nil {
+			return nil, err
 		}
 
 		// Finish the result
@@ -0,0 +1,197 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/krotik/eliasdb/eql/parser"
+)
+
+// Query hints
+// ===========
+
+/*
+Known query hints which can be given in a hint block before a query
+(e.g. use_index(name) no_cache parallel(4)).
+*/
+const (
+	HintUseIndex = "use_index" // Get start nodes from the index of an attribute
+	HintNoCache  = "no_cache"  // Do not store the result in a result cache
+	HintParallel = "parallel"  // Evaluate rows with several workers
+)
+
+/*
+hintArgs is the number of arguments of each known query hint.
+*/
+var hintArgs = map[string]int{
+	HintUseIndex: 1,
+	HintNoCache:  0,
+	HintParallel: 1,
+}
+
+/*
+MaxParallelWorkers is the maximum number of workers which can be requested
+with the parallel hint.
+*/
+var MaxParallelWorkers = 16
+
+/*
+ParallelBatchSize is the number of start nodes which a worker evaluates at once
+if the rows of a query are evaluated in parallel.
+*/
+var ParallelBatchSize = 100
+
+/*
+initHints validates a hint block and stores its hints.
+*/
+func (p *eqlRuntimeProvider) initHints(hintsNode *parser.ASTNode) error {
+
+	for _, hint := range hintsNode.Children {
+		var args []string
+
+		name := hint.Token.Val
+
+		n, ok := hintArgs[name]
+		if !ok {
+			return p.newRuntimeError(ErrInvalidHint, "Unknown hint: "+name, hint)
+		} else if len(hint.Children) != n {
+			return p.newRuntimeError(ErrInvalidHint,
+				fmt.Sprintf("Hint %v requires %v argument(s)", name, n), hint)
+		}
+
+		for _, arg := range hint.Children {
+			args = append(args, arg.Token.Val)
+		}
+
+		if name == HintParallel {
+			workers, err := strconv.Atoi(args[0])
+
+			if err != nil || workers < 1 || workers > MaxParallelWorkers {
+				return p.newRuntimeError(ErrInvalidHint,
+					fmt.Sprintf("Number of parallel workers must be between 1 and %v",
+						MaxParallelWorkers), hint.Children[0])
+			}
+
+			p.parallel = workers
+		}
+
+		p.hints[name] = args
+	}
+
+	return nil
+}
+
+/*
+indexStartKeys returns the start keys for the use_index hint. The keys are
+looked up in the index of the hinted attribute with the value of an equality
+condition (or the values of an in condition) on the attribute in the where
+clause. The where clause is still evaluated for each start node.
+*/
+func (p *eqlRuntimeProvider) indexStartKeys(startKind string, hintsNode *parser.ASTNode) ([]string, error) {
+	var vals []string
+	var ok bool
+
+	attr := p.hints[HintUseIndex][0]
+
+	for _, unindexed := range p.gm.Unindexed()[startKind] {
+		if unindexed == attr {
+			return nil, p.newRuntimeError(ErrInvalidHint,
+				"Attribute is not indexed: "+attr, hintsNode)
+		}
+	}
+
+	if p.where != nil {
+		vals, ok = indexCondValues(p.where.Children[0], attr)
+	}
+
+	if !ok {
+		return nil, p.newRuntimeError(ErrInvalidHint,
+			"Where clause has no equality condition for attribute: "+attr, hintsNode)
+	}
+
+	return p.indexLookup(startKind, attr, vals)
+}
+
+/*
+indexLookup looks up the keys of all nodes of a given kind whose attribute has
+one of the given values in the index. A node is only returned once.
+*/
+func (p *eqlRuntimeProvider) indexLookup(startKind string, attr string, vals []string) ([]string, error) {
+
+	iq, err := p.gm.NodeIndexQuery(p.part, startKind)
+	if err != nil || iq == nil {
+		return nil, err
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+
+	for _, val := range vals {
+
+		valKeys, err := iq.LookupValue(attr, val)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range valKeys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+/*
+indexCondValues looks for an equality condition between a node attribute and a
+constant value or an in condition between a node attribute and a list of
+constant values which must hold for all results of a where clause (i.e. it is
+not part of an or condition). Returns the constant values.
+*/
+func indexCondValues(cond *parser.ASTNode, attr string) ([]string, bool) {
+
+	isAttr := func(n *parser.ASTNode) bool {
+		nattr, ok := condAttr(n)
+		return ok && nattr == attr
+	}
+
+	isConst := func(n *parser.ASTNode) bool {
+		_, ok := condConst(n)
+		return ok
+	}
+
+	if cond.Name == parser.NodeAND {
+		for _, child := range cond.Children {
+			if vals, ok := indexCondValues(child, attr); ok {
+				return vals, true
+			}
+		}
+
+	} else if cond.Name == parser.NodeEQ {
+
+		if isAttr(cond.Children[0]) && isConst(cond.Children[1]) {
+			val, _ := condConst(cond.Children[1])
+			return []string{val}, true
+		} else if isAttr(cond.Children[1]) && isConst(cond.Children[0]) {
+			val, _ := condConst(cond.Children[0])
+			return []string{val}, true
+		}
+
+	} else if cond.Name == parser.NodeIN && isAttr(cond.Children[0]) {
+		return condConstList(cond.Children[1])
+	}
+
+	return nil, false
+}
@@ -0,0 +1,149 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+// Joins
+// =====
+
+/*
+validateJoin validates a join. Joins use the runtime of traversals but get
+their nodes through index lookups instead of following edges. The join
+condition must be an equality between an attribute of the start node kind and
+an attribute of the joined kind (e.g. join Customer on Order.customer_id =
+Customer.key). If both sides of the condition refer to the joined kind then
+the right side is the joined attribute.
+*/
+func (rt *traversalRuntime) validateJoin() error {
+
+	kind := rt.node.Children[0].Token.Val
+	cond := rt.node.Children[1]
+
+	knownKind := false
+	for _, nk := range rt.rtp.gm.NodeKinds() {
+		knownKind = knownKind || nk == kind
+	}
+
+	if !knownKind {
+		return rt.rtp.newRuntimeError(ErrUnknownNodeKind, kind, rt.node.Children[0])
+	}
+
+	if cond.Name != parser.NodeEQ || len(cond.Children) != 2 ||
+		cond.Children[0].Token.ID != parser.TokenVALUE ||
+		cond.Children[1].Token.ID != parser.TokenVALUE {
+
+		return rt.rtp.newRuntimeError(ErrInvalidJoin,
+			"Join condition must be an equality between two attributes", cond)
+	}
+
+	// Find the attribute of the joined kind and the attribute of the source
+
+	rt.joinAttr = ""
+	rt.sourceAttr = ""
+
+	for _, i := range []int{1, 0} {
+		if val := cond.Children[i].Token.Val; strings.HasPrefix(val, kind+".") {
+			rt.joinAttr = val[len(kind)+1:]
+			rt.sourceAttr = strings.TrimPrefix(cond.Children[1-i].Token.Val, rt.rtp.specs[0]+".")
+			break
+		}
+	}
+
+	if rt.joinAttr == "" || rt.sourceAttr == "" {
+		return rt.rtp.newRuntimeError(ErrInvalidJoin,
+			fmt.Sprintf("Join condition must compare an attribute of %v", kind), cond)
+	}
+
+	for _, unindexed := range rt.rtp.gm.Unindexed()[kind] {
+		if unindexed == rt.joinAttr {
+			return rt.rtp.newRuntimeError(ErrInvalidJoin,
+				"Attribute is not indexed: "+rt.joinAttr, cond)
+		}
+	}
+
+	rt.spec = ":::" + kind
+	rt.specIndex = len(rt.rtp.specs)
+	rt.where = nil
+	rt.inLast = nil
+	rt.nodes = nil
+	rt.edges = nil
+	rt.curptr = 0
+	rt.rtp.specs = append(rt.rtp.specs, rt.spec)
+	rt.rtp.attrsNodes = append(rt.rtp.attrsNodes, make(map[string]string))
+	rt.rtp.attrsEdges = append(rt.rtp.attrsEdges, make(map[string]string))
+
+	// The source attribute must be fetched with the start nodes
+
+	rt.rtp.attrsNodes[0][rt.sourceAttr] = ""
+
+	return nil
+}
+
+/*
+joinNodes returns all nodes of the joined kind which match the current source
+node. Nodes are fetched with all required attributes. Nodes which would exceed
+the given visited node limit are not read.
+*/
+func (rt *traversalRuntime) joinNodes(max int) ([]data.Node, []data.Edge, error) {
+	var keys []string
+	var nodes []data.Node
+	var edges []data.Edge
+
+	val := rt.sourceNode.Attr(rt.sourceAttr)
+	if val == nil {
+		return nil, nil, nil
+	}
+
+	kind := rt.spec[3:]
+
+	if rt.joinAttr == data.NodeKey {
+		keys = []string{fmt.Sprint(val)}
+
+	} else {
+
+		iq, err := rt.rtp.gm.NodeIndexQuery(rt.rtp.part, kind)
+		if err != nil || iq == nil {
+			return nil, nil, err
+		}
+
+		if keys, err = iq.LookupValue(rt.joinAttr, fmt.Sprint(val)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	attrs := append(append([]string{}, rt.rtp._attrsNodesFetch[rt.specIndex]...), data.NodeKey)
+
+	for _, key := range keys {
+
+		if max >= 0 && len(nodes) >= max {
+			return nil, nil, &util.GraphError{Type: util.ErrTraversalLimit}
+		}
+
+		node, err := rt.rtp.gm.FetchNodePart(rt.rtp.part, key, kind, attrs)
+
+		if err != nil {
+			return nil, nil, err
+		} else if node != nil {
+			nodes = append(nodes, node)
+			edges = append(edges, nil)
+		}
+	}
+
+	return nodes, edges, nil
+}
@@ -43,7 +43,7 @@ can interpret LOOKUP queries.
 */
 func NewLookupRuntimeProvider(name string, part string, gm *graph.Manager, ni NodeInfo) *LookupRuntimeProvider {
 	return &LookupRuntimeProvider{&eqlRuntimeProvider{name, part, gm, ni, "", false, nil, "",
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, new(int64), 0, false, nil, 0, nil, 0, 0, false, nil, 0, nil}}
 }
 
 /*
@@ -109,6 +109,11 @@ func (rt *lookupRuntime) Validate() error {
 
 	initErr := rt.rtp.init(startKind, rt.node.Children[initIndex+1:])
 
+	if _, ok := rt.rtp.hints[HintUseIndex]; ok && initErr == nil {
+		return rt.rtp.newRuntimeError(ErrInvalidHint,
+			"Index hint cannot be used in lookup queries", rt.node.Children[len(rt.node.Children)-1])
+	}
+
 	if rt.rtp.groupScope == "" {
 
 		nodePtr := len(keys)
@@ -0,0 +1,257 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/krotik/eliasdb/eql/parser"
+)
+
+// Statistics based optimization
+// =============================
+
+/*
+IndexSelectivity is the largest estimated fraction of start nodes which satisfy
+the where clause for which the start nodes are looked up in the index instead
+of scanning all nodes of the start kind.
+*/
+var IndexSelectivity = 0.1
+
+/*
+UnknownSelectivity is the estimated fraction of nodes which satisfy a condition
+that cannot be estimated from the statistics of a node kind.
+*/
+var UnknownSelectivity = 0.5
+
+/*
+statsIndexStartKeys returns the start keys of a query from an index lookup if
+the statistics of the start kind show that an equality condition (or an in
+condition) of the where clause is selective enough. Only attributes which hold
+strings are considered since only their equality matches the lookup of a value
+in the index. Returns false if all nodes of the start kind should be scanned.
+*/
+func (p *eqlRuntimeProvider) statsIndexStartKeys(startKind string) ([]string, bool, error) {
+	var bestAttr string
+	var bestVals []string
+
+	if p.stats == nil || p.where == nil {
+		return nil, false, nil
+	}
+
+	unindexed := make(map[string]bool)
+	for _, attr := range p.gm.Unindexed()[startKind] {
+		unindexed[attr] = true
+	}
+
+	attrs := make([]string, 0, len(p.stats.Attrs))
+	for attr := range p.stats.Attrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	bestSel := IndexSelectivity
+
+	for _, attr := range attrs {
+
+		if !p.stats.Attrs[attr].Strings || unindexed[attr] {
+			continue
+		}
+
+		vals, ok := indexCondValues(p.where.Children[0], attr)
+		if !ok {
+			continue
+		}
+
+		// Numbers are compared by value but looked up by their text
+
+		sel := 0.0
+
+		for _, val := range vals {
+			if _, err := strconv.ParseFloat(val, 64); err == nil {
+				ok = false
+			}
+			sel += p.stats.EqualSelectivity(attr, val)
+		}
+
+		if ok && (sel < bestSel || (sel == bestSel && bestAttr == "")) {
+			bestAttr, bestVals, bestSel = attr, vals, sel
+		}
+	}
+
+	if bestAttr == "" {
+		return nil, false, nil
+	}
+
+	keys, err := p.indexLookup(startKind, bestAttr, bestVals)
+
+	return keys, err == nil, err
+}
+
+/*
+orderConds orders the operands of all and / or conditions of a where clause so
+the operand which most likely decides the condition is evaluated first. Returns
+the estimated fraction of start nodes which satisfy the given condition.
+*/
+func (p *eqlRuntimeProvider) orderConds(cond *parser.ASTNode) float64 {
+
+	switch cond.Name {
+
+	case parser.NodeAND:
+		sel1 := p.orderConds(cond.Children[0])
+		sel2 := p.orderConds(cond.Children[1])
+
+		// The operand which is most likely false is evaluated first
+
+		cond.Runtime.(*andRuntime).swapped = sel2 < sel1
+
+		return sel1 * sel2
+
+	case parser.NodeOR:
+		sel1 := p.orderConds(cond.Children[0])
+		sel2 := p.orderConds(cond.Children[1])
+
+		// The operand which is most likely true is evaluated first
+
+		cond.Runtime.(*orRuntime).swapped = sel2 > sel1
+
+		return sel1 + sel2 - sel1*sel2
+
+	case parser.NodeNOT:
+		return 1 - p.orderConds(cond.Children[0])
+
+	case parser.NodeEQ, parser.NodeNEQ:
+		attr, val, ok := condAttrConst(cond)
+		if !ok {
+			break
+		}
+
+		sel := p.stats.EqualSelectivity(attr, val)
+
+		if cond.Name == parser.NodeNEQ {
+			return 1 - sel
+		}
+
+		return sel
+
+	case parser.NodeLT, parser.NodeLEQ, parser.NodeGT, parser.NodeGEQ:
+		attr, val, ok := condAttrConst(cond)
+		if !ok {
+			break
+		}
+
+		num, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			break
+		}
+
+		// Conditions with the attribute as second operand are mirrored
+
+		_, attrFirst := condAttr(cond.Children[0])
+		lower := (cond.Name == parser.NodeGT || cond.Name == parser.NodeGEQ) == attrFirst
+
+		if lower {
+			return p.stats.RangeSelectivity(attr, num, math.Inf(1))
+		}
+
+		return p.stats.RangeSelectivity(attr, math.Inf(-1), num)
+
+	case parser.NodeIN, parser.NodeNOTIN:
+		attr, ok := condAttr(cond.Children[0])
+		if !ok {
+			break
+		}
+
+		vals, ok := condConstList(cond.Children[1])
+		if !ok {
+			break
+		}
+
+		sel := 0.0
+		for _, val := range vals {
+			sel += p.stats.EqualSelectivity(attr, val)
+		}
+		sel = math.Min(sel, 1)
+
+		if cond.Name == parser.NodeNOTIN {
+			return 1 - sel
+		}
+
+		return sel
+	}
+
+	return UnknownSelectivity
+}
+
+/*
+condAttrConst returns the attribute and the constant value of a comparison
+between a node attribute and a constant value.
+*/
+func condAttrConst(cond *parser.ASTNode) (string, string, bool) {
+
+	if attr, ok := condAttr(cond.Children[0]); ok {
+		val, ok := condConst(cond.Children[1])
+		return attr, val, ok
+	} else if attr, ok := condAttr(cond.Children[1]); ok {
+		val, ok := condConst(cond.Children[0])
+		return attr, val, ok
+	}
+
+	return "", "", false
+}
+
+/*
+condAttr returns the attribute name if a given condition operand is a plain
+node attribute.
+*/
+func condAttr(n *parser.ASTNode) (string, bool) {
+	vr, ok := n.Runtime.(*valueRuntime)
+	if !ok || !vr.isNodeAttrValue || vr.nestedValuePath != nil {
+		return "", false
+	}
+	return vr.condVal, true
+}
+
+/*
+condConst returns the value if a given condition operand is a constant value.
+*/
+func condConst(n *parser.ASTNode) (string, bool) {
+	vr, ok := n.Runtime.(*valueRuntime)
+	if !ok || n.Token.ID != parser.TokenVALUE || vr.isNodeAttrValue || vr.isEdgeAttrValue {
+		return "", false
+	}
+	return vr.condVal, true
+}
+
+/*
+condConstList returns the values if a given condition operand is a list of
+constant values.
+*/
+func condConstList(n *parser.ASTNode) ([]string, bool) {
+
+	if n.Name != parser.NodeLIST {
+		return nil, false
+	}
+
+	vals := make([]string, 0, len(n.Children))
+
+	for _, item := range n.Children {
+		val, ok := condConst(item)
+		if !ok {
+			return nil, false
+		}
+		vals = append(vals, val)
+	}
+
+	return vals, true
+}
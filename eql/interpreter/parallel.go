@@ -0,0 +1,275 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package interpreter
+
+import (
+	"sync"
+
+	"github.com/krotik/eliasdb/eql/parser"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+// Row evaluation
+// ==============
+
+/*
+rowFunc processes an evaluated row of a query. Returns false if no more rows
+should be evaluated.
+*/
+type rowFunc func(rowNode []data.Node, rowEdge []data.Edge) (bool, error)
+
+/*
+rowBatch is a batch of start nodes whose rows are evaluated by a worker.
+*/
+type rowBatch struct {
+	keys    []string      // Keys of the start nodes
+	rowNode [][]data.Node // Evaluated rows of nodes
+	rowEdge [][]data.Edge // Evaluated rows of edges
+	err     error         // Error which stopped the evaluation of the batch
+	done    chan struct{} // Channel which is closed once the batch was evaluated
+}
+
+/*
+nextStartNode returns the next start node.
+*/
+func (p *eqlRuntimeProvider) nextStartNode() (data.Node, error) {
+
+	// Fetch node - always require the key attribute
+	// to make sure we get a node back if it exists
+
+	attrs := append(append([]string{}, p._attrsNodesFetch[0]...), "key")
+
+	startKey, err := p.nextStartKey()
+	if err != nil || startKey == "" {
+		return nil, err
+	}
+
+	return p.gm.FetchNodePart(p.part, startKey, p.specs[0], attrs)
+}
+
+/*
+workerCount returns the number of workers which evaluate the rows of the
+current query.
+*/
+func (p *eqlRuntimeProvider) workerCount() int {
+	if p.parallel > 0 {
+		return p.parallel
+	}
+	return p.workers
+}
+
+/*
+evalRows evaluates the rows of the current query and hands them to a given
+function. The rows are always handed over in the order of their start nodes -
+even if they are evaluated by several workers. The evaluation stops with an
+error once the context of the query is done.
+*/
+func (p *eqlRuntimeProvider) evalRows(topNode *parser.ASTNode, f rowFunc) error {
+
+	if workers := p.workerCount(); workers > 1 {
+		return p.evalRowsParallel(workers, topNode, f)
+	}
+
+	more, err := p.next()
+	for more && err == nil {
+
+		// Stop if the evaluation was canceled
+
+		if err = p.canceled(topNode); err == nil {
+			if more, err = f(p.rowNode, p.rowEdge); more && err == nil {
+				more, err = p.next()
+			}
+		}
+	}
+
+	return err
+}
+
+/*
+evalRowsParallel evaluates the rows of the current query with a given number
+of workers. The start nodes are split into batches - each worker evaluates a
+batch with its own copy of the query. Only a limited number of batches is
+evaluated ahead of the rows which were handed over.
+*/
+func (p *eqlRuntimeProvider) evalRowsParallel(workers int, topNode *parser.ASTNode, f rowFunc) error {
+	var wg sync.WaitGroup
+
+	wps := make([]*eqlRuntimeProvider, workers)
+
+	for i := range wps {
+		wp, err := p.newWorker()
+		if err != nil {
+			return err
+		}
+		wps[i] = wp
+	}
+
+	jobs := make(chan *rowBatch)
+	pending := make(chan *rowBatch, 2*workers)
+	stop := make(chan struct{})
+
+	defer wg.Wait()
+	defer close(stop)
+
+	// Batches of start keys are queued in order for the workers
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(jobs)
+
+		for last := false; !last; {
+			b := &rowBatch{done: make(chan struct{})}
+
+			for len(b.keys) < ParallelBatchSize && b.err == nil && !last {
+				key, err := p.nextStartKey()
+
+				if b.err = err; err == nil && key != "" {
+					b.keys = append(b.keys, key)
+				} else {
+					last = true
+				}
+			}
+
+			if len(b.keys) == 0 && b.err == nil {
+				return
+			}
+
+			select {
+			case pending <- b:
+			case <-stop:
+				return
+			}
+
+			select {
+			case jobs <- b:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for _, wp := range wps {
+		wg.Add(1)
+		go func(wp *eqlRuntimeProvider) {
+			defer wg.Done()
+
+			for b := range jobs {
+				wp.evalBatch(b, topNode, stop)
+				close(b.done)
+			}
+		}(wp)
+	}
+
+	// Hand over the rows of all batches in order
+
+	for b := range pending {
+		<-b.done
+
+		for i := range b.rowNode {
+
+			if err := p.canceled(topNode); err != nil {
+				return err
+			}
+
+			if more, err := f(b.rowNode[i], b.rowEdge[i]); !more || err != nil {
+				return err
+			}
+		}
+
+		if b.err != nil {
+			return b.err
+		}
+	}
+
+	return nil
+}
+
+/*
+evalBatch evaluates the rows of a batch of start nodes. The evaluation is
+aborted without an error if the stop channel is closed.
+*/
+func (p *eqlRuntimeProvider) evalBatch(b *rowBatch, topNode *parser.ASTNode, stop chan struct{}) {
+	keys := b.keys
+
+	p.nextStartKey = func() (string, error) {
+		if len(keys) == 0 {
+			return "", nil
+		}
+
+		nextKey := keys[0]
+		keys = keys[1:]
+
+		return nextKey, nil
+	}
+
+	more, err := p.next()
+	for more && err == nil {
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err = p.canceled(topNode); err == nil {
+			b.rowNode = append(b.rowNode, append([]data.Node(nil), p.rowNode...))
+			b.rowEdge = append(b.rowEdge, append([]data.Edge(nil), p.rowEdge...))
+
+			more, err = p.next()
+		}
+	}
+
+	if err != nil {
+		b.err = err
+	}
+}
+
+/*
+newWorker creates a runtime provider which evaluates a copy of the current
+query. The worker shares the context and the traversal guards of this
+provider.
+*/
+func (p *eqlRuntimeProvider) newWorker() (*eqlRuntimeProvider, error) {
+	wrtp := NewGetRuntimeProvider(p.name, p.part, p.gm, p.ni)
+	wp := wrtp.eqlRuntimeProvider
+
+	wp.ctx = p.ctx
+	wp.maxVisitedNodes = p.maxVisitedNodes
+	wp.cycleDetection = p.cycleDetection
+
+	rootChildren := make([]*parser.ASTNode, len(p.rootChildren))
+
+	for i, child := range p.rootChildren {
+		rootChildren[i] = child.Copy(func(node *parser.ASTNode) *parser.LexToken {
+			return node.Token
+		}, wrtp)
+	}
+
+	if err := wp.init(p.specs[0], rootChildren); err != nil {
+		return nil, err
+	}
+
+	wp.visited = p.visited
+
+	return wp, nil
+}
+
+/*
+canceled returns an error if the context of the query is done.
+*/
+func (p *eqlRuntimeProvider) canceled(topNode *parser.ASTNode) error {
+	if p.ctx != nil && p.ctx.Err() != nil {
+		return p.newRuntimeError(ErrCanceled, p.ctx.Err().Error(), topNode)
+	}
+	return nil
+}
@@ -11,9 +11,11 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph"
@@ -83,6 +85,100 @@ type eqlRuntimeProvider struct {
 
 	_attrsNodesFetch [][]string // Internal copy of attrsNodes better suited for fetchPart calls
 	_attrsEdgesFetch [][]string // Internal copy of attrsEdges better suited for fetchPart calls
+
+	ctx     context.Context // Context which can cancel the evaluation
+	visited *int64          // Number of nodes which were visited by traversals (shared with workers)
+
+	maxVisitedNodes int  // Maximum number of nodes which traversals may visit (0 for no limit)
+	cycleDetection  bool // Flag if nested traversals should stop at nodes of the current path
+
+	hints    map[string][]string // Query hints and their arguments
+	parallel int                 // Number of workers requested with the parallel hint
+
+	namedResults map[string]*namedResult // Named results which can be queried like node kinds
+
+	rowOffset int  // Number of result rows which are skipped during the evaluation
+	rowLimit  int  // Maximum number of result rows which are evaluated (0 for no limit)
+	countRows bool // Flag if the rows outside of the row window should be counted
+
+	rootChildren []*parser.ASTNode // Children of the query node which were given to init
+	workers      int               // Number of workers which evaluate the rows of a query
+
+	stats *graph.KindStatistics // Statistics of the start kind (nil if the kind was not analyzed)
+}
+
+/*
+SetContext sets a context which can cancel the evaluation of a query (e.g.
+after a timeout). The evaluation stops with an error once the context is done.
+*/
+func (p *eqlRuntimeProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+/*
+SetTraversalGuards sets the guards for the traversals of a query. The
+evaluation stops with an error once the traversals would visit more than
+maxVisitedNodes nodes (0 for no limit). If cycleDetection is set then the
+evaluation of nested traversals stops with an error once a traversal reaches a
+node which is already part of the current traversal path.
+*/
+func (p *eqlRuntimeProvider) SetTraversalGuards(maxVisitedNodes int, cycleDetection bool) {
+	p.maxVisitedNodes = maxVisitedNodes
+	p.cycleDetection = cycleDetection
+}
+
+/*
+SetRowWindow sets a window of result rows which should be evaluated. The
+evaluation skips the first offset rows without building them and stops once
+limit rows were added (0 for no limit). The window is only applied if the rows
+of the result are not ordered, filtered or aggregated - otherwise the full
+result is evaluated.
+*/
+func (p *eqlRuntimeProvider) SetRowWindow(offset int, limit int) {
+	p.rowOffset = offset
+	p.rowLimit = limit
+}
+
+/*
+SetCountRows sets if all rows of a result should be counted if only a window
+of rows is evaluated. Rows outside of the window are evaluated but not built -
+TotalRowCount of the result returns the number of all rows.
+*/
+func (p *eqlRuntimeProvider) SetCountRows(count bool) {
+	p.countRows = count
+}
+
+/*
+SetWorkers sets the number of workers which evaluate the rows of a query in
+parallel (0 or 1 for a sequential evaluation). The parallel hint of a query
+overrides this setting.
+*/
+func (p *eqlRuntimeProvider) SetWorkers(workers int) {
+	p.workers = workers
+}
+
+/*
+visitLimit returns the number of nodes which traversals may still visit (-1 for
+no limit).
+*/
+func (p *eqlRuntimeProvider) visitLimit() int {
+
+	if p.maxVisitedNodes <= 0 {
+		return -1
+	}
+
+	if limit := p.maxVisitedNodes - int(atomic.LoadInt64(p.visited)); limit > 0 {
+		return limit
+	}
+
+	return 0
+}
+
+/*
+addVisited adds a number of nodes to the number of visited nodes.
+*/
+func (p *eqlRuntimeProvider) addVisited(n int) {
+	atomic.AddInt64(p.visited, int64(n))
 }
 
 /*
@@ -114,6 +210,13 @@ func (p *eqlRuntimeProvider) init(startKind string,
 	p.rowEdge = nil
 	p._attrsNodesFetch = nil
 	p._attrsEdgesFetch = nil
+	p.visited = new(int64)
+
+	p.hints = make(map[string][]string)
+	p.parallel = 0
+
+	p.rootChildren = rootChildren
+	p.stats = p.gm.Statistics(p.part, startKind)
 
 	p.colLabels = make([]string, 0)
 	p.colFormat = make([]string, 0)
@@ -151,7 +254,7 @@ func (p *eqlRuntimeProvider) init(startKind string,
 
 			p.where = child
 
-		} else if child.Name == parser.NodeTRAVERSE {
+		} else if child.Name == parser.NodeTRAVERSE || child.Name == parser.NodeJOIN {
 
 			// Check if show clause or where clause is already populated
 
@@ -196,6 +299,12 @@ func (p *eqlRuntimeProvider) init(startKind string,
 
 			withChild = child
 
+		} else if child.Name == parser.NodeHINTS {
+
+			if err := p.initHints(child); err != nil {
+				return err
+			}
+
 		} else {
 
 			return p.newRuntimeError(ErrInvalidConstruct, child.Name, child)
@@ -221,6 +330,12 @@ func (p *eqlRuntimeProvider) init(startKind string,
 		p.primaryKind = startKind
 	}
 
+	// Evaluate the most selective conditions first
+
+	if p.where != nil && p.stats != nil {
+		p.orderConds(p.where.Children[0])
+	}
+
 	return nil
 }
 
@@ -289,6 +404,19 @@ func (p *eqlRuntimeProvider) initWithFlags(withNode *parser.ASTNode,
 			}
 		}
 
+		if col == -1 {
+
+			// Columns can also be referred to by their label (e.g. the
+			// label of an expression)
+
+			for i, label := range p.colLabels {
+				if label == colData {
+					col = i
+					break
+				}
+			}
+		}
+
 		if col == -1 {
 			return -1, p.newRuntimeError(ErrInvalidConstruct,
 				"Cannot determine column for with term: "+colData, node)
@@ -371,6 +499,9 @@ The following formats for a show term are allowed:
 <step>:<type>:<attr>  - Attribute from whatever is at the given traversal step
 <kind>:<attr>         - First matching kind in a row provides the attribute
 <attr>                - Show attribute from root node kind
+
+Show terms can also be expressions over attributes of the root node kind
+(e.g. price * quantity) which are evaluated for each row.
 */
 func (p *eqlRuntimeProvider) initCols() (map[string][]int, map[string][]int, error) {
 
@@ -453,16 +584,34 @@ func (p *eqlRuntimeProvider) initCols() (map[string][]int, map[string][]int, err
 
 			// Create the correct colData value
 
-			if col.Token.ID == parser.TokenAT {
+			if col.Token.ID == parser.TokenAT || col.Token.ID == parser.TokenSHOWTERM {
+				var funcInst FuncShowInst
 
-				// We have a function get the attribute which it operates on
+				if col.Token.ID == parser.TokenSHOWTERM {
 
-				funcName := col.Children[0].Children[0].Token.Val
+					// We have an expression which is evaluated for each row
 
-				funcInst, ok := showFunc[funcName]
-				if !ok {
-					return nil, nil, p.newRuntimeError(ErrInvalidConstruct,
-						"Unknown function: "+funcName, col)
+					funcInst = showExprInst
+
+				} else {
+
+					// We have a function get the attribute which it operates on
+
+					funcName := col.Children[0].Children[0].Token.Val
+
+					var ok bool
+
+					if funcInst, ok = showFunc[funcName]; !ok {
+
+						// Where functions can be shown as expressions
+
+						if _, ok = whereFunc[funcName]; !ok {
+							return nil, nil, p.newRuntimeError(ErrInvalidConstruct,
+								"Unknown function: "+funcName, col)
+						}
+
+						funcInst = showExprInst
+					}
 				}
 
 				colFunc, colData, label, err = funcInst(col.Children[0], p)
@@ -555,7 +704,12 @@ func (p *eqlRuntimeProvider) initCols() (map[string][]int, map[string][]int, err
 			colLabel := label
 			colFormat := "auto"
 
-			for _, t := range col.Children {
+			children := col.Children
+			if col.Token.ID == parser.TokenSHOWTERM {
+				children = children[1:]
+			}
+
+			for _, t := range children {
 
 				if t.Name == parser.NodeAS {
 					colLabel = t.Children[0].Token.Val
@@ -649,16 +803,7 @@ func (p *eqlRuntimeProvider) next() (bool, error) {
 
 	// Get next root node
 
-	startKey, err := p.nextStartKey()
-	if err != nil || startKey == "" {
-		return false, err
-	}
-
-	// Fetch node - always require the key attribute
-	// to make sure we get a node back if it exists
-
-	node, err := p.gm.FetchNodePart(p.part, startKey, p.specs[0],
-		append(p._attrsNodesFetch[0], "key"))
+	node, err := p.nextStartNode()
 
 	if err != nil || node == nil {
 		return false, err
@@ -747,6 +892,7 @@ var generalProviderMap = map[string]generalInst{
 	parser.NodeLIST:     valueRuntimeInst,
 	parser.NodeFUNC:     valueRuntimeInst,
 	parser.NodeTRAVERSE: traversalRuntimeInst,
+	parser.NodeJOIN:     traversalRuntimeInst,
 	parser.NodeWHERE:    whereRuntimeInst,
 
 	// Condition components
@@ -780,6 +926,7 @@ var generalProviderMap = map[string]generalInst{
 	// String operations
 
 	parser.NodeLIKE:        likeRuntimeInst,
+	parser.NodeGLOB:        globRuntimeInst,
 	parser.NodeCONTAINS:    containsRuntimeInst,
 	parser.NodeCONTAINSNOT: containsNotRuntimeInst,
 	parser.NodeBEGINSWITH:  beginsWithRuntimeInst,
@@ -11,8 +11,10 @@
 package interpreter
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"testing"
 
@@ -144,6 +146,623 @@ Data: 1:n:key, 2:n:key, 2:e:key, 2:e:name
 	}
 }
 
+func TestTraversalGuards(t *testing.T) {
+	gm, _ := simpleGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	query := "get mynode where key = '123' traverse ::: traverse ::: end end show 1:n:key, 2:n:key, 3:n:key"
+
+	ast, err := parser.ParseWithRuntime("test", query, rt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ast.Runtime.Eval(); err != nil || len(res.(*SearchResult).Data) != 10 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	rt.SetTraversalGuards(5, false)
+
+	if _, err := ast.Runtime.Eval(); err == nil || err.Error() !=
+		"EQL error in test: Traversal limit exceeded (Query visited more than 5 nodes) (Line:1 Pos:43)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	rt.SetTraversalGuards(100, true)
+
+	if _, err := ast.Runtime.Eval(); err == nil || err.Error() !=
+		"EQL error in test: Traversal cycle detected (Node mynode:123 was reached again on path "+
+			"mynode:123 -> mynewnode:456 -> mynode:123) (Line:1 Pos:43)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Traversals without cycles are not affected
+
+	if err := runSearch("get mynode where key = '123' traverse ::: end show 1:n:key, 2:n:key", `
+Labels: Key, Key
+Format: auto, auto
+Data: 1:n:key, 2:n:key
+123, 456
+123, 456
+123, xxx ⌘
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestRowWindow(t *testing.T) {
+	gm, _ := simpleGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	rt.SetRowWindow(1, 2)
+
+	if res, err := getResult("get mynode traverse ::: end show 1:n:key, 2:n:key", `
+Labels: Key, Key
+Format: auto, auto
+Data: 1:n:key, 2:n:key
+123, 456
+123, xxx ⌘
+`[1:], rt, true); err != nil || !res.Windowed() || res.TotalRowCount() != -1 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Rows outside of the window can be counted
+
+	rt.SetCountRows(true)
+
+	if res, err := getResult("get mynode traverse ::: end show 1:n:key, 2:n:key", `
+Labels: Key, Key
+Format: auto, auto
+Data: 1:n:key, 2:n:key
+123, 456
+123, xxx ⌘
+`[1:], rt, true); err != nil || res.RowCount() != 2 || res.TotalRowCount() != 3 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	rt.SetCountRows(false)
+
+	// The window is not applied to ordered, filtered or aggregated results
+
+	for _, query := range []string{
+		"get mynode show key with ordering(descending key)",
+		"get mynode show key with filtering(isnotnull key)",
+		"get mynode show key, @max(key) as m",
+	} {
+		ast, err := parser.ParseWithRuntime("test", query, rt)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if res, err := ast.Runtime.Eval(); err != nil || res.(*SearchResult).Windowed() ||
+			res.(*SearchResult).RowCount() != 2 || res.(*SearchResult).TotalRowCount() != 2 {
+			t.Error("Unexpected result:", query, res, err)
+			return
+		}
+	}
+
+	// The evaluation stops once the window is full
+
+	gm, _ = songGraph()
+	rt = NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+	rt.SetTraversalGuards(5, false)
+
+	if err := runSearch("get Author traverse :::Song end", "", rt); err == nil ||
+		!strings.Contains(err.Error(), "Traversal limit exceeded") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	rt.SetRowWindow(0, 1)
+
+	if res, err := getResult("get Author traverse :::Song end show 1:n:key", `
+Labels: Key
+Format: auto
+Data: 1:n:key
+123
+`[1:], rt, true); err != nil || !res.Windowed() {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
+func TestParallelEvaluation(t *testing.T) {
+	gm, _ := songGraph()
+
+	ParallelBatchSize = 2
+	defer func() {
+		ParallelBatchSize = 100
+	}()
+
+	// Rows of several workers are in the same order as the rows of a sequential
+	// evaluation - only traversals may return their nodes in any order
+
+	rowKeys := func(res interface{}) string {
+		var keys []interface{}
+		for _, row := range res.(*SearchResult).Data {
+			keys = append(keys, row[0])
+		}
+		res.(*SearchResult).StableSort()
+		return fmt.Sprint(keys)
+	}
+
+	for _, query := range []string{
+		"get Song",
+		"get Song where ranking > 3 show key, ranking",
+		"get Author traverse :::Song end show 1:n:key, 2:n:key, 2:n:ranking",
+		"get Author traverse :::Song traverse ::: end end show 1:n:key, 2:n:key, 3:n:key",
+		"get Song where @count(:::) > 1 show key, @count(1, ':::') as c",
+		"get Author traverse :::Song end show 1:n:key, 2:n:key, @count(2, ':::') as c with ordering(ascending c)",
+		"lookup Song 'Aria1', 'Aria2', 'FightSong4', 'LoveSong3' traverse ::: end",
+	} {
+		var rt parser.RuntimeProvider
+
+		grtp := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+		rtp := grtp.eqlRuntimeProvider
+		rt = grtp
+
+		if strings.HasPrefix(query, "lookup") {
+			lrtp := NewLookupRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+			rtp = lrtp.eqlRuntimeProvider
+			rt = lrtp
+		}
+
+		ast, err := parser.ParseWithRuntime("test", query, rt)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		seq, err := ast.Runtime.Eval()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		seqKeys := rowKeys(seq)
+
+		for _, workers := range []int{2, 3, 8} {
+			rtp.SetWorkers(workers)
+
+			if res, err := ast.Runtime.Eval(); err != nil || rowKeys(res) != seqKeys ||
+				res.(*SearchResult).String() != seq.(*SearchResult).String() {
+				t.Error("Unexpected result:", query, workers, res, err, "expected:", seq)
+				return
+			}
+		}
+	}
+
+	// The parallel hint overrides the number of workers
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+	rt.SetWorkers(4)
+
+	if err := runSearch("/*+ parallel(1) */ get Author traverse :::Song end show 1:n:key, 2:n:key", `
+Labels: Key, Key
+Format: auto, auto
+Data: 1:n:key, 2:n:key
+000, Aria1
+000, Aria2
+000, Aria3
+000, Aria4
+123, DeadSong2
+123, FightSong4
+123, LoveSong3
+123, StrangeSong1
+456, MyOnlySong3
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A row window stops the workers once it is full
+
+	rt.SetRowWindow(1, 2)
+
+	if res, err := getResult("get Song show key", `
+Labels: Song Key
+Format: auto
+Data: 1:n:key
+FightSong4
+DeadSong2
+`[1:], rt, false); err != nil || !res.Windowed() {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	rt.SetRowWindow(0, 0)
+
+	// Traversal guards are shared by all workers
+
+	rt.SetTraversalGuards(5, false)
+
+	if err := runSearch("get Author traverse :::Song end", "", rt); err == nil ||
+		!strings.Contains(err.Error(), "Traversal limit exceeded") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Canceled queries stop with an error
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rt.SetTraversalGuards(0, false)
+	rt.SetContext(ctx)
+
+	if err := runSearch("get Song", "", rt); err == nil ||
+		!strings.Contains(err.Error(), "Query was canceled") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestQueryHints(t *testing.T) {
+	gm, _ := simpleGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Start nodes are looked up in the index - the where clause still applies
+
+	if err := runSearch("/*+ use_index(Name) */ get mynewnode where Name = 'Node3' and key != '789-2' show key, Name", `
+Labels: Mynewnode Key, Name
+Format: auto, auto
+Data: 1:n:key, 1:n:Name
+789, Node3
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("/*+ use_index(Name) parallel(4) no_cache */ get mynode where 'Node1' = Name", `
+Labels: Mynode Key, Name
+Format: auto, auto
+Data: 1:n:key, 1:n:Name
+123, Node1
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Start nodes of all values of an in condition are looked up
+
+	if err := runSearch("/*+ use_index(Name) */ get mynewnode where Name in ['Node3', 'Node3-2', 'Node3'] show key, Name", `
+Labels: Mynewnode Key, Name
+Format: auto, auto
+Data: 1:n:key, 1:n:Name
+789-2, Node3-2
+789, Node3
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Rows are evaluated by several workers
+
+	ParallelBatchSize = 2
+	defer func() {
+		ParallelBatchSize = 100
+	}()
+
+	if err := runSearch("/*+ parallel(2) */ get mynewnode where key != '456' show key, Name", `
+Labels: Mynewnode Key, Name
+Format: auto, auto
+Data: 1:n:key, 1:n:Name
+789-2, Node3-2
+789, Node3
+xxx ⌘, <not set>
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ast, err := parser.ParseWithRuntime("test", "/*+ no_cache */ get mynode", rt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ast.Runtime.Eval(); err != nil || fmt.Sprint(res.(*SearchResult).Hints()) != "map[no_cache:[]]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	for query, expected := range map[string]string{
+		"/*+ foo */ get mynode": "EQL error in test: Invalid query hint (Unknown hint: foo) (Line:1 Pos:5)",
+		"/*+ parallel */ get mynode": "EQL error in test: Invalid query hint " +
+			"(Hint parallel requires 1 argument(s)) (Line:1 Pos:5)",
+		"/*+ parallel(100) */ get mynode": "EQL error in test: Invalid query hint " +
+			"(Number of parallel workers must be between 1 and 16) (Line:1 Pos:14)",
+		"/*+ use_index(Name) */ get mynode where Name = 'Node1' or true": "EQL error in test: " +
+			"Invalid query hint (Where clause has no equality condition for attribute: Name) (Line:1 Pos:1)",
+		"/*+ use_index(Name) */ get mynode where Name in ['Node1', key]": "EQL error in test: " +
+			"Invalid query hint (Where clause has no equality condition for attribute: Name) (Line:1 Pos:1)",
+	} {
+		ast, err := parser.ParseWithRuntime("test", query, rt)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := ast.Runtime.Eval(); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+
+	ast, err = parser.ParseWithRuntime("test", "/*+ use_index(Name) */ lookup mynode '123'",
+		NewLookupRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := ast.Runtime.Eval(); err == nil || err.Error() !=
+		"EQL error in test: Invalid query hint (Index hint cannot be used in lookup queries) (Line:1 Pos:1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gm.SetIndexed("mynode", "Name", false)
+
+	ast, err = parser.ParseWithRuntime("test", "/*+ use_index(Name) */ get mynode where Name = 'Node1'", rt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := ast.Runtime.Eval(); err == nil || err.Error() !=
+		"EQL error in test: Invalid query hint (Attribute is not indexed: Name) (Line:1 Pos:1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestStatisticsOptimization(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	validate := func(query string) *parser.ASTNode {
+		ast, err := parser.ParseWithRuntime("test", query, rt)
+		if err == nil {
+			err = ast.Runtime.Validate()
+		}
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return ast
+	}
+
+	indexKeys := func() string {
+		keys, ok, err := rt.statsIndexStartKeys("Song")
+		if err != nil {
+			return err.Error()
+		} else if !ok {
+			return "scan"
+		}
+		sort.Strings(keys)
+		return fmt.Sprint(keys)
+	}
+
+	// Without statistics conditions are evaluated in the order of the query
+	// and all nodes of the start kind are scanned
+
+	ast := validate("get Song where ranking > 10 and name = 'Aria3'")
+	if ast == nil {
+		return
+	} else if ast.Children[1].Children[0].Runtime.(*andRuntime).swapped {
+		t.Error("Unexpected result: operands were swapped")
+		return
+	} else if res := indexKeys(); res != "scan" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := gm.Analyze("main", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	IndexSelectivity = 0.2
+	defer func() {
+		IndexSelectivity = 0.1
+	}()
+
+	// The most selective condition is evaluated first and the start nodes
+	// are looked up in the index
+
+	if ast = validate("get Song where ranking > 10 and name = 'Aria3'"); ast == nil {
+		return
+	} else if !ast.Children[1].Children[0].Runtime.(*andRuntime).swapped {
+		t.Error("Unexpected result: operands were not swapped")
+		return
+	} else if res := indexKeys(); res != "[Aria3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if ast = validate("get Song where name = 'Aria3' or ranking > 1"); ast == nil {
+		return
+	} else if !ast.Children[1].Children[0].Runtime.(*orRuntime).swapped {
+		t.Error("Unexpected result: operands were not swapped")
+		return
+	} else if res := indexKeys(); res != "scan" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := runSearch("get Song where ranking > 1 and not ranking > 10 and name = 'Aria3' show key, ranking", `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+Aria3, 4
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Numeric attributes and unselective conditions are not looked up in the index
+
+	for query, expected := range map[string]string{
+		"get Song where ranking = 4":                     "scan",
+		"get Song where name != 'Aria3'":                 "scan",
+		"get Song where name in ['Aria3', 'Aria4']":      "scan",
+		"get Song where ranking = 18 and name = 'Aria4'": "[Aria4]",
+	} {
+		if validate(query) == nil {
+			return
+		} else if res := indexKeys(); res != expected {
+			t.Error("Unexpected result:", query, res)
+			return
+		}
+	}
+
+	IndexSelectivity = 0.5
+
+	if validate("get Song where name in ['Aria3', 'Aria4']") == nil {
+		return
+	} else if res := indexKeys(); res != "[Aria3 Aria4]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.SetIndexed("Song", "name", false)
+
+	if validate("get Song where name = 'Aria3'") == nil {
+		return
+	} else if res := indexKeys(); res != "scan" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestShowExpressions(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if err := runSearch("get Song where ranking > 10 show name, ranking * 2 + 1 as score, "+
+		"(ranking - 4) / 2, @concat(name, ' (', ranking, ')') format text", `
+Labels: Song Name, score, (ranking - 4) / 2, @concat(name, " (", ranking, ")")
+Format: auto, auto, auto, text
+Data: 1:n:name, 1:func:expr(), 1:func:expr(), 1:func:expr()
+Aria4, 37, 7, Aria4 (18)
+MyOnlySong3, 39, 7.5, MyOnlySong3 (19)
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Song where -ranking < -18 show @concat(name, '!') as title, -ranking", `
+Labels: title, -ranking
+Format: auto, auto
+Data: 1:func:expr(), 1:func:expr()
+MyOnlySong3!, -19
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Song where name = 'Aria1' show name + ranking", "", rt); err == nil || err.Error() !=
+		"EQL error in test: Value of operand is not a number (name=Aria1) (Line:1 Pos:36)" {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Song show @foo(name)", "", rt); err == nil || err.Error() !=
+		"EQL error in test: Invalid construct (Unknown function: foo) (Line:1 Pos:15)" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestJoins(t *testing.T) {
+	gm := orderGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Join on the key of the joined kind
+
+	if err := runSearch("get Order join Customer on Order.customer_id = Customer.key show key, total, Customer:name", `
+Labels: Order Key, Total, Customer Name
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:total, 2:n:name
+o1, 10, Alice
+o2, 20, Bob
+o3, 5, Alice
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Orders without a matching customer are included with nulltraversal
+
+	if err := runSearch("get Order join Customer on customer_id = Customer.key show key, 2:n:name with nulltraversal(true)", `
+Labels: Order Key, Name
+Format: auto, auto
+Data: 1:n:key, 2:n:name
+o1, Alice
+o2, Bob
+o3, Alice
+o4, <not set>
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Join on an attribute of the joined kind using the index
+
+	if err := runSearch("get Customer where city = 'Berlin' join Order on Order.customer_id = Customer.key "+
+		"show name, Order:key, Order:total", `
+Labels: Customer Name, Order Key, Total
+Format: auto, auto, auto
+Data: 1:n:name, 2:n:key, 2:n:total
+Alice, o1, 10
+Alice, o3, 5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Joins can be combined with traversals
+
+	if err := runSearch("get Order where key = 'o2' join Customer on Order.customer_id = Customer.key "+
+		"traverse ::: end show key, Customer:name, 3:n:key", `
+Labels: Order Key, Customer Name, Key
+Format: auto, auto, auto
+Data: 1:n:key, 2:n:name, 3:n:key
+o2, Bob, p1
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.SetIndexed("Order", "customer_id", false)
+
+	for query, expected := range map[string]string{
+		"get Order join Foo on Order.customer_id = Foo.key": "EQL error in test: Unknown node kind (Foo) (Line:1 Pos:16)",
+		"get Order join Customer on Order.customer_id > Customer.key": "EQL error in test: Invalid join " +
+			"(Join condition must be an equality between two attributes) (Line:1 Pos:46)",
+		"get Order join Customer on Order.customer_id = key": "EQL error in test: Invalid join " +
+			"(Join condition must compare an attribute of Customer) (Line:1 Pos:46)",
+		"get Customer join Order on Order.customer_id = Customer.key": "EQL error in test: Invalid join " +
+			"(Attribute is not indexed: customer_id) (Line:1 Pos:46)",
+		"get Order traverse ::: join Customer on Order.customer_id = Customer.key end": "EQL error in test: " +
+			"Invalid construct (join) (Line:1 Pos:24)",
+	} {
+		if err := runSearch(query, "", rt); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", query, err)
+			return
+		}
+	}
+}
+
 func TestErrors(t *testing.T) {
 	gm, mgs := simpleGraph()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -705,6 +1324,50 @@ func multiKindGraph() *graph.Manager {
 	return gm
 }
 
+func orderGraph() *graph.Manager {
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	for _, c := range [][]string{{"c1", "Alice", "Berlin"}, {"c2", "Bob", "Paris"}, {"c3", "Carol", "Berlin"}} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", c[0])
+		node.SetAttr("kind", "Customer")
+		node.SetAttr("name", c[1])
+		node.SetAttr("city", c[2])
+		gm.StoreNode("main", node)
+	}
+
+	for _, o := range [][]interface{}{{"o1", "c1", 10}, {"o2", "c2", 20}, {"o3", "c1", 5}, {"o4", "c9", 7}} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", o[0])
+		node.SetAttr("kind", "Order")
+		node.SetAttr("customer_id", o[1])
+		node.SetAttr("total", o[2])
+		gm.StoreNode("main", node)
+	}
+
+	product := data.NewGraphNode()
+	product.SetAttr("key", "p1")
+	product.SetAttr("kind", "Product")
+	gm.StoreNode("main", product)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "Contains")
+	edge.SetAttr(data.EdgeEnd1Key, "o2")
+	edge.SetAttr(data.EdgeEnd1Kind, "Order")
+	edge.SetAttr(data.EdgeEnd1Role, "order")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "p1")
+	edge.SetAttr(data.EdgeEnd2Kind, "Product")
+	edge.SetAttr(data.EdgeEnd2Role, "product")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+	gm.StoreEdge("main", edge)
+
+	return gm
+}
+
 func dataNodes() *graph.Manager {
 
 	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
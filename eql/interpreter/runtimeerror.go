@@ -54,6 +54,7 @@ Runtime related error types
 */
 var (
 	ErrNotARegex        = errors.New("Value of operand is not a valid regex")
+	ErrNotAGlob         = errors.New("Value of operand is not a valid glob pattern")
 	ErrNotANumber       = errors.New("Value of operand is not a number")
 	ErrNotAList         = errors.New("Value of operand is not a list")
 	ErrInvalidConstruct = errors.New("Invalid construct")
@@ -62,6 +63,13 @@ var (
 	ErrInvalidWhere     = errors.New("Invalid where clause")
 	ErrInvalidColData   = errors.New("Invalid column data spec")
 	ErrEmptyTraversal   = errors.New("Empty traversal")
+	ErrCanceled         = errors.New("Query was canceled")
+	ErrTraversalLimit   = errors.New("Traversal limit exceeded")
+	ErrTraversalCycle   = errors.New("Traversal cycle detected")
+	ErrInvalidHint      = errors.New("Invalid query hint")
+	ErrInvalidJoin      = errors.New("Invalid join")
+	ErrInvalidUnion     = errors.New("Invalid union")
+	ErrRemoteCallLimit  = errors.New("Remote call budget exceeded")
 )
 
 /*
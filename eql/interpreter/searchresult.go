@@ -73,15 +73,23 @@ func (sh *SearchHeader) Data() []string {
 SearchResult data structure. A search result represents the result of an EQL query.
 */
 type SearchResult struct {
-	name      string     // Name to identify the result
-	query     string     // Query which produced the search result
-	withFlags *withFlags // With flags which should be applied to the result
+	name      string              // Name to identify the result
+	query     string              // Query which produced the search result
+	withFlags *withFlags          // With flags which should be applied to the result
+	hints     map[string][]string // Query hints of the query
 
 	SearchHeader            // Embedded search header
 	colFunc      []FuncShow // Function which transforms the data
 
+	aggCols    []aggregateFunc         // Aggregate functions of aggregated columns (nil if there are none)
+	groups     map[string]*resultGroup // Groups of rows with aggregated columns
+	groupOrder []*resultGroup          // Groups in the order of their first row
+
 	Source [][]string      // Special string holding the data source (node / edge) for each column
 	Data   [][]interface{} // Data which is held by this search result
+
+	windowed  bool // Flag if the result only holds the rows of a row window
+	totalRows int  // Number of all rows of a windowed result (-1 if the rows were not counted)
 }
 
 /*
@@ -99,8 +107,21 @@ func newSearchResult(rtp *eqlRuntimeProvider, query string) *SearchResult {
 		}
 	}
 
-	return &SearchResult{rtp.name, query, rtp.withFlags, SearchHeader{rtp.primaryKind, rtp.part, rtp.colLabels, rtp.colFormat,
-		cdl}, rtp.colFunc, make([][]string, 0), make([][]interface{}, 0)}
+	// Rows are grouped if any column is aggregated
+
+	var aggCols []aggregateFunc
+
+	for i, cf := range rtp.colFunc {
+		if af, ok := cf.(aggregateFunc); ok {
+			if aggCols == nil {
+				aggCols = make([]aggregateFunc, len(rtp.colFunc))
+			}
+			aggCols[i] = af
+		}
+	}
+
+	return &SearchResult{rtp.name, query, rtp.withFlags, rtp.hints, SearchHeader{rtp.primaryKind, rtp.part, rtp.colLabels, rtp.colFormat,
+		cdl}, rtp.colFunc, aggCols, make(map[string]*resultGroup), nil, make([][]string, 0), make([][]interface{}, 0), false, -1}
 }
 
 /*
@@ -199,17 +220,40 @@ func (sr *SearchResult) addRow(rowNodes []data.Node, rowEdges []data.Edge) error
 		}
 	}
 
+	// Rows with aggregated columns are only kept per group
+
+	if sr.aggCols != nil {
+		sr.aggregateRow(row, src)
+		return nil
+	}
+
 	sr.Source = append(sr.Source, src)
 	sr.Data = append(sr.Data, row)
 
 	return nil
 }
 
+/*
+windowable checks if the rows of this result can be limited to a row window
+while they are added. This is not possible if the result is ordered, filtered
+or aggregated once all rows have been added.
+*/
+func (sr *SearchResult) windowable() bool {
+	return sr.aggCols == nil && len(sr.withFlags.ordering) == 0 &&
+		len(sr.withFlags.notnullCol) == 0 && len(sr.withFlags.uniqueCol) == 0
+}
+
 /*
 finish is called once all rows have been added.
 */
 func (sr *SearchResult) finish() {
 
+	// Create the rows of aggregated groups
+
+	if sr.aggCols != nil {
+		sr.finishAggregation()
+	}
+
 	// Apply filtering
 
 	if len(sr.withFlags.notnullCol) > 0 || len(sr.withFlags.uniqueCol) > 0 {
@@ -287,6 +331,21 @@ func (sr *SearchResult) Query() string {
 	return sr.query
 }
 
+/*
+Hints returns the query hints of the query which produced this result.
+*/
+func (sr *SearchResult) Hints() map[string][]string {
+	return sr.hints
+}
+
+/*
+Windowed returns if the result only holds the rows of a row window which was
+set for the evaluation of the query.
+*/
+func (sr *SearchResult) Windowed() bool {
+	return sr.windowed
+}
+
 /*
 RowCount returns the number of rows of the result.
 */
@@ -294,6 +353,18 @@ func (sr *SearchResult) RowCount() int {
 	return len(sr.Data)
 }
 
+/*
+TotalRowCount returns the number of rows of the full result. Returns -1 if the
+result only holds the rows of a row window and the other rows were not
+counted.
+*/
+func (sr *SearchResult) TotalRowCount() int {
+	if !sr.windowed {
+		return len(sr.Data)
+	}
+	return sr.totalRows
+}
+
 /*
 Row returns a row of the result.
 */
@@ -413,6 +484,13 @@ func (c SearchResultRowComparator) Less(i, j int) bool {
 	c1 := c.Data[i][c.Column]
 	c2 := c.Data[j][c.Column]
 
+	if res, ok := data.CompareValues(c1, c2); ok {
+		if c.Ascening {
+			return res < 0
+		}
+		return res > 0
+	}
+
 	num1, err := strconv.ParseFloat(fmt.Sprint(c1), 64)
 	if err == nil {
 		num2, err := strconv.ParseFloat(fmt.Sprint(c2), 64)
@@ -11,20 +11,29 @@
 package interpreter
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
 )
 
 /*
 traversalRuntime is the runtime for traversals.
 */
 type traversalRuntime struct {
-	rtp  *eqlRuntimeProvider
-	node *parser.ASTNode
+	rtp    *eqlRuntimeProvider
+	node   *parser.ASTNode
+	parent *traversalRuntime // Parent traversal (nil for top level traversals)
 
-	where *parser.ASTNode // Traversal where clause
+	where  *parser.ASTNode // Traversal where clause
+	inLast *parser.ASTNode // inLast function call which restricts the traversal to a time span
+
+	joinAttr   string // Attribute of the joined nodes (only for joins)
+	sourceAttr string // Attribute of the source node which is joined on (only for joins)
 
 	sourceNode data.Node   // Source node for traversal - should be injected by the parent
 	spec       string      // Spec for this traversal
@@ -38,7 +47,7 @@ type traversalRuntime struct {
 traversalRuntimeInst returns a new runtime component instance.
 */
 func traversalRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtime {
-	return &traversalRuntime{rtp, node, nil, nil, "", -1, nil, nil, 0}
+	return &traversalRuntime{rtp, node, nil, nil, nil, "", "", nil, "", -1, nil, nil, 0}
 }
 
 /*
@@ -46,6 +55,10 @@ Validate this node and all its child nodes.
 */
 func (rt *traversalRuntime) Validate() error {
 
+	if rt.node.Name == parser.NodeJOIN {
+		return rt.validateJoin()
+	}
+
 	spec := rt.node.Children[0].Token.Val
 	rt.specIndex = -1
 
@@ -59,6 +72,10 @@ func (rt *traversalRuntime) Validate() error {
 	rt.spec = spec
 	rt.specIndex = len(rt.rtp.specs)
 	rt.where = nil
+	rt.inLast = nil
+	rt.nodes = nil
+	rt.edges = nil
+	rt.curptr = 0
 	rt.rtp.specs = append(rt.rtp.specs, spec)
 	rt.rtp.attrsNodes = append(rt.rtp.attrsNodes, make(map[string]string))
 	rt.rtp.attrsEdges = append(rt.rtp.attrsEdges, make(map[string]string))
@@ -69,6 +86,8 @@ func (rt *traversalRuntime) Validate() error {
 
 		if child.Name == parser.NodeTRAVERSE {
 
+			child.Runtime.(*traversalRuntime).parent = rt
+
 			if err := child.Runtime.Validate(); err != nil {
 				return err
 			}
@@ -87,6 +106,12 @@ func (rt *traversalRuntime) Validate() error {
 
 			rt.where = child
 
+			// Check if the traversal can use the timelines of timestamped edges
+
+			if sspec[1] != "" {
+				rt.inLast = findInLast(child.Children[0])
+			}
+
 		} else {
 			return rt.rtp.newRuntimeError(ErrInvalidConstruct, child.Name, child)
 		}
@@ -95,6 +120,27 @@ func (rt *traversalRuntime) Validate() error {
 	return nil
 }
 
+/*
+findInLast finds an inLast function call which must be true for a given
+condition to be true (i.e. the condition itself or a part of an and condition).
+*/
+func findInLast(cond *parser.ASTNode) *parser.ASTNode {
+
+	if cond.Token != nil && cond.Token.ID == parser.TokenAT && len(cond.Children) == 2 &&
+		cond.Children[0].Token.Val == "inLast" {
+		return cond
+
+	} else if cond.Name == parser.NodeAND {
+		for _, child := range cond.Children {
+			if ret := findInLast(child); ret != nil {
+				return ret
+			}
+		}
+	}
+
+	return nil
+}
+
 /*
 hasMoreNodes returns true if this traversal runtime component can produce more
 nodes. If the result is negative then a new source node is required.
@@ -126,21 +172,71 @@ func (rt *traversalRuntime) newSource(node data.Node) error {
 	if node != nil {
 		var err error
 
-		// Do a simple traversal without getting any node data first
+		// Nodes which would exceed the visited node limit are not read
+
+		max := rt.rtp.visitLimit()
+
+		ctx := rt.rtp.ctx
+
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		if rt.node.Name == parser.NodeJOIN {
+
+			// Joined nodes are looked up with their attributes
+
+			nodes, edges, err = rt.joinNodes(max)
 
-		nodes, edges, err = rt.rtp.gm.TraverseMulti(rt.rtp.part, rt.sourceNode.Key(),
-			rt.sourceNode.Kind(), rt.spec, false)
+		} else if rt.inLast != nil {
+			var span time.Duration
 
-		if err != nil {
+			// Only traverse timestamped edges within the time span
+
+			if span, err = inLastTimeSpan(rt.inLast, rt.rtp); err == nil {
+				now := time.Now()
+
+				nodes, edges, err = rt.rtp.gm.TraverseTimeRangeLimit(rt.rtp.part, rt.sourceNode.Key(),
+					rt.sourceNode.Kind(), rt.spec, now.Add(-span), now.Add(time.Nanosecond), false, max)
+			}
+
+		} else {
+
+			// Do a simple traversal without getting any node data first
+
+			nodes, edges, err = rt.rtp.gm.TraverseMultiLimit(ctx, rt.rtp.part, rt.sourceNode.Key(),
+				rt.sourceNode.Kind(), rt.spec, false, max)
+		}
+
+		if gerr, ok := err.(*util.GraphError); ok && gerr.Type == util.ErrTraversalLimit {
+			return rt.rtp.newRuntimeError(ErrTraversalLimit, fmt.Sprintf(
+				"Query visited more than %v nodes", rt.rtp.maxVisitedNodes), rt.node)
+		} else if err != nil {
 			return err
 		}
 
+		if err = rt.checkGuards(nodes); err != nil {
+			return err
+		}
+
+		// Nodes of other shards are fetched in batches if attributes are required
+
+		if len(rt.rtp._attrsNodesFetch[rt.specIndex]) > 0 && rt.node.Name != parser.NodeJOIN {
+			err = rt.rtp.gm.FetchRemoteNodes(ctx, rt.rtp.part, nodes)
+
+			if gerr, ok := err.(*util.GraphError); ok && gerr.Type == util.ErrRemoteCallLimit {
+				return rt.rtp.newRuntimeError(ErrRemoteCallLimit, gerr.Detail, rt.node)
+			} else if err != nil {
+				return err
+			}
+		}
+
 		// Now get the attributes which are required
 
 		for _, node := range nodes {
 			attrs := rt.rtp._attrsNodesFetch[rt.specIndex]
 
-			if len(attrs) > 0 {
+			if len(attrs) > 0 && rt.node.Name != parser.NodeJOIN {
 				n, err := rt.rtp.gm.FetchNodePart(rt.rtp.part, node.Key(), node.Kind(), attrs)
 
 				if err != nil {
@@ -155,7 +251,7 @@ func (rt *traversalRuntime) newSource(node data.Node) error {
 		for _, edge := range edges {
 			attrs := rt.rtp._attrsEdgesFetch[rt.specIndex]
 
-			if len(attrs) > 0 {
+			if len(attrs) > 0 && edge != nil {
 				e, err := rt.rtp.gm.FetchEdgePart(rt.rtp.part, edge.Key(), edge.Kind(), attrs)
 
 				if err != nil {
@@ -212,6 +308,49 @@ func (rt *traversalRuntime) newSource(node data.Node) error {
 	return err
 }
 
+/*
+checkGuards counts the visited nodes of a traversal and checks them against the
+nodes of the current traversal path. The visited node limit is already enforced
+by the traversal itself.
+*/
+func (rt *traversalRuntime) checkGuards(nodes []data.Node) error {
+
+	rt.rtp.addVisited(len(nodes))
+
+	if rt.rtp.cycleDetection {
+
+		// Collect the nodes of the current path
+
+		var path []data.Node
+
+		for trt := rt; trt != nil; trt = trt.parent {
+			path = append([]data.Node{trt.sourceNode}, path...)
+		}
+
+		onPath := make(map[string]bool)
+
+		for _, node := range path {
+			onPath[node.Kind()+":"+node.Key()] = true
+		}
+
+		for _, node := range nodes {
+			if id := node.Kind() + ":" + node.Key(); onPath[id] {
+				var pathIDs []string
+
+				for _, node := range path {
+					pathIDs = append(pathIDs, node.Kind()+":"+node.Key())
+				}
+
+				return rt.rtp.newRuntimeError(ErrTraversalCycle, fmt.Sprintf(
+					"Node %v was reached again on path %v", id,
+					strings.Join(append(pathIDs, id), " -> ")), rt.node)
+			}
+		}
+	}
+
+	return nil
+}
+
 /*
 Eval evaluate this runtime component.
 */
@@ -91,44 +91,23 @@ func (rt *whereItemRuntime) stringOp(node data.Node, edge data.Edge, op func(str
 }
 
 /*
-regexOp executes an operation on a string and a regex.
-*/
-func (rt *whereItemRuntime) regexOp(node data.Node, edge data.Edge, op func(string, *regexp.Regexp) interface{}) (interface{}, error) {
-
-	res1, err := rt.astNode.Children[0].Runtime.(CondRuntime).CondEval(node, edge)
-	if err != nil {
-		return nil, err
-	}
-
-	res2, err := rt.astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
-	if err != nil {
-		return nil, err
-	}
-
-	// Try to compile the regex
-
-	res2String := fmt.Sprint(res2)
-
-	regexp, err := regexp.Compile(res2String)
-	if err != nil {
-		return nil, rt.rtp.newRuntimeError(ErrNotARegex,
-			fmt.Sprintf("%#v - %s", res2String, err.Error()), rt.astNode.Children[1])
-	}
-
-	return op(fmt.Sprint(res1), regexp), nil
-}
-
-/*
-numOp executes an operation on two number values.
+numOp executes an operation on two number values. Prefix operations
+(e.g. -ranking) use 0 as first value.
 */
 func (rt *whereItemRuntime) numOp(node data.Node, edge data.Edge, op func(float64, float64) interface{}) (interface{}, error) {
+	var res1 interface{} = 0
+	var err error
 
-	res1, err := rt.astNode.Children[0].Runtime.(CondRuntime).CondEval(node, edge)
-	if err != nil {
-		return nil, err
+	left := rt.astNode.Children[0]
+	right := rt.astNode.Children[len(rt.astNode.Children)-1]
+
+	if left != right {
+		if res1, err = left.Runtime.(CondRuntime).CondEval(node, edge); err != nil {
+			return nil, err
+		}
 	}
 
-	res2, err := rt.astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+	res2, err := right.Runtime.(CondRuntime).CondEval(node, edge)
 	if err != nil {
 		return nil, err
 	}
@@ -146,18 +125,39 @@ func (rt *whereItemRuntime) numOp(node data.Node, edge data.Edge, op func(float6
 	res1Str := fmt.Sprint(res1)
 	res1Num, err := strconv.ParseFloat(res1Str, 64)
 	if err != nil {
-		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[0].Token.Val, res1Str), rt.astNode.Children[0])
+		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(left.Token.Val, res1Str), left)
 	}
 
 	res2Str := fmt.Sprint(res2)
 	res2Num, err := strconv.ParseFloat(res2Str, 64)
 	if err != nil {
-		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[1].Token.Val, res2Str), rt.astNode.Children[1])
+		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(right.Token.Val, res2Str), right)
 	}
 
 	return op(res1Num, res2Num), nil
 }
 
+/*
+compareOp executes a comparison on two values. Rich value types (e.g. datetimes
+or decimals) are compared according to their type, numbers are compared
+numerically and all other values by their string representation. The given
+function gets the result of the comparison (-1, 0 or 1).
+*/
+func (rt *whereItemRuntime) compareOp(node data.Node, edge data.Edge, op func(int) bool) (interface{}, error) {
+
+	res1, err := rt.astNode.Children[0].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
+
+	res2, err := rt.astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
+
+	return op(compareValues(res1, res2)), nil
+}
+
 /*
 listOp executes a list operation on a single value and a list.
 */
@@ -193,12 +193,18 @@ func (rt *whereItemRuntime) listOp(node data.Node, edge data.Edge, op func(inter
 
 /*
 boolOp executes an operation on two boolean values. Can optionally try a
-short circuit operation.
+short circuit operation. The operands are evaluated in reverse order if the
+swapped flag is set.
 */
 func (rt *whereItemRuntime) boolOp(node data.Node, edge data.Edge, op func(bool, bool) interface{},
-	scop func(bool) interface{}) (interface{}, error) {
+	scop func(bool) interface{}, swapped bool) (interface{}, error) {
 
-	res1, err := rt.astNode.Children[0].Runtime.(CondRuntime).CondEval(node, edge)
+	first, second := 0, 1
+	if swapped {
+		first, second = 1, 0
+	}
+
+	res1, err := rt.astNode.Children[first].Runtime.(CondRuntime).CondEval(node, edge)
 	if err != nil {
 		return nil, err
 	}
@@ -220,7 +226,7 @@ func (rt *whereItemRuntime) boolOp(node data.Node, edge data.Edge, op func(bool,
 		}
 	}
 
-	res2, err := rt.astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+	res2, err := rt.astNode.Children[second].Runtime.(CondRuntime).CondEval(node, edge)
 	if err != nil {
 		return nil, err
 	}
@@ -257,8 +263,42 @@ func toBool(res interface{}) bool {
 	}
 }
 
+/*
+compareValues compares two values. Returns -1, 0 or 1.
+*/
+func compareValues(res1 interface{}, res2 interface{}) int {
+
+	if res, ok := data.CompareValues(res1, res2); ok {
+		return res
+	}
+
+	// Try to convert the values into numbers
+
+	num1, err := strconv.ParseFloat(fmt.Sprint(res1), 64)
+	if err == nil {
+		num2, err := strconv.ParseFloat(fmt.Sprint(res2), 64)
+		if err == nil {
+			if num1 < num2 {
+				return -1
+			} else if num1 > num2 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(res1), fmt.Sprint(res2))
+}
+
+/*
+equals checks if two values are equal.
+*/
 func equals(res1 interface{}, res2 interface{}) bool {
 
+	if res, ok := data.CompareValues(res1, res2); ok {
+		return res == 0
+	}
+
 	// Try to convert the string into a number
 
 	num1, err := strconv.ParseFloat(fmt.Sprint(res1), 64)
@@ -444,16 +484,7 @@ func lessThanRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.R
 CondEval evaluates this condition runtime element.
 */
 func (rt *lessThanRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 < res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) < fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.compareOp(node, edge, func(res int) bool { return res < 0 })
 }
 
 /*
@@ -474,16 +505,7 @@ func lessThanEqualsRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) pa
 CondEval evaluates this condition runtime element.
 */
 func (rt *lessThanEqualsRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 <= res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) <= fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.compareOp(node, edge, func(res int) bool { return res <= 0 })
 }
 
 /*
@@ -504,16 +526,7 @@ func greaterThanRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parse
 CondEval evaluates this condition runtime element.
 */
 func (rt *greaterThanRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 > res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) > fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.compareOp(node, edge, func(res int) bool { return res > 0 })
 }
 
 /*
@@ -534,16 +547,7 @@ func greaterThanEqualsRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode)
 CondEval evaluates this condition runtime element.
 */
 func (rt *greaterThanEqualsRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 >= res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) >= fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.compareOp(node, edge, func(res int) bool { return res >= 0 })
 }
 
 /*
@@ -551,13 +555,15 @@ And runtime
 */
 type andRuntime struct {
 	*whereItemRuntime
+
+	swapped bool // Flag if the second operand should be evaluated first
 }
 
 /*
 andRuntimeInst returns a new runtime component instance.
 */
 func andRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtime {
-	return &andRuntime{&whereItemRuntime{rtp, node}}
+	return &andRuntime{&whereItemRuntime{rtp, node}, false}
 }
 
 /*
@@ -570,7 +576,7 @@ func (rt *andRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, err
 				return false
 			}
 			return nil
-		})
+		}, rt.swapped)
 }
 
 /*
@@ -578,13 +584,15 @@ Or runtime
 */
 type orRuntime struct {
 	*whereItemRuntime
+
+	swapped bool // Flag if the second operand should be evaluated first
 }
 
 /*
 orRuntimeInst returns a new runtime component instance.
 */
 func orRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtime {
-	return &orRuntime{&whereItemRuntime{rtp, node}}
+	return &orRuntime{&whereItemRuntime{rtp, node}, false}
 }
 
 /*
@@ -597,7 +605,7 @@ func (rt *orRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, erro
 				return true
 			}
 			return nil
-		})
+		}, rt.swapped)
 }
 
 /*
@@ -618,7 +626,7 @@ func notRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtim
 CondEval evaluates this condition runtime element.
 */
 func (rt *notRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	return rt.boolOp(node, edge, func(res1 bool, res2 bool) interface{} { return !res1 }, nil)
+	return rt.boolOp(node, edge, func(res1 bool, res2 bool) interface{} { return !res1 }, nil, false)
 }
 
 /*
@@ -808,10 +816,26 @@ func (rt *notInRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, e
 }
 
 /*
-Like runtime
+MaxPatternLength is the maximum length of the patterns of the like and glob
+operators. Regular expressions use the RE2 syntax and are matched in linear
+time - the length limit bounds the size of compiled patterns.
+*/
+var MaxPatternLength = 1000
+
+/*
+MaxCachedPatterns is the maximum number of compiled patterns which a like or
+glob condition keeps if its pattern is not a constant (e.g. an attribute value).
+*/
+var MaxCachedPatterns = 100
+
+/*
+Pattern runtime for the like (regex) and glob operators
 */
-type likeRuntime struct {
-	compiledRegex *regexp.Regexp // Quick lookup of the compiled regex if it is a constant
+type patternRuntime struct {
+	compile  func(string) (*regexp.Regexp, error) // Function to compile a pattern
+	errType  error                                // Error type of invalid patterns
+	constant *regexp.Regexp                       // Compiled pattern if it is a constant
+	compiled map[string]*regexp.Regexp            // Cache of compiled patterns which are not constant
 	*whereItemRuntime
 }
 
@@ -819,38 +843,152 @@ type likeRuntime struct {
 likeRuntimeInst returns a new runtime component instance.
 */
 func likeRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtime {
-	return &likeRuntime{nil, &whereItemRuntime{rtp, node}}
+	return &patternRuntime{regexp.Compile, ErrNotARegex, nil, make(map[string]*regexp.Regexp),
+		&whereItemRuntime{rtp, node}}
+}
+
+/*
+globRuntimeInst returns a new runtime component instance.
+*/
+func globRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.Runtime {
+	return &patternRuntime{compileGlob, ErrNotAGlob, nil, make(map[string]*regexp.Regexp),
+		&whereItemRuntime{rtp, node}}
 }
 
 /*
 CondEval evaluates this condition runtime element.
 */
-func (rt *likeRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
+func (rt *patternRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
 
-	// Check for constant regexp
+	res1, err := rt.astNode.Children[0].Runtime.(CondRuntime).CondEval(node, edge)
+	if err != nil {
+		return nil, err
+	}
 
-	if valRT, ok := rt.astNode.Children[1].Runtime.(*valueRuntime); ok {
-		if !valRT.isNodeAttrValue && !valRT.isEdgeAttrValue {
+	re := rt.constant
 
-			// Given regex is a constant and only needs to be compiled once
+	if re == nil {
 
-			val, _ := valRT.CondEval(node, edge)
-			valStr := fmt.Sprint(val)
-			regexp, err := regexp.Compile(valStr)
-			if err != nil {
-				return nil, rt.rtp.newRuntimeError(ErrNotARegex,
-					fmt.Sprintf("%#v - %s", valStr, err.Error()), rt.astNode.Children[1])
-			}
+		res2, err := rt.astNode.Children[1].Runtime.(CondRuntime).CondEval(node, edge)
+		if err != nil {
+			return nil, err
+		}
+
+		if re, err = rt.pattern(fmt.Sprint(res2)); err != nil {
+			return nil, err
+		}
+
+		// A constant pattern only needs to be compiled once
 
-			rt.compiledRegex = regexp
+		if valRT, ok := rt.astNode.Children[1].Runtime.(*valueRuntime); ok &&
+			!valRT.isNodeAttrValue && !valRT.isEdgeAttrValue {
+			rt.constant = re
 		}
 	}
 
-	if rt.compiledRegex == nil {
-		return rt.regexOp(node, edge, func(res1 string, res2 *regexp.Regexp) interface{} { return res2.MatchString(res1) })
+	return re.MatchString(fmt.Sprint(res1)), nil
+}
+
+/*
+pattern returns a compiled pattern. Patterns are checked against the maximum
+pattern length and cached.
+*/
+func (rt *patternRuntime) pattern(pattern string) (*regexp.Regexp, error) {
+
+	if re, ok := rt.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	if MaxPatternLength > 0 && len(pattern) > MaxPatternLength {
+		return nil, rt.rtp.newRuntimeError(rt.errType,
+			fmt.Sprintf("Pattern is longer than %v characters", MaxPatternLength), rt.astNode.Children[1])
+	}
+
+	re, err := rt.compile(pattern)
+	if err != nil {
+		return nil, rt.rtp.newRuntimeError(rt.errType,
+			fmt.Sprintf("%#v - %s", pattern, err.Error()), rt.astNode.Children[1])
+	}
+
+	if len(rt.compiled) >= MaxCachedPatterns {
+		rt.compiled = make(map[string]*regexp.Regexp)
+	}
+
+	rt.compiled[pattern] = re
+
+	return re, nil
+}
+
+/*
+compileGlob compiles a glob pattern which must match a whole value. The
+pattern can contain * (any characters), ? (a single character), character
+classes such as [a-z] or [!0-9] and \ to escape a special character.
+*/
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+
+	runes := []rune(glob)
+
+	buf.WriteString("^(?s:")
+
+	for i := 0; i < len(runes); i++ {
+
+		switch r := runes[i]; r {
+		case '*':
+			buf.WriteString(".*")
+
+		case '?':
+			buf.WriteString(".")
+
+		case '\\':
+			if i++; i == len(runes) {
+				return nil, fmt.Errorf("trailing escape character")
+			}
+			buf.WriteString(regexp.QuoteMeta(string(runes[i])))
+
+		case '[':
+
+			// Find the end of the character class - a ] right after the
+			// opening bracket (or the negation) is part of the class
+
+			j := i + 1
+			if j < len(runes) && (runes[j] == '!' || runes[j] == '^') {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("missing closing ]")
+			}
+
+			buf.WriteString("[")
+
+			for k, c := range runes[i+1 : j] {
+				if k == 0 && (c == '!' || c == '^') {
+					buf.WriteString("^")
+				} else if c == '\\' || c == '[' || c == ']' || c == '^' {
+					buf.WriteString("\\" + string(c))
+				} else {
+					buf.WriteRune(c)
+				}
+			}
+
+			buf.WriteString("]")
+
+			i = j
+
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
 	}
 
-	return rt.stringOp(node, edge, func(res1 string, res2 string) interface{} { return rt.compiledRegex.MatchString(res1) })
+	buf.WriteString(")$")
+
+	return regexp.Compile(buf.String())
 }
 
 /*
@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph"
@@ -122,6 +123,83 @@ Data: 1:n:key, 1:func:objget()
 
 }
 
+func TestRichValueQueries(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	for i, d := range [][]string{
+		{"2020-01-10", "10.10"},
+		{"2020-02-01", "9.99"},
+		{"2021-03-15", "100.5"},
+	} {
+		date, _ := time.Parse("2006-01-02", d[0])
+		price, _ := data.NewDecimal(d[1])
+
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("o", i))
+		node.SetAttr("kind", "order")
+		node.SetAttr("date", date)
+		node.SetAttr("price", price)
+		gm.StoreNode("main", node)
+	}
+
+	// Datetimes are compared as datetimes
+
+	if err := runSearch(`get order where date > "2020-01-15" and date < "2021-03-15T00:00:00Z" show key, price`, `
+Labels: Order Key, Price
+Format: auto, auto
+Data: 1:n:key, 1:n:price
+o1, 9.99
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch(`get order where date = "2021-03-15" show key`, `
+Labels: Order Key
+Format: auto
+Data: 1:n:key
+o2
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Decimals are compared numerically
+
+	if err := runSearch(`get order where price >= 10 show key, price`, `
+Labels: Order Key, Price
+Format: auto, auto
+Data: 1:n:key, 1:n:price
+o0, 10.1
+o2, 100.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Ordering is type-aware
+
+	ast, _ := parser.ParseWithRuntime("test", "get order show key, date with ordering(descending date)", rt)
+	res, err := ast.Runtime.Eval()
+
+	if err != nil || fmt.Sprint(res.(*SearchResult).Data[0][0], res.(*SearchResult).Data[2][0]) != "o2o0" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := runSearch(`get order where price = 9.990 show key`, `
+Labels: Order Key
+Format: auto
+Data: 1:n:key
+o1
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
 func TestWhere(t *testing.T) {
 	gm, _ := simpleGraph()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -528,6 +606,16 @@ Data: 1:n:key, 1:n:name, 1:n:ranking
 		return
 	}
 
+	if err := runSearch("get mynode where ranking not in [1,2.1,3]", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+456, Node1, 3.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
 	if err := runSearch("get mynode where name contains 1", `
 Labels: Mynode Key, Mynode Name, Ranking
 Format: auto, auto, auto
@@ -601,6 +689,17 @@ Data: 1:n:key, 1:n:name, 1:n:ranking
 		return
 	}
 
+	if err := runSearch("get mynode where name like 'de1$'", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+123, Node1, 2.1
+456, Node1, 3.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
 	if err := testSimpleOperationErrors("get mynode where 0 like 2", rt); err != nil {
 		t.Error(err)
 	}
@@ -611,6 +710,70 @@ Data: 1:n:key, 1:n:name, 1:n:ranking
 		return
 	}
 
+	MaxPatternLength = 5
+
+	err := runSearch("get mynode where name like 'Node[0-9]'", "", rt)
+
+	MaxPatternLength = 1000
+
+	if err == nil || err.Error() !=
+		"EQL error in test: Value of operand is not a valid regex (Pattern is longer than 5 characters) (Line:1 Pos:28)" {
+		t.Error(err)
+		return
+	}
+
+	// Test glob patterns
+
+	if err := runSearch("get mynode where name glob 'Node?'", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+000, Node0, 1
+123, Node1, 2.1
+456, Node1, 3.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get mynode where name glob 'N*1' or name glob 'node*'", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+123, Node1, 2.1
+456, Node1, 3.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get mynode where key glob '[!4]*' and key glob '[0-1]?[]0-9]' and not name glob r'Node\\?'", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+000, Node0, 1
+123, Node1, 2.1
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := testSimpleOperationErrors("get mynode where 0 glob 2", rt); err != nil {
+		t.Error(err)
+	}
+
+	if err := runSearch("get mynode where name glob '[1'", "", rt); err.Error() !=
+		"EQL error in test: Value of operand is not a valid glob pattern (\"[1\" - missing closing ]) (Line:1 Pos:28)" {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get mynode where name glob r'a\\'", "", rt); err.Error() !=
+		"EQL error in test: Value of operand is not a valid glob pattern (\"a\\\\\" - trailing escape character) (Line:1 Pos:28)" {
+		t.Error(err)
+		return
+	}
+
 	gm, _ = regexList()
 	rt = NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
 
@@ -90,6 +90,8 @@ const (
 	TokenORDERING
 	TokenWHERE
 	TokenTRAVERSE
+	TokenJOIN
+	TokenON
 	TokenEND
 	TokenPRIMARY
 	TokenSHOW
@@ -98,6 +100,7 @@ const (
 	TokenAND
 	TokenOR
 	TokenLIKE
+	TokenGLOB
 	TokenIN
 	TokenCONTAINS
 	TokenBEGINSWITH
@@ -114,6 +117,10 @@ const (
 	TokenISNOTNULL
 	TokenASCENDING
 	TokenDESCENDING
+	TokenUNION
+	TokenUNIONALL
+	TokenHINTS
+	TokenHINTSEND
 )
 
 /*
@@ -133,11 +140,13 @@ const (
 
 	// Special tokens - always handled in a denotation function
 
-	NodeCOMMA  = "comma"
-	NodeGROUP  = "group"
-	NodeEND    = "end"
-	NodeAS     = "as"
-	NodeFORMAT = "format"
+	NodeCOMMA    = "comma"
+	NodeGROUP    = "group"
+	NodeEND      = "end"
+	NodeON       = "on"
+	NodeAS       = "as"
+	NodeFORMAT   = "format"
+	NodeHINTSEND = "hintsend"
 
 	// Keywords
 
@@ -152,7 +161,10 @@ const (
 	NodeASCENDING   = "asc"
 	NodeDESCENDING  = "desc"
 
+	NodeHINTS = "hints"
+
 	NodeTRAVERSE = "traverse"
+	NodeJOIN     = "join"
 	NodePRIMARY  = "primary"
 	NodeSHOW     = "show"
 	NodeSHOWTERM = "showterm"
@@ -180,6 +192,7 @@ const (
 	// String operations
 
 	NodeLIKE        = "like"
+	NodeGLOB        = "glob"
 	NodeCONTAINS    = "contains"
 	NodeBEGINSWITH  = "beginswith"
 	NodeENDSWITH    = "endswith"
@@ -12,6 +12,7 @@ package parser
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
@@ -81,6 +82,8 @@ var keywordMap = map[string]LexTokenID{
 	"nulltraversal": TokenNULLTRAVERSAL,
 	"where":         TokenWHERE,
 	"traverse":      TokenTRAVERSE,
+	"join":          TokenJOIN,
+	"on":            TokenON,
 	"end":           TokenEND,
 	"primary":       TokenPRIMARY,
 	"show":          TokenSHOW,
@@ -89,6 +92,7 @@ var keywordMap = map[string]LexTokenID{
 	"and":           TokenAND,
 	"or":            TokenOR,
 	"like":          TokenLIKE,
+	"glob":          TokenGLOB,
 	"in":            TokenIN,
 	"contains":      TokenCONTAINS,
 	"beginswith":    TokenBEGINSWITH,
@@ -104,8 +108,21 @@ var keywordMap = map[string]LexTokenID{
 	"isnotnull":     TokenISNOTNULL,
 	"ascending":     TokenASCENDING,
 	"descending":    TokenDESCENDING,
+	"union":         TokenUNION,
 }
 
+/*
+notInRegexp matches the in keyword after the not keyword - "not in" is lexed
+as a single notin token.
+*/
+var notInRegexp = regexp.MustCompile(`(?i)^[ \t]+in\b`)
+
+/*
+unionAllRegexp matches the all keyword after the union keyword - "union all"
+is lexed as a single unionall token.
+*/
+var unionAllRegexp = regexp.MustCompile(`(?i)^[ \t]+all\b`)
+
 /*
 Special symbols which will always be unique - these will separate unquoted strings
 */
@@ -166,6 +183,19 @@ func FirstWord(input string) string {
 	l := &lexer{"", input, 0, 0, 0, 0, 0, -1, nil}
 
 	if skipWhiteSpace(l) {
+
+		// Skip a hint block before the first word
+
+		if strings.HasPrefix(input[l.pos:], "/*+") {
+			if end := strings.Index(input[l.pos:], "*/"); end != -1 {
+				l.pos += end + 2
+
+				if !skipWhiteSpace(l) {
+					return ""
+				}
+			}
+		}
+
 		l.startNew()
 		lexTextBlock(l, false)
 		word = input[l.start:l.pos]
@@ -309,6 +339,10 @@ func lexToken(l *lexer) lexFunc {
 		return skipRestOfLine
 	}
 
+	if strings.HasPrefix(l.input[l.pos:], "/*+") {
+		return lexHints
+	}
+
 	if (n1 == '"' || n1 == '\'') || (n1 == 'r' && (n2 == '"' || n2 == '\'')) {
 		return lexValue
 	}
@@ -332,6 +366,27 @@ func lexToken(l *lexer) lexFunc {
 
 		// Special start token was found
 
+		if token == TokenNOT {
+
+			// Not followed by in is the notin operator
+
+			if loc := notInRegexp.FindStringIndex(l.input[l.pos:]); loc != nil {
+				l.pos += loc[1]
+				l.emitTokenAndValue(TokenNOTIN, "notin")
+				return lexToken
+			}
+
+		} else if token == TokenUNION {
+
+			// Union followed by all keeps duplicate rows
+
+			if loc := unionAllRegexp.FindStringIndex(l.input[l.pos:]); loc != nil {
+				l.pos += loc[1]
+				l.emitTokenAndValue(TokenUNIONALL, "union all")
+				return lexToken
+			}
+		}
+
 		l.emitToken(token)
 
 		switch token {
@@ -371,6 +426,55 @@ func skipRestOfLine(l *lexer) lexFunc {
 	return lexToken
 }
 
+/*
+lexHints lexes a hint block. A hint block starts with /*+ and ends with the
+next star followed by a slash. It contains hint names with optional arguments
+in brackets (e.g. use_index(name) no_cache parallel(4)).
+*/
+func lexHints(l *lexer) lexFunc {
+
+	l.startNew()
+	l.pos += 3
+	l.emitToken(TokenHINTS)
+
+	for {
+		r := l.next(false)
+
+		switch {
+
+		case r == RuneEOF:
+			l.emitError("Unexpected end while reading hints")
+			return nil
+
+		case r == '\n':
+			l.line++
+			l.lastnl = l.pos
+
+		case unicode.IsSpace(r):
+
+		case r == '*' && l.next(true) == '/':
+			l.start = l.pos - 1
+			l.pos++
+			l.emitToken(TokenHINTSEND)
+			return lexToken
+
+		case r == '(' || r == ')' || r == ',':
+			l.start = l.pos - 1
+			l.emitToken(symbolMap[string(r)])
+
+		default:
+			l.start = l.pos - 1
+
+			for r = l.next(true); r != RuneEOF && !unicode.IsSpace(r) &&
+				!strings.ContainsRune("(),*", r); r = l.next(true) {
+				l.next(false)
+			}
+
+			l.emitToken(TokenVALUE)
+		}
+	}
+}
+
 /*
 lexNodeKind lexes a node kind string.
 */
@@ -378,6 +482,12 @@ func lexNodeKind(l *lexer) lexFunc {
 	l.startNew()
 	lexTextBlock(l, false)
 
+	// A closing bracket after the node kind ends a nested query
+
+	if i := strings.IndexRune(l.input[l.start:l.pos], ')'); i != -1 {
+		l.pos = l.start + i
+	}
+
 	nodeKindCandidate := strings.ToLower(l.input[l.start:l.pos])
 	if !stringutil.IsAlphaNumeric(nodeKindCandidate) {
 		l.emitError("Invalid node kind " + fmt.Sprintf("'%v'", nodeKindCandidate) +
@@ -119,6 +119,24 @@ func TestSimpleLexing(t *testing.T) {
 		return
 	}
 
+	// Test not in
+
+	input = `GET mynode WHERE a NOT  in [1] and not b and not inside`
+	if res := LexToList("mytest", input); fmt.Sprint(res) !=
+		`[<GET> "mynode" <WHERE> "a" <NOTIN> [ "1" ] <AND> <NOT> "b" <AND> <NOT> "inside" EOF]` {
+		t.Error("Unexpected lexer result:", res)
+		return
+	}
+
+	// Test union
+
+	input = `with a as (get mynode) get a UNION  all get b union get c where allowed`
+	if res := LexToList("mytest", input); fmt.Sprint(res) !=
+		`[<WITH> "a" <AS> ( <GET> "mynode" ) <GET> "a" <UNION ALL> <GET> "b" <UNION> <GET> "c" <WHERE> "allowed" EOF]` {
+		t.Error("Unexpected lexer result:", res)
+		return
+	}
+
 	// Test comments
 
 	input = `GET mynode  # WHERE testcomment = a * 1.3
@@ -355,3 +373,25 @@ func testBackupPanic(t *testing.T, l *lexer) {
 	l.backup()
 	l.backup()
 }
+
+func TestHintLexing(t *testing.T) {
+
+	input := "/*+ use_index(name) no_cache\n parallel(4, x) */ get mynode"
+	if res := LexToList("mytest", input); fmt.Sprint(res) !=
+		`[</*+> "use_index" ( "name" ) "no_cache" "parallel" ( "4" , "x" ) <*/> <GET> "mynode" EOF]` {
+		t.Error("Unexpected lexer result:", res)
+		return
+	}
+
+	input = "/*+ no_cache get mynode"
+	if res := LexToList("mytest", input); fmt.Sprint(res) !=
+		`[</*+> "no_cache" "get" "mynode" Error: Unexpected end while reading hints (Line 1, Pos 18) EOF]` {
+		t.Error("Unexpected lexer result:", res)
+		return
+	}
+
+	if res := FirstWord(" /*+ no_cache */ Get mynode"); res != "Get" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
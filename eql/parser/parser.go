@@ -100,6 +100,27 @@ func (n *ASTNode) instance(p *parser, t *LexToken) *ASTNode {
 	return ret
 }
 
+/*
+Copy returns a copy of this ASTNode and all its children. The token of each
+copied node is given by a function which is called with the original node. The
+copy is decorated with the runtime components of a given runtime provider
+(which may be nil).
+*/
+func (n *ASTNode) Copy(token func(*ASTNode) *LexToken, rp RuntimeProvider) *ASTNode {
+	ret := &ASTNode{n.Name, token(n), make([]*ASTNode, len(n.Children)), nil, n.binding,
+		n.nullDenotation, n.leftDenotation}
+
+	for i, child := range n.Children {
+		ret.Children[i] = child.Copy(token, rp)
+	}
+
+	if rp != nil {
+		ret.Runtime = rp.Runtime(ret)
+	}
+
+	return ret
+}
+
 /*
 Plain returns this ASTNode and all its children as plain AST. A plain AST
 only contains map objects, lists and primitive types which can be serialized
@@ -146,7 +167,9 @@ func (n *ASTNode) levelString(indent int, buf *bytes.Buffer) {
 
 	buf.WriteString(stringutil.GenerateRollingString(" ", indent*2))
 
-	if n.Name == NodeVALUE || (n.Name == NodeSHOWTERM && n.Token.Val != "@") {
+	// Show terms of functions and expressions have no value
+
+	if n.Name == NodeVALUE || (n.Name == NodeSHOWTERM && n.Token.Val != "@" && n.Token.Val != "") {
 		buf.WriteString(fmt.Sprintf(n.Name+": %v", n.Token))
 	} else {
 		buf.WriteString(n.Name)
@@ -190,13 +213,17 @@ func init() {
 		TokenCOMMA:  {NodeCOMMA, nil, nil, nil, 0, nil, nil},
 		TokenGROUP:  {NodeGROUP, nil, nil, nil, 0, nil, nil},
 		TokenEND:    {NodeEND, nil, nil, nil, 0, nil, nil},
+		TokenON:     {NodeON, nil, nil, nil, 0, nil, nil},
 		TokenAS:     {NodeAS, nil, nil, nil, 0, nil, nil},
 		TokenFORMAT: {NodeFORMAT, nil, nil, nil, 0, nil, nil},
 
+		TokenHINTSEND: {NodeHINTSEND, nil, nil, nil, 0, nil, nil},
+
 		// Keywords
 
 		TokenGET:    {NodeGET, nil, nil, nil, 0, ndGet, nil},
 		TokenLOOKUP: {NodeLOOKUP, nil, nil, nil, 0, ndLookup, nil},
+		TokenHINTS:  {NodeHINTS, nil, nil, nil, 0, ndHints, nil},
 		TokenFROM:   {NodeFROM, nil, nil, nil, 0, ndFrom, nil},
 		TokenWHERE:  {NodeWHERE, nil, nil, nil, 0, ndPrefix, nil},
 
@@ -207,6 +234,7 @@ func init() {
 		TokenDESCENDING:  {NodeDESCENDING, nil, nil, nil, 0, ndPrefix, nil},
 
 		TokenTRAVERSE: {NodeTRAVERSE, nil, nil, nil, 0, ndTraverse, nil},
+		TokenJOIN:     {NodeJOIN, nil, nil, nil, 0, ndJoin, nil},
 		TokenPRIMARY:  {NodePRIMARY, nil, nil, nil, 0, ndPrefix, nil},
 		TokenSHOW:     {NodeSHOW, nil, nil, nil, 0, ndShow, nil},
 		TokenSHOWTERM: {NodeSHOWTERM, nil, nil, nil, 0, ndShow, nil},
@@ -227,6 +255,7 @@ func init() {
 		TokenLT:  {NodeLT, nil, nil, nil, 60, nil, ldInfix},
 
 		TokenLIKE:        {NodeLIKE, nil, nil, nil, 60, nil, ldInfix},
+		TokenGLOB:        {NodeGLOB, nil, nil, nil, 60, nil, ldInfix},
 		TokenIN:          {NodeIN, nil, nil, nil, 60, nil, ldInfix},
 		TokenCONTAINS:    {NodeCONTAINS, nil, nil, nil, 60, nil, ldInfix},
 		TokenBEGINSWITH:  {NodeBEGINSWITH, nil, nil, nil, 60, nil, ldInfix},
@@ -487,6 +516,65 @@ func ndLookup(p *parser, self *ASTNode) (*ASTNode, error) {
 	return self, nil
 }
 
+/*
+ndHints is used to parse a hint block before a query. Each hint is a value
+with optional argument values. The hint block is added as last child of the
+query.
+*/
+func ndHints(p *parser, self *ASTNode) (*ASTNode, error) {
+
+	for p.node.Token.ID != TokenHINTSEND {
+
+		// Must have a hint name
+
+		if err := acceptChild(p, self, TokenVALUE); err != nil {
+			return nil, err
+		}
+
+		hint := self.Children[len(self.Children)-1]
+
+		// Read the optional arguments
+
+		if skipToken(p, TokenLPAREN) == nil {
+
+			if err := acceptChild(p, hint, TokenVALUE); err != nil {
+				return nil, err
+			}
+
+			for skipToken(p, TokenCOMMA) == nil {
+				if err := acceptChild(p, hint, TokenVALUE); err != nil {
+					return nil, err
+				}
+			}
+
+			if err := skipToken(p, TokenRPAREN); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := skipToken(p, TokenHINTSEND); err != nil {
+		return nil, err
+	}
+
+	// Hints must be followed by a query
+
+	query := p.node
+
+	if query.Token.ID != TokenGET && query.Token.ID != TokenLOOKUP {
+		return nil, p.newParserError(ErrUnexpectedToken, query.Token.Val, *query.Token)
+	}
+
+	query, err := p.run(0)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Children = append(query.Children, self)
+
+	return query, nil
+}
+
 /*
 ndFrom is used to parse from group ... expressions.
 */
@@ -515,9 +603,10 @@ func ndTraverse(p *parser, self *ASTNode) (*ASTNode, error) {
 	}
 
 	// Parse the rest and add it as children - must end with "end" if
-	// further clauses are given
+	// further clauses are given (a traversal which is a function parameter
+	// also ends with the closing bracket of the function)
 
-	for p.node.Token.ID != TokenEOF && p.node.Token.ID != TokenEND {
+	for p.node.Token.ID != TokenEOF && p.node.Token.ID != TokenEND && p.node.Token.ID != TokenRPAREN {
 		exp, err := p.run(0)
 		if err != nil {
 			return nil, err
@@ -533,6 +622,33 @@ func ndTraverse(p *parser, self *ASTNode) (*ASTNode, error) {
 	return self, nil
 }
 
+/*
+ndJoin is used to parse joins.
+*/
+func ndJoin(p *parser, self *ASTNode) (*ASTNode, error) {
+
+	// Must be followed by the kind of the joined nodes
+
+	if err := acceptChild(p, self, TokenVALUE); err != nil {
+		return nil, err
+	}
+
+	// The join condition follows the on keyword
+
+	if err := skipToken(p, TokenON); err != nil {
+		return nil, err
+	}
+
+	exp, err := p.run(0)
+	if err != nil {
+		return nil, err
+	}
+
+	self.Children = append(self.Children, exp)
+
+	return self, nil
+}
+
 /*
 ndFunc is used to parse functions.
 */
@@ -550,18 +666,35 @@ func ndFunc(p *parser, self *ASTNode) (*ASTNode, error) {
 		return nil, err
 	}
 
+	// Parameters are values or traversals (subqueries)
+
+	acceptParam := func() error {
+
+		if p.node.Token.ID == TokenTRAVERSE {
+			exp, err := p.run(0)
+			if err == nil {
+				self.Children = append(self.Children, exp)
+			}
+			return err
+		}
+
+		return acceptChild(p, self, TokenVALUE)
+	}
+
 	// Read in the first attribute
 
-	if p.node.Token.ID == TokenVALUE {
+	if p.node.Token.ID == TokenVALUE || p.node.Token.ID == TokenTRAVERSE {
 
-		// Next call cannot fail since we just checked for it. Value is optional.
+		// Next call cannot fail for values since we just checked for it. Value is optional.
 
-		acceptChild(p, self, TokenVALUE)
+		if err := acceptParam(); err != nil {
+			return nil, err
+		}
 
 		// Read all commas and accept further values as parameters until the end
 
 		for skipToken(p, TokenCOMMA) == nil {
-			if err := acceptChild(p, self, TokenVALUE); err != nil {
+			if err := acceptParam(); err != nil {
 				return nil, err
 			}
 		}
@@ -578,9 +711,10 @@ ndShow is used to parse a show clauses.
 func ndShow(p *parser, self *ASTNode) (*ASTNode, error) {
 
 	acceptShowTerm := func() error {
-		st := astNodeMap[TokenSHOWTERM].instance(p, p.node.Token)
+		var st *ASTNode
 
 		if p.node.Token.ID == TokenAT {
+			st = astNodeMap[TokenSHOWTERM].instance(p, p.node.Token)
 
 			// Parse a function
 
@@ -593,9 +727,27 @@ func ndShow(p *parser, self *ASTNode) (*ASTNode, error) {
 
 		} else {
 
-			// Skip the value token from which we just created an AST node
+			// Parse a value or an expression over values
+
+			exp, err := p.run(0)
+			if err != nil {
+				return err
+			}
 
-			skipToken(p, TokenVALUE)
+			if exp.Name == NodeVALUE {
+				st = astNodeMap[TokenSHOWTERM].instance(p, exp.Token)
+
+			} else {
+
+				// Expressions are stored as child of a show term without a value
+
+				token := *exp.Token
+				token.ID = TokenSHOWTERM
+				token.Val = ""
+
+				st = astNodeMap[TokenSHOWTERM].instance(p, &token)
+				st.Children = append(st.Children, exp)
+			}
 		}
 
 		// Parse an "as" definition if given
@@ -629,7 +781,7 @@ func ndShow(p *parser, self *ASTNode) (*ASTNode, error) {
 
 	// Read in the first node attribute
 
-	if p.node.Token.ID == TokenVALUE || p.node.Token.ID == TokenAT {
+	if id := p.node.Token.ID; id == TokenVALUE || id == TokenAT || id == TokenLPAREN {
 		if err := acceptShowTerm(); err != nil {
 			return nil, err
 		}
@@ -24,9 +24,9 @@ import (
 /*
 Map of pretty printer templates for AST nodes
 
-There is special treatment for NodeVALUE, NodeGET, NodeLOOKUP, NodeTRAVERSE,
-NodeFUNC, NodeSHOW, NodeSHOWTERM, NodeORDERING, NodeFILTERING, NodeWITH,
-NodeLPAREN, NodeRPAREN, NodeLBRACK and NodeRBRACK.
+There is special treatment for NodeVALUE, NodeGET, NodeLOOKUP, NodeHINTS,
+NodeTRAVERSE, NodeFUNC, NodeSHOW, NodeSHOWTERM, NodeORDERING, NodeFILTERING,
+NodeWITH, NodeLPAREN, NodeRPAREN, NodeLBRACK and NodeRBRACK.
 */
 var prettyPrinterMap = map[string]*template.Template{
 	NodeTRUE:                 template.Must(template.New(NodeTRUE).Parse("true")),
@@ -52,6 +52,7 @@ var prettyPrinterMap = map[string]*template.Template{
 	NodeASCENDING + "_1":   template.Must(template.New(NodeASCENDING).Parse("ascending {{.c1}}")),
 	NodeDESCENDING + "_1":  template.Must(template.New(NodeDESCENDING).Parse("descending {{.c1}}")),
 
+	NodeJOIN + "_2":    template.Must(template.New(NodeJOIN).Parse("join {{.c1}} on {{.c2}}")),
 	NodePRIMARY + "_1": template.Must(template.New(NodePRIMARY).Parse("primary {{.c1}}")),
 	NodeLIST:           template.Must(template.New(NodeLIST).Parse("list")),
 
@@ -74,6 +75,7 @@ var prettyPrinterMap = map[string]*template.Template{
 	// String operations
 
 	NodeLIKE + "_2":        template.Must(template.New(NodeLIKE).Parse("{{.c1}} like {{.c2}}")),
+	NodeGLOB + "_2":        template.Must(template.New(NodeGLOB).Parse("{{.c1}} glob {{.c2}}")),
 	NodeCONTAINS + "_2":    template.Must(template.New(NodeCONTAINS).Parse("{{.c1}} contains {{.c2}}")),
 	NodeBEGINSWITH + "_2":  template.Must(template.New(NodeBEGINSWITH).Parse("{{.c1}} beginswith {{.c2}}")),
 	NodeENDSWITH + "_2":    template.Must(template.New(NodeENDSWITH).Parse("{{.c1}} endswith {{.c2}}")),
@@ -106,6 +108,10 @@ PrettyPrint produces a pretty printed EQL query from a given AST.
 func PrettyPrint(ast *ASTNode) (string, error) {
 	var visit func(ast *ASTNode, level int) (string, error)
 
+	// Traversals which are function parameters are written in a single line
+
+	inFunc := 0
+
 	quoteValue := func(val string, allowNonQuotation bool) string {
 
 		if val == "" {
@@ -141,6 +147,11 @@ func PrettyPrint(ast *ASTNode) (string, error) {
 
 		// First pretty print children
 
+		if ast.Name == NodeFUNC {
+			inFunc++
+			defer func() { inFunc-- }()
+		}
+
 		if len(ast.Children) > 0 {
 			children = make(map[string]string)
 			for i, child := range ast.Children {
@@ -177,28 +188,38 @@ func PrettyPrint(ast *ASTNode) (string, error) {
 
 		} else if ast.Name == NodeLOOKUP {
 
+			n := len(children)
+
+			// A hint block is the last child but is written first
+
+			if ast.Children[n-1].Name == NodeHINTS {
+				n--
+				buf.WriteString(children[fmt.Sprint("c", n+1)])
+				buf.WriteString("\n")
+			}
+
 			buf.WriteString("lookup ")
 			buf.WriteString(children["c1"])
-			if 1 < len(children) {
+			if 1 < n {
 				buf.WriteString(" ")
 			}
 
 			i := 1
-			for ; i < len(children) && ast.Children[i].Name == NodeVALUE; i++ {
+			for ; i < n && ast.Children[i].Name == NodeVALUE; i++ {
 				buf.WriteString(quoteValue(ast.Children[i].Token.Val, false))
 
-				if i < len(children)-1 && ast.Children[i+1].Name == NodeVALUE {
+				if i < n-1 && ast.Children[i+1].Name == NodeVALUE {
 					buf.WriteString(", ")
 				}
 			}
 
-			if i < len(children) {
+			if i < n {
 				buf.WriteString(" ")
 			}
 
-			for ; i < len(children); i++ {
+			for ; i < n; i++ {
 				buf.WriteString(children[fmt.Sprint("c", i+1)])
-				if i < len(children)-1 && ast.Children[i+1].Name != NodeSHOW {
+				if i < n-1 && ast.Children[i+1].Name != NodeSHOW {
 					buf.WriteString(" ")
 				}
 			}
@@ -207,21 +228,70 @@ func PrettyPrint(ast *ASTNode) (string, error) {
 
 		} else if ast.Name == NodeGET {
 
+			n := len(children)
+
+			// A hint block is the last child but is written first
+
+			if ast.Children[n-1].Name == NodeHINTS {
+				n--
+				buf.WriteString(children[fmt.Sprint("c", n+1)])
+				buf.WriteString("\n")
+			}
+
 			buf.WriteString("get ")
 			buf.WriteString(children["c1"])
-			if 1 < len(children) {
+			if 1 < n {
 				buf.WriteString(" ")
 			}
 
-			for i := 1; i < len(children); i++ {
+			for i := 1; i < n; i++ {
 				buf.WriteString(children[fmt.Sprint("c", i+1)])
-				if i < len(children)-1 && ast.Children[i+1].Name != NodeSHOW {
+				if i < n-1 && ast.Children[i+1].Name != NodeSHOW {
 					buf.WriteString(" ")
 				}
 			}
 
 			return buf.String(), nil
 
+		} else if ast.Name == NodeHINTS {
+
+			buf.WriteString("/*+")
+
+			for _, hint := range ast.Children {
+				buf.WriteString(" ")
+				buf.WriteString(hint.Token.Val)
+
+				if len(hint.Children) > 0 {
+					buf.WriteString("(")
+
+					for i, arg := range hint.Children {
+						buf.WriteString(arg.Token.Val)
+						if i < len(hint.Children)-1 {
+							buf.WriteString(", ")
+						}
+					}
+
+					buf.WriteString(")")
+				}
+			}
+
+			buf.WriteString(" */")
+
+			return buf.String(), nil
+
+		} else if ast.Name == NodeTRAVERSE && inFunc > 0 {
+
+			buf.WriteString("traverse ")
+
+			for i := 0; i < len(children); i++ {
+				buf.WriteString(children[fmt.Sprint("c", i+1)])
+				buf.WriteString(" ")
+			}
+
+			buf.WriteString("end")
+
+			return buf.String(), nil
+
 		} else if ast.Name == NodeTRAVERSE {
 
 			buf.WriteString("\n")
@@ -576,3 +576,168 @@ func testPrettyPrinting(input, astOutput, ppOutput string) error {
 
 	return nil
 }
+
+func TestHintPrinting(t *testing.T) {
+
+	for input, expected := range map[string]string{
+		"/*+ use_index(name) no_cache parallel(4) */\nGeT Song where name = \"x\"": "/*+ use_index(name) no_cache parallel(4) */\nget Song where name = x",
+		"/*+ parallel(2, 3) */ lookup Song \"a\", \"b\"":                           "/*+ parallel(2, 3) */\nlookup Song \"a\", \"b\"",
+	} {
+		astres, err := ParseWithRuntime("mytest", input, &TestRuntimeProvider{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if hints := astres.Children[len(astres.Children)-1]; hints.Name != NodeHINTS {
+			t.Error("Unexpected result:", astres)
+			return
+		}
+
+		ppres, err := PrettyPrint(astres)
+		if err != nil || ppres != expected {
+			t.Error("Unexpected result:", ppres, err)
+			return
+		}
+
+		// Make sure the pretty printed result is valid and gets the same parse tree
+
+		astres2, err := ParseWithRuntime("mytest", ppres, &TestRuntimeProvider{})
+		if err != nil || fmt.Sprint(astres2) != fmt.Sprint(astres) {
+			t.Error("Unexpected result:", astres2, err)
+			return
+		}
+	}
+
+	// Hints must be followed by a query
+
+	if _, err := ParseWithRuntime("mytest", "/*+ no_cache */ where", &TestRuntimeProvider{}); err == nil ||
+		err.Error() != "Parse error in mytest: Unexpected term (where) (Line:1 Pos:17)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := ParseWithRuntime("mytest", "/*+ parallel(2 */ get Song", &TestRuntimeProvider{}); err == nil ||
+		err.Error() != "Parse error in mytest: Unexpected term (*/) (Line:1 Pos:16)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestShowExpressionPrinting(t *testing.T) {
+
+	for input, expected := range map[string]string{
+		"get Song show name, price * (quantity + 1) AS total":        "get Song \nshow\n  name,\n  price * (quantity + 1) as total",
+		"get Song show (ranking - 4) / 2 format text, @concat(a, b)": "get Song \nshow\n  (ranking - 4) / 2 format text,\n  @concat(a, b)",
+	} {
+		astres, err := ParseWithRuntime("mytest", input, &TestRuntimeProvider{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if st := astres.Children[1].Children[len(astres.Children[1].Children)-1]; st.Name != NodeSHOWTERM ||
+			st.Token.ID != TokenSHOWTERM && st.Token.ID != TokenAT {
+			t.Error("Unexpected result:", astres)
+			return
+		}
+
+		ppres, err := PrettyPrint(astres)
+		if err != nil || ppres != expected {
+			t.Error("Unexpected result:", ppres, err)
+			return
+		}
+
+		// Make sure the pretty printed result is valid and gets the same parse tree
+
+		astres2, err := ParseWithRuntime("mytest", ppres, &TestRuntimeProvider{})
+		if err != nil || fmt.Sprint(astres2) != fmt.Sprint(astres) {
+			t.Error("Unexpected result:", astres2, err)
+			return
+		}
+
+		// Make sure the plain representation gets the same parse tree
+
+		astres3, err := ASTFromPlain(astres.Plain())
+		if err != nil || fmt.Sprint(astres3) != fmt.Sprint(astres) {
+			t.Error("Unexpected result:", astres3, err)
+			return
+		}
+	}
+}
+
+func TestJoinPrinting(t *testing.T) {
+
+	input := "get Order join Customer on Order.customer_id = Customer.key show key, Customer:name"
+
+	astres, err := ParseWithRuntime("mytest", input, &TestRuntimeProvider{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if join := astres.Children[1]; join.Name != NodeJOIN || len(join.Children) != 2 ||
+		join.Children[0].Token.Val != "Customer" || join.Children[1].Name != NodeEQ {
+		t.Error("Unexpected result:", astres)
+		return
+	}
+
+	ppres, err := PrettyPrint(astres)
+	if err != nil || ppres != "get Order join Customer on Order.customer_id = Customer.key\nshow\n  key,\n  Customer:name" {
+		t.Error("Unexpected result:", ppres, err)
+		return
+	}
+
+	// Make sure the pretty printed result is valid and gets the same parse tree
+
+	astres2, err := ParseWithRuntime("mytest", ppres, &TestRuntimeProvider{})
+	if err != nil || fmt.Sprint(astres2) != fmt.Sprint(astres) {
+		t.Error("Unexpected result:", ppres, astres2, err)
+		return
+	}
+
+	if _, err := ParseWithRuntime("mytest", "get Order join Customer show key", &TestRuntimeProvider{}); err == nil ||
+		err.Error() != "Parse error in mytest: Unexpected term (show) (Line:1 Pos:25)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestSubqueryPrinting(t *testing.T) {
+
+	input := "get Person where @count(traverse :::Friend where age > 30 traverse :::City where name = 'Berlin') > 5"
+
+	astres, err := ParseWithRuntime("mytest", input, &TestRuntimeProvider{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if trav := astres.Children[1].Children[0].Children[0].Children[1]; trav.Name != NodeTRAVERSE ||
+		len(trav.Children) != 3 || trav.Children[1].Name != NodeWHERE || trav.Children[2].Name != NodeTRAVERSE {
+		t.Error("Unexpected result:", astres)
+		return
+	}
+
+	ppres, err := PrettyPrint(astres)
+	if err != nil || ppres != "get Person where @count(traverse :::Friend where age > 30 "+
+		"traverse :::City where name = Berlin end end) > 5" {
+		t.Error("Unexpected result:", ppres, err)
+		return
+	}
+
+	// Make sure the pretty printed result is valid and gets the same parse tree
+
+	astres2, err := ParseWithRuntime("mytest", ppres, &TestRuntimeProvider{})
+	if err != nil || fmt.Sprint(astres2) != fmt.Sprint(astres) {
+		t.Error("Unexpected result:", ppres, astres2, err)
+		return
+	}
+
+	if _, err := ParseWithRuntime("mytest", "get Person where @count(traverse :::Friend where age > 30 end end)",
+		&TestRuntimeProvider{}); err == nil ||
+		err.Error() != "Parse error in mytest: Unexpected term (end) (Line:1 Pos:63)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
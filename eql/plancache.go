@@ -0,0 +1,298 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"bytes"
+	"container/list"
+	"strconv"
+	"sync"
+
+	"github.com/krotik/eliasdb/eql/parser"
+)
+
+// Query plan cache
+// ================
+
+/*
+DefaultPlanCacheMaxSize is the default maximum number of query plans which are
+kept in the plan cache.
+*/
+const DefaultPlanCacheMaxSize = 1000
+
+/*
+PlanStats are the statistics of the query plan cache. The counters are
+collected since the cache size was last set (or since startup).
+*/
+type PlanStats struct {
+	Hits    uint64 `json:"hits"`     // Number of queries which used a cached plan
+	Misses  uint64 `json:"misses"`   // Number of queries which had to be parsed
+	Size    int    `json:"size"`     // Number of cached plans
+	MaxSize int    `json:"max_size"` // Maximum number of cached plans (0 if the cache is disabled)
+}
+
+/*
+plans is the cache of query plans of all queries.
+*/
+var plans = newPlanCache(DefaultPlanCacheMaxSize)
+
+/*
+SetPlanCacheMaxSize sets the maximum number of query plans which are cached
+(0 disables the cache). All cached plans and statistics are discarded.
+*/
+func SetPlanCacheMaxSize(maxSize int) {
+	plans.reset(maxSize)
+}
+
+/*
+PlanCacheStats returns the statistics of the query plan cache.
+*/
+func PlanCacheStats() *PlanStats {
+	return plans.stats()
+}
+
+/*
+plan is a cached query plan. A plan is a parsed query whose tokens refer to
+the tokens of the query text by their index.
+*/
+type plan struct {
+	ast  *parser.ASTNode              // Parsed query
+	refs map[*parser.ASTNode]tokenRef // Tokens of all AST nodes
+}
+
+/*
+tokenRef refers to the token of an AST node in the token list of a query.
+*/
+type tokenRef struct {
+	index   int  // Index of the token in the token list (-1 if the token is not in the list)
+	derived bool // Flag if the parser derived the token of the node from the referenced token
+}
+
+/*
+planEntry is an entry of the plan cache.
+*/
+type planEntry struct {
+	key  string // Key of the plan
+	plan *plan  // Cached plan
+}
+
+/*
+planCache is a cache of query plans which discards the least recently used
+plan once it is full. Plans are keyed by the normalized text of a query - the
+sequence of its token types. The parsed structure of a query only depends on
+the types of its tokens so queries which only differ in values, white space or
+the case of keywords share a plan.
+*/
+type planCache struct {
+	maxSize int                      // Maximum number of plans
+	entries map[string]*list.Element // Map of plan keys to entries
+	lru     *list.List               // List of entries ordered by their last use
+	hits    uint64                   // Number of cache hits
+	misses  uint64                   // Number of cache misses
+	mutex   *sync.Mutex              // Mutex to protect cache operations
+}
+
+/*
+newPlanCache creates a new plan cache.
+*/
+func newPlanCache(maxSize int) *planCache {
+	return &planCache{maxSize, make(map[string]*list.Element), list.New(), 0, 0, &sync.Mutex{}}
+}
+
+/*
+reset discards all plans and statistics and sets a new maximum size.
+*/
+func (pc *planCache) reset(maxSize int) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.maxSize = maxSize
+	pc.entries = make(map[string]*list.Element)
+	pc.lru = list.New()
+	pc.hits = 0
+	pc.misses = 0
+}
+
+/*
+stats returns the current statistics of the cache.
+*/
+func (pc *planCache) stats() *PlanStats {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	return &PlanStats{pc.hits, pc.misses, pc.lru.Len(), pc.maxSize}
+}
+
+/*
+get returns the plan of a given key or nil if it is not cached.
+*/
+func (pc *planCache) get(key string) *plan {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if e, ok := pc.entries[key]; ok {
+		pc.lru.MoveToFront(e)
+		pc.hits++
+		return e.Value.(*planEntry).plan
+	}
+
+	pc.misses++
+
+	return nil
+}
+
+/*
+put stores a plan under a given key.
+*/
+func (pc *planCache) put(key string, p *plan) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pc.maxSize <= 0 {
+		return
+	} else if e, ok := pc.entries[key]; ok {
+		e.Value.(*planEntry).plan = p
+		pc.lru.MoveToFront(e)
+		return
+	}
+
+	for pc.lru.Len() >= pc.maxSize {
+		oldest := pc.lru.Back()
+		delete(pc.entries, oldest.Value.(*planEntry).key)
+		pc.lru.Remove(oldest)
+	}
+
+	pc.entries[key] = pc.lru.PushFront(&planEntry{key, p})
+}
+
+/*
+enabled checks if plans are cached.
+*/
+func (pc *planCache) enabled() bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	return pc.maxSize > 0
+}
+
+/*
+parseQuery parses a query and decorates the AST with the runtime components of
+a given runtime provider. The plan of the query is taken from the plan cache if
+a query with the same normalized text was parsed before. Queries which cannot
+be parsed are not cached.
+*/
+func parseQuery(name string, query string, rtp parser.RuntimeProvider) (*parser.ASTNode, error) {
+
+	if !plans.enabled() {
+		return parser.ParseWithRuntime(name, query, rtp)
+	}
+
+	tokens := parser.LexToList(name, query)
+
+	key, ok := planKey(tokens)
+	if !ok {
+		return parser.ParseWithRuntime(name, query, rtp)
+	}
+
+	if p := plans.get(key); p != nil {
+		return p.instance(tokens, rtp), nil
+	}
+
+	ast, err := parser.Parse(name, query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPlan(ast, tokens)
+
+	plans.put(key, p)
+
+	return p.instance(tokens, rtp), nil
+}
+
+/*
+planKey returns the normalized text of a query from its token list. Returns
+false if the query contains a lexical error.
+*/
+func planKey(tokens []parser.LexToken) (string, bool) {
+	var buf bytes.Buffer
+
+	for _, t := range tokens {
+		if t.ID == parser.TokenError {
+			return "", false
+		}
+
+		buf.WriteString(strconv.Itoa(int(t.ID)))
+		buf.WriteByte(' ')
+	}
+
+	return buf.String(), true
+}
+
+/*
+newPlan creates a plan from a parsed query and its token list. The tokens of
+the AST nodes are looked up in the token list by their position.
+*/
+func newPlan(ast *parser.ASTNode, tokens []parser.LexToken) *plan {
+	p := &plan{ast, make(map[*parser.ASTNode]tokenRef)}
+
+	tokenIndex := make(map[int]int, len(tokens))
+	for i := len(tokens) - 1; i >= 0; i-- {
+		tokenIndex[tokens[i].Pos] = i
+	}
+
+	var visit func(node *parser.ASTNode)
+
+	visit = func(node *parser.ASTNode) {
+		ref := tokenRef{-1, false}
+
+		if i, ok := tokenIndex[node.Token.Pos]; ok {
+			t := tokens[i]
+			ref = tokenRef{i, t.ID != node.Token.ID || t.Val != node.Token.Val}
+		}
+
+		p.refs[node] = ref
+
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+
+	visit(ast)
+
+	return p
+}
+
+/*
+instance creates an AST from this plan and the token list of a query. The AST
+is decorated with the runtime components of a given runtime provider.
+*/
+func (p *plan) instance(tokens []parser.LexToken, rtp parser.RuntimeProvider) *parser.ASTNode {
+	return p.ast.Copy(func(node *parser.ASTNode) *parser.LexToken {
+		ref := p.refs[node]
+		token := *node.Token
+
+		if ref.index != -1 {
+			token = tokens[ref.index]
+
+			if ref.derived {
+
+				// Derived tokens keep their type and value but get the
+				// position of the referenced token
+
+				token.ID = node.Token.ID
+				token.Val = node.Token.Val
+			}
+		}
+
+		return &token
+	}, rtp)
+}
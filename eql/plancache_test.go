@@ -0,0 +1,137 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlanCache(t *testing.T) {
+	gm, _ := songGraph()
+
+	SetPlanCacheMaxSize(2)
+	defer SetPlanCacheMaxSize(DefaultPlanCacheMaxSize)
+
+	res, err := RunQuery("test", "main", "get Song where (ranking > 5 or ranking < 2) and key != 'Aria1' "+
+		"show key, ranking with ordering(ascending key)", gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Queries which only differ in values, white space or keyword case share a plan
+
+	res2, err := RunQuery("test", "main", "GET  Song WHERE (ranking > 10 or ranking < 3) and key != 'Aria4' "+
+		"show key,ranking with ordering(ascending key)", gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats := fmt.Sprint(*PlanCacheStats()); stats != "{1 1 1 2}" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if res := fmt.Sprint(res); res != `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+Aria4, 18
+DeadSong2, 6
+LoveSong3, 1
+MyOnlySong3, 19
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(res2); res != `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+Aria2, 2
+LoveSong3, 1
+MyOnlySong3, 19
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The query of a result is printed from the cached plan
+
+	if q := res2.Query(); q != `
+get Song where (ranking > 10 or ranking < 3) and key != Aria4
+show
+  key,
+  ranking 
+with
+  ordering(ascending key)`[1:] {
+		t.Error("Unexpected result:", q)
+		return
+	}
+
+	// Errors refer to the positions of the query text
+
+	if _, err := RunQuery("test", "main", "get    Lyrics  where (ranking > 5 or ranking < 2) and key != 'x' "+
+		"show key, ranking with ordering(ascending key)", gm); err == nil || err.Error() !=
+		"EQL error in test: Unknown node kind (Lyrics) (Line:1 Pos:8)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The least recently used plan is discarded once the cache is full
+
+	for _, query := range []string{"get Song", "lookup Song 'Aria1'",
+		"get Song where (ranking > 5 or ranking < 2) and key != 'Aria1' show key, ranking with ordering(ascending key)"} {
+		if _, err := RunQuery("test", "main", query, gm); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if stats := fmt.Sprint(*PlanCacheStats()); stats != "{2 4 2 2}" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// Queries which cannot be parsed are not cached
+
+	if _, err := RunQuery("test", "main", "get Song where", gm); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := RunQuery("test", "main", "get Song where 'a", gm); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if stats := fmt.Sprint(*PlanCacheStats()); stats != "{2 5 2 2}" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// The cache can be disabled
+
+	SetPlanCacheMaxSize(0)
+
+	if _, err := RunQuery("test", "main", "get Song", gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats := fmt.Sprint(*PlanCacheStats()); stats != "{0 0 0 0}" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+}
@@ -18,11 +18,13 @@ GET Person where name = "Marvin"
 package eql
 
 import (
+	"context"
 	"strings"
 
 	"github.com/krotik/eliasdb/eql/interpreter"
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/tracing"
 )
 
 /*
@@ -30,6 +32,11 @@ GroupNodeKind is a special node kind representing groups
 */
 const GroupNodeKind = interpreter.GroupNodeKind
 
+/*
+HintNoCache is the query hint which requests that a result is not cached
+*/
+const HintNoCache = interpreter.HintNoCache
+
 /*
 RunQuery runs a search query against a given graph database.
 */
@@ -37,19 +44,122 @@ func RunQuery(name string, part string, query string, gm *graph.Manager) (Search
 	return RunQueryWithNodeInfo(name, part, query, gm, interpreter.NewDefaultNodeInfo(gm))
 }
 
+/*
+RunQueryContext runs a search query against a given graph database. The parsing
+and evaluation stages of the query are traced as children of the current span
+of the given context.
+*/
+func RunQueryContext(ctx context.Context, name string, part string, query string, gm *graph.Manager) (SearchResult, error) {
+	return runTracedQuery(ctx, name, part, query, gm, interpreter.NewDefaultNodeInfo(gm), nil)
+}
+
+/*
+QueryOptions are options for the evaluation of a query.
+*/
+type QueryOptions struct {
+	TraversalMaxVisitedNodes int  // Maximum number of nodes which the traversals may visit (0 for no limit)
+	TraversalCycleDetection  bool // Flag if nested traversals should stop at nodes of the current path
+	RowOffset                int  // Number of result rows which are skipped without evaluating them
+	RowLimit                 int  // Maximum number of result rows which are evaluated (0 for no limit)
+	CountRows                bool // Flag if all result rows are counted if only a row window is evaluated
+	Workers                  int  // Number of workers which evaluate the rows of a query in parallel
+	RemoteCallBudget         int  // Maximum number of remote calls to other shards (0 for no limit)
+}
+
+/*
+RunQueryWithOptions runs a search query against a given graph database using
+given evaluation options. The parsing and evaluation stages of the query are
+traced as children of the current span of the given context. A row window of
+the options is only applied if the result is not ordered, filtered, aggregated
+or composed - Windowed() of the result reports if it was applied.
+*/
+func RunQueryWithOptions(ctx context.Context, name string, part string, query string, gm *graph.Manager,
+	opts *QueryOptions) (SearchResult, error) {
+	return runTracedQuery(ctx, name, part, query, gm, interpreter.NewDefaultNodeInfo(gm), opts)
+}
+
 /*
 RunQueryWithNodeInfo runs a search query against a given graph database. Using
 a given NodeInfo object to retrieve rendering information.
 */
 func RunQueryWithNodeInfo(name string, part string, query string, gm *graph.Manager, ni interpreter.NodeInfo) (SearchResult, error) {
+	return runTracedQuery(context.Background(), name, part, query, gm, ni, nil)
+}
+
+/*
+runTracedQuery runs a search query against a given graph database.
+*/
+func runTracedQuery(ctx context.Context, name string, part string, query string, gm *graph.Manager,
+	ni interpreter.NodeInfo, opts *QueryOptions) (SearchResult, error) {
+	var sres *interpreter.SearchResult
+	var err error
+
+	ctx, span := tracing.StartSpan(ctx, "eql.query")
+	defer span.Finish()
+
+	span.SetAttr("eql.query", query)
+	span.SetAttr("eql.partition", part)
+
+	// Count the remote calls of all traversals of the query
+
+	budget := 0
+	if opts != nil {
+		budget = opts.RemoteCallBudget
+	}
+
+	ctx = graph.WithRemoteCallBudget(ctx, budget)
+
+	if isComposedQuery(query) {
+		sres, err = runComposedQuery(ctx, name, part, query, gm, ni, opts)
+	} else {
+		sres, err = runSingleQuery(ctx, name, part, query, gm, ni, opts, nil)
+	}
+
+	span.SetAttr("eql.remote_calls", graph.RemoteCalls(ctx))
+
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	span.SetAttr("eql.rows", sres.RowCount())
+
+	return &queryResult{sres}, nil
+}
+
+/*
+runSingleQuery parses and evaluates a single get or lookup query. Get queries
+can use the given named results instead of node kinds.
+*/
+func runSingleQuery(ctx context.Context, name string, part string, query string, gm *graph.Manager,
+	ni interpreter.NodeInfo, opts *QueryOptions, named map[string]*interpreter.SearchResult) (*interpreter.SearchResult, error) {
 	var rtp parser.RuntimeProvider
 
 	word := strings.ToLower(parser.FirstWord(query))
 
 	if word == "get" {
-		rtp = interpreter.NewGetRuntimeProvider(name, part, gm, ni)
+		grtp := interpreter.NewGetRuntimeProvider(name, part, gm, ni)
+		grtp.SetContext(ctx)
+		if opts != nil {
+			grtp.SetTraversalGuards(opts.TraversalMaxVisitedNodes, opts.TraversalCycleDetection)
+			grtp.SetRowWindow(opts.RowOffset, opts.RowLimit)
+			grtp.SetCountRows(opts.CountRows)
+			grtp.SetWorkers(opts.Workers)
+		}
+		for resName, res := range named {
+			grtp.SetNamedResult(resName, res)
+		}
+		rtp = grtp
 	} else if word == "lookup" {
-		rtp = interpreter.NewLookupRuntimeProvider(name, part, gm, ni)
+		lrtp := interpreter.NewLookupRuntimeProvider(name, part, gm, ni)
+		lrtp.SetContext(ctx)
+		if opts != nil {
+			lrtp.SetTraversalGuards(opts.TraversalMaxVisitedNodes, opts.TraversalCycleDetection)
+			lrtp.SetRowWindow(opts.RowOffset, opts.RowLimit)
+			lrtp.SetCountRows(opts.CountRows)
+			lrtp.SetWorkers(opts.Workers)
+		}
+		rtp = lrtp
 	} else {
 		return nil, &interpreter.RuntimeError{
 			Source: name,
@@ -61,17 +171,35 @@ func RunQueryWithNodeInfo(name string, part string, query string, gm *graph.Mana
 		}
 	}
 
-	ast, err := parser.ParseWithRuntime(name, query, rtp)
+	_, parseSpan := tracing.StartSpan(ctx, "eql.parse")
+
+	ast, err := parseQuery(name, query, rtp)
+
+	parseSpan.SetError(err)
+	parseSpan.Finish()
+
 	if err != nil {
 		return nil, err
 	}
 
+	_, evalSpan := tracing.StartSpan(ctx, "eql.eval")
+
+	// Storage reads of the evaluation are children of the evaluation span
+
+	deactivate := tracing.Activate(evalSpan)
+
 	res, err := ast.Runtime.Eval()
+
+	deactivate()
+
+	evalSpan.SetError(err)
+	evalSpan.Finish()
+
 	if err != nil {
 		return nil, err
 	}
 
-	return &queryResult{res.(*interpreter.SearchResult)}, nil
+	return res.(*interpreter.SearchResult), nil
 }
 
 /*
@@ -86,6 +214,23 @@ func ParseQuery(name string, query string) (*parser.ASTNode, error) {
 	return ast, nil
 }
 
+/*
+RegisterFunction registers a user-defined function which can be called in
+where and show clauses with @<name>(...). The function is called with the
+checked and converted values of all given parameters. Functions should be
+registered before any queries are run.
+*/
+func RegisterFunction(def *interpreter.FunctionDef) error {
+	return interpreter.RegisterFunction(def)
+}
+
+/*
+Functions returns the definitions of all user-defined functions.
+*/
+func Functions() []*interpreter.FunctionDef {
+	return interpreter.Functions()
+}
+
 /*
 queryResult datastructure to hide implementation details.
 */
@@ -11,12 +11,16 @@
 package eql
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/krotik/eliasdb/eql/interpreter"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/tracing"
 )
 
 func TestBugFixes(t *testing.T) {
@@ -103,6 +107,168 @@ Data: 1:n:key, 1:n:name
 	}
 }
 
+func TestQueryTracing(t *testing.T) {
+	gm, _ := songGraph()
+
+	me := tracing.NewMemoryExporter()
+	tracing.SetExporter(me)
+	defer tracing.SetExporter(nil)
+
+	ctx, span := tracing.StartSpan(context.Background(), "request")
+
+	res, err := RunQueryContext(ctx, "test", "main", "get Author", gm)
+	if err != nil || res.RowCount() != 3 {
+		t.Error("Unexpected result: ", res, err)
+		return
+	}
+
+	span.Finish()
+
+	spans := me.Spans()
+
+	if len(spans) != 4 || spans[0].Name != "eql.parse" || spans[1].Name != "eql.eval" ||
+		spans[2].Name != "eql.query" || spans[3].Name != "request" {
+		t.Error("Unexpected result: ", spans)
+		return
+	}
+
+	if spans[2].ParentID != span.SpanID || spans[0].ParentID != spans[2].SpanID ||
+		spans[1].ParentID != spans[2].SpanID || spans[2].Attrs["eql.rows"] != 3 {
+		t.Error("Unexpected result: ", spans)
+		return
+	}
+
+	me.Reset()
+
+	_, err = RunQueryContext(context.Background(), "test", "main", "get Author where", gm)
+
+	spans = me.Spans()
+
+	if err == nil || len(spans) != 2 || spans[0].Error != err.Error() || spans[1].Error != err.Error() {
+		t.Error("Unexpected result: ", spans, err)
+		return
+	}
+}
+
+func TestQueryCancel(t *testing.T) {
+	gm, _ := songGraph()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := RunQueryContext(ctx, "test", "main", "get Author", gm)
+	if err == nil || err.Error() != "EQL error in test: Query was canceled (context canceled) (Line:1 Pos:1)" {
+		t.Error("Unexpected result: ", err)
+		return
+	}
+
+	_, err = RunQueryContext(ctx, "test", "main", "lookup Author '000'", gm)
+	if err == nil || err.Error() != "EQL error in test: Query was canceled (context canceled) (Line:1 Pos:1)" {
+		t.Error("Unexpected result: ", err)
+		return
+	}
+}
+
+/*
+testRemoteFetcher marks all nodes with a key starting with "r" as remote nodes
+of a single location.
+*/
+type testRemoteFetcher struct {
+}
+
+func (rf *testRemoteFetcher) IsRemote(part string, key string, kind string) bool {
+	return strings.HasPrefix(key, "r")
+}
+
+func (rf *testRemoteFetcher) Location(part string, key string, kind string) string {
+	return "remote"
+}
+
+func (rf *testRemoteFetcher) FetchNodes(ctx context.Context, part string, location string, kind string,
+	keys []string) ([]data.Node, error) {
+
+	var nodes []data.Node
+
+	for _, key := range keys {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		node.SetAttr("name", "Remote "+key)
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func TestQueryRemoteNodes(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+	gm.SetRemoteNodes(&testRemoteFetcher{})
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "a")
+	node.SetAttr(data.NodeKind, "Author")
+	node.SetAttr("name", "John")
+	gm.StoreNode("main", node)
+
+	for i := 1; i <= 3; i++ {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, fmt.Sprint("e", i))
+		edge.SetAttr(data.NodeKind, "Wrote")
+		edge.SetAttr(data.EdgeEnd1Key, "a")
+		edge.SetAttr(data.EdgeEnd1Kind, "Author")
+		edge.SetAttr(data.EdgeEnd1Role, "Author")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, fmt.Sprint("r", i))
+		edge.SetAttr(data.EdgeEnd2Kind, "Song")
+		edge.SetAttr(data.EdgeEnd2Role, "Song")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		gm.StoreEdge("main", edge)
+	}
+
+	query := "get Author traverse ::: end show 2:n:key, 2:n:name with ordering(ascending 2:n:key)"
+
+	// Traversals fetch the attributes of nodes on other shards
+
+	res, err := RunQueryWithOptions(context.Background(), "test", "main", query, gm,
+		&QueryOptions{RemoteCallBudget: 1})
+
+	if err != nil || res.String() != `
+Labels: Key, Name
+Format: auto, auto
+Data: 2:n:key, 2:n:name
+r1, Remote r1
+r2, Remote r2
+r3, Remote r3
+`[1:] {
+		t.Error("Unexpected result: ", res, err)
+		return
+	}
+
+	// The remote calls of a query are limited by its budget
+
+	oldBatchSize := graph.RemoteFetchBatchSize
+	graph.RemoteFetchBatchSize = 1
+	defer func() {
+		graph.RemoteFetchBatchSize = oldBatchSize
+	}()
+
+	_, err = RunQueryWithOptions(context.Background(), "test", "main", query, gm,
+		&QueryOptions{RemoteCallBudget: 2})
+
+	if err == nil || err.Error() != "EQL error in test: Remote call budget exceeded "+
+		"(Traversal needs more than 2 remote calls) (Line:1 Pos:12)" {
+		t.Error("Unexpected result: ", err)
+		return
+	}
+
+	if res, err := RunQuery("test", "main", query, gm); err != nil || res.RowCount() != 3 {
+		t.Error("Unexpected result: ", res, err)
+		return
+	}
+}
+
 func TestQueryPlainGraph(t *testing.T) {
 
 	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
@@ -142,6 +308,46 @@ Data: 1:n:key, 1:n:name
 	}
 }
 
+func TestRegisterFunction(t *testing.T) {
+	gm, _ := songGraph()
+
+	if err := RegisterFunction(&interpreter.FunctionDef{Name: "lower", Func: func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	}}); err == nil || err.Error() != "Function lower is already defined" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RegisterFunction(&interpreter.FunctionDef{
+		Name:        "initial",
+		Description: "First character of a string",
+		Params:      []interpreter.FunctionParam{{Name: "string", Type: interpreter.ParamString}},
+		Func: func(args []interface{}) (interface{}, error) {
+			return args[0].(string)[:1], nil
+		},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fs := Functions(); len(fs) != 1 || fs[0].Name != "initial" {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	res, err := RunQuery("test", "main", "get Author where @initial(name) = 'J' show name", gm)
+
+	if err != nil || res.String() != `
+Labels: Author Name
+Format: auto
+Data: 1:n:name
+John
+`[1:] {
+		t.Error("Unexpected result: ", err, res)
+		return
+	}
+}
+
 func TestParseQuery(t *testing.T) {
 	res, _ := ParseQuery("test", "get Author with ordering(ascending key)")
 	if res.String() != `
@@ -58,11 +58,29 @@ type SearchResult interface {
 	*/
 	Query() string
 
+	/*
+	   Hints returns the query hints of the query which produced this result.
+	*/
+	Hints() map[string][]string
+
+	/*
+	   Windowed returns if the result only holds the rows of the row window
+	   which was given in the query options.
+	*/
+	Windowed() bool
+
 	/*
 	   RowCount returns the number of rows of the result.
 	*/
 	RowCount() int
 
+	/*
+	   TotalRowCount returns the number of rows of the full result. Returns -1
+	   if the result only holds the rows of a row window and the other rows
+	   were not counted (see CountRows of the query options).
+	*/
+	TotalRowCount() int
+
 	/*
 	   Row returns a row of the result.
 	*/
@@ -3,7 +3,14 @@ module github.com/krotik/eliasdb
 go 1.12
 
 require (
+	github.com/IBM/sarama v1.43.3
+	github.com/apache/arrow/go/v14 v14.0.2
+	github.com/dgraph-io/badger/v2 v2.2007.4
 	github.com/gorilla/websocket v1.4.1
+	github.com/hashicorp/raft v1.7.3
+	github.com/hashicorp/raft-boltdb/v2 v2.3.0
 	github.com/krotik/common v1.4.4
 	github.com/krotik/ecal v1.6.3
+	github.com/nats-io/nats-server/v2 v2.10.5
+	github.com/nats-io/nats.go v1.31.0
 )
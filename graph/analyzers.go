@@ -0,0 +1,336 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+SetAnalyzer sets the text analyzer which is used by the full-text index for an
+attribute of a node or edge kind. A nil analyzer removes an existing analyzer.
+Changes only apply to data which is written afterwards - use ReindexNodes or
+ReindexEdges to apply them to existing data.
+*/
+func (gm *Manager) SetAnalyzer(kind string, attr string, analyzer *util.Analyzer) error {
+
+	if kind == "" || attr == "" {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Analyzer needs a kind and an attribute"}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	analyzers := make(map[string]string)
+
+	for k, v := range gm.getMainDBMap(MainDBAnalyzers + kind) {
+		analyzers[k] = v
+	}
+
+	if analyzer == nil {
+		delete(analyzers, attr)
+
+	} else {
+
+		if err := analyzer.Validate(); err != nil {
+			return err
+		}
+
+		res, err := json.Marshal(analyzer)
+		if err != nil {
+			return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+		}
+
+		analyzers[attr] = string(res)
+	}
+
+	if len(analyzers) == 0 {
+		gm.removeMainDBValue(MainDBAnalyzers + kind)
+	} else {
+		gm.storeMainDBMap(MainDBAnalyzers+kind, analyzers)
+	}
+
+	return gm.flushMain()
+}
+
+/*
+Analyzers returns all text analyzers as a map of kind to a map of attribute to
+analyzer.
+*/
+func (gm *Manager) Analyzers() map[string]map[string]*util.Analyzer {
+	ret := make(map[string]map[string]*util.Analyzer)
+
+	for _, key := range gm.mainDBKeys(MainDBAnalyzers) {
+		kind := key[len(MainDBAnalyzers):]
+		ret[kind] = gm.kindAnalyzers(kind)
+	}
+
+	return ret
+}
+
+/*
+kindAnalyzers returns the text analyzers of all attributes of a kind.
+*/
+func (gm *Manager) kindAnalyzers(kind string) map[string]*util.Analyzer {
+	var ret map[string]*util.Analyzer
+
+	for attr, val := range gm.getMainDBMap(MainDBAnalyzers + kind) {
+		var analyzer util.Analyzer
+
+		if err := json.Unmarshal([]byte(val), &analyzer); err == nil {
+			if ret == nil {
+				ret = make(map[string]*util.Analyzer)
+			}
+			ret[attr] = &analyzer
+		}
+	}
+
+	return ret
+}
+
+/*
+newIndexManager creates a new index manager for a given index HTree which uses
+the text analyzers and the indexing settings of a kind.
+*/
+func (gm *Manager) newIndexManager(iht *hash.HTree, kind string) *util.IndexManager {
+	im := util.NewIndexManager(iht)
+	im.SetAnalyzers(gm.kindAnalyzers(kind))
+	im.SetUnindexed(gm.kindUnindexed(kind))
+	return im
+}
+
+/*
+ReindexNodes rebuilds the full-text and lookup index of all nodes of a kind in
+a partition from the stored node data. This applies changed text analyzers to
+existing data and repairs corrupted indexes. An optional progress function is
+called after each indexed node.
+*/
+func (gm *Manager) ReindexNodes(part string, kind string, progress IndexProgress) error {
+
+	attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || attht == nil || valht == nil {
+		return err
+	}
+
+	iht, err := gm.getNodeIndexHTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	err = gm.reindex(iht, attht, gm.nodeIndexMap(kind, attht, valht), kind, progress)
+
+	if err != nil {
+		gm.rollbackNodeIndex(part, kind)
+		return err
+	}
+
+	return gm.flushNodeIndex(part, kind)
+}
+
+/*
+ReindexEdges rebuilds the full-text and lookup index of all edges of a kind in
+a partition from the stored edge data. This applies changed text analyzers to
+existing data and repairs corrupted indexes. An optional progress function is
+called after each indexed edge.
+*/
+func (gm *Manager) ReindexEdges(part string, kind string, progress IndexProgress) error {
+
+	edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+	if err != nil || edgeht == nil {
+		return err
+	}
+
+	iht, err := gm.getEdgeIndexHTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	err = gm.reindex(iht, edgeht, gm.edgeIndexMap(kind, edgeht), kind, progress)
+
+	if err != nil {
+		gm.rollbackEdgeIndex(part, kind)
+		return err
+	}
+
+	return gm.flushEdgeIndex(part, kind)
+}
+
+/*
+IndexUpgradePending returns true if the indexes were created by an older
+version and need to be rebuilt with UpgradeIndexes (e.g. indexes without word
+dictionaries do not support prefix and fuzzy word lookups).
+*/
+func (gm *Manager) IndexUpgradePending() bool {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	_, ok := gm.mainDBValue(MainDBIndexUpgrade)
+
+	return ok
+}
+
+/*
+UpgradeIndexes rebuilds the indexes of all node and edge kinds and the
+timelines of all edge kinds in all partitions if they were created by an
+older version. An optional progress
+function is called after each indexed node or edge.
+*/
+func (gm *Manager) UpgradeIndexes(progress IndexProgress) error {
+
+	if !gm.IndexUpgradePending() {
+		return nil
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+			if err := gm.ReindexNodes(part, kind, progress); err != nil {
+				return err
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+			if err := gm.ReindexEdges(part, kind, progress); err != nil {
+				return err
+			}
+
+			// Add timestamped edges which were stored before timelines
+			// were supported
+
+			if err := gm.RebuildTimelines(part, kind, progress); err != nil {
+				return err
+			}
+		}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.removeMainDBValue(MainDBIndexUpgrade)
+
+	return gm.flushMain()
+}
+
+/*
+nodeIndexMap returns a function which reads the index map of a stored node.
+*/
+func (gm *Manager) nodeIndexMap(kind string, attht *hash.HTree,
+	valht *hash.HTree) func(key string) (map[string]string, error) {
+
+	return func(key string) (map[string]string, error) {
+		node, err := gm.readNode(key, kind, nil, attht, valht)
+		if err != nil || node == nil {
+			return nil, err
+		}
+		return node.IndexMap(), nil
+	}
+}
+
+/*
+edgeIndexMap returns a function which reads the index map of a stored edge.
+*/
+func (gm *Manager) edgeIndexMap(kind string, edgeht *hash.HTree) func(key string) (map[string]string, error) {
+
+	return func(key string) (map[string]string, error) {
+		node, err := gm.readNode(key, kind, nil, edgeht, edgeht)
+		if err != nil || node == nil {
+			return nil, err
+		}
+		return data.NewGraphEdgeFromNode(node).IndexMap(), nil
+	}
+}
+
+/*
+reindex removes all entries from an index HTree and indexes all items of a
+given storage HTree again.
+*/
+func (gm *Manager) reindex(iht *hash.HTree, storageht *hash.HTree,
+	indexMap func(key string) (map[string]string, error), kind string, progress IndexProgress) error {
+
+	var keys [][]byte
+
+	// Remove all existing index entries
+
+	it := hash.NewHTreeIterator(iht)
+	for it.HasNext() {
+		if k, _ := it.Next(); k != nil {
+			keys = append(keys, k)
+		}
+	}
+
+	if it.LastError != nil {
+		return &util.GraphError{Type: util.ErrIndexError, Detail: it.LastError.Error()}
+	}
+
+	for _, k := range keys {
+		if _, err := iht.Remove(k); err != nil {
+			return &util.GraphError{Type: util.ErrIndexError, Detail: err.Error()}
+		}
+	}
+
+	// Index all stored items
+
+	_, err := gm.indexItems(gm.newIndexManager(iht, kind), storageht, indexMap, progress)
+
+	return err
+}
+
+/*
+indexItems indexes all items of a given storage HTree. Returns the number of
+indexed items.
+*/
+func (gm *Manager) indexItems(im *util.IndexManager, storageht *hash.HTree,
+	indexMap func(key string) (map[string]string, error), progress IndexProgress) (uint64, error) {
+
+	var keys []string
+
+	// Collect all item keys first so the progress can be reported
+
+	it := hash.NewHTreeIterator(storageht)
+	for it.HasNext() {
+		if k, _ := it.Next(); strings.HasPrefix(string(k), PrefixNSAttrs) {
+			keys = append(keys, string(k[len(PrefixNSAttrs):]))
+		}
+	}
+
+	if it.LastError != nil {
+		return 0, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	for i, key := range keys {
+
+		obj, err := indexMap(key)
+		if err != nil {
+			return 0, err
+		} else if obj != nil {
+			if err := im.Index(key, obj); err != nil {
+				return 0, err
+			}
+		}
+
+		if progress != nil {
+			progress(uint64(i+1), uint64(len(keys)))
+		}
+	}
+
+	return uint64(len(keys)), nil
+}
@@ -0,0 +1,250 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+func TestAnalyzers(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := newGraphManagerNoRules(mgs)
+
+	if err := gm.SetAnalyzer("", "name", nil); err == nil || err.Error() != "GraphError: Invalid data (Analyzer needs a kind and an attribute)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetAnalyzer("mynode", "name", &util.Analyzer{Language: "foo"}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown language: foo (supported languages are english, french, german, spanish))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	for i, text := range []string{"Running foxes", "The fox runs"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "mynode")
+		node.SetAttr("text", text)
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKey, "e1")
+	edge.SetAttr(data.NodeKind, "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "0")
+	edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd1Role, "node1")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "1")
+	edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd2Role, "node2")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+	edge.SetAttr("text", "Jumping Foxes")
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Default index does not know about stems
+
+	iq, _ := gm.NodeIndexQuery("main", "mynode")
+
+	if res, _ := iq.LookupWord("text", "fox"); fmt.Sprint(res) != "map[1:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Set analyzers and reindex the existing data
+
+	analyzer := &util.Analyzer{Lowercase: true, Language: "english", Stemming: true, LanguageStopWords: true}
+
+	if err := gm.SetAnalyzer("mynode", "text", analyzer); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.SetAnalyzer("myedge", "text", analyzer); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(gm.Analyzers()["mynode"]["text"]); res != fmt.Sprint(analyzer) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.ReindexNodes("main", "mynode", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.ReindexEdges("main", "myedge", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	iq, _ = gm.NodeIndexQuery("main", "mynode")
+
+	if res, _ := iq.LookupWord("text", "fox"); fmt.Sprint(res) != "map[0:[2] 1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := iq.LookupWord("text", "the"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	iq, _ = gm.EdgeIndexQuery("main", "myedge")
+
+	if res, _ := iq.LookupWord("text", "JUMP"); fmt.Sprint(res) != "map[e1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// New data uses the analyzer straight away
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "2")
+	node.SetAttr(data.NodeKind, "mynode")
+	node.SetAttr("text", "Foxes everywhere")
+	gm.StoreNode("main", node)
+
+	iq, _ = gm.NodeIndexQuery("main", "mynode")
+
+	if res, _ := iq.LookupWord("text", "foxes"); fmt.Sprint(res) != "map[0:[2] 1:[1] 2:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removing analyzers
+
+	if err := gm.SetAnalyzer("myedge", "text", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(len(gm.Analyzers()), gm.Analyzers()["myedge"]); res != "1 map[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.ReindexEdges("main", "myedge", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	iq, _ = gm.EdgeIndexQuery("main", "myedge")
+
+	if res, _ := iq.LookupWord("text", "Foxes"); fmt.Sprint(res) != "map[e1:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Reindexing unknown kinds is a NOP
+
+	if err := gm.ReindexNodes("main", "foo", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.ReindexEdges("main", "foo", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Analyzers are persisted in the main database
+
+	gm = newGraphManagerNoRules(mgs)
+
+	if res := fmt.Sprint(gm.Analyzers()["mynode"]["text"]); res != fmt.Sprint(analyzer) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestUpgradeIndexes(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for i, text := range []string{"Running foxes", "The fox runs"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "mynode")
+		node.SetAttr("text", text)
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Simulate an index of an older version without word dictionaries
+
+	iht, _ := gm.getNodeIndexHTree("main", "mynode", false)
+
+	var dictKeys [][]byte
+
+	it := hash.NewHTreeIterator(iht)
+	for it.HasNext() {
+		if k, _ := it.Next(); strings.HasPrefix(string(k), util.PrefixAttrDict) {
+			dictKeys = append(dictKeys, k)
+		}
+	}
+
+	for _, k := range dictKeys {
+		iht.Remove(k)
+	}
+
+	mgs.MainDB()[MainDBVersion] = "1"
+
+	gm = NewGraphManager(mgs)
+
+	if !gm.IndexUpgradePending() {
+		t.Error("Index upgrade should be pending")
+		return
+	}
+
+	iq, _ := gm.NodeIndexQuery("main", "mynode")
+
+	if res, err := iq.LookupPrefix("text", "fox"); len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := gm.UpgradeIndexes(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if gm.IndexUpgradePending() {
+		t.Error("Index upgrade should not be pending")
+		return
+	}
+
+	iq, _ = gm.NodeIndexQuery("main", "mynode")
+
+	if res, err := iq.LookupPrefix("text", "fox"); len(res) != 2 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sort"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+CacheMinObjects is the minimum number of objects which a storage cache keeps
+when caches are rebalanced. This makes sure that rarely used partitions remain
+usable.
+*/
+var CacheMinObjects = 1000
+
+/*
+PartitionCacheStats are the cache statistics of a partition. The access
+counters are collected since the last rebalance (or since startup).
+*/
+type PartitionCacheStats struct {
+	Partition   string `json:"partition"`   // Name of the partition
+	Hits        uint64 `json:"hits"`        // Number of requests which were answered from the cache
+	Misses      uint64 `json:"misses"`      // Number of requests which had to be answered from disk
+	Size        int    `json:"size"`        // Number of objects in the caches of the partition
+	MaxObjects  int    `json:"max_objects"` // Current cache allocation of the partition
+	Recommended int    `json:"recommended"` // Recommended cache allocation of the partition
+}
+
+/*
+partitionCache is a single storage cache of a partition.
+*/
+type partitionCache struct {
+	part        string               // Partition of the cache
+	cm          storage.CacheManager // Storage manager which holds the cache
+	stats       storage.CacheStats   // Current statistics of the cache
+	recommended int                  // Recommended size of the cache
+}
+
+/*
+CacheStats returns the cache statistics of all partitions. The recommended
+allocation distributes the current total cache size between all storages
+according to their access frequency.
+*/
+func (gm *Manager) CacheStats() []*PartitionCacheStats {
+	return gm.partitionCacheStats(gm.partitionCaches())
+}
+
+/*
+RebalanceCaches resizes all storage caches according to the recommended
+allocation and resets the access counters. Returns the statistics on which
+the new allocation is based. Caches are not changed if there were no
+accesses since the last rebalance.
+*/
+func (gm *Manager) RebalanceCaches() []*PartitionCacheStats {
+	caches := gm.partitionCaches()
+
+	for _, c := range caches {
+		if c.recommended != c.stats.MaxObjects {
+			c.cm.SetMaxObjects(c.recommended)
+		}
+		c.cm.ResetCacheStats()
+	}
+
+	return gm.partitionCacheStats(caches)
+}
+
+/*
+partitionCacheStats aggregates the statistics of storage caches by partition.
+*/
+func (gm *Manager) partitionCacheStats(caches []*partitionCache) []*PartitionCacheStats {
+	var ret []*PartitionCacheStats
+
+	stats := make(map[string]*PartitionCacheStats)
+
+	for _, c := range caches {
+		ps, ok := stats[c.part]
+		if !ok {
+			ps = &PartitionCacheStats{Partition: c.part}
+			stats[c.part] = ps
+			ret = append(ret, ps)
+		}
+
+		ps.Hits += c.stats.Hits
+		ps.Misses += c.stats.Misses
+		ps.Size += c.stats.Size
+		ps.MaxObjects += c.stats.MaxObjects
+		ps.Recommended += c.recommended
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Partition < ret[j].Partition
+	})
+
+	return ret
+}
+
+/*
+partitionCaches collects all storage caches of all partitions and calculates
+their recommended size.
+*/
+func (gm *Manager) partitionCaches() []*partitionCache {
+	var caches []*partitionCache
+	var budget int
+	var accesses uint64
+
+	gm.mutex.RLock()
+	gm.storageMutex.Lock()
+
+	for _, part := range gm.Partitions() {
+		var names []string
+
+		for _, kind := range gm.NodeKinds() {
+			names = append(names, part+kind+StorageSuffixNodes, part+kind+StorageSuffixNodesIndex)
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+			names = append(names, part+kind+StorageSuffixEdges, part+kind+StorageSuffixEdgesIndex)
+		}
+
+		names = append(names, part+StorageSuffixBlobs)
+
+		for _, name := range names {
+			if cm, ok := gm.gs.StorageManager(name, false).(storage.CacheManager); ok {
+				c := &partitionCache{part, cm, cm.CacheStats(), 0}
+
+				budget += c.stats.MaxObjects
+				accesses += c.stats.Hits + c.stats.Misses
+
+				caches = append(caches, c)
+			}
+		}
+	}
+
+	gm.storageMutex.Unlock()
+	gm.mutex.RUnlock()
+
+	if accesses == 0 {
+
+		// Keep the current allocation if there is no data to base a
+		// recommendation on
+
+		for _, c := range caches {
+			c.recommended = c.stats.MaxObjects
+		}
+
+		return caches
+	}
+
+	// Every cache gets a minimum size - the rest of the budget is
+	// distributed according to the access frequency
+
+	min := CacheMinObjects
+	if min*len(caches) > budget {
+		min = budget / len(caches)
+	}
+
+	rest := float64(budget - min*len(caches))
+
+	for _, c := range caches {
+		c.recommended = min + int(rest*float64(c.stats.Hits+c.stats.Misses)/float64(accesses))
+	}
+
+	return caches
+}
@@ -0,0 +1,120 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestCacheStats(t *testing.T) {
+
+	// Memory storages have no caches
+
+	if res := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage")).CacheStats(); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir8, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	oldMin := CacheMinObjects
+	CacheMinObjects = 10
+	defer func() {
+		CacheMinObjects = oldMin
+	}()
+
+	gm := NewGraphManager(dgs)
+
+	// Store a large cold partition and a small hot partition
+
+	for i := 0; i < 100; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "Item")
+
+		if err := gm.StoreNode("big", node); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if i < 5 {
+			if err := gm.StoreNode("hot", node); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	// Writing the large partition gives it most of the cache
+
+	if res := gm.RebalanceCaches(); res[0].Recommended < 390000 {
+		t.Error("Unexpected result:", res[0], res[1])
+		return
+	}
+
+	for i := 0; i < 1000; i++ {
+		if _, err := gm.FetchNode("hot", fmt.Sprint(i%5), "Item"); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	gm.FetchNode("big", "1", "Item")
+
+	stats := gm.CacheStats()
+
+	if len(stats) != 2 || stats[0].Partition != "big" || stats[1].Partition != "hot" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	big, hot := stats[0], stats[1]
+
+	if hot.Hits+hot.Misses < 1000 || big.Hits+big.Misses > 10 {
+		t.Error("Unexpected result:", hot, big)
+		return
+	}
+
+	// Node storage and node index of each partition share the total budget
+	// (minus rounding)
+
+	if big.MaxObjects+hot.MaxObjects < 399990 || big.Recommended+hot.Recommended > 400000 ||
+		hot.Recommended < 390000 || big.Recommended < 20 {
+		t.Error("Unexpected result:", hot, big)
+		return
+	}
+
+	// Apply the recommendation
+
+	res := gm.RebalanceCaches()
+
+	if fmt.Sprint(res[0], res[1]) != fmt.Sprint(big, hot) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	stats = gm.CacheStats()
+
+	if stats[0].MaxObjects != big.Recommended || stats[1].MaxObjects != hot.Recommended ||
+		stats[1].Hits != 0 || stats[1].Misses != 0 || stats[1].Recommended != hot.Recommended {
+		t.Error("Unexpected result:", stats[0], stats[1])
+		return
+	}
+}
@@ -0,0 +1,485 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package codegen generates client packages from the JSON Schema documents of
+node kinds (see graph.KindSchema).
+
+GenerateGoClient generates a Go package with a typed model for each node kind
+and a REST client which stores, fetches, lists and deletes nodes of these kinds.
+The generated code only depends on the Go standard library. Model fields carry
+json and eliasdb struct tags so the models can also be used with an embedded
+EliasDB (see data.Marshal and data.Unmarshal).
+*/
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+/*
+GoModelsFile is the name of the generated file which contains the models.
+*/
+const GoModelsFile = "models.go"
+
+/*
+GoClientFile is the name of the generated file which contains the REST client.
+*/
+const GoClientFile = "client.go"
+
+/*
+reservedGoNames are type names which are used by the generated code.
+*/
+var reservedGoNames = map[string]bool{
+	"Client":     true,
+	"NewClient":  true,
+	"GeoPoint":   true,
+	"BlobRef":    true,
+	"Error":      true,
+	"APIVersion": true,
+}
+
+/*
+goModel is a generated model of a node kind.
+*/
+type goModel struct {
+	Kind   string     // Node kind
+	Name   string     // Type name
+	Fields []*goField // Model fields
+}
+
+/*
+goField is a field of a generated model.
+*/
+type goField struct {
+	Name string // Field name
+	Type string // Field type
+	Attr string // Attribute name
+	Tags string // Struct tags
+}
+
+/*
+GenerateGoClient generates a Go client package from a list of JSON Schema
+documents. Returns a map of file names to file contents.
+*/
+func GenerateGoClient(pkg string, schemas []map[string]interface{}) (map[string][]byte, error) {
+	var models []*goModel
+
+	names := make(map[string]bool)
+
+	for _, schema := range schemas {
+		kind, ok := schema["title"].(string)
+		if !ok || kind == "" {
+			return nil, fmt.Errorf("Schema document has no title")
+		}
+
+		name := uniqueName(goName(kind), names)
+
+		if reservedGoNames[name] {
+			name = uniqueName(name+"Node", names)
+		}
+
+		names[name] = true
+
+		models = append(models, newGoModel(kind, name, schema))
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+
+	ret := make(map[string][]byte)
+
+	for file, tmpl := range map[string]*template.Template{
+		GoModelsFile: goModelsTemplate,
+		GoClientFile: goClientTemplate,
+	} {
+		var buf bytes.Buffer
+
+		if err := tmpl.Execute(&buf, map[string]interface{}{
+			"Package":  pkg,
+			"Models":   models,
+			"UsesTime": usesTime(models),
+		}); err != nil {
+			return nil, err
+		}
+
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("Could not format generated file %v: %v", file, err)
+		}
+
+		ret[file] = src
+	}
+
+	return ret, nil
+}
+
+/*
+newGoModel creates a model from a JSON Schema document.
+*/
+func newGoModel(kind string, name string, schema map[string]interface{}) *goModel {
+	var attrs []string
+
+	model := &goModel{kind, name, nil}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	required := make(map[string]bool)
+
+	if req, ok := schema["required"].([]string); ok {
+		for _, r := range req {
+			required[r] = true
+		}
+	} else if req, ok := schema["required"].([]interface{}); ok {
+		for _, r := range req {
+			required[fmt.Sprint(r)] = true
+		}
+	}
+
+	for attr := range properties {
+		attrs = append(attrs, attr)
+	}
+
+	// Each node has a key and a kind
+
+	for _, attr := range []string{"kind", "key"} {
+		if _, ok := properties[attr]; !ok {
+			attrs = append(attrs, attr)
+		}
+		required[attr] = true
+	}
+
+	// The key and kind attributes are always first
+
+	sort.Slice(attrs, func(i, j int) bool {
+		oi, oj := attrOrder(attrs[i]), attrOrder(attrs[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return attrs[i] < attrs[j]
+	})
+
+	fieldNames := make(map[string]bool)
+
+	for _, attr := range attrs {
+		prop, _ := properties[attr].(map[string]interface{})
+
+		ftype := goType(prop, required[attr])
+		omit := ""
+
+		if attrOrder(attr) < 2 {
+			ftype = "string"
+		}
+
+		if !required[attr] {
+			omit = ",omitempty"
+		}
+
+		fname := uniqueName(goName(attr), fieldNames)
+		fieldNames[fname] = true
+
+		model.Fields = append(model.Fields, &goField{fname, ftype, attr,
+			fmt.Sprintf("`json:\"%v%v\" eliasdb:\"%v%v\"`", attr, omit, attr, omit)})
+	}
+
+	return model
+}
+
+/*
+attrOrder returns the sort order of an attribute.
+*/
+func attrOrder(attr string) int {
+	switch attr {
+	case "key":
+		return 0
+	case "kind":
+		return 1
+	}
+	return 2
+}
+
+/*
+goType returns the Go type of an attribute schema. Optional struct values
+are pointers.
+*/
+func goType(prop map[string]interface{}, required bool) string {
+	var ret string
+
+	t, _ := prop["type"].(string)
+
+	switch t {
+	case "string":
+		if prop["format"] == "date-time" {
+			ret = "time.Time"
+		} else if prop["contentEncoding"] == "base64" {
+			ret = "[]byte"
+		} else {
+			ret = "string"
+		}
+	case "integer":
+		ret = "int64"
+	case "number":
+		ret = "float64"
+	case "boolean":
+		ret = "bool"
+	case "array":
+		ret = "[]interface{}"
+	case "object":
+		props, _ := prop["properties"].(map[string]interface{})
+
+		if _, ok := props["lat"]; ok && len(props) == 2 {
+			return "*GeoPoint"
+		} else if _, ok := props["content_type"]; ok && len(props) == 2 {
+			return "*BlobRef"
+		}
+
+		ret = "map[string]interface{}"
+	default:
+		if _, ok := prop["const"].(string); ok {
+			return "string"
+		}
+		return "interface{}"
+	}
+
+	if !required && ret == "time.Time" {
+		ret = "*" + ret
+	}
+
+	return ret
+}
+
+/*
+goName converts a kind or attribute name into an exported Go identifier.
+*/
+func goName(s string) string {
+	var buf strings.Builder
+
+	upper := true
+
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+
+		if buf.Len() == 0 && unicode.IsDigit(r) {
+			buf.WriteRune('N')
+		}
+
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+
+		buf.WriteRune(r)
+	}
+
+	if buf.Len() == 0 {
+		return "X"
+	}
+
+	return buf.String()
+}
+
+/*
+uniqueName makes sure a name has not been used yet by appending a number.
+*/
+func uniqueName(name string, used map[string]bool) string {
+	ret := name
+
+	for i := 2; used[ret]; i++ {
+		ret = fmt.Sprint(name, i)
+	}
+
+	return ret
+}
+
+/*
+usesTime checks if any model has a time field.
+*/
+func usesTime(models []*goModel) bool {
+	for _, m := range models {
+		for _, f := range m.Fields {
+			if strings.HasSuffix(f.Type, "time.Time") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+goModelsTemplate is the template of the generated models file.
+*/
+var goModelsTemplate = template.Must(template.New(GoModelsFile).Parse(`// Code generated by eliasdb. DO NOT EDIT.
+
+package {{.Package}}
+{{if .UsesTime}}
+import "time"
+{{end}}
+/*
+GeoPoint is a location.
+*/
+type GeoPoint struct {
+	Lat float64 ` + "`json:\"lat\" eliasdb:\"lat\"`" + `
+	Lon float64 ` + "`json:\"lon\" eliasdb:\"lon\"`" + `
+}
+
+/*
+BlobRef is a reference to a blob.
+*/
+type BlobRef struct {
+	Size        int64  ` + "`json:\"size\"`" + `
+	ContentType string ` + "`json:\"content_type\"`" + `
+}
+{{range .Models}}
+/*
+{{.Name}} is a node of kind {{printf "%q" .Kind}}.
+*/
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} {{.Tags}}
+{{- end}}
+}
+{{end}}`))
+
+/*
+goClientTemplate is the template of the generated client file.
+*/
+var goClientTemplate = template.Must(template.New(GoClientFile).Parse(`// Code generated by eliasdb. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+/*
+APIVersion is the path prefix of the EliasDB REST API.
+*/
+const APIVersion = "/db/v1"
+
+/*
+Client is a REST client for an EliasDB server.
+*/
+type Client struct {
+	URL        string       // Base URL of the server (e.g. https://localhost:9090)
+	HTTPClient *http.Client // HTTP client which is used for requests
+	Header     http.Header  // Additional headers for each request (e.g. authentication)
+}
+
+/*
+NewClient creates a new client for a given server URL.
+*/
+func NewClient(serverURL string) *Client {
+	return &Client{serverURL, http.DefaultClient, make(http.Header)}
+}
+
+/*
+do sends a request to the server and decodes the response into a given object.
+*/
+func (c *Client) do(method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	var err error
+
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.URL+APIVersion+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return nil
+}
+{{range .Models}}
+/*
+Get{{.Name}} fetches a node of kind {{printf "%q" .Kind}}.
+*/
+func (c *Client) Get{{.Name}}(part string, key string) (*{{.Name}}, error) {
+	var ret {{.Name}}
+
+	err := c.do("GET", "/graph/"+url.PathEscape(part)+"/n/"+url.PathEscape({{printf "%q" .Kind}})+"/"+url.PathEscape(key), nil, &ret)
+
+	return &ret, err
+}
+
+/*
+List{{.Name}} lists nodes of kind {{printf "%q" .Kind}}.
+*/
+func (c *Client) List{{.Name}}(part string, offset int, limit int) ([]*{{.Name}}, error) {
+	var ret []*{{.Name}}
+
+	err := c.do("GET", fmt.Sprintf("/graph/%v/n/%v?offset=%v&limit=%v",
+		url.PathEscape(part), url.PathEscape({{printf "%q" .Kind}}), offset, limit), nil, &ret)
+
+	return ret, err
+}
+
+/*
+Store{{.Name}} stores nodes of kind {{printf "%q" .Kind}}. Existing nodes are overwritten.
+*/
+func (c *Client) Store{{.Name}}(part string, nodes ...*{{.Name}}) error {
+	for _, n := range nodes {
+		n.Kind = {{printf "%q" .Kind}}
+	}
+
+	return c.do("POST", "/graph/"+url.PathEscape(part)+"/n", nodes, nil)
+}
+
+/*
+Delete{{.Name}} deletes nodes of kind {{printf "%q" .Kind}}.
+*/
+func (c *Client) Delete{{.Name}}(part string, keys ...string) error {
+	var nodes []map[string]string
+
+	for _, key := range keys {
+		nodes = append(nodes, map[string]string{"key": key, "kind": {{printf "%q" .Kind}}})
+	}
+
+	return c.do("DELETE", "/graph/"+url.PathEscape(part)+"/n", nodes, nil)
+}
+{{end}}`))
@@ -0,0 +1,141 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package codegen
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestGenerateGoClient(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	storeNode := func(kind string, key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("Author", "a1", map[string]interface{}{"name": "John", "age": 42,
+		"born": time.Now(), "loc": &data.GeoPoint{Lat: 1, Lon: 2}})
+	storeNode("Author", "a2", map[string]interface{}{"name": "Mike", "age": 3,
+		"first-song": "Aria", "data": []byte("a")})
+	storeNode("Client", "c1", map[string]interface{}{"active": true, "score": 1.5})
+
+	var schemas []map[string]interface{}
+
+	for _, kind := range gm.NodeKinds() {
+		s, _ := graph.KindSchema(kind, 0, gm)
+		schemas = append(schemas, s)
+	}
+
+	// Add a schema which went through JSON (e.g. from the schema endpoint)
+
+	var s map[string]interface{}
+	json.Unmarshal([]byte(`{"title":"song-info","properties":{"ranking":{"type":"integer"}},"required":["ranking"]}`), &s)
+	schemas = append(schemas, s)
+
+	files, err := GenerateGoClient("myclient", schemas)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := string(files[GoModelsFile]); !strings.Contains(res, `
+/*
+Author is a node of kind "Author".
+*/
+type Author struct {
+	Key       string     `+"`"+`json:"key" eliasdb:"key"`+"`"+`
+	Kind      string     `+"`"+`json:"kind" eliasdb:"kind"`+"`"+`
+	Age       int64      `+"`"+`json:"age" eliasdb:"age"`+"`"+`
+	Born      *time.Time `+"`"+`json:"born,omitempty" eliasdb:"born,omitempty"`+"`"+`
+	Data      []byte     `+"`"+`json:"data,omitempty" eliasdb:"data,omitempty"`+"`"+`
+	FirstSong string     `+"`"+`json:"first-song,omitempty" eliasdb:"first-song,omitempty"`+"`"+`
+	Loc       *GeoPoint  `+"`"+`json:"loc,omitempty" eliasdb:"loc,omitempty"`+"`"+`
+	Name      string     `+"`"+`json:"name" eliasdb:"name"`+"`"+`
+}`) || !strings.Contains(res, "type ClientNode struct {") || !strings.Contains(res, `
+type SongInfo struct {
+	Key     string `+"`"+`json:"key" eliasdb:"key"`+"`"+`
+	Kind    string `+"`"+`json:"kind" eliasdb:"kind"`+"`"+`
+	Ranking int64  `+"`"+`json:"ranking" eliasdb:"ranking"`+"`"+`
+}`) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := string(files[GoClientFile]); !strings.Contains(res,
+		"func (c *Client) StoreSongInfo(part string, nodes ...*SongInfo) error {") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Check that the generated package compiles
+
+	fset := token.NewFileSet()
+
+	var astFiles []*ast.File
+
+	for name, src := range files {
+		f, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		astFiles = append(astFiles, f)
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+
+	if _, err := conf.Check("myclient", fset, astFiles, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := GenerateGoClient("myclient", []map[string]interface{}{{}}); err == nil ||
+		err.Error() != "Schema document has no title" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := GenerateGoClient("my client", nil); err == nil ||
+		!strings.HasPrefix(err.Error(), "Could not format generated file") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := goName("1st.value"); res != "N1stValue" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := goName("-"); res != "X" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,557 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+ConsistencyReportMaxExamples is the maximum number of problems which are
+described in a consistency or salvage report.
+*/
+var ConsistencyReportMaxExamples = 20
+
+/*
+ConsistencyReport is the result of a consistency check of a graph.
+*/
+type ConsistencyReport struct {
+	Storage  []*storage.CheckReport `json:"storage"`  // Reports of the storage file checks
+	Trees    uint64                 `json:"trees"`    // Number of checked HTrees
+	Nodes    uint64                 `json:"nodes"`    // Number of readable nodes
+	Edges    uint64                 `json:"edges"`    // Number of readable edges
+	Problems uint64                 `json:"problems"` // Number of found problems in the graph structure
+	Examples []string               `json:"examples"` // Descriptions of found problems
+}
+
+/*
+Consistent returns true if neither the storage files nor the graph structure
+have problems.
+*/
+func (r *ConsistencyReport) Consistent() bool {
+	for _, sr := range r.Storage {
+		if !sr.Consistent() {
+			return false
+		}
+	}
+	return r.Problems == 0
+}
+
+/*
+addProblem adds a problem to the report.
+*/
+func (r *ConsistencyReport) addProblem(format string, args ...interface{}) {
+	r.Problems++
+
+	if len(r.Examples) < ConsistencyReportMaxExamples {
+		r.Examples = append(r.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+/*
+consistencyItem identifies a node or an edge in a consistency check.
+*/
+type consistencyItem struct {
+	part string // Partition of the item
+	kind string // Kind of the item
+	key  string // Key of the item
+}
+
+/*
+String returns a string representation of the item.
+*/
+func (ci consistencyItem) String() string {
+	return fmt.Sprintf("%v (%v) in partition %v", ci.key, ci.kind, ci.part)
+}
+
+/*
+CheckConsistency verifies the storage files (if the graph storage supports it)
+and the graph structure. The graph check walks all HTrees of all node and edge
+kinds and makes sure that every node and edge can be read, that the edge
+references of each node point to existing edges and nodes, that both ends of
+each edge exist and refer back to the edge and that the stored node and edge
+counts are correct. Nothing is changed - use Salvage to copy all readable data
+into a new graph. The graph is locked during the check and all
+node and edge keys are held in memory. An optional progress function is
+called after each checked node or edge.
+*/
+func (gm *Manager) CheckConsistency(progress IndexProgress) (*ConsistencyReport, error) {
+	var err error
+
+	report := &ConsistencyReport{}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if c, ok := gm.gs.(graphstorage.Checker); ok {
+		if report.Storage, err = c.Check(); err != nil {
+			return report, err
+		}
+	}
+
+	var done, total uint64
+
+	for _, kind := range gm.NodeKinds() {
+		total += gm.NodeCount(kind)
+	}
+	for _, kind := range gm.EdgeKinds() {
+		total += gm.EdgeCount(kind)
+	}
+
+	nodes := make(map[consistencyItem]bool)
+	edges := make(map[consistencyItem]bool)
+	edgeRefs := make(map[[2]consistencyItem]bool)
+
+	// Collect the keys of all nodes
+
+	nodeKeys := make(map[consistencyItem][]string)
+
+	for _, part := range gm.Partitions() {
+		for _, kind := range gm.NodeKinds() {
+			item := consistencyItem{part, kind, ""}
+
+			attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+			if err != nil {
+				return report, err
+			} else if attht == nil || valht == nil {
+				continue
+			}
+
+			nodeKeys[item] = gm.checkTree(report, fmt.Sprint(item.part, ".", kind, StorageSuffixNodes, " attributes"),
+				attht, PrefixNSAttrs)
+			gm.checkTree(report, fmt.Sprint(item.part, ".", kind, StorageSuffixNodes, " values"), valht, "")
+
+			if iht, err := gm.getNodeIndexHTree(part, kind, false); err != nil {
+				return report, err
+			} else if iht != nil {
+				gm.checkTree(report, fmt.Sprint(item.part, ".", kind, StorageSuffixNodesIndex), iht, "")
+			}
+
+			for _, key := range nodeKeys[item] {
+				nodes[consistencyItem{part, kind, key}] = true
+			}
+		}
+	}
+
+	// Read all nodes and check their edge references
+
+	nodeCounts := make(map[string]uint64)
+
+	for item, keys := range nodeKeys {
+		attht, valht, _ := gm.getNodeStorageHTree(item.part, item.kind, false)
+
+		for _, key := range keys {
+			node := consistencyItem{item.part, item.kind, key}
+
+			if _, err := gm.readNodeSafe(key, item.kind, attht, valht); err != nil {
+				report.addProblem("Node %v cannot be read: %v", node, err)
+			} else {
+				report.Nodes++
+				nodeCounts[item.kind]++
+			}
+
+			for _, ref := range gm.checkNodeEdges(report, node, valht) {
+				edgeRefs[[2]consistencyItem{node, ref[0]}] = true
+
+				if !nodes[ref[1]] && !gm.isRemote(ref[1].part, ref[1].key, ref[1].kind) {
+					report.addProblem("Node %v refers to missing node %v via edge %v", node, ref[1], ref[0].key)
+				}
+			}
+
+			done++
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+	}
+
+	// Read all edges and check their ends
+
+	edgeCounts := make(map[string]uint64)
+
+	for _, part := range gm.Partitions() {
+		for _, kind := range gm.EdgeKinds() {
+
+			edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+			if err != nil {
+				return report, err
+			} else if edgeht == nil {
+				continue
+			}
+
+			keys := gm.checkTree(report, fmt.Sprint(part, ".", kind, StorageSuffixEdges), edgeht, PrefixNSAttrs)
+
+			if iht, err := gm.getEdgeIndexHTree(part, kind, false); err != nil {
+				return report, err
+			} else if iht != nil {
+				gm.checkTree(report, fmt.Sprint(part, ".", kind, StorageSuffixEdgesIndex), iht, "")
+			}
+
+			for _, key := range keys {
+				item := consistencyItem{part, kind, key}
+
+				edges[item] = true
+
+				if node, err := gm.readNodeSafe(key, kind, edgeht, edgeht); err != nil {
+					report.addProblem("Edge %v cannot be read: %v", item, err)
+				} else {
+					edge := data.NewGraphEdgeFromNode(node)
+
+					for _, end := range []consistencyItem{
+						{part, edge.End1Kind(), edge.End1Key()},
+						{part, edge.End2Kind(), edge.End2Key()},
+					} {
+						if gm.isRemote(end.part, end.key, end.kind) {
+							continue
+						} else if !nodes[end] {
+							report.addProblem("Edge %v points to missing node %v", item, end)
+						} else if !edgeRefs[[2]consistencyItem{end, item}] {
+							report.addProblem("Edge %v is not referenced by its end node %v", item, end)
+						}
+					}
+
+					report.Edges++
+					edgeCounts[kind]++
+				}
+
+				done++
+				if progress != nil {
+					progress(done, total)
+				}
+			}
+		}
+	}
+
+	// Check that all edge references of nodes point to existing edges
+
+	for ref := range edgeRefs {
+		if !edges[ref[1]] {
+			report.addProblem("Node %v refers to missing edge %v", ref[0], ref[1])
+		}
+	}
+
+	// Check the stored counts
+
+	for _, kind := range gm.NodeKinds() {
+		if count := gm.NodeCount(kind); count != nodeCounts[kind] {
+			report.addProblem("Node count of kind %v is %v but %v nodes were found", kind, count, nodeCounts[kind])
+		}
+	}
+
+	for _, kind := range gm.EdgeKinds() {
+		if count := gm.EdgeCount(kind); count != edgeCounts[kind] {
+			report.addProblem("Edge count of kind %v is %v but %v edges were found", kind, count, edgeCounts[kind])
+		}
+	}
+
+	return report, nil
+}
+
+/*
+checkTree checks the structure of a HTree and returns all keys (without the
+prefix) which start with a given prefix.
+*/
+func (gm *Manager) checkTree(report *ConsistencyReport, name string, tree *hash.HTree, prefix string) []string {
+	var keys []string
+
+	report.Trees++
+
+	treeReport := tree.Check(func(key []byte, value interface{}) {
+		if prefix != "" && strings.HasPrefix(string(key), prefix) {
+			keys = append(keys, string(key[len(prefix):]))
+		}
+	})
+
+	for _, problem := range treeReport.Problems {
+		report.addProblem("%v: %v", name, problem)
+	}
+
+	return keys
+}
+
+/*
+checkNodeEdges reads the edge references of a node. Returns pairs of edge and
+target node.
+*/
+func (gm *Manager) checkNodeEdges(report *ConsistencyReport, node consistencyItem,
+	valTree *hash.HTree) [][2]consistencyItem {
+
+	var refs [][2]consistencyItem
+
+	obj, err := valTree.Get([]byte(PrefixNSSpecs + node.key))
+	if err != nil {
+		report.addProblem("Edge specs of node %v cannot be read: %v", node, err)
+		return nil
+	} else if obj == nil {
+		return nil
+	}
+
+	specs, ok := obj.(map[string]string)
+	if !ok {
+		report.addProblem("Edge specs of node %v are invalid", node)
+		return nil
+	}
+
+	for spec := range specs {
+
+		if len(spec) != 8 {
+			report.addProblem("Node %v has an invalid edge spec: %q", node, spec)
+			continue
+		}
+
+		kind := gm.nm.Decode16(spec[2:4])
+
+		obj, err := valTree.Get([]byte(PrefixNSEdge + node.key + spec))
+		if err != nil {
+			report.addProblem("Edges of node %v cannot be read: %v", node, err)
+			continue
+		}
+
+		targets, ok := obj.(map[string]*edgeTargetInfo)
+		if !ok {
+			report.addProblem("Edges of node %v with spec %q are invalid", node, spec)
+			continue
+		}
+
+		for key, info := range targets {
+			refs = append(refs, [2]consistencyItem{
+				{node.part, kind, key},
+				{node.part, info.TargetNodeKind, info.TargetNodeKey},
+			})
+		}
+	}
+
+	return refs
+}
+
+/*
+readNodeSafe reads a node and returns an error if the node does not exist or
+if the storage panics while reading damaged data. Assumes that the caller
+holds the reader lock.
+*/
+func (gm *Manager) readNodeSafe(key string, kind string, attrTree *hash.HTree,
+	valTree *hash.HTree) (node data.Node, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			node, err = nil, &util.GraphError{Type: util.ErrReading, Detail: fmt.Sprint(r)}
+		}
+	}()
+
+	if node, err = gm.readNode(key, kind, nil, attrTree, valTree); err == nil && node == nil {
+		err = &util.GraphError{Type: util.ErrReading, Detail: "Attribute list is missing"}
+	}
+
+	return node, err
+}
+
+/*
+SalvageReport is the result of a salvage operation.
+*/
+type SalvageReport struct {
+	Nodes     uint64   `json:"nodes"`      // Number of copied nodes
+	Edges     uint64   `json:"edges"`      // Number of copied edges
+	Blobs     uint64   `json:"blobs"`      // Number of copied blobs
+	LostNodes uint64   `json:"lost_nodes"` // Number of nodes which could not be copied
+	LostEdges uint64   `json:"lost_edges"` // Number of edges which could not be copied
+	LostBlobs uint64   `json:"lost_blobs"` // Number of blobs which could not be copied
+	Examples  []string `json:"examples"`   // Descriptions of lost items
+}
+
+/*
+addLost adds a description of a lost item to the report.
+*/
+func (r *SalvageReport) addLost(format string, args ...interface{}) {
+	if len(r.Examples) < ConsistencyReportMaxExamples {
+		r.Examples = append(r.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+/*
+Salvage copies all readable nodes, edges and blobs into an empty target graph.
+The keys of all nodes and edges are collected by walking the readable parts of
+the HTrees so entries of damaged trees can be recovered as well. Edges are only
+copied if both their ends could be copied. The text analyzers, unindexed
+attributes and edge role aliases are copied before the data so the indexes of
+the target graph are built with the same settings. The commit sequence numbers
+of all partitions are copied as well so they keep increasing in the target
+graph. An optional progress function is called after each processed node or
+edge.
+*/
+func (gm *Manager) Salvage(target *Manager, progress IndexProgress) (*SalvageReport, error) {
+
+	if len(target.NodeKinds()) > 0 || len(target.EdgeKinds()) > 0 {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Salvage target is not empty"}
+	}
+
+	report := &SalvageReport{}
+
+	// Copy the settings of all kinds
+
+	target.mutex.Lock()
+
+	for _, prefix := range []string{MainDBAnalyzers, MainDBUnindexed, MainDBEdgeRoleAliases, MainDBPartSeq, MainDBKeySeq} {
+		for _, key := range gm.mainDBKeys(prefix) {
+			if val, ok := gm.mainDBValue(key); ok {
+				target.setMainDBValue(key, val, true)
+			}
+		}
+	}
+
+	err := target.flushMain()
+
+	target.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var done, total uint64
+
+	for _, kind := range gm.NodeKinds() {
+		total += gm.NodeCount(kind)
+	}
+	for _, kind := range gm.EdgeKinds() {
+		total += gm.EdgeCount(kind)
+	}
+
+	salvageKeys := func(tree *hash.HTree) []string {
+		var keys []string
+
+		tree.Check(func(key []byte, value interface{}) {
+			if strings.HasPrefix(string(key), PrefixNSAttrs) {
+				keys = append(keys, string(key[len(PrefixNSAttrs):]))
+			}
+		})
+
+		return keys
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+
+			attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+			if err != nil {
+				return report, err
+			} else if attht == nil || valht == nil {
+				continue
+			}
+
+			for _, key := range salvageKeys(attht) {
+				item := consistencyItem{part, kind, key}
+
+				if err := gm.salvageNode(report, target, item, attht, valht); err != nil {
+					report.LostNodes++
+					report.addLost("Node %v: %v", item, err)
+				} else {
+					report.Nodes++
+				}
+
+				done++
+				if progress != nil {
+					progress(done, total)
+				}
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+
+			edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+			if err != nil {
+				return report, err
+			} else if edgeht == nil {
+				continue
+			}
+
+			for _, key := range salvageKeys(edgeht) {
+				item := consistencyItem{part, kind, key}
+
+				unlock := gm.readLock(part)
+				node, err := gm.readNodeSafe(key, kind, edgeht, edgeht)
+				unlock()
+
+				if err == nil {
+					err = target.StoreEdge(part, data.NewGraphEdgeFromNode(node))
+				}
+
+				if err != nil {
+					report.LostEdges++
+					report.addLost("Edge %v: %v", item, err)
+				} else {
+					report.Edges++
+				}
+
+				done++
+				if progress != nil {
+					progress(done, total)
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+/*
+salvageNode copies a node and its blobs into a target graph. A node is copied
+even if some of its blobs cannot be read.
+*/
+func (gm *Manager) salvageNode(report *SalvageReport, target *Manager, item consistencyItem,
+	attht *hash.HTree, valht *hash.HTree) error {
+
+	unlock := gm.readLock(item.part)
+	node, err := gm.readNodeSafe(item.key, item.kind, attht, valht)
+	unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Blobs are stored separately once the node exists
+
+	blobs := make(map[string]*data.BlobRef)
+
+	for attr, val := range node.Data() {
+		if ref, ok := val.(*data.BlobRef); ok {
+			blobs[attr] = ref
+			node.SetAttr(attr, nil)
+		}
+	}
+
+	if err := target.StoreNode(item.part, node); err != nil {
+		return err
+	}
+
+	for attr, ref := range blobs {
+		r, err := gm.OpenBlob(item.part, item.key, item.kind, attr)
+
+		if err == nil {
+			_, err = target.StoreBlob(item.part, item.key, item.kind, attr, ref.ContentType, r)
+		}
+
+		if err != nil {
+			report.LostBlobs++
+			report.addLost("Blob %v of node %v: %v", attr, item, err)
+		} else {
+			report.Blobs++
+		}
+	}
+
+	return nil
+}
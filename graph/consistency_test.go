@@ -0,0 +1,171 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestCheckConsistencyAndSalvage(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	gm.SetIndexed("Person", "bio", false)
+
+	for i := 0; i < 50; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "Person")
+		node.SetAttr("name", fmt.Sprint("Person ", i))
+		node.SetAttr("bio", "secret")
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for i := 0; i < 30; i++ {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, fmt.Sprint(i))
+		edge.SetAttr(data.NodeKind, "Knows")
+		edge.SetAttr(data.EdgeEnd1Key, fmt.Sprint(i))
+		edge.SetAttr(data.EdgeEnd1Kind, "Person")
+		edge.SetAttr(data.EdgeEnd1Role, "Friend1")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, fmt.Sprint(i+1))
+		edge.SetAttr(data.EdgeEnd2Kind, "Person")
+		edge.SetAttr(data.EdgeEnd2Role, "Friend2")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if _, err := gm.StoreBlob("main", "5", "Person", "photo", "image/png",
+		bytes.NewBufferString("0123456789")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var calls uint64
+
+	report, err := gm.CheckConsistency(func(done, total uint64) {
+		calls++
+	})
+
+	if err != nil || !report.Consistent() || report.Nodes != 50 || report.Edges != 30 ||
+		report.Trees != 5 || len(report.Storage) == 0 || calls != 80 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Damage the graph structure
+
+	attht, _, _ := gm.getNodeStorageHTree("main", "Person", false)
+	attht.Remove([]byte(PrefixNSAttrs + "20"))
+
+	edgeht, _ := gm.getEdgeStorageHTree("main", "Knows", false)
+	edgeht.Remove([]byte(PrefixNSAttrs + "3"))
+
+	report, err = gm.CheckConsistency(nil)
+
+	if err != nil || report.Consistent() || report.Nodes != 49 || report.Edges != 29 ||
+		report.Problems != 8 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	expected := map[string]bool{
+		"Node 3 (Person) in partition main refers to missing edge 3 (Knows) in partition main":                true,
+		"Node 4 (Person) in partition main refers to missing edge 3 (Knows) in partition main":                true,
+		"Node 19 (Person) in partition main refers to missing node 20 (Person) in partition main via edge 19": true,
+		"Node 21 (Person) in partition main refers to missing node 20 (Person) in partition main via edge 20": true,
+		"Edge 19 (Knows) in partition main points to missing node 20 (Person) in partition main":              true,
+		"Edge 20 (Knows) in partition main points to missing node 20 (Person) in partition main":              true,
+		"Node count of kind Person is 50 but 49 nodes were found":                                             true,
+		"Edge count of kind Knows is 30 but 29 edges were found":                                              true,
+	}
+
+	for _, example := range report.Examples {
+		if !expected[example] {
+			t.Error("Unexpected problem:", example)
+			return
+		}
+	}
+
+	// Salvage all readable data into a new graph
+
+	tdgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir11, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer tdgs.Close()
+
+	tgm := NewGraphManager(tdgs)
+
+	if _, err := gm.Salvage(gm, nil); err == nil || err.Error() !=
+		"GraphError: Invalid data (Salvage target is not empty)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	salvage, err := gm.Salvage(tgm, nil)
+
+	if err != nil || salvage.Nodes != 49 || salvage.Edges != 27 || salvage.Blobs != 1 ||
+		salvage.LostNodes != 0 || salvage.LostEdges != 2 || salvage.LostBlobs != 0 ||
+		!strings.HasSuffix(salvage.Examples[0], "GraphError: Invalid data (Can't find edge endpoint: 20 (Person))") {
+		t.Error("Unexpected result:", salvage, err)
+		return
+	}
+
+	if report, err = tgm.CheckConsistency(nil); err != nil || !report.Consistent() ||
+		report.Nodes != 49 || report.Edges != 27 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if res := tgm.Unindexed(); fmt.Sprint(res) != "map[Person:[bio]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	br, err := tgm.OpenBlob("main", "5", "Person", "photo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ioutil.ReadAll(br); string(res) != "0123456789" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+}
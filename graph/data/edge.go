@@ -24,6 +24,19 @@ Edges are items stored in the graph. Edges connect nodes. The graphEdge object
 is the minimal implementation of the Edge interface and represents a simple edge.
 Setting a nil value to an attribute is equivalent to removing the attribute. An
 attribute value can be any object which can be serialized by gob.
+
+Attribute values
+
+Besides strings, numbers and booleans attributes can hold datetimes
+(time.Time), arbitrary-precision decimals (*Decimal), byte slices, lists and
+nested maps. These values are stored as they are. CompareValues compares
+values according to their type and is used by EQL for comparisons and ordering.
+
+Locations are stored as *GeoPoint values (or maps with lat and lon values).
+They are indexed in a geo index which supports radius and bounding box queries.
+
+Edges with a timestamp attribute are timestamped edges. Their ends keep a
+timeline which allows traversals within a time range.
 */
 package data
 
@@ -166,6 +179,12 @@ a kind
 */
 const EdgeEnd2CascadingLast = "end2cascadinglast"
 
+/*
+EdgeTimestamp is the timestamp of an edge. Timestamped edges can be
+traversed by time range.
+*/
+const EdgeTimestamp = "timestamp"
+
 /*
 graphEdge data structure.
 */
@@ -0,0 +1,341 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+StructTag is the name of the struct tag which maps struct fields to attributes.
+*/
+const StructTag = "eliasdb"
+
+/*
+timeType is the type of time.Time values which are stored as they are.
+*/
+var timeType = reflect.TypeOf(time.Time{})
+
+/*
+structField describes the mapping of a single struct field.
+*/
+type structField struct {
+	index     int    // Index of the field in the struct
+	attr      string // Attribute name
+	omitEmpty bool   // Flag if empty values should not be stored
+	ref       bool   // Flag if the field references another node (e.g. an edge end)
+}
+
+/*
+Marshal converts a struct (or a pointer to a struct) into a node. Exported
+fields are stored under their name or the name given in an eliasdb struct tag:
+
+	type Person struct {
+		Key  string `eliasdb:"key"`
+		Kind string `eliasdb:"kind"`
+		Name string `eliasdb:"name,omitempty"`
+		Age  int    `eliasdb:"age"`
+		Temp string `eliasdb:"-"`
+	}
+
+Fields with a tag of "-" are ignored. Empty values of fields with the omitempty
+option are not stored. If the struct has no kind field then the name of the
+struct type is used as kind. Fields which contain a struct (or a pointer to a
+struct) reference another node. For these only the key and kind of the
+referenced node are stored using the attribute name as prefix (e.g. a field
+tagged with end1 is stored as end1key and end1kind).
+*/
+func Marshal(v interface{}) (Node, error) {
+
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	node := NewGraphNode()
+
+	for _, f := range structFields(rv.Type()) {
+		fv := rv.Field(f.index)
+
+		if f.ref {
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+
+			ref, err := Marshal(fv.Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			node.SetAttr(f.attr+NodeKey, ref.Key())
+			node.SetAttr(f.attr+NodeKind, ref.Kind())
+
+			continue
+		}
+
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		node.SetAttr(f.attr, fv.Interface())
+	}
+
+	if node.Kind() == "" {
+		node.SetAttr(NodeKind, rv.Type().Name())
+	}
+
+	return node, nil
+}
+
+/*
+MarshalEdge converts a struct (or a pointer to a struct) into an edge. See
+Marshal for the mapping rules.
+*/
+func MarshalEdge(v interface{}) (Edge, error) {
+	node, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewGraphEdgeFromNode(node), nil
+}
+
+/*
+Unmarshal copies the attributes of a node or edge into a struct. The given
+value must be a pointer to a struct. See Marshal for the mapping rules. Numbers,
+strings and booleans are converted if the attribute value has a different type
+than the struct field.
+*/
+func Unmarshal(node Node, v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal needs a non-nil pointer to a struct not %T", v)
+	}
+
+	rv = rv.Elem()
+
+	for _, f := range structFields(rv.Type()) {
+		fv := rv.Field(f.index)
+
+		if f.ref {
+			key, kind := node.Attr(f.attr+NodeKey), node.Attr(f.attr+NodeKind)
+
+			if key == nil && kind == nil {
+				continue
+			}
+
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+
+			ref := NewGraphNode()
+			ref.SetAttr(NodeKey, key)
+			ref.SetAttr(NodeKind, kind)
+
+			if err := Unmarshal(ref, fv.Addr().Interface()); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if val := node.Attr(f.attr); val != nil {
+			if err := assignValue(fv, reflect.ValueOf(val)); err != nil {
+				return fmt.Errorf("Cannot set field %v from attribute %v: %v",
+					rv.Type().Field(f.index).Name, f.attr, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+structValue returns the struct value of a struct or a pointer to a struct.
+*/
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return rv, fmt.Errorf("Marshal needs a struct or a pointer to a struct not %T", v)
+	}
+
+	return rv, nil
+}
+
+/*
+structFields returns the field mappings of a struct type.
+*/
+func structFields(t reflect.Type) []*structField {
+	var fields []*structField
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		if sf.PkgPath != "" {
+			continue // Unexported field
+		}
+
+		tag := sf.Tag.Get(StructTag)
+		if tag == "-" {
+			continue
+		}
+
+		f := &structField{i, sf.Name, false, false}
+
+		if tag != "" {
+			opts := strings.Split(tag, ",")
+
+			if opts[0] != "" {
+				f.attr = opts[0]
+			}
+
+			for _, opt := range opts[1:] {
+				if opt == "omitempty" {
+					f.omitEmpty = true
+				}
+			}
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		f.ref = ft.Kind() == reflect.Struct && ft != timeType
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+/*
+isEmptyValue checks if a value is the zero value of its type or an empty
+container.
+*/
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+/*
+assignValue assigns a value to a struct field converting the value if necessary.
+*/
+func assignValue(fv reflect.Value, val reflect.Value) error {
+
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	if val.Type().AssignableTo(fv.Type()) {
+		fv.Set(val)
+		return nil
+	}
+
+	switch fv.Kind() {
+
+	case reflect.Ptr:
+		nv := reflect.New(fv.Type().Elem())
+		if err := assignValue(nv.Elem(), val); err != nil {
+			return err
+		}
+		fv.Set(nv)
+		return nil
+
+	case reflect.String:
+		if isNumberKind(val.Kind()) || val.Kind() == reflect.Bool || val.Kind() == reflect.String {
+			fv.SetString(fmt.Sprint(val.Interface()))
+			return nil
+		}
+
+	case reflect.Bool:
+		if val.Kind() == reflect.String {
+			b, err := strconv.ParseBool(val.String())
+			if err != nil {
+				return err
+			}
+			fv.SetBool(b)
+			return nil
+		}
+
+	case reflect.Slice:
+		if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+			nv := reflect.MakeSlice(fv.Type(), val.Len(), val.Len())
+			for i := 0; i < val.Len(); i++ {
+				if err := assignValue(nv.Index(i), val.Index(i)); err != nil {
+					return err
+				}
+			}
+			fv.Set(nv)
+			return nil
+		}
+
+	case reflect.Map:
+		if val.Kind() == reflect.Map {
+			nv := reflect.MakeMapWithSize(fv.Type(), val.Len())
+			for _, k := range val.MapKeys() {
+				nk := reflect.New(fv.Type().Key()).Elem()
+				ne := reflect.New(fv.Type().Elem()).Elem()
+				if err := assignValue(nk, k); err != nil {
+					return err
+				}
+				if err := assignValue(ne, val.MapIndex(k)); err != nil {
+					return err
+				}
+				nv.SetMapIndex(nk, ne)
+			}
+			fv.Set(nv)
+			return nil
+		}
+
+	default:
+		if isNumberKind(fv.Kind()) {
+			if isNumberKind(val.Kind()) {
+				fv.Set(val.Convert(fv.Type()))
+				return nil
+			} else if val.Kind() == reflect.String {
+				f, err := strconv.ParseFloat(val.String(), 64)
+				if err != nil {
+					return err
+				}
+				fv.Set(reflect.ValueOf(f).Convert(fv.Type()))
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("Cannot convert %v to %v", val.Type(), fv.Type())
+}
+
+/*
+isNumberKind checks if a given kind is a number kind.
+*/
+func isNumberKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uint64) || k == reflect.Float32 || k == reflect.Float64
+}
@@ -0,0 +1,211 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testPerson struct {
+	Key      string            `eliasdb:"key"`
+	Kind     string            `eliasdb:"kind"`
+	Name     string            `eliasdb:"name,omitempty"`
+	Age      int               `eliasdb:"age"`
+	Score    *float64          `eliasdb:"score,omitempty"`
+	Tags     []string          `eliasdb:"tags,omitempty"`
+	Props    map[string]string `eliasdb:"props,omitempty"`
+	Born     time.Time         `eliasdb:"born,omitempty"`
+	Active   bool
+	Internal string `eliasdb:"-"`
+	hidden   string
+}
+
+type testSong struct {
+	Key   string `eliasdb:"key"`
+	Title string `eliasdb:"title"`
+}
+
+type testWrote struct {
+	Key    string      `eliasdb:"key"`
+	Kind   string      `eliasdb:"kind"`
+	Author *testPerson `eliasdb:"end1"`
+	Role1  string      `eliasdb:"end1role"`
+	Casc1  bool        `eliasdb:"end1cascading"`
+	Song   testSong    `eliasdb:"end2"`
+	Role2  string      `eliasdb:"end2role"`
+	Casc2  bool        `eliasdb:"end2cascading"`
+}
+
+func TestMarshalNode(t *testing.T) {
+
+	score := 1.5
+
+	p := &testPerson{Key: "123", Kind: "Person", Age: 42, Score: &score,
+		Tags: []string{"a", "b"}, Active: true, Internal: "x", hidden: "y"}
+
+	node, err := Marshal(p)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if node.Key() != "123" || node.Kind() != "Person" || node.Attr("age") != 42 ||
+		node.Attr("score") != &score || fmt.Sprint(node.Attr("tags")) != "[a b]" || node.Attr("Active") != true {
+		t.Error("Unexpected result:", node.Data())
+		return
+	}
+
+	if node.Attr("name") != nil || node.Attr("Internal") != nil || node.Attr("hidden") != nil ||
+		node.Attr("born") != nil || node.Attr("props") != nil {
+		t.Error("Unexpected result:", node.Data())
+		return
+	}
+
+	// Kind defaults to the struct type name
+
+	node, _ = Marshal(testSong{"s1", "Aria"})
+
+	if node.Kind() != "testSong" || node.Attr("title") != "Aria" {
+		t.Error("Unexpected result:", node)
+		return
+	}
+
+	if _, err := Marshal("foo"); err == nil || err.Error() != "Marshal needs a struct or a pointer to a struct not string" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Unmarshal with type conversions
+
+	node = NewGraphNodeFromMap(map[string]interface{}{
+		"key":    123,
+		"kind":   "Person",
+		"name":   "John",
+		"age":    float64(42),
+		"score":  "2.5",
+		"tags":   []interface{}{"x", 1},
+		"props":  map[string]interface{}{"a": "b"},
+		"born":   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		"Active": "true",
+	})
+
+	var p2 testPerson
+
+	if err := Unmarshal(node, &p2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if p2.Key != "123" || p2.Name != "John" || p2.Age != 42 || *p2.Score != 2.5 ||
+		fmt.Sprint(p2.Tags) != "[x 1]" || p2.Props["a"] != "b" || p2.Born.Year() != 2000 || !p2.Active {
+		t.Error("Unexpected result:", p2)
+		return
+	}
+
+	if err := Unmarshal(node, p2); err == nil || err.Error() != "Unmarshal needs a non-nil pointer to a struct not data.testPerson" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	node.SetAttr("age", "foo")
+
+	if err := Unmarshal(node, &p2); err == nil || err.Error() != `Cannot set field Age from attribute age: strconv.ParseFloat: parsing "foo": invalid syntax` {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	node.SetAttr("age", []string{"foo"})
+
+	if err := Unmarshal(node, &p2); err == nil || err.Error() != "Cannot set field Age from attribute age: Cannot convert []string to int" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	node.SetAttr("age", 1)
+	node.SetAttr("Active", "foo")
+
+	if err := Unmarshal(node, &p2); err == nil || err.Error() != `Cannot set field Active from attribute Active: strconv.ParseBool: parsing "foo": invalid syntax` {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestMarshalEdge(t *testing.T) {
+
+	w := &testWrote{Key: "e1", Kind: "Wrote", Author: &testPerson{Key: "123", Kind: "Person"},
+		Role1: "Author", Song: testSong{Key: "s1"}, Role2: "Song", Casc2: true}
+
+	edge, err := MarshalEdge(w)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if edge.End1Key() != "123" || edge.End1Kind() != "Person" || edge.End1Role() != "Author" ||
+		edge.End2Key() != "s1" || edge.End2Kind() != "testSong" || !edge.End2IsCascading() {
+		t.Error("Unexpected result:", edge)
+		return
+	}
+
+	var w2 testWrote
+
+	if err := Unmarshal(edge, &w2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if w2.Key != "e1" || w2.Author == nil || w2.Author.Key != "123" || w2.Author.Kind != "Person" ||
+		w2.Song.Key != "s1" || w2.Role2 != "Song" || !w2.Casc2 {
+		t.Error("Unexpected result:", w2)
+		return
+	}
+
+	// Nil references are not stored
+
+	w.Author = nil
+
+	edge, _ = MarshalEdge(w)
+
+	if edge.Attr(EdgeEnd1Key) != nil || edge.Attr(EdgeEnd1Kind) != nil {
+		t.Error("Unexpected result:", edge)
+		return
+	}
+
+	var w3 testWrote
+
+	if err := Unmarshal(edge, &w3); err != nil || w3.Author != nil {
+		t.Error("Unexpected result:", w3, err)
+		return
+	}
+
+	if _, err := MarshalEdge(1); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	edge.SetAttr(EdgeEnd2Key, []string{"foo"})
+
+	if err := Unmarshal(edge, &w3); err == nil || err.Error() != "Cannot set field Key from attribute key: Cannot convert []string to string" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	type badRef struct {
+		Ref *struct{ Key []int } `eliasdb:"ref"`
+	}
+
+	if _, err := Marshal(&badRef{&struct{ Key []int }{}}); err != nil {
+		t.Error(err)
+		return
+	}
+}
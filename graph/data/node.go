@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"time"
 )
 
 /*
@@ -223,6 +224,31 @@ func createIndexMap(gn *graphNode, attFilter func(attr string) bool) map[string]
 
 				ret[attr] = st
 
+			} else if _, ok := val.(*BlobRef); ok {
+
+				// Blobs are not indexed
+
+				continue
+
+			} else if t, ok := val.(time.Time); ok {
+
+				// Datetimes are indexed in a sortable and parsable format
+
+				ret[attr] = t.UTC().Format(time.RFC3339Nano)
+
+			} else if d, ok := val.(*Decimal); ok {
+
+				// Decimals are indexed in their shortest decimal notation so
+				// they are found with the same value as an equal number
+
+				if d != nil {
+					ret[attr] = d.String()
+				}
+
+			} else if d, ok := val.(Decimal); ok {
+
+				ret[attr] = d.String()
+
 			} else if st, ok := val.(fmt.Stringer); ok {
 
 				// Value has a proper string representation - use that
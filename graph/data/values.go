@@ -0,0 +1,496 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+func init() {
+
+	// Register rich attribute value types so they can be stored as they are
+
+	gob.Register(time.Time{})
+	gob.Register(&Decimal{})
+	gob.Register(&BlobRef{})
+	gob.Register(&GeoPoint{})
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+/*
+DecimalMaxDigits is the maximum number of fractional digits which are printed
+for decimals which have no finite decimal representation (e.g. 1/3).
+*/
+const DecimalMaxDigits = 32
+
+/*
+TimeFormats are the formats which are accepted when a string is compared
+to a datetime attribute value.
+*/
+var TimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+/*
+Tagged JSON values
+
+Rich attribute values lose their type in plain JSON (e.g. a datetime becomes
+a string). TagValues and UntagValues convert them from and to tagged JSON
+objects of the form:
+
+	{ "$type" : <datetime, decimal or binary>, "value" : <value as string> }
+
+Datetimes are encoded in RFC 3339 format, decimals in decimal notation (or as
+fraction if there is no finite decimal notation) and binary values in
+standard base64 encoding.
+*/
+const (
+	TaggedTypeKey  = "$type"
+	TaggedValueKey = "value"
+
+	TaggedTypeDatetime = "datetime"
+	TaggedTypeDecimal  = "decimal"
+	TaggedTypeBinary   = "binary"
+)
+
+/*
+Decimal is an arbitrary-precision decimal attribute value.
+*/
+type Decimal struct {
+	rat big.Rat
+}
+
+/*
+NewDecimal creates a new decimal from a string (e.g. "12.34" or "1/3").
+*/
+func NewDecimal(s string) (*Decimal, error) {
+	d := &Decimal{}
+
+	if _, ok := d.rat.SetString(strings.TrimSpace(s)); !ok {
+		return nil, fmt.Errorf("Invalid decimal: %v", s)
+	}
+
+	return d, nil
+}
+
+/*
+NewDecimalFromRat creates a new decimal from a rational number.
+*/
+func NewDecimalFromRat(r *big.Rat) *Decimal {
+	d := &Decimal{}
+	d.rat.Set(r)
+	return d
+}
+
+/*
+Rat returns a copy of the decimal as a rational number.
+*/
+func (d *Decimal) Rat() *big.Rat {
+	return new(big.Rat).Set(&d.rat)
+}
+
+/*
+Cmp compares this decimal to another decimal. Returns -1, 0 or 1.
+*/
+func (d *Decimal) Cmp(o *Decimal) int {
+	return d.rat.Cmp(&o.rat)
+}
+
+/*
+String returns the decimal notation of this decimal.
+*/
+func (d *Decimal) String() string {
+
+	// Determine the number of digits which are needed for an exact
+	// representation - only denominators of the form 2^x * 5^y have one
+
+	var two, five, digits int
+
+	denom := new(big.Int).Set(d.rat.Denom())
+	mod := new(big.Int)
+
+	for denom.Bit(0) == 0 {
+		denom.Rsh(denom, 1)
+		two++
+	}
+
+	for {
+		q, m := new(big.Int).QuoRem(denom, big.NewInt(5), mod)
+		if m.Sign() != 0 {
+			break
+		}
+		denom = q
+		five++
+	}
+
+	if digits = two; five > two {
+		digits = five
+	}
+
+	if denom.Cmp(big.NewInt(1)) != 0 {
+		digits = DecimalMaxDigits
+	}
+
+	ret := d.rat.FloatString(digits)
+
+	if strings.Contains(ret, ".") {
+		ret = strings.TrimRight(strings.TrimRight(ret, "0"), ".")
+	}
+
+	return ret
+}
+
+/*
+exactString returns the decimal notation of this decimal or a fraction if
+there is no finite decimal notation.
+*/
+func (d *Decimal) exactString() string {
+	ret := d.String()
+
+	if nd, err := NewDecimal(ret); err != nil || nd.Cmp(d) != 0 {
+		ret = d.rat.RatString()
+	}
+
+	return ret
+}
+
+/*
+MarshalJSON returns the decimal as a JSON number.
+*/
+func (d *Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+/*
+UnmarshalJSON reads the decimal from a JSON number or string.
+*/
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	nd, err := NewDecimal(strings.Trim(string(b), `"`))
+	if err == nil {
+		d.rat.Set(&nd.rat)
+	}
+	return err
+}
+
+/*
+GobEncode encodes the decimal for storage.
+*/
+func (d *Decimal) GobEncode() ([]byte, error) {
+	return d.rat.GobEncode()
+}
+
+/*
+GobDecode decodes the decimal from storage.
+*/
+func (d *Decimal) GobDecode(b []byte) error {
+	return d.rat.GobDecode(b)
+}
+
+/*
+BlobRef is an attribute value which references a large binary value. The
+binary data itself is kept in a separate blob storage of the partition (see
+the GraphManager functions StoreBlob, OpenBlob and RemoveBlob).
+*/
+type BlobRef struct {
+	Loc         uint64 `json:"-"`            // Storage location of the blob header
+	Size        int64  `json:"size"`         // Size of the blob in bytes
+	ContentType string `json:"content_type"` // Content type of the blob
+}
+
+/*
+String returns a string representation of this blob reference.
+*/
+func (b *BlobRef) String() string {
+	return fmt.Sprintf("<blob %v %v bytes>", b.ContentType, b.Size)
+}
+
+/*
+GeoPoint is a location attribute value. Nested maps with numeric lat and lon
+values are treated as locations as well.
+*/
+type GeoPoint struct {
+	Lat float64 `json:"lat"` // Latitude in degrees
+	Lon float64 `json:"lon"` // Longitude in degrees
+}
+
+/*
+ToGeoPoint converts an attribute value to a location. Returns false if the
+value does not represent a location.
+*/
+func ToGeoPoint(v interface{}) (*GeoPoint, bool) {
+
+	switch t := v.(type) {
+
+	case *GeoPoint:
+		return t, t != nil
+
+	case GeoPoint:
+		return &t, true
+
+	case map[string]interface{}:
+		lat, ok1 := t["lat"].(float64)
+		lon, ok2 := t["lon"].(float64)
+
+		if ok1 && ok2 {
+			return &GeoPoint{lat, lon}, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+CompareValues compares two attribute values taking rich value types into
+account. Datetimes are compared with datetimes or strings in one of the
+TimeFormats, decimals with decimals, numbers or number strings and byte slices
+with byte slices or strings. Returns the result of the comparison (-1, 0 or 1)
+and a flag if the values could be compared. The flag is false if none of the
+values has a rich value type.
+*/
+func CompareValues(v1 interface{}, v2 interface{}) (int, bool) {
+
+	if res, ok := compareTyped(v1, v2); ok {
+		return res, true
+	}
+
+	res, ok := compareTyped(v2, v1)
+
+	return -res, ok
+}
+
+/*
+compareTyped compares two values if the first value has a rich value type.
+*/
+func compareTyped(v1 interface{}, v2 interface{}) (int, bool) {
+
+	switch t1 := v1.(type) {
+
+	case time.Time:
+		if t2, ok := toTime(v2); ok {
+			if t1.Before(t2) {
+				return -1, true
+			} else if t1.After(t2) {
+				return 1, true
+			}
+			return 0, true
+		}
+
+	case *Decimal:
+		if d2, ok := toDecimal(v2); ok {
+			return t1.Cmp(d2), true
+		}
+
+	case []byte:
+		switch t2 := v2.(type) {
+		case []byte:
+			return bytes.Compare(t1, t2), true
+		case string:
+			return bytes.Compare(t1, []byte(t2)), true
+		}
+	}
+
+	return 0, false
+}
+
+/*
+toTime converts a value to a datetime.
+*/
+func toTime(v interface{}) (time.Time, bool) {
+
+	switch t := v.(type) {
+
+	case time.Time:
+		return t, true
+
+	case string:
+		for _, f := range TimeFormats {
+			if ret, err := time.Parse(f, t); err == nil {
+				return ret, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+/*
+toDecimal converts a value to a decimal.
+*/
+func toDecimal(v interface{}) (*Decimal, bool) {
+
+	switch t := v.(type) {
+
+	case *Decimal:
+		return t, true
+
+	case float32, float64:
+		if r, ok := new(big.Rat).SetString(fmt.Sprint(t)); ok {
+			return NewDecimalFromRat(r), true
+		}
+
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, string:
+		d, err := NewDecimal(fmt.Sprint(t))
+		return d, err == nil
+	}
+
+	return nil, false
+}
+
+/*
+TagValues returns a copy of node or edge data where all rich attribute values
+(also in lists and nested maps) are replaced by tagged JSON objects.
+*/
+func TagValues(nodeData map[string]interface{}) map[string]interface{} {
+
+	if nodeData == nil {
+		return nil
+	}
+
+	return tagValue(nodeData).(map[string]interface{})
+}
+
+/*
+tagValue replaces a rich attribute value by a tagged JSON object.
+*/
+func tagValue(v interface{}) interface{} {
+
+	switch t := v.(type) {
+
+	case time.Time:
+		return map[string]interface{}{
+			TaggedTypeKey:  TaggedTypeDatetime,
+			TaggedValueKey: t.Format(time.RFC3339Nano),
+		}
+
+	case *Decimal:
+		if t != nil {
+			return map[string]interface{}{
+				TaggedTypeKey:  TaggedTypeDecimal,
+				TaggedValueKey: t.exactString(),
+			}
+		}
+
+	case []byte:
+		return map[string]interface{}{
+			TaggedTypeKey:  TaggedTypeBinary,
+			TaggedValueKey: base64.StdEncoding.EncodeToString(t),
+		}
+
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, lv := range t {
+			ret[i] = tagValue(lv)
+		}
+		return ret
+
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, mv := range t {
+			ret[k] = tagValue(mv)
+		}
+		return ret
+	}
+
+	return v
+}
+
+/*
+UntagValues replaces all tagged JSON objects in node or edge data (which was
+received from a client) by rich attribute values. The given data is modified.
+Objects with an unknown type or with other attributes besides type and value
+are not touched.
+*/
+func UntagValues(nodeData map[string]interface{}) error {
+	var err error
+
+	for k, v := range nodeData {
+		if nodeData[k], err = untagValue(v); err != nil {
+			return fmt.Errorf("Invalid value for attribute %v: %v", k, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+untagValue replaces a tagged JSON object by a rich attribute value.
+*/
+func untagValue(v interface{}) (interface{}, error) {
+	var err error
+
+	switch t := v.(type) {
+
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, lv := range t {
+			if ret[i], err = untagValue(lv); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+
+	case map[string]interface{}:
+		tag, ok1 := t[TaggedTypeKey].(string)
+		val, ok2 := t[TaggedValueKey].(string)
+
+		if ok1 && ok2 && len(t) == 2 {
+			if ret, ok, err := untagString(tag, val); ok {
+				return ret, err
+			}
+		}
+
+		ret := make(map[string]interface{}, len(t))
+		for k, mv := range t {
+			if ret[k], err = untagValue(mv); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+
+	return v, nil
+}
+
+/*
+untagString converts the string value of a tagged JSON object. Returns false
+if the type is unknown.
+*/
+func untagString(tag string, val string) (interface{}, bool, error) {
+	var ret interface{}
+	var err error
+
+	switch tag {
+
+	case TaggedTypeDatetime:
+		ret, err = time.Parse(time.RFC3339Nano, val)
+
+	case TaggedTypeDecimal:
+		ret, err = NewDecimal(val)
+
+	case TaggedTypeBinary:
+		ret, err = base64.StdEncoding.DecodeString(val)
+
+	default:
+		return nil, false, nil
+	}
+
+	return ret, true, err
+}
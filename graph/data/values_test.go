@@ -0,0 +1,243 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDecimal(t *testing.T) {
+
+	for in, out := range map[string]string{
+		"12.34":   "12.34",
+		"12.3400": "12.34",
+		"-0.5":    "-0.5",
+		"100":     "100",
+		"1/8":     "0.125",
+		"1/3":     "0.33333333333333333333333333333333",
+		"1e-3":    "0.001",
+		"123456789012345678901234567890.123456789": "123456789012345678901234567890.123456789",
+	} {
+		d, err := NewDecimal(in)
+		if err != nil || d.String() != out {
+			t.Error("Unexpected result:", in, d, err)
+			return
+		}
+	}
+
+	if _, err := NewDecimal("foo"); err == nil || err.Error() != "Invalid decimal: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	d := NewDecimalFromRat(big.NewRat(5, 2))
+
+	if d.String() != "2.5" || d.Rat().String() != "5/2" {
+		t.Error("Unexpected result:", d)
+		return
+	}
+
+	res, err := json.Marshal(map[string]interface{}{"d": d})
+	if err != nil || string(res) != `{"d":2.5}` {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	var d2 Decimal
+
+	if err := json.Unmarshal([]byte(`"0.1"`), &d2); err != nil || d2.String() != "0.1" {
+		t.Error("Unexpected result:", d2.String(), err)
+		return
+	}
+
+	if err := json.Unmarshal([]byte(`"x"`), &d2); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestRichValueSerialization(t *testing.T) {
+	dec, _ := NewDecimal("0.1")
+	date := time.Date(2020, 2, 29, 12, 30, 0, 0, time.UTC)
+
+	attrs := map[string]interface{}{
+		"date":    date,
+		"decimal": dec,
+		"binary":  []byte{1, 2, 3},
+		"list":    []string{"a", "b"},
+		"mixed":   []interface{}{"a", 1.5},
+		"nested":  map[string]interface{}{"a": []interface{}{"b"}},
+	}
+
+	bb := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(bb).Encode(attrs); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string]interface{}
+
+	if err := gob.NewDecoder(bb).Decode(&res); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res["date"] != date || res["decimal"].(*Decimal).String() != "0.1" ||
+		fmt.Sprint(res["binary"], res["list"], res["mixed"], res["nested"]) != "[1 2 3] [a b] [a 1.5] map[a:[b]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Check the index representation
+
+	node := NewGraphNodeFromMap(attrs)
+
+	if res := fmt.Sprint(node.IndexMap()); res != `map[date:2020-02-29T12:30:00Z decimal:0.1 list:["a","b"] mixed:["a",1.5] nested:{"a":["b"]} nested.a:["b"]]` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Decimals are indexed like equal numbers
+
+	dec, _ = NewDecimal("12.50")
+
+	node = NewGraphNodeFromMap(map[string]interface{}{
+		"d1": dec,
+		"d2": *dec,
+		"d3": (*Decimal)(nil),
+		"n":  12.5,
+		"m":  map[string]interface{}{"d": dec},
+	})
+
+	if res := fmt.Sprint(node.IndexMap()); res != `map[d1:12.5 d2:12.5 m:{"d":12.5} m.d:12.5 n:12.5]` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestTaggedValues(t *testing.T) {
+	dec, _ := NewDecimal("0.1")
+	third, _ := NewDecimal("1/3")
+	date := time.Date(2020, 2, 29, 12, 30, 0, 0, time.UTC)
+
+	attrs := map[string]interface{}{
+		"date":    date,
+		"decimal": dec,
+		"third":   third,
+		"binary":  []byte{1, 2, 3},
+		"list":    []interface{}{"a", date},
+		"nested":  map[string]interface{}{"a": dec},
+		"plain":   "a",
+	}
+
+	res, err := json.Marshal(TagValues(attrs))
+	if err != nil || string(res) != `{"binary":{"$type":"binary","value":"AQID"},`+
+		`"date":{"$type":"datetime","value":"2020-02-29T12:30:00Z"},`+
+		`"decimal":{"$type":"decimal","value":"0.1"},`+
+		`"list":["a",{"$type":"datetime","value":"2020-02-29T12:30:00Z"}],`+
+		`"nested":{"a":{"$type":"decimal","value":"0.1"}},"plain":"a",`+
+		`"third":{"$type":"decimal","value":"1/3"}}` {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	// The original data is not modified
+
+	if attrs["date"] != date {
+		t.Error("Unexpected result:", attrs)
+		return
+	}
+
+	if TagValues(nil) != nil {
+		t.Error("Unexpected result")
+		return
+	}
+
+	// Decode the tagged values again
+
+	var data map[string]interface{}
+
+	json.Unmarshal(res, &data)
+
+	if err := UntagValues(data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !data["date"].(time.Time).Equal(date) || data["decimal"].(*Decimal).Cmp(dec) != 0 ||
+		data["third"].(*Decimal).Cmp(third) != 0 ||
+		fmt.Sprintf("%v %v %v %v", data["binary"], data["list"].([]interface{})[1].(time.Time).Equal(date),
+			data["nested"].(map[string]interface{})["a"], data["plain"]) != "[1 2 3] true 0.1 a" {
+		t.Error("Unexpected result:", data)
+		return
+	}
+
+	// Objects with unknown types or other attributes are not touched
+
+	data = map[string]interface{}{
+		"a": map[string]interface{}{"$type": "foo", "value": "x"},
+		"b": map[string]interface{}{"$type": "decimal", "value": "1", "c": 1},
+	}
+
+	if err := UntagValues(data); err != nil || fmt.Sprint(data) != "map[a:map[$type:foo value:x] b:map[$type:decimal c:1 value:1]]" {
+		t.Error("Unexpected result:", data, err)
+		return
+	}
+
+	data = map[string]interface{}{
+		"a": []interface{}{map[string]interface{}{"$type": "datetime", "value": "x"}},
+	}
+
+	if err := UntagValues(data); err == nil || err.Error() != `Invalid value for attribute a: parsing time "x" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "x" as "2006"` {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	dec, _ := NewDecimal("0.3")
+	date := time.Date(2020, 2, 29, 12, 30, 0, 0, time.UTC)
+
+	for i, test := range []struct {
+		v1, v2 interface{}
+		res    int
+		ok     bool
+	}{
+		{date, "2020-02-29", 1, true},
+		{"2020-02-29", date, -1, true},
+		{date, "2020-02-29T12:30:00Z", 0, true},
+		{date, "2021-01-01 00:00:00", -1, true},
+		{date, date.Add(time.Hour), -1, true},
+		{date, "foo", 0, false},
+		{dec, 0.3, 0, true},
+		{dec, "0.29999999", 1, true},
+		{0.1, dec, -1, true},
+		{dec, 1, -1, true},
+		{dec, true, 0, false},
+		{[]byte("abc"), "abd", -1, true},
+		{[]byte("abc"), []byte("abc"), 0, true},
+		{[]byte("abc"), 1, 0, false},
+		{"a", "b", 0, false},
+		{1, 2, 0, false},
+	} {
+		if res, ok := CompareValues(test.v1, test.v2); res != test.res || ok != test.ok {
+			t.Error("Unexpected result in test", i, ":", res, ok)
+			return
+		}
+	}
+}
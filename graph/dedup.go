@@ -0,0 +1,423 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+DuplicateEdgeKind is the kind of the edges which connect candidate duplicate nodes.
+*/
+var DuplicateEdgeKind = "PossibleDuplicate"
+
+/*
+DuplicateEdgeRole is the role of both ends of a duplicate edge.
+*/
+var DuplicateEdgeRole = "Duplicate"
+
+/*
+DedupFuzzyThreshold is the default minimum similarity of fuzzy matching rules.
+*/
+var DedupFuzzyThreshold = 0.8
+
+/*
+DedupRule is a matching rule for an attribute. Values of exact rules must be
+equal (ignoring case and surrounding whitespace). Values of fuzzy rules must
+have a similarity (based on their edit distance) of at least the threshold of
+the rule.
+*/
+type DedupRule struct {
+	Attr      string  // Attribute which is compared
+	Fuzzy     bool    // Flag if the values are compared by similarity
+	Threshold float64 // Minimum similarity of fuzzy values (0 to 1)
+	Weight    float64 // Weight of the rule in the score of a match
+}
+
+/*
+DuplicateCandidate is a pair of nodes which match all rules.
+*/
+type DuplicateCandidate struct {
+	Key1  string  `json:"key1"`  // Key of the first node
+	Key2  string  `json:"key2"`  // Key of the second node
+	Score float64 `json:"score"` // Weighted average similarity of all rules
+}
+
+/*
+dedupRecord holds the normalized rule values of a node.
+*/
+type dedupRecord struct {
+	key    string   // Key of the node
+	values []string // Normalized values for each rule
+}
+
+/*
+FindDuplicates finds pairs of nodes of a kind which match all given rules. Only
+nodes with equal values for all exact rules are compared with each other - at
+least one exact rule should be given for large node kinds as otherwise all
+nodes are compared with each other. The score of a pair is the weighted average
+of the similarities of all rules. Pairs are returned by descending score. An
+optional progress function is called after each scanned node.
+*/
+func (gm *Manager) FindDuplicates(part string, kind string, rules []*DedupRule,
+	progress IndexProgress) ([]*DuplicateCandidate, error) {
+
+	var attrs []string
+
+	if len(rules) == 0 {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Need at least one matching rule"}
+	}
+
+	for _, rule := range rules {
+
+		if rule.Attr == "" || rule.Attr == data.NodeKey || rule.Attr == data.NodeKind {
+			return nil, &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprintf("Invalid attribute for matching rule: '%v'", rule.Attr)}
+		} else if rule.Threshold < 0 || rule.Threshold > 1 || rule.Weight < 0 {
+			return nil, &util.GraphError{Type: util.ErrInvalidData,
+				Detail: "Threshold of a matching rule must be between 0 and 1 and its weight must be positive"}
+		}
+
+		attrs = append(attrs, rule.Attr)
+	}
+
+	// Group all nodes by the values of their exact rules
+
+	blocks := make(map[string][]*dedupRecord)
+
+	it, err := gm.NodeKeyIterator(part, kind)
+
+	total := gm.NodeCount(kind)
+	var done uint64
+
+	for err == nil && it != nil && it.HasNext() {
+		var node data.Node
+
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			if node, err = gm.FetchNodePart(part, key, kind, attrs); err == nil && node != nil {
+
+				if rec, block := dedupRecordOf(node, rules); rec != nil {
+					blocks[block] = append(blocks[block], rec)
+				}
+			}
+		}
+
+		done++
+
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*DuplicateCandidate{}
+
+	for _, recs := range blocks {
+		for i := 0; i < len(recs); i++ {
+			for j := i + 1; j < len(recs); j++ {
+
+				if score, ok := dedupScore(recs[i], recs[j], rules); ok {
+					key1, key2 := recs[i].key, recs[j].key
+
+					if key2 < key1 {
+						key1, key2 = key2, key1
+					}
+
+					ret = append(ret, &DuplicateCandidate{key1, key2, score})
+				}
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Score != ret[j].Score {
+			return ret[i].Score > ret[j].Score
+		} else if ret[i].Key1 != ret[j].Key1 {
+			return ret[i].Key1 < ret[j].Key1
+		}
+		return ret[i].Key2 < ret[j].Key2
+	})
+
+	return ret, nil
+}
+
+/*
+dedupRecordOf returns the normalized rule values of a node and the block of the
+node. Returns nil if the node cannot match because it has no value for an exact
+rule.
+*/
+func dedupRecordOf(node data.Node, rules []*DedupRule) (*dedupRecord, string) {
+	var block []string
+
+	rec := &dedupRecord{node.Key(), make([]string, len(rules))}
+
+	for i, rule := range rules {
+		var val string
+
+		if v := node.Attr(rule.Attr); v != nil {
+			val = strings.ToLower(strings.TrimSpace(fmt.Sprint(v)))
+		}
+
+		if !rule.Fuzzy {
+			if val == "" {
+				return nil, ""
+			}
+			block = append(block, val)
+		}
+
+		rec.values[i] = val
+	}
+
+	return rec, strings.Join(block, "\x00")
+}
+
+/*
+dedupScore calculates the score of two records. Returns false if the records do
+not match all fuzzy rules (exact rules match since both records are in the same
+block).
+*/
+func dedupScore(rec1 *dedupRecord, rec2 *dedupRecord, rules []*DedupRule) (float64, bool) {
+	var score, weights float64
+
+	for i, rule := range rules {
+		sim := 1.0
+
+		weight := rule.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		if rule.Fuzzy {
+			threshold := rule.Threshold
+			if threshold == 0 {
+				threshold = DedupFuzzyThreshold
+			}
+
+			if rec1.values[i] == "" || rec2.values[i] == "" {
+				return 0, false
+			} else if sim = util.Similarity(rec1.values[i], rec2.values[i]); sim < threshold {
+				return 0, false
+			}
+		}
+
+		score += weight * sim
+		weights += weight
+	}
+
+	if weights == 0 {
+		return 1, true
+	}
+
+	return score / weights, true
+}
+
+/*
+StoreDuplicateEdges connects the nodes of all given duplicate candidates of a
+kind with duplicate edges. The score of a candidate is stored in the score
+attribute of its edge. Edges of candidates which were found before are updated.
+*/
+func (gm *Manager) StoreDuplicateEdges(part string, kind string, candidates []*DuplicateCandidate) error {
+
+	trans := NewGraphTrans(gm)
+
+	for _, c := range candidates {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, duplicateEdgeKey(c.Key1, c.Key2))
+		edge.SetAttr(data.NodeKind, DuplicateEdgeKind)
+
+		edge.SetAttr(data.EdgeEnd1Key, c.Key1)
+		edge.SetAttr(data.EdgeEnd1Kind, kind)
+		edge.SetAttr(data.EdgeEnd1Role, DuplicateEdgeRole)
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+		edge.SetAttr(data.EdgeEnd2Key, c.Key2)
+		edge.SetAttr(data.EdgeEnd2Kind, kind)
+		edge.SetAttr(data.EdgeEnd2Role, DuplicateEdgeRole)
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		edge.SetAttr("score", c.Score)
+
+		if err := trans.StoreEdge(part, edge); err != nil {
+			return err
+		}
+	}
+
+	return trans.Commit()
+}
+
+/*
+MergeNodes merges source nodes of a kind into a target node. Attributes which
+the target node does not have are copied from the source nodes (earlier source
+nodes take precedence). All edges of the source nodes are moved to the target
+node - duplicate edges between merged nodes are removed. The source nodes are
+removed afterwards. Returns the merged node.
+*/
+func (gm *Manager) MergeNodes(part string, kind string, target string, sources []string) (data.Node, error) {
+
+	fetch := func(key string) (data.Node, error) {
+		node, err := gm.FetchNode(part, key, kind)
+
+		if err == nil && node == nil {
+			err = &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprintf("Unknown node: %v (%v)", key, kind)}
+		}
+
+		return node, err
+	}
+
+	targetNode, err := fetch(target)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := data.CopyNode(targetNode)
+	mergedKeys := map[string]bool{target: true}
+
+	for _, source := range sources {
+		if mergedKeys[source] {
+			continue
+		}
+
+		sourceNode, err := fetch(source)
+		if err != nil {
+			return nil, err
+		}
+
+		for attr, val := range sourceNode.Data() {
+			if merged.Attr(attr) == nil {
+				merged.SetAttr(attr, val)
+			}
+		}
+
+		mergedKeys[source] = true
+	}
+
+	if err := gm.StoreNode(part, merged); err != nil {
+		return nil, err
+	}
+
+	for source := range mergedKeys {
+		if source == target {
+			continue
+		}
+
+		if err := gm.moveEdges(part, kind, source, target, mergedKeys); err != nil {
+			return nil, err
+		}
+
+		if _, err := gm.RemoveNode(part, source, kind); err != nil {
+			return nil, err
+		}
+	}
+
+	return merged, nil
+}
+
+/*
+moveEdges moves all edges of a source node to a target node. Ends which point
+to other merged nodes are moved as well.
+*/
+func (gm *Manager) moveEdges(part string, kind string, source string, target string,
+	mergedKeys map[string]bool) error {
+
+	_, edges, err := gm.TraverseMulti(part, source, kind, ":::", false)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range edges {
+
+		// Traversal results are relative to the source node - fetch the
+		// stored edge to get its actual ends
+
+		edge, err := gm.FetchEdge(part, e.Key(), e.Kind())
+		if err != nil {
+			return err
+		} else if edge == nil {
+			continue
+		}
+
+		// Endpoints of existing edges cannot be changed - the edge
+		// needs to be removed and stored again
+
+		if _, err := gm.RemoveEdge(part, edge.Key(), edge.Kind()); err != nil {
+			return err
+		}
+
+		newEdge := data.NewGraphEdgeFromNode(data.CopyNode(edge))
+
+		if edge.End1Kind() == kind && mergedKeys[edge.End1Key()] {
+			newEdge.SetAttr(data.EdgeEnd1Key, target)
+		}
+		if edge.End2Kind() == kind && mergedKeys[edge.End2Key()] {
+			newEdge.SetAttr(data.EdgeEnd2Key, target)
+		}
+
+		if edge.Kind() == DuplicateEdgeKind {
+			if stored, err := gm.normalizeDuplicateEdge(part, newEdge); err != nil {
+				return err
+			} else if stored {
+				continue
+			}
+		}
+
+		if err := gm.StoreEdge(part, newEdge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+normalizeDuplicateEdge gives a moved duplicate edge the key of the pair of nodes
+which it now connects. Returns true if the edge should not be stored because it
+connects a node with itself or because the nodes are already connected.
+*/
+func (gm *Manager) normalizeDuplicateEdge(part string, edge data.Edge) (bool, error) {
+
+	if edge.End1Key() == edge.End2Key() {
+		return true, nil
+	}
+
+	if edge.End2Key() < edge.End1Key() {
+		end1Key, end1Kind := edge.End1Key(), edge.End1Kind()
+
+		edge.SetAttr(data.EdgeEnd1Key, edge.End2Key())
+		edge.SetAttr(data.EdgeEnd1Kind, edge.End2Kind())
+		edge.SetAttr(data.EdgeEnd2Key, end1Key)
+		edge.SetAttr(data.EdgeEnd2Kind, end1Kind)
+	}
+
+	edge.SetAttr(data.NodeKey, duplicateEdgeKey(edge.End1Key(), edge.End2Key()))
+
+	existing, err := gm.FetchEdgePart(part, edge.Key(), edge.Kind(), []string{data.NodeKey})
+
+	return existing != nil, err
+}
+
+/*
+duplicateEdgeKey returns the key of the duplicate edge between two nodes.
+*/
+func duplicateEdgeKey(key1 string, key2 string) string {
+	return key1 + "#" + key2
+}
@@ -0,0 +1,186 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestDuplicates(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	storeNode := func(kind string, key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeEdge := func(key string, end1 string, end2 string) {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "Wrote")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "Author")
+		edge.SetAttr(data.EdgeEnd1Role, "Author")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "Song")
+		edge.SetAttr(data.EdgeEnd2Role, "Song")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("Author", "a1", map[string]interface{}{"name": "John Smith", "city": "London"})
+	storeNode("Author", "a2", map[string]interface{}{"name": "Jon Smith", "city": "london ", "age": 42})
+	storeNode("Author", "a3", map[string]interface{}{"name": "John Smith", "city": "Paris"})
+	storeNode("Author", "a4", map[string]interface{}{"name": "Mike Miller", "city": "London"})
+	storeNode("Author", "a5", map[string]interface{}{"name": "John Smith"})
+	storeNode("Song", "s1", map[string]interface{}{"name": "Aria"})
+	storeNode("Song", "s2", map[string]interface{}{"name": "Bolero"})
+
+	storeEdge("e1", "a1", "s1")
+	storeEdge("e2", "a2", "s2")
+
+	if _, err := gm.FindDuplicates("main", "Author", nil, nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Need at least one matching rule)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gm.FindDuplicates("main", "Author", []*DedupRule{{"key", false, 0, 0}}, nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid attribute for matching rule: 'key')" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	var progress []string
+
+	rules := []*DedupRule{{"city", false, 0, 0}, {"name", true, 0, 2}}
+
+	res, err := gm.FindDuplicates("main", "Author", rules, func(done uint64, total uint64) {
+		progress = append(progress, fmt.Sprintf("%v/%v", done, total))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if out, _ := json.Marshal(res); string(out) != `[{"key1":"a1","key2":"a2","score":0.9333333333333332}]` {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	if fmt.Sprint(progress) != "[1/5 2/5 3/5 4/5 5/5]" {
+		t.Error("Unexpected result:", progress)
+		return
+	}
+
+	// Only fuzzy rules compare all nodes with each other
+
+	res, err = gm.FindDuplicates("main", "Author", []*DedupRule{{"name", true, 0.85, 0}}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if out, _ := json.Marshal(res); string(out) != `[{"key1":"a1","key2":"a3","score":1},`+
+		`{"key1":"a1","key2":"a5","score":1},{"key1":"a3","key2":"a5","score":1},`+
+		`{"key1":"a1","key2":"a2","score":0.9},{"key1":"a2","key2":"a3","score":0.9},`+
+		`{"key1":"a2","key2":"a5","score":0.9}]` {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	if err := gm.StoreDuplicateEdges("main", "Author", res); err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodes, edges, _ := gm.TraverseMulti("main", "a1", "Author", ":PossibleDuplicate::", true)
+
+	if len(nodes) != 3 || len(edges) != 3 || edges[0].Attr("score") == nil {
+		t.Error("Unexpected result:", nodes, edges)
+		return
+	}
+
+	if _, err := gm.MergeNodes("main", "Author", "a1", []string{"a9"}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown node: a9 (Author))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	merged, err := gm.MergeNodes("main", "Author", "a1", []string{"a2", "a1", "a3"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := merged.Data(); fmt.Sprint(res) != "map[age:42 city:London key:a1 kind:Author name:John Smith]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for _, key := range []string{"a2", "a3"} {
+		if node, _ := gm.FetchNode("main", key, "Author"); node != nil {
+			t.Error("Node should have been removed:", node)
+			return
+		}
+	}
+
+	// The songs of the merged authors belong to the target and the duplicate
+	// edges between merged nodes are gone
+
+	nodes, _, _ = gm.TraverseMulti("main", "a1", "Author", ":Wrote::", false)
+
+	var keys []string
+	for _, n := range nodes {
+		keys = append(keys, n.Key())
+	}
+	sort.Strings(keys)
+
+	if fmt.Sprint(keys) != "[s1 s2]" {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	nodes, edges, _ = gm.TraverseMulti("main", "a1", "Author", ":PossibleDuplicate::", false)
+
+	if len(nodes) != 1 || nodes[0].Key() != "a5" || len(edges) != 1 {
+		t.Error("Unexpected result:", nodes, edges)
+		return
+	}
+
+	nodes, _, _ = gm.TraverseMulti("main", "a5", "Author", ":PossibleDuplicate::", false)
+
+	if len(nodes) != 1 || nodes[0].Key() != "a1" {
+		t.Error("Unexpected result:", nodes)
+		return
+	}
+
+	if gm.EdgeCount(DuplicateEdgeKind) != 1 {
+		t.Error("Unexpected result:", gm.EdgeCount(DuplicateEdgeKind))
+		return
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+RenameEdgeRole renames an edge role of all edges of a given kind in all
+partitions. Both end1 and end2 roles are renamed. Edges are rewritten one by
+one so the index and the traversal information of the endpoints stay
+consistent. Once all edges have been renamed the old role is kept as an alias
+of the new role so existing traversal specs continue to work. An optional
+progress function is called after each checked edge. Returns the number of
+renamed edges.
+*/
+func (gm *Manager) RenameEdgeRole(kind string, oldRole string, newRole string,
+	progress IndexProgress) (uint64, error) {
+
+	var renamed uint64
+
+	if kind == "" || oldRole == "" || newRole == "" {
+		return 0, &util.GraphError{Type: util.ErrInvalidData, Detail: "Role rename needs a kind, an old and a new role"}
+	} else if !stringutil.IsAlphaNumeric(newRole) {
+		return 0, &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Edge role %v is not alphanumeric - can only contain [a-zA-Z0-9_]", newRole),
+		}
+	} else if oldRole == newRole {
+		return 0, nil
+	}
+
+	// Collect the keys of all edges first so the progress can be reported
+
+	keys := make(map[string][]string)
+	var total uint64
+
+	for _, part := range gm.Partitions() {
+
+		edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+		if err != nil {
+			return 0, err
+		} else if edgeht == nil {
+			continue
+		}
+
+		unlock := gm.readLock(part)
+		keys[part], err = edgeKeys(edgeht)
+		unlock()
+
+		if err != nil {
+			return 0, err
+		}
+
+		total += uint64(len(keys[part]))
+	}
+
+	var done uint64
+
+	for part, partKeys := range keys {
+		for _, key := range partKeys {
+
+			edge, err := gm.FetchEdge(part, key, kind)
+			if err != nil {
+				return renamed, err
+			}
+
+			if edge != nil && (edge.End1Role() == oldRole || edge.End2Role() == oldRole) {
+
+				// Endpoints of existing edges cannot be changed - the edge
+				// needs to be removed and stored again
+
+				newEdge := data.NewGraphEdgeFromNode(data.CopyNode(edge))
+
+				if edge.End1Role() == oldRole {
+					newEdge.SetAttr(data.EdgeEnd1Role, newRole)
+				}
+				if edge.End2Role() == oldRole {
+					newEdge.SetAttr(data.EdgeEnd2Role, newRole)
+				}
+
+				if _, err := gm.RemoveEdge(part, key, kind); err != nil {
+					return renamed, err
+				} else if err := gm.StoreEdge(part, newEdge); err != nil {
+					return renamed, err
+				}
+
+				renamed++
+			}
+
+			done++
+
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.renameEdgeSpecs(kind, oldRole, newRole)
+	gm.addEdgeRoleAlias(kind, oldRole, newRole)
+
+	return renamed, gm.flushMain()
+}
+
+/*
+EdgeRoles returns a sorted list of all roles which are used by edges of a
+given kind.
+*/
+func (gm *Manager) EdgeRoles(kind string) []string {
+	var ret []string
+
+	roles := make(map[string]bool)
+
+	for _, nkind := range gm.NodeKinds() {
+		for _, spec := range gm.NodeEdges(nkind) {
+
+			if sspec := strings.Split(spec, ":"); len(sspec) == 4 && sspec[1] == kind {
+				roles[sspec[0]] = true
+				roles[sspec[2]] = true
+			}
+		}
+	}
+
+	for role := range roles {
+		ret = append(ret, role)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+EdgeRoleAliases returns all renamed roles of a given edge kind as a map of
+old role to new role.
+*/
+func (gm *Manager) EdgeRoleAliases(kind string) map[string]string {
+	ret := make(map[string]string)
+
+	for k, v := range gm.getMainDBMap(MainDBEdgeRoleAliases + kind) {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+/*
+resolveSpec replaces renamed roles in a traversal spec with their new names.
+*/
+func (gm *Manager) resolveSpec(spec string) string {
+
+	sspec := strings.Split(spec, ":")
+	if len(sspec) != 4 || sspec[1] == "" {
+		return spec
+	}
+
+	aliases := gm.getMainDBMap(MainDBEdgeRoleAliases + sspec[1])
+	if aliases == nil {
+		return spec
+	}
+
+	for _, i := range []int{0, 2} {
+		if role, ok := aliases[sspec[i]]; ok {
+			sspec[i] = role
+		}
+	}
+
+	return strings.Join(sspec, ":")
+}
+
+/*
+renameEdgeSpecs renames a role in the known edge specs of all node kinds.
+*/
+func (gm *Manager) renameEdgeSpecs(kind string, oldRole string, newRole string) {
+
+	for _, nkind := range gm.NodeKinds() {
+		var changed bool
+
+		specs := make(map[string]string)
+
+		for spec, v := range gm.getMainDBMap(MainDBNodeEdges + nkind) {
+
+			if sspec := strings.Split(spec, ":"); len(sspec) == 4 && sspec[1] == kind {
+				for _, i := range []int{0, 2} {
+					if sspec[i] == oldRole {
+						sspec[i] = newRole
+						changed = true
+					}
+				}
+				spec = strings.Join(sspec, ":")
+			}
+
+			specs[spec] = v
+		}
+
+		if changed {
+			gm.storeMainDBMap(MainDBNodeEdges+nkind, specs)
+		}
+	}
+}
+
+/*
+addEdgeRoleAlias records that a role of an edge kind has been renamed. Aliases
+which pointed to the old role are updated and an alias for the new role is
+removed since the new role is now in use.
+*/
+func (gm *Manager) addEdgeRoleAlias(kind string, oldRole string, newRole string) {
+	aliases := make(map[string]string)
+
+	for k, v := range gm.getMainDBMap(MainDBEdgeRoleAliases + kind) {
+		if v == oldRole {
+			v = newRole
+		}
+		aliases[k] = v
+	}
+
+	aliases[oldRole] = newRole
+	delete(aliases, newRole)
+
+	gm.storeMainDBMap(MainDBEdgeRoleAliases+kind, aliases)
+}
+
+/*
+removeEdgeRoleAlias removes the alias of a role of an edge kind. This is
+necessary once the old role is used again by a new edge.
+*/
+func (gm *Manager) removeEdgeRoleAlias(kind string, role string) {
+	var empty bool
+
+	if _, ok := gm.getMainDBMap(MainDBEdgeRoleAliases + kind)[role]; !ok {
+		return
+	}
+
+	gm.updateMainDBMap(MainDBEdgeRoleAliases+kind, func(aliases map[string]string) bool {
+
+		if _, ok := aliases[role]; !ok {
+			return false
+		}
+
+		delete(aliases, role)
+		empty = len(aliases) == 0
+
+		return true
+	})
+
+	if empty {
+		gm.removeMainDBValue(MainDBEdgeRoleAliases + kind)
+	}
+}
+
+/*
+edgeKeys returns the keys of all edges in an edge storage HTree.
+*/
+func edgeKeys(edgeht *hash.HTree) ([]string, error) {
+	var keys []string
+
+	it := hash.NewHTreeIterator(edgeht)
+	for it.HasNext() {
+		if k, _ := it.Next(); strings.HasPrefix(string(k), PrefixNSAttrs) {
+			keys = append(keys, string(k[len(PrefixNSAttrs):]))
+		}
+	}
+
+	if it.LastError != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	return keys, nil
+}
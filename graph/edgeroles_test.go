@@ -0,0 +1,177 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestRenameEdgeRole(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(part string, key string) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "mynode")
+
+		if err := gm.StoreNode(part, node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeEdge := func(part string, key string, end1 string, role1 string, end2 string, role2 string) {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "myedge")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd1Role, role1)
+		edge.SetAttr(data.EdgeEnd1Cascading, true)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd2Role, role2)
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		edge.SetAttr("name", "edge"+key)
+
+		if err := gm.StoreEdge(part, edge); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("main", "1")
+	storeNode("main", "2")
+	storeNode("main", "3")
+	storeNode("other", "1")
+	storeNode("other", "2")
+
+	storeEdge("main", "a", "1", "parent", "2", "child")
+	storeEdge("main", "b", "1", "parent", "3", "child")
+	storeEdge("main", "c", "2", "friend", "3", "friend")
+	storeEdge("other", "a", "1", "parent", "2", "child")
+
+	if res := gm.EdgeRoles("myedge"); fmt.Sprint(res) != "[child friend parent]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := gm.RenameEdgeRole("myedge", "parent", "my-parent", nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Edge role my-parent is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	var progress []string
+
+	renamed, err := gm.RenameEdgeRole("myedge", "parent", "owner", func(done uint64, total uint64) {
+		progress = append(progress, fmt.Sprint(done, "/", total))
+	})
+
+	if err != nil || renamed != 3 {
+		t.Error("Unexpected result:", renamed, err)
+		return
+	}
+
+	if fmt.Sprint(progress) != "[1/4 2/4 3/4 4/4]" {
+		t.Error("Unexpected result:", progress)
+		return
+	}
+
+	if res := gm.EdgeRoles("myedge"); fmt.Sprint(res) != "[child friend owner]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.NodeEdges("mynode"); fmt.Sprint(res) != "[child:myedge:owner:mynode friend:myedge:friend:mynode owner:myedge:child:mynode]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.EdgeRoleAliases("myedge"); fmt.Sprint(res) != "map[parent:owner]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Edge data, counts and the index are preserved
+
+	if res := gm.EdgeCount("myedge"); res != 4 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	edge, err := gm.FetchEdge("main", "b", "myedge")
+	if err != nil || edge.End1Role() != "owner" || edge.End2Role() != "child" || edge.Attr("name") != "edgeb" {
+		t.Error("Unexpected result:", edge, err)
+		return
+	}
+
+	if report, err := gm.VerifyEdgeIndex("main", "myedge", nil); err != nil || !report.Consistent() || report.Items != 3 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Old and new roles can both be used in traversal specs
+
+	for _, spec := range []string{"owner:myedge:child:mynode", "parent:myedge:child:mynode", "parent:myedge::"} {
+		nodes, _, err := gm.TraverseMulti("main", "1", "mynode", spec, false)
+		if err != nil || len(nodes) != 2 {
+			t.Error("Unexpected result:", spec, nodes, err)
+			return
+		}
+	}
+
+	// Renaming again updates the alias
+
+	if renamed, err := gm.RenameEdgeRole("myedge", "owner", "boss", nil); err != nil || renamed != 3 {
+		t.Error("Unexpected result:", renamed, err)
+		return
+	}
+
+	if res := gm.EdgeRoleAliases("myedge"); fmt.Sprint(res) != "map[owner:boss parent:boss]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if nodes, _, err := gm.Traverse("other", "1", "mynode", "parent:myedge:child:mynode", false); err != nil || len(nodes) != 1 {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Using an old role again removes its alias
+
+	storeEdge("main", "d", "2", "parent", "3", "child")
+
+	if res := gm.EdgeRoleAliases("myedge"); fmt.Sprint(res) != "map[owner:boss]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if nodes, _, err := gm.Traverse("main", "2", "mynode", "parent:myedge:child:mynode", false); err != nil ||
+		fmt.Sprint(nodes) != "[GraphNode:\n     key : 3\n    kind : mynode\n]" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if renamed, err := gm.RenameEdgeRole("myedge", "friend", "friend", nil); err != nil || renamed != 0 {
+		t.Error("Unexpected result:", renamed, err)
+		return
+	}
+
+	if _, err := gm.RenameEdgeRole("myedge", "", "friend", nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Role rename needs a kind, an old and a new role)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -23,7 +23,16 @@ Node iterator
 
 All available node keys in a partition of a given kind can be iterated by using
 a NodeKeyIterator. The manager can produce these with the NodeKeyIterator()
-function.
+function. Iterators from the NodeKeySnapshotIterator() function iterate over a
+stable snapshot of the keys which is not affected by concurrent writes.
+
+Snapshots
+
+Readers which need a consistent view of the data can take a snapshot with the
+Snapshot() function. It returns a read-only manager which sees the data as it
+was when the snapshot was taken. Readers of a snapshot neither observe changes
+of concurrent writers nor wait for them. Snapshots should be released with
+Release() as writers keep old versions of changed data for every open snapshot.
 
 Fulltext search
 
@@ -31,6 +40,14 @@ All nodes and edges in the datastore are indexed. The index can be queried
 using a IndexQuery object. The manager can produce these with the NodeIndexQuery()
 or EdgeIndexQuery function.
 
+The way words are extracted from attribute values can be configured for each
+attribute of a node or edge kind with a text analyzer (tokenizer, lowercasing,
+stemming, stop words and n-grams). Analyzers are set with SetAnalyzer() and
+applied to existing data with ReindexNodes() or ReindexEdges(). These functions
+also rebuild corrupted indexes from the stored data. VerifyNodeIndex() and
+VerifyEdgeIndex() check an index without changing it. Attributes or whole kinds
+can be excluded from the index with SetIndexed().
+
 Transactions
 
 A transaction is used to build up multiple store and delete tasks for the
@@ -38,8 +55,52 @@ graph database. Nothing is written to the database before calling commit().
 A transaction commit does an automatic rollback if an error occurs
 (except fatal disk write errors which might cause a panic).
 
+Transaction commits are serialized by a single writer lock of the graph.
+Write operations on a single node or edge only lock their partition so writes
+to different partitions run in parallel. This requires that all graph rules
+only work on the partition of an event (see PartitionRule) - otherwise single
+writes take the writer lock of the graph as well. A commit is atomic and
+isolated - readers either see all changes of a transaction or none. Committed changes are written to the transaction log of
+the storage which is synced on every flush. Use FlushAndSync() as a commit
+barrier before triggering external side effects which depend on the
+durability of previous commits.
+
+With group commit (see DiskGraphStorage.SetGroupCommit) flushes are not synced
+individually. Instead every write operation or commit waits after releasing the
+writer lock until a single disk sync has made all changes of concurrent commits
+durable. This trades a small latency for a much higher write throughput.
+
+The durability mode of a disk storage (see DiskGraphStorage.SetDurability) can
+relax durability further. Commits are then only synced periodically or when the
+operating system decides to. This speeds up bulk loads considerably - a final
+FlushAndSync() makes all previous commits durable.
+
 A trans object can be created with the NewGraphTrans() function.
 
+Savepoints mark the state of a transaction before it is committed. A call to
+RollbackTo() discards only the changes which were added after a savepoint so a
+bulk import can skip a failed chunk of work and continue with the same
+transaction.
+
+The size of single transactions can be limited with SetTransLimits(). An
+operation which would exceed a limit is rejected with an util.ErrTransLimit
+error. Large imports can use a chunked transaction which is created with the
+NewChunkedTrans() function. It commits itself every n nodes and edges or once
+the estimated size of the current chunk reaches a given number of bytes.
+
+Read-modify-write operations can use an optimistic transaction which is created
+with the NewOptimisticGraphTrans() function. It records the versions of all
+nodes and edges which are fetched through it. The commit fails with a
+util.ConflictError and the transaction is discarded if any of them has been
+modified concurrently.
+
+Pessimistic read-modify-write operations can use a locking transaction which is
+created with the NewLockingGraphTrans() function. It locks all nodes and edges
+which it accesses through the LockManager of the graph manager until it is
+committed or rolled back. The lock manager detects wait cycles between
+transactions - the transaction whose lock request would close a cycle fails
+with an util.ErrDeadlock error and is rolled back.
+
 Rules
 
 (Use with caution)
@@ -83,6 +144,12 @@ Each node kind database stores:
 	PrefixNSEdge + node key + spec -> map[edge key]edgeinfo{other node key, other node kind}]
 	(connection from one node to another via a spec)
 
+	PrefixNSTimeline + node key + edge kind -> [ bucket ]
+	(a sorted list of time buckets which contain timestamped edges)
+
+	PrefixNSTimelineBucket + node key + edge kind + bucket -> [ timelineEntry ]
+	(timestamped edges of a node within a time bucket ordered by time)
+
 Edges database
 
 Each edge kind database stores:
@@ -93,6 +160,13 @@ Each edge kind database stores:
 	PrefixNSAttr + edge key + attr num -> value
 	(attribute value of a certain edge)
 
+Sequence database
+
+Each partition has a sequence database which stores:
+
+	n|e + # + kind + # + key -> sequence number
+	(commit sequence number of the last write of a certain node or edge)
+
 Index database
 
 The text index managed by util/indexmanager.go. IndexQuery provides access to
@@ -105,7 +179,13 @@ import "errors"
 /*
 VERSION of the GraphManager
 */
-const VERSION = 1
+const VERSION = 2
+
+/*
+VersionWordDictionaries is the first version whose full-text indexes contain
+word dictionaries
+*/
+const VersionWordDictionaries = 2
 
 /*
 MainDBEntryPrefix is the prefix for entries stored in the main database
@@ -120,6 +200,12 @@ MainDBVersion is the MainDB entry key for version information
 */
 const MainDBVersion = MainDBEntryPrefix + "ver"
 
+/*
+MainDBIndexUpgrade is the MainDB entry key which marks that the indexes were
+created by an older version and need to be rebuilt
+*/
+const MainDBIndexUpgrade = MainDBEntryPrefix + "idxupgrade"
+
 /*
 MainDBNodeKinds is the MainDB entry key for node kind information
 */
@@ -160,6 +246,37 @@ MainDBEdgeCount is the MainDB entry key for an edge count
 */
 const MainDBEdgeCount = MainDBEntryPrefix + "ecnt"
 
+/*
+MainDBAnalyzers is the MainDB entry key for the text analyzers of a kind
+*/
+const MainDBAnalyzers = MainDBEntryPrefix + "anlz"
+
+/*
+MainDBUnindexed is the MainDB entry key for the attributes of a kind which are
+not indexed
+*/
+const MainDBUnindexed = MainDBEntryPrefix + "unix"
+
+/*
+MainDBEdgeRoleAliases is the MainDB entry key for renamed roles of an edge kind
+*/
+const MainDBEdgeRoleAliases = MainDBEntryPrefix + "eral"
+
+/*
+MainDBPartSeq is the prefix for the commit sequence number of a partition
+*/
+const MainDBPartSeq = MainDBEntryPrefix + "pseq"
+
+/*
+MainDBKindStats is the prefix for the statistics of a node kind in a partition
+*/
+const MainDBKindStats = MainDBEntryPrefix + "kstat"
+
+/*
+MainDBKeySeq is the prefix for the key sequence number of a kind in a partition
+*/
+const MainDBKeySeq = MainDBEntryPrefix + "kseq"
+
 // Root IDs for StorageManagers
 // ============================
 
@@ -196,6 +313,16 @@ StorageSuffixEdgesIndex is the suffix for an edge index
 */
 const StorageSuffixEdgesIndex = ".edgeidx"
 
+/*
+StorageSuffixBlobs is the suffix for the blob storage of a partition
+*/
+const StorageSuffixBlobs = ".blobs"
+
+/*
+StorageSuffixSeq is the suffix for the sequence number storage of a partition
+*/
+const StorageSuffixSeq = ".seq"
+
 // PREFIXES for Node storage
 // =========================
 
@@ -223,6 +350,18 @@ PrefixNSEdge is the prefix for storing a link from a node (and a spec) to an edg
 */
 const PrefixNSEdge = "\x04"
 
+/*
+PrefixNSTimeline is the prefix for storing the time buckets of timestamped
+edges of a node (and an edge kind)
+*/
+const PrefixNSTimeline = "\x05"
+
+/*
+PrefixNSTimelineBucket is the prefix for storing a time bucket of timestamped
+edges of a node (and an edge kind)
+*/
+const PrefixNSTimelineBucket = "\x06"
+
 // Graph events
 //=============
 
@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
@@ -30,7 +31,14 @@ type Manager struct {
 	nm           *util.NamesManager           // Manager object which manages name encodings
 	mapCache     map[string]map[string]string // Cache which caches maps stored in the main database
 	mutex        *sync.RWMutex                // Mutex to protect atomic graph operations
+	partLocks    *partitionLocks              // Locks to protect operations on single partitions
+	lockManager  *LockManager                 // Manager for record locks of locking transactions
+	transLimits  *transLimits                 // Size limits of single transactions
+	keyGen       *keyGeneration               // Key generation for nodes and edges without a key
+	remote       *remoteNodes                 // Nodes which are stored by other shards
 	storageMutex *sync.Mutex                  // Special mutex for storage object access
+	mainMutex    *sync.Mutex                  // Mutex to protect the main database
+	mvcc         *mvccRegistry                // Registry for snapshots (nil for snapshots)
 }
 
 /*
@@ -41,6 +49,7 @@ func NewGraphManager(gs graphstorage.Storage) *Manager {
 
 	gm.SetGraphRule(&SystemRuleDeleteNodeEdges{})
 	gm.SetGraphRule(&SystemRuleUpdateNodeStats{})
+	gm.SetGraphRule(&SystemRuleDeleteNodeBlobs{})
 
 	return gm
 }
@@ -68,16 +77,25 @@ func createGraphManager(gs graphstorage.Storage) *Manager {
 
 		} else if v < VERSION {
 
-			// Update the version if it is older
+			// Update the version if it is older - indexes without word
+			// dictionaries must be rebuilt (see UpgradeIndexes)
+
+			if v < VersionWordDictionaries {
+				mdb[MainDBIndexUpgrade] = version
+			}
 
 			mdb[MainDBVersion] = strconv.Itoa(VERSION)
 			gs.FlushMain()
 		}
 	}
 
+	mainMutex := &sync.Mutex{}
+
 	gm := &Manager{gs, &graphRulesManager{nil, make(map[string]Rule),
-		make(map[int]map[string]Rule)}, util.NewNamesManager(mdb),
-		make(map[string]map[string]string), &sync.RWMutex{}, &sync.Mutex{}}
+		make(map[int]map[string]Rule)}, util.NewSharedNamesManager(mdb, mainMutex),
+		make(map[string]map[string]string), &sync.RWMutex{}, newPartitionLocks(),
+		NewLockManager(), &transLimits{}, &keyGeneration{}, &remoteNodes{}, &sync.Mutex{}, mainMutex,
+		newMVCCRegistry()}
 
 	gm.gr.gm = gm
 
@@ -105,6 +123,105 @@ func (gm *Manager) GraphRules() []string {
 	return gm.gr.GraphRules()
 }
 
+/*
+LockManager returns the lock manager which holds the record locks of locking
+transactions.
+*/
+func (gm *Manager) LockManager() *LockManager {
+	return gm.lockManager
+}
+
+/*
+FlushAndSync is a commit barrier. It waits for all running write operations and
+transaction commits to finish and makes sure that all prior commits are durable
+on disk when it returns. Storages which cannot guarantee durability are flushed.
+*/
+func (gm *Manager) FlushAndSync() error {
+
+	// Take the writer lock so no commit can run while syncing
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if s, ok := gm.gs.(graphstorage.Sync); ok {
+		return s.Sync()
+	}
+
+	return gm.gs.FlushAll()
+}
+
+/*
+Compact reclaims the space of deleted and updated data if the graph storage
+supports it. The graph storage stays online during the compaction - data is
+copied in batches of the given size with the given pause between batches.
+*/
+func (gm *Manager) Compact(batchSize int, pause time.Duration) error {
+
+	if c, ok := gm.gs.(graphstorage.Compactor); ok {
+		return c.Compact(batchSize, pause)
+	}
+
+	return &util.GraphError{Type: util.ErrInvalidData,
+		Detail: "Graph storage does not support compaction"}
+}
+
+/*
+Reencrypt rewrites all data which is not encrypted with the active key if the
+graph storage supports encryption. Returns the number of rewritten records.
+*/
+func (gm *Manager) Reencrypt(batchSize int, pause time.Duration) (int, error) {
+
+	if r, ok := gm.gs.(graphstorage.Reencrypter); ok {
+		return r.Reencrypt(batchSize, pause)
+	}
+
+	return 0, &util.GraphError{Type: util.ErrInvalidData,
+		Detail: "Graph storage does not support encryption"}
+}
+
+/*
+ReencryptPartition rewrites all data of a given partition which is not
+encrypted with the active key of the partition if the graph storage supports
+partition keys. Returns the number of rewritten records.
+*/
+func (gm *Manager) ReencryptPartition(part string, batchSize int, pause time.Duration) (int, error) {
+
+	if r, ok := gm.gs.(graphstorage.PartitionReencrypter); ok {
+		return r.ReencryptPartition(part, batchSize, pause)
+	}
+
+	return 0, &util.GraphError{Type: util.ErrInvalidData,
+		Detail: "Graph storage does not support partition encryption"}
+}
+
+/*
+CompactionStatus returns the status of the last or running compaction. Returns
+nil if the graph storage does not support compaction or if there was no
+compaction.
+*/
+func (gm *Manager) CompactionStatus() *graphstorage.CompactionStatus {
+
+	if c, ok := gm.gs.(graphstorage.Compactor); ok {
+		return c.CompactionStatus()
+	}
+
+	return nil
+}
+
+/*
+waitDurable waits until all flushed changes are durable if the storage
+coalesces the disk syncs of concurrent commits (group commit). Must not be
+called while holding the writer lock.
+*/
+func (gm *Manager) waitDurable() error {
+
+	if gc, ok := gm.gs.(graphstorage.GroupCommitter); ok {
+		return gc.WaitDurable()
+	}
+
+	return nil
+}
+
 /*
 NodeIndexQuery returns an object to query the full text search index for nodes.
 */
@@ -114,7 +231,7 @@ func (gm *Manager) NodeIndexQuery(part string, kind string) (IndexQuery, error)
 		return nil, err
 	}
 
-	return util.NewIndexManager(iht), nil
+	return gm.newIndexManager(iht, kind), nil
 }
 
 /*
@@ -126,7 +243,7 @@ func (gm *Manager) EdgeIndexQuery(part string, kind string) (IndexQuery, error)
 		return nil, err
 	}
 
-	return util.NewIndexManager(iht), nil
+	return gm.newIndexManager(iht, kind), nil
 }
 
 /*
@@ -0,0 +1,353 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+BlobChunkSize is the size of the chunks in which blobs are stored.
+*/
+var BlobChunkSize = 64 * 1024
+
+/*
+blobHeader is the storage object which lists the chunks of a blob.
+*/
+type blobHeader struct {
+	ChunkSize int64    // Size of each chunk (the last chunk may be smaller)
+	Chunks    []uint64 // Storage locations of the chunks
+}
+
+/*
+StoreBlob reads a blob from a given reader and stores it as an attribute of an
+existing node. The node attribute holds a *data.BlobRef value while the binary
+data is kept in the blob storage of the partition. A blob which was previously
+stored in the attribute is removed.
+*/
+func (gm *Manager) StoreBlob(part string, key string, kind string, attr string,
+	contentType string, r io.Reader) (*data.BlobRef, error) {
+
+	sm, err := gm.getBlobStorage(part, key, kind, true)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &blobHeader{ChunkSize: int64(BlobChunkSize)}
+	ref := &data.BlobRef{ContentType: contentType}
+
+	// Cleanup function in case of an error
+
+	cleanup := func(err error) (*data.BlobRef, error) {
+		for _, loc := range header.Chunks {
+			sm.Free(loc)
+		}
+		return nil, err
+	}
+
+	// Write the blob in chunks
+
+	for {
+		buf := make([]byte, BlobChunkSize)
+
+		n, err := io.ReadFull(r, buf)
+
+		if n > 0 {
+			loc, ierr := sm.Insert(buf[:n])
+			if ierr != nil {
+				return cleanup(&util.GraphError{Type: util.ErrWriting, Detail: ierr.Error()})
+			}
+
+			header.Chunks = append(header.Chunks, loc)
+			ref.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return cleanup(&util.GraphError{Type: util.ErrReading, Detail: err.Error()})
+		}
+	}
+
+	if ref.Loc, err = sm.Insert(header); err != nil {
+		return cleanup(&util.GraphError{Type: util.ErrWriting, Detail: err.Error()})
+	}
+
+	if err = sm.Flush(); err != nil {
+		return nil, &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	// Update the node - a previously stored blob is removed by the
+	// system.deletenodeblobs rule
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, key)
+	node.SetAttr(data.NodeKind, kind)
+	node.SetAttr(attr, ref)
+
+	if err = gm.UpdateNode(part, node); err != nil {
+		gm.freeBlob(sm, ref)
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+/*
+OpenBlob returns a reader for a blob which is stored in an attribute of a
+node. Returns nil if the node or the attribute does not exist or if the
+attribute does not contain a blob.
+*/
+func (gm *Manager) OpenBlob(part string, key string, kind string, attr string) (*BlobReader, error) {
+
+	node, err := gm.FetchNodePart(part, key, kind, []string{attr})
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	ref, ok := node.Attr(attr).(*data.BlobRef)
+	if !ok {
+		return nil, nil
+	}
+
+	sm := gm.storageManager(part, StorageSuffixBlobs, false)
+	if sm == nil {
+		return nil, &util.GraphError{Type: util.ErrReading,
+			Detail: fmt.Sprintf("Blob storage of partition %v does not exist", part)}
+	}
+
+	header := &blobHeader{}
+
+	if err := sm.Fetch(ref.Loc, header); err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	return &BlobReader{ref, sm, header, 0, -1, nil}, nil
+}
+
+/*
+RemoveBlob removes a blob which is stored in an attribute of a node. The
+attribute is removed from the node. Returns the reference of the removed
+blob or nil if the attribute did not contain a blob.
+*/
+func (gm *Manager) RemoveBlob(part string, key string, kind string, attr string) (*data.BlobRef, error) {
+
+	node, err := gm.FetchNode(part, key, kind)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	ref, ok := node.Attr(attr).(*data.BlobRef)
+	if !ok {
+		return nil, nil
+	}
+
+	// Store the node without the attribute - the blob itself is removed
+	// by the system.deletenodeblobs rule
+
+	node.SetAttr(attr, nil)
+
+	return ref, gm.StoreNode(part, node)
+}
+
+/*
+getBlobStorage returns the blob storage of a partition after checking that
+a given node exists.
+*/
+func (gm *Manager) getBlobStorage(part string, key string, kind string, create bool) (storage.Manager, error) {
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	node, err := gm.FetchNodePart(part, key, kind, []string{data.NodeKey})
+	if err != nil {
+		return nil, err
+	} else if node == nil {
+		return nil, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprintf("Node %v of kind %v does not exist in partition %v", key, kind, part)}
+	}
+
+	sm := gm.storageManager(part, StorageSuffixBlobs, create)
+	if sm == nil {
+		return nil, &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: fmt.Sprintf("Blob storage of partition %v", part)}
+	}
+
+	return sm, nil
+}
+
+/*
+freeBlob frees the storage of a blob.
+*/
+func (gm *Manager) freeBlob(sm storage.Manager, ref *data.BlobRef) error {
+	header := &blobHeader{}
+
+	if err := sm.Fetch(ref.Loc, header); err != nil {
+		return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	for _, loc := range header.Chunks {
+		if err := sm.Free(loc); err != nil {
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	if err := sm.Free(ref.Loc); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return sm.Flush()
+}
+
+/*
+BlobReader reads a stored blob. It implements io.ReadSeeker so it can be used
+to serve range requests (e.g. with http.ServeContent).
+*/
+type BlobReader struct {
+	Ref      *data.BlobRef   // Reference of the blob
+	sm       storage.Manager // Blob storage
+	header   *blobHeader     // Header of the blob
+	pos      int64           // Current read position
+	chunkIdx int             // Index of the currently loaded chunk
+	chunk    []byte          // Currently loaded chunk
+}
+
+/*
+Read reads up to len(p) bytes of the blob into p.
+*/
+func (br *BlobReader) Read(p []byte) (int, error) {
+
+	if br.pos >= br.Ref.Size {
+		return 0, io.EOF
+	}
+
+	idx := int(br.pos / br.header.ChunkSize)
+
+	if idx != br.chunkIdx {
+		var chunk []byte
+
+		if err := br.sm.Fetch(br.header.Chunks[idx], &chunk); err != nil {
+			return 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		br.chunk = chunk
+		br.chunkIdx = idx
+	}
+
+	n := copy(p, br.chunk[br.pos-int64(idx)*br.header.ChunkSize:])
+	br.pos += int64(n)
+
+	return n, nil
+}
+
+/*
+Seek sets the offset for the next Read.
+*/
+func (br *BlobReader) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += br.pos
+	case io.SeekEnd:
+		offset += br.Ref.Size
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+
+	br.pos = offset
+
+	return offset, nil
+}
+
+// System rule SystemRuleDeleteNodeBlobs
+// =====================================
+
+/*
+SystemRuleDeleteNodeBlobs is a system rule to delete blobs from the blob
+storage once they are no longer referenced by a node.
+*/
+type SystemRuleDeleteNodeBlobs struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleDeleteNodeBlobs) Name() string {
+	return "system.deletenodeblobs"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleDeleteNodeBlobs) Handles() []int {
+	return []int{EventNodeUpdated, EventNodeDeleted}
+}
+
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+*/
+func (r *SystemRuleDeleteNodeBlobs) PartitionOnly() bool {
+	return true
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleDeleteNodeBlobs) Handle(gm *Manager, trans Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+	node := ed[1].(data.Node)
+	oldnode := node
+
+	if event == EventNodeUpdated {
+		oldnode = ed[2].(data.Node)
+	}
+
+	var sm storage.Manager
+
+	for attr, val := range oldnode.Data() {
+		ref, ok := val.(*data.BlobRef)
+		if !ok {
+			continue
+		}
+
+		// Check if the blob is still referenced
+
+		if event == EventNodeUpdated {
+			if nref, ok := node.Attr(attr).(*data.BlobRef); ok && nref.Loc == ref.Loc {
+				continue
+			}
+		}
+
+		if sm == nil {
+			if sm = gm.storageManager(part, StorageSuffixBlobs, false); sm == nil {
+				return nil
+			}
+		}
+
+		if err := gm.freeBlob(sm, ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestBlobs(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	oldChunkSize := BlobChunkSize
+	BlobChunkSize = 4
+	defer func() {
+		BlobChunkSize = oldChunkSize
+	}()
+
+	if _, err := gm.StoreBlob("main", "123", "mynode", "image", "image/png",
+		bytes.NewBufferString("foo")); err == nil || err.Error() != "GraphError: Invalid data (Node 123 of kind mynode does not exist in partition main)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gm.StoreBlob("main#", "123", "mynode", "image", "image/png",
+		bytes.NewBufferString("foo")); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "123")
+	node.SetAttr(data.NodeKind, "mynode")
+	node.SetAttr(data.NodeName, "Node1")
+	gm.StoreNode("main", node)
+
+	ref, err := gm.StoreBlob("main", "123", "mynode", "image", "image/png",
+		bytes.NewBufferString("0123456789"))
+
+	if err != nil || ref.Size != 10 || ref.String() != "<blob image/png 10 bytes>" {
+		t.Error("Unexpected result:", ref, err)
+		return
+	}
+
+	blobStorage := func() int {
+		return len(mgs.StorageManager("main"+StorageSuffixBlobs, false).(*storage.MemoryStorageManager).Data)
+	}
+
+	// Three chunks plus the header
+
+	if res := blobStorage(); res != 4 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Check the node
+
+	n, _ := gm.FetchNode("main", "123", "mynode")
+
+	if n.Name() != "Node1" || n.Attr("image").(*data.BlobRef).Size != 10 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Blobs are not part of the index
+
+	if _, ok := n.IndexMap()["image"]; ok {
+		t.Error("Unexpected result:", n.IndexMap())
+		return
+	}
+
+	// Read the blob
+
+	br, err := gm.OpenBlob("main", "123", "mynode", "image")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ioutil.ReadAll(br); string(res) != "0123456789" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	buf := make([]byte, 3)
+
+	if pos, err := br.Seek(3, io.SeekStart); pos != 3 || err != nil {
+		t.Error("Unexpected result:", pos, err)
+		return
+	}
+
+	if n, err := io.ReadFull(br, buf); string(buf[:n]) != "345" || err != nil {
+		t.Error("Unexpected result:", string(buf[:n]), err)
+		return
+	}
+
+	if pos, err := br.Seek(1, io.SeekCurrent); pos != 7 || err != nil {
+		t.Error("Unexpected result:", pos, err)
+		return
+	}
+
+	if pos, err := br.Seek(-1, io.SeekEnd); pos != 9 || err != nil {
+		t.Error("Unexpected result:", pos, err)
+		return
+	}
+
+	if res, err := ioutil.ReadAll(br); string(res) != "9" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if _, err := br.Seek(-1, io.SeekStart); err == nil || err.Error() != "Negative position" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := br.Seek(0, 5); err == nil || err.Error() != "Invalid whence" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Non-blob attributes and unknown nodes have no blob
+
+	if br, err := gm.OpenBlob("main", "123", "mynode", "name"); br != nil || err != nil {
+		t.Error("Unexpected result:", br, err)
+		return
+	}
+
+	if br, err := gm.OpenBlob("main", "456", "mynode", "image"); br != nil || err != nil {
+		t.Error("Unexpected result:", br, err)
+		return
+	}
+
+	// Replacing a blob frees the old blob
+
+	if _, err := gm.StoreBlob("main", "123", "mynode", "image", "image/png",
+		bytes.NewBufferString("ab")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := blobStorage(); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Updating other attributes keeps the blob
+
+	node.SetAttr(data.NodeName, "Node2")
+	gm.UpdateNode("main", node)
+
+	if res := blobStorage(); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Remove the blob
+
+	if ref, err := gm.RemoveBlob("main", "123", "mynode", "image"); ref == nil || ref.Size != 2 || err != nil {
+		t.Error("Unexpected result:", ref, err)
+		return
+	}
+
+	if ref, err := gm.RemoveBlob("main", "123", "mynode", "image"); ref != nil || err != nil {
+		t.Error("Unexpected result:", ref, err)
+		return
+	}
+
+	n, _ = gm.FetchNode("main", "123", "mynode")
+
+	if res := blobStorage(); res != 0 || n.Attr("image") != nil || n.Name() != "Node2" {
+		t.Error("Unexpected result:", res, n)
+		return
+	}
+
+	// Removing a node removes its blobs
+
+	gm.StoreBlob("main", "123", "mynode", "image", "", bytes.NewBufferString("abc"))
+	gm.StoreBlob("main", "123", "mynode", "doc", "", bytes.NewBufferString("abcde"))
+
+	if res := blobStorage(); res != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.RemoveNode("main", "123", "mynode")
+
+	if res := blobStorage(); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
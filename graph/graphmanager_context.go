@@ -0,0 +1,160 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+The functions in this file are context aware versions of the GraphManager API.
+They return the error of the context (e.g. context.Canceled or
+context.DeadlineExceeded) if the context is done before the operation has
+finished. Single store and remove operations are atomic - the context is only
+checked before they start. Use a transaction and Trans.CommitContext to abort
+larger write operations.
+*/
+
+/*
+NodeKeyIteratorContext iterates node keys of a certain kind. The iterator sets
+LastError to the error of the context once the context is done.
+*/
+func (gm *Manager) NodeKeyIteratorContext(ctx context.Context, part string, kind string) (*NodeKeyIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return gm.nodeKeyIterator(ctx, part, kind)
+}
+
+/*
+FetchNodeContext fetches a single node from a partition of the graph.
+*/
+func (gm *Manager) FetchNodeContext(ctx context.Context, part string, key string, kind string) (data.Node, error) {
+	return gm.FetchNodePartContext(ctx, part, key, kind, nil)
+}
+
+/*
+FetchNodePartContext fetches part of a single node from a partition of the graph.
+*/
+func (gm *Manager) FetchNodePartContext(ctx context.Context, part string, key string, kind string,
+	attrs []string) (data.Node, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return gm.FetchNodePart(part, key, kind, attrs)
+}
+
+/*
+StoreNodeContext stores a single node in a partition of the graph. This function will
+overwrites any existing node.
+*/
+func (gm *Manager) StoreNodeContext(ctx context.Context, part string, node data.Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return gm.StoreNode(part, node)
+}
+
+/*
+UpdateNodeContext updates a single node in a partition of the graph. This function will
+only update the given values of the node.
+*/
+func (gm *Manager) UpdateNodeContext(ctx context.Context, part string, node data.Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return gm.UpdateNode(part, node)
+}
+
+/*
+RemoveNodeContext removes a single node from a partition of the graph.
+*/
+func (gm *Manager) RemoveNodeContext(ctx context.Context, part string, key string, kind string) (data.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return gm.RemoveNode(part, key, kind)
+}
+
+/*
+TraverseContext traverses from a given node to other nodes following a given edge spec.
+The traversal is aborted if the context is done.
+*/
+func (gm *Manager) TraverseContext(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool) ([]data.Node, []data.Edge, error) {
+
+	return gm.traverse(ctx, part, key, kind, spec, allData, -1)
+}
+
+/*
+TraverseMultiContext traverses from a given node to other nodes following a given
+partial edge spec. The traversal is aborted if the context is done.
+*/
+func (gm *Manager) TraverseMultiContext(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool) ([]data.Node, []data.Edge, error) {
+
+	return gm.traverseMulti(ctx, part, key, kind, spec, allData, -1)
+}
+
+/*
+TraverseMultiLimit traverses from a given node to other nodes following a given
+partial edge spec. The traversal stops with an ErrTraversalLimit error before
+more than max nodes are read (no limit if max is negative). The traversal is
+aborted if the context is done.
+*/
+func (gm *Manager) TraverseMultiLimit(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	return gm.traverseMulti(ctx, part, key, kind, spec, allData, max)
+}
+
+/*
+FetchEdgeContext fetches a single edge from a partition of the graph.
+*/
+func (gm *Manager) FetchEdgeContext(ctx context.Context, part string, key string, kind string) (data.Edge, error) {
+	return gm.FetchEdgePartContext(ctx, part, key, kind, nil)
+}
+
+/*
+FetchEdgePartContext fetches part of a single edge from a partition of the graph.
+*/
+func (gm *Manager) FetchEdgePartContext(ctx context.Context, part string, key string, kind string,
+	attrs []string) (data.Edge, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return gm.FetchEdgePart(part, key, kind, attrs)
+}
+
+/*
+StoreEdgeContext stores a single edge in a partition of the graph. This function will
+overwrites any existing edge.
+*/
+func (gm *Manager) StoreEdgeContext(ctx context.Context, part string, edge data.Edge) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return gm.StoreEdge(part, edge)
+}
+
+/*
+RemoveEdgeContext removes a single edge from a partition of the graph.
+*/
+func (gm *Manager) RemoveEdgeContext(ctx context.Context, part string, key string, kind string) (data.Edge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return gm.RemoveEdge(part, key, kind)
+}
@@ -0,0 +1,255 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestGraphManagerContext(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	ctx := context.Background()
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	newNode := func(key string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "mynode")
+		return node
+	}
+
+	newEdge := func(key string, end1 string, end2 string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "myedge")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd1Role, "node1")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd2Role, "node2")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	// Operations with a done context do nothing
+
+	if err := gm.StoreNodeContext(cctx, "main", newNode("1")); err != context.Canceled {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.UpdateNodeContext(cctx, "main", newNode("1")); err != context.Canceled {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if gm.NodeCount("mynode") != 0 {
+		t.Error("Unexpected node count:", gm.NodeCount("mynode"))
+		return
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := gm.StoreNodeContext(ctx, "main", newNode(fmt.Sprint(i))); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := gm.UpdateNodeContext(ctx, "main", newNode("1")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdgeContext(cctx, "main", newEdge("e1", "1", "2")); err != context.Canceled {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.StoreEdgeContext(ctx, "main", newEdge("e1", "1", "2")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdgeContext(ctx, "main", newEdge("e2", "1", "3")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeContext(cctx, "main", "1", "mynode"); n != nil || err != context.Canceled {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNodeContext(ctx, "main", "1", "mynode"); n == nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if e, err := gm.FetchEdgeContext(cctx, "main", "e1", "myedge"); e != nil || err != context.Canceled {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	if e, err := gm.FetchEdgeContext(ctx, "main", "e1", "myedge"); e == nil || err != nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	// Traversals
+
+	if nodes, _, err := gm.TraverseMultiContext(cctx, "main", "1", "mynode", ":::", true); nodes != nil || err != context.Canceled {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseContext(cctx, "main", "1", "mynode", "node1:myedge:node2:mynode", false); nodes != nil || err != context.Canceled {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseMultiContext(ctx, "main", "1", "mynode", ":::", true); len(nodes) != 2 || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseContext(ctx, "main", "1", "mynode", "node1:myedge:node2:mynode", true); len(nodes) != 2 || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Traversals with a limit stop before nodes above the limit are read
+
+	if nodes, _, err := gm.TraverseMultiLimit(ctx, "main", "1", "mynode", ":::", true, 1); nodes != nil || err == nil ||
+		err.Error() != "GraphError: Traversal limit exceeded (Node 1 has more than 1 connected nodes over node1:myedge:node2:mynode)" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseMultiLimit(ctx, "main", "1", "mynode", ":::", false, 2); len(nodes) != 2 || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseMultiLimit(ctx, "main", "1", "mynode", ":::", false, -1); len(nodes) != 2 || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Iterators stop once the context is done
+
+	if it, err := gm.NodeKeyIteratorContext(cctx, "main", "mynode"); it != nil || err != context.Canceled {
+		t.Error("Unexpected result:", it, err)
+		return
+	}
+
+	ictx, icancel := context.WithCancel(ctx)
+	defer icancel()
+
+	it, err := gm.NodeKeyIteratorContext(ictx, "main", "mynode")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if key := it.Next(); key == "" || it.LastError != nil {
+		t.Error("Unexpected result:", key, it.LastError)
+		return
+	}
+
+	icancel()
+
+	if key := it.Next(); key != "" || it.LastError != context.Canceled || !it.HasNext() {
+		t.Error("Unexpected result:", key, it.LastError)
+		return
+	}
+
+	// Removals
+
+	if e, err := gm.RemoveEdgeContext(cctx, "main", "e1", "myedge"); e != nil || err != context.Canceled {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	if e, err := gm.RemoveEdgeContext(ctx, "main", "e1", "myedge"); e == nil || err != nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	if n, err := gm.RemoveNodeContext(cctx, "main", "3", "mynode"); n != nil || err != context.Canceled {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.RemoveNodeContext(ctx, "main", "3", "mynode"); n == nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+}
+
+func TestTransCommitContext(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, newTrans := range []func() Trans{
+		func() Trans { return NewGraphTrans(gm) },
+		func() Trans { return NewConcurrentGraphTrans(gm) },
+		func() Trans { return NewRollingTrans(NewGraphTrans(gm), 10, gm, NewGraphTrans) },
+	} {
+		trans := newTrans()
+
+		for i := 0; i < 3; i++ {
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, fmt.Sprint(i))
+			node.SetAttr(data.NodeKind, "mynode")
+			trans.StoreNode("main", node)
+		}
+
+		if err := trans.CommitContext(cctx); err == nil {
+			t.Error("Commit should have been aborted")
+			return
+		}
+
+		// The transaction was rolled back
+
+		if n, err := gm.FetchNode("main", "1", "mynode"); n != nil || err != nil {
+			t.Error("Unexpected result:", n, err)
+			return
+		}
+	}
+
+	trans := NewGraphTrans(gm)
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "1")
+	node.SetAttr(data.NodeKind, "mynode")
+	trans.StoreNode("main", node)
+
+	if err := trans.CommitContext(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "1", "mynode"); n == nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+}
@@ -11,6 +11,7 @@
 package graph
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
@@ -48,7 +49,7 @@ EdgeCount returns the edge count for a given edge kind.
 */
 func (gm *Manager) EdgeCount(kind string) uint64 {
 
-	if val, ok := gm.gs.MainDB()[MainDBEdgeCount+kind]; ok {
+	if val, ok := gm.mainDBValue(MainDBEdgeCount + kind); ok {
 		return binary.LittleEndian.Uint64([]byte(val))
 	}
 
@@ -67,8 +68,7 @@ func (gm *Manager) FetchNodeEdgeSpecs(part string, key string, kind string) ([]s
 
 	// Take reader lock
 
-	gm.mutex.RLock()
-	defer gm.mutex.RUnlock()
+	defer gm.readLock(part)()
 
 	specsNodeKey := PrefixNSSpecs + key
 	obj, err := tree.Get([]byte(specsNodeKey))
@@ -109,13 +109,49 @@ the minimal set of attributes will be populated.
 func (gm *Manager) TraverseMulti(part string, key string, kind string,
 	spec string, allData bool) ([]data.Node, []data.Edge, error) {
 
+	return gm.traverseMulti(context.Background(), part, key, kind, spec, allData, -1)
+}
+
+/*
+traverseMulti traverses from a given node to other nodes following a given
+partial edge spec. The traversal is aborted if the given context is done or
+if more than max nodes would be returned (no limit if max is negative).
+*/
+func (gm *Manager) traverseMulti(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	spec = gm.resolveSpec(spec)
+
 	sspec := strings.Split(spec, ":")
 	if len(sspec) != 4 {
 		return nil, nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Invalid spec: " + spec}
 	} else if IsFullSpec(spec) {
-		return gm.Traverse(part, key, kind, spec, allData)
+		return gm.traverse(ctx, part, key, kind, spec, allData, max)
+	}
+
+	nodes, edges, err := gm.traverseMultiLocal(ctx, part, key, kind, spec, sspec, allData, max)
+
+	if err == nil && allData {
+
+		// Nodes of other shards are fetched for all specs together
+
+		err = gm.FetchRemoteNodes(ctx, part, nodes)
+	}
+
+	if err != nil {
+		return nil, nil, err
 	}
 
+	return nodes, edges, nil
+}
+
+/*
+traverseMultiLocal follows all specs of a node which match a given partial
+edge spec. Nodes of other shards are returned with their key and kind only.
+*/
+func (gm *Manager) traverseMultiLocal(ctx context.Context, part string, key string, kind string,
+	spec string, sspec []string, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
 	// Get all specs for the given node
 
 	specs, err := gm.FetchNodeEdgeSpecs(part, key, kind)
@@ -145,9 +181,21 @@ func (gm *Manager) TraverseMulti(part string, key string, kind string,
 	var edges []data.Edge
 
 	for _, rspec := range specs {
+
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
 		if spec == ":::" || matchSpec(rspec) {
 
-			sn, se, err := gm.Traverse(part, key, kind, rspec, allData)
+			// The limit applies to the nodes of all matching specs
+
+			rmax := max
+			if max >= 0 {
+				rmax = max - len(nodes)
+			}
+
+			sn, se, err := gm.traverseLocal(ctx, part, key, kind, rspec, allData, rmax)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -169,6 +217,42 @@ attributes will be populated.
 func (gm *Manager) Traverse(part string, key string, kind string,
 	spec string, allData bool) ([]data.Node, []data.Edge, error) {
 
+	return gm.traverse(context.Background(), part, key, kind, spec, allData, -1)
+}
+
+/*
+traverse traverses from a given node to other nodes following a given edge spec.
+The traversal is aborted if the given context is done or if more than max nodes
+would be returned (no limit if max is negative).
+*/
+func (gm *Manager) traverse(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	nodes, edges, err := gm.traverseLocal(ctx, part, key, kind, spec, allData, max)
+
+	if err == nil && allData {
+		err = gm.FetchRemoteNodes(ctx, part, nodes)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, edges, nil
+}
+
+/*
+traverseLocal traverses from a given node to other nodes following a given edge
+spec. Nodes of other shards are returned with their key and kind only - they
+are fetched after the reader lock was released.
+*/
+func (gm *Manager) traverseLocal(ctx context.Context, part string, key string, kind string,
+	spec string, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
 	_, tree, err := gm.getNodeStorageHTree(part, kind, false)
 	if err != nil || tree == nil {
 		return nil, nil, err
@@ -176,8 +260,9 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
-	defer gm.mutex.RUnlock()
+	defer gm.readLock(part)()
+
+	spec = gm.resolveSpec(spec)
 
 	sspec := strings.Split(spec, ":")
 	if len(sspec) != 4 {
@@ -201,6 +286,13 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 
 	targetMap := obj.(map[string]*edgeTargetInfo)
 
+	// Check the limit before any nodes or edges are created or read
+
+	if max >= 0 && len(targetMap) > max {
+		return nil, nil, &util.GraphError{Type: util.ErrTraversalLimit,
+			Detail: fmt.Sprintf("Node %v has more than %v connected nodes over %v", key, max, spec)}
+	}
+
 	nodes := make([]data.Node, 0, len(targetMap))
 	edges := make([]data.Edge, 0, len(targetMap))
 
@@ -249,6 +341,10 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 
 		for k, v := range targetMap {
 
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+
 			// Read the edge from the datastore
 
 			edgenode, err := gm.readNode(k, sspec[1], nil, edgeht, edgeht)
@@ -260,20 +356,19 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 			// Exchange ends if necessary
 
 			if edge.End2Key() == key && edge.End2Kind() == kind {
-				swap := func(attr1 string, attr2 string) {
-					tmp := edge.Attr(attr1)
-					edge.SetAttr(attr1, edge.Attr(attr2))
-					edge.SetAttr(attr2, tmp)
-				}
-
-				swap(data.EdgeEnd1Key, data.EdgeEnd2Key)
-				swap(data.EdgeEnd1Kind, data.EdgeEnd2Kind)
-				swap(data.EdgeEnd1Role, data.EdgeEnd2Role)
-				swap(data.EdgeEnd1Cascading, data.EdgeEnd2Cascading)
+				swapEdgeEnds(edge)
 			}
 
 			edges = append(edges, edge)
 
+			if gm.isRemote(part, v.TargetNodeKey, v.TargetNodeKind) {
+
+				// Nodes of other shards are returned with their key and kind
+
+				nodes = append(nodes, remoteNode(v.TargetNodeKey, v.TargetNodeKind))
+				continue
+			}
+
 			// Get the HTrees which stores the node
 
 			attht, valht, err := gm.getNodeStorageHTree(part, v.TargetNodeKind, false)
@@ -315,8 +410,7 @@ func (gm *Manager) FetchEdgePart(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
-	defer gm.mutex.RUnlock()
+	defer gm.readLock(part)()
 
 	// Read the edge from the datastore
 
@@ -327,9 +421,28 @@ func (gm *Manager) FetchEdgePart(part string, key string, kind string,
 
 /*
 StoreEdge stores a single edge in a partition of the graph. This function will
-overwrites any existing edge.
+overwrites any existing edge. An edge without a key gets a generated key if key
+generation is enabled (see SetKeyGeneration).
 */
 func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
+	err := gm.storeEdge(part, edge)
+
+	if err == nil {
+		err = gm.waitDurable()
+	}
+
+	return err
+}
+
+/*
+storeEdge stores a single edge like StoreEdge but does not wait for
+the changes to become durable.
+*/
+func (gm *Manager) storeEdge(part string, edge data.Edge) error {
+	if err := gm.assignKey(part, edge, true); err != nil {
+		return err
+	}
+
 	trans := newInternalGraphTrans(gm)
 	trans.subtrans = true
 
@@ -365,42 +478,14 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 		// Get the HTrees which stores the edge endpoints and make sure the endpoints
 		// do exist
 
-		end1nodeht, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
-
-		if err != nil {
-			return err
-		} else if end1ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: "Can't store edge to non-existing node kind: " + edge.End1Kind(),
-			}
-		} else if end1, err := end1nodeht.Get([]byte(PrefixNSAttrs + edge.End1Key())); err != nil || end1 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End1Key(), edge.End1Kind()),
-			}
-		}
-
-		end2nodeht, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
-
+		end1ht, end2ht, err := gm.getEdgeEndHTrees(part, edge, true)
 		if err != nil {
 			return err
-		} else if end2ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: "Can't store edge to non-existing node kind: " + edge.End2Kind(),
-			}
-		} else if end2, err := end2nodeht.Get([]byte(PrefixNSAttrs + edge.End2Key())); err != nil || end2 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End2Key(), edge.End2Kind()),
-			}
 		}
 
 		// Take writer lock
 
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
+		defer gm.writeLock(part)()
 
 		// Write edge to the datastore
 
@@ -416,8 +501,7 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 			// Increase edge count
 
-			currentCount := gm.EdgeCount(edge.Kind())
-			if err := gm.writeEdgeCount(edge.Kind(), currentCount+1, true); err != nil {
+			if err := gm.changeCount(MainDBEdgeCount+edge.Kind(), 1, true); err != nil {
 				return err
 			}
 
@@ -425,7 +509,7 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 			if iht != nil {
 
-				if err := util.NewIndexManager(iht).Index(edge.Key(), edge.IndexMap()); err != nil {
+				if err := gm.newIndexManager(iht, edge.Kind()).Index(edge.Key(), edge.IndexMap()); err != nil {
 
 					// The edge was written at this point and the model is
 					// consistent only the index is missing entries
@@ -436,7 +520,7 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 		} else if iht != nil {
 
-			err := util.NewIndexManager(iht).Reindex(edge.Key(), edge.IndexMap(),
+			err := gm.newIndexManager(iht, edge.Kind()).Reindex(edge.Key(), edge.IndexMap(),
 				oldedge.IndexMap())
 
 			if err != nil {
@@ -452,7 +536,7 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 			// Flush changes - errors only reported on the actual node storage flush
 
-			gm.gs.FlushMain()
+			gm.flushMain()
 
 			gm.flushEdgeIndex(part, edge.Kind())
 
@@ -463,6 +547,10 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 			gm.flushEdgeStorage(part, edge.Kind())
 		}()
 
+		if err := gm.writeSeq(part, seqKey(true, edge.Kind(), edge.Key()), false, true); err != nil {
+			return err
+		}
+
 		// Execute rules
 
 		trans := newInternalGraphTrans(gm)
@@ -489,8 +577,8 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 writeEdge writes a given edge to the datastore. It is assumed that the caller
 holds the writer lock before calling the functions and that, after the function
 returns, the changes are flushed to the storage. The caller has also to ensure
-that the endpoints of the edge do exist. Returns the old edge if an
-update occurred.
+that the endpoints of the edge do exist. Nothing is written for a remote end
+(nil HTree). Returns the old edge if an update occurred.
 */
 func (gm *Manager) writeEdge(edge data.Edge, edgeTree *hash.HTree,
 	end1Tree *hash.HTree, end2Tree *hash.HTree) (data.Edge, error) {
@@ -514,6 +602,10 @@ func (gm *Manager) writeEdge(edge data.Edge, edgeTree *hash.HTree,
 	updateSpecMap := func(key string, spec string, tree *hash.HTree) error {
 		var specsNode map[string]string
 
+		if tree == nil {
+			return nil
+		}
+
 		obj, err := tree.Get([]byte(key))
 
 		if err != nil {
@@ -540,6 +632,10 @@ func (gm *Manager) writeEdge(edge data.Edge, edgeTree *hash.HTree,
 
 		var targetMap map[string]*edgeTargetInfo
 
+		if tree == nil {
+			return nil
+		}
+
 		obj, err := tree.Get([]byte(key))
 
 		if err != nil {
@@ -590,7 +686,9 @@ func (gm *Manager) writeEdge(edge data.Edge, edgeTree *hash.HTree,
 			}
 		}
 
-		return oldedge, nil
+		// Update the timelines if the timestamp has changed
+
+		return oldedge, gm.updateTimeline(edge, oldedge, end1Tree, end2Tree)
 	}
 
 	// Create / update specs map on the nodes
@@ -616,13 +714,29 @@ func (gm *Manager) writeEdge(edge data.Edge, edgeTree *hash.HTree,
 		return nil, err
 	}
 
-	return nil, nil
+	// Add timestamped edges to the timelines
+
+	return nil, gm.updateTimeline(edge, nil, end1Tree, end2Tree)
 }
 
 /*
 RemoveEdge removes a single edge from a partition of the graph.
 */
 func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge, error) {
+	edge, err := gm.removeEdge(part, key, kind)
+
+	if err == nil {
+		err = gm.waitDurable()
+	}
+
+	return edge, err
+}
+
+/*
+removeEdge removes a single edge like RemoveEdge but does not wait for
+the changes to become durable.
+*/
+func (gm *Manager) removeEdge(part string, key string, kind string) (data.Edge, error) {
 	var err error
 
 	trans := newInternalGraphTrans(gm)
@@ -653,8 +767,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 		// Take writer lock
 
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
+		defer gm.writeLock(part)()
 
 		// Delete the node from the datastore
 
@@ -668,12 +781,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 			// Get the HTrees which stores the edge endpoints
 
-			_, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
-			if err != nil {
-				return edge, err
-			}
-
-			_, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
+			end1ht, end2ht, err := gm.getEdgeEndHTrees(part, edge, false)
 			if err != nil {
 				return edge, err
 			}
@@ -685,7 +793,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 			}
 
 			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(key, edge.IndexMap())
+				err := gm.newIndexManager(iht, kind).Deindex(key, edge.IndexMap())
 				if err != nil {
 					return edge, err
 				}
@@ -693,8 +801,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 			// Decrease edge count
 
-			currentCount := gm.EdgeCount(edge.Kind())
-			if err := gm.writeEdgeCount(edge.Kind(), currentCount-1, true); err != nil {
+			if err := gm.changeCount(MainDBEdgeCount+edge.Kind(), -1, true); err != nil {
 				return edge, err
 			}
 
@@ -702,7 +809,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 				// Flush changes - errors only reported on the actual node storage flush
 
-				gm.gs.FlushMain()
+				gm.flushMain()
 
 				gm.flushEdgeIndex(part, edge.Kind())
 
@@ -713,6 +820,10 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 				gm.flushEdgeStorage(part, edge.Kind())
 			}()
 
+			if err := gm.writeSeq(part, seqKey(true, kind, key), true, true); err != nil {
+				return edge, err
+			}
+
 			// Execute rules
 
 			trans := newInternalGraphTrans(gm)
@@ -732,7 +843,8 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 }
 
 /*
-Delete edge information from a given node storage
+Delete edge information from a given node storage. Nothing is deleted for a
+remote end (nil HTree).
 */
 func (gm *Manager) deleteEdge(edge data.Edge, end1Tree *hash.HTree, end2Tree *hash.HTree) error {
 
@@ -755,6 +867,10 @@ func (gm *Manager) deleteEdge(edge data.Edge, end1Tree *hash.HTree, end2Tree *ha
 	updateSpecMap := func(key string, spec string, tree *hash.HTree) error {
 		var specsNode map[string]string
 
+		if tree == nil {
+			return nil
+		}
+
 		obj, err := tree.Get([]byte(key))
 
 		if err != nil {
@@ -789,6 +905,10 @@ func (gm *Manager) deleteEdge(edge data.Edge, end1Tree *hash.HTree, end2Tree *ha
 
 		var targetMap map[string]*edgeTargetInfo
 
+		if tree == nil {
+			return false, nil
+		}
+
 		obj, err := tree.Get([]byte(key))
 
 		if err != nil {
@@ -845,7 +965,9 @@ func (gm *Manager) deleteEdge(edge data.Edge, end1Tree *hash.HTree, end2Tree *ha
 		}
 	}
 
-	return nil
+	// Remove timestamped edges from the timelines
+
+	return gm.updateTimeline(nil, edge, end1Tree, end2Tree)
 }
 
 /*
@@ -650,7 +650,7 @@ func TestSimpleGraphStorageErrorCases(t *testing.T) {
 	delete(sm.(*storage.MemoryStorageManager).AccessMap, 1)
 
 	sm = gm.gs.StorageManager("main"+"myedge"+StorageSuffixEdgesIndex, false)
-	sm.(*storage.MemoryStorageManager).AccessMap[5] = storage.AccessInsertError
+	sm.(*storage.MemoryStorageManager).AccessMap[10] = storage.AccessInsertError
 
 	edge.SetAttr("name", "New edge name")
 
@@ -659,7 +659,7 @@ func TestSimpleGraphStorageErrorCases(t *testing.T) {
 		return
 	}
 
-	delete(sm.(*storage.MemoryStorageManager).AccessMap, 5)
+	delete(sm.(*storage.MemoryStorageManager).AccessMap, 10)
 
 	resetStorage := func() {
 		mgs = graphstorage.NewMemoryGraphStorage("mystorage")
@@ -930,14 +930,18 @@ func TestSimpleGraphStorageErrorCases(t *testing.T) {
 	resetStorage()
 
 	sm = gm.gs.StorageManager("main"+edge.Kind()+StorageSuffixEdgesIndex, false)
-	sm.(*storage.MemoryStorageManager).AccessMap[2] = storage.AccessFreeError
+	for i := 0; i < 20; i++ {
+		sm.(*storage.MemoryStorageManager).AccessMap[uint64(i)] = storage.AccessFreeError
+	}
 
 	if _, err := gm.RemoveEdge("main", edge.Key(), edge.Kind()); !strings.Contains(err.Error(), "Index error") {
 		t.Error("Unexpected store result:", err)
 		return
 	}
 
-	delete(sm.(*storage.MemoryStorageManager).AccessMap, 2)
+	for i := 0; i < 20; i++ {
+		delete(sm.(*storage.MemoryStorageManager).AccessMap, uint64(i))
+	}
 
 	// Test removal of non-existing edge
 
@@ -11,6 +11,7 @@
 package graph
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 
@@ -31,7 +32,7 @@ NodeCount returns the node count for a given node kind.
 */
 func (gm *Manager) NodeCount(kind string) uint64 {
 
-	if val, ok := gm.gs.MainDB()[MainDBNodeCount+kind]; ok {
+	if val, ok := gm.mainDBValue(MainDBNodeCount + kind); ok {
 		return binary.LittleEndian.Uint64([]byte(val))
 	}
 
@@ -42,6 +43,14 @@ func (gm *Manager) NodeCount(kind string) uint64 {
 NodeKeyIterator iterates node keys of a certain kind.
 */
 func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator, error) {
+	return gm.nodeKeyIterator(context.Background(), part, kind)
+}
+
+/*
+nodeKeyIterator iterates node keys of a certain kind. The iterator stops
+returning keys once the given context is done.
+*/
+func (gm *Manager) nodeKeyIterator(ctx context.Context, part string, kind string) (*NodeKeyIterator, error) {
 	// Get the HTrees which stores the node
 
 	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
@@ -57,7 +66,85 @@ func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator,
 		}
 	}
 
-	return &NodeKeyIterator{gm, it, nil}, nil
+	return &NodeKeyIterator{gm, part, it, ctx, nil, nil}, nil
+}
+
+/*
+NodeKeySnapshotIterator iterates node keys of a certain kind. All keys are
+read when the iterator is created - the iterator is not affected by nodes
+which are stored or removed during the iteration. This is meant for
+operations which need a consistent view under write load (e.g. exports or
+paged lists). Note that all keys of the kind are held in memory.
+*/
+func (gm *Manager) NodeKeySnapshotIterator(part string, kind string) (*NodeKeyIterator, error) {
+
+	its, err := gm.NodeKeySnapshotIterators(part, []string{kind})
+	if err != nil {
+		return nil, err
+	}
+
+	return its[0], nil
+}
+
+/*
+NodeKeySnapshotIterators creates snapshot iterators for several node kinds.
+All snapshots are taken at the same point in time so together they form a
+consistent view of the partition. The returned list has an entry for each
+given kind which is nil if the kind does not exist in the partition.
+*/
+func (gm *Manager) NodeKeySnapshotIterators(part string, kinds []string) ([]*NodeKeyIterator, error) {
+
+	// Take reader lock so no node can be stored or removed while the
+	// keys are collected
+
+	defer gm.readLock(part)()
+
+	its := make([]*NodeKeyIterator, len(kinds))
+
+	for i, kind := range kinds {
+
+		keys, err := gm.nodeKeys(part, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if keys != nil {
+			its[i] = &NodeKeyIterator{gm, part, nil, context.Background(), keys, nil}
+		}
+	}
+
+	return its, nil
+}
+
+/*
+nodeKeys reads all node keys of a certain kind. Returns nil if the kind does
+not exist in the partition. Assumes that the caller holds the reader lock.
+*/
+func (gm *Manager) nodeKeys(part string, kind string) ([]string, error) {
+
+	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+
+	it := hash.NewHTreeIterator(tree)
+
+	for it.LastError == nil && it.HasNext() {
+		if k, _ := it.Next(); len(k) > 0 {
+			keys = append(keys, string(k[len(PrefixNSAttrs):]))
+		}
+	}
+
+	if it.LastError != nil {
+		return nil, &util.GraphError{
+			Type:   util.ErrReading,
+			Detail: it.LastError.Error(),
+		}
+	}
+
+	return keys, nil
 }
 
 /*
@@ -82,8 +169,7 @@ func (gm *Manager) FetchNodePart(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
-	defer gm.mutex.RUnlock()
+	defer gm.readLock(part)()
 
 	// Read the node from the datastore
 
@@ -181,9 +267,28 @@ func (gm *Manager) readNode(key string, kind string, attrs []string,
 
 /*
 StoreNode stores a single node in a partition of the graph. This function will
-overwrites any existing node.
+overwrites any existing node. A node without a key gets a generated key if key
+generation is enabled (see SetKeyGeneration).
 */
 func (gm *Manager) StoreNode(part string, node data.Node) error {
+	err := gm.storeNode(part, node)
+
+	if err == nil {
+		err = gm.waitDurable()
+	}
+
+	return err
+}
+
+/*
+storeNode stores a single node like StoreNode but does not wait for
+the changes to become durable.
+*/
+func (gm *Manager) storeNode(part string, node data.Node) error {
+	if err := gm.assignKey(part, node, false); err != nil {
+		return err
+	}
+
 	trans := newInternalGraphTrans(gm)
 	trans.subtrans = true
 
@@ -208,6 +313,24 @@ UpdateNode updates a single node in a partition of the graph. This function will
 only update the given values of the node.
 */
 func (gm *Manager) UpdateNode(part string, node data.Node) error {
+	err := gm.updateNode(part, node)
+
+	if err == nil {
+		err = gm.waitDurable()
+	}
+
+	return err
+}
+
+/*
+updateNode updates a single node like UpdateNode but does not wait for
+the changes to become durable.
+*/
+func (gm *Manager) updateNode(part string, node data.Node) error {
+	if err := gm.assignKey(part, node, false); err != nil {
+		return err
+	}
+
 	trans := newInternalGraphTrans(gm)
 	trans.subtrans = true
 
@@ -252,8 +375,7 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	// Take writer lock
 
-	gm.mutex.Lock()
-	defer gm.mutex.Unlock()
+	defer gm.writeLock(part)()
 
 	// Write the node to the datastore
 
@@ -266,13 +388,12 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 	// to the index.
 
 	if oldnode == nil {
-		currentCount := gm.NodeCount(node.Kind())
-		if err := gm.writeNodeCount(node.Kind(), currentCount+1, true); err != nil {
+		if err := gm.changeCount(MainDBNodeCount+node.Kind(), 1, true); err != nil {
 			return err
 		}
 
 		if iht != nil {
-			err := util.NewIndexManager(iht).Index(node.Key(), node.IndexMap())
+			err := gm.newIndexManager(iht, node.Kind()).Index(node.Key(), node.IndexMap())
 			if err != nil {
 
 				// The node was written at this point and the model is
@@ -284,7 +405,7 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	} else if iht != nil {
 
-		err := util.NewIndexManager(iht).Reindex(node.Key(), node.IndexMap(),
+		err := gm.newIndexManager(iht, node.Kind()).Reindex(node.Key(), node.IndexMap(),
 			oldnode.IndexMap())
 
 		if err != nil {
@@ -300,7 +421,7 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 		// Flush changes
 
-		gm.gs.FlushMain()
+		gm.flushMain()
 
 		gm.flushNodeIndex(part, node.Kind())
 
@@ -308,6 +429,10 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	}()
 
+	if err := gm.writeSeq(part, seqKey(false, node.Kind(), node.Key()), false, true); err != nil {
+		return err
+	}
+
 	// Execute rules
 
 	trans := newInternalGraphTrans(gm)
@@ -476,6 +601,20 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 RemoveNode removes a single node from a partition of the graph.
 */
 func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node, error) {
+	node, err := gm.removeNode(part, key, kind)
+
+	if err == nil {
+		err = gm.waitDurable()
+	}
+
+	return node, err
+}
+
+/*
+removeNode removes a single node like RemoveNode but does not wait for
+the changes to become durable.
+*/
+func (gm *Manager) removeNode(part string, key string, kind string) (data.Node, error) {
 	var err error
 
 	trans := newInternalGraphTrans(gm)
@@ -506,8 +645,7 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 
 		// Take writer lock
 
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
+		defer gm.writeLock(part)()
 
 		// Delete the node from the datastore
 
@@ -521,7 +659,7 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 		if node != nil {
 
 			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(key, node.IndexMap())
+				err := gm.newIndexManager(iht, kind).Deindex(key, node.IndexMap())
 				if err != nil {
 					return node, err
 				}
@@ -529,8 +667,7 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 
 			// Decrease the node count
 
-			currentCount := gm.NodeCount(kind)
-			if err := gm.writeNodeCount(kind, currentCount-1, true); err != nil {
+			if err := gm.changeCount(MainDBNodeCount+kind, -1, true); err != nil {
 				return node, err
 			}
 
@@ -538,13 +675,17 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 
 				// Flush changes
 
-				gm.gs.FlushMain()
+				gm.flushMain()
 
 				gm.flushNodeIndex(part, kind)
 
 				gm.flushNodeStorage(part, kind)
 			}()
 
+			if err := gm.writeSeq(part, seqKey(false, kind, key), true, true); err != nil {
+				return node, err
+			}
+
 			// Execute rules
 
 			trans := newInternalGraphTrans(gm)
@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
@@ -592,7 +593,7 @@ func TestSimpleNodeStorageErrorCases(t *testing.T) {
 	is := gm.gs.StorageManager("testpart"+"testkind"+StorageSuffixNodesIndex,
 		false).(*storage.MemoryStorageManager)
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < 12; i++ {
 		is.AccessMap[uint64(i)] = storage.AccessInsertError
 	}
 
@@ -614,7 +615,7 @@ func TestSimpleNodeStorageErrorCases(t *testing.T) {
 		return
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < 12; i++ {
 		is.AccessMap[uint64(i)] = storage.AccessUpdateError
 	}
 
@@ -625,7 +626,7 @@ func TestSimpleNodeStorageErrorCases(t *testing.T) {
 		return
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < 12; i++ {
 		delete(is.AccessMap, uint64(i))
 	}
 
@@ -774,3 +775,72 @@ func testVersionPanic(t *testing.T, gs graphstorage.Storage) {
 
 	newGraphManagerNoRules(gs)
 }
+
+func TestGraphManagerRichValues(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir1, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := newGraphManagerNoRules(dgs)
+
+	dec, _ := data.NewDecimal("19.99")
+	date := time.Date(2020, 2, 29, 12, 30, 0, 0, time.UTC)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "order")
+	node.SetAttr("date", date)
+	node.SetAttr("price", dec)
+	node.SetAttr("receipt", []byte{1, 2, 3})
+	node.SetAttr("tags", []string{"a", "b"})
+	node.SetAttr("items", []interface{}{"x", 1.5})
+	node.SetAttr("address", map[string]interface{}{"city": "Berlin"})
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir1, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm = newGraphManagerNoRules(dgs)
+
+	n, err := gm.FetchNode("main", "123", "order")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n.Attr("date") != date || n.Attr("price").(*data.Decimal).String() != "19.99" ||
+		fmt.Sprint(n.Attr("receipt"), n.Attr("tags"), n.Attr("items"), n.Attr("address")) != "[1 2 3] [a b] [x 1.5] map[city:Berlin]" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Index lookups use the canonical string representation of the values
+
+	iq, _ := gm.NodeIndexQuery("main", "order")
+
+	if res, err := iq.LookupValue("date", "2020-02-29T12:30:00Z"); fmt.Sprint(res) != "[123]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := iq.LookupValue("price", "19.99"); fmt.Sprint(res) != "[123]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/binary"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+// Commit sequence numbers
+// =======================
+//
+// Every write of a node or an edge (store, update or removal) is assigned the
+// next commit sequence number of its partition. Writes to a partition are
+// serialised so the sequence numbers of a partition are strictly increasing
+// in the order in which the writes were applied. The sequence number of the
+// last write of every existing node and edge is kept in the sequence database
+// of the partition. Graph rules which handle the events of a write can
+// retrieve its sequence number with PartitionSeq.
+
+/*
+PartitionSeq returns the commit sequence number of the last write in a
+partition. Returns 0 if the partition has never been written to.
+*/
+func (gm *Manager) PartitionSeq(part string) uint64 {
+
+	if val, ok := gm.mainDBValue(MainDBPartSeq + part); ok {
+		return binary.LittleEndian.Uint64([]byte(val))
+	}
+
+	return 0
+}
+
+/*
+NodeSeq returns the commit sequence number of the last write of a node.
+Returns 0 if the node does not exist.
+*/
+func (gm *Manager) NodeSeq(part string, key string, kind string) (uint64, error) {
+	return gm.readSeq(part, seqKey(false, kind, key))
+}
+
+/*
+EdgeSeq returns the commit sequence number of the last write of an edge.
+Returns 0 if the edge does not exist.
+*/
+func (gm *Manager) EdgeSeq(part string, key string, kind string) (uint64, error) {
+	return gm.readSeq(part, seqKey(true, kind, key))
+}
+
+/*
+seqKey returns the key of a node or an edge in the sequence database.
+*/
+func seqKey(isEdge bool, kind string, key string) string {
+	if isEdge {
+		return "e#" + kind + "#" + key
+	}
+	return "n#" + kind + "#" + key
+}
+
+/*
+readSeq reads a commit sequence number from the sequence database of a
+partition.
+*/
+func (gm *Manager) readSeq(part string, skey string) (uint64, error) {
+
+	tree, err := gm.getSeqHTree(part, false)
+	if err != nil || tree == nil {
+		return 0, err
+	}
+
+	defer gm.readLock(part)()
+
+	seq, err := tree.Get([]byte(skey))
+	if err != nil {
+		return 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if seq == nil {
+		return 0, nil
+	}
+
+	return seq.(uint64), nil
+}
+
+/*
+nextSeq increases the commit sequence number of a partition and returns the
+new value. It is assumed that the caller holds the writer lock of the
+partition and flushes the main database afterwards.
+*/
+func (gm *Manager) nextSeq(part string) uint64 {
+	var seq uint64
+
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	if val, ok := gm.gs.MainDB()[MainDBPartSeq+part]; ok {
+		seq = binary.LittleEndian.Uint64([]byte(val))
+	}
+
+	seq++
+
+	gm.setSeqLocked(part, seq)
+
+	return seq
+}
+
+/*
+resetSeq sets the commit sequence number of a partition back to a given value.
+This is used to give back sequence numbers of writes which were rolled back.
+*/
+func (gm *Manager) resetSeq(part string, seq uint64) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	gm.setSeqLocked(part, seq)
+}
+
+/*
+setSeqLocked sets the commit sequence number of a partition. It is assumed that
+the caller holds the main mutex.
+*/
+func (gm *Manager) setSeqLocked(part string, seq uint64) {
+	numstr := make([]byte, 8)
+	binary.LittleEndian.PutUint64(numstr, seq)
+	gm.gs.MainDB()[MainDBPartSeq+part] = string(numstr)
+}
+
+/*
+writeSeq assigns the next commit sequence number of a partition to a written
+node or edge and records it.
+*/
+func (gm *Manager) writeSeq(part string, skey string, removed bool, flush bool) error {
+	return gm.recordSeq(part, skey, gm.nextSeq(part), removed, flush)
+}
+
+/*
+recordSeq records the commit sequence number of a written node or edge in the
+sequence database of a partition. The record is removed if the node or edge
+was removed.
+*/
+func (gm *Manager) recordSeq(part string, skey string, seq uint64, removed bool, flush bool) error {
+
+	tree, err := gm.getSeqHTree(part, true)
+	if err != nil {
+		return err
+	}
+
+	if removed {
+		_, err = tree.Remove([]byte(skey))
+	} else {
+		_, err = tree.Put([]byte(skey), seq)
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	if flush {
+		return gm.flushSeqStorage(part)
+	}
+
+	return nil
+}
+
+/*
+getSeqHTree gets the HTree of the sequence database of a partition.
+*/
+func (gm *Manager) getSeqHTree(part string, create bool) (*hash.HTree, error) {
+
+	gm.storageMutex.Lock()
+	defer gm.storageMutex.Unlock()
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	sm := gm.storageManager(part, StorageSuffixSeq, create)
+	if sm == nil {
+		return nil, nil
+	}
+
+	return gm.getHTree(sm, RootIDNodeHTree)
+}
+
+/*
+flushSeqStorage flushes the sequence database of a partition.
+*/
+func (gm *Manager) flushSeqStorage(part string) error {
+	if sm := gm.storageManager(part, StorageSuffixSeq, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
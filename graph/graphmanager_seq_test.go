@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestCommitSeq(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newNode := func(key string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "mynode")
+		return node
+	}
+
+	newEdge := func(key string, end1 string, end2 string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", key)
+		edge.SetAttr("kind", "myedge")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd1Role, "node1")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd2Role, "node2")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	seqs := func(part string) string {
+		n1, _ := gm.NodeSeq(part, "1", "mynode")
+		n2, _ := gm.NodeSeq(part, "2", "mynode")
+		e1, _ := gm.EdgeSeq(part, "e1", "myedge")
+		return fmt.Sprint(gm.PartitionSeq(part), " ", n1, " ", n2, " ", e1)
+	}
+
+	if res := seqs("main"); res != "0 0 0 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Every single write gets the next number of its partition
+
+	gm.StoreNode("main", newNode("1"))
+	gm.StoreNode("main", newNode("2"))
+	gm.StoreEdge("main", newEdge("e1", "1", "2"))
+	gm.UpdateNode("main", newNode("1"))
+
+	if res := seqs("main"); res != "4 4 2 3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Partitions have their own sequence
+
+	gm.StoreNode("other", newNode("1"))
+
+	if res := seqs("other"); res != "1 1 0 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removals are counted and remove the number of the node or edge
+
+	gm.RemoveEdge("main", "e1", "myedge")
+
+	if res := seqs("main"); res != "5 4 2 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Writes of a transaction are numbered in the order in which they
+	// are applied
+
+	trans := NewGraphTrans(gm)
+	trans.RemoveNode("main", "1", "mynode")
+	trans.StoreNode("main", newNode("2"))
+	trans.StoreEdge("other", newEdge("e1", "1", "1"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := seqs("main"); res != "7 0 6 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := seqs("other"); res != "2 1 0 2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Failed transactions do not change any numbers
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("1"))
+	trans.StoreEdge("main", newEdge("e2", "1", "3"))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Commit should fail")
+		return
+	}
+
+	if res := seqs("main"); res != "7 0 6 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Numbers are persistent
+
+	gm = NewGraphManager(mgs)
+
+	if err := gm.StoreNode("main", newNode("1")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := seqs("main"); res != "8 8 6 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := gm.NodeSeq("main#", "1", "mynode"); err == nil {
+		t.Error("Invalid partition name should fail")
+		return
+	}
+}
@@ -14,9 +14,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
 )
 
@@ -32,10 +35,18 @@ const GraphManagerTestDBDir3 = "gmtest3"
 const GraphManagerTestDBDir4 = "gmtest4"
 const GraphManagerTestDBDir5 = "gmtest5"
 const GraphManagerTestDBDir6 = "gmtest6"
+const GraphManagerTestDBDir7 = "gmtest7"
+const GraphManagerTestDBDir8 = "gmtest8"
+const GraphManagerTestDBDir9 = "gmtest9"
+const GraphManagerTestDBDir10 = "gmtest10"
+const GraphManagerTestDBDir11 = "gmtest11"
+const GraphManagerTestDBDir12 = "gmtest12"
 
 var DBDIRS = []string{GraphManagerTestDBDir1, GraphManagerTestDBDir2,
 	GraphManagerTestDBDir3, GraphManagerTestDBDir4, GraphManagerTestDBDir5,
-	GraphManagerTestDBDir6}
+	GraphManagerTestDBDir6, GraphManagerTestDBDir7, GraphManagerTestDBDir8,
+	GraphManagerTestDBDir9, GraphManagerTestDBDir10, GraphManagerTestDBDir11,
+	GraphManagerTestDBDir12}
 
 const InvlaidFileName = "**" + "\x00"
 
@@ -75,3 +86,184 @@ NewGraphManager returns a new GraphManager instance without loading rules.
 func newGraphManagerNoRules(gs graphstorage.Storage) *Manager {
 	return createGraphManager(gs)
 }
+
+func TestGroupCommit(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	gs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir9, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs := gs.(*graphstorage.DiskGraphStorage)
+	dgs.SetGroupCommit(10 * time.Millisecond)
+
+	gm := NewGraphManager(dgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "0")
+	node.SetAttr(data.NodeKind, "mynode")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Concurrent writes share disk syncs
+
+	var wg sync.WaitGroup
+
+	for i := 1; i < 21; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, fmt.Sprint(i))
+			node.SetAttr(data.NodeKind, "mynode")
+
+			if err := gm.StoreNode("main", node); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if commits, syncs := dgs.GroupCommit().Stats(); commits != 21 || syncs < 2 || syncs >= 21 {
+		t.Error("Unexpected result:", commits, syncs)
+		return
+	}
+
+	// Transactions wait as well - empty transactions do not
+
+	trans := NewGraphTrans(gm)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	node = data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "21")
+	node.SetAttr(data.NodeKind, "mynode")
+	trans.StoreNode("main", node)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if commits, _ := dgs.GroupCommit().Stats(); commits != 22 {
+		t.Error("Unexpected result:", commits)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All committed data is on disk
+
+	gs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir9, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer gs.Close()
+
+	if res := NewGraphManager(gs).NodeCount("mynode"); res != 22 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestFlushAndSync(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+	trans := NewGraphTrans(gm)
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "mynode")
+		trans.StoreNode("main", node)
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The barrier waits for running write operations
+
+	synced := make(chan error)
+
+	gm.mutex.Lock()
+
+	go func() {
+		synced <- gm.FlushAndSync()
+	}()
+
+	select {
+	case err := <-synced:
+		t.Error("Sync should wait for the writer lock:", err)
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	gm.mutex.Unlock()
+
+	if err := <-synced; err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All committed data is on disk
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	if res := gm.NodeCount("mynode"); res != 10 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Storages which cannot sync are flushed
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm = NewGraphManager(mgs)
+
+	if err := gm.FlushAndSync(); err != nil {
+		t.Error(err)
+		return
+	}
+}
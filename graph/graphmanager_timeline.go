@@ -0,0 +1,682 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+TimelineBucketSize is the time span of a single time bucket of timestamped
+edges (in nanoseconds). Changing this value makes existing timelines unreadable.
+*/
+const TimelineBucketSize = int64(time.Hour)
+
+/*
+timelineEntry is an internal structure which stores a timestamped edge in the
+timeline of a node.
+*/
+type timelineEntry struct {
+	Time      int64  // Timestamp of the edge (Unix time in nanoseconds)
+	EdgeKey   string // Key of the edge
+	Role      string // Role of the node
+	OtherRole string // Role of the other end
+	OtherKey  string // Key of the other end
+	OtherKind string // Kind of the other end
+}
+
+func init() {
+
+	// Make sure we can use the relevant types in a gob operation
+
+	gob.Register([]int64{})
+	gob.Register([]*timelineEntry{})
+}
+
+/*
+TraverseTimeRange traverses from a given node to other nodes following
+timestamped edges (edges with a timestamp attribute) which match a given
+partial edge spec. The spec must contain an edge kind. Only edges with a
+timestamp in the interval [from, to) are followed. The results are ordered by
+timestamp. The last parameter allData specifies if all data should be retrieved
+for the connected nodes and edges. If set to false only the minimal set of
+attributes will be populated.
+*/
+func (gm *Manager) TraverseTimeRange(part string, key string, kind string,
+	spec string, from time.Time, to time.Time, allData bool) ([]data.Node, []data.Edge, error) {
+
+	return gm.TraverseTimeRangeLimit(part, key, kind, spec, from, to, allData, -1)
+}
+
+/*
+TraverseTimeRangeLimit traverses from a given node to other nodes following
+timestamped edges like TraverseTimeRange. The traversal stops with an
+ErrTraversalLimit error before more than max nodes are read (no limit if max
+is negative).
+*/
+func (gm *Manager) TraverseTimeRangeLimit(part string, key string, kind string,
+	spec string, from time.Time, to time.Time, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	nodes, edges, err := gm.traverseTimeRange(part, key, kind, spec, from, to, allData, max)
+
+	if err == nil && allData {
+		err = gm.FetchRemoteNodes(context.Background(), part, nodes)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, edges, nil
+}
+
+/*
+traverseTimeRange follows timestamped edges like TraverseTimeRangeLimit. Nodes
+of other shards are returned with their key and kind only.
+*/
+func (gm *Manager) traverseTimeRange(part string, key string, kind string,
+	spec string, from time.Time, to time.Time, allData bool, max int) ([]data.Node, []data.Edge, error) {
+
+	sspec := strings.Split(spec, ":")
+	if len(sspec) != 4 {
+		return nil, nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Invalid spec: " + spec}
+	} else if sspec[1] == "" {
+		return nil, nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Invalid spec: " + spec +
+			" - spec needs an edge kind for time range traversal"}
+	}
+
+	_, tree, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, nil, err
+	}
+
+	var edgeht *hash.HTree
+
+	if allData {
+		if edgeht, err = gm.getEdgeStorageHTree(part, sspec[1], false); err != nil || edgeht == nil {
+			return nil, nil, err
+		}
+	}
+
+	// Take reader lock
+
+	defer gm.readLock(part)()
+
+	enckind := gm.nm.Encode16(sspec[1], false)
+	if enckind == "" {
+		return nil, nil, nil
+	}
+
+	entries, err := timelineRange(tree, key+enckind, timelineNanos(from), timelineNanos(to))
+	if err != nil {
+		return nil, nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	var nodes []data.Node
+	var edges []data.Edge
+
+	for _, e := range entries {
+
+		if (sspec[0] != "" && e.Role != sspec[0]) ||
+			(sspec[2] != "" && e.OtherRole != sspec[2]) ||
+			(sspec[3] != "" && e.OtherKind != sspec[3]) {
+			continue
+		}
+
+		if max >= 0 && len(nodes) == max {
+			return nil, nil, &util.GraphError{Type: util.ErrTraversalLimit,
+				Detail: fmt.Sprintf("Node %v has more than %v connected nodes over %v", key, max, spec)}
+		}
+
+		var edge data.Edge
+		var node data.Node
+
+		if !allData {
+
+			// Populate nodes and edges with the minimal set of attributes
+
+			edge = data.NewGraphEdge()
+
+			edge.SetAttr(data.NodeKey, e.EdgeKey)
+			edge.SetAttr(data.NodeKind, sspec[1])
+			edge.SetAttr(data.EdgeEnd1Key, key)
+			edge.SetAttr(data.EdgeEnd1Kind, kind)
+			edge.SetAttr(data.EdgeEnd1Role, e.Role)
+			edge.SetAttr(data.EdgeEnd2Key, e.OtherKey)
+			edge.SetAttr(data.EdgeEnd2Kind, e.OtherKind)
+			edge.SetAttr(data.EdgeEnd2Role, e.OtherRole)
+			edge.SetAttr(data.EdgeTimestamp, time.Unix(0, e.Time).UTC())
+
+			node = data.NewGraphNode()
+
+			node.SetAttr(data.NodeKey, e.OtherKey)
+			node.SetAttr(data.NodeKind, e.OtherKind)
+
+		} else {
+
+			edgenode, err := gm.readNode(e.EdgeKey, sspec[1], nil, edgeht, edgeht)
+			if err != nil || edgenode == nil {
+				return nil, nil, err
+			}
+
+			edge = data.NewGraphEdgeFromNode(edgenode)
+
+			// Exchange ends if necessary
+
+			if edge.End2Key() == key && edge.End2Kind() == kind && edge.End2Role() == e.Role {
+				swapEdgeEnds(edge)
+			}
+
+			if gm.isRemote(part, e.OtherKey, e.OtherKind) {
+
+				// Nodes of other shards are returned with their key and kind
+
+				node = remoteNode(e.OtherKey, e.OtherKind)
+
+			} else {
+
+				attht, valht, err := gm.getNodeStorageHTree(part, e.OtherKind, false)
+				if err != nil || attht == nil || valht == nil {
+					return nil, nil, err
+				}
+
+				if node, err = gm.readNode(e.OtherKey, e.OtherKind, nil, attht, valht); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		nodes = append(nodes, node)
+		edges = append(edges, edge)
+	}
+
+	return nodes, edges, nil
+}
+
+/*
+swapEdgeEnds exchanges the ends of an edge.
+*/
+func swapEdgeEnds(edge data.Edge) {
+	swap := func(attr1 string, attr2 string) {
+		tmp := edge.Attr(attr1)
+		edge.SetAttr(attr1, edge.Attr(attr2))
+		edge.SetAttr(attr2, tmp)
+	}
+
+	swap(data.EdgeEnd1Key, data.EdgeEnd2Key)
+	swap(data.EdgeEnd1Kind, data.EdgeEnd2Kind)
+	swap(data.EdgeEnd1Role, data.EdgeEnd2Role)
+	swap(data.EdgeEnd1Cascading, data.EdgeEnd2Cascading)
+}
+
+/*
+EdgeTime returns the timestamp of a timestamped edge. The timestamp attribute
+can hold a datetime, a datetime string (see data.TimeFormats) or a number of
+seconds since the Unix epoch.
+*/
+func EdgeTime(edge data.Edge) (time.Time, bool) {
+
+	switch t := edge.Attr(data.EdgeTimestamp).(type) {
+
+	case time.Time:
+		return t, true
+
+	case string:
+		for _, f := range data.TimeFormats {
+			if ret, err := time.Parse(f, t); err == nil {
+				return ret, true
+			}
+		}
+
+	case int:
+		return time.Unix(int64(t), 0), true
+
+	case int64:
+		return time.Unix(t, 0), true
+
+	case float64:
+		return time.Unix(0, int64(t*1e9)), true
+	}
+
+	return time.Time{}, false
+}
+
+/*
+updateTimeline updates the timelines of the ends of an edge. It is assumed
+that the caller holds the writer lock. Either edge can be nil.
+*/
+func (gm *Manager) updateTimeline(edge data.Edge, oldedge data.Edge,
+	end1Tree *hash.HTree, end2Tree *hash.HTree) error {
+
+	var newTime, oldTime time.Time
+	var newOk, oldOk bool
+
+	if edge != nil {
+		newTime, newOk = EdgeTime(edge)
+	}
+
+	if oldedge != nil {
+		oldTime, oldOk = EdgeTime(oldedge)
+	}
+
+	if newOk && oldOk && newTime.Equal(oldTime) {
+		return nil
+	}
+
+	update := func(edge data.Edge, t time.Time, remove bool) error {
+		enckind := gm.nm.Encode16(edge.Kind(), true)
+
+		e1 := &timelineEntry{timelineNanos(t), edge.Key(), edge.End1Role(),
+			edge.End2Role(), edge.End2Key(), edge.End2Kind()}
+
+		e2 := &timelineEntry{timelineNanos(t), edge.Key(), edge.End2Role(),
+			edge.End1Role(), edge.End1Key(), edge.End1Kind()}
+
+		if remove {
+			if err := timelineRemove(end1Tree, edge.End1Key()+enckind, e1); err != nil {
+				return err
+			}
+			return timelineRemove(end2Tree, edge.End2Key()+enckind, e2)
+		}
+
+		if err := timelineAdd(end1Tree, edge.End1Key()+enckind, e1); err != nil {
+			return err
+		}
+		return timelineAdd(end2Tree, edge.End2Key()+enckind, e2)
+	}
+
+	if oldOk {
+		if err := update(oldedge, oldTime, true); err != nil {
+			return err
+		}
+	}
+
+	if newOk {
+		return update(edge, newTime, false)
+	}
+
+	return nil
+}
+
+/*
+RebuildTimelines rebuilds the timelines of all timestamped edges of a kind in
+a partition from the stored edge data. This adds edges which were stored
+before timelines were supported and repairs inconsistent timelines. An
+optional progress function is called after each processed edge.
+*/
+func (gm *Manager) RebuildTimelines(part string, kind string, progress IndexProgress) error {
+
+	edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+	if err != nil || edgeht == nil {
+		return err
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	trees := make(map[string]*hash.HTree)
+
+	err = gm.rebuildTimelines(part, kind, edgeht, trees, progress)
+
+	for nkind := range trees {
+		if err != nil {
+			gm.rollbackNodeStorage(part, nkind)
+		} else {
+			err = gm.flushNodeStorage(part, nkind)
+		}
+	}
+
+	return err
+}
+
+/*
+rebuildTimelines removes all timeline entries of an edge kind from the node
+storages and adds all stored timestamped edges again. All used node storage
+HTrees are added to the given map. Assumes that the caller holds the writer
+lock.
+*/
+func (gm *Manager) rebuildTimelines(part string, kind string, edgeht *hash.HTree,
+	trees map[string]*hash.HTree, progress IndexProgress) error {
+
+	var keys []string
+
+	nodeTree := func(nkind string) (*hash.HTree, error) {
+		if tree, ok := trees[nkind]; ok {
+			return tree, nil
+		}
+
+		_, tree, err := gm.getNodeStorageHTree(part, nkind, false)
+		if err == nil && tree != nil {
+			trees[nkind] = tree
+		}
+
+		return tree, err
+	}
+
+	// Collect all edge keys and the node storages of all edge ends - old
+	// timeline entries might be stored with any known node kind
+
+	for _, nkind := range gm.mainStringList(MainDBNodeKinds) {
+		if _, err := nodeTree(nkind); err != nil {
+			return err
+		}
+	}
+
+	it := hash.NewHTreeIterator(edgeht)
+	for it.HasNext() {
+		if k, _ := it.Next(); strings.HasPrefix(string(k), PrefixNSAttrs) {
+			key := string(k[len(PrefixNSAttrs):])
+
+			node, err := gm.readNode(key, kind, []string{data.EdgeEnd1Kind, data.EdgeEnd2Kind}, edgeht, edgeht)
+			if err != nil {
+				return err
+			} else if node == nil {
+				continue
+			}
+
+			for _, attr := range []string{data.EdgeEnd1Kind, data.EdgeEnd2Kind} {
+				if _, err := nodeTree(fmt.Sprint(node.Attr(attr))); err != nil {
+					return err
+				}
+			}
+
+			keys = append(keys, key)
+		}
+	}
+
+	if it.LastError != nil {
+		return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	// Remove all existing timeline entries of the edge kind
+
+	enckind := gm.nm.Encode16(kind, true)
+
+	for _, tree := range trees {
+		var remove [][]byte
+
+		it := hash.NewHTreeIterator(tree)
+		for it.HasNext() {
+			k, _ := it.Next()
+
+			if ks := string(k); (strings.HasPrefix(ks, PrefixNSTimeline) && strings.HasSuffix(ks, enckind)) ||
+				(strings.HasPrefix(ks, PrefixNSTimelineBucket) && len(ks) > 8 && strings.HasSuffix(ks[:len(ks)-8], enckind)) {
+				remove = append(remove, k)
+			}
+		}
+
+		if it.LastError != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+		}
+
+		for _, k := range remove {
+			if _, err := tree.Remove(k); err != nil {
+				return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			}
+		}
+	}
+
+	// Add all timestamped edges
+
+	for i, key := range keys {
+
+		node, err := gm.readNode(key, kind, nil, edgeht, edgeht)
+		if err != nil {
+			return err
+		}
+
+		edge := data.NewGraphEdgeFromNode(node)
+
+		// Edges need stored ends to be part of a timeline - remote ends are
+		// stored by other shards
+
+		end1Tree, end2Tree := trees[edge.End1Kind()], trees[edge.End2Kind()]
+		end1Remote := gm.isRemote(part, edge.End1Key(), edge.End1Kind())
+		end2Remote := gm.isRemote(part, edge.End2Key(), edge.End2Kind())
+
+		if end1Remote {
+			end1Tree = nil
+		}
+		if end2Remote {
+			end2Tree = nil
+		}
+
+		if (end1Tree != nil || end1Remote) && (end2Tree != nil || end2Remote) {
+			if err := gm.updateTimeline(edge, nil, end1Tree, end2Tree); err != nil {
+				return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			}
+		}
+
+		if progress != nil {
+			progress(uint64(i+1), uint64(len(keys)))
+		}
+	}
+
+	return nil
+}
+
+/*
+timelineNanos converts a time into nanoseconds since the Unix epoch. Times
+which cannot be represented are clamped.
+*/
+func timelineNanos(t time.Time) int64 {
+
+	if t.Before(time.Unix(0, math.MinInt64)) {
+		return math.MinInt64
+	} else if t.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+
+	return t.UnixNano()
+}
+
+/*
+timelineBucket returns the time bucket of a timestamp.
+*/
+func timelineBucket(t int64) int64 {
+	b := t / TimelineBucketSize
+
+	if t%TimelineBucketSize < 0 {
+		b--
+	}
+
+	return b
+}
+
+/*
+timelineBucketKey returns the storage key of a time bucket.
+*/
+func timelineBucketKey(timeline string, bucket int64) []byte {
+	var b [8]byte
+
+	binary.BigEndian.PutUint64(b[:], uint64(bucket))
+
+	return []byte(PrefixNSTimelineBucket + timeline + string(b[:]))
+}
+
+/*
+timelineLess defines the order of timeline entries.
+*/
+func timelineLess(e1 *timelineEntry, e2 *timelineEntry) bool {
+	if e1.Time != e2.Time {
+		return e1.Time < e2.Time
+	} else if e1.EdgeKey != e2.EdgeKey {
+		return e1.EdgeKey < e2.EdgeKey
+	}
+	return e1.Role < e2.Role
+}
+
+/*
+timelineAdd adds an entry to a timeline. Nothing is done for the timeline of a
+remote node (nil HTree).
+*/
+func timelineAdd(tree *hash.HTree, timeline string, entry *timelineEntry) error {
+	var entries []*timelineEntry
+
+	if tree == nil {
+		return nil
+	}
+
+	bucket := timelineBucket(entry.Time)
+	bucketKey := timelineBucketKey(timeline, bucket)
+
+	obj, err := tree.Get(bucketKey)
+	if err != nil {
+		return err
+	}
+
+	if obj == nil {
+
+		// Register the new bucket
+
+		var buckets []int64
+
+		obj, err := tree.Get([]byte(PrefixNSTimeline + timeline))
+		if err != nil {
+			return err
+		} else if obj != nil {
+			buckets = obj.([]int64)
+		}
+
+		i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= bucket })
+
+		buckets = append(buckets, 0)
+		copy(buckets[i+1:], buckets[i:])
+		buckets[i] = bucket
+
+		if _, err := tree.Put([]byte(PrefixNSTimeline+timeline), buckets); err != nil {
+			return err
+		}
+
+	} else {
+		entries = obj.([]*timelineEntry)
+	}
+
+	// Entries are usually appended so the search should end quickly
+
+	i := len(entries)
+	for i > 0 && timelineLess(entry, entries[i-1]) {
+		i--
+	}
+
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry
+
+	_, err = tree.Put(bucketKey, entries)
+
+	return err
+}
+
+/*
+timelineRemove removes an entry from a timeline. Nothing is done for the
+timeline of a remote node (nil HTree).
+*/
+func timelineRemove(tree *hash.HTree, timeline string, entry *timelineEntry) error {
+
+	if tree == nil {
+		return nil
+	}
+
+	bucket := timelineBucket(entry.Time)
+	bucketKey := timelineBucketKey(timeline, bucket)
+
+	obj, err := tree.Get(bucketKey)
+	if err != nil || obj == nil {
+		return err
+	}
+
+	entries := obj.([]*timelineEntry)
+
+	for i, e := range entries {
+		if e.EdgeKey == entry.EdgeKey && e.Role == entry.Role && e.Time == entry.Time {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+
+	if len(entries) > 0 {
+		_, err = tree.Put(bucketKey, entries)
+		return err
+	}
+
+	// Remove the empty bucket
+
+	if _, err = tree.Remove(bucketKey); err != nil {
+		return err
+	}
+
+	if obj, err = tree.Get([]byte(PrefixNSTimeline + timeline)); err != nil || obj == nil {
+		return err
+	}
+
+	buckets := obj.([]int64)
+
+	if i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= bucket }); i < len(buckets) && buckets[i] == bucket {
+		buckets = append(buckets[:i], buckets[i+1:]...)
+	}
+
+	if len(buckets) == 0 {
+		_, err = tree.Remove([]byte(PrefixNSTimeline + timeline))
+	} else {
+		_, err = tree.Put([]byte(PrefixNSTimeline+timeline), buckets)
+	}
+
+	return err
+}
+
+/*
+timelineRange returns all entries of a timeline in the interval [from, to).
+*/
+func timelineRange(tree *hash.HTree, timeline string, from int64, to int64) ([]*timelineEntry, error) {
+	var ret []*timelineEntry
+
+	if from >= to {
+		return nil, nil
+	}
+
+	obj, err := tree.Get([]byte(PrefixNSTimeline + timeline))
+	if err != nil || obj == nil {
+		return nil, err
+	}
+
+	buckets := obj.([]int64)
+	first, last := timelineBucket(from), timelineBucket(to-1)
+
+	for i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= first }); i < len(buckets) && buckets[i] <= last; i++ {
+
+		obj, err := tree.Get(timelineBucketKey(timeline, buckets[i]))
+		if err != nil {
+			return nil, err
+		} else if obj == nil {
+			continue
+		}
+
+		for _, e := range obj.([]*timelineEntry) {
+			if e.Time >= from && e.Time < to {
+				ret = append(ret, e)
+			}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,380 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestTimeline(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for _, key := range []string{"u1", "u2"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "User")
+		gm.StoreNode("main", node)
+	}
+
+	for _, key := range []string{"p1", "p2", "p3"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Page")
+		node.SetAttr(data.NodeName, "Page "+key)
+		gm.StoreNode("main", node)
+	}
+
+	visit := func(key string, user string, page string, ts interface{}) data.Edge {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "Visited")
+		edge.SetAttr(data.EdgeEnd1Key, user)
+		edge.SetAttr(data.EdgeEnd1Kind, "User")
+		edge.SetAttr(data.EdgeEnd1Role, "visitor")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, page)
+		edge.SetAttr(data.EdgeEnd2Kind, "Page")
+		edge.SetAttr(data.EdgeEnd2Role, "page")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		if ts != nil {
+			edge.SetAttr(data.EdgeTimestamp, ts)
+		}
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+
+		return edge
+	}
+
+	base := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	visit("v1", "u1", "p1", base)
+	visit("v2", "u1", "p2", base.Add(-3*time.Hour))
+	visit("v3", "u1", "p3", "2020-05-01T12:30:00Z")
+	visit("v4", "u1", "p1", base.Add(-48*time.Hour).Unix())
+	visit("v5", "u2", "p1", time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC))
+	visit("v6", "u1", "p2", nil)
+	visit("v7", "u1", "p2", "foo")
+
+	keys := func(nodes []data.Node, edges []data.Edge) string {
+		var ret []string
+		for i, e := range edges {
+			ret = append(ret, fmt.Sprintf("%v>%v", e.Key(), nodes[i].Key()))
+		}
+		return fmt.Sprint(ret)
+	}
+
+	traverse := func(key string, kind string, spec string, from time.Time, to time.Time) string {
+		nodes, edges, err := gm.TraverseTimeRange("main", key, kind, spec, from, to, false)
+		if err != nil {
+			return err.Error()
+		}
+		return keys(nodes, edges)
+	}
+
+	if res := traverse("u1", "User", ":Visited::", base.Add(-72*time.Hour), base.Add(time.Hour)); res != "[v4>p1 v2>p2 v1>p1 v3>p3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", ":Visited::", base.Add(-3*time.Hour), base); res != "[v2>p2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", "visitor:Visited:page:Page", base, base.Add(time.Minute)); res != "[v1>p1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", "page:Visited::", base.Add(-72*time.Hour), base.Add(time.Hour)); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u2", "User", ":Visited::", time.Date(1959, 1, 1, 0, 0, 0, 0, time.UTC), base); res != "[v5>p1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("p1", "Page", ":Visited::", time.Time{}, base.Add(time.Hour)); res != "[v5>u2 v4>u1 v1>u1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", ":Likes::", time.Time{}, base); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", ":::", time.Time{}, base); res != "GraphError: Invalid data (Invalid spec: ::: - spec needs an edge kind for time range traversal)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := traverse("u1", "User", "::", time.Time{}, base); res != "GraphError: Invalid data (Invalid spec: ::)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Get all data traversing from the other end
+
+	nodes, edges, err := gm.TraverseTimeRange("main", "p1", "Page", ":Visited::",
+		base.Add(-time.Hour), base.Add(time.Hour), true)
+
+	if err != nil || len(nodes) != 1 || edges[0].End1Key() != "p1" || edges[0].End1Role() != "page" ||
+		edges[0].End2Key() != "u1" || edges[0].Attr(data.EdgeTimestamp) != base {
+		t.Error("Unexpected result:", nodes, edges, err)
+		return
+	}
+
+	// Traversals with a limit stop before nodes above the limit are read
+
+	if _, _, err := gm.TraverseTimeRangeLimit("main", "u1", "User", ":Visited::",
+		base.Add(-2*time.Hour), base.Add(time.Hour), false, 1); err == nil ||
+		err.Error() != "GraphError: Traversal limit exceeded (Node u1 has more than 1 connected nodes over :Visited::)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Update the timestamp of an edge
+
+	visit("v1", "u1", "p1", base.Add(-time.Hour))
+
+	if res := traverse("u1", "User", ":Visited::", base.Add(-2*time.Hour), base.Add(time.Hour)); res != "[v1>p1 v3>p3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Updates which keep the timestamp do not change the timeline
+
+	visit("v1", "u1", "p1", base.Add(-time.Hour))
+
+	if res := traverse("u1", "User", ":Visited::", base.Add(-2*time.Hour), base.Add(time.Hour)); res != "[v1>p1 v3>p3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Remove edges - empty time buckets are removed
+
+	_, tree, _ := gm.getNodeStorageHTree("main", "User", false)
+	timeline := PrefixNSTimeline + "u1" + gm.nm.Encode16("Visited", false)
+
+	if obj, _ := tree.Get([]byte(timeline)); len(obj.([]int64)) != 4 {
+		t.Error("Unexpected result:", obj)
+		return
+	}
+
+	gm.RemoveEdge("main", "v3", "Visited")
+	gm.RemoveEdge("main", "v4", "Visited")
+
+	if res := traverse("u1", "User", ":Visited::", time.Time{}, base.Add(time.Hour)); res != "[v2>p2 v1>p1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.RemoveEdge("main", "v1", "Visited")
+	gm.RemoveEdge("main", "v2", "Visited")
+
+	if obj, _ := tree.Get([]byte(timeline)); obj != nil {
+		t.Error("Unexpected result:", obj)
+		return
+	}
+
+	// Transactions maintain the timelines as well
+
+	trans := NewGraphTrans(gm)
+
+	edge := visit("v8", "u2", "p2", base)
+	gm.RemoveEdge("main", "v8", "Visited")
+
+	trans.StoreEdge("main", edge)
+	trans.Commit()
+
+	if res := traverse("u2", "User", ":Visited::", base, base.Add(time.Hour)); res != "[v8>p2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans.RemoveEdge("main", "v8", "Visited")
+	trans.Commit()
+
+	if res := traverse("u2", "User", ":Visited::", base, base.Add(time.Hour)); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removing a node removes its edges and their timeline entries
+
+	visit("v9", "u2", "p3", base)
+	gm.RemoveNode("main", "p3", "Page")
+
+	if res := traverse("u2", "User", ":Visited::", base, base.Add(time.Hour)); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestTimelineHelpers(t *testing.T) {
+
+	if res := timelineBucket(-1); res != -1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := timelineBucket(TimelineBucketSize); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := timelineNanos(time.Time{}); res != math.MinInt64 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := timelineNanos(time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)); res != math.MaxInt64 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	edge := data.NewGraphEdge()
+
+	for _, test := range []struct {
+		val interface{}
+		res string
+	}{
+		{time.Unix(5, 0), "5000000000"},
+		{"1970-01-01", "0"},
+		{5, "5000000000"},
+		{int64(5), "5000000000"},
+		{1.5, "1500000000"},
+		{"foo", "false"},
+		{true, "false"},
+	} {
+		edge.SetAttr(data.EdgeTimestamp, test.val)
+
+		res := "false"
+		if ts, ok := EdgeTime(edge); ok {
+			res = fmt.Sprint(ts.UnixNano())
+		}
+
+		if res != test.res {
+			t.Error("Unexpected result:", test.val, res)
+			return
+		}
+	}
+}
+
+func TestRebuildTimelines(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for _, kind := range []string{"User", "Page"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, "1")
+		node.SetAttr(data.NodeKind, kind)
+		gm.StoreNode("main", node)
+	}
+
+	base := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, fmt.Sprint("v", i))
+		edge.SetAttr(data.NodeKind, "Visited")
+		edge.SetAttr(data.EdgeEnd1Key, "1")
+		edge.SetAttr(data.EdgeEnd1Kind, "User")
+		edge.SetAttr(data.EdgeEnd1Role, "visitor")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "1")
+		edge.SetAttr(data.EdgeEnd2Kind, "Page")
+		edge.SetAttr(data.EdgeEnd2Role, "page")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		edge.SetAttr(data.EdgeTimestamp, base.Add(time.Duration(i)*time.Hour))
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	traverse := func() string {
+		_, edges, err := gm.TraverseTimeRange("main", "1", "User", ":Visited::",
+			base, base.Add(24*time.Hour), false)
+
+		var ret []string
+		for _, e := range edges {
+			ret = append(ret, e.Key())
+		}
+
+		return fmt.Sprint(ret, err)
+	}
+
+	if res := traverse(); res != "[v0 v1 v2] <nil>" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Simulate a datastore which was created before timelines were supported
+	// and a timeline with a stale entry
+
+	_, userTree, _ := gm.getNodeStorageHTree("main", "User", false)
+	_, pageTree, _ := gm.getNodeStorageHTree("main", "Page", false)
+
+	enckind := gm.nm.Encode16("Visited", false)
+
+	userTree.Remove([]byte(PrefixNSTimeline + "1" + enckind))
+	userTree.Remove(timelineBucketKey("1"+enckind, timelineBucket(base.UnixNano())))
+	timelineAdd(pageTree, "1"+enckind, &timelineEntry{base.UnixNano(), "v9", "page", "visitor", "1", "User"})
+
+	if res := traverse(); res != "[] <nil>" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var done, total uint64
+
+	if err := gm.RebuildTimelines("main", "Visited", func(d uint64, t uint64) {
+		done, total = d, t
+	}); err != nil || done != 3 || total != 3 {
+		t.Error("Unexpected result:", done, total, err)
+		return
+	}
+
+	if res := traverse(); res != "[v0 v1 v2] <nil>" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	_, edges, err := gm.TraverseTimeRange("main", "1", "Page", ":Visited::",
+		base, base.Add(24*time.Hour), false)
+
+	if len(edges) != 3 || err != nil {
+		t.Error("Unexpected result:", edges, err)
+		return
+	}
+
+	// Unknown kinds are ignored
+
+	if err := gm.RebuildTimelines("main", "Foo", nil); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+PartitionMetrics are structural metrics of the graph in a partition.
+*/
+type PartitionMetrics struct {
+	Partition        string            `json:"partition"`         // Name of the partition
+	Nodes            uint64            `json:"nodes"`             // Number of nodes
+	Edges            uint64            `json:"edges"`             // Number of edges
+	NodeCounts       map[string]uint64 `json:"node_counts"`       // Number of nodes of each kind
+	EdgeCounts       map[string]uint64 `json:"edge_counts"`       // Number of edges of each kind
+	Density          float64           `json:"density"`           // Ratio of edges to possible edges between different nodes
+	Components       uint64            `json:"components"`        // Number of connected components
+	LargestComponent uint64            `json:"largest_component"` // Number of nodes in the largest connected component
+	IsolatedNodes    uint64            `json:"isolated_nodes"`    // Number of nodes without edges
+	MaxDegree        uint64            `json:"max_degree"`        // Highest number of edges of a node
+	DegreeHistogram  []*DegreeBucket   `json:"degree_histogram"`  // Number of nodes by number of edges
+}
+
+/*
+PartitionUsage is the number and the estimated size of the nodes and edges in
+a partition.
+*/
+type PartitionUsage struct {
+	Nodes uint64 `json:"nodes"` // Number of nodes
+	Edges uint64 `json:"edges"` // Number of edges
+	Bytes int64  `json:"bytes"` // Estimated size of all nodes and edges in bytes
+}
+
+/*
+DegreeBucket is a bucket of a degree histogram. Buckets grow exponentially
+(0, 1, 2-3, 4-7, ...) so the histogram stays small for large graphs.
+*/
+type DegreeBucket struct {
+	Min   uint64 `json:"min"`   // Smallest degree in the bucket
+	Max   uint64 `json:"max"`   // Largest degree in the bucket
+	Count uint64 `json:"count"` // Number of nodes with a degree in the bucket
+}
+
+/*
+PartitionMetrics calculates structural metrics of the graph in a partition.
+All nodes and edges of the partition are read - writes to the partition are
+blocked during the calculation. Edges which connect to nodes which do not exist
+in the partition count these nodes as part of the partition.
+*/
+func (gm *Manager) PartitionMetrics(part string) (*PartitionMetrics, error) {
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	metrics := &PartitionMetrics{part, 0, 0, make(map[string]uint64), make(map[string]uint64),
+		0, 0, 0, 0, 0, []*DegreeBucket{}}
+
+	nodeIDs := make(map[string]int)
+	var degrees []uint64
+	var parents []int
+
+	// Nodes are numbered and connected components are tracked with a
+	// union-find structure
+
+	find := func(i int) int {
+		for parents[i] != i {
+			parents[i] = parents[parents[i]]
+			i = parents[i]
+		}
+		return i
+	}
+
+	nodeID := func(key string, kind string) int {
+		id := fmt.Sprint(kind, "#", key)
+
+		i, ok := nodeIDs[id]
+		if !ok {
+			i = len(parents)
+			nodeIDs[id] = i
+			parents = append(parents, i)
+			degrees = append(degrees, 0)
+		}
+
+		return i
+	}
+
+	nodeKinds := gm.NodeKinds()
+	edgeKinds := gm.EdgeKinds()
+
+	// Take reader lock
+
+	defer gm.readLock(part)()
+
+	// Collect all nodes
+
+	for _, kind := range nodeKinds {
+
+		keys, err := gm.nodeKeys(part, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			metrics.NodeCounts[kind] = uint64(len(keys))
+			metrics.Nodes += uint64(len(keys))
+		}
+
+		for _, key := range keys {
+			nodeID(key, kind)
+		}
+	}
+
+	// Connect the nodes of all edges
+
+	endAttrs := []string{data.EdgeEnd1Key, data.EdgeEnd1Kind, data.EdgeEnd2Key, data.EdgeEnd2Kind}
+
+	for _, kind := range edgeKinds {
+
+		edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+		if err != nil {
+			return nil, err
+		} else if edgeht == nil {
+			continue
+		}
+
+		keys, err := edgeKeys(edgeht)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+
+			node, err := gm.readNode(key, kind, endAttrs, edgeht, edgeht)
+			if err != nil {
+				return nil, err
+			} else if node == nil {
+				continue
+			}
+
+			edge := data.NewGraphEdgeFromNode(node)
+
+			end1 := nodeID(edge.End1Key(), edge.End1Kind())
+			end2 := nodeID(edge.End2Key(), edge.End2Kind())
+
+			degrees[end1]++
+			degrees[end2]++
+
+			if r1, r2 := find(end1), find(end2); r1 != r2 {
+				parents[r1] = r2
+			}
+
+			metrics.EdgeCounts[kind]++
+			metrics.Edges++
+		}
+	}
+
+	// Calculate component sizes and the degree histogram
+
+	sizes := make(map[int]uint64)
+
+	for i, degree := range degrees {
+		sizes[find(i)]++
+
+		if degree == 0 {
+			metrics.IsolatedNodes++
+		}
+
+		if degree > metrics.MaxDegree {
+			metrics.MaxDegree = degree
+		}
+
+		bucket := 0
+		for d := degree; d > 0; d >>= 1 {
+			bucket++
+		}
+
+		for b := len(metrics.DegreeHistogram); b <= bucket; b++ {
+			min, max := uint64(0), uint64(0)
+			if b > 0 {
+				min, max = 1<<uint(b-1), 1<<uint(b)-1
+			}
+			metrics.DegreeHistogram = append(metrics.DegreeHistogram, &DegreeBucket{min, max, 0})
+		}
+
+		metrics.DegreeHistogram[bucket].Count++
+	}
+
+	metrics.Components = uint64(len(sizes))
+
+	for _, size := range sizes {
+		if size > metrics.LargestComponent {
+			metrics.LargestComponent = size
+		}
+	}
+
+	if n := float64(len(degrees)); n > 1 {
+		metrics.Density = float64(metrics.Edges) / (n * (n - 1) / 2)
+	}
+
+	return metrics, nil
+}
+
+/*
+PartitionUsage counts the nodes and edges of a partition and estimates their
+size. All nodes and edges of the partition are read - writes to the partition
+are blocked during the calculation.
+*/
+func (gm *Manager) PartitionUsage(part string) (*PartitionUsage, error) {
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	usage := &PartitionUsage{}
+
+	nodeKinds := gm.NodeKinds()
+	edgeKinds := gm.EdgeKinds()
+
+	// Take reader lock
+
+	defer gm.readLock(part)()
+
+	for _, kind := range nodeKinds {
+
+		attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+		if err != nil {
+			return nil, err
+		} else if attht == nil || valht == nil {
+			continue
+		}
+
+		keys, err := gm.nodeKeys(part, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+
+			node, err := gm.readNode(key, kind, nil, attht, valht)
+			if err != nil {
+				return nil, err
+			} else if node != nil {
+				usage.Nodes++
+				usage.Bytes += estimateSize(node)
+			}
+		}
+	}
+
+	for _, kind := range edgeKinds {
+
+		edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+		if err != nil {
+			return nil, err
+		} else if edgeht == nil {
+			continue
+		}
+
+		keys, err := edgeKeys(edgeht)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+
+			node, err := gm.readNode(key, kind, nil, edgeht, edgeht)
+			if err != nil {
+				return nil, err
+			} else if node != nil {
+				usage.Edges++
+				usage.Bytes += estimateSize(node)
+			}
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,203 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestPartitionMetrics(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(key string, kind string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", kind)
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeEdge := func(key string, kind string, end1 string, end2 string) {
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", key)
+		edge.SetAttr("kind", kind)
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd1Role, "node")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd2Role, "node")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A star with 5 nodes, a pair and 2 isolated nodes
+
+	for i := 1; i <= 9; i++ {
+		storeNode(fmt.Sprint(i), "mynode")
+	}
+
+	for i := 2; i <= 5; i++ {
+		storeEdge(fmt.Sprint("s", i), "star", "1", fmt.Sprint(i))
+	}
+
+	storeEdge("p1", "pair", "6", "7")
+
+	storeNode("1", "othernode")
+	storeNode("a", "othernode")
+
+	// Nodes in other partitions are not counted
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mynode")
+	gm.StoreNode("other", node)
+
+	metrics, err := gm.PartitionMetrics("main")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, _ := json.MarshalIndent(metrics, "", "  ")
+
+	if string(res) != `
+{
+  "partition": "main",
+  "nodes": 11,
+  "edges": 5,
+  "node_counts": {
+    "mynode": 9,
+    "othernode": 2
+  },
+  "edge_counts": {
+    "pair": 1,
+    "star": 4
+  },
+  "density": 0.09090909090909091,
+  "components": 6,
+  "largest_component": 5,
+  "isolated_nodes": 4,
+  "max_degree": 4,
+  "degree_histogram": [
+    {
+      "min": 0,
+      "max": 0,
+      "count": 4
+    },
+    {
+      "min": 1,
+      "max": 1,
+      "count": 6
+    },
+    {
+      "min": 2,
+      "max": 3,
+      "count": 0
+    },
+    {
+      "min": 4,
+      "max": 7,
+      "count": 1
+    }
+  ]
+}`[1:] {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	// Test empty partition and error case
+
+	if metrics, err := gm.PartitionMetrics("empty"); err != nil ||
+		metrics.Nodes != 0 || metrics.Components != 0 || len(metrics.DegreeHistogram) != 0 {
+		t.Error("Unexpected result:", metrics, err)
+		return
+	}
+
+	if _, err := gm.PartitionMetrics("my-part"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Partition name my-part is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestPartitionUsage(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	node1 := data.NewGraphNode()
+	node1.SetAttr("key", "1")
+	node1.SetAttr("kind", "mynode")
+	node1.SetAttr("name", "foo")
+
+	node2 := data.NewGraphNode()
+	node2.SetAttr("key", "2")
+	node2.SetAttr("kind", "mynode")
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd1Role, "node")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "2")
+	edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd2Role, "node")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	for _, n := range []data.Node{node1, node2} {
+		if err := gm.StoreNode("main", n); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	usage, err := gm.PartitionUsage("main")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	bytes := EstimateSize(node1) + EstimateSize(node2) + EstimateSize(edge)
+
+	if usage.Nodes != 2 || usage.Edges != 1 || usage.Bytes != bytes {
+		t.Error("Unexpected result:", usage, bytes)
+		return
+	}
+
+	if usage, err := gm.PartitionUsage("empty"); err != nil || usage.Nodes != 0 || usage.Bytes != 0 {
+		t.Error("Unexpected result:", usage, err)
+		return
+	}
+
+	if _, err := gm.PartitionUsage("my-part"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
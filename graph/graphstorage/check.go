@@ -0,0 +1,46 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Check verifies the consistency of the storage files of all storage managers
+on disk. Storage managers which cannot be checked (e.g. storage managers of a
+key-value engine) are skipped.
+*/
+func (dgs *DiskGraphStorage) Check() ([]*storage.CheckReport, error) {
+	var reports []*storage.CheckReport
+
+	smnames, err := dgs.storageManagerNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, smname := range smnames {
+
+		if cm, ok := dgs.StorageManager(smname, false).(storage.CheckingManager); ok {
+
+			report, err := cm.Check()
+			if err != nil {
+				return reports, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+			}
+
+			report.Name = smname
+			reports = append(reports, report)
+		}
+	}
+
+	return reports, nil
+}
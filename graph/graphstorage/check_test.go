@@ -0,0 +1,57 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiskGraphStorageCheck(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs := gs.(*DiskGraphStorage)
+
+	for _, smname := range []string{"test1", "test2"} {
+		sm := dgs.StorageManager(smname, true)
+
+		for i := 0; i < 100; i++ {
+			sm.Insert(fmt.Sprint("data", i))
+		}
+
+		sm.Flush()
+	}
+
+	dgs.Close()
+
+	// Storage files which have not been opened yet are checked as well
+
+	gs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir7, false)
+	dgs = gs.(*DiskGraphStorage)
+	defer dgs.Close()
+
+	reports, err := dgs.Check()
+	if err != nil || len(reports) != 2 {
+		t.Error("Unexpected result:", reports, err)
+		return
+	}
+
+	for i, smname := range []string{"test1", "test2"} {
+		if r := reports[i]; r.Name != smname || !r.Consistent() || r.Records != 100 {
+			t.Error("Unexpected result:", r)
+			return
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+CompactionStatus is the status of a compaction of a DiskGraphStorage.
+*/
+type CompactionStatus struct {
+	Running   bool   `json:"running"`           // Flag if the compaction is running
+	Current   string `json:"current,omitempty"` // Storage manager which is being compacted
+	Done      uint64 `json:"done"`              // Processed locations of the current storage manager
+	Total     uint64 `json:"total"`             // Total locations of the current storage manager
+	Compacted int    `json:"compacted"`         // Number of compacted storage managers
+	Managers  int    `json:"managers"`          // Total number of storage managers
+	Reclaimed int64  `json:"reclaimed"`         // Number of reclaimed bytes
+	Started   int64  `json:"started"`           // Start time of the compaction
+	Finished  int64  `json:"finished"`          // Finish time of the compaction
+	Error     string `json:"error,omitempty"`   // Error of a failed compaction
+}
+
+/*
+Compact reclaims the space of deleted and updated data in all storage files.
+The storage stays online during the compaction. Each storage file is copied
+in batches of the given size with the given pause between batches. The
+progress of the compaction can be retrieved with CompactionStatus.
+*/
+func (dgs *DiskGraphStorage) Compact(batchSize int, pause time.Duration) error {
+
+	// Fail operation when readonly
+
+	if dgs.readonly {
+		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot compact storage"}
+	}
+
+	smnames, err := dgs.storageManagerNames()
+	if err != nil {
+		return err
+	}
+
+	dgs.mutex.Lock()
+
+	if dgs.compaction != nil && dgs.compaction.Running {
+		dgs.mutex.Unlock()
+		return &util.GraphError{Type: util.ErrAccessComponent, Detail: storage.ErrCompacting.Error()}
+	}
+
+	status := &CompactionStatus{Running: true, Managers: len(smnames), Started: time.Now().Unix()}
+	dgs.compaction = status
+
+	dgs.mutex.Unlock()
+
+	for _, smname := range smnames {
+
+		dgs.mutex.Lock()
+		status.Current = smname
+		status.Done = 0
+		status.Total = 0
+		dgs.mutex.Unlock()
+
+		var reclaimed int64
+
+		if cm, ok := dgs.StorageManager(smname, false).(storage.CompactingManager); ok {
+			reclaimed, err = cm.Compact(batchSize, pause, func(done uint64, total uint64) {
+				dgs.mutex.Lock()
+				status.Done = done
+				status.Total = total
+				dgs.mutex.Unlock()
+			})
+		}
+
+		dgs.mutex.Lock()
+
+		if err != nil {
+			status.Error = fmt.Sprint(smname, ": ", err.Error())
+		} else {
+			status.Compacted++
+			status.Reclaimed += reclaimed
+		}
+
+		dgs.mutex.Unlock()
+
+		if err != nil {
+			break
+		}
+	}
+
+	dgs.mutex.Lock()
+
+	status.Running = false
+	status.Current = ""
+	status.Finished = time.Now().Unix()
+
+	dgs.mutex.Unlock()
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrAccessComponent, Detail: status.Error}
+	}
+
+	return nil
+}
+
+/*
+CompactionStatus returns the status of the last or running compaction. Returns
+nil if there was no compaction.
+*/
+func (dgs *DiskGraphStorage) CompactionStatus() *CompactionStatus {
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	if dgs.compaction == nil {
+		return nil
+	}
+
+	status := *dgs.compaction
+
+	return &status
+}
+
+/*
+storageManagerNames returns the sorted names of all storage managers on disk.
+*/
+func (dgs *DiskGraphStorage) storageManagerNames() ([]string, error) {
+
+	if dgs.engine != EnginePages {
+		dirs, err := filepath.Glob(fmt.Sprintf("%v/*.%v", dgs.name, FileSuffixKeyValue))
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		}
+
+		var smnames []string
+
+		for _, d := range dirs {
+			smnames = append(smnames, strings.TrimSuffix(filepath.Base(d), "."+FileSuffixKeyValue))
+		}
+
+		sort.Strings(smnames)
+
+		return smnames, nil
+	}
+
+	files, err := dgs.backend.Glob(fmt.Sprintf("%v/*.%v.0", dgs.name, storage.FileSuffixPhysicalSlots))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+	}
+
+	var smnames []string
+
+	for _, f := range files {
+		smname := strings.TrimSuffix(filepath.Base(f), "."+storage.FileSuffixPhysicalSlots+".0")
+
+		if !strings.HasSuffix(smname, "."+storage.FileSuffixCompaction) {
+			smnames = append(smnames, smname)
+		}
+	}
+
+	sort.Strings(smnames)
+
+	return smnames, nil
+}
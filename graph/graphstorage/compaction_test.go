@@ -0,0 +1,108 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiskGraphStorageCompaction(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir4, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs := gs.(*DiskGraphStorage)
+
+	if res := dgs.CompactionStatus(); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Create two storage files and delete most of their data again
+
+	kept := make(map[string][]uint64)
+
+	for _, smname := range []string{"test1", "test2"} {
+		var locs []uint64
+
+		sm := dgs.StorageManager(smname, true)
+
+		for i := 0; i < 500; i++ {
+			loc, _ := sm.Insert(fmt.Sprint(i, strings.Repeat("x", 500)))
+			locs = append(locs, loc)
+		}
+
+		sm.Flush()
+
+		for i, loc := range locs {
+			if i%5 == 0 {
+				kept[smname] = append(kept[smname], loc)
+			} else {
+				sm.Free(loc)
+			}
+		}
+
+		sm.Flush()
+	}
+
+	dgs.Close()
+
+	// Storage files which have not been opened yet are compacted as well
+
+	gs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir4, false)
+	dgs = gs.(*DiskGraphStorage)
+
+	dgs.StorageManager("test1", false)
+
+	if err := dgs.Compact(100, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	status := dgs.CompactionStatus()
+
+	if status.Running || status.Compacted != 2 || status.Managers != 2 || status.Reclaimed <= 0 ||
+		status.Done != status.Total || status.Error != "" || status.Finished == 0 {
+		t.Error("Unexpected result:", status)
+		return
+	}
+
+	for smname, locs := range kept {
+		var res string
+
+		sm := dgs.StorageManager(smname, false)
+
+		for i, loc := range locs {
+			if err := sm.Fetch(loc, &res); err != nil || res != fmt.Sprint(i*5, strings.Repeat("x", 500)) {
+				t.Error("Unexpected result:", smname, i, res, err)
+				return
+			}
+		}
+	}
+
+	dgs.Close()
+
+	// Readonly storages cannot be compacted
+
+	gs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir4, true)
+
+	if err := gs.(*DiskGraphStorage).Compact(100, 0); err == nil ||
+		err.Error() != "GraphError: Failed write to readonly storage (Cannot compact storage)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gs.Close()
+}
@@ -18,13 +18,18 @@ package graphstorage
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/codec"
 	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 /*
@@ -32,33 +37,111 @@ FilenameNameDB is the filename for the name storage file
 */
 var FilenameNameDB = "names.pm"
 
+/*
+FilenamePartitionDB is the filename of the file which stores the partition of
+each storage manager
+*/
+var FilenamePartitionDB = "partitions.pm"
+
+/*
+FilenameHealthProbe is the filename of the temporary file which is written
+during a health check
+*/
+var FilenameHealthProbe = "health.probe"
+
+/*
+Durability modes of a DiskGraphStorage
+*/
+const (
+	DurabilitySync     = "sync"     // Every commit is synced to stable storage (default)
+	DurabilityPeriodic = "periodic" // Commits are synced in regular intervals
+	DurabilityOS       = "os"       // Syncing is left to the operating system
+)
+
 /*
 DiskGraphStorage data structure
 */
 type DiskGraphStorage struct {
-	name            string                        // Name of the graph storage
-	readonly        bool                          // Flag for readonly mode
-	mainDB          *datautil.PersistentStringMap // Database storing names
-	storagemanagers map[string]storage.Manager    // Map of StorageManagers
+	name            string                         // Name of the graph storage
+	readonly        bool                           // Flag for readonly mode
+	mainDB          *datautil.PersistentStringMap  // Database storing names
+	partitions      *datautil.PersistentStringMap  // Database storing the partition of each storage manager
+	storagemanagers map[string]storage.Manager     // Map of StorageManagers
+	mutex           *sync.Mutex                    // Mutex to protect the map of StorageManagers
+	groupCommit     *storage.GroupCommit           // Group commit which coalesces disk syncs
+	durability      string                         // Durability mode
+	stopSync        chan bool                      // Channel to stop periodic syncs
+	compaction      *CompactionStatus              // Status of the last or running compaction
+	compression     map[string]string              // Compression of records for each partition
+	encryption      *storage.Encryption            // Key ring for the encryption of records
+	partEncryption  map[string]*storage.Encryption // Key rings of partitions with their own keys
+	engine          string                         // Storage engine of the storage managers
+	backend         file.StorageBackend            // Backend which stores the storage files
 }
 
 /*
-NewDiskGraphStorage creates a new DiskGraphStorage instance.
+NewDiskGraphStorage creates a new DiskGraphStorage instance. New storages
+use page based storage files.
 */
 func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
+	return NewDiskGraphStorageWithEngine(name, readonly, EnginePages)
+}
+
+/*
+NewDiskGraphStorageWithEngine creates a new DiskGraphStorage instance with a
+given storage engine. The engine is only used if the storage is created -
+existing storages keep the engine they were created with.
+*/
+func NewDiskGraphStorageWithEngine(name string, readonly bool, engine string) (Storage, error) {
+	return NewDiskGraphStorageWithBackend(name, readonly, engine, &file.OSBackend{})
+}
+
+/*
+NewDiskGraphStorageWithBackend creates a new DiskGraphStorage instance with a
+given storage engine whose page based storage files are stored in a given
+storage backend.
+*/
+func NewDiskGraphStorageWithBackend(name string, readonly bool, engine string,
+	backend file.StorageBackend) (Storage, error) {
 
-	dgs := &DiskGraphStorage{name, readonly, nil, make(map[string]storage.Manager)}
+	if err := checkEngine(engine); err != nil {
+		return nil, err
+	}
+
+	dgs := &DiskGraphStorage{name, readonly, nil, nil, make(map[string]storage.Manager), &sync.Mutex{}, nil,
+		DurabilitySync, nil, nil, make(map[string]string), nil, make(map[string]*storage.Encryption),
+		engine, backend}
 
-	// Load the graph storage if the storage directory already exists if not try to create it
+	// Make sure the storage directory exists
 
 	if res, _ := fileutil.PathExists(name); !res {
 		if err := os.Mkdir(name, 0770); err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
+	}
+
+	// Load the graph storage if the names database exists in the storage
+	// backend (it might not exist locally if the local disk is ephemeral)
+	// if not try to create it
+
+	namesDB := name + "/" + FilenameNameDB
+
+	if res, _ := dgs.backend.Exists(namesDB); !res {
 
 		// Create the graph storage files
 
-		mainDB, err := datautil.NewPersistentStringMap(name + "/" + FilenameNameDB)
+		err := ioutil.WriteFile(name+"/"+FilenameEngine, []byte(engine), 0660)
+
+		var mainDB *datautil.PersistentStringMap
+
+		if err == nil {
+			mainDB, err = datautil.NewPersistentStringMap(namesDB)
+		}
+
+		if err == nil {
+			err = dgs.backend.FileWritten(namesDB)
+		}
+
 		if err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
@@ -67,17 +150,101 @@ func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
 
 	} else {
 
+		// Make sure the file is available locally
+
+		f, err := dgs.backend.OpenFile(namesDB, os.O_RDONLY, 0660)
+		if err == nil {
+			f.Close()
+		}
+
 		// Load graph storage files
 
-		mainDB, err := datautil.LoadPersistentStringMap(name + "/" + FilenameNameDB)
+		mainDB, err := datautil.LoadPersistentStringMap(namesDB)
 		if err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
 
 		dgs.mainDB = mainDB
+
+		// Storages which were created without an engine file use pages
+
+		dgs.engine = EnginePages
+
+		if b, err := ioutil.ReadFile(name + "/" + FilenameEngine); err == nil {
+			dgs.engine = strings.TrimSpace(string(b))
+		}
+
+		if err := checkEngine(dgs.engine); err != nil {
+			return nil, err
+		}
+	}
+
+	return dgs, dgs.loadPartitions()
+}
+
+/*
+loadPartitions loads the database which stores the partition of each storage
+manager. The database is created if it does not exist - storages which were
+created before partitions were recorded learn the partitions of their storage
+managers when they are next accessed.
+*/
+func (dgs *DiskGraphStorage) loadPartitions() error {
+	var err error
+
+	partitionDB := dgs.name + "/" + FilenamePartitionDB
+
+	if res, _ := dgs.backend.Exists(partitionDB); res {
+
+		// Make sure the file is available locally
+
+		f, err := dgs.backend.OpenFile(partitionDB, os.O_RDONLY, 0660)
+		if err == nil {
+			f.Close()
+		}
+
+		dgs.partitions, err = datautil.LoadPersistentStringMap(partitionDB)
+
+	} else if !dgs.readonly {
+
+		dgs.partitions, err = datautil.NewPersistentStringMap(partitionDB)
+
+		if err == nil {
+			err = dgs.backend.FileWritten(partitionDB)
+		}
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+flushPartitions writes the partitions of storage managers to disk.
+*/
+func (dgs *DiskGraphStorage) flushPartitions() error {
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	if dgs.partitions == nil || dgs.readonly {
+		return nil
+	}
+
+	err := dgs.partitions.Flush()
+	if err == nil {
+		err = dgs.backend.FileWritten(dgs.name + "/" + FilenamePartitionDB)
 	}
 
-	return dgs, nil
+	return err
+}
+
+/*
+Engine returns the storage engine of this storage.
+*/
+func (dgs *DiskGraphStorage) Engine() string {
+	return dgs.engine
 }
 
 /*
@@ -87,6 +254,33 @@ func (dgs *DiskGraphStorage) Name() string {
 	return dgs.name
 }
 
+/*
+CheckHealth checks that the storage files can be opened and that the storage
+directory can be written (only if the storage is not readonly).
+*/
+func (dgs *DiskGraphStorage) CheckHealth() error {
+
+	file, err := os.Open(dgs.name + "/" + FilenameNameDB)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+	}
+	file.Close()
+
+	if !dgs.readonly {
+		probe := dgs.name + "/" + FilenameHealthProbe
+
+		if err = ioutil.WriteFile(probe, []byte("ok"), 0660); err == nil {
+			err = os.Remove(probe)
+		}
+
+		if err != nil {
+			return &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		}
+	}
+
+	return nil
+}
+
 /*
 MainDB returns the main database.
 */
@@ -126,7 +320,16 @@ func (dgs *DiskGraphStorage) FlushMain() error {
 		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot flush main db"}
 	}
 
-	if err := dgs.mainDB.Flush(); err != nil {
+	err := dgs.mainDB.Flush()
+	if err == nil {
+		err = dgs.backend.FileWritten(dgs.name + "/" + FilenameNameDB)
+	}
+
+	if err == nil {
+		err = dgs.flushPartitions()
+	}
+
+	if err != nil {
 		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 	}
 	return nil
@@ -138,6 +341,9 @@ StorageManager is created automatically if the create flag is set to true.
 */
 func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.Manager {
 
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
 	sm, ok := dgs.storagemanagers[smname]
 
 	filename := dgs.name + "/" + smname
@@ -145,15 +351,324 @@ func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.
 	// Create storage manager object either if we may create or if the
 	// database already exists
 
-	if !ok && (create || storage.DataFileExist(filename)) {
-		dsm := storage.NewDiskStorageManager(dgs.name+"/"+smname, dgs.readonly, false, false, false)
-		sm = storage.NewCachedDiskStorageManager(dsm, 100000)
+	if !ok && dgs.engine != EnginePages {
+
+		if res, _ := fileutil.PathExists(filename + "." + FileSuffixKeyValue); create || res {
+			sm = dgs.newKVStorageManager(smname)
+			dgs.storagemanagers[smname] = sm
+		}
+
+	} else if !ok && (create || storage.DataFileExist(dgs.backend, filename)) {
+		dsm := storage.NewDiskStorageManagerWithBackend(dgs.name+"/"+smname, dgs.readonly,
+			false, false, false, dgs.backend)
+		cdsm := storage.NewCachedDiskStorageManager(dsm, 100000)
+
+		if dgs.deferSync() {
+			cdsm.SetDeferSync(true)
+		}
+
+		if compression := dgs.compressionOf(smname); compression != "" {
+			cdsm.SetCompression(compression)
+		}
+
+		cdsm.SetEncryption(dgs.encryptionOf(smname))
+
+		sm = cdsm
 		dgs.storagemanagers[smname] = sm
 	}
 
 	return sm
 }
 
+/*
+PartitionStorageManager gets a storage manager with a certain name which
+belongs to a given partition. The partition of the storage manager is
+recorded so partition specific settings (e.g. encryption keys) can be applied
+to it. A non-existing StorageManager is created automatically if the create
+flag is set to true.
+*/
+func (dgs *DiskGraphStorage) PartitionStorageManager(partition string, name string, create bool) storage.Manager {
+	smname := partition + name
+
+	sm := dgs.StorageManager(smname, false)
+
+	if sm == nil && !create {
+		return nil
+	}
+
+	dgs.mutex.Lock()
+
+	known := true
+
+	if _, ok := dgs.partitionOf(smname); !ok && dgs.partitions != nil && !dgs.readonly {
+		dgs.partitions.Data[smname] = partition
+		known = false
+	}
+
+	// Storage managers which were opened before their partition was known
+	// get the settings of their partition
+
+	if sm != nil && !known {
+		dgs.applySettings(smname, sm)
+	}
+
+	dgs.mutex.Unlock()
+
+	if sm == nil {
+		sm = dgs.StorageManager(smname, true)
+	}
+
+	return sm
+}
+
+/*
+partitionOf returns the recorded partition of a storage manager. Assumes that
+the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) partitionOf(smname string) (string, bool) {
+
+	if dgs.partitions == nil {
+		return "", false
+	}
+
+	partition, ok := dgs.partitions.Data[smname]
+
+	return partition, ok
+}
+
+/*
+applySettings applies the compression and encryption settings to an open
+storage manager. Assumes that the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) applySettings(smname string, sm storage.Manager) {
+	if cdsm, ok := sm.(*storage.CachedDiskStorageManager); ok {
+		cdsm.SetCompression(dgs.compressionOf(smname))
+		cdsm.SetEncryption(dgs.encryptionOf(smname))
+	} else if kvsm, ok := sm.(*storage.KVStorageManager); ok {
+		kvsm.SetCompression(dgs.compressionOf(smname))
+		kvsm.SetEncryption(dgs.encryptionOf(smname))
+	}
+}
+
+/*
+SetCompression sets the compression (e.g. flate or gzip:9) of new records in all storage
+managers of a given partition. The compression applies to all partitions
+without their own setting if the partition is empty. Existing records stay
+readable if the compression is changed.
+*/
+func (dgs *DiskGraphStorage) SetCompression(partition string, compression string) error {
+
+	if _, err := codec.Get(compression); err != nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+	}
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	dgs.compression[partition] = compression
+
+	// Apply the compression to storage managers which are already open
+
+	for smname, sm := range dgs.storagemanagers {
+		dgs.applySettings(smname, sm)
+	}
+
+	return nil
+}
+
+/*
+compressionOf returns the compression of a storage manager. The partition of
+the storage manager is used if it is known. Otherwise the longest partition
+name which matches the start of the storage manager name is used. Assumes that
+the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) compressionOf(smname string) string {
+
+	if partition, ok := dgs.partitionOf(smname); ok {
+		if c, ok := dgs.compression[partition]; ok {
+			return c
+		}
+		return dgs.compression[""]
+	}
+
+	var match string
+
+	compression := dgs.compression[""]
+
+	for partition, c := range dgs.compression {
+		if partition != "" && strings.HasPrefix(smname, partition) && len(partition) > len(match) {
+			match, compression = partition, c
+		}
+	}
+
+	return compression
+}
+
+/*
+SetGroupCommit enables group commit with a given max latency. Flushes no longer
+sync the transaction logs - instead writers call WaitDurable after their
+commit and all writers within the max latency share a single disk sync. A max
+latency of 0 disables group commit.
+*/
+func (dgs *DiskGraphStorage) SetGroupCommit(maxLatency time.Duration) {
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	if maxLatency > 0 {
+		dgs.groupCommit = storage.NewGroupCommit(maxLatency, dgs.syncLogs)
+	} else {
+		dgs.groupCommit = nil
+	}
+
+	dgs.applyDeferSync()
+}
+
+/*
+SetDurability sets the durability mode of this storage. In sync mode every
+commit is synced to stable storage. In periodic mode commits are synced in the
+given interval and in os mode syncing is left to the operating system. Commits
+which were not synced can be lost if the system crashes - use Sync to
+make all previous commits durable (e.g. at the end of a bulk load).
+*/
+func (dgs *DiskGraphStorage) SetDurability(mode string, interval time.Duration) error {
+
+	if mode != DurabilitySync && mode != DurabilityPeriodic && mode != DurabilityOS {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Unknown durability mode: " + mode}
+	} else if mode == DurabilityPeriodic && interval <= 0 {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Periodic durability needs a sync interval"}
+	}
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	dgs.stopPeriodicSync()
+
+	dgs.durability = mode
+
+	if mode == DurabilityPeriodic {
+		stop := make(chan bool)
+		dgs.stopSync = stop
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					dgs.syncLogs()
+				}
+			}
+		}()
+	}
+
+	dgs.applyDeferSync()
+
+	return nil
+}
+
+/*
+Durability returns the durability mode of this storage.
+*/
+func (dgs *DiskGraphStorage) Durability() string {
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	return dgs.durability
+}
+
+/*
+stopPeriodicSync stops periodic syncs if they are running. Assumes that the
+caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) stopPeriodicSync() {
+	if dgs.stopSync != nil {
+		close(dgs.stopSync)
+		dgs.stopSync = nil
+	}
+}
+
+/*
+deferSync returns if storage managers should not sync on every flush. Assumes
+that the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) deferSync() bool {
+	return dgs.groupCommit != nil || dgs.durability != DurabilitySync
+}
+
+/*
+applyDeferSync applies the current sync setting to all storage managers.
+Assumes that the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) applyDeferSync() {
+	for _, sm := range dgs.storagemanagers {
+		if dsm, ok := sm.(storage.DeferredSyncManager); ok {
+			dsm.SetDeferSync(dgs.deferSync())
+		}
+	}
+}
+
+/*
+GroupCommit returns the group commit object of this storage or nil if group
+commit is disabled.
+*/
+func (dgs *DiskGraphStorage) GroupCommit() *storage.GroupCommit {
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	return dgs.groupCommit
+}
+
+/*
+WaitDurable waits until all flushed changes have been committed to stable
+storage. Returns immediately if group commit is disabled since every flush
+is synced in this case. Also returns immediately if the durability mode is
+not sync.
+*/
+func (dgs *DiskGraphStorage) WaitDurable() error {
+
+	if gc := dgs.GroupCommit(); gc != nil && !dgs.readonly && dgs.Durability() == DurabilitySync {
+		return gc.Wait()
+	}
+
+	return nil
+}
+
+/*
+syncLogs syncs the transaction logs of all storage managers.
+*/
+func (dgs *DiskGraphStorage) syncLogs() error {
+	var errors []string
+	var sms []storage.Manager
+
+	dgs.mutex.Lock()
+
+	for _, sm := range dgs.storagemanagers {
+		sms = append(sms, sm)
+	}
+
+	dgs.mutex.Unlock()
+
+	for _, sm := range sms {
+		if dsm, ok := sm.(storage.DeferredSyncManager); ok {
+			if err := dsm.SyncLog(); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		details := fmt.Sprint(dgs.name, " :", strings.Join(errors, "; "))
+
+		return &util.GraphError{Type: util.ErrFlushing, Detail: details}
+	}
+
+	return nil
+}
+
 /*
 FlushAll writes all pending changes to the storage.
 */
@@ -186,6 +701,49 @@ func (dgs *DiskGraphStorage) FlushAll() error {
 	return nil
 }
 
+/*
+Sync writes all pending changes to the storage and waits until the main
+database and all storage files have been committed to stable storage.
+*/
+func (dgs *DiskGraphStorage) Sync() error {
+
+	if dgs.readonly {
+		return nil
+	}
+
+	var errors []string
+
+	if err := dgs.FlushAll(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(dgs.name+"/"+FilenameNameDB, os.O_RDWR, 0660)
+	if err == nil {
+		err = file.Sync()
+		file.Close()
+	}
+
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	for _, sm := range dgs.storagemanagers {
+		if s, ok := sm.(Sync); ok {
+			if err := s.Sync(); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		details := fmt.Sprint(dgs.name, " :", strings.Join(errors, "; "))
+
+		return &util.GraphError{Type: util.ErrFlushing, Detail: details}
+	}
+
+	return nil
+}
+
 /*
 Close closes the storage.
 */
@@ -193,7 +751,19 @@ func (dgs *DiskGraphStorage) Close() error {
 
 	var errors []string
 
+	dgs.mutex.Lock()
+	dgs.stopPeriodicSync()
+	dgs.mutex.Unlock()
+
 	err := dgs.mainDB.Flush()
+	if err == nil && !dgs.readonly {
+		err = dgs.backend.FileWritten(dgs.name + "/" + FilenameNameDB)
+	}
+
+	if err == nil {
+		err = dgs.flushPartitions()
+	}
+
 	if err != nil {
 		errors = append(errors, err.Error())
 	}
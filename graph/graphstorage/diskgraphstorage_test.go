@@ -11,21 +11,33 @@
 package graphstorage
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/common/fileutil"
 	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 const diskGraphStorageTestDBDir = "diskgraphstoragetest1"
 const diskGraphStorageTestDBDir2 = "diskgraphstoragetest2"
+const diskGraphStorageTestDBDir3 = "diskgraphstoragetest3"
+const diskGraphStorageTestDBDir4 = "diskgraphstoragetest4"
+const diskGraphStorageTestDBDir5 = "diskgraphstoragetest5"
+const diskGraphStorageTestDBDir6 = "diskgraphstoragetest6"
+const diskGraphStorageTestDBDir7 = "diskgraphstoragetest7"
 
-var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2}
+var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2, diskGraphStorageTestDBDir3,
+	diskGraphStorageTestDBDir4, diskGraphStorageTestDBDir5, diskGraphStorageTestDBDir6,
+	diskGraphStorageTestDBDir7}
 
 const invalidFileName = "**" + "\x00"
 
@@ -118,6 +130,38 @@ func TestDiskGraphStorage(t *testing.T) {
 		t.Error("Unexpected error return:", err)
 	}
 
+	// Check health check
+
+	if err := dgsnew.(HealthCheck).CheckHealth(); err != nil {
+		t.Error("Unexpected error return:", err)
+	}
+
+	if res, _ := fileutil.PathExists(diskGraphStorageTestDBDir + "/" + FilenameHealthProbe); res {
+		t.Error("Health probe file should have been removed")
+		return
+	}
+
+	oldName := FilenameNameDB
+	FilenameNameDB = "foo"
+
+	if err := dgsnew.(HealthCheck).CheckHealth(); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to access graph storage component") {
+		t.Error("Unexpected error return:", err)
+	}
+
+	if err := dgsnew.(Sync).Sync(); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to flush changes") {
+		t.Error("Unexpected error return:", err)
+	}
+
+	FilenameNameDB = oldName
+
+	// Check sync
+
+	if err := dgsnew.(Sync).Sync(); err != nil {
+		t.Error("Unexpected error return:", err)
+	}
+
 	if err := dgsnew.Close(); err != nil {
 		t.Error(err)
 		return
@@ -152,6 +196,10 @@ func TestDiskGraphStorage(t *testing.T) {
 		t.Error("Unexpected error return:", err)
 	}
 
+	if err := dgs.(Sync).Sync(); err != nil {
+		t.Error("Unexpected error return:", err)
+	}
+
 	if err := dgs.Close(); err != nil {
 		t.Error(err)
 		return
@@ -186,8 +234,10 @@ func TestDiskGraphStorageErrors(t *testing.T) {
 
 	FilenameNameDB = old
 
-	dgs := &DiskGraphStorage{invalidFileName, false, nil,
-		make(map[string]storage.Manager)}
+	dgs := &DiskGraphStorage{invalidFileName, false, nil, nil,
+		make(map[string]storage.Manager), &sync.Mutex{}, nil,
+		DurabilitySync, nil, nil, make(map[string]string), nil,
+		make(map[string]*storage.Encryption), EnginePages, &file.OSBackend{}}
 	pm, _ := datautil.NewPersistentStringMap(invalidFileName)
 	dgs.mainDB = pm
 
@@ -211,8 +261,315 @@ func TestDiskGraphStorageErrors(t *testing.T) {
 		return
 	}
 
+	if err := dgs.Sync(); err == nil {
+		t.Error("Unexpected sync result")
+		return
+	}
+
 	if err := dgs.Close(); err == nil {
 		t.Error("Unexpected close result")
 		return
 	}
 }
+
+func TestDiskGraphStorageGroupCommit(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer gs.Close()
+
+	dgs := gs.(*DiskGraphStorage)
+
+	if err := dgs.WaitDurable(); err != nil || dgs.GroupCommit() != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm1 := dgs.StorageManager("test1", true)
+
+	dgs.SetGroupCommit(10 * time.Millisecond)
+
+	sm2 := dgs.StorageManager("test2", true)
+
+	// Writers flush their changes and share syncs
+
+	var wg sync.WaitGroup
+	var writeLock sync.Mutex
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sm := sm1
+			if i%2 == 0 {
+				sm = sm2
+			}
+
+			writeLock.Lock()
+			_, err := sm.Insert(fmt.Sprint("test", i))
+			if err == nil {
+				err = sm.Flush()
+			}
+			writeLock.Unlock()
+
+			if err == nil {
+				err = dgs.WaitDurable()
+			}
+
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if commits, syncs := dgs.GroupCommit().Stats(); commits != 10 || syncs == 0 || syncs >= 10 {
+		t.Error("Unexpected result:", commits, syncs)
+		return
+	}
+
+	dgs.SetGroupCommit(0)
+
+	if err := dgs.WaitDurable(); err != nil || dgs.GroupCommit() != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestDiskGraphStorageDurability(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer gs.Close()
+
+	dgs := gs.(*DiskGraphStorage)
+
+	if res := dgs.Durability(); res != DurabilitySync {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dgs.SetDurability(DurabilityPeriodic, 0); err == nil ||
+		err.Error() != "GraphError: Invalid data (Periodic durability needs a sync interval)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dgs.SetDurability(DurabilityPeriodic, 5*time.Millisecond); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Commits do not wait for group commits if the durability mode is not sync
+
+	dgs.SetGroupCommit(time.Hour)
+
+	sm := dgs.StorageManager("test3", true)
+
+	if _, err := sm.Insert("test"); err != nil {
+		t.Error(err)
+		return
+	} else if err := sm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.WaitDurable(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := dgs.SetDurability(DurabilityOS, 0); err != nil || dgs.stopSync != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dgs.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.SetGroupCommit(0)
+
+	if err := dgs.SetDurability(DurabilitySync, 0); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskGraphStorageCompression(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs := gs.(*DiskGraphStorage)
+
+	if err := dgs.SetCompression("main", "foo"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown compression: foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm := dgs.StorageManager("mainAuthor.nodes", true)
+
+	dgs.SetCompression("", "flate")
+	dgs.SetCompression("mainx", storage.CompressionNone)
+
+	if res := dgs.compressionOf("mainAuthor.nodes"); res != "flate" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := dgs.compressionOf("mainxAuthor.nodes"); res != storage.CompressionNone {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The compression is applied to storage managers which are already open
+
+	text := strings.Repeat("compress me ", 100)
+
+	loc, _ := sm.Insert(text)
+	sm.Flush()
+
+	var res string
+
+	if err := sm.Fetch(loc, &res); err != nil || res != text {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gs.Close()
+
+	// Check the stored record
+
+	var b bytes.Buffer
+
+	bdsm := storage.NewByteDiskStorageManager(diskGraphStorageTestDBDir3+"/mainAuthor.nodes", true, false, true, true)
+	defer bdsm.Close()
+
+	if err := bdsm.Fetch(loc, &b); err != nil || b.Len() >= len(text) {
+		t.Error("Record should have been compressed:", b.Len(), err)
+		return
+	}
+}
+
+func TestDiskGraphStorageKeyValueEngine(t *testing.T) {
+
+	// Register a memory-only engine which keeps its stores across reopens
+
+	stores := make(map[string]*storage.MemoryKeyValueStore)
+
+	RegisterEngine("testkv", func(dir string, readonly bool) (storage.KeyValueStore, error) {
+		if _, ok := stores[dir]; !ok {
+			stores[dir] = storage.NewMemoryKeyValueStore()
+		}
+		return stores[dir], os.MkdirAll(dir, 0770)
+	})
+	defer delete(engines, "testkv")
+
+	if _, err := NewDiskGraphStorageWithEngine(diskGraphStorageTestDBDir6, false, "foo"); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Unknown storage engine: foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, ok := engines[EngineBadger]; !ok {
+		if _, err := NewDiskGraphStorageWithEngine(diskGraphStorageTestDBDir6, false, EngineBadger); err == nil ||
+			!strings.Contains(err.Error(), "badger build tag") {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	gs, err := NewDiskGraphStorageWithEngine(diskGraphStorageTestDBDir6, false, "testkv")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gs.(*DiskGraphStorage).Engine(); res != "testkv" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if sm := gs.StorageManager("test1", false); sm != nil {
+		t.Error("Unexpected result:", sm)
+		return
+	}
+
+	sm := gs.StorageManager("test1", true)
+
+	if _, ok := sm.(*storage.KVStorageManager); !ok {
+		t.Error("Unexpected storage manager:", sm)
+		return
+	}
+
+	loc, err := sm.Insert("testdata")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gs.(*DiskGraphStorage).Compact(10, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The engine of an existing storage is kept
+
+	gs, err = NewDiskGraphStorage(diskGraphStorageTestDBDir6, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gs.(*DiskGraphStorage).Engine(); res != "testkv" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var res string
+
+	if err := gs.StorageManager("test1", false).Fetch(loc, &res); err != nil || res != "testdata" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if smnames, err := gs.(*DiskGraphStorage).storageManagerNames(); err != nil || fmt.Sprint(smnames) != "[test1]" {
+		t.Error("Unexpected result:", smnames, err)
+		return
+	}
+
+	gs.Close()
+
+	// Existing storages cannot be opened if their engine is not available
+
+	delete(engines, "testkv")
+
+	if _, err := NewDiskGraphStorage(diskGraphStorageTestDBDir6, true); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Unknown storage engine: testkv)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+SetEncryption sets the key ring which is used to encrypt the records of all
+storage managers (data, index and blob records). Records which were written
+before encryption was enabled stay readable. Encryption is switched off with
+nil - encrypted records can then no longer be read.
+*/
+func (dgs *DiskGraphStorage) SetEncryption(e *storage.Encryption) {
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	dgs.encryption = e
+
+	for smname, sm := range dgs.storagemanagers {
+		dgs.applySettings(smname, sm)
+	}
+}
+
+/*
+SetPartitionEncryption sets the key ring which is used to encrypt the records
+of all storage managers of a given partition. Partitions without their own
+key ring use the key ring of the storage. The own key ring of a partition is
+removed with nil.
+*/
+func (dgs *DiskGraphStorage) SetPartitionEncryption(partition string, e *storage.Encryption) {
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	if e == nil {
+		delete(dgs.partEncryption, partition)
+	} else {
+		dgs.partEncryption[partition] = e
+	}
+
+	for smname, sm := range dgs.storagemanagers {
+		dgs.applySettings(smname, sm)
+	}
+}
+
+/*
+encryptionOf returns the key ring of a storage manager. Assumes that the
+caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) encryptionOf(smname string) *storage.Encryption {
+
+	if partition, ok := dgs.partitionOf(smname); ok {
+		if e, ok := dgs.partEncryption[partition]; ok {
+			return e
+		}
+	}
+
+	return dgs.encryption
+}
+
+/*
+Reencrypt rewrites all records which are not encrypted with the active key
+of the key ring (e.g. after a key rotation or after encryption was enabled).
+Records are rewritten in batches of the given size with the given pause
+between batches. Returns the number of rewritten records.
+*/
+func (dgs *DiskGraphStorage) Reencrypt(batchSize int, pause time.Duration) (int, error) {
+
+	// Fail operation when readonly
+
+	if dgs.readonly {
+		return 0, &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot re-encrypt storage"}
+	}
+
+	dgs.mutex.Lock()
+	enabled := dgs.encryption != nil || len(dgs.partEncryption) > 0
+	dgs.mutex.Unlock()
+
+	if !enabled {
+		return 0, &util.GraphError{Type: util.ErrInvalidData, Detail: "Encryption is not enabled"}
+	}
+
+	smnames, err := dgs.storageManagerNames()
+	if err != nil {
+		return 0, err
+	}
+
+	return dgs.reencrypt(smnames, batchSize, pause)
+}
+
+/*
+ReencryptPartition rewrites all records of a given partition which are not
+encrypted with the active key of the key ring of the partition. Only storage
+managers whose partition is known are rewritten. Returns the number of
+rewritten records.
+*/
+func (dgs *DiskGraphStorage) ReencryptPartition(partition string, batchSize int, pause time.Duration) (int, error) {
+
+	// Fail operation when readonly
+
+	if dgs.readonly {
+		return 0, &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot re-encrypt storage"}
+	}
+
+	var smnames []string
+
+	dgs.mutex.Lock()
+
+	enabled := dgs.encryption != nil || dgs.partEncryption[partition] != nil
+
+	if dgs.partitions != nil {
+		for smname, p := range dgs.partitions.Data {
+			if p == partition {
+				smnames = append(smnames, smname)
+			}
+		}
+	}
+
+	dgs.mutex.Unlock()
+
+	if !enabled {
+		return 0, &util.GraphError{Type: util.ErrInvalidData, Detail: "Encryption is not enabled"}
+	}
+
+	sort.Strings(smnames)
+
+	return dgs.reencrypt(smnames, batchSize, pause)
+}
+
+/*
+reencrypt rewrites the records of the given storage managers. Storage managers
+without encryption and storage managers whose keys were removed (they cannot
+be read anymore) are skipped.
+*/
+func (dgs *DiskGraphStorage) reencrypt(smnames []string, batchSize int, pause time.Duration) (int, error) {
+	count := 0
+
+	for _, smname := range smnames {
+
+		dgs.mutex.Lock()
+		e := dgs.encryptionOf(smname)
+		dgs.mutex.Unlock()
+
+		if e == nil || e.ActiveKey() == 0 {
+			continue
+		}
+
+		if cdsm, ok := dgs.StorageManager(smname, false).(*storage.CachedDiskStorageManager); ok {
+			n, err := cdsm.Reencrypt(batchSize, pause)
+
+			count += n
+
+			if err != nil {
+				return count, &util.GraphError{Type: util.ErrAccessComponent,
+					Detail: fmt.Sprint(smname, ": ", err.Error())}
+			}
+		}
+	}
+
+	return count, nil
+}
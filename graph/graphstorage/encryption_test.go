@@ -0,0 +1,180 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestDiskGraphStorageEncryption(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir5, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs := gs.(*DiskGraphStorage)
+
+	if _, err := dgs.Reencrypt(10, 0); err == nil ||
+		err.Error() != "GraphError: Invalid data (Encryption is not enabled)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Store a record before encryption is enabled
+
+	sm1 := dgs.StorageManager("test1", true)
+	loc1, _ := sm1.Insert("test1")
+	sm1.Flush()
+
+	e := storage.NewEncryption(nil)
+	e.AddKey(1, []byte("secret"))
+	e.SetActiveKey(1)
+
+	dgs.SetEncryption(e)
+
+	// New storage managers get the encryption as well
+
+	sm2 := dgs.StorageManager("test2", true)
+	loc2, _ := sm2.Insert("test2")
+	sm2.Flush()
+
+	if n, err := dgs.Reencrypt(10, 0); n != 1 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	gs.Close()
+
+	// Records can only be read with the key
+
+	gs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir5, true)
+	dgs = gs.(*DiskGraphStorage)
+
+	var res string
+
+	if err := dgs.StorageManager("test2", false).Fetch(loc2, &res); err != storage.ErrNoEncryption {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.SetEncryption(e)
+
+	if err := dgs.StorageManager("test1", false).Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dgs.StorageManager("test2", false).Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := dgs.Reencrypt(10, 0); err == nil ||
+		err.Error() != "GraphError: Failed write to readonly storage (Cannot re-encrypt storage)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gs.Close()
+}
+
+func TestDiskGraphStoragePartitionEncryption(t *testing.T) {
+	gs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir5+"p", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(diskGraphStorageTestDBDir5 + "p")
+
+	dgs := gs.(*DiskGraphStorage)
+
+	e := storage.NewEncryption(nil)
+	e.AddKey(1, []byte("secret"))
+	e.SetActiveKey(1)
+
+	dgs.SetEncryption(e)
+
+	// Store a record before the partition gets its own key
+
+	sm1 := dgs.PartitionStorageManager("p1", "test.nodes", true)
+	loc1, _ := sm1.Insert("test1")
+	sm1.Flush()
+
+	sm2 := dgs.PartitionStorageManager("p2", "test.nodes", true)
+	loc2, _ := sm2.Insert("test2")
+	sm2.Flush()
+
+	pe := storage.NewEncryption(func(id byte) ([]byte, error) {
+		return []byte("secret"), nil
+	})
+	pe.AddKey(2, []byte("partition secret"))
+	pe.SetActiveKey(2)
+
+	dgs.SetPartitionEncryption("p1", pe)
+
+	if n, err := dgs.ReencryptPartition("p1", 10, 0); n != 1 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if err := gs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Partitions of storage managers are kept
+
+	gs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir5+"p", false)
+	dgs = gs.(*DiskGraphStorage)
+
+	dgs.SetEncryption(e)
+
+	var res string
+
+	if err := dgs.StorageManager("p2test.nodes", false).Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dgs.StorageManager("p1test.nodes", false).Fetch(loc1, &res); err == nil ||
+		err.Error() != "Unknown encryption key: 2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Records of a partition become unreadable when its keys are removed
+
+	dgs.SetPartitionEncryption("p1", pe)
+
+	if err := dgs.StorageManager("p1test.nodes", false).Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.SetPartitionEncryption("p1", storage.NewEncryption(nil))
+
+	if err := dgs.StorageManager("p1test.nodes", false).Fetch(loc1, &res); err == nil ||
+		err.Error() != "Unknown encryption key: 2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if n, err := dgs.Reencrypt(10, 0); n != 0 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	gs.Close()
+}
@@ -0,0 +1,106 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+FilenameEngine is the filename of the file which holds the storage engine
+*/
+var FilenameEngine = "engine"
+
+/*
+FileSuffixKeyValue is the file ending of the directories of key-value
+storage managers
+*/
+var FileSuffixKeyValue = "kv"
+
+/*
+Storage engines of a DiskGraphStorage
+*/
+const (
+	EnginePages  = "pages"  // Page based storage files (default)
+	EngineBadger = "badger" // Badger key-value stores (requires the badger build tag)
+)
+
+/*
+KeyValueOpener opens the key-value store of a storage manager in a given
+directory. The directory should be created if it does not exist.
+*/
+type KeyValueOpener func(dir string, readonly bool) (storage.KeyValueStore, error)
+
+/*
+engines holds all registered key-value storage engines by name.
+*/
+var engines = map[string]KeyValueOpener{}
+
+/*
+RegisterEngine registers a key-value storage engine. Engines must be
+registered before a storage which uses them is opened.
+*/
+func RegisterEngine(name string, opener KeyValueOpener) {
+	if name == EnginePages {
+		panic(fmt.Sprintf("Storage engine name %v is reserved", name))
+	}
+	engines[name] = opener
+}
+
+/*
+checkEngine checks that a given storage engine is available.
+*/
+func checkEngine(engine string) error {
+
+	if _, ok := engines[engine]; ok || engine == EnginePages {
+		return nil
+	}
+
+	detail := "Unknown storage engine: " + engine
+
+	if engine == EngineBadger {
+		detail = "Storage engine badger is not available (EliasDB must be built with the badger build tag)"
+	}
+
+	return &util.GraphError{Type: util.ErrOpening, Detail: detail}
+}
+
+/*
+newKVStorageManager creates a storage manager which stores its data in a
+key-value store of the storage engine. Assumes that the caller holds the mutex.
+*/
+func (dgs *DiskGraphStorage) newKVStorageManager(smname string) storage.Manager {
+
+	dir := dgs.name + "/" + smname + "." + FileSuffixKeyValue
+
+	store, err := engines[dgs.engine](dir, dgs.readonly)
+	if err != nil {
+		panic(fmt.Sprintf("Could not open %v store: %v (%v)", dgs.engine, dir, err))
+	}
+
+	kvsm, err := storage.NewKVStorageManager(dir, store, dgs.readonly)
+	if err != nil {
+		panic(fmt.Sprintf("Could not initialize KVStorageManager: %v (%v)", dir, err))
+	}
+
+	kvsm.SetDeferSync(dgs.deferSync())
+
+	if compression := dgs.compressionOf(smname); compression != "" {
+		kvsm.SetCompression(compression)
+	}
+
+	kvsm.SetEncryption(dgs.encryptionOf(smname))
+
+	return kvsm
+}
@@ -0,0 +1,29 @@
+//go:build badger
+// +build badger
+
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/badger"
+)
+
+func init() {
+	RegisterEngine(EngineBadger, func(dir string, readonly bool) (storage.KeyValueStore, error) {
+		store, err := badger.Open(dir, readonly)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	})
+}
@@ -10,7 +10,11 @@
 
 package graphstorage
 
-import "github.com/krotik/eliasdb/storage"
+import (
+	"sync"
+
+	"github.com/krotik/eliasdb/storage"
+)
 
 /*
 MgsRetClose is the return value on successful close
@@ -39,6 +43,7 @@ type MemoryGraphStorage struct {
 	name            string                     // Name of the graph storage
 	mainDB          map[string]string          // Database storing names
 	storagemanagers map[string]storage.Manager // Map of StorageManagers
+	mutex           *sync.Mutex                // Mutex to protect the map of StorageManagers
 }
 
 /*
@@ -46,7 +51,7 @@ NewMemoryGraphStorage creates a new MemoryGraphStorage instance.
 */
 func NewMemoryGraphStorage(name string) Storage {
 	return &MemoryGraphStorage{name, make(map[string]string),
-		make(map[string]storage.Manager)}
+		make(map[string]storage.Manager), &sync.Mutex{}}
 }
 
 /*
@@ -83,6 +88,9 @@ StorageManager is created automatically if the create flag is set to true.
 */
 func (mgs *MemoryGraphStorage) StorageManager(smname string, create bool) storage.Manager {
 
+	mgs.mutex.Lock()
+	defer mgs.mutex.Unlock()
+
 	sm, ok := mgs.storagemanagers[smname]
 
 	if !ok && create {
@@ -0,0 +1,297 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/codec"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+memorySnapshot is the data which is written to a snapshot file.
+*/
+type memorySnapshot struct {
+	MainDB map[string]string            // Main database
+	Stores map[string]map[string][]byte // Data of all storage managers
+}
+
+/*
+MemorySnapshotGraphStorage is a graph storage which keeps all data in memory.
+The data can be written to a snapshot file which is loaded when the storage is
+created. Records are serialized like in a DiskGraphStorage so a snapshot
+contains all data without references to live objects. Snapshot files can be
+compressed with a codec of the codec registry.
+*/
+type MemorySnapshotGraphStorage struct {
+	name            string                                  // Name of the graph storage
+	snapshotFile    string                                  // Snapshot file (empty for no snapshots)
+	mainDB          map[string]string                       // Database storing names
+	flushedMainDB   map[string]string                       // Copy of the main database at the last flush
+	stores          map[string]*storage.MemoryKeyValueStore // Key-value stores of all storage managers
+	storagemanagers map[string]*storage.KVStorageManager    // Map of StorageManagers
+	snapshotCodec   codec.Codec                             // Codec for snapshot files (nil for no compression)
+	mutex           *sync.Mutex                             // Mutex to protect map operations
+}
+
+/*
+NewMemorySnapshotGraphStorage creates a new MemorySnapshotGraphStorage
+instance. The data of an existing snapshot file is loaded. Snapshots are
+disabled if no snapshot file is given.
+*/
+func NewMemorySnapshotGraphStorage(name string, snapshotFile string) (*MemorySnapshotGraphStorage, error) {
+
+	msgs := &MemorySnapshotGraphStorage{name, snapshotFile, make(map[string]string),
+		make(map[string]string), make(map[string]*storage.MemoryKeyValueStore),
+		make(map[string]*storage.KVStorageManager), nil, &sync.Mutex{}}
+
+	if snapshotFile == "" {
+		return msgs, nil
+	}
+
+	if ok, _ := fileutil.PathExists(snapshotFile); !ok {
+		return msgs, nil
+	}
+
+	f, err := os.Open(snapshotFile)
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+	defer f.Close()
+
+	var snapshot memorySnapshot
+
+	r, err := codec.NewStreamReader(f)
+
+	if err == nil {
+		err = gob.NewDecoder(r).Decode(&snapshot)
+	}
+
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening,
+			Detail: "Could not read snapshot " + snapshotFile + ": " + err.Error()}
+	}
+
+	for k, v := range snapshot.MainDB {
+		msgs.mainDB[k] = v
+		msgs.flushedMainDB[k] = v
+	}
+
+	for smname, data := range snapshot.Stores {
+		store := storage.NewMemoryKeyValueStore()
+		store.Data = data
+		msgs.stores[smname] = store
+	}
+
+	return msgs, nil
+}
+
+/*
+Name returns the name of the MemorySnapshotGraphStorage instance.
+*/
+func (msgs *MemorySnapshotGraphStorage) Name() string {
+	return msgs.name
+}
+
+/*
+MainDB returns the main database.
+*/
+func (msgs *MemorySnapshotGraphStorage) MainDB() map[string]string {
+	return msgs.mainDB
+}
+
+/*
+RollbackMain rollback the main database to the state of the last flush.
+*/
+func (msgs *MemorySnapshotGraphStorage) RollbackMain() error {
+	msgs.mutex.Lock()
+	defer msgs.mutex.Unlock()
+
+	msgs.mainDB = copyStringMap(msgs.flushedMainDB)
+
+	return nil
+}
+
+/*
+FlushMain writes the main database to the storage.
+*/
+func (msgs *MemorySnapshotGraphStorage) FlushMain() error {
+	msgs.mutex.Lock()
+	defer msgs.mutex.Unlock()
+
+	msgs.flushedMainDB = copyStringMap(msgs.mainDB)
+
+	return nil
+}
+
+/*
+StorageManager gets a storage manager with a certain name. A non-existing
+StorageManager is created automatically if the create flag is set to true.
+*/
+func (msgs *MemorySnapshotGraphStorage) StorageManager(smname string, create bool) storage.Manager {
+	msgs.mutex.Lock()
+	defer msgs.mutex.Unlock()
+
+	if sm, ok := msgs.storagemanagers[smname]; ok {
+		return sm
+	}
+
+	store, ok := msgs.stores[smname]
+
+	if !ok {
+		if !create {
+			return nil
+		}
+
+		store = storage.NewMemoryKeyValueStore()
+		msgs.stores[smname] = store
+	}
+
+	sm, err := storage.NewKVStorageManager(msgs.name+"/"+smname, store, false)
+	if err != nil {
+		panic("Could not initialize KVStorageManager: " + smname + " (" + err.Error() + ")")
+	}
+
+	msgs.storagemanagers[smname] = sm
+
+	return sm
+}
+
+/*
+FlushAll writes all pending changes to the storage.
+*/
+func (msgs *MemorySnapshotGraphStorage) FlushAll() error {
+	msgs.mutex.Lock()
+	defer msgs.mutex.Unlock()
+
+	for _, sm := range msgs.storagemanagers {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+
+	msgs.flushedMainDB = copyStringMap(msgs.mainDB)
+
+	return nil
+}
+
+/*
+SetSnapshotCompression sets the compression (e.g. gzip or zlib:9) of snapshot
+files which are written from now on. Existing snapshot files are read
+regardless of their compression. Compression is switched off with the name
+none.
+*/
+func (msgs *MemorySnapshotGraphStorage) SetSnapshotCompression(compression string) error {
+
+	c, err := codec.Get(compression)
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+	}
+
+	msgs.mutex.Lock()
+	msgs.snapshotCodec = c
+	msgs.mutex.Unlock()
+
+	return nil
+}
+
+/*
+Snapshot writes all flushed data to the snapshot file. Changes which were not
+yet flushed are not part of the snapshot. The snapshot file is replaced
+atomically.
+*/
+func (msgs *MemorySnapshotGraphStorage) Snapshot() error {
+
+	if msgs.snapshotFile == "" {
+		return &util.GraphError{Type: util.ErrAccessComponent, Detail: "No snapshot file was given"}
+	}
+
+	msgs.mutex.Lock()
+
+	snapshot := memorySnapshot{copyStringMap(msgs.flushedMainDB), make(map[string]map[string][]byte)}
+
+	for smname, store := range msgs.stores {
+		snapshot.Stores[smname] = store.Items()
+	}
+
+	c := msgs.snapshotCodec
+
+	msgs.mutex.Unlock()
+
+	tmpFile := msgs.snapshotFile + ".tmp"
+
+	f, err := os.Create(tmpFile)
+	if err == nil {
+		var w io.WriteCloser
+
+		if w, err = codec.NewStreamWriter(f, c); err == nil {
+			if err = gob.NewEncoder(w).Encode(&snapshot); err == nil {
+				err = w.Close()
+			}
+		}
+
+		if err == nil {
+			err = f.Sync()
+		}
+
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err == nil {
+			err = os.Rename(tmpFile, msgs.snapshotFile)
+		}
+	}
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return &util.GraphError{Type: util.ErrWriting, Detail: "Could not write snapshot " +
+			msgs.snapshotFile + ": " + err.Error()}
+	}
+
+	return nil
+}
+
+/*
+Close closes the storage. A final snapshot is written if a snapshot file was given.
+*/
+func (msgs *MemorySnapshotGraphStorage) Close() error {
+
+	if err := msgs.FlushAll(); err != nil {
+		return err
+	}
+
+	if msgs.snapshotFile != "" {
+		return msgs.Snapshot()
+	}
+
+	return nil
+}
+
+/*
+copyStringMap returns a copy of a string map.
+*/
+func copyStringMap(m map[string]string) map[string]string {
+	ret := make(map[string]string, len(m))
+
+	for k, v := range m {
+		ret[k] = v
+	}
+
+	return ret
+}
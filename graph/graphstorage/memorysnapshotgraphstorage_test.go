@@ -0,0 +1,184 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const memorySnapshotTestDBDir = "memorysnapshottest"
+
+func TestMemorySnapshotGraphStorage(t *testing.T) {
+	snapshotFile := filepath.Join(memorySnapshotTestDBDir, "snapshot.db")
+
+	os.RemoveAll(memorySnapshotTestDBDir)
+	os.MkdirAll(memorySnapshotTestDBDir, 0770)
+	defer os.RemoveAll(memorySnapshotTestDBDir)
+
+	msgs, err := NewMemorySnapshotGraphStorage("mytest", snapshotFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if msgs.Name() != "mytest" {
+		t.Error("Unexpected name:", msgs.Name())
+		return
+	}
+
+	if res := msgs.StorageManager("123", false); res != nil {
+		t.Error("Unexpected result", res)
+		return
+	}
+
+	sm := msgs.StorageManager("123", true)
+
+	loc, _ := sm.Insert("test")
+	sm.SetRoot(1, loc)
+
+	msgs.MainDB()["test1"] = "testvalue1"
+
+	// Data is only part of a snapshot once it has been flushed
+
+	loc2, _ := sm.Insert("test2")
+	msgs.MainDB()["test2"] = "testvalue2"
+
+	if err := msgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm.Insert("test3")
+	msgs.MainDB()["test3"] = "testvalue3"
+
+	if err := msgs.Snapshot(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Rollback restores the flushed main db
+
+	msgs.RollbackMain()
+
+	if _, ok := msgs.MainDB()["test3"]; ok || len(msgs.MainDB()) != 2 {
+		t.Error("Unexpected result:", msgs.MainDB())
+		return
+	}
+
+	msgs2, err := NewMemorySnapshotGraphStorage("mytest", snapshotFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if msgs2.MainDB()["test1"] != "testvalue1" || msgs2.MainDB()["test2"] != "testvalue2" ||
+		len(msgs2.MainDB()) != 2 {
+		t.Error("Unexpected result:", msgs2.MainDB())
+		return
+	}
+
+	sm2 := msgs2.StorageManager("123", false)
+
+	var res string
+
+	if err := sm2.Fetch(sm2.Root(1), &res); err != nil || res != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := sm2.Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := sm2.Fetch(loc2+1, &res); err == nil {
+		t.Error("Unflushed data should not be in the snapshot")
+		return
+	}
+
+	// Closing the storage writes a final snapshot
+
+	if loc, _ = sm2.Insert("test4"); loc != loc2+1 {
+		t.Error("Unexpected location:", loc)
+		return
+	}
+
+	if err := msgs2.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	msgs3, _ := NewMemorySnapshotGraphStorage("mytest", snapshotFile)
+	sm3 := msgs3.StorageManager("123", false)
+
+	if err := sm3.Fetch(loc, &res); err != nil || res != "test4" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Write a compressed snapshot
+
+	if err := msgs3.SetSnapshotCompression("foo"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Unknown compression: foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := msgs3.SetSnapshotCompression("gzip:9"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := msgs3.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if data, _ := ioutil.ReadFile(snapshotFile); len(data) < 2 || data[0] != 0 || data[1] != 2 {
+		t.Error("Snapshot should be compressed with gzip:", data)
+		return
+	}
+
+	msgs5, _ := NewMemorySnapshotGraphStorage("mytest", snapshotFile)
+	sm5 := msgs5.StorageManager("123", false)
+
+	if err := sm5.Fetch(loc, &res); err != nil || res != "test4" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Test error cases
+
+	msgs4, _ := NewMemorySnapshotGraphStorage("mytest", "")
+
+	if err := msgs4.Snapshot(); err == nil || err.Error() !=
+		"GraphError: Failed to access graph storage component (No snapshot file was given)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := msgs4.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ioutil.WriteFile(snapshotFile, []byte("foo"), 0660)
+
+	if _, err := NewMemorySnapshotGraphStorage("mytest", snapshotFile); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to open graph storage (Could not read snapshot") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -10,7 +10,11 @@
 
 package graphstorage
 
-import "github.com/krotik/eliasdb/storage"
+import (
+	"time"
+
+	"github.com/krotik/eliasdb/storage"
+)
 
 /*
 Storage interface models the storage backend for a graph manager.
@@ -54,3 +58,116 @@ type Storage interface {
 	*/
 	Close() error
 }
+
+/*
+HealthCheck is an optional interface for storages which can verify that their
+underlying resources are accessible.
+*/
+type HealthCheck interface {
+
+	/*
+	   CheckHealth checks that the storage can be accessed. Writable storages
+	   also check that data can be written.
+	*/
+	CheckHealth() error
+}
+
+/*
+Sync is an optional interface for storages which can guarantee that written
+data is durable.
+*/
+type Sync interface {
+
+	/*
+	   Sync writes all pending changes to the storage and waits until they
+	   have been committed to stable storage.
+	*/
+	Sync() error
+}
+
+/*
+GroupCommitter is an optional interface for storages which coalesce the disk
+syncs of concurrent commits.
+*/
+type GroupCommitter interface {
+
+	/*
+	   WaitDurable waits until all flushed changes have been committed to
+	   stable storage.
+	*/
+	WaitDurable() error
+}
+
+/*
+Compactor is an optional interface for storages which can reclaim the space
+of deleted and updated data while they are in use.
+*/
+type Compactor interface {
+
+	/*
+	   Compact reclaims the space of deleted and updated data. Data is copied
+	   in batches of the given size with a pause between batches.
+	*/
+	Compact(batchSize int, pause time.Duration) error
+
+	/*
+	   CompactionStatus returns the status of the last or running compaction.
+	   Returns nil if there was no compaction.
+	*/
+	CompactionStatus() *CompactionStatus
+}
+
+/*
+Checker is an optional interface for storages which can verify the
+consistency of their storage files.
+*/
+type Checker interface {
+
+	/*
+	   Check verifies the consistency of all storage files and returns a
+	   report for each storage manager. The storage files are not changed.
+	*/
+	Check() ([]*storage.CheckReport, error)
+}
+
+/*
+Reencrypter is an optional interface for storages which encrypt their data
+and can rewrite it with a new key.
+*/
+type Reencrypter interface {
+
+	/*
+	   Reencrypt rewrites all data which is not encrypted with the active key.
+	   Data is rewritten in batches of the given size with a pause between
+	   batches. Returns the number of rewritten records.
+	*/
+	Reencrypt(batchSize int, pause time.Duration) (int, error)
+}
+
+/*
+PartitionStorage is an optional interface for storages which keep track of the
+partition of their storage managers.
+*/
+type PartitionStorage interface {
+
+	/*
+	   PartitionStorageManager gets a storage manager with a certain name
+	   which belongs to a given partition. A non-existing StorageManager is not
+	   created automatically if the create flag is set to false.
+	*/
+	PartitionStorageManager(partition string, name string, create bool) storage.Manager
+}
+
+/*
+PartitionReencrypter is an optional interface for storages which encrypt the
+data of partitions with their own keys.
+*/
+type PartitionReencrypter interface {
+
+	/*
+	   ReencryptPartition rewrites all data of a partition which is not
+	   encrypted with the active key of the partition. Returns the number of
+	   rewritten records.
+	*/
+	ReencryptPartition(partition string, batchSize int, pause time.Duration) (int, error)
+}
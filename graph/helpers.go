@@ -41,6 +41,17 @@ func (gm *Manager) checkPartitionName(part string) error {
 	return nil
 }
 
+/*
+storageManager gets a storage manager of a given partition. Storage managers
+are wrapped so snapshots of the data can be taken.
+*/
+func (gm *Manager) storageManager(part string, name string, create bool) storage.Manager {
+	if gm.mvcc != nil {
+		return gm.mvcc.storageManager(gm.gs, part, name, create)
+	}
+	return rawStorageManager(gm.gs, part, name, create)
+}
+
 /*
 checkNode checks if a given node can be written to the datastore.
 */
@@ -136,10 +147,38 @@ func (gm *Manager) writeNodeCount(kind string, count uint64, flush bool) error {
 	numstr := make([]byte, 8)
 
 	binary.LittleEndian.PutUint64(numstr, count)
-	gm.gs.MainDB()[MainDBNodeCount+kind] = string(numstr)
+	gm.setMainDBValue(MainDBNodeCount+kind, string(numstr), true)
+
+	if flush {
+		return gm.flushMain()
+	}
+
+	return nil
+}
+
+/*
+changeCount changes a node or edge count in the datastore by a given delta.
+The count is read and written in one step so concurrent writers of different
+partitions cannot lose updates.
+*/
+func (gm *Manager) changeCount(key string, delta int, flush bool) error {
+	var count uint64
+
+	gm.mainMutex.Lock()
+
+	if val, ok := gm.gs.MainDB()[key]; ok {
+		count = binary.LittleEndian.Uint64([]byte(val))
+	}
+
+	numstr := make([]byte, 8)
+
+	binary.LittleEndian.PutUint64(numstr, count+uint64(delta))
+	gm.gs.MainDB()[key] = string(numstr)
+
+	gm.mainMutex.Unlock()
 
 	if flush {
-		return gm.gs.FlushMain()
+		return gm.flushMain()
 	}
 
 	return nil
@@ -152,10 +191,10 @@ func (gm *Manager) writeEdgeCount(kind string, count uint64, flush bool) error {
 	numstr := make([]byte, 8)
 
 	binary.LittleEndian.PutUint64(numstr, count)
-	gm.gs.MainDB()[MainDBEdgeCount+kind] = string(numstr)
+	gm.setMainDBValue(MainDBEdgeCount+kind, string(numstr), true)
 
 	if flush {
-		return gm.gs.FlushMain()
+		return gm.flushMain()
 	}
 
 	return nil
@@ -188,29 +227,16 @@ func (gm *Manager) getNodeStorageHTree(part string, kind string,
 
 	// Make sure all required lookup maps are there
 
-	if gm.getMainDBMap(MainDBNodeKinds) == nil {
-		gm.storeMainDBMap(MainDBNodeKinds, make(map[string]string))
-	}
-
-	if gm.getMainDBMap(MainDBParts) == nil {
-		gm.storeMainDBMap(MainDBParts, make(map[string]string))
-	}
-
-	if gm.getMainDBMap(MainDBNodeAttrs+kind) == nil {
-		gm.storeMainDBMap(MainDBNodeAttrs+kind, make(map[string]string))
-	}
-
-	if gm.getMainDBMap(MainDBNodeEdges+kind) == nil {
-		gm.storeMainDBMap(MainDBNodeEdges+kind, make(map[string]string))
-	}
+	gm.ensureMainDBMap(MainDBNodeKinds)
+	gm.ensureMainDBMap(MainDBParts)
+	gm.ensureMainDBMap(MainDBNodeAttrs + kind)
+	gm.ensureMainDBMap(MainDBNodeEdges + kind)
 
-	if _, ok := gm.gs.MainDB()[MainDBNodeCount+kind]; !ok {
-		gm.gs.MainDB()[MainDBNodeCount+kind] = string(make([]byte, 8, 8))
-	}
+	gm.setMainDBValue(MainDBNodeCount+kind, string(make([]byte, 8, 8)), false)
 
 	// Return the actual storage
 
-	gs := gm.gs.StorageManager(part+kind+StorageSuffixNodes, create)
+	gs := gm.storageManager(part, kind+StorageSuffixNodes, create)
 	if gs == nil {
 		return nil, nil, nil
 	}
@@ -254,21 +280,14 @@ func (gm *Manager) getEdgeStorageHTree(part string, kind string, create bool) (*
 
 	// Make sure all required lookup maps are there
 
-	if gm.getMainDBMap(MainDBEdgeKinds) == nil {
-		gm.storeMainDBMap(MainDBEdgeKinds, make(map[string]string))
-	}
+	gm.ensureMainDBMap(MainDBEdgeKinds)
+	gm.ensureMainDBMap(MainDBEdgeAttrs + kind)
 
-	if gm.getMainDBMap(MainDBEdgeAttrs+kind) == nil {
-		gm.storeMainDBMap(MainDBEdgeAttrs+kind, make(map[string]string))
-	}
-
-	if _, ok := gm.gs.MainDB()[MainDBEdgeCount+kind]; !ok {
-		gm.gs.MainDB()[MainDBEdgeCount+kind] = string(make([]byte, 8, 8))
-	}
+	gm.setMainDBValue(MainDBEdgeCount+kind, string(make([]byte, 8, 8)), false)
 
 	// Return the actual storage
 
-	gs := gm.gs.StorageManager(part+kind+StorageSuffixEdges, create)
+	gs := gm.storageManager(part, kind+StorageSuffixEdges, create)
 	if gs == nil {
 		return nil, nil
 	}
@@ -313,7 +332,7 @@ func (gm *Manager) getIndexHTree(part string, kind string, create bool, name str
 		}
 	}
 
-	gs := gm.gs.StorageManager(part+kind+suffix, create)
+	gs := gm.storageManager(part, kind+suffix, create)
 	if gs == nil {
 		return nil, nil
 	}
@@ -325,7 +344,7 @@ func (gm *Manager) getIndexHTree(part string, kind string, create bool, name str
 flushNodeStorage flushes a node storage.
 */
 func (gm *Manager) flushNodeStorage(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixNodes, false); sm != nil {
 		if err := sm.Flush(); err != nil {
 			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 		}
@@ -337,7 +356,7 @@ func (gm *Manager) flushNodeStorage(part string, kind string) error {
 flushNodeIndex flushes a node index.
 */
 func (gm *Manager) flushNodeIndex(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesIndex, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixNodesIndex, false); sm != nil {
 		if err := sm.Flush(); err != nil {
 			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 		}
@@ -349,7 +368,7 @@ func (gm *Manager) flushNodeIndex(part string, kind string) error {
 flushEdgeStorage flushes an edge storage.
 */
 func (gm *Manager) flushEdgeStorage(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdges, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixEdges, false); sm != nil {
 		if err := sm.Flush(); err != nil {
 			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 		}
@@ -361,7 +380,7 @@ func (gm *Manager) flushEdgeStorage(part string, kind string) error {
 flushEdgeIndex flushes an edge index.
 */
 func (gm *Manager) flushEdgeIndex(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdgesIndex, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixEdgesIndex, false); sm != nil {
 		if err := sm.Flush(); err != nil {
 			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 		}
@@ -373,7 +392,7 @@ func (gm *Manager) flushEdgeIndex(part string, kind string) error {
 rollbackNodeStorage rollbacks a node storage.
 */
 func (gm *Manager) rollbackNodeStorage(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixNodes, false); sm != nil {
 		if err := sm.Rollback(); err != nil {
 			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
 		}
@@ -385,7 +404,7 @@ func (gm *Manager) rollbackNodeStorage(part string, kind string) error {
 rollbackNodeIndex rollbacks a node index.
 */
 func (gm *Manager) rollbackNodeIndex(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesIndex, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixNodesIndex, false); sm != nil {
 		if err := sm.Rollback(); err != nil {
 			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
 		}
@@ -397,7 +416,7 @@ func (gm *Manager) rollbackNodeIndex(part string, kind string) error {
 rollbackEdgeStorage rollbacks an edge storage.
 */
 func (gm *Manager) rollbackEdgeStorage(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdges, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixEdges, false); sm != nil {
 		if err := sm.Rollback(); err != nil {
 			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
 		}
@@ -409,7 +428,7 @@ func (gm *Manager) rollbackEdgeStorage(part string, kind string) error {
 rollbackEdgeIndex rollbacks an edge index.
 */
 func (gm *Manager) rollbackEdgeIndex(part string, kind string) error {
-	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdgesIndex, false); sm != nil {
+	if sm := gm.storageManager(part, kind+StorageSuffixEdgesIndex, false); sm != nil {
 		if err := sm.Rollback(); err != nil {
 			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
 		}
@@ -453,9 +472,21 @@ func (gm *Manager) getHTree(sm storage.Manager, slot int) (*hash.HTree, error) {
 }
 
 /*
-getMainDBMap gets a map from the main database.
+getMainDBMap gets a map from the main database. The returned map is shared and
+must not be modified - use updateMainDBMap to change a map.
 */
 func (gm *Manager) getMainDBMap(key string) map[string]string {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	return gm.getMainDBMapLocked(key)
+}
+
+/*
+getMainDBMapLocked gets a map from the main database. Assumes that the main
+database lock is held.
+*/
+func (gm *Manager) getMainDBMapLocked(key string) map[string]string {
 
 	// First try to cache
 
@@ -480,10 +511,86 @@ storeMainDBMap stores a map in the main database. The map is stored as a gob byt
 Once it has been decoded it is cached for read operations.
 */
 func (gm *Manager) storeMainDBMap(key string, mapval map[string]string) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
 	gm.mapCache[key] = mapval
 	gm.gs.MainDB()[key] = mapToString(mapval)
 }
 
+/*
+updateMainDBMap changes a map in the main database in one step. The given
+function gets a copy of the map (nil if the map does not exist) and returns
+if the changed copy should be stored.
+*/
+func (gm *Manager) updateMainDBMap(key string, update func(mapval map[string]string) bool) {
+	var mapval map[string]string
+
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	if current := gm.getMainDBMapLocked(key); current != nil {
+		mapval = make(map[string]string, len(current))
+		for k, v := range current {
+			mapval[k] = v
+		}
+	}
+
+	if update(mapval) {
+		gm.mapCache[key] = mapval
+		gm.gs.MainDB()[key] = mapToString(mapval)
+	}
+}
+
+/*
+addMainDBMapKeys adds keys to an existing map in the main database. The map is
+only changed if a key is missing.
+*/
+func (gm *Manager) addMainDBMapKeys(key string, keys ...string) {
+	var mapval map[string]string
+
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	current := gm.getMainDBMapLocked(key)
+	if current == nil {
+		return
+	}
+
+	for _, k := range keys {
+		if _, ok := current[k]; !ok {
+
+			if mapval == nil {
+				mapval = make(map[string]string, len(current)+len(keys))
+				for ck, cv := range current {
+					mapval[ck] = cv
+				}
+			}
+
+			mapval[k] = ""
+		}
+	}
+
+	if mapval != nil {
+		gm.mapCache[key] = mapval
+		gm.gs.MainDB()[key] = mapToString(mapval)
+	}
+}
+
+/*
+ensureMainDBMap makes sure that a map exists in the main database.
+*/
+func (gm *Manager) ensureMainDBMap(key string) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	if gm.getMainDBMapLocked(key) == nil {
+		mapval := make(map[string]string)
+		gm.mapCache[key] = mapval
+		gm.gs.MainDB()[key] = mapToString(mapval)
+	}
+}
+
 // Static helper functions
 // =======================
 
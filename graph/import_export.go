@@ -66,29 +66,28 @@ func ExportPartition(out io.Writer, part string, gm *Manager) error {
   "nodes" : [
 `)
 
-	// Loop over all available kinds and build iterators if nodes
-	// exist in the given partition
+	// Build snapshot iterators for all available kinds - all snapshots
+	// are taken at the same time so nodes which are written during the
+	// export are neither skipped nor repeated
 
-	var iters []*NodeKeyIterator
-	var kinds []string
+	kinds := gm.NodeKinds()
 
-	for _, k := range gm.NodeKinds() {
-
-		it, err := gm.NodeKeyIterator(part, k)
-		if err != nil {
-			return err
-		}
-		if it != nil {
-			iters = append(iters, it)
-			kinds = append(kinds, k)
-		}
+	iters, err := gm.NodeKeySnapshotIterators(part, kinds)
+	if err != nil {
+		return err
 	}
 
+	first := true
+
 	for ik, it := range iters {
 
+		if it == nil {
+			continue
+		}
+
 		// Iterate over all node keys
 
-		for i := 0; it.HasNext(); i++ {
+		for it.HasNext() {
 			key := it.Next()
 
 			if it.LastError != nil {
@@ -98,6 +97,11 @@ func ExportPartition(out io.Writer, part string, gm *Manager) error {
 			node, err := gm.FetchNode(part, key, kinds[ik])
 			if err != nil {
 				return err
+			} else if node == nil {
+
+				// Node was removed after the snapshot was taken
+
+				continue
 			}
 
 			// Fetch all connected relationships and store their key and kind
@@ -113,46 +117,60 @@ func ExportPartition(out io.Writer, part string, gm *Manager) error {
 
 			// Write out JSON object
 
+			if !first {
+				fmt.Fprint(out, ",\n")
+			}
+			first = false
+
 			fmt.Fprint(out, "    {\n")
 
 			writeData(node.Data())
 
-			if it.HasNext() || ik < len(iters)-1 {
-				fmt.Fprint(out, "    },\n")
-			} else {
-				fmt.Fprint(out, "    }\n")
-			}
+			fmt.Fprint(out, "    }")
 		}
 	}
 
+	if !first {
+		fmt.Fprint(out, "\n")
+	}
+
 	fmt.Fprint(out, `  ],
   "edges" : [
 `)
 
 	// Iterate over all available edge kinds
 
-	ie := 0
+	first = true
+
 	for key, kind := range edgeKeys {
 		key = key[len(kind):]
 
 		edge, err := gm.FetchEdge(part, key, kind)
 		if err != nil {
 			return err
+		} else if edge == nil {
+
+			// Edge was removed during the export
+
+			continue
 		}
 
 		// Write out JSON object
 
+		if !first {
+			fmt.Fprint(out, ",\n")
+		}
+		first = false
+
 		fmt.Fprint(out, "    {\n")
 
 		writeData(edge.Data())
 
-		if ie < len(edgeKeys)-1 {
-			fmt.Fprint(out, "    },\n")
-		} else {
-			fmt.Fprint(out, "    }\n")
-		}
+		fmt.Fprint(out, "    }")
+	}
 
-		ie++
+	if !first {
+		fmt.Fprint(out, "\n")
 	}
 
 	fmt.Fprint(out, `  ]
@@ -0,0 +1,181 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+IndexReportMaxExamples is the maximum number of inconsistent index entries
+which are listed in an index report.
+*/
+var IndexReportMaxExamples = 10
+
+/*
+IndexProgress is called during index operations with the number of processed
+items and the total number of items.
+*/
+type IndexProgress func(done uint64, total uint64)
+
+/*
+IndexReport is the result of an index consistency check of a node or edge kind.
+*/
+type IndexReport struct {
+	Partition  string   `json:"partition"`  // Partition which was checked
+	Kind       string   `json:"kind"`       // Kind which was checked
+	Items      uint64   `json:"items"`      // Number of checked nodes or edges
+	Entries    uint64   `json:"entries"`    // Number of expected index entries
+	Missing    uint64   `json:"missing"`    // Expected entries which are not in the index
+	Mismatched uint64   `json:"mismatched"` // Entries which are in the index but differ
+	Unexpected uint64   `json:"unexpected"` // Entries in the index which should not exist
+	Examples   []string `json:"examples"`   // Examples of inconsistent entries
+}
+
+/*
+Consistent returns true if no discrepancies were found.
+*/
+func (ir *IndexReport) Consistent() bool {
+	return ir.Missing == 0 && ir.Mismatched == 0 && ir.Unexpected == 0
+}
+
+/*
+addExample adds an example of an inconsistent index entry.
+*/
+func (ir *IndexReport) addExample(problem string, key []byte) {
+	if len(ir.Examples) < IndexReportMaxExamples {
+		ir.Examples = append(ir.Examples, fmt.Sprintf("%v: %q", problem, key))
+	}
+}
+
+/*
+VerifyNodeIndex checks the full-text and lookup index of all nodes of a kind
+in a partition against the stored node data. The index is not changed - use
+ReindexNodes to repair it. An optional progress function is called after each
+checked node.
+*/
+func (gm *Manager) VerifyNodeIndex(part string, kind string, progress IndexProgress) (*IndexReport, error) {
+	report := &IndexReport{Partition: part, Kind: kind}
+
+	attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || attht == nil || valht == nil {
+		return report, err
+	}
+
+	iht, err := gm.getNodeIndexHTree(part, kind, false)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gm.readLock(part)()
+
+	err = gm.verifyIndex(report, iht, attht, gm.nodeIndexMap(kind, attht, valht), progress)
+
+	return report, err
+}
+
+/*
+VerifyEdgeIndex checks the full-text and lookup index of all edges of a kind
+in a partition against the stored edge data. The index is not changed - use
+ReindexEdges to repair it. An optional progress function is called after each
+checked edge.
+*/
+func (gm *Manager) VerifyEdgeIndex(part string, kind string, progress IndexProgress) (*IndexReport, error) {
+	report := &IndexReport{Partition: part, Kind: kind}
+
+	edgeht, err := gm.getEdgeStorageHTree(part, kind, false)
+	if err != nil || edgeht == nil {
+		return report, err
+	}
+
+	iht, err := gm.getEdgeIndexHTree(part, kind, false)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gm.readLock(part)()
+
+	err = gm.verifyIndex(report, iht, edgeht, gm.edgeIndexMap(kind, edgeht), progress)
+
+	return report, err
+}
+
+/*
+verifyIndex builds the expected index of all items of a storage HTree in
+memory and compares it with a given index HTree (which may be nil).
+*/
+func (gm *Manager) verifyIndex(report *IndexReport, iht *hash.HTree, storageht *hash.HTree,
+	indexMap func(key string) (map[string]string, error), progress IndexProgress) error {
+
+	expected, err := hash.NewHTree(storage.NewMemoryStorageManager("verify"))
+	if err != nil {
+		return &util.GraphError{Type: util.ErrIndexError, Detail: err.Error()}
+	}
+
+	if report.Items, err = gm.indexItems(gm.newIndexManager(expected, report.Kind),
+		storageht, indexMap, progress); err != nil {
+		return err
+	}
+
+	// Check that all expected entries are in the index
+
+	it := hash.NewHTreeIterator(expected)
+	for it.HasNext() {
+		var actual interface{}
+
+		k, v := it.Next()
+
+		report.Entries++
+
+		if iht != nil {
+			if actual, err = iht.Get(k); err != nil {
+				return &util.GraphError{Type: util.ErrIndexError, Detail: err.Error()}
+			}
+		}
+
+		if actual == nil {
+			report.Missing++
+			report.addExample("missing", k)
+		} else if !reflect.DeepEqual(actual, v) {
+			report.Mismatched++
+			report.addExample("mismatched", k)
+		}
+	}
+
+	if it.LastError != nil {
+		return &util.GraphError{Type: util.ErrIndexError, Detail: it.LastError.Error()}
+	} else if iht == nil {
+		return nil
+	}
+
+	// Check that the index has no additional entries
+
+	it = hash.NewHTreeIterator(iht)
+	for it.HasNext() {
+		k, _ := it.Next()
+
+		if ok, _ := expected.Exists(k); !ok {
+			report.Unexpected++
+			report.addExample("unexpected", k)
+		}
+	}
+
+	if it.LastError != nil {
+		return &util.GraphError{Type: util.ErrIndexError, Detail: it.LastError.Error()}
+	}
+
+	return nil
+}
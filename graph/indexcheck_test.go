@@ -0,0 +1,154 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	var progress []string
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := newGraphManagerNoRules(mgs)
+
+	for i, text := range []string{"Hello world", "Hello index"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "mynode")
+		node.SetAttr("text", text)
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKey, "e1")
+	edge.SetAttr(data.NodeKind, "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "0")
+	edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd1Role, "node1")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "1")
+	edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+	edge.SetAttr(data.EdgeEnd2Role, "node2")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+	edge.SetAttr("text", "Edge text")
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := gm.VerifyNodeIndex("main", "mynode", func(done uint64, total uint64) {
+		progress = append(progress, fmt.Sprint(done, "/", total))
+	})
+
+	if err != nil || !report.Consistent() || report.Items != 2 || report.Entries == 0 ||
+		fmt.Sprint(progress) != "[1/2 2/2]" {
+		t.Error("Unexpected result:", report, progress, err)
+		return
+	}
+
+	if report, err = gm.VerifyEdgeIndex("main", "myedge", nil); err != nil ||
+		!report.Consistent() || report.Items != 1 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Unknown kinds have an empty report
+
+	if report, err = gm.VerifyNodeIndex("main", "foo", nil); err != nil ||
+		fmt.Sprint(report) != "&{main foo 0 0 0 0 0 []}" {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Corrupt the node index
+
+	iht, _ := gm.getNodeIndexHTree("main", "mynode", false)
+
+	iht.Remove([]byte(util.PrefixAttrWord + "text" + "world"))
+	iht.Put([]byte(util.PrefixAttrWord+"text"+"hello"), "foo")
+	iht.Put([]byte(util.PrefixAttrWord+"text"+"bar"), "foo")
+
+	if report, err = gm.VerifyNodeIndex("main", "mynode", nil); err != nil || report.Consistent() ||
+		report.Missing != 1 || report.Mismatched != 1 || report.Unexpected != 1 ||
+		len(report.Examples) != 3 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	IndexReportMaxExamples = 1
+	defer func() {
+		IndexReportMaxExamples = 10
+	}()
+
+	if report, err = gm.VerifyNodeIndex("main", "mynode", nil); err != nil || len(report.Examples) != 1 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Repair the index
+
+	progress = nil
+
+	if err := gm.ReindexNodes("main", "mynode", func(done uint64, total uint64) {
+		progress = append(progress, fmt.Sprint(done, "/", total))
+	}); err != nil || fmt.Sprint(progress) != "[1/2 2/2]" {
+		t.Error("Unexpected result:", progress, err)
+		return
+	}
+
+	if report, err = gm.VerifyNodeIndex("main", "mynode", nil); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// A lost index can be rebuilt
+
+	var keys [][]byte
+
+	iht, _ = gm.getEdgeIndexHTree("main", "myedge", false)
+
+	it := hash.NewHTreeIterator(iht)
+	for it.HasNext() {
+		k, _ := it.Next()
+		keys = append(keys, k)
+	}
+
+	for _, k := range keys {
+		iht.Remove(k)
+	}
+
+	if report, err = gm.VerifyEdgeIndex("main", "myedge", nil); err != nil ||
+		report.Missing != report.Entries || report.Entries == 0 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if err := gm.ReindexEdges("main", "myedge", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if report, err = gm.VerifyEdgeIndex("main", "myedge", nil); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sort"
+
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+SetIndexed enables or disables the full-text and lookup index for an attribute
+of a node or edge kind. The attribute util.UnindexedAll (*) disables the index
+for all attributes of a kind. Changes only apply to data which is written
+afterwards - use ReindexNodes or ReindexEdges to apply them to existing data.
+*/
+func (gm *Manager) SetIndexed(kind string, attr string, indexed bool) error {
+
+	if kind == "" || attr == "" {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Index setting needs a kind and an attribute"}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	unindexed := make(map[string]string)
+
+	for k, v := range gm.getMainDBMap(MainDBUnindexed + kind) {
+		unindexed[k] = v
+	}
+
+	if indexed {
+		delete(unindexed, attr)
+	} else {
+		unindexed[attr] = ""
+	}
+
+	if len(unindexed) == 0 {
+		gm.removeMainDBValue(MainDBUnindexed + kind)
+	} else {
+		gm.storeMainDBMap(MainDBUnindexed+kind, unindexed)
+	}
+
+	return gm.flushMain()
+}
+
+/*
+Unindexed returns all attributes which are not indexed as a map of kind to a
+sorted list of attributes.
+*/
+func (gm *Manager) Unindexed() map[string][]string {
+	ret := make(map[string][]string)
+
+	for _, key := range gm.mainDBKeys(MainDBUnindexed) {
+		var attrs []string
+
+		kind := key[len(MainDBUnindexed):]
+
+		for attr := range gm.kindUnindexed(kind) {
+			attrs = append(attrs, attr)
+		}
+
+		sort.Strings(attrs)
+
+		ret[kind] = attrs
+	}
+
+	return ret
+}
+
+/*
+kindUnindexed returns all attributes of a kind which are not indexed.
+*/
+func (gm *Manager) kindUnindexed(kind string) map[string]bool {
+	var ret map[string]bool
+
+	for attr := range gm.getMainDBMap(MainDBUnindexed + kind) {
+		if ret == nil {
+			ret = make(map[string]bool)
+		}
+		ret[attr] = true
+	}
+
+	return ret
+}
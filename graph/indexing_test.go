@@ -0,0 +1,122 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+func TestSetIndexed(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := newGraphManagerNoRules(mgs)
+
+	if err := gm.SetIndexed("mynode", "", false); err == nil ||
+		err.Error() != "GraphError: Invalid data (Index setting needs a kind and an attribute)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetIndexed("mynode", "counter", false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.Unindexed(); fmt.Sprint(res) != "map[mynode:[counter]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "1")
+	node.SetAttr(data.NodeKind, "mynode")
+	node.SetAttr("name", "hello")
+	node.SetAttr("counter", 42)
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	iq, _ := gm.NodeIndexQuery("main", "mynode")
+
+	if res, _ := iq.LookupValue("counter", "42"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := iq.LookupWord("name", "hello"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Disable the index for the whole kind - existing data is only
+	// affected after a reindex
+
+	if err := gm.SetIndexed("mynode", util.UnindexedAll, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.Unindexed(); fmt.Sprint(res) != "map[mynode:[* counter]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if report, _ := gm.VerifyNodeIndex("main", "mynode", nil); report.Unexpected == 0 || report.Entries != 0 {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	if err := gm.ReindexNodes("main", "mynode", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := iq.LookupWord("name", "hello"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Enable the index again
+
+	gm.SetIndexed("mynode", util.UnindexedAll, true)
+	gm.SetIndexed("mynode", "counter", true)
+
+	if res := gm.Unindexed(); fmt.Sprint(res) != "map[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.ReindexNodes("main", "mynode", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := iq.LookupValue("counter", "42"); fmt.Sprint(res) != "[1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	graphstorage.MgsRetFlushMain = &util.GraphError{Type: util.ErrFlushing, Detail: errors.New("Test").Error()}
+
+	if err := gm.SetIndexed("mynode", "counter", false); err == nil ||
+		err.Error() != "GraphError: Failed to flush changes (Test)" {
+		t.Error("Unexpected result:", err)
+	}
+
+	graphstorage.MgsRetFlushMain = nil
+}
@@ -10,6 +10,8 @@
 
 package graph
 
+import "github.com/krotik/eliasdb/graph/util"
+
 /*
 IndexQuery models the interface to the full text search index.
 */
@@ -22,6 +24,12 @@ type IndexQuery interface {
 	*/
 	LookupPhrase(attr, phrase string) ([]string, error)
 
+	/*
+		LookupPhraseScored finds all nodes where an attribute contains a certain
+		phrase. This call returns a list of matches ranked by their BM25 score.
+	*/
+	LookupPhraseScored(attr, phrase string) ([]*util.PhraseMatch, error)
+
 	/*
 		LookupWord finds all nodes where an attribute contains a certain word.
 		This call returns a map which maps node key to a list of word positions.
@@ -33,4 +41,38 @@ type IndexQuery interface {
 		This call returns a list of node keys.
 	*/
 	LookupValue(attr, value string) ([]string, error)
+
+	/*
+		LookupGeoBBox finds all nodes where an attribute holds a location
+		inside a given bounding box. This call returns a list of node keys.
+	*/
+	LookupGeoBBox(attr string, minLat, minLon, maxLat, maxLon float64) ([]string, error)
+
+	/*
+		LookupGeoRadius finds all nodes where an attribute holds a location
+		within a given radius (in km) of a given location. This call returns
+		a list of node keys ordered by distance.
+	*/
+	LookupGeoRadius(attr string, lat, lon, radius float64) ([]string, error)
+
+	/*
+		LookupPrefix finds all nodes where an attribute contains a word which
+		starts with a given prefix. This call returns a list of matches ranked
+		by the length of the completion and the number of occurrences.
+	*/
+	LookupPrefix(attr, prefix string) ([]*util.WordMatch, error)
+
+	/*
+		LookupFuzzy finds all nodes where an attribute contains a word which
+		has at most a given edit distance to a given word. This call returns a
+		list of matches ranked by distance and number of occurrences.
+	*/
+	LookupFuzzy(attr, word string, maxDistance int) ([]*util.WordMatch, error)
+
+	/*
+		LookupComposite finds all nodes which match a composite query which
+		combines word, value and phrase lookups over several attributes with
+		and / or. This call returns a sorted list of node keys.
+	*/
+	LookupComposite(term *util.IndexTerm) ([]string, error)
 }
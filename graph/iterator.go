@@ -11,16 +11,24 @@
 package graph
 
 import (
+	"context"
+
 	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/hash"
 )
 
 /*
-NodeKeyIterator can be used to iterate node keys of a certain node kind.
+NodeKeyIterator can be used to iterate node keys of a certain node kind. A
+normal iterator walks the live node storage - keys which are stored or removed
+during the iteration might be missed or returned. A snapshot iterator returns
+exactly the keys which existed when it was created.
 */
 type NodeKeyIterator struct {
 	gm        *Manager            // GraphManager which created the iterator
-	it        *hash.HTreeIterator // Internal HTree iterator
+	part      string              // Partition of the iterated nodes
+	it        *hash.HTreeIterator // Internal HTree iterator (nil for snapshots)
+	ctx       context.Context     // Context which can abort the iteration
+	keys      []string            // Remaining keys of a snapshot
 	LastError error               // Last encountered error
 }
 
@@ -29,10 +37,26 @@ Next returns the next node key. Sets the LastError attribute if an error occurs.
 */
 func (it *NodeKeyIterator) Next() string {
 
+	// Check if the iteration was aborted
+
+	if err := it.ctx.Err(); err != nil {
+		it.LastError = err
+		return ""
+	}
+
+	if it.it == nil {
+		var k string
+
+		if len(it.keys) > 0 {
+			k, it.keys = it.keys[0], it.keys[1:]
+		}
+
+		return k
+	}
+
 	// Take reader lock
 
-	it.gm.mutex.RLock()
-	defer it.gm.mutex.RUnlock()
+	defer it.gm.readLock(it.part)()
 
 	k, _ := it.it.Next()
 
@@ -50,9 +74,19 @@ func (it *NodeKeyIterator) Next() string {
 HasNext returns if there is a next node key.
 */
 func (it *NodeKeyIterator) HasNext() bool {
+	if it.it == nil {
+		return len(it.keys) > 0
+	}
 	return it.it.HasNext()
 }
 
+/*
+IsSnapshot returns if this iterator iterates over a snapshot of the node keys.
+*/
+func (it *NodeKeyIterator) IsSnapshot() bool {
+	return it.it == nil
+}
+
 /*
 Error returns the last encountered error.
 */
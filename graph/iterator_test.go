@@ -11,6 +11,9 @@
 package graph
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/krotik/eliasdb/graph/data"
@@ -143,3 +146,117 @@ func TestNodeKeyIterator(t *testing.T) {
 		return
 	}
 }
+
+func TestNodeKeySnapshotIterator(t *testing.T) {
+
+	mgs := graphstorage.NewMemoryGraphStorage("iterator test")
+
+	gm := newGraphManagerNoRules(mgs)
+
+	for _, key := range []string{"1", "2", "3"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "mykind")
+		gm.StoreNode("main", node)
+	}
+
+	if ni, err := gm.NodeKeySnapshotIterator("main", "unknown"); ni != nil || err != nil {
+		t.Error("Unexpected result:", ni, err)
+		return
+	}
+
+	ni, err := gm.NodeKeySnapshotIterator("main", "mykind")
+	if err != nil || !ni.IsSnapshot() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Changes during the iteration do not affect the snapshot
+
+	var keys []string
+
+	for ni.HasNext() {
+		key := ni.Next()
+		keys = append(keys, key)
+
+		if key == "1" {
+			gm.RemoveNode("main", "2", "mykind")
+
+			node := data.NewGraphNode()
+			node.SetAttr("key", "4")
+			node.SetAttr("kind", "mykind")
+			gm.StoreNode("main", node)
+		}
+	}
+
+	if fmt.Sprint(keys) != "[1 2 3]" || ni.Error() != nil {
+		t.Error("Unexpected result:", keys, ni.Error())
+		return
+	}
+
+	if ni.Next() != "" || ni.Error() != nil {
+		t.Error("Expected iterator to run out of items:", ni.Error())
+		return
+	}
+
+	// Errors are reported when the snapshot is created
+
+	msm := mgs.StorageManager("main"+"mykind"+StorageSuffixNodes, false)
+
+	tree, _, _ := gm.getNodeStorageHTree("main", "mykind", false)
+	_, loc, _ := tree.GetValueAndLocation([]byte(PrefixNSAttrs + "3"))
+
+	msm.(*storage.MemoryStorageManager).AccessMap[loc] = storage.AccessCacheAndFetchSeriousError
+
+	if ni, err = gm.NodeKeySnapshotIterator("main", "mykind"); ni != nil || err == nil {
+		t.Error("Snapshot iterator should not be created at this point")
+		return
+	}
+
+	delete(msm.(*storage.MemoryStorageManager).AccessMap, loc)
+}
+
+func TestNodeKeySnapshotIterators(t *testing.T) {
+
+	mgs := graphstorage.NewMemoryGraphStorage("iterator test")
+
+	gm := NewGraphManager(mgs)
+
+	for _, kind := range []string{"kind1", "kind2"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", "1")
+		node.SetAttr("kind", kind)
+		gm.StoreNode("main", node)
+	}
+
+	its, err := gm.NodeKeySnapshotIterators("main", []string{"kind1", "unknown", "kind2"})
+	if err != nil || len(its) != 3 || its[1] != nil {
+		t.Error("Unexpected result:", its, err)
+		return
+	}
+
+	// Removing a node after the snapshot does not affect any of the iterators
+
+	gm.RemoveNode("main", "1", "kind2")
+
+	if !its[0].HasNext() || its[0].Next() != "1" || !its[2].HasNext() || its[2].Next() != "1" {
+		t.Error("Unexpected iterator state")
+		return
+	}
+
+	// The export produces valid JSON if a kind has no nodes left
+
+	var out bytes.Buffer
+
+	if err := ExportPartition(&out, "main", gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string][]interface{}
+
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil || len(res["nodes"]) != 1 {
+		t.Error("Unexpected result:", out.String(), err)
+		return
+	}
+}
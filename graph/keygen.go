@@ -0,0 +1,140 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/krotik/common/cryptutil"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+Modes of key generation for nodes and edges which are stored without a key
+*/
+const (
+	KeyGenerationNone     = "none"     // Nodes and edges must have a key
+	KeyGenerationUUID     = "uuid"     // Keys are random UUIDs
+	KeyGenerationSequence = "sequence" // Keys are increasing numbers per partition and kind
+)
+
+/*
+keyGeneration holds the mode of key generation of a graph manager.
+*/
+type keyGeneration struct {
+	mode atomic.Value // Current mode
+}
+
+/*
+SetKeyGeneration sets how keys are generated for nodes and edges which are
+stored without a key. Keys are either random UUIDs or increasing numbers
+(starting with 1) for each partition and kind. Nodes and edges must have a key
+if key generation is disabled (default).
+*/
+func (gm *Manager) SetKeyGeneration(mode string) error {
+
+	if mode != KeyGenerationNone && mode != KeyGenerationUUID && mode != KeyGenerationSequence {
+		return &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprintf("Unknown key generation mode: %v", mode)}
+	}
+
+	gm.keyGen.mode.Store(mode)
+
+	return nil
+}
+
+/*
+KeyGeneration returns how keys are generated for nodes and edges which are
+stored without a key.
+*/
+func (gm *Manager) KeyGeneration() string {
+	if mode, ok := gm.keyGen.mode.Load().(string); ok {
+		return mode
+	}
+	return KeyGenerationNone
+}
+
+/*
+assignKey sets a generated key on a node or an edge which has no key. Nothing
+is done if key generation is disabled or the node or edge has no kind - it
+cannot be stored in this case.
+*/
+func (gm *Manager) assignKey(part string, node data.Node, isEdge bool) error {
+
+	if node.Key() != "" || node.Kind() == "" {
+		return nil
+	}
+
+	switch gm.KeyGeneration() {
+
+	case KeyGenerationUUID:
+		node.SetAttr(data.NodeKey, fmt.Sprintf("%x", cryptutil.GenerateUUID()))
+
+	case KeyGenerationSequence:
+
+		// Sequence numbers are not flushed on their own - numbers which were
+		// lost in a crash are skipped if they were already used as keys
+
+		for {
+			var existing data.Node
+			var err error
+
+			key := strconv.FormatUint(gm.nextKeySeq(part, node.Kind(), isEdge), 10)
+
+			if isEdge {
+				existing, err = gm.FetchEdge(part, key, node.Kind())
+			} else {
+				existing, err = gm.FetchNode(part, key, node.Kind())
+			}
+
+			if err != nil {
+				return err
+			} else if existing == nil {
+				node.SetAttr(data.NodeKey, key)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+nextKeySeq increases the key sequence number of a kind in a partition and
+returns the new value.
+*/
+func (gm *Manager) nextKeySeq(part string, kind string, isEdge bool) uint64 {
+	var seq uint64
+
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	entry := MainDBKeySeq + part + "#n#" + kind
+	if isEdge {
+		entry = MainDBKeySeq + part + "#e#" + kind
+	}
+
+	if val, ok := gm.gs.MainDB()[entry]; ok {
+		seq = binary.LittleEndian.Uint64([]byte(val))
+	}
+
+	seq++
+
+	numstr := make([]byte, 8)
+	binary.LittleEndian.PutUint64(numstr, seq)
+	gm.gs.MainDB()[entry] = string(numstr)
+
+	return seq
+}
@@ -0,0 +1,141 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestKeyGeneration(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	newNode := func(kind string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKind, kind)
+		return node
+	}
+
+	// Nodes must have a key by default
+
+	if mode := gm.KeyGeneration(); mode != KeyGenerationNone {
+		t.Error("Unexpected result:", mode)
+		return
+	}
+
+	if err := gm.StoreNode("main", newNode("Song")); err == nil ||
+		err.Error() != "GraphError: Invalid data (Node is missing a key value)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetKeyGeneration("foo"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown key generation mode: foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Generate UUIDs
+
+	gm.SetKeyGeneration(KeyGenerationUUID)
+
+	node := newNode("Song")
+
+	if err := gm.StoreNode("main", node); err != nil || len(node.Key()) != 32 {
+		t.Error("Unexpected result:", node.Key(), err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", node.Key(), "Song"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Generate sequence keys - existing keys are skipped
+
+	gm.SetKeyGeneration(KeyGenerationSequence)
+
+	node = newNode("Author")
+	node.SetAttr(data.NodeKey, "2")
+	gm.StoreNode("main", node)
+
+	var keys []string
+
+	for _, part := range []string{"main", "main", "main", "test"} {
+		node := newNode("Author")
+
+		if err := gm.StoreNode(part, node); err != nil {
+			t.Error(err)
+			return
+		}
+
+		keys = append(keys, node.Key())
+	}
+
+	node = newNode("Song")
+	gm.UpdateNode("main", node)
+	keys = append(keys, node.Key())
+
+	if res := fmt.Sprint(keys); res != "[1 3 4 1 1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Keys are generated for nodes and edges of transactions
+
+	trans := NewGraphTrans(gm)
+
+	node = newNode("Author")
+	trans.StoreNode("main", node)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKind, "Wrote")
+	edge.SetAttr(data.EdgeEnd1Key, node.Key())
+	edge.SetAttr(data.EdgeEnd1Kind, "Author")
+	edge.SetAttr(data.EdgeEnd1Role, "Author")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "1")
+	edge.SetAttr(data.EdgeEnd2Kind, "Author")
+	edge.SetAttr(data.EdgeEnd2Role, "Other")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := trans.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if node.Key() != "5" || edge.Key() != "1" {
+		t.Error("Unexpected result:", node.Key(), edge.Key())
+		return
+	}
+
+	if e, err := gm.FetchEdge("main", "1", "Wrote"); err != nil || e == nil || e.End1Key() != "5" {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	// Nodes without a kind are not changed
+
+	node = data.NewGraphNode()
+
+	if err := gm.StoreNode("main", node); err == nil || node.Key() != "" {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+}
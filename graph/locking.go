@@ -0,0 +1,191 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"strings"
+	"sync"
+)
+
+/*
+PartitionLocking is a flag if operations on a single partition should only
+lock the partition they work on. If the flag is false every write operation
+takes the writer lock of the whole graph.
+*/
+var PartitionLocking = true
+
+// Locking
+// =======
+//
+// The graph manager uses three levels of locks:
+//
+// 1. The graph lock (gm.mutex) is taken exclusively by operations which work
+//    on several partitions (e.g. transaction commits). Operations on a single
+//    partition only take it for reading.
+//
+// 2. Each partition has its own reader/writer lock which is taken by operations
+//    on a single partition. Writes to different partitions run in parallel.
+//
+// 3. The main database lock (gm.mainMutex) protects the main database which is
+//    shared by all partitions. It is only held for short accesses and no other
+//    lock is taken while it is held.
+
+/*
+partitionLocks holds a reader/writer lock for every partition of a graph.
+*/
+type partitionLocks struct {
+	locks map[string]*sync.RWMutex // Locks of all partitions
+	mutex *sync.Mutex              // Mutex to protect the map of locks
+}
+
+/*
+newPartitionLocks creates a new partitionLocks object.
+*/
+func newPartitionLocks() *partitionLocks {
+	return &partitionLocks{make(map[string]*sync.RWMutex), &sync.Mutex{}}
+}
+
+/*
+get returns the lock of a given partition.
+*/
+func (pl *partitionLocks) get(part string) *sync.RWMutex {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	l, ok := pl.locks[part]
+
+	if !ok {
+		l = &sync.RWMutex{}
+		pl.locks[part] = l
+	}
+
+	return l
+}
+
+/*
+readLock takes the locks for reading from a single partition. Returns a
+function which releases the locks.
+*/
+func (gm *Manager) readLock(part string) func() {
+	gm.mutex.RLock()
+
+	l := gm.partLocks.get(part)
+	l.RLock()
+
+	return func() {
+		l.RUnlock()
+		gm.mutex.RUnlock()
+	}
+}
+
+/*
+writeLock takes the locks for writing to a single partition. Writers of
+different partitions run in parallel if all graph rules only work on the
+partition of an event (see PartitionRule) - rules are executed while the
+locks are held. Otherwise the writer lock of the whole graph is taken.
+Returns a function which releases the locks.
+*/
+func (gm *Manager) writeLock(part string) func() {
+
+	if !PartitionLocking || !gm.gr.partitionOnly() {
+		gm.mutex.Lock()
+		return gm.mutex.Unlock
+	}
+
+	gm.mutex.RLock()
+
+	l := gm.partLocks.get(part)
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+		gm.mutex.RUnlock()
+	}
+}
+
+// Main database access
+// ====================
+
+/*
+mainDBValue returns a value of the main database.
+*/
+func (gm *Manager) mainDBValue(key string) (string, bool) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	val, ok := gm.gs.MainDB()[key]
+
+	return val, ok
+}
+
+/*
+setMainDBValue sets a value of the main database. An existing value is only
+overwritten if the overwrite flag is set.
+*/
+func (gm *Manager) setMainDBValue(key string, val string, overwrite bool) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	if _, ok := gm.gs.MainDB()[key]; overwrite || !ok {
+		gm.gs.MainDB()[key] = val
+	}
+}
+
+/*
+removeMainDBValue removes a value (and a cached map) from the main database.
+*/
+func (gm *Manager) removeMainDBValue(key string) {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	delete(gm.mapCache, key)
+	delete(gm.gs.MainDB(), key)
+}
+
+/*
+mainDBKeys returns all keys of the main database which start with a given
+prefix.
+*/
+func (gm *Manager) mainDBKeys(prefix string) []string {
+	var ret []string
+
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	for key := range gm.gs.MainDB() {
+		if strings.HasPrefix(key, prefix) {
+			ret = append(ret, key)
+		}
+	}
+
+	return ret
+}
+
+/*
+flushMain writes the main database to the storage.
+*/
+func (gm *Manager) flushMain() error {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	return gm.gs.FlushMain()
+}
+
+/*
+rollbackMain rolls back all changes of the main database which have not been
+written to the storage.
+*/
+func (gm *Manager) rollbackMain() error {
+	gm.mainMutex.Lock()
+	defer gm.mainMutex.Unlock()
+
+	return gm.gs.RollbackMain()
+}
@@ -0,0 +1,261 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestPartitionLocking(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(part string, key string) chan error {
+		res := make(chan error, 1)
+
+		go func() {
+			node := data.NewGraphNode()
+			node.SetAttr("key", key)
+			node.SetAttr("kind", "mynode")
+			node.SetAttr("name", "Node "+key)
+
+			res <- gm.StoreNode(part, node)
+		}()
+
+		return res
+	}
+
+	waitFor := func(res chan error) bool {
+		select {
+		case err := <-res:
+			if err != nil {
+				t.Error(err)
+			}
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+
+	// Writes to different partitions run in parallel
+
+	gm.partLocks.get("part1").Lock()
+
+	blocked := storeNode("part1", "123")
+
+	if !waitFor(storeNode("part2", "123")) {
+		t.Error("Write to other partition should not be blocked")
+		return
+	}
+
+	if waitFor(blocked) {
+		t.Error("Write to locked partition should be blocked")
+		return
+	}
+
+	gm.partLocks.get("part1").Unlock()
+
+	if !waitFor(blocked) {
+		t.Error("Write should have finished")
+		return
+	}
+
+	// Readers of the graph lock do not block partition writers
+
+	gm.mutex.RLock()
+
+	if !waitFor(storeNode("part2", "456")) {
+		t.Error("Write should not be blocked")
+		return
+	}
+
+	// Rules which are not partition rules require the graph lock
+
+	gm.SetGraphRule(&TestRule{handles: []int{EventNodeCreated}})
+
+	blocked = storeNode("part2", "789")
+
+	if waitFor(blocked) {
+		t.Error("Write should be blocked by the graph lock")
+		return
+	}
+
+	gm.mutex.RUnlock()
+
+	if !waitFor(blocked) {
+		t.Error("Write should have finished")
+		return
+	}
+
+	if cnt := gm.NodeCount("mynode"); cnt != 4 {
+		t.Error("Unexpected node count:", cnt)
+		return
+	}
+
+	// Concurrent writes to many partitions keep the shared data consistent
+
+	mgs = graphstorage.NewMemoryGraphStorage("mystorage")
+	gm = NewGraphManager(mgs)
+
+	var wg sync.WaitGroup
+	var errors int32
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(part string) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				node := data.NewGraphNode()
+				node.SetAttr("key", fmt.Sprint(j))
+				node.SetAttr("kind", "mynode")
+				node.SetAttr("attr"+part, j)
+
+				if err := gm.StoreNode(part, node); err != nil {
+					atomic.AddInt32(&errors, 1)
+				}
+
+				if _, _, err := gm.TraverseMulti(part, fmt.Sprint(j), "mynode", ":::", false); err != nil {
+					atomic.AddInt32(&errors, 1)
+				}
+			}
+		}(fmt.Sprint("part", i))
+	}
+
+	wg.Wait()
+
+	if errors != 0 {
+		t.Error("Unexpected number of errors:", errors)
+		return
+	}
+
+	if cnt := gm.NodeCount("mynode"); cnt != 400 {
+		t.Error("Unexpected node count:", cnt)
+		return
+	}
+
+	if res := fmt.Sprint(gm.Partitions()); res != "[part0 part1 part2 part3 part4 part5 part6 part7]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := len(gm.NodeAttrs("mynode")); res != 10 {
+		t.Error("Unexpected result:", gm.NodeAttrs("mynode"))
+		return
+	}
+
+	for i := 0; i < 8; i++ {
+		part := fmt.Sprint("part", i)
+
+		if n, err := gm.FetchNode(part, "49", "mynode"); err != nil || n.Attr("attr"+part) != 49 {
+			t.Error("Unexpected result:", n, err)
+			return
+		}
+	}
+}
+
+/*
+benchmarkStoreNodes stores nodes from several goroutines. Each goroutine
+writes to its own partition if the separate flag is set.
+*/
+func benchmarkStoreNodes(b *testing.B, separate bool) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	var workers int32
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		worker := atomic.AddInt32(&workers, 1)
+		part := "main"
+
+		if separate {
+			part = fmt.Sprint("part", worker)
+		}
+
+		for i := 0; pb.Next(); i++ {
+			node := data.NewGraphNode()
+			node.SetAttr("key", fmt.Sprint(worker, "-", i))
+			node.SetAttr("kind", "mynode")
+			node.SetAttr("name", "Some name")
+
+			if err := gm.StoreNode(part, node); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkStoreNodesSamePartition(b *testing.B) {
+	benchmarkStoreNodes(b, false)
+}
+
+func BenchmarkStoreNodesSeparatePartitions(b *testing.B) {
+	benchmarkStoreNodes(b, true)
+}
+
+func BenchmarkStoreNodesSeparatePartitionsGraphLock(b *testing.B) {
+	PartitionLocking = false
+	defer func() {
+		PartitionLocking = true
+	}()
+
+	benchmarkStoreNodes(b, true)
+}
+
+func BenchmarkFetchNodesDuringWrites(b *testing.B) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mynode")
+	gm.StoreNode("read", node)
+
+	done := make(chan bool)
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			node := data.NewGraphNode()
+			node.SetAttr("key", fmt.Sprint(i))
+			node.SetAttr("kind", "mynode")
+			gm.StoreNode("write", node)
+		}
+	}()
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if n, err := gm.FetchNode("read", "123", "mynode"); n == nil || err != nil {
+				b.Error("Unexpected result:", n, err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,346 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+LockManager manages named reader/writer locks which are held by owners (e.g.
+transactions) until they are released. Lock requests which cannot be granted
+wait in the order in which they were made. A request which would close a wait
+cycle between owners fails with an util.ErrDeadlock error - the requesting
+owner should release all its locks so the other owners can continue. An owner
+must not request locks concurrently.
+*/
+type LockManager struct {
+	locks     map[string]*managedLock // All locks which are held or waited for
+	waits     map[string]*lockWait    // Waiting requests of owners
+	deadlocks uint64                  // Number of detected deadlocks
+	mutex     *sync.Mutex             // Mutex to protect the lock manager
+	cond      *sync.Cond              // Condition which is signalled when a lock is released
+}
+
+/*
+managedLock is a lock of the lock manager.
+*/
+type managedLock struct {
+	holders   map[string]bool // Owners which hold the lock
+	exclusive bool            // Flag if the lock is held exclusively
+	waiters   []*lockWait     // Waiting requests in the order of their arrival
+}
+
+/*
+lockWait is a waiting lock request.
+*/
+type lockWait struct {
+	owner     string    // Owner which waits
+	lock      string    // Name of the requested lock
+	exclusive bool      // Flag if the lock was requested exclusively
+	since     time.Time // Time when the request was made
+}
+
+/*
+NewLockManager creates a new LockManager object.
+*/
+func NewLockManager() *LockManager {
+	lm := &LockManager{make(map[string]*managedLock), make(map[string]*lockWait), 0, &sync.Mutex{}, nil}
+	lm.cond = sync.NewCond(lm.mutex)
+	return lm
+}
+
+/*
+Lock acquires a lock for an owner. Waits until the lock can be granted. A
+lock which is already held by the owner is granted immediately - a shared
+lock is upgraded if an exclusive lock is requested. Returns an util.ErrDeadlock
+error if waiting for the lock would result in a deadlock.
+*/
+func (lm *LockManager) Lock(owner string, name string, exclusive bool) error {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	l, ok := lm.locks[name]
+	if !ok {
+		l = &managedLock{make(map[string]bool), false, nil}
+		lm.locks[name] = l
+	}
+
+	if l.canGrant(owner, exclusive, nil) {
+		l.grant(owner, exclusive)
+		return nil
+	}
+
+	w := &lockWait{owner, name, exclusive, time.Now()}
+
+	l.waiters = append(l.waiters, w)
+	lm.waits[owner] = w
+
+	if cycle := lm.findCycle(owner); cycle != nil {
+		lm.removeWait(l, w)
+		lm.deadlocks++
+
+		return &util.GraphError{
+			Type: util.ErrDeadlock,
+			Detail: fmt.Sprintf("%v cannot acquire lock %v - wait cycle: %v",
+				owner, name, cycle),
+		}
+	}
+
+	for !l.canGrant(owner, exclusive, w) {
+		lm.cond.Wait()
+	}
+
+	lm.removeWait(l, w)
+	l.grant(owner, exclusive)
+
+	// Other waiters might be able to share the lock
+
+	lm.cond.Broadcast()
+
+	return nil
+}
+
+/*
+Unlock releases a lock of an owner.
+*/
+func (lm *LockManager) Unlock(owner string, name string) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	lm.release(owner, name)
+	lm.cond.Broadcast()
+}
+
+/*
+UnlockAll releases all locks of an owner.
+*/
+func (lm *LockManager) UnlockAll(owner string) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	for name, l := range lm.locks {
+		if _, ok := l.holders[owner]; ok {
+			lm.release(owner, name)
+		}
+	}
+
+	lm.cond.Broadcast()
+}
+
+/*
+release releases a lock of an owner. Assumes that the mutex is held.
+*/
+func (lm *LockManager) release(owner string, name string) {
+	if l, ok := lm.locks[name]; ok {
+		delete(l.holders, owner)
+
+		if len(l.holders) == 0 {
+			l.exclusive = false
+
+			if len(l.waiters) == 0 {
+				delete(lm.locks, name)
+			}
+		}
+	}
+}
+
+/*
+removeWait removes a waiting request. Assumes that the mutex is held.
+*/
+func (lm *LockManager) removeWait(l *managedLock, w *lockWait) {
+	for i, lw := range l.waiters {
+		if lw == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+
+	delete(lm.waits, w.owner)
+
+	if len(l.holders) == 0 && len(l.waiters) == 0 {
+		delete(lm.locks, w.lock)
+	}
+}
+
+/*
+blockers returns all owners which block a given waiting request - holders of
+the lock and owners of earlier requests which are not compatible with the
+request. Assumes that the mutex is held.
+*/
+func (lm *LockManager) blockers(w *lockWait) []string {
+	var ret []string
+
+	l := lm.locks[w.lock]
+	_, upgrade := l.holders[w.owner]
+
+	if upgrade || l.exclusive || w.exclusive {
+		for holder := range l.holders {
+			if holder != w.owner {
+				ret = append(ret, holder)
+			}
+		}
+	}
+
+	if !upgrade {
+		for _, lw := range l.waiters {
+			if lw == w {
+				break
+			} else if lw.exclusive || w.exclusive {
+				ret = append(ret, lw.owner)
+			}
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+findCycle checks if the waiting request of an owner closes a wait cycle.
+Returns the owners of the cycle or nil if there is no cycle. Assumes that
+the mutex is held.
+*/
+func (lm *LockManager) findCycle(owner string) []string {
+	visited := make(map[string]bool)
+
+	var search func(current string, path []string) []string
+
+	search = func(current string, path []string) []string {
+		w, ok := lm.waits[current]
+		if !ok {
+			return nil
+		}
+
+		for _, blocker := range lm.blockers(w) {
+
+			if blocker == owner {
+				return append(path, owner)
+			}
+
+			if !visited[blocker] {
+				visited[blocker] = true
+
+				if cycle := search(blocker, append(path, blocker)); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return search(owner, []string{owner})
+}
+
+/*
+canGrant checks if a lock can be granted to an owner. Requests are granted in
+the order of their arrival - a waiting request is only granted if it is the
+first compatible request. Assumes that the mutex is held.
+*/
+func (l *managedLock) canGrant(owner string, exclusive bool, w *lockWait) bool {
+
+	if _, ok := l.holders[owner]; ok {
+
+		// The owner holds the lock already
+
+		return l.exclusive || !exclusive || len(l.holders) == 1
+	}
+
+	for _, lw := range l.waiters {
+		if lw == w {
+			break
+		} else if lw.exclusive || exclusive {
+			return false
+		}
+	}
+
+	return len(l.holders) == 0 || (!l.exclusive && !exclusive)
+}
+
+/*
+grant grants a lock to an owner. Assumes that the mutex is held.
+*/
+func (l *managedLock) grant(owner string, exclusive bool) {
+	l.holders[owner] = true
+	l.exclusive = l.exclusive || exclusive
+}
+
+/*
+LockStatus is the state of a lock of a lock manager.
+*/
+type LockStatus struct {
+	Name      string        `json:"name"`      // Name of the lock
+	Holders   []string      `json:"holders"`   // Owners which hold the lock
+	Exclusive bool          `json:"exclusive"` // Flag if the lock is held exclusively
+	Waiters   []*WaitStatus `json:"waiters"`   // Waiting requests in the order of their arrival
+}
+
+/*
+WaitStatus is a waiting lock request.
+*/
+type WaitStatus struct {
+	Owner     string   `json:"owner"`     // Owner which waits
+	Exclusive bool     `json:"exclusive"` // Flag if the lock was requested exclusively
+	Since     int64    `json:"since"`     // Time when the request was made (Unix seconds)
+	WaitsFor  []string `json:"waits_for"` // Owners which block the request
+}
+
+/*
+Status returns the state of all locks which are held or waited for sorted by
+name.
+*/
+func (lm *LockManager) Status() []*LockStatus {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	ret := make([]*LockStatus, 0, len(lm.locks))
+
+	for name, l := range lm.locks {
+		ls := &LockStatus{name, make([]string, 0, len(l.holders)), l.exclusive,
+			make([]*WaitStatus, 0, len(l.waiters))}
+
+		for holder := range l.holders {
+			ls.Holders = append(ls.Holders, holder)
+		}
+
+		sort.Strings(ls.Holders)
+
+		for _, w := range l.waiters {
+			ls.Waiters = append(ls.Waiters, &WaitStatus{w.owner, w.exclusive,
+				w.since.Unix(), lm.blockers(w)})
+		}
+
+		ret = append(ret, ls)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+Deadlocks returns the number of detected deadlocks.
+*/
+func (lm *LockManager) Deadlocks() uint64 {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	return lm.deadlocks
+}
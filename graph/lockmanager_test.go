@@ -0,0 +1,204 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+waitForWaiters waits until a lock has a given number of waiting requests.
+*/
+func waitForWaiters(lm *LockManager, name string, n int) bool {
+	for i := 0; i < 200; i++ {
+		for _, ls := range lm.Status() {
+			if ls.Name == name && len(ls.Waiters) == n {
+				return true
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestLockManager(t *testing.T) {
+	lm := NewLockManager()
+
+	// Shared locks can be held by several owners - re-entrance is allowed
+
+	lm.Lock("t1", "a", false)
+	lm.Lock("t2", "a", false)
+	lm.Lock("t2", "a", false)
+
+	if res := fmt.Sprint(lm.Status()[0]); res != "&{a [t1 t2] false []}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Exclusive requests wait for all holders
+
+	done := make(chan string, 10)
+
+	go func() {
+		lm.Lock("t3", "a", true)
+		done <- "t3"
+	}()
+
+	if !waitForWaiters(lm, "a", 1) {
+		t.Error("Request should wait:", lm.Status())
+		return
+	}
+
+	// Later shared requests wait behind the exclusive request
+
+	go func() {
+		lm.Lock("t4", "a", false)
+		done <- "t4"
+	}()
+
+	if !waitForWaiters(lm, "a", 2) {
+		t.Error("Request should wait:", lm.Status())
+		return
+	}
+
+	out, _ := json.Marshal(lm.Status())
+
+	var status []map[string]interface{}
+	json.Unmarshal(out, &status)
+
+	waiters := status[0]["waiters"].([]interface{})
+
+	if len(status) != 1 || fmt.Sprint(status[0]["holders"]) != "[t1 t2]" ||
+		waiters[0].(map[string]interface{})["owner"] != "t3" ||
+		fmt.Sprint(waiters[0].(map[string]interface{})["waits_for"]) != "[t1 t2]" ||
+		fmt.Sprint(waiters[1].(map[string]interface{})["waits_for"]) != "[t3]" ||
+		waiters[1].(map[string]interface{})["since"].(float64) < float64(time.Now().Unix()-60) {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	lm.Unlock("t1", "a")
+	lm.UnlockAll("t2")
+
+	if res := <-done; res != "t3" {
+		t.Error("Unexpected grant order:", res)
+		return
+	}
+
+	lm.Unlock("t3", "a")
+
+	if res := <-done; res != "t4" {
+		t.Error("Unexpected grant order:", res)
+		return
+	}
+
+	lm.Unlock("t4", "a")
+
+	if len(lm.Status()) != 0 {
+		t.Error("Unexpected result:", lm.Status())
+		return
+	}
+
+	// A single shared holder can upgrade its lock
+
+	lm.Lock("t1", "b", false)
+
+	if err := lm.Lock("t1", "b", true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(lm.Status()[0]); res != "&{b [t1] true []}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	lm.UnlockAll("t1")
+
+	// Detect a wait cycle between two owners
+
+	lm.Lock("t1", "x", true)
+	lm.Lock("t2", "y", true)
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- lm.Lock("t1", "y", true)
+	}()
+
+	if !waitForWaiters(lm, "y", 1) {
+		t.Error("Request should wait:", lm.Status())
+		return
+	}
+
+	err := lm.Lock("t2", "x", true)
+
+	if gerr, ok := err.(*util.GraphError); !ok || gerr.Type != util.ErrDeadlock ||
+		err.Error() != "GraphError: Deadlock (t2 cannot acquire lock x - wait cycle: [t2 t1 t2])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if lm.Deadlocks() != 1 {
+		t.Error("Unexpected result:", lm.Deadlocks())
+		return
+	}
+
+	// The aborted owner releases its locks so the other owner can continue
+
+	lm.UnlockAll("t2")
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	lm.UnlockAll("t1")
+
+	// Detect a cycle of shared holders which want to upgrade
+
+	lm.Lock("t1", "c", false)
+	lm.Lock("t2", "c", false)
+
+	go func() {
+		errs <- lm.Lock("t1", "c", true)
+	}()
+
+	if !waitForWaiters(lm, "c", 1) {
+		t.Error("Request should wait:", lm.Status())
+		return
+	}
+
+	if err := lm.Lock("t2", "c", true); err == nil || err.Error() !=
+		"GraphError: Deadlock (t2 cannot acquire lock c - wait cycle: [t2 t1 t2])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	lm.UnlockAll("t2")
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	lm.UnlockAll("t1")
+
+	if len(lm.Status()) != 0 || lm.Deadlocks() != 2 {
+		t.Error("Unexpected result:", lm.Status(), lm.Deadlocks())
+		return
+	}
+}
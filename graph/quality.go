@@ -0,0 +1,271 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+QualityMaxExamples is the maximum number of examples (e.g. duplicate groups or
+orphaned edges) which are listed in a quality report.
+*/
+var QualityMaxExamples = 10
+
+/*
+QualityReport is a data quality report of a partition.
+*/
+type QualityReport struct {
+	Partition string                        `json:"partition"` // Partition which was scanned
+	Kinds     map[string]*KindQualityReport `json:"kinds"`     // Reports for each scanned kind
+}
+
+/*
+KindQualityReport is a data quality report of a single node kind.
+*/
+type KindQualityReport struct {
+	Count             uint64                       `json:"count"`              // Number of scanned nodes
+	Attrs             map[string]*AttrQualityStats `json:"attrs"`              // Statistics of each attribute
+	DuplicateGroups   int                          `json:"duplicate_groups"`   // Number of groups of duplicate candidates
+	Duplicates        [][]string                   `json:"duplicates"`         // Examples of duplicate candidate groups
+	IsolatedNodes     uint64                       `json:"isolated_nodes"`     // Number of nodes without edges
+	Edges             uint64                       `json:"edges"`              // Number of checked edges
+	OrphanedEdges     uint64                       `json:"orphaned_edges"`     // Number of edges with a missing end
+	OrphanRate        float64                      `json:"orphan_rate"`        // Rate of orphaned edges
+	OrphanedEdgeKeys  []string                     `json:"orphaned_edge_keys"` // Examples of orphaned edges
+	duplicateKeys     map[string][]string          // Node keys for each attribute fingerprint
+	orphanedEdgeCheck map[string]bool              // Edges which have been checked
+}
+
+/*
+AttrQualityStats are the statistics of a single attribute of a node kind.
+*/
+type AttrQualityStats struct {
+	Count          uint64            `json:"count"`           // Number of nodes with this attribute
+	FillRate       float64           `json:"fill_rate"`       // Rate of nodes with this attribute
+	Types          map[string]uint64 `json:"types"`           // Number of values of each type
+	NumericStrings uint64            `json:"numeric_strings"` // Number of numbers stored as strings
+	Inconsistent   bool              `json:"inconsistent"`    // Flag if values have different types
+}
+
+/*
+DataQualityReport scans the given node kinds of a partition (all kinds if no kinds
+are given) and reports attribute fill rates, type inconsistencies, duplicate
+candidate nodes and orphaned edges. Nodes are duplicate candidates if all their
+attributes except the key are equal.
+*/
+func DataQualityReport(part string, kinds []string, gm *Manager) (*QualityReport, error) {
+	var err error
+
+	if len(kinds) == 0 {
+		kinds = gm.NodeKinds()
+	}
+
+	report := &QualityReport{part, make(map[string]*KindQualityReport)}
+
+	for _, kind := range kinds {
+		var kr *KindQualityReport
+
+		if kr, err = kindQualityReport(part, kind, gm); err != nil {
+			break
+		}
+
+		report.Kinds[kind] = kr
+	}
+
+	return report, err
+}
+
+/*
+kindQualityReport creates a data quality report for a single node kind.
+*/
+func kindQualityReport(part string, kind string, gm *Manager) (*KindQualityReport, error) {
+
+	kr := &KindQualityReport{0, make(map[string]*AttrQualityStats), 0, [][]string{}, 0, 0, 0, 0,
+		[]string{}, make(map[string][]string), make(map[string]bool)}
+
+	it, err := gm.NodeKeyIterator(part, kind)
+
+	for err == nil && it != nil && it.HasNext() {
+		var node data.Node
+
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			if node, err = gm.FetchNode(part, key, kind); err == nil && node != nil {
+				kr.Count++
+				kr.addNode(node)
+				err = kr.checkEdges(part, node, gm)
+			}
+		}
+	}
+
+	if err == nil {
+		kr.finish()
+	}
+
+	return kr, err
+}
+
+/*
+addNode adds the attributes of a node to the report.
+*/
+func (kr *KindQualityReport) addNode(node data.Node) {
+	ndata := node.Data()
+	fpdata := make(map[string]interface{})
+
+	for attr, val := range ndata {
+		if attr == data.NodeKey || attr == data.NodeKind {
+			continue
+		}
+
+		stats, ok := kr.Attrs[attr]
+		if !ok {
+			stats = &AttrQualityStats{0, 0, make(map[string]uint64), 0, false}
+			kr.Attrs[attr] = stats
+		}
+
+		stats.Count++
+		stats.Types[qualityValueType(val)]++
+
+		if s, ok := val.(string); ok {
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				stats.NumericStrings++
+			}
+		}
+
+		fpdata[attr] = val
+	}
+
+	// JSON encoding sorts map keys so equal attribute maps have the same fingerprint
+
+	if len(fpdata) > 0 {
+		if fp, err := json.Marshal(fpdata); err == nil {
+			kr.duplicateKeys[string(fp)] = append(kr.duplicateKeys[string(fp)], node.Key())
+		}
+	}
+}
+
+/*
+checkEdges checks that all edges of a node have an existing end node.
+*/
+func (kr *KindQualityReport) checkEdges(part string, node data.Node, gm *Manager) error {
+
+	_, edges, err := gm.TraverseMulti(part, node.Key(), node.Kind(), ":::", false)
+
+	if err == nil && len(edges) == 0 {
+		kr.IsolatedNodes++
+	}
+
+	for _, edge := range edges {
+
+		if err != nil || kr.orphanedEdgeCheck[edge.Kind()+"#"+edge.Key()] {
+			continue
+		}
+
+		kr.orphanedEdgeCheck[edge.Kind()+"#"+edge.Key()] = true
+		kr.Edges++
+
+		for _, end := range [][2]string{{edge.End1Key(), edge.End1Kind()}, {edge.End2Key(), edge.End2Kind()}} {
+			var endNode data.Node
+
+			if gm.isRemote(part, end[0], end[1]) {
+				continue
+			}
+
+			if endNode, err = gm.FetchNodePart(part, end[0], end[1], []string{data.NodeKey}); err == nil && endNode == nil {
+				kr.OrphanedEdges++
+
+				if len(kr.OrphanedEdgeKeys) < QualityMaxExamples {
+					kr.OrphanedEdgeKeys = append(kr.OrphanedEdgeKeys, fmt.Sprintf("%v (%v)", edge.Key(), edge.Kind()))
+				}
+
+				break
+			}
+		}
+	}
+
+	return err
+}
+
+/*
+finish calculates rates and collects duplicate candidates.
+*/
+func (kr *KindQualityReport) finish() {
+
+	for _, stats := range kr.Attrs {
+		stats.FillRate = float64(stats.Count) / float64(kr.Count)
+
+		// Integer and float values can be mixed
+
+		numbers := stats.Types["integer"] + stats.Types["float"]
+		stats.Inconsistent = len(stats.Types) > 1 && !(len(stats.Types) == 2 && numbers == stats.Count)
+	}
+
+	if kr.Edges > 0 {
+		kr.OrphanRate = float64(kr.OrphanedEdges) / float64(kr.Edges)
+	}
+
+	for _, keys := range kr.duplicateKeys {
+		if len(keys) > 1 {
+			sort.Strings(keys)
+			kr.Duplicates = append(kr.Duplicates, keys)
+		}
+	}
+
+	sort.Slice(kr.Duplicates, func(i, j int) bool {
+		return kr.Duplicates[i][0] < kr.Duplicates[j][0]
+	})
+
+	kr.DuplicateGroups = len(kr.Duplicates)
+
+	if len(kr.Duplicates) > QualityMaxExamples {
+		kr.Duplicates = kr.Duplicates[:QualityMaxExamples]
+	}
+}
+
+/*
+qualityValueType returns the type name of an attribute value.
+*/
+func qualityValueType(val interface{}) string {
+
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	case float32:
+		return qualityValueType(float64(v))
+	case float64:
+
+		// JSON decoded numbers are always floats
+
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "float"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", val)
+}
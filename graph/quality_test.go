@@ -0,0 +1,159 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestDataQualityReport(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	storeNode := func(kind string, key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeEdge := func(key string, end1 string, end2 string) {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "Wrote")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "Author")
+		edge.SetAttr(data.EdgeEnd1Role, "Author")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "Song")
+		edge.SetAttr(data.EdgeEnd2Role, "Song")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("Author", "a1", map[string]interface{}{"name": "John", "age": 42})
+	storeNode("Author", "a2", map[string]interface{}{"name": "John", "age": 42})
+	storeNode("Author", "a3", map[string]interface{}{"name": "Mike", "age": "35"})
+	storeNode("Author", "a4", map[string]interface{}{"age": 1.5})
+	storeNode("Song", "s1", map[string]interface{}{"name": "Aria"})
+	storeNode("Song", "s2", map[string]interface{}{"name": "Bolero"})
+
+	storeEdge("e1", "a1", "s1")
+	storeEdge("e2", "a3", "s2")
+
+	// Remove the attributes of a song directly from the storage to
+	// simulate a missing edge end
+
+	attrTree, _, _ := gm.getNodeStorageHTree("main", "Song", false)
+	attrTree.Remove([]byte(PrefixNSAttrs + "s2"))
+
+	report, err := DataQualityReport("main", []string{"Author"}, gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, _ := json.MarshalIndent(report, "", "  ")
+
+	if string(res) != `
+{
+  "partition": "main",
+  "kinds": {
+    "Author": {
+      "count": 4,
+      "attrs": {
+        "age": {
+          "count": 4,
+          "fill_rate": 1,
+          "types": {
+            "float": 1,
+            "integer": 2,
+            "string": 1
+          },
+          "numeric_strings": 1,
+          "inconsistent": true
+        },
+        "name": {
+          "count": 3,
+          "fill_rate": 0.75,
+          "types": {
+            "string": 3
+          },
+          "numeric_strings": 0,
+          "inconsistent": false
+        }
+      },
+      "duplicate_groups": 1,
+      "duplicates": [
+        [
+          "a1",
+          "a2"
+        ]
+      ],
+      "isolated_nodes": 2,
+      "edges": 2,
+      "orphaned_edges": 1,
+      "orphan_rate": 0.5,
+      "orphaned_edge_keys": [
+        "e2 (Wrote)"
+      ]
+    }
+  }
+}`[1:] {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	// Check that all kinds are scanned if no kinds are given
+
+	report, err = DataQualityReport("main", nil, gm)
+
+	if err != nil || len(report.Kinds) != 2 || report.Kinds["Song"].Count != 1 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if res := qualityValueType(float32(1)); res != "integer" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := qualityValueType([]interface{}{}); res != "list" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := qualityValueType(map[string]interface{}{}); res != "object" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := qualityValueType(nil); res != "null" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := qualityValueType(struct{}{}); res != "struct {}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
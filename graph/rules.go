@@ -50,6 +50,21 @@ type Rule interface {
 	Handle(gm *Manager, trans Trans, event int, data ...interface{}) error
 }
 
+/*
+PartitionRule is a graph rule which only reads and writes the partition of the
+events which it handles. Write operations on a single partition only lock the
+partition if all rules are partition rules - otherwise the whole graph is
+locked.
+*/
+type PartitionRule interface {
+	Rule
+
+	/*
+		PartitionOnly returns if the rule only works on the partition of an event.
+	*/
+	PartitionOnly() bool
+}
+
 /*
 graphEvent main event handler which receives all graph related events.
 */
@@ -100,10 +115,26 @@ func (gr *graphRulesManager) graphEvent(trans Trans, event int, data ...interfac
 }
 
 /*
-Clone a given graph manager and insert a new RWMutex.
+Clone a given graph manager and insert a new RWMutex and new partition locks.
+The main database lock, the lock manager, the transaction limits, the key
+generation and the remote nodes are shared.
 */
 func (gr *graphRulesManager) cloneGraphManager() *Manager {
-	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, &sync.RWMutex{}, &sync.Mutex{}}
+	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, &sync.RWMutex{}, newPartitionLocks(),
+		gr.gm.lockManager, gr.gm.transLimits, gr.gm.keyGen, gr.gm.remote, &sync.Mutex{}, gr.gm.mainMutex,
+		gr.gm.mvcc}
+}
+
+/*
+partitionOnly checks if all rules only work on the partition of an event.
+*/
+func (gr *graphRulesManager) partitionOnly() bool {
+	for _, rule := range gr.rules {
+		if prule, ok := rule.(PartitionRule); !ok || !prule.PartitionOnly() {
+			return false
+		}
+	}
+	return true
 }
 
 /*
@@ -163,6 +194,13 @@ func (r *SystemRuleDeleteNodeEdges) Handles() []int {
 	return []int{EventNodeDeleted}
 }
 
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+*/
+func (r *SystemRuleDeleteNodeEdges) PartitionOnly() bool {
+	return true
+}
+
 /*
 Handle handles an event.
 */
@@ -268,6 +306,14 @@ func (r *SystemRuleUpdateNodeStats) Handles() []int {
 		EventEdgeCreated, EventEdgeUpdated}
 }
 
+/*
+PartitionOnly returns if the rule only works on the partition of an event. The
+rule changes only the main database which has its own lock.
+*/
+func (r *SystemRuleUpdateNodeStats) PartitionOnly() bool {
+	return true
+}
+
 /*
 Handle handles an event.
 */
@@ -278,15 +324,7 @@ func (r *SystemRuleUpdateNodeStats) Handle(gm *Manager, trans Trans, event int,
 		edge := ed[1].(data.Edge)
 
 		updateNodeRels := func(key string, kind string) {
-			spec := edge.Spec(key)
-			specs := gm.getMainDBMap(MainDBNodeEdges + kind)
-
-			if specs != nil {
-				if _, ok := specs[spec]; !ok {
-					specs[spec] = ""
-					gm.storeMainDBMap(MainDBNodeEdges+kind, specs)
-				}
-			}
+			gm.addMainDBMapKeys(MainDBNodeEdges+kind, edge.Spec(key))
 		}
 
 		// Update stored relationships for both ends
@@ -294,6 +332,11 @@ func (r *SystemRuleUpdateNodeStats) Handle(gm *Manager, trans Trans, event int,
 		updateNodeRels(edge.End1Key(), edge.End1Kind())
 		updateNodeRels(edge.End2Key(), edge.End2Kind())
 
+		// Old roles which are used again are no longer aliases of renamed roles
+
+		gm.removeEdgeRoleAlias(edge.Kind(), edge.End1Role())
+		gm.removeEdgeRoleAlias(edge.Kind(), edge.End2Role())
+
 		attrMap = MainDBEdgeAttrs
 	}
 
@@ -305,44 +348,24 @@ func (r *SystemRuleUpdateNodeStats) Handle(gm *Manager, trans Trans, event int,
 	if event == EventNodeCreated || event == EventEdgeCreated {
 		part := ed[0].(string)
 
-		updateMainDB := func(entry string, val string) {
-			vals := gm.getMainDBMap(entry)
-			if _, ok := vals[val]; !ok {
-				vals[val] = ""
-				gm.storeMainDBMap(entry, vals)
-			}
-		}
-
-		updateMainDB(MainDBParts, part)
+		gm.addMainDBMapKeys(MainDBParts, part)
 
 		if event == EventNodeCreated {
-			updateMainDB(MainDBNodeKinds, kind)
+			gm.addMainDBMapKeys(MainDBNodeKinds, kind)
 		} else {
-			updateMainDB(MainDBEdgeKinds, kind)
+			gm.addMainDBMapKeys(MainDBEdgeKinds, kind)
 		}
 	}
 
-	storeAttrs := false
-
-	attrs := gm.getMainDBMap(attrMap + kind)
-
-	if attrs != nil {
+	// Update stored node attributes
 
-		// Update stored node attributes
+	attrs := make([]string, 0, len(node.Data()))
 
-		for attr := range node.Data() {
-			if _, ok := attrs[attr]; !ok {
-				attrs[attr] = ""
-				storeAttrs = true
-			}
-		}
-
-		// Store attribute map if something was changed
-
-		if storeAttrs {
-			gm.storeMainDBMap(attrMap+kind, attrs)
-		}
+	for attr := range node.Data() {
+		attrs = append(attrs, attr)
 	}
 
+	gm.addMainDBMapKeys(attrMap+kind, attrs...)
+
 	return nil
 }
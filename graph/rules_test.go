@@ -448,7 +448,7 @@ func TestRulesErrors(t *testing.T) {
 	// Check that the test rule was added
 
 	if rules := fmt.Sprint(gm.GraphRules()); rules !=
-		"[system.deletenodeedges system.updatenodestats testrule]" {
+		"[system.deletenodeblobs system.deletenodeedges system.updatenodestats testrule]" {
 		t.Error("unexpected graph rule list:", rules)
 		return
 	}
@@ -736,7 +736,7 @@ func TestRulesErrorsPreChange(t *testing.T) {
 	// Check that the test rule was added
 
 	if rules := fmt.Sprint(gm.GraphRules()); rules !=
-		"[system.deletenodeedges system.updatenodestats testrule]" {
+		"[system.deletenodeblobs system.deletenodeedges system.updatenodestats testrule]" {
 		t.Error("unexpected graph rule list:", rules)
 		return
 	}
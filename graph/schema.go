@@ -0,0 +1,194 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+SchemaSampleSize is the default number of nodes which are scanned to infer
+the schema of a node kind.
+*/
+var SchemaSampleSize = 1000
+
+/*
+JSONSchemaVersion is the JSON Schema version of generated schema documents.
+*/
+const JSONSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+/*
+KindSchema infers a JSON Schema document for a node kind. The attribute types
+are inferred from up to sampleSize stored nodes of all partitions (all nodes if
+sampleSize is 0). Attributes which are present on all scanned nodes are
+required. Returns nil if the node kind is unknown.
+*/
+func KindSchema(kind string, sampleSize int, gm *Manager) (map[string]interface{}, error) {
+	var err error
+
+	attrs := gm.NodeAttrs(kind)
+
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+
+	count := 0
+	types := make(map[string]map[string]interface{})
+	present := make(map[string]int)
+
+	for _, part := range gm.Partitions() {
+		var it *NodeKeyIterator
+
+		if it, err = gm.NodeKeyIterator(part, kind); err != nil {
+			break
+		}
+
+		for it != nil && it.HasNext() && (sampleSize == 0 || count < sampleSize) {
+			var node data.Node
+
+			key := it.Next()
+
+			if err = it.LastError; err != nil {
+				break
+			}
+
+			if node, err = gm.FetchNode(part, key, kind); err != nil {
+				break
+			} else if node == nil {
+				continue
+			}
+
+			count++
+
+			for attr, val := range node.Data() {
+				t := schemaValueType(val)
+				s, _ := json.Marshal(t)
+
+				if _, ok := types[attr]; !ok {
+					types[attr] = make(map[string]interface{})
+				}
+
+				types[attr][string(s)] = t
+				present[attr]++
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	properties := make(map[string]interface{})
+	required := []string{data.NodeKey, data.NodeKind}
+
+	for _, attr := range attrs {
+		if attr == data.NodeKey || attr == data.NodeKind {
+			continue
+		}
+
+		properties[attr] = mergeSchemaTypes(types[attr])
+
+		if count > 0 && present[attr] == count {
+			required = append(required, attr)
+		}
+	}
+
+	properties[data.NodeKey] = map[string]interface{}{"type": "string"}
+	properties[data.NodeKind] = map[string]interface{}{"const": kind}
+
+	sort.Strings(required)
+
+	return map[string]interface{}{
+		"$schema":    JSONSchemaVersion,
+		"title":      kind,
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, err
+}
+
+/*
+mergeSchemaTypes merges the observed types of an attribute into a single
+schema. Integers and floats are merged into numbers.
+*/
+func mergeSchemaTypes(types map[string]interface{}) interface{} {
+
+	if _, ok := types[`{"type":"number"}`]; ok {
+		delete(types, `{"type":"integer"}`)
+	}
+
+	keys := make([]string, 0, len(types))
+	for k := range types {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) == 0 {
+		return map[string]interface{}{}
+	} else if len(keys) == 1 {
+		return types[keys[0]]
+	}
+
+	anyOf := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		anyOf = append(anyOf, types[k])
+	}
+
+	return map[string]interface{}{"anyOf": anyOf}
+}
+
+/*
+schemaValueType returns the JSON Schema of an attribute value.
+*/
+func schemaValueType(val interface{}) map[string]interface{} {
+
+	switch val.(type) {
+	case time.Time:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case *data.Decimal:
+		return map[string]interface{}{"type": "number"}
+	case []byte:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	case *data.GeoPoint:
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"lat": map[string]interface{}{"type": "number"},
+				"lon": map[string]interface{}{"type": "number"},
+			},
+			"required": []string{"lat", "lon"},
+		}
+	case *data.BlobRef:
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"size":         map[string]interface{}{"type": "integer"},
+				"content_type": map[string]interface{}{"type": "string"},
+			},
+		}
+	}
+
+	switch t := qualityValueType(val); t {
+	case "null", "boolean", "integer", "string", "object":
+		return map[string]interface{}{"type": t}
+	case "float":
+		return map[string]interface{}{"type": "number"}
+	case "list":
+		return map[string]interface{}{"type": "array"}
+	}
+
+	return map[string]interface{}{}
+}
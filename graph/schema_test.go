@@ -0,0 +1,142 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestKindSchema(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	storeNode := func(part string, key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Author")
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode(part, node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("main", "a1", map[string]interface{}{"name": "John", "age": 42,
+		"born": time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), "tags": []interface{}{"a"}})
+	storeNode("main", "a2", map[string]interface{}{"name": "Mike", "age": 1.5,
+		"loc": &data.GeoPoint{Lat: 1, Lon: 2}})
+	storeNode("other", "a3", map[string]interface{}{"name": 5, "age": 3})
+
+	if res, err := KindSchema("foo", 0, gm); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	res, err := KindSchema("Author", 0, gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, _ := json.MarshalIndent(res, "", "  ")
+
+	if string(out) != `
+{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "properties": {
+    "age": {
+      "type": "number"
+    },
+    "born": {
+      "format": "date-time",
+      "type": "string"
+    },
+    "key": {
+      "type": "string"
+    },
+    "kind": {
+      "const": "Author"
+    },
+    "loc": {
+      "properties": {
+        "lat": {
+          "type": "number"
+        },
+        "lon": {
+          "type": "number"
+        }
+      },
+      "required": [
+        "lat",
+        "lon"
+      ],
+      "type": "object"
+    },
+    "name": {
+      "anyOf": [
+        {
+          "type": "integer"
+        },
+        {
+          "type": "string"
+        }
+      ]
+    },
+    "tags": {
+      "type": "array"
+    }
+  },
+  "required": [
+    "age",
+    "key",
+    "kind",
+    "name"
+  ],
+  "title": "Author",
+  "type": "object"
+}`[1:] {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	// Only the first node is scanned - unseen attributes can have any type
+
+	res, _ = KindSchema("Author", 1, gm)
+	out, _ = json.Marshal(res["properties"].(map[string]interface{})["loc"])
+
+	if string(out) != "{}" || len(res["required"].([]string)) != 6 {
+		t.Error("Unexpected result:", string(out), res["required"])
+		return
+	}
+
+	for _, test := range []struct {
+		val interface{}
+		res string
+	}{
+		{nil, `{"type":"null"}`},
+		{true, `{"type":"boolean"}`},
+		{&data.Decimal{}, `{"type":"number"}`},
+		{[]byte("a"), `{"contentEncoding":"base64","type":"string"}`},
+		{map[string]interface{}{}, `{"type":"object"}`},
+		{&data.BlobRef{}, `{"properties":{"content_type":{"type":"string"},"size":{"type":"integer"}},"type":"object"}`},
+		{struct{}{}, `{}`},
+	} {
+		if out, _ := json.Marshal(schemaValueType(test.val)); string(out) != test.res {
+			t.Error("Unexpected result:", test.val, string(out))
+			return
+		}
+	}
+}
@@ -0,0 +1,392 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+RemoteNodes decides which nodes of a sharded graph are stored by other graph
+managers (shards).
+*/
+type RemoteNodes interface {
+
+	/*
+		IsRemote returns if a node is stored by another shard.
+	*/
+	IsRemote(part string, key string, kind string) bool
+}
+
+/*
+RemoteFetcher is implemented by RemoteNodes objects which can fetch the data
+of remote nodes. Traversals which retrieve all data of the connected nodes use
+it to fetch nodes of other shards.
+*/
+type RemoteFetcher interface {
+	RemoteNodes
+
+	/*
+		Location returns the location (e.g. the shard name) of a remote node.
+		Nodes of the same location and kind are fetched together.
+	*/
+	Location(part string, key string, kind string) string
+
+	/*
+		FetchNodes fetches nodes of a single kind from a location. Nodes which
+		do not exist are not part of the result.
+	*/
+	FetchNodes(ctx context.Context, part string, location string, kind string,
+		keys []string) ([]data.Node, error)
+}
+
+/*
+RemoteFetchBatchSize is the maximum number of nodes which are fetched with a
+single remote call.
+*/
+var RemoteFetchBatchSize = 50
+
+/*
+RemoteFetchWorkers is the maximum number of remote calls which a traversal
+issues in parallel.
+*/
+var RemoteFetchWorkers = 4
+
+/*
+remoteNodes holds the remote nodes of a graph manager.
+*/
+type remoteNodes struct {
+	value atomic.Value // Current remote nodes (remoteNodesValue)
+}
+
+/*
+remoteNodesValue wraps a RemoteNodes object so it can be stored in an
+atomic value.
+*/
+type remoteNodesValue struct {
+	rn RemoteNodes
+}
+
+/*
+SetRemoteNodes sets the nodes which are stored by other shards (nil if all
+nodes are local). An edge to a remote node can be stored if its other end is
+a local node - only the local end of the edge is updated. Traversals which
+retrieve all data fetch remote nodes if rn is a RemoteFetcher - otherwise
+remote nodes are returned with their key and kind only.
+*/
+func (gm *Manager) SetRemoteNodes(rn RemoteNodes) {
+	gm.remote.value.Store(remoteNodesValue{rn})
+}
+
+/*
+RemoteNodes returns the nodes which are stored by other shards (nil if all
+nodes are local).
+*/
+func (gm *Manager) RemoteNodes() RemoteNodes {
+	if v, ok := gm.remote.value.Load().(remoteNodesValue); ok {
+		return v.rn
+	}
+	return nil
+}
+
+/*
+isRemote checks if a node is stored by another shard.
+*/
+func (gm *Manager) isRemote(part string, key string, kind string) bool {
+	rn := gm.RemoteNodes()
+	return rn != nil && rn.IsRemote(part, key, kind)
+}
+
+/*
+remoteNode returns a node of another shard with its key and kind.
+*/
+func remoteNode(key string, kind string) data.Node {
+	node := data.NewGraphNode()
+
+	node.SetAttr(data.NodeKey, key)
+	node.SetAttr(data.NodeKind, kind)
+
+	return node
+}
+
+/*
+getEdgeEndHTrees gets the HTrees which store the edge information of the ends
+of an edge. The HTree of a remote end is nil. If check is set then the local
+ends must exist and at least one end must be local.
+*/
+func (gm *Manager) getEdgeEndHTrees(part string, edge data.Edge,
+	check bool) (*hash.HTree, *hash.HTree, error) {
+
+	var trees [2]*hash.HTree
+
+	ends := [2][2]string{{edge.End1Key(), edge.End1Kind()}, {edge.End2Key(), edge.End2Kind()}}
+
+	for i, end := range ends {
+
+		if gm.isRemote(part, end[0], end[1]) {
+			continue
+		}
+
+		nodeht, ht, err := gm.getNodeStorageHTree(part, end[1], false)
+
+		if err != nil {
+			return nil, nil, err
+		} else if !check {
+			trees[i] = ht
+			continue
+		} else if ht == nil {
+			return nil, nil, &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: "Can't store edge to non-existing node kind: " + end[1],
+			}
+		} else if node, err := nodeht.Get([]byte(PrefixNSAttrs + end[0])); err != nil || node == nil {
+			return nil, nil, &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", end[0], end[1]),
+			}
+		}
+
+		trees[i] = ht
+	}
+
+	if check && trees[0] == nil && trees[1] == nil {
+		return nil, nil, &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Can't store edge between remote nodes: %s", edge.Key()),
+		}
+	}
+
+	return trees[0], trees[1], nil
+}
+
+/*
+remoteCallBudget counts the remote calls of a query.
+*/
+type remoteCallBudget struct {
+	max   int64 // Maximum number of remote calls (0 for no limit)
+	calls int64 // Number of remote calls so far
+}
+
+/*
+remoteCallBudgetKey is the context key of the remote call budget.
+*/
+type remoteCallBudgetKey struct{}
+
+/*
+WithRemoteCallBudget returns a context which limits the remote calls of all
+traversals which use it to max calls (0 for no limit). Traversals stop with an
+ErrRemoteCallLimit error once the budget is used up. The calls are counted
+even if there is no limit (see RemoteCalls).
+*/
+func WithRemoteCallBudget(ctx context.Context, max int) context.Context {
+	return context.WithValue(ctx, remoteCallBudgetKey{}, &remoteCallBudget{int64(max), 0})
+}
+
+/*
+RemoteCalls returns the number of remote calls which were made with a context
+of WithRemoteCallBudget.
+*/
+func RemoteCalls(ctx context.Context) int {
+	if budget, ok := ctx.Value(remoteCallBudgetKey{}).(*remoteCallBudget); ok {
+		return int(atomic.LoadInt64(&budget.calls))
+	}
+	return 0
+}
+
+/*
+useRemoteCall takes a remote call from the budget of a context.
+*/
+func useRemoteCall(ctx context.Context) error {
+	budget, ok := ctx.Value(remoteCallBudgetKey{}).(*remoteCallBudget)
+	if !ok {
+		return nil
+	}
+
+	if calls := atomic.AddInt64(&budget.calls, 1); budget.max > 0 && calls > budget.max {
+		atomic.AddInt64(&budget.calls, -1)
+		atomic.AddUint64(&remoteStats.BudgetExceeded, 1)
+
+		return &util.GraphError{
+			Type:   util.ErrRemoteCallLimit,
+			Detail: fmt.Sprintf("Traversal needs more than %v remote calls", budget.max),
+		}
+	}
+
+	return nil
+}
+
+/*
+RemoteFetchStats are the statistics of the remote fetches of all graph
+managers since startup.
+*/
+type RemoteFetchStats struct {
+	Calls          uint64 `json:"calls"`           // Number of remote calls
+	Nodes          uint64 `json:"nodes"`           // Number of fetched nodes
+	Errors         uint64 `json:"errors"`          // Number of failed remote calls
+	BudgetExceeded uint64 `json:"budget_exceeded"` // Number of traversals which exceeded their budget
+}
+
+/*
+remoteStats holds the statistics of all remote fetches.
+*/
+var remoteStats = &RemoteFetchStats{}
+
+/*
+RemoteFetchStatistics returns the statistics of the remote fetches.
+*/
+func RemoteFetchStatistics() *RemoteFetchStats {
+	return &RemoteFetchStats{
+		atomic.LoadUint64(&remoteStats.Calls),
+		atomic.LoadUint64(&remoteStats.Nodes),
+		atomic.LoadUint64(&remoteStats.Errors),
+		atomic.LoadUint64(&remoteStats.BudgetExceeded),
+	}
+}
+
+/*
+remoteBatch is a batch of remote nodes which are fetched with a single call.
+*/
+type remoteBatch struct {
+	location string // Location of the nodes
+	kind     string // Kind of the nodes
+	indices  []int  // Indices of the nodes in the node list
+}
+
+/*
+FetchRemoteNodes replaces the remote nodes of a given list with nodes which
+contain all data. Nodes of other shards are fetched in parallel batches. Each
+batch is a remote call which is taken from the budget of the given context
+(see WithRemoteCallBudget). Remote nodes which no longer exist keep their key
+and kind only. Nothing is fetched if the remote nodes of this graph manager
+cannot fetch nodes.
+*/
+func (gm *Manager) FetchRemoteNodes(ctx context.Context, part string, nodes []data.Node) error {
+	rf, ok := gm.RemoteNodes().(RemoteFetcher)
+	if !ok {
+		return nil
+	}
+
+	// Group the remote nodes by location and kind
+
+	var batches []*remoteBatch
+
+	groups := make(map[[2]string]*remoteBatch)
+
+	for i, node := range nodes {
+		key, kind := node.Key(), node.Kind()
+
+		if !rf.IsRemote(part, key, kind) {
+			continue
+		}
+
+		group := [2]string{rf.Location(part, key, kind), kind}
+
+		batch, ok := groups[group]
+		if !ok || len(batch.indices) == RemoteFetchBatchSize {
+			batch = &remoteBatch{group[0], kind, nil}
+			batches = append(batches, batch)
+			groups[group] = batch
+		}
+
+		batch.indices = append(batch.indices, i)
+	}
+
+	if len(batches) == 0 {
+		return nil
+	}
+
+	// Fetch the batches with a limited number of workers - each batch only
+	// writes the nodes of its own indices
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var fetchErr error
+
+	sem := make(chan struct{}, RemoteFetchWorkers)
+
+	for _, batch := range batches {
+
+		if err := ctx.Err(); err != nil {
+			errOnce.Do(func() { fetchErr = err })
+			break
+		} else if err := useRemoteCall(ctx); err != nil {
+			errOnce.Do(func() { fetchErr = err })
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(batch *remoteBatch) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := gm.fetchRemoteBatch(ctx, rf, part, batch, nodes); err != nil {
+				errOnce.Do(func() { fetchErr = err })
+			}
+		}(batch)
+	}
+
+	wg.Wait()
+
+	return fetchErr
+}
+
+/*
+fetchRemoteBatch fetches a batch of remote nodes and stores them in a given
+node list.
+*/
+func (gm *Manager) fetchRemoteBatch(ctx context.Context, rf RemoteFetcher, part string,
+	batch *remoteBatch, nodes []data.Node) error {
+
+	keys := make([]string, len(batch.indices))
+
+	for i, index := range batch.indices {
+		keys[i] = nodes[index].Key()
+	}
+
+	atomic.AddUint64(&remoteStats.Calls, 1)
+
+	fetched, err := rf.FetchNodes(ctx, part, batch.location, batch.kind, keys)
+	if err != nil {
+		atomic.AddUint64(&remoteStats.Errors, 1)
+
+		return &util.GraphError{
+			Type:   util.ErrRemoteFetch,
+			Detail: fmt.Sprintf("%v (%v nodes of kind %v)", err, len(keys), batch.kind),
+		}
+	}
+
+	atomic.AddUint64(&remoteStats.Nodes, uint64(len(fetched)))
+
+	byKey := make(map[string]data.Node, len(fetched))
+
+	for _, node := range fetched {
+		byKey[node.Key()] = node
+	}
+
+	for _, index := range batch.indices {
+		if node, ok := byKey[nodes[index].Key()]; ok {
+			nodes[index] = node
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+/*
+testRemoteNodes marks all nodes with a key starting with "r" as remote.
+*/
+type testRemoteNodes struct {
+}
+
+func (rn *testRemoteNodes) IsRemote(part string, key string, kind string) bool {
+	return strings.HasPrefix(key, "r")
+}
+
+func TestRemoteNodes(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	newEdge := func(key string, end1 string, end2 string) data.Edge {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "link")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+		edge.SetAttr(data.EdgeEnd1Role, "from")
+		edge.SetAttr(data.EdgeEnd1Cascading, true)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+		edge.SetAttr(data.EdgeEnd2Role, "to")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		return edge
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "a")
+	node.SetAttr(data.NodeKind, "mykind")
+	node.SetAttr("name", "A")
+	gm.StoreNode("main", node)
+
+	// Edges need existing ends if all nodes are local
+
+	if gm.RemoteNodes() != nil {
+		t.Error("Unexpected result:", gm.RemoteNodes())
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e1", "a", "r1")); err == nil ||
+		err.Error() != "GraphError: Invalid data (Can't find edge endpoint: r1 (mykind))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gm.SetRemoteNodes(&testRemoteNodes{})
+
+	// Only the local end of an edge to a remote node is stored
+
+	if err := gm.StoreEdge("main", newEdge("e1", "a", "r1")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	edge := newEdge("e2", "r2", "a")
+	edge.SetAttr(data.EdgeTimestamp, time.Unix(1000, 0))
+
+	trans := NewGraphTrans(gm)
+	trans.StoreEdge("main", edge)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e3", "r1", "r2")); err == nil ||
+		err.Error() != "GraphError: Invalid data (Can't store edge between remote nodes: e3)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e3", "a", "b")); err == nil ||
+		err.Error() != "GraphError: Invalid data (Can't find edge endpoint: b (mykind))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Remote nodes are returned with their key and kind
+
+	nodes, edges, err := gm.TraverseMulti("main", "a", "mykind", ":::", true)
+	if err != nil || len(nodes) != 2 || len(edges) != 2 {
+		t.Error("Unexpected result:", nodes, edges, err)
+		return
+	}
+
+	for _, n := range nodes {
+		if !strings.HasPrefix(n.Key(), "r") || n.Kind() != "mykind" || len(n.Data()) != 2 {
+			t.Error("Unexpected node:", n)
+			return
+		}
+	}
+
+	if nodes, _, err := gm.Traverse("main", "r1", "mykind", "to:link:from:mykind", true); err != nil || nodes != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	nodes, _, err = gm.TraverseTimeRange("main", "a", "mykind", ":link::", time.Unix(0, 0), time.Unix(2000, 0), true)
+	if err != nil || fmt.Sprint(nodes) != `[GraphNode:
+     key : r2
+    kind : mykind
+]` {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if err := gm.RebuildTimelines("main", "link", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if nodes, _, _ := gm.TraverseTimeRange("main", "a", "mykind", ":link::", time.Unix(0, 0), time.Unix(2000, 0), false); len(nodes) != 1 {
+		t.Error("Unexpected result:", nodes)
+		return
+	}
+
+	// Edges to remote nodes are consistent
+
+	if report, err := gm.CheckConsistency(nil); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Removing edges only updates the local end
+
+	if _, err := gm.RemoveEdge("main", "e1", "link"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveEdge("main", "e2", "link")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if specs, err := gm.FetchNodeEdgeSpecs("main", "a", "mykind"); err != nil || len(specs) != 0 {
+		t.Error("Unexpected result:", specs, err)
+		return
+	}
+
+	if report, err := gm.CheckConsistency(nil); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Deleting a node removes its edges to remote nodes
+
+	gm.StoreEdge("main", newEdge("e1", "a", "r1"))
+
+	if _, err := gm.RemoveNode("main", "a", "mykind"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if e, err := gm.FetchEdge("main", "e1", "link"); err != nil || e != nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	gm.SetRemoteNodes(nil)
+
+	if gm.RemoteNodes() != nil {
+		t.Error("Unexpected result:", gm.RemoteNodes())
+		return
+	}
+}
+
+/*
+testRemoteFetcher fetches remote nodes from locations which are derived from the
+last digit of the node key.
+*/
+type testRemoteFetcher struct {
+	testRemoteNodes
+	calls []string
+	fail  bool
+	lock  sync.Mutex
+}
+
+func (rf *testRemoteFetcher) Location(part string, key string, kind string) string {
+	if key[len(key)-1]%2 == 0 {
+		return "even"
+	}
+	return "odd"
+}
+
+func (rf *testRemoteFetcher) FetchNodes(ctx context.Context, part string, location string, kind string,
+	keys []string) ([]data.Node, error) {
+
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	rf.calls = append(rf.calls, fmt.Sprint(location, " ", kind, " ", keys))
+
+	if rf.fail {
+		return nil, errors.New("Location unreachable")
+	}
+
+	var nodes []data.Node
+
+	for _, key := range keys {
+		if key != "r4" {
+			node := remoteNode(key, kind)
+			node.SetAttr("name", "Remote "+key)
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+func (rf *testRemoteFetcher) takeCalls() string {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	sort.Strings(rf.calls)
+	res := strings.Join(rf.calls, "\n")
+	rf.calls = nil
+
+	return res
+}
+
+func TestRemoteFetch(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	rf := &testRemoteFetcher{}
+	gm.SetRemoteNodes(rf)
+
+	oldBatchSize := RemoteFetchBatchSize
+	RemoteFetchBatchSize = 2
+	defer func() {
+		RemoteFetchBatchSize = oldBatchSize
+	}()
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "a")
+	node.SetAttr(data.NodeKind, "mykind")
+	gm.StoreNode("main", node)
+
+	for i := 1; i <= 5; i++ {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, fmt.Sprint("e", i))
+		edge.SetAttr(data.NodeKind, "link")
+		edge.SetAttr(data.EdgeEnd1Key, "a")
+		edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+		edge.SetAttr(data.EdgeEnd1Role, "from")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, fmt.Sprint("r", i))
+		edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+		edge.SetAttr(data.EdgeEnd2Role, "to")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		edge.SetAttr(data.EdgeTimestamp, time.Unix(int64(i), 0))
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	stats := RemoteFetchStatistics()
+
+	// Remote nodes are only fetched if all data is requested
+
+	if nodes, _, err := gm.TraverseMulti("main", "a", "mykind", ":::", false); err != nil ||
+		len(nodes) != 5 || rf.takeCalls() != "" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Remote nodes are fetched in batches of the same location and kind
+
+	nodes, _, err := gm.TraverseMulti("main", "a", "mykind", ":::", true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	names := make([]string, 0, len(nodes))
+
+	for _, n := range nodes {
+		names = append(names, fmt.Sprint(n.Key(), "=", n.Attr("name")))
+	}
+
+	sort.Strings(names)
+
+	if res := strings.Join(names, " "); res != "r1=Remote r1 r2=Remote r2 r3=Remote r3 r4=<nil> r5=Remote r5" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	calls := rf.takeCalls()
+
+	if strings.Count(calls, "\n") != 2 || !strings.HasPrefix(calls, "even mykind [r") {
+		t.Error("Unexpected calls:", calls)
+		return
+	}
+
+	if res := RemoteFetchStatistics(); res.Calls != stats.Calls+3 || res.Nodes != stats.Nodes+4 {
+		t.Error("Unexpected statistics:", res, stats)
+		return
+	}
+
+	nodes, _, err = gm.TraverseTimeRange("main", "a", "mykind", ":link::", time.Unix(0, 0), time.Unix(2, 0), true)
+	if err != nil || len(nodes) != 1 || nodes[0].Attr("name") != "Remote r1" ||
+		rf.takeCalls() != "odd mykind [r1]" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	// Remote calls are counted against the budget of a query
+
+	ctx := WithRemoteCallBudget(context.Background(), 0)
+
+	if _, _, err := gm.TraverseMultiContext(ctx, "main", "a", "mykind", ":::", true); err != nil || RemoteCalls(ctx) != 3 {
+		t.Error("Unexpected result:", RemoteCalls(ctx), err)
+		return
+	}
+
+	if _, _, err := gm.TraverseMultiContext(ctx, "main", "a", "mykind", "from:link:to:mykind", true); err != nil || RemoteCalls(ctx) != 6 {
+		t.Error("Unexpected result:", RemoteCalls(ctx), err)
+		return
+	}
+
+	ctx = WithRemoteCallBudget(context.Background(), 2)
+
+	if _, _, err := gm.TraverseMultiContext(ctx, "main", "a", "mykind", ":::", true); err == nil ||
+		err.Error() != "GraphError: Remote call budget exceeded (Traversal needs more than 2 remote calls)" ||
+		RemoteCalls(ctx) != 2 {
+		t.Error("Unexpected result:", RemoteCalls(ctx), err)
+		return
+	}
+
+	if res := RemoteFetchStatistics(); res.BudgetExceeded != stats.BudgetExceeded+1 {
+		t.Error("Unexpected statistics:", res, stats)
+		return
+	}
+
+	if RemoteCalls(context.Background()) != 0 {
+		t.Error("Unexpected result:", RemoteCalls(context.Background()))
+		return
+	}
+
+	rf.takeCalls()
+
+	// Failed remote calls are reported
+
+	rf.fail = true
+
+	if _, _, err := gm.TraverseMulti("main", "a", "mykind", ":::", true); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Could not fetch remote nodes (Location unreachable (") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := RemoteFetchStatistics(); res.Errors == stats.Errors {
+		t.Error("Unexpected statistics:", res, stats)
+		return
+	}
+}
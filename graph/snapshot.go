@@ -0,0 +1,301 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sync"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Snapshot returns a read-only GraphManager which operates on a consistent
+snapshot of the current data. Readers of the snapshot neither see changes
+which are committed after the snapshot was taken nor do they wait for running
+writers. The snapshot must be released with Release() once it is no longer
+needed - writers keep old versions of changed data for every open snapshot.
+*/
+func (gm *Manager) Snapshot() (*Manager, error) {
+
+	if gm.mvcc == nil {
+		return nil, &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Cannot take a snapshot of a snapshot"}
+	}
+
+	// Take the writer lock so no write operation is in progress
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.mainMutex.Lock()
+	sgs := gm.mvcc.snapshot(gm.gs)
+	gm.mainMutex.Unlock()
+
+	mainMutex := &sync.Mutex{}
+
+	sgm := &Manager{sgs, &graphRulesManager{nil, make(map[string]Rule),
+		make(map[int]map[string]Rule)}, util.NewSharedNamesManager(sgs.mainDB, mainMutex),
+		make(map[string]map[string]string), &sync.RWMutex{}, newPartitionLocks(),
+		NewLockManager(), &transLimits{}, &keyGeneration{}, gm.remote, &sync.Mutex{}, mainMutex, nil}
+
+	sgm.gr.gm = sgm
+
+	return sgm, nil
+}
+
+/*
+IsSnapshot returns if this GraphManager operates on a snapshot.
+*/
+func (gm *Manager) IsSnapshot() bool {
+	_, ok := gm.gs.(*snapshotGraphStorage)
+	return ok
+}
+
+/*
+Release releases a snapshot. Further reads on a released snapshot fail. Calling
+this function on a GraphManager which does not operate on a snapshot has no
+effect.
+*/
+func (gm *Manager) Release() {
+	if sgs, ok := gm.gs.(*snapshotGraphStorage); ok {
+		sgs.Close()
+	}
+}
+
+/*
+mvccRegistry wraps all storage managers of a graph storage so snapshots can be
+taken. It keeps track of all open snapshots.
+*/
+type mvccRegistry struct {
+	managers  map[string]*storage.MVCCStorageManager // Wrapped storage managers
+	snapshots map[*snapshotGraphStorage]bool         // Open snapshots
+	mutex     *sync.Mutex                            // Mutex to protect map operations
+}
+
+/*
+newMVCCRegistry creates a new mvccRegistry object.
+*/
+func newMVCCRegistry() *mvccRegistry {
+	return &mvccRegistry{make(map[string]*storage.MVCCStorageManager),
+		make(map[*snapshotGraphStorage]bool), &sync.Mutex{}}
+}
+
+/*
+storageManager returns the wrapped storage manager of a partition.
+*/
+func (r *mvccRegistry) storageManager(gs graphstorage.Storage, part string,
+	name string, create bool) storage.Manager {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if m := r.manager(gs, part, name, create); m != nil {
+		return m
+	}
+
+	return nil
+}
+
+/*
+manager returns the wrapped storage manager of a partition. Assumes that the
+registry mutex is held.
+*/
+func (r *mvccRegistry) manager(gs graphstorage.Storage, part string,
+	name string, create bool) *storage.MVCCStorageManager {
+
+	if m, ok := r.managers[part+name]; ok {
+		return m
+	}
+
+	isNew := false
+
+	sm := rawStorageManager(gs, part, name, false)
+
+	if sm == nil && create {
+		sm = rawStorageManager(gs, part, name, true)
+		isNew = true
+	}
+
+	if sm == nil {
+		return nil
+	}
+
+	m := storage.NewMVCCStorageManager(sm)
+	r.managers[part+name] = m
+
+	// Open snapshots did not see storage managers which were created after
+	// they were taken
+
+	for s := range r.snapshots {
+		if _, ok := s.sms[part+name]; ok {
+			continue
+		}
+
+		if isNew {
+			s.sms[part+name] = nil
+		} else {
+			s.sms[part+name] = m.Snapshot()
+		}
+	}
+
+	return m
+}
+
+/*
+snapshot creates a new snapshot of the current data. Assumes that no write
+operations are in progress.
+*/
+func (r *mvccRegistry) snapshot(gs graphstorage.Storage) *snapshotGraphStorage {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	mainDB := make(map[string]string)
+
+	for k, v := range gs.MainDB() {
+		mainDB[k] = v
+	}
+
+	sgs := &snapshotGraphStorage{gs, r, mainDB,
+		make(map[string]*storage.SnapshotStorageManager), false}
+
+	for name, m := range r.managers {
+		sgs.sms[name] = m.Snapshot()
+	}
+
+	r.snapshots[sgs] = true
+
+	return sgs
+}
+
+/*
+snapshotGraphStorage is a read-only graph storage which provides the data of
+a snapshot.
+*/
+type snapshotGraphStorage struct {
+	gs       graphstorage.Storage                       // Graph storage of the live data
+	registry *mvccRegistry                              // Registry which created this snapshot
+	mainDB   map[string]string                          // Copy of the main database
+	sms      map[string]*storage.SnapshotStorageManager // Snapshots of storage managers
+	released bool                                       // Flag if the snapshot was released
+}
+
+/*
+Name returns the name of the graph storage.
+*/
+func (sgs *snapshotGraphStorage) Name() string {
+	return sgs.gs.Name()
+}
+
+/*
+MainDB returns the copy of the main database.
+*/
+func (sgs *snapshotGraphStorage) MainDB() map[string]string {
+	return sgs.mainDB
+}
+
+/*
+RollbackMain has no effect on a snapshot.
+*/
+func (sgs *snapshotGraphStorage) RollbackMain() error {
+	return nil
+}
+
+/*
+FlushMain has no effect on a snapshot. Changes to the main database copy are
+not persisted.
+*/
+func (sgs *snapshotGraphStorage) FlushMain() error {
+	return nil
+}
+
+/*
+FlushAll has no effect on a snapshot.
+*/
+func (sgs *snapshotGraphStorage) FlushAll() error {
+	return nil
+}
+
+/*
+StorageManager gets a storage manager with a certain name. Snapshots only
+support storage managers of partitions.
+*/
+func (sgs *snapshotGraphStorage) StorageManager(smname string, create bool) storage.Manager {
+	return nil
+}
+
+/*
+PartitionStorageManager gets the snapshot of a storage manager which belongs
+to a given partition. Returns nil if the storage manager did not exist when
+the snapshot was taken.
+*/
+func (sgs *snapshotGraphStorage) PartitionStorageManager(part string, name string, create bool) storage.Manager {
+	sgs.registry.mutex.Lock()
+	defer sgs.registry.mutex.Unlock()
+
+	if sgs.released {
+		return nil
+	}
+
+	s, ok := sgs.sms[part+name]
+
+	if !ok {
+
+		// The storage manager was not used since the graph manager was
+		// created - wrapping it creates a snapshot for all open snapshots
+
+		if sgs.registry.manager(sgs.gs, part, name, false) == nil {
+			return nil
+		}
+
+		s = sgs.sms[part+name]
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	return s
+}
+
+/*
+Close releases the snapshot.
+*/
+func (sgs *snapshotGraphStorage) Close() error {
+	sgs.registry.mutex.Lock()
+	defer sgs.registry.mutex.Unlock()
+
+	if !sgs.released {
+		for _, s := range sgs.sms {
+			if s != nil {
+				s.Close()
+			}
+		}
+
+		delete(sgs.registry.snapshots, sgs)
+		sgs.released = true
+	}
+
+	return nil
+}
+
+/*
+rawStorageManager gets a storage manager of a given partition from a graph
+storage. Storages which keep track of partitions learn the partition of the
+storage manager.
+*/
+func rawStorageManager(gs graphstorage.Storage, part string, name string, create bool) storage.Manager {
+	if ps, ok := gs.(graphstorage.PartitionStorage); ok {
+		return ps.PartitionStorageManager(part, name, create)
+	}
+	return gs.StorageManager(part+name, create)
+}
@@ -0,0 +1,267 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSnapshot(t *testing.T) {
+	testSnapshot(t, graphstorage.NewMemoryGraphStorage("test"))
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir12, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	testSnapshot(t, dgs)
+}
+
+func testSnapshot(t *testing.T, gs graphstorage.Storage) {
+	gm := NewGraphManager(gs)
+
+	newNode := func(key string, kind string, name string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		node.SetAttr("name", name)
+		return node
+	}
+
+	newEdge := func(key string, end1 string, end2 string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "myedge")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd1Role, "node1")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+		edge.SetAttr(data.EdgeEnd2Role, "node2")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := gm.StoreNode("main", newNode(fmt.Sprint(i), "mynode", fmt.Sprint("old", i))); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e1", "1", "2")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sgm, err := gm.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !sgm.IsSnapshot() || gm.IsSnapshot() {
+		t.Error("Unexpected snapshot flags")
+		return
+	}
+
+	if _, err := sgm.Snapshot(); err == nil || err.Error() !=
+		"GraphError: Failed to access graph storage component (Cannot take a snapshot of a snapshot)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Change the live data
+
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			if _, err := gm.RemoveNode("main", fmt.Sprint(i), "mynode"); err != nil {
+				t.Error(err)
+				return
+			}
+		} else if err := gm.UpdateNode("main", newNode(fmt.Sprint(i), "mynode", fmt.Sprint("new", i))); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for i := 100; i < 150; i++ {
+		if err := gm.StoreNode("main", newNode(fmt.Sprint(i), "mynode", fmt.Sprint("new", i))); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := gm.StoreNode("main", newNode("x", "othernode", "x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Iterating the snapshot returns exactly the old data
+
+	it, err := sgm.NodeKeyIterator("main", "mynode")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var keys []string
+
+	for it.HasNext() {
+		key := it.Next()
+
+		node, err := sgm.FetchNode("main", key, "mynode")
+		if err != nil || node.Attr("name") != "old"+key {
+			t.Error("Unexpected result:", node, err)
+			return
+		}
+
+		keys = append(keys, key)
+	}
+
+	if it.LastError != nil || len(keys) != 100 || sgm.NodeCount("mynode") != 100 {
+		t.Error("Unexpected result:", len(keys), it.LastError, sgm.NodeCount("mynode"))
+		return
+	}
+
+	if res := sgm.NodeKinds(); fmt.Sprint(res) != "[mynode]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if node, err := sgm.FetchNode("main", "x", "othernode"); node != nil || err != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if nodes, _, err := sgm.TraverseMulti("main", "1", "mynode", ":::", true); err != nil ||
+		len(nodes) != 1 || nodes[0].Attr("name") != "old2" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if q, err := sgm.NodeIndexQuery("main", "mynode"); err != nil {
+		t.Error(err)
+		return
+	} else if res, err := q.LookupPhrase("name", "old4"); fmt.Sprint(res) != "[4]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The live data has changed
+
+	it, _ = gm.NodeKeyIterator("main", "mynode")
+	keys = nil
+
+	for it.HasNext() {
+		keys = append(keys, it.Next())
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) != 100 || gm.NodeCount("mynode") != 100 || keys[0] != "1" {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if node, err := gm.FetchNode("main", "1", "mynode"); err != nil || node.Attr("name") != "new1" {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Readers of a snapshot do not wait for writers
+
+	done := make(chan error)
+
+	go func() {
+		for i := 1; i < 100; i += 2 {
+			if err := gm.UpdateNode("main", newNode(fmt.Sprint(i), "mynode", "concurrent")); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	it, _ = sgm.NodeKeyIterator("main", "mynode")
+	keys = nil
+
+	for it.HasNext() {
+		key := it.Next()
+
+		if node, err := sgm.FetchNode("main", key, "mynode"); err != nil || node.Attr("name") != "old"+key {
+			t.Error("Unexpected result:", node, err)
+			return
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := <-done; err != nil || len(keys) != 100 {
+		t.Error("Unexpected result:", len(keys), err)
+		return
+	}
+
+	// Snapshots cannot be changed
+
+	if err := sgm.StoreNode("main", newNode("y", "mynode", "y")); err == nil {
+		t.Error("Writing to a snapshot should fail")
+		return
+	}
+
+	// A second snapshot sees the new data
+
+	sgm2, _ := gm.Snapshot()
+
+	if node, err := sgm2.FetchNode("main", "1", "mynode"); err != nil || node.Attr("name") != "concurrent" {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	sgm.Release()
+	sgm.Release()
+	sgm2.Release()
+	gm.Release()
+
+	if node, err := sgm.FetchNode("main", "1", "mynode"); node != nil || err != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// The live data can still be changed after all snapshots were released
+
+	if _, err := gm.RemoveNode("main", "1", "mynode"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if gm.NodeCount("mynode") != 99 {
+		t.Error("Unexpected result:", gm.NodeCount("mynode"))
+		return
+	}
+
+	if report, err := gm.CheckConsistency(nil); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+StatisticsMostCommonValues is the maximum number of most common values which
+are kept in the statistics of an attribute.
+*/
+var StatisticsMostCommonValues = 10
+
+/*
+StatisticsHistogramBuckets is the maximum number of buckets of the histogram of
+the numeric values of an attribute.
+*/
+var StatisticsHistogramBuckets = 10
+
+/*
+KindStatistics are the statistics of a node kind in a partition. Statistics are
+collected by Analyze and can be used to estimate how many nodes satisfy a
+condition.
+*/
+type KindStatistics struct {
+	Partition string                     `json:"partition"` // Partition of the nodes
+	Kind      string                     `json:"kind"`      // Kind of the nodes
+	Count     uint64                     `json:"count"`     // Number of nodes
+	Analyzed  int64                      `json:"analyzed"`  // Time of the analysis (Unix time in seconds)
+	Attrs     map[string]*AttrStatistics `json:"attrs"`     // Statistics of each attribute
+}
+
+/*
+AttrStatistics are the statistics of a single attribute of a node kind.
+*/
+type AttrStatistics struct {
+	Count      uint64             `json:"count"`       // Number of nodes with this attribute
+	Distinct   uint64             `json:"distinct"`    // Number of distinct values
+	Strings    bool               `json:"strings"`     // Flag if all values are strings
+	MostCommon []*ValueCount      `json:"most_common"` // Most common values
+	Numeric    uint64             `json:"numeric"`     // Number of numeric values
+	Histogram  []*HistogramBucket `json:"histogram"`   // Equi-depth histogram of the numeric values
+	values     map[string]uint64  // Number of nodes with each value
+	numbers    []float64          // All numeric values
+}
+
+/*
+ValueCount is a value and the number of nodes which have it.
+*/
+type ValueCount struct {
+	Value string `json:"value"` // Value of the attribute
+	Count uint64 `json:"count"` // Number of nodes with the value
+}
+
+/*
+HistogramBucket is a bucket of a histogram of numeric values. All buckets of a
+histogram contain roughly the same number of values.
+*/
+type HistogramBucket struct {
+	Min   float64 `json:"min"`   // Smallest value in the bucket
+	Max   float64 `json:"max"`   // Largest value in the bucket
+	Count uint64  `json:"count"` // Number of values in the bucket
+}
+
+/*
+Analyze collects the statistics of the given node kinds of a partition (all
+kinds if no kinds are given) and stores them. Statistics are not updated when
+nodes change - a partition should be analyzed again after larger changes.
+*/
+func (gm *Manager) Analyze(part string, kinds []string) ([]*KindStatistics, error) {
+	var ret []*KindStatistics
+
+	if len(kinds) == 0 {
+		kinds = gm.NodeKinds()
+	}
+
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+
+		ks, err := gm.analyzeKind(part, kind)
+		if err != nil {
+			return nil, err
+		} else if ks == nil {
+			continue
+		}
+
+		res, err := json.Marshal(ks)
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+		}
+
+		gm.setMainDBValue(MainDBKindStats+part+"#"+kind, string(res), true)
+
+		ret = append(ret, ks)
+	}
+
+	return ret, gm.flushMain()
+}
+
+/*
+Statistics returns the stored statistics of a node kind in a partition. Returns
+nil if the kind was not analyzed.
+*/
+func (gm *Manager) Statistics(part string, kind string) *KindStatistics {
+	var ks *KindStatistics
+
+	if val, ok := gm.mainDBValue(MainDBKindStats + part + "#" + kind); ok {
+		if err := json.Unmarshal([]byte(val), &ks); err != nil {
+			return nil
+		}
+	}
+
+	return ks
+}
+
+/*
+analyzeKind collects the statistics of a single node kind. Returns nil if the
+partition has no nodes of the kind.
+*/
+func (gm *Manager) analyzeKind(part string, kind string) (*KindStatistics, error) {
+
+	it, err := gm.NodeKeyIterator(part, kind)
+	if err != nil || it == nil {
+		return nil, err
+	}
+
+	ks := &KindStatistics{part, kind, 0, time.Now().Unix(), make(map[string]*AttrStatistics)}
+
+	for it.HasNext() {
+		var node data.Node
+
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			node, err = gm.FetchNode(part, key, kind)
+		}
+
+		if err != nil {
+			return nil, err
+		} else if node != nil {
+			ks.Count++
+			ks.addNode(node)
+		}
+	}
+
+	for _, as := range ks.Attrs {
+		as.finish()
+	}
+
+	return ks, nil
+}
+
+/*
+addNode adds the attributes of a node to the statistics.
+*/
+func (ks *KindStatistics) addNode(node data.Node) {
+
+	for attr, val := range node.Data() {
+		if attr == data.NodeKey || attr == data.NodeKind {
+			continue
+		}
+
+		as, ok := ks.Attrs[attr]
+		if !ok {
+			as = &AttrStatistics{0, 0, true, nil, 0, nil, make(map[string]uint64), nil}
+			ks.Attrs[attr] = as
+		}
+
+		sval := fmt.Sprint(val)
+
+		as.Count++
+		as.values[sval]++
+
+		if _, ok := val.(string); !ok {
+			as.Strings = false
+		}
+
+		// Only finite numbers can be part of a histogram
+
+		if num, err := strconv.ParseFloat(sval, 64); err == nil && !math.IsInf(num, 0) && !math.IsNaN(num) {
+			as.Numeric++
+			as.numbers = append(as.numbers, num)
+		}
+	}
+}
+
+/*
+finish calculates the most common values and the histogram of an attribute.
+*/
+func (as *AttrStatistics) finish() {
+
+	as.Distinct = uint64(len(as.values))
+	as.MostCommon = make([]*ValueCount, 0, len(as.values))
+
+	for val, count := range as.values {
+		as.MostCommon = append(as.MostCommon, &ValueCount{val, count})
+	}
+
+	sort.Slice(as.MostCommon, func(i, j int) bool {
+		if as.MostCommon[i].Count != as.MostCommon[j].Count {
+			return as.MostCommon[i].Count > as.MostCommon[j].Count
+		}
+		return as.MostCommon[i].Value < as.MostCommon[j].Value
+	})
+
+	if len(as.MostCommon) > StatisticsMostCommonValues {
+		as.MostCommon = as.MostCommon[:StatisticsMostCommonValues]
+	}
+
+	// Each bucket of the histogram gets the same share of the sorted values
+
+	sort.Float64s(as.numbers)
+
+	as.Histogram = make([]*HistogramBucket, 0, StatisticsHistogramBuckets)
+
+	for i := 0; i < StatisticsHistogramBuckets; i++ {
+		start := i * len(as.numbers) / StatisticsHistogramBuckets
+		end := (i + 1) * len(as.numbers) / StatisticsHistogramBuckets
+
+		if start < end {
+			as.Histogram = append(as.Histogram,
+				&HistogramBucket{as.numbers[start], as.numbers[end-1], uint64(end - start)})
+		}
+	}
+
+	as.values = nil
+	as.numbers = nil
+}
+
+/*
+EqualSelectivity estimates the fraction of nodes whose attribute has a given
+value. Values which are not among the most common values are assumed to be
+equally distributed.
+*/
+func (ks *KindStatistics) EqualSelectivity(attr string, val string) float64 {
+
+	as, ok := ks.Attrs[attr]
+	if !ok || ks.Count == 0 {
+		return 0
+	}
+
+	rest := as.Count
+	restDistinct := as.Distinct
+
+	for _, vc := range as.MostCommon {
+		if vc.Value == val {
+			return float64(vc.Count) / float64(ks.Count)
+		}
+
+		rest -= vc.Count
+		restDistinct--
+	}
+
+	if restDistinct == 0 {
+		return 0
+	}
+
+	return float64(rest) / float64(restDistinct) / float64(ks.Count)
+}
+
+/*
+RangeSelectivity estimates the fraction of nodes whose attribute has a numeric
+value between lower and upper. The bounds can be infinite. Values are assumed
+to be equally distributed within each histogram bucket.
+*/
+func (ks *KindStatistics) RangeSelectivity(attr string, lower float64, upper float64) float64 {
+	var matches float64
+
+	as, ok := ks.Attrs[attr]
+	if !ok || ks.Count == 0 {
+		return 0
+	}
+
+	for _, b := range as.Histogram {
+
+		if b.Max < lower || b.Min > upper {
+			continue
+		} else if b.Min == b.Max {
+			matches += float64(b.Count)
+			continue
+		}
+
+		lo := math.Max(lower, b.Min)
+		hi := math.Min(upper, b.Max)
+
+		matches += float64(b.Count) * (hi - lo) / (b.Max - b.Min)
+	}
+
+	return matches / float64(ks.Count)
+}
@@ -0,0 +1,164 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestStatistics(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("test"))
+
+	storeNode := func(kind string, key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	StatisticsMostCommonValues = 2
+	StatisticsHistogramBuckets = 2
+	defer func() {
+		StatisticsMostCommonValues = 10
+		StatisticsHistogramBuckets = 10
+	}()
+
+	storeNode("Author", "a1", map[string]interface{}{"name": "John", "age": 20})
+	storeNode("Author", "a2", map[string]interface{}{"name": "John", "age": 30})
+	storeNode("Author", "a3", map[string]interface{}{"name": "Mike", "age": "40"})
+	storeNode("Author", "a4", map[string]interface{}{"name": "Anna", "age": 50})
+	storeNode("Author", "a5", map[string]interface{}{"name": "Bob", "age": "Inf"})
+	storeNode("Author", "a6", map[string]interface{}{"name": "Carl"})
+	storeNode("Song", "s1", map[string]interface{}{"name": "Aria"})
+
+	if ks := gm.Statistics("main", "Author"); ks != nil {
+		t.Error("Unexpected result:", ks)
+		return
+	}
+
+	stats, err := gm.Analyze("main", []string{"Song", "Author", "Lyrics"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Unknown kinds are skipped
+
+	if len(stats) != 2 || stats[0].Kind != "Author" || stats[1].Kind != "Song" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// Statistics are stored
+
+	ks := gm.Statistics("main", "Author")
+	ks.Analyzed = 0
+
+	res, _ := json.MarshalIndent(ks, "", "  ")
+
+	if string(res) != `
+{
+  "partition": "main",
+  "kind": "Author",
+  "count": 6,
+  "analyzed": 0,
+  "attrs": {
+    "age": {
+      "count": 5,
+      "distinct": 5,
+      "strings": false,
+      "most_common": [
+        {
+          "value": "20",
+          "count": 1
+        },
+        {
+          "value": "30",
+          "count": 1
+        }
+      ],
+      "numeric": 4,
+      "histogram": [
+        {
+          "min": 20,
+          "max": 30,
+          "count": 2
+        },
+        {
+          "min": 40,
+          "max": 50,
+          "count": 2
+        }
+      ]
+    },
+    "name": {
+      "count": 6,
+      "distinct": 5,
+      "strings": true,
+      "most_common": [
+        {
+          "value": "John",
+          "count": 2
+        },
+        {
+          "value": "Anna",
+          "count": 1
+        }
+      ],
+      "numeric": 0,
+      "histogram": []
+    }
+  }
+}`[1:] {
+		t.Error("Unexpected result:", string(res))
+		return
+	}
+
+	// Estimate the fraction of nodes which satisfy a condition
+
+	for _, test := range []struct {
+		sel      float64
+		expected string
+	}{
+		{ks.EqualSelectivity("name", "John"), "0.333"},
+		{ks.EqualSelectivity("name", "Carl"), "0.167"},
+		{ks.EqualSelectivity("age", "30"), "0.167"},
+		{ks.EqualSelectivity("title", "x"), "0.000"},
+		{ks.RangeSelectivity("age", math.Inf(-1), 25), "0.167"},
+		{ks.RangeSelectivity("age", 30, math.Inf(1)), "0.333"},
+		{ks.RangeSelectivity("age", 60, 70), "0.000"},
+		{ks.RangeSelectivity("name", 0, 1), "0.000"},
+	} {
+		if res := fmt.Sprintf("%.3f", test.sel); res != test.expected {
+			t.Error("Unexpected result:", res, "expected:", test.expected)
+			return
+		}
+	}
+
+	// Only analyzed kinds have statistics
+
+	stats, err = gm.Analyze("test", nil)
+	if err != nil || len(stats) != 0 || gm.Statistics("test", "Author") != nil {
+		t.Error("Unexpected result:", stats, err)
+		return
+	}
+}
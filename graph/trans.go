@@ -11,13 +11,17 @@
 package graph
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/krotik/common/errorutil"
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
 )
 
 /*
@@ -53,6 +57,13 @@ type Trans interface {
 	*/
 	Commit() error
 
+	/*
+	   CommitContext writes the transaction to the graph database like Commit. The commit
+	   is aborted and rolled back if the given context is done before all changes have
+	   been written.
+	*/
+	CommitContext(ctx context.Context) error
+
 	/*
 	   StoreNode stores a single node in a partition of the graph. This function will
 	   overwrites any existing node.
@@ -80,6 +91,20 @@ type Trans interface {
 	   RemoveEdge removes a single edge from a partition of the graph.
 	*/
 	RemoveEdge(part string, ekey string, ekind string) error
+
+	/*
+	   Savepoint marks the current state of the transaction and returns the
+	   ID of the savepoint. Savepoints are released when the transaction is
+	   committed or rolled back.
+	*/
+	Savepoint() int
+
+	/*
+	   RollbackTo discards all changes which were added to the transaction after
+	   a given savepoint. The savepoint can be used again - all savepoints which
+	   were created after it are released.
+	*/
+	RollbackTo(savepoint int) error
 }
 
 /*
@@ -98,6 +123,76 @@ func NewConcurrentGraphTrans(gm *Manager) Trans {
 	return &concurrentTrans{NewGraphTrans(gm), &sync.RWMutex{}}
 }
 
+/*
+OptimisticTrans is a transaction which detects concurrent modifications of the
+nodes and edges which it has read. The version of every node and edge which is
+fetched through the transaction is recorded. Commit fails with a
+util.ConflictError if any of them has been changed or removed (or was created
+if it did not exist) since it was fetched. Failed transactions are rolled back.
+*/
+type OptimisticTrans interface {
+	Trans
+
+	/*
+	   FetchNode fetches a single node from a partition of the graph and
+	   records its version.
+	*/
+	FetchNode(part string, key string, kind string) (data.Node, error)
+
+	/*
+	   FetchEdge fetches a single edge from a partition of the graph and
+	   records its version.
+	*/
+	FetchEdge(part string, key string, kind string) (data.Edge, error)
+}
+
+/*
+NewOptimisticGraphTrans creates a new graph transaction with optimistic
+conflict detection. This object is not thread safe.
+*/
+func NewOptimisticGraphTrans(gm *Manager) OptimisticTrans {
+	return &optimisticTrans{newInternalGraphTrans(gm), make(map[string]*readVersion)}
+}
+
+/*
+LockingTrans is a transaction which locks all nodes and edges which it
+accesses until it is committed or rolled back. Fetched nodes and edges are
+locked shared, changed nodes and edges are locked exclusively. Lock requests
+wait until conflicting locks of other transactions are released. A request
+which would cause a deadlock fails with an util.ErrDeadlock error - the
+transaction is then rolled back so the other transactions can continue.
+Locks are kept if the transaction is rolled back to a savepoint.
+*/
+type LockingTrans interface {
+	Trans
+
+	/*
+	   FetchNode locks a single node shared and fetches it from a partition
+	   of the graph.
+	*/
+	FetchNode(part string, key string, kind string) (data.Node, error)
+
+	/*
+	   FetchEdge locks a single edge shared and fetches it from a partition
+	   of the graph.
+	*/
+	FetchEdge(part string, key string, kind string) (data.Edge, error)
+
+	/*
+	   Rollback discards all changes of the transaction and releases its locks.
+	*/
+	Rollback()
+}
+
+/*
+NewLockingGraphTrans creates a new graph transaction which locks the nodes
+and edges it accesses through the lock manager of the graph manager. This
+object is not thread safe.
+*/
+func NewLockingGraphTrans(gm *Manager) LockingTrans {
+	return &lockingTrans{newInternalGraphTrans(gm)}
+}
+
 /*
 NewRollingTrans wraps an existing transaction into a rolling transaction.
 Rolling transactions can be used for VERY large datasets and will commit
@@ -134,6 +229,9 @@ func NewRollingTrans(t Trans, n int, gm *Manager, newTrans func(*Manager) Trans)
 		countEdgeIns: 0,
 		countEdgeRem: 0,
 
+		savepointCounter: 0,
+		savepoints:       make(map[int]*rollingSavepoint),
+
 		transLock: &sync.RWMutex{},
 	}
 }
@@ -149,7 +247,7 @@ func newInternalGraphTrans(gm *Manager) *baseTrans {
 	idCounter++
 
 	return &baseTrans{fmt.Sprint(idCounter), gm, false, make(map[string]data.Node), make(map[string]data.Node),
-		make(map[string]data.Edge), make(map[string]data.Edge)}
+		make(map[string]data.Edge), make(map[string]data.Edge), nil, nil, 0, nil, nil, 0}
 }
 
 /*
@@ -170,6 +268,48 @@ type baseTrans struct {
 	removeNodes map[string]data.Node // Nodes which should be removed
 	storeEdges  map[string]data.Edge // Edges which should be stored
 	removeEdges map[string]data.Edge // Edges which should be removed
+
+	ctx  context.Context // Context of the current commit
+	seqs []*seqRecord    // Commit sequence numbers which are recorded once the commit succeeded
+
+	savepointCounter int          // Counter for savepoint IDs
+	savepoints       []*savepoint // Current savepoints in the order of their creation
+	undoLog          []*transUndo // Previous entries of all changes since the first savepoint
+
+	size int64 // Estimated size of all nodes and edges of the transaction in bytes
+}
+
+/*
+savepoint is a marked state of a transaction.
+*/
+type savepoint struct {
+	id   int   // ID of the savepoint
+	pos  int   // Length of the undo log when the savepoint was created
+	size int64 // Estimated size of the transaction when the savepoint was created
+}
+
+/*
+transUndo is the state of a node or edge entry of a transaction before it was
+changed.
+*/
+type transUndo struct {
+	isEdge     bool      // Flag if the entry is an edge
+	key        string    // Transaction key of the node or edge
+	storeNode  data.Node // Previous node which should be stored
+	removeNode data.Node // Previous node which should be removed
+	storeEdge  data.Edge // Previous edge which should be stored
+	removeEdge data.Edge // Previous edge which should be removed
+}
+
+/*
+seqRecord is a commit sequence number of a node or edge which was written by a
+transaction.
+*/
+type seqRecord struct {
+	part    string // Partition of the node or edge
+	key     string // Key in the sequence database
+	seq     uint64 // Assigned sequence number
+	removed bool   // Flag if the node or edge was removed
 }
 
 /*
@@ -212,6 +352,34 @@ any non-fatal error occurs. Failed transactions cannot be committed again.
 Serious write errors which may corrupt the database will cause a panic.
 */
 func (gt *baseTrans) Commit() error {
+	return gt.CommitContext(context.Background())
+}
+
+/*
+CommitContext writes the transaction to the graph database like Commit. The commit
+is aborted and rolled back if the given context is done before all changes have
+been written.
+*/
+func (gt *baseTrans) CommitContext(ctx context.Context) error {
+	empty := gt.IsEmpty()
+
+	err := gt.commitContext(ctx)
+
+	// Wait for durability outside of the writer lock so concurrent commits
+	// can share a single disk sync
+
+	if err == nil && !empty && !gt.subtrans {
+		err = gt.gm.waitDurable()
+	}
+
+	return err
+}
+
+/*
+commitContext writes the transaction to the graph database without waiting for
+the changes to become durable.
+*/
+func (gt *baseTrans) commitContext(ctx context.Context) error {
 
 	// Take writer lock if we are not in a subtransaction
 
@@ -220,6 +388,20 @@ func (gt *baseTrans) Commit() error {
 		defer gt.gm.mutex.Unlock()
 	}
 
+	return gt.commitLocked(ctx)
+}
+
+/*
+commitLocked writes the transaction to the graph database. Assumes that the
+writer lock is held.
+*/
+func (gt *baseTrans) commitLocked(ctx context.Context) error {
+
+	// Savepoints do not survive the commit and all entries are consumed
+
+	gt.releaseSavepoints()
+	gt.size = 0
+
 	// Return if there is nothing to do
 
 	if gt.IsEmpty() {
@@ -231,7 +413,7 @@ func (gt *baseTrans) Commit() error {
 
 		// Rollback main database
 
-		gt.gm.gs.RollbackMain()
+		gt.gm.rollbackMain()
 
 		// Rollback node storages
 
@@ -258,8 +440,21 @@ func (gt *baseTrans) Commit() error {
 
 		gt.storeEdges = make(map[string]data.Edge)
 		gt.removeEdges = make(map[string]data.Edge)
+
+		// Give back the assigned commit sequence numbers
+
+		for i := len(gt.seqs) - 1; i >= 0; i-- {
+			gt.gm.resetSeq(gt.seqs[i].part, gt.seqs[i].seq-1)
+		}
+
+		gt.seqs = nil
 	}
 
+	gt.ctx = ctx
+	defer func() {
+		gt.ctx = nil
+	}()
+
 	// Write nodes and edges until everything has been written
 
 	nodePartsAndKinds := make(map[string]string)
@@ -291,7 +486,22 @@ func (gt *baseTrans) Commit() error {
 		}
 	}
 
-	panicIfError(gt.gm.gs.FlushMain())
+	panicIfError(gt.gm.flushMain())
+
+	// Record the commit sequence numbers of all written nodes and edges
+
+	seqParts := make(map[string]bool)
+
+	for _, sr := range gt.seqs {
+		panicIfError(gt.gm.recordSeq(sr.part, sr.key, sr.seq, sr.removed, false))
+		seqParts[sr.part] = true
+	}
+
+	gt.seqs = nil
+
+	for part := range seqParts {
+		panicIfError(gt.gm.flushSeqStorage(part))
+	}
 
 	for kkey := range nodePartsAndKinds {
 
@@ -319,7 +529,12 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 
 	// First insert nodes
 
-	for tkey, node := range gt.storeNodes {
+	for _, tkey := range sortedNodeKeys(gt.storeNodes) {
+		node := gt.storeNodes[tkey]
+
+		if err := gt.ctx.Err(); err != nil {
+			return err
+		}
 
 		// Get partition and kind
 
@@ -352,11 +567,10 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 		// to the index.
 
 		if oldnode == nil {
-			currentCount := gt.gm.NodeCount(node.Kind())
-			gt.gm.writeNodeCount(node.Kind(), currentCount+1, false)
+			gt.gm.changeCount(MainDBNodeCount+node.Kind(), 1, false)
 
 			if iht != nil {
-				err := util.NewIndexManager(iht).Index(node.Key(), node.IndexMap())
+				err := gt.gm.newIndexManager(iht, node.Kind()).Index(node.Key(), node.IndexMap())
 				if err != nil {
 
 					// The node was written at this point and the model is
@@ -368,7 +582,7 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 
 		} else if iht != nil {
 
-			err := util.NewIndexManager(iht).Reindex(node.Key(), node.IndexMap(),
+			err := gt.gm.newIndexManager(iht, node.Kind()).Reindex(node.Key(), node.IndexMap(),
 				oldnode.IndexMap())
 
 			if err != nil {
@@ -380,6 +594,8 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 			}
 		}
 
+		gt.addSeq(part, seqKey(false, node.Kind(), node.Key()), false)
+
 		// Execute rules
 
 		var event int
@@ -389,16 +605,24 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 			event = EventNodeUpdated
 		}
 
+		// Remove the node from the transaction before the rules run so rules
+		// can write the same node again
+
+		delete(gt.storeNodes, tkey)
+
 		if err := gt.gm.gr.graphEvent(gt, event, part, node, oldnode); err != nil {
 			return err
 		}
-
-		delete(gt.storeNodes, tkey)
 	}
 
 	// Then remove nodes
 
-	for tkey, node := range gt.removeNodes {
+	for _, tkey := range sortedNodeKeys(gt.removeNodes) {
+		node := gt.removeNodes[tkey]
+
+		if err := gt.ctx.Err(); err != nil {
+			return err
+		}
 
 		// Get partition and kind
 
@@ -439,7 +663,7 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 		if oldnode != nil {
 
 			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(node.Key(), oldnode.IndexMap())
+				err := gt.gm.newIndexManager(iht, node.Kind()).Deindex(node.Key(), oldnode.IndexMap())
 
 				if err != nil {
 					return err
@@ -448,8 +672,9 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 
 			// Decrease the node count
 
-			currentCount := gt.gm.NodeCount(node.Kind())
-			gt.gm.writeNodeCount(node.Kind(), currentCount-1, false)
+			gt.gm.changeCount(MainDBNodeCount+node.Kind(), -1, false)
+
+			gt.addSeq(part, seqKey(false, node.Kind(), node.Key()), true)
 
 			// Execute rules
 
@@ -471,7 +696,12 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 	// First insert edges
 
-	for tkey, edge := range gt.storeEdges {
+	for _, tkey := range sortedEdgeKeys(gt.storeEdges) {
+		edge := gt.storeEdges[tkey]
+
+		if err := gt.ctx.Err(); err != nil {
+			return err
+		}
 
 		// Get partition and kind
 
@@ -498,35 +728,9 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 		// Get the HTrees which stores the edge endpoints and make sure the endpoints
 		// do exist
 
-		end1nodeht, end1ht, err := gt.gm.getNodeStorageHTree(part, edge.End1Kind(), false)
-
-		if err != nil {
-			return err
-		} else if end1ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't store edge to non-existing node kind: %v", edge.End1Kind()),
-			}
-		} else if end1, err := end1nodeht.Get([]byte(PrefixNSAttrs + edge.End1Key())); err != nil || end1 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End1Key(), edge.End1Kind()),
-			}
-		}
-
-		end2nodeht, end2ht, err := gt.gm.getNodeStorageHTree(part, edge.End2Kind(), false)
-
+		end1ht, end2ht, err := gt.gm.getEdgeEndHTrees(part, edge, true)
 		if err != nil {
 			return err
-		} else if end2ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: "Can't store edge to non-existing node kind: " + edge.End2Kind()}
-		} else if end2, err := end2nodeht.Get([]byte(PrefixNSAttrs + edge.End2Key())); err != nil || end2 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End2Key(), edge.End2Kind()),
-			}
 		}
 
 		// Write edge to the datastore
@@ -543,14 +747,13 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 			// Increase edge count
 
-			currentCount := gt.gm.EdgeCount(edge.Kind())
-			gt.gm.writeEdgeCount(edge.Kind(), currentCount+1, false)
+			gt.gm.changeCount(MainDBEdgeCount+edge.Kind(), 1, false)
 
 			// Write edge data to the index
 
 			if iht != nil {
 
-				if err := util.NewIndexManager(iht).Index(edge.Key(), edge.IndexMap()); err != nil {
+				if err := gt.gm.newIndexManager(iht, edge.Kind()).Index(edge.Key(), edge.IndexMap()); err != nil {
 
 					// The edge was written at this point and the model is
 					// consistent only the index is missing entries
@@ -561,7 +764,7 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 		} else if iht != nil {
 
-			err := util.NewIndexManager(iht).Reindex(edge.Key(), edge.IndexMap(),
+			err := gt.gm.newIndexManager(iht, edge.Kind()).Reindex(edge.Key(), edge.IndexMap(),
 				oldedge.IndexMap())
 
 			if err != nil {
@@ -573,6 +776,8 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 			}
 		}
 
+		gt.addSeq(part, seqKey(true, edge.Kind(), edge.Key()), false)
+
 		// Execute rules
 
 		var event int
@@ -582,16 +787,24 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 			event = EventEdgeUpdated
 		}
 
+		// Remove the edge from the transaction before the rules run so rules
+		// can write the same edge again
+
+		delete(gt.storeEdges, tkey)
+
 		if err := gt.gm.gr.graphEvent(gt, event, part, edge, oldedge); err != nil {
 			return err
 		}
-
-		delete(gt.storeEdges, tkey)
 	}
 
 	// Then remove edges
 
-	for tkey, edge := range gt.removeEdges {
+	for _, tkey := range sortedEdgeKeys(gt.removeEdges) {
+		edge := gt.removeEdges[tkey]
+
+		if err := gt.ctx.Err(); err != nil {
+			return err
+		}
 
 		// Get partition and kind
 
@@ -627,12 +840,7 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 			// Get the HTrees which stores the edge endpoints
 
-			_, end1ht, err := gt.gm.getNodeStorageHTree(part, oldedge.End1Kind(), false)
-			if err != nil {
-				return err
-			}
-
-			_, end2ht, err := gt.gm.getNodeStorageHTree(part, oldedge.End2Kind(), false)
+			end1ht, end2ht, err := gt.gm.getEdgeEndHTrees(part, oldedge, false)
 			if err != nil {
 				return err
 			}
@@ -645,7 +853,7 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 			if iht != nil {
 
-				err := util.NewIndexManager(iht).Deindex(edge.Key(), oldedge.IndexMap())
+				err := gt.gm.newIndexManager(iht, edge.Kind()).Deindex(edge.Key(), oldedge.IndexMap())
 				if err != nil {
 					return err
 				}
@@ -653,8 +861,9 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 
 			// Decrease edge count
 
-			currentCount := gt.gm.EdgeCount(oldedge.Kind())
-			gt.gm.writeEdgeCount(oldedge.Kind(), currentCount-1, false)
+			gt.gm.changeCount(MainDBEdgeCount+oldedge.Kind(), -1, false)
+
+			gt.addSeq(part, seqKey(true, edge.Kind(), edge.Key()), true)
 
 			// Execute rules
 
@@ -676,12 +885,20 @@ overwrites any existing node.
 func (gt *baseTrans) StoreNode(part string, node data.Node) error {
 	if err := gt.gm.checkPartitionName(part); err != nil {
 		return err
+	} else if err := gt.gm.assignKey(part, node, false); err != nil {
+		return err
 	} else if err := gt.gm.checkNode(node); err != nil {
 		return err
 	}
 
 	key := gt.createKey(part, node.Key(), node.Kind())
 
+	if err := gt.reserve(false, key, node); err != nil {
+		return err
+	}
+
+	gt.recordUndo(false, key)
+
 	if _, ok := gt.removeNodes[key]; ok {
 		delete(gt.removeNodes, key)
 	}
@@ -698,17 +915,19 @@ only update the given values of the node.
 func (gt *baseTrans) UpdateNode(part string, node data.Node) error {
 	if err := gt.gm.checkPartitionName(part); err != nil {
 		return err
+	} else if err := gt.gm.assignKey(part, node, false); err != nil {
+		return err
 	} else if err := gt.gm.checkNode(node); err != nil {
 		return err
 	}
 
 	key := gt.createKey(part, node.Key(), node.Kind())
 
-	if _, ok := gt.removeNodes[key]; ok {
-		delete(gt.removeNodes, key)
-	} else if storeNode, ok := gt.storeNodes[key]; ok {
+	_, removed := gt.removeNodes[key]
+
+	if storeNode, ok := gt.storeNodes[key]; ok {
 		node = data.NodeMerge(storeNode, node)
-	} else {
+	} else if !removed {
 
 		// Check the actual database if the node exists
 
@@ -720,6 +939,16 @@ func (gt *baseTrans) UpdateNode(part string, node data.Node) error {
 		}
 	}
 
+	if err := gt.reserve(false, key, node); err != nil {
+		return err
+	}
+
+	gt.recordUndo(false, key)
+
+	if removed {
+		delete(gt.removeNodes, key)
+	}
+
 	gt.storeNodes[key] = node
 
 	return nil
@@ -735,14 +964,20 @@ func (gt *baseTrans) RemoveNode(part string, nkey string, nkind string) error {
 
 	key := gt.createKey(part, nkey, nkind)
 
-	if _, ok := gt.storeNodes[key]; ok {
-		delete(gt.storeNodes, key)
-	}
-
 	node := data.NewGraphNode()
 	node.SetAttr(data.NodeKey, nkey)
 	node.SetAttr(data.NodeKind, nkind)
 
+	if err := gt.reserve(false, key, node); err != nil {
+		return err
+	}
+
+	gt.recordUndo(false, key)
+
+	if _, ok := gt.storeNodes[key]; ok {
+		delete(gt.storeNodes, key)
+	}
+
 	gt.removeNodes[key] = node
 
 	return nil
@@ -755,12 +990,20 @@ overwrites any existing edge.
 func (gt *baseTrans) StoreEdge(part string, edge data.Edge) error {
 	if err := gt.gm.checkPartitionName(part); err != nil {
 		return err
+	} else if err := gt.gm.assignKey(part, edge, true); err != nil {
+		return err
 	} else if err := gt.gm.checkEdge(edge); err != nil {
 		return err
 	}
 
 	key := gt.createKey(part, edge.Key(), edge.Kind())
 
+	if err := gt.reserve(true, key, edge); err != nil {
+		return err
+	}
+
+	gt.recordUndo(true, key)
+
 	if _, ok := gt.removeEdges[key]; ok {
 		delete(gt.removeEdges, key)
 	}
@@ -780,14 +1023,20 @@ func (gt *baseTrans) RemoveEdge(part string, ekey string, ekind string) error {
 
 	key := gt.createKey(part, ekey, ekind)
 
-	if _, ok := gt.storeEdges[key]; ok {
-		delete(gt.storeEdges, key)
-	}
-
 	edge := data.NewGraphEdge()
 	edge.SetAttr(data.NodeKey, ekey)
 	edge.SetAttr(data.NodeKind, ekind)
 
+	if err := gt.reserve(true, key, edge); err != nil {
+		return err
+	}
+
+	gt.recordUndo(true, key)
+
+	if _, ok := gt.storeEdges[key]; ok {
+		delete(gt.storeEdges, key)
+	}
+
 	gt.removeEdges[key] = edge
 
 	return nil
@@ -800,6 +1049,164 @@ func (gt *baseTrans) createKey(part string, key string, kind string) string {
 	return part + "#" + kind + "#" + key
 }
 
+/*
+sortedNodeKeys returns the sorted transaction keys of a map of nodes. Nodes and
+edges are committed in the order of their keys so that the commit sequence
+numbers which they get are deterministic.
+*/
+func sortedNodeKeys(nodes map[string]data.Node) []string {
+	keys := make([]string, 0, len(nodes))
+
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+sortedEdgeKeys returns the sorted transaction keys of a map of edges.
+*/
+func sortedEdgeKeys(edges map[string]data.Edge) []string {
+	keys := make([]string, 0, len(edges))
+
+	for key := range edges {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+addSeq assigns the next commit sequence number of a partition to a written
+node or edge. The number is recorded once the commit succeeded.
+*/
+func (gt *baseTrans) addSeq(part string, skey string, removed bool) {
+	gt.seqs = append(gt.seqs, &seqRecord{part, skey, gt.gm.nextSeq(part), removed})
+}
+
+/*
+discard removes all changes from the transaction.
+*/
+func (gt *baseTrans) discard() {
+	gt.storeNodes = make(map[string]data.Node)
+	gt.removeNodes = make(map[string]data.Node)
+	gt.storeEdges = make(map[string]data.Edge)
+	gt.removeEdges = make(map[string]data.Edge)
+	gt.size = 0
+	gt.releaseSavepoints()
+}
+
+/*
+Savepoint marks the current state of the transaction and returns the ID of the
+savepoint. Savepoints are released when the transaction is committed or rolled
+back.
+*/
+func (gt *baseTrans) Savepoint() int {
+	gt.savepointCounter++
+
+	gt.savepoints = append(gt.savepoints, &savepoint{gt.savepointCounter, len(gt.undoLog), gt.size})
+
+	return gt.savepointCounter
+}
+
+/*
+RollbackTo discards all changes which were added to the transaction after a
+given savepoint. The savepoint can be used again - all savepoints which were
+created after it are released.
+*/
+func (gt *baseTrans) RollbackTo(id int) error {
+	var sp *savepoint
+
+	i := len(gt.savepoints) - 1
+
+	for ; i >= 0; i-- {
+		if gt.savepoints[i].id == id {
+			sp = gt.savepoints[i]
+			break
+		}
+	}
+
+	if sp == nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprint("Unknown savepoint: ", id)}
+	}
+
+	// Restore the previous entries in reverse order
+
+	for j := len(gt.undoLog) - 1; j >= sp.pos; j-- {
+		gt.undoLog[j].restore(gt)
+	}
+
+	gt.undoLog = gt.undoLog[:sp.pos]
+	gt.savepoints = gt.savepoints[:i+1]
+	gt.size = sp.size
+
+	return nil
+}
+
+/*
+recordUndo records the current state of a node or edge entry before it is
+changed. Nothing is recorded if there are no savepoints.
+*/
+func (gt *baseTrans) recordUndo(isEdge bool, key string) {
+	if len(gt.savepoints) == 0 {
+		return
+	}
+
+	u := &transUndo{isEdge: isEdge, key: key}
+
+	if isEdge {
+		u.storeEdge = gt.storeEdges[key]
+		u.removeEdge = gt.removeEdges[key]
+	} else {
+		u.storeNode = gt.storeNodes[key]
+		u.removeNode = gt.removeNodes[key]
+	}
+
+	gt.undoLog = append(gt.undoLog, u)
+}
+
+/*
+releaseSavepoints releases all savepoints of the transaction.
+*/
+func (gt *baseTrans) releaseSavepoints() {
+	gt.savepoints = nil
+	gt.undoLog = nil
+}
+
+/*
+restore restores a recorded node or edge entry of a transaction.
+*/
+func (u *transUndo) restore(gt *baseTrans) {
+	if u.isEdge {
+		delete(gt.storeEdges, u.key)
+		delete(gt.removeEdges, u.key)
+
+		if u.storeEdge != nil {
+			gt.storeEdges[u.key] = u.storeEdge
+		}
+		if u.removeEdge != nil {
+			gt.removeEdges[u.key] = u.removeEdge
+		}
+
+		return
+	}
+
+	delete(gt.storeNodes, u.key)
+	delete(gt.removeNodes, u.key)
+
+	if u.storeNode != nil {
+		gt.storeNodes[u.key] = u.storeNode
+	}
+	if u.removeNode != nil {
+		gt.removeNodes[u.key] = u.removeNode
+	}
+}
+
 /*
 concurrentTrans is a lock-wrapper around baseTrans which allows concurrent use.
 */
@@ -861,6 +1268,18 @@ func (gt *concurrentTrans) Commit() error {
 	return gt.Trans.Commit()
 }
 
+/*
+CommitContext writes the transaction to the graph database like Commit. The commit
+is aborted and rolled back if the given context is done before all changes have
+been written.
+*/
+func (gt *concurrentTrans) CommitContext(ctx context.Context) error {
+	gt.transLock.Lock()
+	defer gt.transLock.Unlock()
+
+	return gt.Trans.CommitContext(ctx)
+}
+
 /*
 StoreNode stores a single node in a partition of the graph. This function will
 overwrites any existing node.
@@ -914,6 +1333,28 @@ func (gt *concurrentTrans) RemoveEdge(part string, ekey string, ekind string) er
 	return gt.Trans.RemoveEdge(part, ekey, ekind)
 }
 
+/*
+Savepoint marks the current state of the transaction and returns the ID of the
+savepoint.
+*/
+func (gt *concurrentTrans) Savepoint() int {
+	gt.transLock.Lock()
+	defer gt.transLock.Unlock()
+
+	return gt.Trans.Savepoint()
+}
+
+/*
+RollbackTo discards all changes which were added to the transaction after a
+given savepoint.
+*/
+func (gt *concurrentTrans) RollbackTo(savepoint int) error {
+	gt.transLock.Lock()
+	defer gt.transLock.Unlock()
+
+	return gt.Trans.RollbackTo(savepoint)
+}
+
 /*
 rollingTrans is a rolling transaction which will commit itself after
 n operations.
@@ -936,9 +1377,20 @@ type rollingTrans struct {
 	countEdgeIns int // Count for inserted edges
 	countEdgeRem int // Count for removed edges
 
+	savepointCounter int                       // Counter for savepoint IDs
+	savepoints       map[int]*rollingSavepoint // Savepoints of the current transaction
+
 	transLock *sync.RWMutex // Lock for this transaction
 }
 
+/*
+rollingSavepoint is a savepoint of a sub-transaction of a rolling transaction.
+*/
+type rollingSavepoint struct {
+	trans Trans // Sub-transaction of the savepoint
+	id    int   // ID of the savepoint in the sub-transaction
+}
+
 /*
 ID returns a unique transaction ID.
 */
@@ -994,15 +1446,26 @@ Commit writes the remaining operations of this rolling transaction to
 the graph database.
 */
 func (gt *rollingTrans) Commit() error {
+	return gt.CommitContext(context.Background())
+}
+
+/*
+CommitContext commits the current transaction like Commit. Only the current
+transaction is aborted if the given context is done - transactions which
+are already in-flight are not affected.
+*/
+func (gt *rollingTrans) CommitContext(ctx context.Context) error {
 
 	// Commit current transaction
 
 	gt.transLock.Lock()
 
-	if err := gt.currentTrans.Commit(); err != nil {
+	if err := gt.currentTrans.CommitContext(ctx); err != nil {
 		gt.transErrors.Add(err)
 	}
 
+	gt.savepoints = make(map[int]*rollingSavepoint)
+
 	gt.transLock.Unlock()
 
 	// Wait for other transactions
@@ -1034,6 +1497,10 @@ func (gt *rollingTrans) checkNewSubTrans() {
 		cTrans := gt.currentTrans
 		gt.currentTrans = gt.newTransFunc(gt.gm)
 
+		// Savepoints of the committed transaction cannot be used anymore
+
+		gt.savepoints = make(map[int]*rollingSavepoint)
+
 		ns, es, nr, er := cTrans.Counts()
 
 		gt.countNodeIns += ns
@@ -1157,3 +1624,348 @@ func (gt *rollingTrans) RemoveEdge(part string, ekey string, ekind string) error
 
 	return err
 }
+
+/*
+Savepoint marks the current state of the current sub-transaction and returns
+the ID of the savepoint. A savepoint is released once its sub-transaction is
+committed.
+*/
+func (gt *rollingTrans) Savepoint() int {
+	gt.transLock.Lock()
+	defer gt.transLock.Unlock()
+
+	gt.savepointCounter++
+
+	gt.savepoints[gt.savepointCounter] = &rollingSavepoint{gt.currentTrans, gt.currentTrans.Savepoint()}
+
+	return gt.savepointCounter
+}
+
+/*
+RollbackTo discards all changes which were added to the current sub-transaction
+after a given savepoint. Fails if the sub-transaction of the savepoint has
+already been committed.
+*/
+func (gt *rollingTrans) RollbackTo(savepoint int) error {
+	gt.transLock.Lock()
+	defer gt.transLock.Unlock()
+
+	sp, ok := gt.savepoints[savepoint]
+
+	if !ok {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprint("Unknown savepoint: ", savepoint)}
+	}
+
+	// Release all later savepoints
+
+	for id := range gt.savepoints {
+		if id > savepoint {
+			delete(gt.savepoints, id)
+		}
+	}
+
+	return sp.trans.RollbackTo(sp.id)
+}
+
+/*
+readVersion is the version of a node or edge which was read by an optimistic
+transaction.
+*/
+type readVersion struct {
+	part   string    // Partition of the node or edge
+	key    string    // Key of the node or edge
+	kind   string    // Kind of the node or edge
+	isEdge bool      // Flag if the version is an edge
+	node   data.Node // Copy of the node or edge (nil if it did not exist)
+}
+
+/*
+optimisticTrans is a graph transaction which records the versions of all nodes
+and edges which it has read.
+*/
+type optimisticTrans struct {
+	*baseTrans
+	reads map[string]*readVersion // Recorded versions
+}
+
+/*
+FetchNode fetches a single node from a partition of the graph and records its
+version. Only the version of the first fetch is recorded.
+*/
+func (gt *optimisticTrans) FetchNode(part string, key string, kind string) (data.Node, error) {
+	node, err := gt.gm.FetchNode(part, key, kind)
+
+	if err == nil {
+		gt.recordRead(part, key, kind, false, node)
+	}
+
+	return node, err
+}
+
+/*
+FetchEdge fetches a single edge from a partition of the graph and records its
+version. Only the version of the first fetch is recorded.
+*/
+func (gt *optimisticTrans) FetchEdge(part string, key string, kind string) (data.Edge, error) {
+	edge, err := gt.gm.FetchEdge(part, key, kind)
+
+	if err == nil {
+		gt.recordRead(part, key, kind, true, edge)
+	}
+
+	return edge, err
+}
+
+/*
+recordRead records the version of a node or edge. The returned node might be
+changed by the caller so a deep copy is kept.
+*/
+func (gt *optimisticTrans) recordRead(part string, key string, kind string, isEdge bool, node data.Node) {
+	rkey := fmt.Sprint(isEdge, "#", gt.createKey(part, key, kind))
+
+	if _, ok := gt.reads[rkey]; !ok {
+		if node != nil {
+			node = data.NodeClone(node)
+		}
+
+		gt.reads[rkey] = &readVersion{part, key, kind, isEdge, node}
+	}
+}
+
+/*
+Commit writes the transaction to the graph database if none of the read nodes
+and edges were modified concurrently. Returns a util.ConflictError otherwise.
+*/
+func (gt *optimisticTrans) Commit() error {
+	return gt.CommitContext(context.Background())
+}
+
+/*
+CommitContext writes the transaction to the graph database like Commit. The commit
+is aborted and rolled back if the given context is done before all changes have
+been written.
+*/
+func (gt *optimisticTrans) CommitContext(ctx context.Context) error {
+	empty := gt.IsEmpty()
+
+	gt.gm.mutex.Lock()
+
+	err := gt.checkReads()
+
+	if err == nil {
+		err = gt.commitLocked(ctx)
+	} else {
+
+		// Discard the transaction
+
+		gt.discard()
+	}
+
+	gt.gm.mutex.Unlock()
+
+	gt.reads = make(map[string]*readVersion)
+
+	if err == nil && !empty {
+		err = gt.gm.waitDurable()
+	}
+
+	return err
+}
+
+/*
+checkReads compares all recorded versions with the current data. Assumes that
+the writer lock is held.
+*/
+func (gt *optimisticTrans) checkReads() error {
+	var conflicts []string
+
+	for _, rv := range gt.reads {
+		var node data.Node
+		var err error
+
+		if rv.isEdge {
+			var tree *hash.HTree
+
+			if tree, err = gt.gm.getEdgeStorageHTree(rv.part, rv.kind, false); err == nil && tree != nil {
+				node, err = gt.gm.readNode(rv.key, rv.kind, nil, tree, tree)
+			}
+
+		} else {
+			var attTree, valTree *hash.HTree
+
+			if attTree, valTree, err = gt.gm.getNodeStorageHTree(rv.part, rv.kind, false); err == nil && attTree != nil {
+				node, err = gt.gm.readNode(rv.key, rv.kind, nil, attTree, valTree)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if (node == nil) != (rv.node == nil) ||
+			(node != nil && !reflect.DeepEqual(node.Data(), rv.node.Data())) {
+
+			t := "n"
+			if rv.isEdge {
+				t = "e"
+			}
+
+			conflicts = append(conflicts, fmt.Sprintf("%v:%v:%v:%v", rv.part, t, rv.kind, rv.key))
+		}
+	}
+
+	if conflicts != nil {
+		sort.Strings(conflicts)
+		return util.NewConflictError(conflicts)
+	}
+
+	return nil
+}
+
+/*
+lockingTrans is a graph transaction which holds locks on all nodes and edges
+which it has accessed.
+*/
+type lockingTrans struct {
+	*baseTrans
+}
+
+/*
+FetchNode locks a single node shared and fetches it from a partition of the
+graph.
+*/
+func (gt *lockingTrans) FetchNode(part string, key string, kind string) (data.Node, error) {
+	if err := gt.lock(part, key, kind, false, false); err != nil {
+		return nil, err
+	}
+
+	return gt.gm.FetchNode(part, key, kind)
+}
+
+/*
+FetchEdge locks a single edge shared and fetches it from a partition of the
+graph.
+*/
+func (gt *lockingTrans) FetchEdge(part string, key string, kind string) (data.Edge, error) {
+	if err := gt.lock(part, key, kind, true, false); err != nil {
+		return nil, err
+	}
+
+	return gt.gm.FetchEdge(part, key, kind)
+}
+
+/*
+StoreNode locks a node exclusively and stores it in a partition of the graph.
+*/
+func (gt *lockingTrans) StoreNode(part string, node data.Node) error {
+	if err := gt.gm.assignKey(part, node, false); err != nil {
+		return err
+	} else if err := gt.gm.checkNode(node); err != nil {
+		return err
+	} else if err := gt.lock(part, node.Key(), node.Kind(), false, true); err != nil {
+		return err
+	}
+
+	return gt.baseTrans.StoreNode(part, node)
+}
+
+/*
+UpdateNode locks a node exclusively and updates it in a partition of the graph.
+*/
+func (gt *lockingTrans) UpdateNode(part string, node data.Node) error {
+	if err := gt.gm.assignKey(part, node, false); err != nil {
+		return err
+	} else if err := gt.gm.checkNode(node); err != nil {
+		return err
+	} else if err := gt.lock(part, node.Key(), node.Kind(), false, true); err != nil {
+		return err
+	}
+
+	return gt.baseTrans.UpdateNode(part, node)
+}
+
+/*
+RemoveNode locks a node exclusively and removes it from a partition of the graph.
+*/
+func (gt *lockingTrans) RemoveNode(part string, nkey string, nkind string) error {
+	if err := gt.lock(part, nkey, nkind, false, true); err != nil {
+		return err
+	}
+
+	return gt.baseTrans.RemoveNode(part, nkey, nkind)
+}
+
+/*
+StoreEdge locks an edge exclusively and stores it in a partition of the graph.
+*/
+func (gt *lockingTrans) StoreEdge(part string, edge data.Edge) error {
+	if err := gt.gm.assignKey(part, edge, true); err != nil {
+		return err
+	} else if err := gt.gm.checkEdge(edge); err != nil {
+		return err
+	} else if err := gt.lock(part, edge.Key(), edge.Kind(), true, true); err != nil {
+		return err
+	}
+
+	return gt.baseTrans.StoreEdge(part, edge)
+}
+
+/*
+RemoveEdge locks an edge exclusively and removes it from a partition of the graph.
+*/
+func (gt *lockingTrans) RemoveEdge(part string, ekey string, ekind string) error {
+	if err := gt.lock(part, ekey, ekind, true, true); err != nil {
+		return err
+	}
+
+	return gt.baseTrans.RemoveEdge(part, ekey, ekind)
+}
+
+/*
+Commit writes the transaction to the graph database and releases all locks.
+*/
+func (gt *lockingTrans) Commit() error {
+	return gt.CommitContext(context.Background())
+}
+
+/*
+CommitContext writes the transaction to the graph database like Commit. The commit
+is aborted and rolled back if the given context is done before all changes have
+been written. All locks are released.
+*/
+func (gt *lockingTrans) CommitContext(ctx context.Context) error {
+	defer gt.gm.lockManager.UnlockAll(gt.id)
+
+	return gt.baseTrans.CommitContext(ctx)
+}
+
+/*
+Rollback discards all changes of the transaction and releases its locks.
+*/
+func (gt *lockingTrans) Rollback() {
+	gt.discard()
+	gt.gm.lockManager.UnlockAll(gt.id)
+}
+
+/*
+lock acquires a lock on a node or an edge. The transaction is rolled back if
+the lock cannot be acquired.
+*/
+func (gt *lockingTrans) lock(part string, key string, kind string, isEdge bool, exclusive bool) error {
+	if err := gt.gm.checkPartitionName(part); err != nil {
+		return err
+	}
+
+	t := "n"
+	if isEdge {
+		t = "e"
+	}
+
+	err := gt.gm.lockManager.Lock(gt.id, fmt.Sprintf("%v:%v:%v:%v", part, t, kind, key), exclusive)
+
+	if err != nil {
+		gt.Rollback()
+	}
+
+	return err
+}
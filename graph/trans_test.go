@@ -19,6 +19,7 @@ import (
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/storage"
 )
 
@@ -502,6 +503,418 @@ func TestNormalTrans(t *testing.T) {
 	dgs.Close()
 }
 
+func TestOptimisticTrans(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	newNode := func(key string, val interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "account")
+		node.SetAttr("balance", val)
+		return node
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "transfer")
+	edge.SetAttr(data.EdgeEnd1Key, "a1")
+	edge.SetAttr(data.EdgeEnd1Kind, "account")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "a2")
+	edge.SetAttr(data.EdgeEnd2Kind, "account")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	gm.StoreNode("main", newNode("a1", 100))
+	gm.StoreNode("main", newNode("a2", []interface{}{1, 2}))
+	gm.StoreEdge("main", edge)
+
+	// Read-modify-write without concurrent changes
+
+	trans := NewOptimisticGraphTrans(gm)
+
+	node, err := trans.FetchNode("main", "a1", "account")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	node.SetAttr("balance", node.Attr("balance").(int)-10)
+
+	if err := trans.UpdateNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := trans.FetchNode("main", "a2", "account"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if e, err := trans.FetchEdge("main", "e1", "transfer"); e == nil || err != nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "a1", "account"); n.Attr("balance") != 90 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Concurrent changes are detected
+
+	trans = NewOptimisticGraphTrans(gm)
+
+	node, _ = trans.FetchNode("main", "a1", "account")
+	trans.FetchNode("main", "a2", "account")
+	trans.FetchNode("main", "a3", "account")
+	trans.FetchEdge("main", "e1", "transfer")
+	trans.FetchEdge("main", "e2", "transfer")
+
+	node.SetAttr("balance", 0)
+	trans.UpdateNode("main", node)
+
+	gm.UpdateNode("main", newNode("a1", 50))
+	gm.StoreNode("main", newNode("a3", 1))
+
+	edge.SetAttr("amount", 5)
+	gm.StoreEdge("main", edge)
+
+	err = trans.Commit()
+
+	if cerr, ok := err.(*util.ConflictError); !ok || err.Error() !=
+		"GraphError: Concurrent modification (main:e:transfer:e1, main:n:account:a1, main:n:account:a3)" ||
+		len(cerr.Conflicts) != 3 {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "a1", "account"); n.Attr("balance") != 50 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// The failed transaction was discarded
+
+	if !trans.IsEmpty() {
+		t.Error("Transaction should be empty:", trans)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Removals are detected
+
+	trans = NewOptimisticGraphTrans(gm)
+
+	trans.FetchNode("main", "a3", "account")
+	gm.RemoveNode("main", "a3", "account")
+
+	if err := trans.Commit(); err == nil || err.Error() !=
+		"GraphError: Concurrent modification (main:n:account:a3)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestLockingTrans(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	newNode := func(key string, val interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "account")
+		node.SetAttr("balance", val)
+		return node
+	}
+
+	gm.StoreNode("main", newNode("a1", 100))
+	gm.StoreNode("main", newNode("a2", 100))
+
+	if _, err := NewLockingGraphTrans(gm).FetchNode("main#", "a1", "account"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Partition name main# is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	trans1 := NewLockingGraphTrans(gm)
+	trans2 := NewLockingGraphTrans(gm)
+
+	// Both transactions can read the same node
+
+	node, err := trans1.FetchNode("main", "a1", "account")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := trans2.FetchNode("main", "a1", "account"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(gm.LockManager().Status()[0]); res != fmt.Sprintf("&{main:n:account:a1 [%v %v] false []}",
+		trans1.ID(), trans2.ID()) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans2.StoreNode("main", newNode("a2", 50))
+
+	// The first writer waits for the second reader
+
+	errs := make(chan error, 1)
+
+	go func() {
+		node.SetAttr("balance", 90)
+		if err := trans1.UpdateNode("main", node); err != nil {
+			errs <- err
+			return
+		}
+
+		// The node of the other transaction can be read once it was rolled back
+
+		trans1.FetchNode("main", "a2", "account")
+		errs <- trans1.Commit()
+	}()
+
+	if !waitForWaiters(gm.LockManager(), "main:n:account:a1", 1) {
+		t.Error("Request should wait:", gm.LockManager().Status())
+		return
+	}
+
+	// The second writer would close the wait cycle and is rolled back
+
+	err = trans2.UpdateNode("main", newNode("a1", 0))
+
+	if gerr, ok := err.(*util.GraphError); !ok || gerr.Type != util.ErrDeadlock ||
+		err.Error() != fmt.Sprintf("GraphError: Deadlock (%v cannot acquire lock main:n:account:a1 - wait cycle: [%v %v %v])",
+			trans2.ID(), trans2.ID(), trans1.ID(), trans2.ID()) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if !trans2.IsEmpty() {
+		t.Error("Transaction should be empty:", trans2)
+		return
+	}
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "a1", "account"); n.Attr("balance") != 90 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "a2", "account"); n.Attr("balance") != 100 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Changes of edges and removals are locked as well
+
+	trans1 = NewLockingGraphTrans(gm)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "transfer")
+	edge.SetAttr(data.EdgeEnd1Key, "a1")
+	edge.SetAttr(data.EdgeEnd1Kind, "account")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "a2")
+	edge.SetAttr(data.EdgeEnd2Kind, "account")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	trans1.StoreEdge("main", edge)
+	trans1.FetchEdge("main", "e2", "transfer")
+	trans1.RemoveEdge("main", "e3", "transfer")
+	trans1.RemoveNode("main", "a2", "account")
+
+	var names []string
+	for _, ls := range gm.LockManager().Status() {
+		names = append(names, fmt.Sprint(ls.Name, ":", ls.Exclusive))
+	}
+
+	if res := fmt.Sprint(names); res !=
+		"[main:e:transfer:e1:true main:e:transfer:e2:false main:e:transfer:e3:true main:n:account:a2:true]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A rollback releases all locks
+
+	trans1.Rollback()
+
+	if !trans1.IsEmpty() || len(gm.LockManager().Status()) != 0 {
+		t.Error("Unexpected result:", trans1, gm.LockManager().Status())
+		return
+	}
+}
+
+func TestTransSavepoints(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	newNode := func(key string, val interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "item")
+		node.SetAttr("val", val)
+		return node
+	}
+
+	newEdge := func(key string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", key)
+		edge.SetAttr("kind", "link")
+		edge.SetAttr(data.EdgeEnd1Key, "1")
+		edge.SetAttr(data.EdgeEnd1Kind, "item")
+		edge.SetAttr(data.EdgeEnd1Role, "from")
+		edge.SetAttr(data.EdgeEnd1Cascading, true)
+		edge.SetAttr(data.EdgeEnd2Key, "2")
+		edge.SetAttr(data.EdgeEnd2Kind, "item")
+		edge.SetAttr(data.EdgeEnd2Role, "to")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	gm.StoreNode("main", newNode("3", "stored"))
+
+	trans := NewGraphTrans(gm)
+
+	if err := trans.RollbackTo(1); err == nil || err.Error() != "GraphError: Invalid data (Unknown savepoint: 1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	trans.StoreNode("main", newNode("1", "a"))
+
+	sp1 := trans.Savepoint()
+
+	trans.StoreNode("main", newNode("2", "b"))
+	trans.UpdateNode("main", newNode("1", "c"))
+	trans.RemoveNode("main", "3", "item")
+
+	sp2 := trans.Savepoint()
+
+	trans.StoreEdge("main", newEdge("e1"))
+	trans.RemoveNode("main", "1", "item")
+
+	if res := fmt.Sprint(trans.Counts()); res != "1 1 2 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Roll back the last chunk - the savepoint can be used again
+
+	if err := trans.RollbackTo(sp2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(trans.Counts()); res != "2 0 1 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans.RemoveEdge("main", "e1", "link")
+
+	if err := trans.RollbackTo(sp2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Rolling back to an earlier savepoint releases later savepoints
+
+	if err := trans.RollbackTo(sp1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := trans.RollbackTo(sp2); err == nil {
+		t.Error("Savepoint should have been released")
+		return
+	}
+
+	trans.StoreNode("main", newNode("2", "d"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	n1, _ := gm.FetchNode("main", "1", "item")
+	n2, _ := gm.FetchNode("main", "2", "item")
+	n3, _ := gm.FetchNode("main", "3", "item")
+
+	if n1.Attr("val") != "a" || n2.Attr("val") != "d" || n3 == nil {
+		t.Error("Unexpected result:", n1, n2, n3)
+		return
+	}
+
+	// Savepoints are released by the commit
+
+	if err := trans.RollbackTo(sp1); err == nil {
+		t.Error("Savepoint should have been released")
+		return
+	}
+
+	// Savepoints of rolling transactions are released once their
+	// sub-transaction is committed
+
+	rtrans := NewRollingTrans(NewConcurrentGraphTrans(gm), 3, gm, NewConcurrentGraphTrans)
+
+	sp1 = rtrans.Savepoint()
+
+	rtrans.StoreNode("main", newNode("4", "e"))
+
+	sp2 = rtrans.Savepoint()
+
+	rtrans.StoreNode("main", newNode("5", "f"))
+
+	if err := rtrans.RollbackTo(sp2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rtrans.StoreNode("main", newNode("6", "g"))
+	rtrans.StoreNode("main", newNode("7", "h"))
+
+	if err := rtrans.RollbackTo(sp1); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown savepoint: 1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := rtrans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(gm.NodeCount("item")); res != "6" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "5", "item"); n != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+}
+
 func TestTransBuilding(t *testing.T) {
 	node1 := data.NewGraphNode()
 	node1.SetAttr("key", "123")
@@ -1128,12 +1541,12 @@ func TestTransErrors(t *testing.T) {
 	}
 
 	sm = mgs.StorageManager("main"+"myedge"+StorageSuffixEdgesIndex, false).(*storage.MemoryStorageManager)
-	sm.AccessMap[4] = storage.AccessCacheAndFetchError
+	sm.AccessMap[7] = storage.AccessCacheAndFetchError
 	if err := trans.Commit(); !strings.Contains(fmt.Sprint(err), "GraphError: Index error") {
 		t.Error("Unexpected error return:", err)
 		return
 	}
-	delete(sm.AccessMap, 4)
+	delete(sm.AccessMap, 7)
 
 	// Test edge deletion errors
 
@@ -0,0 +1,402 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+transLimits are the size limits of single transactions.
+*/
+type transLimits struct {
+	maxOps   int64 // Maximum number of nodes and edges (0 for no limit)
+	maxBytes int64 // Maximum estimated size in bytes (0 for no limit)
+}
+
+/*
+SetTransLimits sets hard limits for the size of single transactions. A
+transaction can contain at most maxOps nodes and edges (stored or removed) and
+their estimated size must not exceed maxBytes. An operation which would exceed
+a limit is rejected with an util.ErrTransLimit error - the transaction itself
+stays usable. A limit of 0 disables the check.
+*/
+func (gm *Manager) SetTransLimits(maxOps int, maxBytes int64) {
+	atomic.StoreInt64(&gm.transLimits.maxOps, int64(maxOps))
+	atomic.StoreInt64(&gm.transLimits.maxBytes, maxBytes)
+}
+
+/*
+TransLimits returns the hard limits for the size of single transactions.
+*/
+func (gm *Manager) TransLimits() (int, int64) {
+	return int(atomic.LoadInt64(&gm.transLimits.maxOps)), atomic.LoadInt64(&gm.transLimits.maxBytes)
+}
+
+/*
+reserve checks if a node or an edge entry can be added to the transaction and
+updates the estimated size of the transaction. An existing entry with the
+same key is replaced. The limits are not checked during a commit since graph
+rules might add further entries.
+*/
+func (gt *baseTrans) reserve(isEdge bool, key string, entry data.Node) error {
+	var old int64
+	var exists bool
+
+	if gt.ctx != nil {
+		return nil
+	}
+
+	if isEdge {
+		if e, ok := gt.storeEdges[key]; ok {
+			old, exists = estimateSize(e), true
+		} else if e, ok := gt.removeEdges[key]; ok {
+			old, exists = estimateSize(e), true
+		}
+	} else {
+		if n, ok := gt.storeNodes[key]; ok {
+			old, exists = estimateSize(n), true
+		} else if n, ok := gt.removeNodes[key]; ok {
+			old, exists = estimateSize(n), true
+		}
+	}
+
+	size := gt.size - old + estimateSize(entry)
+	maxOps, maxBytes := gt.gm.TransLimits()
+
+	if maxOps > 0 && !exists {
+		sn, se, rn, re := gt.Counts()
+
+		if sn+se+rn+re >= maxOps {
+			return &util.GraphError{Type: util.ErrTransLimit,
+				Detail: fmt.Sprintf("Transaction %v cannot contain more than %v nodes and edges", gt.id, maxOps)}
+		}
+	}
+
+	if maxBytes > 0 && size > maxBytes {
+		return &util.GraphError{Type: util.ErrTransLimit,
+			Detail: fmt.Sprintf("Transaction %v cannot be larger than %v bytes", gt.id, maxBytes)}
+	}
+
+	gt.size = size
+
+	return nil
+}
+
+/*
+EstimateSize estimates the size of a node or an edge in bytes. This is the
+size which is counted for transaction limits and partition usage.
+*/
+func EstimateSize(node data.Node) int64 {
+	return estimateSize(node)
+}
+
+/*
+estimateSize estimates the memory which is needed to hold a value (e.g. a node,
+an edge or an attribute value) in bytes.
+*/
+func estimateSize(v interface{}) int64 {
+	var size int64
+
+	switch v := v.(type) {
+	case nil:
+	case string:
+		size = int64(len(v))
+	case []byte:
+		size = int64(len(v))
+	case bool, int8, uint8:
+		size = 1
+	case int16, uint16:
+		size = 2
+	case int32, uint32, float32:
+		size = 4
+	case int, int64, uint, uint64, float64:
+		size = 8
+	case []string:
+		for _, s := range v {
+			size += int64(len(s))
+		}
+	case []interface{}:
+		for _, i := range v {
+			size += estimateSize(i)
+		}
+	case map[string]interface{}:
+		for k, i := range v {
+			size += int64(len(k)) + estimateSize(i)
+		}
+	case data.Node:
+		size = estimateSize(v.Data())
+	default:
+		size = int64(len(fmt.Sprint(v)))
+	}
+
+	return size
+}
+
+/*
+ChunkedTrans is a transaction which commits itself in chunks. The current chunk
+is committed once it contains a given number of nodes and edges or once its
+estimated size reaches a given number of bytes. A chunk is also committed
+before an operation would exceed the hard limits of the graph manager.
+*/
+type ChunkedTrans interface {
+	Trans
+
+	/*
+	   Chunks returns the number of chunks which have been committed.
+	*/
+	Chunks() int
+}
+
+/*
+NewChunkedTrans creates a new chunked transaction which commits after maxOps
+nodes and edges or once the estimated size of a chunk reaches maxBytes (0 for
+no limit). Chunks are committed synchronously by the operation which fills
+them. A chunk which fails to commit is rolled back - chunks which have been
+committed before are kept. This object is not thread safe.
+*/
+func NewChunkedTrans(gm *Manager, maxOps int, maxBytes int64) ChunkedTrans {
+	trans := newInternalGraphTrans(gm)
+
+	idCounterLock.Lock()
+	defer idCounterLock.Unlock()
+
+	idCounter++
+
+	return &chunkedTrans{fmt.Sprint(idCounter), gm, trans, maxOps, maxBytes,
+		0, 0, 0, 0, 0, 0, make(map[int]*rollingSavepoint)}
+}
+
+/*
+chunkedTrans is a transaction which commits itself in chunks.
+*/
+type chunkedTrans struct {
+	id string   // ID of this transaction
+	gm *Manager // Graph manager which created this transaction
+
+	currentTrans *baseTrans // Current chunk
+	maxOps       int        // Number of nodes and edges after which a chunk is committed
+	maxBytes     int64      // Estimated size in bytes after which a chunk is committed
+
+	chunks       int // Number of committed chunks
+	countNodeIns int // Count for inserted nodes of committed chunks
+	countNodeRem int // Count for removed nodes of committed chunks
+	countEdgeIns int // Count for inserted edges of committed chunks
+	countEdgeRem int // Count for removed edges of committed chunks
+
+	savepointCounter int                       // Counter for savepoint IDs
+	savepoints       map[int]*rollingSavepoint // Savepoints of the current chunk
+}
+
+/*
+ID returns a unique transaction ID.
+*/
+func (gt *chunkedTrans) ID() string {
+	return gt.id
+}
+
+/*
+String returns a string representation of this transatction.
+*/
+func (gt *chunkedTrans) String() string {
+	ns, es, nr, er := gt.Counts()
+
+	return fmt.Sprintf("Chunked transaction %v - Nodes: I:%v R:%v - "+
+		"Edges: I:%v R:%v - Chunks: %v", gt.id, ns, nr, es, er, gt.chunks)
+}
+
+/*
+Counts returns the transaction size in terms of objects. Returned values
+are nodes to store, edges to store, nodes to remove and edges to remove.
+The counts include all committed chunks.
+*/
+func (gt *chunkedTrans) Counts() (int, int, int, int) {
+	ns, es, nr, er := gt.currentTrans.Counts()
+
+	return ns + gt.countNodeIns, es + gt.countEdgeIns,
+		nr + gt.countNodeRem, er + gt.countEdgeRem
+}
+
+/*
+IsEmpty returns if this transaction is empty.
+*/
+func (gt *chunkedTrans) IsEmpty() bool {
+	sn, se, rn, re := gt.Counts()
+
+	return sn == 0 && se == 0 && rn == 0 && re == 0
+}
+
+/*
+Chunks returns the number of chunks which have been committed.
+*/
+func (gt *chunkedTrans) Chunks() int {
+	return gt.chunks
+}
+
+/*
+Commit writes the current chunk to the graph database.
+*/
+func (gt *chunkedTrans) Commit() error {
+	return gt.CommitContext(context.Background())
+}
+
+/*
+CommitContext writes the current chunk to the graph database like Commit. The
+commit of the chunk is aborted and rolled back if the given context is done
+before all changes have been written.
+*/
+func (gt *chunkedTrans) CommitContext(ctx context.Context) error {
+	return gt.commitChunk(ctx)
+}
+
+/*
+commitChunk commits the current chunk and starts a new one.
+*/
+func (gt *chunkedTrans) commitChunk(ctx context.Context) error {
+
+	if gt.currentTrans.IsEmpty() {
+		return nil
+	}
+
+	ns, es, nr, er := gt.currentTrans.Counts()
+
+	// Savepoints of the committed chunk cannot be used anymore
+
+	gt.savepoints = make(map[int]*rollingSavepoint)
+
+	if err := gt.currentTrans.CommitContext(ctx); err != nil {
+		return err
+	}
+
+	gt.chunks++
+	gt.countNodeIns += ns
+	gt.countEdgeIns += es
+	gt.countNodeRem += nr
+	gt.countEdgeRem += er
+
+	return nil
+}
+
+/*
+apply runs an operation on the current chunk. The current chunk is committed
+and the operation is retried if the operation exceeds the hard limits of the
+graph manager. The current chunk is committed if it is full after the operation.
+*/
+func (gt *chunkedTrans) apply(op func(trans *baseTrans) error) error {
+	err := op(gt.currentTrans)
+
+	if gerr, ok := err.(*util.GraphError); ok && gerr.Type == util.ErrTransLimit &&
+		!gt.currentTrans.IsEmpty() {
+
+		if err = gt.commitChunk(context.Background()); err == nil {
+			err = op(gt.currentTrans)
+		}
+	}
+
+	if err == nil {
+		ns, es, nr, er := gt.currentTrans.Counts()
+
+		if (gt.maxOps > 0 && ns+es+nr+er >= gt.maxOps) ||
+			(gt.maxBytes > 0 && gt.currentTrans.size >= gt.maxBytes) {
+
+			err = gt.commitChunk(context.Background())
+		}
+	}
+
+	return err
+}
+
+/*
+StoreNode stores a single node in a partition of the graph. This function will
+overwrites any existing node.
+*/
+func (gt *chunkedTrans) StoreNode(part string, node data.Node) error {
+	return gt.apply(func(trans *baseTrans) error {
+		return trans.StoreNode(part, node)
+	})
+}
+
+/*
+UpdateNode updates a single node in a partition of the graph. This function will
+only update the given values of the node.
+*/
+func (gt *chunkedTrans) UpdateNode(part string, node data.Node) error {
+	return gt.apply(func(trans *baseTrans) error {
+		return trans.UpdateNode(part, node)
+	})
+}
+
+/*
+RemoveNode removes a single node from a partition of the graph.
+*/
+func (gt *chunkedTrans) RemoveNode(part string, nkey string, nkind string) error {
+	return gt.apply(func(trans *baseTrans) error {
+		return trans.RemoveNode(part, nkey, nkind)
+	})
+}
+
+/*
+StoreEdge stores a single edge in a partition of the graph. This function will
+overwrites any existing edge.
+*/
+func (gt *chunkedTrans) StoreEdge(part string, edge data.Edge) error {
+	return gt.apply(func(trans *baseTrans) error {
+		return trans.StoreEdge(part, edge)
+	})
+}
+
+/*
+RemoveEdge removes a single edge from a partition of the graph.
+*/
+func (gt *chunkedTrans) RemoveEdge(part string, ekey string, ekind string) error {
+	return gt.apply(func(trans *baseTrans) error {
+		return trans.RemoveEdge(part, ekey, ekind)
+	})
+}
+
+/*
+Savepoint marks the current state of the current chunk and returns the ID of
+the savepoint. A savepoint is released once its chunk is committed.
+*/
+func (gt *chunkedTrans) Savepoint() int {
+	gt.savepointCounter++
+
+	gt.savepoints[gt.savepointCounter] = &rollingSavepoint{gt.currentTrans, gt.currentTrans.Savepoint()}
+
+	return gt.savepointCounter
+}
+
+/*
+RollbackTo discards all changes which were added to the current chunk after a
+given savepoint. Fails if the chunk of the savepoint has already been committed.
+*/
+func (gt *chunkedTrans) RollbackTo(savepoint int) error {
+	sp, ok := gt.savepoints[savepoint]
+
+	if !ok {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprint("Unknown savepoint: ", savepoint)}
+	}
+
+	// Release all later savepoints
+
+	for id := range gt.savepoints {
+		if id > savepoint {
+			delete(gt.savepoints, id)
+		}
+	}
+
+	return sp.trans.RollbackTo(sp.id)
+}
@@ -0,0 +1,255 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+func TestTransLimits(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	newNode := func(key string, val string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "item")
+		node.SetAttr("val", val)
+		return node
+	}
+
+	if res := estimateSize(map[string]interface{}{
+		"a": "abc",
+		"b": []interface{}{1, true, []byte("xy")},
+		"c": 1.5,
+		"d": []string{"x", "yz"},
+		"e": struct{ X int }{42},
+	}); res != 34 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.SetTransLimits(3, 60)
+
+	if maxOps, maxBytes := gm.TransLimits(); maxOps != 3 || maxBytes != 60 {
+		t.Error("Unexpected result:", maxOps, maxBytes)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+
+	trans.StoreNode("main", newNode("1", "a"))
+	trans.StoreNode("main", newNode("2", "b"))
+	trans.RemoveNode("main", "3", "item")
+
+	err := trans.StoreNode("main", newNode("4", "c"))
+
+	if gerr, ok := err.(*util.GraphError); !ok || gerr.Type != util.ErrTransLimit ||
+		err.Error() != "GraphError: Transaction limit exceeded (Transaction "+trans.ID()+
+			" cannot contain more than 3 nodes and edges)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Existing entries can still be changed
+
+	if err := trans.UpdateNode("main", newNode("1", "aa")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = trans.UpdateNode("main", newNode("1", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+
+	if err == nil || err.Error() != "GraphError: Transaction limit exceeded (Transaction "+trans.ID()+
+		" cannot be larger than 60 bytes)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The transaction is still usable
+
+	if res := fmt.Sprint(trans.Counts()); res != "2 0 1 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "1", "item"); n == nil || n.Attr("val") != "aa" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Committed transactions can be filled again
+
+	if err := trans.StoreNode("main", newNode("4", "c")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Savepoints restore the size of a transaction
+
+	sp := trans.Savepoint()
+
+	trans.StoreNode("main", newNode("5", "dddddddddddddddddddd"))
+
+	if err := trans.StoreNode("main", newNode("6", "e")); err == nil {
+		t.Error("Transaction limit should be exceeded")
+		return
+	}
+
+	trans.RollbackTo(sp)
+
+	if err := trans.StoreNode("main", newNode("6", "e")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.SetTransLimits(0, 0)
+
+	if err := trans.StoreNode("main", newNode("7", "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee")); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestChunkedTrans(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	newNode := func(key string, val string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "item")
+		node.SetAttr("val", val)
+		return node
+	}
+
+	trans := NewChunkedTrans(gm, 3, 0)
+
+	for i := 0; i < 7; i++ {
+		if err := trans.StoreNode("main", newNode(fmt.Sprint(i), "a")); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if gm.NodeCount("item") != 6 || trans.Chunks() != 2 ||
+		trans.String() != "Chunked transaction "+trans.ID()+" - Nodes: I:7 R:0 - Edges: I:0 R:0 - Chunks: 2" {
+		t.Error("Unexpected result:", gm.NodeCount("item"), trans)
+		return
+	}
+
+	// Savepoints are released once their chunk is committed
+
+	sp := trans.Savepoint()
+
+	trans.RemoveNode("main", "0", "item")
+
+	if err := trans.RollbackTo(sp); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans.UpdateNode("main", newNode("1", "b"))
+	trans.UpdateNode("main", newNode("2", "b"))
+
+	if err := trans.RollbackTo(sp); err == nil || err.Error() != "GraphError: Invalid data (Unknown savepoint: 1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := trans.Commit(); err != nil || trans.IsEmpty() || trans.Chunks() != 3 {
+		t.Error("Unexpected result:", err, trans)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "1", "item"); n.Attr("val") != "b" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Chunks are committed by size and before they exceed the hard limits
+
+	gm.SetTransLimits(2, 0)
+
+	trans = NewChunkedTrans(gm, 100, 60)
+
+	trans.StoreNode("main", newNode("10", "a"))
+	trans.StoreNode("main", newNode("11", "a"))
+
+	if trans.Chunks() != 0 {
+		t.Error("Unexpected result:", trans)
+		return
+	}
+
+	trans.StoreNode("main", newNode("12", "a"))
+
+	if trans.Chunks() != 1 {
+		t.Error("Unexpected result:", trans)
+		return
+	}
+
+	trans.StoreNode("main", newNode("13", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+
+	if trans.Chunks() != 2 {
+		t.Error("Unexpected result:", trans)
+		return
+	}
+
+	if err := trans.Commit(); err != nil || gm.NodeCount("item") != 11 || trans.Chunks() != 2 {
+		t.Error("Unexpected result:", err, gm.NodeCount("item"), trans)
+		return
+	}
+
+	// Failed chunks are discarded
+
+	trans = NewChunkedTrans(gm, 2, 0)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "link")
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "item")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "99")
+	edge.SetAttr(data.EdgeEnd2Kind, "item")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	trans.StoreNode("main", newNode("20", "a"))
+
+	if err := trans.StoreEdge("main", edge); err == nil {
+		t.Error("Commit should fail")
+		return
+	}
+
+	if res := trans.String(); res != "Chunked transaction "+trans.ID()+" - Nodes: I:0 R:0 - Edges: I:0 R:0 - Chunks: 0" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans.StoreNode("main", newNode("99", "a"))
+	trans.StoreEdge("main", edge)
+	trans.RemoveEdge("main", "e1", "link")
+
+	if err := trans.Commit(); err != nil || trans.Chunks() != 2 || gm.EdgeCount("link") != 0 {
+		t.Error("Unexpected result:", err, trans, gm.EdgeCount("link"))
+		return
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/krotik/common/stringutil"
+)
+
+/*
+Available tokenizers
+*/
+const (
+	TokenizerStandard   = "standard"   // Split on whitespace, control and punctuation characters
+	TokenizerWhitespace = "whitespace" // Split only on whitespace
+)
+
+/*
+MaxGramSize is the maximum size of n-grams produced by an analyzer.
+*/
+const MaxGramSize = 10
+
+/*
+Analyzer describes how the full-text index extracts words from an attribute
+value. Queries against an attribute are analyzed in the same way.
+*/
+type Analyzer struct {
+	Tokenizer         string   `json:"tokenizer,omitempty"`         // Tokenizer which splits text into words
+	Lowercase         bool     `json:"lowercase"`                   // Flag if all words should be lowercased
+	Language          string   `json:"language,omitempty"`          // Language for stemming and stop words
+	Stemming          bool     `json:"stemming,omitempty"`          // Flag if words should be reduced to their stem
+	LanguageStopWords bool     `json:"languagestopwords,omitempty"` // Flag if stop words of the language are removed
+	StopWords         []string `json:"stopwords,omitempty"`         // Additional stop words which are removed
+	MinGram           int      `json:"mingram,omitempty"`           // Minimum n-gram size (0 disables n-grams)
+	MaxGram           int      `json:"maxgram,omitempty"`           // Maximum n-gram size
+}
+
+/*
+AnalyzerLanguages returns all languages which are supported by analyzers.
+*/
+func AnalyzerLanguages() []string {
+	var ret []string
+
+	for lang := range stemmers {
+		ret = append(ret, lang)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+Validate checks that the analyzer configuration is valid.
+*/
+func (a *Analyzer) Validate() error {
+
+	if a.Tokenizer != "" && a.Tokenizer != TokenizerStandard && a.Tokenizer != TokenizerWhitespace {
+		return &GraphError{ErrInvalidData, fmt.Sprintf("Unknown tokenizer: %v", a.Tokenizer)}
+	}
+
+	if _, ok := stemmers[a.Language]; a.Language != "" && !ok {
+		return &GraphError{ErrInvalidData, fmt.Sprintf("Unknown language: %v (supported languages are %v)",
+			a.Language, strings.Join(AnalyzerLanguages(), ", "))}
+	}
+
+	if (a.Stemming || a.LanguageStopWords) && a.Language == "" {
+		return &GraphError{ErrInvalidData, "Stemming and language stop words need a language"}
+	}
+
+	if a.MinGram < 0 || a.MaxGram < a.MinGram || a.MaxGram > MaxGramSize || (a.MinGram == 0) != (a.MaxGram == 0) {
+		return &GraphError{ErrInvalidData, fmt.Sprintf("N-gram sizes must be between 1 and %v "+
+			"(minimum must not be larger than maximum)", MaxGramSize)}
+	}
+
+	return nil
+}
+
+/*
+Words splits a given text into normalized words. Stop words are removed and
+the positions of all following words are moved up.
+*/
+func (a *Analyzer) Words(text string) []string {
+	var ret []string
+
+	split := func(r rune) bool {
+		return !stringutil.IsAlphaNumeric(string(r)) && (unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r))
+	}
+
+	if a.Tokenizer == TokenizerWhitespace {
+		split = unicode.IsSpace
+	}
+
+	for _, word := range strings.FieldsFunc(text, split) {
+
+		if a.Lowercase {
+			word = strings.ToLower(word)
+		}
+
+		if a.isStopWord(word) {
+			continue
+		}
+
+		if a.Stemming {
+			word = stemmers[a.Language](word)
+		}
+
+		ret = append(ret, word)
+	}
+
+	return ret
+}
+
+/*
+isStopWord checks if a given word is a stop word.
+*/
+func (a *Analyzer) isStopWord(word string) bool {
+
+	if a.LanguageStopWords && stopWords[a.Language][strings.ToLower(word)] {
+		return true
+	}
+
+	for _, stopWord := range a.StopWords {
+		if strings.EqualFold(stopWord, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+extractWords extracts all words and their positions from a given string. All
+n-grams of a word are stored at the position of the word.
+*/
+func (a *Analyzer) extractWords(s string) *wordSet {
+	ws := newWordSet(4)
+
+	for i, word := range a.Words(s) {
+		pos := uint64(i + 1)
+
+		ws.Add(word, pos)
+
+		if a.MaxGram > 0 {
+			runes := []rune(word)
+
+			for size := a.MinGram; size <= a.MaxGram && size < len(runes); size++ {
+				for start := 0; start+size <= len(runes); start++ {
+					ws.Add(string(runes[start:start+size]), pos)
+				}
+			}
+		}
+	}
+
+	return ws
+}
+
+/*
+stopWords are the stop words of all supported languages.
+*/
+var stopWords = map[string]map[string]bool{
+	"english": wordMap("a an and are as at be but by for if in into is it no not of on or " +
+		"such that the their then there these they this to was will with"),
+	"german": wordMap("aber als am an auch auf aus bei bin bis da das dass dem den der des die " +
+		"doch du ein eine einem einen einer eines er es fur für hat ich ihr im in ist ja " +
+		"mit nach nicht noch oder sie sind so und von vor war was wie wir zu zum zur"),
+	"french": wordMap("au aux avec ce ces dans de des du elle en et eux il je la le les leur " +
+		"lui ma mais me meme même mes moi mon ne nos notre nous on ou par pas pour qu que " +
+		"qui sa se ses son sur ta te tes toi ton tu un une vos votre vous"),
+	"spanish": wordMap("a al algo como con de del el ella ellos en entre era es esta este " +
+		"la las le les lo los mas más me mi no nos o para pero por que se si sin sobre su " +
+		"sus te tu un una uno y ya"),
+}
+
+/*
+wordMap creates a lookup map from a space separated list of words.
+*/
+func wordMap(words string) map[string]bool {
+	ret := make(map[string]bool)
+
+	for _, word := range strings.Fields(words) {
+		ret[word] = true
+	}
+
+	return ret
+}
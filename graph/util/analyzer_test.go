@@ -0,0 +1,199 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestAnalyzerValidate(t *testing.T) {
+
+	for _, test := range []struct {
+		a   *Analyzer
+		res string
+	}{
+		{&Analyzer{}, "<nil>"},
+		{&Analyzer{Tokenizer: "whitespace", Language: "german", Stemming: true, MinGram: 2, MaxGram: 3}, "<nil>"},
+		{&Analyzer{Tokenizer: "foo"}, "GraphError: Invalid data (Unknown tokenizer: foo)"},
+		{&Analyzer{Language: "klingon"}, "GraphError: Invalid data (Unknown language: klingon (supported languages are english, french, german, spanish))"},
+		{&Analyzer{Stemming: true}, "GraphError: Invalid data (Stemming and language stop words need a language)"},
+		{&Analyzer{LanguageStopWords: true}, "GraphError: Invalid data (Stemming and language stop words need a language)"},
+		{&Analyzer{MinGram: 3, MaxGram: 2}, "GraphError: Invalid data (N-gram sizes must be between 1 and 10 (minimum must not be larger than maximum))"},
+		{&Analyzer{MaxGram: 2}, "GraphError: Invalid data (N-gram sizes must be between 1 and 10 (minimum must not be larger than maximum))"},
+		{&Analyzer{MinGram: 1, MaxGram: 11}, "GraphError: Invalid data (N-gram sizes must be between 1 and 10 (minimum must not be larger than maximum))"},
+	} {
+		if res := fmt.Sprint(test.a.Validate()); res != test.res {
+			t.Error("Unexpected result:", test.a, res)
+			return
+		}
+	}
+}
+
+func TestAnalyzerWords(t *testing.T) {
+
+	for _, test := range []struct {
+		a    *Analyzer
+		text string
+		res  string
+	}{
+		{&Analyzer{}, "The Quick-brown fox", "[The Quick brown fox]"},
+		{&Analyzer{Tokenizer: "whitespace", Lowercase: true}, "The Quick-brown fox.", "[the quick-brown fox.]"},
+		{&Analyzer{Lowercase: true, Language: "english", LanguageStopWords: true}, "The fox and THE dog", "[fox dog]"},
+		{&Analyzer{Lowercase: true, StopWords: []string{"FOX"}}, "The fox and the dog", "[the and the dog]"},
+		{&Analyzer{Lowercase: true, Language: "english", Stemming: true}, "Running foxes jumped quickly over ponies", "[run fox jump quick over pony]"},
+		{&Analyzer{Lowercase: true, Language: "german", Stemming: true, LanguageStopWords: true}, "Die Häuser der Lehrerinnen", "[haus lehrerin]"},
+	} {
+		if res := fmt.Sprint(test.a.Words(test.text)); res != test.res {
+			t.Error("Unexpected result:", test.text, res)
+			return
+		}
+	}
+
+	a := &Analyzer{Lowercase: true, MinGram: 2, MaxGram: 3}
+
+	if res := fmt.Sprint(a.extractWords("Hello world").set); res != "map[el:[1] ell:[1] he:[1] hel:[1] hello:[1] ld:[2] ll:[1] llo:[1] lo:[1] or:[2] orl:[2] rl:[2] rld:[2] wo:[2] wor:[2] world:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestStemmers(t *testing.T) {
+
+	for _, test := range []struct {
+		lang string
+		word string
+		res  string
+	}{
+		{"english", "cat", "cat"},
+		{"english", "cats", "cat"},
+		{"english", "classes", "class"},
+		{"english", "boxes", "box"},
+		{"english", "stories", "story"},
+		{"english", "status", "status"},
+		{"english", "hopping", "hop"},
+		{"english", "falling", "fall"},
+		{"english", "string", "string"},
+		{"english", "jumped", "jump"},
+		{"english", "surprisingly", "surpris"},
+		{"german", "Bücher", "Buch"},
+		{"german", "hunde", "hund"},
+		{"german", "auto", "auto"},
+		{"german", "katzen", "katz"},
+		{"french", "chevaux", "cheval"},
+		{"french", "maisons", "maison"},
+		{"french", "petite", "petit"},
+		{"french", "belles", "bel"},
+		{"french", "chat", "chat"},
+		{"spanish", "ciudades", "ciudad"},
+		{"spanish", "luces", "luz"},
+		{"spanish", "perros", "perr"},
+		{"spanish", "mesa", "mesa"},
+		{"spanish", "intereses", "interes"},
+		{"spanish", "canción", "cancion"},
+	} {
+		if res := stemmers[test.lang](test.word); res != test.res {
+			t.Error("Unexpected result:", test.lang, test.word, res)
+			return
+		}
+	}
+}
+
+func TestIndexManagerAnalyzers(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+	im.SetAnalyzers(map[string]*Analyzer{
+		"text": {Lowercase: true, Language: "english", Stemming: true, LanguageStopWords: true},
+		"code": {Tokenizer: TokenizerWhitespace},
+		"name": {Lowercase: true, MinGram: 3, MaxGram: 4},
+	})
+
+	im.Index("1", map[string]string{"text": "The foxes are jumping over the dogs", "code": "Foo.Bar baz", "name": "Marmalade"})
+	im.Index("2", map[string]string{"text": "A fox jumped", "code": "foo.bar"})
+
+	if res, _ := im.LookupWord("text", "Fox"); fmt.Sprint(res) != "map[1:[1] 2:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupWord("text", "the"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.Count("text", "jumps"); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.Count("text", "the"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Stop words are removed from phrases
+
+	if res, _ := im.LookupPhrase("text", "fox jumps"); fmt.Sprint(res) != "[1 2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupPhrase("text", "jumping over the dog"); fmt.Sprint(res) != "[1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Case sensitive attributes
+
+	if res, _ := im.LookupWord("code", "Foo.Bar"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupPrefix("code", "foo"); fmt.Sprint(res[0]) != "&{2 foo.bar 4 1}" || len(res) != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// N-grams
+
+	if res, _ := im.LookupWord("name", "MALA"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupWord("name", "ma"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Word statistics count words and not n-grams
+
+	if res, _ := im.attrStats("name", "1"); fmt.Sprint(res) != "[1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removing data removes all words
+
+	im.Deindex("1", map[string]string{"text": "The foxes are jumping over the dogs", "code": "Foo.Bar baz", "name": "Marmalade"})
+	im.Deindex("2", map[string]string{"text": "A fox jumped", "code": "foo.bar"})
+
+	if it := hash.NewHTreeIterator(htree); it.HasNext() {
+		t.Error("Unexpected result:", im.String())
+		return
+	}
+}
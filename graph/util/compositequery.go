@@ -0,0 +1,212 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+IndexTerm is a term of a composite index query. A term either combines a list
+of other terms (And or Or) or looks up a word, a value or a phrase in a single
+attribute.
+*/
+type IndexTerm struct {
+	And    []*IndexTerm `json:"and,omitempty"`    // All terms must match
+	Or     []*IndexTerm `json:"or,omitempty"`     // At least one term must match
+	Attr   string       `json:"attr,omitempty"`   // Attribute to search
+	Word   string       `json:"word,omitempty"`   // Word which the attribute must contain
+	Value  string       `json:"value,omitempty"`  // Value which the attribute must have
+	Phrase string       `json:"phrase,omitempty"` // Phrase which the attribute must contain
+}
+
+/*
+Validate checks that a term and all its subterms are well-formed.
+*/
+func (t *IndexTerm) Validate() error {
+	var lookups int
+
+	for _, s := range []string{t.Word, t.Value, t.Phrase} {
+		if s != "" {
+			lookups++
+		}
+	}
+
+	switch {
+	case t.And != nil && t.Or != nil:
+		return &GraphError{ErrInvalidData, "Index term cannot have and and or terms"}
+
+	case t.And != nil || t.Or != nil:
+		if t.Attr != "" || lookups != 0 {
+			return &GraphError{ErrInvalidData, "Index term cannot have and or or terms and a lookup"}
+		}
+
+		terms := t.And
+		if terms == nil {
+			terms = t.Or
+		}
+
+		if len(terms) == 0 {
+			return &GraphError{ErrInvalidData, "Index term has an empty list of terms"}
+		}
+
+		for _, term := range terms {
+			if term == nil {
+				return &GraphError{ErrInvalidData, "Index term has an empty list of terms"}
+			} else if err := term.Validate(); err != nil {
+				return err
+			}
+		}
+
+	case t.Attr == "":
+		return &GraphError{ErrInvalidData, "Index term needs either and or or terms or an attribute"}
+
+	case lookups != 1:
+		return &GraphError{ErrInvalidData,
+			fmt.Sprintf("Index term for attribute %v needs exactly one of word, value or phrase", t.Attr)}
+	}
+
+	return nil
+}
+
+/*
+LookupComposite finds all nodes which match a composite index query. The terms
+are evaluated by intersecting (and) or merging (or) the key lists of all lookups.
+This call returns a sorted list of node keys.
+*/
+func (im *IndexManager) LookupComposite(term *IndexTerm) ([]string, error) {
+
+	if term == nil {
+		return nil, &GraphError{ErrInvalidData, "Index term needs either and or or terms or an attribute"}
+	}
+
+	if err := term.Validate(); err != nil {
+		return nil, err
+	}
+
+	return im.lookupTerm(term)
+}
+
+/*
+lookupTerm evaluates a validated index term and returns a sorted list of keys.
+*/
+func (im *IndexManager) lookupTerm(term *IndexTerm) ([]string, error) {
+	var ret []string
+	var err error
+
+	switch {
+	case term.And != nil:
+
+		// Stop as soon as the intersection is empty
+
+		for i, t := range term.And {
+			var keys []string
+
+			if keys, err = im.lookupTerm(t); err != nil {
+				return nil, err
+			}
+
+			if i == 0 {
+				ret = keys
+			} else {
+				ret = intersectKeys(ret, keys)
+			}
+
+			if len(ret) == 0 {
+				return nil, nil
+			}
+		}
+
+	case term.Or != nil:
+
+		for _, t := range term.Or {
+			var keys []string
+
+			if keys, err = im.lookupTerm(t); err != nil {
+				return nil, err
+			}
+
+			ret = mergeKeys(ret, keys)
+		}
+
+	case term.Word != "":
+		var res map[string][]uint64
+
+		if res, err = im.LookupWord(term.Attr, term.Word); err == nil {
+			for key := range res {
+				ret = append(ret, key)
+			}
+			sort.Strings(ret)
+		}
+
+	case term.Value != "":
+		if ret, err = im.LookupValue(term.Attr, term.Value); err == nil {
+			sort.Strings(ret)
+		}
+
+	default:
+
+		// Phrase lookups return a sorted list
+
+		ret, err = im.LookupPhrase(term.Attr, term.Phrase)
+	}
+
+	return ret, err
+}
+
+/*
+intersectKeys returns all keys which are in both given sorted lists.
+*/
+func intersectKeys(keys1 []string, keys2 []string) []string {
+	var ret []string
+
+	for i, j := 0, 0; i < len(keys1) && j < len(keys2); {
+		if keys1[i] == keys2[j] {
+			ret = append(ret, keys1[i])
+			i++
+			j++
+		} else if keys1[i] < keys2[j] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return ret
+}
+
+/*
+mergeKeys returns all keys which are in either of two given sorted lists.
+*/
+func mergeKeys(keys1 []string, keys2 []string) []string {
+	ret := make([]string, 0, len(keys1)+len(keys2))
+
+	i, j := 0, 0
+
+	for i < len(keys1) && j < len(keys2) {
+		if keys1[i] == keys2[j] {
+			ret = append(ret, keys1[i])
+			i++
+			j++
+		} else if keys1[i] < keys2[j] {
+			ret = append(ret, keys1[i])
+			i++
+		} else {
+			ret = append(ret, keys2[j])
+			j++
+		}
+	}
+
+	ret = append(ret, keys1[i:]...)
+
+	return append(ret, keys2[j:]...)
+}
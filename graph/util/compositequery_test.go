@@ -0,0 +1,123 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestLookupComposite(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+
+	im.Index("1", map[string]string{"name": "Red apple", "color": "red", "desc": "A sweet red fruit"})
+	im.Index("2", map[string]string{"name": "Green apple", "color": "green", "desc": "A sour green fruit"})
+	im.Index("3", map[string]string{"name": "Cherry", "color": "red", "desc": "A small sweet fruit"})
+	im.Index("4", map[string]string{"name": "Tomato", "color": "red", "desc": "Not a sweet red fruit"})
+
+	lookup := func(query string) string {
+		var term IndexTerm
+
+		if err := json.Unmarshal([]byte(query), &term); err != nil {
+			return err.Error()
+		}
+
+		res, err := im.LookupComposite(&term)
+		if err != nil {
+			return err.Error()
+		}
+
+		return fmt.Sprint(res)
+	}
+
+	for _, test := range []struct {
+		query string
+		res   string
+	}{
+		{`{"attr": "name", "word": "apple"}`, "[1 2]"},
+		{`{"attr": "color", "value": "RED"}`, "[1 3 4]"},
+		{`{"attr": "desc", "phrase": "sweet red fruit"}`, "[1 4]"},
+		{`{"and": [{"attr": "name", "word": "apple"}, {"attr": "color", "value": "red"}]}`, "[1]"},
+		{`{"and": [{"attr": "name", "word": "foo"}, {"attr": "color", "value": "red"}]}`, "[]"},
+		{`{"or": [{"attr": "name", "word": "apple"}, {"attr": "desc", "word": "small"}]}`, "[1 2 3]"},
+		{`{"or": [{"attr": "name", "word": "foo"}, {"attr": "desc", "word": "bar"}]}`, "[]"},
+		{`{"and": [
+			{"attr": "color", "value": "red"},
+			{"or": [{"attr": "desc", "phrase": "sweet red"}, {"attr": "name", "word": "cherry"}]},
+			{"or": [{"attr": "name", "word": "apple"}, {"attr": "name", "word": "tomato"}]}
+		]}`, "[1 4]"},
+
+		// Error cases
+
+		{`{}`, "GraphError: Invalid data (Index term needs either and or or terms or an attribute)"},
+		{`{"and": []}`, "GraphError: Invalid data (Index term has an empty list of terms)"},
+		{`{"or": [null]}`, "GraphError: Invalid data (Index term has an empty list of terms)"},
+		{`{"and": [{"attr": "a", "word": "b"}], "or": [{"attr": "a", "word": "b"}]}`,
+			"GraphError: Invalid data (Index term cannot have and and or terms)"},
+		{`{"and": [{"attr": "a", "word": "b"}], "attr": "a"}`,
+			"GraphError: Invalid data (Index term cannot have and or or terms and a lookup)"},
+		{`{"attr": "a"}`, "GraphError: Invalid data (Index term for attribute a needs exactly one of word, value or phrase)"},
+		{`{"or": [{"attr": "a", "word": "b", "value": "c"}]}`,
+			"GraphError: Invalid data (Index term for attribute a needs exactly one of word, value or phrase)"},
+	} {
+		if res := lookup(test.query); res != test.res {
+			t.Error("Unexpected result:", test.query, res)
+			return
+		}
+	}
+
+	if _, err := im.LookupComposite(nil); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	// Lookup errors are returned
+
+	for i := 0; i < 100; i++ {
+		sm.AccessMap[uint64(i)] = storage.AccessCacheAndFetchError
+	}
+
+	if res := lookup(`{"or": [{"attr": "name", "word": "apple"}]}`); !strings.HasPrefix(res, "GraphError: Index error") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(`{"and": [{"attr": "color", "value": "red"}]}`); !strings.HasPrefix(res, "GraphError: Index error") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestIntersectMergeKeys(t *testing.T) {
+
+	if res := fmt.Sprint(intersectKeys([]string{"a", "c", "d", "f"}, []string{"b", "c", "f", "g"})); res != "[c f]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(mergeKeys([]string{"a", "c", "d", "f"}, []string{"b", "c", "f", "g"})); res != "[a b c d f g]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(mergeKeys(nil, []string{"b"}), intersectKeys(nil, []string{"b"})); res != "[b] []" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
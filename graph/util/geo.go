@@ -0,0 +1,403 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+PrefixAttrGeo is the prefix used for geo index entries
+*/
+const PrefixAttrGeo = "\x02"
+
+/*
+GeoIndexPrecision is the maximum geohash length which is stored in the geo
+index. Each geo value is stored for every geohash length up to this precision.
+*/
+const GeoIndexPrecision = 6
+
+/*
+GeoMaxCells is the maximum number of geohash cells which are looked up for a
+single geo query.
+*/
+const GeoMaxCells = 64
+
+/*
+EarthRadius is the mean earth radius in km.
+*/
+const EarthRadius = 6371.0088
+
+/*
+geohashBase32 is the alphabet used for geohashes.
+*/
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+/*
+GeoHash returns the geohash of a given location with a given length.
+*/
+func GeoHash(lat float64, lon float64, length int) string {
+	var buf strings.Builder
+
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	bit, ch, even := 0, 0, true
+
+	for buf.Len() < length {
+
+		if even {
+			if mid := (minLon + maxLon) / 2; lon >= mid {
+				ch |= 1 << uint(4-bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			if mid := (minLat + maxLat) / 2; lat >= mid {
+				ch |= 1 << uint(4-bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+
+		even = !even
+
+		if bit < 4 {
+			bit++
+		} else {
+			buf.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return buf.String()
+}
+
+/*
+geoCellSize returns the height (latitude) and width (longitude) in degrees
+of the geohash cells of a given length.
+*/
+func geoCellSize(length int) (float64, float64) {
+	bits := uint(5 * length)
+	return 180 / math.Pow(2, float64(bits/2)), 360 / math.Pow(2, float64(bits-bits/2))
+}
+
+/*
+GeoDistance returns the great-circle distance in km between two locations.
+*/
+func GeoDistance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	rad := math.Pi / 180
+
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+/*
+ParseGeoPoint parses a location from a JSON object string with numeric lat and
+lon values (e.g. {"lat":52.52,"lon":13.40}). This is the index representation
+of geo attribute values.
+*/
+func ParseGeoPoint(s string) (float64, float64, bool) {
+	var p struct {
+		Lat *float64 `json:"lat"`
+		Lon *float64 `json:"lon"`
+	}
+
+	if !strings.HasPrefix(s, "{") || !strings.Contains(s, `"lat"`) {
+		return 0, 0, false
+	}
+
+	if err := json.Unmarshal([]byte(s), &p); err != nil || p.Lat == nil || p.Lon == nil ||
+		*p.Lat < -90 || *p.Lat > 90 || *p.Lon < -180 || *p.Lon > 180 {
+		return 0, 0, false
+	}
+
+	return *p.Lat, *p.Lon, true
+}
+
+/*
+LookupGeoBBox finds all nodes where an attribute holds a location inside a
+given bounding box. This call returns a sorted list of node keys.
+*/
+func (im *IndexManager) LookupGeoBBox(attr string, minLat, minLon, maxLat, maxLon float64) ([]string, error) {
+
+	points, err := im.lookupGeo(attr, minLat, minLon, maxLat, maxLon)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(points))
+
+	for key, p := range points {
+		if p[0] >= minLat && p[0] <= maxLat && p[1] >= minLon && p[1] <= maxLon {
+			ret = append(ret, key)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+/*
+LookupGeoRadius finds all nodes where an attribute holds a location within a
+given radius (in km) of a given location. This call returns a list of node
+keys ordered by distance.
+*/
+func (im *IndexManager) LookupGeoRadius(attr string, lat, lon, radius float64) ([]string, error) {
+
+	// Calculate the bounding box of the search circle
+
+	dLat := radius / (EarthRadius * math.Pi / 180)
+	dLon := 360.0
+
+	if cos := math.Cos(lat * math.Pi / 180); cos > 1e-9 {
+		dLon = math.Min(360, dLat/cos)
+	}
+
+	points, err := im.lookupGeo(attr, lat-dLat, lon-dLon, lat+dLat, lon+dLon)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(points))
+	dist := make(map[string]float64)
+
+	for key, p := range points {
+		if d := GeoDistance(lat, lon, p[0], p[1]); d <= radius {
+			ret = append(ret, key)
+			dist[key] = d
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if dist[ret[i]] == dist[ret[j]] {
+			return ret[i] < ret[j]
+		}
+		return dist[ret[i]] < dist[ret[j]]
+	})
+
+	return ret, nil
+}
+
+/*
+lookupGeo collects the locations of all index entries in the geohash cells
+which cover a given bounding box. Bounding boxes which cross the 180th
+meridian are split.
+*/
+func (im *IndexManager) lookupGeo(attr string, minLat, minLon, maxLat, maxLon float64) (map[string][2]float64, error) {
+
+	if minLat > maxLat || minLon > maxLon {
+		return nil, &GraphError{ErrInvalidData, "Invalid bounding box"}
+	}
+
+	minLat, maxLat = math.Max(minLat, -90), math.Min(maxLat, 90)
+
+	if maxLon-minLon >= 360 {
+		minLon, maxLon = -180, 180
+	} else if minLon < -180 {
+		return im.lookupGeoSplit(attr, minLat, maxLat, minLon+360, 180, -180, maxLon)
+	} else if maxLon > 180 {
+		return im.lookupGeoSplit(attr, minLat, maxLat, minLon, 180, -180, maxLon-360)
+	}
+
+	// Find the longest geohash length which needs only a few cells
+
+	length := 0
+	var height, width float64
+
+	for l := GeoIndexPrecision; l > 0; l-- {
+		height, width = geoCellSize(l)
+
+		if cells := (math.Floor((maxLat+90)/height) - math.Floor((minLat+90)/height) + 1) *
+			(math.Floor((maxLon+180)/width) - math.Floor((minLon+180)/width) + 1); cells <= GeoMaxCells || l == 1 {
+			length = l
+			break
+		}
+	}
+
+	cell := func(v float64, origin float64, size float64, max float64) int {
+		return int(math.Min(math.Floor((v+origin)/size), max/size-1))
+	}
+
+	ret := make(map[string][2]float64)
+
+	for i := cell(minLat, 90, height, 180); i <= cell(maxLat, 90, height, 180); i++ {
+		for j := cell(minLon, 180, width, 360); j <= cell(maxLon, 180, width, 360); j++ {
+
+			hash := GeoHash(-90+(float64(i)+0.5)*height, -180+(float64(j)+0.5)*width, length)
+
+			obj, err := im.htree.Get([]byte(PrefixAttrGeo + attr + "\x00" + hash))
+			if err != nil {
+				return nil, &GraphError{ErrIndexError, err.Error()}
+			} else if obj == nil {
+				continue
+			}
+
+			for key, pos := range obj.(*indexEntry).WordPos {
+				if lat, lon, ok := parseGeoPos(pos); ok {
+					ret[key] = [2]float64{lat, lon}
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+lookupGeoSplit looks up two longitude ranges and merges the results.
+*/
+func (im *IndexManager) lookupGeoSplit(attr string, minLat, maxLat, minLon1, maxLon1,
+	minLon2, maxLon2 float64) (map[string][2]float64, error) {
+
+	ret, err := im.lookupGeo(attr, minLat, minLon1, maxLat, maxLon1)
+	if err == nil {
+		var ret2 map[string][2]float64
+
+		if ret2, err = im.lookupGeo(attr, minLat, minLon2, maxLat, maxLon2); err == nil {
+			for k, v := range ret2 {
+				ret[k] = v
+			}
+		}
+	}
+
+	return ret, err
+}
+
+/*
+updateGeoEntries updates the geo index entries of an attribute if the old or
+new value is a location.
+*/
+func (im *IndexManager) updateGeoEntries(key string, attr string, newval string, newok bool,
+	oldval string, oldok bool) error {
+
+	var newLat, newLon, oldLat, oldLon float64
+	var newGeo, oldGeo bool
+
+	if newok {
+		newLat, newLon, newGeo = ParseGeoPoint(newval)
+	}
+
+	if oldok {
+		oldLat, oldLon, oldGeo = ParseGeoPoint(oldval)
+	}
+
+	if oldGeo && newGeo && oldLat == newLat && oldLon == newLon {
+		return nil
+	}
+
+	if oldGeo {
+		if err := im.removeGeoEntries(key, attr, oldLat, oldLon); err != nil {
+			return err
+		}
+	}
+
+	if newGeo {
+		return im.addGeoEntries(key, attr, newLat, newLon)
+	}
+
+	return nil
+}
+
+/*
+addGeoEntries adds a location to the geo index.
+*/
+func (im *IndexManager) addGeoEntries(key string, attr string, lat float64, lon float64) error {
+	hash := GeoHash(lat, lon, GeoIndexPrecision)
+	pos := fmt.Sprint(lat, ",", lon)
+
+	for l := 1; l <= GeoIndexPrecision; l++ {
+		var entry *indexEntry
+
+		indexkey := []byte(PrefixAttrGeo + attr + "\x00" + hash[:l])
+
+		obj, err := im.htree.Get(indexkey)
+		if err != nil {
+			return err
+		}
+
+		if obj == nil {
+			entry = &indexEntry{make(map[string]string)}
+		} else {
+			entry = obj.(*indexEntry)
+		}
+
+		entry.WordPos[key] = pos
+
+		if _, err = im.htree.Put(indexkey, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+removeGeoEntries removes a location from the geo index.
+*/
+func (im *IndexManager) removeGeoEntries(key string, attr string, lat float64, lon float64) error {
+	hash := GeoHash(lat, lon, GeoIndexPrecision)
+
+	for l := 1; l <= GeoIndexPrecision; l++ {
+		indexkey := []byte(PrefixAttrGeo + attr + "\x00" + hash[:l])
+
+		obj, err := im.htree.Get(indexkey)
+		if err != nil {
+			return err
+		} else if obj == nil {
+			continue
+		}
+
+		entry := obj.(*indexEntry)
+
+		delete(entry.WordPos, key)
+
+		if len(entry.WordPos) == 0 {
+			_, err = im.htree.Remove(indexkey)
+		} else {
+			_, err = im.htree.Put(indexkey, entry)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+parseGeoPos parses a location which is stored in a geo index entry.
+*/
+func parseGeoPos(pos string) (float64, float64, bool) {
+	i := strings.Index(pos, ",")
+	if i == -1 {
+		return 0, 0, false
+	}
+
+	lat, err1 := strconv.ParseFloat(pos[:i], 64)
+	lon, err2 := strconv.ParseFloat(pos[i+1:], 64)
+
+	return lat, lon, err1 == nil && err2 == nil
+}
@@ -0,0 +1,140 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestGeoHelpers(t *testing.T) {
+
+	if res := GeoHash(57.64911, 10.40744, 11); res != "u4pruydqqvj" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := GeoHash(-90, -180, 3); res != "000" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if h, w := geoCellSize(1); h != 45 || w != 45 {
+		t.Error("Unexpected result:", h, w)
+		return
+	}
+
+	// Berlin - Hamburg is about 255 km
+
+	if res := GeoDistance(52.52, 13.405, 53.55, 9.99); math.Abs(res-255) > 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for _, test := range []struct {
+		s        string
+		lat, lon float64
+		ok       bool
+	}{
+		{`{"lat":52.52,"lon":13.405}`, 52.52, 13.405, true},
+		{`{"lat":1,"lon":2,"name":"foo"}`, 1, 2, true},
+		{`{"lat":1}`, 0, 0, false},
+		{`{"lat":91,"lon":2}`, 0, 0, false},
+		{`{"lat":"1","lon":2}`, 0, 0, false},
+		{`foo`, 0, 0, false},
+	} {
+		if lat, lon, ok := ParseGeoPoint(test.s); lat != test.lat || lon != test.lon || ok != test.ok {
+			t.Error("Unexpected result:", test.s, lat, lon, ok)
+			return
+		}
+	}
+
+	if _, _, ok := parseGeoPos("1"); ok {
+		t.Error("Unexpected result")
+		return
+	}
+}
+
+func TestGeoIndex(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+
+	geo := func(lat float64, lon float64) map[string]string {
+		return map[string]string{"loc": fmt.Sprintf(`{"lat":%v,"lon":%v}`, lat, lon)}
+	}
+
+	im.Index("berlin", geo(52.52, 13.405))
+	im.Index("potsdam", geo(52.39, 13.06))
+	im.Index("hamburg", geo(53.55, 9.99))
+	im.Index("fiji", geo(-17.8, 179.9))
+	im.Index("samoa", geo(-13.8, -172.1))
+	im.Index("other", map[string]string{"loc": "foo"})
+
+	if res, err := im.LookupGeoRadius("loc", 52.5, 13.3, 40); fmt.Sprint(res) != "[berlin potsdam]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := im.LookupGeoRadius("loc", 52.4, 13.1, 300); fmt.Sprint(res) != "[potsdam berlin hamburg]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := im.LookupGeoBBox("loc", 52, 13, 53, 14); fmt.Sprint(res) != "[berlin potsdam]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := im.LookupGeoBBox("loc", -90, -180, 90, 180); fmt.Sprint(res) != "[berlin fiji hamburg potsdam samoa]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := im.LookupGeoBBox("loc", 53, 13, 52, 14); err == nil || err.Error() != "GraphError: Invalid data (Invalid bounding box)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Radius searches across the 180th meridian
+
+	if res, err := im.LookupGeoRadius("loc", -15, 180, 1000); fmt.Sprint(res) != "[fiji samoa]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Update and remove locations
+
+	im.Reindex("berlin", geo(48.14, 11.58), geo(52.52, 13.405))
+
+	if res, err := im.LookupGeoRadius("loc", 52.5, 13.3, 40); fmt.Sprint(res) != "[potsdam]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	im.Reindex("potsdam", geo(52.39, 13.06), geo(52.39, 13.06))
+	im.Deindex("potsdam", geo(52.39, 13.06))
+
+	if res, err := im.LookupGeoRadius("loc", 52.5, 13.3, 40); fmt.Sprint(res) != "[]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := im.LookupGeoRadius("loc", 48, 11.5, 40); fmt.Sprint(res) != "[berlin]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
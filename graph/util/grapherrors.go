@@ -45,6 +45,7 @@ package util
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 /*
@@ -66,6 +67,23 @@ func (ge *GraphError) Error() string {
 	return fmt.Sprintf("GraphError: %v", ge.Type)
 }
 
+/*
+ConflictError is returned by optimistic transactions if nodes or edges which
+were read by the transaction have been modified concurrently.
+*/
+type ConflictError struct {
+	*GraphError
+	Conflicts []string // Conflicting nodes and edges as <part>:<n|e>:<kind>:<key>
+}
+
+/*
+NewConflictError creates a new ConflictError for a list of conflicting nodes
+and edges.
+*/
+func NewConflictError(conflicts []string) *ConflictError {
+	return &ConflictError{&GraphError{ErrConflict, strings.Join(conflicts, ", ")}, conflicts}
+}
+
 /*
 Graph storage related error types
 */
@@ -82,9 +100,15 @@ var (
 Graph related error types
 */
 var (
-	ErrInvalidData = errors.New("Invalid data")
-	ErrIndexError  = errors.New("Index error")
-	ErrReading     = errors.New("Could not read graph information")
-	ErrWriting     = errors.New("Could not write graph information")
-	ErrRule        = errors.New("Graph rule error")
+	ErrInvalidData     = errors.New("Invalid data")
+	ErrIndexError      = errors.New("Index error")
+	ErrReading         = errors.New("Could not read graph information")
+	ErrWriting         = errors.New("Could not write graph information")
+	ErrRule            = errors.New("Graph rule error")
+	ErrTraversalLimit  = errors.New("Traversal limit exceeded")
+	ErrConflict        = errors.New("Concurrent modification")
+	ErrDeadlock        = errors.New("Deadlock")
+	ErrTransLimit      = errors.New("Transaction limit exceeded")
+	ErrRemoteFetch     = errors.New("Could not fetch remote nodes")
+	ErrRemoteCallLimit = errors.New("Remote call budget exceeded")
 )
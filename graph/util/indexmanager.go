@@ -46,11 +46,18 @@ PrefixAttrHash is the prefix used for hashes of attribute values
 */
 const PrefixAttrHash = "\x01"
 
+/*
+UnindexedAll is the attribute name which excludes all attributes from the index.
+*/
+const UnindexedAll = "*"
+
 /*
 IndexManager data structure
 */
 type IndexManager struct {
-	htree *hash.HTree // Persistent HTree which stores this index
+	htree     *hash.HTree          // Persistent HTree which stores this index
+	analyzers map[string]*Analyzer // Analyzers for attributes (may be nil)
+	unindexed map[string]bool      // Attributes which are not indexed (may be nil)
 }
 
 /*
@@ -71,7 +78,24 @@ func init() {
 NewIndexManager creates a new index manager instance.
 */
 func NewIndexManager(htree *hash.HTree) *IndexManager {
-	return &IndexManager{htree}
+	return &IndexManager{htree, nil, nil}
+}
+
+/*
+SetAnalyzers sets the analyzers which should be used for attributes. Attributes
+without an analyzer use the default word extraction.
+*/
+func (im *IndexManager) SetAnalyzers(analyzers map[string]*Analyzer) {
+	im.analyzers = analyzers
+}
+
+/*
+SetUnindexed sets attributes which should not be indexed. The attribute
+UnindexedAll excludes all attributes. Existing index entries of these
+attributes are not changed.
+*/
+func (im *IndexManager) SetUnindexed(attrs map[string]bool) {
+	im.unindexed = attrs
 }
 
 /*
@@ -105,9 +129,7 @@ func (im *IndexManager) LookupPhrase(attr, phrase string) ([]string, error) {
 
 	// Chop up the phrase into words
 
-	phraseWords := strings.FieldsFunc(phrase, func(r rune) bool {
-		return !stringutil.IsAlphaNumeric(string(r)) && (unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r))
-	})
+	phraseWords := im.phraseWords(attr, phrase)
 
 	// Lookup every phrase word
 
@@ -115,7 +137,7 @@ func (im *IndexManager) LookupPhrase(attr, phrase string) ([]string, error) {
 
 	for i, phraseWord := range phraseWords {
 
-		res, err := im.LookupWord(attr, phraseWord)
+		res, err := im.lookupWord(attr, phraseWord)
 		if err != nil {
 			return nil, &GraphError{ErrIndexError, err.Error()}
 		}
@@ -233,14 +255,20 @@ LookupWord finds all nodes where an attribute contains a certain word. This call
 a map which maps node key to a list of word positions.
 */
 func (im *IndexManager) LookupWord(attr, word string) (map[string][]uint64, error) {
-	var s string
 
-	if CaseSensitiveWordIndex {
-		s = word
-	} else {
-		s = strings.ToLower(word)
+	s, ok := im.normalizeWord(attr, word)
+	if !ok {
+		return nil, nil
 	}
 
+	return im.lookupWord(attr, s)
+}
+
+/*
+lookupWord looks up a normalized word.
+*/
+func (im *IndexManager) lookupWord(attr, s string) (map[string][]uint64, error) {
+
 	entry, err := im.htree.Get([]byte(PrefixAttrWord + attr + s))
 
 	if err != nil {
@@ -303,12 +331,10 @@ func (im *IndexManager) LookupValue(attr, value string) ([]string, error) {
 Count returns the number of found nodes for a given word in a given attribute.
 */
 func (im *IndexManager) Count(attr, word string) (int, error) {
-	var s string
 
-	if CaseSensitiveWordIndex {
-		s = word
-	} else {
-		s = strings.ToLower(word)
+	s, ok := im.normalizeWord(attr, word)
+	if !ok {
+		return 0, nil
 	}
 
 	entry, err := im.htree.Get([]byte(PrefixAttrWord + attr + s))
@@ -363,9 +389,17 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 
 	emptyws := newWordSet(1)
 
+	if im.unindexed[UnindexedAll] {
+		return nil
+	}
+
 	for attr := range attrMap {
 		var newwords, toadd, oldwords, toremove *wordSet
 
+		if im.unindexed[attr] {
+			continue
+		}
+
 		newval, newok := newObj[attr]
 		oldval, oldok := oldObj[attr]
 
@@ -375,7 +409,7 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 		oldwords = emptyws
 
 		if newok {
-			newwords = extractWords(newval)
+			newwords = im.extractWords(attr, newval)
 		}
 
 		// At this point we have only words to add
@@ -383,8 +417,11 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 		toadd = newwords
 		toremove = emptyws
 
+		newLen, oldLen := newwords.WordCount(), 0
+
 		if oldok {
-			oldwords = extractWords(oldval)
+			oldwords = im.extractWords(attr, oldval)
+			oldLen = oldwords.WordCount()
 
 			if !oldwords.Empty() && !newwords.Empty() {
 
@@ -446,6 +483,18 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 				return &GraphError{ErrIndexError, err.Error()}
 			}
 		}
+
+		// Update geo lookup
+
+		if err := im.updateGeoEntries(key, attr, newval, newok, oldval, oldok); err != nil {
+			return &GraphError{ErrIndexError, err.Error()}
+		}
+
+		// Update word statistics
+
+		if err := im.updateAttrStats(key, attr, newLen, newok, oldLen, oldok); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -575,7 +624,9 @@ func (im *IndexManager) removeIndexEntry(key string, attr string, word string, p
 	}
 
 	if len(entry.WordPos) == 0 {
-		_, err = im.htree.Remove(indexkey)
+		if _, err = im.htree.Remove(indexkey); err == nil {
+			err = im.removeDictWord(attr, word)
+		}
 	} else {
 		_, err = im.htree.Put(indexkey, entry)
 	}
@@ -600,6 +651,11 @@ func (im *IndexManager) addIndexEntry(key string, attr string, word string, pos
 
 	if obj == nil {
 		entry = &indexEntry{make(map[string]string)}
+
+		if err := im.addDictWord(attr, word); err != nil {
+			return err
+		}
+
 	} else {
 		entry = obj.(*indexEntry)
 	}
@@ -669,8 +725,13 @@ func (im *IndexManager) String() string {
 	for it.HasNext() {
 		key, value := it.Next()
 
+		entry, ok := value.(*indexEntry)
+		if !ok {
+			continue
+		}
+
 		posmap := make(map[string][]uint64)
-		for k, v := range value.(*indexEntry).WordPos {
+		for k, v := range entry.WordPos {
 			posmap[k] = bitutil.UnpackList(v)
 		}
 
@@ -680,6 +741,80 @@ func (im *IndexManager) String() string {
 	return buf.String()
 }
 
+/*
+extractWords extracts all words from a given attribute value using the
+analyzer of the attribute.
+*/
+func (im *IndexManager) extractWords(attr string, s string) *wordSet {
+	if a, ok := im.analyzers[attr]; ok {
+		return a.extractWords(s)
+	}
+	return extractWords(s)
+}
+
+/*
+phraseWords splits a given phrase into normalized words using the analyzer of
+an attribute.
+*/
+func (im *IndexManager) phraseWords(attr string, phrase string) []string {
+
+	if a, ok := im.analyzers[attr]; ok {
+		return a.Words(phrase)
+	}
+
+	words := strings.FieldsFunc(phrase, func(r rune) bool {
+		return !stringutil.IsAlphaNumeric(string(r)) && (unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r))
+	})
+
+	if !CaseSensitiveWordIndex {
+		for i, word := range words {
+			words[i] = strings.ToLower(word)
+		}
+	}
+
+	return words
+}
+
+/*
+normalizeWord normalizes a single word using the analyzer of an attribute.
+Returns false if the word is not indexed (e.g. if it is a stop word).
+*/
+func (im *IndexManager) normalizeWord(attr string, word string) (string, bool) {
+
+	if a, ok := im.analyzers[attr]; ok {
+		words := a.Words(word)
+		if len(words) == 0 {
+			return "", false
+		}
+		return words[0], true
+	}
+
+	if !CaseSensitiveWordIndex {
+		word = strings.ToLower(word)
+	}
+
+	return word, true
+}
+
+/*
+lowercaseWord lowercases a given word if the attribute is indexed in lowercase.
+*/
+func (im *IndexManager) lowercaseWord(attr string, word string) string {
+
+	if a, ok := im.analyzers[attr]; ok {
+		if a.Lowercase {
+			return strings.ToLower(word)
+		}
+		return word
+	}
+
+	if !CaseSensitiveWordIndex {
+		return strings.ToLower(word)
+	}
+
+	return word
+}
+
 /*
 extractWords extracts all words from a given string and return a wordSet which contains
 all words and their positions.
@@ -806,6 +941,22 @@ func (ws *wordSet) Empty() bool {
 	return len(ws.set) == 0
 }
 
+/*
+WordCount returns the number of words (including repeated words) in this word
+set. This is the largest position of any word.
+*/
+func (ws *wordSet) WordCount() int {
+	var count uint64
+
+	for _, pos := range ws.set {
+		if last := pos[len(pos)-1]; last > count {
+			count = last
+		}
+	}
+
+	return int(count)
+}
+
 /*
 Has checks if this word set has a certain word.
 */
@@ -70,7 +70,7 @@ func TestIndexManager(t *testing.T) {
 		return
 	}
 
-	for i := 0; i < 6; i++ {
+	for i := 0; i < 12; i++ {
 		sm.AccessMap[uint64(i)] = storage.AccessCacheAndFetchError
 	}
 
@@ -89,7 +89,7 @@ func TestIndexManager(t *testing.T) {
 		return
 	}
 
-	for i := 0; i < 6; i++ {
+	for i := 0; i < 12; i++ {
 		delete(sm.AccessMap, uint64(i))
 	}
 
@@ -191,7 +191,7 @@ func TestPhraseSearch(t *testing.T) {
 		t.Error("Unexpected result:", res, err)
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < 30; i++ {
 		sm.AccessMap[uint64(i)] = storage.AccessCacheAndFetchError
 	}
 
@@ -205,7 +205,7 @@ func TestPhraseSearch(t *testing.T) {
 		return
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < 30; i++ {
 		delete(sm.AccessMap, uint64(i))
 	}
 }
@@ -319,8 +319,13 @@ func countChildren(tree *hash.HTree) int {
 	it := hash.NewHTreeIterator(tree)
 
 	for it.HasNext() {
-		it.Next()
-		count++
+
+		// Word dictionary and statistics entries are not counted
+
+		if key, _ := it.Next(); !strings.HasPrefix(string(key), PrefixAttrDict) &&
+			!strings.HasPrefix(string(key), PrefixAttrStats) {
+			count++
+		}
 	}
 
 	return count
@@ -457,7 +462,7 @@ func TestAddRemoveIndexEntry(t *testing.T) {
 
 	testAddIndexPanic(t, im)
 
-	sm.AccessMap[2] = storage.AccessCacheAndFetchError
+	sm.AccessMap[4] = storage.AccessCacheAndFetchError
 
 	if res := im.addIndexEntry("mykey2", "myattr", "myword", []uint64{10, 12, 80}); res.(*storage.ManagerError).Type != storage.ErrSlotNotFound {
 		t.Error("Unexpected result:", res)
@@ -469,7 +474,7 @@ func TestAddRemoveIndexEntry(t *testing.T) {
 		return
 	}
 
-	delete(sm.AccessMap, 2)
+	delete(sm.AccessMap, 4)
 
 	im.removeIndexEntry("mykey", "myattr", "myword", []uint64{1, 5, 7})
 
@@ -555,7 +560,7 @@ func TestIndexManagerHashErrors(t *testing.T) {
 
 	im.Index("testkey", obj1)
 
-	sm.AccessMap[4] = storage.AccessCacheAndFetchError
+	sm.AccessMap[7] = storage.AccessCacheAndFetchError
 	if err := im.Index("testkey", obj1); err == nil {
 		t.Error("Error expected")
 		return
@@ -573,7 +578,7 @@ func TestIndexManagerHashErrors(t *testing.T) {
 		t.Error("Error expected")
 		return
 	}
-	delete(sm.AccessMap, 4)
+	delete(sm.AccessMap, 7)
 }
 
 func testAddIndexPanic(t *testing.T, in *IndexManager) {
@@ -769,3 +774,52 @@ func TestIndexManagerString(t *testing.T) {
 		return
 	}
 }
+
+func TestIndexManagerUnindexed(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+	im.SetUnindexed(map[string]bool{"counter": true})
+
+	obj := map[string]string{"name": "hello", "counter": "42"}
+
+	im.Index("1", obj)
+
+	if res, _ := im.LookupWord("name", "hello"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupValue("counter", "42"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Updates of unindexed attributes do not touch the index
+
+	im.Reindex("1", map[string]string{"name": "hello", "counter": "43"}, obj)
+
+	if res, _ := im.LookupValue("counter", "43"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.SetUnindexed(map[string]bool{UnindexedAll: true})
+
+	im.Index("2", obj)
+
+	if res, _ := im.LookupWord("name", "hello"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.SetUnindexed(nil)
+
+	im.Deindex("1", obj)
+
+	if res, _ := im.LookupWord("name", "hello"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -10,7 +10,10 @@
 
 package util
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"sync"
+)
 
 /*
 PrefixCode is the prefix for entries storing codes
@@ -42,13 +45,23 @@ NamesManager data structure
 */
 type NamesManager struct {
 	nameDB map[string]string // Database storing names
+	lock   sync.Locker       // Lock to synchronize access to the database
 }
 
 /*
 NewNamesManager creates a new names manager instance.
 */
 func NewNamesManager(nameDB map[string]string) *NamesManager {
-	return &NamesManager{nameDB}
+	return NewSharedNamesManager(nameDB, &sync.Mutex{})
+}
+
+/*
+NewSharedNamesManager creates a new names manager instance for a database which
+is also used by other writers. All access to the database is synchronized with
+the given lock.
+*/
+func NewSharedNamesManager(nameDB map[string]string, lock sync.Locker) *NamesManager {
+	return &NamesManager{nameDB, lock}
 }
 
 /*
@@ -87,6 +100,9 @@ encode encodes a name to a code.
 func (gs *NamesManager) encode(prefix string, name string, create bool) string {
 	codekey := string(PrefixCode) + prefix + name
 
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
 	code, ok := gs.nameDB[codekey]
 
 	// If the code doesn't exist yet create it
@@ -113,11 +129,15 @@ decode decodes a name from a code.
 func (gs *NamesManager) decode(prefix string, code string) string {
 	namekey := string(PrefixName) + prefix + code
 
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
 	return gs.nameDB[namekey]
 }
 
 /*
-newCode32 generates a new 32 bit number for the names map.
+newCode32 generates a new 32 bit number for the names map. Assumes that the
+lock is held.
 */
 func (gs *NamesManager) newCode32() (res string) {
 	var resnum uint32
@@ -150,7 +170,8 @@ func (gs *NamesManager) newCode32() (res string) {
 }
 
 /*
-newCode16 generates a new 16 bit number for the names map.
+newCode16 generates a new 16 bit number for the names map. Assumes that the
+lock is held.
 */
 func (gs *NamesManager) newCode16() (res string) {
 	var resnum uint16
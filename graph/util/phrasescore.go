@@ -0,0 +1,245 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"encoding/gob"
+	"math"
+	"sort"
+)
+
+/*
+PrefixAttrStats is the prefix used for the word statistics of an attribute. The
+statistics store the number of indexed values and words of an attribute and
+the number of words of each indexed value.
+*/
+const PrefixAttrStats = "\x04"
+
+/*
+BM25 parameters for ranked phrase lookups. BM25K1 controls how quickly the
+score saturates with the number of phrase occurrences. BM25B controls how much
+the score is normalized by the length of a value.
+*/
+var (
+	BM25K1 = 1.2
+	BM25B  = 0.75
+)
+
+/*
+PhraseMatch is a result of a ranked phrase lookup.
+*/
+type PhraseMatch struct {
+	Key   string  `json:"key"`   // Node key
+	Score float64 `json:"score"` // BM25 score of the match
+	Count int     `json:"count"` // Number of occurrences of the phrase
+}
+
+func init() {
+
+	// Make sure we can use the attribute statistics in a gob operation
+
+	gob.Register([]uint64{})
+}
+
+/*
+LookupPhraseScored finds all nodes where an attribute contains a certain
+phrase like LookupPhrase. The matches are ranked by their BM25 score which
+treats the phrase as a single term. Values which were indexed before word
+statistics were kept are scored as if they had an average length.
+*/
+func (im *IndexManager) LookupPhraseScored(attr, phrase string) ([]*PhraseMatch, error) {
+
+	keys, err := im.LookupPhrase(attr, phrase)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	stats, err := im.attrStats(attr, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate the inverse document frequency of the phrase
+
+	docs := float64(stats[0])
+	df := float64(len(keys))
+
+	if docs < df {
+		docs = df
+	}
+
+	avgLen := 1.0
+	if stats[0] > 0 && stats[1] > 0 {
+		avgLen = float64(stats[1]) / float64(stats[0])
+	}
+
+	idf := math.Log(1 + (docs-df+0.5)/(df+0.5))
+
+	phraseWords := im.phraseWords(attr, phrase)
+
+	positions := make([]map[string][]uint64, len(phraseWords))
+
+	for i, phraseWord := range phraseWords {
+		if positions[i], err = im.lookupWord(attr, phraseWord); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]*PhraseMatch, 0, len(keys))
+
+	for _, key := range keys {
+
+		docLen := avgLen
+
+		docStats, err := im.attrStats(attr, key)
+		if err != nil {
+			return nil, err
+		} else if docStats[0] > 0 {
+			docLen = float64(docStats[0])
+		}
+
+		count := countPhrase(key, positions)
+		if count == 0 {
+			count = 1
+		}
+
+		tf := float64(count)
+		score := idf * tf * (BM25K1 + 1) / (tf + BM25K1*(1-BM25B+BM25B*docLen/avgLen))
+
+		ret = append(ret, &PhraseMatch{key, score, count})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Score != ret[j].Score {
+			return ret[i].Score > ret[j].Score
+		}
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret, nil
+}
+
+/*
+countPhrase counts the occurrences of a phrase in a node given the word
+positions of all phrase words.
+*/
+func countPhrase(key string, positions []map[string][]uint64) int {
+	var count int
+
+	if len(positions) == 0 {
+		return 0
+	}
+
+	for _, start := range positions[0][key] {
+		found := true
+
+		for i := 1; i < len(positions) && found; i++ {
+			posList := positions[i][key]
+			pos := start + uint64(i)
+
+			j := sort.Search(len(posList), func(j int) bool { return posList[j] >= pos })
+			found = j < len(posList) && posList[j] == pos
+		}
+
+		if found {
+			count++
+		}
+	}
+
+	return count
+}
+
+/*
+attrStats returns the word statistics of an attribute. Returns the number of
+indexed values and the number of indexed words if no node key is given.
+Returns the number of words of a single value otherwise.
+*/
+func (im *IndexManager) attrStats(attr string, key string) ([]uint64, error) {
+	statsKey := PrefixAttrStats + attr
+
+	if key != "" {
+		statsKey += "\x00" + key
+	}
+
+	obj, err := im.htree.Get([]byte(statsKey))
+	if err != nil {
+		return nil, &GraphError{ErrIndexError, err.Error()}
+	} else if obj == nil {
+		return []uint64{0, 0}, nil
+	}
+
+	return obj.([]uint64), nil
+}
+
+/*
+updateAttrStats updates the word statistics of an attribute after a value of
+a node has been added, changed or removed.
+*/
+func (im *IndexManager) updateAttrStats(key string, attr string, newLen int, newok bool,
+	oldLen int, oldok bool) error {
+
+	if newok == oldok && newLen == oldLen {
+		return nil
+	}
+
+	stats, err := im.attrStats(attr, "")
+	if err != nil {
+		return err
+	}
+
+	stats = []uint64{stats[0], stats[1]}
+
+	if oldok {
+		docStats, err := im.attrStats(attr, key)
+		if err != nil {
+			return err
+		}
+
+		// Only remove what was counted before (values might have been
+		// indexed before statistics were kept)
+
+		if docStats[0] > 0 && stats[0] > 0 {
+			stats[0]--
+
+			if stats[1] >= docStats[0] {
+				stats[1] -= docStats[0]
+			} else {
+				stats[1] = 0
+			}
+		}
+	}
+
+	docKey := []byte(PrefixAttrStats + attr + "\x00" + key)
+
+	if newok && newLen > 0 {
+		stats[0]++
+		stats[1] += uint64(newLen)
+
+		_, err = im.htree.Put(docKey, []uint64{uint64(newLen)})
+
+	} else if oldok {
+		_, err = im.htree.Remove(docKey)
+	}
+
+	if err == nil {
+		if stats[0] == 0 {
+			_, err = im.htree.Remove([]byte(PrefixAttrStats + attr))
+		} else {
+			_, err = im.htree.Put([]byte(PrefixAttrStats+attr), stats)
+		}
+	}
+
+	if err != nil {
+		return &GraphError{ErrIndexError, err.Error()}
+	}
+
+	return nil
+}
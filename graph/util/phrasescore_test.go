@@ -0,0 +1,154 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestLookupPhraseScored(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+
+	matches := func(res []*PhraseMatch, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+
+		var ret []string
+		for _, m := range res {
+			ret = append(ret, fmt.Sprintf("%v:%.3f:%v", m.Key, m.Score, m.Count))
+		}
+		return fmt.Sprint(ret)
+	}
+
+	im.Index("1", map[string]string{"text": "The quick brown fox"})
+	im.Index("2", map[string]string{"text": "Quick brown fox jumps over the lazy dog - quick brown fox!"})
+	im.Index("3", map[string]string{"text": "Quick brown fox"})
+	im.Index("4", map[string]string{"text": "Slow dog"})
+
+	if res, _ := im.attrStats("text", ""); fmt.Sprint(res) != "[4 20]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPhraseScored("text", "quick brown fox")); res != "[3:0.426:1 1:0.388:1 2:0.367:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPhraseScored("text", "dog")); res != "[4:0.919:1 2:0.465:1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPhraseScored("text", "cat")); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Statistics are kept up to date
+
+	im.Reindex("3", map[string]string{"text": "Quick brown fox and slow brown cat"},
+		map[string]string{"text": "Quick brown fox"})
+
+	if res, _ := im.attrStats("text", "3"); fmt.Sprint(res) != "[7]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.Deindex("4", map[string]string{"text": "Slow dog"})
+
+	if res, _ := im.attrStats("text", ""); fmt.Sprint(res) != "[3 22]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPhraseScored("text", "brown")); res != "[3:0.186:2 1:0.164:1 2:0.161:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.Deindex("1", map[string]string{"text": "The quick brown fox"})
+	im.Deindex("2", map[string]string{"text": "Quick brown fox jumps over the lazy dog - quick brown fox!"})
+	im.Deindex("3", map[string]string{"text": "Quick brown fox and slow brown cat"})
+
+	if it := hash.NewHTreeIterator(htree); it.HasNext() {
+		t.Error("Unexpected result:", htree.String())
+		return
+	}
+
+	// Values without statistics are scored with an average length
+
+	im.Index("5", map[string]string{"text": "Quick brown fox"})
+	htree.Remove([]byte(PrefixAttrStats + "text\x005"))
+
+	if res := matches(im.LookupPhraseScored("text", "fox")); res != "[5:0.288:1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.Deindex("5", map[string]string{"text": "Quick brown fox"})
+
+	if res, _ := im.attrStats("text", ""); fmt.Sprint(res) != "[1 3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Test storage errors
+
+	im.Index("6", map[string]string{"text": "Quick brown fox"})
+
+	for i := 0; i < 100; i++ {
+		sm.AccessMap[uint64(i)] = storage.AccessCacheAndFetchError
+	}
+
+	if res := matches(im.LookupPhraseScored("text", "fox")); !strings.Contains(res, "Slot not found") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestCountPhrase(t *testing.T) {
+
+	positions := []map[string][]uint64{
+		{"a": {1, 5, 9}},
+		{"a": {2, 6, 7}},
+		{"a": {3, 8, 10}},
+	}
+
+	if res := countPhrase("a", positions); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := countPhrase("a", positions[:2]); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := countPhrase("b", positions); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := countPhrase("a", nil); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+/*
+Similarity returns the similarity of two strings between 0 (nothing in common)
+and 1 (equal). The similarity is based on the edit distance relative to the
+length of the longer string.
+*/
+func Similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	max := len(ra)
+	if len(rb) > max {
+		max = len(rb)
+	}
+
+	if max == 0 {
+		return 1
+	}
+
+	return 1 - float64(editDistance(ra, rb, max))/float64(max)
+}
+
+/*
+editDistance calculates the Levenshtein distance between two words. The
+calculation stops early if the distance exceeds a given maximum (the returned
+value is then larger than the maximum).
+*/
+func editDistance(a []rune, b []rune, max int) int {
+
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+
+			if v := prev[j] + 1; v < cur[j] {
+				cur[j] = v
+			}
+
+			if v := cur[j-1] + 1; v < cur[j] {
+				cur[j] = v
+			}
+
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
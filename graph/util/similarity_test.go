@@ -0,0 +1,36 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+
+	for _, test := range []struct {
+		a, b string
+		res  string
+	}{
+		{"", "", "1.00"},
+		{"abc", "abc", "1.00"},
+		{"abc", "", "0.00"},
+		{"abc", "xyz", "0.00"},
+		{"kitten", "sitting", "0.57"},
+		{"jon smith", "john smith", "0.90"},
+	} {
+		if res := fmt.Sprintf("%.2f", Similarity(test.a, test.b)); res != test.res {
+			t.Error("Unexpected result:", test, res)
+			return
+		}
+	}
+}
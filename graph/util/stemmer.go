@@ -0,0 +1,157 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"strings"
+)
+
+/*
+stemmers are light stemmers for all supported languages. Light stemmers only
+remove common inflectional suffixes (e.g. plural forms) - they do not try to
+reduce words to a linguistic root.
+*/
+var stemmers = map[string]func(string) string{
+	"english": stemEnglish,
+	"german":  stemGerman,
+	"french":  stemFrench,
+	"spanish": stemSpanish,
+}
+
+/*
+stemEnglish removes plural forms and the suffixes -ing, -ed and -ly from
+English words.
+*/
+func stemEnglish(word string) string {
+	w := []rune(word)
+
+	if len(w) <= 3 {
+		return word
+	}
+
+	switch {
+	case hasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case hasSuffix(w, "ies") && len(w) > 4:
+		w = append(w[:len(w)-3], 'y')
+	case hasSuffix(w, "xes") || hasSuffix(w, "ches") || hasSuffix(w, "shes") || hasSuffix(w, "zes"):
+		w = w[:len(w)-2]
+	case hasSuffix(w, "s") && !hasSuffix(w, "ss") && !hasSuffix(w, "us") && !hasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly"} {
+		if hasSuffix(w, suffix) {
+
+			if stem := w[:len(w)-len(suffix)]; len(stem) >= 3 && strings.ContainsAny(string(stem), "aeiouy") {
+				w = stem
+
+				// Remove double consonants (e.g. running -> run)
+
+				if l := len(w); w[l-1] == w[l-2] && !strings.ContainsRune("aeioulsz", w[l-1]) {
+					w = w[:l-1]
+				}
+			}
+
+			break
+		}
+	}
+
+	return string(w)
+}
+
+/*
+stemGerman removes common plural and case suffixes from German words. Umlauts
+are replaced by their base vowel.
+*/
+func stemGerman(word string) string {
+	w := []rune(strings.NewReplacer("ä", "a", "ö", "o", "ü", "u", "ß", "ss").Replace(word))
+
+	if len(w) < 5 {
+		return string(w)
+	}
+
+	if len(w) > 6 && hasSuffix(w, "nen") {
+		return string(w[:len(w)-3])
+	}
+
+	if len(w) > 5 && (hasSuffix(w, "en") || hasSuffix(w, "se") || hasSuffix(w, "es") || hasSuffix(w, "er")) {
+		return string(w[:len(w)-2])
+	}
+
+	if strings.ContainsRune("nesr", w[len(w)-1]) {
+		return string(w[:len(w)-1])
+	}
+
+	return string(w)
+}
+
+/*
+stemFrench removes plural and feminine forms from French words.
+*/
+func stemFrench(word string) string {
+	w := []rune(word)
+
+	if len(w) < 6 {
+		return word
+	}
+
+	if w[len(w)-1] == 'x' {
+		if hasSuffix(w, "aux") {
+			w[len(w)-2] = 'l'
+		}
+		return string(w[:len(w)-1])
+	}
+
+	for _, r := range "sreé" {
+		if w[len(w)-1] == r {
+			w = w[:len(w)-1]
+		}
+	}
+
+	if l := len(w); w[l-1] == w[l-2] {
+		w = w[:l-1]
+	}
+
+	return string(w)
+}
+
+/*
+stemSpanish removes plural forms and gender suffixes from Spanish words.
+Accents are replaced by their base vowel.
+*/
+func stemSpanish(word string) string {
+	w := []rune(strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u").Replace(word))
+
+	if len(w) < 5 {
+		return string(w)
+	}
+
+	switch {
+	case hasSuffix(w, "eses"):
+		w = w[:len(w)-2]
+	case hasSuffix(w, "ces"):
+		w = append(w[:len(w)-3], 'z')
+	case hasSuffix(w, "os") || hasSuffix(w, "as") || hasSuffix(w, "es"):
+		w = w[:len(w)-2]
+	case hasSuffix(w, "o") || hasSuffix(w, "a") || hasSuffix(w, "e"):
+		w = w[:len(w)-1]
+	}
+
+	return string(w)
+}
+
+/*
+hasSuffix checks if a given word ends with a given suffix.
+*/
+func hasSuffix(w []rune, suffix string) bool {
+	return strings.HasSuffix(string(w), suffix)
+}
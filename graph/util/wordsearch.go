@@ -0,0 +1,308 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/krotik/common/bitutil"
+)
+
+/*
+PrefixAttrDict is the prefix used for the word dictionary of an attribute. The
+dictionary stores all indexed words of an attribute in shards - words are
+grouped by their first DictShardLength characters.
+*/
+const PrefixAttrDict = "\x03"
+
+/*
+DictShardLength is the number of leading characters which determine the shard
+of a word in a word dictionary.
+*/
+const DictShardLength = 2
+
+/*
+MaxFuzzyDistance is the maximum edit distance for fuzzy word lookups.
+*/
+const MaxFuzzyDistance = 3
+
+/*
+MaxWordMatches is the maximum number of matching words for a single prefix or
+fuzzy word lookup. The closest words are used if more words match.
+*/
+const MaxWordMatches = 100
+
+/*
+WordMatch is a result of a prefix or fuzzy word lookup.
+*/
+type WordMatch struct {
+	Key      string `json:"key"`      // Node key
+	Word     string `json:"word"`     // Closest matching word of the node
+	Distance int    `json:"distance"` // Distance between the matching word and the searched word
+	Count    int    `json:"count"`    // Number of occurrences of matching words
+}
+
+func init() {
+
+	// Make sure we can use the dictionary in a gob operation
+
+	gob.Register([]string{})
+}
+
+/*
+LookupPrefix finds all nodes where an attribute contains a word which starts
+with a given prefix. The distance of a match is the number of characters which
+complete the prefix. Matches are ranked by distance and number of occurrences.
+*/
+func (im *IndexManager) LookupPrefix(attr, prefix string) ([]*WordMatch, error) {
+
+	prefix = im.lowercaseWord(attr, prefix)
+
+	if prefix == "" {
+		return nil, nil
+	}
+
+	// Short prefixes span several shards
+
+	shards := []string{shardOf(prefix)}
+
+	if shards[0] == prefix && utf8.RuneCountInString(prefix) < DictShardLength {
+		allShards, err := im.dictShard(attr, "")
+		if err != nil {
+			return nil, err
+		}
+
+		shards = nil
+
+		for i := sort.SearchStrings(allShards, prefix); i < len(allShards) &&
+			strings.HasPrefix(allShards[i], prefix); i++ {
+			shards = append(shards, allShards[i])
+		}
+	}
+
+	distances := make(map[string]int)
+
+	for _, shard := range shards {
+		words, err := im.dictShard(attr, shard)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := sort.SearchStrings(words, prefix); i < len(words) && strings.HasPrefix(words[i], prefix); i++ {
+			distances[words[i]] = utf8.RuneCountInString(words[i]) - utf8.RuneCountInString(prefix)
+		}
+	}
+
+	return im.rankWordMatches(attr, distances)
+}
+
+/*
+LookupFuzzy finds all nodes where an attribute contains a word which is similar
+to a given word. Words are similar if their edit distance (Levenshtein distance)
+is at most maxDistance. Matches are ranked by distance and number of occurrences.
+*/
+func (im *IndexManager) LookupFuzzy(attr, word string, maxDistance int) ([]*WordMatch, error) {
+
+	if maxDistance < 0 || maxDistance > MaxFuzzyDistance {
+		return nil, &GraphError{ErrInvalidData, fmt.Sprint("Maximum edit distance must be between 0 and ",
+			MaxFuzzyDistance)}
+	}
+
+	word = im.lowercaseWord(attr, word)
+
+	shards, err := im.dictShard(attr, "")
+	if err != nil {
+		return nil, err
+	}
+
+	distances := make(map[string]int)
+	runes := []rune(word)
+
+	for _, shard := range shards {
+		words, err := im.dictShard(attr, shard)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, w := range words {
+			if d := editDistance(runes, []rune(w), maxDistance); d <= maxDistance {
+				distances[w] = d
+			}
+		}
+	}
+
+	return im.rankWordMatches(attr, distances)
+}
+
+/*
+rankWordMatches looks up the closest matching words and returns the ranked
+matches for each node.
+*/
+func (im *IndexManager) rankWordMatches(attr string, distances map[string]int) ([]*WordMatch, error) {
+	var words []string
+
+	for w := range distances {
+		words = append(words, w)
+	}
+
+	sort.Slice(words, func(i, j int) bool {
+		if distances[words[i]] != distances[words[j]] {
+			return distances[words[i]] < distances[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	if len(words) > MaxWordMatches {
+		words = words[:MaxWordMatches]
+	}
+
+	matches := make(map[string]*WordMatch)
+
+	for _, w := range words {
+		obj, err := im.htree.Get([]byte(PrefixAttrWord + attr + w))
+		if err != nil {
+			return nil, &GraphError{ErrIndexError, err.Error()}
+		} else if obj == nil {
+			continue
+		}
+
+		for key, pos := range obj.(*indexEntry).WordPos {
+			count := len(bitutil.UnpackList(pos))
+
+			if m, ok := matches[key]; !ok {
+				matches[key] = &WordMatch{key, w, distances[w], count}
+			} else {
+				m.Count += count
+			}
+		}
+	}
+
+	ret := make([]*WordMatch, 0, len(matches))
+
+	for _, m := range matches {
+		ret = append(ret, m)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Distance != ret[j].Distance {
+			return ret[i].Distance < ret[j].Distance
+		} else if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret, nil
+}
+
+/*
+shardOf returns the leading characters of a word which determine its shard
+in a word dictionary.
+*/
+func shardOf(word string) string {
+	i, n := 0, 0
+
+	for i < len(word) && n < DictShardLength {
+		_, size := utf8.DecodeRuneInString(word[i:])
+		i += size
+		n++
+	}
+
+	return word[:i]
+}
+
+/*
+dictShard returns a sorted list of all words of an attribute in a given shard.
+Returns the sorted list of all shards if no shard is given.
+*/
+func (im *IndexManager) dictShard(attr string, shard string) ([]string, error) {
+
+	obj, err := im.htree.Get([]byte(PrefixAttrDict + attr + "\x00" + shard))
+	if err != nil {
+		return nil, &GraphError{ErrIndexError, err.Error()}
+	} else if obj == nil {
+		return nil, nil
+	}
+
+	return obj.([]string), nil
+}
+
+/*
+addDictWord adds a word to the word dictionary of an attribute.
+*/
+func (im *IndexManager) addDictWord(attr string, word string) error {
+	shard := shardOf(word)
+
+	for _, entry := range [][2]string{{shard, word}, {"", shard}} {
+
+		list, err := im.dictShard(attr, entry[0])
+		if err != nil {
+			return err
+		}
+
+		i := sort.SearchStrings(list, entry[1])
+		if i < len(list) && list[i] == entry[1] {
+			return nil
+		}
+
+		list = append(list, "")
+		copy(list[i+1:], list[i:])
+		list[i] = entry[1]
+
+		if _, err := im.htree.Put([]byte(PrefixAttrDict+attr+"\x00"+entry[0]), list); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+removeDictWord removes a word from the word dictionary of an attribute.
+*/
+func (im *IndexManager) removeDictWord(attr string, word string) error {
+	shard := shardOf(word)
+
+	for _, entry := range [][2]string{{shard, word}, {"", shard}} {
+
+		list, err := im.dictShard(attr, entry[0])
+		if err != nil {
+			return err
+		}
+
+		i := sort.SearchStrings(list, entry[1])
+		if i == len(list) || list[i] != entry[1] {
+			return nil
+		}
+
+		list = append(list[:i], list[i+1:]...)
+		key := []byte(PrefixAttrDict + attr + "\x00" + entry[0])
+
+		if len(list) > 0 {
+
+			// Keep the shard as long as it contains other words
+
+			_, err = im.htree.Put(key, list)
+
+			return err
+		}
+
+		if _, err = im.htree.Remove(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestWordSearch(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := hash.NewHTree(sm)
+
+	im := NewIndexManager(htree)
+
+	matches := func(res []*WordMatch, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+
+		var ret []string
+		for _, m := range res {
+			ret = append(ret, fmt.Sprintf("%v:%v:%v:%v", m.Key, m.Word, m.Distance, m.Count))
+		}
+		return fmt.Sprint(ret)
+	}
+
+	im.Index("1", map[string]string{"name": "Anna Annabelle", "city": "Annaberg"})
+	im.Index("2", map[string]string{"name": "Ann and Anne ann"})
+	im.Index("3", map[string]string{"name": "Jon Johnny"})
+	im.Index("4", map[string]string{"name": "John Jan"})
+
+	if res := matches(im.LookupPrefix("name", "ANN")); res != "[2:ann:0:3 1:anna:1:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPrefix("name", "annab")); res != "[1:annabelle:4:1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPrefix("city", "ann")); res != "[1:annaberg:5:1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPrefix("name", "j")); res != "[3:jon:2:2 4:jan:2:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPrefix("name", "x")); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupPrefix("name", "")); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupFuzzy("name", "jon", 1)); res != "[3:jon:0:1 4:jan:1:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupFuzzy("name", "Jon", 2)); res != "[3:jon:0:1 4:jan:1:2 2:ann:2:2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupFuzzy("name", "jon", 4)); res != "GraphError: Invalid data (Maximum edit distance must be between 0 and 3)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removed words are removed from the dictionary
+
+	im.Reindex("1", map[string]string{"name": "Bella"}, map[string]string{"name": "Anna Annabelle", "city": "Annaberg"})
+
+	if res := matches(im.LookupPrefix("name", "ann")); res != "[2:ann:0:3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.dictShard("name", ""); fmt.Sprint(res) != "[an be ja jo]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.dictShard("city", ""); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.Deindex("2", map[string]string{"name": "Ann and Anne ann"})
+
+	if res, _ := im.dictShard("name", ""); fmt.Sprint(res) != "[be ja jo]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Test storage errors
+
+	for i := 0; i < 20; i++ {
+		sm.AccessMap[uint64(i)] = storage.AccessCacheAndFetchError
+	}
+
+	if res := matches(im.LookupPrefix("name", "jo")); !strings.HasPrefix(res, "GraphError: Index error (Slot not found") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := matches(im.LookupFuzzy("name", "jo", 1)); !strings.HasPrefix(res, "GraphError: Index error (Slot not found") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+
+	for _, test := range []struct {
+		a, b string
+		max  int
+		res  int
+	}{
+		{"", "", 1, 0},
+		{"abc", "abc", 1, 0},
+		{"abc", "abd", 1, 1},
+		{"abc", "ab", 1, 1},
+		{"abc", "bca", 2, 2},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3},
+		{"abc", "abcdef", 2, 3},
+		{"straße", "strasse", 2, 2},
+	} {
+		if res := editDistance([]rune(test.a), []rune(test.b), test.max); res != test.res {
+			t.Error("Unexpected result:", test, res)
+			return
+		}
+	}
+}
@@ -225,6 +225,14 @@ func (r *SystemRuleGraphQLSubscriptions) Handles() []int {
 	}
 }
 
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+Events are handled asynchronously.
+*/
+func (r *SystemRuleGraphQLSubscriptions) PartitionOnly() bool {
+	return true
+}
+
 /*
 Handle handles an event.
 */
@@ -0,0 +1,175 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+)
+
+/*
+CheckReport is the result of a structure check of a HTree.
+*/
+type CheckReport struct {
+	Pages    uint64   // Number of readable pages
+	Buckets  uint64   // Number of readable buckets
+	Entries  uint64   // Number of readable entries
+	Problems []string // Found problems
+}
+
+/*
+Consistent returns true if no problems were found.
+*/
+func (r *CheckReport) Consistent() bool {
+	return len(r.Problems) == 0
+}
+
+/*
+addProblem adds a problem to the report.
+*/
+func (r *CheckReport) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+/*
+Check walks all pages and buckets of the tree and verifies that they can be
+read, that their depth matches their position and that each key is stored
+where its hash code points to. Nodes which cannot be read are reported and
+skipped. An optional function is called with each readable entry - this can
+be used to salvage the entries of a damaged tree (the function must not access
+the tree). Note that all storage locations of the tree are held in memory.
+*/
+func (t *HTree) Check(entry func(key []byte, value interface{})) *CheckReport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	report := &CheckReport{}
+
+	visited := map[uint64]bool{t.Root.loc: true}
+
+	t.checkPage(report, t.Root, nil, visited, entry)
+
+	return report
+}
+
+/*
+checkPage checks a page and all its children. The path contains the child
+index of each parent page.
+*/
+func (t *HTree) checkPage(report *CheckReport, page *htreePage, path []int,
+	visited map[uint64]bool, entry func(key []byte, value interface{})) {
+
+	report.Pages++
+
+	if int(page.Depth) != len(path) || page.Depth > MaxTreeDepth {
+		report.addProblem("Page %v has depth %v but is on level %v", page.loc, page.Depth, len(path))
+		return
+	} else if len(page.Children) != MaxPageChildren {
+		report.addProblem("Page %v has %v children instead of %v", page.loc, len(page.Children), MaxPageChildren)
+		return
+	}
+
+	for i, loc := range page.Children {
+
+		if loc == 0 {
+			continue
+		} else if visited[loc] {
+			report.addProblem("Page %v points to node %v which is also referenced elsewhere", page.loc, loc)
+			continue
+		}
+
+		visited[loc] = true
+
+		node, err := fetchNodeSafe(page, loc)
+		if err != nil {
+			report.addProblem("Node %v of page %v cannot be read: %v", loc, page.loc, err)
+			continue
+		}
+
+		node.tree = t
+		node.loc = loc
+		node.sm = page.sm
+
+		childPath := append(append([]int{}, path...), i)
+
+		if node.Children != nil {
+			t.checkPage(report, &htreePage{node}, childPath, visited, entry)
+		} else {
+			t.checkBucket(report, &htreeBucket{node}, childPath, entry)
+		}
+	}
+}
+
+/*
+checkBucket checks a bucket and all its entries. The path contains the child
+index of each parent page.
+*/
+func (t *HTree) checkBucket(report *CheckReport, bucket *htreeBucket, path []int,
+	entry func(key []byte, value interface{})) {
+
+	report.Buckets++
+
+	if int(bucket.Depth) != len(path) {
+		report.addProblem("Bucket %v has depth %v but is on level %v", bucket.loc, bucket.Depth, len(path))
+		return
+	} else if int(bucket.BucketSize) > len(bucket.Keys) || int(bucket.BucketSize) > len(bucket.Values) ||
+		(!bucket.IsLeaf() && bucket.BucketSize > MaxBucketElements) {
+		report.addProblem("Bucket %v has an invalid size: %v", bucket.loc, bucket.BucketSize)
+		return
+	}
+
+	for i := 0; i < int(bucket.BucketSize); i++ {
+		key := bucket.Keys[i]
+
+		if len(key) == 0 || bucket.Values[i] == nil {
+			report.addProblem("Bucket %v contains an empty entry", bucket.loc)
+			continue
+		}
+
+		// Check that the key is reachable from the root
+
+		misplaced := false
+
+		for depth, index := range path {
+			page := &htreePage{&htreeNode{Depth: byte(depth)}}
+
+			if int(page.hashKey(key)) != index {
+				misplaced = true
+				break
+			}
+		}
+
+		if misplaced {
+			report.addProblem("Bucket %v contains a misplaced key: %q", bucket.loc, key)
+			continue
+		}
+
+		report.Entries++
+
+		if entry != nil {
+			entry(key, bucket.Values[i])
+		}
+	}
+}
+
+/*
+fetchNodeSafe fetches a node and returns an error if the storage panics while
+reading damaged data.
+*/
+func fetchNodeSafe(page *htreePage, loc uint64) (node *htreeNode, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			node, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	return page.fetchNode(loc)
+}
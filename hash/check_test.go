@@ -0,0 +1,118 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestHTreeCheck(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	entries := make(map[string]interface{})
+
+	report := htree.Check(func(key []byte, value interface{}) {
+		entries[string(key)] = value
+	})
+
+	if !report.Consistent() || report.Entries != 1000 || len(entries) != 1000 ||
+		entries["key123"] != 123 || report.Pages == 0 || report.Buckets == 0 {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	// Move a key into the wrong bucket
+
+	var findBucket func(page *htreeNode) *htreeNode
+
+	findBucket = func(page *htreeNode) *htreeNode {
+		for _, loc := range page.Children {
+			if node, _ := sm.FetchCached(loc); node != nil {
+				if node.(*htreeNode).Children == nil {
+					return node.(*htreeNode)
+				}
+				return findBucket(node.(*htreeNode))
+			}
+		}
+		return nil
+	}
+
+	bucket := findBucket(htree.Root.htreeNode)
+
+	oldKey := bucket.Keys[0]
+	bucket.Keys[0] = []byte("misplaced")
+
+	report = htree.Check(nil)
+
+	if report.Consistent() || report.Entries != 999 || len(report.Problems) != 1 ||
+		!strings.HasPrefix(report.Problems[0], "Bucket") ||
+		!strings.HasSuffix(report.Problems[0], `contains a misplaced key: "misplaced"`) {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	bucket.Keys[0] = oldKey
+
+	// Make a page unreadable - its entries are skipped
+
+	var loc uint64
+	for _, loc = range htree.Root.Children {
+		if loc != 0 {
+			break
+		}
+	}
+
+	sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+
+	entries = make(map[string]interface{})
+
+	report = htree.Check(func(key []byte, value interface{}) {
+		entries[string(key)] = value
+	})
+
+	if report.Consistent() || report.Entries == 1000 || int(report.Entries) != len(entries) ||
+		report.Problems[0] != fmt.Sprintf("Node %v of page 1 cannot be read: "+
+			"Slot not found (testsm - Location:%v)", loc, loc) {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	// Detect nodes which are referenced twice and invalid depths
+
+	htree.Root.Children[255] = loc
+
+	if report = htree.Check(nil); len(report.Problems) != 1 ||
+		report.Problems[0] != fmt.Sprintf("Page 1 points to node %v which is also referenced elsewhere", loc) {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	htree.Root.Children[255] = 0
+	htree.Root.Depth = 1
+
+	if report = htree.Check(nil); len(report.Problems) != 1 ||
+		report.Problems[0] != "Page 1 has depth 1 but is on level 0" {
+		t.Error("Unexpected result:", report)
+		return
+	}
+}
@@ -111,6 +111,18 @@ func (n *htreeNode) fetchNode(loc uint64) (*htreeNode, error) {
 	return node, nil
 }
 
+/*
+setStorage sets the storage location and the StorageManager of a node which
+was fetched from storage. Cached nodes are shared between concurrent readers -
+their fields are only written if they change.
+*/
+func (n *htreeNode) setStorage(loc uint64, sm storage.Manager) {
+	if n.loc != loc || n.sm != sm {
+		n.loc = loc
+		n.sm = sm
+	}
+}
+
 /*
 NewHTree creates a new HTree.
 */
@@ -160,8 +172,7 @@ func LoadHTree(sm storage.Manager, loc uint64) (*HTree, error) {
 		tree = &HTree{&htreePage{obj.(*htreeNode)}, nil}
 	}
 
-	tree.Root.loc = loc
-	tree.Root.sm = sm
+	tree.Root.setStorage(loc, sm)
 
 	tree.mutex = &sync.Mutex{}
 
@@ -14,6 +14,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/krotik/common/fileutil"
@@ -180,3 +181,56 @@ func TestHTree(t *testing.T) {
 		return
 	}
 }
+
+func TestHTreeConcurrentReads(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("testkey", i)), i)
+	}
+
+	// Trees which are loaded by concurrent readers share the cached nodes
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			tree, err := LoadHTree(sm, htree.Location())
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			count := 0
+
+			for it := NewHTreeIterator(tree); it.HasNext(); count++ {
+				key, val := it.Next()
+
+				if res, err := tree.Get(key); res != val || err != nil {
+					errs <- fmt.Errorf("Unexpected result for %s: %v %v", key, res, err)
+					return
+				}
+			}
+
+			if count != 1000 {
+				errs <- fmt.Errorf("Unexpected number of keys: %v", count)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+		return
+	}
+}
@@ -68,8 +68,7 @@ func (p *htreePage) Get(key []byte) (interface{}, *htreeBucket, error) {
 
 			page := &htreePage{node}
 
-			page.loc = loc
-			page.sm = p.sm
+			page.setStorage(loc, p.sm)
 
 			return page.Get(key)
 
@@ -79,8 +78,7 @@ func (p *htreePage) Get(key []byte) (interface{}, *htreeBucket, error) {
 
 		bucket := &htreeBucket{node}
 
-		bucket.loc = loc
-		bucket.sm = p.sm
+		bucket.setStorage(loc, p.sm)
 
 		return bucket.Get(key), bucket, nil
 	}
@@ -107,8 +105,7 @@ func (p *htreePage) Exists(key []byte) (bool, error) {
 
 			page := &htreePage{node}
 
-			page.loc = loc
-			page.sm = p.sm
+			page.setStorage(loc, p.sm)
 
 			return page.Exists(key)
 
@@ -352,8 +349,7 @@ func (p *htreePage) String() string {
 
 				page := &htreePage{node}
 
-				page.loc = child
-				page.sm = p.sm
+				page.setStorage(child, p.sm)
 
 				buf.WriteString(page.String())
 
@@ -0,0 +1,338 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package parquet contains a writer and a reader for Apache Parquet files.
+
+The writer uses the column types of the arrow package (see arrow.InferFields).
+Each batch of rows is written as a row group with Snappy compression. All
+columns are nullable.
+
+The reader converts the values of a file into attribute values: integers are
+int64 values, floating point numbers are float64 values, decimals are
+*data.Decimal values, timestamps and dates are time.Time values (UTC), strings
+are string values and binary values are byte slices. Nested columns (lists,
+maps and structs) are not supported.
+*/
+package parquet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	apache "github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	pq "github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/compress"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/krotik/eliasdb/arrow"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+ContentType is the media type of Parquet files.
+*/
+const ContentType = "application/vnd.apache.parquet"
+
+/*
+ReadBatchSize is the maximum number of rows which are returned by a single
+call of Reader.Next.
+*/
+var ReadBatchSize = 1024
+
+/*
+Writer writes rows as Parquet file.
+*/
+type Writer struct {
+	fw     *pqarrow.FileWriter // Writer of the Parquet file
+	schema *apache.Schema      // Schema of the file
+	fields []arrow.Field       // Columns of the file
+}
+
+/*
+NewWriter creates a new Writer for a file with given columns.
+*/
+func NewWriter(out io.Writer, fields []arrow.Field) (*Writer, error) {
+	schemaFields := make([]apache.Field, len(fields))
+
+	for i, f := range fields {
+		var t apache.DataType
+
+		switch f.Type {
+		case arrow.TypeUtf8:
+			t = apache.BinaryTypes.String
+		case arrow.TypeBinary:
+			t = apache.BinaryTypes.Binary
+		case arrow.TypeInt64:
+			t = apache.PrimitiveTypes.Int64
+		case arrow.TypeFloat64:
+			t = apache.PrimitiveTypes.Float64
+		case arrow.TypeBool:
+			t = apache.FixedWidthTypes.Boolean
+		case arrow.TypeTimestamp:
+			t = &apache.TimestampType{Unit: apache.Microsecond, TimeZone: "UTC"}
+		default:
+			return nil, fmt.Errorf("Unknown column type %v for column %v", f.Type, f.Name)
+		}
+
+		schemaFields[i] = apache.Field{Name: f.Name, Type: t, Nullable: true}
+	}
+
+	schema := apache.NewSchema(schemaFields, nil)
+
+	fw, err := pqarrow.NewFileWriter(schema, out,
+		pq.NewWriterProperties(pq.WithCompression(compress.Codecs.Snappy)),
+		pqarrow.DefaultWriterProps())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{fw, schema, fields}, nil
+}
+
+/*
+WriteRowGroup writes a row group with given rows. Values which cannot be
+represented in the type of their column are written as null.
+*/
+func (w *Writer) WriteRowGroup(rows [][]interface{}) error {
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, w.schema)
+	defer b.Release()
+
+	for i, f := range w.fields {
+		fb := b.Field(i)
+
+		for _, row := range rows {
+			var v interface{}
+
+			if i < len(row) {
+				v = arrow.ConvertValue(f.Type, row[i])
+			}
+
+			if v == nil {
+				fb.AppendNull()
+				continue
+			}
+
+			switch fb := fb.(type) {
+			case *array.StringBuilder:
+				fb.Append(string(v.([]byte)))
+			case *array.BinaryBuilder:
+				fb.Append(v.([]byte))
+			case *array.Int64Builder:
+				fb.Append(v.(int64))
+			case *array.Float64Builder:
+				fb.Append(v.(float64))
+			case *array.BooleanBuilder:
+				fb.Append(v.(bool))
+			case *array.TimestampBuilder:
+				fb.Append(apache.Timestamp(v.(int64)))
+			}
+		}
+	}
+
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	return w.fw.Write(rec)
+}
+
+/*
+Close writes the footer of the file.
+*/
+func (w *Writer) Close() error {
+	return w.fw.Close()
+}
+
+/*
+Reader reads the rows of a Parquet file.
+*/
+type Reader struct {
+	pr    *file.Reader         // Reader of the Parquet file
+	rr    pqarrow.RecordReader // Reader of the record batches
+	names []string             // Column names
+}
+
+/*
+NewReader creates a new Reader for a Parquet file. Returns an error if the file
+has columns of unsupported types.
+*/
+func NewReader(in pq.ReaderAtSeeker) (*Reader, error) {
+
+	pr, err := file.NewParquetReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read Parquet file: %v", err)
+	}
+
+	fr, err := pqarrow.NewFileReader(pr, pqarrow.ArrowReadProperties{BatchSize: int64(ReadBatchSize)},
+		memory.DefaultAllocator)
+
+	if err == nil {
+		var schema *apache.Schema
+
+		if schema, err = fr.Schema(); err == nil {
+			var names []string
+
+			for _, f := range schema.Fields() {
+				if !supportedType(f.Type) {
+					pr.Close()
+					return nil, fmt.Errorf("Unsupported type %v of column %v", f.Type, f.Name)
+				}
+
+				names = append(names, f.Name)
+			}
+
+			var rr pqarrow.RecordReader
+
+			if rr, err = fr.GetRecordReader(context.Background(), nil, nil); err == nil {
+				return &Reader{pr, rr, names}, nil
+			}
+		}
+	}
+
+	pr.Close()
+
+	return nil, fmt.Errorf("Could not read Parquet file: %v", err)
+}
+
+/*
+Names returns the column names of the file.
+*/
+func (r *Reader) Names() []string {
+	return r.names
+}
+
+/*
+NumRows returns the number of rows of the file.
+*/
+func (r *Reader) NumRows() int64 {
+	return r.pr.NumRows()
+}
+
+/*
+Next returns the next rows of the file (nil if there are no more rows). Null
+values are nil.
+*/
+func (r *Reader) Next() ([][]interface{}, error) {
+
+	if !r.rr.Next() {
+		if err := r.rr.Err(); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("Could not read Parquet file: %v", err)
+		}
+		return nil, nil
+	}
+
+	rec := r.rr.Record()
+	rows := make([][]interface{}, rec.NumRows())
+
+	for i := range rows {
+		rows[i] = make([]interface{}, rec.NumCols())
+	}
+
+	for j, col := range rec.Columns() {
+		for i := range rows {
+			if !col.IsNull(i) {
+				rows[i][j] = value(col, i)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+/*
+Close closes the reader.
+*/
+func (r *Reader) Close() error {
+	r.rr.Release()
+	return r.pr.Close()
+}
+
+/*
+supportedType checks if the values of a given type can be read.
+*/
+func supportedType(t apache.DataType) bool {
+	switch t.ID() {
+	case apache.INT8, apache.INT16, apache.INT32, apache.INT64,
+		apache.UINT8, apache.UINT16, apache.UINT32, apache.UINT64,
+		apache.FLOAT32, apache.FLOAT64, apache.BOOL, apache.DECIMAL128,
+		apache.STRING, apache.LARGE_STRING, apache.BINARY, apache.LARGE_BINARY,
+		apache.FIXED_SIZE_BINARY, apache.TIMESTAMP, apache.DATE32, apache.DATE64:
+		return true
+	}
+	return false
+}
+
+/*
+value returns a value of a column as attribute value.
+*/
+func value(col apache.Array, i int) interface{} {
+
+	switch c := col.(type) {
+	case *array.Int8:
+		return int64(c.Value(i))
+	case *array.Int16:
+		return int64(c.Value(i))
+	case *array.Int32:
+		return int64(c.Value(i))
+	case *array.Int64:
+		return c.Value(i)
+	case *array.Uint8:
+		return int64(c.Value(i))
+	case *array.Uint16:
+		return int64(c.Value(i))
+	case *array.Uint32:
+		return int64(c.Value(i))
+	case *array.Uint64:
+		if v := c.Value(i); v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return float64(c.Value(i))
+	case *array.Float32:
+		return float64(c.Value(i))
+	case *array.Float64:
+		return c.Value(i)
+	case *array.Boolean:
+		return c.Value(i)
+	case *array.Decimal128:
+		scale := c.DataType().(*apache.Decimal128Type).Scale
+		denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+		return data.NewDecimalFromRat(new(big.Rat).SetFrac(c.Value(i).BigInt(), denom))
+	case *array.String:
+		return c.Value(i)
+	case *array.LargeString:
+		return c.Value(i)
+	case *array.Binary:
+		return append([]byte{}, c.Value(i)...)
+	case *array.LargeBinary:
+		return append([]byte{}, c.Value(i)...)
+	case *array.FixedSizeBinary:
+		return append([]byte{}, c.Value(i)...)
+	case *array.Timestamp:
+		unit := c.DataType().(*apache.TimestampType).Unit
+		return c.Value(i).ToTime(unit).UTC()
+	case *array.Date32:
+		return c.Value(i).ToTime().UTC()
+	case *array.Date64:
+		return c.Value(i).ToTime().UTC()
+	}
+
+	return nil
+}
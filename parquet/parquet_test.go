@@ -0,0 +1,220 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package parquet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	apache "github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/krotik/eliasdb/arrow"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	oldBatchSize := ReadBatchSize
+	ReadBatchSize = 2
+	defer func() {
+		ReadBatchSize = oldBatchSize
+	}()
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	fields := []arrow.Field{
+		{Name: "key", Type: arrow.TypeUtf8},
+		{Name: "count", Type: arrow.TypeInt64},
+		{Name: "ratio", Type: arrow.TypeFloat64},
+		{Name: "ok", Type: arrow.TypeBool},
+		{Name: "time", Type: arrow.TypeTimestamp},
+		{Name: "blob", Type: arrow.TypeBinary},
+	}
+
+	if _, err := NewWriter(&buf, []arrow.Field{{Name: "x", Type: "foo"}}); err == nil ||
+		err.Error() != "Unknown column type foo for column x" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	w, err := NewWriter(&buf, fields)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := w.WriteRowGroup([][]interface{}{
+		{"a", 1, 1.5, true, ts, []byte{1, 2}},
+		{"b", nil, 2, false, nil, nil},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Empty row groups are not written and values of the wrong type are null
+
+	if err := w.WriteRowGroup(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := w.WriteRowGroup([][]interface{}{
+		{"c", "x", "y", "z", "t"},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Close()
+
+	if res := fmt.Sprint(r.Names(), " ", r.NumRows()); res != "[key count ratio ok time blob] 3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var res []string
+
+	for {
+		rows, err := r.Next()
+		if err != nil {
+			t.Error(err)
+			return
+		} else if rows == nil {
+			break
+		}
+
+		res = append(res, fmt.Sprint(rows))
+	}
+
+	if strings.Join(res, "\n") != `[[a 1 1.5 true 2020-01-02 03:04:05.000006 +0000 UTC [1 2]] [b <nil> 2 false <nil> <nil>]]
+[[c <nil> <nil> <nil> <nil> <nil>]]` {
+		t.Error("Unexpected result:", strings.Join(res, "\n"))
+		return
+	}
+}
+
+func TestParquetReaderTypes(t *testing.T) {
+	var buf bytes.Buffer
+
+	schema := apache.NewSchema([]apache.Field{
+		{Name: "i32", Type: apache.PrimitiveTypes.Int32, Nullable: true},
+		{Name: "u64", Type: apache.PrimitiveTypes.Uint64, Nullable: true},
+		{Name: "f32", Type: apache.PrimitiveTypes.Float32, Nullable: true},
+		{Name: "dec", Type: &apache.Decimal128Type{Precision: 10, Scale: 2}, Nullable: true},
+		{Name: "day", Type: apache.FixedWidthTypes.Date32, Nullable: true},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	b.Field(0).(*array.Int32Builder).Append(-5)
+	b.Field(1).(*array.Uint64Builder).Append(1 << 63)
+	b.Field(2).(*array.Float32Builder).Append(0.5)
+	b.Field(3).(*array.Decimal128Builder).Append(decimal128.FromI64(12345))
+	b.Field(4).(*array.Date32Builder).Append(apache.Date32FromTime(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)))
+
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	fw, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	if err == nil {
+		if err = fw.Write(rec); err == nil {
+			err = fw.Close()
+		}
+	}
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Close()
+
+	rows, err := r.Next()
+	if err != nil || len(rows) != 1 {
+		t.Error("Unexpected result:", rows, err)
+		return
+	}
+
+	if dec, ok := rows[0][3].(*data.Decimal); !ok || dec.String() != "123.45" {
+		t.Error("Unexpected result:", rows[0][3])
+		return
+	}
+
+	if res := fmt.Sprintf("%T %v %T %v", rows[0][0], rows[0][0], rows[0][1], rows[0][1]); res != "int64 -5 float64 9.223372036854776e+18" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(rows[0][2], " ", rows[0][4]); res != "0.5 2021-03-04 00:00:00 +0000 UTC" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if rows, err := r.Next(); rows != nil || err != nil {
+		t.Error("Unexpected result:", rows, err)
+		return
+	}
+}
+
+func TestParquetReaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	if _, err := NewReader(bytes.NewReader([]byte("foo"))); err == nil ||
+		!strings.HasPrefix(err.Error(), "Could not read Parquet file: ") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Nested columns cannot be read
+
+	schema := apache.NewSchema([]apache.Field{
+		{Name: "tags", Type: apache.ListOf(apache.BinaryTypes.String), Nullable: true},
+	}, nil)
+
+	fw, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	if err == nil {
+		err = fw.Close()
+	}
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := NewReader(bytes.NewReader(buf.Bytes())); err == nil ||
+		err.Error() != "Unsupported type list<list: utf8, nullable> of column tags" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,457 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+LimitsNodeKind is the node kind which stores partition limits.
+*/
+const LimitsNodeKind = "quota"
+
+/*
+RecountInterval is the interval after which the usage of all partitions is
+counted again.
+*/
+var RecountInterval = 5 * time.Minute
+
+/*
+Manager enforces quotas on a graph.
+*/
+type Manager struct {
+	gm         *graph.Manager     // Graph manager which is checked
+	part       string             // Partition which stores the limits (empty if limits are not stored)
+	limits     map[string]*Limits // Limits by partition
+	usage      map[string]*Usage  // Usage by partition
+	counted    time.Time          // Time when the usage was last counted
+	stale      bool               // Flag if the usage needs to be counted again
+	recounting bool               // Flag if the usage is currently counted in the background
+	closed     bool               // Flag if the manager was closed
+	recounts   *sync.WaitGroup    // Wait group for counts in the background
+	lock       *sync.Mutex        // Lock for limits and usage
+}
+
+/*
+NewManager creates a new quota manager for a graph. Limits are stored in and
+loaded from a given partition of the graph - an empty partition name means
+that limits are only kept in memory. The partition which stores the limits is
+not counted. The manager registers a graph rule which rejects writes which
+would exceed a limit.
+*/
+func NewManager(gm *graph.Manager, part string) (*Manager, error) {
+
+	m := &Manager{gm, part, make(map[string]*Limits), make(map[string]*Usage),
+		time.Time{}, true, false, false, &sync.WaitGroup{}, &sync.Mutex{}}
+
+	if part != "" {
+		it, err := gm.NodeKeyIterator(part, LimitsNodeKind)
+
+		for err == nil && it != nil && it.HasNext() {
+			key := it.Next()
+
+			if err = it.LastError; err == nil {
+				var node data.Node
+
+				if node, err = gm.FetchNode(part, key, LimitsNodeKind); err == nil && node != nil {
+					limits := &Limits{}
+
+					if err = json.Unmarshal([]byte(fmt.Sprint(node.Attr("data"))), limits); err == nil {
+						m.limits[key] = limits
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.recount(); err != nil {
+		return nil, err
+	}
+
+	gm.SetGraphRule(&quotaRule{m})
+
+	return m, nil
+}
+
+/*
+Close stops counting the usage in the background. Waits until a running count
+has finished. Should be called before the graph storage is closed.
+*/
+func (m *Manager) Close() {
+	m.lock.Lock()
+	m.closed = true
+	m.lock.Unlock()
+
+	m.recounts.Wait()
+}
+
+/*
+Limits returns the limits of all partitions.
+*/
+func (m *Manager) Limits() map[string]*Limits {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := make(map[string]*Limits, len(m.limits))
+
+	for part, limits := range m.limits {
+		l := *limits
+		ret[part] = &l
+	}
+
+	return ret
+}
+
+/*
+SetLimits validates and sets the limits of a partition. Limits without any
+set value remove the limits of the partition. Existing data is not checked -
+only writes which increase the usage are rejected.
+*/
+func (m *Manager) SetLimits(part string, limits *Limits) error {
+
+	if err := limits.Validate(part); err != nil {
+		return err
+	}
+
+	if m.part != "" {
+		var err error
+
+		if limits.IsEmpty() {
+			_, err = m.gm.RemoveNode(m.part, part, LimitsNodeKind)
+
+		} else {
+			var limitsJSON []byte
+
+			if limitsJSON, err = json.Marshal(limits); err == nil {
+				node := data.NewGraphNode()
+				node.SetAttr(data.NodeKey, part)
+				node.SetAttr(data.NodeKind, LimitsNodeKind)
+				node.SetAttr("updated", time.Now().Unix())
+				node.SetAttr("data", string(limitsJSON))
+
+				err = m.gm.StoreNode(m.part, node)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if limits.IsEmpty() {
+		delete(m.limits, part)
+	} else {
+		l := *limits
+		m.limits[part] = &l
+	}
+
+	return nil
+}
+
+/*
+Usage returns the usage of a partition or the usage of all partitions
+together (AllPartitions).
+*/
+func (m *Manager) Usage(part string) (*Usage, error) {
+
+	usage, err := m.Usages()
+	if err != nil {
+		return nil, err
+	}
+
+	if u, ok := usage[part]; ok {
+		return u, nil
+	}
+
+	return &Usage{}, nil
+}
+
+/*
+Usages returns the usage of all partitions. The sum of all partitions is
+stored under AllPartitions.
+*/
+func (m *Manager) Usages() (map[string]*Usage, error) {
+
+	m.lock.Lock()
+	recount := m.stale || time.Since(m.counted) > RecountInterval
+	m.lock.Unlock()
+
+	if recount {
+		if err := m.recount(); err != nil {
+			return nil, err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	total := &Usage{}
+	ret := make(map[string]*Usage, len(m.usage)+1)
+
+	for part, usage := range m.usage {
+		u := *usage
+		ret[part] = &u
+		total.add(usage)
+	}
+
+	ret[AllPartitions] = total
+
+	return ret, nil
+}
+
+/*
+recount counts the usage of all partitions. The graph is read without holding
+the lock of the manager since graph rules are called while the graph is locked.
+*/
+func (m *Manager) recount() error {
+	usage := make(map[string]*Usage)
+
+	for _, part := range m.gm.Partitions() {
+
+		if part == m.part {
+			continue
+		}
+
+		pu, err := m.gm.PartitionUsage(part)
+		if err != nil {
+			return err
+		}
+
+		usage[part] = &Usage{int64(pu.Nodes), int64(pu.Edges), pu.Bytes}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.usage = usage
+	m.counted = time.Now()
+	m.stale = false
+
+	return nil
+}
+
+/*
+recountLater counts the usage of all partitions in the background. Assumes
+that the caller holds the lock of the manager.
+*/
+func (m *Manager) recountLater() {
+
+	if m.recounting || m.closed {
+		return
+	}
+
+	m.recounting = true
+	m.recounts.Add(1)
+
+	go func() {
+		defer m.recounts.Done()
+
+		m.recount()
+
+		m.lock.Lock()
+		m.recounting = false
+		m.lock.Unlock()
+	}()
+}
+
+/*
+change checks a change of the usage of a partition against the limits of the
+partition and the limits of all partitions. A change which does not increase
+the usage is always allowed. The change is applied to the usage if requested.
+An exceeded limit of an applied change means that the change was already
+written - the usage is then counted again in the background since the write
+might be rolled back.
+*/
+func (m *Manager) change(part string, delta *Usage, apply bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	usage := &Usage{}
+	if u, ok := m.usage[part]; ok {
+		*usage = *u
+	}
+	usage.add(delta)
+
+	if delta.Nodes > 0 || delta.Edges > 0 || delta.Bytes > 0 {
+
+		total := &Usage{}
+		for p, u := range m.usage {
+			if p != part {
+				total.add(u)
+			}
+		}
+		total.add(usage)
+
+		err := usage.check(part, m.limits[part])
+
+		if err == nil {
+			err = total.check(AllPartitions, m.limits[AllPartitions])
+		}
+
+		if err != nil {
+			if apply {
+				m.stale = true
+				m.recountLater()
+			}
+			return err
+		}
+	}
+
+	if apply {
+		m.usage[part] = usage
+
+		if time.Since(m.counted) > RecountInterval {
+			m.recountLater()
+		}
+	}
+
+	return nil
+}
+
+/*
+quotaRule is a graph rule which enforces the limits of a quota manager. Single
+writes are checked before they are stored. Writes of transactions are checked
+after they were written so the transaction is rolled back.
+*/
+type quotaRule struct {
+	m *Manager // Manager of the limits
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *quotaRule) Name() string {
+	return "quota.limits"
+}
+
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+*/
+func (r *quotaRule) PartitionOnly() bool {
+	return true
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *quotaRule) Handles() []int {
+	return []int{graph.EventNodeStore, graph.EventNodeUpdate, graph.EventEdgeStore,
+		graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventNodeDeleted,
+		graph.EventEdgeCreated, graph.EventEdgeUpdated, graph.EventEdgeDeleted}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *quotaRule) Handle(gm *graph.Manager, trans graph.Trans, event int, ed ...interface{}) error {
+	var old data.Node
+	var err error
+
+	part := ed[0].(string)
+
+	if part == r.m.part {
+		return nil
+	}
+
+	switch event {
+
+	case graph.EventNodeStore, graph.EventNodeUpdate:
+		node := ed[1].(data.Node)
+
+		if old, err = gm.FetchNode(part, node.Key(), node.Kind()); err == nil {
+			if event == graph.EventNodeUpdate {
+				node = merge(old, node)
+			}
+			err = r.m.change(part, delta(false, node, old), false)
+		}
+
+	case graph.EventEdgeStore:
+		var oldEdge data.Edge
+
+		edge := ed[1].(data.Edge)
+
+		if oldEdge, err = gm.FetchEdge(part, edge.Key(), edge.Kind()); err == nil {
+			if oldEdge != nil {
+				old = oldEdge
+			}
+			err = r.m.change(part, delta(true, edge, old), false)
+		}
+
+	case graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventEdgeCreated, graph.EventEdgeUpdated:
+		isEdge := event == graph.EventEdgeCreated || event == graph.EventEdgeUpdated
+		node := ed[1].(data.Node)
+
+		if len(ed) > 2 {
+			old, _ = ed[2].(data.Node)
+		}
+
+		err = r.m.change(part, delta(isEdge, merge(old, node), old), true)
+
+	case graph.EventNodeDeleted, graph.EventEdgeDeleted:
+		usage := delta(event == graph.EventEdgeDeleted, ed[1].(data.Node), nil)
+
+		usage.Nodes, usage.Edges, usage.Bytes = -usage.Nodes, -usage.Edges, -usage.Bytes
+
+		err = r.m.change(part, usage, true)
+	}
+
+	return err
+}
+
+/*
+merge returns the result of an update of a node.
+*/
+func merge(old data.Node, node data.Node) data.Node {
+
+	if old == nil {
+		return node
+	}
+
+	merged := data.CopyNode(old)
+
+	for attr, val := range node.Data() {
+		merged.SetAttr(attr, val)
+	}
+
+	return merged
+}
+
+/*
+delta returns the change of the usage if a node or edge replaces an old
+version (nil if the node or edge is new).
+*/
+func delta(isEdge bool, node data.Node, old data.Node) *Usage {
+	usage := &Usage{Bytes: graph.EstimateSize(node)}
+
+	if old != nil {
+		usage.Bytes -= graph.EstimateSize(old)
+	} else if isEdge {
+		usage.Edges = 1
+	} else {
+		usage.Nodes = 1
+	}
+
+	return usage
+}
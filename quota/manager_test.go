@@ -0,0 +1,247 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package quota
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestLimitsValidate(t *testing.T) {
+
+	for _, test := range []struct {
+		part   string
+		limits *Limits
+		msg    string
+	}{
+		{"my-part", &Limits{},
+			"Invalid partition name (allowed are letters, digits and _ or * for all partitions): my-part"},
+		{"main", &Limits{MaxBytes: -1},
+			"Limits of partition main must not be negative"},
+	} {
+		if err := test.limits.Validate(test.part); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	if err := (&Limits{MaxNodes: 1}).Validate(AllPartitions); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if IsExceeded(nil) || IsExceeded(fmt.Errorf("foo")) ||
+		!IsExceeded(fmt.Errorf("GraphError: Graph rule error (%v: test)", ErrExceeded)) {
+		t.Error("Unexpected result")
+		return
+	}
+}
+
+const testDBDir = "quotatest"
+
+func TestManager(t *testing.T) {
+	os.RemoveAll(testDBDir)
+	defer os.RemoveAll(testDBDir)
+
+	// Transactions are only rolled back by disk storages
+
+	gs, err := graphstorage.NewDiskGraphStorage(testDBDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer gs.Close()
+
+	gm := graph.NewGraphManager(gs)
+
+	newNode := func(key string, name string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Song")
+		node.SetAttr("name", name)
+		return node
+	}
+
+	newEdge := func(key string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "Link")
+		edge.SetAttr(data.EdgeEnd1Key, "1")
+		edge.SetAttr(data.EdgeEnd1Kind, "Song")
+		edge.SetAttr(data.EdgeEnd1Role, "from")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "2")
+		edge.SetAttr(data.EdgeEnd2Kind, "Song")
+		edge.SetAttr(data.EdgeEnd2Role, "to")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	// Existing data is counted
+
+	if err := gm.StoreNode("main", newNode("1", "a")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	m, err := NewManager(gm, "_system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer m.Close()
+
+	if u, err := m.Usage("main"); err != nil || fmt.Sprint(*u) != "{1 0 17}" {
+		t.Error("Unexpected result:", u, err)
+		return
+	}
+
+	if err := m.SetLimits("my-part", &Limits{}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := m.SetLimits("main", &Limits{MaxNodes: 2, MaxEdges: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.SetLimits(AllPartitions, &Limits{MaxNodes: 3}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Limits of a partition
+
+	if err := gm.StoreNode("main", newNode("2", "b")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newNode("3", "c")); err == nil ||
+		err.Error() != "GraphError: Graph rule error (Quota exceeded: Limit of 2 nodes reached in partition main)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Updates and writes to other partitions are still possible
+
+	if err := gm.UpdateNode("main", newNode("2", "bb")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("other", newNode("3", "c")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Limits of all partitions
+
+	if err := gm.StoreNode("other", newNode("4", "d")); err == nil ||
+		err.Error() != "GraphError: Graph rule error (Quota exceeded: Limit of 3 nodes reached)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e1")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e2")); !IsExceeded(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Transactions which exceed a limit are rolled back
+
+	trans := graph.NewGraphTrans(gm)
+	trans.StoreNode("other", newNode("5", "e"))
+
+	if err := trans.Commit(); !IsExceeded(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("other", "5", "Song"); n != nil {
+		t.Error("Node should not be stored:", n)
+		return
+	}
+
+	// Removals free up space
+
+	if _, err := gm.RemoveNode("other", "3", "Song"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("other", newNode("4", "d")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	usage, err := m.Usages()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	counted, _ := gm.PartitionUsage("main")
+
+	if res := fmt.Sprint(*usage["main"], " ", *usage["other"], " ", *usage[AllPartitions]); res !=
+		fmt.Sprintf("{2 1 %v} {1 0 17} {3 1 %v}", counted.Bytes, counted.Bytes+17) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Byte limits
+
+	if err := m.SetLimits("other", &Limits{MaxBytes: 20}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.UpdateNode("other", newNode("4", "a longer name")); err == nil ||
+		err.Error() != "GraphError: Graph rule error (Quota exceeded: Limit of 20 bytes reached in partition other)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Limits are loaded by a new manager and can be removed
+
+	if err := m.SetLimits("main", &Limits{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	m2, err := NewManager(gm, "_system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer m2.Close()
+
+	if res := fmt.Sprint(len(m2.Limits()), *m2.Limits()["other"], *m2.Limits()[AllPartitions]); res != "2 {0 0 20} {3 0 0}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if u, err := m2.Usage("foo"); err != nil || fmt.Sprint(*u) != "{0 0 0}" {
+		t.Error("Unexpected result:", u, err)
+		return
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package quota contains a manager which enforces resource quotas on a graph.
+
+Quotas limit the number of nodes, the number of edges and the estimated size
+of the stored data. Limits can be set for single partitions or for all
+partitions of a graph together. Writes which would exceed a limit are rejected
+with an error which can be detected with IsExceeded.
+
+The usage of each partition is counted once and then maintained through graph
+events. It is recounted periodically to correct any drift (e.g. from rolled
+back transactions).
+*/
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+AllPartitions is the partition name of limits which apply to the sum of all
+partitions.
+*/
+const AllPartitions = "*"
+
+/*
+ErrExceeded is the error message of writes which exceed a quota.
+*/
+var ErrExceeded = errors.New("Quota exceeded")
+
+/*
+partitionNameRegexp is the pattern of valid partition names.
+*/
+var partitionNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+/*
+Limits are the resource limits of a partition. A value of 0 means no limit.
+*/
+type Limits struct {
+	MaxNodes int64 `json:"max_nodes,omitempty"` // Maximum number of nodes
+	MaxEdges int64 `json:"max_edges,omitempty"` // Maximum number of edges
+	MaxBytes int64 `json:"max_bytes,omitempty"` // Maximum estimated size of all nodes and edges in bytes
+}
+
+/*
+Validate checks the limits of a partition.
+*/
+func (l *Limits) Validate(part string) error {
+
+	if part != AllPartitions && !partitionNameRegexp.MatchString(part) {
+		return fmt.Errorf("Invalid partition name (allowed are letters, digits and _ or %v for all partitions): %v",
+			AllPartitions, part)
+	}
+
+	if l.MaxNodes < 0 || l.MaxEdges < 0 || l.MaxBytes < 0 {
+		return fmt.Errorf("Limits of partition %v must not be negative", part)
+	}
+
+	return nil
+}
+
+/*
+IsEmpty returns if no limit is set.
+*/
+func (l *Limits) IsEmpty() bool {
+	return l.MaxNodes == 0 && l.MaxEdges == 0 && l.MaxBytes == 0
+}
+
+/*
+Usage is the resource usage of a partition.
+*/
+type Usage struct {
+	Nodes int64 `json:"nodes"` // Number of nodes
+	Edges int64 `json:"edges"` // Number of edges
+	Bytes int64 `json:"bytes"` // Estimated size of all nodes and edges in bytes
+}
+
+/*
+add adds another usage to this usage.
+*/
+func (u *Usage) add(o *Usage) {
+	u.Nodes += o.Nodes
+	u.Edges += o.Edges
+	u.Bytes += o.Bytes
+}
+
+/*
+check checks this usage against given limits. Returns an error describing the
+first exceeded limit.
+*/
+func (u *Usage) check(part string, l *Limits) error {
+	var what string
+	var max int64
+
+	if l == nil {
+		return nil
+	} else if l.MaxNodes > 0 && u.Nodes > l.MaxNodes {
+		what, max = "nodes", l.MaxNodes
+	} else if l.MaxEdges > 0 && u.Edges > l.MaxEdges {
+		what, max = "edges", l.MaxEdges
+	} else if l.MaxBytes > 0 && u.Bytes > l.MaxBytes {
+		what, max = "bytes", l.MaxBytes
+	} else {
+		return nil
+	}
+
+	if part == AllPartitions {
+		return fmt.Errorf("%v: Limit of %v %v reached", ErrExceeded, max, what)
+	}
+
+	return fmt.Errorf("%v: Limit of %v %v reached in partition %v", ErrExceeded, max, what, part)
+}
+
+/*
+IsExceeded checks if an error was caused by an exceeded quota. Errors of graph
+rules are wrapped by the graph manager so the error message is checked.
+*/
+func IsExceeded(err error) bool {
+	return err != nil && strings.Contains(err.Error(), ErrExceeded.Error())
+}
@@ -0,0 +1,518 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package replication contains a lightweight primary / replica replication.
+
+Change log
+
+The ChangeLog is a graph rule which records all changes of a GraphManager
+in a bounded in-memory log. Each change has a unique sequence number and the
+commit sequence number of its partition (see graph.Manager.PartitionSeq).
+Created and updated nodes and edges are recorded with their full state which
+makes the application of a change idempotent.
+
+Replica
+
+A Replica tails the change log of a primary instance over HTTP and applies the
+changes to a local GraphManager. A new replica (or a replica which fell behind
+further than the change log of the primary reaches) first loads a snapshot of
+all partitions from the primary. Nodes and edges of the replica which are not
+part of the snapshot are removed once the snapshot was loaded.
+
+Changes and snapshots are transferred as gob streams which preserve the types
+of attribute values.
+
+Replication is eventually consistent - a replica may lag behind its primary.
+
+Raft
+
+A RaftNode replicates the changes of a GraphManager through the Raft consensus
+protocol. The members of a cluster elect a leader which accepts writes - each
+change of the leader is committed by a majority of the members before the
+write returns. Followers apply committed changes and serve reads. New members
+and members which fell behind the compacted log install a snapshot.
+*/
+package replication
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/cryptutil"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+Operations which are recorded in the change log
+*/
+const (
+	OpStoreNode  = "node.store"
+	OpDeleteNode = "node.delete"
+	OpStoreEdge  = "edge.store"
+	OpDeleteEdge = "edge.delete"
+)
+
+/*
+ErrChangesTruncated is returned if requested changes are no longer in the change log.
+*/
+var ErrChangesTruncated = errors.New("Requested changes are no longer available")
+
+/*
+Change models a single change of the graph.
+*/
+type Change struct {
+	Seq     uint64                 `json:"seq"`            // Sequence number of the change
+	PartSeq uint64                 `json:"part_seq"`       // Commit sequence number of the change in its partition
+	Time    int64                  `json:"time"`           // Time of the change (Unix nano seconds)
+	Op      string                 `json:"op"`             // Operation of the change
+	Part    string                 `json:"part"`           // Partition of the change
+	Key     string                 `json:"key"`            // Key of the changed node or edge
+	Kind    string                 `json:"kind"`           // Kind of the changed node or edge
+	Data    map[string]interface{} `json:"data,omitempty"` // New state of the node or edge
+}
+
+/*
+ChangeLog records changes of a GraphManager.
+*/
+type ChangeLog struct {
+	id      string        // Unique ID of the change log
+	changes []*Change     // Ring buffer of changes
+	start   int           // Position of the oldest change in the ring buffer
+	size    int           // Number of changes in the ring buffer
+	seq     uint64        // Sequence number of the last change
+	notify  chan struct{} // Channel which is closed once a change was added
+	lock    *sync.RWMutex // Lock for the change log
+}
+
+/*
+NewChangeLog creates a new change log which holds a given maximum number of changes.
+*/
+func NewChangeLog(capacity int) *ChangeLog {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ChangeLog{fmt.Sprintf("%x", cryptutil.GenerateUUID()),
+		make([]*Change, capacity), 0, 0, 0, make(chan struct{}), &sync.RWMutex{}}
+}
+
+/*
+ID returns the unique ID of this change log. Sequence numbers of different
+change logs are not related.
+*/
+func (cl *ChangeLog) ID() string {
+	return cl.id
+}
+
+/*
+Capacity returns the maximum number of changes which are held by this change log.
+*/
+func (cl *ChangeLog) Capacity() int {
+	return len(cl.changes)
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (cl *ChangeLog) Name() string {
+	return "system.changelog"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (cl *ChangeLog) Handles() []int {
+	return []int{graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventNodeDeleted,
+		graph.EventEdgeCreated, graph.EventEdgeUpdated, graph.EventEdgeDeleted}
+}
+
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+*/
+func (cl *ChangeLog) PartitionOnly() bool {
+	return true
+}
+
+/*
+Handle handles an event.
+*/
+func (cl *ChangeLog) Handle(gm *graph.Manager, trans graph.Trans, event int, ed ...interface{}) error {
+	c, err := newChange(gm, event, ed...)
+
+	if err == nil {
+		cl.add(c)
+	}
+
+	return err
+}
+
+/*
+newChange creates a change (without sequence number and time) for a graph event.
+*/
+func newChange(gm *graph.Manager, event int, ed ...interface{}) (*Change, error) {
+	var err error
+
+	part := ed[0].(string)
+
+	// The commit sequence number of the partition belongs to the write
+	// which caused the event
+
+	c := &Change{Part: part, PartSeq: gm.PartitionSeq(part)}
+
+	switch event {
+
+	case graph.EventNodeCreated, graph.EventNodeUpdated:
+		var node data.Node
+
+		// Record the full state of the node since an update only contains
+		// the changed attributes
+
+		c.Op = OpStoreNode
+		c.Key, c.Kind = ed[1].(data.Node).Key(), ed[1].(data.Node).Kind()
+
+		if node, err = gm.FetchNode(part, c.Key, c.Kind); err == nil && node != nil {
+			c.Data = node.Data()
+		}
+
+	case graph.EventEdgeCreated, graph.EventEdgeUpdated:
+		var edge data.Edge
+
+		c.Op = OpStoreEdge
+		c.Key, c.Kind = ed[1].(data.Edge).Key(), ed[1].(data.Edge).Kind()
+
+		if edge, err = gm.FetchEdge(part, c.Key, c.Kind); err == nil && edge != nil {
+			c.Data = edge.Data()
+		}
+
+	case graph.EventNodeDeleted:
+		c.Op = OpDeleteNode
+		c.Key, c.Kind = ed[1].(data.Node).Key(), ed[1].(data.Node).Kind()
+
+	case graph.EventEdgeDeleted:
+		c.Op = OpDeleteEdge
+		c.Key, c.Kind = ed[1].(data.Edge).Key(), ed[1].(data.Edge).Kind()
+	}
+
+	return c, err
+}
+
+/*
+add adds a change to the log.
+*/
+func (cl *ChangeLog) add(c *Change) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	cl.seq++
+	c.Seq = cl.seq
+	c.Time = time.Now().UnixNano()
+
+	pos := (cl.start + cl.size) % len(cl.changes)
+	cl.changes[pos] = c
+
+	if cl.size < len(cl.changes) {
+		cl.size++
+	} else {
+		cl.start = (cl.start + 1) % len(cl.changes)
+	}
+
+	// Wake up all waiting consumers
+
+	close(cl.notify)
+	cl.notify = make(chan struct{})
+}
+
+/*
+Notify returns a channel which is closed once the next change was added to the
+log. Consumers should get the channel before they read the changes of the log
+so no change is missed.
+*/
+func (cl *ChangeLog) Notify() <-chan struct{} {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	return cl.notify
+}
+
+/*
+LastSeq returns the sequence number and the time of the last change.
+*/
+func (cl *ChangeLog) LastSeq() (uint64, int64) {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	var t int64
+
+	if cl.size > 0 {
+		t = cl.changes[(cl.start+cl.size-1)%len(cl.changes)].Time
+	}
+
+	return cl.seq, t
+}
+
+/*
+Changes returns up to limit changes after a given sequence number. A limit
+smaller than 1 returns all available changes. Returns ErrChangesTruncated if
+changes after the given sequence number have been dropped from the log.
+*/
+func (cl *ChangeLog) Changes(since uint64, limit int) ([]*Change, error) {
+	ret, _, err := cl.FilteredChanges(since, limit, nil)
+	return ret, err
+}
+
+/*
+ChangeFilter selects changes of the change log.
+*/
+type ChangeFilter func(c *Change) bool
+
+/*
+FilteredChanges returns up to limit changes after a given sequence number which
+are selected by a given filter (nil selects all changes). The second return
+value is the sequence number of the last examined change which should be used
+as the start of the next request. Returns ErrChangesTruncated if changes after
+the given sequence number have been dropped from the log.
+*/
+func (cl *ChangeLog) FilteredChanges(since uint64, limit int, filter ChangeFilter) ([]*Change, uint64, error) {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	first := cl.seq - uint64(cl.size) + 1
+
+	if since+1 < first {
+		return nil, since, ErrChangesTruncated
+	}
+
+	ret := make([]*Change, 0)
+	next := since
+
+	for i := since + 1; i <= cl.seq; i++ {
+
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+
+		c := cl.changes[(cl.start+int(i-first))%len(cl.changes)]
+		next = i
+
+		if filter == nil || filter(c) {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret, next, nil
+}
+
+/*
+ChangesSince returns all changes which happened after a given time (Unix nano
+seconds). Changes which have been dropped from the log are not returned.
+*/
+func (cl *ChangeLog) ChangesSince(t int64) []*Change {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	// Changes are ordered by time - search the first change after the given time
+
+	i := sort.Search(cl.size, func(i int) bool {
+		return cl.changes[(cl.start+i)%len(cl.changes)].Time > t
+	})
+
+	ret := make([]*Change, 0, cl.size-i)
+
+	for ; i < cl.size; i++ {
+		ret = append(ret, cl.changes[(cl.start+i)%len(cl.changes)])
+	}
+
+	return ret
+}
+
+/*
+ContentTypeGob is the content type of gob encoded replication data. Gob
+preserves the types of attribute values (e.g. dates and decimals) which are
+lost in JSON.
+*/
+const ContentTypeGob = "application/x-gob"
+
+/*
+OpSnapshotEnd is the operation of the last entry of a complete snapshot.
+*/
+const OpSnapshotEnd = "snapshot.end"
+
+/*
+SnapshotHeader is the first object of a snapshot stream.
+*/
+type SnapshotHeader struct {
+	ID  string // ID of the change log
+	Seq uint64 // Sequence number of the last change before the snapshot
+}
+
+/*
+SnapshotEntry is a node or an edge of a snapshot stream. The nodes of a
+partition are written before its edges. The last entry of a complete stream
+has the operation OpSnapshotEnd.
+*/
+type SnapshotEntry struct {
+	Part string                 // Partition of the node or edge
+	Op   string                 // OpStoreNode, OpStoreEdge or OpSnapshotEnd
+	Data map[string]interface{} // State of the node or edge
+}
+
+/*
+DataTranslation translates the keys of node or edge data. Translations are used
+if keys are obfuscated at the REST boundary of a primary. A translation may
+return the given map or a modified copy.
+*/
+type DataTranslation func(nodeData map[string]interface{}) (map[string]interface{}, error)
+
+/*
+WriteSnapshot writes all partitions of a given GraphManager as a gob stream.
+The stream consists of a SnapshotHeader followed by SnapshotEntry objects.
+Nodes and edges are read one by one so the snapshot is not held in memory.
+Changes which are made while the snapshot is written are contained in the
+change log after the sequence number of the header. An optional translation
+is applied to the data of all nodes and edges.
+*/
+func (cl *ChangeLog) WriteSnapshot(w io.Writer, gm *graph.Manager, translate DataTranslation) error {
+	seq, _ := cl.LastSeq()
+
+	return writeSnapshot(w, gm, &SnapshotHeader{cl.id, seq}, translate)
+}
+
+/*
+writeSnapshot writes all partitions of a given GraphManager as a gob stream
+with a given header.
+*/
+func writeSnapshot(w io.Writer, gm *graph.Manager, header *SnapshotHeader, translate DataTranslation) error {
+	enc := gob.NewEncoder(w)
+
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	write := func(part string, op string, nodeData map[string]interface{}) error {
+		var err error
+
+		if translate != nil {
+			if nodeData, err = translate(nodeData); err != nil {
+				return err
+			}
+		}
+
+		return enc.Encode(&SnapshotEntry{part, op, nodeData})
+	}
+
+	for _, part := range gm.Partitions() {
+		if err := writeSnapshotNodes(write, part, gm, false); err != nil {
+			return err
+		}
+		if err := writeSnapshotNodes(write, part, gm, true); err != nil {
+			return err
+		}
+	}
+
+	return enc.Encode(&SnapshotEntry{Op: OpSnapshotEnd})
+}
+
+/*
+WalkSnapshot calls a given function for all nodes and edges of the given
+partitions of a GraphManager. The nodes of a partition are visited before its
+edges. Returns the sequence number of the last change before the snapshot -
+changes which are made while the snapshot is read are contained in the change
+log after this number.
+*/
+func (cl *ChangeLog) WalkSnapshot(gm *graph.Manager, parts []string,
+	f func(part string, op string, nodeData map[string]interface{}) error) (uint64, error) {
+
+	seq, _ := cl.LastSeq()
+
+	for _, part := range parts {
+		if err := writeSnapshotNodes(f, part, gm, false); err != nil {
+			return seq, err
+		}
+		if err := writeSnapshotNodes(f, part, gm, true); err != nil {
+			return seq, err
+		}
+	}
+
+	return seq, nil
+}
+
+/*
+writeSnapshotNodes writes either all nodes of a partition or all edges of
+a partition. Edges are found by traversing from their first end.
+*/
+func writeSnapshotNodes(write func(string, string, map[string]interface{}) error,
+	part string, gm *graph.Manager, edges bool) error {
+
+	for _, kind := range gm.NodeKinds() {
+
+		it, err := gm.NodeKeySnapshotIterator(part, kind)
+		if err != nil {
+			return err
+		} else if it == nil {
+			continue
+		}
+
+		for it.HasNext() {
+			key := it.Next()
+
+			if it.LastError != nil {
+				return it.LastError
+			}
+
+			if !edges {
+				node, err := gm.FetchNode(part, key, kind)
+
+				if err == nil && node != nil {
+					err = write(part, OpStoreNode, node.Data())
+				}
+
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			_, travEdges, err := gm.TraverseMulti(part, key, kind, ":::", false)
+			if err != nil {
+				return err
+			}
+
+			written := make(map[string]bool)
+
+			for _, travEdge := range travEdges {
+				ekey := travEdge.Kind() + "#" + travEdge.Key()
+
+				if written[ekey] {
+					continue
+				}
+
+				edge, err := gm.FetchEdge(part, travEdge.Key(), travEdge.Kind())
+
+				if err == nil && edge != nil && edge.End1Key() == key && edge.End1Kind() == kind {
+					written[ekey] = true
+					err = write(part, OpStoreEdge, edge.Data())
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
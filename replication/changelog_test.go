@@ -0,0 +1,247 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestChangeLog(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	cl := NewChangeLog(3)
+	gm.SetGraphRule(cl)
+
+	if cl.Name() != "system.changelog" || len(cl.ID()) != 32 || cl.Capacity() != 3 {
+		t.Error("Unexpected result:", cl.Name(), cl.ID(), cl.Capacity())
+		return
+	}
+
+	if seq, tm := cl.LastSeq(); seq != 0 || tm != 0 {
+		t.Error("Unexpected result:", seq, tm)
+		return
+	}
+
+	node1 := data.NewGraphNode()
+	node1.SetAttr("key", "123")
+	node1.SetAttr("kind", "mykind")
+	node1.SetAttr("name", "foo")
+	gm.StoreNode("main", node1)
+
+	// Updates are recorded with the full state of the node
+
+	node1 = data.NewGraphNode()
+	node1.SetAttr("key", "123")
+	node1.SetAttr("kind", "mykind")
+	node1.SetAttr("age", 42)
+	gm.UpdateNode("main", node1)
+
+	changes, err := cl.Changes(0, -1)
+
+	if err != nil || len(changes) != 2 {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	if res := fmt.Sprintf("%v %v %v %v", changes[1].Seq, changes[1].Op, changes[1].Part, changes[1].Data); res !=
+		"2 node.store main map[age:42 key:123 kind:mykind name:foo]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if seq, tm := cl.LastSeq(); seq != 2 || tm != changes[1].Time {
+		t.Error("Unexpected result:", seq, tm)
+		return
+	}
+
+	node2 := data.NewGraphNode()
+	node2.SetAttr("key", "456")
+	node2.SetAttr("kind", "mykind")
+	gm.StoreNode("main", node2)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "abc")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "123")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "node1")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "456")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "node2")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+	gm.StoreEdge("main", edge)
+
+	// The first change has been dropped from the log
+
+	if _, err := cl.Changes(0, -1); err != ErrChangesTruncated {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	changes, err = cl.Changes(2, 1)
+
+	if err != nil || len(changes) != 1 || changes[0].Seq != 3 || changes[0].Key != "456" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	// Changes can be requested by time
+
+	if res := cl.ChangesSince(0); len(res) != 3 || res[0].Seq != 2 || res[2].Seq != 4 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := cl.ChangesSince(changes[0].Time); len(res) != 1 || res[0].Key != "abc" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, tm := cl.LastSeq(); len(cl.ChangesSince(tm)) != 0 {
+		t.Error("Unexpected result:", cl.ChangesSince(tm))
+		return
+	}
+
+	// Deleting a node also deletes its edges
+
+	gm.RemoveNode("main", "123", "mykind")
+
+	changes, err = cl.Changes(4, -1)
+
+	if err != nil || len(changes) != 2 {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	if res := fmt.Sprintf("%v %v %v %v", changes[0].Op, changes[0].Key, changes[1].Op, changes[1].Key); res !=
+		"node.delete 123 edge.delete abc" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Changes carry the commit sequence number of the partition
+
+	if res := fmt.Sprintf("%v %v", changes[0].PartSeq, changes[1].PartSeq); res != "5 6" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if changes, err = cl.Changes(6, -1); err != nil || len(changes) != 0 {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	// Changes can be filtered - the next sequence number skips changes which
+	// were not selected
+
+	isEdge := func(c *Change) bool {
+		return c.Kind == "myedge"
+	}
+
+	if changes, next, err := cl.FilteredChanges(3, -1, func(c *Change) bool {
+		return c.Op == OpDeleteNode
+	}); err != nil || len(changes) != 1 || changes[0].Seq != 5 || next != 6 {
+		t.Error("Unexpected result:", changes, next, err)
+		return
+	}
+
+	if changes, next, err := cl.FilteredChanges(3, 1, isEdge); err != nil ||
+		len(changes) != 1 || changes[0].Seq != 4 || next != 4 {
+		t.Error("Unexpected result:", changes, next, err)
+		return
+	}
+
+	if changes, next, err := cl.FilteredChanges(4, 1, isEdge); err != nil ||
+		len(changes) != 1 || changes[0].Seq != 6 || next != 6 {
+		t.Error("Unexpected result:", changes, next, err)
+		return
+	}
+
+	if changes, next, err := cl.FilteredChanges(0, 1, isEdge); err != ErrChangesTruncated ||
+		changes != nil || next != 0 {
+		t.Error("Unexpected result:", changes, next, err)
+		return
+	}
+
+	// Consumers are notified about new changes
+
+	notify := cl.Notify()
+
+	select {
+	case <-notify:
+		t.Error("Unexpected notification")
+		return
+	default:
+	}
+
+	// Check snapshot - nodes are written before edges
+
+	gm.StoreNode("main", node1)
+	gm.StoreEdge("main", edge)
+
+	select {
+	case <-notify:
+	default:
+		t.Error("Consumer was not notified")
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if err := cl.WriteSnapshot(&buf, gm, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var header SnapshotHeader
+
+	dec := gob.NewDecoder(&buf)
+
+	if err := dec.Decode(&header); err != nil || header.Seq != 8 || header.ID != cl.ID() {
+		t.Error("Unexpected result:", header, err)
+		return
+	}
+
+	var ops []string
+
+	for {
+		var entry SnapshotEntry
+
+		if err := dec.Decode(&entry); err != nil {
+			t.Error(err)
+			return
+		}
+
+		ops = append(ops, fmt.Sprint(entry.Op, " ", entry.Part, " ", entry.Data["key"]))
+
+		if entry.Op == OpSnapshotEnd {
+			break
+		}
+	}
+
+	sort.Strings(ops[:2])
+
+	if res := fmt.Sprint(ops); res !=
+		"[node.store main 123 node.store main 456 edge.store main abc snapshot.end  <nil>]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,522 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+ClusterPath is the path of the cluster endpoint relative to the URL of a member.
+*/
+var ClusterPath = "/db/v1/cluster/"
+
+/*
+RaftTimeout is the heartbeat and election timeout of the Raft protocol.
+*/
+var RaftTimeout = 1 * time.Second
+
+/*
+RaftApplyTimeout is the maximum time to wait until a change was committed by
+a majority of the cluster.
+*/
+var RaftApplyTimeout = 10 * time.Second
+
+/*
+RaftSnapshotThreshold is the number of log entries after which a snapshot of
+the graph is taken and the log is compacted.
+*/
+var RaftSnapshotThreshold uint64 = 8192
+
+/*
+RaftTrailingLogs is the number of log entries which are kept after a snapshot
+so slow followers do not need to install a snapshot.
+*/
+var RaftTrailingLogs uint64 = 10240
+
+/*
+ErrNotLeader is returned if an operation needs the leader of the cluster.
+*/
+var ErrNotLeader = errors.New("This member is not the leader of the cluster")
+
+/*
+RaftConfig is the configuration of a Raft cluster member.
+*/
+type RaftConfig struct {
+	ID        string         // Unique ID of the member (e.g. its advertised URL)
+	BindAddr  string         // Address of the Raft transport (host:port)
+	Advertise string         // Advertised address of the Raft transport (defaults to BindAddr)
+	Dir       string         // Directory of the Raft log and snapshots (in-memory if empty)
+	Bootstrap bool           // Flag to bootstrap a new cluster with this member
+	Transport raft.Transport // Transport to use instead of TCP (e.g. for tests)
+	LogOutput io.Writer      // Output of Raft log messages (discarded if nil)
+
+	// Handler which is called when the member becomes the leader and
+	// accepts writes or when it loses its leadership (optional)
+
+	LeaderHandler func(leader bool)
+}
+
+/*
+raftEntry is an entry of the replicated log.
+*/
+type raftEntry struct {
+	Origin string  // ID of the member which made the change
+	Change *Change // Change of the graph
+}
+
+/*
+RaftNode replicates the changes of a GraphManager to all members of a Raft
+cluster. Only the leader of the cluster accepts writes. Every change of the
+leader's graph is proposed to the cluster before the write returns. Followers
+apply the committed changes to their graph and serve reads.
+
+Changes are applied to the leader's graph before they are proposed. A write
+whose proposal fails (e.g. because the leader lost its leadership) returns an
+error but may remain on the former leader.
+*/
+type RaftNode struct {
+	id        string            // ID of this member
+	raft      *raft.Raft        // Raft instance
+	gm        *graph.Manager    // GraphManager which is replicated
+	notify    chan bool         // Channel for leadership changes
+	handler   func(leader bool) // Handler for leadership changes
+	ready     bool              // Flag if this member is the leader and accepts writes
+	lastError error             // Last error which occurred when applying a change
+	closers   []io.Closer       // Stores which need to be closed on shutdown
+	lock      *sync.RWMutex     // Lock for the node state
+	wg        *sync.WaitGroup   // Wait group for the leadership loop
+}
+
+/*
+NewRaftNode creates a new Raft cluster member which replicates a given
+GraphManager. The member bootstraps a new cluster if requested and if it has
+no existing state. Other members need to be added with Join on the leader.
+*/
+func NewRaftNode(config *RaftConfig, gm *graph.Manager) (*RaftNode, error) {
+	var logs raft.LogStore
+	var stable raft.StableStore
+	var snaps raft.SnapshotStore
+	var err error
+
+	rn := &RaftNode{config.ID, nil, gm, make(chan bool, 1), config.LeaderHandler, false, nil,
+		nil, &sync.RWMutex{}, &sync.WaitGroup{}}
+
+	logOutput := config.LogOutput
+	if logOutput == nil {
+		logOutput = ioutil.Discard
+	}
+
+	rconfig := raft.DefaultConfig()
+	rconfig.LocalID = raft.ServerID(config.ID)
+	rconfig.HeartbeatTimeout = RaftTimeout
+	rconfig.ElectionTimeout = RaftTimeout
+	rconfig.LeaderLeaseTimeout = RaftTimeout / 2
+	rconfig.SnapshotThreshold = RaftSnapshotThreshold
+	rconfig.TrailingLogs = RaftTrailingLogs
+	rconfig.NotifyCh = rn.notify
+	rconfig.LogOutput = logOutput
+	rconfig.LogLevel = "WARN"
+
+	if config.Dir == "" {
+		store := raft.NewInmemStore()
+		logs, stable, snaps = store, store, raft.NewInmemSnapshotStore()
+
+	} else {
+		var store *raftboltdb.BoltStore
+
+		if err = os.MkdirAll(config.Dir, 0770); err == nil {
+			if store, err = raftboltdb.NewBoltStore(filepath.Join(config.Dir, "raft.db")); err == nil {
+				logs, stable = store, store
+				rn.closers = append(rn.closers, store)
+
+				snaps, err = raft.NewFileSnapshotStore(config.Dir, 2, logOutput)
+			}
+		}
+	}
+
+	trans := config.Transport
+
+	if err == nil && trans == nil {
+		var addr *net.TCPAddr
+		var tcpTrans *raft.NetworkTransport
+
+		advertise := config.Advertise
+		if advertise == "" {
+			advertise = config.BindAddr
+		}
+
+		if addr, err = net.ResolveTCPAddr("tcp", advertise); err == nil {
+			if tcpTrans, err = raft.NewTCPTransport(config.BindAddr, addr, 3, RaftTimeout*10, logOutput); err == nil {
+				trans = tcpTrans
+				rn.closers = append(rn.closers, tcpTrans)
+			}
+		}
+	}
+
+	if err == nil && config.Bootstrap {
+		var exists bool
+
+		if exists, err = raft.HasExistingState(logs, stable, snaps); err == nil && !exists {
+			err = raft.BootstrapCluster(rconfig, logs, stable, snaps, trans, raft.Configuration{
+				Servers: []raft.Server{{Suffrage: raft.Voter, ID: rconfig.LocalID, Address: trans.LocalAddr()}},
+			})
+		}
+	}
+
+	if err == nil {
+		rn.raft, err = raft.NewRaft(rconfig, &raftFSM{rn}, logs, stable, snaps, trans)
+	}
+
+	if err != nil {
+		rn.close()
+		return nil, err
+	}
+
+	rn.wg.Add(1)
+	go rn.watchLeadership()
+
+	return rn, nil
+}
+
+/*
+watchLeadership watches the leadership of this member. A new leader accepts
+writes once all committed changes of the previous leader have been applied.
+*/
+func (rn *RaftNode) watchLeadership() {
+	defer rn.wg.Done()
+
+	for leader := range rn.notify {
+
+		// Changes which are applied from the log must not be proposed again
+
+		for leader {
+			err := rn.raft.Barrier(RaftApplyTimeout).Error()
+
+			if err == nil {
+				break
+			}
+
+			leader = err != raft.ErrRaftShutdown && rn.raft.State() == raft.Leader
+		}
+
+		rn.lock.Lock()
+
+		rn.ready = leader && rn.raft.State() == raft.Leader
+		ready := rn.ready
+
+		rn.lock.Unlock()
+
+		if rn.handler != nil {
+			rn.handler(ready)
+		}
+	}
+}
+
+/*
+IsLeader returns if this member is the leader of the cluster and accepts writes.
+*/
+func (rn *RaftNode) IsLeader() bool {
+	rn.lock.RLock()
+	defer rn.lock.RUnlock()
+
+	return rn.ready
+}
+
+/*
+Leader returns the ID of the current leader of the cluster (empty if unknown).
+*/
+func (rn *RaftNode) Leader() string {
+	_, id := rn.raft.LeaderWithID()
+	return string(id)
+}
+
+/*
+Join adds a new voting member to the cluster. Can only be called on the leader.
+*/
+func (rn *RaftNode) Join(id string, addr string) error {
+	if rn.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	return rn.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, RaftApplyTimeout).Error()
+}
+
+/*
+Remove removes a member from the cluster. Can only be called on the leader.
+*/
+func (rn *RaftNode) Remove(id string) error {
+	if rn.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	return rn.raft.RemoveServer(raft.ServerID(id), 0, RaftApplyTimeout).Error()
+}
+
+/*
+Members returns all members of the cluster.
+*/
+func (rn *RaftNode) Members() ([]map[string]interface{}, error) {
+	future := rn.raft.GetConfiguration()
+
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]map[string]interface{}, 0)
+
+	for _, s := range future.Configuration().Servers {
+		ret = append(ret, map[string]interface{}{
+			"id":      string(s.ID),
+			"address": string(s.Address),
+			"voter":   s.Suffrage == raft.Voter,
+		})
+	}
+
+	return ret, nil
+}
+
+/*
+Status returns the status of this member.
+*/
+func (rn *RaftNode) Status() map[string]interface{} {
+	var lastError interface{}
+
+	members, err := rn.Members()
+	if err != nil {
+		lastError = err.Error()
+	}
+
+	leaderAddr, leaderID := rn.raft.LeaderWithID()
+
+	rn.lock.RLock()
+	defer rn.lock.RUnlock()
+
+	if rn.lastError != nil {
+		lastError = rn.lastError.Error()
+	}
+
+	return map[string]interface{}{
+		"id":             rn.id,
+		"state":          strings.ToLower(rn.raft.State().String()),
+		"leader":         string(leaderID),
+		"leader_address": string(leaderAddr),
+		"writable":       rn.ready,
+		"term":           rn.raft.CurrentTerm(),
+		"last_index":     rn.raft.LastIndex(),
+		"commit_index":   rn.raft.CommitIndex(),
+		"applied_index":  rn.raft.AppliedIndex(),
+		"members":        members,
+		"last_error":     lastError,
+	}
+}
+
+/*
+Shutdown stops this member.
+*/
+func (rn *RaftNode) Shutdown() error {
+	if rn.raft.State() == raft.Shutdown {
+		return nil
+	}
+
+	err := rn.raft.Shutdown().Error()
+
+	close(rn.notify)
+	rn.wg.Wait()
+
+	rn.close()
+
+	return err
+}
+
+/*
+close closes all stores and the transport of this member.
+*/
+func (rn *RaftNode) close() {
+	for _, c := range rn.closers {
+		c.Close()
+	}
+	rn.closers = nil
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (rn *RaftNode) Name() string {
+	return "system.raft"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (rn *RaftNode) Handles() []int {
+	return []int{graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventNodeDeleted,
+		graph.EventEdgeCreated, graph.EventEdgeUpdated, graph.EventEdgeDeleted}
+}
+
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+*/
+func (rn *RaftNode) PartitionOnly() bool {
+	return true
+}
+
+/*
+Handle handles an event. The leader proposes each change to the cluster and
+waits until it was committed. Changes of followers come from the log and are
+not proposed.
+*/
+func (rn *RaftNode) Handle(gm *graph.Manager, trans graph.Trans, event int, ed ...interface{}) error {
+	var buf bytes.Buffer
+
+	if !rn.IsLeader() {
+		return nil
+	}
+
+	c, err := newChange(gm, event, ed...)
+
+	if err == nil {
+		c.Time = time.Now().UnixNano()
+
+		if err = gob.NewEncoder(&buf).Encode(&raftEntry{rn.id, c}); err == nil {
+			err = rn.raft.Apply(buf.Bytes(), RaftApplyTimeout).Error()
+		}
+	}
+
+	return err
+}
+
+/*
+raftFSM applies the replicated log to the graph of a member.
+*/
+type raftFSM struct {
+	rn *RaftNode
+}
+
+/*
+Apply applies a committed log entry. Changes which were made by this member
+are already part of its graph.
+*/
+func (fsm *raftFSM) Apply(l *raft.Log) interface{} {
+	var entry raftEntry
+
+	err := gob.NewDecoder(bytes.NewReader(l.Data)).Decode(&entry)
+
+	if err == nil && entry.Origin != fsm.rn.id {
+		err = applyChange(fsm.rn.gm, entry.Change)
+	}
+
+	if err != nil {
+		fsm.rn.lock.Lock()
+		fsm.rn.lastError = err
+		fsm.rn.lock.Unlock()
+	}
+
+	return err
+}
+
+/*
+Snapshot returns a snapshot of the graph. The graph is read when the snapshot
+is persisted - changes which are made in the meantime are contained in the
+log after the snapshot and are applied again when a member is restored.
+*/
+func (fsm *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &raftSnapshot{fsm.rn}, nil
+}
+
+/*
+Restore replaces the graph with a snapshot.
+*/
+func (fsm *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	_, err := readSnapshot(rc, fsm.rn.gm, nil)
+
+	return err
+}
+
+/*
+raftSnapshot is a snapshot of the graph of a member.
+*/
+type raftSnapshot struct {
+	rn *RaftNode
+}
+
+/*
+Persist writes the snapshot to a given sink.
+*/
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := writeSnapshot(sink, s.rn.gm, &SnapshotHeader{ID: s.rn.id}, nil)
+
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+/*
+Release is called once the snapshot is no longer needed.
+*/
+func (s *raftSnapshot) Release() {
+}
+
+/*
+JoinRaft asks a member of a cluster (e.g. https://host:9090) to add this
+member. The request is redirected to the leader if the contacted member is a
+follower.
+*/
+func JoinRaft(client *http.Client, member string, id string, addr string) error {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	body, _ := json.Marshal(map[string]string{"name": id, "netaddr": addr})
+
+	req, err := http.NewRequest("PUT", strings.TrimSuffix(member, "/")+ClusterPath+"join",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		err = fmt.Errorf("Could not join cluster: %v", strings.TrimSpace(string(msg)))
+	}
+
+	return err
+}
@@ -0,0 +1,303 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+/*
+waitFor waits until a given condition is true.
+*/
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+/*
+fetchTestName returns the name attribute of a test node (empty if the node
+does not exist).
+*/
+func fetchTestName(gm *graph.Manager, key string) string {
+	if node, err := gm.FetchNode("main", key, "mykind"); err == nil && node != nil {
+		return fmt.Sprint(node.Attr("name"))
+	}
+	return ""
+}
+
+func TestRaftNode(t *testing.T) {
+	oldRaftTimeout := RaftTimeout
+	oldTrailingLogs := RaftTrailingLogs
+	RaftTimeout = 100 * time.Millisecond
+	RaftTrailingLogs = 0
+	defer func() {
+		RaftTimeout = oldRaftTimeout
+		RaftTrailingLogs = oldTrailingLogs
+	}()
+
+	// Create the transports of all members
+
+	var addrs []raft.ServerAddress
+	var transports []*raft.InmemTransport
+
+	for i := 0; i < 4; i++ {
+		addr, trans := raft.NewInmemTransport("")
+		addrs = append(addrs, addr)
+		transports = append(transports, trans)
+	}
+
+	for i, t1 := range transports {
+		for j, t2 := range transports {
+			if i != j {
+				t1.Connect(addrs[j], t2)
+			}
+		}
+	}
+
+	var gms []*graph.Manager
+	var nodes []*RaftNode
+	var leaderChanges []bool
+	var leaderLock sync.Mutex
+
+	for i := 0; i < 4; i++ {
+		gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage(fmt.Sprint("member", i)))
+
+		config := &RaftConfig{ID: fmt.Sprint("n", i), Bootstrap: i == 0, Transport: transports[i]}
+
+		if i == 0 {
+			config.LeaderHandler = func(leader bool) {
+				leaderLock.Lock()
+				defer leaderLock.Unlock()
+
+				leaderChanges = append(leaderChanges, leader)
+			}
+		}
+
+		rn, err := NewRaftNode(config, gm)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer rn.Shutdown()
+
+		gm.SetGraphRule(rn)
+
+		gms = append(gms, gm)
+		nodes = append(nodes, rn)
+	}
+
+	if !waitFor(nodes[0].IsLeader) {
+		t.Error("Bootstrapped member did not become the leader")
+		return
+	}
+
+	// Data of the leader is replicated to new members
+
+	storeTestNode(gms[0], "123", "foo")
+
+	if err := nodes[1].Join("n2", string(addrs[2])); err != ErrNotLeader {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	for i := 1; i < 3; i++ {
+		if err := nodes[0].Join(fmt.Sprint("n", i), string(addrs[i])); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for i := 1; i < 3; i++ {
+		gm := gms[i]
+
+		if !waitFor(func() bool { return fetchTestName(gm, "123") == "foo" }) {
+			t.Error("Change was not replicated to member", i)
+			return
+		}
+	}
+
+	if leader := nodes[2].Leader(); leader != "n0" {
+		t.Error("Unexpected leader:", leader)
+		return
+	}
+
+	status := nodes[0].Status()
+
+	if status["state"] != "leader" || status["writable"] != true ||
+		len(status["members"].([]map[string]interface{})) != 3 || status["last_error"] != nil {
+		t.Error("Unexpected status:", status)
+		return
+	}
+
+	if status := nodes[1].Status(); status["state"] != "follower" || status["writable"] != false ||
+		status["leader"] != "n0" {
+		t.Error("Unexpected status:", status)
+		return
+	}
+
+	// Updates, edges and deletions are replicated
+
+	storeTestNode(gms[0], "123", "bar")
+	storeTestNode(gms[0], "456", "baz")
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "link")
+	edge.SetAttr(data.EdgeEnd1Key, "123")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "456")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := gms[0].StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !waitFor(func() bool {
+		e, _ := gms[2].FetchEdge("main", "e1", "link")
+		return e != nil && fetchTestName(gms[2], "123") == "bar"
+	}) {
+		t.Error("Changes were not replicated")
+		return
+	}
+
+	if _, err := gms[0].RemoveNode("main", "456", "mykind"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !waitFor(func() bool {
+		e, _ := gms[1].FetchEdge("main", "e1", "link")
+		return e == nil && fetchTestName(gms[1], "456") == ""
+	}) {
+		t.Error("Deletion was not replicated")
+		return
+	}
+
+	// Changes which are applied by followers are not proposed again
+
+	if last := nodes[0].raft.LastIndex(); nodes[1].raft.LastIndex() != last {
+		t.Error("Unexpected log index:", last, nodes[1].raft.LastIndex())
+		return
+	}
+
+	// A new member receives a snapshot once the log was compacted - data
+	// which is not part of the snapshot is removed
+
+	storeTestNode(gms[3], "stale", "x")
+
+	if err := nodes[0].raft.Snapshot().Error(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := nodes[0].Join("n3", string(addrs[3])); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !waitFor(func() bool {
+		return fetchTestName(gms[3], "123") == "bar" && fetchTestName(gms[3], "stale") == ""
+	}) {
+		t.Error("Snapshot was not installed")
+		return
+	}
+
+	if err := nodes[0].Remove("n3"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if members, _ := nodes[0].Members(); len(members) != 3 {
+		t.Error("Unexpected members:", members)
+		return
+	}
+
+	// A new leader is elected if the leader fails
+
+	nodes[0].Shutdown()
+
+	leaderLock.Lock()
+	if fmt.Sprint(leaderChanges) != "[true false]" {
+		t.Error("Unexpected leader changes:", leaderChanges)
+	}
+	leaderLock.Unlock()
+
+	if !waitFor(func() bool { return nodes[1].IsLeader() || nodes[2].IsLeader() }) {
+		t.Error("No new leader was elected")
+		return
+	}
+
+	leader, follower := 1, 2
+	if nodes[2].IsLeader() {
+		leader, follower = 2, 1
+	}
+
+	storeTestNode(gms[leader], "789", "new")
+
+	if !waitFor(func() bool { return fetchTestName(gms[follower], "789") == "new" }) {
+		t.Error("Change of the new leader was not replicated")
+		return
+	}
+}
+
+func TestJoinRaft(t *testing.T) {
+	var received string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body [100]byte
+
+		n, _ := r.Body.Read(body[:])
+		received = r.Method + " " + r.URL.Path + " " + string(body[:n])
+
+		if r.URL.Path != "/db/v1/cluster/join" {
+			http.Error(w, "Unknown command", http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	if err := JoinRaft(nil, ts.URL+"/", "n1", "host:9091"); err != nil ||
+		received != `PUT /db/v1/cluster/join {"name":"n1","netaddr":"host:9091"}` {
+		t.Error("Unexpected result:", received, err)
+		return
+	}
+
+	oldClusterPath := ClusterPath
+	ClusterPath = "/foo/"
+	defer func() {
+		ClusterPath = oldClusterPath
+	}()
+
+	if err := JoinRaft(nil, ts.URL, "n1", "host:9091"); err == nil ||
+		err.Error() != "Could not join cluster: Unknown command" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
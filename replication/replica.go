@@ -0,0 +1,560 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+ChangesPath is the path of the changes endpoint relative to the primary URL.
+*/
+var ChangesPath = "/db/v1/changes/"
+
+/*
+ReplicaURLHeader is the request header which carries the URL of a replica
+when it requests changes from its primary.
+*/
+var ReplicaURLHeader = "X-Replica-URL"
+
+/*
+BatchSize is the maximum number of changes which are requested with one call.
+*/
+var BatchSize = 1000
+
+/*
+ChangesResponse is the response of the changes endpoint of a primary.
+*/
+type ChangesResponse struct {
+	ID       string    `json:"id"`        // ID of the change log
+	LastSeq  uint64    `json:"last_seq"`  // Sequence number of the last change
+	LastTime int64     `json:"last_time"` // Time of the last change
+	Changes  []*Change `json:"changes"`   // Requested changes
+	Next     uint64    `json:"next"`      // Sequence number to continue with (since of the next request)
+}
+
+/*
+Replica applies the changes of a primary to a local GraphManager.
+*/
+type Replica struct {
+	primary     string          // URL of the primary
+	url         string          // Advertised URL of this replica
+	gm          *graph.Manager  // GraphManager which receives changes
+	client      *http.Client    // Client to contact the primary
+	logID       string          // ID of the change log of the primary
+	lastSeq     uint64          // Sequence number of the last applied change
+	lastTime    int64           // Time of the last applied change
+	primarySeq  uint64          // Sequence number of the last change on the primary
+	primaryTime int64           // Time of the last change on the primary
+	lastSync    time.Time       // Time of the last successful synchronization
+	lastError   error           // Last error which occurred
+	lock        *sync.RWMutex   // Lock for the replication state
+	stopChan    chan bool       // Channel to stop the replication loop
+	wg          *sync.WaitGroup // Wait group for the replication loop
+	translate   DataTranslation // Translation of received node and edge data
+}
+
+/*
+NewReplica creates a new replica of a given primary URL (e.g. https://host:9090).
+*/
+func NewReplica(primary string, gm *graph.Manager, client *http.Client) *Replica {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Replica{strings.TrimSuffix(primary, "/"), "", gm, client, "", 0, 0, 0, 0,
+		time.Time{}, nil, &sync.RWMutex{}, nil, &sync.WaitGroup{}, nil}
+}
+
+/*
+SetTranslation sets a translation which is applied to all received node and
+edge data before it is stored (e.g. to translate obfuscated keys). Must be
+called before the replica is started.
+*/
+func (r *Replica) SetTranslation(translate DataTranslation) {
+	r.translate = translate
+}
+
+/*
+SetURL sets the URL of this replica which is sent to the primary. This allows
+the primary to advertise its replicas.
+*/
+func (r *Replica) SetURL(url string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.url = url
+}
+
+/*
+Primary returns the URL of the primary of this replica.
+*/
+func (r *Replica) Primary() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.primary
+}
+
+/*
+SetPrimary lets this replica follow a new primary. The replica loads a
+snapshot from the new primary on the next synchronization.
+*/
+func (r *Replica) SetPrimary(primary string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.primary = strings.TrimSuffix(primary, "/")
+	r.logID = ""
+	r.lastError = nil
+}
+
+/*
+Start starts the replication loop which synchronizes with the primary in a
+given interval.
+*/
+func (r *Replica) Start(interval time.Duration) {
+	r.stopChan = make(chan bool)
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			r.Sync()
+
+			select {
+			case <-r.stopChan:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+/*
+Stop stops the replication loop.
+*/
+func (r *Replica) Stop() {
+	if r.stopChan != nil {
+		close(r.stopChan)
+		r.wg.Wait()
+		r.stopChan = nil
+	}
+}
+
+/*
+Sync synchronizes this replica with its primary.
+*/
+func (r *Replica) Sync() error {
+	var err error
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.logID == "" {
+		err = r.loadSnapshot()
+	}
+
+	for err == nil {
+		var res *ChangesResponse
+
+		if res, err = r.fetchChanges(); err == ErrChangesTruncated ||
+			(err == nil && res.ID != r.logID) {
+
+			// Resynchronize if the replica fell too far behind or
+			// if the primary has a new change log
+
+			if err = r.loadSnapshot(); err == nil {
+				continue
+			}
+		}
+
+		if err == nil {
+			r.primarySeq, r.primaryTime = res.LastSeq, res.LastTime
+
+			for _, c := range res.Changes {
+				if err = r.apply(c); err != nil {
+					break
+				}
+
+				r.lastSeq, r.lastTime = c.Seq, c.Time
+			}
+
+			if err == nil && len(res.Changes) < BatchSize {
+				r.lastSync = time.Now()
+				break
+			}
+		}
+	}
+
+	r.lastError = err
+
+	return err
+}
+
+/*
+Status returns the replication status of this replica.
+*/
+func (r *Replica) Status() map[string]interface{} {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var lastError interface{}
+	var lagSeconds float64
+
+	if r.lastError != nil {
+		lastError = r.lastError.Error()
+	}
+
+	lag := uint64(0)
+
+	if r.primarySeq > r.lastSeq {
+		lag = r.primarySeq - r.lastSeq
+		lagSeconds = float64(r.primaryTime-r.lastTime) / float64(time.Second)
+	}
+
+	var lastSync interface{}
+
+	if !r.lastSync.IsZero() {
+		lastSync = r.lastSync.Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"primary":      r.primary,
+		"last_seq":     r.lastSeq,
+		"primary_seq":  r.primarySeq,
+		"lag_changes":  lag,
+		"lag_seconds":  lagSeconds,
+		"last_sync":    lastSync,
+		"last_error":   lastError,
+		"change_log":   r.logID,
+		"synchronized": lag == 0 && r.lastError == nil && lastSync != nil,
+	}
+}
+
+/*
+fetchChanges requests the next changes from the primary.
+*/
+func (r *Replica) fetchChanges() (*ChangesResponse, error) {
+	var res ChangesResponse
+
+	url := fmt.Sprintf("%v%v?since=%v&limit=%v", r.primary, ChangesPath, r.lastSeq, BatchSize)
+
+	resp, err := r.get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		err = ErrChangesTruncated
+	} else if resp.StatusCode != http.StatusOK {
+		err = statusError(resp)
+	} else if resp.Header.Get("content-type") == ContentTypeGob {
+		err = gob.NewDecoder(resp.Body).Decode(&res)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&res)
+	}
+
+	return &res, err
+}
+
+/*
+snapshotKey identifies a node or an edge of a snapshot.
+*/
+type snapshotKey struct {
+	edge bool
+	kind string
+	key  string
+}
+
+/*
+loadSnapshot loads a snapshot from the primary.
+*/
+func (r *Replica) loadSnapshot() error {
+	resp, err := r.get(r.primary + ChangesPath + "snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	header, err := readSnapshot(resp.Body, r.gm, r.translate)
+
+	if err == nil {
+		r.logID = header.ID
+		r.lastSeq = header.Seq
+	}
+
+	return err
+}
+
+/*
+readSnapshot reads a snapshot stream into a given GraphManager. The snapshot
+is applied in batches. Nodes and edges of the GraphManager which are not part
+of the snapshot are removed afterwards - the keys of the snapshot are kept in
+memory for this. An optional translation is applied to all received data.
+*/
+func readSnapshot(r io.Reader, gm *graph.Manager, translate DataTranslation) (*SnapshotHeader, error) {
+	var header SnapshotHeader
+
+	dec := gob.NewDecoder(r)
+
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+
+	var err error
+	var lastOp string
+	var pending int
+
+	seen := make(map[string]map[snapshotKey]bool)
+	trans := graph.NewGraphTrans(gm)
+
+	for {
+		var entry SnapshotEntry
+
+		if err = dec.Decode(&entry); err == io.EOF {
+			err = errors.New("Snapshot is incomplete")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Changes are committed in batches - nodes are committed before the
+		// edges which point to them are stored
+
+		if pending >= BatchSize || entry.Op != lastOp {
+			if err = trans.Commit(); err != nil {
+				return nil, err
+			}
+			pending = 0
+		}
+
+		lastOp = entry.Op
+
+		if entry.Op == OpSnapshotEnd {
+			break
+		}
+
+		if translate != nil {
+			if entry.Data, err = translate(entry.Data); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, ok := seen[entry.Part]; !ok {
+			seen[entry.Part] = make(map[snapshotKey]bool)
+		}
+
+		node := data.NewGraphNodeFromMap(entry.Data)
+
+		if entry.Op == OpStoreNode {
+			err = trans.StoreNode(entry.Part, node)
+		} else if entry.Op == OpStoreEdge {
+			edge := data.NewGraphEdgeFromNode(node)
+
+			// Edges which were created while the snapshot was written may
+			// point to nodes which are not in the snapshot - these edges
+			// are contained in the change log
+
+			if nodeExists(gm, entry.Part, edge.End1Key(), edge.End1Kind()) &&
+				nodeExists(gm, entry.Part, edge.End2Key(), edge.End2Kind()) {
+				err = trans.StoreEdge(entry.Part, edge)
+			}
+		} else {
+			err = fmt.Errorf("Unknown snapshot operation: %v", entry.Op)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		seen[entry.Part][snapshotKey{entry.Op == OpStoreEdge, node.Kind(), node.Key()}] = true
+		pending++
+	}
+
+	return &header, removeStale(gm, seen)
+}
+
+/*
+get sends a GET request to the primary. Gob encoded responses are requested.
+The caller must close the body of the returned response.
+*/
+func (r *Replica) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("accept", ContentTypeGob)
+
+	if r.url != "" {
+		req.Header.Set(ReplicaURLHeader, r.url)
+	}
+
+	return r.client.Do(req)
+}
+
+/*
+statusError returns an error for an unexpected response status of the primary.
+*/
+func statusError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("Primary returned %v: %v", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+/*
+nodeExists checks if a node exists in a GraphManager.
+*/
+func nodeExists(gm *graph.Manager, part string, key string, kind string) bool {
+	node, err := gm.FetchNode(part, key, kind)
+	return err == nil && node != nil
+}
+
+/*
+removeStale removes all nodes and edges of a GraphManager which are not part
+of a loaded snapshot. Removing a node also removes its edges.
+*/
+func removeStale(gm *graph.Manager, seen map[string]map[snapshotKey]bool) error {
+
+	for _, part := range gm.Partitions() {
+		partSeen := seen[part]
+
+		for _, kind := range gm.NodeKinds() {
+
+			it, err := gm.NodeKeySnapshotIterator(part, kind)
+			if err != nil {
+				return err
+			} else if it == nil {
+				continue
+			}
+
+			for it.HasNext() {
+				key := it.Next()
+
+				if it.LastError != nil {
+					return it.LastError
+				}
+
+				if !partSeen[snapshotKey{false, kind, key}] {
+					if _, err := gm.RemoveNode(part, key, kind); err != nil {
+						return err
+					}
+					continue
+				}
+
+				_, edges, err := gm.TraverseMulti(part, key, kind, ":::", false)
+				if err != nil {
+					return err
+				}
+
+				for _, edge := range edges {
+					if !partSeen[snapshotKey{true, edge.Kind(), edge.Key()}] {
+						if _, err := gm.RemoveEdge(part, edge.Key(), edge.Kind()); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+apply applies a single change to the local GraphManager.
+*/
+func (r *Replica) apply(c *Change) error {
+	var err error
+
+	if r.translate != nil {
+		if c, err = r.translateChange(c); err != nil {
+			return err
+		}
+	}
+
+	return applyChange(r.gm, c)
+}
+
+/*
+applyChange applies a single change to a GraphManager.
+*/
+func applyChange(gm *graph.Manager, c *Change) error {
+	var err error
+
+	switch c.Op {
+
+	case OpStoreNode:
+		if c.Data != nil {
+			err = gm.StoreNode(c.Part, data.NewGraphNodeFromMap(c.Data))
+		}
+
+	case OpStoreEdge:
+		if c.Data != nil {
+			err = gm.StoreEdge(c.Part, data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(c.Data)))
+		}
+
+	case OpDeleteNode:
+		_, err = gm.RemoveNode(c.Part, c.Key, c.Kind)
+
+	case OpDeleteEdge:
+		_, err = gm.RemoveEdge(c.Part, c.Key, c.Kind)
+
+	default:
+		err = fmt.Errorf("Unknown change operation: %v", c.Op)
+	}
+
+	return err
+}
+
+/*
+translateChange returns a copy of a change with translated data and key.
+*/
+func (r *Replica) translateChange(c *Change) (*Change, error) {
+	var err error
+
+	ret := *c
+
+	if ret.Data != nil {
+		if ret.Data, err = r.translate(ret.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	keyData, err := r.translate(map[string]interface{}{
+		data.NodeKey:  c.Key,
+		data.NodeKind: c.Kind,
+	})
+
+	if err == nil {
+		ret.Key = fmt.Sprint(keyData[data.NodeKey])
+	}
+
+	return &ret, err
+}
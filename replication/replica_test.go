@@ -0,0 +1,266 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+/*
+testPrimary serves the change log of a GraphManager. The keys of all nodes and
+edges are translated with an optional translation.
+*/
+func testPrimary(gm *graph.Manager, cl *ChangeLog, translate DataTranslation) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == ChangesPath+"snapshot" {
+			w.Header().Set("content-type", ContentTypeGob)
+			cl.WriteSnapshot(w, gm, translate)
+			return
+		}
+
+		since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		changes, next, err := cl.FilteredChanges(since, limit, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		lastSeq, lastTime := cl.LastSeq()
+
+		if translate != nil {
+			for i, c := range changes {
+				tc := *c
+				tdata, _ := translate(map[string]interface{}{data.NodeKey: c.Key, data.NodeKind: c.Kind})
+				tc.Key = tdata[data.NodeKey].(string)
+				if c.Data != nil {
+					tc.Data, _ = translate(c.Data)
+				}
+				changes[i] = &tc
+			}
+		}
+
+		if r.Header.Get("accept") == ContentTypeGob {
+			w.Header().Set("content-type", ContentTypeGob)
+			gob.NewEncoder(w).Encode(&ChangesResponse{cl.ID(), lastSeq, lastTime, changes, next})
+			return
+		}
+
+		json.NewEncoder(w).Encode(&ChangesResponse{cl.ID(), lastSeq, lastTime, changes, next})
+	}))
+}
+
+func storeTestNode(gm *graph.Manager, key string, name string) {
+	node := data.NewGraphNode()
+	node.SetAttr("key", key)
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", name)
+	gm.StoreNode("main", node)
+}
+
+func TestReplica(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("primary"))
+	rgm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("replica"))
+
+	// Data which is stored before the change log exists needs a snapshot
+
+	storeTestNode(gm, "123", "foo")
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "t1")
+	node.SetAttr("kind", "typed")
+	node.SetAttr("created", created)
+	gm.StoreNode("main", node)
+
+	// Data which only exists on the replica is removed by a snapshot
+
+	storeTestNode(rgm, "stale", "x")
+
+	cl := NewChangeLog(2)
+	gm.SetGraphRule(cl)
+
+	storeTestNode(gm, "456", "bar")
+
+	ts := testPrimary(gm, cl, nil)
+	defer ts.Close()
+
+	oldBatchSize := BatchSize
+	BatchSize = 1
+	defer func() {
+		BatchSize = oldBatchSize
+	}()
+
+	r := NewReplica(ts.URL+"/", rgm, nil)
+
+	if status := r.Status(); status["synchronized"] != false || status["last_sync"] != nil {
+		t.Error("Unexpected result:", status)
+		return
+	}
+
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := rgm.FetchNode("main", "stale", "mykind"); n != nil || rgm.NodeCount("mykind") != 2 {
+		t.Error("Unexpected result:", n, rgm.NodeCount("mykind"))
+		return
+	}
+
+	// Attribute values keep their type
+
+	if n, err := rgm.FetchNode("main", "t1", "typed"); err != nil || n == nil || n.Attr("created") != created {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Changes are applied in batches
+
+	storeTestNode(gm, "789", "baz")
+	gm.RemoveNode("main", "123", "mykind")
+
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := rgm.FetchNode("main", "123", "mykind"); n != nil || rgm.NodeCount("mykind") != 2 {
+		t.Error("Unexpected result:", n, rgm.NodeCount("mykind"))
+		return
+	}
+
+	if status := r.Status(); status["synchronized"] != true || status["last_seq"] != uint64(3) ||
+		status["lag_changes"] != uint64(0) || status["change_log"] != cl.ID() {
+		t.Error("Unexpected result:", status)
+		return
+	}
+
+	// A replica which fell behind too far loads a new snapshot
+
+	storeTestNode(gm, "1", "a")
+	storeTestNode(gm, "2", "b")
+	storeTestNode(gm, "3", "c")
+
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if rgm.NodeCount("mykind") != 5 || r.Status()["last_seq"] != uint64(6) {
+		t.Error("Unexpected result:", rgm.NodeCount("mykind"), r.Status())
+		return
+	}
+
+	// Check the replication loop
+
+	r.Start(10 * time.Millisecond)
+
+	storeTestNode(gm, "4", "d")
+
+	for i := 0; i < 100 && rgm.NodeCount("mykind") != 6; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.Stop()
+
+	if rgm.NodeCount("mykind") != 6 {
+		t.Error("Unexpected result:", rgm.NodeCount("mykind"))
+		return
+	}
+
+	// Errors are reported in the status
+
+	ts.Close()
+
+	if err := r.Sync(); err == nil || r.Status()["last_error"] == nil {
+		t.Error("Unexpected result:", err, r.Status())
+		return
+	}
+}
+
+/*
+translateTestKeys returns a translation which changes all keys of node or edge
+data with a given function.
+*/
+func translateTestKeys(f func(string) string) DataTranslation {
+	return func(nodeData map[string]interface{}) (map[string]interface{}, error) {
+		ret := make(map[string]interface{}, len(nodeData))
+
+		for k, v := range nodeData {
+			ret[k] = v
+		}
+
+		for _, attr := range []string{data.NodeKey, data.EdgeEnd1Key, data.EdgeEnd2Key} {
+			if v, ok := ret[attr]; ok {
+				ret[attr] = f(fmt.Sprint(v))
+			}
+		}
+
+		return ret, nil
+	}
+}
+
+func TestReplicaTranslation(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("primary"))
+	rgm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("replica"))
+
+	storeTestNode(gm, "123", "foo")
+
+	cl := NewChangeLog(10)
+	gm.SetGraphRule(cl)
+
+	storeTestNode(gm, "456", "bar")
+
+	// The primary obfuscates keys and the replica translates them back
+
+	ts := testPrimary(gm, cl, translateTestKeys(func(key string) string {
+		return "x" + key
+	}))
+	defer ts.Close()
+
+	r := NewReplica(ts.URL+"/", rgm, nil)
+	r.SetTranslation(translateTestKeys(func(key string) string {
+		return strings.TrimPrefix(key, "x")
+	}))
+
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.RemoveNode("main", "123", "mykind")
+
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := rgm.FetchNode("main", "456", "mykind"); n == nil || rgm.NodeCount("mykind") != 1 {
+		t.Error("Unexpected result:", n, rgm.NodeCount("mykind"))
+		return
+	}
+}
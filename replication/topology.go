@@ -0,0 +1,283 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Roles of an instance in a replication topology
+*/
+const (
+	RolePrimary = "primary" // Instance which accepts writes
+	RoleReplica = "replica" // Read-only instance which follows a primary
+)
+
+/*
+ErrAlreadyPrimary is returned when promoting an instance which is already the
+primary.
+*/
+var ErrAlreadyPrimary = errors.New("This instance is already the primary")
+
+/*
+Member is a member of a replication topology.
+*/
+type Member struct {
+	URL      string `json:"url"`                 // URL of the member
+	Role     string `json:"role"`                // Role of the member
+	LastSeq  uint64 `json:"last_seq,omitempty"`  // Last change which was requested by a replica
+	LastSeen int64  `json:"last_seen,omitempty"` // Time when a replica was last seen
+}
+
+/*
+TopologyInfo describes the roles of all known members of a replication
+topology. The epoch is increased on every change.
+*/
+type TopologyInfo struct {
+	Epoch   uint64    `json:"epoch"`   // Number of topology changes
+	Role    string    `json:"role"`    // Role of this instance
+	Self    string    `json:"self"`    // URL of this instance
+	Primary string    `json:"primary"` // URL of the current primary
+	Members []*Member `json:"members"` // All known members
+}
+
+/*
+Topology tracks the role of this instance and the members of its replication
+topology. A primary learns about its replicas when they request changes.
+Replicas which have not been seen for a given timeout are removed. Listeners
+are notified of every change.
+*/
+type Topology struct {
+	self      string                      // URL of this instance
+	primary   string                      // URL of the primary (empty if this instance is the primary)
+	epoch     uint64                      // Number of topology changes
+	replicas  map[string]*Member          // Known replicas of this instance
+	listeners map[chan *TopologyInfo]bool // Listeners for topology changes
+	timeout   time.Duration               // Timeout after which replicas are removed
+	mutex     *sync.Mutex                 // Mutex to protect the topology
+	stopChan  chan bool                   // Channel to stop the expiry loop
+}
+
+/*
+NewTopology creates a new topology for an instance with a given URL. The
+instance is a replica if the URL of a primary is given. Replicas which have
+not been seen for the given timeout are removed.
+*/
+func NewTopology(self string, primary string, timeout time.Duration) *Topology {
+	return &Topology{self, primary, 0, make(map[string]*Member),
+		make(map[chan *TopologyInfo]bool), timeout, &sync.Mutex{}, nil}
+}
+
+/*
+Start starts a loop which removes replicas which have timed out. Replicas
+are never removed if the timeout is not positive.
+*/
+func (t *Topology) Start() {
+	if t.timeout <= 0 {
+		return
+	}
+
+	t.stopChan = make(chan bool)
+
+	go func(stop chan bool) {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(t.timeout / 2):
+				t.Expire()
+			}
+		}
+	}(t.stopChan)
+}
+
+/*
+Stop stops the expiry loop.
+*/
+func (t *Topology) Stop() {
+	if t.stopChan != nil {
+		close(t.stopChan)
+		t.stopChan = nil
+	}
+}
+
+/*
+Role returns the role of this instance.
+*/
+func (t *Topology) Role() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.primary != "" {
+		return RoleReplica
+	}
+
+	return RolePrimary
+}
+
+/*
+Info returns the current topology.
+*/
+func (t *Topology) Info() *TopologyInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.info()
+}
+
+/*
+ReplicaSeen records that a replica with a given URL requested changes.
+*/
+func (t *Topology) ReplicaSeen(url string, lastSeq uint64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	m, ok := t.replicas[url]
+
+	if !ok {
+		m = &Member{URL: url, Role: RoleReplica}
+		t.replicas[url] = m
+	}
+
+	m.LastSeq = lastSeq
+	m.LastSeen = time.Now().Unix()
+
+	if !ok {
+		t.changed()
+	}
+}
+
+/*
+Expire removes all replicas which have not been seen within the timeout.
+*/
+func (t *Topology) Expire() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	changed := false
+	deadline := time.Now().Add(-t.timeout).Unix()
+
+	for url, m := range t.replicas {
+		if m.LastSeen < deadline {
+			delete(t.replicas, url)
+			changed = true
+		}
+	}
+
+	if changed {
+		t.changed()
+	}
+}
+
+/*
+Promote makes this instance the primary.
+*/
+func (t *Topology) Promote() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.primary == "" {
+		return ErrAlreadyPrimary
+	}
+
+	t.primary = ""
+	t.changed()
+
+	return nil
+}
+
+/*
+Follow makes this instance a replica of a given primary.
+*/
+func (t *Topology) Follow(primary string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.primary = primary
+	t.replicas = make(map[string]*Member)
+	t.changed()
+}
+
+/*
+Subscribe registers a listener which receives the topology after every
+change. The returned function unregisters the listener. Listeners which
+cannot keep up miss intermediate changes.
+*/
+func (t *Topology) Subscribe() (<-chan *TopologyInfo, func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c := make(chan *TopologyInfo, 1)
+	t.listeners[c] = true
+
+	return c, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		delete(t.listeners, c)
+	}
+}
+
+/*
+changed increases the epoch and notifies all listeners. Assumes that the
+caller holds the mutex.
+*/
+func (t *Topology) changed() {
+	t.epoch++
+
+	info := t.info()
+
+	for c := range t.listeners {
+
+		// Replace a notification which was not yet received
+
+		select {
+		case <-c:
+		default:
+		}
+
+		c <- info
+	}
+}
+
+/*
+info returns the current topology. Assumes that the caller holds the mutex.
+*/
+func (t *Topology) info() *TopologyInfo {
+	role, primary := RolePrimary, t.self
+
+	if t.primary != "" {
+		role, primary = RoleReplica, t.primary
+	}
+
+	members := []*Member{{URL: primary, Role: RolePrimary}}
+
+	if role == RoleReplica {
+		members = append(members, &Member{URL: t.self, Role: RoleReplica})
+	}
+
+	var replicas []*Member
+
+	for _, m := range t.replicas {
+		mcopy := *m
+		replicas = append(replicas, &mcopy)
+	}
+
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].URL < replicas[j].URL
+	})
+
+	return &TopologyInfo{t.epoch, role, t.self, primary, append(members, replicas...)}
+}
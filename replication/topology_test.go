@@ -0,0 +1,113 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopology(t *testing.T) {
+	top := NewTopology("https://a:9090", "", time.Hour)
+
+	if top.Role() != RolePrimary {
+		t.Error("Unexpected result:", top.Role())
+		return
+	}
+
+	changes, unsubscribe := top.Subscribe()
+
+	top.ReplicaSeen("https://c:9090", 5)
+	top.ReplicaSeen("https://b:9090", 3)
+
+	// Seeing a known replica again is not a change
+
+	top.ReplicaSeen("https://c:9090", 7)
+
+	// Only the latest change is kept for a slow listener
+
+	info := <-changes
+
+	if info.Epoch != 2 || info.Role != RolePrimary || info.Primary != "https://a:9090" ||
+		len(info.Members) != 3 || info.Members[1].URL != "https://b:9090" {
+		t.Error("Unexpected result:", info)
+		return
+	}
+
+	info = top.Info()
+
+	if info.Epoch != 2 || info.Members[2].URL != "https://c:9090" || info.Members[2].LastSeq != 7 {
+		t.Error("Unexpected result:", info)
+		return
+	}
+
+	if err := top.Promote(); err != ErrAlreadyPrimary {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Replicas which have not been seen are removed
+
+	top.replicas["https://b:9090"].LastSeen = 0
+	top.Expire()
+
+	if info = <-changes; info.Epoch != 3 || len(info.Members) != 2 {
+		t.Error("Unexpected result:", info)
+		return
+	}
+
+	// Follow a new primary and promote this instance again
+
+	top.Follow("https://c:9090")
+
+	if info = <-changes; info.Role != RoleReplica || info.Primary != "https://c:9090" ||
+		len(info.Members) != 2 || info.Members[1].URL != "https://a:9090" {
+		t.Error("Unexpected result:", info)
+		return
+	}
+
+	if err := top.Promote(); err != nil || top.Role() != RolePrimary {
+		t.Error("Unexpected result:", top.Role(), err)
+		return
+	}
+
+	unsubscribe()
+
+	if len(top.listeners) != 0 {
+		t.Error("Listener should have been removed")
+		return
+	}
+
+	// The expiry loop is not started without a timeout
+
+	top = NewTopology("https://a:9090", "", 0)
+	top.Start()
+
+	if top.stopChan != nil {
+		t.Error("Expiry loop should not be running")
+		return
+	}
+
+	top = NewTopology("https://a:9090", "", 10*time.Millisecond)
+	top.Start()
+	top.ReplicaSeen("https://b:9090", 1)
+
+	top.mutex.Lock()
+	top.replicas["https://b:9090"].LastSeen = 0
+	top.mutex.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	top.Stop()
+
+	if info = top.Info(); len(info.Members) != 1 {
+		t.Error("Unexpected result:", info)
+	}
+}
@@ -0,0 +1,578 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+RuleNodeKind is the node kind which stores rules.
+*/
+const RuleNodeKind = "rule"
+
+/*
+AsyncQueueSize is the maximum number of pending asynchronous rule executions.
+Executions are dropped (and counted as failed) if the queue is full.
+*/
+var AsyncQueueSize = 1000
+
+/*
+WebhookTimeout is the timeout for the delivery of a webhook action.
+*/
+var WebhookTimeout = 10 * time.Second
+
+/*
+ScriptHandler runs the script action of a rule. The handler gets the name of
+the script event, the rule event, the partition and the data of the written
+node or edge. Changes should be written to the given transaction.
+*/
+type ScriptHandler func(name string, event string, part string, obj map[string]interface{}, trans graph.Trans) error
+
+/*
+Stats are the execution statistics of a rule.
+*/
+type Stats struct {
+	Fired     int64  `json:"fired"`      // Number of times the rule fired
+	Failed    int64  `json:"failed"`     // Number of failed executions
+	LastFired int64  `json:"last_fired"` // Time of the last execution (Unix seconds)
+	LastError string `json:"last_error"` // Error of the last failed execution
+}
+
+/*
+asyncTask is a pending asynchronous rule execution.
+*/
+type asyncTask struct {
+	rule  *Rule  // Rule which fired
+	event string // Event which fired the rule
+	part  string // Partition of the written node or edge
+	key   string // Key of the written node or edge
+	kind  string // Kind of the written node or edge
+	obj   map[string]interface{}
+}
+
+/*
+Engine is a graph rule which runs user defined rules on writes of nodes and
+edges.
+*/
+type Engine struct {
+	gm    *graph.Manager    // Graph manager which stores the rules
+	part  string            // Partition which stores the rules
+	rules map[string]*Rule  // Rules by name
+	stats map[string]*Stats // Execution statistics by rule name
+	lock  *sync.RWMutex     // Lock for rules and statistics
+
+	queue   chan *asyncTask // Queue of asynchronous rule executions
+	pending *sync.WaitGroup // Pending asynchronous rule executions
+
+	Active        func() bool   // Function which returns if rules should run (nil to always run rules)
+	ScriptHandler ScriptHandler // Handler for script actions (nil if scripts are not supported)
+}
+
+/*
+NewEngine creates a new rules engine and loads all rules which are stored in a
+given partition. Writes to this partition never fire rules. The engine needs
+to be registered as graph rule.
+*/
+func NewEngine(gm *graph.Manager, part string) (*Engine, error) {
+	e := &Engine{gm, part, make(map[string]*Rule), make(map[string]*Stats), &sync.RWMutex{},
+		make(chan *asyncTask, AsyncQueueSize), &sync.WaitGroup{}, nil, nil}
+
+	it, err := gm.NodeKeyIterator(part, RuleNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var node data.Node
+
+			if node, err = gm.FetchNode(part, key, RuleNodeKind); err == nil && node != nil {
+				r := &Rule{}
+
+				if err = json.Unmarshal([]byte(fmt.Sprint(node.Attr("data"))), r); err == nil {
+					e.rules[r.Name] = r
+					e.stats[r.Name] = &Stats{}
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	go e.runAsync()
+
+	return e, nil
+}
+
+/*
+Close stops the execution of asynchronous rules after all pending executions
+have finished.
+*/
+func (e *Engine) Close() {
+	e.Wait()
+	close(e.queue)
+}
+
+/*
+Wait waits until all pending asynchronous rule executions have finished.
+*/
+func (e *Engine) Wait() {
+	e.pending.Wait()
+}
+
+/*
+Rules returns all rules sorted by name.
+*/
+func (e *Engine) Rules() []*Rule {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	ret := make([]*Rule, 0, len(e.rules))
+
+	for _, r := range e.rules {
+		ret = append(ret, r)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+Rule returns a rule and its execution statistics. Returns nil if the rule does
+not exist.
+*/
+func (e *Engine) Rule(name string) (*Rule, Stats) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if r, ok := e.rules[name]; ok {
+		return r, *e.stats[name]
+	}
+
+	return nil, Stats{}
+}
+
+/*
+StoreRule validates and stores a rule. An existing rule with the same name is
+replaced and its statistics are reset.
+*/
+func (e *Engine) StoreRule(r *Rule) error {
+
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	ruleJSON, err := json.Marshal(r)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, r.Name)
+		node.SetAttr(data.NodeKind, RuleNodeKind)
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(ruleJSON))
+
+		// The rule is stored before the lock is taken since writes hold
+		// the lock of the graph manager while rules are evaluated
+
+		if err = e.gm.StoreNode(e.part, node); err == nil {
+			e.lock.Lock()
+			e.rules[r.Name] = r
+			e.stats[r.Name] = &Stats{}
+			e.lock.Unlock()
+		}
+	}
+
+	return err
+}
+
+/*
+RemoveRule removes a rule. Returns if the rule existed.
+*/
+func (e *Engine) RemoveRule(name string) (bool, error) {
+
+	node, err := e.gm.RemoveNode(e.part, name, RuleNodeKind)
+
+	if err == nil {
+		e.lock.Lock()
+		delete(e.rules, name)
+		delete(e.stats, name)
+		e.lock.Unlock()
+	}
+
+	return node != nil, err
+}
+
+// Graph rule
+// ==========
+
+/*
+graphEvents maps graph events to rule events.
+*/
+var graphEvents = map[int]string{
+	graph.EventNodeCreated: EventNodeCreated,
+	graph.EventNodeUpdated: EventNodeUpdated,
+	graph.EventNodeDeleted: EventNodeDeleted,
+	graph.EventEdgeCreated: EventEdgeCreated,
+	graph.EventEdgeUpdated: EventEdgeUpdated,
+	graph.EventEdgeDeleted: EventEdgeDeleted,
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (e *Engine) Name() string {
+	return "system.rules"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (e *Engine) Handles() []int {
+	return []int{graph.EventNodeCreated, graph.EventNodeUpdated, graph.EventNodeDeleted,
+		graph.EventEdgeCreated, graph.EventEdgeUpdated, graph.EventEdgeDeleted}
+}
+
+/*
+PartitionOnly returns if the rule only works on the partition of an event.
+Scripts might read or write any partition.
+*/
+func (e *Engine) PartitionOnly() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	for _, r := range e.rules {
+		if r.hasScript() && r.Mode == ModeSync {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Handle handles an event. Synchronous rules run their actions on the given
+transaction - asynchronous rules are queued.
+*/
+func (e *Engine) Handle(gm *graph.Manager, trans graph.Trans, event int, ed ...interface{}) error {
+	var obj, old data.Node
+	var errs []string
+
+	part := ed[0].(string)
+	rules := e.Rules()
+
+	if len(rules) == 0 || part == e.part || (e.Active != nil && !e.Active()) {
+		return nil
+	}
+
+	name := graphEvents[event]
+	isEdge := strings.HasPrefix(name, "edge.")
+
+	obj = ed[1].(data.Node)
+
+	// Updates might only contain the changed attributes - use the full state
+	// of the node or edge for conditions and actions
+
+	if name == EventNodeCreated || name == EventNodeUpdated {
+		if node, err := gm.FetchNode(part, obj.Key(), obj.Kind()); err == nil && node != nil {
+			obj = node
+		}
+	} else if name == EventEdgeCreated || name == EventEdgeUpdated {
+		if edge, err := gm.FetchEdge(part, obj.Key(), obj.Kind()); err == nil && edge != nil {
+			obj = edge
+		}
+	}
+
+	// The previous state of an update only contains the previous values of
+	// written attributes - all other attributes did not change
+
+	if name == EventNodeUpdated || name == EventEdgeUpdated {
+		written := ed[1].(data.Node)
+		prev, _ := ed[2].(data.Node)
+
+		old = data.CopyNode(obj)
+
+		for attr := range written.Data() {
+			if prev != nil {
+				old.SetAttr(attr, prev.Attr(attr))
+			} else {
+				old.SetAttr(attr, nil)
+			}
+		}
+	}
+
+	for _, r := range rules {
+
+		if !r.matches(part, name, obj, old) {
+			continue
+		}
+
+		if r.Mode == ModeAsync {
+			e.enqueue(&asyncTask{r, name, part, obj.Key(), obj.Kind(), data.CopyNode(obj).Data()})
+			continue
+		}
+
+		err := e.runActions(gm, trans, r, name, part, isEdge, obj)
+		e.recordRun(r.Name, err)
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Rule %v failed: %v", r.Name, err))
+		}
+	}
+
+	if errs != nil {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+/*
+recordRun records the execution of a rule.
+*/
+func (e *Engine) recordRun(name string, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if s, ok := e.stats[name]; ok {
+		s.Fired++
+		s.LastFired = time.Now().Unix()
+
+		if err != nil {
+			s.Failed++
+			s.LastError = err.Error()
+		}
+	}
+}
+
+/*
+enqueue queues an asynchronous rule execution.
+*/
+func (e *Engine) enqueue(t *asyncTask) {
+	e.pending.Add(1)
+
+	select {
+	case e.queue <- t:
+	default:
+		e.pending.Done()
+		e.recordRun(t.rule.Name, fmt.Errorf("Queue of asynchronous rules is full"))
+	}
+}
+
+/*
+runAsync runs queued asynchronous rule executions. The written node or edge is
+fetched again so no actions run for writes which were rolled back.
+*/
+func (e *Engine) runAsync() {
+
+	for t := range e.queue {
+		var obj data.Node
+		var err error
+
+		isEdge := strings.HasPrefix(t.event, "edge.")
+		deleted := t.event == EventNodeDeleted || t.event == EventEdgeDeleted
+
+		if isEdge {
+			var edge data.Edge
+			if edge, err = e.gm.FetchEdge(t.part, t.key, t.kind); edge != nil {
+				obj = edge
+			}
+		} else {
+			obj, err = e.gm.FetchNode(t.part, t.key, t.kind)
+		}
+
+		if err == nil && (obj == nil) == deleted {
+
+			if deleted {
+				obj = data.NewGraphNodeFromMap(t.obj)
+				if isEdge {
+					obj = data.NewGraphEdgeFromNode(obj)
+				}
+			}
+
+			trans := graph.NewGraphTrans(e.gm)
+
+			if err = e.runActions(e.gm, trans, t.rule, t.event, t.part, isEdge, obj); err == nil {
+				err = trans.Commit()
+			}
+
+			e.recordRun(t.rule.Name, err)
+
+		} else if err != nil {
+			e.recordRun(t.rule.Name, err)
+		}
+
+		e.pending.Done()
+	}
+}
+
+/*
+runActions runs the actions of a rule for a written node or edge. Changes are
+written to the given transaction.
+*/
+func (e *Engine) runActions(gm *graph.Manager, trans graph.Trans, r *Rule, event string,
+	part string, isEdge bool, obj data.Node) error {
+
+	for _, a := range r.Actions {
+		var err error
+
+		switch a.Type {
+
+		case ActionSetAttr:
+			value := a.Value
+
+			if a.ValueAttr != "" {
+				value = obj.Attr(a.ValueAttr)
+			}
+
+			// Only write a changed value - this also stops rules which fire
+			// on their own updates
+
+			if cur := obj.Attr(a.Attr); (cur == nil) != (value == nil) ||
+				(cur != nil && compareValues(cur, value) != 0) {
+
+				// The full node or edge is stored since an update would
+				// need to read the database which is locked during a commit
+
+				// Following actions see the changed value
+
+				obj = data.CopyNode(obj)
+				obj.SetAttr(a.Attr, value)
+
+				if isEdge {
+					err = trans.StoreEdge(part, data.NewGraphEdgeFromNode(obj))
+				} else {
+					err = trans.StoreNode(part, obj)
+				}
+			}
+
+		case ActionCreateEdge:
+			err = createEdge(gm, trans, a, part, obj)
+
+		case ActionWebhook:
+			err = postEvent(a.URL, r.Name, event, part, isEdge, obj)
+
+		case ActionScript:
+			if e.ScriptHandler == nil {
+				err = fmt.Errorf("Scripting is not enabled")
+			} else {
+				err = e.ScriptHandler(a.Event, event, part, obj.Data(), trans)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+createEdge creates an edge from a written node to a target node. Nothing is
+done if the target node or the edge does not exist. The key of the edge is
+derived from the keys of both ends so the edge is only created once.
+*/
+func createEdge(gm *graph.Manager, trans graph.Trans, a *Action, part string, obj data.Node) error {
+	targetKey := a.TargetKey
+
+	if a.TargetKeyAttr != "" {
+		if v := obj.Attr(a.TargetKeyAttr); v != nil {
+			targetKey = fmt.Sprint(v)
+		} else {
+			targetKey = ""
+		}
+	}
+
+	if targetKey == "" {
+		return nil
+	}
+
+	target, err := gm.FetchNode(part, targetKey, a.TargetKind)
+
+	if err != nil || target == nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%v-%v-%v", obj.Key(), a.EdgeKind, targetKey)
+
+	if existing, err := gm.FetchEdge(part, key, a.EdgeKind); err != nil || existing != nil {
+		return err
+	}
+
+	edge := data.NewGraphEdge()
+
+	edge.SetAttr(data.NodeKey, key)
+	edge.SetAttr(data.NodeKind, a.EdgeKind)
+
+	edge.SetAttr(data.EdgeEnd1Key, obj.Key())
+	edge.SetAttr(data.EdgeEnd1Kind, obj.Kind())
+	edge.SetAttr(data.EdgeEnd1Role, a.Role)
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+	edge.SetAttr(data.EdgeEnd2Key, targetKey)
+	edge.SetAttr(data.EdgeEnd2Kind, a.TargetKind)
+	edge.SetAttr(data.EdgeEnd2Role, a.TargetRole)
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	return trans.StoreEdge(part, edge)
+}
+
+/*
+postEvent posts a rule event as JSON to a webhook.
+*/
+func postEvent(url string, rule string, event string, part string, isEdge bool, obj data.Node) error {
+
+	msg := map[string]interface{}{
+		"rule":      rule,
+		"event":     event,
+		"partition": part,
+		"time":      time.Now().Unix(),
+	}
+
+	if isEdge {
+		msg["edge"] = obj.Data()
+	} else {
+		msg["node"] = obj.Data()
+	}
+
+	msgJSON, err := json.Marshal(msg)
+
+	if err == nil {
+		var resp *http.Response
+
+		client := &http.Client{Timeout: WebhookTimeout}
+
+		if resp, err = client.Post(url, "application/json; charset=utf-8", bytes.NewReader(msgJSON)); err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("Webhook %v returned status: %v", url, resp.Status)
+			}
+		}
+	}
+
+	return err
+}
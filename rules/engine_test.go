@@ -0,0 +1,358 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func newNode(key string, kind string, attrs ...interface{}) data.Node {
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, key)
+	node.SetAttr(data.NodeKind, kind)
+
+	for i := 0; i < len(attrs); i += 2 {
+		node.SetAttr(attrs[i].(string), attrs[i+1])
+	}
+
+	return node
+}
+
+func TestRuleValidation(t *testing.T) {
+
+	for _, test := range []struct {
+		rule string
+		msg  string
+	}{
+		{`{"name": "a b", "events": ["node.created"], "actions": [{"type": "webhook", "url": "http://x"}]}`,
+			"Invalid rule name (allowed are letters, digits, - and _): a b"},
+		{`{"name": "r", "actions": [{"type": "webhook", "url": "http://x"}]}`,
+			"Rule must contain at least one event"},
+		{`{"name": "r", "events": ["node.foo"], "actions": [{"type": "webhook", "url": "http://x"}]}`,
+			"Unknown event: node.foo"},
+		{`{"name": "r", "events": ["node.created"], "conditions": [{"op": "="}], "actions": [{"type": "webhook", "url": "http://x"}]}`,
+			"Condition must contain an attribute"},
+		{`{"name": "r", "events": ["node.created"], "conditions": [{"attr": "a", "op": "~"}], "actions": [{"type": "webhook", "url": "http://x"}]}`,
+			"Unknown operator: ~"},
+		{`{"name": "r", "events": ["node.created"]}`,
+			"Rule must contain at least one action"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "set_attr", "attr": "key"}]}`,
+			"Action set_attr needs an attribute other than key and kind"},
+		{`{"name": "r", "events": ["node.deleted"], "actions": [{"type": "set_attr", "attr": "a"}]}`,
+			"Action set_attr cannot run on delete events"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "create_edge", "edge_kind": "e"}]}`,
+			"Action create_edge needs an edge kind, a target kind and a target key"},
+		{`{"name": "r", "events": ["edge.created"], "actions": [{"type": "create_edge", "edge_kind": "e", "target_kind": "k", "target_key": "1"}]}`,
+			"Action create_edge can only run on created or updated nodes"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "webhook", "url": "file:x"}]}`,
+			"Action webhook needs a webhook URL: file:x"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "script", "event": "a.b"}]}`,
+			"Invalid script event name (allowed are letters, digits, - and _): a.b"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "foo"}]}`,
+			"Unknown action: foo"},
+		{`{"name": "r", "events": ["node.created"], "actions": [{"type": "script"}], "mode": "foo"}`,
+			"Unknown mode: foo"},
+	} {
+		r := &Rule{}
+		json.Unmarshal([]byte(test.rule), r)
+
+		if err := r.Validate(); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", test.rule, err)
+			return
+		}
+	}
+
+	r := &Rule{}
+	json.Unmarshal([]byte(`{"name": "r", "events": ["node.created"], "actions": [
+		{"type": "script"}, {"type": "create_edge", "edge_kind": "e", "target_kind": "k", "target_key": "1"}]}`), r)
+
+	if err := r.Validate(); err != nil || r.Mode != ModeSync || r.Actions[0].Event != "r" ||
+		r.Actions[1].Role != "source" || r.Actions[1].TargetRole != "target" {
+		t.Error("Unexpected result:", r, err)
+		return
+	}
+}
+
+func TestSyncRules(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	e, err := NewEngine(gm, "system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer e.Close()
+
+	gm.SetGraphRule(e)
+
+	// Rules which flag big orders and link orders to their customer
+
+	for _, rule := range []string{
+		`{"name": "big", "partition": "main", "kind": "Order", "events": ["node.created", "node.updated"],
+			"conditions": [{"attr": "amount", "op": ">=", "value": 100}],
+			"actions": [{"type": "set_attr", "attr": "big", "value": true},
+				{"type": "set_attr", "attr": "total", "value_attr": "amount"}]}`,
+		`{"name": "link", "kind": "Order", "events": ["node.created"],
+			"conditions": [{"attr": "customer", "op": "exists"}],
+			"actions": [{"type": "create_edge", "edge_kind": "OrderedBy", "target_kind": "Customer",
+				"target_key_attr": "customer", "role": "order", "target_role": "customer"}]}`,
+		`{"name": "disabled", "events": ["node.created"], "disabled": true,
+			"actions": [{"type": "set_attr", "attr": "disabled", "value": 1}]}`,
+	} {
+		r := &Rule{}
+		json.Unmarshal([]byte(rule), r)
+
+		if err := e.StoreRule(r); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	gm.StoreNode("main", newNode("c1", "Customer"))
+	gm.StoreNode("main", newNode("o1", "Order", "amount", 50, "customer", "c1"))
+	gm.StoreNode("main", newNode("o2", "Order", "amount", 150, "customer", "c2"))
+	gm.StoreNode("other", newNode("o3", "Order", "amount", 150))
+
+	if n, _ := gm.FetchNode("main", "o1", "Order"); n.Attr("big") != nil || n.Attr("disabled") != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "o2", "Order"); n.Attr("big") != true || fmt.Sprint(n.Attr("total")) != "150" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	if n, _ := gm.FetchNode("other", "o3", "Order"); n.Attr("big") != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Only the order of an existing customer is linked
+
+	if res := fmt.Sprint(gm.EdgeCount("OrderedBy")); res != "1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if e, _ := gm.FetchEdge("main", "o1-OrderedBy-c1", "OrderedBy"); e == nil ||
+		e.End1Role() != "order" || e.End2Key() != "c1" {
+		t.Error("Unexpected result:", e)
+		return
+	}
+
+	// Rules also run within transactions
+
+	trans := graph.NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("o4", "Order", "amount", 200, "customer", "c1"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "o4", "Order"); n.Attr("big") != true {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	if res := fmt.Sprint(gm.EdgeCount("OrderedBy")); res != "2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Updates see the full node
+
+	gm.UpdateNode("main", newNode("o1", "Order", "amount", 120))
+
+	if n, _ := gm.FetchNode("main", "o1", "Order"); n.Attr("big") != true || n.Attr("customer") != "c1" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// The rule also fires on its own updates which do not write anything
+
+	if _, stats := e.Rule("big"); stats.Fired != 6 || stats.Failed != 0 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// Failing synchronous rules fail the transaction of the write
+
+	r := &Rule{}
+	json.Unmarshal([]byte(`{"name": "script", "kind": "Order", "events": ["node.created"],
+		"actions": [{"type": "script"}]}`), r)
+	e.StoreRule(r)
+
+	trans = graph.NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("o5", "Order", "amount", 1))
+
+	if err := trans.Commit(); err == nil ||
+		err.Error() != "GraphError: Graph rule error (Rule script failed: Scripting is not enabled)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	var scriptCalls []string
+
+	e.ScriptHandler = func(name string, event string, part string, obj map[string]interface{}, trans graph.Trans) error {
+		scriptCalls = append(scriptCalls, fmt.Sprint(name, " ", event, " ", part, " ", obj["key"]))
+		return nil
+	}
+
+	if err := gm.StoreNode("main", newNode("o6", "Order", "amount", 1)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(scriptCalls); res != "[script node.created main o6]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, stats := e.Rule("script"); stats.Fired != 2 || stats.Failed != 1 ||
+		stats.LastError != "Scripting is not enabled" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// Rules are not active if the engine is inactive
+
+	e.Active = func() bool { return false }
+
+	gm.StoreNode("main", newNode("o7", "Order", "amount", 500))
+
+	if n, _ := gm.FetchNode("main", "o7", "Order"); n.Attr("big") != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	e.Active = nil
+
+	// Rules are loaded from the storage partition
+
+	e2, err := NewEngine(gm, "system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer e2.Close()
+
+	var names []string
+	for _, r := range e2.Rules() {
+		names = append(names, r.Name)
+	}
+
+	if res := fmt.Sprint(names); res != "[big disabled link script]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if ok, err := e.RemoveRule("big"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := e.RemoveRule("big"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if r, _ := e.Rule("big"); r != nil {
+		t.Error("Unexpected result:", r)
+		return
+	}
+}
+
+func TestAsyncRules(t *testing.T) {
+	var msgs []map[string]interface{}
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]interface{}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &msg)
+
+		msgs = append(msgs, msg)
+	}))
+	defer hook.Close()
+
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	e, err := NewEngine(gm, "system")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer e.Close()
+
+	gm.SetGraphRule(e)
+
+	for _, rule := range []string{
+		`{"name": "hook", "kind": "Order", "events": ["node.updated", "node.deleted", "edge.created"], "mode": "async",
+			"conditions": [{"attr": "state", "op": "changed"}],
+			"actions": [{"type": "webhook", "url": "` + hook.URL + `"}]}`,
+		`{"name": "stamp", "kind": "Order", "events": ["node.updated"], "mode": "async",
+			"conditions": [{"attr": "state", "op": "=", "value": "done"}],
+			"actions": [{"type": "set_attr", "attr": "archived", "value": "yes"}]}`,
+		`{"name": "broken", "kind": "Order", "events": ["node.deleted"], "mode": "async",
+			"actions": [{"type": "webhook", "url": "http://127.0.0.1:1/foo"}]}`,
+	} {
+		r := &Rule{}
+		json.Unmarshal([]byte(rule), r)
+
+		if err := e.StoreRule(r); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	gm.StoreNode("main", newNode("o1", "Order", "state", "new"))
+	gm.UpdateNode("main", newNode("o1", "Order", "comment", "foo"))
+	gm.UpdateNode("main", newNode("o1", "Order", "state", "done"))
+
+	e.Wait()
+
+	if n, _ := gm.FetchNode("main", "o1", "Order"); n.Attr("archived") != "yes" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	gm.RemoveNode("main", "o1", "Order")
+
+	e.Wait()
+
+	if len(msgs) != 2 || msgs[0]["event"] != EventNodeUpdated || msgs[1]["event"] != EventNodeDeleted ||
+		msgs[0]["rule"] != "hook" || msgs[0]["partition"] != "main" ||
+		msgs[0]["node"].(map[string]interface{})["state"] != "done" {
+		t.Error("Unexpected result:", msgs)
+		return
+	}
+
+	if _, stats := e.Rule("broken"); stats.Fired != 1 || stats.Failed != 1 || stats.LastError == "" {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if _, stats := e.Rule("stamp"); stats.Fired != 2 || stats.Failed != 0 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+}
@@ -0,0 +1,320 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package rules contains a rules engine which runs user defined actions on writes
+of nodes and edges.
+
+A rule has conditions (partition, kind, events and attribute predicates) and a
+list of actions (set an attribute, create an edge, call a webhook or run a
+script). Synchronous rules run their actions within the transaction of the
+write - a failing action fails the write. Asynchronous rules run their actions
+in the background after the write with their own transaction.
+
+Rules are stored as nodes in a partition of the graph so they survive restarts.
+*/
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+Events which can fire a rule
+*/
+const (
+	EventNodeCreated = "node.created"
+	EventNodeUpdated = "node.updated"
+	EventNodeDeleted = "node.deleted"
+	EventEdgeCreated = "edge.created"
+	EventEdgeUpdated = "edge.updated"
+	EventEdgeDeleted = "edge.deleted"
+)
+
+/*
+Execution modes of rules
+*/
+const (
+	ModeSync  = "sync"  // Actions run within the transaction of the write
+	ModeAsync = "async" // Actions run in the background after the write
+)
+
+/*
+Action types
+*/
+const (
+	ActionSetAttr    = "set_attr"    // Set an attribute of the written node or edge
+	ActionCreateEdge = "create_edge" // Create an edge from the written node to another node
+	ActionWebhook    = "webhook"     // Post the event to a webhook
+	ActionScript     = "script"      // Run a script with the event
+)
+
+/*
+knownEvents are all events which can fire a rule.
+*/
+var knownEvents = map[string]bool{
+	EventNodeCreated: true,
+	EventNodeUpdated: true,
+	EventNodeDeleted: true,
+	EventEdgeCreated: true,
+	EventEdgeUpdated: true,
+	EventEdgeDeleted: true,
+}
+
+/*
+ruleNameRegexp is the pattern of valid rule names.
+*/
+var ruleNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+/*
+Condition is a predicate on an attribute of the written node or edge.
+*/
+type Condition struct {
+	Attr     string      `json:"attr"`            // Name of the attribute
+	Operator string      `json:"op"`              // Operator of the condition
+	Value    interface{} `json:"value,omitempty"` // Compared value
+}
+
+/*
+conditionOperators are the supported operators of conditions. The functions get
+the attribute value of the current state and of the previous state (if known).
+*/
+var conditionOperators = map[string]func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool{
+	"=": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && compareValues(v, cmp) == 0
+	},
+	"!=": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v == nil || compareValues(v, cmp) != 0
+	},
+	">": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && compareValues(v, cmp) > 0
+	},
+	">=": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && compareValues(v, cmp) >= 0
+	},
+	"<": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && compareValues(v, cmp) < 0
+	},
+	"<=": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && compareValues(v, cmp) <= 0
+	},
+	"contains": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil && strings.Contains(fmt.Sprint(v), fmt.Sprint(cmp))
+	},
+	"exists": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v != nil
+	},
+	"missing": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return v == nil
+	},
+	"changed": func(v interface{}, old interface{}, hasOld bool, cmp interface{}) bool {
+		return !hasOld || (v == nil) != (old == nil) || (v != nil && compareValues(v, old) != 0)
+	},
+}
+
+/*
+compareValues compares two values. Numbers are compared by their value - all
+other values are compared by their string representation.
+*/
+func compareValues(v1 interface{}, v2 interface{}) int {
+	s1, s2 := fmt.Sprint(v1), fmt.Sprint(v2)
+
+	if f1, err := strconv.ParseFloat(s1, 64); err == nil {
+		if f2, err := strconv.ParseFloat(s2, 64); err == nil {
+			if f1 < f2 {
+				return -1
+			} else if f1 > f2 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(s1, s2)
+}
+
+/*
+Action is an action of a rule.
+*/
+type Action struct {
+	Type string `json:"type"` // Type of the action
+
+	Attr      string      `json:"attr,omitempty"`       // Attribute which is set (set_attr)
+	Value     interface{} `json:"value,omitempty"`      // Value which is set (set_attr)
+	ValueAttr string      `json:"value_attr,omitempty"` // Attribute whose value is copied (set_attr)
+
+	EdgeKind      string `json:"edge_kind,omitempty"`       // Kind of the created edge (create_edge)
+	Role          string `json:"role,omitempty"`            // Role of the written node (create_edge)
+	TargetKind    string `json:"target_kind,omitempty"`     // Kind of the target node (create_edge)
+	TargetKey     string `json:"target_key,omitempty"`      // Key of the target node (create_edge)
+	TargetKeyAttr string `json:"target_key_attr,omitempty"` // Attribute which contains the key of the target node (create_edge)
+	TargetRole    string `json:"target_role,omitempty"`     // Role of the target node (create_edge)
+
+	URL string `json:"url,omitempty"` // URL of the webhook (webhook)
+
+	Event string `json:"event,omitempty"` // Name of the script event - defaults to the rule name (script)
+}
+
+/*
+Rule is a user defined rule which runs actions on writes of nodes and edges.
+*/
+type Rule struct {
+	Name       string       `json:"name"`                 // Name of the rule
+	Partition  string       `json:"partition,omitempty"`  // Partition of the writes (empty for all partitions)
+	Kind       string       `json:"kind,omitempty"`       // Kind of the written nodes or edges (empty for all kinds)
+	Events     []string     `json:"events"`               // Events which fire the rule
+	Conditions []*Condition `json:"conditions,omitempty"` // Conditions on attributes which must all match
+	Actions    []*Action    `json:"actions"`              // Actions which run if the rule fires
+	Mode       string       `json:"mode"`                 // Execution mode (sync or async)
+	Disabled   bool         `json:"disabled,omitempty"`   // Flag if the rule is disabled
+}
+
+/*
+Validate checks a rule and fills in default values.
+*/
+func (r *Rule) Validate() error {
+	var hasDelete, hasEdge bool
+
+	if !ruleNameRegexp.MatchString(r.Name) {
+		return fmt.Errorf("Invalid rule name (allowed are letters, digits, - and _): %v", r.Name)
+	}
+
+	if len(r.Events) == 0 {
+		return fmt.Errorf("Rule must contain at least one event")
+	}
+
+	for _, e := range r.Events {
+		if !knownEvents[e] {
+			return fmt.Errorf("Unknown event: %v", e)
+		}
+
+		hasDelete = hasDelete || e == EventNodeDeleted || e == EventEdgeDeleted
+		hasEdge = hasEdge || strings.HasPrefix(e, "edge.")
+	}
+
+	for _, c := range r.Conditions {
+		if c.Attr == "" {
+			return fmt.Errorf("Condition must contain an attribute")
+		} else if _, ok := conditionOperators[c.Operator]; !ok {
+			return fmt.Errorf("Unknown operator: %v", c.Operator)
+		}
+	}
+
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("Rule must contain at least one action")
+	}
+
+	for _, a := range r.Actions {
+
+		switch a.Type {
+
+		case ActionSetAttr:
+			if a.Attr == "" || a.Attr == data.NodeKey || a.Attr == data.NodeKind {
+				return fmt.Errorf("Action %v needs an attribute other than key and kind", a.Type)
+			} else if hasDelete {
+				return fmt.Errorf("Action %v cannot run on delete events", a.Type)
+			}
+
+		case ActionCreateEdge:
+			if a.EdgeKind == "" || a.TargetKind == "" || (a.TargetKey == "" && a.TargetKeyAttr == "") {
+				return fmt.Errorf("Action %v needs an edge kind, a target kind and a target key", a.Type)
+			} else if hasDelete || hasEdge {
+				return fmt.Errorf("Action %v can only run on created or updated nodes", a.Type)
+			}
+
+			if a.Role == "" {
+				a.Role = "source"
+			}
+
+			if a.TargetRole == "" {
+				a.TargetRole = "target"
+			}
+
+		case ActionWebhook:
+			if !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://") {
+				return fmt.Errorf("Action %v needs a webhook URL: %v", a.Type, a.URL)
+			}
+
+		case ActionScript:
+			if a.Event == "" {
+				a.Event = r.Name
+			} else if !ruleNameRegexp.MatchString(a.Event) {
+				return fmt.Errorf("Invalid script event name (allowed are letters, digits, - and _): %v", a.Event)
+			}
+
+		default:
+			return fmt.Errorf("Unknown action: %v", a.Type)
+		}
+	}
+
+	if r.Mode == "" {
+		r.Mode = ModeSync
+	} else if r.Mode != ModeSync && r.Mode != ModeAsync {
+		return fmt.Errorf("Unknown mode: %v", r.Mode)
+	}
+
+	return nil
+}
+
+/*
+matches checks if the rule fires for a written node or edge. The previous
+state is only given for update events.
+*/
+func (r *Rule) matches(part string, event string, obj data.Node, old data.Node) bool {
+
+	if r.Disabled || (r.Partition != "" && r.Partition != part) ||
+		(r.Kind != "" && r.Kind != obj.Kind()) {
+		return false
+	}
+
+	found := false
+
+	for _, e := range r.Events {
+		if e == event {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return false
+	}
+
+	for _, c := range r.Conditions {
+		var oldVal interface{}
+
+		if old != nil {
+			oldVal = old.Attr(c.Attr)
+		}
+
+		if !conditionOperators[c.Operator](obj.Attr(c.Attr), oldVal, old != nil, c.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+hasScript checks if the rule contains a script action.
+*/
+func (r *Rule) hasScript() bool {
+	for _, a := range r.Actions {
+		if a.Type == ActionScript {
+			return true
+		}
+	}
+	return false
+}
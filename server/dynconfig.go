@@ -0,0 +1,214 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/krotik/common/datautil"
+	"github.com/krotik/eliasdb/api"
+	v1 "github.com/krotik/eliasdb/api/v1"
+	"github.com/krotik/eliasdb/config"
+	"github.com/krotik/eliasdb/eql"
+	"github.com/krotik/eliasdb/webhooks"
+)
+
+/*
+registerDynamicOptions registers the configuration options which can be
+changed at runtime through the config endpoint or by reloading the config file.
+*/
+func registerDynamicOptions() {
+
+	config.DynamicOptions[config.RequestLogLevel] = func() error {
+		level := config.Str(config.RequestLogLevel)
+
+		if api.RequestLog == nil {
+
+			// Only check the level if the request log is disabled
+
+			_, err := api.NewRequestLogger(ioutil.Discard, level)
+			return err
+		}
+
+		return api.RequestLog.SetLevel(level)
+	}
+
+	applyResultCache := func() error {
+		if err := checkNonNegative(config.ResultCacheMaxSize, config.ResultCacheMaxAgeSeconds); err != nil {
+			return err
+		}
+
+		v1.ResultCacheMaxSize = uint64(config.Int(config.ResultCacheMaxSize))
+		v1.ResultCacheMaxAge = config.Int(config.ResultCacheMaxAgeSeconds)
+
+		// The result cache is replaced - cached results are discarded
+
+		if v1.ResultCache != nil {
+			v1.ResultCache = datautil.NewMapCache(v1.ResultCacheMaxSize, v1.ResultCacheMaxAge)
+		}
+
+		return nil
+	}
+
+	config.DynamicOptions[config.ResultCacheMaxSize] = applyResultCache
+	config.DynamicOptions[config.ResultCacheMaxAgeSeconds] = applyResultCache
+
+	config.DynamicOptions[config.EQLPlanCacheMaxSize] = func() error {
+		if err := checkNonNegative(config.EQLPlanCacheMaxSize); err != nil {
+			return err
+		}
+
+		// Cached query plans are discarded
+
+		eql.SetPlanCacheMaxSize(int(config.Int(config.EQLPlanCacheMaxSize)))
+
+		return nil
+	}
+
+	config.DynamicOptions[config.UserHistoryMaxEntries] = func() error {
+		err := checkNonNegative(config.UserHistoryMaxEntries)
+
+		if err == nil {
+			v1.HistoryMaxEntries = int(config.Int(config.UserHistoryMaxEntries))
+		}
+
+		return err
+	}
+
+	applySandbox := func() error {
+		err := checkNonNegative(config.SandboxMaxRows, config.SandboxRateLimit,
+			config.SandboxQueryTimeoutSeconds)
+
+		if err == nil {
+			v1.SandboxMaxRows = int(config.Int(config.SandboxMaxRows))
+			v1.SandboxRateLimit = int(config.Int(config.SandboxRateLimit))
+			v1.SandboxQueryTimeout = time.Duration(config.Int(config.SandboxQueryTimeoutSeconds)) * time.Second
+		}
+
+		return err
+	}
+
+	config.DynamicOptions[config.SandboxMaxRows] = applySandbox
+	config.DynamicOptions[config.SandboxRateLimit] = applySandbox
+	config.DynamicOptions[config.SandboxQueryTimeoutSeconds] = applySandbox
+
+	config.DynamicOptions[config.WidgetAllowedOrigins] = func() error {
+		v1.WidgetAllowedOrigins = splitList(config.Str(config.WidgetAllowedOrigins))
+		return nil
+	}
+
+	applyQueryOptions := func() error {
+		err := checkNonNegative(config.TraversalMaxVisitedNodes, config.EQLWorkerCount,
+			config.ShardRemoteCallBudget)
+
+		if err == nil {
+			v1.QueryOptions = &eql.QueryOptions{
+				TraversalMaxVisitedNodes: int(config.Int(config.TraversalMaxVisitedNodes)),
+				TraversalCycleDetection:  config.Bool(config.TraversalCycleDetection),
+				Workers:                  int(config.Int(config.EQLWorkerCount)),
+				RemoteCallBudget:         int(config.Int(config.ShardRemoteCallBudget)),
+			}
+		}
+
+		return err
+	}
+
+	config.DynamicOptions[config.TraversalMaxVisitedNodes] = applyQueryOptions
+	config.DynamicOptions[config.TraversalCycleDetection] = applyQueryOptions
+	config.DynamicOptions[config.EQLWorkerCount] = applyQueryOptions
+	config.DynamicOptions[config.ShardRemoteCallBudget] = applyQueryOptions
+
+	applyTransLimits := func() error {
+		err := checkNonNegative(config.TransactionMaxOperations, config.TransactionMaxBytes)
+
+		if err == nil {
+			api.GM.SetTransLimits(int(config.Int(config.TransactionMaxOperations)),
+				config.Int(config.TransactionMaxBytes))
+		}
+
+		return err
+	}
+
+	config.DynamicOptions[config.TransactionMaxOperations] = applyTransLimits
+	config.DynamicOptions[config.TransactionMaxBytes] = applyTransLimits
+
+	config.DynamicOptions[config.KeyGeneration] = func() error {
+		return api.GM.SetKeyGeneration(config.Str(config.KeyGeneration))
+	}
+
+	applyWebhooks := func() error {
+		err := checkNonNegative(config.WebhookMaxRetries, config.WebhookTimeoutSeconds)
+
+		if err == nil {
+			webhooks.MaxRetries = int(config.Int(config.WebhookMaxRetries))
+			webhooks.DeliveryTimeout = time.Duration(config.Int(config.WebhookTimeoutSeconds)) * time.Second
+		}
+
+		return err
+	}
+
+	config.DynamicOptions[config.WebhookMaxRetries] = applyWebhooks
+	config.DynamicOptions[config.WebhookTimeoutSeconds] = applyWebhooks
+}
+
+/*
+reloadConfig reloads the options which can be changed at runtime from the
+config file.
+*/
+func reloadConfig() {
+
+	print("Reloading configuration from ", config.DefaultConfigFile)
+
+	changed, restart, err := config.ReloadDynamic(config.DefaultConfigFile)
+
+	if err != nil {
+		print("Failed to reload configuration: ", err)
+		return
+	}
+
+	if len(changed) > 0 {
+		print("Changed configuration: ", strings.Join(changed, ", "))
+	}
+
+	if len(restart) > 0 {
+		print("Changed configuration which requires a restart: ", strings.Join(restart, ", "))
+	}
+}
+
+/*
+checkNonNegative checks that the given numeric options are not negative.
+*/
+func checkNonNegative(keys ...string) error {
+	for _, k := range keys {
+		if config.Int(k) < 0 {
+			return fmt.Errorf("%v must not be negative", k)
+		}
+	}
+	return nil
+}
+
+/*
+splitList splits a comma separated list. Empty entries are skipped.
+*/
+func splitList(list string) []string {
+	var ret []string
+
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,344 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+EncryptionKeyProvider is used to get the secrets of encryption keys which are
+not in the encryption key file (e.g. from an external key management system).
+Embedding applications can set this before the server is started.
+*/
+var EncryptionKeyProvider storage.KeyProvider
+
+/*
+encryptionKeyFile is the content of an encryption key file. Keys are stored
+by their ID (1-255). A secret can be taken from an environment variable with
+the prefix env: - partitions can have their own keys. The keys of shredded
+partitions were removed.
+*/
+type encryptionKeyFile struct {
+	Active     int                        `json:"active"`               // ID of the key for new records
+	Keys       map[string]string          `json:"keys"`                 // Secrets of all keys
+	Partitions map[string]*encryptionKeys `json:"partitions,omitempty"` // Keys of partitions with their own keys
+	Shredded   []string                   `json:"shredded,omitempty"`   // Partitions whose keys were removed
+	LastKey    int                        `json:"last_key,omitempty"`   // Highest key ID which was ever used
+}
+
+/*
+encryptionKeys are the keys of a partition.
+*/
+type encryptionKeys struct {
+	Active int               `json:"active"` // ID of the key for new records
+	Keys   map[string]string `json:"keys"`   // Secrets of all keys
+}
+
+/*
+keyRing holds the key rings of the storage and of all partitions with their
+own keys. Rotated and removed keys are written back to the key file.
+*/
+type keyRing struct {
+	filename   string                         // Name of the key file
+	file       *encryptionKeyFile             // Content of the key file
+	encryption *storage.Encryption            // Key ring of the storage
+	partitions map[string]*storage.Encryption // Key rings of partitions
+	gs         *graphstorage.DiskGraphStorage // Storage which uses the key rings
+	mutex      *sync.Mutex                    // Mutex to protect key changes
+}
+
+/*
+loadEncryption creates the encryption key rings from a given key file.
+*/
+func loadEncryption(filename string) (*keyRing, error) {
+	var kf encryptionKeyFile
+
+	content, err := ioutil.ReadFile(filename)
+
+	if err == nil {
+		err = json.Unmarshal(content, &kf)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read encryption key file %v: %v", filename, err)
+	}
+
+	kr := &keyRing{filename, &kf, nil, make(map[string]*storage.Encryption), nil, &sync.Mutex{}}
+
+	if kr.encryption, err = newEncryption(kf.Keys, kf.Active, EncryptionKeyProvider); err != nil {
+		return nil, err
+	}
+
+	for partition, keys := range kf.Partitions {
+		if kr.partitions[partition], err = newEncryption(keys.Keys, keys.Active, kr.secret); err != nil {
+			return nil, fmt.Errorf("Partition %v: %v", partition, err)
+		}
+	}
+
+	// Records of shredded partitions cannot be read or written
+
+	for _, partition := range kf.Shredded {
+		kr.partitions[partition] = storage.NewEncryption(nil)
+	}
+
+	return kr, nil
+}
+
+/*
+newEncryption creates a key ring from the given keys.
+*/
+func newEncryption(keys map[string]string, active int, provider storage.KeyProvider) (*storage.Encryption, error) {
+
+	e := storage.NewEncryption(provider)
+
+	for idString, secret := range keys {
+		id, err := strconv.ParseUint(idString, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encryption key ID: %v", idString)
+		}
+
+		if err := e.AddKey(byte(id), []byte(resolveSecret(secret))); err != nil {
+			return nil, err
+		}
+	}
+
+	if active < 1 || active > 255 {
+		return nil, fmt.Errorf("Invalid active encryption key: %v", active)
+	}
+
+	return e, e.SetActiveKey(byte(active))
+}
+
+/*
+resolveSecret resolves secrets which are taken from environment variables.
+*/
+func resolveSecret(secret string) string {
+	if strings.HasPrefix(secret, "env:") {
+		return os.Getenv(strings.TrimPrefix(secret, "env:"))
+	}
+	return secret
+}
+
+/*
+secret returns the secret of a key of the storage. This allows partitions to
+read records which were written before they got their own keys.
+*/
+func (kr *keyRing) secret(id byte) ([]byte, error) {
+
+	if secret, ok := kr.file.Keys[fmt.Sprint(id)]; ok {
+		return []byte(resolveSecret(secret)), nil
+	} else if EncryptionKeyProvider != nil {
+		return EncryptionKeyProvider(id)
+	}
+
+	return nil, fmt.Errorf("Unknown encryption key: %v", id)
+}
+
+/*
+apply applies all key rings to a given storage.
+*/
+func (kr *keyRing) apply(gs *graphstorage.DiskGraphStorage) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	kr.gs = gs
+
+	gs.SetEncryption(kr.encryption)
+
+	for partition, e := range kr.partitions {
+		gs.SetPartitionEncryption(partition, e)
+	}
+}
+
+/*
+RotateKey creates a new random key for a given partition (or for the storage
+if the partition is empty) and makes it the active key. The key file is
+written before the key is used.
+*/
+func (kr *keyRing) RotateKey(partition string) (byte, error) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	for _, p := range kr.file.Shredded {
+		if p == partition {
+			return 0, fmt.Errorf("Encryption keys of partition %v were shredded", partition)
+		}
+	}
+
+	id := kr.lastKey() + 1
+	if id > 255 {
+		return 0, fmt.Errorf("No encryption key IDs left")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, err
+	}
+
+	keys := &encryptionKeys{kr.file.Active, kr.file.Keys}
+
+	if partition != "" {
+		var ok bool
+
+		if keys, ok = kr.file.Partitions[partition]; !ok {
+			keys = &encryptionKeys{0, make(map[string]string)}
+		}
+	}
+
+	oldActive, oldLast := keys.Active, kr.file.LastKey
+
+	keys.Keys[fmt.Sprint(id)] = hex.EncodeToString(secret)
+	keys.Active = id
+	kr.file.LastKey = id
+
+	if partition == "" {
+		kr.file.Active = id
+	} else {
+		if kr.file.Partitions == nil {
+			kr.file.Partitions = make(map[string]*encryptionKeys)
+		}
+		kr.file.Partitions[partition] = keys
+	}
+
+	if err := kr.write(); err != nil {
+
+		// Revert the changes so the key file and the key rings stay in sync
+
+		delete(keys.Keys, fmt.Sprint(id))
+		keys.Active, kr.file.LastKey = oldActive, oldLast
+
+		if partition == "" {
+			kr.file.Active = oldActive
+		} else if len(keys.Keys) == 0 {
+			delete(kr.file.Partitions, partition)
+		}
+
+		return 0, err
+	}
+
+	e := kr.encryption
+
+	if partition != "" {
+		var ok bool
+
+		if e, ok = kr.partitions[partition]; !ok {
+			e = storage.NewEncryption(kr.secret)
+		}
+	}
+
+	err := e.AddKey(byte(id), []byte(keys.Keys[fmt.Sprint(id)]))
+
+	if err == nil {
+		err = e.SetActiveKey(byte(id))
+	}
+
+	if err == nil && partition != "" {
+		kr.partitions[partition] = e
+
+		if kr.gs != nil {
+			kr.gs.SetPartitionEncryption(partition, e)
+		}
+	}
+
+	return byte(id), err
+}
+
+/*
+ShredPartition removes all keys of a given partition. Records of the partition
+cannot be read or written afterwards.
+*/
+func (kr *keyRing) ShredPartition(partition string) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+
+	keys, ok := kr.file.Partitions[partition]
+	if !ok {
+		return fmt.Errorf("Partition %v has no own encryption keys", partition)
+	}
+
+	delete(kr.file.Partitions, partition)
+	kr.file.Shredded = append(kr.file.Shredded, partition)
+
+	if err := kr.write(); err != nil {
+		kr.file.Partitions[partition] = keys
+		kr.file.Shredded = kr.file.Shredded[:len(kr.file.Shredded)-1]
+
+		return err
+	}
+
+	e := storage.NewEncryption(nil)
+
+	kr.partitions[partition] = e
+
+	if kr.gs != nil {
+		kr.gs.SetPartitionEncryption(partition, e)
+	}
+
+	return nil
+}
+
+/*
+lastKey returns the highest key ID which was ever used. IDs are never reused
+so records of shredded partitions cannot be read with a new key.
+*/
+func (kr *keyRing) lastKey() int {
+	last := kr.file.LastKey
+
+	check := func(keys map[string]string) {
+		for idString := range keys {
+			if id, err := strconv.Atoi(idString); err == nil && id > last {
+				last = id
+			}
+		}
+	}
+
+	check(kr.file.Keys)
+
+	for _, keys := range kr.file.Partitions {
+		check(keys.Keys)
+	}
+
+	return last
+}
+
+/*
+write writes the key file. The file is replaced atomically.
+*/
+func (kr *keyRing) write() error {
+
+	content, err := json.MarshalIndent(kr.file, "", "  ")
+
+	if err == nil {
+		err = ioutil.WriteFile(kr.filename+".tmp", content, 0600)
+	}
+
+	if err == nil {
+		err = os.Rename(kr.filename+".tmp", kr.filename)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not write encryption key file %v: %v", kr.filename, err)
+	}
+
+	return nil
+}
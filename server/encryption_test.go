@@ -0,0 +1,149 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEncryption(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "encryptiontest")
+	defer os.RemoveAll(dir)
+
+	keyfile := filepath.Join(dir, "keys.json")
+
+	if _, err := loadEncryption(keyfile); err == nil || !strings.HasPrefix(err.Error(), "Could not read encryption key file") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	os.Setenv("ELIASDB_TEST_KEY", "secret2")
+	defer os.Unsetenv("ELIASDB_TEST_KEY")
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 2, "keys": {"1": "secret1", "2": "env:ELIASDB_TEST_KEY"}}`), 0660)
+
+	if kr, err := loadEncryption(keyfile); err != nil || kr.encryption.ActiveKey() != 2 {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 3, "keys": {"1": "secret1"}}`), 0660)
+
+	if _, err := loadEncryption(keyfile); err == nil || err.Error() != "Unknown encryption key: 3" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 0, "keys": {"1": "secret1"}}`), 0660)
+
+	if _, err := loadEncryption(keyfile); err == nil || err.Error() != "Invalid active encryption key: 0" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 1, "keys": {"x": "secret1"}}`), 0660)
+
+	if _, err := loadEncryption(keyfile); err == nil || err.Error() != "Invalid encryption key ID: x" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 1, "keys": {"1": "env:ELIASDB_TEST_UNSET"}}`), 0660)
+
+	if _, err := loadEncryption(keyfile); err == nil || err.Error() != "Encryption key 1 has no secret" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "encryptiontest")
+	defer os.RemoveAll(dir)
+
+	keyfile := filepath.Join(dir, "keys.json")
+
+	ioutil.WriteFile(keyfile, []byte(`{"active": 1, "keys": {"1": "secret1"}, "partitions": {"p1": {"active": 2, "keys": {"2": "secret2"}}}}`), 0660)
+
+	kr, err := loadEncryption(keyfile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if kr.partitions["p1"].ActiveKey() != 2 {
+		t.Error("Unexpected result:", kr.partitions)
+		return
+	}
+
+	// Key IDs are unique across partitions
+
+	if id, err := kr.RotateKey(""); err != nil || id != 3 || kr.encryption.ActiveKey() != 3 {
+		t.Error("Unexpected result:", id, err)
+		return
+	}
+
+	if id, err := kr.RotateKey("p2"); err != nil || id != 4 || kr.partitions["p2"].ActiveKey() != 4 {
+		t.Error("Unexpected result:", id, err)
+		return
+	}
+
+	if err := kr.ShredPartition("p3"); err == nil || err.Error() != "Partition p3 has no own encryption keys" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kr.ShredPartition("p2"); err != nil || kr.partitions["p2"].ActiveKey() != 0 {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := kr.RotateKey("p2"); err == nil || err.Error() != "Encryption keys of partition p2 were shredded" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Changes were written to the key file
+
+	kr, err = loadEncryption(keyfile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if kr.encryption.ActiveKey() != 3 || kr.partitions["p1"].ActiveKey() != 2 ||
+		kr.partitions["p2"].ActiveKey() != 0 || kr.file.Partitions["p2"] != nil {
+		t.Error("Unexpected result:", kr.file)
+		return
+	}
+
+	// Shredded key IDs are not reused
+
+	if id, err := kr.RotateKey("p1"); err != nil || id != 5 {
+		t.Error("Unexpected result:", id, err)
+		return
+	}
+
+	// Partitions can read records which were written with the default keys
+
+	if secret, err := kr.secret(1); err != nil || string(secret) != "secret1" {
+		t.Error("Unexpected result:", string(secret), err)
+		return
+	}
+
+	if _, err := kr.secret(2); err == nil || err.Error() != "Unknown encryption key: 2" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
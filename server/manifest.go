@@ -0,0 +1,305 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/krotik/common/httputil/access"
+	"github.com/krotik/eliasdb/api/ac"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+Manifest describes the desired state of a server. A manifest is applied at
+startup and can be applied any number of times - only missing or changed
+parts are written.
+*/
+type Manifest struct {
+	Groups     map[string]map[string]string         `json:"groups"`     // Group name to permissions (path to CRUD string)
+	Users      map[string]*ManifestUser             `json:"users"`      // User name to user details
+	Analyzers  map[string]map[string]*util.Analyzer `json:"analyzers"`  // Kind to attribute to full-text index analyzer
+	Partitions map[string]*ManifestPartition        `json:"partitions"` // Partition name to seed data
+}
+
+/*
+ManifestPartition describes the seed data of a partition in a manifest.
+*/
+type ManifestPartition struct {
+	Nodes []map[string]interface{} `json:"nodes"` // Nodes which should exist
+	Edges []map[string]interface{} `json:"edges"` // Edges which should exist
+}
+
+/*
+ManifestUser describes a user in a manifest.
+*/
+type ManifestUser struct {
+	Password string                 `json:"password"` // Initial password of the user
+	Groups   []string               `json:"groups"`   // Groups of the user
+	Data     map[string]interface{} `json:"data"`     // User data
+}
+
+/*
+LoadManifest loads a manifest from a JSON file. Unknown sections are rejected
+so typos do not go unnoticed.
+*/
+func LoadManifest(filename string) (*Manifest, error) {
+	var m Manifest
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("Could not decode manifest %v: %v", filename, err)
+	}
+
+	return &m, nil
+}
+
+/*
+Apply applies the manifest to a given GraphManager and to the user database
+and access control lists (if access control is enabled). Groups and their
+permissions, the groups of users and analyzers are set to the state of the
+manifest. Users and seed data are only created if they do not exist yet - the
+password or data of existing users and changes to seed data are kept. Only
+users and groups are applied if no GraphManager is given.
+*/
+func (m *Manifest) Apply(gm *graph.Manager) error {
+
+	if len(m.Groups) > 0 || len(m.Users) > 0 {
+
+		if ac.ACL == nil || ac.UserDB == nil {
+			return fmt.Errorf("Manifest contains users or groups but access control is not enabled")
+		}
+
+		if err := m.applyGroups(); err != nil {
+			return err
+		}
+
+		if err := m.applyUsers(); err != nil {
+			return err
+		}
+	}
+
+	if gm == nil {
+		return nil
+	}
+
+	if err := m.applyAnalyzers(gm); err != nil {
+		return err
+	}
+
+	return m.applySeedData(gm)
+}
+
+/*
+applyGroups creates all groups of the manifest and sets their permissions.
+*/
+func (m *Manifest) applyGroups() error {
+
+	for _, name := range sortedKeys(m.Groups) {
+		var err error
+
+		perms := m.Groups[name]
+		rights := make(map[string]*access.Rights)
+
+		for path, perm := range perms {
+			if rights[path], err = access.RightsFromString(perm); err != nil {
+				return fmt.Errorf("Invalid permission %v of group %v: %v", perm, name, err)
+			}
+		}
+
+		current, err := ac.ACL.Permissions(name)
+		if err != nil {
+			if err = ac.ACL.AddGroup(name); err != nil {
+				return fmt.Errorf("Could not add group %v: %v", name, err)
+			}
+		} else if reflect.DeepEqual(current, perms) || (len(current) == 0 && len(perms) == 0) {
+			continue
+		}
+
+		if err = ac.ACL.ClearPermissions(name); err == nil {
+			for _, path := range sortedKeys(rights) {
+				if err = ac.ACL.AddPermission(name, path, rights[path]); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("Could not set permissions of group %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+applyUsers creates all missing users of the manifest and sets the groups of
+all users.
+*/
+func (m *Manifest) applyUsers() error {
+
+	for _, name := range sortedKeys(m.Users) {
+		user := m.Users[name]
+
+		if user == nil {
+			user = &ManifestUser{}
+		}
+
+		if !ac.UserDB.UserExists(name) {
+			if err := ac.UserDB.AddUserEntry(name, user.Password, user.Data); err != nil {
+				return fmt.Errorf("Could not add user %v: %v", name, err)
+			}
+		}
+
+		current, _ := ac.ACL.GroupsOfUser(name)
+		groups := append([]string{}, user.Groups...)
+
+		sort.Strings(current)
+		sort.Strings(groups)
+
+		if reflect.DeepEqual(current, groups) || (len(current) == 0 && len(groups) == 0) {
+			continue
+		}
+
+		for _, g := range current {
+			if err := ac.ACL.RemoveUserFromGroup(name, g); err != nil {
+				return fmt.Errorf("Could not remove user %v from group %v: %v", name, g, err)
+			}
+		}
+
+		for _, g := range groups {
+			if err := ac.ACL.AddUserToGroup(name, g); err != nil {
+				return fmt.Errorf("Could not add user %v to group %v: %v", name, g, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+applyAnalyzers sets all analyzers of the manifest. All partitions are
+reindexed for a kind if one of its analyzers has changed.
+*/
+func (m *Manifest) applyAnalyzers(gm *graph.Manager) error {
+	current := gm.Analyzers()
+
+	for _, kind := range sortedKeys(m.Analyzers) {
+		changed := false
+
+		for _, attr := range sortedKeys(m.Analyzers[kind]) {
+			analyzer := m.Analyzers[kind][attr]
+
+			if reflect.DeepEqual(current[kind][attr], analyzer) {
+				continue
+			}
+
+			if err := gm.SetAnalyzer(kind, attr, analyzer); err != nil {
+				return fmt.Errorf("Could not set analyzer %v/%v: %v", kind, attr, err)
+			}
+
+			changed = true
+		}
+
+		if changed {
+			for _, part := range gm.Partitions() {
+				if err := gm.ReindexNodes(part, kind, nil); err != nil {
+					return err
+				}
+				if err := gm.ReindexEdges(part, kind, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+applySeedData stores all nodes and edges of the manifest which do not exist
+yet. The data of each partition is stored in a single transaction.
+*/
+func (m *Manifest) applySeedData(gm *graph.Manager) error {
+
+	for _, part := range sortedKeys(m.Partitions) {
+		if m.Partitions[part] == nil {
+			continue
+		}
+
+		trans := graph.NewGraphTrans(gm)
+
+		for _, ndata := range m.Partitions[part].Nodes {
+			node := data.NewGraphNodeFromMap(ndata)
+
+			existing, err := gm.FetchNodePart(part, node.Key(), node.Kind(), []string{data.NodeKey})
+			if err != nil {
+				return err
+			}
+
+			if existing == nil {
+				if err := trans.StoreNode(part, node); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, edata := range m.Partitions[part].Edges {
+			edge := data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edata))
+
+			existing, err := gm.FetchEdgePart(part, edge.Key(), edge.Kind(), []string{data.NodeKey})
+			if err != nil {
+				return err
+			}
+
+			if existing == nil {
+				if err := trans.StoreEdge(part, edge); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := trans.Commit(); err != nil {
+			return fmt.Errorf("Could not store seed data in partition %v: %v", part, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+sortedKeys returns the sorted keys of a map with string keys.
+*/
+func sortedKeys(m interface{}) []string {
+	var ret []string
+
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		ret = append(ret, k.String())
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
@@ -0,0 +1,200 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krotik/common/datautil"
+	"github.com/krotik/common/httputil/access"
+	"github.com/krotik/eliasdb/api/ac"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+const testManifest = `
+{
+  "groups": {
+    "reader": { "/db/*": "-R--" }
+  },
+  "users": {
+    "alice": { "password": "Al1ce-Passw0rd!", "groups": ["reader"] }
+  },
+  "analyzers": {
+    "Article": { "text": { "lowercase": true, "language": "english", "stemming": true } }
+  },
+  "partitions": {
+    "main": {
+      "nodes": [
+        { "key": "1", "kind": "Article", "text": "Running foxes" },
+        { "key": "2", "kind": "Article", "text": "Sleeping dogs" }
+      ],
+      "edges": [
+        {
+          "key": "e1", "kind": "Link",
+          "end1key": "1", "end1kind": "Article", "end1role": "from", "end1cascading": false,
+          "end2key": "2", "end2kind": "Article", "end2role": "to", "end2cascading": false
+        }
+      ]
+    }
+  }
+}`
+
+func TestManifest(t *testing.T) {
+	manifestFile := filepath.Join(testdb, "manifest.json")
+
+	oldUserDB, oldACL := ac.UserDB, ac.ACL
+	defer func() {
+		ac.UserDB, ac.ACL = oldUserDB, oldACL
+	}()
+
+	if _, err := LoadManifest(invalidFileName); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	ioutil.WriteFile(manifestFile, []byte(`{"webhooks": {}}`), 0600)
+
+	if _, err := LoadManifest(manifestFile); err == nil ||
+		!strings.Contains(err.Error(), `json: unknown field "webhooks"`) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(manifestFile, []byte(testManifest), 0600)
+
+	manifest, err := LoadManifest(manifestFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	// Users and groups need access control
+
+	ac.UserDB, ac.ACL = nil, nil
+
+	if err := manifest.Apply(gm); err == nil ||
+		err.Error() != "Manifest contains users or groups but access control is not enabled" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ac.UserDB, _ = datautil.NewEnforcedUserDB(filepath.Join(testdb, "manifest.db"), "test")
+	ac.InitACLs(access.NewMemoryACLTable())
+
+	if err := manifest.Apply(gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ac.ACL.Permissions("reader"); fmt.Sprint(res) != "map[/db/*:-R--]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := ac.ACL.GroupsOfUser("alice"); fmt.Sprint(res) != "[reader]" ||
+		!ac.UserDB.CheckUserPassword("alice", "Al1ce-Passw0rd!") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(gm.NodeCount("Article"), gm.EdgeCount("Link")); res != "2 1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	iq, _ := gm.NodeIndexQuery("main", "Article")
+
+	if res, _ := iq.LookupWord("text", "run"); fmt.Sprint(res) != "map[1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Existing data and passwords are not changed - groups and permissions
+	// are set to the state of the manifest
+
+	node, _ := gm.FetchNode("main", "1", "Article")
+	node.SetAttr("text", "Jumping foxes")
+	gm.StoreNode("main", node)
+
+	ac.UserDB.UpdateUserPassword("alice", "N3w-Passw0rd!x")
+	ac.ACL.AddGroup("writer")
+	ac.ACL.AddUserToGroup("alice", "writer")
+	ac.ACL.AddPermission("reader", "/db/v1/graph/*", &access.Rights{Create: true})
+
+	if err := manifest.Apply(gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if node, _ = gm.FetchNode("main", "1", "Article"); node.Attr("text") != "Jumping foxes" {
+		t.Error("Unexpected result:", node)
+		return
+	}
+
+	if res, _ := ac.ACL.GroupsOfUser("alice"); fmt.Sprint(res) != "[reader]" ||
+		!ac.UserDB.CheckUserPassword("alice", "N3w-Passw0rd!x") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := ac.ACL.Permissions("reader"); fmt.Sprint(res) != "map[/db/*:-R--]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Only users and groups are applied without a graph manager
+
+	ac.ACL.RemoveUserFromGroup("alice", "reader")
+	gm.RemoveNode("main", "2", "Article")
+
+	if err := manifest.Apply(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ac.ACL.GroupsOfUser("alice"); fmt.Sprint(res, gm.NodeCount("Article")) != "[reader] 1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Errors are reported
+
+	for _, test := range []struct {
+		manifest *Manifest
+		res      string
+	}{
+		{&Manifest{Groups: map[string]map[string]string{"foo": {"/": "xxx"}}},
+			"Invalid permission xxx of group foo:"},
+		{&Manifest{Users: map[string]*ManifestUser{"bob": {Password: "weak"}}},
+			"Could not add user bob:"},
+		{&Manifest{Users: map[string]*ManifestUser{"alice": {Groups: []string{"foo"}}}},
+			"Could not add user alice to group foo:"},
+		{&Manifest{Analyzers: map[string]map[string]*util.Analyzer{"Article": {"text": {Language: "foo"}}}},
+			"Could not set analyzer Article/text: GraphError: Invalid data (Unknown language: foo"},
+		{&Manifest{Partitions: map[string]*ManifestPartition{"main": {Nodes: []map[string]interface{}{{"key": "3"}}}}},
+			"GraphError: Invalid data (Node is missing a kind value)"},
+		{&Manifest{Partitions: map[string]*ManifestPartition{"main": {Edges: []map[string]interface{}{{"key": "e2", "kind": "Link"}}}}},
+			"GraphError: Invalid data (Edge is missing a key value for end1)"},
+	} {
+		if err := test.manifest.Apply(gm); err == nil || !strings.HasPrefix(err.Error(), test.res) {
+			t.Error("Unexpected result:", test.res, err)
+			return
+		}
+	}
+}
@@ -336,6 +336,150 @@ const TermSRC = `
         t.ajaxPrefix = "/db";
         t.partition = "main";
 
+        // Console connection
+        // ==================
+
+        t.console = {
+
+            socket : undefined,
+            ready : false,
+            idCount : 0,
+            queue : [],
+            callbacks : {},
+
+            // Send a command to the console endpoint. The connection is
+            // opened on first use.
+            //
+            send : function (cmd, callbackOK, callbackError, callbackProgress) {
+                "use strict";
+
+                t.console.idCount++;
+                cmd.id = String(t.console.idCount);
+
+                t.console.callbacks[cmd.id] = {
+                    ok : callbackOK,
+                    error : callbackError,
+                    progress : callbackProgress,
+                    start : Date.now()
+                };
+
+                if (t.console.socket === undefined) {
+                    t.console.connect();
+                }
+
+                if (t.console.ready) {
+                    t.console.socket.send(JSON.stringify(cmd));
+                } else {
+                    t.console.queue.push(cmd);
+                }
+            },
+
+            // Open the connection to the console endpoint.
+            //
+            connect : function () {
+                "use strict";
+                var proto = window.location.protocol === "https:" ? "wss://" : "ws://",
+                    socket = new WebSocket(proto + window.location.host + t.ajaxPrefix + "/v1/console/",
+                        "eliasdb-console");
+
+                t.console.socket = socket;
+
+                socket.onmessage = function (e) {
+                    var msg = JSON.parse(e.data),
+                        cb = t.console.callbacks[msg.id];
+
+                    if (msg.type === "ready") {
+                        t.console.ready = true;
+                        t.console.queue.forEach(function (cmd) {
+                            socket.send(JSON.stringify(cmd));
+                        });
+                        t.console.queue = [];
+                        return;
+                    }
+
+                    if (cb === undefined) {
+                        return;
+                    }
+
+                    if (msg.type === "progress") {
+                        if (cb.progress) {
+                            cb.progress(msg.payload);
+                        }
+                        return;
+                    }
+
+                    delete t.console.callbacks[msg.id];
+
+                    var rtime = Date.now() - cb.start;
+                    document.title = "Terminal (Last response time: "+ rtime +"ms)";
+
+                    if (msg.type === "error") {
+                        if (cb.error) {
+                            cb.error(msg.payload.message);
+                        }
+                    } else if (cb.ok) {
+                        cb.ok(msg.payload);
+                    }
+                };
+
+                socket.onclose = function () {
+
+                    // Fail all pending commands - the connection is
+                    // reopened by the next command
+
+                    var callbacks = t.console.callbacks;
+
+                    t.console.socket = undefined;
+                    t.console.ready = false;
+                    t.console.queue = [];
+                    t.console.callbacks = {};
+
+                    t.getObjectValues(callbacks).forEach(function (cb) {
+                        if (cb.error) {
+                            cb.error("Console connection was closed");
+                        }
+                    });
+                };
+            },
+
+            // Run a query and fetch all pages of its result.
+            //
+            query : function (element, query) {
+                "use strict";
+                var onError = function (r) {
+                        t.main.addError(element, r);
+                    },
+                    onProgress = function (p) {
+                        t.main.addOutput(element, "Running (" + p.elapsed_ms + "ms) ...");
+                    };
+
+                t.console.send({
+                    cmd : "query",
+                    query : query,
+                    partition : t.partition
+                }, function (r) {
+                    var fetchPage = function () {
+                        if (r.rows.length >= r.total) {
+                            t.main.addTableOutput(element, r);
+                            return;
+                        }
+                        t.console.send({
+                            cmd : "page",
+                            result_id : r.result_id,
+                            offset : r.rows.length
+                        }, function (p) {
+                            if (p.rows.length === 0) {
+                                r.total = r.rows.length;
+                            }
+                            r.rows = r.rows.concat(p.rows);
+                            fetchPage();
+                        }, onError);
+                    };
+                    fetchPage();
+                }, onError, onProgress);
+            }
+        };
+
         // Console
         // =======
 
@@ -655,8 +799,10 @@ const TermSRC = `
             "info" : function (element) {
                 "use strict";
 
-                t.ajax(t.ajaxPrefix + "/v1/info/", "GET", undefined, function (r) {
+                t.console.send({ cmd : "describe" }, function (r) {
                     t.main.addOutput(element, JSON.stringify(r, undefined, 4));
+                }, function (r) {
+                    t.main.addError(element, r);
                 });
             },
 
@@ -665,26 +811,14 @@ const TermSRC = `
             "get" : function (element, data) {
                 "use strict";
 
-                t.ajax(t.ajaxPrefix + "/v1/query/" + t.partition + "?q=get" + encodeURIComponent(data), "GET", undefined,
-                    function (r) {
-                        t.main.addTableOutput(element, r);
-                    },
-                    function (r) {
-                        t.main.addError(element, r);
-                    });
+                t.console.query(element, "get" + data);
             },
 
             // Lookup data in the datastore.
             //
             "lookup" : function (element, data) {
                 "use strict";
-                t.ajax(t.ajaxPrefix + "/v1/query/" + t.partition + "?q=lookup" + encodeURIComponent(data), "GET", undefined,
-                    function (r) {
-                        t.main.addTableOutput(element, r);
-                    },
-                    function (r) {
-                        t.main.addError(element, r);
-                    });
+                t.console.query(element, "lookup" + data);
             },
 
             // Lookup data in the datastore.
@@ -1282,3 +1416,1036 @@ const ClusterTermSRC = `
   </body>
 </html>
 `
+
+/*
+ExplorerSRC is the explorer HTML as a text blob.
+*/
+const ExplorerSRC = `
+<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Explorer</title>
+    <style>
+        body {
+            background: #fff;
+            font-family: 'verdana';
+            font-size: 11px;
+            margin: 0;
+            min-width: 320px;
+        }
+
+        .x-header {
+            background: linear-gradient(#000, #444);
+            color: #fff;
+            font-weight: bold;
+            padding: 0 1em;
+            margin: 0 0 1em 0;
+            box-shadow: 3px 3px 3px rgba(50, 50, 50, 0.25);
+        }
+
+        .x-header h1 {
+            display: inline-block;
+            font-size: 18px;
+            margin: 3px 0;
+        }
+
+        .x-header select {
+            float: right;
+            margin: 5px 0;
+        }
+
+        .x-main {
+            padding: 0 2em 2em 2em;
+        }
+
+        .x-panel {
+            position: relative;
+            background: #EEEEEE;
+            padding: 10px;
+            border: #000000 2px solid;
+            border-radius: 10px;
+            margin: 1em 0 0 0;
+        }
+
+        .x-query {
+            width: 100%;
+            height: 5em;
+            box-sizing: border-box;
+            padding: 4px;
+            border: #888888 1px solid;
+            font-family: "Lucida Console", "Courier";
+            font-size: 12px;
+            resize: vertical;
+        }
+
+        .x-button {
+            background: #EEEEEE;
+            border: #000000 2px solid;
+            margin: 2px;
+            border-radius: 10px;
+            font-weight: bold;
+            cursor: pointer;
+        }
+
+        .x-button:hover {
+            color: #888888;
+            border-color: #888888;
+        }
+
+        .x-button:disabled {
+            color: #BBBBBB;
+            border-color: #BBBBBB;
+            cursor: default;
+        }
+
+        .x-suggest {
+            position: absolute;
+            z-index: 10;
+            min-width: 15em;
+            max-height: 15em;
+            overflow-y: auto;
+            margin: 0;
+            padding: 0;
+            list-style: none;
+            background: #fff;
+            border: #888888 1px solid;
+            font-family: "Lucida Console", "Courier";
+        }
+
+        .x-suggest li {
+            padding: 2px 6px;
+            cursor: pointer;
+        }
+
+        .x-suggest li.x-selected {
+            background: #B3D9FF;
+        }
+
+        .x-suggest li span {
+            float: right;
+            margin-left: 2em;
+            color: #888888;
+        }
+
+        .x-status {
+            margin: 0.5em 0;
+        }
+
+        .x-error {
+            padding: 5px;
+            background: #FFBBBB;
+            white-space: pre-wrap;
+            font-family: "Lucida Console", "Courier";
+        }
+
+        .x-table-wrap {
+            max-height: 30em;
+            overflow: auto;
+        }
+
+        .x-table {
+            width: 100%;
+            border-spacing: 0;
+            border-collapse: collapse;
+            background: #fff;
+        }
+
+        .x-table th {
+            position: sticky;
+            top: 0;
+            padding: 5px;
+            text-align: left;
+            background: #DDDDDD;
+            border-bottom: #000000 1px solid;
+        }
+
+        .x-table td {
+            padding: 5px;
+            border-bottom: #DDDDDD 1px solid;
+        }
+
+        .x-table tr.x-node-row:hover {
+            background: #B3D9FF;
+            cursor: pointer;
+        }
+
+        .x-graph {
+            display: block;
+            width: 100%;
+            height: 600px;
+            background: #fff;
+            border: #888888 1px solid;
+        }
+
+        .x-graph line {
+            stroke: #999999;
+            stroke-width: 1.5px;
+        }
+
+        .x-graph circle {
+            stroke: #fff;
+            stroke-width: 2px;
+            cursor: pointer;
+        }
+
+        .x-graph circle.x-expanded {
+            stroke: #000;
+        }
+
+        .x-graph text {
+            font-size: 10px;
+            pointer-events: none;
+        }
+
+        .x-graph text.x-edge-label {
+            fill: #888888;
+            font-size: 9px;
+        }
+
+        .x-details {
+            display: none;
+            position: absolute;
+            top: 45px;
+            right: 20px;
+            width: 250px;
+            max-height: 550px;
+            overflow: auto;
+            padding: 5px;
+            background: rgba(255, 255, 255, 0.9);
+            border: #888888 1px solid;
+            white-space: pre-wrap;
+            font-family: "Lucida Console", "Courier";
+        }
+    </style>
+  </head>
+  <body onload="x.main.init()">
+
+    <div class="x-header">
+      <h1 id="name"></h1> <h1 id="version"></h1> <h1>Explorer</h1>
+      <select id="partition" title="Partition"></select>
+    </div>
+    <div class="x-main">
+      <div class="x-panel">
+        <textarea id="query" class="x-query" spellcheck="false"
+          placeholder="get <kind> where ... (Ctrl+Enter runs the query - Ctrl+Space shows suggestions)"></textarea>
+        <ul id="suggest" class="x-suggest" style="display:none"></ul>
+        <button id="run" class="x-button">Run</button>
+        <button id="csv" class="x-button" disabled>Export CSV</button>
+        <button id="json" class="x-button" disabled>Export JSON</button>
+        <button id="showgraph" class="x-button" disabled>Show graph</button>
+        <div id="status" class="x-status"></div>
+        <div id="result" class="x-table-wrap"></div>
+      </div>
+      <div class="x-panel">
+        <button id="cleargraph" class="x-button">Clear graph</button>
+        <span id="graphstatus">Click a node to expand it and to show its attributes - nodes can be dragged.</span>
+        <svg id="graph" class="x-graph"><g id="edges"></g><g id="nodes"></g></svg>
+        <div id="details" class="x-details"></div>
+      </div>
+    </div>
+
+    <script>
+
+        // Utility functions
+        // =================
+
+        if (x === undefined) {
+          var x = {};
+        }
+
+        x.$ = function(id) { "use strict"; return document.getElementById(id); };
+        x.esc = function (str) { "use strict"; return String(str).replace(/&/g,'&amp;').replace(/</g,'&lt;').replace(/>/g,'&gt;' ); };
+        x.create = function(tag, attrs, ns) {
+            "use strict";
+            var element = ns === undefined ? document.createElement(tag) : document.createElementNS(ns, tag);
+            if (attrs !== undefined) {
+                Object.keys(attrs).forEach(function (v) {
+                    element.setAttribute(v, attrs[v]);
+                });
+            }
+            return element;
+        };
+        x.addEvent = function (element, eventName, func) {
+            "use strict";
+            element.addEventListener(eventName, func, false);
+        };
+        x.ajax = function (url, method, body, callbackOK, callbackError) {
+            "use strict";
+            var http = new XMLHttpRequest();
+
+            http.open(method, url, true);
+            http.setRequestHeader("content-type", "application/json");
+            http.setRequestHeader("accept", "application/json");
+            http.onload = function () {
+                if (http.status === 200) {
+                    if (callbackOK) {
+                        callbackOK(http.response !== "" ? JSON.parse(http.response) : undefined, http);
+                    }
+                } else if (callbackError) {
+                    callbackError(http.response);
+                } else {
+                    console.log(http.response);
+                }
+            };
+            http.onerror = function () {
+                if (callbackError) {
+                    callbackError("Request failed");
+                }
+            };
+
+            http.send(body !== undefined ? JSON.stringify(body) : undefined);
+        };
+        x.download = function (filename, href) {
+            "use strict";
+            var a = x.create("a", { href : href, download : filename });
+            document.body.appendChild(a);
+            a.click();
+            document.body.removeChild(a);
+        };
+
+        // Global variables
+        // ================
+
+        x.ajaxPrefix = "/db";
+        x.partition = "main";
+        x.rowLimit = 1000;          // Maximum number of rows which are shown
+        x.graphMaxNodes = 500;      // Maximum number of nodes in the graph view
+        x.graphResultNodes = 100;   // Maximum number of result nodes which are added to the graph view
+
+        // Schema information
+        // ==================
+
+        x.schema = {
+
+            keywords : ["get", "lookup", "from", "group", "with", "filtering", "ordering", "nulltraversal",
+                        "where", "traverse", "join", "on", "end", "primary", "show", "as", "format", "and", "or",
+                        "like", "glob", "in", "contains", "beginswith", "endswith", "containsnot", "not", "notin",
+                        "false", "true", "unique", "uniquecount", "null", "isnotnull", "ascending", "descending"],
+            functions : ["@count", "@objget", "@reach", "@avg", "@max", "@median", "@min", "@percentile",
+                         "@stddev", "@sum", "@bucket", "@concat", "@date", "@dateDiff", "@distance", "@formatDate",
+                         "@inLast", "@now", "@parseDate", "@lower", "@upper", "@trim", "@substr", "@length",
+                         "@abs", "@floor", "@ceil", "@round"],
+            partitions : [],
+            nodeKinds : [],
+            edgeKinds : [],
+            roles : [],
+            attrs : {},
+
+            // Load partitions, kinds and roles from the info endpoint.
+            //
+            load : function (callback) {
+                "use strict";
+                x.ajax(x.ajaxPrefix + "/v1/info/", "GET", undefined, function (r) {
+                    var roles = {};
+
+                    x.schema.partitions = r.partitions || [];
+                    x.schema.nodeKinds = r.node_kinds || [];
+                    x.schema.edgeKinds = r.edge_kinds || [];
+
+                    Object.keys(r.edge_roles || {}).forEach(function (k) {
+                        (r.edge_roles[k] || []).forEach(function (role) {
+                            roles[role] = true;
+                        });
+                    });
+
+                    x.schema.roles = Object.keys(roles);
+                    x.schema.attrs = {};
+
+                    callback();
+                }, x.main.showError);
+            },
+
+            // Get the attributes of a kind (the result is cached).
+            //
+            kindAttrs : function (kind, callback) {
+                "use strict";
+                if (x.schema.attrs[kind] !== undefined) {
+                    callback(x.schema.attrs[kind]);
+                    return;
+                }
+
+                x.ajax(x.ajaxPrefix + "/v1/info/kind/" + encodeURIComponent(kind), "GET", undefined, function (r) {
+                    x.schema.attrs[kind] = (r.node_attrs || []).concat(r.edge_attrs || []);
+                    callback(x.schema.attrs[kind]);
+                }, function () {
+                    x.schema.attrs[kind] = [];
+                    callback([]);
+                });
+            }
+        };
+
+        // Autocompletion
+        // ==============
+
+        x.suggest = {
+
+            items : [],
+            selected : 0,
+
+            // Return the word in front of the cursor.
+            //
+            currentWord : function () {
+                "use strict";
+                var q = x.$("query");
+                return q.value.substring(0, q.selectionStart).match(/[@\w]*$/)[0];
+            },
+
+            // Collect all suggestions for a word. Attributes are suggested for
+            // all kinds which are mentioned in the query.
+            //
+            candidates : function (word, callback) {
+                "use strict";
+                var q = x.$("query").value,
+                    kinds = x.schema.nodeKinds.concat(x.schema.edgeKinds).filter(function (k) {
+                        return new RegExp("\\b" + k + "\\b").test(q);
+                    }),
+                    attrs = [],
+                    pending = kinds.length,
+                    finish = function () {
+                        var ret = [], seen = {}, lword = word.toLowerCase(),
+                            add = function (list, type) {
+                                list.forEach(function (v) {
+                                    if (v !== word && !seen[v] && v.toLowerCase().indexOf(lword) === 0) {
+                                        seen[v] = true;
+                                        ret.push({ value : v, type : type });
+                                    }
+                                });
+                            };
+
+                        add(x.schema.nodeKinds, "node kind");
+                        add(x.schema.edgeKinds, "edge kind");
+                        add(attrs, "attribute");
+                        add(x.schema.roles, "role");
+                        add(x.schema.keywords, "keyword");
+                        add(x.schema.functions, "function");
+
+                        callback(ret.slice(0, 20));
+                    };
+
+                if (pending === 0) {
+                    finish();
+                    return;
+                }
+
+                kinds.forEach(function (k) {
+                    x.schema.kindAttrs(k, function (a) {
+                        attrs = attrs.concat(a);
+                        if (--pending === 0) {
+                            finish();
+                        }
+                    });
+                });
+            },
+
+            // Show the suggestions for the current word. Suggestions for an
+            // empty word are only shown if forced.
+            //
+            show : function (force) {
+                "use strict";
+                var word = x.suggest.currentWord();
+
+                if (word === "" && !force) {
+                    x.suggest.hide();
+                    return;
+                }
+
+                x.suggest.candidates(word, function (items) {
+                    var q = x.$("query"), list = x.$("suggest");
+
+                    if (word !== x.suggest.currentWord()) {
+                        return; // The input has changed in the meantime
+                    }
+
+                    x.suggest.items = items;
+                    x.suggest.selected = 0;
+
+                    if (items.length === 0) {
+                        x.suggest.hide();
+                        return;
+                    }
+
+                    list.innerHTML = "";
+
+                    items.forEach(function (item, i) {
+                        var li = x.create("li");
+                        li.innerHTML = x.esc(item.value) + "<span>" + item.type + "</span>";
+                        x.addEvent(li, "mousedown", function (e) {
+                            e.preventDefault();
+                            x.suggest.selected = i;
+                            x.suggest.apply();
+                        });
+                        list.appendChild(li);
+                    });
+
+                    list.style.left = q.offsetLeft + "px";
+                    list.style.top = (q.offsetTop + q.offsetHeight) + "px";
+                    list.style.display = "block";
+
+                    x.suggest.move(0);
+                });
+            },
+
+            // Hide the suggestions.
+            //
+            hide : function () {
+                "use strict";
+                x.suggest.items = [];
+                x.$("suggest").style.display = "none";
+            },
+
+            // Check if suggestions are shown.
+            //
+            visible : function () {
+                "use strict";
+                return x.suggest.items.length > 0;
+            },
+
+            // Move the selection.
+            //
+            move : function (delta) {
+                "use strict";
+                var lis = x.$("suggest").childNodes, n = x.suggest.items.length;
+
+                x.suggest.selected = (x.suggest.selected + delta + n) % n;
+
+                for (var i = 0; i < lis.length; i++) {
+                    lis[i].className = i === x.suggest.selected ? "x-selected" : "";
+                }
+
+                lis[x.suggest.selected].scrollIntoView({ block : "nearest" });
+            },
+
+            // Replace the current word with the selected suggestion.
+            //
+            apply : function () {
+                "use strict";
+                var q = x.$("query"),
+                    word = x.suggest.currentWord(),
+                    value = x.suggest.items[x.suggest.selected].value,
+                    pos = q.selectionStart - word.length;
+
+                q.value = q.value.substring(0, pos) + value + q.value.substring(q.selectionStart);
+                q.selectionStart = q.selectionEnd = pos + value.length;
+
+                x.suggest.hide();
+                q.focus();
+            }
+        };
+
+        // Graph view
+        // ==========
+
+        x.graph = {
+
+            svgNS : "http://www.w3.org/2000/svg",
+            colors : ["#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+                      "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"],
+            kindColors : {},
+            nodes : {},
+            edges : {},
+            alpha : 0,
+            running : false,
+            drag : undefined,
+
+            // Return the color of a node kind.
+            //
+            color : function (kind) {
+                "use strict";
+                if (x.graph.kindColors[kind] === undefined) {
+                    x.graph.kindColors[kind] = x.graph.colors[Object.keys(x.graph.kindColors).length % x.graph.colors.length];
+                }
+                return x.graph.kindColors[kind];
+            },
+
+            // Add a node to the graph. New nodes are placed next to an optional
+            // parent node. Returns the node or undefined if the graph is full.
+            //
+            addNode : function (kind, key, data, parent) {
+                "use strict";
+                var id = kind + ":" + key, node = x.graph.nodes[id], svg = x.$("graph"), label;
+
+                if (node !== undefined) {
+                    if (data !== undefined) {
+                        node.data = data;
+                    }
+                    return node;
+                }
+
+                if (Object.keys(x.graph.nodes).length >= x.graphMaxNodes) {
+                    x.$("graphstatus").innerHTML = "The graph view is limited to " + x.graphMaxNodes + " nodes";
+                    return undefined;
+                }
+
+                label = data !== undefined && data.name !== undefined && data.name !== null ? String(data.name) : key;
+
+                if (label.length > 20) {
+                    label = label.substring(0, 19) + "...";
+                }
+
+                node = {
+                    id : id,
+                    kind : kind,
+                    key : key,
+                    data : data,
+                    x : parent !== undefined ? parent.x + Math.random() * 60 - 30 : svg.clientWidth / 2 + Math.random() * 200 - 100,
+                    y : parent !== undefined ? parent.y + Math.random() * 60 - 30 : svg.clientHeight / 2 + Math.random() * 200 - 100,
+                    vx : 0,
+                    vy : 0,
+                    fixed : false,
+                    expanded : false,
+                    circle : x.create("circle", { r : 8, fill : x.graph.color(kind) }, x.graph.svgNS),
+                    text : x.create("text", { dx : 10, dy : 4 }, x.graph.svgNS)
+                };
+
+                node.text.textContent = label;
+                node.circle.appendChild(x.create("title", {}, x.graph.svgNS)).textContent = kind + ": " + key;
+
+                x.addEvent(node.circle, "mousedown", function (e) {
+                    e.preventDefault();
+                    x.graph.drag = { node : node, moved : false };
+                    node.fixed = true;
+                });
+
+                x.$("nodes").appendChild(node.circle);
+                x.$("nodes").appendChild(node.text);
+
+                x.graph.nodes[id] = node;
+
+                return node;
+            },
+
+            // Add an edge to the graph if both its ends are in the graph.
+            //
+            addEdge : function (data) {
+                "use strict";
+                var id = data.kind + ":" + data.key,
+                    source = x.graph.nodes[data.end1kind + ":" + data.end1key],
+                    target = x.graph.nodes[data.end2kind + ":" + data.end2key],
+                    edge;
+
+                if (x.graph.edges[id] !== undefined || source === undefined || target === undefined) {
+                    return;
+                }
+
+                edge = {
+                    source : source,
+                    target : target,
+                    line : x.create("line", {}, x.graph.svgNS),
+                    text : x.create("text", { "class" : "x-edge-label", "text-anchor" : "middle" }, x.graph.svgNS)
+                };
+
+                edge.text.textContent = data.kind;
+
+                x.$("edges").appendChild(edge.line);
+                x.$("edges").appendChild(edge.text);
+
+                x.graph.edges[id] = edge;
+            },
+
+            // Add the neighbours of a node to the graph. If onlyKnown is set
+            // then only edges to nodes which are already in the graph are added.
+            //
+            expand : function (node, onlyKnown, callback) {
+                "use strict";
+                x.ajax(x.ajaxPrefix + "/v1/graph/" + encodeURIComponent(x.partition) + "/n/" +
+                        encodeURIComponent(node.kind) + "/" + encodeURIComponent(node.key) + "/:::", "GET", undefined, function (r) {
+
+                    r[0].forEach(function (n, i) {
+                        if (!onlyKnown || x.graph.nodes[n.kind + ":" + n.key] !== undefined) {
+                            if (x.graph.addNode(n.kind, n.key, n, node) !== undefined) {
+                                x.graph.addEdge(r[1][i]);
+                            }
+                        }
+                    });
+
+                    if (!onlyKnown) {
+                        node.expanded = true;
+                        node.circle.setAttribute("class", "x-expanded");
+                    }
+
+                    x.graph.restart();
+
+                    if (callback) {
+                        callback();
+                    }
+                }, function (r) {
+                    x.$("graphstatus").innerHTML = x.esc(r);
+                });
+            },
+
+            // Show the attributes of a node and expand it.
+            //
+            select : function (node) {
+                "use strict";
+                var details = x.$("details");
+
+                x.ajax(x.ajaxPrefix + "/v1/graph/" + encodeURIComponent(x.partition) + "/n/" +
+                        encodeURIComponent(node.kind) + "/" + encodeURIComponent(node.key), "GET", undefined, function (r) {
+                    node.data = r;
+                    details.innerHTML = Object.keys(r).sort().map(function (k) {
+                        var v = r[k];
+                        return "<b>" + x.esc(k) + "</b>: " + x.esc(typeof v === "object" ? JSON.stringify(v) : v);
+                    }).join("\n");
+                    details.style.display = "block";
+                }, function (r) {
+                    details.innerHTML = x.esc(r);
+                    details.style.display = "block";
+                });
+
+                if (!node.expanded) {
+                    x.graph.expand(node, false);
+                }
+            },
+
+            // Remove all nodes and edges.
+            //
+            clear : function () {
+                "use strict";
+                x.graph.nodes = {};
+                x.graph.edges = {};
+                x.graph.kindColors = {};
+                x.$("nodes").innerHTML = "";
+                x.$("edges").innerHTML = "";
+                x.$("details").style.display = "none";
+            },
+
+            // Restart the layout.
+            //
+            restart : function () {
+                "use strict";
+                x.graph.alpha = 1;
+                if (!x.graph.running) {
+                    x.graph.running = true;
+                    window.requestAnimationFrame(x.graph.tick);
+                }
+            },
+
+            // Run one step of the force-directed layout. Nodes repel each
+            // other, edges pull their ends together and all nodes are pulled
+            // towards the center of the view.
+            //
+            tick : function () {
+                "use strict";
+                var nodes = Object.keys(x.graph.nodes).map(function (id) { return x.graph.nodes[id]; }),
+                    edges = Object.keys(x.graph.edges).map(function (id) { return x.graph.edges[id]; }),
+                    svg = x.$("graph"),
+                    cx = svg.clientWidth / 2,
+                    cy = svg.clientHeight / 2,
+                    alpha = x.graph.alpha,
+                    i, j, a, b, dx, dy, d2, d, f;
+
+                for (i = 0; i < nodes.length; i++) {
+                    a = nodes[i];
+                    for (j = i + 1; j < nodes.length; j++) {
+                        b = nodes[j];
+                        dx = a.x - b.x;
+                        dy = a.y - b.y;
+                        d2 = Math.max(dx * dx + dy * dy, 1);
+                        f = 1500 / d2 * alpha;
+                        a.vx += dx * f / Math.sqrt(d2);
+                        a.vy += dy * f / Math.sqrt(d2);
+                        b.vx -= dx * f / Math.sqrt(d2);
+                        b.vy -= dy * f / Math.sqrt(d2);
+                    }
+                }
+
+                edges.forEach(function (e) {
+                    dx = e.target.x - e.source.x;
+                    dy = e.target.y - e.source.y;
+                    d = Math.max(Math.sqrt(dx * dx + dy * dy), 1);
+                    f = (d - 80) * 0.05 * alpha / d;
+                    e.source.vx += dx * f;
+                    e.source.vy += dy * f;
+                    e.target.vx -= dx * f;
+                    e.target.vy -= dy * f;
+                });
+
+                nodes.forEach(function (n) {
+                    if (!n.fixed) {
+                        n.vx = (n.vx + (cx - n.x) * 0.01 * alpha) * 0.6;
+                        n.vy = (n.vy + (cy - n.y) * 0.01 * alpha) * 0.6;
+                        n.x += n.vx;
+                        n.y += n.vy;
+                    }
+                    n.circle.setAttribute("cx", n.x);
+                    n.circle.setAttribute("cy", n.y);
+                    n.text.setAttribute("x", n.x);
+                    n.text.setAttribute("y", n.y);
+                });
+
+                edges.forEach(function (e) {
+                    e.line.setAttribute("x1", e.source.x);
+                    e.line.setAttribute("y1", e.source.y);
+                    e.line.setAttribute("x2", e.target.x);
+                    e.line.setAttribute("y2", e.target.y);
+                    e.text.setAttribute("x", (e.source.x + e.target.x) / 2);
+                    e.text.setAttribute("y", (e.source.y + e.target.y) / 2);
+                });
+
+                x.graph.alpha *= 0.98;
+
+                if (x.graph.alpha > 0.01) {
+                    window.requestAnimationFrame(x.graph.tick);
+                } else {
+                    x.graph.running = false;
+                }
+            },
+
+            // Handle dragging of nodes - a node which is not moved is selected.
+            //
+            init : function () {
+                "use strict";
+                var svg = x.$("graph");
+
+                x.addEvent(svg, "mousemove", function (e) {
+                    var drag = x.graph.drag, rect = svg.getBoundingClientRect();
+
+                    if (drag !== undefined) {
+                        drag.moved = true;
+                        drag.node.x = e.clientX - rect.left;
+                        drag.node.y = e.clientY - rect.top;
+                        x.graph.restart();
+                    }
+                });
+
+                x.addEvent(window, "mouseup", function () {
+                    var drag = x.graph.drag;
+
+                    if (drag !== undefined) {
+                        x.graph.drag = undefined;
+                        drag.node.fixed = false;
+
+                        if (!drag.moved) {
+                            x.graph.select(drag.node);
+                        }
+                    }
+                });
+            }
+        };
+
+        // Explorer
+        // ========
+
+        x.main = {
+
+            result : undefined,   // Last query result
+            resultID : undefined, // ID of the last query result in the result cache
+
+            init : function () {
+                "use strict";
+
+                x.ajax(x.ajaxPrefix + "/about/", "GET", undefined, function (r) {
+                    x.$("name").innerHTML = x.esc(r.product);
+                    x.$("version").innerHTML = x.esc(r.version);
+                });
+
+                x.schema.load(function () {
+                    var select = x.$("partition");
+
+                    if (x.schema.partitions.indexOf(x.partition) === -1 && x.schema.partitions.length > 0) {
+                        x.partition = x.schema.partitions[0];
+                    }
+
+                    x.schema.partitions.forEach(function (p) {
+                        var option = x.create("option", { value : p });
+                        option.textContent = p;
+                        option.selected = p === x.partition;
+                        select.appendChild(option);
+                    });
+                });
+
+                x.addEvent(x.$("partition"), "change", function () {
+                    x.partition = x.$("partition").value;
+                    x.graph.clear();
+                });
+
+                x.addEvent(x.$("query"), "keydown", function (e) {
+                    if (x.suggest.visible()) {
+                        if (e.keyCode === 40 || e.keyCode === 38) {         // Down / Up
+                            x.suggest.move(e.keyCode === 40 ? 1 : -1);
+                            e.preventDefault();
+                            return;
+                        } else if ((e.keyCode === 9 || e.keyCode === 13) && !e.ctrlKey) { // Tab / Enter
+                            x.suggest.apply();
+                            e.preventDefault();
+                            return;
+                        } else if (e.keyCode === 27) {                      // Escape
+                            x.suggest.hide();
+                            e.preventDefault();
+                            return;
+                        }
+                    }
+
+                    if (e.ctrlKey && e.keyCode === 13) {
+                        x.suggest.hide();
+                        x.main.run();
+                        e.preventDefault();
+                    } else if (e.ctrlKey && e.keyCode === 32) {
+                        x.suggest.show(true);
+                        e.preventDefault();
+                    }
+                });
+
+                x.addEvent(x.$("query"), "keyup", function (e) {
+                    if ([9, 13, 16, 17, 27, 38, 40].indexOf(e.keyCode) === -1 && !e.ctrlKey) {
+                        x.suggest.show(false);
+                    }
+                });
+
+                x.addEvent(x.$("query"), "blur", x.suggest.hide);
+                x.addEvent(x.$("run"), "click", x.main.run);
+                x.addEvent(x.$("csv"), "click", x.main.exportCSV);
+                x.addEvent(x.$("json"), "click", x.main.exportJSON);
+                x.addEvent(x.$("showgraph"), "click", x.main.showGraph);
+                x.addEvent(x.$("cleargraph"), "click", x.graph.clear);
+
+                x.graph.init();
+            },
+
+            // Run the query of the console.
+            //
+            run : function () {
+                "use strict";
+                var query = x.$("query").value.trim(), start = Date.now();
+
+                if (query === "") {
+                    return;
+                }
+
+                x.$("status").innerHTML = "Running ...";
+
+                x.ajax(x.ajaxPrefix + "/v1/query/" + encodeURIComponent(x.partition) + "?limit=" + x.rowLimit +
+                        "&q=" + encodeURIComponent(query), "GET", undefined, function (r, http) {
+
+                    x.main.result = r;
+                    x.main.resultID = http.getResponseHeader("X-Cache-Id") || undefined;
+
+                    x.main.showTable(r);
+
+                    x.$("status").innerHTML = "Showing " + r.rows.length + " of " +
+                        (http.getResponseHeader("X-Total-Count") || "more than " + r.rows.length) +
+                        " rows (" + (Date.now() - start) + "ms)";
+
+                    ["csv", "json", "showgraph"].forEach(function (id) {
+                        x.$(id).disabled = false;
+                    });
+
+                }, x.main.showError);
+            },
+
+            // Show an error.
+            //
+            showError : function (msg) {
+                "use strict";
+                x.$("status").innerHTML = "<div class='x-error'>" + x.esc(msg) + "</div>";
+            },
+
+            // Show a query result as table. Clicking a row adds its nodes to
+            // the graph view.
+            //
+            showTable : function (r) {
+                "use strict";
+                var table = x.create("table", { "class" : "x-table" }),
+                    tr = x.create("tr");
+
+                r.header.labels.forEach(function (l) {
+                    var th = x.create("th");
+                    th.textContent = l;
+                    tr.appendChild(th);
+                });
+
+                table.appendChild(tr);
+
+                r.rows.forEach(function (row, i) {
+                    tr = x.create("tr", { "class" : "x-node-row", title : "Add to graph" });
+
+                    row.forEach(function (c) {
+                        var td = x.create("td");
+                        td.textContent = c !== null && typeof c === "object" ? JSON.stringify(c) : c;
+                        tr.appendChild(td);
+                    });
+
+                    x.addEvent(tr, "click", function () {
+                        x.main.addSources([r.sources[i]]);
+                    });
+
+                    table.appendChild(tr);
+                });
+
+                x.$("result").innerHTML = "";
+                x.$("result").appendChild(table);
+            },
+
+            // Add the nodes of result rows to the graph view and connect them
+            // with the nodes which are already shown.
+            //
+            addSources : function (sources) {
+                "use strict";
+                var added = [];
+
+                sources.forEach(function (row) {
+                    row.forEach(function (src) {
+                        var parts = src.split(":"), node;
+
+                        if (parts[0] === "n" && parts.length >= 3 && added.length < x.graphResultNodes) {
+                            node = x.graph.addNode(parts[1], parts.slice(2).join(":"));
+                            if (node !== undefined && added.indexOf(node) === -1) {
+                                added.push(node);
+                            }
+                        }
+                    });
+                });
+
+                added.forEach(function (node) {
+                    x.graph.expand(node, true);
+                });
+
+                x.graph.restart();
+            },
+
+            // Show the nodes of the result in the graph view.
+            //
+            showGraph : function () {
+                "use strict";
+                x.main.addSources(x.main.result.sources);
+                x.$("graph").scrollIntoView();
+            },
+
+            // Export the result as CSV. The complete result is exported if it
+            // is held in the result cache.
+            //
+            exportCSV : function () {
+                "use strict";
+                var r = x.main.result, quote = function (v) {
+                    v = v !== null && typeof v === "object" ? JSON.stringify(v) : String(v);
+                    return /[",\n]/.test(v) ? '"' + v.replace(/"/g, '""') + '"' : v;
+                };
+
+                if (x.main.resultID !== undefined) {
+                    x.download("result.csv", x.ajaxPrefix + "/v1/queryresult/" + x.main.resultID + "/csv");
+                    return;
+                }
+
+                x.download("result.csv", URL.createObjectURL(new Blob([[r.header.labels].concat(r.rows).map(function (row) {
+                    return row.map(quote).join(",");
+                }).join("\n")], { type : "text/csv" })));
+            },
+
+            // Export the shown rows as JSON.
+            //
+            exportJSON : function () {
+                "use strict";
+                var r = x.main.result;
+
+                x.download("result.json", URL.createObjectURL(new Blob([JSON.stringify({
+                    header : r.header,
+                    rows : r.rows,
+                    sources : r.sources
+                }, null, 2)], { type : "application/json" })));
+            }
+        };
+    </script>
+  </body>
+</html>
+`
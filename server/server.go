@@ -15,13 +15,17 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/krotik/common/cryptutil"
@@ -37,12 +41,25 @@ import (
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/api/ac"
 	v1 "github.com/krotik/eliasdb/api/v1"
+	"github.com/krotik/eliasdb/cdc"
 	"github.com/krotik/eliasdb/cluster"
 	"github.com/krotik/eliasdb/cluster/manager"
+	"github.com/krotik/eliasdb/codec"
 	"github.com/krotik/eliasdb/config"
+	"github.com/krotik/eliasdb/databases"
 	"github.com/krotik/eliasdb/ecal"
+	"github.com/krotik/eliasdb/eql"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/quota"
+	"github.com/krotik/eliasdb/replication"
+	"github.com/krotik/eliasdb/rules"
+	"github.com/krotik/eliasdb/shard"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/s3"
+	"github.com/krotik/eliasdb/tracing"
+	"github.com/krotik/eliasdb/webhooks"
 )
 
 /*
@@ -95,7 +112,48 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 
 		print("Starting memory only datastore")
 
-		gs = graphstorage.NewMemoryGraphStorage(config.MemoryOnlyStorage)
+		if snapshotFile := config.Str(config.SnapshotFile); snapshotFile != "" {
+			snapshotFile = filepath.Join(basepath, snapshotFile)
+
+			print("Loading snapshot from ", snapshotFile)
+
+			msgs, err := graphstorage.NewMemorySnapshotGraphStorage(config.MemoryOnlyStorage, snapshotFile)
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			if compression := config.Str(config.SnapshotCompression); compression != codec.None {
+				print("Compressing snapshots with ", compression)
+
+				if err := msgs.SetSnapshotCompression(compression); err != nil {
+					fatal(err)
+					return
+				}
+			}
+
+			// Periodically write the datastore to the snapshot file
+
+			if interval := config.Int(config.SnapshotIntervalSeconds); interval > 0 {
+				print("Writing snapshots every ", interval, " seconds")
+
+				go func() {
+					for {
+						time.Sleep(time.Duration(interval) * time.Second)
+
+						if err := msgs.Snapshot(); err != nil {
+							print("Snapshot failed: ", err)
+						}
+					}
+				}()
+			}
+
+			gs = msgs
+
+		} else {
+
+			gs = graphstorage.NewMemoryGraphStorage(config.MemoryOnlyStorage)
+		}
 
 		if config.Bool(config.EnableReadOnly) {
 			print("Ignoring EnableReadOnly setting")
@@ -116,11 +174,123 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 
 		ensurePath(loc)
 
-		gs, err = graphstorage.NewDiskGraphStorage(loc, readonly)
+		// Limit the memory which is used to keep records of the datastore files
+
+		file.DefaultPageCache.SetMaxSize(config.Int(config.PageCacheSize))
+
+		// Select the backend which stores the datastore files
+
+		var backend file.StorageBackend = &file.OSBackend{}
+
+		if name := config.Str(config.StorageBackend); name == "s3" {
+
+			print("Reading S3 config")
+
+			sconfig, err := fileutil.LoadConfig(filepath.Join(basepath, config.Str(config.S3ConfigFile)),
+				s3.DefaultConfig)
+
+			if err == nil {
+				backend, err = s3.NewBackend(sconfig)
+			}
+
+			if err != nil {
+				fatal("Failed to setup S3 storage backend:", err)
+				return
+			}
+
+		} else if name == "mmap" {
+
+			print("Reading datastore files through memory mapping")
+
+			backend = &file.MmapBackend{}
+
+		} else if name != "local" {
+			fatal("Unknown storage backend:", name)
+			return
+		}
+
+		// Key-value engines write their files directly to the local disk
+
+		engine := config.Str(config.StorageEngine)
+
+		if engine != graphstorage.EnginePages && config.Str(config.StorageBackend) != "local" {
+			fatal(fmt.Sprintf("The %v storage engine can only be used with the local storage backend", engine))
+			return
+		}
+
+		gs, err = graphstorage.NewDiskGraphStorageWithBackend(loc, readonly, engine, backend)
 		if err != nil {
 			fatal(err)
 			return
 		}
+
+		// Encrypt stored records if there is an encryption key file
+
+		if keyfile := config.Str(config.EncryptionKeyFile); keyfile != "" {
+			print("Enabling encryption at rest")
+
+			kr, err := loadEncryption(filepath.Join(basepath, keyfile))
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			kr.apply(gs.(*graphstorage.DiskGraphStorage))
+
+			if !readonly {
+				api.EncryptionKeys = kr
+			}
+		}
+
+		if latency := config.Int(config.GroupCommitLatencyMillis); latency > 0 && !readonly {
+			print(fmt.Sprintf("Enabling group commit (max latency: %vms)", latency))
+
+			gs.(*graphstorage.DiskGraphStorage).SetGroupCommit(time.Duration(latency) * time.Millisecond)
+		}
+
+		if mode := config.Str(config.DurabilityMode); mode != graphstorage.DurabilitySync && !readonly {
+			interval := time.Duration(config.Int(config.DurabilitySyncSeconds)) * time.Second
+
+			print(fmt.Sprintf("Setting durability mode: %v", mode))
+
+			if err = gs.(*graphstorage.DiskGraphStorage).SetDurability(mode, interval); err != nil {
+				fatal(err)
+				return
+			}
+		}
+
+		if algorithm := config.Str(config.CompressionAlgorithm); algorithm != storage.CompressionNone && !readonly {
+			partitions := strings.Split(config.Str(config.CompressionPartitions), ",")
+
+			print(fmt.Sprintf("Compressing records with %v (partitions: %v)", algorithm,
+				config.Str(config.CompressionPartitions)))
+
+			for _, part := range partitions {
+				if err = gs.(*graphstorage.DiskGraphStorage).SetCompression(strings.TrimSpace(part), algorithm); err != nil {
+					fatal(err)
+					return
+				}
+			}
+		}
+
+		// Periodically reclaim the space of deleted and updated data
+
+		if interval := config.Int(config.CompactionIntervalSeconds); interval > 0 && !readonly {
+			batchSize := int(config.Int(config.CompactionBatchSize))
+			pause := time.Duration(config.Int(config.CompactionPauseMillis)) * time.Millisecond
+
+			print("Compacting storage files every ", interval, " seconds")
+
+			go func(dgs *graphstorage.DiskGraphStorage) {
+				for {
+					time.Sleep(time.Duration(interval) * time.Second)
+
+					if err := dgs.Compact(batchSize, pause); err != nil {
+						print("Compaction failed: ", err)
+					}
+				}
+			}(gs.(*graphstorage.DiskGraphStorage))
+		}
 	}
 
 	// Check if clustering is enabled
@@ -178,12 +348,66 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		ds.MemberManager.Start()
 	}
 
+	// Setup tracing
+
+	gmStorage := gs
+
+	if config.Bool(config.EnableTracing) {
+
+		print("Enabling tracing (", config.Str(config.TracingSink), ")")
+
+		sink, err := api.NewLogSink(config.Str(config.TracingSink),
+			filepath.Join(basepath, config.Str(config.TracingFile)))
+
+		if err != nil {
+			fatal("Failed to create tracing sink:", err)
+			return
+		}
+
+		tracing.SetExporter(tracing.NewJSONExporter(sink))
+
+		if config.Bool(config.EnableStorageTracing) {
+			gmStorage = tracing.NewGraphStorage(gs)
+		}
+	}
+
 	// Create GraphManager
 
 	print("Creating GraphManager instance")
 
 	api.GS = gs
-	api.GM = graph.NewGraphManager(gs)
+	api.GM = graph.NewGraphManager(gmStorage)
+
+	// Limit the size of single transactions
+
+	api.GM.SetTransLimits(int(config.Int(config.TransactionMaxOperations)),
+		config.Int(config.TransactionMaxBytes))
+
+	// Generate keys for nodes and edges which are stored without a key
+
+	if err := api.GM.SetKeyGeneration(config.Str(config.KeyGeneration)); err != nil {
+		fatal(err)
+		return
+	}
+
+	// Enforce quotas on the partitions of the datastore
+
+	quota.RecountInterval = time.Duration(config.Int(config.QuotaRecountSeconds)) * time.Second
+
+	if config.Bool(config.EnableQuotas) {
+
+		print("Enabling quotas")
+
+		qm, err := quota.NewManager(api.GM, api.SystemPartition)
+		if err != nil {
+			fatal("Failed to load quotas:", err)
+			return
+		}
+
+		v1.Quotas = qm
+
+		defer qm.Close()
+	}
 
 	defer func() {
 
@@ -197,6 +421,16 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		os.RemoveAll(filepath.Join(basepath, config.Str(config.LockFile)))
 	}()
 
+	// Record changes so replicas can follow this instance
+
+	if config.Bool(config.EnableChangeLog) {
+
+		print("Enabling change log (", config.Int(config.ChangeLogSize), " changes)")
+
+		v1.ChangeLog = replication.NewChangeLog(int(config.Int(config.ChangeLogSize)))
+		api.GM.SetGraphRule(v1.ChangeLog)
+	}
+
 	// Create ScriptingInterpreter instance and run ECAL scripts
 
 	if config.Bool(config.EnableECALScripts) {
@@ -215,6 +449,49 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		}
 	}
 
+	// Run user defined rules on writes of nodes and edges
+
+	ruleEngine, err := rules.NewEngine(api.GM, api.SystemPartition)
+	if err != nil {
+		fatal("Failed to load rules:", err)
+		return
+	}
+
+	// Rules do not run on replicas - their results are replicated
+
+	ruleEngine.Active = func() bool {
+		return !api.ReadOnly
+	}
+
+	if api.SI != nil {
+		ruleEngine.ScriptHandler = api.SI.HandleRuleScript
+	}
+
+	v1.Rules = ruleEngine
+	api.GM.SetGraphRule(ruleEngine)
+
+	defer ruleEngine.Close()
+
+	// Deployed scripts which run on requests or graph events
+
+	if config.Bool(config.EnableScripts) {
+
+		print("Enabling scripts")
+
+		scripts, err := ecal.NewScriptManager(api.GM, api.SystemPartition)
+		if err != nil {
+			fatal("Failed to load scripts:", err)
+			return
+		}
+
+		scripts.Active = func() bool {
+			return !api.ReadOnly
+		}
+
+		v1.Scripts = scripts
+		api.GM.SetGraphRule(scripts)
+	}
+
 	// Handle single operation - these are operations which work on the GraphManager
 	// and then exit.
 
@@ -222,6 +499,257 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		return
 	}
 
+	// Setup obfuscation of node keys
+
+	if secret := config.Str(config.KeyObfuscationSecret); secret != "" {
+
+		print("Enabling key obfuscation")
+
+		ko, err := api.NewTokenKeyObfuscator(secret)
+		if err != nil {
+			fatal("Failed to create key obfuscator:", err)
+			return
+		}
+
+		api.KeyObfuscation = ko
+	}
+
+	// Webhooks and change data capture need a change log even if the change feed is disabled
+
+	cl := v1.ChangeLog
+
+	if cl == nil && (config.Bool(config.EnableWebhooks) || config.Bool(config.EnableCDC)) {
+		cl = replication.NewChangeLog(int(config.Int(config.ChangeLogSize)))
+		api.GM.SetGraphRule(cl)
+	}
+
+	// Deliver changes to webhooks
+
+	if config.Bool(config.EnableWebhooks) {
+
+		print("Enabling webhooks")
+
+		webhooks.MaxRetries = int(config.Int(config.WebhookMaxRetries))
+		webhooks.DeliveryTimeout = time.Duration(config.Int(config.WebhookTimeoutSeconds)) * time.Second
+
+		wm, err := webhooks.NewManager(api.GM, api.SystemPartition, cl, v1.ExternalChanges)
+		if err != nil {
+			fatal("Failed to load webhooks:", err)
+			return
+		}
+
+		v1.Webhooks = wm
+
+		defer wm.Close()
+	}
+
+	// Publish changes to Kafka or NATS
+
+	if config.Bool(config.EnableCDC) {
+
+		print("Enabling change data capture")
+
+		configs, err := cdc.LoadConfig(config.Str(config.CDCConfigFile))
+		if err != nil {
+			fatal("Failed to load change data capture configuration:", err)
+			return
+		}
+
+		for _, sc := range configs {
+
+			pub, err := sc.NewPublisher()
+			if err == nil {
+				var sink *cdc.Sink
+
+				if sink, err = cdc.NewSink(api.GM, api.SystemPartition, cl, sc, pub, v1.ExternalChanges); err == nil {
+					print(fmt.Sprintf("Publishing changes to %v sink %v", sc.Type, sc.Name))
+
+					v1.CDCSinks = append(v1.CDCSinks, sink)
+
+					defer sink.Close()
+				}
+			}
+
+			if err != nil {
+				fatal("Failed to start change data capture sink:", err)
+				return
+			}
+		}
+	}
+
+	// Follow a primary instance if this instance is a replica
+
+	if primary := config.Str(config.ReplicaOf); primary != "" {
+
+		print("Starting replication of ", primary)
+
+		client := &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: config.Bool(config.ReplicaSkipTLSVerify),
+				},
+			},
+		}
+
+		api.ReadOnly = true
+		v1.Replica = replication.NewReplica(primary, api.GM, client)
+
+		// Keys which are sent by the primary are obfuscated
+
+		if api.KeyObfuscation != nil {
+			v1.Replica.SetTranslation(v1.InternalReplicationData)
+		}
+
+		v1.Replica.Start(time.Duration(config.Int(config.ReplicaPollIntervalSeconds)) * time.Second)
+
+		defer v1.Replica.Stop()
+	}
+
+	advertised := config.Str(config.AdvertisedURL)
+	if advertised == "" {
+		advertised = fmt.Sprintf("https://%v:%v", config.Str(config.HTTPSHost), config.Str(config.HTTPSPort))
+	}
+
+	// Replicate the datastore to the members of a Raft cluster
+
+	if config.Bool(config.EnableRaft) {
+
+		if config.Bool(config.EnableCluster) || v1.Replica != nil || config.Bool(config.EnableReadOnly) {
+			fatal("Raft replication cannot be used with clustering, as a replica or with a read-only datastore")
+			return
+		}
+
+		print("Starting Raft replication on ", config.Str(config.RaftBindAddress))
+
+		// The log is kept in memory if the datastore is kept in memory
+
+		var dir string
+
+		if !config.Bool(config.MemoryOnlyStorage) {
+			dir = filepath.Join(basepath, config.Str(config.LocationRaft))
+		}
+
+		// Changes are only accepted by the leader
+
+		api.ReadOnly = true
+
+		v1.Raft, err = replication.NewRaftNode(&replication.RaftConfig{
+			ID:        advertised,
+			BindAddr:  config.Str(config.RaftBindAddress),
+			Advertise: config.Str(config.RaftAdvertisedAddress),
+			Dir:       dir,
+			Bootstrap: config.Bool(config.RaftBootstrap),
+			LeaderHandler: func(leader bool) {
+				if api.ReadOnly = !leader; leader {
+					print("This instance is now the Raft leader")
+				}
+			},
+		}, api.GM)
+
+		if err != nil {
+			fatal("Failed to start Raft replication:", err)
+			return
+		}
+
+		api.GM.SetGraphRule(v1.Raft)
+
+		defer v1.Raft.Shutdown()
+
+		if member := config.Str(config.RaftJoin); member != "" {
+
+			print("Joining Raft cluster of ", member)
+
+			client := &http.Client{
+				Timeout: 30 * time.Second,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						InsecureSkipVerify: config.Bool(config.ReplicaSkipTLSVerify),
+					},
+				},
+			}
+
+			addr := config.Str(config.RaftAdvertisedAddress)
+			if addr == "" {
+				addr = config.Str(config.RaftBindAddress)
+			}
+
+			if err = replication.JoinRaft(client, member, advertised, addr); err != nil {
+				fatal(err)
+				return
+			}
+		}
+	}
+
+	// Distribute the graph over several shards
+
+	if config.Bool(config.EnableSharding) {
+
+		print("Loading shard map from ", config.Str(config.ShardConfigFile))
+
+		sm, err := shard.LoadShardMap(filepath.Join(basepath, config.Str(config.ShardConfigFile)))
+
+		if err != nil {
+			fatal("Failed to start sharding:", err)
+			return
+		}
+
+		v1.ShardRouter = shard.NewRouter(sm, &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: config.Bool(config.ShardSkipTLSVerify),
+				},
+			},
+		})
+
+		if name := config.Str(config.ShardName); name != "" {
+			ls, err := v1.ShardRouter.Local(name)
+
+			if err != nil {
+				fatal("Failed to start sharding:", err)
+				return
+			}
+
+			print("This instance is shard ", name)
+
+			api.GM.SetRemoteNodes(ls)
+		}
+	}
+
+	// Rebuild indexes which were created by an older version (e.g. to add
+	// word dictionaries) in the background
+
+	if !api.ReadOnly && !config.Bool(config.EnableReadOnly) && api.GM.IndexUpgradePending() {
+		print("Upgrading indexes which were created by an older version")
+
+		if _, err := v1.StartJob("upgradeindex", nil); err != nil {
+			print("Could not start index upgrade: ", err)
+		}
+	}
+
+	// Track the replication topology so clients can find the primary
+
+	v1.PromotedChangeLogSize = int(config.Int(config.ChangeLogSize))
+	v1.Topology = replication.NewTopology(advertised, config.Str(config.ReplicaOf),
+		time.Duration(config.Int(config.ReplicaTimeoutSeconds))*time.Second)
+	v1.Topology.Start()
+
+	defer v1.Topology.Stop()
+
+	if v1.Replica != nil {
+		v1.Replica.SetURL(advertised)
+
+	} else {
+
+		// Scheduled queries only run on the primary
+
+		scheduler := v1.NewScheduler()
+		scheduler.Start()
+
+		defer scheduler.Stop()
+	}
+
 	// Setting other API parameters
 
 	// Setup cookie expiry
@@ -234,6 +762,101 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 	api.APIHost = config.Str(config.HTTPSHost) + ":" + config.Str(config.HTTPSPort)
 	v1.ResultCacheMaxSize = uint64(config.Int(config.ResultCacheMaxSize))
 	v1.ResultCacheMaxAge = config.Int(config.ResultCacheMaxAgeSeconds)
+	eql.SetPlanCacheMaxSize(int(config.Int(config.EQLPlanCacheMaxSize)))
+	api.ReadyMaxPendingTransfers = int(config.Int(config.ReadyMaxPendingTransfers))
+	v1.HistoryMaxEntries = int(config.Int(config.UserHistoryMaxEntries))
+
+	if dir := config.Str(config.ScheduleResultDir); dir != "" {
+		v1.ScheduleResultDir = filepath.Join(basepath, dir)
+	}
+
+	v1.ScheduleSMTPServer = config.Str(config.ScheduleSMTPServer)
+	v1.ScheduleSMTPFrom = config.Str(config.ScheduleSMTPFrom)
+	v1.ScheduleSMTPUsername = config.Str(config.ScheduleSMTPUsername)
+	v1.ScheduleSMTPPassword = config.Str(config.ScheduleSMTPPassword)
+
+	// Setup structured request logging
+
+	if config.Bool(config.EnableRequestLog) {
+
+		print("Enabling request log (", config.Str(config.RequestLogSink), ")")
+
+		sink, err := api.NewLogSink(config.Str(config.RequestLogSink),
+			filepath.Join(basepath, config.Str(config.RequestLogFile)))
+
+		if err == nil {
+			api.RequestLog, err = api.NewRequestLogger(sink, config.Str(config.RequestLogLevel))
+		}
+
+		if err != nil {
+			fatal("Failed to create request log:", err)
+			return
+		}
+	}
+
+	// Setup compression of REST responses
+
+	if compression := config.Str(config.HTTPCompression); compression != codec.None {
+
+		print("Compressing REST responses with ", compression)
+
+		if api.ResponseCodecs, err = codec.GetList(compression); err != nil {
+			fatal("Failed to setup response compression:", err)
+			return
+		}
+	}
+
+	// Setup embeddable query widgets
+
+	if secret := config.Str(config.WidgetSecret); secret != "" {
+
+		print("Enabling query widgets")
+
+		v1.WidgetSecret = secret
+		v1.WidgetAllowedOrigins = splitList(config.Str(config.WidgetAllowedOrigins))
+	}
+
+	// Setup the guards for EQL traversals and the workers of EQL queries
+
+	v1.QueryOptions = &eql.QueryOptions{
+		TraversalMaxVisitedNodes: int(config.Int(config.TraversalMaxVisitedNodes)),
+		TraversalCycleDetection:  config.Bool(config.TraversalCycleDetection),
+		Workers:                  int(config.Int(config.EQLWorkerCount)),
+		RemoteCallBudget:         int(config.Int(config.ShardRemoteCallBudget)),
+	}
+
+	// Setup the anonymous read-only sandbox
+
+	if parts := config.Str(config.SandboxPartitions); parts != "" {
+
+		print("Enabling sandbox for partitions: ", parts)
+
+		v1.SandboxPartitions = splitList(parts)
+		v1.SandboxMaxRows = int(config.Int(config.SandboxMaxRows))
+		v1.SandboxRateLimit = int(config.Int(config.SandboxRateLimit))
+		v1.SandboxQueryTimeout = time.Duration(config.Int(config.SandboxQueryTimeoutSeconds)) * time.Second
+	}
+
+	// Allow changes of the configuration at runtime
+
+	registerDynamicOptions()
+
+	// Setup the projection policy for responses
+
+	if config.Bool(config.EnableProjectionPolicy) {
+
+		print("Loading projection policy from ", config.Str(config.LocationProjectionPolicy))
+
+		pp, err := api.LoadProjectionPolicy(filepath.Join(basepath,
+			config.Str(config.LocationProjectionPolicy)))
+
+		if err != nil {
+			fatal("Failed to load projection policy:", err)
+			return
+		}
+
+		api.ResponseProjection = pp
+	}
 
 	// Check if HTTPS key and certificate are in place
 
@@ -314,11 +937,42 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 			// Setup the AuthHandler object which provides cookie based authentication
 			// for endpoints which are registered with its HandleFunc
 
-			ac.AuthHandler = auth.NewCookieAuthHandleFuncWrapper(http.HandleFunc)
+			ac.AuthHandler = auth.NewCookieAuthHandleFuncWrapper(ac.TokenHandleFunc(http.HandleFunc))
+
+			// Setup the access token database - tokens give narrowly scoped access
+			// to third parties
+
+			if ac.Tokens, err = ac.NewTokenDB(filepath.Join(basepath, config.Str(config.LocationTokenDB))); err != nil {
+				fatal("Failed to load access tokens:", err)
+				return
+			}
+
+			// Select the backend which verifies user passwords
+
+			if backend := config.Str(config.AuthBackend); backend == "ldap" {
+
+				print("Reading LDAP config")
+
+				lconfig, err := fileutil.LoadConfig(filepath.Join(basepath, config.Str(config.LDAPConfigFile)),
+					ac.LDAPDefaultConfig)
+
+				if err == nil {
+					ac.Backend, err = ac.NewLDAPAuthBackend(lconfig)
+				}
+
+				if err != nil {
+					fatal("Failed to setup LDAP authentication:", err)
+					return
+				}
+
+			} else if backend != "local" {
+				fatal("Unknown authentication backend:", backend)
+				return
+			}
 
-			// Connect the UserDB object to the AuthHandler - this provides authentication for users
+			// Connect the authentication backend to the AuthHandler - this provides authentication for users
 
-			ac.AuthHandler.SetAuthFunc(ac.UserDB.CheckUserPassword)
+			ac.AuthHandler.SetAuthFunc(ac.CheckUserPassword)
 
 			// Connect the ACL object to the AuthHandler - this provides authorization for users
 
@@ -344,9 +998,14 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 			ac.AuthHandler.CallbackSessionExpired = ac.CallbackSessionExpired
 			ac.AuthHandler.CallbackUnauthorized = ac.CallbackUnauthorized
 
+			// Projection policy rules and user specific data use the request user
+
+			api.RequestGroups = ac.RequestGroups
+			api.RequestUser = ac.RequestUser
+
 			// Finally set the HandleFunc of the AuthHandler as the HandleFunc of the API
 
-			api.HandleFunc = ac.AuthHandler.HandleFunc
+			api.HandleFunc = ac.HandleFunc
 
 			// After the api.HandleFunc has been set we can now register the management
 			// endpoints which should be subject to access control
@@ -355,11 +1014,103 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		}
 	}
 
+	// Apply the bootstrap manifest - graph data is not changed on read-only
+	// instances and replicas
+
+	if manifestFile := config.Str(config.BootstrapManifest); manifestFile != "" {
+
+		print("Applying bootstrap manifest ", manifestFile)
+
+		manifest, err := LoadManifest(filepath.Join(basepath, manifestFile))
+
+		if err == nil {
+			gm := api.GM
+
+			if api.ReadOnly || config.Bool(config.EnableReadOnly) {
+				gm = nil
+			}
+
+			err = manifest.Apply(gm)
+		}
+
+		if err != nil {
+			fatal("Failed to apply bootstrap manifest:", err)
+			return
+		}
+	}
+
+	// Periodically distribute the cache between partitions according to
+	// their access frequency
+
+	if interval := config.Int(config.CacheResizeIntervalSeconds); interval > 0 {
+
+		print("Rebalancing caches every ", interval, " seconds")
+
+		go func(gm *graph.Manager) {
+			for {
+				time.Sleep(time.Duration(interval) * time.Second)
+				gm.RebalanceCaches()
+			}
+		}(api.GM)
+	}
+
 	// Register EliasDB API endpoints - depending on if access control has been enabled
 	// these will require authentication and authorization for a given user
 
 	api.RegisterRestEndpoints(v1.V1EndpointMap)
 
+	// Serve additional isolated databases under /db/<name>/api/
+
+	if config.Bool(config.EnableDatabases) {
+		loc := filepath.Join(basepath, config.Str(config.LocationDatabases))
+		memoryOnly := config.Bool(config.MemoryOnlyStorage)
+
+		if memoryOnly {
+			print("Enabling memory only databases")
+		} else {
+			print("Enabling databases in ", loc)
+
+			ensurePath(loc)
+		}
+
+		open := func(name string) (graphstorage.Storage, error) {
+			if memoryOnly {
+				return graphstorage.NewMemoryGraphStorage(name), nil
+			}
+			return graphstorage.NewDiskGraphStorageWithEngine(filepath.Join(loc, name), false,
+				config.Str(config.StorageEngine))
+		}
+
+		remove := func(name string) error {
+			if memoryOnly {
+				return nil
+			}
+			return os.RemoveAll(filepath.Join(loc, name))
+		}
+
+		dm, err := databases.NewManager(api.GM, api.SystemPartition, open, remove)
+		if err != nil {
+			fatal("Failed to open databases:", err)
+			return
+		}
+
+		v1.Databases = dm
+		api.OpenDatabase = v1.OpenDatabase
+		api.RegisterDatabaseEndpoints(v1.V1DatabaseEndpointMap)
+
+		for _, db := range dm.Databases() {
+			print("Serving database ", db.Name)
+
+			api.RegisterDatabase(db.Name)
+		}
+
+		defer func() {
+			if err := dm.Close(); err != nil {
+				print(err)
+			}
+		}()
+	}
+
 	// Register normal web server
 
 	if config.Bool(config.EnableWebFolder) {
@@ -401,6 +1152,19 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 			}
 		}
 
+		if config.Bool(config.EnableWebExplorer) {
+
+			ensurePath(filepath.Join(webFolder, api.APIRoot))
+
+			explorerFile := filepath.Join(webFolder, api.APIRoot, "explorer.html")
+
+			print("Ensuring web explorer: ", explorerFile)
+
+			if res, _ := fileutil.PathExists(explorerFile); !res {
+				errorutil.AssertOk(ioutil.WriteFile(explorerFile, []byte(ExplorerSRC[1:]), 0644))
+			}
+		}
+
 		if config.Bool(config.EnableClusterTerminal) {
 
 			ensurePath(filepath.Join(webFolder, api.APIRoot))
@@ -496,6 +1260,22 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		hs.Shutdown()
 	}()
 
+	// Reload the configuration options which can be changed at runtime on SIGHUP
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	defer func() {
+		signal.Stop(reload)
+		close(reload)
+	}()
+
+	go func() {
+		for range reload {
+			reloadConfig()
+		}
+	}()
+
 	print("Waiting for shutdown")
 	wg.Wait()
 
@@ -215,6 +215,7 @@ Creating key (key.pem) and certificate (cert.pem) in: ssl
 Ensuring web folder: testdb/web
 Ensuring login page: testdb/web/login.html
 Ensuring web terminal: testdb/web/db/term.html
+Ensuring web explorer: testdb/web/db/explorer.html
 Ensuring cluster terminal: testdb/web/db/cluster.html
 Starting HTTPS server on: 127.0.0.1:9090
 Writing fingerprint file: testdb/web/fingerprint.json
@@ -307,6 +308,28 @@ func TestMainErrorCases(t *testing.T) {
 	printLog = []string{}
 	errorLog = []string{}
 
+	// Test invalid snapshot file
+
+	os.MkdirAll(testdb, 0770)
+	ioutil.WriteFile(basepath+"snapshot.db", []byte("foo"), 0660)
+
+	config.Config[config.MemoryOnlyStorage] = true
+	config.Config[config.SnapshotFile] = "snapshot.db"
+
+	runServer()
+
+	if len(errorLog) != 1 || !strings.Contains(errorLog[0], "Could not read snapshot") {
+		t.Error("Unexpected error:", errorLog)
+		return
+	}
+
+	config.Config[config.SnapshotFile] = ""
+
+	// Set back logs
+
+	printLog = []string{}
+	errorLog = []string{}
+
 	// Use memory only storage and the ignored readonly flag
 
 	config.Config[config.MemoryOnlyStorage] = true
@@ -0,0 +1,96 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package shard
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+Local returns the nodes which are not stored by a given shard. The result can
+be given to graph.Manager.SetRemoteNodes of the shard. Traversals of the shard
+fetch remote nodes with this router.
+*/
+func (r *Router) Local(name string) (*LocalShard, error) {
+	if r.sm.Shard(name) == nil {
+		return nil, fmt.Errorf("Unknown shard: %v", name)
+	}
+	return &LocalShard{r, name}, nil
+}
+
+/*
+LocalShard decides which nodes are not stored by a shard and fetches them from
+the shards which store them.
+*/
+type LocalShard struct {
+	r    *Router // Router to contact other shards
+	name string  // Name of the local shard
+}
+
+/*
+IsRemote returns if a node is stored by another shard.
+*/
+func (ls *LocalShard) IsRemote(part string, key string, kind string) bool {
+	return ls.Location(part, key, kind) != ls.name
+}
+
+/*
+Location returns the name of the shard which stores a node.
+*/
+func (ls *LocalShard) Location(part string, key string, kind string) string {
+	return ls.r.sm.ShardOf(part, key).Name
+}
+
+/*
+FetchNodes fetches nodes of a single kind from a shard with a single request.
+Nodes which do not exist are not part of the result.
+*/
+func (ls *LocalShard) FetchNodes(ctx context.Context, part string, location string, kind string,
+	keys []string) ([]data.Node, error) {
+
+	var res []map[string]interface{}
+
+	s := ls.r.sm.Shard(location)
+	if s == nil {
+		return nil, fmt.Errorf("Unknown shard: %v", location)
+	}
+
+	query := url.Values{"key": keys}
+
+	status, err := ls.r.do(ctx, s, "GET", fmt.Sprintf("/v1/graph/%v/n/%v?%v", url.PathEscape(part),
+		url.PathEscape(kind), query.Encode()), nil, &res)
+
+	if err != nil {
+		if status == http.StatusBadRequest {
+
+			// The graph endpoint reports unknown node kinds as bad requests
+
+			err = nil
+		}
+		return nil, err
+	}
+
+	nodes := make([]data.Node, 0, len(res))
+
+	for _, nodeData := range res {
+		if err := data.UntagValues(nodeData); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, data.NewGraphNodeFromMap(nodeData))
+	}
+
+	return nodes, nil
+}
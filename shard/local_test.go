@@ -0,0 +1,85 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package shard
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalShard(t *testing.T) {
+	ts := &testShard{name: "s2"}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	sm, _ := ParseShardMap([]byte(fmt.Sprintf(`{"shards":[{"name":"s1","url":"http://127.0.0.1:1/db"},
+{"name":"s2","url":"%v/db"}],"partitions":{"main":["s1","s2"]},"default":["s1"]}`, srv.URL)))
+
+	r := NewRouter(sm, nil)
+
+	if _, err := r.Local("s3"); err == nil || err.Error() != "Unknown shard: s3" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Local shards decide which nodes are remote
+
+	ls, _ := r.Local("s1")
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint("key", i)
+
+		if ls.IsRemote("main", key, "mykind") != (sm.ShardOf("main", key).Name != "s1") ||
+			ls.IsRemote("other", key, "mykind") || ls.Location("main", key, "mykind") != sm.ShardOf("main", key).Name {
+			t.Error("Unexpected result:", key)
+			return
+		}
+	}
+
+	// Remote nodes are fetched with a single request
+
+	nodes, err := ls.FetchNodes(context.Background(), "main", "s2", "mykind", []string{"a", "x", "b c"})
+	if err != nil || len(nodes) != 2 || nodes[0].Key() != "a" || nodes[1].Key() != "b c" ||
+		nodes[1].Attr("shard") != "s2" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if res := ts.takeRequests(); res != "GET /db/v1/graph/main/n/mykind " {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Unknown node kinds have no nodes
+
+	if nodes, err := ls.FetchNodes(context.Background(), "main", "s2", "otherkind", []string{"a"}); err != nil || nodes != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	if _, err := ls.FetchNodes(context.Background(), "main", "s3", "mykind", []string{"a"}); err == nil ||
+		err.Error() != "Unknown shard: s3" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Requests are canceled with their context
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ls.FetchNodes(ctx, "main", "s2", "mykind", []string{"a"}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
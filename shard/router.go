@@ -0,0 +1,395 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/cryptutil"
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+Router sends requests to the shards of a shard map.
+*/
+type Router struct {
+	sm     *ShardMap    // Shard map
+	client *http.Client // Client to contact the shards
+}
+
+/*
+NewRouter creates a new router for a shard map. A default client is used if no
+client is given.
+*/
+func NewRouter(sm *ShardMap, client *http.Client) *Router {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Router{sm, client}
+}
+
+/*
+ShardMap returns the shard map of this router.
+*/
+func (r *Router) ShardMap() *ShardMap {
+	return r.sm
+}
+
+/*
+graphRequest is the body of a graph request to a single shard.
+*/
+type graphRequest struct {
+	Nodes []map[string]interface{} `json:"nodes"` // Nodes of the request
+	Edges []map[string]interface{} `json:"edges"` // Edges of the request
+}
+
+/*
+graphRequests collects the graph requests for several shards.
+*/
+type graphRequests map[*Shard]*graphRequest
+
+/*
+request returns the graph request for a shard.
+*/
+func (gr graphRequests) request(s *Shard) *graphRequest {
+	req, ok := gr[s]
+	if !ok {
+		req = &graphRequest{[]map[string]interface{}{}, []map[string]interface{}{}}
+		gr[s] = req
+	}
+	return req
+}
+
+/*
+addEdge adds an edge to the graph requests of the shards of both of its ends.
+*/
+func (gr graphRequests) addEdge(sm *ShardMap, part string, edgeData map[string]interface{}, edge data.Edge) {
+	s1 := sm.ShardOf(part, edge.End1Key())
+	s2 := sm.ShardOf(part, edge.End2Key())
+
+	gr.request(s1).Edges = append(gr.request(s1).Edges, edgeData)
+
+	if s2 != s1 {
+		gr.request(s2).Edges = append(gr.request(s2).Edges, edgeData)
+	}
+}
+
+/*
+StoreGraph stores nodes and edges in a partition. Nodes are stored by the shard
+of their key and edges by the shards of both of their ends. Nodes and edges
+without a key get a random UUID.
+*/
+func (r *Router) StoreGraph(part string, nodes []data.Node, edges []data.Edge) error {
+	reqs := make(graphRequests)
+
+	for _, node := range nodes {
+		if node.Key() == "" {
+			node.SetAttr(data.NodeKey, fmt.Sprintf("%x", cryptutil.GenerateUUID()))
+		}
+
+		req := reqs.request(r.sm.ShardOf(part, node.Key()))
+		req.Nodes = append(req.Nodes, data.TagValues(node.Data()))
+	}
+
+	for _, edge := range edges {
+		if edge.Key() == "" {
+			edge.SetAttr(data.NodeKey, fmt.Sprintf("%x", cryptutil.GenerateUUID()))
+		}
+
+		reqs.addEdge(r.sm, part, data.TagValues(edge.Data()), edge)
+	}
+
+	return r.sendGraphRequests("POST", part, reqs)
+}
+
+/*
+RemoveGraph removes nodes and edges from a partition. The edges of a removed
+node which are also stored by other shards are removed from these shards.
+*/
+func (r *Router) RemoveGraph(part string, nodes []data.Node, edges []data.Edge) error {
+	reqs := make(graphRequests)
+
+	for _, node := range nodes {
+		s := r.sm.ShardOf(part, node.Key())
+
+		req := reqs.request(s)
+		req.Nodes = append(req.Nodes, map[string]interface{}{
+			data.NodeKey:  node.Key(),
+			data.NodeKind: node.Kind(),
+		})
+
+		// Find edges to nodes of other shards
+
+		_, nodeEdges, err := r.traverse(s, part, node.Key(), node.Kind(), ":::")
+		if err != nil {
+			return err
+		}
+
+		for _, edge := range nodeEdges {
+			if os := r.sm.ShardOf(part, edge.End2Key()); os != s {
+				req := reqs.request(os)
+				req.Edges = append(req.Edges, map[string]interface{}{
+					data.NodeKey:  edge.Key(),
+					data.NodeKind: edge.Kind(),
+				})
+			}
+		}
+	}
+
+	for _, edge := range edges {
+		reqs.addEdge(r.sm, part, map[string]interface{}{
+			data.NodeKey:  edge.Key(),
+			data.NodeKind: edge.Kind(),
+		}, edge)
+	}
+
+	return r.sendGraphRequests("DELETE", part, reqs)
+}
+
+/*
+FetchNode fetches a single node from the shard which stores it. Returns nil if
+the node does not exist.
+*/
+func (r *Router) FetchNode(part string, key string, kind string) (data.Node, error) {
+	var res map[string]interface{}
+
+	s := r.sm.ShardOf(part, key)
+
+	status, err := r.do(context.Background(), s, "GET", fmt.Sprintf("/v1/graph/%v/n/%v/%v", url.PathEscape(part),
+		url.PathEscape(kind), url.PathEscape(key)), nil, &res)
+
+	if err != nil {
+		if status == http.StatusBadRequest {
+
+			// The graph endpoint reports unknown nodes as bad requests
+
+			err = nil
+		}
+		return nil, err
+	}
+
+	if err := data.UntagValues(res); err != nil {
+		return nil, err
+	}
+
+	return data.NewGraphNodeFromMap(res), nil
+}
+
+/*
+Query runs an EQL query on all shards of a partition. The rows of all shards
+are concatenated - the header is taken from the first shard.
+*/
+func (r *Router) Query(part string, query string) (map[string]interface{}, error) {
+	shards := r.sm.ShardsOf(part)
+	results := make([]map[string]interface{}, len(shards))
+
+	path := fmt.Sprintf("/v1/query/%v?q=%v", url.PathEscape(part), url.QueryEscape(query))
+
+	err := fanOut(shards, func(i int, s *Shard) error {
+		_, err := r.do(context.Background(), s, "GET", path, nil, &results[i])
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeResults(results), nil
+}
+
+/*
+mergeResults merges the query results of several shards.
+*/
+func mergeResults(results []map[string]interface{}) map[string]interface{} {
+	var rows, sources, selections []interface{}
+	var totalSelections float64
+
+	for _, res := range results {
+		for _, l := range []struct {
+			list *[]interface{}
+			attr string
+		}{{&rows, "rows"}, {&sources, "sources"}, {&selections, "selections"}} {
+			if v, ok := res[l.attr].([]interface{}); ok {
+				*l.list = append(*l.list, v...)
+			}
+		}
+
+		if v, ok := res["total_selections"].(float64); ok {
+			totalSelections += v
+		}
+	}
+
+	return map[string]interface{}{
+		"header":           results[0]["header"],
+		"rows":             nonNil(rows),
+		"sources":          nonNil(sources),
+		"selections":       nonNil(selections),
+		"total_selections": totalSelections,
+	}
+}
+
+/*
+nonNil returns an empty list instead of nil.
+*/
+func nonNil(l []interface{}) []interface{} {
+	if l == nil {
+		return []interface{}{}
+	}
+	return l
+}
+
+/*
+traverse traverses from a node of a shard. Returns the connected nodes and the
+traversed edges.
+*/
+func (r *Router) traverse(s *Shard, part string, key string, kind string,
+	spec string) ([]data.Node, []data.Edge, error) {
+
+	var res [][]map[string]interface{}
+
+	status, err := r.do(context.Background(), s, "GET", fmt.Sprintf("/v1/graph/%v/n/%v/%v/%v", url.PathEscape(part),
+		url.PathEscape(kind), url.PathEscape(key), url.PathEscape(spec)), nil, &res)
+
+	if err != nil || len(res) != 2 {
+		if status == http.StatusBadRequest {
+
+			// The node does not exist
+
+			err = nil
+		}
+		return nil, nil, err
+	}
+
+	nodes := make([]data.Node, 0, len(res[0]))
+	edges := make([]data.Edge, 0, len(res[1]))
+
+	for i, nodeData := range res[0] {
+		edgeData := res[1][i]
+
+		if err := data.UntagValues(nodeData); err != nil {
+			return nil, nil, err
+		} else if err := data.UntagValues(edgeData); err != nil {
+			return nil, nil, err
+		}
+
+		nodes = append(nodes, data.NewGraphNodeFromMap(nodeData))
+		edges = append(edges, data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edgeData)))
+	}
+
+	return nodes, edges, nil
+}
+
+/*
+sendGraphRequests sends graph requests to their shards.
+*/
+func (r *Router) sendGraphRequests(method string, part string, reqs graphRequests) error {
+	var shards []*Shard
+
+	for _, s := range r.sm.Shards {
+		if _, ok := reqs[s]; ok {
+			shards = append(shards, s)
+		}
+	}
+
+	return fanOut(shards, func(i int, s *Shard) error {
+		_, err := r.do(context.Background(), s, method, "/v1/graph/"+url.PathEscape(part), reqs[s], nil)
+		return err
+	})
+}
+
+/*
+fanOut calls a function for several shards in parallel. Returns the error of
+the first shard which failed.
+*/
+func fanOut(shards []*Shard, f func(i int, s *Shard) error) error {
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(shards))
+
+	for i, s := range shards {
+		wg.Add(1)
+
+		go func(i int, s *Shard) {
+			defer wg.Done()
+			errs[i] = f(i, s)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+do sends a request to a shard. The request is canceled once the given context
+is done. The request body and the response are JSON encoded. Returns the
+response status.
+*/
+func (r *Router) do(ctx context.Context, s *Shard, method string, path string, body interface{},
+	res interface{}) (int, error) {
+
+	var reqBody io.Reader
+
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Shard %v: %v", s.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("Shard %v returned %v: %v", s.Name, resp.StatusCode,
+			strings.TrimSpace(string(msg)))
+	}
+
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			return resp.StatusCode, fmt.Errorf("Shard %v: %v", s.Name, err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
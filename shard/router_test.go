@@ -0,0 +1,291 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+)
+
+/*
+testShard is a shard which records all graph requests.
+*/
+type testShard struct {
+	name     string
+	requests []string
+	lock     sync.Mutex
+}
+
+func (ts *testShard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	ts.lock.Lock()
+	ts.requests = append(ts.requests, r.Method+" "+r.URL.Path+" "+string(body))
+	ts.lock.Unlock()
+
+	switch {
+	case r.URL.Path == "/db/v1/graph/main/n/mykind/a":
+		fmt.Fprint(w, `{"key":"a","kind":"mykind","time":{"$type":"datetime","value":"2020-01-02T03:04:05Z"}}`)
+
+	case r.URL.Path == "/db/v1/graph/main/n/mykind/a/:::":
+		fmt.Fprint(w, `[[{"key":"b","kind":"mykind"},{"key":"c","kind":"mykind"}],`+
+			`[{"key":"e1","kind":"link","end1key":"a","end1kind":"mykind","end2key":"b","end2kind":"mykind"},`+
+			`{"key":"e2","kind":"link","end1key":"a","end1kind":"mykind","end2key":"c","end2kind":"mykind"}]]`)
+
+	case r.URL.Path == "/db/v1/graph/main/n/mykind":
+		var nodes []string
+
+		for _, key := range r.URL.Query()["key"] {
+			if key != "x" {
+				nodes = append(nodes, fmt.Sprintf(`{"key":"%v","kind":"mykind","shard":"%v"}`, key, ts.name))
+			}
+		}
+
+		fmt.Fprintf(w, "[%v]", strings.Join(nodes, ","))
+
+	case strings.HasPrefix(r.URL.Path, "/db/v1/query/"):
+		fmt.Fprintf(w, `{"header":{"labels":["Key"]},"rows":[["%v"]],"sources":[["n:mykind:%v"]],`+
+			`"selections":[false],"total_selections":0}`, ts.name, ts.name)
+
+	case r.Method == "POST" || r.Method == "DELETE":
+		if strings.Contains(string(body), "fail") {
+			http.Error(w, "Request failed", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "{}")
+
+	default:
+		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
+	}
+}
+
+/*
+takeRequests returns and clears all recorded requests of a shard.
+*/
+func (ts *testShard) takeRequests() string {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	res := strings.Join(ts.requests, "\n")
+	ts.requests = nil
+
+	return res
+}
+
+func TestRouter(t *testing.T) {
+	var shards []*testShard
+	var urls []string
+
+	for i := 0; i < 2; i++ {
+		ts := &testShard{name: fmt.Sprint("s", i+1)}
+		srv := httptest.NewServer(ts)
+		defer srv.Close()
+
+		shards = append(shards, ts)
+		urls = append(urls, srv.URL)
+	}
+
+	sm, _ := ParseShardMap([]byte(fmt.Sprintf(`{"shards":[{"name":"s1","url":"%v/db"},{"name":"s2","url":"%v/db"}],
+"partitions":{"main":["s1","s2"]},"default":["s2"]}`, urls[0], urls[1])))
+
+	r := NewRouter(sm, nil)
+
+	if r.ShardMap() != sm {
+		t.Error("Unexpected result:", r.ShardMap())
+		return
+	}
+
+	// Find keys of nodes on both shards
+
+	keys := make(map[string][]string)
+
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		name := sm.ShardOf("main", k).Name
+		keys[name] = append(keys[name], k)
+	}
+
+	if len(keys["s1"]) < 2 || len(keys["s2"]) < 2 {
+		t.Error("Unexpected distribution:", keys)
+		return
+	}
+
+	k1, k2 := keys["s1"][0], keys["s2"][0]
+
+	newNode := func(key string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "mykind")
+		return node
+	}
+
+	newEdge := func(key string, end1 string, end2 string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "link")
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+		return edge
+	}
+
+	// Nodes are stored by their shard - edges by the shards of both ends
+
+	if err := r.StoreGraph("main", []data.Node{newNode(k1), newNode(k2)},
+		[]data.Edge{newEdge("e1", k1, k2), newEdge("e2", k1, keys["s1"][1])}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := shards[0].takeRequests(); res != fmt.Sprintf(`POST /db/v1/graph/main {"nodes":[{"key":"%v","kind":"mykind"}],`+
+		`"edges":[{"end1key":"%v","end1kind":"mykind","end2key":"%v","end2kind":"mykind","key":"e1","kind":"link"},`+
+		`{"end1key":"%v","end1kind":"mykind","end2key":"%v","end2kind":"mykind","key":"e2","kind":"link"}]}`,
+		k1, k1, k2, k1, keys["s1"][1]) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := shards[1].takeRequests(); res != fmt.Sprintf(`POST /db/v1/graph/main {"nodes":[{"key":"%v","kind":"mykind"}],`+
+		`"edges":[{"end1key":"%v","end1kind":"mykind","end2key":"%v","end2kind":"mykind","key":"e1","kind":"link"}]}`,
+		k2, k1, k2) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Nodes without a key get a generated key
+
+	node := newNode("")
+
+	if err := r.StoreGraph("other", []data.Node{node}, nil); err != nil || len(node.Key()) != 32 {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if res := shards[1].takeRequests(); !strings.HasPrefix(res, "POST /db/v1/graph/other") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Errors of shards are returned
+
+	if err := r.StoreGraph("other", []data.Node{newNode("fail")}, nil); err == nil ||
+		err.Error() != "Shard s2 returned 400: Request failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	shards[1].takeRequests()
+
+	// Removing a node removes its edges from other shards
+
+	if err := r.RemoveGraph("main", []data.Node{newNode("a")}, []data.Edge{newEdge("e3", k1, k2)}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res []string
+
+	for i, shard := range shards {
+		for _, req := range strings.Split(shard.takeRequests(), "\n") {
+			res = append(res, fmt.Sprint("s", i+1, " ", req))
+		}
+	}
+
+	sort.Strings(res)
+
+	var expected []string
+
+	aShard := sm.ShardOf("main", "a").Name
+
+	expected = append(expected, fmt.Sprintf("%v GET /db/v1/graph/main/n/mykind/a/::: ", aShard))
+
+	edges := map[string][]string{}
+	nodes := map[string]string{aShard: `{"key":"a","kind":"mykind"}`}
+
+	for _, e := range [][2]string{{"e1", "b"}, {"e2", "c"}} {
+		if s := sm.ShardOf("main", e[1]).Name; s != aShard {
+			edges[s] = append(edges[s], fmt.Sprintf(`{"key":"%v","kind":"link"}`, e[0]))
+		}
+	}
+
+	edges["s1"] = append(edges["s1"], `{"key":"e3","kind":"link"}`)
+	edges["s2"] = append(edges["s2"], `{"key":"e3","kind":"link"}`)
+
+	for _, s := range []string{"s1", "s2"} {
+		expected = append(expected, fmt.Sprintf(`%v DELETE /db/v1/graph/main {"nodes":[%v],"edges":[%v]}`,
+			s, nodes[s], strings.Join(edges[s], ",")))
+	}
+
+	sort.Strings(expected)
+
+	if strings.Join(res, "\n") != strings.Join(expected, "\n") {
+		t.Error("Unexpected result:\n", strings.Join(res, "\n"), "\nexpected:\n", strings.Join(expected, "\n"))
+		return
+	}
+
+	// Nodes are fetched from their shard
+
+	if node, err := r.FetchNode("main", "a", "mykind"); err != nil ||
+		fmt.Sprint(node.Attr("time")) != "2020-01-02 03:04:05 +0000 UTC" {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if node, err := r.FetchNode("main", "x", "mykind"); err != nil || node != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	shards[0].takeRequests()
+	shards[1].takeRequests()
+
+	// Queries are sent to all shards of a partition
+
+	qres, err := r.Query("main", "get mykind")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if out, _ := json.Marshal(qres); string(out) != `{"header":{"labels":["Key"]},"rows":[["s1"],["s2"]],`+
+		`"selections":[false,false],"sources":[["n:mykind:s1"],["n:mykind:s2"]],"total_selections":0}` {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	if res := shards[0].takeRequests(); res != "GET /db/v1/query/main " {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if qres, _ := r.Query("other", "get mykind"); len(qres["rows"].([]interface{})) != 1 {
+		t.Error("Unexpected result:", qres)
+		return
+	}
+
+	// Unreachable shards are reported
+
+	sm.Shard("s2").URL = "http://127.0.0.1:1/db"
+
+	if _, err := r.Query("main", "get mykind"); err == nil || !strings.HasPrefix(err.Error(), "Shard s2: ") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package shard contains a sharding layer which distributes the partitions of a
+graph over several EliasDB instances (shards).
+
+Shard map
+
+A shard map assigns each partition to one or more shards. A partition with a
+single shard is stored completely by this shard. The nodes of a partition with
+several shards are distributed by hash ranges of their keys: the 32 bit hash
+of a node key is split into as many equal ranges as there are shards.
+Partitions which are not part of the map are assigned to the default shards. A
+shard map is a JSON object:
+
+	{
+		"shards" : [
+			{ "name" : "s1", "url" : "https://host1:9090/db" },
+			{ "name" : "s2", "url" : "https://host2:9090/db" }
+		],
+		"partitions" : {
+			"main" : [ "s1", "s2" ]
+		},
+		"default" : [ "s1" ]
+	}
+
+The URL of a shard is the root of its REST API (e.g. https://host:9090/db for
+the main database or https://host:9090/db/<name>/api for a named database).
+
+Edges
+
+An edge is stored by the shards of both of its ends. Each shard knows the nodes
+which are stored by other shards (see graph.RemoteNodes) and only updates the
+local ends of such edges. Cascading deletions are not propagated to other
+shards. Traversals which need the data of nodes on other shards fetch them in
+parallel batches (see graph.RemoteFetcher) - a batch contains nodes of the same
+kind from the same shard.
+
+Router
+
+A router sends graph changes to the responsible shards and fans out queries to
+all shards of a partition. The results of all shards are merged. Changes which
+affect several shards are committed by each shard separately - a failed request
+can leave a partially applied change.
+*/
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"strings"
+)
+
+/*
+Shard is a single EliasDB instance which stores a part of a graph.
+*/
+type Shard struct {
+	Name string `json:"name"` // Unique name of the shard
+	URL  string `json:"url"`  // Root of the REST API of the shard
+}
+
+/*
+ShardMap assigns the partitions of a graph to shards.
+*/
+type ShardMap struct {
+	Shards     []*Shard            `json:"shards"`     // All shards
+	Partitions map[string][]string `json:"partitions"` // Names of the shards of each partition
+	Default    []string            `json:"default"`    // Names of the shards of all other partitions
+	shards     map[string]*Shard   // Lookup map for shards by name
+}
+
+/*
+LoadShardMap loads a shard map from a JSON file.
+*/
+func LoadShardMap(filename string) (*ShardMap, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseShardMap(content)
+}
+
+/*
+ParseShardMap parses a JSON encoded shard map.
+*/
+func ParseShardMap(content []byte) (*ShardMap, error) {
+	sm := &ShardMap{}
+
+	if err := json.Unmarshal(content, sm); err != nil {
+		return nil, fmt.Errorf("Could not parse shard map: %v", err)
+	}
+
+	sm.shards = make(map[string]*Shard)
+
+	for _, s := range sm.Shards {
+		if s.Name == "" || s.URL == "" {
+			return nil, fmt.Errorf("Shard needs a name and an url")
+		} else if sm.shards[s.Name] != nil {
+			return nil, fmt.Errorf("Duplicate shard: %v", s.Name)
+		}
+
+		s.URL = strings.TrimSuffix(s.URL, "/")
+		sm.shards[s.Name] = s
+	}
+
+	if len(sm.Default) == 0 {
+		return nil, fmt.Errorf("Shard map needs default shards")
+	}
+
+	check := func(names []string) error {
+		for _, name := range names {
+			if sm.shards[name] == nil {
+				return fmt.Errorf("Unknown shard: %v", name)
+			}
+		}
+		return nil
+	}
+
+	if err := check(sm.Default); err != nil {
+		return nil, err
+	}
+
+	for part, names := range sm.Partitions {
+		if len(names) == 0 {
+			return nil, fmt.Errorf("Partition %v has no shards", part)
+		} else if err := check(names); err != nil {
+			return nil, err
+		}
+	}
+
+	return sm, nil
+}
+
+/*
+Shard returns a shard by its name (nil if the shard does not exist).
+*/
+func (sm *ShardMap) Shard(name string) *Shard {
+	return sm.shards[name]
+}
+
+/*
+ShardsOf returns all shards of a partition.
+*/
+func (sm *ShardMap) ShardsOf(part string) []*Shard {
+	names, ok := sm.Partitions[part]
+	if !ok {
+		names = sm.Default
+	}
+
+	shards := make([]*Shard, len(names))
+
+	for i, name := range names {
+		shards[i] = sm.shards[name]
+	}
+
+	return shards
+}
+
+/*
+ShardOf returns the shard which stores a node of a partition.
+*/
+func (sm *ShardMap) ShardOf(part string, key string) *Shard {
+	shards := sm.ShardsOf(part)
+
+	if len(shards) == 1 {
+		return shards[0]
+	}
+
+	return shards[uint64(keyHash(key))*uint64(len(shards))>>32]
+}
+
+/*
+keyHash returns the hash of a node key. The FNV-1a hash is mixed with the
+finalizer of MurmurHash3 so that similar short keys are spread over the whole
+hash range.
+*/
+func keyHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	x := h.Sum32()
+
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+
+	return x
+}
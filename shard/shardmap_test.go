@@ -0,0 +1,98 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package shard
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const testShardMap = `{
+	"shards" : [
+		{ "name" : "s1", "url" : "http://host1/db/" },
+		{ "name" : "s2", "url" : "http://host2/db" },
+		{ "name" : "s3", "url" : "http://host3/db" }
+	],
+	"partitions" : {
+		"main" : [ "s1", "s2", "s3" ]
+	},
+	"default" : [ "s3" ]
+}`
+
+func TestShardMap(t *testing.T) {
+
+	for _, test := range []struct {
+		content string
+		err     string
+	}{
+		{`[]`, "Could not parse shard map: json: cannot unmarshal array into Go value of type shard.ShardMap"},
+		{`{"shards":[{"name":"s1"}]}`, "Shard needs a name and an url"},
+		{`{"shards":[{"name":"s1","url":"u"},{"name":"s1","url":"u"}]}`, "Duplicate shard: s1"},
+		{`{"shards":[{"name":"s1","url":"u"}]}`, "Shard map needs default shards"},
+		{`{"shards":[{"name":"s1","url":"u"}],"default":["s2"]}`, "Unknown shard: s2"},
+		{`{"shards":[{"name":"s1","url":"u"}],"default":["s1"],"partitions":{"main":[]}}`, "Partition main has no shards"},
+		{`{"shards":[{"name":"s1","url":"u"}],"default":["s1"],"partitions":{"main":["s3"]}}`, "Unknown shard: s3"},
+	} {
+		if _, err := ParseShardMap([]byte(test.content)); err == nil || err.Error() != test.err {
+			t.Error("Unexpected result:", test.content, err)
+			return
+		}
+	}
+
+	ioutil.WriteFile("shards_test.json", []byte(testShardMap), 0660)
+	defer os.Remove("shards_test.json")
+
+	sm, err := LoadShardMap("shards_test.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := LoadShardMap("shards_missing.json"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if s := sm.Shard("s1"); s.URL != "http://host1/db" || sm.Shard("s4") != nil {
+		t.Error("Unexpected result:", s)
+		return
+	}
+
+	if res := fmt.Sprint(len(sm.ShardsOf("main")), " ", sm.ShardsOf("other")[0].Name); res != "3 s3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Nodes of a partition are distributed over all of its shards
+
+	counts := make(map[string]int)
+
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprint("key", i)
+
+		s := sm.ShardOf("main", key)
+		counts[s.Name]++
+
+		if sm.ShardOf("main", key) != s || sm.ShardOf("other", key).Name != "s3" {
+			t.Error("Unexpected shard:", key, s)
+			return
+		}
+	}
+
+	for _, name := range []string{"s1", "s2", "s3"} {
+		if counts[name] < 800 {
+			t.Error("Unexpected distribution:", counts)
+			return
+		}
+	}
+}
@@ -0,0 +1,23 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package badger contains a key-value store for the KVStorageManager which is
+backed by Badger (github.com/dgraph-io/badger).
+
+Badger is a log-structured merge tree which writes sequentially and keeps
+large values in a separate value log. Compared to page based storage files
+this suits write-heavy ingest.
+
+The package is only compiled if EliasDB is built with the badger build tag:
+
+	go build -tags badger
+*/
+package badger
@@ -0,0 +1,218 @@
+//go:build badger
+// +build badger
+
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+/*
+GCDiscardRatio is the ratio of discardable data in a value log file which
+triggers a rewrite of the file during a compaction.
+*/
+var GCDiscardRatio = 0.5
+
+/*
+ErrReadonly is returned when trying to write to a readonly store
+*/
+var ErrReadonly = errors.New("Store is readonly")
+
+/*
+Store is a key-value store which is backed by Badger.
+*/
+type Store struct {
+	db         *badger.DB // Badger database
+	readonly   bool       // Flag for readonly mode
+	syncWrites int32      // Flag if every write should be synced (accessed atomically)
+}
+
+/*
+Open opens a store in a given directory. The directory is created if it
+does not exist.
+*/
+func Open(dir string, readonly bool) (*Store, error) {
+
+	// Writes are synced by the store itself so syncing can be switched
+	// on and off while the store is open
+
+	opts := badger.DefaultOptions(dir).
+		WithReadOnly(readonly).
+		WithSyncWrites(false).
+		WithLogger(nil)
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db, readonly, 1}, nil
+}
+
+/*
+Get returns the value of a given key. The returned flag is false if the key
+does not exist.
+*/
+func (s *Store) Get(key []byte) ([]byte, bool, error) {
+	var res []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == nil {
+			res, err = item.ValueCopy(nil)
+		}
+		return err
+	})
+
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if res == nil {
+		res = []byte{}
+	}
+
+	return res, true, nil
+}
+
+/*
+Write writes a batch of updates in a single transaction. Keys with a nil
+value are deleted. Batches which are too big for a single transaction are
+split into several transactions - these batches are not atomic.
+*/
+func (s *Store) Write(updates map[string][]byte) error {
+
+	if s.readonly {
+		return ErrReadonly
+	} else if len(updates) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return applyUpdates(txn, updates)
+	})
+
+	if err == badger.ErrTxnTooBig {
+		wb := s.db.NewWriteBatch()
+		defer wb.Cancel()
+
+		for k, v := range updates {
+			if v == nil {
+				err = wb.Delete([]byte(k))
+			} else {
+				err = wb.Set([]byte(k), v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err = wb.Flush()
+	}
+
+	if err == nil && atomic.LoadInt32(&s.syncWrites) == 1 {
+		err = s.db.Sync()
+	}
+
+	return err
+}
+
+/*
+applyUpdates applies a batch of updates to a transaction.
+*/
+func applyUpdates(txn *badger.Txn, updates map[string][]byte) error {
+	var err error
+
+	for k, v := range updates {
+		if v == nil {
+			err = txn.Delete([]byte(k))
+		} else {
+			err = txn.Set([]byte(k), v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Sync makes sure that all writes have been committed to stable storage.
+*/
+func (s *Store) Sync() error {
+
+	if s.readonly {
+		return nil
+	}
+
+	return s.db.Sync()
+}
+
+/*
+SetSyncWrites sets if every write should be synced to stable storage. Writes
+which were not synced can be lost if the system crashes.
+*/
+func (s *Store) SetSyncWrites(syncWrites bool) {
+	var v int32
+
+	if syncWrites {
+		v = 1
+	}
+
+	atomic.StoreInt32(&s.syncWrites, v)
+}
+
+/*
+Compact rewrites value log files which contain mostly overwritten or deleted
+values. Returns the number of bytes which were reclaimed according to the
+sizes which Badger reports (Badger refreshes these sizes periodically).
+*/
+func (s *Store) Compact() (int64, error) {
+
+	if s.readonly {
+		return 0, ErrReadonly
+	}
+
+	lsmBefore, vlogBefore := s.db.Size()
+
+	err := s.db.RunValueLogGC(GCDiscardRatio)
+
+	for err == nil {
+		err = s.db.RunValueLogGC(GCDiscardRatio)
+	}
+
+	if err != badger.ErrNoRewrite {
+		return 0, err
+	}
+
+	lsmAfter, vlogAfter := s.db.Size()
+
+	if reclaimed := lsmBefore + vlogBefore - lsmAfter - vlogAfter; reclaimed > 0 {
+		return reclaimed, nil
+	}
+
+	return 0, nil
+}
+
+/*
+Close closes the store.
+*/
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,126 @@
+//go:build badger
+// +build badger
+
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+)
+
+const testdbdir = "test"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	os.RemoveAll(testdbdir)
+
+	res := m.Run()
+
+	os.RemoveAll(testdbdir)
+
+	os.Exit(res)
+}
+
+func TestStore(t *testing.T) {
+
+	s, err := Open(testdbdir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := s.Write(map[string][]byte{
+			fmt.Sprint("key", i): []byte(fmt.Sprint("value", i)),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	s.SetSyncWrites(false)
+
+	if err := s.Write(map[string][]byte{
+		"key1": []byte("newvalue1"),
+		"key2": nil,
+		"key3": {},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := s.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	check := func(s *Store) {
+		t.Helper()
+
+		if v, ok, err := s.Get([]byte("key1")); !ok || err != nil || string(v) != "newvalue1" {
+			t.Error("Unexpected result:", string(v), ok, err)
+		}
+
+		if v, ok, err := s.Get([]byte("key2")); ok || err != nil || v != nil {
+			t.Error("Unexpected result:", string(v), ok, err)
+		}
+
+		if v, ok, err := s.Get([]byte("key3")); !ok || err != nil || v == nil || len(v) != 0 {
+			t.Error("Unexpected result:", string(v), ok, err)
+		}
+
+		if v, ok, err := s.Get([]byte("key42")); !ok || err != nil || string(v) != "value42" {
+			t.Error("Unexpected result:", string(v), ok, err)
+		}
+	}
+
+	check(s)
+
+	if _, err := s.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Open readonly
+
+	s, err = Open(testdbdir, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	check(s)
+
+	if err := s.Write(map[string][]byte{"key1": nil}); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := s.Compact(); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
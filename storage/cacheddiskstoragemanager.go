@@ -34,10 +34,18 @@ MemoryStorageManager
 
 A storage manager which keeps all its data in memory and provides several
 error simulation facilities.
+
+MVCCStorageManager
+
+A wrapper for a storage manager which allows readers to work on consistent
+snapshots of the data while writers continue to change it.
 */
 package storage
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 /*
 CachedDiskStorageManager data structure
@@ -49,6 +57,8 @@ type CachedDiskStorageManager struct {
 	maxObjects         int                    // Max number of objects which should be held in the cache
 	firstentry         *cacheEntry            // Pointer to first entry in cacheEntry linked list
 	lastentry          *cacheEntry            // Pointer to last entry in cacheEntry linked list
+	hits               uint64                 // Number of requests which were answered from the cache
+	misses             uint64                 // Number of requests which were answered from disk
 }
 
 /*
@@ -71,7 +81,7 @@ NewCachedDiskStorageManager creates a new cache wrapper for a DiskStorageManger.
 */
 func NewCachedDiskStorageManager(diskstoragemanager *DiskStorageManager, maxObjects int) *CachedDiskStorageManager {
 	return &CachedDiskStorageManager{diskstoragemanager, &sync.Mutex{}, make(map[uint64]*cacheEntry),
-		maxObjects, nil, nil}
+		maxObjects, nil, nil, 0, 0}
 }
 
 /*
@@ -172,6 +182,8 @@ func (cdsm *CachedDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 	cdsm.mutex.Lock()
 	defer cdsm.mutex.Unlock()
 
+	cdsm.misses++
+
 	// Put the retrieved value into the cache
 
 	if entry, ok := cdsm.cache[loc]; !ok {
@@ -193,12 +205,59 @@ func (cdsm *CachedDiskStorageManager) FetchCached(loc uint64) (interface{}, erro
 	defer cdsm.mutex.Unlock()
 
 	if entry, ok := cdsm.cache[loc]; ok {
+		cdsm.hits++
 		return entry.object, nil
 	}
 
 	return nil, NewStorageManagerError(ErrNotInCache, "", cdsm.Name())
 }
 
+/*
+CacheStats returns the current statistics of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) CacheStats() CacheStats {
+
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	return CacheStats{len(cdsm.cache), cdsm.maxObjects, cdsm.hits, cdsm.misses}
+}
+
+/*
+ResetCacheStats resets the hit and miss counters of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) ResetCacheStats() {
+
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.hits = 0
+	cdsm.misses = 0
+}
+
+/*
+SetMaxObjects sets the max number of objects which should be held in the
+cache. Entries which were requested the least are removed if the cache holds
+more objects.
+*/
+func (cdsm *CachedDiskStorageManager) SetMaxObjects(maxObjects int) {
+
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	if maxObjects < 1 {
+		maxObjects = 1
+	}
+
+	cdsm.maxObjects = maxObjects
+
+	for len(cdsm.cache) > maxObjects {
+		entry := cdsm.removeOldestFromCache()
+		entry.object = nil
+		entryPool.Put(entry)
+	}
+}
+
 /*
 Rollback cancels all pending changes which have not yet been written to disk.
 */
@@ -236,6 +295,63 @@ func (cdsm *CachedDiskStorageManager) Flush() error {
 	return cdsm.diskstoragemanager.Flush()
 }
 
+/*
+Sync writes all pending changes to disk and makes sure that they have been
+committed to stable storage.
+*/
+func (cdsm *CachedDiskStorageManager) Sync() error {
+	return cdsm.diskstoragemanager.Sync()
+}
+
+/*
+SetDeferSync sets if syncing the transaction log on every flush should be
+deferred.
+*/
+func (cdsm *CachedDiskStorageManager) SetDeferSync(deferSync bool) {
+	cdsm.diskstoragemanager.SetDeferSync(deferSync)
+}
+
+/*
+SyncLog makes sure that all flushed changes have been committed to stable
+storage.
+*/
+func (cdsm *CachedDiskStorageManager) SyncLog() error {
+	return cdsm.diskstoragemanager.SyncLog()
+}
+
+/*
+Compact reclaims the space of deleted and updated records. Storage locations
+do not change so cached objects stay valid.
+*/
+func (cdsm *CachedDiskStorageManager) Compact(batchSize int, pause time.Duration,
+	progress CompactionProgress) (int64, error) {
+	return cdsm.diskstoragemanager.Compact(batchSize, pause, progress)
+}
+
+/*
+SetCompression sets the compression which is used for records which are
+inserted or updated from now on.
+*/
+func (cdsm *CachedDiskStorageManager) SetCompression(name string) error {
+	return cdsm.diskstoragemanager.SetCompression(name)
+}
+
+/*
+SetEncryption sets the key ring which is used to encrypt records which are
+inserted or updated from now on.
+*/
+func (cdsm *CachedDiskStorageManager) SetEncryption(e *Encryption) {
+	cdsm.diskstoragemanager.SetEncryption(e)
+}
+
+/*
+Reencrypt rewrites all records which are not encrypted with the active key.
+The content of records does not change so cached objects stay valid.
+*/
+func (cdsm *CachedDiskStorageManager) Reencrypt(batchSize int, pause time.Duration) (int, error) {
+	return cdsm.diskstoragemanager.Reencrypt(batchSize, pause)
+}
+
 /*
 addToCache adds an entry to the cache.
 */
@@ -341,3 +457,10 @@ func (cdsm *CachedDiskStorageManager) llRemoveEntry(entry *cacheEntry) {
 		entry.next = nil
 	}
 }
+
+/*
+Check verifies the consistency of the storage files.
+*/
+func (cdsm *CachedDiskStorageManager) Check() (*CheckReport, error) {
+	return cdsm.diskstoragemanager.Check()
+}
@@ -197,15 +197,25 @@ func TestCachedDiskStorageManager(t *testing.T) {
 		return
 	}
 
+	if err := cdsm.Sync(); err == nil {
+		t.Error("Unexpected sync result:", err)
+		return
+	}
+
 	dsm.physicalSlotsSf.ReleaseInUse(record)
 
-	// Flush should now succeed
+	// Flush and sync should now succeed
 
 	if err := cdsm.Flush(); err != nil {
 		t.Error(err)
 		return
 	}
 
+	if err := cdsm.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
 	if err = cdsm.Close(); err != nil {
 		t.Error(err)
 	}
@@ -401,3 +411,57 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCachedDiskStorageManagerCacheStats(t *testing.T) {
+
+	var ret string
+
+	dsm := NewDiskStorageManager(DBDIR+"/ctest4", false, false, true, true)
+
+	cdsm := NewCachedDiskStorageManager(dsm, 3)
+
+	loc1, _ := cdsm.Insert("test1")
+	loc2, _ := cdsm.Insert("test2")
+	loc3, _ := cdsm.Insert("test3")
+
+	cdsm.FetchCached(loc1)
+	cdsm.FetchCached(loc2)
+	cdsm.Fetch(loc3, &ret)
+
+	if res := cdsm.CacheStats(); res != (CacheStats{3, 3, 2, 1}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Shrinking the cache removes the entries which were requested the least
+
+	cdsm.SetMaxObjects(1)
+
+	if _, err := cdsm.FetchCached(loc1); err == nil {
+		t.Error("Cache entry should not be available")
+		return
+	}
+
+	if res, err := cdsm.FetchCached(loc3); res != "test3" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	cdsm.ResetCacheStats()
+
+	if res := cdsm.CacheStats(); res != (CacheStats{1, 1, 0, 0}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	cdsm.SetMaxObjects(0)
+
+	if res := cdsm.CacheStats(); res.MaxObjects != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,383 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+CheckReportMaxExamples is the maximum number of problems which are described
+in a check report.
+*/
+var CheckReportMaxExamples = 20
+
+/*
+CheckReport is the result of a consistency check of a storage.
+*/
+type CheckReport struct {
+	Name              string   `json:"name"`                // Name of the checked storage
+	Pages             uint64   `json:"pages"`               // Number of pages in all storage files
+	Records           uint64   `json:"records"`             // Number of readable records
+	FreePhysicalSlots uint64   `json:"free_physical_slots"` // Number of free physical slots
+	FreeLogicalSlots  uint64   `json:"free_logical_slots"`  // Number of free logical slots
+	Problems          uint64   `json:"problems"`            // Number of found problems
+	Examples          []string `json:"examples"`            // Descriptions of found problems
+}
+
+/*
+Consistent returns true if no problems were found.
+*/
+func (r *CheckReport) Consistent() bool {
+	return r.Problems == 0
+}
+
+/*
+addProblem adds a problem to the report.
+*/
+func (r *CheckReport) addProblem(format string, args ...interface{}) {
+	r.Problems++
+
+	if len(r.Examples) < CheckReportMaxExamples {
+		r.Examples = append(r.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+/*
+pageTypeNames are the names of all page types.
+*/
+var pageTypeNames = map[int16]string{
+	view.TypeFreePage:             "free",
+	view.TypeDataPage:             "data",
+	view.TypeTranslationPage:      "translation",
+	view.TypeFreeLogicalSlotPage:  "free logical slot",
+	view.TypeFreePhysicalSlotPage: "free physical slot",
+}
+
+/*
+checkPage is a page which was found in a page list.
+*/
+type checkPage struct {
+	pagetype int16  // Type of the list which contains the page
+	next     uint64 // Next page in the list
+}
+
+/*
+Check verifies the consistency of the storage files. The check walks all page
+lists (including the free page lists), makes sure that every allocated page
+is in exactly one list and that the page types and links are correct. It then
+checks that every used logical slot points to a readable physical slot and
+that free physical and logical slots are not in use. The storage files are
+not changed. The storage is locked during the check.
+*/
+func (bdsm *ByteDiskStorageManager) Check() (*CheckReport, error) {
+
+	bdsm.checkFileOpen()
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	report := &CheckReport{Name: bdsm.filename}
+
+	dataPages := bdsm.checkPages(report, bdsm.physicalSlotsPager)
+	freePhysicalPages := bdsm.checkPages(report, bdsm.physicalFreeSlotsPager)
+	transPages := bdsm.checkPages(report, bdsm.logicalSlotsPager)
+	freeLogicalPages := bdsm.checkPages(report, bdsm.logicalFreeSlotsPager)
+
+	// Check all used logical slots
+
+	used := make(map[uint64]uint64)
+	usedLogical := make(map[uint64]bool)
+
+	for _, page := range pagesOfType(transPages, view.TypeTranslationPage) {
+
+		locs, err := readLocations(bdsm.logicalSlotsSf, page, pageview.OffsetTransData, util.LocationSize)
+		if err != nil {
+			report.addProblem("Translation page %v cannot be read: %v", page, err)
+			continue
+		}
+
+		for i, ploc := range locs {
+
+			if ploc == 0 {
+				continue
+			}
+
+			loc := util.PackLocation(page, uint16(pageview.OffsetTransData+i*util.LocationSize))
+
+			usedLogical[loc] = true
+
+			if other, ok := used[ploc]; ok {
+				report.addProblem("Records %v and %v share the physical slot %v", other, loc, ploc)
+				continue
+			}
+
+			used[ploc] = loc
+
+			if err := bdsm.checkSlot(dataPages, ploc); err != nil {
+				report.addProblem("Record %v cannot be read: %v", loc, err)
+				continue
+			}
+
+			report.Records++
+		}
+	}
+
+	// Check all free physical slots
+
+	freed := make(map[uint64]bool)
+
+	for _, page := range pagesOfType(freePhysicalPages, view.TypeFreePhysicalSlotPage) {
+
+		locs, err := readLocations(bdsm.physicalFreeSlotsSf, page, pageview.OffsetData, pageview.SlotInfoSize)
+		if err != nil {
+			report.addProblem("Free physical slot page %v cannot be read: %v", page, err)
+			continue
+		}
+
+		for _, ploc := range locs {
+
+			if ploc == 0 {
+				continue
+			}
+
+			report.FreePhysicalSlots++
+
+			if loc, ok := used[ploc]; ok {
+				report.addProblem("Free physical slot %v is used by record %v", ploc, loc)
+			} else if freed[ploc] {
+				report.addProblem("Physical slot %v is free more than once", ploc)
+			} else if p, ok := dataPages[util.LocationRecord(ploc)]; !ok || p.pagetype != view.TypeDataPage {
+				report.addProblem("Free physical slot %v is not on a data page", ploc)
+			}
+
+			freed[ploc] = true
+		}
+	}
+
+	// Check all free logical slots
+
+	freed = make(map[uint64]bool)
+
+	for _, page := range pagesOfType(freeLogicalPages, view.TypeFreeLogicalSlotPage) {
+
+		locs, err := readLocations(bdsm.logicalFreeSlotsSf, page, pageview.OffsetData, util.LocationSize)
+		if err != nil {
+			report.addProblem("Free logical slot page %v cannot be read: %v", page, err)
+			continue
+		}
+
+		for _, loc := range locs {
+
+			if loc == 0 {
+				continue
+			}
+
+			report.FreeLogicalSlots++
+
+			if usedLogical[loc] {
+				report.addProblem("Free logical slot %v is in use", loc)
+			} else if freed[loc] {
+				report.addProblem("Logical slot %v is free more than once", loc)
+			} else if p, ok := transPages[util.LocationRecord(loc)]; !ok || p.pagetype != view.TypeTranslationPage {
+				report.addProblem("Free logical slot %v is not on a translation page", loc)
+			}
+
+			freed[loc] = true
+		}
+	}
+
+	return report, nil
+}
+
+/*
+checkPages walks all page lists of a paged storage file and returns all found
+pages. Assumes that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) checkPages(report *CheckReport,
+	pager *paging.PagedStorageFile) map[uint64]*checkPage {
+
+	sf := pager.StorageFile()
+	pages := make(map[uint64]*checkPage)
+
+	// The last element of the free page list points to the next page which
+	// will be allocated
+
+	end := pager.Last(view.TypeFreePage)
+	if end == 0 {
+		end = 1
+	}
+
+	for pagetype := int16(view.TypeFreePage); pagetype <= view.TypeFreePhysicalSlotPage; pagetype++ {
+		var prev uint64
+
+		for id := pager.First(pagetype); id != 0; {
+
+			if id >= end {
+				report.addProblem("%v: %v page list points to unallocated page %v",
+					sf.Name(), pageTypeNames[pagetype], id)
+				break
+
+			} else if p, ok := pages[id]; ok {
+				report.addProblem("%v: Page %v is in the %v page list and in the %v page list",
+					sf.Name(), id, pageTypeNames[p.pagetype], pageTypeNames[pagetype])
+				break
+			}
+
+			record, err := sf.Get(id)
+			if err != nil {
+				report.addProblem("%v: Page %v cannot be read: %v", sf.Name(), id, err)
+				break
+			}
+
+			actualtype := record.ReadInt16(0) - view.ViewPageHeader
+			next := record.ReadUInt64(view.OffsetNextPage)
+			actualprev := record.ReadUInt64(view.OffsetPrevPage)
+
+			sf.ReleaseInUse(record)
+
+			pages[id] = &checkPage{pagetype, next}
+
+			if actualtype != pagetype {
+				report.addProblem("%v: Page %v of type %v is in the %v page list",
+					sf.Name(), id, actualtype, pageTypeNames[pagetype])
+			}
+
+			// The previous pointers of free pages are not maintained
+
+			if pagetype != view.TypeFreePage && actualprev != prev {
+				report.addProblem("%v: Page %v points to previous page %v instead of %v",
+					sf.Name(), id, actualprev, prev)
+			}
+
+			prev = id
+			id = next
+		}
+
+		if last := pager.Last(pagetype); pagetype != view.TypeFreePage && last != prev {
+			report.addProblem("%v: %v page list ends with page %v instead of %v",
+				sf.Name(), pageTypeNames[pagetype], prev, last)
+		}
+	}
+
+	for id := uint64(1); id < end; id++ {
+		if _, ok := pages[id]; !ok {
+			report.addProblem("%v: Page %v is not in any page list", sf.Name(), id)
+		}
+	}
+
+	report.Pages += uint64(len(pages))
+
+	return pages
+}
+
+/*
+checkSlot checks that a physical slot is on a data page and that its data
+fits on the following data pages. Assumes that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) checkSlot(dataPages map[uint64]*checkPage, ploc uint64) error {
+
+	page := util.LocationRecord(ploc)
+	offset := int(util.LocationOffset(ploc))
+
+	if p, ok := dataPages[page]; !ok || p.pagetype != view.TypeDataPage {
+		return fmt.Errorf("Physical slot %v is not on a data page", ploc)
+	}
+
+	record, err := bdsm.physicalSlotsSf.Get(page)
+	if err != nil {
+		return err
+	}
+
+	recordSize := len(record.Data())
+
+	if offset < pageview.OffsetData || offset+util.SizeInfoSize > recordSize {
+		bdsm.physicalSlotsSf.ReleaseInUse(record)
+		return fmt.Errorf("Physical slot %v has an invalid offset", ploc)
+	}
+
+	available := util.AvailableSize(record, offset)
+	difference := record.ReadUInt16(offset + util.OffsetCurrentSize)
+
+	bdsm.physicalSlotsSf.ReleaseInUse(record)
+
+	if difference != util.UnsignedShortMax && uint32(difference) > available {
+		return fmt.Errorf("Physical slot %v has an invalid size", ploc)
+	}
+
+	// Data which does not fit on the page continues on the next data pages
+
+	rest := int64(available) - int64(recordSize-offset-util.SizeInfoSize)
+
+	for rest > 0 {
+		if p, ok := dataPages[page]; ok && p.next != 0 {
+			page = p.next
+		} else {
+			return fmt.Errorf("Physical slot %v exceeds the last data page", ploc)
+		}
+
+		if p, ok := dataPages[page]; !ok || p.pagetype != view.TypeDataPage {
+			return fmt.Errorf("Physical slot %v continues on page %v which is not a data page", ploc, page)
+		}
+
+		rest -= int64(recordSize - pageview.OffsetData)
+	}
+
+	return nil
+}
+
+/*
+pagesOfType returns the sorted ids of all pages of a given type.
+*/
+func pagesOfType(pages map[uint64]*checkPage, pagetype int16) []uint64 {
+	var ret []uint64
+
+	for id, p := range pages {
+		if p.pagetype == pagetype {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+
+	return ret
+}
+
+/*
+readLocations reads the locations which are stored in fixed size entries on a
+page. Unused entries contain 0.
+*/
+func readLocations(sf *file.StorageFile, page uint64, start int, entrySize int) ([]uint64, error) {
+
+	record, err := sf.Get(page)
+	if err != nil {
+		return nil, err
+	}
+
+	defer sf.ReleaseInUse(record)
+
+	var locs []uint64
+
+	for offset := start; offset+entrySize <= len(record.Data()); offset += entrySize {
+		locs = append(locs, record.ReadUInt64(offset))
+	}
+
+	return locs, nil
+}
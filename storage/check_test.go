@@ -0,0 +1,141 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+func TestCheck(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/check1", false, false, false, false)
+	defer dsm.Close()
+
+	var locs []uint64
+
+	for i := 0; i < 500; i++ {
+		loc, err := dsm.Insert(fmt.Sprint(i, strings.Repeat("x", 100+i*10)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	for i, loc := range locs {
+		if i%3 == 0 {
+			dsm.Free(loc)
+		}
+	}
+
+	dsm.Flush()
+
+	report, err := dsm.Check()
+
+	if err != nil || !report.Consistent() || report.Records != 333 ||
+		report.FreePhysicalSlots != 167 || report.FreeLogicalSlots == 0 || report.Pages == 0 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Two logical slots point to the same physical slot
+
+	ploc1, _ := dsm.logicalSlotManager.Fetch(locs[1])
+	ploc2, _ := dsm.logicalSlotManager.Fetch(locs[2])
+
+	dsm.logicalSlotManager.Update(locs[2], ploc1)
+
+	report, err = dsm.Check()
+
+	if err != nil || report.Problems != 1 || report.Examples[0] !=
+		fmt.Sprintf("Records %v and %v share the physical slot %v", locs[1], locs[2], ploc1) {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// A free physical slot is used by a record
+
+	record, _ := dsm.physicalFreeSlotsSf.Get(dsm.physicalFreeSlotsPager.First(view.TypeFreePhysicalSlotPage))
+	ploc0 := record.ReadUInt64(pageview.OffsetData)
+	dsm.physicalFreeSlotsSf.ReleaseInUse(record)
+
+	dsm.logicalSlotManager.Update(locs[2], ploc0)
+
+	report, err = dsm.Check()
+
+	if err != nil || report.Problems != 1 || report.Examples[0] !=
+		fmt.Sprintf("Free physical slot %v is used by record %v", ploc0, locs[2]) {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	dsm.logicalSlotManager.Update(locs[2], ploc2)
+
+	// Break the link between two data pages
+
+	page := util.LocationRecord(ploc1)
+
+	record, _ = dsm.physicalSlotsSf.Get(page)
+	next := record.ReadUInt64(view.OffsetNextPage)
+	record.WriteUInt64(view.OffsetNextPage, 0)
+	dsm.physicalSlotsSf.ReleaseInUseID(page, true)
+
+	report, err = dsm.Check()
+
+	if err != nil || report.Consistent() || !strings.HasSuffix(report.Examples[0],
+		fmt.Sprintf("data page list ends with page %v instead of %v", page,
+			dsm.physicalSlotsPager.Last(view.TypeDataPage))) ||
+		!strings.HasSuffix(report.Examples[1], fmt.Sprintf("Page %v is not in any page list", next)) {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	record, _ = dsm.physicalSlotsSf.Get(page)
+	record.WriteUInt64(view.OffsetNextPage, next)
+	dsm.physicalSlotsSf.ReleaseInUseID(page, true)
+
+	// Change the type of a page
+
+	record, _ = dsm.physicalSlotsSf.Get(next)
+	record.WriteInt16(0, view.ViewPageHeader+view.TypeTranslationPage)
+	dsm.physicalSlotsSf.ReleaseInUseID(next, true)
+
+	report, err = dsm.Check()
+
+	if err != nil || report.Consistent() || !strings.HasSuffix(report.Examples[0],
+		fmt.Sprintf("Page %v of type 2 is in the data page list", next)) {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	record, _ = dsm.physicalSlotsSf.Get(next)
+	record.WriteInt16(0, view.ViewPageHeader+view.TypeDataPage)
+	dsm.physicalSlotsSf.ReleaseInUseID(next, true)
+
+	if report, err = dsm.Check(); err != nil || !report.Consistent() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Check the cached storage manager
+
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	if report, err = cdsm.Check(); err != nil || !report.Consistent() || report.Records != 333 {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+}
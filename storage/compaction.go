@@ -0,0 +1,576 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krotik/common/errorutil"
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+FileSuffixCompaction is the file ending for the files of a compacted copy of
+a storage. A file with this ending and without further suffix marks that the
+compacted copy is complete and is replacing the original files.
+*/
+const FileSuffixCompaction = "compact"
+
+/*
+ErrCompacting is returned when a compaction is started on a storage which is
+already being compacted.
+*/
+var ErrCompacting = errors.New("Storage is already being compacted")
+
+/*
+ErrCompactionAborted is returned when a storage was closed while it was being
+compacted.
+*/
+var ErrCompactionAborted = errors.New("Storage was closed during compaction")
+
+/*
+ErrCompactionPending is returned when a compaction could not finish because
+a storage had unflushed changes for longer than CompactionMaxWait.
+*/
+var ErrCompactionPending = errors.New("Storage had pending changes for too long to finish compaction")
+
+/*
+CompactionMaxWait is the maximum time a compaction waits for the last changed
+locations to be copied. Once the time is exceeded all remaining locations are
+copied at once if there are no pending changes - otherwise the compaction
+fails.
+*/
+var CompactionMaxWait = time.Minute
+
+/*
+storageFileSuffixes are the file endings of all files of a storage manager.
+*/
+var storageFileSuffixes = []string{FileSuffixPhysicalSlots, FileSuffixPhysicalFreeSlots,
+	FileSuffixLogicalSlots, FileSuffixLogicalFreeSlots}
+
+/*
+CompactionProgress reports the number of processed storage locations and the
+total number of storage locations of a compaction.
+*/
+type CompactionProgress func(done uint64, total uint64)
+
+/*
+Compact reclaims the space of deleted and updated records. The storage stays
+online while it is compacted: all records are copied in batches into a new
+set of files - the storage is only locked while a batch is copied and the
+compaction pauses for the given duration between batches. Locations which
+are changed in the meantime are copied again. Once all records have been
+copied and there are no pending changes the new files replace the current
+files (see CompactionMaxWait). Logical storage locations do not change.
+Returns the number of bytes which were reclaimed.
+*/
+func (bdsm *ByteDiskStorageManager) Compact(batchSize int, pause time.Duration,
+	progress CompactionProgress) (int64, error) {
+
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return 0, ErrReadonly
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	bdsm.mutex.Lock()
+
+	if bdsm.compactDirty != nil {
+		bdsm.mutex.Unlock()
+		return 0, ErrCompacting
+	}
+
+	// Record all changed locations from here on
+
+	bdsm.compactDirty = make(map[uint64]bool)
+
+	bdsm.mutex.Unlock()
+
+	tmpname := fmt.Sprintf("%v.%v", bdsm.filename, FileSuffixCompaction)
+
+	target := &ByteDiskStorageManager{tmpname, false, bdsm.onlyAppend, true, &sync.Mutex{}, nil, nil,
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, false, false, nil, bdsm.backend}
+
+	// Remove files of a previous compaction which did not finish
+
+	err := bdsm.removeStorageFiles(tmpname)
+
+	if err == nil {
+		err = openFiles(target)
+	}
+
+	var reclaimed int64
+
+	if err == nil {
+		reclaimed, err = bdsm.compactInto(target, batchSize, pause, progress)
+	}
+
+	if err != nil {
+
+		// Stop recording changes and try to clean up
+
+		bdsm.mutex.Lock()
+		bdsm.compactDirty = nil
+		bdsm.mutex.Unlock()
+
+		if target.physicalSlotsSf != nil {
+			target.Close()
+		}
+
+		bdsm.removeStorageFiles(tmpname)
+
+		return 0, err
+	}
+
+	return reclaimed, nil
+}
+
+/*
+compactInto copies all records of this storage manager into a given target
+storage manager and replaces the files of this storage manager with the files
+of the target once all records have been copied.
+*/
+func (bdsm *ByteDiskStorageManager) compactInto(target *ByteDiskStorageManager,
+	batchSize int, pause time.Duration, progress CompactionProgress) (int64, error) {
+	var err error
+	var done, total uint64
+
+	bdsm.mutex.Lock()
+
+	page := bdsm.logicalSlotsPager.First(view.TypeTranslationPage)
+	elementsPerPage := uint64(bdsm.logicalSlotManager.ElementsPerPage())
+	total = bdsm.logicalSlotsPager.Last(view.TypeTranslationPage) * elementsPerPage
+
+	bdsm.mutex.Unlock()
+
+	// Copy all logical slots in batches
+
+	var i uint64
+
+	for page != 0 {
+
+		bdsm.mutex.Lock()
+
+		if bdsm.physicalSlotsSf == nil {
+			bdsm.mutex.Unlock()
+			return 0, ErrCompactionAborted
+		}
+
+		for n := 0; n < batchSize && page != 0 && err == nil; n++ {
+			offset := uint16(pageview.OffsetTransData) + uint16(i)*util.LocationSize
+
+			if err = bdsm.copyLocation(target, util.PackLocation(page, offset)); err == nil {
+				done++
+
+				if i++; i == elementsPerPage {
+					i = 0
+					page, err = bdsm.logicalSlotsPager.Next(page)
+				}
+			}
+		}
+
+		bdsm.mutex.Unlock()
+
+		if err == nil {
+			err = target.Flush()
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if done > total {
+			total = done
+		}
+
+		if progress != nil {
+			progress(done, total)
+		}
+
+		time.Sleep(pause)
+	}
+
+	// Copy all locations which were changed in the meantime. Changed
+	// locations are only copied if there are no pending changes which
+	// might still be rolled back.
+
+	deadline := time.Now().Add(CompactionMaxWait)
+
+	for {
+		bdsm.mutex.Lock()
+
+		if bdsm.physicalSlotsSf == nil {
+			bdsm.mutex.Unlock()
+			return 0, ErrCompactionAborted
+		}
+
+		overdue := time.Now().After(deadline)
+
+		if !bdsm.pending {
+
+			// Copy all remaining locations at once if the changes keep
+			// coming in faster than they can be copied in batches
+
+			limit := batchSize
+			if overdue {
+				limit = len(bdsm.compactDirty)
+			}
+
+			n := 0
+			for loc := range bdsm.compactDirty {
+				if n == limit {
+					break
+				}
+
+				delete(bdsm.compactDirty, loc)
+
+				if err = bdsm.copyLocation(target, loc); err != nil {
+					break
+				}
+
+				n++
+			}
+
+			if err == nil && len(bdsm.compactDirty) == 0 {
+
+				// All records have been copied - replace the files
+
+				reclaimed, err := bdsm.replaceFiles(target)
+
+				if err == nil {
+					bdsm.compactDirty = nil
+				}
+
+				bdsm.mutex.Unlock()
+
+				return reclaimed, err
+			}
+
+		} else if overdue {
+			bdsm.mutex.Unlock()
+			return 0, ErrCompactionPending
+		}
+
+		bdsm.mutex.Unlock()
+
+		if err == nil {
+			err = target.Flush()
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		// Wait at least a little bit for pending changes to be flushed
+
+		if pause < time.Millisecond {
+			time.Sleep(time.Millisecond)
+		} else {
+			time.Sleep(pause)
+		}
+	}
+}
+
+/*
+copyLocation copies the record of a given logical location into a given target
+storage manager. The record is removed from the target if it no longer exists.
+Assumes that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) copyLocation(target *ByteDiskStorageManager, loc uint64) error {
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	}
+
+	tploc, err := target.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	}
+
+	if ploc == 0 {
+
+		// Remove a record which has been freed in the meantime
+
+		if tploc != 0 {
+			if err = target.physicalSlotManager.Free(tploc); err == nil {
+				err = target.logicalSlotManager.Free(loc)
+			}
+		}
+
+		return err
+	}
+
+	var b bytes.Buffer
+
+	if err = bdsm.physicalSlotManager.Fetch(ploc, &b); err != nil {
+		return err
+	}
+
+	data := b.Bytes()
+
+	if tploc == 0 {
+
+		if tploc, err = target.physicalSlotManager.Insert(data, 0, uint32(len(data))); err == nil {
+			err = target.logicalSlotManager.ForceInsert(loc, tploc)
+		}
+
+		return err
+	}
+
+	newTploc, err := target.physicalSlotManager.Update(tploc, data, 0, uint32(len(data)))
+
+	if err == nil && newTploc != tploc {
+		err = target.logicalSlotManager.Update(loc, newTploc)
+	}
+
+	return err
+}
+
+/*
+replaceFiles replaces the files of this storage manager with the files of a
+given target storage manager. Assumes that the caller holds the mutex and
+that there are no pending changes. Returns the number of reclaimed bytes.
+*/
+func (bdsm *ByteDiskStorageManager) replaceFiles(target *ByteDiskStorageManager) (int64, error) {
+
+	// Copy the root values and make unused logical slots available again
+
+	header := bdsm.physicalSlotsPager.Header()
+
+	for i := 0; i < header.Roots(); i++ {
+		target.physicalSlotsPager.Header().SetRoot(i, header.Root(i))
+	}
+
+	if err := target.logicalSlotManager.FreeUnused(); err != nil {
+		return 0, err
+	}
+
+	if err := target.Flush(); err != nil {
+		return 0, err
+	}
+
+	if err := target.Close(); err != nil {
+		return 0, err
+	}
+
+	// Write the list of compacted files into the marker file - from here
+	// on the compacted files will replace the current files even if the
+	// process is interrupted
+
+	var files []string
+
+	for _, suffix := range storageFileSuffixes {
+		tmpfiles, err := bdsm.backend.Glob(fmt.Sprintf("%v.%v.*", target.filename, suffix))
+		if err != nil {
+			return 0, err
+		}
+
+		for _, tmpfile := range tmpfiles {
+			files = append(files, strings.TrimPrefix(tmpfile, target.filename+"."))
+		}
+	}
+
+	if err := bdsm.closeFiles(); err != nil {
+		return 0, bdsm.reopenFiles(err)
+	}
+
+	sizeBefore := bdsm.storageFilesSize(bdsm.filename)
+
+	if err := file.WriteFile(bdsm.backend, target.filename, []byte(strings.Join(files, "\n"))); err != nil {
+
+		// The original files are still in place - make sure an incomplete
+		// marker file does not replace them later
+
+		bdsm.backend.Remove(target.filename)
+
+		return 0, bdsm.reopenFiles(err)
+	}
+
+	// From here on the compacted files replace the current files - try
+	// once more if the replacement was interrupted
+
+	err := bdsm.finishCompaction(bdsm.filename)
+
+	if err != nil {
+		err = bdsm.finishCompaction(bdsm.filename)
+	}
+
+	if err == nil {
+		err = openFiles(bdsm)
+	}
+
+	if err != nil {
+		return 0, bdsm.reopenFiles(err)
+	}
+
+	for _, sf := range bdsm.storageFiles() {
+		sf.SetDeferSync(bdsm.deferSync)
+	}
+
+	return sizeBefore - bdsm.storageFilesSize(bdsm.filename), nil
+}
+
+/*
+reopenFiles reopens the files of this storage manager after replacing its
+files failed. A replacement which has already started is finished first. The
+storage stays closed and its lockfile is released if its files cannot be
+opened. Returns the error which caused the replacement to fail and any error
+which occurred while reopening the files. Assumes that the caller holds the
+mutex.
+*/
+func (bdsm *ByteDiskStorageManager) reopenFiles(cause error) error {
+
+	// Close all files which are still open
+
+	bdsm.closeFiles()
+
+	err := bdsm.finishCompaction(bdsm.filename)
+
+	if err == nil {
+		err = openFiles(bdsm)
+	}
+
+	if err != nil {
+		bdsm.closeFiles()
+
+		// Release the lockfile so the storage can be opened again
+
+		if bdsm.lockfile != nil {
+			bdsm.lockfile.Finish()
+		}
+
+		ce := errorutil.NewCompositeError()
+		ce.Add(cause)
+		ce.Add(err)
+
+		return ce
+	}
+
+	for _, sf := range bdsm.storageFiles() {
+		sf.SetDeferSync(bdsm.deferSync)
+	}
+
+	return cause
+}
+
+/*
+finishCompaction replaces the files of a storage with the files of a
+compacted copy if the copy is complete. The files of an incomplete copy are
+removed. This operation can be repeated if it was interrupted.
+*/
+func (bdsm *ByteDiskStorageManager) finishCompaction(filename string) error {
+	tmpname := fmt.Sprintf("%v.%v", filename, FileSuffixCompaction)
+
+	if ok, _ := bdsm.backend.Exists(tmpname); !ok {
+		return bdsm.removeStorageFiles(tmpname)
+	}
+
+	// Read the list of files of the compacted copy
+
+	content, err := file.ReadFile(bdsm.backend, tmpname)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]bool)
+
+	for _, f := range strings.Split(string(content), "\n") {
+		if f != "" {
+			files[fmt.Sprintf("%v.%v", filename, f)] = true
+		}
+	}
+
+	// Move the remaining files of the copy into place
+
+	for f := range files {
+		tmpfile := tmpname + strings.TrimPrefix(f, filename)
+
+		if ok, _ := bdsm.backend.Exists(tmpfile); ok {
+			if err := bdsm.backend.Rename(tmpfile, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove all files which are not part of the copy (e.g. transaction logs)
+
+	for _, suffix := range storageFileSuffixes {
+		oldfiles, err := bdsm.backend.Glob(fmt.Sprintf("%v.%v.*", filename, suffix))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range oldfiles {
+			if !files[f] {
+				if err := bdsm.backend.Remove(f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return bdsm.backend.Remove(tmpname)
+}
+
+/*
+removeStorageFiles removes all files of a storage.
+*/
+func (bdsm *ByteDiskStorageManager) removeStorageFiles(filename string) error {
+
+	for _, suffix := range storageFileSuffixes {
+		files, err := bdsm.backend.Glob(fmt.Sprintf("%v.%v.*", filename, suffix))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			if err := bdsm.backend.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+storageFilesSize returns the size of all files of a storage in bytes.
+*/
+func (bdsm *ByteDiskStorageManager) storageFilesSize(filename string) int64 {
+	var size int64
+
+	for _, suffix := range storageFileSuffixes {
+		files, _ := bdsm.backend.Glob(fmt.Sprintf("%v.%v.*", filename, suffix))
+
+		for _, f := range files {
+			if fsize, err := bdsm.backend.Size(f); err == nil {
+				size += fsize
+			}
+		}
+	}
+
+	return size
+}
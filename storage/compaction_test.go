@@ -0,0 +1,374 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+func TestCompaction(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/compact1", false, false, false, false)
+
+	// Insert a lot of data and delete most of it again
+
+	var locs []uint64
+
+	for i := 0; i < 2000; i++ {
+		loc, err := dsm.Insert(fmt.Sprint(i, strings.Repeat("x", 200)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	dsm.SetRoot(5, 42)
+	dsm.Flush()
+
+	var kept []uint64
+
+	for i, loc := range locs {
+		if i%10 == 0 {
+			kept = append(kept, loc)
+		} else if err := dsm.Free(loc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	dsm.Flush()
+
+	sizeBefore := dsm.storageFilesSize(dsm.filename)
+
+	// Change records while the compaction is running
+
+	var updates int
+
+	reclaimed, err := dsm.Compact(50, 0, func(done uint64, total uint64) {
+		if done > total {
+			t.Error("Unexpected progress:", done, total)
+		}
+
+		if updates < 3 {
+			dsm.Update(kept[updates], fmt.Sprint("updated", updates))
+			dsm.Flush()
+		}
+
+		if updates == 3 {
+			dsm.Free(kept[3])
+			dsm.Flush()
+		}
+
+		if updates == 4 {
+
+			// Changes which are rolled back are not copied
+
+			dsm.Update(kept[4], "rolledback")
+			dsm.Rollback()
+		}
+
+		updates++
+	})
+
+	if err != nil || reclaimed <= 0 || dsm.storageFilesSize(dsm.filename) >= sizeBefore {
+		t.Error("Unexpected result:", reclaimed, err)
+		return
+	}
+
+	if ok, _ := fileutil.PathExists(DBDIR + "/compact1.compact"); ok {
+		t.Error("Marker file should have been removed")
+		return
+	}
+
+	check := func() {
+		var res string
+
+		for i, loc := range kept {
+			exp := fmt.Sprint(i*10, strings.Repeat("x", 200))
+
+			if i < 3 {
+				exp = fmt.Sprint("updated", i)
+			}
+
+			err := dsm.Fetch(loc, &res)
+
+			if i == 3 {
+				if err == nil {
+					t.Error("Freed location should not exist:", res)
+				}
+			} else if err != nil || res != exp {
+				t.Error("Unexpected result:", i, res, err)
+				return
+			}
+		}
+
+		if dsm.Root(5) != 42 {
+			t.Error("Unexpected root:", dsm.Root(5))
+		}
+	}
+
+	check()
+
+	// Reclaimed logical slots are used again
+
+	loc, err := dsm.Insert("new")
+	if err != nil || loc > kept[len(kept)-1] {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	dsm.Free(loc)
+
+	// Data is still available after reopening the storage
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/compact1", false, false, false, false)
+
+	check()
+
+	// Readonly storages cannot be compacted
+
+	dsm.Close()
+
+	dsm = NewDiskStorageManager(DBDIR+"/compact1", true, false, false, false)
+
+	if _, err := dsm.Compact(10, 0, nil); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.Close()
+}
+
+func TestCompactionRecovery(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/compact2", false, false, false, false)
+
+	loc, _ := dsm.Insert("test")
+	dsm.Close()
+
+	// Incomplete copies are removed
+
+	ioutil.WriteFile(DBDIR+"/compact2.compact.db.0", []byte("foo"), 0660)
+
+	dsm = NewDiskStorageManager(DBDIR+"/compact2", false, false, false, false)
+
+	if ok, _ := fileutil.PathExists(DBDIR + "/compact2.compact.db.0"); ok {
+		t.Error("Incomplete copy should have been removed")
+		return
+	}
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Simulate an interrupted replacement of the files - the files of the
+	// compacted copy are moved into place when the storage is opened
+
+	target := &ByteDiskStorageManager{DBDIR + "/compact2.compact", false, false, true, &sync.Mutex{}, nil, nil,
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, false, false, nil, &file.OSBackend{}}
+
+	openFiles(target)
+
+	dsm.mutex.Lock()
+	err := dsm.copyLocation(target, loc)
+	dsm.mutex.Unlock()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	target.Update(loc, []byte("copy"))
+	target.Close()
+	dsm.Close()
+
+	ioutil.WriteFile(DBDIR+"/compact2.compact", []byte("db.0\ndbf.0\nix.0\nixf.0"), 0660)
+
+	dsm = NewDiskStorageManager(DBDIR+"/compact2", false, false, false, false)
+
+	var b bytes.Buffer
+
+	if err := dsm.ByteDiskStorageManager.Fetch(loc, &b); err != nil || b.String() != "copy" {
+		t.Error("Unexpected result:", b.String(), err)
+		return
+	}
+
+	if ok, _ := fileutil.PathExists(DBDIR + "/compact2.compact"); ok {
+		t.Error("Marker file should have been removed")
+		return
+	}
+
+	dsm.Close()
+}
+
+/*
+failingBackend is a local storage backend which fails certain operations.
+*/
+type failingBackend struct {
+	file.OSBackend
+	failOpen   func(name string, flag int) bool
+	failRename func(oldname string) bool
+}
+
+func (b *failingBackend) OpenFile(name string, flag int, perm os.FileMode) (file.BackendFile, error) {
+	if b.failOpen != nil && b.failOpen(name, flag) {
+		return nil, errors.New("Injected open error")
+	}
+	return b.OSBackend.OpenFile(name, flag, perm)
+}
+
+func (b *failingBackend) Rename(oldname string, newname string) error {
+	if b.failRename != nil && b.failRename(oldname) {
+		return errors.New("Injected rename error")
+	}
+	return b.OSBackend.Rename(oldname, newname)
+}
+
+func TestCompactionFailure(t *testing.T) {
+	backend := &failingBackend{}
+
+	dsm := NewDiskStorageManagerWithBackend(DBDIR+"/compact3", false, false, false, false, backend)
+
+	var locs []uint64
+
+	for i := 0; i < 100; i++ {
+		loc, _ := dsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	dsm.Flush()
+
+	checkRecords := func(dsm *DiskStorageManager) {
+		t.Helper()
+
+		for i, loc := range locs {
+			var res string
+
+			if err := dsm.Fetch(loc, &res); err != nil || res != fmt.Sprint("test", i) {
+				t.Error("Unexpected result:", res, err)
+				return
+			}
+		}
+	}
+
+	// The original files are reopened if the marker file cannot be written
+
+	backend.failOpen = func(name string, flag int) bool {
+		return name == DBDIR+"/compact3.compact" && flag&os.O_TRUNC != 0
+	}
+
+	if _, err := dsm.Compact(10, 0, nil); err == nil || err.Error() != "Injected open error" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	backend.failOpen = nil
+
+	checkRecords(dsm)
+
+	if ok, _ := fileutil.PathExists(DBDIR + "/compact3.compact"); ok {
+		t.Error("Marker file should have been removed")
+		return
+	}
+
+	// An interrupted replacement is tried once more
+
+	renames := 0
+
+	backend.failRename = func(oldname string) bool {
+		renames++
+		return renames == 1
+	}
+
+	if _, err := dsm.Compact(10, 0, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRecords(dsm)
+
+	// The storage stays closed if the replacement cannot be finished - it
+	// is finished when the storage is opened again
+
+	backend.failRename = func(oldname string) bool {
+		return true
+	}
+
+	if _, err := dsm.Compact(10, 0, nil); err == nil || !strings.Contains(err.Error(), "Injected rename error") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if dsm.physicalSlotsSf != nil {
+		t.Error("Storage should be closed")
+		return
+	}
+
+	backend.failRename = nil
+
+	dsm = NewDiskStorageManagerWithBackend(DBDIR+"/compact3", false, false, false, false, backend)
+
+	checkRecords(dsm)
+
+	// Compaction fails if there are pending changes for too long
+
+	oldMaxWait := CompactionMaxWait
+	defer func() {
+		CompactionMaxWait = oldMaxWait
+	}()
+
+	CompactionMaxWait = 10 * time.Millisecond
+
+	dsm.Insert("pending")
+
+	if _, err := dsm.Compact(10, 0, nil); err != ErrCompactionPending {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.Flush()
+
+	// Locations which are changed during compaction are copied at once
+	// once the maximum wait time is exceeded
+
+	CompactionMaxWait = 0
+
+	if _, err := dsm.Compact(10, 0, func(done uint64, total uint64) {
+		dsm.Update(locs[0], "test0")
+		dsm.Update(locs[1], "test1")
+		dsm.Flush()
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRecords(dsm)
+
+	dsm.Close()
+}
@@ -0,0 +1,94 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/krotik/eliasdb/codec"
+)
+
+/*
+CompressionNone is the name of the compression which leaves records as they are.
+Records are compressed with the codecs of the codec registry.
+*/
+const CompressionNone = codec.None
+
+/*
+compressionMarker is the first byte of a compressed record. A gob stream never
+starts with a zero byte so compressed and uncompressed records can be stored
+side by side. The second byte of a compressed record is the codec ID.
+*/
+const compressionMarker = codec.StreamMarker
+
+/*
+CompressionMinSize is the minimum size of a record in bytes before compression
+is attempted. Records are also stored uncompressed if compression does not
+make them smaller.
+*/
+var CompressionMinSize = 64
+
+/*
+compressRecord compresses a record with a given codec. The record is
+returned unchanged if it is too small or if it does not get smaller.
+*/
+func compressRecord(c codec.Codec, data []byte) ([]byte, error) {
+
+	if c == nil || len(data) < CompressionMinSize {
+		return data, nil
+	}
+
+	var b bytes.Buffer
+
+	b.Grow(len(data) / 2)
+	b.Write([]byte{compressionMarker, c.ID()})
+
+	w, err := c.NewWriter(&b)
+
+	if err == nil {
+		if _, err = w.Write(data); err == nil {
+			err = w.Close()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Len() >= len(data) {
+		return data, nil
+	}
+
+	return b.Bytes(), nil
+}
+
+/*
+decompressRecord returns a reader for the uncompressed data of a record.
+Uncompressed records are returned as they are.
+*/
+func decompressRecord(bb *bytes.Buffer) (io.Reader, error) {
+
+	data := bb.Bytes()
+
+	if len(data) < 2 || data[0] != compressionMarker {
+		return bb, nil
+	}
+
+	c, err := codec.ByID(data[1])
+
+	if err != nil {
+		return nil, fmt.Errorf("Unknown compressor ID: %v", data[1])
+	}
+
+	return c.NewReader(bytes.NewReader(data[2:]))
+}
@@ -0,0 +1,98 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/compress1", false, false, false, false)
+	defer dsm.Close()
+
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+
+	// Records which were stored before compression was enabled stay readable
+
+	loc1, _ := dsm.Insert(text)
+
+	if err := dsm.SetCompression("foo"); err == nil || err.Error() != "Unknown compression: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.SetCompression("flate"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc2, _ := dsm.Insert(text)
+	loc3, _ := dsm.Insert("short")
+
+	var b1, b2, b3 bytes.Buffer
+
+	dsm.ByteDiskStorageManager.Fetch(loc1, &b1)
+	dsm.ByteDiskStorageManager.Fetch(loc2, &b2)
+	dsm.ByteDiskStorageManager.Fetch(loc3, &b3)
+
+	if b2.Len()*10 > b1.Len() || b2.Bytes()[0] != compressionMarker || b2.Bytes()[1] != 1 {
+		t.Error("Record should have been compressed:", b1.Len(), b2.Len())
+		return
+	}
+
+	// Small records are not compressed
+
+	if b3.Bytes()[0] == compressionMarker {
+		t.Error("Record should not have been compressed")
+		return
+	}
+
+	var res string
+
+	for _, loc := range []uint64{loc1, loc2} {
+		if err := dsm.Fetch(loc, &res); err != nil || res != text {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	if err := dsm.Fetch(loc3, &res); err != nil || res != "short" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Compressed records stay readable if compression is switched off
+
+	dsm.Update(loc3, text)
+	dsm.SetCompression(CompressionNone)
+	dsm.Update(loc1, text+"x")
+
+	if err := dsm.Fetch(loc3, &res); err != nil || res != text {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Fetch(loc1, &res); err != nil || res != text+"x" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Records with an unknown compressor cannot be read
+
+	dsm.ByteDiskStorageManager.Update(loc2, []byte{compressionMarker, 99, 1, 2, 3})
+
+	if err := dsm.Fetch(loc2, &res); err == nil || err.Error() != "Unknown compressor ID: 99" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
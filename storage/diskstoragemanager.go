@@ -20,8 +20,8 @@ import (
 	"time"
 
 	"github.com/krotik/common/errorutil"
-	"github.com/krotik/common/fileutil"
 	"github.com/krotik/common/lockutil"
+	"github.com/krotik/eliasdb/codec"
 	"github.com/krotik/eliasdb/storage/file"
 	"github.com/krotik/eliasdb/storage/paging"
 	"github.com/krotik/eliasdb/storage/slotting"
@@ -86,6 +86,8 @@ DiskStorageManager is a storage manager which can store any gob serializable dat
 */
 type DiskStorageManager struct {
 	*ByteDiskStorageManager
+	compressor codec.Codec // Codec for new records (nil for no compression)
+	encryption *Encryption // Key ring for the encryption of records (nil for no encryption)
 }
 
 /*
@@ -98,8 +100,56 @@ transactions.
 func NewDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	transDisabled bool, lockfileDisabled bool) *DiskStorageManager {
 
-	return &DiskStorageManager{NewByteDiskStorageManager(filename, readonly,
-		onlyAppend, transDisabled, lockfileDisabled)}
+	return NewDiskStorageManagerWithBackend(filename, readonly, onlyAppend,
+		transDisabled, lockfileDisabled, &file.OSBackend{})
+}
+
+/*
+NewDiskStorageManagerWithBackend creates a new disk storage manager whose
+files are stored in a given storage backend.
+*/
+func NewDiskStorageManagerWithBackend(filename string, readonly bool, onlyAppend bool,
+	transDisabled bool, lockfileDisabled bool, backend file.StorageBackend) *DiskStorageManager {
+
+	return &DiskStorageManager{NewByteDiskStorageManagerWithBackend(filename, readonly,
+		onlyAppend, transDisabled, lockfileDisabled, backend), nil, nil}
+}
+
+/*
+SetCompression sets the compression (e.g. flate or gzip:9) which is used for records
+which are inserted or updated from now on. Existing records are read
+regardless of their compression. Compression is switched off with the name
+none.
+*/
+func (dsm *DiskStorageManager) SetCompression(name string) error {
+
+	c, err := codec.Get(name)
+
+	if err == nil {
+		dsm.compressor = c
+	}
+
+	return err
+}
+
+/*
+SetEncryption sets the key ring which is used to encrypt records which are
+inserted or updated from now on. Encrypted records can only be read if the key
+ring contains their key. Encryption is switched off with nil.
+*/
+func (dsm *DiskStorageManager) SetEncryption(e *Encryption) {
+	dsm.encryption = e
+}
+
+/*
+Reencrypt rewrites all records which are not encrypted with the active key
+of the key ring of this storage manager.
+*/
+func (dsm *DiskStorageManager) Reencrypt(batchSize int, pause time.Duration) (int, error) {
+	if dsm.encryption == nil {
+		return 0, errors.New("Encryption is not enabled")
+	}
+	return dsm.ByteDiskStorageManager.Reencrypt(dsm.encryption, batchSize, pause)
 }
 
 /*
@@ -129,7 +179,7 @@ func (dsm *DiskStorageManager) Serialize(o interface{}) ([]byte, error) {
 		return nil, err
 	}
 
-	return bb.Bytes(), nil
+	return compressRecord(dsm.compressor, bb.Bytes())
 }
 
 /*
@@ -143,6 +193,15 @@ func (dsm *DiskStorageManager) Insert(o interface{}) (uint64, error) {
 		return 0, err
 	}
 
+	if e := dsm.encryption; e != nil {
+
+		// Encrypted records are bound to their location
+
+		return dsm.ByteDiskStorageManager.insertSealed(b, func(data []byte, loc uint64) ([]byte, error) {
+			return e.encrypt(data, loc)
+		})
+	}
+
 	return dsm.ByteDiskStorageManager.Insert(b)
 }
 
@@ -153,6 +212,10 @@ func (dsm *DiskStorageManager) Update(loc uint64, o interface{}) error {
 
 	b, err := dsm.Serialize(o)
 
+	if err == nil {
+		b, err = encryptRecord(dsm.encryption, b, loc)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -178,9 +241,19 @@ func (dsm *DiskStorageManager) Fetch(loc uint64, o interface{}) error {
 		return err
 	}
 
+	plain, err := decryptRecord(dsm.encryption, bb, loc)
+	if err != nil {
+		return err
+	}
+
+	r, err := decompressRecord(plain)
+	if err != nil {
+		return err
+	}
+
 	//  Deserialize the object from a gob bytes stream
 
-	return gob.NewDecoder(bb).Decode(o)
+	return gob.NewDecoder(r).Decode(o)
 }
 
 /*
@@ -208,6 +281,12 @@ type ByteDiskStorageManager struct {
 	logicalSlotManager *slotting.LogicalSlotManager // Manager for physical slots
 
 	lockfile *lockutil.LockFile // Lockfile manager
+
+	deferSync    bool            // Flag if syncing the transaction log is deferred
+	pending      bool            // Flag if there are changes which were not yet flushed
+	compactDirty map[uint64]bool // Locations which were changed during a running compaction
+
+	backend file.StorageBackend // Backend which stores the physical files
 }
 
 /*
@@ -220,6 +299,18 @@ manager will not support transactions.
 func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	transDisabled bool, lockfileDisabled bool) *ByteDiskStorageManager {
 
+	return NewByteDiskStorageManagerWithBackend(filename, readonly, onlyAppend,
+		transDisabled, lockfileDisabled, &file.OSBackend{})
+}
+
+/*
+NewByteDiskStorageManagerWithBackend creates a new disk storage manager which
+can only store byte slices and whose files are stored in a given storage
+backend.
+*/
+func NewByteDiskStorageManagerWithBackend(filename string, readonly bool, onlyAppend bool,
+	transDisabled bool, lockfileDisabled bool, backend file.StorageBackend) *ByteDiskStorageManager {
+
 	var lf *lockutil.LockFile
 
 	// Create a lockfile which is checked every 50 milliseconds
@@ -230,7 +321,7 @@ func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	}
 
 	bdsm := &ByteDiskStorageManager{filename, readonly, onlyAppend, transDisabled, &sync.Mutex{}, nil, nil,
-		nil, nil, nil, nil, nil, nil, nil, nil, lf}
+		nil, nil, nil, nil, nil, nil, nil, nil, lf, false, false, nil, backend}
 
 	err := initByteDiskStorageManager(bdsm)
 	if err != nil {
@@ -241,10 +332,11 @@ func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 }
 
 /*
-DataFileExist checks if the main datastore file exists.
+DataFileExist checks if the main datastore file exists in a given storage
+backend.
 */
-func DataFileExist(filename string) bool {
-	ret, err := fileutil.PathExists(fmt.Sprintf("%v.%v.0", filename,
+func DataFileExist(backend file.StorageBackend, filename string) bool {
+	ret, err := backend.Exists(fmt.Sprintf("%v.%v.0", filename,
 		FileSuffixPhysicalSlots))
 
 	if err != nil {
@@ -288,6 +380,7 @@ func (bdsm *ByteDiskStorageManager) SetRoot(root int, val uint64) {
 
 	bdsm.checkFileOpen()
 	bdsm.physicalSlotsPager.Header().SetRoot(root, val)
+	bdsm.pending = true
 }
 
 /*
@@ -307,6 +400,8 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 	bdsm.mutex.Lock()
 	defer bdsm.mutex.Unlock()
 
+	bdsm.pending = true
+
 	// Store the data in a physical slot
 
 	b := o.([]byte)
@@ -323,9 +418,69 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 		return 0, err
 	}
 
+	bdsm.markChanged(loc)
+
 	return loc, nil
 }
 
+/*
+insertSealed inserts a record which is sealed for its logical storage location
+by a given function. The record is sealed once the location is known - the
+sealed record must have the same size for all locations.
+*/
+func (bdsm *ByteDiskStorageManager) insertSealed(data []byte,
+	seal func(data []byte, loc uint64) ([]byte, error)) (uint64, error) {
+
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return 0, ErrReadonly
+	}
+
+	// Seal the record for a preliminary location to get its size
+
+	b, err := seal(data, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	bdsm.pending = true
+
+	ploc, err := bdsm.physicalSlotManager.Insert(b, 0, uint32(len(b)))
+	if err != nil {
+		return 0, err
+	}
+
+	loc, err := bdsm.logicalSlotManager.Insert(ploc)
+	if err != nil {
+		return 0, err
+	}
+
+	bdsm.markChanged(loc)
+
+	// Seal the record for its actual location and overwrite the physical
+	// slot (the size does not change so the record stays in place)
+
+	if b, err = seal(data, loc); err == nil {
+		var newPloc uint64
+
+		newPloc, err = bdsm.physicalSlotManager.Update(ploc, b, 0, uint32(len(b)))
+
+		if err == nil && newPloc != ploc {
+			err = bdsm.logicalSlotManager.Update(loc, newPloc)
+		}
+	}
+
+	return loc, err
+}
+
 /*
 Update updates a storage location.
 */
@@ -357,6 +512,8 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 	bdsm.mutex.Lock()
 	defer bdsm.mutex.Unlock()
 
+	bdsm.markChanged(loc)
+
 	// Update the physical record
 
 	b := o.([]byte)
@@ -440,6 +597,8 @@ func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
 	bdsm.mutex.Lock()
 	defer bdsm.mutex.Unlock()
 
+	bdsm.markChanged(loc)
+
 	// Get the physical slot for the given logical slot
 
 	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
@@ -517,9 +676,84 @@ func (bdsm *ByteDiskStorageManager) Flush() error {
 		return ce
 	}
 
+	bdsm.pending = false
+
 	return nil
 }
 
+/*
+Sync writes all pending changes to disk and makes sure that all written data
+has been committed to stable storage. The transaction log is usually already
+synced on every flush - this call additionally syncs the physical storage files.
+*/
+func (bdsm *ByteDiskStorageManager) Sync() error {
+
+	if err := bdsm.Flush(); err != nil || bdsm.readonly {
+		return err
+	}
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	for _, sf := range bdsm.storageFiles() {
+		sf.SyncLog()
+		sf.Sync()
+	}
+
+	return nil
+}
+
+/*
+SetDeferSync sets if syncing the transaction log on every flush should be
+deferred. Flushed changes are only durable after SyncLog has been called.
+*/
+func (bdsm *ByteDiskStorageManager) SetDeferSync(deferSync bool) {
+	bdsm.checkFileOpen()
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	bdsm.deferSync = deferSync
+
+	for _, sf := range bdsm.storageFiles() {
+		sf.SetDeferSync(deferSync)
+	}
+}
+
+/*
+SyncLog makes sure that all flushed changes have been committed to stable
+storage. Pending changes are not flushed.
+*/
+func (bdsm *ByteDiskStorageManager) SyncLog() error {
+
+	if bdsm.readonly {
+		return nil
+	}
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	// A closed storage has already been synced
+
+	if bdsm.physicalSlotsSf == nil {
+		return nil
+	}
+
+	for _, sf := range bdsm.storageFiles() {
+		sf.SyncLog()
+	}
+
+	return nil
+}
+
+/*
+storageFiles returns all storage files of this storage manager.
+*/
+func (bdsm *ByteDiskStorageManager) storageFiles() []*file.StorageFile {
+	return []*file.StorageFile{bdsm.physicalSlotsSf, bdsm.physicalFreeSlotsSf,
+		bdsm.logicalSlotsSf, bdsm.logicalFreeSlotsSf}
+}
+
 /*
 Rollback cancels all pending changes which have not yet been written to disk.
 */
@@ -574,6 +808,8 @@ func (bdsm *ByteDiskStorageManager) Rollback() error {
 		return ce
 	}
 
+	bdsm.pending = false
+
 	return nil
 }
 
@@ -583,26 +819,40 @@ Close closes the StorageManager and write all pending changes to disk.
 func (bdsm *ByteDiskStorageManager) Close() error {
 	bdsm.checkFileOpen()
 
-	ce := errorutil.NewCompositeError()
-
 	// Continue single threaded from here on
 
 	bdsm.mutex.Lock()
 	defer bdsm.mutex.Unlock()
 
-	// Try to close all files and collect any errors which are returned
-
-	if err := bdsm.physicalSlotsPager.Close(); err != nil {
-		ce.Add(err)
-	}
-	if err := bdsm.physicalFreeSlotsPager.Close(); err != nil {
-		ce.Add(err)
+	if err := bdsm.closeFiles(); err != nil {
+		return err
 	}
-	if err := bdsm.logicalSlotsPager.Close(); err != nil {
-		ce.Add(err)
+
+	if bdsm.lockfile != nil {
+		return bdsm.lockfile.Finish()
 	}
-	if err := bdsm.logicalFreeSlotsPager.Close(); err != nil {
-		ce.Add(err)
+
+	return nil
+}
+
+/*
+closeFiles closes all files of this storage manager. Assumes that the caller
+holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) closeFiles() error {
+	ce := errorutil.NewCompositeError()
+
+	// Try to close all files and collect any errors which are returned -
+	// files which were not opened are skipped
+
+	for _, pager := range []*paging.PagedStorageFile{bdsm.physicalSlotsPager,
+		bdsm.physicalFreeSlotsPager, bdsm.logicalSlotsPager, bdsm.logicalFreeSlotsPager} {
+
+		if pager != nil {
+			if err := pager.Close(); err != nil {
+				ce.Add(err)
+			}
+		}
 	}
 
 	// Return errors if there were any
@@ -624,13 +874,21 @@ func (bdsm *ByteDiskStorageManager) Close() error {
 	bdsm.logicalFreeSlotsPager = nil
 	bdsm.logicalSlotManager = nil
 
-	if bdsm.lockfile != nil {
-		return bdsm.lockfile.Finish()
-	}
-
 	return nil
 }
 
+/*
+markChanged records that a given location has been changed. Assumes that the
+caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) markChanged(loc uint64) {
+	bdsm.pending = true
+
+	if bdsm.compactDirty != nil {
+		bdsm.compactDirty[loc] = true
+	}
+}
+
 /*
 checkFileOpen checks that the files on disk are still open.
 */
@@ -659,6 +917,57 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 		}
 	}
 
+	// Finish a compaction which was interrupted while replacing the files
+
+	err := bdsm.finishCompaction(bdsm.filename)
+
+	if err == nil {
+		err = openFiles(bdsm)
+	}
+
+	// If there were any file related errors return at this point
+
+	if err != nil {
+
+		// Release the lockfile if there were errors
+
+		if bdsm.lockfile != nil {
+			bdsm.lockfile.Finish()
+		}
+
+		return err
+	}
+
+	// Check version
+
+	version := bdsm.Root(RootIDVersion)
+	if version > VERSION {
+
+		// Try to clean up
+
+		bdsm.Close()
+
+		panic(fmt.Sprint("Cannot open datastore ", bdsm.filename, " - version of disk files is "+
+			"newer than supported version. Supported version:", VERSION,
+			" Disk files version:", version))
+	}
+
+	if version != VERSION {
+		bdsm.SetRoot(RootIDVersion, VERSION)
+
+		// The version update does not need to be protected from a rollback
+
+		bdsm.pending = false
+	}
+
+	return nil
+}
+
+/*
+openFiles opens all files of a given ByteDiskStorageManager.
+*/
+func openFiles(bdsm *ByteDiskStorageManager) error {
+
 	// Try to open all files and collect all errors
 
 	ce := errorutil.NewCompositeError()
@@ -717,37 +1026,10 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 			bdsm.logicalFreeSlotsPager)
 	}
 
-	// If there were any file related errors return at this point
-
 	if ce.HasErrors() {
-
-		// Release the lockfile if there were errors
-
-		if bdsm.lockfile != nil {
-			bdsm.lockfile.Finish()
-		}
-
 		return ce
 	}
 
-	// Check version
-
-	version := bdsm.Root(RootIDVersion)
-	if version > VERSION {
-
-		// Try to clean up
-
-		bdsm.Close()
-
-		panic(fmt.Sprint("Cannot open datastore ", bdsm.filename, " - version of disk files is "+
-			"newer than supported version. Supported version:", VERSION,
-			" Disk files version:", version))
-	}
-
-	if version != VERSION {
-		bdsm.SetRoot(RootIDVersion, VERSION)
-	}
-
 	return nil
 }
 
@@ -757,7 +1039,7 @@ createFileAndPager creates a storagefile and a pager.
 func createFileAndPager(filename string, recordSize uint32,
 	bdsm *ByteDiskStorageManager) (*file.StorageFile, *paging.PagedStorageFile, error) {
 
-	sf, err := file.NewStorageFile(filename, recordSize, bdsm.transDisabled)
+	sf, err := file.NewStorageFileWithBackend(filename, recordSize, bdsm.transDisabled, bdsm.backend)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -215,12 +215,12 @@ func TestDiskStorageManager1(t *testing.T) {
 		return
 	}
 
-	if !DataFileExist(DBDIR + "/test1") {
+	if !DataFileExist(&file.OSBackend{}, DBDIR + "/test1") {
 		t.Error("Main disk storage file was not detected.")
 		return
 	}
 
-	if DataFileExist(DBDIR + "/" + InvalidFileName) {
+	if DataFileExist(&file.OSBackend{}, DBDIR + "/" + InvalidFileName) {
 		t.Error("Main disk storage file with invalid name should not exist.")
 		return
 	}
@@ -394,6 +394,11 @@ func TestDiskStorageManager2(t *testing.T) {
 		return
 	}
 
+	if err = dsm.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
 	if err = dsm.Close(); err != nil {
 		t.Error(err)
 	}
@@ -443,6 +448,11 @@ func TestDiskStorageManager2(t *testing.T) {
 		return
 	}
 
+	if dsm.Sync() != nil {
+		t.Error("Syncing failed:", err)
+		return
+	}
+
 	if err = dsm.Close(); err != nil {
 		t.Error(err)
 	}
@@ -596,7 +606,7 @@ const InvalidFileName = "**" + "\x00"
 func TestDiskStorageManagerInit(t *testing.T) {
 	lockfile := lockutil.NewLockFile(DBDIR+"/"+"lock0.lck", time.Duration(50)*time.Millisecond)
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/" + InvalidFileName, false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile, false, false, nil, &file.OSBackend{}}, nil, nil}
 
 	err := initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err == nil {
@@ -607,7 +617,7 @@ func TestDiskStorageManagerInit(t *testing.T) {
 	testCannotInitPanic(t)
 
 	dsm = &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, false, false, nil, &file.OSBackend{}}, nil, nil}
 
 	err = initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err != nil {
@@ -648,7 +658,7 @@ func testClosedPanic(t *testing.T, dsm *DiskStorageManager) {
 
 func testVersionCheckPanic(t *testing.T) {
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, false, false, nil, &file.OSBackend{}}, nil, nil}
 
 	defer func() {
 		if r := recover(); r == nil {
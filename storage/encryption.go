@@ -0,0 +1,397 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+encryptionID is the second byte of an encrypted record (the first byte is the
+compression marker). It is followed by the ID of the key, the nonce and the
+sealed data. Records are gob streams which never start with a zero byte (the
+first byte is the non-zero length of the first gob message) - this is also
+true for records which hold raw byte slices (e.g. chunks of blobs). Encrypted
+records can therefore not be confused with any other record.
+
+The sealed data is bound to the logical storage location of the record and to
+the ID of the key (as additional authenticated data) so a record cannot be
+moved to another location without being detected.
+*/
+const encryptionID = 0xFF
+
+/*
+encryptionKeySalt is the salt which is used to derive encryption keys from
+configured secrets.
+*/
+var encryptionKeySalt = []byte("eliasdb storage encryption")
+
+/*
+ErrNoEncryption is returned when an encrypted record is read without an
+encryption key ring.
+*/
+var ErrNoEncryption = errors.New("Record is encrypted but encryption is not enabled")
+
+/*
+ErrReencryptionAborted is returned when a storage was closed while it was
+being re-encrypted.
+*/
+var ErrReencryptionAborted = errors.New("Storage was closed during re-encryption")
+
+/*
+KeyProvider returns the secret of an encryption key. A key provider can fetch
+secrets from an external key management system.
+*/
+type KeyProvider func(id byte) ([]byte, error)
+
+/*
+Encryption is a ring of encryption keys. New records are encrypted with the
+active key using AES-GCM. Records which were encrypted with other keys of the
+ring stay readable - this allows the rotation of keys. Keys which are not in
+the ring are requested from the key provider (if there is one).
+*/
+type Encryption struct {
+	keys     map[byte]cipher.AEAD // Known keys
+	active   byte                 // ID of the active key
+	provider KeyProvider          // Provider for unknown keys
+	mutex    *sync.RWMutex        // Mutex to protect the key ring
+}
+
+/*
+NewEncryption creates a new key ring. Keys which are not in the ring are
+requested from the given key provider (can be nil).
+*/
+func NewEncryption(provider KeyProvider) *Encryption {
+	return &Encryption{make(map[byte]cipher.AEAD), 0, provider, &sync.RWMutex{}}
+}
+
+/*
+AddKey adds a key to the key ring. The actual AES-256 key is derived from
+the given secret. Key IDs must be greater than 0.
+*/
+func (e *Encryption) AddKey(id byte, secret []byte) error {
+
+	if id == 0 {
+		return fmt.Errorf("Invalid encryption key ID: %v", id)
+	} else if len(secret) == 0 {
+		return fmt.Errorf("Encryption key %v has no secret", id)
+	}
+
+	aead, err := deriveKey(secret)
+
+	if err == nil {
+		e.mutex.Lock()
+		e.keys[id] = aead
+		e.mutex.Unlock()
+	}
+
+	return err
+}
+
+/*
+SetActiveKey sets the key which is used to encrypt new records.
+*/
+func (e *Encryption) SetActiveKey(id byte) error {
+
+	if _, err := e.key(id); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	e.active = id
+	e.mutex.Unlock()
+
+	return nil
+}
+
+/*
+ActiveKey returns the ID of the key which is used to encrypt new records.
+*/
+func (e *Encryption) ActiveKey() byte {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.active
+}
+
+/*
+key returns a key from the key ring. Unknown keys are requested from the key
+provider.
+*/
+func (e *Encryption) key(id byte) (cipher.AEAD, error) {
+
+	e.mutex.RLock()
+	aead, ok := e.keys[id]
+	e.mutex.RUnlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	if e.provider != nil && id != 0 {
+		secret, err := e.provider(id)
+
+		if err == nil {
+			if err = e.AddKey(id, secret); err == nil {
+				return e.key(id)
+			}
+		}
+
+		return nil, fmt.Errorf("Could not get encryption key %v: %v", id, err)
+	}
+
+	return nil, fmt.Errorf("Unknown encryption key: %v", id)
+}
+
+/*
+encrypt encrypts a record for a given logical storage location with the
+active key.
+*/
+func (e *Encryption) encrypt(data []byte, loc uint64) ([]byte, error) {
+
+	id := e.ActiveKey()
+
+	aead, err := e.key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]byte, 3+aead.NonceSize(), 3+aead.NonceSize()+len(data)+aead.Overhead())
+
+	ret[0], ret[1], ret[2] = compressionMarker, encryptionID, id
+
+	if _, err := rand.Read(ret[3:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(ret, ret[3:], data, additionalData(id, loc)), nil
+}
+
+/*
+decrypt decrypts an encrypted record of a given logical storage location.
+Returns the ID of the key which was used.
+*/
+func (e *Encryption) decrypt(data []byte, loc uint64) ([]byte, byte, error) {
+
+	id := data[2]
+
+	aead, err := e.key(id)
+	if err != nil {
+		return nil, id, err
+	}
+
+	if len(data) < 3+aead.NonceSize() {
+		return nil, id, errors.New("Encrypted record is too short")
+	}
+
+	nonce := data[3 : 3+aead.NonceSize()]
+
+	plain, err := aead.Open(nil, nonce, data[3+aead.NonceSize():], additionalData(id, loc))
+
+	return plain, id, err
+}
+
+/*
+additionalData returns the additional authenticated data of a record which is
+encrypted with a given key for a given logical storage location.
+*/
+func additionalData(id byte, loc uint64) []byte {
+	ret := make([]byte, 10)
+
+	ret[0], ret[1] = encryptionID, id
+	binary.BigEndian.PutUint64(ret[2:], loc)
+
+	return ret
+}
+
+/*
+deriveKey derives an AES-256-GCM key from a secret.
+*/
+func deriveKey(secret []byte) (cipher.AEAD, error) {
+
+	mac := hmac.New(sha256.New, encryptionKeySalt)
+	mac.Write(secret)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+/*
+isEncrypted checks if a given record is encrypted.
+*/
+func isEncrypted(data []byte) bool {
+	return len(data) > 2 && data[0] == compressionMarker && data[1] == encryptionID
+}
+
+/*
+encryptRecord encrypts a record for a given logical storage location if an
+encryption is given.
+*/
+func encryptRecord(e *Encryption, data []byte, loc uint64) ([]byte, error) {
+	if e == nil {
+		return data, nil
+	}
+	return e.encrypt(data, loc)
+}
+
+/*
+decryptRecord decrypts a given record of a given logical storage location if
+it is encrypted.
+*/
+func decryptRecord(e *Encryption, bb *bytes.Buffer, loc uint64) (*bytes.Buffer, error) {
+
+	if !isEncrypted(bb.Bytes()) {
+		return bb, nil
+	} else if e == nil {
+		return nil, ErrNoEncryption
+	}
+
+	plain, _, err := e.decrypt(bb.Bytes(), loc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewBuffer(plain), nil
+}
+
+/*
+Reencrypt rewrites all records which are not encrypted with the active key of
+the given key ring. Records are rewritten in batches of the given size - the
+storage is only locked while a batch is rewritten. The operation pauses for
+the given duration between batches. Returns the number of rewritten records.
+*/
+func (bdsm *ByteDiskStorageManager) Reencrypt(e *Encryption, batchSize int, pause time.Duration) (int, error) {
+
+	bdsm.checkFileOpen()
+
+	if bdsm.readonly {
+		return 0, ErrReadonly
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	active := e.ActiveKey()
+	count := 0
+
+	bdsm.mutex.Lock()
+	page := bdsm.logicalSlotsPager.First(view.TypeTranslationPage)
+	elementsPerPage := uint64(bdsm.logicalSlotManager.ElementsPerPage())
+	bdsm.mutex.Unlock()
+
+	var i uint64
+	var err error
+
+	for page != 0 {
+
+		bdsm.mutex.Lock()
+
+		if bdsm.physicalSlotsSf == nil {
+			bdsm.mutex.Unlock()
+			return count, ErrReencryptionAborted
+		}
+
+		for n := 0; n < batchSize && page != 0 && err == nil; n++ {
+			offset := uint16(pageview.OffsetTransData) + uint16(i)*util.LocationSize
+
+			var rewritten bool
+
+			rewritten, err = bdsm.reencryptLocation(e, active, util.PackLocation(page, offset))
+
+			if rewritten {
+				count++
+			}
+
+			if i++; i == elementsPerPage && err == nil {
+				i = 0
+				page, err = bdsm.logicalSlotsPager.Next(page)
+			}
+		}
+
+		bdsm.mutex.Unlock()
+
+		if err == nil {
+			err = bdsm.Flush()
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		time.Sleep(pause)
+	}
+
+	return count, nil
+}
+
+/*
+reencryptLocation rewrites the record of a given logical location if it is
+not encrypted with the active key. Assumes that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) reencryptLocation(e *Encryption, active byte, loc uint64) (bool, error) {
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil || ploc == 0 {
+		return false, err
+	}
+
+	var b bytes.Buffer
+
+	if err = bdsm.physicalSlotManager.Fetch(ploc, &b); err != nil {
+		return false, err
+	}
+
+	data := b.Bytes()
+
+	if isEncrypted(data) {
+		if data[2] == active {
+			return false, nil
+		}
+
+		if data, _, err = e.decrypt(data, loc); err != nil {
+			return false, err
+		}
+	}
+
+	if data, err = e.encrypt(data, loc); err != nil {
+		return false, err
+	}
+
+	bdsm.markChanged(loc)
+
+	newPloc, err := bdsm.physicalSlotManager.Update(ploc, data, 0, uint32(len(data)))
+
+	if err == nil && newPloc != ploc {
+		err = bdsm.logicalSlotManager.Update(loc, newPloc)
+	}
+
+	return err == nil, err
+}
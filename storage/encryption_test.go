@@ -0,0 +1,270 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/encrypt1", false, false, false, false)
+	defer dsm.Close()
+
+	text := strings.Repeat("secret data ", 20)
+
+	// Records which were stored before encryption was enabled stay readable
+
+	loc1, _ := dsm.Insert(text)
+
+	e := NewEncryption(nil)
+
+	if err := e.AddKey(0, []byte("foo")); err == nil || err.Error() != "Invalid encryption key ID: 0" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := e.AddKey(1, nil); err == nil || err.Error() != "Encryption key 1 has no secret" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := e.SetActiveKey(1); err == nil || err.Error() != "Unknown encryption key: 1" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	e.AddKey(1, []byte("key1"))
+	e.SetActiveKey(1)
+
+	dsm.SetEncryption(e)
+
+	// Encryption and compression work together
+
+	dsm.SetCompression("flate")
+
+	loc2, _ := dsm.Insert(text)
+
+	var b bytes.Buffer
+
+	dsm.ByteDiskStorageManager.Fetch(loc2, &b)
+
+	if !isEncrypted(b.Bytes()) || b.Bytes()[2] != 1 || strings.Contains(b.String(), "secret") ||
+		b.Len() > len(text) {
+		t.Error("Record should have been encrypted:", b.Bytes())
+		return
+	}
+
+	var res string
+
+	for _, loc := range []uint64{loc1, loc2} {
+		if err := dsm.Fetch(loc, &res); err != nil || res != text {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	// Rotate the key - old records stay readable
+
+	e.AddKey(2, []byte("key2"))
+	e.SetActiveKey(2)
+
+	loc3, _ := dsm.Insert(text)
+
+	for _, loc := range []uint64{loc1, loc2, loc3} {
+		if err := dsm.Fetch(loc, &res); err != nil || res != text {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	// Re-encrypt all records with the active key
+
+	if n, err := dsm.Reencrypt(1, 0); n != 2 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := dsm.Reencrypt(10, 0); n != 0 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	for _, loc := range []uint64{loc1, loc2, loc3} {
+		b.Reset()
+		dsm.ByteDiskStorageManager.Fetch(loc, &b)
+
+		if !isEncrypted(b.Bytes()) || b.Bytes()[2] != 2 {
+			t.Error("Record should have been encrypted with the new key:", loc)
+			return
+		}
+
+		if err := dsm.Fetch(loc, &res); err != nil || res != text {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	// Unknown keys are requested from the key provider
+
+	e2 := NewEncryption(func(id byte) ([]byte, error) {
+		if id == 2 {
+			return []byte("key2"), nil
+		}
+		return nil, errors.New("Key not found")
+	})
+
+	dsm.SetEncryption(e2)
+
+	if err := dsm.Fetch(loc1, &res); err != nil || res != text {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := e2.SetActiveKey(3); err == nil || err.Error() != "Could not get encryption key 3: Key not found" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Records cannot be read with the wrong key or without encryption
+
+	e3 := NewEncryption(nil)
+	e3.AddKey(2, []byte("wrong"))
+	dsm.SetEncryption(e3)
+
+	if err := dsm.Fetch(loc1, &res); err == nil || err.Error() != "cipher: message authentication failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.SetEncryption(nil)
+
+	if err := dsm.Fetch(loc1, &res); err != ErrNoEncryption {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := dsm.Reencrypt(10, 0); err == nil || err.Error() != "Encryption is not enabled" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestEncryptionBinding(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/encrypt2", false, false, false, false)
+	defer dsm.Close()
+
+	e := NewEncryption(nil)
+	e.AddKey(1, []byte("foo"))
+	e.AddKey(2, []byte("foo"))
+	e.SetActiveKey(1)
+	dsm.SetEncryption(e)
+
+	loc1, _ := dsm.Insert("record1")
+	loc2, _ := dsm.Insert("record2")
+
+	var res string
+
+	if err := dsm.Fetch(loc1, &res); err != nil || res != "record1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Encrypted records cannot be moved to another location
+
+	var b1, b2 bytes.Buffer
+
+	dsm.ByteDiskStorageManager.Fetch(loc1, &b1)
+	dsm.ByteDiskStorageManager.Fetch(loc2, &b2)
+
+	dsm.ByteDiskStorageManager.Update(loc1, b2.Bytes())
+
+	if err := dsm.Fetch(loc1, &res); err == nil || err.Error() != "cipher: message authentication failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The ID of the key cannot be changed (both keys have the same secret)
+
+	data := append([]byte{}, b1.Bytes()...)
+	data[2] = 2
+
+	dsm.ByteDiskStorageManager.Update(loc1, data)
+
+	if err := dsm.Fetch(loc1, &res); err == nil || err.Error() != "cipher: message authentication failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.ByteDiskStorageManager.Update(loc1, b1.Bytes())
+
+	if err := dsm.Fetch(loc1, &res); err != nil || res != "record1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Records of the key-value storage manager are also bound to their location
+
+	kvsm, _ := NewKVStorageManager("test", NewMemoryKeyValueStore(), false)
+	kvsm.SetEncryption(e)
+
+	kloc1, _ := kvsm.Insert("record1")
+	kloc2, _ := kvsm.Insert("record2")
+
+	if err := kvsm.Fetch(kloc2, &res); err != nil || res != "record2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	kvsm.pending[recordKey(kloc2)] = kvsm.pending[recordKey(kloc1)]
+
+	if err := kvsm.Fetch(kloc2, &res); err == nil || err.Error() != "cipher: message authentication failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestEncryptionMarker(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/encrypt3", false, false, false, false)
+	defer dsm.Close()
+
+	// Raw byte slices (e.g. blob chunks) which look like encrypted or
+	// compressed records are stored as gob streams which never start with
+	// the compression marker
+
+	for _, chunk := range [][]byte{{compressionMarker, encryptionID, 1, 2, 3},
+		{compressionMarker, 1, 2, 3}, {}} {
+
+		loc, err := dsm.Insert(chunk)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var b bytes.Buffer
+
+		dsm.ByteDiskStorageManager.Fetch(loc, &b)
+
+		if b.Bytes()[0] == compressionMarker || isEncrypted(b.Bytes()) {
+			t.Error("Unexpected stored record:", b.Bytes())
+			return
+		}
+
+		var res []byte
+
+		if err := dsm.Fetch(loc, &res); err != nil || !bytes.Equal(res, chunk) {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+BackendFile is a file of a storage backend.
+*/
+type BackendFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+
+	/*
+		Name returns the name of the file.
+	*/
+	Name() string
+
+	/*
+		Sync commits the content of the file to stable storage.
+	*/
+	Sync() error
+}
+
+/*
+StorageBackend is the backend which stores the physical files of storage
+files and transaction logs.
+*/
+type StorageBackend interface {
+
+	/*
+		OpenFile opens a file (see os.OpenFile). Errors of files which do not
+		exist must be recognized by os.IsNotExist.
+	*/
+	OpenFile(name string, flag int, perm os.FileMode) (BackendFile, error)
+
+	/*
+		Exists checks if a file exists.
+	*/
+	Exists(name string) (bool, error)
+
+	/*
+		Size returns the size of a file in bytes.
+	*/
+	Size(name string) (int64, error)
+
+	/*
+		Glob returns the names of all files which match a given pattern (see
+		filepath.Glob).
+	*/
+	Glob(pattern string) ([]string, error)
+
+	/*
+		Rename renames a file.
+	*/
+	Rename(oldname string, newname string) error
+
+	/*
+		Remove removes a file.
+	*/
+	Remove(name string) error
+
+	/*
+		FileWritten is called after a local file was written without the
+		backend (e.g. a file of another component which should be kept
+		together with the storage files).
+	*/
+	FileWritten(name string) error
+}
+
+/*
+OSBackend is a storage backend which stores files in the local file system.
+*/
+type OSBackend struct {
+}
+
+/*
+OpenFile opens a file.
+*/
+func (b *OSBackend) OpenFile(name string, flag int, perm os.FileMode) (BackendFile, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+/*
+Exists checks if a file exists.
+*/
+func (b *OSBackend) Exists(name string) (bool, error) {
+	_, err := os.Stat(name)
+
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+/*
+Size returns the size of a file in bytes.
+*/
+func (b *OSBackend) Size(name string) (int64, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+/*
+Glob returns the names of all files which match a given pattern.
+*/
+func (b *OSBackend) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+/*
+Rename renames a file.
+*/
+func (b *OSBackend) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+/*
+Remove removes a file.
+*/
+func (b *OSBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+/*
+FileWritten is called after a local file was written without the backend.
+*/
+func (b *OSBackend) FileWritten(name string) error {
+	return nil
+}
+
+/*
+ReadFile reads a file of a storage backend.
+*/
+func ReadFile(backend StorageBackend, name string) ([]byte, error) {
+
+	f, err := backend.OpenFile(name, os.O_RDONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+/*
+WriteFile writes a file of a storage backend and commits it to stable storage.
+*/
+func WriteFile(backend StorageBackend, name string, data []byte) error {
+
+	f, err := backend.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,153 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+/*
+ErrMmapUnsupported is returned if memory mapping is not supported on the
+current platform.
+*/
+var ErrMmapUnsupported = errors.New("Memory mapping is not supported")
+
+/*
+MmapBackend is a storage backend which stores files in the local file system
+and reads storage files through a read-only memory mapping. Reading a record
+from the mapping avoids a system call for each random read. Writes still go
+through the file. Files which cannot be mapped (e.g. on platforms without
+mmap support or if the address space is exhausted) are read normally.
+*/
+type MmapBackend struct {
+	OSBackend
+}
+
+/*
+OpenFile opens a file. Only files which are opened for random access are
+mapped - files which are truncated or appended (e.g. transaction logs) are
+read normally.
+*/
+func (b *MmapBackend) OpenFile(name string, flag int, perm os.FileMode) (BackendFile, error) {
+
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_RDWR == 0 || flag&(os.O_TRUNC|os.O_APPEND) != 0 {
+		return f, nil
+	}
+
+	return &mmapFile{f, nil, false, &sync.RWMutex{}}, nil
+}
+
+/*
+mmapFile is a file whose content is read through a memory mapping.
+*/
+type mmapFile struct {
+	*os.File
+	data     []byte        // Mapped content of the file
+	fallback bool          // Flag if the file could not be mapped
+	mutex    *sync.RWMutex // Mutex to protect the mapping
+}
+
+/*
+ReadAt reads len(b) bytes from the file starting at a given offset. The
+mapping is extended if the file has grown since it was mapped.
+*/
+func (f *mmapFile) ReadAt(b []byte, off int64) (int, error) {
+
+	if n, ok := f.readMapped(b, off); ok {
+		return n, nil
+	}
+
+	if f.remap() {
+		if n, ok := f.readMapped(b, off); ok {
+			return n, nil
+		}
+	}
+
+	return f.File.ReadAt(b, off)
+}
+
+/*
+readMapped reads from the mapping. Returns false if the requested range is
+not mapped.
+*/
+func (f *mmapFile) readMapped(b []byte, off int64) (int, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if off < 0 || off+int64(len(b)) > int64(len(f.data)) {
+		return 0, false
+	}
+
+	return copy(b, f.data[off:]), true
+}
+
+/*
+remap maps the current content of the file. Returns true if the mapping
+was extended.
+*/
+func (f *mmapFile) remap() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.fallback {
+		return false
+	}
+
+	fi, err := f.File.Stat()
+	if err != nil || fi.Size() <= int64(len(f.data)) {
+		return false
+	}
+
+	size := int(fi.Size())
+
+	if int64(size) != fi.Size() {
+
+		// The file is too large for the address space
+
+		f.fallback = true
+		return false
+	}
+
+	if f.data != nil {
+		munmap(f.data)
+		f.data = nil
+	}
+
+	if f.data, err = mmap(f.File, size); err != nil {
+		f.data = nil
+		f.fallback = true
+		return false
+	}
+
+	return true
+}
+
+/*
+Close unmaps and closes the file.
+*/
+func (f *mmapFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.data != nil {
+		munmap(f.data)
+		f.data = nil
+	}
+
+	return f.File.Close()
+}
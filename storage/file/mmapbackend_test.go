@@ -0,0 +1,160 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestMmapBackend(t *testing.T) {
+	backend := &MmapBackend{}
+
+	sf, err := NewStorageFileWithBackend(DBDir+"/mmap_test", 10, true, backend)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Records are always read from disk
+
+	sf.SetPageCache(NewPageCache(0))
+
+	if _, ok := sf.files[0].(*mmapFile); !ok {
+		t.Error("Storage file should be mapped")
+		return
+	}
+
+	// Files which are truncated (e.g. transaction logs) are not mapped
+
+	f, err := backend.OpenFile(DBDir+"/mmap_test."+LogFileSuffix, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0660)
+	if _, ok := f.(*os.File); !ok || err != nil {
+		t.Error("Unexpected result:", f, err)
+		return
+	}
+	f.Close()
+
+	writeRecords := func(from uint64, to uint64) {
+		for i := from; i < to; i++ {
+			record, err := sf.Get(i)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			record.WriteSingleByte(0, byte(i))
+			sf.ReleaseInUse(record)
+		}
+
+		if err := sf.Flush(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	checkRecords := func(from uint64, to uint64) {
+		for i := from; i < to; i++ {
+			record, err := sf.Get(i)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if b := record.ReadSingleByte(0); b != byte(i) {
+				t.Error("Unexpected result:", i, b)
+			}
+
+			sf.ReleaseInUse(record)
+		}
+	}
+
+	writeRecords(0, 5)
+	checkRecords(0, 5)
+
+	mf := sf.files[0].(*mmapFile)
+
+	if len(mf.data) != 50 {
+		t.Error("Unexpected mapping size:", len(mf.data))
+		return
+	}
+
+	// The mapping is extended once the file grows and changes are visible
+
+	writeRecords(3, 10)
+	checkRecords(0, 10)
+
+	if len(mf.data) != 100 || mf.fallback {
+		t.Error("Unexpected mapping size:", len(mf.data), mf.fallback)
+		return
+	}
+
+	// Reads beyond the end of the file return an empty record
+
+	record, err := sf.Get(20)
+	if err != nil || record.ReadSingleByte(0) != 0 {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	// Files which cannot be mapped are read normally
+
+	mf.mutex.Lock()
+	munmap(mf.data)
+	mf.data = nil
+	mf.fallback = true
+	mf.mutex.Unlock()
+
+	checkRecords(0, 10)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func BenchmarkRandomRead(b *testing.B) {
+	const records = 2000
+
+	for _, backend := range []StorageBackend{&OSBackend{}, &MmapBackend{}} {
+
+		name := fmt.Sprintf("%T", backend)[6:]
+
+		sf, err := NewStorageFileWithBackend(DBDir+"/benchmark_"+name, DefaultRecordSize, true, backend)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+
+		for i := uint64(0); i < records; i++ {
+			record, _ := sf.Get(i)
+			record.WriteSingleByte(0, byte(i))
+			sf.ReleaseInUse(record)
+		}
+		sf.Flush()
+
+		sf.SetPageCache(NewPageCache(0))
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				record, err := sf.Get(uint64(rand.Intn(records)))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				sf.ReleaseInUse(record)
+			}
+		})
+
+		sf.Close()
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+/*
+DefaultPageCacheSize is the default capacity of a page cache in bytes (64MB).
+*/
+const DefaultPageCacheSize = 64 * 1024 * 1024
+
+/*
+DefaultPageCache is the page cache which is used by all storage files unless
+a different page cache is set.
+*/
+var DefaultPageCache = NewPageCache(DefaultPageCacheSize)
+
+/*
+PageCacheStats holds the statistics of a page cache.
+*/
+type PageCacheStats struct {
+	MaxSize   int64  `json:"max_size"`  // Capacity of the cache in bytes
+	Size      int64  `json:"size"`      // Bytes of records which are currently cached
+	Hits      uint64 `json:"hits"`      // Number of records which were found in memory
+	Misses    uint64 `json:"misses"`    // Number of records which had to be read from disk
+	Evictions uint64 `json:"evictions"` // Number of records which were removed from the cache
+}
+
+/*
+PageCache limits the memory which storage files use to keep unmodified records
+(pages) in memory. All storage files which use the same page cache share its
+capacity. A storage file evicts its least recently used records once the
+capacity of its page cache is exceeded. Records which are in use, modified or
+part of a transaction are never evicted and are not counted.
+*/
+type PageCache struct {
+	maxSize   int64  // Capacity of the cache in bytes
+	size      int64  // Bytes of records which are currently cached
+	hits      uint64 // Number of records which were found in memory
+	misses    uint64 // Number of records which had to be read from disk
+	evictions uint64 // Number of records which were removed from the cache
+}
+
+/*
+NewPageCache creates a new page cache with a given capacity in bytes.
+*/
+func NewPageCache(maxSize int64) *PageCache {
+	return &PageCache{maxSize, 0, 0, 0, 0}
+}
+
+/*
+SetMaxSize sets the capacity of the page cache in bytes. Storage files evict
+records on their next access if the cache is over capacity.
+*/
+func (pc *PageCache) SetMaxSize(maxSize int64) {
+	atomic.StoreInt64(&pc.maxSize, maxSize)
+}
+
+/*
+Stats returns the current statistics of the page cache.
+*/
+func (pc *PageCache) Stats() PageCacheStats {
+	return PageCacheStats{atomic.LoadInt64(&pc.maxSize), atomic.LoadInt64(&pc.size),
+		atomic.LoadUint64(&pc.hits), atomic.LoadUint64(&pc.misses), atomic.LoadUint64(&pc.evictions)}
+}
+
+/*
+ResetStats resets the hit, miss and eviction counters of the page cache.
+*/
+func (pc *PageCache) ResetStats() {
+	atomic.StoreUint64(&pc.hits, 0)
+	atomic.StoreUint64(&pc.misses, 0)
+	atomic.StoreUint64(&pc.evictions, 0)
+}
+
+/*
+full checks if the page cache has reached its capacity.
+*/
+func (pc *PageCache) full() bool {
+	return atomic.LoadInt64(&pc.size) >= atomic.LoadInt64(&pc.maxSize)
+}
+
+/*
+overCapacity checks if the page cache holds more bytes than its capacity.
+*/
+func (pc *PageCache) overCapacity() bool {
+	return atomic.LoadInt64(&pc.size) > atomic.LoadInt64(&pc.maxSize)
+}
+
+// Free record handling of storage files
+// =====================================
+
+/*
+addFree adds an unmodified record to the cached records of a storage file.
+Least recently used records are evicted if the page cache is over capacity.
+*/
+func (s *StorageFile) addFree(record *Record) {
+	id := record.ID()
+
+	if elem, ok := s.freeElems[id]; ok {
+		s.freeOrder.MoveToBack(elem)
+		s.free[id] = record
+		elem.Value = record
+		return
+	}
+
+	s.free[id] = record
+	s.freeElems[id] = s.freeOrder.PushBack(record)
+
+	atomic.AddInt64(&s.pageCache.size, int64(s.recordSize))
+
+	for s.pageCache.overCapacity() && s.freeOrder.Len() > 0 {
+		s.evictFree()
+	}
+}
+
+/*
+removeFree removes a record from the cached records of a storage file.
+Returns nil if the record is not cached.
+*/
+func (s *StorageFile) removeFree(id uint64) *Record {
+	record, ok := s.free[id]
+
+	if ok {
+		delete(s.free, id)
+		s.freeOrder.Remove(s.freeElems[id])
+		delete(s.freeElems, id)
+
+		atomic.AddInt64(&s.pageCache.size, -int64(s.recordSize))
+	}
+
+	return record
+}
+
+/*
+evictFree removes the least recently used record from the cached records of a
+storage file and returns it.
+*/
+func (s *StorageFile) evictFree() *Record {
+	record := s.removeFree(s.freeOrder.Front().Value.(*Record).ID())
+
+	atomic.AddUint64(&s.pageCache.evictions, 1)
+
+	return record
+}
+
+/*
+clearFree removes all cached records of a storage file.
+*/
+func (s *StorageFile) clearFree() {
+	atomic.AddInt64(&s.pageCache.size, -int64(len(s.free))*int64(s.recordSize))
+
+	s.free = make(map[uint64]*Record)
+	s.freeOrder = list.New()
+	s.freeElems = make(map[uint64]*list.Element)
+}
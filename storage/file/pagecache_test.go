@@ -0,0 +1,110 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPageCache(t *testing.T) {
+	pc := NewPageCache(30)
+
+	sf, err := NewStorageFile(DBDir+"/pagecache_test", 10, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf.SetPageCache(pc)
+
+	getAndRelease := func(id uint64) {
+		record, err := sf.Get(id)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sf.ReleaseInUse(record)
+	}
+
+	freeIDs := func() string {
+		var ids []uint64
+		for e := sf.freeOrder.Front(); e != nil; e = e.Next() {
+			ids = append(ids, e.Value.(*Record).ID())
+		}
+		return fmt.Sprint(ids, " ", len(sf.free))
+	}
+
+	for i := uint64(1); i < 6; i++ {
+		getAndRelease(i)
+	}
+
+	// The least recently used records were evicted
+
+	if res := freeIDs(); res != "[3 4 5] 3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := pc.Stats(); res != (PageCacheStats{30, 30, 0, 5, 2}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	getAndRelease(4)
+
+	if res := freeIDs(); res != "[3 5 4] 3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A full cache recycles its least recently used record
+
+	getAndRelease(6)
+
+	if res := freeIDs(); res != "[5 4 6] 3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := pc.Stats(); res != (PageCacheStats{30, 30, 1, 6, 3}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Shrinking the cache evicts records on the next access
+
+	pc.SetMaxSize(10)
+
+	getAndRelease(5)
+
+	if res := freeIDs(); res != "[5] 1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := pc.Stats(); res != (PageCacheStats{10, 10, 2, 6, 5}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pc.ResetStats()
+
+	if res := pc.Stats(); res != (PageCacheStats{10, 0, 0, 0, 0}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
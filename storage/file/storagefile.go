@@ -12,10 +12,12 @@ package file
 
 import (
 	"bytes"
+	"container/list"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
 
 	"github.com/krotik/common/sortutil"
 )
@@ -56,9 +58,16 @@ type StorageFile struct {
 	inTrans map[uint64]*Record // Records which are in the transaction log but not yet written to disk
 	dirty   map[uint64]*Record // Dirty little records waiting to be written
 
-	files []*os.File // List of storage files
+	freeOrder *list.List               // Records of the free map in order of their last use
+	freeElems map[uint64]*list.Element // List elements of records in freeOrder
+	pageCache *PageCache               // Page cache which limits the size of the free map
+
+	files   []BackendFile  // List of storage files
+	backend StorageBackend // Backend which stores the physical files
 
 	tm *TransactionManager // Manager object for transactions
+
+	deferSync bool // Flag if syncing the transaction log on flush is deferred
 }
 
 /*
@@ -70,14 +79,26 @@ func NewDefaultStorageFile(name string, transDisabled bool) (*StorageFile, error
 }
 
 /*
-NewStorageFile creates a new storage file and returns a reference to it.
+NewStorageFile creates a new storage file and returns a reference to it. The
+physical files are stored in the local file system.
 */
 func NewStorageFile(name string, recordSize uint32, transDisabled bool) (*StorageFile, error) {
+	return NewStorageFileWithBackend(name, recordSize, transDisabled, &OSBackend{})
+}
+
+/*
+NewStorageFileWithBackend creates a new storage file whose physical files are
+stored in a given storage backend and returns a reference to it.
+*/
+func NewStorageFileWithBackend(name string, recordSize uint32, transDisabled bool,
+	backend StorageBackend) (*StorageFile, error) {
+
 	maxFileSize := DefaultFileSize - DefaultFileSize%uint64(recordSize)
 
 	ret := &StorageFile{name, transDisabled, recordSize, maxFileSize,
 		make(map[uint64]*Record), make(map[uint64]*Record), make(map[uint64]*Record),
-		make(map[uint64]*Record), make([]*os.File, 0), nil}
+		make(map[uint64]*Record), list.New(), make(map[uint64]*list.Element), DefaultPageCache,
+		make([]BackendFile, 0), backend, nil, false}
 
 	if !transDisabled {
 		tm, err := NewTransactionManager(ret, true)
@@ -110,6 +131,15 @@ func (s *StorageFile) RecordSize() uint32 {
 	return s.recordSize
 }
 
+/*
+SetPageCache sets the page cache which limits the number of records which are
+kept in memory. All cached records are discarded.
+*/
+func (s *StorageFile) SetPageCache(pc *PageCache) {
+	s.clearFree()
+	s.pageCache = pc
+}
+
 /*
 Get returns a record from the file. Other components can write to this record.
 Any write operation should set the dirty flag on the record. Dirty records will
@@ -124,18 +154,20 @@ func (s *StorageFile) Get(id uint64) (*Record, error) {
 	if record, ok := s.inTrans[id]; ok {
 		delete(s.inTrans, id)
 		s.inUse[id] = record
+		atomic.AddUint64(&s.pageCache.hits, 1)
 		return record, nil
 	}
 
 	if record, ok := s.dirty[id]; ok {
 		delete(s.dirty, id)
 		s.inUse[id] = record
+		atomic.AddUint64(&s.pageCache.hits, 1)
 		return record, nil
 	}
 
-	if record, ok := s.free[id]; ok {
-		delete(s.free, id)
+	if record := s.removeFree(id); record != nil {
 		s.inUse[id] = record
+		atomic.AddUint64(&s.pageCache.hits, 1)
 		return record, nil
 	}
 
@@ -146,6 +178,8 @@ func (s *StorageFile) Get(id uint64) (*Record, error) {
 
 	// Read the record in from file
 
+	atomic.AddUint64(&s.pageCache.misses, 1)
+
 	record = s.createRecord(id)
 	err := s.readRecord(record)
 
@@ -161,7 +195,7 @@ func (s *StorageFile) Get(id uint64) (*Record, error) {
 /*
 getFile gets a physical file for a specific offset.
 */
-func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
+func (s *StorageFile) getFile(offset uint64) (BackendFile, error) {
 
 	filenumber := int(offset / s.maxFileSize)
 
@@ -172,7 +206,7 @@ func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
 		s.files = append(s.files, nil)
 	}
 
-	var ret *os.File
+	var ret BackendFile
 
 	if len(s.files) > filenumber {
 		ret = s.files[filenumber]
@@ -185,7 +219,7 @@ func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
 
 		filename := fmt.Sprintf("%s.%d", s.name, filenumber)
 
-		file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0660)
+		file, err := s.backend.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0660)
 		if err != nil {
 			return nil, err
 		}
@@ -198,18 +232,17 @@ func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
 }
 
 /*
-createRecord creates a new record - (either from the free cache or newly created).
+createRecord creates a new record - (either recycled from the free cache if
+the page cache is full or newly created).
 */
 func (s *StorageFile) createRecord(id uint64) *Record {
 	var record *Record
 
-	if len(s.free) != 0 {
-		var rkey uint64
+	if s.pageCache.full() && s.freeOrder.Len() != 0 {
 
-		for rkey, record = range s.free {
-			break
-		}
-		delete(s.free, rkey)
+		// Recycle the least recently used record
+
+		record = s.evictFree()
 
 		// NOTE At this point the free record contains
 		// still old data. It is expected that the following
@@ -309,7 +342,7 @@ func (s *StorageFile) releaseInTrans(record *Record, recycle bool) {
 		delete(s.inTrans, record.ID())
 
 		if recycle {
-			s.free[record.ID()] = record
+			s.addFree(record)
 		}
 	}
 }
@@ -357,7 +390,7 @@ func (s *StorageFile) ReleaseInUse(record *Record) {
 		if !s.transDisabled && record.InTransaction() {
 			s.inTrans[id] = record
 		} else {
-			s.free[id] = record
+			s.addFree(record)
 		}
 	}
 }
@@ -389,7 +422,7 @@ func (s *StorageFile) Flush() error {
 			}
 			record.ClearDirty()
 			delete(s.dirty, id)
-			s.free[id] = record
+			s.addFree(record)
 		} else {
 			s.tm.add(record)
 			delete(s.dirty, id)
@@ -441,6 +474,30 @@ func (s *StorageFile) Sync() {
 	}
 }
 
+/*
+SetDeferSync sets if syncing the transaction log on every flush should be
+deferred. If syncing is deferred then flushed transactions only become durable
+once SyncLog is called. This allows multiple transactions to share a single
+disk sync.
+*/
+func (s *StorageFile) SetDeferSync(deferSync bool) {
+	s.deferSync = deferSync
+}
+
+/*
+SyncLog syncs the transaction log with the disk. All physical files are synced
+if transactions are disabled.
+*/
+func (s *StorageFile) SyncLog() {
+
+	if s.tm != nil && s.tm.logFile != nil {
+		s.tm.syncFile()
+		return
+	}
+
+	s.Sync()
+}
+
 /*
 Close commits all data and closes all physical files.
 */
@@ -473,8 +530,8 @@ func (s *StorageFile) Close() error {
 		}
 	}
 
-	s.free = make(map[uint64]*Record)
-	s.files = make([]*os.File, 0)
+	s.clearFree()
+	s.files = make([]BackendFile, 0)
 
 	// If transactions are enabled then a StorageFile cannot be
 	// reused after it was closed.
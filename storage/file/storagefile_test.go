@@ -11,6 +11,7 @@
 package file
 
 import (
+	"container/list"
 	"flag"
 	"fmt"
 	"os"
@@ -94,8 +95,8 @@ func TestStorageFileInitialisation(t *testing.T) {
 }
 
 func TestGetFile(t *testing.T) {
-	sf := &StorageFile{DBDir + "/test2", true, 10, 10, nil, nil, nil, nil,
-		make([]*os.File, 0), nil}
+	sf := &StorageFile{DBDir + "/test2", true, 10, 10, nil, nil, nil, nil, list.New(), nil,
+		DefaultPageCache, make([]BackendFile, 0), &OSBackend{}, nil, false}
 	defer sf.Close()
 
 	file, err := sf.getFile(0)
@@ -202,7 +203,7 @@ func TestLowLevelReadWrite(t *testing.T) {
 
 	oldfiles := sf.files
 	sf.name = DBDir + "/" + InvalidFileName
-	sf.files = make([]*os.File, 0)
+	sf.files = make([]BackendFile, 0)
 
 	_, err = sf.Get(1)
 
@@ -240,7 +241,7 @@ func TestLowLevelReadWrite(t *testing.T) {
 
 	oldfiles = sf.files
 	sf.name = DBDir + "/" + InvalidFileName
-	sf.files = make([]*os.File, 0)
+	sf.files = make([]BackendFile, 0)
 
 	record.data = nil
 
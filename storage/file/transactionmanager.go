@@ -126,7 +126,7 @@ func NewTransactionManager(owner *StorageFile, doRecover bool) (*TransactionMana
 recover tries to recover pending transactions from the physical transaction log.
 */
 func (t *TransactionManager) recover() error {
-	file, err := os.OpenFile(t.name, os.O_RDONLY, 0660)
+	file, err := t.owner.backend.OpenFile(t.name, os.O_RDONLY, 0660)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -183,7 +183,7 @@ func (t *TransactionManager) open() error {
 
 	// Always create a new empty transaction log file
 
-	file, err := os.OpenFile(t.name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0660)
+	file, err := t.owner.backend.OpenFile(t.name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0660)
 	if err != nil {
 		return err
 	}
@@ -237,7 +237,9 @@ func (t *TransactionManager) commit() error {
 		}
 	}
 
-	t.syncFile()
+	if !t.owner.deferSync {
+		t.syncFile()
+	}
 
 	// Clear all dirty flags
 
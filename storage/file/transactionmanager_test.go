@@ -233,6 +233,10 @@ func TestTMComplexHighLevelGetRelease(t *testing.T) {
 		return
 	}
 
+	// Free records are only reused once the page cache is full
+
+	sf.SetPageCache(NewPageCache(DefaultTransInLog * DefaultRecordSize))
+
 	// Releasing a nil pointer should have no effect
 	sf.releaseInTrans(nil, true)
 
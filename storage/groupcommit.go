@@ -0,0 +1,104 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+GroupCommit coalesces the disk syncs of concurrent transactions. Writers flush
+their changes without syncing (see DeferredSyncManager) and then wait for
+durability. The first waiter of a group schedules a single sync which runs
+once the max latency has passed - all writers which wait in the meantime
+share this sync.
+*/
+type GroupCommit struct {
+	maxLatency time.Duration // Max time a writer waits before a sync is done
+	syncFunc   func() error  // Function which syncs all flushed changes
+	mutex      *sync.Mutex   // Mutex to protect the waiter list
+	waiters    []chan error  // Writers which wait for the next sync
+	commits    uint64        // Number of commits which were made durable
+	syncs      uint64        // Number of syncs which were done
+}
+
+/*
+NewGroupCommit creates a new group commit object which calls a given sync
+function at most once every max latency interval.
+*/
+func NewGroupCommit(maxLatency time.Duration, syncFunc func() error) *GroupCommit {
+	return &GroupCommit{maxLatency, syncFunc, &sync.Mutex{}, nil, 0, 0}
+}
+
+/*
+MaxLatency returns the max time a writer waits before a sync is done.
+*/
+func (gc *GroupCommit) MaxLatency() time.Duration {
+	return gc.maxLatency
+}
+
+/*
+Wait waits until all changes which were flushed before the call have been
+committed to stable storage. Returns the error of the sync.
+*/
+func (gc *GroupCommit) Wait() error {
+	c := make(chan error, 1)
+
+	gc.mutex.Lock()
+
+	gc.waiters = append(gc.waiters, c)
+
+	if len(gc.waiters) == 1 {
+
+		// First writer of a new group schedules the sync
+
+		time.AfterFunc(gc.maxLatency, gc.sync)
+	}
+
+	gc.mutex.Unlock()
+
+	return <-c
+}
+
+/*
+Stats returns the number of commits which were made durable and the number
+of syncs which were necessary to do so.
+*/
+func (gc *GroupCommit) Stats() (uint64, uint64) {
+	gc.mutex.Lock()
+	defer gc.mutex.Unlock()
+
+	return gc.commits, gc.syncs
+}
+
+/*
+sync syncs all flushed changes and notifies all waiting writers of the
+current group.
+*/
+func (gc *GroupCommit) sync() {
+
+	gc.mutex.Lock()
+
+	waiters := gc.waiters
+	gc.waiters = nil
+
+	gc.commits += uint64(len(waiters))
+	gc.syncs++
+
+	gc.mutex.Unlock()
+
+	err := gc.syncFunc()
+
+	for _, c := range waiters {
+		c <- err
+	}
+}
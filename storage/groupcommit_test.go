@@ -0,0 +1,74 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	var syncErr error
+
+	gc := NewGroupCommit(20*time.Millisecond, func() error {
+		return syncErr
+	})
+
+	if res := gc.MaxLatency(); res != 20*time.Millisecond {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Concurrent writers share a single sync
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- gc.Wait()
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if commits, syncs := gc.Stats(); commits != 10 || syncs == 0 || syncs >= 10 {
+		t.Error("Unexpected result:", commits, syncs)
+		return
+	}
+
+	// Errors of the sync are returned to all writers of a group
+
+	syncErr = errors.New("Testerror")
+
+	if err := gc.Wait(); err == nil || err.Error() != "Testerror" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if commits, _ := gc.Stats(); commits != 11 {
+		t.Error("Unexpected result:", commits)
+		return
+	}
+}
@@ -0,0 +1,499 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/codec"
+)
+
+/*
+KeyValueStore models a key-value store which can be used as storage engine
+of a KVStorageManager.
+*/
+type KeyValueStore interface {
+
+	/*
+		Get returns the value of a given key. The returned flag is false if
+		the key does not exist.
+	*/
+	Get(key []byte) ([]byte, bool, error)
+
+	/*
+		Write writes a batch of updates atomically. Keys with a nil value are
+		deleted.
+	*/
+	Write(updates map[string][]byte) error
+
+	/*
+		Sync makes sure that all writes have been committed to stable storage.
+	*/
+	Sync() error
+
+	/*
+		Close closes the store.
+	*/
+	Close() error
+}
+
+/*
+KeyValueCompactor is an optional interface for key-value stores which can
+reclaim the space of deleted and overwritten values.
+*/
+type KeyValueCompactor interface {
+
+	/*
+		Compact reclaims the space of deleted and overwritten values. Returns
+		the number of bytes which were reclaimed.
+	*/
+	Compact() (int64, error)
+}
+
+/*
+KeyValueSyncControl is an optional interface for key-value stores which can
+defer syncing writes to stable storage.
+*/
+type KeyValueSyncControl interface {
+
+	/*
+		SetSyncWrites sets if every write should be synced to stable storage.
+	*/
+	SetSyncWrites(syncWrites bool)
+}
+
+/*
+Key prefixes of a KVStorageManager
+*/
+const (
+	kvPrefixRecord  = 'l' // Prefix for stored records
+	kvPrefixRoot    = 'r' // Prefix for root values
+	kvKeyLocCounter = "c" // Key of the location counter
+)
+
+/*
+KVStorageManager is a storage manager which stores gob serializable objects
+in a key-value store. Changes are kept in memory until they are flushed - all
+changes of a flush are written as a single atomic batch.
+*/
+type KVStorageManager struct {
+	name       string            // Name of the storage manager
+	store      KeyValueStore     // Key-value store which holds the data
+	readonly   bool              // Flag for readonly mode
+	mutex      *sync.Mutex       // Mutex to protect the pending changes
+	pending    map[string][]byte // Pending changes (nil values are deletes)
+	locCount   uint64            // Counter for locations
+	compressor codec.Codec       // Codec for new records (nil for no compression)
+	encryption *Encryption       // Key ring for the encryption of records (nil for no encryption)
+}
+
+/*
+NewKVStorageManager creates a new storage manager on top of a given
+key-value store.
+*/
+func NewKVStorageManager(name string, store KeyValueStore, readonly bool) (*KVStorageManager, error) {
+
+	kvsm := &KVStorageManager{name, store, readonly, &sync.Mutex{},
+		make(map[string][]byte), 0, nil, nil}
+
+	if err := kvsm.loadLocCount(); err != nil {
+		return nil, err
+	}
+
+	return kvsm, nil
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (kvsm *KVStorageManager) Name() string {
+	return fmt.Sprint("KVStorage:", kvsm.name)
+}
+
+/*
+SetCompression sets the compression (e.g. flate or gzip:9) which is used for records
+which are inserted or updated from now on. Compression is switched off with
+the name none.
+*/
+func (kvsm *KVStorageManager) SetCompression(name string) error {
+
+	c, err := codec.Get(name)
+
+	if err == nil {
+		kvsm.mutex.Lock()
+		kvsm.compressor = c
+		kvsm.mutex.Unlock()
+	}
+
+	return err
+}
+
+/*
+SetEncryption sets the key ring which is used to encrypt records which are
+inserted or updated from now on. Encryption is switched off with nil.
+*/
+func (kvsm *KVStorageManager) SetEncryption(e *Encryption) {
+	kvsm.mutex.Lock()
+	kvsm.encryption = e
+	kvsm.mutex.Unlock()
+}
+
+/*
+Root returns a root value. Default (empty) value is 0.
+*/
+func (kvsm *KVStorageManager) Root(root int) uint64 {
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	v, ok, _ := kvsm.get(rootKey(root))
+	if !ok || len(v) != 8 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+/*
+SetRoot writes a root value.
+*/
+func (kvsm *KVStorageManager) SetRoot(root int, val uint64) {
+
+	// When readonly this operation becomes a NOP
+
+	if kvsm.readonly {
+		return
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	kvsm.pending[rootKey(root)] = uint64Bytes(val)
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (kvsm *KVStorageManager) Insert(o interface{}) (uint64, error) {
+
+	if kvsm.readonly {
+		return 0, ErrReadonly
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	// Locations must start > 0 - roots with the value 0 are considered empty
+
+	loc := kvsm.locCount + 1
+
+	b, err := kvsm.serialize(o, loc)
+	if err != nil {
+		return 0, err
+	}
+
+	kvsm.locCount = loc
+
+	kvsm.pending[kvKeyLocCounter] = uint64Bytes(kvsm.locCount)
+	kvsm.pending[recordKey(loc)] = b
+
+	return loc, nil
+}
+
+/*
+Update updates a storage location.
+*/
+func (kvsm *KVStorageManager) Update(loc uint64, o interface{}) error {
+
+	if kvsm.readonly {
+		return ErrReadonly
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	if err := kvsm.checkLocation(loc); err != nil {
+		return err
+	}
+
+	b, err := kvsm.serialize(o, loc)
+	if err != nil {
+		return err
+	}
+
+	kvsm.pending[recordKey(loc)] = b
+
+	return nil
+}
+
+/*
+Free frees a storage location.
+*/
+func (kvsm *KVStorageManager) Free(loc uint64) error {
+
+	if kvsm.readonly {
+		return ErrReadonly
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	if err := kvsm.checkLocation(loc); err != nil {
+		return err
+	}
+
+	kvsm.pending[recordKey(loc)] = nil
+
+	return nil
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (kvsm *KVStorageManager) Fetch(loc uint64, o interface{}) error {
+
+	kvsm.mutex.Lock()
+	v, ok, err := kvsm.get(recordKey(loc))
+	e := kvsm.encryption
+	kvsm.mutex.Unlock()
+
+	if err != nil {
+		return err
+	} else if !ok {
+		return NewStorageManagerError(ErrSlotNotFound, fmt.Sprint("Location:", loc), kvsm.Name())
+	}
+
+	plain, err := decryptRecord(e, bytes.NewBuffer(v), loc)
+	if err != nil {
+		return err
+	}
+
+	r, err := decompressRecord(plain)
+	if err != nil {
+		return err
+	}
+
+	return gob.NewDecoder(r).Decode(o)
+}
+
+/*
+FetchCached is not implemented for a KVStorageManager.
+Only defined to satisfy the StorageManager interface.
+*/
+func (kvsm *KVStorageManager) FetchCached(loc uint64) (interface{}, error) {
+	return nil, NewStorageManagerError(ErrNotInCache, "", kvsm.Name())
+}
+
+/*
+Flush writes all pending changes to the key-value store.
+*/
+func (kvsm *KVStorageManager) Flush() error {
+
+	if kvsm.readonly {
+		return nil
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	if err := kvsm.store.Write(kvsm.pending); err != nil {
+		return err
+	}
+
+	kvsm.pending = make(map[string][]byte)
+
+	return nil
+}
+
+/*
+Sync writes all pending changes to the key-value store and makes sure that
+they have been committed to stable storage.
+*/
+func (kvsm *KVStorageManager) Sync() error {
+
+	if err := kvsm.Flush(); err != nil || kvsm.readonly {
+		return err
+	}
+
+	return kvsm.store.Sync()
+}
+
+/*
+SetDeferSync sets if syncing on every flush should be deferred. Flushed
+changes are only durable after SyncLog has been called. Has no effect if
+the key-value store cannot defer syncs.
+*/
+func (kvsm *KVStorageManager) SetDeferSync(deferSync bool) {
+	if sc, ok := kvsm.store.(KeyValueSyncControl); ok {
+		sc.SetSyncWrites(!deferSync)
+	}
+}
+
+/*
+SyncLog makes sure that all flushed changes have been committed to stable
+storage. Pending changes are not flushed.
+*/
+func (kvsm *KVStorageManager) SyncLog() error {
+
+	if kvsm.readonly {
+		return nil
+	}
+
+	return kvsm.store.Sync()
+}
+
+/*
+Compact reclaims the space of deleted and overwritten records if the
+key-value store supports it. Pending changes are flushed first. The batch
+size and pause are not used since the key-value store compacts its files
+as a whole.
+*/
+func (kvsm *KVStorageManager) Compact(batchSize int, pause time.Duration,
+	progress CompactionProgress) (int64, error) {
+
+	kvc, ok := kvsm.store.(KeyValueCompactor)
+	if !ok || kvsm.readonly {
+		return 0, nil
+	}
+
+	if err := kvsm.Flush(); err != nil {
+		return 0, err
+	}
+
+	reclaimed, err := kvc.Compact()
+
+	if err == nil && progress != nil {
+		progress(1, 1)
+	}
+
+	return reclaimed, err
+}
+
+/*
+Rollback cancels all pending changes which have not yet been flushed.
+*/
+func (kvsm *KVStorageManager) Rollback() error {
+
+	if kvsm.readonly {
+		return nil
+	}
+
+	kvsm.mutex.Lock()
+	defer kvsm.mutex.Unlock()
+
+	kvsm.pending = make(map[string][]byte)
+
+	return kvsm.loadLocCount()
+}
+
+/*
+Close the StorageManager and write all pending changes to disk.
+*/
+func (kvsm *KVStorageManager) Close() error {
+
+	if err := kvsm.Flush(); err != nil {
+		return err
+	}
+
+	return kvsm.store.Close()
+}
+
+/*
+serialize serializes, compresses and encrypts an object for a given storage
+location. Assumes that the caller holds the mutex.
+*/
+func (kvsm *KVStorageManager) serialize(o interface{}, loc uint64) ([]byte, error) {
+	var bb bytes.Buffer
+
+	if err := gob.NewEncoder(&bb).Encode(o); err != nil {
+		return nil, err
+	}
+
+	b, err := compressRecord(kvsm.compressor, bb.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptRecord(kvsm.encryption, b, loc)
+}
+
+/*
+get returns a value from the pending changes or the key-value store. Assumes
+that the caller holds the mutex.
+*/
+func (kvsm *KVStorageManager) get(key string) ([]byte, bool, error) {
+
+	if v, ok := kvsm.pending[key]; ok {
+		return v, v != nil, nil
+	}
+
+	return kvsm.store.Get([]byte(key))
+}
+
+/*
+checkLocation checks that a given location holds a record. Assumes that the
+caller holds the mutex.
+*/
+func (kvsm *KVStorageManager) checkLocation(loc uint64) error {
+
+	_, ok, err := kvsm.get(recordKey(loc))
+
+	if err == nil && !ok {
+		err = NewStorageManagerError(ErrSlotNotFound, fmt.Sprint("Location:", loc), kvsm.Name())
+	}
+
+	return err
+}
+
+/*
+loadLocCount loads the location counter from the key-value store. Assumes
+that the caller holds the mutex.
+*/
+func (kvsm *KVStorageManager) loadLocCount() error {
+
+	v, ok, err := kvsm.store.Get([]byte(kvKeyLocCounter))
+
+	kvsm.locCount = 0
+
+	if ok && len(v) == 8 {
+		kvsm.locCount = binary.BigEndian.Uint64(v)
+	}
+
+	return err
+}
+
+/*
+recordKey returns the key of a record.
+*/
+func recordKey(loc uint64) string {
+	return string(append([]byte{kvPrefixRecord}, uint64Bytes(loc)...))
+}
+
+/*
+rootKey returns the key of a root value.
+*/
+func rootKey(root int) string {
+	return string(append([]byte{kvPrefixRoot}, uint64Bytes(uint64(root))...))
+}
+
+/*
+uint64Bytes returns the big endian representation of a number.
+*/
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
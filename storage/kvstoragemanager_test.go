@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"testing"
+)
+
+func TestKVStorageManager(t *testing.T) {
+	dir := DBDIR + "/kvtest"
+
+	store := NewMemoryKeyValueStore()
+
+	kvsm, err := NewKVStorageManager(dir, store, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := kvsm.Name(); res != "KVStorage:"+dir {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	loc, err := kvsm.Insert("test1")
+	if err != nil || loc != 1 {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	loc2, _ := kvsm.Insert("test2")
+
+	kvsm.SetRoot(RootIDVersion, loc2)
+
+	if err := kvsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Pending changes can be rolled back
+
+	kvsm.Update(loc, "test3")
+	kvsm.Free(loc2)
+	kvsm.Insert("test4")
+	kvsm.SetRoot(RootIDVersion, 5)
+
+	var res string
+
+	if err := kvsm.Fetch(loc, &res); err != nil || res != "test3" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := kvsm.Fetch(loc2, &res); err == nil {
+		t.Error("Freed location should not be found")
+		return
+	}
+
+	if err := kvsm.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := kvsm.Fetch(loc, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := kvsm.Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if r := kvsm.Root(RootIDVersion); r != loc2 {
+		t.Error("Unexpected root:", r)
+		return
+	}
+
+	if _, err := kvsm.FetchCached(loc); err.(*ManagerError).Type != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kvsm.Update(10, "test"); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kvsm.Free(10); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Compressed records can be read
+
+	kvsm.SetCompression("flate")
+
+	long := string(make([]byte, 1000))
+
+	loc3, _ := kvsm.Insert(long)
+
+	if loc3 != 3 {
+		t.Error("Unexpected location:", loc3)
+		return
+	}
+
+	// Compaction is a NOP if the store does not support it
+
+	if res, err := kvsm.Compact(10, 0, nil); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := kvsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Reopen the store
+
+	kvsm, err = NewKVStorageManager(dir, store, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := kvsm.Fetch(loc3, &res); err != nil || res != long {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if _, err := kvsm.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	kvsm.Close()
+}
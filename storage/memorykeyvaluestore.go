@@ -0,0 +1,92 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"sync"
+)
+
+/*
+MemoryKeyValueStore is a memory-only key-value store which can be used for
+testing and for temporary data.
+*/
+type MemoryKeyValueStore struct {
+	Data  map[string][]byte // Stored values
+	mutex *sync.RWMutex     // Mutex to protect map operations
+}
+
+/*
+NewMemoryKeyValueStore creates a new MemoryKeyValueStore.
+*/
+func NewMemoryKeyValueStore() *MemoryKeyValueStore {
+	return &MemoryKeyValueStore{make(map[string][]byte), &sync.RWMutex{}}
+}
+
+/*
+Get returns the value of a given key. The returned flag is false if the key
+does not exist.
+*/
+func (mkvs *MemoryKeyValueStore) Get(key []byte) ([]byte, bool, error) {
+	mkvs.mutex.RLock()
+	defer mkvs.mutex.RUnlock()
+
+	v, ok := mkvs.Data[string(key)]
+
+	return v, ok, nil
+}
+
+/*
+Write writes a batch of updates. Keys with a nil value are deleted.
+*/
+func (mkvs *MemoryKeyValueStore) Write(updates map[string][]byte) error {
+	mkvs.mutex.Lock()
+	defer mkvs.mutex.Unlock()
+
+	for k, v := range updates {
+		if v == nil {
+			delete(mkvs.Data, k)
+		} else {
+			mkvs.Data[k] = append([]byte{}, v...)
+		}
+	}
+
+	return nil
+}
+
+/*
+Items returns a copy of all stored values.
+*/
+func (mkvs *MemoryKeyValueStore) Items() map[string][]byte {
+	mkvs.mutex.RLock()
+	defer mkvs.mutex.RUnlock()
+
+	ret := make(map[string][]byte, len(mkvs.Data))
+
+	for k, v := range mkvs.Data {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+/*
+Sync is a NOP for a MemoryKeyValueStore.
+*/
+func (mkvs *MemoryKeyValueStore) Sync() error {
+	return nil
+}
+
+/*
+Close is a NOP for a MemoryKeyValueStore.
+*/
+func (mkvs *MemoryKeyValueStore) Close() error {
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/eliasdb/storage/file"
@@ -38,9 +39,9 @@ MsmRetClose nil or the error which should be returned by a Close call
 var MsmRetClose error
 
 /*
-MsmCallNumClose counter how often Close is called
+MsmCallNumClose counter how often Close is called (updated atomically)
 */
-var MsmCallNumClose int
+var MsmCallNumClose int64
 
 /*
 MsmRetFlush nil or the error which should be returned by a Flush call
@@ -48,9 +49,9 @@ MsmRetFlush nil or the error which should be returned by a Flush call
 var MsmRetFlush error
 
 /*
-MsmCallNumFlush counter how often Flush is called
+MsmCallNumFlush counter how often Flush is called (updated atomically)
 */
-var MsmCallNumFlush int
+var MsmCallNumFlush int64
 
 /*
 MsmRetRollback nil or the error which should be returned by a Rollback call
@@ -58,9 +59,9 @@ MsmRetRollback nil or the error which should be returned by a Rollback call
 var MsmRetRollback error
 
 /*
-MsmCallNumRollback counter how often Rollback is called
+MsmCallNumRollback counter how often Rollback is called (updated atomically)
 */
-var MsmCallNumRollback int
+var MsmCallNumRollback int64
 
 /*
 MemoryStorageManager data structure
@@ -203,7 +204,7 @@ func (msm *MemoryStorageManager) FetchCached(loc uint64) (interface{}, error) {
 Flush writes all pending changes to disk.
 */
 func (msm *MemoryStorageManager) Flush() error {
-	MsmCallNumFlush++
+	atomic.AddInt64(&MsmCallNumFlush, 1)
 	return MsmRetFlush
 }
 
@@ -211,7 +212,7 @@ func (msm *MemoryStorageManager) Flush() error {
 Rollback cancels all pending changes which have not yet been written to disk.
 */
 func (msm *MemoryStorageManager) Rollback() error {
-	MsmCallNumRollback++
+	atomic.AddInt64(&MsmCallNumRollback, 1)
 	return MsmRetRollback
 }
 
@@ -219,7 +220,7 @@ func (msm *MemoryStorageManager) Rollback() error {
 Close the StorageManager and write all pending changes to disk.
 */
 func (msm *MemoryStorageManager) Close() error {
-	MsmCallNumClose++
+	atomic.AddInt64(&MsmCallNumClose, 1)
 	return MsmRetClose
 }
 
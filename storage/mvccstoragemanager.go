@@ -0,0 +1,429 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/krotik/common/datautil"
+)
+
+/*
+MVCCStorageManager wraps a storage manager and allows readers to work on
+consistent snapshots while writers continue to change the data (multi-version
+concurrency control).
+
+Objects which are handed out by a storage manager may be changed in place
+before they are written back. A snapshot therefore keeps a copy of every
+record which is accessed through this storage manager after the snapshot was
+taken. Root values are kept when they are changed. Freeing records is
+deferred until no snapshot can see the record anymore.
+*/
+type MVCCStorageManager struct {
+	sm             Manager                          // Wrapped storage manager
+	snapshots      map[*SnapshotStorageManager]bool // Open snapshots
+	pendingFrees   []uint64                         // Deferred frees of the current transaction
+	committedFrees []uint64                         // Deferred frees of committed transactions
+	mutex          *sync.Mutex                      // Mutex to protect snapshots and write operations
+}
+
+/*
+NewMVCCStorageManager creates a new MVCCStorageManager which wraps a given
+storage manager.
+*/
+func NewMVCCStorageManager(sm Manager) *MVCCStorageManager {
+	return &MVCCStorageManager{sm, make(map[*SnapshotStorageManager]bool),
+		nil, nil, &sync.Mutex{}}
+}
+
+/*
+Snapshot returns a read-only view of the current state of the storage. The
+snapshot must be closed once it is no longer needed.
+*/
+func (mvcc *MVCCStorageManager) Snapshot() *SnapshotStorageManager {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	s := &SnapshotStorageManager{mvcc, make(map[int]uint64), make(map[uint64]interface{}), false}
+	mvcc.snapshots[s] = true
+
+	return s
+}
+
+/*
+Snapshots returns the number of open snapshots.
+*/
+func (mvcc *MVCCStorageManager) Snapshots() int {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	return len(mvcc.snapshots)
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (mvcc *MVCCStorageManager) Name() string {
+	return mvcc.sm.Name()
+}
+
+/*
+Root returns a root value.
+*/
+func (mvcc *MVCCStorageManager) Root(root int) uint64 {
+	return mvcc.sm.Root(root)
+}
+
+/*
+SetRoot writes a root value.
+*/
+func (mvcc *MVCCStorageManager) SetRoot(root int, val uint64) {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	for s := range mvcc.snapshots {
+		if _, ok := s.roots[root]; !ok {
+			s.roots[root] = mvcc.sm.Root(root)
+		}
+	}
+
+	mvcc.sm.SetRoot(root, val)
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (mvcc *MVCCStorageManager) Insert(o interface{}) (uint64, error) {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	loc, err := mvcc.sm.Insert(o)
+
+	if err == nil {
+
+		// The location did not exist when the open snapshots were taken
+
+		for s := range mvcc.snapshots {
+			if _, ok := s.records[loc]; !ok {
+				s.records[loc] = nil
+			}
+		}
+	}
+
+	return loc, err
+}
+
+/*
+Update updates a storage location.
+*/
+func (mvcc *MVCCStorageManager) Update(loc uint64, o interface{}) error {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	if mvcc.needsCopy(loc) {
+
+		// Keep the current version of the record for the open snapshots
+
+		obj := newContainer(o)
+
+		if err := mvcc.sm.Fetch(loc, obj); err != nil {
+			return err
+		}
+
+		mvcc.keepRecord(loc, obj)
+	}
+
+	return mvcc.sm.Update(loc, o)
+}
+
+/*
+Free frees a storage location. The location is only freed once no open
+snapshot can see it anymore.
+*/
+func (mvcc *MVCCStorageManager) Free(loc uint64) error {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	if len(mvcc.snapshots) == 0 {
+		return mvcc.sm.Free(loc)
+	}
+
+	mvcc.pendingFrees = append(mvcc.pendingFrees, loc)
+
+	return nil
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (mvcc *MVCCStorageManager) Fetch(loc uint64, o interface{}) error {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	err := mvcc.sm.Fetch(loc, o)
+
+	if err == nil && mvcc.needsCopy(loc) {
+
+		// The fetched object might be changed by the caller
+
+		mvcc.keepCopy(loc, o)
+	}
+
+	return err
+}
+
+/*
+FetchCached fetches an object from a cache and returns its reference.
+Returns a storage.ErrNotInCache error if the entry is not in the cache.
+*/
+func (mvcc *MVCCStorageManager) FetchCached(loc uint64) (interface{}, error) {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	o, err := mvcc.sm.FetchCached(loc)
+
+	if err == nil && o != nil && mvcc.needsCopy(loc) {
+
+		// The cached object might be changed by the caller
+
+		mvcc.keepCopy(loc, o)
+	}
+
+	return o, err
+}
+
+/*
+Flush writes all pending changes to disk. Deferred frees are carried out
+if there are no open snapshots.
+*/
+func (mvcc *MVCCStorageManager) Flush() error {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	mvcc.committedFrees = append(mvcc.committedFrees, mvcc.pendingFrees...)
+	mvcc.pendingFrees = nil
+
+	if len(mvcc.snapshots) == 0 {
+		for len(mvcc.committedFrees) > 0 {
+			if err := mvcc.sm.Free(mvcc.committedFrees[0]); err != nil {
+				return err
+			}
+			mvcc.committedFrees = mvcc.committedFrees[1:]
+		}
+	}
+
+	return mvcc.sm.Flush()
+}
+
+/*
+Rollback cancels all pending changes which have not yet been written to disk.
+*/
+func (mvcc *MVCCStorageManager) Rollback() error {
+	mvcc.mutex.Lock()
+	defer mvcc.mutex.Unlock()
+
+	mvcc.pendingFrees = nil
+
+	return mvcc.sm.Rollback()
+}
+
+/*
+Close the StorageManager and write all pending changes to disk.
+*/
+func (mvcc *MVCCStorageManager) Close() error {
+	return mvcc.sm.Close()
+}
+
+/*
+needsCopy checks if an open snapshot has no version of a given record. Assumes
+that the mutex is held.
+*/
+func (mvcc *MVCCStorageManager) needsCopy(loc uint64) bool {
+	for s := range mvcc.snapshots {
+		if _, ok := s.records[loc]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+keepRecord stores a record in all open snapshots which have no version yet.
+Assumes that the mutex is held.
+*/
+func (mvcc *MVCCStorageManager) keepRecord(loc uint64, obj interface{}) {
+	for s := range mvcc.snapshots {
+		if _, ok := s.records[loc]; !ok {
+			s.records[loc] = obj
+		}
+	}
+}
+
+/*
+keepCopy stores a copy of a record in all open snapshots which have no version
+yet. Assumes that the mutex is held. Objects which cannot be copied are not
+kept - snapshots read them from the wrapped storage manager.
+*/
+func (mvcc *MVCCStorageManager) keepCopy(loc uint64, o interface{}) {
+	obj := newContainer(o)
+
+	if err := datautil.CopyObject(o, obj); err == nil {
+		mvcc.keepRecord(loc, obj)
+	}
+}
+
+/*
+SnapshotStorageManager is a read-only view of the data of a MVCCStorageManager
+at the time the snapshot was taken.
+*/
+type SnapshotStorageManager struct {
+	mvcc    *MVCCStorageManager    // Storage manager which created the snapshot
+	roots   map[int]uint64         // Root values which were changed after the snapshot was taken
+	records map[uint64]interface{} // Records which were changed after the snapshot was taken
+	closed  bool                   // Flag if the snapshot was closed
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (s *SnapshotStorageManager) Name() string {
+	return s.mvcc.Name()
+}
+
+/*
+Root returns a root value.
+*/
+func (s *SnapshotStorageManager) Root(root int) uint64 {
+	s.mvcc.mutex.Lock()
+	defer s.mvcc.mutex.Unlock()
+
+	if val, ok := s.roots[root]; ok {
+		return val
+	}
+
+	return s.mvcc.sm.Root(root)
+}
+
+/*
+SetRoot writes a root value. Root values of a snapshot cannot be changed.
+*/
+func (s *SnapshotStorageManager) SetRoot(root int, val uint64) {
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (s *SnapshotStorageManager) Insert(o interface{}) (uint64, error) {
+	return 0, ErrReadonly
+}
+
+/*
+Update updates a storage location.
+*/
+func (s *SnapshotStorageManager) Update(loc uint64, o interface{}) error {
+	return ErrReadonly
+}
+
+/*
+Free frees a storage location.
+*/
+func (s *SnapshotStorageManager) Free(loc uint64) error {
+	return ErrReadonly
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (s *SnapshotStorageManager) Fetch(loc uint64, o interface{}) error {
+	s.mvcc.mutex.Lock()
+	defer s.mvcc.mutex.Unlock()
+
+	if s.closed {
+		return NewStorageManagerError(ErrSlotNotFound, "Snapshot was closed", s.Name())
+	}
+
+	obj, ok := s.records[loc]
+
+	if !ok {
+
+		// The record was not changed - the wrapped storage manager might
+		// keep the given container in a cache so a copy is used
+
+		obj = newContainer(o)
+
+		if err := s.mvcc.sm.Fetch(loc, obj); err != nil {
+			return err
+		}
+
+	} else if obj == nil {
+
+		return NewStorageManagerError(ErrSlotNotFound, fmt.Sprint("Location:", loc), s.Name())
+	}
+
+	return datautil.CopyObject(obj, o)
+}
+
+/*
+FetchCached fetches an object from a cache and returns its reference.
+A snapshot has no cache.
+*/
+func (s *SnapshotStorageManager) FetchCached(loc uint64) (interface{}, error) {
+	return nil, NewStorageManagerError(ErrNotInCache, "", s.Name())
+}
+
+/*
+Flush writes all pending changes to disk. A snapshot has no pending changes.
+*/
+func (s *SnapshotStorageManager) Flush() error {
+	return nil
+}
+
+/*
+Rollback cancels all pending changes which have not yet been written to disk.
+A snapshot has no pending changes.
+*/
+func (s *SnapshotStorageManager) Rollback() error {
+	return nil
+}
+
+/*
+Close releases the snapshot.
+*/
+func (s *SnapshotStorageManager) Close() error {
+	s.mvcc.mutex.Lock()
+	defer s.mvcc.mutex.Unlock()
+
+	delete(s.mvcc.snapshots, s)
+
+	s.closed = true
+	s.roots = nil
+	s.records = nil
+
+	return nil
+}
+
+/*
+newContainer creates a new data container of the same type as a given
+data container.
+*/
+func newContainer(o interface{}) interface{} {
+	t := reflect.TypeOf(o)
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return reflect.New(t).Interface()
+}
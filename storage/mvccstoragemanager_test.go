@@ -0,0 +1,199 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMVCCStorageManager(t *testing.T) {
+	msm := NewMemoryStorageManager("test")
+	mvcc := NewMVCCStorageManager(msm)
+
+	if mvcc.Name() != "test" {
+		t.Error("Unexpected name:", mvcc.Name())
+		return
+	}
+
+	loc1, _ := mvcc.Insert("foo")
+	loc2, _ := mvcc.Insert("bar")
+	loc4, _ := mvcc.Insert(map[string]string{"a": "1"})
+	mvcc.SetRoot(2, loc1)
+
+	if obj, err := mvcc.FetchCached(loc1); obj != "foo" || err != nil {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	s := mvcc.Snapshot()
+
+	if mvcc.Snapshots() != 1 || s.Name() != "test" {
+		t.Error("Unexpected result:", mvcc.Snapshots(), s.Name())
+		return
+	}
+
+	// Change the data after the snapshot was taken
+
+	mvcc.Update(loc1, "foo2")
+	mvcc.Update(loc1, "foo3")
+	mvcc.Free(loc2)
+	loc3, _ := mvcc.Insert("xxx")
+	mvcc.Update(loc3, "xxx2")
+	mvcc.SetRoot(2, loc3)
+	mvcc.SetRoot(3, 5)
+
+	// Objects which are changed in place are preserved for the snapshot
+
+	obj, err := mvcc.FetchCached(loc4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	obj.(map[string]string)["a"] = "2"
+
+	var resMap map[string]string
+
+	if err := s.Fetch(loc4, &resMap); resMap["a"] != "1" || err != nil {
+		t.Error("Unexpected result:", resMap, err)
+		return
+	}
+
+	if err := mvcc.Fetch(loc4, &resMap); resMap["a"] != "2" || err != nil {
+		t.Error("Unexpected result:", resMap, err)
+		return
+	}
+
+	var res string
+
+	if err := mvcc.Fetch(loc1, &res); res != "foo3" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if mvcc.Root(2) != loc3 || mvcc.Root(3) != 5 {
+		t.Error("Unexpected roots:", mvcc.Root(2), mvcc.Root(3))
+		return
+	}
+
+	// The snapshot still sees the old data
+
+	if err := s.Fetch(loc1, &res); res != "foo" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := s.Fetch(loc2, &res); res != "bar" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := s.Fetch(loc3, &res); err == nil || err.Error() != fmt.Sprint("Slot not found (test - Location:", loc3, ")") {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if s.Root(2) != loc1 || s.Root(3) != 0 {
+		t.Error("Unexpected roots:", s.Root(2), s.Root(3))
+		return
+	}
+
+	if obj, err := s.FetchCached(loc1); obj != nil || err == nil {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	// Snapshots cannot be changed
+
+	if _, err := s.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Update(loc1, "test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Free(loc1); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	s.SetRoot(2, 99)
+
+	if err := s.Flush(); err != nil || s.Root(2) != loc1 {
+		t.Error("Unexpected result:", err, s.Root(2))
+		return
+	}
+
+	if err := s.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Freeing is deferred while the snapshot is open
+
+	mvcc.Flush()
+
+	if _, ok := msm.Data[loc2]; !ok {
+		t.Error("Location should not have been freed yet")
+		return
+	}
+
+	s2 := mvcc.Snapshot()
+
+	mvcc.Free(loc1)
+	mvcc.Rollback()
+
+	s.Close()
+
+	if err := s.Fetch(loc1, &res); err == nil || err.Error() != "Slot not found (test - Snapshot was closed)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	s2.Close()
+
+	if mvcc.Snapshots() != 0 {
+		t.Error("Unexpected number of snapshots:", mvcc.Snapshots())
+		return
+	}
+
+	mvcc.Flush()
+
+	if _, ok := msm.Data[loc2]; ok {
+		t.Error("Location should have been freed")
+		return
+	}
+
+	// The rolled back free was not carried out
+
+	if _, ok := msm.Data[loc1]; !ok {
+		t.Error("Location should not have been freed")
+		return
+	}
+
+	// Without snapshots locations are freed immediately
+
+	mvcc.Free(loc1)
+
+	if _, ok := msm.Data[loc1]; ok {
+		t.Error("Location should have been freed")
+		return
+	}
+
+	if err := mvcc.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
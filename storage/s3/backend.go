@@ -0,0 +1,720 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+/*
+Configuration keys of the backend
+*/
+const (
+	ConfEndpoint    = "Endpoint"
+	ConfBucket      = "Bucket"
+	ConfRegion      = "Region"
+	ConfAccessKey   = "AccessKey"
+	ConfSecretKey   = "SecretKey"
+	ConfPrefix      = "Prefix"
+	ConfSegmentSize = "SegmentSize"
+)
+
+/*
+DefaultConfig is the default configuration of the backend.
+*/
+var DefaultConfig = map[string]interface{}{
+	ConfEndpoint:    "https://s3.amazonaws.com",
+	ConfBucket:      "eliasdb",
+	ConfRegion:      "us-east-1",
+	ConfAccessKey:   "",
+	ConfSecretKey:   "",
+	ConfPrefix:      "",
+	ConfSegmentSize: 4194304,
+}
+
+/*
+MaxSegmentParts is the maximum number of objects which make up a segment.
+Data which is appended to a segment is uploaded as an additional part - once a
+segment has this many parts it is uploaded again as a whole.
+*/
+var MaxSegmentParts = 16
+
+/*
+manifestObject is the name of the object which holds the manifest of a file.
+*/
+const manifestObject = "manifest"
+
+/*
+manifest describes a version of a file in the object storage. A new version
+is published by storing its manifest - objects are never changed after they
+were referenced by a manifest.
+*/
+type manifest struct {
+	Size        int64      // Size of the file
+	Version     int64      // Version of the file
+	SegmentSize int64      // Segment size of the file
+	Segments    [][]string // Object keys of the parts of each segment
+}
+
+/*
+segmentLength returns the length of a segment in this version of the file.
+*/
+func (m *manifest) segmentLength(segment int64) int64 {
+
+	if segment >= int64(len(m.Segments)) {
+		return 0
+	}
+
+	length := m.Size - segment*m.SegmentSize
+
+	if length > m.SegmentSize {
+		length = m.SegmentSize
+	} else if length < 0 {
+		length = 0
+	}
+
+	return length
+}
+
+/*
+Backend is a storage backend which keeps files in a local directory and
+persists them in segments to S3-compatible object storage.
+*/
+type Backend struct {
+	client      *Client     // Object storage client
+	prefix      string      // Prefix for all object keys
+	segmentSize int64       // Size of a segment of new files
+	mutex       *sync.Mutex // Mutex to protect the restoring of files
+}
+
+/*
+NewBackend creates a new backend from a given configuration.
+*/
+func NewBackend(config map[string]interface{}) (*Backend, error) {
+
+	str := func(key string) string {
+		return fmt.Sprint(config[key])
+	}
+
+	segmentSize, err := strconv.ParseInt(str(ConfSegmentSize), 10, 64)
+	if err != nil || segmentSize < 1 {
+		return nil, fmt.Errorf("Invalid segment size: %v", config[ConfSegmentSize])
+	}
+
+	if str(ConfBucket) == "" {
+		return nil, fmt.Errorf("Need a bucket")
+	}
+
+	client := NewClient(str(ConfEndpoint), str(ConfBucket), str(ConfRegion),
+		str(ConfAccessKey), str(ConfSecretKey), nil)
+
+	return NewBackendWithClient(client, str(ConfPrefix), segmentSize), nil
+}
+
+/*
+NewBackendWithClient creates a new backend with a given client.
+*/
+func NewBackendWithClient(client *Client, prefix string, segmentSize int64) *Backend {
+	return &Backend{client, strings.Trim(prefix, "/"), segmentSize, &sync.Mutex{}}
+}
+
+/*
+OpenFile opens a file. A file which does not exist locally is restored from
+the object storage.
+*/
+func (b *Backend) OpenFile(name string, flag int, perm os.FileMode) (file.BackendFile, error) {
+
+	remote, err := b.manifest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_TRUNC == 0 && remote != nil {
+		if err := b.restore(name, remote); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.newBackendFile(f, name, remote, flag&os.O_TRUNC != 0), nil
+}
+
+/*
+Exists checks if a file exists.
+*/
+func (b *Backend) Exists(name string) (bool, error) {
+
+	if _, err := os.Stat(name); err == nil {
+		return true, nil
+	}
+
+	remote, err := b.manifest(name)
+
+	return remote != nil, err
+}
+
+/*
+Size returns the size of a file in bytes.
+*/
+func (b *Backend) Size(name string) (int64, error) {
+
+	if fi, err := os.Stat(name); err == nil {
+		return fi.Size(), nil
+	}
+
+	remote, err := b.manifest(name)
+	if err != nil {
+		return 0, err
+	} else if remote == nil {
+		return 0, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return remote.Size, nil
+}
+
+/*
+Glob returns the names of all files which match a given pattern.
+*/
+func (b *Backend) Glob(pattern string) ([]string, error) {
+
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+
+	for _, name := range names {
+		found[name] = true
+	}
+
+	// Add all files which only exist in the object storage
+
+	dir := filepath.Dir(pattern)
+
+	keys, err := b.client.List(b.key(dir) + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/"+manifestObject) {
+			continue
+		}
+
+		name := filepath.Join(dir, path.Base(path.Dir(key)))
+
+		if ok, _ := filepath.Match(pattern, name); ok && !found[name] {
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+/*
+Rename renames a file.
+*/
+func (b *Backend) Rename(oldname string, newname string) error {
+
+	// Make sure the file exists locally
+
+	f, err := b.OpenFile(oldname, os.O_RDONLY, 0660)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	if err := b.FileWritten(newname); err != nil {
+		return err
+	}
+
+	return b.removeRemote(oldname)
+}
+
+/*
+Remove removes a file.
+*/
+func (b *Backend) Remove(name string) error {
+
+	err := os.Remove(name)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if remote, rerr := b.manifest(name); rerr != nil {
+		return rerr
+	} else if remote == nil {
+		return err
+	}
+
+	return b.removeRemote(name)
+}
+
+/*
+FileWritten uploads a local file which was written without the backend.
+*/
+func (b *Backend) FileWritten(name string) error {
+
+	remote, err := b.manifest(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return b.newBackendFile(f, name, remote, true).upload()
+}
+
+/*
+key returns the object key prefix of a local file.
+*/
+func (b *Backend) key(name string) string {
+	key := filepath.ToSlash(filepath.Clean(name))
+
+	key = strings.TrimPrefix(key, "./")
+	key = strings.TrimPrefix(key, "/")
+
+	if b.prefix != "" {
+		key = b.prefix + "/" + key
+	}
+
+	return key
+}
+
+/*
+partKey returns the object key of a part of a segment which was uploaded for
+a given version of a local file.
+*/
+func (b *Backend) partKey(name string, segment int64, version int64) string {
+	return fmt.Sprintf("%v/%08d.%08d", b.key(name), segment, version)
+}
+
+/*
+manifest returns the manifest of a file in the object storage. Returns nil if
+the file does not exist.
+*/
+func (b *Backend) manifest(name string) (*manifest, error) {
+
+	data, err := b.client.Get(b.key(name) + "/" + manifestObject)
+
+	if _, ok := err.(*ErrNotFound); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest of %v: %v", name, err)
+	}
+
+	return m, nil
+}
+
+/*
+restore downloads a file from the object storage if it does not exist locally.
+*/
+func (b *Backend) restore(name string, m *manifest) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+
+	// Download into a temporary file so an interrupted download does not
+	// leave an incomplete file
+
+	tmpname := name + ".restore"
+
+	f, err := os.OpenFile(tmpname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+
+	for _, parts := range m.Segments {
+		for _, key := range parts {
+			var data []byte
+
+			if data, err = b.client.Get(key); err == nil {
+				_, err = f.Write(data)
+				written += int64(len(data))
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil && written != m.Size {
+		err = fmt.Errorf("Restored %v bytes of %v but expected %v bytes", written, name, m.Size)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpname, name)
+	}
+
+	if err != nil {
+		os.Remove(tmpname)
+	}
+
+	return err
+}
+
+/*
+removeRemote removes all objects of a file from the object storage. The
+manifest is removed first so the file never refers to missing objects.
+*/
+func (b *Backend) removeRemote(name string) error {
+
+	err := b.client.Delete(b.key(name) + "/" + manifestObject)
+
+	if _, ok := err.(*ErrNotFound); ok {
+		err = nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keys, err := b.client.List(b.key(name) + "/")
+
+	for _, key := range keys {
+		if err == nil {
+			err = b.client.Delete(key)
+		}
+	}
+
+	return err
+}
+
+/*
+backendFile is a local file whose changed segments are uploaded to the object
+storage when the file is synced.
+*/
+type backendFile struct {
+	backend   *Backend        // Backend of the file
+	*os.File                  // Local file
+	name      string          // Name of the file
+	remote    *manifest       // Manifest of the file in the object storage
+	dirty     map[int64]int64 // Lowest changed offset of each changed segment
+	sizeDirty bool            // Flag if the manifest should be stored
+	garbage   []string        // Objects which are no longer referenced
+	mutex     *sync.Mutex     // Mutex to protect the dirty segments
+}
+
+/*
+newBackendFile creates a new backendFile. If the replace flag is set all
+objects of the remote file are replaced on the next upload.
+*/
+func (b *Backend) newBackendFile(f *os.File, name string, remote *manifest, replace bool) *backendFile {
+
+	bf := &backendFile{b, f, name, remote, make(map[int64]int64), false, nil, &sync.Mutex{}}
+
+	if remote == nil || replace {
+
+		// New and replaced files need to be stored
+
+		bf.remote = &manifest{0, 0, b.segmentSize, nil}
+		bf.sizeDirty = true
+
+		if remote != nil {
+			bf.remote.Version = remote.Version
+
+			for _, parts := range remote.Segments {
+				bf.garbage = append(bf.garbage, parts...)
+			}
+		}
+	}
+
+	return bf
+}
+
+/*
+Write writes data at the current offset of the file.
+*/
+func (bf *backendFile) Write(p []byte) (int, error) {
+
+	offset, err := bf.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := bf.File.Write(p)
+
+	bf.markDirty(offset, int64(n))
+
+	return n, err
+}
+
+/*
+WriteAt writes data at a given offset of the file.
+*/
+func (bf *backendFile) WriteAt(p []byte, off int64) (int, error) {
+
+	n, err := bf.File.WriteAt(p, off)
+
+	bf.markDirty(off, int64(n))
+
+	return n, err
+}
+
+/*
+Sync commits the file to the local disk and uploads all changed segments.
+*/
+func (bf *backendFile) Sync() error {
+
+	if err := bf.File.Sync(); err != nil {
+		return err
+	}
+
+	return bf.upload()
+}
+
+/*
+Close uploads all changed segments and closes the file.
+*/
+func (bf *backendFile) Close() error {
+
+	err := bf.upload()
+
+	if cerr := bf.File.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+/*
+markDirty marks all segments of a given range as changed.
+*/
+func (bf *backendFile) markDirty(offset int64, length int64) {
+
+	if length <= 0 {
+		return
+	}
+
+	bf.mutex.Lock()
+	defer bf.mutex.Unlock()
+
+	segmentSize := bf.remote.SegmentSize
+
+	for i := offset / segmentSize; i <= (offset+length-1)/segmentSize; i++ {
+		lowest := offset - i*segmentSize
+
+		if lowest < 0 {
+			lowest = 0
+		}
+
+		if l, ok := bf.dirty[i]; !ok || lowest < l {
+			bf.dirty[i] = lowest
+		}
+	}
+
+	bf.sizeDirty = true
+}
+
+/*
+upload uploads a new version of the file. Changed segments are uploaded as
+new objects - data which was only appended to a segment is uploaded as an
+additional part of the segment. The new version is published by storing its
+manifest. Objects which are no longer referenced are removed afterwards.
+*/
+func (bf *backendFile) upload() error {
+
+	bf.mutex.Lock()
+	defer bf.mutex.Unlock()
+
+	if len(bf.dirty) == 0 && !bf.sizeDirty {
+		bf.collectGarbage()
+		return nil
+	}
+
+	fi, err := bf.File.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := fi.Size()
+	segmentSize := bf.remote.SegmentSize
+	segments := (size + segmentSize - 1) / segmentSize
+
+	m := &manifest{size, bf.remote.Version + 1, segmentSize, make([][]string, segments)}
+
+	var obsolete []string
+
+	for i, parts := range bf.remote.Segments {
+		if int64(i) < segments {
+			m.Segments[i] = append([]string{}, parts...)
+		} else {
+			obsolete = append(obsolete, parts...)
+		}
+	}
+
+	buf := make([]byte, segmentSize)
+
+	// Objects of a failed upload are not referenced by any manifest. They
+	// are overwritten by the next upload or removed afterwards
+
+	var uploaded []string
+
+	defer func() {
+		if err != nil {
+			bf.garbage = append(bf.garbage, uploaded...)
+		}
+	}()
+
+	for i := int64(0); i < segments; i++ {
+		start := i * segmentSize
+		length := size - start
+
+		if length > segmentSize {
+			length = segmentSize
+		}
+
+		remoteLength := bf.remote.segmentLength(i)
+
+		lowest, ok := bf.dirty[i]
+		if !ok {
+			if remoteLength == length {
+				continue
+			}
+
+			// The file grew past the end of this segment without writing it
+
+			lowest = remoteLength
+		}
+
+		if lowest >= remoteLength && remoteLength > 0 && len(m.Segments[i]) < MaxSegmentParts {
+
+			// Only data was appended - upload it as an additional part
+
+			start += remoteLength
+			length -= remoteLength
+
+			if length <= 0 {
+				continue
+			}
+
+		} else {
+			obsolete = append(obsolete, m.Segments[i]...)
+			m.Segments[i] = nil
+		}
+
+		var n int
+
+		n, err = bf.File.ReadAt(buf[:length], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		key := bf.backend.partKey(bf.name, i, m.Version)
+
+		if err = bf.backend.client.Put(key, buf[:n]); err != nil {
+			return err
+		}
+
+		uploaded = append(uploaded, key)
+		m.Segments[i] = append(m.Segments[i], key)
+	}
+
+	var data []byte
+
+	if data, err = json.Marshal(m); err != nil {
+		return err
+	}
+
+	if err = bf.backend.client.Put(bf.backend.key(bf.name)+"/"+manifestObject, data); err != nil {
+		return err
+	}
+
+	bf.remote = m
+	bf.dirty = make(map[int64]int64)
+	bf.sizeDirty = false
+	bf.garbage = append(bf.garbage, obsolete...)
+
+	bf.collectGarbage()
+
+	return nil
+}
+
+/*
+collectGarbage removes objects which are no longer referenced. Objects which
+could not be removed are tried again after the next upload.
+*/
+func (bf *backendFile) collectGarbage() {
+	var remaining []string
+
+	referenced := make(map[string]bool)
+
+	for _, parts := range bf.remote.Segments {
+		for _, key := range parts {
+			referenced[key] = true
+		}
+	}
+
+	for _, key := range bf.garbage {
+		if referenced[key] {
+			continue
+		}
+
+		if err := bf.backend.client.Delete(key); err != nil {
+			if _, ok := err.(*ErrNotFound); !ok {
+				remaining = append(remaining, key)
+			}
+		}
+	}
+
+	bf.garbage = remaining
+}
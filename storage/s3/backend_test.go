@@ -0,0 +1,314 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestNewBackend(t *testing.T) {
+	config := make(map[string]interface{})
+
+	for k, v := range DefaultConfig {
+		config[k] = v
+	}
+
+	if _, err := NewBackend(config); err != nil {
+		t.Error(err)
+		return
+	}
+
+	config[ConfSegmentSize] = "foo"
+
+	if _, err := NewBackend(config); err == nil || err.Error() != "Invalid segment size: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	config[ConfSegmentSize] = 10
+	config[ConfBucket] = ""
+
+	if _, err := NewBackend(config); err == nil || err.Error() != "Need a bucket" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestBackendFiles(t *testing.T) {
+	fs3, srv := newFakeS3()
+	defer srv.Close()
+
+	oldBackoff := RetryBackoff
+	defer func() {
+		RetryBackoff = oldBackoff
+	}()
+
+	RetryBackoff = time.Millisecond
+
+	dir, _ := ioutil.TempDir("", "s3backend")
+	defer os.RemoveAll(dir)
+
+	b := NewBackendWithClient(NewClient(srv.URL, "bucket", "us-east-1", "key", "secret", nil),
+		"db", 4)
+
+	name := filepath.Join(dir, "test.dat")
+	key := b.key(name)
+
+	if _, err := b.OpenFile(name, os.O_RDWR, 0660); !os.IsNotExist(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	f, err := b.OpenFile(name, os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	f.Write([]byte("0123456789"))
+	f.WriteAt([]byte("ab"), 3)
+
+	// Nothing is uploaded before the file is synced
+
+	if keys := fs3.keys(); len(keys) != 0 {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if err := f.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The manifest is stored after all segments
+
+	if res := fmt.Sprint(fs3.puts); res != fmt.Sprintf("[%v/00000000.00000001 %v/00000001.00000001 "+
+		"%v/00000002.00000001 %v/manifest]", key, key, key, key) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := string(fs3.objects[key+"/00000000.00000001"]); res != "012a" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Appended data is uploaded as an additional part of the last segment
+
+	fs3.puts = nil
+
+	f.Write([]byte("cd"))
+
+	if err := f.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(fs3.puts); res != fmt.Sprintf("[%v/00000002.00000002 %v/manifest]", key, key) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := string(fs3.objects[key+"/00000002.00000002"]); res != "cd" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Changed segments are uploaded as a whole and replace the old objects
+
+	fs3.puts = nil
+
+	f.WriteAt([]byte("x"), 1)
+	f.Close()
+
+	if res := fmt.Sprint(fs3.puts); res != fmt.Sprintf("[%v/00000000.00000003 %v/manifest]", key, key) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(fs3.keys()); res != fmt.Sprintf("[%v/00000000.00000003 %v/00000001.00000001 "+
+		"%v/00000002.00000001 %v/00000002.00000002 %v/manifest]", key, key, key, key, key) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Restore the file after it was lost locally
+
+	os.Remove(name)
+
+	if ok, err := b.Exists(name); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if size, err := b.Size(name); size != 12 || err != nil {
+		t.Error("Unexpected result:", size, err)
+		return
+	}
+
+	if names, err := b.Glob(filepath.Join(dir, "*.dat")); fmt.Sprint(names) != fmt.Sprint([]string{name}) || err != nil {
+		t.Error("Unexpected result:", names, err)
+		return
+	}
+
+	f, _ = b.OpenFile(name, os.O_RDWR, 0660)
+	data, err := ioutil.ReadAll(f)
+
+	if string(data) != "0x2ab56789cd" || err != nil {
+		t.Error("Unexpected result:", string(data), err)
+		return
+	}
+
+	// A failed upload does not change the stored version of the file
+
+	fs3.fail = func(r *http.Request) int {
+		if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/manifest") {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+
+	f.WriteAt([]byte("y"), 5)
+
+	if err := f.Sync(); err == nil {
+		t.Error("Upload should fail")
+		return
+	}
+
+	if m, err := b.manifest(name); m.Version != 3 || m.Size != 12 || err != nil {
+		t.Error("Unexpected result:", m, err)
+		return
+	}
+
+	fs3.fail = nil
+
+	if err := f.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.Remove(name)
+
+	f, _ = b.OpenFile(name, os.O_RDONLY, 0660)
+	data, err = ioutil.ReadAll(f)
+	f.Close()
+
+	if string(data) != "0x2aby6789cd" || err != nil {
+		t.Error("Unexpected result:", string(data), err)
+		return
+	}
+
+	// Truncating a file removes objects which are no longer needed
+
+	f, _ = b.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0660)
+	f.Write([]byte("abc"))
+	f.Close()
+
+	if res := fmt.Sprint(fs3.keys()); res != fmt.Sprintf("[%v/00000000.00000005 %v/manifest]", key, key) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Rename and remove files
+
+	name2 := filepath.Join(dir, "test2.dat")
+	key2 := b.key(name2)
+
+	os.Remove(name)
+
+	if err := b.Rename(name, name2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(fs3.keys()); res != fmt.Sprintf("[%v/00000000.00000001 %v/manifest]", key2, key2) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if data, err := ioutil.ReadFile(name2); string(data) != "abc" || err != nil {
+		t.Error("Unexpected result:", string(data), err)
+		return
+	}
+
+	if err := b.Remove(name2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if keys := fs3.keys(); len(keys) != 0 {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if err := b.Remove(name2); !os.IsNotExist(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if ok, err := b.Exists(name2); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+}
+
+func TestBackendStorageManager(t *testing.T) {
+	_, srv := newFakeS3()
+	defer srv.Close()
+
+	dir, _ := ioutil.TempDir("", "s3backend")
+	defer os.RemoveAll(dir)
+
+	b := NewBackendWithClient(NewClient(srv.URL, "bucket", "us-east-1", "key", "secret", nil),
+		"", 1024)
+
+	dsm := storage.NewDiskStorageManagerWithBackend(filepath.Join(dir, "test"), false, false, false, false, b)
+
+	loc, err := dsm.Insert("Hello S3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Lose all local files
+
+	os.RemoveAll(dir)
+	os.MkdirAll(dir, 0770)
+
+	if !storage.DataFileExist(b, filepath.Join(dir, "test")) {
+		t.Error("Data file should exist")
+		return
+	}
+
+	dsm = storage.NewDiskStorageManagerWithBackend(filepath.Join(dir, "test"), false, false, false, false, b)
+	defer dsm.Close()
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); res != "Hello S3" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
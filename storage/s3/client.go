@@ -0,0 +1,398 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package s3 contains a storage backend which persists storage files in
+S3-compatible object storage.
+
+Files are kept in a local directory which acts as a cache for hot pages. Each
+file is split into segments of a fixed size which are stored as objects. When
+a file is synced all segments which were changed since the last sync are
+uploaded as new objects - data which was only appended to a segment (e.g. to a
+transaction log) is uploaded as an additional part of the segment. The new
+version of the file is then published with a single write of a manifest which
+lists the objects of all segments. Objects which are no longer referenced are
+removed afterwards. Files which do not exist locally (e.g. after the local disk
+was lost) are restored from the object storage when they are opened.
+
+Failed requests are retried with an exponential backoff (see MaxRetries).
+
+The client speaks the S3 REST protocol with AWS signature version 4 using
+path-style requests so it works with AWS S3 as well as with other
+S3-compatible object stores.
+*/
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ErrNotFound is returned when an object does not exist.
+*/
+type ErrNotFound struct {
+	Key string
+}
+
+/*
+Error returns a string representation of the error.
+*/
+func (e *ErrNotFound) Error() string {
+	return fmt.Sprintf("Object not found: %v", e.Key)
+}
+
+/*
+Client is a minimal client for S3-compatible object storage.
+*/
+type Client struct {
+	endpoint  string       // Endpoint URL of the object storage
+	bucket    string       // Name of the bucket
+	region    string       // Region of the bucket
+	accessKey string       // Access key ID
+	secretKey string       // Secret access key
+	http      *http.Client // HTTP client
+	now       func() time.Time
+}
+
+/*
+NewClient creates a new object storage client.
+*/
+func NewClient(endpoint string, bucket string, region string, accessKey string,
+	secretKey string, client *http.Client) *Client {
+
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+
+	return &Client{strings.TrimSuffix(endpoint, "/"), bucket, region, accessKey,
+		secretKey, client, time.Now}
+}
+
+/*
+Get returns the content of an object.
+*/
+func (c *Client) Get(key string) ([]byte, error) {
+
+	res, err := c.do("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+/*
+Put stores an object.
+*/
+func (c *Client) Put(key string, data []byte) error {
+
+	res, err := c.do("PUT", key, nil, data)
+	if err == nil {
+		res.Body.Close()
+	}
+
+	return err
+}
+
+/*
+Delete removes an object.
+*/
+func (c *Client) Delete(key string) error {
+
+	res, err := c.do("DELETE", key, nil, nil)
+	if err == nil {
+		res.Body.Close()
+	}
+
+	return err
+}
+
+/*
+Exists checks if an object exists.
+*/
+func (c *Client) Exists(key string) (bool, error) {
+
+	res, err := c.do("HEAD", key, nil, nil)
+
+	if err == nil {
+		res.Body.Close()
+		return true, nil
+	} else if _, ok := err.(*ErrNotFound); ok {
+		return false, nil
+	}
+
+	return false, err
+}
+
+/*
+listResult is the result of a list request.
+*/
+type listResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+/*
+List returns the keys of all objects with a given prefix.
+*/
+func (c *Client) List(prefix string) ([]string, error) {
+	var keys []string
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+
+	for {
+		res, err := c.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listResult
+
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	return keys, nil
+}
+
+/*
+MaxRetries is the number of times a request is retried after a network error,
+a server error or a throttled request.
+*/
+var MaxRetries = 5
+
+/*
+RetryBackoff is the wait time before the first retry of a request. The wait
+time doubles with every further retry.
+*/
+var RetryBackoff = 100 * time.Millisecond
+
+/*
+do sends a signed request to the object storage. Failed requests are retried
+with an exponential backoff.
+*/
+func (c *Client) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+
+	u, err := url.Parse(fmt.Sprintf("%v/%v/%v", c.endpoint, c.bucket, key))
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = canonicalQuery(query)
+
+	backoff := RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+
+		res, err := c.send(method, u.String(), body)
+
+		if err == nil {
+			if res.StatusCode == http.StatusNotFound {
+				res.Body.Close()
+				return nil, &ErrNotFound{key}
+			} else if res.StatusCode < 300 {
+				return res, nil
+			}
+
+			msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+			res.Body.Close()
+
+			err = fmt.Errorf("Object storage request %v %v failed: %v %v", method, key,
+				res.Status, strings.TrimSpace(string(msg)))
+
+			// Only server errors and throttled requests are retried
+
+			if res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+				return nil, err
+			}
+		}
+
+		if attempt >= MaxRetries {
+			return nil, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+/*
+send sends a single signed request.
+*/
+func (c *Client) send(method string, u string, body []byte) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		req.Body = nil
+	}
+
+	c.sign(req, body)
+
+	return c.http.Do(req)
+}
+
+/*
+sign signs a request with AWS signature version 4.
+*/
+func (c *Client) sign(req *http.Request, body []byte) {
+
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+
+	// Collect the headers which are signed
+
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		lname := strings.ToLower(name)
+
+		if strings.HasPrefix(lname, "x-amz-") || lname == "range" || lname == "content-type" {
+			headers[lname] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	var names []string
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", date, c.region)
+
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+/*
+hmacSHA256 calculates a HMAC-SHA256 of a given string.
+*/
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/*
+canonicalQuery returns the canonical form of query parameters (sorted and
+URI encoded).
+*/
+func canonicalQuery(query url.Values) string {
+	var params []string
+
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+/*
+uriEncode encodes a string as required by AWS signature version 4. Slashes are
+only encoded if the encodeSlash flag is set.
+*/
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -212,6 +212,42 @@ func (lsm *LogicalSlotManager) Fetch(logicalSlot uint64) (uint64, error) {
 	return slot, nil
 }
 
+/*
+FreeUnused gives all unused slots on allocated pages to the
+FreeLogicalSlotManager. This is necessary after slots have been inserted
+with ForceInsert.
+*/
+func (lsm *LogicalSlotManager) FreeUnused() error {
+	page := lsm.pager.First(view.TypeTranslationPage)
+
+	for page != 0 {
+		record, err := lsm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		tpage := pageview.NewTransPage(record)
+
+		offset := uint16(pageview.OffsetTransData)
+
+		var i uint16
+		for i = 0; i < lsm.elementsPerPage; i++ {
+			if tpage.SlotInfoRecord(offset) == 0 {
+				lsm.freeManager.Add(util.PackLocation(page, offset))
+			}
+			offset += util.LocationSize
+		}
+
+		lsm.storagefile.ReleaseInUseID(page, false)
+
+		if page, err = lsm.pager.Next(page); err != nil {
+			return err
+		}
+	}
+
+	return lsm.Flush()
+}
+
 /*
 Flush writes all pending changes.
 */
@@ -10,6 +10,8 @@
 
 package storage
 
+import "time"
+
 /*
 RootIDVersion is the root id holding the version.
 */
@@ -77,3 +79,83 @@ type Manager interface {
 	*/
 	Close() error
 }
+
+/*
+CacheStats holds the statistics of a storage manager cache.
+*/
+type CacheStats struct {
+	Size       int    // Number of objects in the cache
+	MaxObjects int    // Max number of objects which can be held in the cache
+	Hits       uint64 // Number of requests which were answered from the cache
+	Misses     uint64 // Number of requests which had to be answered from disk
+}
+
+/*
+CacheManager is an optional interface for storage managers which maintain
+a cache of stored objects.
+*/
+type CacheManager interface {
+
+	/*
+		CacheStats returns the current statistics of the cache.
+	*/
+	CacheStats() CacheStats
+
+	/*
+		ResetCacheStats resets the hit and miss counters of the cache.
+	*/
+	ResetCacheStats()
+
+	/*
+		SetMaxObjects sets the max number of objects which should be held in
+		the cache. Entries which were requested the least are removed if the
+		cache holds more objects.
+	*/
+	SetMaxObjects(maxObjects int)
+}
+
+/*
+DeferredSyncManager is an optional interface for storage managers which can
+defer syncing flushed changes to stable storage. This allows multiple
+transactions to share a single disk sync (group commit).
+*/
+type DeferredSyncManager interface {
+
+	/*
+		SetDeferSync sets if syncing on every flush should be deferred.
+	*/
+	SetDeferSync(deferSync bool)
+
+	/*
+		SyncLog makes sure that all flushed changes have been committed to
+		stable storage.
+	*/
+	SyncLog() error
+}
+
+/*
+CompactingManager is an optional interface for storage managers which can
+reclaim the space of deleted and updated records while they are in use.
+*/
+type CompactingManager interface {
+
+	/*
+		Compact reclaims the space of deleted and updated records. Records are
+		copied in batches of the given size with a pause between batches.
+		Returns the number of bytes which were reclaimed.
+	*/
+	Compact(batchSize int, pause time.Duration, progress CompactionProgress) (int64, error)
+}
+
+/*
+CheckingManager is an optional interface for storage managers which can verify
+the consistency of their storage files.
+*/
+type CheckingManager interface {
+
+	/*
+		Check verifies the consistency of the storage files. The storage files
+		are not changed.
+	*/
+	Check() (*CheckReport, error)
+}
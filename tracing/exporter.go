@@ -0,0 +1,206 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+/*
+Exporter receives finished spans.
+*/
+type Exporter interface {
+
+	/*
+		ExportSpan exports a finished span.
+	*/
+	ExportSpan(span *Span)
+}
+
+/*
+ServiceName is the service name which is reported for exported spans.
+*/
+var ServiceName = "eliasdb"
+
+/*
+OTLP status code for failed operations
+*/
+const otlpStatusCodeError = 2
+
+/*
+JSONExporter writes finished spans in the OTLP/JSON format of OpenTelemetry.
+Every span is written as an ExportTraceServiceRequest object (with one
+resourceSpans entry which holds the span) on a single line. The lines can be
+ingested by an OpenTelemetry collector (e.g. with the otlpjsonfile receiver).
+*/
+type JSONExporter struct {
+	out  io.Writer   // Output of the exporter
+	lock *sync.Mutex // Lock to serialize writes
+}
+
+/*
+NewJSONExporter creates a new JSONExporter which writes to a given writer.
+*/
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out, &sync.Mutex{}}
+}
+
+/*
+ExportSpan writes a finished span.
+*/
+func (je *JSONExporter) ExportSpan(span *Span) {
+
+	span.lock.Lock()
+
+	otlpSpan := map[string]interface{}{
+		"traceId":           span.TraceID,
+		"spanId":            span.SpanID,
+		"name":              span.Name,
+		"startTimeUnixNano": fmt.Sprint(span.Start.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprint(span.End.UnixNano()),
+		"attributes":        otlpAttributes(span.Attrs),
+	}
+
+	span.lock.Unlock()
+
+	if span.ParentID != "" {
+		otlpSpan["parentSpanId"] = span.ParentID
+	}
+
+	if span.Error != "" {
+		otlpSpan["status"] = map[string]interface{}{
+			"code":    otlpStatusCodeError,
+			"message": span.Error,
+		}
+	}
+
+	data := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{
+						"service.name": ServiceName,
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": "github.com/krotik/eliasdb/tracing",
+						},
+						"spans": []interface{}{otlpSpan},
+					},
+				},
+			},
+		},
+	}
+
+	line, err := json.Marshal(data)
+
+	if err == nil {
+		je.lock.Lock()
+		defer je.lock.Unlock()
+
+		je.out.Write(append(line, '\n'))
+	}
+}
+
+/*
+otlpAttributes converts a map of attributes into a list of OTLP key/value
+pairs. The list is sorted by key.
+*/
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	var keys []string
+
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	res := make([]interface{}, 0, len(keys))
+
+	for _, k := range keys {
+		res = append(res, map[string]interface{}{
+			"key":   k,
+			"value": otlpValue(attrs[k]),
+		})
+	}
+
+	return res
+}
+
+/*
+otlpValue converts a value into an OTLP AnyValue. 64 bit integers are encoded
+as strings as required by the OTLP/JSON format.
+*/
+func otlpValue(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return map[string]interface{}{"intValue": fmt.Sprint(val)}
+	case float32, float64:
+		return map[string]interface{}{"doubleValue": val}
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	}
+
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+/*
+MemoryExporter keeps finished spans in memory.
+*/
+type MemoryExporter struct {
+	spans []*Span     // Finished spans
+	lock  *sync.Mutex // Lock for the span list
+}
+
+/*
+NewMemoryExporter creates a new MemoryExporter.
+*/
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{nil, &sync.Mutex{}}
+}
+
+/*
+ExportSpan stores a finished span.
+*/
+func (me *MemoryExporter) ExportSpan(span *Span) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.spans = append(me.spans, span)
+}
+
+/*
+Spans returns all stored spans.
+*/
+func (me *MemoryExporter) Spans() []*Span {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	return append([]*Span(nil), me.spans...)
+}
+
+/*
+Reset removes all stored spans.
+*/
+func (me *MemoryExporter) Reset() {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.spans = nil
+}
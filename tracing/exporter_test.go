@@ -0,0 +1,85 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+
+	SetExporter(NewJSONExporter(&buf))
+	defer SetExporter(nil)
+
+	ctx, root := StartSpan(context.Background(), "root")
+	_, child := StartSpan(ctx, "child")
+
+	child.SetAttr("foo", "bar")
+	child.SetAttr("count", 5)
+	child.SetError(errors.New("test"))
+	child.Finish()
+	root.Finish()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Error("Unexpected result:", lines)
+		return
+	}
+
+	span := func(line string) map[string]interface{} {
+		var res map[string]interface{}
+
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Error(err)
+			return nil
+		}
+
+		rs := res["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		ss := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})
+
+		if attrs := rs["resource"].(map[string]interface{})["attributes"]; fmt.Sprint(attrs) !=
+			"[map[key:service.name value:map[stringValue:eliasdb]]]" {
+			t.Error("Unexpected resource attributes:", attrs)
+		}
+
+		return ss["spans"].([]interface{})[0].(map[string]interface{})
+	}
+
+	res := span(lines[0])
+
+	if res["name"] != "child" || res["parentSpanId"] != root.SpanID || res["traceId"] != root.TraceID ||
+		fmt.Sprint(res["attributes"]) != "[map[key:count value:map[intValue:5]] map[key:foo value:map[stringValue:bar]]]" ||
+		fmt.Sprint(res["status"]) != "map[code:2 message:test]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := strconv.ParseInt(res["startTimeUnixNano"].(string), 10, 64); err != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	res = span(lines[1])
+
+	if _, ok := res["parentSpanId"]; ok || res["name"] != "root" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
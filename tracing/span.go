@@ -0,0 +1,385 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package tracing contains a lightweight tracing implementation which follows the
+OpenTelemetry data model.
+
+Spans
+
+A span measures a single operation (e.g. a REST request, the parsing of an EQL
+query or a storage read). Spans are created with StartSpan() and are finished
+with End(). Spans are linked to their parent span through a context.Context.
+
+Operations which are not called with a context (e.g. storage reads and writes)
+are linked to the active span of their goroutine. A span is made the active
+span of the current goroutine with Activate().
+
+Context propagation
+
+Trace context is propagated using the W3C traceparent header format:
+
+	version "-" trace-id "-" parent-id "-" trace-flags
+
+Exporters
+
+Finished spans are given to an Exporter. By default no exporter is set which
+disables tracing. The JSONExporter writes spans in the OTLP/JSON format of
+OpenTelemetry - one export request per line.
+*/
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+HTTPHeaderTraceParent is the W3C header which carries the trace context.
+*/
+const HTTPHeaderTraceParent = "traceparent"
+
+/*
+Span models a single traced operation.
+*/
+type Span struct {
+	TraceID  string                 // ID of the trace (32 hex characters)
+	SpanID   string                 // ID of this span (16 hex characters)
+	ParentID string                 // ID of the parent span (empty for root spans)
+	Name     string                 // Name of the traced operation
+	Start    time.Time              // Start time of the operation
+	End      time.Time              // End time of the operation
+	Attrs    map[string]interface{} // Attributes of the operation
+	Error    string                 // Error of the operation (empty if no error occurred)
+
+	lock  *sync.Mutex // Lock for attributes
+	ended bool        // Flag if the span has been ended
+}
+
+/*
+exporter is the exporter for finished spans (nil if tracing is disabled).
+*/
+var exporter Exporter
+
+/*
+exporterLock is the lock for the exporter.
+*/
+var exporterLock = &sync.RWMutex{}
+
+/*
+SetExporter sets the exporter for finished spans. Setting nil disables tracing.
+*/
+func SetExporter(e Exporter) {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+
+	exporter = e
+}
+
+/*
+Enabled returns if tracing is enabled.
+*/
+func Enabled() bool {
+	exporterLock.RLock()
+	defer exporterLock.RUnlock()
+
+	return exporter != nil
+}
+
+/*
+spanKey is the context key for the current span.
+*/
+type spanKey struct{}
+
+/*
+remoteKey is the context key for a remote parent span.
+*/
+type remoteKey struct{}
+
+/*
+remoteParent is a parent span which was received from a remote caller.
+*/
+type remoteParent struct {
+	traceID string
+	spanID  string
+}
+
+/*
+FromContext returns the current span of a context or nil if there is no span.
+*/
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+/*
+StartSpan starts a new span as a child of the current span in the given context.
+Returns a context which contains the new span. Returns the given context and a
+nil span if tracing is disabled - all Span methods are safe to use on nil.
+*/
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	span := &Span{
+		SpanID: newID(8),
+		Name:   name,
+		Start:  time.Now(),
+		Attrs:  make(map[string]interface{}),
+		lock:   &sync.Mutex{},
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		span.TraceID = remote.traceID
+		span.ParentID = remote.spanID
+	} else {
+		span.TraceID = newID(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+/*
+activeSpans holds the active spans of goroutines by goroutine ID.
+*/
+var activeSpans = make(map[uint64]*Span)
+
+/*
+activeSpansLock is the lock for the active spans.
+*/
+var activeSpansLock = &sync.RWMutex{}
+
+/*
+Activate makes a given span the active span of the calling goroutine. The
+returned function restores the previously active span and must be called on
+the same goroutine (e.g. with defer).
+*/
+func Activate(span *Span) func() {
+
+	if span == nil {
+		return func() {}
+	}
+
+	gid := goroutineID()
+
+	activeSpansLock.Lock()
+	prev, hasPrev := activeSpans[gid]
+	activeSpans[gid] = span
+	activeSpansLock.Unlock()
+
+	return func() {
+		activeSpansLock.Lock()
+		defer activeSpansLock.Unlock()
+
+		if hasPrev {
+			activeSpans[gid] = prev
+		} else {
+			delete(activeSpans, gid)
+		}
+	}
+}
+
+/*
+ActiveContext returns a context which contains the active span of the
+calling goroutine. Returns the background context if there is no active span.
+*/
+func ActiveContext() context.Context {
+	ctx := context.Background()
+
+	if !Enabled() {
+		return ctx
+	}
+
+	activeSpansLock.RLock()
+	span := activeSpans[goroutineID()]
+	activeSpansLock.RUnlock()
+
+	if span != nil {
+		ctx = context.WithValue(ctx, spanKey{}, span)
+	}
+
+	return ctx
+}
+
+/*
+goroutineID returns the ID of the calling goroutine.
+*/
+func goroutineID() uint64 {
+	var buf [64]byte
+
+	// The stack trace starts with "goroutine <id> [..."
+
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+
+	return id
+}
+
+/*
+SetAttr sets an attribute of this span.
+*/
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Attrs[key] = value
+}
+
+/*
+SetError records an error for this span. Nil errors are ignored.
+*/
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Error = err.Error()
+}
+
+/*
+Finish ends this span and hands it to the exporter. Subsequent calls
+have no effect.
+*/
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.End = time.Now()
+
+	s.lock.Unlock()
+
+	exporterLock.RLock()
+	e := exporter
+	exporterLock.RUnlock()
+
+	if e != nil {
+		e.ExportSpan(s)
+	}
+}
+
+/*
+Duration returns the duration of this span.
+*/
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+/*
+TraceParent returns the W3C traceparent header value for this span.
+*/
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+/*
+ContextWithTraceParent returns a context which contains the remote parent
+span of a given W3C traceparent header value. Invalid values are ignored.
+*/
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	traceID, spanID, ok := ParseTraceParent(traceparent)
+
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, remoteKey{}, remoteParent{traceID, spanID})
+}
+
+/*
+ParseTraceParent parses a W3C traceparent header value. Returns the trace ID,
+the parent span ID and if the value was valid.
+*/
+func ParseTraceParent(traceparent string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		!isHexID(parts[1], 16) || !isHexID(parts[2], 8) || len(parts[3]) != 2 {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+/*
+isHexID checks if a given string is a valid non-zero lowercase hex ID of a given byte length.
+*/
+func isHexID(s string, length int) bool {
+	if len(s) != length*2 || s != strings.ToLower(s) {
+		return false
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+newID creates a new random ID with a given byte length.
+*/
+func newID(length int) string {
+	b := make([]byte, length)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+
+	// Tracing is disabled by default
+
+	ctx, span := StartSpan(context.Background(), "foo")
+
+	if Enabled() || span != nil || FromContext(ctx) != nil {
+		t.Error("Unexpected result:", span)
+		return
+	}
+
+	// All span functions should be safe on nil
+
+	span.SetAttr("a", 1)
+	span.SetError(errors.New("test"))
+	span.Finish()
+
+	if res := span.TraceParent(); res != "" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	me := NewMemoryExporter()
+	SetExporter(me)
+	defer SetExporter(nil)
+
+	ctx, root := StartSpan(nil, "root")
+
+	if FromContext(ctx) != root || root.ParentID != "" || len(root.TraceID) != 32 || len(root.SpanID) != 16 {
+		t.Error("Unexpected result:", root)
+		return
+	}
+
+	_, child := StartSpan(ctx, "child")
+
+	child.SetAttr("a", 1)
+	child.SetError(errors.New("test"))
+	child.SetError(nil)
+	child.Finish()
+	child.Finish()
+
+	root.Finish()
+
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID ||
+		child.Error != "test" || child.Attrs["a"] != 1 || child.Duration() < 0 {
+		t.Error("Unexpected result:", child)
+		return
+	}
+
+	if spans := me.Spans(); len(spans) != 2 || spans[0] != child || spans[1] != root {
+		t.Error("Unexpected result:", spans)
+		return
+	}
+
+	me.Reset()
+
+	if spans := me.Spans(); len(spans) != 0 {
+		t.Error("Unexpected result:", spans)
+		return
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+
+	me := NewMemoryExporter()
+	SetExporter(me)
+	defer SetExporter(nil)
+
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	if traceID, spanID, ok := ParseTraceParent(tp); !ok ||
+		traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7" {
+		t.Error("Unexpected result:", traceID, spanID, ok)
+		return
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, _, ok := ParseTraceParent(invalid); ok {
+			t.Error("Unexpected result for:", invalid)
+			return
+		}
+	}
+
+	ctx := ContextWithTraceParent(context.Background(), "foo")
+	_, span := StartSpan(ctx, "foo")
+
+	if span.ParentID != "" {
+		t.Error("Unexpected result:", span)
+		return
+	}
+
+	ctx = ContextWithTraceParent(context.Background(), tp)
+	_, span = StartSpan(ctx, "foo")
+
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentID != "00f067aa0ba902b7" {
+		t.Error("Unexpected result:", span)
+		return
+	}
+
+	if res := span.TraceParent(); res != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
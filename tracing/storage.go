@@ -0,0 +1,315 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+graphStorage is a graph storage wrapper which traces the operations of all
+its storage managers.
+*/
+type graphStorage struct {
+	graphstorage.Storage
+	managers map[string]storage.Manager // Wrapped storage managers
+	lock     *sync.Mutex                // Lock for the storage manager map
+}
+
+/*
+NewGraphStorage wraps a given graph storage so all reads and writes of its
+storage managers are traced. Storage operations are not called with a context
+so each operation is recorded as a child of the active span of its goroutine
+(see Activate). The optional interfaces of the graph storage and its storage
+managers are forwarded.
+*/
+func NewGraphStorage(gs graphstorage.Storage) graphstorage.Storage {
+	return &graphStorage{gs, make(map[string]storage.Manager), &sync.Mutex{}}
+}
+
+/*
+StorageManager gets a traced storage manager with a certain name.
+*/
+func (gs *graphStorage) StorageManager(smname string, create bool) storage.Manager {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if sm, ok := gs.managers[smname]; ok {
+		return sm
+	}
+
+	sm := gs.Storage.StorageManager(smname, create)
+
+	if sm == nil {
+		return nil
+	}
+
+	var tsm storage.Manager = &storageManager{sm}
+
+	if cm, ok := sm.(storage.CacheManager); ok {
+		tsm = &cacheManager{tsm.(*storageManager), cm}
+	}
+
+	gs.managers[smname] = tsm
+
+	return tsm
+}
+
+/*
+Unwrap returns the wrapped graph storage (e.g. to change settings of a
+DiskGraphStorage).
+*/
+func (gs *graphStorage) Unwrap() graphstorage.Storage {
+	return gs.Storage
+}
+
+/*
+CheckHealth checks the wrapped storage if it supports health checks.
+*/
+func (gs *graphStorage) CheckHealth() error {
+	if hc, ok := gs.Storage.(graphstorage.HealthCheck); ok {
+		return hc.CheckHealth()
+	}
+	return nil
+}
+
+/*
+Sync syncs the wrapped storage if it supports syncing. Falls back to a flush
+otherwise.
+*/
+func (gs *graphStorage) Sync() error {
+	if s, ok := gs.Storage.(graphstorage.Sync); ok {
+		return s.Sync()
+	}
+	return gs.Storage.FlushAll()
+}
+
+/*
+WaitDurable waits for the wrapped storage if it coalesces disk syncs.
+*/
+func (gs *graphStorage) WaitDurable() error {
+	if gc, ok := gs.Storage.(graphstorage.GroupCommitter); ok {
+		return gc.WaitDurable()
+	}
+	return nil
+}
+
+/*
+Compact compacts the wrapped storage if it supports compaction.
+*/
+func (gs *graphStorage) Compact(batchSize int, pause time.Duration) error {
+	if c, ok := gs.Storage.(graphstorage.Compactor); ok {
+		return c.Compact(batchSize, pause)
+	}
+	return &util.GraphError{Type: util.ErrInvalidData,
+		Detail: "Graph storage does not support compaction"}
+}
+
+/*
+CompactionStatus returns the compaction status of the wrapped storage if it
+supports compaction.
+*/
+func (gs *graphStorage) CompactionStatus() *graphstorage.CompactionStatus {
+	if c, ok := gs.Storage.(graphstorage.Compactor); ok {
+		return c.CompactionStatus()
+	}
+	return nil
+}
+
+/*
+Reencrypt reencrypts the wrapped storage if it supports encryption.
+*/
+func (gs *graphStorage) Reencrypt(batchSize int, pause time.Duration) (int, error) {
+	if r, ok := gs.Storage.(graphstorage.Reencrypter); ok {
+		return r.Reencrypt(batchSize, pause)
+	}
+	return 0, &util.GraphError{Type: util.ErrInvalidData,
+		Detail: "Graph storage does not support encryption"}
+}
+
+/*
+storageManager is a storage manager wrapper which traces reads and writes.
+*/
+type storageManager struct {
+	storage.Manager
+}
+
+/*
+startSpan starts a span for a storage operation.
+*/
+func (sm *storageManager) startSpan(op string) *Span {
+	_, span := StartSpan(ActiveContext(), "storage."+op)
+	span.SetAttr("storage.name", sm.Manager.Name())
+	return span
+}
+
+/*
+SetDeferSync sets if the wrapped storage manager should defer syncs if it
+supports it.
+*/
+func (sm *storageManager) SetDeferSync(deferSync bool) {
+	if dsm, ok := sm.Manager.(storage.DeferredSyncManager); ok {
+		dsm.SetDeferSync(deferSync)
+	}
+}
+
+/*
+SyncLog syncs the wrapped storage manager if it defers syncs.
+*/
+func (sm *storageManager) SyncLog() error {
+	if dsm, ok := sm.Manager.(storage.DeferredSyncManager); ok {
+		span := sm.startSpan("SyncLog")
+		defer span.Finish()
+
+		err := dsm.SyncLog()
+		span.SetError(err)
+
+		return err
+	}
+	return nil
+}
+
+/*
+Compact compacts the wrapped storage manager if it supports compaction.
+*/
+func (sm *storageManager) Compact(batchSize int, pause time.Duration,
+	progress storage.CompactionProgress) (int64, error) {
+
+	if cm, ok := sm.Manager.(storage.CompactingManager); ok {
+		span := sm.startSpan("Compact")
+		defer span.Finish()
+
+		reclaimed, err := cm.Compact(batchSize, pause, progress)
+		span.SetAttr("storage.reclaimed", reclaimed)
+		span.SetError(err)
+
+		return reclaimed, err
+	}
+	return 0, nil
+}
+
+/*
+cacheManager is a traced storage manager which forwards the cache management
+of the wrapped storage manager.
+*/
+type cacheManager struct {
+	*storageManager
+	cm storage.CacheManager // Cache management of the wrapped storage manager
+}
+
+/*
+CacheStats returns the current statistics of the cache.
+*/
+func (cm *cacheManager) CacheStats() storage.CacheStats {
+	return cm.cm.CacheStats()
+}
+
+/*
+ResetCacheStats resets the hit and miss counters of the cache.
+*/
+func (cm *cacheManager) ResetCacheStats() {
+	cm.cm.ResetCacheStats()
+}
+
+/*
+SetMaxObjects sets the max number of objects which should be held in the cache.
+*/
+func (cm *cacheManager) SetMaxObjects(maxObjects int) {
+	cm.cm.SetMaxObjects(maxObjects)
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (sm *storageManager) Insert(o interface{}) (uint64, error) {
+	span := sm.startSpan("Insert")
+	defer span.Finish()
+
+	loc, err := sm.Manager.Insert(o)
+	span.SetAttr("storage.location", loc)
+	span.SetError(err)
+
+	return loc, err
+}
+
+/*
+Update updates a storage location.
+*/
+func (sm *storageManager) Update(loc uint64, o interface{}) error {
+	span := sm.startSpan("Update")
+	defer span.Finish()
+
+	err := sm.Manager.Update(loc, o)
+	span.SetAttr("storage.location", loc)
+	span.SetError(err)
+
+	return err
+}
+
+/*
+Free frees a storage location.
+*/
+func (sm *storageManager) Free(loc uint64) error {
+	span := sm.startSpan("Free")
+	defer span.Finish()
+
+	err := sm.Manager.Free(loc)
+	span.SetAttr("storage.location", loc)
+	span.SetError(err)
+
+	return err
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (sm *storageManager) Fetch(loc uint64, o interface{}) error {
+	span := sm.startSpan("Fetch")
+	defer span.Finish()
+
+	err := sm.Manager.Fetch(loc, o)
+	span.SetAttr("storage.location", loc)
+	span.SetError(err)
+
+	return err
+}
+
+/*
+Flush writes all pending changes to disk.
+*/
+func (sm *storageManager) Flush() error {
+	span := sm.startSpan("Flush")
+	defer span.Finish()
+
+	err := sm.Manager.Flush()
+	span.SetError(err)
+
+	return err
+}
+
+/*
+Rollback cancels all pending changes which have not yet been written to disk.
+*/
+func (sm *storageManager) Rollback() error {
+	span := sm.startSpan("Rollback")
+	defer span.Finish()
+
+	err := sm.Manager.Rollback()
+	span.SetError(err)
+
+	return err
+}
@@ -0,0 +1,142 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+const storageTracingTestDBDir = "storagetracingtest"
+
+func TestStorageTracing(t *testing.T) {
+	os.RemoveAll(storageTracingTestDBDir)
+	defer os.RemoveAll(storageTracingTestDBDir)
+
+	me := NewMemoryExporter()
+	SetExporter(me)
+	defer SetExporter(nil)
+
+	gs := NewGraphStorage(graphstorage.NewMemoryGraphStorage("test"))
+
+	if sm := gs.StorageManager("foo", false); sm != nil {
+		t.Error("Unexpected result:", sm)
+		return
+	}
+
+	sm := gs.StorageManager("foo", true)
+
+	if sm2 := gs.StorageManager("foo", false); sm2 != sm {
+		t.Error("Storage manager should be cached")
+		return
+	}
+
+	loc, _ := sm.Insert("bar")
+	sm.Update(loc, "bar2")
+
+	var res string
+	sm.Fetch(loc, &res)
+	sm.Free(loc)
+	sm.Flush()
+	sm.Rollback()
+
+	if res != "bar2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var names []string
+	for _, s := range me.Spans() {
+		names = append(names, s.Name)
+
+		if s.Attrs["storage.name"] != "test/foo" {
+			t.Error("Unexpected result:", s.Attrs)
+			return
+		}
+	}
+
+	if fmt.Sprint(names) != "[storage.Insert storage.Update storage.Fetch storage.Free storage.Flush storage.Rollback]" {
+		t.Error("Unexpected result:", names)
+		return
+	}
+
+	if err := gs.(*graphStorage).CheckHealth(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Storage spans are children of the active span
+
+	me.Reset()
+
+	_, parent := StartSpan(context.Background(), "request")
+	deactivate := Activate(parent)
+
+	sm.Insert("bar")
+
+	deactivate()
+
+	sm.Insert("bar")
+
+	if spans := me.Spans(); len(spans) != 2 || spans[0].ParentID != parent.SpanID ||
+		spans[0].TraceID != parent.TraceID || spans[1].ParentID != "" {
+		t.Error("Unexpected result:", spans)
+		return
+	}
+
+	// Optional interfaces are forwarded
+
+	if err := gs.(graphstorage.Compactor).Compact(10, 0); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph storage does not support compaction)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gs.(graphstorage.Reencrypter).Reencrypt(10, 0); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if gs.(*graphStorage).Unwrap().Name() != "test" {
+		t.Error("Unexpected result:", gs.(*graphStorage).Unwrap())
+		return
+	}
+
+	if _, ok := sm.(storage.CacheManager); ok {
+		t.Error("Memory storage manager should not have a cache")
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(storageTracingTestDBDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	cm, ok := NewGraphStorage(dgs).StorageManager("foo", true).(storage.CacheManager)
+	if !ok {
+		t.Error("Cache management should be forwarded")
+		return
+	}
+
+	cm.SetMaxObjects(10)
+
+	if stats := cm.CacheStats(); stats.MaxObjects != 10 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+}
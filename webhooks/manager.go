@@ -0,0 +1,426 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+WebhookNodeKind is the node kind which stores webhooks.
+*/
+const WebhookNodeKind = "webhook"
+
+/*
+DeliveryTimeout is the timeout of a single delivery attempt.
+*/
+var DeliveryTimeout = 10 * time.Second
+
+/*
+MaxRetries is the number of retries of a failed delivery.
+*/
+var MaxRetries = 5
+
+/*
+RetryBackoff is the wait time before the first retry of a failed delivery. The
+wait time doubles with every further retry.
+*/
+var RetryBackoff = time.Second
+
+/*
+RetryMaxBackoff is the maximum wait time between two retries.
+*/
+var RetryMaxBackoff = time.Minute
+
+/*
+DeliveryBatchSize is the maximum number of changes which a worker reads from
+the change log at once.
+*/
+var DeliveryBatchSize = 100
+
+/*
+ChangeTranslation translates changes before they are delivered (e.g. to
+replace keys with external IDs).
+*/
+type ChangeTranslation func(changes []*replication.Change) []*replication.Change
+
+/*
+Stats are the delivery statistics of a webhook.
+*/
+type Stats struct {
+	Delivered    int64  `json:"delivered"`     // Number of delivered changes
+	Failed       int64  `json:"failed"`        // Number of failed deliveries
+	LastSeq      uint64 `json:"last_seq"`      // Sequence number of the last processed change
+	LastDelivery int64  `json:"last_delivery"` // Time of the last successful delivery (Unix seconds)
+	LastError    string `json:"last_error"`    // Error of the last failed delivery
+}
+
+/*
+worker delivers the changes of a single webhook.
+*/
+type worker struct {
+	hook  *Webhook      // Webhook of the worker
+	stats *Stats        // Delivery statistics
+	stop  chan struct{} // Channel which is closed to stop the worker
+	done  chan struct{} // Channel which is closed once the worker has stopped
+}
+
+/*
+Manager manages webhooks and delivers the changes of a change log to them.
+*/
+type Manager struct {
+	gm        *graph.Manager         // Graph manager which stores the webhooks
+	part      string                 // Partition which stores the webhooks
+	cl        *replication.ChangeLog // Change log which is delivered
+	translate ChangeTranslation      // Translation of delivered changes (nil for no translation)
+	workers   map[string]*worker     // Workers by webhook name
+	lock      *sync.RWMutex          // Lock for workers and statistics
+}
+
+/*
+NewManager creates a new webhook manager and loads all webhooks which are
+stored in a given partition. Changes of this partition are never delivered.
+*/
+func NewManager(gm *graph.Manager, part string, cl *replication.ChangeLog,
+	translate ChangeTranslation) (*Manager, error) {
+
+	m := &Manager{gm, part, cl, translate, make(map[string]*worker), &sync.RWMutex{}}
+
+	it, err := gm.NodeKeyIterator(part, WebhookNodeKind)
+
+	for err == nil && it != nil && it.HasNext() {
+		key := it.Next()
+
+		if err = it.LastError; err == nil {
+			var node data.Node
+
+			if node, err = gm.FetchNode(part, key, WebhookNodeKind); err == nil && node != nil {
+				wh := &Webhook{}
+
+				if err = json.Unmarshal([]byte(fmt.Sprint(node.Attr("data"))), wh); err == nil {
+					m.workers[wh.Name] = m.start(wh)
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+/*
+Close stops all deliveries.
+*/
+func (m *Manager) Close() {
+	m.lock.Lock()
+	workers := m.workers
+	m.workers = make(map[string]*worker)
+	m.lock.Unlock()
+
+	for _, w := range workers {
+		w.halt()
+	}
+}
+
+/*
+Webhooks returns all webhooks sorted by name.
+*/
+func (m *Manager) Webhooks() []*Webhook {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	ret := make([]*Webhook, 0, len(m.workers))
+
+	for _, w := range m.workers {
+		ret = append(ret, w.hook)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+Webhook returns a webhook and its delivery statistics. Returns nil if the
+webhook does not exist.
+*/
+func (m *Manager) Webhook(name string) (*Webhook, Stats) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if w, ok := m.workers[name]; ok {
+		return w.hook, *w.stats
+	}
+
+	return nil, Stats{}
+}
+
+/*
+Validate checks a webhook.
+*/
+func (m *Manager) Validate(wh *Webhook) error {
+
+	if err := wh.Validate(); err != nil {
+		return err
+	}
+
+	for _, p := range wh.Partitions {
+		if p == m.part {
+			return fmt.Errorf("Webhooks cannot access the partition: %v", p)
+		}
+	}
+
+	return nil
+}
+
+/*
+StoreWebhook validates and stores a webhook. An existing webhook with the same
+name is replaced and its statistics are reset.
+*/
+func (m *Manager) StoreWebhook(wh *Webhook) error {
+
+	if err := m.Validate(wh); err != nil {
+		return err
+	}
+
+	webhookJSON, err := json.Marshal(wh)
+
+	if err == nil {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, wh.Name)
+		node.SetAttr(data.NodeKind, WebhookNodeKind)
+		node.SetAttr("updated", time.Now().Unix())
+		node.SetAttr("data", string(webhookJSON))
+
+		if err = m.gm.StoreNode(m.part, node); err == nil {
+			w := m.start(wh)
+
+			m.lock.Lock()
+			old := m.workers[wh.Name]
+			m.workers[wh.Name] = w
+			m.lock.Unlock()
+
+			// The old worker is stopped outside of the lock since it
+			// records its statistics with the lock
+
+			if old != nil {
+				old.halt()
+			}
+		}
+	}
+
+	return err
+}
+
+/*
+RemoveWebhook removes a webhook. Returns if the webhook existed.
+*/
+func (m *Manager) RemoveWebhook(name string) (bool, error) {
+
+	node, err := m.gm.RemoveNode(m.part, name, WebhookNodeKind)
+
+	if err == nil {
+		m.lock.Lock()
+		old := m.workers[name]
+		delete(m.workers, name)
+		m.lock.Unlock()
+
+		if old != nil {
+			old.halt()
+		}
+	}
+
+	return node != nil, err
+}
+
+/*
+start starts a worker for a webhook. The worker delivers all changes which are
+recorded from now on. Disabled webhooks get a worker which is already stopped.
+*/
+func (m *Manager) start(wh *Webhook) *worker {
+	w := &worker{wh, &Stats{}, make(chan struct{}), make(chan struct{})}
+
+	if wh.Disabled {
+		close(w.done)
+		return w
+	}
+
+	since, _ := m.cl.LastSeq()
+
+	go m.run(w, since)
+
+	return w
+}
+
+/*
+halt stops a worker and waits until it has stopped.
+*/
+func (w *worker) halt() {
+	close(w.stop)
+	<-w.done
+}
+
+/*
+run delivers the changes after a given sequence number until the worker is
+stopped.
+*/
+func (m *Manager) run(w *worker, since uint64) {
+	defer close(w.done)
+
+	selects := func(c *replication.Change) bool {
+		return c.Part != m.part && w.hook.Selects(c)
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		// Get the notification channel first so no change is missed
+
+		notify := m.cl.Notify()
+
+		changes, next, err := m.cl.FilteredChanges(since, DeliveryBatchSize, selects)
+
+		if err != nil {
+
+			// Changes were dropped from the change log before they could be
+			// delivered - continue with the latest change
+
+			m.record(w, 0, fmt.Errorf("Changes after %v were lost: %v", since, err))
+			since, _ = m.cl.LastSeq()
+			continue
+		}
+
+		if m.translate != nil {
+			changes = m.translate(changes)
+		}
+
+		for _, c := range changes {
+			if !m.deliver(w, c) {
+				return
+			}
+		}
+
+		since = next
+
+		if len(changes) == 0 {
+			select {
+			case <-notify:
+			case <-w.stop:
+				return
+			}
+		}
+	}
+}
+
+/*
+deliver delivers a change and retries failed deliveries. Returns false if the
+worker was stopped.
+*/
+func (m *Manager) deliver(w *worker, c *replication.Change) bool {
+	backoff := RetryBackoff
+
+	body, err := json.Marshal(c)
+
+	for attempt := 0; err == nil; attempt++ {
+
+		if err = post(w.hook, c, body); err == nil || attempt >= MaxRetries {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.stop:
+			return false
+		}
+
+		if backoff *= 2; backoff > RetryMaxBackoff {
+			backoff = RetryMaxBackoff
+		}
+
+		err = nil
+	}
+
+	m.record(w, c.Seq, err)
+
+	return true
+}
+
+/*
+record records the result of a delivery.
+*/
+func (m *Manager) record(w *worker, seq uint64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if seq > 0 {
+		w.stats.LastSeq = seq
+	}
+
+	if err != nil {
+		w.stats.Failed++
+		w.stats.LastError = err.Error()
+	} else {
+		w.stats.Delivered++
+		w.stats.LastDelivery = time.Now().Unix()
+	}
+}
+
+/*
+post posts a change as JSON to a webhook.
+*/
+func post(wh *Webhook, c *replication.Change, body []byte) error {
+
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(body))
+
+	if err == nil {
+		var resp *http.Response
+
+		req.Header.Set("content-type", "application/json; charset=utf-8")
+		req.Header.Set(HeaderWebhook, wh.Name)
+		req.Header.Set(HeaderDelivery, fmt.Sprint(c.Seq))
+
+		if sig := wh.Sign(body); sig != "" {
+			req.Header.Set(HeaderSignature, sig)
+		}
+
+		client := &http.Client{Timeout: DeliveryTimeout}
+
+		if resp, err = client.Do(req); err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("Webhook %v returned status: %v", wh.URL, resp.Status)
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,218 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/replication"
+)
+
+func TestWebhookValidate(t *testing.T) {
+
+	for _, test := range []struct {
+		webhook *Webhook
+		msg     string
+	}{
+		{&Webhook{Name: "a b", URL: "http://localhost"},
+			"Invalid webhook name (allowed are letters, digits, - and _): a b"},
+		{&Webhook{Name: "w", URL: "ftp://localhost"},
+			"Invalid webhook URL: ftp://localhost"},
+		{&Webhook{Name: "w", URL: "http://localhost", Ops: []string{"foo"}},
+			"Unknown operation: foo"},
+	} {
+		if err := test.webhook.Validate(); err == nil || err.Error() != test.msg {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	wh := &Webhook{Name: "w", URL: "http://localhost", Partitions: []string{"main"},
+		Kinds: []string{"Song"}, Secret: "secret"}
+
+	if err := wh.Validate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !wh.Selects(&replication.Change{Part: "main", Kind: "Song", Op: replication.OpStoreNode}) ||
+		wh.Selects(&replication.Change{Part: "main", Kind: "Author", Op: replication.OpStoreNode}) ||
+		wh.Selects(&replication.Change{Part: "other", Kind: "Song", Op: replication.OpStoreNode}) {
+		t.Error("Unexpected selection")
+		return
+	}
+
+	if res := wh.Sign([]byte("test")); res != "sha256=0329a06b62cd16b33eb6792be8c60b158d89a2ee3a876fce9a881ebb488c0914" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestManager(t *testing.T) {
+	oldRetryBackoff := RetryBackoff
+	oldMaxRetries := MaxRetries
+	defer func() {
+		RetryBackoff = oldRetryBackoff
+		MaxRetries = oldMaxRetries
+	}()
+
+	RetryBackoff = time.Millisecond
+	MaxRetries = 2
+
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	failures := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if strings.HasSuffix(r.URL.Path, "/flaky") && failures < 1 {
+			failures++
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if strings.HasSuffix(r.URL.Path, "/broken") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+
+	cl := replication.NewChangeLog(100)
+	gm.SetGraphRule(cl)
+
+	m, err := NewManager(gm, "system", cl, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.StoreWebhook(&Webhook{Name: "s", URL: "http://localhost", Partitions: []string{"system"}}); err == nil ||
+		err.Error() != "Webhooks cannot access the partition: system" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := m.StoreWebhook(&Webhook{Name: "songs", URL: srv.URL + "/flaky", Secret: "secret",
+		Kinds: []string{"Song"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.StoreWebhook(&Webhook{Name: "broken", URL: srv.URL + "/broken"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "b",
+		"kind": "Author",
+	}))
+
+	gm.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "a",
+		"kind": "Song",
+		"name": "Aria",
+	}))
+
+	// The first delivery fails and is retried
+
+	var r *http.Request
+	var body []byte
+
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Error("No delivery")
+		return
+	}
+
+	wh, _ := m.Webhook("songs")
+
+	if r.Header.Get(HeaderWebhook) != "songs" || r.Header.Get(HeaderSignature) != wh.Sign(body) {
+		t.Error("Unexpected headers:", r.Header)
+		return
+	}
+
+	c := &replication.Change{}
+	json.Unmarshal(body, c)
+
+	if res := fmt.Sprint(c.Op, " ", c.Part, " ", c.Key, " ", c.Kind, " ", c.Data["name"]); res != "node.store main a Song Aria" ||
+		r.Header.Get(HeaderDelivery) != fmt.Sprint(c.Seq) {
+		t.Error("Unexpected result:", res, r.Header)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, stats := m.Webhook("broken"); stats.Failed == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, stats := m.Webhook("broken"); stats.Failed != 2 || stats.Delivered != 0 ||
+		!strings.HasSuffix(stats.LastError, "returned status: 500 Internal Server Error") {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if _, stats := m.Webhook("songs"); stats.Failed != 0 || stats.Delivered != 1 || stats.LastSeq != c.Seq {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// Webhooks are loaded from the storage partition
+
+	m.Close()
+
+	m, err = NewManager(gm, "system", cl, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer m.Close()
+
+	var names []string
+	for _, wh := range m.Webhooks() {
+		names = append(names, wh.Name)
+	}
+
+	if res := fmt.Sprint(names); res != "[broken songs]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if ok, err := m.RemoveWebhook("broken"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if wh, _ := m.Webhook("broken"); wh != nil {
+		t.Error("Unexpected result:", wh)
+		return
+	}
+}
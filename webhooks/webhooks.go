@@ -0,0 +1,137 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package webhooks contains a webhook manager which posts the changes of a
+change log to external URLs.
+
+A webhook selects changes by partition, kind and operation. Each webhook is
+served by its own worker which delivers the selected changes in order. Failed
+deliveries are retried with an exponential backoff - a change is dropped once
+all retries failed. Requests of webhooks with a secret are signed with an HMAC
+(SHA-256) of the request body.
+
+Webhooks are stored as nodes in a partition of the graph so they survive
+restarts. Deliveries start with the changes which are recorded after the
+webhook was loaded or stored.
+*/
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/krotik/eliasdb/replication"
+)
+
+/*
+HTTP headers of webhook requests
+*/
+const (
+	HeaderWebhook   = "X-EliasDB-Webhook"   // Name of the webhook
+	HeaderDelivery  = "X-EliasDB-Delivery"  // Sequence number of the delivered change
+	HeaderSignature = "X-EliasDB-Signature" // HMAC signature of the body (sha256=<hex digest>)
+)
+
+/*
+knownOps are all operations of changes.
+*/
+var knownOps = map[string]bool{
+	replication.OpStoreNode:  true,
+	replication.OpDeleteNode: true,
+	replication.OpStoreEdge:  true,
+	replication.OpDeleteEdge: true,
+}
+
+/*
+webhookNameRegexp is the pattern of valid webhook names.
+*/
+var webhookNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+/*
+Webhook is an external URL which receives selected changes of the datastore.
+*/
+type Webhook struct {
+	Name       string   `json:"name"`                 // Name of the webhook
+	URL        string   `json:"url"`                  // URL which receives the changes
+	Secret     string   `json:"secret,omitempty"`     // Secret for the signature of requests (empty for no signature)
+	Partitions []string `json:"partitions,omitempty"` // Partitions of the changes (empty for all partitions)
+	Kinds      []string `json:"kinds,omitempty"`      // Kinds of the changes (empty for all kinds)
+	Ops        []string `json:"ops,omitempty"`        // Operations of the changes (empty for all operations)
+	Disabled   bool     `json:"disabled,omitempty"`   // Flag if the webhook is disabled
+}
+
+/*
+Validate checks a webhook.
+*/
+func (wh *Webhook) Validate() error {
+
+	if !webhookNameRegexp.MatchString(wh.Name) {
+		return fmt.Errorf("Invalid webhook name (allowed are letters, digits, - and _): %v", wh.Name)
+	}
+
+	if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid webhook URL: %v", wh.URL)
+	}
+
+	for _, op := range wh.Ops {
+		if !knownOps[op] {
+			return fmt.Errorf("Unknown operation: %v", op)
+		}
+	}
+
+	return nil
+}
+
+/*
+Selects checks if a change is selected by this webhook.
+*/
+func (wh *Webhook) Selects(c *replication.Change) bool {
+	return contains(wh.Partitions, c.Part) && contains(wh.Kinds, c.Kind) && contains(wh.Ops, c.Op)
+}
+
+/*
+Sign returns the signature of a request body. Returns an empty string if the
+webhook has no secret.
+*/
+func (wh *Webhook) Sign(body []byte) string {
+
+	if wh.Secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(wh.Secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+contains checks if a list of values contains a value. An empty list contains
+all values.
+*/
+func contains(list []string, val string) bool {
+
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}